@@ -48,7 +48,9 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/ftpd"
 	"github.com/drakkan/sftpgo/v2/internal/httpd"
+	"github.com/drakkan/sftpgo/v2/internal/jobs"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/s3d"
 	"github.com/drakkan/sftpgo/v2/internal/telemetry"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/version"
@@ -636,6 +638,8 @@ func (c *Configuration) renewCertificates() error {
 		acmeLog(logger.LevelInfo, "httpd certificates manager reloaded , error: %v", err)
 		err = webdavd.ReloadCertificateMgr()
 		acmeLog(logger.LevelInfo, "webdav certificates manager reloaded , error: %v", err)
+		err = s3d.ReloadCertificateMgr()
+		acmeLog(logger.LevelInfo, "s3d certificates manager reloaded , error: %v", err)
 		err = telemetry.ReloadCertificateMgr()
 		acmeLog(logger.LevelInfo, "telemetry certificates manager reloaded , error: %v", err)
 	}
@@ -662,6 +666,7 @@ func sanitizedDomain(domain string) string {
 func stopScheduler() {
 	if scheduler != nil {
 		scheduler.Stop()
+		jobs.RemoveScheduler(scheduler)
 		scheduler = nil
 	}
 }
@@ -673,7 +678,7 @@ func startScheduler() error {
 	randSecs := rand.Intn(59)
 
 	scheduler = cron.New()
-	_, err := scheduler.AddFunc(fmt.Sprintf("@every 12h0m%ds", randSecs), renewCertificates)
+	err := jobs.Add(scheduler, "certificates_renewal", fmt.Sprintf("@every 12h0m%ds", randSecs), renewCertificates)
 	if err != nil {
 		return fmt.Errorf("unable to schedule certificates renewal: %w", err)
 	}
@@ -682,19 +687,21 @@ func startScheduler() error {
 	initialTimer := time.NewTimer(time.Duration(randSecs) * time.Second)
 	go func() {
 		<-initialTimer.C
-		renewCertificates()
+		renewCertificates() //nolint:errcheck
 	}()
 
 	scheduler.Start()
 	return nil
 }
 
-func renewCertificates() {
+func renewCertificates() error {
 	if config != nil {
 		if err := config.renewCertificates(); err != nil {
 			acmeLog(logger.LevelError, "unable to renew certificates: %v", err)
+			return err
 		}
 	}
+	return nil
 }
 
 func setLogMode(checkRenew bool) {
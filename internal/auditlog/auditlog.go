@@ -0,0 +1,428 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package auditlog implements an append-only, tamper-evident, log for
+// security events. Records are stored as JSON lines and each record includes
+// the hash of the previous one, so any change to a record breaks the chain
+package auditlog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	logSender = "auditlog"
+	// maxRecordSize is the maximum size of a record line
+	maxRecordSize = 1024 * 1024
+)
+
+// Supported event types
+const (
+	EventLogin            = "login"
+	EventAdminLogin       = "admin_login"
+	EventPermissionDenied = "permission_denied"
+	EventAdminAction      = "admin_action"
+	EventShareAccess      = "share_access"
+	EventImpersonation    = "impersonation"
+)
+
+var (
+	supportedEvents = []string{EventLogin, EventAdminLogin, EventPermissionDenied, EventAdminAction, EventShareAccess,
+		EventImpersonation}
+	// genesisHash is the previous hash for the first record
+	genesisHash = strings.Repeat("0", 64)
+	mu          sync.Mutex
+	current     *auditLog
+)
+
+// Config defines the configuration for the security audit log
+type Config struct {
+	// Path to the audit log file. This can be an absolute path or a path
+	// relative to the config dir. Leave empty to disable the audit log
+	FilePath string `json:"file_path" mapstructure:"file_path"`
+	// Events to record, empty means all the supported events
+	Events []string `json:"events" mapstructure:"events"`
+}
+
+// IsEnabled returns true if the audit log is enabled
+func (c *Config) IsEnabled() bool {
+	return c.FilePath != ""
+}
+
+func (c *Config) validate() error {
+	for _, event := range c.Events {
+		if !util.Contains(supportedEvents, event) {
+			return fmt.Errorf("invalid audit log event %q, supported events: %s", event,
+				strings.Join(supportedEvents, ","))
+		}
+	}
+	return nil
+}
+
+// GetFilePath returns the absolute path for the configured audit log file
+func (c *Config) GetFilePath(configDir string) string {
+	if c.FilePath == "" || filepath.IsAbs(c.FilePath) {
+		return c.FilePath
+	}
+	return filepath.Join(configDir, c.FilePath)
+}
+
+// Initialize validates the configuration and opens the audit log file.
+// The last record is read from an existing file so the new records are
+// chained to it
+func (c *Config) Initialize(configDir string) error {
+	if !c.IsEnabled() {
+		return Close()
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	filePath := c.GetFilePath(configDir)
+	last, size, err := readLastRecord(filePath)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open audit log file %q: %w", filePath, err)
+	}
+	l := &auditLog{
+		file:     file,
+		filePath: filePath,
+		events:   c.Events,
+		size:     size,
+		sequence: last.Sequence,
+		lastHash: genesisHash,
+	}
+	if last.Hash != "" {
+		l.lastHash = last.Hash
+	}
+
+	mu.Lock()
+	prev := current
+	current = l
+	mu.Unlock()
+
+	if prev != nil {
+		prev.close()
+	}
+	logger.Info(logSender, "", "audit log enabled, file %q, events %v, last sequence: %d", filePath, c.Events,
+		l.sequence)
+	return nil
+}
+
+// Close closes the audit log, no more records are written
+func Close() error {
+	mu.Lock()
+	prev := current
+	current = nil
+	mu.Unlock()
+
+	if prev != nil {
+		return prev.close()
+	}
+	return nil
+}
+
+// IsEnabled returns true if the audit log is enabled
+func IsEnabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return current != nil
+}
+
+// Record defines an audit log record
+type Record struct {
+	// Sequence number, records are numbered starting from 1
+	Sequence int64 `json:"seq"`
+	// Unix timestamp in milliseconds
+	Timestamp int64  `json:"timestamp"`
+	Event     string `json:"event"`
+	Username  string `json:"username,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	Protocol  string `json:"protocol,omitempty"`
+	// Error is set for failed logins and denied actions
+	Error   string            `json:"error,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+	// Hash of the previous record
+	PrevHash string `json:"prev_hash"`
+	// Hash of this record, computed with an empty hash field
+	Hash string `json:"hash,omitempty"`
+}
+
+// computeHash returns the hash for the record. The hash is computed on the
+// JSON representation of the record without the hash field. Struct fields
+// are encoded in declaration order and map keys are sorted, so the
+// representation is stable
+func (r *Record) computeHash() (string, error) {
+	record := *r
+	record.Hash = ""
+	data, err := json.Marshal(&record)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+type auditLog struct {
+	mu       sync.Mutex
+	file     *os.File
+	filePath string
+	events   []string
+	size     int64
+	sequence int64
+	lastHash string
+}
+
+func (l *auditLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (l *auditLog) isEventEnabled(event string) bool {
+	return len(l.events) == 0 || util.Contains(l.events, event)
+}
+
+func (l *auditLog) add(record *Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return errors.New("the audit log is closed")
+	}
+	record.Sequence = l.sequence + 1
+	record.PrevHash = l.lastHash
+	hash, err := record.computeHash()
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := l.file.Write(data); err != nil {
+		return err
+	}
+	l.size += int64(len(data))
+	l.sequence = record.Sequence
+	l.lastHash = record.Hash
+	return nil
+}
+
+func (l *auditLog) getSize() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.size
+}
+
+func getCurrent() *auditLog {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return current
+}
+
+// Add records an event in the audit log, if enabled. The sequence, timestamp
+// and hashes are set automatically
+func Add(record Record) {
+	l := getCurrent()
+	if l == nil || !l.isEventEnabled(record.Event) {
+		return
+	}
+	record.Timestamp = util.GetTimeAsMsSinceEpoch(time.Now())
+	if err := l.add(&record); err != nil {
+		logger.Error(logSender, "", "unable to add audit log record for event %q, username %q: %v",
+			record.Event, record.Username, err)
+	}
+}
+
+// AddLogin records a login attempt
+func AddLogin(event, username, ip, protocol, loginMethod string, err error) {
+	record := Record{
+		Event:    event,
+		Username: username,
+		IP:       ip,
+		Protocol: protocol,
+		Details: map[string]string{
+			"login_method": loginMethod,
+		},
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	Add(record)
+}
+
+// Export writes the records within the specified time range, as JSON lines,
+// to w. Unix timestamps are in milliseconds, 0 means no limit. The exported
+// records are contiguous and so they can be verified
+func Export(w io.Writer, startTimestamp, endTimestamp int64) error {
+	l := getCurrent()
+	if l == nil {
+		return util.NewValidationError("the audit log is disabled")
+	}
+	// the records appended after this point are not exported
+	size := l.getSize()
+	file, err := os.Open(l.filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return iterate(io.LimitReader(file, size), func(line []byte, record *Record) error {
+		if startTimestamp > 0 && record.Timestamp < startTimestamp {
+			return nil
+		}
+		if endTimestamp > 0 && record.Timestamp > endTimestamp {
+			return nil
+		}
+		_, err := w.Write(append(line, '\n'))
+		return err
+	})
+}
+
+// iterate calls fn for each record read from r. Empty lines are ignored
+func iterate(r io.Reader, fn func(line []byte, record *Record) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRecordSize)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("line %d: invalid record: %w", lineNumber, err)
+		}
+		if err := fn(line, &record); err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// readLastRecord returns the last record and the size for the specified
+// audit log file. A missing file is not an error
+func readLastRecord(filePath string) (Record, int64, error) {
+	var last Record
+	file, err := os.Open(filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return last, 0, nil
+		}
+		return last, 0, fmt.Errorf("unable to open audit log file %q: %w", filePath, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return last, 0, err
+	}
+	err = iterate(file, func(_ []byte, record *Record) error {
+		last = *record
+		return nil
+	})
+	if err != nil {
+		return last, 0, fmt.Errorf("unable to read audit log file %q: %w", filePath, err)
+	}
+	return last, info.Size(), nil
+}
+
+// VerifyResult defines the result of a successful verification
+type VerifyResult struct {
+	Records       int64  `json:"records"`
+	FirstSequence int64  `json:"first_sequence"`
+	LastSequence  int64  `json:"last_sequence"`
+	LastHash      string `json:"last_hash"`
+}
+
+// Verify reads the records from r and checks that they are contiguous and
+// that the hash chain is intact. If the first record is not the first one
+// ever written, for example for exported records, it is trusted as chain start
+func Verify(r io.Reader) (VerifyResult, error) {
+	var result VerifyResult
+	prevHash := ""
+	err := iterate(r, func(_ []byte, record *Record) error {
+		if result.Records == 0 {
+			if record.Sequence < 1 {
+				return fmt.Errorf("invalid sequence %d", record.Sequence)
+			}
+			if record.Sequence == 1 && record.PrevHash != genesisHash {
+				return fmt.Errorf("invalid previous hash %q for the first record", record.PrevHash)
+			}
+			result.FirstSequence = record.Sequence
+		} else {
+			if record.Sequence != result.LastSequence+1 {
+				return fmt.Errorf("unexpected sequence %d, expected %d, records were removed or reordered",
+					record.Sequence, result.LastSequence+1)
+			}
+			if record.PrevHash != prevHash {
+				return fmt.Errorf("sequence %d: the previous hash does not match, the chain is broken", record.Sequence)
+			}
+		}
+		hash, err := record.computeHash()
+		if err != nil {
+			return err
+		}
+		if hash != record.Hash {
+			return fmt.Errorf("sequence %d: hash mismatch, the record was modified", record.Sequence)
+		}
+		result.Records++
+		result.LastSequence = record.Sequence
+		result.LastHash = record.Hash
+		prevHash = record.Hash
+		return nil
+	})
+	return result, err
+}
+
+// VerifyFile verifies the audit log file at the specified path
+func VerifyFile(filePath string) (VerifyResult, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	defer file.Close()
+
+	return Verify(file)
+}
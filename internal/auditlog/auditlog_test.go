@@ -0,0 +1,256 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auditlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readRecords(t *testing.T, filePath string) []Record {
+	data, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	var records []Record
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var record Record
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func writeRecords(t *testing.T, filePath string, records []Record) {
+	var buf bytes.Buffer
+	for idx := range records {
+		data, err := json.Marshal(&records[idx])
+		require.NoError(t, err)
+		buf.Write(data)
+		buf.WriteString("\n")
+	}
+	require.NoError(t, os.WriteFile(filePath, buf.Bytes(), 0600))
+}
+
+func TestConfig(t *testing.T) {
+	c := Config{}
+	assert.False(t, c.IsEnabled())
+	require.NoError(t, c.Initialize(os.TempDir()))
+	assert.False(t, IsEnabled())
+	// no records are added if the audit log is disabled
+	Add(Record{Event: EventLogin})
+	err := Export(&bytes.Buffer{}, 0, 0)
+	assert.Error(t, err)
+
+	c.FilePath = "audit.jsonl"
+	assert.Equal(t, filepath.Join(os.TempDir(), "audit.jsonl"), c.GetFilePath(os.TempDir()))
+	c.FilePath = filepath.Join(t.TempDir(), "audit.jsonl")
+	assert.Equal(t, c.FilePath, c.GetFilePath(os.TempDir()))
+	c.Events = []string{EventLogin, "unknown"}
+	err = c.Initialize(os.TempDir())
+	assert.ErrorContains(t, err, "invalid audit log event")
+	assert.False(t, IsEnabled())
+	// the parent directory does not exist
+	c.Events = nil
+	c.FilePath = filepath.Join(t.TempDir(), "missing", "audit.jsonl")
+	assert.Error(t, c.Initialize(os.TempDir()))
+	assert.False(t, IsEnabled())
+}
+
+func TestChain(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "audit.jsonl")
+	c := Config{
+		FilePath: filePath,
+	}
+	require.NoError(t, c.Initialize(""))
+	require.True(t, IsEnabled())
+	AddLogin(EventLogin, "user1", "127.0.0.1", "SSH", "password", nil)
+	AddLogin(EventLogin, "user1", "127.0.0.1", "SSH", "password", errors.New("invalid credentials"))
+	require.NoError(t, Close())
+	assert.False(t, IsEnabled())
+	// the new records must be chained to the existing ones
+	require.NoError(t, c.Initialize(""))
+	Add(Record{
+		Event:    EventAdminAction,
+		Username: "admin",
+		Details: map[string]string{
+			"action":      "add",
+			"object_type": "user",
+			"object_name": "user2",
+		},
+	})
+	require.NoError(t, Close())
+
+	info, err := os.Stat(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	records := readRecords(t, filePath)
+	require.Len(t, records, 3)
+	assert.Equal(t, genesisHash, records[0].PrevHash)
+	assert.Equal(t, "password", records[0].Details["login_method"])
+	assert.Empty(t, records[0].Error)
+	assert.Equal(t, "invalid credentials", records[1].Error)
+	for idx, record := range records {
+		assert.Equal(t, int64(idx+1), record.Sequence)
+		assert.Greater(t, record.Timestamp, int64(0))
+		if idx > 0 {
+			assert.Equal(t, records[idx-1].Hash, record.PrevHash)
+		}
+	}
+	result, err := VerifyFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), result.Records)
+	assert.Equal(t, int64(1), result.FirstSequence)
+	assert.Equal(t, int64(3), result.LastSequence)
+	assert.Equal(t, records[2].Hash, result.LastHash)
+
+	_, err = VerifyFile(filepath.Join(t.TempDir(), "missing.jsonl"))
+	assert.Error(t, err)
+}
+
+func TestVerifyTampering(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "audit.jsonl")
+	c := Config{
+		FilePath: filePath,
+	}
+	require.NoError(t, c.Initialize(""))
+	for _, username := range []string{"user1", "user2", "user3", "user4"} {
+		AddLogin(EventLogin, username, "127.0.0.1", "FTP", "password", nil)
+	}
+	require.NoError(t, Close())
+	records := readRecords(t, filePath)
+	require.Len(t, records, 4)
+	tamperedPath := filepath.Join(t.TempDir(), "tampered.jsonl")
+	// modified record
+	modified := make([]Record, len(records))
+	copy(modified, records)
+	modified[1].Username = "admin"
+	writeRecords(t, tamperedPath, modified)
+	_, err := VerifyFile(tamperedPath)
+	assert.ErrorContains(t, err, "the record was modified")
+	// modified record with a recomputed hash
+	modified[1].Hash, err = modified[1].computeHash()
+	require.NoError(t, err)
+	writeRecords(t, tamperedPath, modified)
+	_, err = VerifyFile(tamperedPath)
+	assert.ErrorContains(t, err, "the chain is broken")
+	// removed record
+	writeRecords(t, tamperedPath, []Record{records[0], records[2], records[3]})
+	_, err = VerifyFile(tamperedPath)
+	assert.ErrorContains(t, err, "records were removed or reordered")
+	// reordered records
+	writeRecords(t, tamperedPath, []Record{records[0], records[2], records[1], records[3]})
+	_, err = VerifyFile(tamperedPath)
+	assert.ErrorContains(t, err, "records were removed or reordered")
+	// invalid first record
+	first := records[0]
+	first.PrevHash = records[3].Hash
+	writeRecords(t, tamperedPath, []Record{first})
+	_, err = VerifyFile(tamperedPath)
+	assert.ErrorContains(t, err, "invalid previous hash")
+	first.Sequence = 0
+	writeRecords(t, tamperedPath, []Record{first})
+	_, err = VerifyFile(tamperedPath)
+	assert.ErrorContains(t, err, "invalid sequence")
+	// invalid JSON
+	err = os.WriteFile(tamperedPath, []byte("{not json}\n"), 0600)
+	require.NoError(t, err)
+	_, err = VerifyFile(tamperedPath)
+	assert.ErrorContains(t, err, "invalid record")
+	// truncated records, starting after the first one, are a valid chain
+	writeRecords(t, tamperedPath, records[2:])
+	result, err := VerifyFile(tamperedPath)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), result.Records)
+	assert.Equal(t, int64(3), result.FirstSequence)
+	assert.Equal(t, int64(4), result.LastSequence)
+	// an invalid existing file cannot be initialized
+	err = os.WriteFile(tamperedPath, []byte("{not json}\n"), 0600)
+	require.NoError(t, err)
+	c.FilePath = tamperedPath
+	assert.Error(t, c.Initialize(""))
+	assert.False(t, IsEnabled())
+}
+
+func TestExportAndEvents(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "audit.jsonl")
+	c := Config{
+		FilePath: filePath,
+		Events:   []string{EventPermissionDenied, EventShareAccess},
+	}
+	require.NoError(t, c.Initialize(""))
+	AddLogin(EventLogin, "user", "127.0.0.1", "SSH", "password", nil)
+	Add(Record{Event: EventPermissionDenied, Username: "user", Protocol: "SFTP"})
+	Add(Record{Event: EventShareAccess, Username: "user", Protocol: "HTTPShare"})
+	Add(Record{Event: EventPermissionDenied, Username: "user", Protocol: "FTP"})
+
+	var buf bytes.Buffer
+	require.NoError(t, Export(&buf, 0, 0))
+	result, err := Verify(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	// the login event is not enabled
+	assert.Equal(t, int64(3), result.Records)
+
+	records := readRecords(t, filePath)
+	require.Len(t, records, 3)
+	assert.Equal(t, EventPermissionDenied, records[0].Event)
+	// limit the time range
+	records[0].Timestamp = 1000
+	records[1].Timestamp = 2000
+	records[2].Timestamp = 3000
+	for idx := range records {
+		if idx > 0 {
+			records[idx].PrevHash = records[idx-1].Hash
+		}
+		records[idx].Hash, err = records[idx].computeHash()
+		require.NoError(t, err)
+	}
+	require.NoError(t, Close())
+	writeRecords(t, filePath, records)
+	require.NoError(t, c.Initialize(""))
+
+	buf.Reset()
+	require.NoError(t, Export(&buf, 1500, 0))
+	result, err = Verify(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), result.Records)
+	assert.Equal(t, int64(2), result.FirstSequence)
+
+	buf.Reset()
+	require.NoError(t, Export(&buf, 1500, 2500))
+	result, err = Verify(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.Records)
+	assert.Equal(t, records[1].Hash, result.LastHash)
+
+	buf.Reset()
+	require.NoError(t, Export(&buf, 5000, 0))
+	assert.Empty(t, buf.Bytes())
+	// the chain continues after the last record
+	Add(Record{Event: EventShareAccess, Username: "user"})
+	require.NoError(t, Close())
+	result, err = VerifyFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), result.LastSequence)
+	// closing twice is not an error
+	assert.NoError(t, Close())
+}
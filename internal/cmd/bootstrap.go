@@ -0,0 +1,406 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	bootstrapConfigFile        = "sftpgo.json"
+	bootstrapCertFile          = "sftpgo.crt"
+	bootstrapCertKeyFile       = "sftpgo.key"
+	bootstrapCertValidity      = 365 * 24 * time.Hour
+	bootstrapAdminPasswordKey  = "bootstrap_admin_password"
+	bootstrapDefaultSQLiteName = "sftpgo.db"
+	bootstrapDefaultBoltName   = "sftpgo.bolt"
+)
+
+var (
+	bootstrapTargetDir        string
+	bootstrapInteractive      bool
+	bootstrapForce            bool
+	bootstrapAdminUsername    string
+	bootstrapAdminPassword    string
+	bootstrapProviderDriver   string
+	bootstrapProviderName     string
+	bootstrapProviderConnStr  string
+	bootstrapHostKeys         bool
+	bootstrapSelfSignedCert   bool
+	bootstrapCertHosts        []string
+	bootstrapSupportedDrivers = []string{dataprovider.SQLiteDataProviderName, dataprovider.BoltDataProviderName,
+		dataprovider.MySQLDataProviderName, dataprovider.PGSQLDataProviderName, dataprovider.CockroachDataProviderName}
+	bootstrapHostKeyFiles = []struct {
+		name     string
+		generate func(string) error
+	}{
+		{name: "id_rsa", generate: util.GenerateRSAKeys},
+		{name: "id_ecdsa", generate: util.GenerateECDSAKeys},
+		{name: "id_ed25519", generate: util.GenerateEd25519Keys},
+	}
+	bootstrapCmd = &cobra.Command{
+		Use:   "bootstrap",
+		Short: "Generate a ready to use configuration directory",
+		Long: `This command generates a configuration file with the specified data provider,
+initializes the data provider, creates the first admin and optionally generates
+the SFTP host keys and a self-signed TLS certificate. Everything is written
+to the target directory that can be used as configuration directory for the
+serve command.
+
+The generated configuration is read back and validated before initializing the
+data provider. Existing host keys and certificates are preserved, an existing
+configuration file is overwritten only if the "force" flag is set.
+
+The values not provided using flags can be requested interactively, the
+typed values are not hidden. For unattended setups the admin password can
+be set using the SFTPGO_BOOTSTRAP_ADMIN_PASSWORD env var.
+
+Example:
+
+$ sftpgo bootstrap --target-dir /etc/sftpgo --admin-username admin --host-keys \
+--self-signed-cert --cert-hosts sftp.example.com
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(_ *cobra.Command, _ []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			if bootstrapInteractive {
+				if err := readBootstrapOptions(bufio.NewReader(os.Stdin)); err != nil {
+					logger.ErrorToConsole("Unable to read the bootstrap options: %v", err)
+					os.Exit(1)
+				}
+			}
+			if err := runBootstrap(); err != nil {
+				logger.ErrorToConsole("Unable to bootstrap the configuration: %v", err)
+				os.Exit(1)
+			}
+			logger.InfoToConsole("Bootstrap completed, you can now start SFTPGo using: sftpgo serve --config-dir %q",
+				bootstrapTargetDir)
+		},
+	}
+)
+
+func readBootstrapInput(reader *bufio.Reader, prompt, defaultValue string) (string, error) {
+	if defaultValue != "" {
+		fmt.Printf("%s [%s]: ", prompt, defaultValue)
+	} else {
+		fmt.Printf("%s: ", prompt)
+	}
+	answer, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return defaultValue, nil
+	}
+	return answer, nil
+}
+
+func readBootstrapConfirm(reader *bufio.Reader, prompt string, defaultValue bool) (bool, error) {
+	defaultAnswer := "n"
+	if defaultValue {
+		defaultAnswer = "y"
+	}
+	answer, err := readBootstrapInput(reader, prompt+" (y/n)", defaultAnswer)
+	if err != nil {
+		return false, err
+	}
+	return strings.ToLower(answer) == "y", nil
+}
+
+func readBootstrapProviderOptions(reader *bufio.Reader) error {
+	var err error
+
+	bootstrapProviderDriver, err = readBootstrapInput(reader, fmt.Sprintf("Data provider driver (%s)",
+		strings.Join(bootstrapSupportedDrivers, ", ")), bootstrapProviderDriver)
+	if err != nil {
+		return err
+	}
+	if isBootstrapLocalProvider() {
+		bootstrapProviderName, err = readBootstrapInput(reader, "Database file name", getBootstrapProviderName())
+		return err
+	}
+	bootstrapProviderConnStr, err = readBootstrapInput(reader, "Database connection string", bootstrapProviderConnStr)
+	return err
+}
+
+func readBootstrapOptions(reader *bufio.Reader) error {
+	var err error
+
+	if bootstrapTargetDir, err = readBootstrapInput(reader, "Target directory", bootstrapTargetDir); err != nil {
+		return err
+	}
+	if bootstrapAdminUsername, err = readBootstrapInput(reader, "Admin username", bootstrapAdminUsername); err != nil {
+		return err
+	}
+	if bootstrapAdminPassword == "" {
+		if bootstrapAdminPassword, err = readBootstrapInput(reader, "Admin password", ""); err != nil {
+			return err
+		}
+	}
+	if err = readBootstrapProviderOptions(reader); err != nil {
+		return err
+	}
+	if bootstrapHostKeys, err = readBootstrapConfirm(reader, "Generate SFTP host keys", bootstrapHostKeys); err != nil {
+		return err
+	}
+	bootstrapSelfSignedCert, err = readBootstrapConfirm(reader, "Generate a self-signed TLS certificate", bootstrapSelfSignedCert)
+	if err != nil {
+		return err
+	}
+	if bootstrapSelfSignedCert {
+		hosts, err := readBootstrapInput(reader, "Certificate hostnames and IP addresses, comma separated",
+			strings.Join(bootstrapCertHosts, ","))
+		if err != nil {
+			return err
+		}
+		bootstrapCertHosts = util.RemoveDuplicates(strings.Split(hosts, ","), true)
+	}
+	return nil
+}
+
+func isBootstrapLocalProvider() bool {
+	return bootstrapProviderDriver == dataprovider.SQLiteDataProviderName ||
+		bootstrapProviderDriver == dataprovider.BoltDataProviderName
+}
+
+func getBootstrapProviderName() string {
+	if bootstrapProviderName != "" {
+		return bootstrapProviderName
+	}
+	if bootstrapProviderDriver == dataprovider.BoltDataProviderName {
+		return bootstrapDefaultBoltName
+	}
+	return bootstrapDefaultSQLiteName
+}
+
+func validateBootstrapOptions() error {
+	if strings.TrimSpace(bootstrapTargetDir) == "" {
+		return errors.New("the target directory is required")
+	}
+	if bootstrapAdminUsername == "" || bootstrapAdminPassword == "" {
+		return errors.New("the admin username and password are required")
+	}
+	if !util.Contains(bootstrapSupportedDrivers, bootstrapProviderDriver) {
+		return fmt.Errorf("unsupported data provider driver %q, supported drivers: %s", bootstrapProviderDriver,
+			strings.Join(bootstrapSupportedDrivers, ", "))
+	}
+	if !isBootstrapLocalProvider() && bootstrapProviderConnStr == "" {
+		return fmt.Errorf("a connection string is required for the %q data provider", bootstrapProviderDriver)
+	}
+	if bootstrapSelfSignedCert && len(bootstrapCertHosts) == 0 {
+		return errors.New("at least a hostname or IP address is required for the self-signed certificate")
+	}
+	return nil
+}
+
+func generateBootstrapHostKeys(targetDir string) ([]string, error) {
+	var hostKeys []string
+
+	for _, k := range bootstrapHostKeyFiles {
+		keyPath := filepath.Join(targetDir, k.name)
+		hostKeys = append(hostKeys, k.name)
+		if _, err := os.Stat(keyPath); err == nil {
+			logger.InfoToConsole("Host key %q already exists, preserving it", keyPath)
+			continue
+		}
+		logger.InfoToConsole("Generating host key %q", keyPath)
+		if err := k.generate(keyPath); err != nil {
+			return nil, fmt.Errorf("unable to generate host key %q: %w", keyPath, err)
+		}
+	}
+	return hostKeys, nil
+}
+
+func generateBootstrapCertificate(targetDir string) error {
+	certPath := filepath.Join(targetDir, bootstrapCertFile)
+	keyPath := filepath.Join(targetDir, bootstrapCertKeyFile)
+	if _, err := os.Stat(certPath); err == nil {
+		if _, err := os.Stat(keyPath); err == nil {
+			logger.InfoToConsole("Certificate %q already exists, preserving it", certPath)
+			return nil
+		}
+	}
+	logger.InfoToConsole("Generating self-signed certificate %q for %v", certPath, bootstrapCertHosts)
+	if err := util.GenerateSelfSignedCertificate(certPath, keyPath, bootstrapCertHosts, bootstrapCertValidity); err != nil {
+		return fmt.Errorf("unable to generate the self-signed certificate: %w", err)
+	}
+	return nil
+}
+
+// setBootstrapConfig updates the default configuration based on the bootstrap options.
+// The generated files are referenced using paths relative to the target directory
+func setBootstrapConfig(targetDir string) error {
+	providerConf := config.GetProviderConf()
+	providerConf.Driver = bootstrapProviderDriver
+	if isBootstrapLocalProvider() {
+		providerConf.Name = getBootstrapProviderName()
+	} else {
+		providerConf.ConnectionString = bootstrapProviderConnStr
+	}
+	config.SetProviderConf(providerConf)
+
+	if bootstrapHostKeys {
+		hostKeys, err := generateBootstrapHostKeys(targetDir)
+		if err != nil {
+			return err
+		}
+		sftpdConf := config.GetSFTPDConfig()
+		sftpdConf.HostKeys = hostKeys
+		config.SetSFTPDConfig(sftpdConf)
+	}
+	if bootstrapSelfSignedCert {
+		if err := generateBootstrapCertificate(targetDir); err != nil {
+			return err
+		}
+		httpdConf := config.GetHTTPDConfig()
+		httpdConf.CertificateFile = bootstrapCertFile
+		httpdConf.CertificateKeyFile = bootstrapCertKeyFile
+		for idx := range httpdConf.Bindings {
+			httpdConf.Bindings[idx].EnableHTTPS = true
+		}
+		config.SetHTTPDConfig(httpdConf)
+		ftpdConf := config.GetFTPDConfig()
+		ftpdConf.CertificateFile = bootstrapCertFile
+		ftpdConf.CertificateKeyFile = bootstrapCertKeyFile
+		config.SetFTPDConfig(ftpdConf)
+		webDAVDConf := config.GetWebDAVDConfig()
+		webDAVDConf.CertificateFile = bootstrapCertFile
+		webDAVDConf.CertificateKeyFile = bootstrapCertKeyFile
+		config.SetWebDAVDConfig(webDAVDConf)
+	}
+	return nil
+}
+
+func createBootstrapAdmin() error {
+	if _, err := dataprovider.AdminExists(bootstrapAdminUsername); err == nil {
+		logger.InfoToConsole("Admin %q already exists, preserving it", bootstrapAdminUsername)
+		return nil
+	}
+	admin := dataprovider.Admin{
+		Username:    bootstrapAdminUsername,
+		Password:    bootstrapAdminPassword,
+		Status:      1,
+		Permissions: []string{dataprovider.PermAdminAny},
+	}
+	if err := dataprovider.AddAdmin(&admin, dataprovider.ActionExecutorSystem, ""); err != nil {
+		return fmt.Errorf("unable to create admin %q: %w", bootstrapAdminUsername, err)
+	}
+	logger.InfoToConsole("Admin %q successfully created", bootstrapAdminUsername)
+	return nil
+}
+
+func initializeBootstrapProvider(targetDir string) error {
+	kmsConfig := config.GetKMSConfig()
+	if err := kmsConfig.Initialize(); err != nil {
+		return fmt.Errorf("unable to initialize KMS: %w", err)
+	}
+	providerConf := config.GetProviderConf()
+	// ignore actions
+	providerConf.Actions.Hook = ""
+	providerConf.Actions.ExecuteFor = nil
+	providerConf.Actions.ExecuteOn = nil
+	// always initialize/update the database schema
+	providerConf.UpdateMode = 0
+	logger.InfoToConsole("Initializing provider: %q", providerConf.Driver)
+	if err := dataprovider.Initialize(providerConf, targetDir, false); err != nil {
+		return fmt.Errorf("unable to initialize the data provider: %w", err)
+	}
+	defer dataprovider.Close() //nolint:errcheck
+
+	return createBootstrapAdmin()
+}
+
+func runBootstrap() error {
+	if err := validateBootstrapOptions(); err != nil {
+		return err
+	}
+	bootstrapTargetDir = util.CleanDirInput(bootstrapTargetDir)
+	configFilePath := filepath.Join(bootstrapTargetDir, bootstrapConfigFile)
+	if _, err := os.Stat(configFilePath); err == nil && !bootstrapForce {
+		return fmt.Errorf("the configuration file %q already exists, use the \"force\" flag to overwrite it", configFilePath)
+	}
+	if err := os.MkdirAll(bootstrapTargetDir, 0700); err != nil {
+		return fmt.Errorf("unable to create the target directory: %w", err)
+	}
+	// start from the default configuration
+	config.Init()
+	if err := setBootstrapConfig(bootstrapTargetDir); err != nil {
+		return err
+	}
+	if err := config.WriteConfig(configFilePath); err != nil {
+		return err
+	}
+	logger.InfoToConsole("Configuration file %q written", configFilePath)
+	if err := config.LoadConfig(bootstrapTargetDir, bootstrapConfigFile); err != nil {
+		return fmt.Errorf("unable to validate the generated configuration: %w", err)
+	}
+	return initializeBootstrapProvider(bootstrapTargetDir)
+}
+
+func init() {
+	bootstrapCmd.Flags().StringVarP(&bootstrapTargetDir, "target-dir", "d", "", `Directory where the configuration file,
+the SQLite/bolt database, host keys and
+certificates are written. It will be created
+if missing`)
+	bootstrapCmd.Flags().BoolVarP(&bootstrapInteractive, "interactive", "i", false, `Ask for the bootstrap options,
+the values set using flags are proposed as
+defaults`)
+	bootstrapCmd.Flags().BoolVar(&bootstrapForce, "force", false, `Overwrite an existing configuration file`)
+	bootstrapCmd.Flags().StringVar(&bootstrapAdminUsername, "admin-username", "admin", `Username for the first admin`)
+
+	viper.SetDefault(bootstrapAdminPasswordKey, "")
+	viper.BindEnv(bootstrapAdminPasswordKey, "SFTPGO_BOOTSTRAP_ADMIN_PASSWORD") //nolint:errcheck
+	bootstrapCmd.Flags().StringVar(&bootstrapAdminPassword, "admin-password", viper.GetString(bootstrapAdminPasswordKey),
+		`Password for the first admin. This flag can
+be set using SFTPGO_BOOTSTRAP_ADMIN_PASSWORD
+env var too`)
+	bootstrapCmd.Flags().StringVar(&bootstrapProviderDriver, "provider-driver", dataprovider.SQLiteDataProviderName,
+		`Data provider driver. Supported values:
+sqlite, bolt, mysql, postgresql, cockroachdb`)
+	bootstrapCmd.Flags().StringVar(&bootstrapProviderName, "provider-name", "", `Database file name, relative to the target
+directory, for the sqlite and bolt drivers.
+Default "sftpgo.db" for sqlite and
+"sftpgo.bolt" for bolt`)
+	bootstrapCmd.Flags().StringVar(&bootstrapProviderConnStr, "provider-connection-string", "",
+		`Connection string for the mysql, postgresql
+and cockroachdb drivers. The database must
+already exist`)
+	bootstrapCmd.Flags().BoolVar(&bootstrapHostKeys, "host-keys", false, `Generate RSA, ECDSA and Ed25519 SFTP host
+keys`)
+	bootstrapCmd.Flags().BoolVar(&bootstrapSelfSignedCert, "self-signed-cert", false, `Generate a self-signed TLS certificate and
+enable HTTPS for the web admin and REST API`)
+	bootstrapCmd.Flags().StringSliceVar(&bootstrapCertHosts, "cert-hosts", []string{"localhost"},
+		`Hostnames and IP addresses for the
+self-signed certificate, comma separated`)
+
+	rootCmd.AddCommand(bootstrapCmd)
+}
@@ -0,0 +1,201 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	bulkFormat     string
+	bulkFile       string
+	bulkDryRun     bool
+	bulkObjectArgs = []string{dataprovider.BulkObjectUsers, dataprovider.BulkObjectGroups, dataprovider.BulkObjectFolders}
+	bulkCmd        = &cobra.Command{
+		Use:   "bulk",
+		Short: "Import and export users, groups and folders in CSV or NDJSON format",
+	}
+	bulkExportCmd = &cobra.Command{
+		Use:   "export [users|groups|folders]",
+		Short: "Export users, groups or folders to a CSV or NDJSON file",
+		Long: `This command exports all the users, groups or folders stored in the
+configured data provider. NDJSON files contain one complete object per line,
+including hashed passwords and encrypted secrets, CSV files contain only the
+fields supported for CSV imports. Example:
+
+$ sftpgo bulk export users --format csv --file users.csv
+
+This command is not supported for the memory provider.
+
+Please take a look at the usage below to customize the options.`,
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: bulkObjectArgs,
+		Run: func(_ *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			configDir = util.CleanDirInput(configDir)
+			if err := runBulkExport(args[0]); err != nil {
+				logger.ErrorToConsole("%v", err)
+				os.Exit(1)
+			}
+		},
+	}
+	bulkImportCmd = &cobra.Command{
+		Use:   "import [users|groups|folders]",
+		Short: "Import users, groups or folders from a CSV or NDJSON file",
+		Long: `This command adds the users, groups or folders defined in the specified
+file and updates the existing ones. Each NDJSON line must contain a complete
+object, as in backups, and replaces the existing one. CSV files must start with
+a header and only the fields for the defined columns are updated. Example:
+
+$ sftpgo bulk import users --format csv --file users.csv
+
+Invalid records do not stop the import and are reported at the end. Use the
+"dry-run" flag to validate the file without saving anything.
+The command exits with a non-zero status if any record cannot be imported.
+This command is not supported for the memory provider.
+
+Please take a look at the usage below to customize the options.`,
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: bulkObjectArgs,
+		Run: func(_ *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			configDir = util.CleanDirInput(configDir)
+			if err := runBulkImport(args[0]); err != nil {
+				logger.ErrorToConsole("%v", err)
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+func initBulkDataProvider() error {
+	if err := config.LoadConfig(configDir, configFile); err != nil {
+		return fmt.Errorf("unable to load configuration: %w", err)
+	}
+	kmsConfig := config.GetKMSConfig()
+	if err := kmsConfig.Initialize(); err != nil {
+		return fmt.Errorf("unable to initialize KMS: %w", err)
+	}
+	mfaConfig := config.GetMFAConfig()
+	if err := mfaConfig.Initialize(); err != nil {
+		return fmt.Errorf("unable to initialize MFA: %w", err)
+	}
+	if err := plugin.Initialize(config.GetPluginsConfig(), "info"); err != nil {
+		return fmt.Errorf("unable to initialize plugin system: %w", err)
+	}
+	providerConf := config.GetProviderConf()
+	if providerConf.Driver == dataprovider.MemoryDataProviderName {
+		return fmt.Errorf("the %q data provider is not supported", providerConf.Driver)
+	}
+	// ignore actions
+	providerConf.Actions.Hook = ""
+	providerConf.Actions.ExecuteFor = nil
+	providerConf.Actions.ExecuteOn = nil
+	if err := dataprovider.Initialize(providerConf, configDir, false); err != nil {
+		return fmt.Errorf("unable to initialize the data provider: %w", err)
+	}
+	return nil
+}
+
+func runBulkExport(objectType string) error {
+	if bulkFile == "" {
+		return errors.New("the file to write is mandatory")
+	}
+	if err := dataprovider.ValidateBulkOptions(objectType, bulkFormat); err != nil {
+		return err
+	}
+	if err := initBulkDataProvider(); err != nil {
+		return err
+	}
+	defer plugin.Handler.Cleanup()
+	defer dataprovider.Close() //nolint:errcheck
+
+	f, err := os.OpenFile(bulkFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := dataprovider.ExportBulk(f, objectType, bulkFormat); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to export %s: %w", objectType, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	logger.InfoToConsole("Exported %s to %q", objectType, bulkFile)
+	return nil
+}
+
+func runBulkImport(objectType string) error {
+	if bulkFile == "" {
+		return errors.New("the file to read is mandatory")
+	}
+	if err := dataprovider.ValidateBulkOptions(objectType, bulkFormat); err != nil {
+		return err
+	}
+	f, err := os.Open(bulkFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := initBulkDataProvider(); err != nil {
+		return err
+	}
+	defer plugin.Handler.Cleanup()
+	defer dataprovider.Close() //nolint:errcheck
+
+	logger.InfoToConsole("Importing %s from %q, dry run: %t", objectType, bulkFile, bulkDryRun)
+	result, err := dataprovider.ImportBulk(f, objectType, bulkFormat, bulkDryRun, "", "")
+	for _, e := range result.Errors {
+		logger.WarnToConsole("Line %d, name %q: %s", e.Line, e.Name, e.Error)
+	}
+	logger.InfoToConsole("Processed records: %d, added: %d, updated: %d, failed: %d", result.Total, result.Added,
+		result.Updated, result.Failed)
+	if err != nil {
+		return err
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("unable to import %d record/s", result.Failed)
+	}
+	return nil
+}
+
+func init() {
+	addBulkFlags := func(cmd *cobra.Command, fileUsage string) {
+		addConfigFlags(cmd)
+		cmd.Flags().StringVar(&bulkFormat, "format", dataprovider.BulkFormatNDJSON, `Supported formats: "csv", "ndjson"`)
+		cmd.Flags().StringVar(&bulkFile, "file", "", fileUsage)
+	}
+	addBulkFlags(bulkExportCmd, "Path to the file to write")
+	addBulkFlags(bulkImportCmd, "Path to the file to read")
+	bulkImportCmd.Flags().BoolVar(&bulkDryRun, "dry-run", false, `Validate the records without saving
+them`)
+
+	bulkCmd.AddCommand(bulkExportCmd, bulkImportCmd)
+	rootCmd.AddCommand(bulkCmd)
+}
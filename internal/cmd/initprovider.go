@@ -15,6 +15,8 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 
 	"github.com/rs/zerolog"
@@ -28,8 +30,15 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
+const (
+	migrationPlanFormatSQL  = "sql"
+	migrationPlanFormatJSON = "json"
+)
+
 var (
-	initProviderCmd = &cobra.Command{
+	migrationDryRun     bool
+	migrationPlanFormat string
+	initProviderCmd     = &cobra.Command{
 		Use:   "initprovider",
 		Short: "Initialize and/or updates the configured data provider",
 		Long: `This command reads the data provider connection details from the specified
@@ -48,11 +57,19 @@ To initialize/update the data provider from the configuration directory simply u
 
 $ sftpgo initprovider
 
+To review the changes before applying them use the "dry-run" flag, the SQL
+statements to execute are printed and the data provider is not modified:
+
+$ sftpgo initprovider --dry-run
+
+Use "--plan-format json" to get a machine-readable migration plan.
+The "dry-run" flag is supported for SQL based data providers only.
+
 Any defined action is ignored.
 Please take a look at the usage below to customize the options.`,
 		Run: func(_ *cobra.Command, _ []string) {
 			logger.DisableLogger()
-			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			enableMigrationConsoleLogger()
 			configDir = util.CleanDirInput(configDir)
 			err := config.LoadConfig(configDir, configFile)
 			if err != nil {
@@ -70,6 +87,18 @@ Please take a look at the usage below to customize the options.`,
 			providerConf.Actions.Hook = ""
 			providerConf.Actions.ExecuteFor = nil
 			providerConf.Actions.ExecuteOn = nil
+			if migrationDryRun {
+				plan, err := dataprovider.PlanDatabaseMigration(providerConf, configDir)
+				if err != nil {
+					logger.ErrorToConsole("Unable to plan the data provider initialization/update: %v", err)
+					os.Exit(1)
+				}
+				if err := printMigrationPlan(plan); err != nil {
+					logger.ErrorToConsole("%v", err)
+					os.Exit(1)
+				}
+				return
+			}
 			logger.InfoToConsole("Initializing provider: %#v config file: %#v", providerConf.Driver, viper.ConfigFileUsed())
 			err = dataprovider.InitializeDatabase(providerConf, configDir)
 			if err == nil {
@@ -96,8 +125,49 @@ Please take a look at the usage below to customize the options.`,
 	}
 )
 
+// enableMigrationConsoleLogger enables the console logger. In dry-run mode
+// only errors are logged so the standard output contains the plan only
+func enableMigrationConsoleLogger() {
+	if migrationDryRun {
+		logger.EnableConsoleLogger(zerolog.ErrorLevel)
+		return
+	}
+	logger.EnableConsoleLogger(zerolog.DebugLevel)
+}
+
+func printMigrationPlan(plan dataprovider.MigrationPlan) error {
+	switch migrationPlanFormat {
+	case migrationPlanFormatSQL:
+		if plan.IsEmpty() {
+			fmt.Printf("-- %s: schema version %d is up to date, nothing to do\n", plan.Driver, plan.CurrentVersion)
+			return nil
+		}
+		fmt.Print(plan.SQL())
+	case migrationPlanFormatJSON:
+		data, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return fmt.Errorf("unable to serialize the migration plan: %w", err)
+		}
+		fmt.Println(string(data))
+	default:
+		return fmt.Errorf("unsupported plan format %q, supported formats: %s, %s", migrationPlanFormat,
+			migrationPlanFormatSQL, migrationPlanFormatJSON)
+	}
+	return nil
+}
+
+func addMigrationPlanFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&migrationDryRun, "dry-run", false, `Print the planned changes without
+modifying the data provider. Supported
+for SQL based data providers only`)
+	cmd.Flags().StringVar(&migrationPlanFormat, "plan-format", migrationPlanFormatSQL, `Format for the planned changes printed
+in dry-run mode. Supported values: "sql",
+"json"`)
+}
+
 func init() {
 	rootCmd.AddCommand(initProviderCmd)
 	addConfigFlags(initProviderCmd)
 	addBaseLoadDataFlags(initProviderCmd)
+	addMigrationPlanFlags(initProviderCmd)
 }
@@ -64,12 +64,16 @@ var (
 	portableS3KeyPrefix                string
 	portableS3ULPartSize               int
 	portableS3ULConcurrency            int
+	portableS3DLPartSize               int
+	portableS3DLConcurrency            int
 	portableS3ForcePathStyle           bool
 	portableGCSBucket                  string
 	portableGCSCredentialsFile         string
 	portableGCSAutoCredentials         int
 	portableGCSStorageClass            string
 	portableGCSKeyPrefix               string
+	portableGCSEncryptionKey           string
+	portableGCSKMSKeyName              string
 	portableFTPDPort                   int
 	portableFTPSCert                   string
 	portableFTPSKey                    string
@@ -200,17 +204,19 @@ Please take a look at the usage below to customize the serving parameters`,
 						Provider: sdk.GetProviderByName(portableFsProvider),
 						S3Config: vfs.S3FsConfig{
 							BaseS3FsConfig: sdk.BaseS3FsConfig{
-								Bucket:            portableS3Bucket,
-								Region:            portableS3Region,
-								AccessKey:         portableS3AccessKey,
-								RoleARN:           portableS3RoleARN,
-								Endpoint:          portableS3Endpoint,
-								StorageClass:      portableS3StorageClass,
-								ACL:               portableS3ACL,
-								KeyPrefix:         portableS3KeyPrefix,
-								UploadPartSize:    int64(portableS3ULPartSize),
-								UploadConcurrency: portableS3ULConcurrency,
-								ForcePathStyle:    portableS3ForcePathStyle,
+								Bucket:              portableS3Bucket,
+								Region:              portableS3Region,
+								AccessKey:           portableS3AccessKey,
+								RoleARN:             portableS3RoleARN,
+								Endpoint:            portableS3Endpoint,
+								StorageClass:        portableS3StorageClass,
+								ACL:                 portableS3ACL,
+								KeyPrefix:           portableS3KeyPrefix,
+								UploadPartSize:      int64(portableS3ULPartSize),
+								UploadConcurrency:   portableS3ULConcurrency,
+								DownloadPartSize:    int64(portableS3DLPartSize),
+								DownloadConcurrency: portableS3DLConcurrency,
+								ForcePathStyle:      portableS3ForcePathStyle,
 							},
 							AccessSecret: kms.NewPlainSecret(portableS3AccessSecret),
 						},
@@ -221,7 +227,9 @@ Please take a look at the usage below to customize the serving parameters`,
 								StorageClass:         portableGCSStorageClass,
 								KeyPrefix:            portableGCSKeyPrefix,
 							},
-							Credentials: kms.NewPlainSecret(portableGCSCredentials),
+							Credentials:   kms.NewPlainSecret(portableGCSCredentials),
+							EncryptionKey: kms.NewPlainSecret(portableGCSEncryptionKey),
+							KMSKeyName:    portableGCSKMSKeyName,
 						},
 						AzBlobConfig: vfs.AzBlobFsConfig{
 							BaseAzBlobFsConfig: sdk.BaseAzBlobFsConfig{
@@ -343,6 +351,10 @@ prefix and its contents`)
 	portableCmd.Flags().IntVar(&portableS3ULPartSize, "s3-upload-part-size", 5, `The buffer size for multipart uploads
 (MB)`)
 	portableCmd.Flags().IntVar(&portableS3ULConcurrency, "s3-upload-concurrency", 2, `How many parts are uploaded in
+parallel`)
+	portableCmd.Flags().IntVar(&portableS3DLPartSize, "s3-download-part-size", 5, `The buffer size for multipart downloads
+(MB)`)
+	portableCmd.Flags().IntVar(&portableS3DLConcurrency, "s3-download-concurrency", 5, `How many parts are downloaded in
 parallel`)
 	portableCmd.Flags().BoolVar(&portableS3ForcePathStyle, "s3-force-path-style", false, `Force path style bucket URL`)
 	portableCmd.Flags().StringVar(&portableGCSBucket, "gcs-bucket", "", "")
@@ -355,6 +367,10 @@ file`)
 	portableCmd.Flags().IntVar(&portableGCSAutoCredentials, "gcs-automatic-credentials", 1, `0 means explicit credentials using
 a JSON credentials file, 1 automatic
 `)
+	portableCmd.Flags().StringVar(&portableGCSEncryptionKey, "gcs-encryption-key", "", `Base64 encoded AES-256 customer-supplied
+encryption key`)
+	portableCmd.Flags().StringVar(&portableGCSKMSKeyName, "gcs-kms-key-name", "", `Cloud KMS key name to use to encrypt
+new objects`)
 	portableCmd.Flags().StringVar(&portableFTPSCert, "ftpd-cert", "", "Path to the certificate file for FTPS")
 	portableCmd.Flags().StringVar(&portableFTPSKey, "ftpd-key", "", "Path to the key file for FTPS")
 	portableCmd.Flags().StringVar(&portableWebDAVCert, "webdav-cert", "", `Path to the certificate file for WebDAV
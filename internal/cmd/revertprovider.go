@@ -17,7 +17,6 @@ package cmd
 import (
 	"os"
 
-	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
@@ -36,12 +35,17 @@ var (
 configuration file and restore the provider schema and/or data to a previous version.
 This command is not supported for the memory provider.
 
+To review the changes before applying them use the "dry-run" flag, the SQL
+statements to execute are printed and the data provider is not modified:
+
+$ sftpgo revertprovider --to-version 26 --dry-run
+
 Please take a look at the usage below to customize the options.`,
 		Run: func(_ *cobra.Command, _ []string) {
 			logger.DisableLogger()
-			logger.EnableConsoleLogger(zerolog.DebugLevel)
-			if revertProviderTargetVersion != 19 {
-				logger.WarnToConsole("Unsupported target version, 19 is the only supported one")
+			enableMigrationConsoleLogger()
+			if revertProviderTargetVersion < 19 {
+				logger.WarnToConsole("Unsupported target version, 19 is the oldest supported one")
 				os.Exit(1)
 			}
 			configDir = util.CleanDirInput(configDir)
@@ -57,6 +61,18 @@ Please take a look at the usage below to customize the options.`,
 				os.Exit(1)
 			}
 			providerConf := config.GetProviderConf()
+			if migrationDryRun {
+				plan, err := dataprovider.PlanDatabaseRevert(providerConf, configDir, revertProviderTargetVersion)
+				if err != nil {
+					logger.ErrorToConsole("Unable to plan the data provider revert: %v", err)
+					os.Exit(1)
+				}
+				if err := printMigrationPlan(plan); err != nil {
+					logger.ErrorToConsole("%v", err)
+					os.Exit(1)
+				}
+				return
+			}
 			logger.InfoToConsole("Reverting provider: %#v config file: %#v target version %v", providerConf.Driver,
 				viper.ConfigFileUsed(), revertProviderTargetVersion)
 			err = dataprovider.RevertDatabase(providerConf, configDir, revertProviderTargetVersion)
@@ -71,7 +87,9 @@ Please take a look at the usage below to customize the options.`,
 
 func init() {
 	addConfigFlags(revertProviderCmd)
-	revertProviderCmd.Flags().IntVar(&revertProviderTargetVersion, "to-version", 19, `19 means the version supported in v2.3.x`)
+	revertProviderCmd.Flags().IntVar(&revertProviderTargetVersion, "to-version", 19, `Target schema version. 19 means the
+version supported in v2.3.x`)
+	addMigrationPlanFlags(revertProviderCmd)
 
 	rootCmd.AddCommand(revertProviderCmd)
 }
@@ -0,0 +1,145 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	rotateKMSOldMasterKeyPath string
+	rotateKMSOldURL           string
+	rotateKMSDryRun           bool
+	rotateKMSKeyCmd           = &cobra.Command{
+		Use:   "rotatekmskey",
+		Short: "Re-encrypt the stored secrets using the current KMS configuration",
+		Long: `This command decrypts the secrets stored in the configured data provider,
+for users, groups, folders, admins and event actions, using the previous
+master key and encrypts them again using the KMS configuration from the
+specified configuration file.
+
+SFTPGo must be stopped while this command is running. Set the new master key
+in the configuration file, run this command and then start SFTPGo again:
+
+$ sftpgo rotatekmskey --old-master-key-path /etc/sftpgo/old_master_key
+
+If no previous master key is specified the secrets encrypted without a master
+key are encrypted again using the configured one.
+Secrets already encrypted using the current configuration are not modified,
+so the command can be safely executed again after a failure.
+Use the "dry-run" flag to check that all the secrets can be decrypted without
+updating the data provider.
+This command is not supported for the memory provider.
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(_ *cobra.Command, _ []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			configDir = util.CleanDirInput(configDir)
+			if err := runRotateKMSKey(); err != nil {
+				logger.ErrorToConsole("%v", err)
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+func runRotateKMSKey() error {
+	if err := config.LoadConfig(configDir, configFile); err != nil {
+		return fmt.Errorf("unable to load configuration: %w", err)
+	}
+	kmsConfig := config.GetKMSConfig()
+	oldKMSConfig := kms.Configuration{
+		Secrets: kms.Secrets{
+			URL:           kmsConfig.Secrets.URL,
+			MasterKeyPath: rotateKMSOldMasterKeyPath,
+		},
+	}
+	if rotateKMSOldURL != "" {
+		oldKMSConfig.Secrets.URL = rotateKMSOldURL
+	}
+	if err := plugin.Initialize(config.GetPluginsConfig(), "info"); err != nil {
+		return fmt.Errorf("unable to initialize plugin system: %w", err)
+	}
+	defer plugin.Handler.Cleanup()
+
+	if err := kmsConfig.Initialize(); err != nil {
+		return fmt.Errorf("unable to initialize KMS: %w", err)
+	}
+	rotator, err := kms.NewKeyRotator(oldKMSConfig)
+	if err != nil {
+		return err
+	}
+	mfaConfig := config.GetMFAConfig()
+	if err := mfaConfig.Initialize(); err != nil {
+		return fmt.Errorf("unable to initialize MFA: %w", err)
+	}
+	providerConf := config.GetProviderConf()
+	if providerConf.Driver == dataprovider.MemoryDataProviderName {
+		return fmt.Errorf("the %q data provider is not supported", providerConf.Driver)
+	}
+	// ignore actions
+	providerConf.Actions.Hook = ""
+	providerConf.Actions.ExecuteFor = nil
+	providerConf.Actions.ExecuteOn = nil
+	if err := dataprovider.Initialize(providerConf, configDir, false); err != nil {
+		return fmt.Errorf("unable to initialize the data provider: %w", err)
+	}
+	defer dataprovider.Close() //nolint:errcheck
+
+	logger.InfoToConsole("Rotating KMS secrets, provider: %q, dry run: %t", providerConf.Driver, rotateKMSDryRun)
+	result, err := dataprovider.RotateKMSSecrets(rotator, rotateKMSDryRun, func(p dataprovider.KeyRotationProgress) {
+		if p.Err != nil {
+			logger.WarnToConsole("Unable to rotate the secrets for %s %q: %v", p.ObjectType, p.Name, p.Err)
+			return
+		}
+		if p.Rotated > 0 {
+			logger.InfoToConsole("Rotated %d secret/s for %s %q", p.Rotated, p.ObjectType, p.Name)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	logger.InfoToConsole("Rotated %d secret/s, updated objects: %d, failed objects: %d", result.Rotated,
+		result.Updated, len(result.Failed))
+	if len(result.Failed) > 0 {
+		return fmt.Errorf("unable to rotate the secrets for %d object/s", len(result.Failed))
+	}
+	return nil
+}
+
+func init() {
+	addConfigFlags(rotateKMSKeyCmd)
+	rotateKMSKeyCmd.Flags().StringVar(&rotateKMSOldMasterKeyPath, "old-master-key-path", "", `Path to the file containing the
+previous master key. Leave empty if the
+secrets were encrypted without a master key`)
+	rotateKMSKeyCmd.Flags().StringVar(&rotateKMSOldURL, "old-url", "", `Previous KMS URL. Leave empty if the KMS
+URL is unchanged`)
+	rotateKMSKeyCmd.Flags().BoolVar(&rotateKMSDryRun, "dry-run", false, `Check that the secrets can be decrypted
+without updating the data provider`)
+
+	rootCmd.AddCommand(rotateKMSKeyCmd)
+}
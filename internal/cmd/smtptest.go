@@ -28,6 +28,9 @@ import (
 
 var (
 	smtpTestRecipient string
+	smtpTestCc        []string
+	smtpTestBcc       []string
+	smtpTestReplyTo   string
 	smtpTestCmd       = &cobra.Command{
 		Use:   "smtptest",
 		Short: "Test the SMTP configuration",
@@ -48,7 +51,13 @@ If the SMTP configuration is correct you should receive this email.`,
 				logger.ErrorToConsole("unable to initialize SMTP configuration: %v", err)
 				os.Exit(1)
 			}
-			err = smtp.SendEmail([]string{smtpTestRecipient}, "SFTPGo - Testing Email Settings", "It appears your SFTPGo email is setup correctly!",
+			addresses := smtp.EmailAddresses{
+				To:      []string{smtpTestRecipient},
+				Cc:      smtpTestCc,
+				Bcc:     smtpTestBcc,
+				ReplyTo: smtpTestReplyTo,
+			}
+			err = smtp.SendEmailTo(addresses, "SFTPGo - Testing Email Settings", "It appears your SFTPGo email is setup correctly!",
 				smtp.EmailContentTypeTextPlain)
 			if err != nil {
 				logger.WarnToConsole("Error sending email: %v", err)
@@ -63,6 +72,9 @@ func init() {
 	addConfigFlags(smtpTestCmd)
 	smtpTestCmd.Flags().StringVar(&smtpTestRecipient, "recipient", "", `email address to send the test e-mail to`)
 	smtpTestCmd.MarkFlagRequired("recipient") //nolint:errcheck
+	smtpTestCmd.Flags().StringSliceVar(&smtpTestCc, "cc", nil, `optional carbon copy recipients, comma separated`)
+	smtpTestCmd.Flags().StringSliceVar(&smtpTestBcc, "bcc", nil, `optional blind carbon copy recipients, comma separated`)
+	smtpTestCmd.Flags().StringVar(&smtpTestReplyTo, "reply-to", "", `optional Reply-To address`)
 
 	rootCmd.AddCommand(smtpTestCmd)
 }
@@ -127,6 +127,11 @@ Command-line flags should be specified in the Subsystem declaration.
 				logger.Error(logSender, connectionID, "unable to initialize the data provider: %v", err)
 				os.Exit(1)
 			}
+			dnsConfig := config.GetDNSConfig()
+			if err := dnsConfig.Initialize(); err != nil {
+				logger.Error(logSender, connectionID, "unable to initialize DNS configuration: %v", err)
+				os.Exit(1)
+			}
 			httpConfig := config.GetHTTPConfig()
 			if err := httpConfig.Initialize(configDir); err != nil {
 				logger.Error(logSender, connectionID, "unable to initialize http client: %v", err)
@@ -170,7 +175,8 @@ Command-line flags should be specified in the Subsystem declaration.
 				logger.Error(logSender, connectionID, "unable to apply group settings for user %#v: %v", username, err)
 				os.Exit(1)
 			}
-			err = sftpd.ServeSubSystemConnection(&user, connectionID, os.Stdin, os.Stdout)
+			sftpdConf := config.GetSFTPDConfig()
+			err = sftpd.ServeSubSystemConnection(&user, connectionID, os.Stdin, os.Stdout, &sftpdConf)
 			if err != nil && err != io.EOF {
 				logger.Warn(logSender, connectionID, "serving subsystem finished with error: %v", err)
 				os.Exit(1)
@@ -0,0 +1,84 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/v2/internal/auditlog"
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	verifyAuditLogFile string
+	verifyAuditLogCmd  = &cobra.Command{
+		Use:   "verifyauditlog",
+		Short: "Verify the integrity of the security audit log",
+		Long: `This command checks that the records of the security audit log are
+contiguous and that the hash chain is intact. Any modified, removed or
+reordered record is detected.
+
+By default the audit log file configured in the specified configuration file
+is verified. Use the "file" flag to verify a different file, for example an
+export:
+
+$ sftpgo verifyauditlog --file auditlog-20221015T100000Z.jsonl
+
+The command exits with a non-zero status if the verification fails.
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(_ *cobra.Command, _ []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			filePath := verifyAuditLogFile
+			if filePath == "" {
+				configDir = util.CleanDirInput(configDir)
+				err := config.LoadConfig(configDir, configFile)
+				if err != nil {
+					logger.ErrorToConsole("Unable to load the configuration: %v", err)
+					os.Exit(1)
+				}
+				auditLogConfig := config.GetAuditLogConfig()
+				if !auditLogConfig.IsEnabled() {
+					logger.ErrorToConsole("The audit log is not configured, please specify the file to verify")
+					os.Exit(1)
+				}
+				filePath = auditLogConfig.GetFilePath(configDir)
+			}
+			result, err := auditlog.VerifyFile(filePath)
+			if err != nil {
+				logger.ErrorToConsole("Audit log %q verification failed: %v", filePath, err)
+				os.Exit(1)
+			}
+			logger.InfoToConsole("Audit log %q successfully verified, records: %d, first sequence: %d, "+
+				"last sequence: %d, last hash: %q", filePath, result.Records, result.FirstSequence,
+				result.LastSequence, result.LastHash)
+		},
+	}
+)
+
+func init() {
+	addConfigFlags(verifyAuditLogCmd)
+	verifyAuditLogCmd.Flags().StringVar(&verifyAuditLogFile, "file", "", `Path to the audit log file to verify.
+If empty the file configured in the
+"audit_log" section is verified`)
+
+	rootCmd.AddCommand(verifyAuditLogCmd)
+}
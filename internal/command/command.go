@@ -42,12 +42,14 @@ const (
 	HookPostLogin           = "post_login"
 	HookExternalAuth        = "external_auth"
 	HookKeyboardInteractive = "keyboard_interactive"
+	HookEventAction         = "event_action"
 )
 
 var (
 	config         Config
 	supportedHooks = []string{HookFsActions, HookProviderActions, HookStartup, HookPostConnect, HookPostDisconnect,
-		HookDataRetention, HookCheckPassword, HookPreLogin, HookPostLogin, HookExternalAuth, HookKeyboardInteractive}
+		HookDataRetention, HookCheckPassword, HookPreLogin, HookPostLogin, HookExternalAuth, HookKeyboardInteractive,
+		HookEventAction}
 )
 
 // Command define the configuration for a specific commands
@@ -67,6 +69,9 @@ type Command struct {
 	Args []string `json:"args" mapstructure:"args"`
 	// if not empty both command path and hook name must match
 	Hook string `json:"hook" mapstructure:"hook"`
+	// Limits defines the execution limits for this command.
+	// The values set override the global limits
+	Limits Limits `json:"limits" mapstructure:"limits"`
 }
 
 // Config defines the configuration for external commands such as
@@ -81,6 +86,13 @@ type Config struct {
 	Env []string `json:"env" mapstructure:"env"`
 	// Commands defines configuration for specific commands
 	Commands []Command `json:"commands" mapstructure:"commands"`
+	// Limits defines the execution limits for all the commands
+	Limits Limits `json:"limits" mapstructure:"limits"`
+	// Absolute path to a cgroup v2 directory, writable by the SFTPGo process.
+	// If set, each command runs in a dedicated child cgroup, the memory limit
+	// is enforced using the cgroup and any process left running by the
+	// command is killed when it ends. Linux only
+	Cgroup string `json:"cgroup" mapstructure:"cgroup"`
 }
 
 func init() {
@@ -99,6 +111,12 @@ func (c Config) Initialize() error {
 			return fmt.Errorf("invalid env var %#v", env)
 		}
 	}
+	if err := c.Limits.validate(); err != nil {
+		return err
+	}
+	if err := validateCgroup(c.Cgroup); err != nil {
+		return err
+	}
 	for idx, cmd := range c.Commands {
 		if cmd.Path == "" {
 			return fmt.Errorf("invalid path %#v", cmd.Path)
@@ -121,6 +139,9 @@ func (c Config) Initialize() error {
 				return fmt.Errorf("invalid hook name %q, supported values: %+v", cmd.Hook, supportedHooks)
 			}
 		}
+		if err := cmd.Limits.validate(); err != nil {
+			return fmt.Errorf("%w for command %q", err, cmd.Path)
+		}
 	}
 	config = c
 	return nil
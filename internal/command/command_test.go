@@ -15,6 +15,12 @@
 package command
 
 import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -179,3 +185,179 @@ func TestConfigErrors(t *testing.T) {
 		assert.Contains(t, err.Error(), "invalid hook name")
 	}
 }
+
+func TestLimitsConfig(t *testing.T) {
+	cfg := Config{
+		Timeout: 10,
+		Limits: Limits{
+			CPUTime:      -1,
+			EnvAllowlist: []string{"PATH"},
+		},
+	}
+	err := cfg.Initialize()
+	assert.ErrorContains(t, err, "cannot be negative")
+	cfg.Limits.CPUTime = 10
+	cfg.Limits.WorkingDir = "relative"
+	err = cfg.Initialize()
+	assert.ErrorContains(t, err, "invalid working directory")
+	cfg.Limits.WorkingDir = ""
+	cfg.Limits.EnvAllowlist = []string{"A=B"}
+	err = cfg.Initialize()
+	assert.ErrorContains(t, err, "invalid allowed env var name")
+	cfg.Limits.EnvAllowlist = []string{"PATH"}
+	cfg.Cgroup = "relative"
+	err = cfg.Initialize()
+	assert.ErrorContains(t, err, "invalid cgroup")
+	cfg.Cgroup = filepath.Join(os.TempDir(), "missing_cgroup")
+	err = cfg.Initialize()
+	assert.ErrorContains(t, err, "invalid cgroup")
+	cfg.Cgroup = ""
+	cfg.Commands = []Command{
+		{
+			Path: "cmd1",
+			Limits: Limits{
+				MaxOutputSize: -1,
+			},
+		},
+	}
+	err = cfg.Initialize()
+	assert.ErrorContains(t, err, "for command \"cmd1\"")
+	cfg.Commands = []Command{
+		{
+			Path: "cmd1",
+			Limits: Limits{
+				MaxMemory:     100,
+				MaxOutputSize: 10,
+				WorkingDir:    os.TempDir(),
+				EnvAllowlist:  []string{"HOME", "PATH"},
+			},
+			Hook: HookEventAction,
+		},
+	}
+	err = cfg.Initialize()
+	require.NoError(t, err)
+	limits := GetLimits("cmd1", HookEventAction)
+	assert.Equal(t, 10, limits.CPUTime)
+	assert.Equal(t, 100, limits.MaxMemory)
+	assert.Equal(t, 10, limits.MaxOutputSize)
+	assert.Equal(t, os.TempDir(), limits.WorkingDir)
+	assert.Equal(t, []string{"PATH", "HOME"}, limits.EnvAllowlist)
+	limits = GetLimits("cmd1", HookFsActions)
+	assert.Equal(t, 10, limits.CPUTime)
+	assert.Equal(t, 0, limits.MaxMemory)
+	assert.Empty(t, limits.WorkingDir)
+	assert.Equal(t, []string{"PATH"}, limits.EnvAllowlist)
+	// the global config must not be modified
+	assert.Equal(t, []string{"PATH"}, config.Limits.EnvAllowlist)
+
+	cfg = Config{
+		Timeout: defaultTimeout,
+	}
+	err = cfg.Initialize()
+	require.NoError(t, err)
+}
+
+func TestRunWithLimits(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("this test is not available on Windows")
+	}
+	t.Setenv("SFTPGO_TEST_ALLOWED", "allowed")
+	t.Setenv("SFTPGO_TEST_DENIED", "denied")
+	shell := "/bin/sh"
+	cfg := Config{
+		Timeout: 10,
+		Commands: []Command{
+			{
+				Path: shell,
+				Limits: Limits{
+					MaxOutputSize: 1,
+					WorkingDir:    os.TempDir(),
+					EnvAllowlist:  []string{"SFTPGO_TEST_ALLOWED", "SFTPGO_TEST_MISSING"},
+				},
+			},
+		},
+	}
+	err := cfg.Initialize()
+	require.NoError(t, err)
+
+	cmd := exec.Command(shell, "-c", "pwd; echo $SFTPGO_TEST_ALLOWED; echo $SFTPGO_TEST_DENIED; echo $A")
+	cmd.Env = []string{"A=B"}
+	out, err := Output(cmd, HookPreLogin)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, filepath.Clean(os.TempDir()), filepath.Clean(lines[0]))
+	assert.Equal(t, "allowed", lines[1])
+	assert.Empty(t, lines[2])
+	assert.Equal(t, "B", lines[3])
+	// the configured env vars have precedence
+	cmd = exec.Command(shell, "-c", "echo $SFTPGO_TEST_ALLOWED")
+	cmd.Env = []string{"SFTPGO_TEST_ALLOWED=configured"}
+	out, err = Output(cmd, HookPreLogin)
+	require.NoError(t, err)
+	assert.Equal(t, "configured", strings.TrimSpace(string(out)))
+
+	cmd = exec.Command(shell, "-c", "while true; do echo 0123456789; done")
+	_, err = Output(cmd, HookPreLogin)
+	assert.ErrorIs(t, err, ErrOutputLimitExceeded)
+	// the output is discarded and so not limited
+	cmd = exec.Command(shell, "-c", "i=0; while [ $i -lt 200 ]; do echo 0123456789; i=$((i+1)); done")
+	err = Run(cmd, HookPreLogin)
+	assert.NoError(t, err)
+
+	cmd = exec.Command(filepath.Join(os.TempDir(), "missing_command"))
+	err = Run(cmd, HookPreLogin)
+	assert.Error(t, err)
+	_, err = Start(exec.Command(filepath.Join(os.TempDir(), "missing_command")), HookPreLogin)
+	assert.Error(t, err)
+
+	if runtime.GOOS == "linux" {
+		cfg.Limits.CPUTime = 1
+		err = cfg.Initialize()
+		require.NoError(t, err)
+		startTime := time.Now()
+		cmd = exec.Command(shell, "-c", "while true; do :; done")
+		err = Run(cmd, HookPreLogin)
+		assert.Error(t, err)
+		assert.Less(t, time.Since(startTime), 8*time.Second)
+
+		cfg.Limits.CPUTime = 0
+		cfg.Limits.MaxMemory = 1024
+		err = cfg.Initialize()
+		require.NoError(t, err)
+		cmd = exec.Command(shell, "-c", "exit 0")
+		cleanup, err := Start(cmd, HookPreLogin)
+		require.NoError(t, err)
+		err = cmd.Wait()
+		assert.NoError(t, err)
+		cleanup()
+	}
+
+	cfg = Config{
+		Timeout: defaultTimeout,
+	}
+	err = cfg.Initialize()
+	require.NoError(t, err)
+}
+
+func TestLimitedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &limitedWriter{
+		w:     &buf,
+		cmd:   &exec.Cmd{},
+		limit: 10,
+	}
+	n, err := w.Write([]byte("01234"))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	n, err = w.Write([]byte("56789"))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.False(t, w.isExceeded())
+	_, err = w.Write([]byte("a"))
+	assert.ErrorIs(t, err, ErrOutputLimitExceeded)
+	assert.True(t, w.isExceeded())
+	_, err = w.Write([]byte("b"))
+	assert.ErrorIs(t, err, ErrOutputLimitExceeded)
+	assert.Equal(t, "0123456789", buf.String())
+}
@@ -0,0 +1,252 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// ErrOutputLimitExceeded is returned if a command writes more than the
+// configured max output size, the command is killed
+var ErrOutputLimitExceeded = errors.New("command output limit exceeded")
+
+// Limits defines the execution limits for the external commands.
+// Zero values mean no limit
+type Limits struct {
+	// Maximum CPU time, as seconds
+	CPUTime int `json:"cpu_time" mapstructure:"cpu_time"`
+	// Maximum memory, as MB. This is the virtual address space limit or,
+	// if a cgroup is configured, the cgroup memory limit. Linux only
+	MaxMemory int `json:"max_memory" mapstructure:"max_memory"`
+	// Maximum size, as KB, for the command output read by SFTPGo, for example
+	// the pre-login and external auth hooks responses
+	MaxOutputSize int `json:"max_output_size" mapstructure:"max_output_size"`
+	// Absolute path to the working directory. Empty means the SFTPGo one
+	WorkingDir string `json:"working_dir" mapstructure:"working_dir"`
+	// Names of the environment variables to pass from the SFTPGo process
+	// environment, for example "PATH". Per-command names are added to the
+	// global ones
+	EnvAllowlist []string `json:"env_allowlist" mapstructure:"env_allowlist"`
+}
+
+func (l *Limits) validate() error {
+	if l.CPUTime < 0 || l.MaxMemory < 0 || l.MaxOutputSize < 0 {
+		return errors.New("execution limits cannot be negative")
+	}
+	if (l.CPUTime > 0 || l.MaxMemory > 0) && !isProcessLimitsSupported() {
+		return errors.New("CPU time and memory limits are not supported on this platform")
+	}
+	if l.WorkingDir != "" && !filepath.IsAbs(l.WorkingDir) {
+		return fmt.Errorf("invalid working directory %q, it must be an absolute path", l.WorkingDir)
+	}
+	for _, name := range l.EnvAllowlist {
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("invalid allowed env var name %q", name)
+		}
+	}
+	return nil
+}
+
+// merge returns the limits obtained overriding l with the values set in other
+func (l Limits) merge(other Limits) Limits {
+	if other.CPUTime > 0 {
+		l.CPUTime = other.CPUTime
+	}
+	if other.MaxMemory > 0 {
+		l.MaxMemory = other.MaxMemory
+	}
+	if other.MaxOutputSize > 0 {
+		l.MaxOutputSize = other.MaxOutputSize
+	}
+	if other.WorkingDir != "" {
+		l.WorkingDir = other.WorkingDir
+	}
+	allowlist := make([]string, 0, len(l.EnvAllowlist)+len(other.EnvAllowlist))
+	allowlist = append(allowlist, l.EnvAllowlist...)
+	for _, name := range other.EnvAllowlist {
+		if !util.Contains(allowlist, name) {
+			allowlist = append(allowlist, name)
+		}
+	}
+	l.EnvAllowlist = allowlist
+	return l
+}
+
+func (l *Limits) getAllowedEnv() []string {
+	var env []string
+	for _, name := range l.EnvAllowlist {
+		if val, ok := os.LookupEnv(name); ok {
+			env = append(env, fmt.Sprintf("%s=%s", name, val))
+		}
+	}
+	return env
+}
+
+// GetLimits returns the execution limits for the specified command
+func GetLimits(command, hook string) Limits {
+	limits := config.Limits.merge(Limits{})
+	for _, cmd := range config.Commands {
+		if cmd.Path == command {
+			if cmd.Hook == "" || cmd.Hook == hook {
+				limits = limits.merge(cmd.Limits)
+				break
+			}
+		}
+	}
+	return limits
+}
+
+func validateCgroup(cgroup string) error {
+	if cgroup == "" {
+		return nil
+	}
+	if !isProcessLimitsSupported() {
+		return errors.New("cgroups are not supported on this platform")
+	}
+	if !filepath.IsAbs(cgroup) {
+		return fmt.Errorf("invalid cgroup %q, it must be an absolute path", cgroup)
+	}
+	if _, err := os.Stat(filepath.Join(cgroup, "cgroup.procs")); err != nil {
+		return fmt.Errorf("invalid cgroup %q: %w", cgroup, err)
+	}
+	return nil
+}
+
+// limitedWriter kills the command if the written data exceed the limit
+type limitedWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	cmd      *exec.Cmd
+	limit    int
+	written  int
+	exceeded bool
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.exceeded {
+		return 0, ErrOutputLimitExceeded
+	}
+	if w.written+len(p) > w.limit {
+		w.exceeded = true
+		if w.cmd.Process != nil {
+			w.cmd.Process.Kill() //nolint:errcheck
+		}
+		return 0, ErrOutputLimitExceeded
+	}
+	n, err := w.w.Write(p)
+	w.written += n
+	return n, err
+}
+
+func (w *limitedWriter) isExceeded() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.exceeded
+}
+
+func start(cmd *exec.Cmd, hook string) (*limitedWriter, *tracing.Span, func(), error) {
+	limits := GetLimits(cmd.Args[0], hook)
+	if cmd.Dir == "" {
+		cmd.Dir = limits.WorkingDir
+	}
+	cmd.Env = append(limits.getAllowedEnv(), cmd.Env...)
+	_, span := tracing.Start(context.Background(), fmt.Sprintf("hook %s", hook), tracing.SpanKindInternal)
+	span.SetAttribute("sftpgo.hook", hook)
+	span.SetAttribute("process.executable.path", cmd.Args[0])
+	if traceParent := span.TraceParent(); traceParent != "" {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", tracing.TraceParentEnvVar, traceParent))
+	}
+	var writer *limitedWriter
+	if _, isFile := cmd.Stdout.(*os.File); limits.MaxOutputSize > 0 && cmd.Stdout != nil && !isFile {
+		writer = &limitedWriter{
+			w:     cmd.Stdout,
+			cmd:   cmd,
+			limit: limits.MaxOutputSize * 1024,
+		}
+		cmd.Stdout = writer
+	}
+	if err := cmd.Start(); err != nil {
+		span.SetError(err)
+		span.End()
+		return nil, nil, nil, err
+	}
+	cleanup, err := applyProcessLimits(cmd.Process.Pid, limits, config.Cgroup)
+	if err != nil {
+		cmd.Process.Kill() //nolint:errcheck
+		cmd.Wait()         //nolint:errcheck
+		err = fmt.Errorf("unable to apply the execution limits: %w", err)
+		span.SetError(err)
+		span.End()
+		return nil, nil, nil, err
+	}
+	return writer, span, cleanup, nil
+}
+
+// Start starts the specified command applying the execution limits configured
+// for it. The command must be created using its path as defined in the hook
+// configuration. The returned function must be called after waiting for the
+// command to complete
+func Start(cmd *exec.Cmd, hook string) (func(), error) {
+	_, span, cleanup, err := start(cmd, hook)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		cleanup()
+		span.End()
+	}, nil
+}
+
+// Run starts the specified command, applying the configured execution limits,
+// and waits for it to complete
+func Run(cmd *exec.Cmd, hook string) error {
+	writer, span, cleanup, err := start(cmd, hook)
+	if err != nil {
+		return err
+	}
+	defer span.End()
+	defer cleanup()
+
+	err = cmd.Wait()
+	if writer != nil && writer.isExceeded() {
+		err = ErrOutputLimitExceeded
+	}
+	span.SetError(err)
+	return err
+}
+
+// Output is like Run but returns the command standard output
+func Output(cmd *exec.Cmd, hook string) ([]byte, error) {
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := Run(cmd, hook)
+	return stdout.Bytes(), err
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build linux
+// +build linux
+
+package command
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const logSender = "command"
+
+func isProcessLimitsSupported() bool {
+	return true
+}
+
+// applyProcessLimits applies the limits to the started process with the specified pid.
+// The limits are applied just after the process starts, processes forked before
+// are not limited
+func applyProcessLimits(pid int, limits Limits, cgroup string) (func(), error) {
+	if limits.CPUTime > 0 {
+		// SIGXCPU is sent at the soft limit and SIGKILL at the hard limit
+		rlimit := unix.Rlimit{
+			Cur: uint64(limits.CPUTime),
+			Max: uint64(limits.CPUTime) + 1,
+		}
+		if err := unix.Prlimit(pid, unix.RLIMIT_CPU, &rlimit, nil); err != nil {
+			return nil, fmt.Errorf("unable to set the CPU time limit: %w", err)
+		}
+	}
+	if cgroup != "" {
+		return addToCgroup(pid, limits, cgroup)
+	}
+	if limits.MaxMemory > 0 {
+		maxMemory := uint64(limits.MaxMemory) * 1048576
+		rlimit := unix.Rlimit{
+			Cur: maxMemory,
+			Max: maxMemory,
+		}
+		if err := unix.Prlimit(pid, unix.RLIMIT_AS, &rlimit, nil); err != nil {
+			return nil, fmt.Errorf("unable to set the memory limit: %w", err)
+		}
+	}
+	return func() {}, nil
+}
+
+func addToCgroup(pid int, limits Limits, cgroup string) (func(), error) {
+	dir := filepath.Join(cgroup, fmt.Sprintf("sftpgo-cmd-%d", pid))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create cgroup %q: %w", dir, err)
+	}
+	cleanup := func() {
+		removeCgroup(dir)
+	}
+	if limits.MaxMemory > 0 {
+		maxMemory := strconv.FormatInt(int64(limits.MaxMemory)*1048576, 10)
+		if err := os.WriteFile(filepath.Join(dir, "memory.max"), []byte(maxMemory), 0644); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("unable to set the cgroup memory limit: %w", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("unable to add process %d to cgroup %q: %w", pid, dir, err)
+	}
+	return cleanup, nil
+}
+
+// removeCgroup kills the processes left in the cgroup, if any, and removes it
+func removeCgroup(dir string) {
+	// cgroup.kill is available since Linux 5.14
+	os.WriteFile(filepath.Join(dir, "cgroup.kill"), []byte("1"), 0644) //nolint:errcheck
+	var err error
+	for i := 0; i < 20; i++ {
+		if err = os.Remove(dir); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	logger.Warn(logSender, "", "unable to remove cgroup %q: %v", dir, err)
+}
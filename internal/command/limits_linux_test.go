@@ -0,0 +1,63 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build linux
+// +build linux
+
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCgroup(t *testing.T) {
+	// a regular directory is used to simulate a cgroup
+	cgroup := filepath.Join(os.TempDir(), "sftpgo_test_cgroup")
+	err := os.MkdirAll(cgroup, os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(cgroup, "cgroup.procs"), nil, 0644)
+	require.NoError(t, err)
+	err = validateCgroup(cgroup)
+	assert.NoError(t, err)
+
+	pid := os.Getpid()
+	cleanup, err := addToCgroup(pid, Limits{MaxMemory: 10}, cgroup)
+	require.NoError(t, err)
+	dir := filepath.Join(cgroup, "sftpgo-cmd-"+strconv.Itoa(pid))
+	content, err := os.ReadFile(filepath.Join(dir, "memory.max"))
+	assert.NoError(t, err)
+	assert.Equal(t, "10485760", string(content))
+	content, err = os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(pid), string(content))
+	// the directory is not empty and so it cannot be removed
+	cleanup()
+	assert.DirExists(t, dir)
+	_, err = addToCgroup(pid, Limits{}, cgroup)
+	assert.Error(t, err)
+	err = os.RemoveAll(dir)
+	assert.NoError(t, err)
+
+	_, err = applyProcessLimits(pid, Limits{}, filepath.Join(os.TempDir(), "missing_cgroup"))
+	assert.ErrorContains(t, err, "unable to create cgroup")
+
+	err = os.RemoveAll(cgroup)
+	assert.NoError(t, err)
+}
@@ -33,10 +33,12 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/command"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/eventstream"
 	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 var (
@@ -56,7 +58,7 @@ func hookEnded() {
 
 // ProtocolActions defines the action to execute on file operations and SSH commands
 type ProtocolActions struct {
-	// Valid values are download, upload, pre-delete, delete, rename, ssh_cmd. Empty slice to disable
+	// Valid values are download, upload, pre-delete, delete, rename, ssh_cmd, port_forward. Empty slice to disable
 	ExecuteOn []string `json:"execute_on" mapstructure:"execute_on"`
 	// Actions to be performed synchronously.
 	// The pre-delete action is always executed synchronously while the other ones are asynchronous.
@@ -91,7 +93,8 @@ func ExecutePreAction(conn *BaseConnection, operation, filePath, virtualPath str
 	var event *notifier.FsEvent
 	hasNotifiersPlugin := plugin.Handler.HasNotifiers()
 	hasHook := util.Contains(Config.Actions.ExecuteOn, operation)
-	if !hasHook && !hasNotifiersPlugin {
+	hasSubscribers := eventstream.HasSubscribers()
+	if !hasHook && !hasNotifiersPlugin && !hasSubscribers {
 		return handleUnconfiguredPreAction(operation)
 	}
 	event = newActionNotification(&conn.User, operation, filePath, virtualPath, "", "", "",
@@ -99,6 +102,9 @@ func ExecutePreAction(conn *BaseConnection, operation, filePath, virtualPath str
 	if hasNotifiersPlugin {
 		plugin.Handler.NotifyFsEvent(event)
 	}
+	if hasSubscribers {
+		eventstream.PublishFsEvent(event)
+	}
 	if !hasHook {
 		return handleUnconfiguredPreAction(operation)
 	}
@@ -112,7 +118,8 @@ func ExecuteActionNotification(conn *BaseConnection, operation, filePath, virtua
 	hasNotifiersPlugin := plugin.Handler.HasNotifiers()
 	hasHook := util.Contains(Config.Actions.ExecuteOn, operation)
 	hasRules := eventManager.hasFsRules()
-	if !hasHook && !hasNotifiersPlugin && !hasRules {
+	hasSubscribers := eventstream.HasSubscribers()
+	if !hasHook && !hasNotifiersPlugin && !hasRules && !hasSubscribers {
 		return nil
 	}
 	notification := newActionNotification(&conn.User, operation, filePath, virtualPath, target, virtualTarget, sshCmd,
@@ -120,6 +127,9 @@ func ExecuteActionNotification(conn *BaseConnection, operation, filePath, virtua
 	if hasNotifiersPlugin {
 		plugin.Handler.NotifyFsEvent(notification)
 	}
+	if hasSubscribers {
+		eventstream.PublishFsEvent(notification)
+	}
 	var errRes error
 	if hasRules {
 		params := EventParams{
@@ -137,6 +147,8 @@ func ExecuteActionNotification(conn *BaseConnection, operation, filePath, virtua
 			IP:                notification.IP,
 			Timestamp:         notification.Timestamp,
 			Object:            nil,
+			Metadata:          conn.User.Metadata,
+			Impersonator:      conn.impersonator,
 		}
 		if err != nil {
 			params.AddError(fmt.Errorf("%q failed: %w", params.Event, err))
@@ -191,6 +203,11 @@ func newActionNotification(
 		endpoint = fsConfig.SFTPConfig.Endpoint
 	case sdk.HTTPFilesystemProvider:
 		endpoint = fsConfig.HTTPConfig.Endpoint
+	case vfs.SMBFilesystemProvider:
+		bucket = fsConfig.SMBConfig.Share
+		endpoint = fsConfig.SMBConfig.Endpoint
+	case vfs.WebDAVFilesystemProvider:
+		endpoint = fsConfig.WebDAVConfig.Endpoint
 	}
 
 	return &notifier.FsEvent{
@@ -247,8 +264,9 @@ func (h *defaultActionHandler) handleHTTP(event *notifier.FsEvent) error {
 
 	var b bytes.Buffer
 	_ = json.NewEncoder(&b).Encode(event)
+	payload := b.Bytes()
 
-	resp, err := httpclient.RetryablePost(Config.Actions.Hook, "application/json", &b)
+	resp, err := httpclient.RetryablePost(Config.Actions.Hook, "application/json", bytes.NewReader(payload))
 	if err == nil {
 		respCode = resp.StatusCode
 		resp.Body.Close()
@@ -261,6 +279,10 @@ func (h *defaultActionHandler) handleHTTP(event *notifier.FsEvent) error {
 	logger.Debug(event.Protocol, "", "notified operation %q to URL: %s status code: %d, elapsed: %s err: %v",
 		event.Action, u.Redacted(), respCode, time.Since(startTime), err)
 
+	if err != nil {
+		dataprovider.AddHookDeadLetter(dataprovider.DeadLetterSourceFsHook, event.Action, Config.Actions.Hook, payload, respCode, err)
+	}
+
 	return err
 }
 
@@ -280,7 +302,7 @@ func (h *defaultActionHandler) handleCommand(event *notifier.FsEvent) error {
 	cmd.Env = append(env, notificationAsEnvVars(event)...)
 
 	startTime := time.Now()
-	err := cmd.Run()
+	err := command.Run(cmd, command.HookFsActions)
 
 	logger.Debug(event.Protocol, "", "executed command %#v, elapsed: %v, error: %v",
 		Config.Actions.Hook, time.Since(startTime), err)
@@ -188,7 +188,7 @@ func TestWrongActions(t *testing.T) {
 		badCommand = "C:\\bad\\command"
 	}
 	Config.Actions = ProtocolActions{
-		ExecuteOn: []string{operationUpload},
+		ExecuteOn: []string{OperationUpload},
 		Hook:      badCommand,
 	}
 	user := &dataprovider.User{
@@ -197,17 +197,17 @@ func TestWrongActions(t *testing.T) {
 		},
 	}
 
-	a := newActionNotification(user, operationUpload, "", "", "", "", "", ProtocolSFTP, "", xid.New().String(),
+	a := newActionNotification(user, OperationUpload, "", "", "", "", "", ProtocolSFTP, "", xid.New().String(),
 		123, 0, nil)
 	err := actionHandler.Handle(a)
 	assert.Error(t, err, "action with bad command must fail")
 
-	a.Action = operationDelete
+	a.Action = OperationDelete
 	err = actionHandler.Handle(a)
 	assert.EqualError(t, err, errUnconfiguredAction.Error())
 
 	Config.Actions.Hook = "http://foo\x7f.com/"
-	a.Action = operationUpload
+	a.Action = OperationUpload
 	err = actionHandler.Handle(a)
 	assert.Error(t, err, "action with bad url must fail")
 
@@ -0,0 +1,301 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/smtp"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	archiveRestoreCheckInterval = 10 * time.Minute
+	maxPendingRestoresPerUser   = 100
+	maxPendingRestoreAge        = 7 * 24 * time.Hour
+	defaultArchiveRestoreDays   = 1
+	maxArchiveRestoreDays       = 365
+)
+
+var (
+	// ArchiveRestores tracks the pending restore requests for archived objects
+	ArchiveRestores ActiveArchiveRestores
+	// ErrTooManyPendingRestores is returned if a user has too many pending restore requests
+	ErrTooManyPendingRestores = errors.New("too many pending restore requests")
+)
+
+// PendingArchiveRestore defines a restore request not yet completed
+type PendingArchiveRestore struct {
+	Username    string `json:"username"`
+	VirtualPath string `json:"virtual_path"`
+	// restore request time as unix timestamp in milliseconds
+	RequestedAt int64 `json:"requested_at"`
+}
+
+// ActiveArchiveRestores holds the pending restore requests, the users are
+// notified, by email, when the restored objects become downloadable
+type ActiveArchiveRestores struct {
+	sync.RWMutex
+	pending map[string]map[string]PendingArchiveRestore
+}
+
+// Get returns the pending restore requests for the specified user
+func (r *ActiveArchiveRestores) Get(username string) []PendingArchiveRestore {
+	r.RLock()
+	defer r.RUnlock()
+
+	restores := make([]PendingArchiveRestore, 0, len(r.pending[username]))
+	for _, restore := range r.pending[username] {
+		restores = append(restores, restore)
+	}
+	return restores
+}
+
+func (r *ActiveArchiveRestores) add(username, virtualPath string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.pending == nil {
+		r.pending = make(map[string]map[string]PendingArchiveRestore)
+	}
+	userRestores, ok := r.pending[username]
+	if !ok {
+		userRestores = make(map[string]PendingArchiveRestore)
+		r.pending[username] = userRestores
+	}
+	if _, ok := userRestores[virtualPath]; ok {
+		return nil
+	}
+	if len(userRestores) >= maxPendingRestoresPerUser {
+		return ErrTooManyPendingRestores
+	}
+	userRestores[virtualPath] = PendingArchiveRestore{
+		Username:    username,
+		VirtualPath: virtualPath,
+		RequestedAt: util.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+	return nil
+}
+
+func (r *ActiveArchiveRestores) remove(username, virtualPath string) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.pending[username], virtualPath)
+	if len(r.pending[username]) == 0 {
+		delete(r.pending, username)
+	}
+}
+
+func (r *ActiveArchiveRestores) getUsernames() []string {
+	r.RLock()
+	defer r.RUnlock()
+
+	usernames := make([]string, 0, len(r.pending))
+	for username := range r.pending {
+		usernames = append(usernames, username)
+	}
+	return usernames
+}
+
+func (r *ActiveArchiveRestores) check() {
+	for _, username := range r.getUsernames() {
+		restores := r.Get(username)
+		if len(restores) == 0 {
+			continue
+		}
+		user, err := dataprovider.GetUserWithGroupSettings(username)
+		if err != nil {
+			if _, ok := err.(*util.RecordNotFoundError); ok {
+				for _, restore := range restores {
+					r.remove(username, restore.VirtualPath)
+				}
+			}
+			logger.Warn(logSender, "", "unable to check pending restores for user %q: %v", username, err)
+			continue
+		}
+		r.checkUserRestores(&user, restores)
+	}
+}
+
+func (r *ActiveArchiveRestores) checkUserRestores(user *dataprovider.User, restores []PendingArchiveRestore) {
+	connectionID := fmt.Sprintf("%s_%s", protocolArchiveRestore, util.GenerateUniqueID())
+	defer user.CloseFs() //nolint:errcheck
+
+	for _, restore := range restores {
+		status, removeRestore, err := getArchiveStatus(user, connectionID, restore.VirtualPath)
+		if err != nil {
+			logger.Warn(logSender, connectionID, "unable to get archive status for user %q, path %q: %v",
+				user.Username, restore.VirtualPath, err)
+			if removeRestore {
+				r.remove(user.Username, restore.VirtualPath)
+			}
+			continue
+		}
+		if status.Available || !status.Archived {
+			r.remove(user.Username, restore.VirtualPath)
+			logger.Info(logSender, connectionID, "archived object %q restored for user %q",
+				restore.VirtualPath, user.Username)
+			notifyArchiveRestored(user, restore.VirtualPath, status)
+			continue
+		}
+		if time.Since(util.GetTimeFromMsecSinceEpoch(restore.RequestedAt)) > maxPendingRestoreAge {
+			logger.Warn(logSender, connectionID, "restore request for user %q, path %q expired, status: %+v",
+				user.Username, restore.VirtualPath, status)
+			r.remove(user.Username, restore.VirtualPath)
+		}
+	}
+}
+
+// getArchiveStatus returns the archive status for the specified path and true
+// if the error is permanent and so the pending restore should be removed
+func getArchiveStatus(user *dataprovider.User, connectionID, virtualPath string) (vfs.ArchiveStatus, bool, error) {
+	fs, err := user.GetFilesystemForPath(virtualPath, connectionID)
+	if err != nil {
+		return vfs.ArchiveStatus{}, false, err
+	}
+	restorer, ok := fs.(vfs.FsArchiveRestorer)
+	if !ok {
+		return vfs.ArchiveStatus{}, true, ErrOpUnsupported
+	}
+	fsPath, err := fs.ResolvePath(virtualPath)
+	if err != nil {
+		return vfs.ArchiveStatus{}, false, err
+	}
+	status, err := restorer.GetArchiveStatus(fsPath)
+	return status, fs.IsNotExist(err), err
+}
+
+func notifyArchiveRestored(user *dataprovider.User, virtualPath string, status vfs.ArchiveStatus) {
+	if user.Email == "" || !smtp.IsEnabled() {
+		return
+	}
+	data := map[string]string{
+		"Username":    user.Username,
+		"VirtualPath": virtualPath,
+		"ExpiresAt":   "",
+	}
+	if status.RestoreExpiresAt > 0 {
+		data["ExpiresAt"] = util.GetTimeFromMsecSinceEpoch(status.RestoreExpiresAt).UTC().Format(time.RFC1123)
+	}
+	body, err := smtp.RenderArchiveRestoredBody(user.Filters.Language, data)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to render archive restored template: %v", err)
+		return
+	}
+	subject := fmt.Sprintf("The file %q is now available for download", virtualPath)
+	if err := smtp.SendEmailBody(smtp.EmailAddresses{To: []string{user.Email}}, subject, body); err != nil {
+		logger.Warn(logSender, "", "unable to notify restored object %q for user %q: %v",
+			virtualPath, user.Username, err)
+	}
+}
+
+func (c *BaseConnection) getArchiveRestorer(virtualPath string) (vfs.FsArchiveRestorer, string, error) {
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualPath)) {
+		return nil, "", c.GetPermissionDeniedError()
+	}
+	if ok, policy := c.User.IsFileAllowed(virtualPath); !ok {
+		c.Log(logger.LevelWarn, "archive restore for file %q is not allowed", virtualPath)
+		return nil, "", c.GetErrorForDeniedFile(policy)
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil, "", err
+	}
+	restorer, ok := fs.(vfs.FsArchiveRestorer)
+	if !ok {
+		return nil, "", c.GetOpUnsupportedError()
+	}
+	return restorer, fsPath, nil
+}
+
+// GetArchiveStatus returns the archive status for the specified virtual path
+func (c *BaseConnection) GetArchiveStatus(virtualPath string) (vfs.ArchiveStatus, error) {
+	restorer, fsPath, err := c.getArchiveRestorer(virtualPath)
+	if err != nil {
+		return vfs.ArchiveStatus{}, err
+	}
+	status, err := restorer.GetArchiveStatus(fsPath)
+	if err != nil {
+		c.Log(logger.LevelDebug, "unable to get archive status for path %q: %+v", virtualPath, err)
+		return status, c.GetFsError(restorer, err)
+	}
+	return status, nil
+}
+
+// RestoreArchivedObject requests the restore of the archived object at the specified
+// virtual path. The restored copy will be available for the specified number of days,
+// if supported by the storage backend. The user will be notified by email when
+// the object becomes downloadable
+func (c *BaseConnection) RestoreArchivedObject(virtualPath string, days int) (vfs.ArchiveStatus, error) {
+	if days <= 0 {
+		days = defaultArchiveRestoreDays
+	}
+	if days > maxArchiveRestoreDays {
+		return vfs.ArchiveStatus{}, util.NewValidationError(fmt.Sprintf("the restore period cannot exceed %d days",
+			maxArchiveRestoreDays))
+	}
+	restorer, fsPath, err := c.getArchiveRestorer(virtualPath)
+	if err != nil {
+		return vfs.ArchiveStatus{}, err
+	}
+	status, err := restorer.GetArchiveStatus(fsPath)
+	if err != nil {
+		c.Log(logger.LevelDebug, "unable to get archive status for path %q: %+v", virtualPath, err)
+		return status, c.GetFsError(restorer, err)
+	}
+	if !status.Archived || status.Available {
+		return status, nil
+	}
+	if err := ArchiveRestores.add(c.User.Username, virtualPath); err != nil {
+		return status, err
+	}
+	if !status.Restoring {
+		if err := restorer.RestoreArchivedObject(fsPath, days); err != nil {
+			c.Log(logger.LevelError, "unable to restore archived object %q: %+v", virtualPath, err)
+			ArchiveRestores.remove(c.User.Username, virtualPath)
+			return status, c.GetFsError(restorer, err)
+		}
+		status.Restoring = true
+	}
+	logger.CommandLog(restoreLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "",
+		"", "", -1, c.localAddr, c.remoteAddr, c.impersonator)
+	return status, nil
+}
+
+// CheckArchivedObject returns an appropriate error if the object at the specified
+// virtual path is archived and not yet downloadable. Storage backends without archive
+// tiers are ignored
+func (c *BaseConnection) CheckArchivedObject(fs vfs.Fs, fsPath string) error {
+	restorer, ok := fs.(vfs.FsArchiveRestorer)
+	if !ok {
+		return nil
+	}
+	status, err := restorer.GetArchiveStatus(fsPath)
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	if status.Archived && !status.Available {
+		return c.GetArchivedObjectError()
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package common
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// maxTrackedClientVersions defines the maximum number of protocol/client version
+// pairs tracked, when the limit is reached the least recently seen pair is evicted
+const maxTrackedClientVersions = 1000
+
+var clientVersions = newClientVersionsTracker(maxTrackedClientVersions)
+
+// ClientVersionStats defines the statistics for a client identification string
+// observed for a protocol
+type ClientVersionStats struct {
+	Protocol string `json:"protocol"`
+	// Client identification string: the SSH client version, the FTP CLNT value
+	// or the HTTP User-Agent. Empty if the client does not identify itself
+	Version string `json:"version"`
+	// Number of connections for SSH and FTP, number of requests for WebDAV and HTTP
+	Count int64 `json:"count"`
+	// Number of connections, requests or login attempts denied by a client policy
+	Denied int64 `json:"denied"`
+	// First and last time, as unix timestamp in milliseconds, the version was observed
+	FirstSeen int64 `json:"first_seen"`
+	LastSeen  int64 `json:"last_seen"`
+}
+
+type clientVersionKey struct {
+	protocol string
+	version  string
+}
+
+type clientVersionsTracker struct {
+	maxEntries int
+	mu         sync.Mutex
+	versions   map[clientVersionKey]*ClientVersionStats
+}
+
+func newClientVersionsTracker(maxEntries int) *clientVersionsTracker {
+	return &clientVersionsTracker{
+		maxEntries: maxEntries,
+		versions:   make(map[clientVersionKey]*ClientVersionStats),
+	}
+}
+
+func (t *clientVersionsTracker) add(protocol, version string, denied bool) {
+	key := clientVersionKey{
+		protocol: protocol,
+		version:  dataprovider.TruncateClientVersion(version),
+	}
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats, ok := t.versions[key]
+	if !ok {
+		if len(t.versions) >= t.maxEntries {
+			t.evictOldest()
+		}
+		stats = &ClientVersionStats{
+			Protocol:  key.protocol,
+			Version:   key.version,
+			FirstSeen: now,
+		}
+		t.versions[key] = stats
+	}
+	if denied {
+		stats.Denied++
+	} else {
+		stats.Count++
+	}
+	stats.LastSeen = now
+}
+
+func (t *clientVersionsTracker) evictOldest() {
+	var oldestKey clientVersionKey
+	var oldest int64
+
+	for k, v := range t.versions {
+		if oldest == 0 || v.LastSeen < oldest {
+			oldest = v.LastSeen
+			oldestKey = k
+		}
+	}
+	delete(t.versions, oldestKey)
+}
+
+func (t *clientVersionsTracker) get() []ClientVersionStats {
+	t.mu.Lock()
+	result := make([]ClientVersionStats, 0, len(t.versions))
+	for _, v := range t.versions {
+		result = append(result, *v)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].LastSeen == result[j].LastSeen {
+			if result[i].Protocol == result[j].Protocol {
+				return result[i].Version < result[j].Version
+			}
+			return result[i].Protocol < result[j].Protocol
+		}
+		return result[i].LastSeen > result[j].LastSeen
+	})
+	return result
+}
+
+// AddClientVersion records a connection or a request from a client
+// with the specified identification string
+func AddClientVersion(protocol, version string) {
+	clientVersions.add(protocol, version, false)
+}
+
+// AddDeniedClientVersion records a connection, request or login attempt
+// rejected by a client policy
+func AddDeniedClientVersion(protocol, version string) {
+	clientVersions.add(protocol, version, true)
+}
+
+// GetClientVersions returns the client identification strings observed
+// by this node, the most recently seen first
+func GetClientVersions() []ClientVersionStats {
+	return clientVersions.get()
+}
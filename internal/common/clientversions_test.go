@@ -0,0 +1,54 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package common
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+)
+
+func TestClientVersionsTracker(t *testing.T) {
+	tracker := newClientVersionsTracker(2)
+	tracker.add(ProtocolSSH, "SSH-2.0-OpenSSH_9.0", false)
+	tracker.add(ProtocolSSH, "SSH-2.0-OpenSSH_9.0", false)
+	tracker.add(ProtocolSSH, "SSH-2.0-OpenSSH_9.0", true)
+	time.Sleep(5 * time.Millisecond)
+	tracker.add(ProtocolFTP, "", false)
+
+	versions := tracker.get()
+	require.Len(t, versions, 2)
+	assert.Equal(t, ProtocolFTP, versions[0].Protocol)
+	assert.Empty(t, versions[0].Version)
+	assert.Equal(t, int64(1), versions[0].Count)
+	assert.Equal(t, ProtocolSSH, versions[1].Protocol)
+	assert.Equal(t, int64(2), versions[1].Count)
+	assert.Equal(t, int64(1), versions[1].Denied)
+	assert.LessOrEqual(t, versions[1].FirstSeen, versions[1].LastSeen)
+	// the least recently seen version must be evicted
+	time.Sleep(5 * time.Millisecond)
+	tracker.add(ProtocolHTTP, strings.Repeat("a", dataprovider.MaxClientVersionLength+10), true)
+	versions = tracker.get()
+	require.Len(t, versions, 2)
+	assert.Equal(t, ProtocolHTTP, versions[0].Protocol)
+	assert.Len(t, versions[0].Version, dataprovider.MaxClientVersionLength)
+	assert.Equal(t, int64(0), versions[0].Count)
+	assert.Equal(t, int64(1), versions[0].Denied)
+	assert.Equal(t, ProtocolFTP, versions[1].Protocol)
+}
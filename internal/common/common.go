@@ -36,6 +36,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/command"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/jobs"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
@@ -57,21 +58,28 @@ const (
 	chmodLogSender         = "Chmod"
 	chtimesLogSender       = "Chtimes"
 	truncateLogSender      = "Truncate"
+	restoreLogSender       = "Restore"
+	versionLogSender       = "RestoreVersion"
+	metadataLogSender      = "SetMetadata"
 	operationDownload      = "download"
-	operationUpload        = "upload"
 	operationFirstDownload = "first-download"
 	operationFirstUpload   = "first-upload"
-	operationDelete        = "delete"
 	// Pre-download action name
 	OperationPreDownload = "pre-download"
 	// Pre-upload action name
 	OperationPreUpload = "pre-upload"
+	// Upload action name
+	OperationUpload = "upload"
+	// Delete action name
+	OperationDelete    = "delete"
 	operationPreDelete = "pre-delete"
 	operationRename    = "rename"
 	operationMkdir     = "mkdir"
 	operationRmdir     = "rmdir"
 	// SSH command action name
-	OperationSSHCmd              = "ssh_cmd"
+	OperationSSHCmd = "ssh_cmd"
+	// SSH port forwarding action name
+	OperationPortForward         = "port_forward"
 	chtimesFormat                = "2006-01-02T15:04:05" // YYYY-MM-DDTHH:MM:SS
 	idleTimeoutCheckInterval     = 3 * time.Minute
 	periodicTimeoutCheckInterval = 1 * time.Minute
@@ -83,6 +91,9 @@ const (
 	StatAttrPerms  = 2
 	StatAttrTimes  = 4
 	StatAttrSize   = 8
+	// StatAttrMetadata is used to update the custom metadata, stored as
+	// extended attributes or object metadata depending on the backend
+	StatAttrMetadata = 16
 )
 
 // Transfer types
@@ -93,16 +104,19 @@ const (
 
 // Supported protocols
 const (
-	ProtocolSFTP          = "SFTP"
-	ProtocolSCP           = "SCP"
-	ProtocolSSH           = "SSH"
-	ProtocolFTP           = "FTP"
-	ProtocolWebDAV        = "DAV"
-	ProtocolHTTP          = "HTTP"
-	ProtocolHTTPShare     = "HTTPShare"
-	ProtocolDataRetention = "DataRetention"
-	ProtocolOIDC          = "OIDC"
-	protocolEventAction   = "EventAction"
+	ProtocolSFTP           = "SFTP"
+	ProtocolSCP            = "SCP"
+	ProtocolSSH            = "SSH"
+	ProtocolFTP            = "FTP"
+	ProtocolWebDAV         = "DAV"
+	ProtocolHTTP           = "HTTP"
+	ProtocolHTTPShare      = "HTTPShare"
+	ProtocolDataRetention  = "DataRetention"
+	ProtocolOIDC           = "OIDC"
+	ProtocolSAML           = "SAML"
+	ProtocolS3             = "S3"
+	protocolEventAction    = "EventAction"
+	protocolArchiveRestore = "ArchiveRestore"
 )
 
 // Upload modes
@@ -132,9 +146,11 @@ var (
 	ErrConnectionDenied  = errors.New("you are not allowed to connect")
 	ErrNoBinding         = errors.New("no binding configured")
 	ErrCrtRevoked        = errors.New("your certificate has been revoked")
+	ErrClientNotAllowed  = errors.New("your client software is not allowed")
 	ErrNoCredentials     = errors.New("no credential provided")
 	ErrInternalFailure   = errors.New("internal failure")
 	ErrTransferAborted   = errors.New("transfer aborted")
+	ErrInfectedFile      = errors.New("the uploaded file is infected")
 	errNoTransfer        = errors.New("requested transfer not found")
 	errTransferMismatch  = errors.New("transfer mismatch")
 )
@@ -150,7 +166,7 @@ var (
 	ActiveMetadataChecks MetadataChecks
 	transfersChecker     TransfersChecker
 	supportedProtocols   = []string{ProtocolSFTP, ProtocolSCP, ProtocolSSH, ProtocolFTP, ProtocolWebDAV,
-		ProtocolHTTP, ProtocolHTTPShare, ProtocolOIDC}
+		ProtocolHTTP, ProtocolHTTPShare, ProtocolOIDC, ProtocolS3}
 	disconnHookProtocols = []string{ProtocolSFTP, ProtocolSCP, ProtocolSSH, ProtocolFTP}
 	// the map key is the protocol, for each protocol we can have multiple rate limiters
 	rateLimiters map[string][]*rateLimiter
@@ -166,6 +182,7 @@ func Initialize(c Configuration, isShared int) error {
 	Config.idleTimeoutAsDuration = time.Duration(Config.IdleTimeout) * time.Minute
 	startPeriodicChecks(periodicTimeoutCheckInterval)
 	Config.defender = nil
+	defenderFeeds = nil
 	Config.whitelist = nil
 	rateLimiters = make(map[string][]*rateLimiter)
 	for _, rlCfg := range c.RateLimitersConfig {
@@ -184,6 +201,12 @@ func Initialize(c Configuration, isShared int) error {
 			}
 		}
 	}
+	if err := c.LatencyThrottling.validate(); err != nil {
+		return fmt.Errorf("latency throttling initialization error: %w", err)
+	}
+	if err := startLatencyThrottlingCheck(c.LatencyThrottling); err != nil {
+		return fmt.Errorf("latency throttling initialization error: %w", err)
+	}
 	if c.DefenderConfig.Enabled {
 		if !util.Contains(supportedDefenderDrivers, c.DefenderConfig.Driver) {
 			return fmt.Errorf("unsupported defender driver %#v", c.DefenderConfig.Driver)
@@ -201,7 +224,14 @@ func Initialize(c Configuration, isShared int) error {
 		}
 		logger.Info(logSender, "", "defender initialized with config %+v", c.DefenderConfig)
 		Config.defender = defender
+		if err := startDefenderFeeds(c.DefenderConfig.Feeds); err != nil {
+			return fmt.Errorf("defender feeds initialization error: %w", err)
+		}
 	}
+	if err := c.GeoIP.load(); err != nil {
+		return fmt.Errorf("GeoIP initialization error: %w", err)
+	}
+	dataprovider.SetCountryResolver(GetCountryForIP)
 	if c.WhiteListFile != "" {
 		whitelist := &whitelist{
 			fileName: c.WhiteListFile,
@@ -214,6 +244,27 @@ func Initialize(c Configuration, isShared int) error {
 	}
 	vfs.SetTempPath(c.TempPath)
 	dataprovider.SetTempPath(c.TempPath)
+	if err := c.TempFilesCleanup.validate(); err != nil {
+		return fmt.Errorf("temporary files cleanup initialization error: %w", err)
+	}
+	if err := startTempFilesCleanup(c.TempFilesCleanup); err != nil {
+		return fmt.Errorf("temporary files cleanup initialization error: %w", err)
+	}
+	if err := c.ResourceLimits.validate(); err != nil {
+		return fmt.Errorf("resource limits initialization error: %w", err)
+	}
+	if err := startResourceLimitsCheck(c.ResourceLimits); err != nil {
+		return fmt.Errorf("resource limits initialization error: %w", err)
+	}
+	if err := vfs.InitializeReadCache(c.ReadCache); err != nil {
+		return fmt.Errorf("read cache initialization error: %w", err)
+	}
+	if err := vfs.InitializeMultipartUploads(c.MultipartUploads); err != nil {
+		return fmt.Errorf("multipart uploads initialization error: %w", err)
+	}
+	if err := c.ICAP.initialize(); err != nil {
+		return fmt.Errorf("ICAP initialization error: %w", err)
+	}
 	vfs.SetAllowSelfConnections(c.AllowSelfConnections)
 	dataprovider.SetAllowSelfConnections(c.AllowSelfConnections)
 	transfersChecker = getTransfersChecker(isShared)
@@ -234,20 +285,25 @@ func LimitRate(protocol, ip string) (time.Duration, error) {
 	return 0, nil
 }
 
-// Reload reloads the whitelist, the IP filter plugin and the defender's block and safe lists
+// Reload reloads the whitelist, the GeoIP database, the IP filter plugin and
+// the defender's block and safe lists
 func Reload() error {
 	plugin.Handler.ReloadFilter()
 	var errWithelist error
 	if Config.whitelist != nil {
 		errWithelist = Config.whitelist.reload()
 	}
-	if Config.defender == nil {
-		return errWithelist
+	// on error the previously loaded database is preserved
+	errGeoIP := Config.GeoIP.load()
+	if Config.defender != nil {
+		if err := Config.defender.Reload(); err != nil {
+			return err
+		}
 	}
-	if err := Config.defender.Reload(); err != nil {
-		return err
+	if errWithelist != nil {
+		return errWithelist
 	}
-	return errWithelist
+	return errGeoIP
 }
 
 // IsBanned returns true if the specified IP address is banned
@@ -308,28 +364,65 @@ func GetDefenderScore(ip string) (int, error) {
 	return Config.defender.GetScore(ip)
 }
 
+// GetDefenderRolloutStatus returns the rollout status for the defender
+func GetDefenderRolloutStatus() dataprovider.RolloutStatus {
+	if Config.defender == nil {
+		return dataprovider.RolloutStatus{}
+	}
+	return Config.DefenderConfig.Rollout.GetStatus(defenderRolloutSeed, false)
+}
+
+// GetDefenderFeedsStatus returns the status of the external reputation feeds
+func GetDefenderFeedsStatus() ([]DefenderFeedStatus, error) {
+	if Config.defender == nil {
+		return nil, errors.New("defender is disabled")
+	}
+	if defenderFeeds == nil {
+		return make([]DefenderFeedStatus, 0), nil
+	}
+
+	return defenderFeeds.getStatus(), nil
+}
+
 // AddDefenderEvent adds the specified defender event for the given IP
 func AddDefenderEvent(ip string, event HostEvent) {
+	if event == HostEventLoginFailed || event == HostEventUserNotFound {
+		summaryReports.addFailedLogin(ip)
+	}
 	if Config.defender == nil {
 		return
 	}
 
+	logger.SyslogDefenderEvent(ip, getHostEventName(event), time.Time{})
 	Config.defender.AddEvent(ip, event)
 }
 
 func startPeriodicChecks(duration time.Duration) {
 	startEventScheduler()
 	spec := fmt.Sprintf("@every %s", duration)
-	_, err := eventScheduler.AddFunc(spec, Connections.checkTransfers)
+	err := jobs.Add(eventScheduler, "transfers_check", spec, func() error {
+		Connections.checkTransfers()
+		return nil
+	})
 	util.PanicOnError(err)
 	logger.Info(logSender, "", "scheduled overquota transfers check, schedule %q", spec)
 	if Config.IdleTimeout > 0 {
 		ratio := idleTimeoutCheckInterval / periodicTimeoutCheckInterval
 		spec = fmt.Sprintf("@every %s", duration*ratio)
-		_, err = eventScheduler.AddFunc(spec, Connections.checkIdles)
+		err = jobs.Add(eventScheduler, "idle_connections_check", spec, func() error {
+			Connections.checkIdles()
+			return nil
+		})
 		util.PanicOnError(err)
 		logger.Info(logSender, "", "scheduled idle connections check, schedule %q", spec)
 	}
+	spec = fmt.Sprintf("@every %s", archiveRestoreCheckInterval)
+	err = jobs.Add(eventScheduler, "archive_restores_check", spec, func() error {
+		ArchiveRestores.check()
+		return nil
+	})
+	util.PanicOnError(err)
+	logger.Info(logSender, "", "scheduled archive restores check, schedule %q", spec)
 }
 
 // ActiveTransfer defines the interface for the current active transfers
@@ -378,6 +471,8 @@ type StatAttributes struct {
 	GID   int
 	Flags int
 	Size  int64
+	// Metadata to set, keys with an empty value are removed
+	Metadata map[string]string
 }
 
 // ConnectionTransfer defines the trasfer details to expose
@@ -395,7 +490,7 @@ type ConnectionTransfer struct {
 func (t *ConnectionTransfer) getConnectionTransferAsString() string {
 	result := ""
 	switch t.OperationType {
-	case operationUpload:
+	case OperationUpload:
 		result += "UL "
 	case operationDownload:
 		result += "DL "
@@ -462,6 +557,12 @@ type Configuration struct {
 	// silently ignored for cloud based filesystem such as S3, GCS, Azure Blob. Requests  for changing
 	// modification times are ignored for cloud based filesystem if they are not supported.
 	SetstatMode int `json:"setstat_mode" mapstructure:"setstat_mode"`
+	// RenameMode 0 means renames between different storage backends are denied.
+	// 1 means files can be moved between different storage backends, for example between
+	// a local virtual folder and an S3 one: the file is copied to the target path reading
+	// and writing its content and then the source file is removed. Directories cannot be
+	// renamed between different storage backends.
+	RenameMode int `json:"rename_mode" mapstructure:"rename_mode"`
 	// TempPath defines the path for temporary files such as those used for atomic uploads or file pipes.
 	// If you set this option you must make sure that the defined path exists, is accessible for writing
 	// by the user running SFTPGo, and is on the same filesystem as the users home directories otherwise
@@ -513,8 +614,25 @@ type Configuration struct {
 	AllowSelfConnections int `json:"allow_self_connections" mapstructure:"allow_self_connections"`
 	// Defender configuration
 	DefenderConfig DefenderConfig `json:"defender" mapstructure:"defender"`
+	// GeoIP configuration, used for the country based access restrictions
+	GeoIP GeoIPConfig `json:"geoip" mapstructure:"geoip"`
 	// Rate limiter configurations
-	RateLimitersConfig    []RateLimiterConfig `json:"rate_limiters" mapstructure:"rate_limiters"`
+	RateLimitersConfig []RateLimiterConfig `json:"rate_limiters" mapstructure:"rate_limiters"`
+	// Adaptive throttling for new connections based on the backend latency
+	LatencyThrottling LatencyThrottlingConfig `json:"latency_throttling" mapstructure:"latency_throttling"`
+	// Periodic removal of the stale temporary files
+	TempFilesCleanup TempFilesCleanupConfig `json:"temp_files_cleanup" mapstructure:"temp_files_cleanup"`
+	// Soft limits for the process memory and goroutines, new connections are rejected
+	// while a limit is exceeded
+	ResourceLimits ResourceLimitsConfig `json:"resource_limits" mapstructure:"resource_limits"`
+	// Local disk cache for downloads from cloud storage backends
+	ReadCache vfs.ReadCacheConfig `json:"read_cache" mapstructure:"read_cache"`
+	// Server side limits and auto-tuning for the multipart uploads to cloud storage backends
+	MultipartUploads vfs.MultipartUploadsConfig `json:"multipart_uploads" mapstructure:"multipart_uploads"`
+	// Send downloads from the local filesystem using sendfile/splice
+	ZeroCopyDownloads ZeroCopyConfig `json:"zero_copy_downloads" mapstructure:"zero_copy_downloads"`
+	// Antivirus scanning of the uploaded files using an ICAP server
+	ICAP                  ICAPConfig `json:"icap" mapstructure:"icap"`
 	idleTimeoutAsDuration time.Duration
 	idleLoginTimeout      time.Duration
 	defender              Defender
@@ -593,7 +711,7 @@ func (c *Configuration) ExecuteStartupHook() error {
 
 	cmd := exec.CommandContext(ctx, c.StartupHook, args...)
 	cmd.Env = env
-	err := cmd.Run()
+	err := command.Run(cmd, command.HookStartup)
 	logger.Debug(logSender, "", "Startup hook executed, elapsed: %v, error: %v", time.Since(startTime), err)
 	return nil
 }
@@ -644,7 +762,7 @@ func (c *Configuration) executePostDisconnectHook(remoteAddr, protocol, username
 		fmt.Sprintf("SFTPGO_CONNECTION_USERNAME=%v", username),
 		fmt.Sprintf("SFTPGO_CONNECTION_DURATION=%v", connDuration),
 		fmt.Sprintf("SFTPGO_CONNECTION_PROTOCOL=%v", protocol))
-	err := cmd.Run()
+	err := command.Run(cmd, command.HookPostDisconnect)
 	logger.Debug(protocol, connID, "Post disconnect hook executed, elapsed: %v error: %v", time.Since(startTime), err)
 }
 
@@ -701,7 +819,7 @@ func (c *Configuration) ExecutePostConnectHook(ipAddr, protocol string) error {
 	cmd.Env = append(env,
 		fmt.Sprintf("SFTPGO_CONNECTION_IP=%v", ipAddr),
 		fmt.Sprintf("SFTPGO_CONNECTION_PROTOCOL=%v", protocol))
-	err := cmd.Run()
+	err := command.Run(cmd, command.HookPostConnect)
 	if err != nil {
 		logger.Warn(protocol, "", "Login from ip %#v denied, connect hook error: %v", ipAddr, err)
 	}
@@ -803,12 +921,15 @@ func (conns *ActiveConnections) Add(c ActiveConnection) error {
 			}
 		}
 		conns.addUserConnection(username)
+		metric.AddUserConnection(username, c.GetProtocol())
 	}
 	conns.mapping[c.GetID()] = len(conns.connections)
 	conns.connections = append(conns.connections, c)
 	metric.UpdateActiveConnectionsSize(len(conns.connections))
-	logger.Debug(c.GetProtocol(), c.GetID(), "connection added, local address %q, remote address %q, num open connections: %d",
-		c.GetLocalAddress(), c.GetRemoteAddress(), len(conns.connections))
+	logger.Debug(c.GetProtocol(), c.GetID(), "connection added, local address %q, remote address %q, country %q, num open connections: %d",
+		c.GetLocalAddress(), c.GetRemoteAddress(), GetCountryForIP(util.GetIPFromRemoteAddress(c.GetRemoteAddress())),
+		len(conns.connections))
+	logger.SyslogConnectionEvent(true, c.GetID(), c.GetUsername(), c.GetRemoteAddress(), c.GetProtocol())
 	return nil
 }
 
@@ -832,6 +953,8 @@ func (conns *ActiveConnections) Swap(c ActiveConnection) error {
 			}
 			conns.addUserConnection(username)
 		}
+		metric.RemoveUserConnection(conn.GetUsername(), conn.GetProtocol())
+		metric.AddUserConnection(c.GetUsername(), c.GetProtocol())
 		err := conn.CloseFS()
 		conns.connections[idx] = c
 		logger.Debug(logSender, c.GetID(), "connection swapped, close fs error: %v", err)
@@ -859,12 +982,14 @@ func (conns *ActiveConnections) Remove(connectionID string) {
 			conns.mapping[conns.connections[idx].GetID()] = idx
 		}
 		conns.removeUserConnection(conn.GetUsername())
+		metric.RemoveUserConnection(conn.GetUsername(), conn.GetProtocol())
 		metric.UpdateActiveConnectionsSize(lastIdx)
 		logger.Debug(conn.GetProtocol(), conn.GetID(), "connection removed, local address %#v, remote address %#v close fs error: %v, num open connections: %v",
 			conn.GetLocalAddress(), conn.GetRemoteAddress(), err, lastIdx)
+		logger.SyslogConnectionEvent(false, conn.GetID(), conn.GetUsername(), conn.GetRemoteAddress(), conn.GetProtocol())
 		if conn.GetProtocol() == ProtocolFTP && conn.GetUsername() == "" {
 			ip := util.GetIPFromRemoteAddress(conn.GetRemoteAddress())
-			logger.ConnectionFailedLog("", ip, dataprovider.LoginMethodNoAuthTryed, conn.GetProtocol(),
+			logger.ConnectionFailedLog("", ip, GetCountryForIP(ip), dataprovider.LoginMethodNoAuthTryed, conn.GetProtocol(),
 				dataprovider.ErrNoAuthTryed.Error())
 			metric.AddNoAuthTryed()
 			AddDefenderEvent(ip, HostEventNoLoginTried)
@@ -1051,14 +1176,18 @@ func (conns *ActiveConnections) GetClientConnections() int32 {
 	return conns.clients.getTotal()
 }
 
-// IsNewConnectionAllowed returns false if the maximum number of concurrent allowed connections is exceeded
-// or a whitelist is defined and the specified ipAddr is not listed
+// IsNewConnectionAllowed returns false if the maximum number of concurrent allowed connections is exceeded,
+// a whitelist is defined and the specified ipAddr is not listed or the process resource limits are exceeded
 func (conns *ActiveConnections) IsNewConnectionAllowed(ipAddr string) bool {
 	if Config.whitelist != nil {
 		if !Config.whitelist.isAllowed(ipAddr) {
 			return false
 		}
 	}
+	if AreResourceLimitsExceeded() {
+		logger.Debug(logSender, "", "connection from %v not allowed, resource limits exceeded", ipAddr)
+		return false
+	}
 	if Config.MaxTotalConnections == 0 && Config.MaxPerHostConnections == 0 {
 		return true
 	}
@@ -1158,7 +1287,7 @@ func (c *ConnectionStatus) GetConnectionInfo() string {
 	switch c.Protocol {
 	case ProtocolSSH, ProtocolFTP:
 		result.WriteString(fmt.Sprintf(". Command: %#v", c.Command))
-	case ProtocolWebDAV:
+	case ProtocolWebDAV, ProtocolS3:
 		result.WriteString(fmt.Sprintf(". Method: %#v", c.Command))
 	}
 
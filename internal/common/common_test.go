@@ -15,14 +15,17 @@
 package common
 
 import (
+	"bytes"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -35,6 +38,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/jobs"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/util"
@@ -315,6 +319,8 @@ func TestDefenderIntegration(t *testing.T) {
 	hosts, err := GetDefenderHosts()
 	assert.NoError(t, err)
 	assert.Nil(t, hosts)
+	_, err = GetDefenderFeedsStatus()
+	assert.Error(t, err)
 
 	Config.DefenderConfig = DefenderConfig{
 		Enabled:          true,
@@ -392,6 +398,32 @@ func TestDefenderIntegration(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Nil(t, banTime)
 	assert.False(t, DeleteDefenderHost(ip))
+	feeds, err := GetDefenderFeedsStatus()
+	assert.NoError(t, err)
+	assert.Len(t, feeds, 0)
+
+	Config.DefenderConfig.SafeListFile = ""
+	Config.DefenderConfig.Feeds = []DefenderFeed{
+		{
+			Name:   "feed",
+			URL:    "http://127.0.0.1/feed",
+			Format: DefenderFeedFormatAbuseIPDB,
+		},
+	}
+	err = Initialize(Config, 0)
+	assert.ErrorContains(t, err, "defender feeds initialization error")
+	Config.DefenderConfig.Feeds[0].Format = DefenderFeedFormatCIDR
+	Config.DefenderConfig.Feeds[0].RefreshInterval = 60
+	Config.DefenderConfig.Feeds[0].Score = 1
+	err = Initialize(Config, 0)
+	assert.NoError(t, err)
+	feeds, err = GetDefenderFeedsStatus()
+	assert.NoError(t, err)
+	if assert.Len(t, feeds, 1) {
+		assert.Equal(t, "feed", feeds[0].Name)
+	}
+	_, err = jobs.GetJob(defenderFeedJobPrefix + "feed")
+	assert.NoError(t, err)
 
 	Config = configCopy
 }
@@ -433,11 +465,12 @@ func TestRateLimitersIntegration(t *testing.T) {
 	err = Initialize(Config, 0)
 	assert.NoError(t, err)
 
-	assert.Len(t, rateLimiters, 4)
+	assert.Len(t, rateLimiters, 5)
 	assert.Len(t, rateLimiters[ProtocolSSH], 1)
 	assert.Len(t, rateLimiters[ProtocolFTP], 2)
 	assert.Len(t, rateLimiters[ProtocolWebDAV], 2)
 	assert.Len(t, rateLimiters[ProtocolHTTP], 1)
+	assert.Len(t, rateLimiters[ProtocolS3], 1)
 
 	source1 := "127.1.1.1"
 	source2 := "127.1.1.2"
@@ -812,7 +845,7 @@ func TestConnectionStatus(t *testing.T) {
 			for _, tr := range stat.Transfers {
 				if tr.OperationType == operationDownload {
 					assert.True(t, strings.HasPrefix(tr.getConnectionTransferAsString(), "DL"))
-				} else if tr.OperationType == operationUpload {
+				} else if tr.OperationType == OperationUpload {
 					assert.True(t, strings.HasPrefix(tr.getConnectionTransferAsString(), "UL"))
 				}
 			}
@@ -1465,3 +1498,87 @@ func BenchmarkAddRemoveSSHConnections(b *testing.B) {
 	conn1.Close()
 	conn2.Close()
 }
+
+func TestSpeedTest(t *testing.T) {
+	size, err := GetSpeedTestSize("")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultSpeedTestSize, size)
+	size, err = GetSpeedTestSize("1024")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1024), size)
+	for _, val := range []string{"a", "0", "-10", strconv.FormatInt(MaxSpeedTestSize+1, 10)} {
+		_, err = GetSpeedTestSize(val)
+		var validationErr *util.ValidationError
+		assert.ErrorAs(t, err, &validationErr, val)
+	}
+
+	var buf bytes.Buffer
+	result, err := WriteSpeedTestData(&buf, 100000)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100000), result.Bytes)
+	assert.Equal(t, 100000, buf.Len())
+
+	result, err = DiscardSpeedTestData(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100000), result.Bytes)
+	pr, pw := io.Pipe()
+	go func() {
+		_, errWrite := WriteSpeedTestData(pw, MaxSpeedTestSize+10)
+		pw.CloseWithError(errWrite)
+	}()
+	_, err = DiscardSpeedTestData(pr)
+	assert.Error(t, err)
+	pr.Close()
+
+	result = NewSpeedTestResult(2048, 2*time.Second)
+	assert.Equal(t, int64(2000), result.Elapsed)
+	assert.Equal(t, int64(1024), result.Throughput)
+	assert.Equal(t, "2.0 KiB transferred in 2000 ms, throughput: 1.0 KiB/s", result.String())
+	result = NewSpeedTestResult(2048, 0)
+	assert.Equal(t, int64(0), result.Throughput)
+}
+
+func TestMultipartUploadsConfig(t *testing.T) {
+	mb := int64(1024 * 1024)
+	err := vfs.InitializeMultipartUploads(vfs.MultipartUploadsConfig{MaxPartSize: 4})
+	assert.Error(t, err)
+	err = vfs.InitializeMultipartUploads(vfs.MultipartUploadsConfig{MaxConcurrency: -1})
+	assert.Error(t, err)
+	// no limits
+	err = vfs.InitializeMultipartUploads(vfs.MultipartUploadsConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, 5*mb, vfs.GetUploadPartSize(5*mb, 100000*mb, 5000*mb))
+	assert.Equal(t, 32, vfs.GetUploadConcurrency(32))
+
+	err = vfs.InitializeMultipartUploads(vfs.MultipartUploadsConfig{
+		MaxPartSize:    64,
+		MaxConcurrency: 8,
+		AutoTune:       true,
+	})
+	require.NoError(t, err)
+	// unknown size
+	assert.Equal(t, 5*mb, vfs.GetUploadPartSize(5*mb, 0, 5000*mb))
+	// small files use the configured part size
+	assert.Equal(t, 5*mb, vfs.GetUploadPartSize(5*mb, 100*mb, 5000*mb))
+	// 20 GB, 1000 parts of 21 MB
+	assert.Equal(t, 21*mb, vfs.GetUploadPartSize(5*mb, 20*1024*mb, 5000*mb))
+	// the tuned part size is limited by max part size
+	assert.Equal(t, 64*mb, vfs.GetUploadPartSize(5*mb, 100000*mb, 5000*mb))
+	// the configured part size is limited too
+	assert.Equal(t, 64*mb, vfs.GetUploadPartSize(100*mb, 0, 5000*mb))
+	// and by the storage backend limit
+	assert.Equal(t, 50*mb, vfs.GetUploadPartSize(100*mb, 0, 50*mb))
+	assert.Equal(t, 8, vfs.GetUploadConcurrency(32))
+	assert.Equal(t, 4, vfs.GetUploadConcurrency(4))
+
+	err = vfs.InitializeMultipartUploads(vfs.MultipartUploadsConfig{})
+	require.NoError(t, err)
+	// filesystems not implementing FsSizedCreator ignore the size
+	rootDir := t.TempDir()
+	fs := vfs.NewOsFs("", rootDir, "")
+	f, w, cancelFn, err := vfs.CreateWithSize(fs, filepath.Join(rootDir, "file"), os.O_WRONLY|os.O_CREATE, 100)
+	require.NoError(t, err)
+	assert.Nil(t, w)
+	assert.Nil(t, cancelFn)
+	assert.NoError(t, f.Close())
+}
@@ -15,8 +15,10 @@
 package common
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"strings"
@@ -28,8 +30,11 @@ import (
 	"github.com/pkg/sftp"
 	"github.com/sftpgo/sdk"
 
+	"github.com/drakkan/sftpgo/v2/internal/auditlog"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
@@ -53,6 +58,10 @@ type BaseConnection struct {
 	protocol   string
 	remoteAddr string
 	localAddr  string
+	// trace context for the spans created within this connection
+	traceCtx context.Context
+	// admin impersonating the user, if any
+	impersonator string
 	sync.RWMutex
 	activeTransfers []ActiveTransfer
 }
@@ -80,6 +89,10 @@ func NewBaseConnection(id, protocol, localAddr, remoteAddr string, user dataprov
 
 // Log outputs a log entry to the configured logger
 func (c *BaseConnection) Log(level logger.LogLevel, format string, v ...any) {
+	if c.impersonator != "" {
+		format = "impersonated by admin %q: " + format
+		v = append([]any{c.impersonator}, v...)
+	}
 	logger.Log(level, c.protocol, c.ID, format, v...)
 }
 
@@ -88,6 +101,12 @@ func (c *BaseConnection) GetTransferID() int64 {
 	return c.transferID.Add(1)
 }
 
+// GetBandwidth returns the upload and download bandwidth limits, as KB/s,
+// to apply at the specified time
+func (c *BaseConnection) GetBandwidth(t time.Time) (int64, int64) {
+	return c.User.GetBandwidthForTime(t, c.User.UploadBandwidth, c.User.DownloadBandwidth)
+}
+
 // GetID returns the connection ID
 func (c *BaseConnection) GetID() string {
 	return c.ID
@@ -136,6 +155,59 @@ func (c *BaseConnection) GetLastActivity() time.Time {
 	return time.Unix(0, c.lastActivity.Load())
 }
 
+// SetTraceContext sets the context, containing the protocol session or
+// request span, used as parent for the spans created within this connection
+func (c *BaseConnection) SetTraceContext(ctx context.Context) {
+	c.traceCtx = ctx
+}
+
+// SetImpersonator sets the admin impersonating the user for this connection.
+// Logs, audit records and events for the connection are tagged with it
+func (c *BaseConnection) SetImpersonator(admin string) {
+	c.impersonator = admin
+}
+
+// GetImpersonator returns the admin impersonating the user for this
+// connection, if any
+func (c *BaseConnection) GetImpersonator() string {
+	return c.impersonator
+}
+
+// GetTraceContext returns the trace context for this connection
+func (c *BaseConnection) GetTraceContext() context.Context {
+	if c.traceCtx == nil {
+		return context.Background()
+	}
+	return c.traceCtx
+}
+
+// startFsSpan starts a span for a storage backend operation
+func (c *BaseConnection) startFsSpan(operation string, fs vfs.Fs, virtualPath string) *tracing.Span {
+	_, span := tracing.Start(c.GetTraceContext(), fmt.Sprintf("vfs %s", operation), tracing.SpanKindClient)
+	span.SetAttribute("sftpgo.vfs", fs.Name())
+	span.SetAttribute("sftpgo.path", virtualPath)
+	span.SetAttribute("enduser.id", c.User.Username)
+	return span
+}
+
+// updateOperationMetrics updates the user metrics for a filesystem operation
+func (c *BaseConnection) updateOperationMetrics(operation, virtualPath string, err error) {
+	if !metric.IsUserMetricsEnabled() {
+		return
+	}
+	metric.AddUserOperation(c.User.Username, c.protocol, c.getFolderNameForPath(virtualPath), operation, err)
+}
+
+// getFolderNameForPath returns the name of the virtual folder for the given
+// virtual path or an empty string if the path is inside the user home
+func (c *BaseConnection) getFolderNameForPath(virtualPath string) string {
+	folder, err := c.User.GetVirtualFolderForPath(virtualPath)
+	if err != nil {
+		return ""
+	}
+	return folder.Name
+}
+
 // CloseFS closes the underlying fs
 func (c *BaseConnection) CloseFS() error {
 	return c.User.CloseFs()
@@ -220,7 +292,7 @@ func (c *BaseConnection) GetTransfers() []ConnectionTransfer {
 		case TransferDownload:
 			operationType = operationDownload
 		case TransferUpload:
-			operationType = operationUpload
+			operationType = OperationUpload
 		}
 		transfers = append(transfers, ConnectionTransfer{
 			ID:            t.GetID(),
@@ -299,7 +371,10 @@ func (c *BaseConnection) ListDir(virtualPath string) ([]os.FileInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	span := c.startFsSpan("readdir", fs, virtualPath)
 	files, err := fs.ReadDir(fsPath)
+	span.EndWithError(err)
+	c.updateOperationMetrics("readdir", virtualPath, err)
 	if err != nil {
 		c.Log(logger.LevelDebug, "error listing directory: %+v", err)
 		return nil, c.GetFsError(fs, err)
@@ -354,14 +429,18 @@ func (c *BaseConnection) CreateDir(virtualPath string, checkFilePatterns bool) e
 	if err != nil {
 		return err
 	}
-	if err := fs.Mkdir(fsPath); err != nil {
+	span := c.startFsSpan("mkdir", fs, virtualPath)
+	err = fs.Mkdir(fsPath)
+	span.EndWithError(err)
+	c.updateOperationMetrics("mkdir", virtualPath, err)
+	if err != nil {
 		c.Log(logger.LevelError, "error creating dir: %#v error: %+v", fsPath, err)
 		return c.GetFsError(fs, err)
 	}
 	vfs.SetPathPermissions(fs, fsPath, c.User.GetUID(), c.User.GetGID())
 
 	logger.CommandLog(mkdirLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", -1,
-		c.localAddr, c.remoteAddr)
+		c.localAddr, c.remoteAddr, c.impersonator)
 	ExecuteActionNotification(c, operationMkdir, fsPath, virtualPath, "", "", "", 0, nil) //nolint:errcheck
 	return nil
 }
@@ -389,18 +468,22 @@ func (c *BaseConnection) RemoveFile(fs vfs.Fs, fsPath, virtualPath string, info
 	if actionErr == nil {
 		c.Log(logger.LevelDebug, "remove for path %#v handled by pre-delete action", fsPath)
 	} else {
-		if err := fs.Remove(fsPath, false); err != nil {
+		span := c.startFsSpan("remove", fs, virtualPath)
+		err := fs.Remove(fsPath, false)
+		span.EndWithError(err)
+		c.updateOperationMetrics("remove", virtualPath, err)
+		if err != nil {
 			c.Log(logger.LevelError, "failed to remove file/symlink %#v: %+v", fsPath, err)
 			return c.GetFsError(fs, err)
 		}
 	}
 
 	logger.CommandLog(removeLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", -1,
-		c.localAddr, c.remoteAddr)
+		c.localAddr, c.remoteAddr, c.impersonator)
 	if info.Mode()&os.ModeSymlink == 0 {
 		vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath))
 		if err == nil {
-			dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, -1, -size, false) //nolint:errcheck
+			dataprovider.UpdateVirtualFolderQuotaForUser(&vfolder, c.User.Username, -1, -size) //nolint:errcheck
 			if vfolder.IsIncludedInUserQuota() {
 				dataprovider.UpdateUserQuota(&c.User, -1, -size, false) //nolint:errcheck
 			}
@@ -409,7 +492,7 @@ func (c *BaseConnection) RemoveFile(fs vfs.Fs, fsPath, virtualPath string, info
 		}
 	}
 	if actionErr != nil {
-		ExecuteActionNotification(c, operationDelete, fsPath, virtualPath, "", "", "", size, nil) //nolint:errcheck
+		ExecuteActionNotification(c, OperationDelete, fsPath, virtualPath, "", "", "", size, nil) //nolint:errcheck
 	}
 	return nil
 }
@@ -466,13 +549,17 @@ func (c *BaseConnection) RemoveDir(virtualPath string) error {
 		return c.GetGenericError(nil)
 	}
 
-	if err := fs.Remove(fsPath, true); err != nil {
+	span := c.startFsSpan("rmdir", fs, virtualPath)
+	err = fs.Remove(fsPath, true)
+	span.EndWithError(err)
+	c.updateOperationMetrics("rmdir", virtualPath, err)
+	if err != nil {
 		c.Log(logger.LevelError, "failed to remove directory %#v: %+v", fsPath, err)
 		return c.GetFsError(fs, err)
 	}
 
 	logger.CommandLog(rmdirLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", -1,
-		c.localAddr, c.remoteAddr)
+		c.localAddr, c.remoteAddr, c.impersonator)
 	ExecuteActionNotification(c, operationRmdir, fsPath, virtualPath, "", "", "", 0, nil) //nolint:errcheck
 	return nil
 }
@@ -526,24 +613,202 @@ func (c *BaseConnection) Rename(virtualSourcePath, virtualTargetPath string) err
 			return err
 		}
 	}
+	if !c.isSameResourceRename(virtualSourcePath, virtualTargetPath) {
+		return c.moveFileAcrossResources(fsSrc, fsDst, fsSourcePath, fsTargetPath, virtualSourcePath,
+			virtualTargetPath, srcInfo, initialSize)
+	}
 	if !c.hasSpaceForRename(fsSrc, virtualSourcePath, virtualTargetPath, initialSize, fsSourcePath) {
 		c.Log(logger.LevelInfo, "denying cross rename due to space limit")
 		return c.GetGenericError(ErrQuotaExceeded)
 	}
-	if err := fsDst.Rename(fsSourcePath, fsTargetPath); err != nil {
+	span := c.startFsSpan("rename", fsDst, virtualSourcePath)
+	span.SetAttribute("sftpgo.target_path", virtualTargetPath)
+	err = fsDst.Rename(fsSourcePath, fsTargetPath)
+	span.EndWithError(err)
+	c.updateOperationMetrics("rename", virtualSourcePath, err)
+	if err != nil {
 		c.Log(logger.LevelError, "failed to rename %#v -> %#v: %+v", fsSourcePath, fsTargetPath, err)
 		return c.GetFsError(fsSrc, err)
 	}
 	vfs.SetPathPermissions(fsDst, fsTargetPath, c.User.GetUID(), c.User.GetGID())
 	c.updateQuotaAfterRename(fsDst, virtualSourcePath, virtualTargetPath, fsTargetPath, initialSize) //nolint:errcheck
 	logger.CommandLog(renameLogSender, fsSourcePath, fsTargetPath, c.User.Username, "", c.ID, c.protocol, -1, -1,
-		"", "", "", -1, c.localAddr, c.remoteAddr)
+		"", "", "", -1, c.localAddr, c.remoteAddr, c.impersonator)
 	ExecuteActionNotification(c, operationRename, fsSourcePath, virtualSourcePath, fsTargetPath, //nolint:errcheck
 		virtualTargetPath, "", 0, nil)
 
 	return nil
 }
 
+// moveFileAcrossResources moves a file between different storage backends copying
+// its content to the target path and then removing the source file
+func (c *BaseConnection) moveFileAcrossResources(fsSrc, fsDst vfs.Fs, fsSourcePath, fsTargetPath, virtualSourcePath,
+	virtualTargetPath string, srcInfo os.FileInfo, initialSize int64,
+) error {
+	numFiles := 0
+	truncatedSize := initialSize
+	if initialSize < 0 {
+		numFiles = 1
+		truncatedSize = 0
+	}
+	if err := c.hasSpaceForCopy(virtualTargetPath, numFiles, srcInfo.Size()-truncatedSize, srcInfo.Size()); err != nil {
+		c.Log(logger.LevelInfo, "denying cross backend move due to space limit")
+		return err
+	}
+	if err := ExecutePreAction(c, OperationPreUpload, fsTargetPath, virtualTargetPath, truncatedSize, 0); err != nil {
+		c.Log(logger.LevelDebug, "move to %q denied by pre action: %v", virtualTargetPath, err)
+		return c.GetPermissionDeniedError()
+	}
+	if err := c.copyFileContent(virtualSourcePath, virtualTargetPath); err != nil {
+		return err
+	}
+	if err := fsSrc.Remove(fsSourcePath, false); err != nil {
+		c.Log(logger.LevelError, "unable to remove %q after moving it to %q: %+v", fsSourcePath, fsTargetPath, err)
+		return c.GetFsError(fsSrc, err)
+	}
+	updateUserQuotaAfterFileWrite(c, virtualSourcePath, -1, -srcInfo.Size())
+	logger.CommandLog(renameLogSender, fsSourcePath, fsTargetPath, c.User.Username, "", c.ID, c.protocol, -1, -1,
+		"", "", "", -1, c.localAddr, c.remoteAddr, c.impersonator)
+	ExecuteActionNotification(c, operationRename, fsSourcePath, virtualSourcePath, fsTargetPath, //nolint:errcheck
+		virtualTargetPath, "", srcInfo.Size(), nil)
+	return nil
+}
+
+// CopyFile copies virtualSourcePath to virtualTargetPath, only files are supported.
+// If the source and the target are on the same storage and the filesystem allows it
+// the copy is done by the storage backend, for example using a server side copy
+// for cloud backends, otherwise the source file is read and written to the target
+func (c *BaseConnection) CopyFile(virtualSourcePath, virtualTargetPath string, overwrite bool) error {
+	if virtualSourcePath == virtualTargetPath {
+		return fmt.Errorf("the copy source and target cannot be the same: %w", c.GetOpUnsupportedError())
+	}
+	fsSrc, fsSourcePath, err := c.GetFsAndResolvedPath(virtualSourcePath)
+	if err != nil {
+		return err
+	}
+	fsDst, fsTargetPath, err := c.GetFsAndResolvedPath(virtualTargetPath)
+	if err != nil {
+		return err
+	}
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualSourcePath)) ||
+		!c.User.HasPerm(dataprovider.PermUpload, path.Dir(virtualTargetPath)) {
+		return c.GetPermissionDeniedError()
+	}
+	if ok, policy := c.User.IsFileAllowed(virtualSourcePath); !ok {
+		c.Log(logger.LevelInfo, "copying file %q is not allowed", virtualSourcePath)
+		return c.GetErrorForDeniedFile(policy)
+	}
+	if ok, _ := c.User.IsFileAllowed(virtualTargetPath); !ok {
+		c.Log(logger.LevelInfo, "copying to file %q is not allowed", virtualTargetPath)
+		return c.GetPermissionDeniedError()
+	}
+	srcInfo, err := fsSrc.Stat(fsSourcePath)
+	if err != nil {
+		return c.GetFsError(fsSrc, err)
+	}
+	if !srcInfo.Mode().IsRegular() {
+		c.Log(logger.LevelDebug, "unable to copy %q, only files are supported", virtualSourcePath)
+		return c.GetOpUnsupportedError()
+	}
+	numFiles := 1
+	initialSize := int64(0)
+	if dstInfo, err := fsDst.Lstat(fsTargetPath); err == nil {
+		if dstInfo.IsDir() {
+			c.Log(logger.LevelWarn, "attempted to copy %q overwriting an existing directory %q",
+				virtualSourcePath, virtualTargetPath)
+			return c.GetOpUnsupportedError()
+		}
+		if !overwrite {
+			return fmt.Errorf("unable to copy %q: %w", virtualSourcePath, os.ErrExist)
+		}
+		if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(virtualTargetPath)) {
+			return c.GetPermissionDeniedError()
+		}
+		if dstInfo.Mode().IsRegular() {
+			initialSize = dstInfo.Size()
+		}
+		numFiles = 0
+	} else if !fsDst.IsNotExist(err) {
+		return c.GetFsError(fsDst, err)
+	}
+	if err := c.hasSpaceForCopy(virtualTargetPath, numFiles, srcInfo.Size()-initialSize, srcInfo.Size()); err != nil {
+		return err
+	}
+	if err := ExecutePreAction(c, OperationPreUpload, fsTargetPath, virtualTargetPath, initialSize, 0); err != nil {
+		c.Log(logger.LevelDebug, "copy to %q denied by pre action: %v", virtualTargetPath, err)
+		return c.GetPermissionDeniedError()
+	}
+	copier, ok := fsDst.(vfs.FsFileCopier)
+	if !ok || !c.isSameResourceRename(virtualSourcePath, virtualTargetPath) {
+		return c.copyFileContent(virtualSourcePath, virtualTargetPath)
+	}
+	c.Log(logger.LevelDebug, "copying %q -> %q using the storage backend", fsSourcePath, fsTargetPath)
+	if err := copier.CopyFile(fsSourcePath, fsTargetPath); err != nil {
+		c.Log(logger.LevelError, "failed to copy %q -> %q: %+v", fsSourcePath, fsTargetPath, err)
+		return c.GetFsError(fsDst, err)
+	}
+	vfs.SetPathPermissions(fsDst, fsTargetPath, c.User.GetUID(), c.User.GetGID())
+	copiedSize := srcInfo.Size()
+	if vfs.IsDedupFs(fsDst) {
+		// the copy references the source blob and its quota size depends on the quota mode
+		if dstInfo, err := fsDst.Stat(fsTargetPath); err == nil {
+			copiedSize = dstInfo.Size()
+		}
+	}
+	updateUserQuotaAfterFileWrite(c, virtualTargetPath, numFiles, copiedSize-initialSize)
+	ExecuteActionNotification(c, OperationUpload, fsTargetPath, virtualTargetPath, "", "", "", //nolint:errcheck
+		srcInfo.Size(), nil)
+	return nil
+}
+
+// copyFileContent copies a file reading it and writing its content to the target path
+func (c *BaseConnection) copyFileContent(virtualSourcePath, virtualTargetPath string) error {
+	c.Log(logger.LevelDebug, "copying %q -> %q reading and writing the file content", virtualSourcePath,
+		virtualTargetPath)
+	reader, cancelReader, err := getFileReader(c, virtualSourcePath)
+	if err != nil {
+		return err
+	}
+	defer cancelReader()
+	defer reader.Close()
+
+	writer, numFiles, truncatedSize, cancelWriter, err := getFileWriter(c, virtualTargetPath)
+	if err != nil {
+		return err
+	}
+	defer cancelWriter()
+
+	_, err = io.Copy(writer, reader)
+	errClose := closeWriterAndUpdateQuota(writer, c, virtualTargetPath, numFiles, truncatedSize, err)
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		c.Log(logger.LevelError, "failed to copy %q -> %q: %+v", virtualSourcePath, virtualTargetPath, err)
+		return c.GetGenericError(err)
+	}
+	return nil
+}
+
+func (c *BaseConnection) hasSpaceForCopy(virtualTargetPath string, numFiles int, sizeDiff, fileSize int64) error {
+	if maxUploadFileSize := c.User.GetMaxUploadFileSize(virtualTargetPath); maxUploadFileSize > 0 &&
+		fileSize > maxUploadFileSize {
+		c.Log(logger.LevelDebug, "denying copy, the file size %d exceeds the max upload file size %d",
+			fileSize, maxUploadFileSize)
+		return c.GetQuotaExceededError()
+	}
+	quotaResult, _ := c.HasSpace(numFiles > 0, false, virtualTargetPath)
+	if !quotaResult.HasSpace {
+		return c.GetQuotaExceededError()
+	}
+	if quotaResult.QuotaSize > 0 && sizeDiff > 0 && quotaResult.GetRemainingSize() < sizeDiff {
+		c.Log(logger.LevelDebug, "denying copy due to space limit, remaining size: %d, to copy: %d",
+			quotaResult.GetRemainingSize(), sizeDiff)
+		return c.GetQuotaExceededError()
+	}
+	return nil
+}
+
 // CreateSymlink creates fsTargetPath as a symbolic link to fsSourcePath
 func (c *BaseConnection) CreateSymlink(virtualSourcePath, virtualTargetPath string) error {
 	var relativePath string
@@ -586,15 +851,20 @@ func (c *BaseConnection) CreateSymlink(virtualSourcePath, virtualTargetPath stri
 		c.Log(logger.LevelError, "symlink target path %#v is not allowed", virtualTargetPath)
 		return c.GetPermissionDeniedError()
 	}
-	if relativePath != "" {
+	// emulated symlinks on object storages always store the resolved target
+	if relativePath != "" && !fs.HasVirtualFolders() {
 		fsSourcePath = relativePath
 	}
-	if err := fs.Symlink(fsSourcePath, fsTargetPath); err != nil {
+	span := c.startFsSpan("symlink", fs, virtualTargetPath)
+	err = fs.Symlink(fsSourcePath, fsTargetPath)
+	span.EndWithError(err)
+	c.updateOperationMetrics("symlink", virtualTargetPath, err)
+	if err != nil {
 		c.Log(logger.LevelError, "failed to create symlink %#v -> %#v: %+v", fsSourcePath, fsTargetPath, err)
 		return c.GetFsError(fs, err)
 	}
 	logger.CommandLog(symlinkLogSender, fsSourcePath, fsTargetPath, c.User.Username, "", c.ID, c.protocol, -1, -1, "",
-		"", "", -1, c.localAddr, c.remoteAddr)
+		"", "", -1, c.localAddr, c.remoteAddr, c.impersonator)
 	return nil
 }
 
@@ -631,17 +901,19 @@ func (c *BaseConnection) doStatInternal(virtualPath string, mode int, checkFileP
 		return info, err
 	}
 
+	span := c.startFsSpan("stat", fs, virtualPath)
 	if mode == 1 {
 		info, err = fs.Lstat(c.getRealFsPath(fsPath))
 	} else {
 		info, err = fs.Stat(c.getRealFsPath(fsPath))
 	}
+	span.EndWithError(err)
 	if err != nil {
 		c.Log(logger.LevelWarn, "stat error for path %#v: %+v", virtualPath, err)
 		return info, c.GetFsError(fs, err)
 	}
-	if convertResult && vfs.IsCryptOsFs(fs) {
-		info = fs.(*vfs.CryptFs).ConvertFileInfo(info)
+	if convertResult {
+		info = vfs.ConvertFileInfo(fs, info)
 	}
 	return info, nil
 }
@@ -663,7 +935,8 @@ func (c *BaseConnection) ignoreSetStat(fs vfs.Fs) bool {
 	if Config.SetstatMode == 1 {
 		return true
 	}
-	if Config.SetstatMode == 2 && !vfs.IsLocalOrSFTPFs(fs) && !vfs.IsCryptOsFs(fs) {
+	if Config.SetstatMode == 2 && !vfs.IsLocalOrSFTPFs(fs) && !vfs.IsCryptOsFs(fs) && !vfs.IsCompressedOsFs(fs) &&
+		!vfs.IsDedupFs(fs) {
 		return true
 	}
 	return false
@@ -681,7 +954,7 @@ func (c *BaseConnection) handleChmod(fs vfs.Fs, fsPath, pathForPerms string, att
 		return c.GetFsError(fs, err)
 	}
 	logger.CommandLog(chmodLogSender, fsPath, "", c.User.Username, attributes.Mode.String(), c.ID, c.protocol,
-		-1, -1, "", "", "", -1, c.localAddr, c.remoteAddr)
+		-1, -1, "", "", "", -1, c.localAddr, c.remoteAddr, c.impersonator)
 	return nil
 }
 
@@ -698,7 +971,7 @@ func (c *BaseConnection) handleChown(fs vfs.Fs, fsPath, pathForPerms string, att
 		return c.GetFsError(fs, err)
 	}
 	logger.CommandLog(chownLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, attributes.UID, attributes.GID,
-		"", "", "", -1, c.localAddr, c.remoteAddr)
+		"", "", "", -1, c.localAddr, c.remoteAddr, c.impersonator)
 	return nil
 }
 
@@ -722,7 +995,7 @@ func (c *BaseConnection) handleChtimes(fs vfs.Fs, fsPath, pathForPerms string, a
 	accessTimeString := attributes.Atime.Format(chtimesFormat)
 	modificationTimeString := attributes.Mtime.Format(chtimesFormat)
 	logger.CommandLog(chtimesLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1,
-		accessTimeString, modificationTimeString, "", -1, c.localAddr, c.remoteAddr)
+		accessTimeString, modificationTimeString, "", -1, c.localAddr, c.remoteAddr, c.impersonator)
 	return nil
 }
 
@@ -765,7 +1038,13 @@ func (c *BaseConnection) SetStat(virtualPath string, attributes *StatAttributes)
 			return c.GetFsError(fs, err)
 		}
 		logger.CommandLog(truncateLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "",
-			"", attributes.Size, c.localAddr, c.remoteAddr)
+			"", attributes.Size, c.localAddr, c.remoteAddr, c.impersonator)
+	}
+
+	if attributes.Flags&StatAttrMetadata != 0 {
+		if err = c.handleSetCustomMetadata(fs, fsPath, pathForPerms, attributes.Metadata); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -791,7 +1070,7 @@ func (c *BaseConnection) truncateFile(fs vfs.Fs, fsPath, virtualPath string, siz
 		sizeDiff := initialSize - size
 		vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath))
 		if err == nil {
-			dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -sizeDiff, false) //nolint:errcheck
+			dataprovider.UpdateVirtualFolderQuotaForUser(&vfolder, c.User.Username, 0, -sizeDiff) //nolint:errcheck
 			if vfolder.IsIncludedInUserQuota() {
 				dataprovider.UpdateUserQuota(&c.User, 0, -sizeDiff, false) //nolint:errcheck
 			}
@@ -862,19 +1141,25 @@ func (c *BaseConnection) hasRenamePerms(virtualSourcePath, virtualTargetPath str
 		c.User.HasAnyPerm(perms, path.Dir(virtualTargetPath))
 }
 
+// isCrossResourceMovePermitted returns true if the configured rename mode allows
+// to move the specified file between different storage backends
+func (c *BaseConnection) isCrossResourceMovePermitted(fi os.FileInfo) bool {
+	return Config.RenameMode == 1 && fi != nil && fi.Mode().IsRegular()
+}
+
 func (c *BaseConnection) isRenamePermitted(fsSrc, fsDst vfs.Fs, fsSourcePath, fsTargetPath, virtualSourcePath,
 	virtualTargetPath string, fi os.FileInfo,
 ) bool {
-	if !c.isSameResourceRename(virtualSourcePath, virtualTargetPath) {
+	if !c.isSameResourceRename(virtualSourcePath, virtualTargetPath) && !c.isCrossResourceMovePermitted(fi) {
 		c.Log(logger.LevelInfo, "rename %#v->%#v is not allowed: the paths must be on the same resource",
 			virtualSourcePath, virtualTargetPath)
 		return false
 	}
-	if c.User.IsMappedPath(fsSourcePath) && vfs.IsLocalOrCryptoFs(fsSrc) {
+	if c.User.IsMappedPath(fsSourcePath) && (vfs.IsLocalOrCryptoFs(fsSrc) || vfs.IsDedupFs(fsSrc)) {
 		c.Log(logger.LevelWarn, "renaming a directory mapped as virtual folder is not allowed: %#v", fsSourcePath)
 		return false
 	}
-	if c.User.IsMappedPath(fsTargetPath) && vfs.IsLocalOrCryptoFs(fsDst) {
+	if c.User.IsMappedPath(fsTargetPath) && (vfs.IsLocalOrCryptoFs(fsDst) || vfs.IsDedupFs(fsDst)) {
 		c.Log(logger.LevelWarn, "renaming to a directory mapped as virtual folder is not allowed: %#v", fsTargetPath)
 		return false
 	}
@@ -978,22 +1263,23 @@ func (c *BaseConnection) hasSpaceForCrossRename(fs vfs.Fs, quotaResult vfs.Quota
 	return true
 }
 
-// GetMaxWriteSize returns the allowed size for an upload or an error
-// if no enough size is available for a resume/append
-func (c *BaseConnection) GetMaxWriteSize(quotaResult vfs.QuotaCheckResult, isResume bool, fileSize int64,
-	isUploadResumeSupported bool,
+// GetMaxWriteSize returns the allowed size for an upload to the specified virtual
+// path or an error if no enough size is available for a resume/append
+func (c *BaseConnection) GetMaxWriteSize(virtualPath string, quotaResult vfs.QuotaCheckResult, isResume bool,
+	fileSize int64, isUploadResumeSupported bool,
 ) (int64, error) {
 	maxWriteSize := quotaResult.GetRemainingSize()
+	maxUploadFileSize := c.User.GetMaxUploadFileSize(virtualPath)
 
 	if isResume {
 		if !isUploadResumeSupported {
 			return 0, c.GetOpUnsupportedError()
 		}
-		if c.User.Filters.MaxUploadFileSize > 0 && c.User.Filters.MaxUploadFileSize <= fileSize {
+		if maxUploadFileSize > 0 && maxUploadFileSize <= fileSize {
 			return 0, c.GetQuotaExceededError()
 		}
-		if c.User.Filters.MaxUploadFileSize > 0 {
-			maxUploadSize := c.User.Filters.MaxUploadFileSize - fileSize
+		if maxUploadFileSize > 0 {
+			maxUploadSize := maxUploadFileSize - fileSize
 			if maxUploadSize < maxWriteSize || maxWriteSize == 0 {
 				maxWriteSize = maxUploadSize
 			}
@@ -1002,14 +1288,38 @@ func (c *BaseConnection) GetMaxWriteSize(quotaResult vfs.QuotaCheckResult, isRes
 		if maxWriteSize > 0 {
 			maxWriteSize += fileSize
 		}
-		if c.User.Filters.MaxUploadFileSize > 0 && (c.User.Filters.MaxUploadFileSize < maxWriteSize || maxWriteSize == 0) {
-			maxWriteSize = c.User.Filters.MaxUploadFileSize
+		if maxUploadFileSize > 0 && (maxUploadFileSize < maxWriteSize || maxWriteSize == 0) {
+			maxWriteSize = maxUploadFileSize
 		}
 	}
 
 	return maxWriteSize, nil
 }
 
+// CheckDeclaredUploadSize returns a quota exceeded error if the upload
+// size declared by the client before sending any data exceeds the allowed
+// size. maxWriteSize is the value returned by GetMaxWriteSize, 0 means no limit.
+// A declared size lower than or equal to 0 means unknown and it is not checked
+func (c *BaseConnection) CheckDeclaredUploadSize(declaredSize, maxWriteSize int64,
+	transferQuota dataprovider.TransferQuota,
+) error {
+	if declaredSize <= 0 {
+		return nil
+	}
+	if maxWriteSize > 0 && declaredSize > maxWriteSize {
+		c.Log(logger.LevelInfo, "denying upload, the declared size %d exceeds the allowed size %d",
+			declaredSize, maxWriteSize)
+		return c.GetQuotaExceededError()
+	}
+	if (transferQuota.AllowedULSize > 0 && declaredSize > transferQuota.AllowedULSize) ||
+		(transferQuota.AllowedTotalSize > 0 && declaredSize > transferQuota.AllowedTotalSize) {
+		c.Log(logger.LevelInfo, "denying upload, the declared size %d exceeds the transfer quota, allowed upload size: %d, allowed total size: %d",
+			declaredSize, transferQuota.AllowedULSize, transferQuota.AllowedTotalSize)
+		return c.GetQuotaExceededError()
+	}
+	return nil
+}
+
 // GetTransferQuota returns the data transfers quota
 func (c *BaseConnection) GetTransferQuota() dataprovider.TransferQuota {
 	result, _, _ := c.checkUserQuota()
@@ -1049,6 +1359,26 @@ func (c *BaseConnection) checkUserQuota() (dataprovider.TransferQuota, int, int6
 	return result, usedFiles, usedSize
 }
 
+// applyUserFolderQuota replaces the limits in the quota check result with the
+// per-user limits defined for the virtual folder if they are more restrictive
+func (c *BaseConnection) applyUserFolderQuota(result *vfs.QuotaCheckResult, vfolder *vfs.VirtualFolder) error {
+	usedFiles, usedSize, err := dataprovider.GetUsedUserFolderQuota(c.User.Username, vfolder.Name)
+	if err != nil {
+		return err
+	}
+	if vfolder.UserQuotaSize > 0 && (result.QuotaSize <= 0 ||
+		vfolder.UserQuotaSize-usedSize < result.QuotaSize-result.UsedSize) {
+		result.QuotaSize = vfolder.UserQuotaSize
+		result.UsedSize = usedSize
+	}
+	if vfolder.UserQuotaFiles > 0 && (result.QuotaFiles <= 0 ||
+		vfolder.UserQuotaFiles-usedFiles < result.QuotaFiles-result.UsedFiles) {
+		result.QuotaFiles = vfolder.UserQuotaFiles
+		result.UsedFiles = usedFiles
+	}
+	return nil
+}
+
 // HasSpace checks user's quota usage
 func (c *BaseConnection) HasSpace(checkFiles, getUsage bool, requestPath string) (vfs.QuotaCheckResult,
 	dataprovider.TransferQuota,
@@ -1070,15 +1400,16 @@ func (c *BaseConnection) HasSpace(checkFiles, getUsage bool, requestPath string)
 	var err error
 	var vfolder vfs.VirtualFolder
 	vfolder, err = c.User.GetVirtualFolderForPath(path.Dir(requestPath))
+	hasUserFolderQuota := err == nil && vfolder.HasUserQuotaRestrictions()
 	if err == nil && !vfolder.IsIncludedInUserQuota() {
-		if vfolder.HasNoQuotaRestrictions(checkFiles) && !getUsage {
+		if vfolder.HasNoQuotaRestrictions(checkFiles) && !getUsage && !hasUserFolderQuota {
 			return result, transferQuota
 		}
 		result.QuotaSize = vfolder.QuotaSize
 		result.QuotaFiles = vfolder.QuotaFiles
 		result.UsedFiles, result.UsedSize, err = dataprovider.GetUsedVirtualFolderQuota(vfolder.Name)
 	} else {
-		if c.User.HasNoQuotaRestrictions(checkFiles) && !getUsage {
+		if c.User.HasNoQuotaRestrictions(checkFiles) && !getUsage && !hasUserFolderQuota {
 			return result, transferQuota
 		}
 		result.QuotaSize = c.User.QuotaSize
@@ -1091,6 +1422,9 @@ func (c *BaseConnection) HasSpace(checkFiles, getUsage bool, requestPath string)
 			result.UsedSize = usedSize
 		}
 	}
+	if err == nil && hasUserFolderQuota {
+		err = c.applyUserFolderQuota(&result, &vfolder)
+	}
 	if err != nil {
 		c.Log(logger.LevelError, "error getting used quota for %#v request path %#v: %v", c.User.Username, requestPath, err)
 		result.HasSpace = false
@@ -1144,7 +1478,7 @@ func (c *BaseConnection) updateQuotaMoveBetweenVFolders(sourceFolder, dstFolder
 	if sourceFolder.Name == dstFolder.Name {
 		// both files are inside the same virtual folder
 		if initialSize != -1 {
-			dataprovider.UpdateVirtualFolderQuota(&dstFolder.BaseVirtualFolder, -numFiles, -initialSize, false) //nolint:errcheck
+			dataprovider.UpdateVirtualFolderQuotaForUser(dstFolder, c.User.Username, -numFiles, -initialSize) //nolint:errcheck
 			if dstFolder.IsIncludedInUserQuota() {
 				dataprovider.UpdateUserQuota(&c.User, -numFiles, -initialSize, false) //nolint:errcheck
 			}
@@ -1152,18 +1486,18 @@ func (c *BaseConnection) updateQuotaMoveBetweenVFolders(sourceFolder, dstFolder
 		return
 	}
 	// files are inside different virtual folders
-	dataprovider.UpdateVirtualFolderQuota(&sourceFolder.BaseVirtualFolder, -numFiles, -filesSize, false) //nolint:errcheck
+	dataprovider.UpdateVirtualFolderQuotaForUser(sourceFolder, c.User.Username, -numFiles, -filesSize) //nolint:errcheck
 	if sourceFolder.IsIncludedInUserQuota() {
 		dataprovider.UpdateUserQuota(&c.User, -numFiles, -filesSize, false) //nolint:errcheck
 	}
 	if initialSize == -1 {
-		dataprovider.UpdateVirtualFolderQuota(&dstFolder.BaseVirtualFolder, numFiles, filesSize, false) //nolint:errcheck
+		dataprovider.UpdateVirtualFolderQuotaForUser(dstFolder, c.User.Username, numFiles, filesSize) //nolint:errcheck
 		if dstFolder.IsIncludedInUserQuota() {
 			dataprovider.UpdateUserQuota(&c.User, numFiles, filesSize, false) //nolint:errcheck
 		}
 	} else {
 		// we cannot have a directory here, initialSize != -1 only for files
-		dataprovider.UpdateVirtualFolderQuota(&dstFolder.BaseVirtualFolder, 0, filesSize-initialSize, false) //nolint:errcheck
+		dataprovider.UpdateVirtualFolderQuotaForUser(dstFolder, c.User.Username, 0, filesSize-initialSize) //nolint:errcheck
 		if dstFolder.IsIncludedInUserQuota() {
 			dataprovider.UpdateUserQuota(&c.User, 0, filesSize-initialSize, false) //nolint:errcheck
 		}
@@ -1172,7 +1506,7 @@ func (c *BaseConnection) updateQuotaMoveBetweenVFolders(sourceFolder, dstFolder
 
 func (c *BaseConnection) updateQuotaMoveFromVFolder(sourceFolder *vfs.VirtualFolder, initialSize, filesSize int64, numFiles int) {
 	// move between a virtual folder and the user home dir
-	dataprovider.UpdateVirtualFolderQuota(&sourceFolder.BaseVirtualFolder, -numFiles, -filesSize, false) //nolint:errcheck
+	dataprovider.UpdateVirtualFolderQuotaForUser(sourceFolder, c.User.Username, -numFiles, -filesSize) //nolint:errcheck
 	if sourceFolder.IsIncludedInUserQuota() {
 		dataprovider.UpdateUserQuota(&c.User, -numFiles, -filesSize, false) //nolint:errcheck
 	}
@@ -1188,13 +1522,13 @@ func (c *BaseConnection) updateQuotaMoveToVFolder(dstFolder *vfs.VirtualFolder,
 	// move between the user home dir and a virtual folder
 	dataprovider.UpdateUserQuota(&c.User, -numFiles, -filesSize, false) //nolint:errcheck
 	if initialSize == -1 {
-		dataprovider.UpdateVirtualFolderQuota(&dstFolder.BaseVirtualFolder, numFiles, filesSize, false) //nolint:errcheck
+		dataprovider.UpdateVirtualFolderQuotaForUser(dstFolder, c.User.Username, numFiles, filesSize) //nolint:errcheck
 		if dstFolder.IsIncludedInUserQuota() {
 			dataprovider.UpdateUserQuota(&c.User, numFiles, filesSize, false) //nolint:errcheck
 		}
 	} else {
 		// we cannot have a directory here, initialSize != -1 only for files
-		dataprovider.UpdateVirtualFolderQuota(&dstFolder.BaseVirtualFolder, 0, filesSize-initialSize, false) //nolint:errcheck
+		dataprovider.UpdateVirtualFolderQuotaForUser(dstFolder, c.User.Username, 0, filesSize-initialSize) //nolint:errcheck
 		if dstFolder.IsIncludedInUserQuota() {
 			dataprovider.UpdateUserQuota(&c.User, 0, filesSize-initialSize, false) //nolint:errcheck
 		}
@@ -1256,7 +1590,7 @@ func (c *BaseConnection) IsNotExistError(err error) bool {
 	switch c.protocol {
 	case ProtocolSFTP:
 		return errors.Is(err, sftp.ErrSSHFxNoSuchFile)
-	case ProtocolWebDAV, ProtocolFTP, ProtocolHTTP, ProtocolOIDC, ProtocolHTTPShare, ProtocolDataRetention:
+	case ProtocolWebDAV, ProtocolFTP, ProtocolHTTP, ProtocolOIDC, ProtocolHTTPShare, ProtocolDataRetention, ProtocolS3:
 		return errors.Is(err, os.ErrNotExist)
 	default:
 		return errors.Is(err, ErrNotExist)
@@ -1275,10 +1609,23 @@ func (c *BaseConnection) GetErrorForDeniedFile(policy int) error {
 
 // GetPermissionDeniedError returns an appropriate permission denied error for the connection protocol
 func (c *BaseConnection) GetPermissionDeniedError() error {
+	record := auditlog.Record{
+		Event:    auditlog.EventPermissionDenied,
+		Username: c.User.Username,
+		IP:       c.GetRemoteIP(),
+		Protocol: c.protocol,
+		Details: map[string]string{
+			"connection_id": c.GetID(),
+		},
+	}
+	if c.impersonator != "" {
+		record.Details["impersonated_by"] = c.impersonator
+	}
+	auditlog.Add(record)
 	switch c.protocol {
 	case ProtocolSFTP:
 		return sftp.ErrSSHFxPermissionDenied
-	case ProtocolWebDAV, ProtocolFTP, ProtocolHTTP, ProtocolOIDC, ProtocolHTTPShare, ProtocolDataRetention:
+	case ProtocolWebDAV, ProtocolFTP, ProtocolHTTP, ProtocolOIDC, ProtocolHTTPShare, ProtocolDataRetention, ProtocolS3:
 		return os.ErrPermission
 	default:
 		return ErrPermissionDenied
@@ -1290,7 +1637,7 @@ func (c *BaseConnection) GetNotExistError() error {
 	switch c.protocol {
 	case ProtocolSFTP:
 		return sftp.ErrSSHFxNoSuchFile
-	case ProtocolWebDAV, ProtocolFTP, ProtocolHTTP, ProtocolOIDC, ProtocolHTTPShare, ProtocolDataRetention:
+	case ProtocolWebDAV, ProtocolFTP, ProtocolHTTP, ProtocolOIDC, ProtocolHTTPShare, ProtocolDataRetention, ProtocolS3:
 		return os.ErrNotExist
 	default:
 		return ErrNotExist
@@ -1337,6 +1684,17 @@ func (c *BaseConnection) GetReadQuotaExceededError() error {
 	return getReadQuotaExceededError(c.protocol)
 }
 
+// GetArchivedObjectError returns an appropriate error for archived objects that
+// must be restored before they can be downloaded
+func (c *BaseConnection) GetArchivedObjectError() error {
+	switch c.protocol {
+	case ProtocolSFTP:
+		return fmt.Errorf("%w: %v", sftp.ErrSSHFxFailure, vfs.ErrObjectArchived.Error())
+	default:
+		return vfs.ErrObjectArchived
+	}
+}
+
 // IsQuotaExceededError returns true if the given error is a quota exceeded error
 func (c *BaseConnection) IsQuotaExceededError(err error) bool {
 	switch c.protocol {
@@ -1388,6 +1746,8 @@ func (c *BaseConnection) GetFsError(fs vfs.Fs, err error) error {
 		return c.GetPermissionDeniedError()
 	} else if fs.IsNotSupported(err) {
 		return c.GetOpUnsupportedError()
+	} else if errors.Is(err, vfs.ErrObjectArchived) {
+		return c.GetArchivedObjectError()
 	} else if err != nil {
 		return c.GetGenericError(err)
 	}
@@ -15,10 +15,12 @@
 package common
 
 import (
+	"fmt"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -320,11 +322,88 @@ func TestUpdateQuotaAfterRename(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCopyFile(t *testing.T) {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: userTestUsername,
+			HomeDir:  filepath.Join(os.TempDir(), "home"),
+		},
+	}
+	mappedPath := filepath.Join(os.TempDir(), "vdir")
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	user.Permissions["/noperms"] = []string{dataprovider.PermUpload}
+	user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name:       "vdir",
+			MappedPath: mappedPath,
+		},
+		VirtualPath: "/vdir",
+		QuotaFiles:  -1,
+		QuotaSize:   -1,
+	})
+	user.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:            "/",
+			DeniedPatterns:  []string{"*.zip"},
+			AllowedPatterns: []string{},
+		},
+	}
+	err := os.MkdirAll(filepath.Join(user.GetHomeDir(), "noperms"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.MkdirAll(mappedPath, os.ModePerm)
+	assert.NoError(t, err)
+	content := []byte("test content")
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file"), content, os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "noperms", "file"), content, os.ModePerm)
+	assert.NoError(t, err)
+	c := NewBaseConnection("", ProtocolSFTP, "", "", user)
+	// copy using the local filesystem
+	err = c.CopyFile("/file", "/file1", false)
+	assert.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(user.GetHomeDir(), "file1"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+	err = c.CopyFile("/file", "/file1", false)
+	assert.ErrorIs(t, err, os.ErrExist)
+	err = c.CopyFile("/file", "/file1", true)
+	assert.NoError(t, err)
+	// copy between different resources, the file content is read and written
+	err = c.CopyFile("/file", "/vdir/file", false)
+	assert.NoError(t, err)
+	data, err = os.ReadFile(filepath.Join(mappedPath, "file"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	err = c.CopyFile("/file", "/file", true)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxOpUnsupported)
+	err = c.CopyFile("/noperms", "/file2", true)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxOpUnsupported)
+	err = c.CopyFile("/file", "/noperms", true)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxOpUnsupported)
+	err = c.CopyFile("/missing", "/file2", true)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxNoSuchFile)
+	err = c.CopyFile("/noperms/file", "/file2", true)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxPermissionDenied)
+	err = c.CopyFile("/file", "/file.zip", true)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxPermissionDenied)
+	c.User.Filters.MaxUploadFileSize = 5
+	err = c.CopyFile("/file", "/file2", true)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxFailure)
+	assert.Contains(t, err.Error(), ErrQuotaExceeded.Error())
+
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestErrorsMapping(t *testing.T) {
 	fs := vfs.NewOsFs("", os.TempDir(), "")
 	conn := NewBaseConnection("", ProtocolSFTP, "", "", dataprovider.User{BaseUser: sdk.BaseUser{HomeDir: os.TempDir()}})
 	osErrorsProtocols := []string{ProtocolWebDAV, ProtocolFTP, ProtocolHTTP, ProtocolHTTPShare,
-		ProtocolDataRetention, ProtocolOIDC, protocolEventAction}
+		ProtocolDataRetention, ProtocolOIDC, protocolEventAction, ProtocolS3}
 	for _, protocol := range supportedProtocols {
 		conn.SetProtocol(protocol)
 		err := conn.GetFsError(fs, os.ErrNotExist)
@@ -366,6 +445,13 @@ func TestErrorsMapping(t *testing.T) {
 		} else {
 			assert.EqualError(t, err, vfs.ErrStorageSizeUnavailable.Error())
 		}
+		err = conn.GetFsError(fs, fmt.Errorf("%w: download error", vfs.ErrObjectArchived))
+		if protocol == ProtocolSFTP {
+			assert.ErrorIs(t, err, sftp.ErrSSHFxFailure)
+			assert.Contains(t, err.Error(), vfs.ErrObjectArchived.Error())
+		} else {
+			assert.ErrorIs(t, err, vfs.ErrObjectArchived)
+		}
 		err = conn.GetQuotaExceededError()
 		assert.True(t, conn.IsQuotaExceededError(err))
 		err = conn.GetReadQuotaExceededError()
@@ -404,42 +490,185 @@ func TestMaxWriteSize(t *testing.T) {
 	quotaResult := vfs.QuotaCheckResult{
 		HasSpace: true,
 	}
-	size, err := conn.GetMaxWriteSize(quotaResult, false, 0, fs.IsUploadResumeSupported())
+	size, err := conn.GetMaxWriteSize("/file.txt", quotaResult, false, 0, fs.IsUploadResumeSupported())
 	assert.NoError(t, err)
 	assert.Equal(t, int64(0), size)
 
 	conn.User.Filters.MaxUploadFileSize = 100
-	size, err = conn.GetMaxWriteSize(quotaResult, false, 0, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize("/file.txt", quotaResult, false, 0, fs.IsUploadResumeSupported())
 	assert.NoError(t, err)
 	assert.Equal(t, int64(100), size)
 
 	quotaResult.QuotaSize = 1000
-	size, err = conn.GetMaxWriteSize(quotaResult, false, 50, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize("/file.txt", quotaResult, false, 50, fs.IsUploadResumeSupported())
 	assert.NoError(t, err)
 	assert.Equal(t, int64(100), size)
 
 	quotaResult.QuotaSize = 1000
 	quotaResult.UsedSize = 990
-	size, err = conn.GetMaxWriteSize(quotaResult, false, 50, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize("/file.txt", quotaResult, false, 50, fs.IsUploadResumeSupported())
 	assert.NoError(t, err)
 	assert.Equal(t, int64(60), size)
 
 	quotaResult.QuotaSize = 0
 	quotaResult.UsedSize = 0
-	size, err = conn.GetMaxWriteSize(quotaResult, true, 100, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize("/file.txt", quotaResult, true, 100, fs.IsUploadResumeSupported())
 	assert.True(t, conn.IsQuotaExceededError(err))
 	assert.Equal(t, int64(0), size)
 
-	size, err = conn.GetMaxWriteSize(quotaResult, true, 10, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize("/file.txt", quotaResult, true, 10, fs.IsUploadResumeSupported())
 	assert.NoError(t, err)
 	assert.Equal(t, int64(90), size)
 
+	conn.User.Filters.UploadSizeLimits = []dataprovider.UploadSizeLimit{
+		{
+			Path:       "/",
+			Extensions: []string{".mp4"},
+			MaxSize:    1000,
+		},
+		{
+			Path:    "/docs",
+			MaxSize: 20,
+		},
+	}
+	size, err = conn.GetMaxWriteSize("/video.mp4", quotaResult, false, 0, fs.IsUploadResumeSupported())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), size)
+	size, err = conn.GetMaxWriteSize("/docs/video.mp4", quotaResult, true, 10, fs.IsUploadResumeSupported())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), size)
+	size, err = conn.GetMaxWriteSize("/docs/file.txt", quotaResult, true, 20, fs.IsUploadResumeSupported())
+	assert.True(t, conn.IsQuotaExceededError(err))
+	assert.Equal(t, int64(0), size)
+	conn.User.Filters.UploadSizeLimits = nil
+
 	fs = newMockOsFs(true, fs.ConnectionID(), user.GetHomeDir(), "")
-	size, err = conn.GetMaxWriteSize(quotaResult, true, 100, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize("/file.txt", quotaResult, true, 100, fs.IsUploadResumeSupported())
 	assert.EqualError(t, err, ErrOpUnsupported.Error())
 	assert.Equal(t, int64(0), size)
 }
 
+func TestArchiveRestores(t *testing.T) {
+	permissions := make(map[string][]string)
+	permissions["/"] = []string{dataprovider.PermAny}
+	permissions["/sub"] = []string{dataprovider.PermListItems}
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:    userTestUsername,
+			Permissions: permissions,
+			HomeDir:     filepath.Clean(os.TempDir()),
+		},
+	}
+	conn := NewBaseConnection(xid.New().String(), ProtocolHTTP, "", "", user)
+	_, err := conn.GetArchiveStatus("/file.txt")
+	assert.ErrorIs(t, err, ErrOpUnsupported)
+	_, err = conn.RestoreArchivedObject("/file.txt", 2)
+	assert.ErrorIs(t, err, ErrOpUnsupported)
+	_, err = conn.RestoreArchivedObject("/file.txt", maxArchiveRestoreDays+1)
+	assert.Error(t, err)
+	_, err = conn.GetArchiveStatus("/sub/file.txt")
+	assert.ErrorIs(t, err, os.ErrPermission)
+	_, err = conn.RestoreArchivedObject("/sub/file.txt", 1)
+	assert.ErrorIs(t, err, os.ErrPermission)
+	err = conn.CheckArchivedObject(vfs.NewOsFs("", os.TempDir(), ""), filepath.Join(os.TempDir(), "file.txt"))
+	assert.NoError(t, err)
+
+	restores := ActiveArchiveRestores{}
+	for i := 0; i < maxPendingRestoresPerUser; i++ {
+		err = restores.add(userTestUsername, fmt.Sprintf("/file%d", i))
+		assert.NoError(t, err)
+	}
+	// adding an existing path is a no-op
+	err = restores.add(userTestUsername, "/file0")
+	assert.NoError(t, err)
+	err = restores.add(userTestUsername, "/file")
+	assert.ErrorIs(t, err, ErrTooManyPendingRestores)
+	assert.Len(t, restores.Get(userTestUsername), maxPendingRestoresPerUser)
+	assert.Len(t, restores.getUsernames(), 1)
+	// the user does not exist, the pending restores must be removed
+	restores.check()
+	assert.Len(t, restores.Get(userTestUsername), 0)
+	assert.Len(t, restores.getUsernames(), 0)
+
+	err = restores.add(userTestUsername, "/file")
+	assert.NoError(t, err)
+	restores.checkUserRestores(&user, restores.Get(userTestUsername))
+	assert.Len(t, restores.getUsernames(), 0)
+}
+
+func TestCustomMetadata(t *testing.T) {
+	permissions := make(map[string][]string)
+	permissions["/"] = []string{dataprovider.PermAny}
+	permissions["/sub"] = []string{dataprovider.PermListItems}
+	homeDir := filepath.Join(os.TempDir(), "metadata_home")
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:    userTestUsername,
+			Permissions: permissions,
+			HomeDir:     homeDir,
+		},
+	}
+	err := os.MkdirAll(filepath.Join(homeDir, "sub"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(homeDir, "file.txt"), []byte("content"), 0666)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(homeDir, "sub", "file.txt"), []byte("content"), 0666)
+	assert.NoError(t, err)
+
+	conn := NewBaseConnection(xid.New().String(), ProtocolHTTP, "", "", user)
+	err = conn.SetCustomMetadata("/file.txt", map[string]string{"sftpgo_key": "value"})
+	assert.Error(t, err)
+	err = conn.SetCustomMetadata("/file.txt", map[string]string{"Key": "value", "key": "value"})
+	assert.Error(t, err)
+	err = conn.SetCustomMetadata("/sub/file.txt", map[string]string{"key": "value"})
+	assert.ErrorIs(t, err, os.ErrPermission)
+	if runtime.GOOS == osWindows || runtime.GOOS == "darwin" {
+		_, err = conn.GetCustomMetadata("/file.txt")
+		assert.ErrorIs(t, err, ErrOpUnsupported)
+	} else {
+		err = conn.SetCustomMetadata("/missing.txt", map[string]string{"key": "value"})
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		err = conn.SetCustomMetadata("/file.txt", map[string]string{"Key1": "value1", "key2": "value2"})
+		assert.NoError(t, err)
+		metadata, err := conn.GetCustomMetadata("/file.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"key1": "value1", "key2": "value2"}, metadata)
+		err = conn.SetCustomMetadata("/file.txt", map[string]string{"key1": "", "key3": "value3"})
+		assert.NoError(t, err)
+		metadata, err = conn.GetCustomMetadata("/file.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"key2": "value2", "key3": "value3"}, metadata)
+		err = conn.SetCustomMetadata("/file.txt", map[string]string{"key4": strings.Repeat("a", 2048)})
+		assert.Error(t, err)
+		// the metadata are preserved on copy
+		err = conn.CopyFile("/file.txt", "/file_copy.txt", false)
+		assert.NoError(t, err)
+		metadata, err = conn.GetCustomMetadata("/file_copy.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"key2": "value2", "key3": "value3"}, metadata)
+		metadata, err = conn.GetCustomMetadata("/sub/file.txt")
+		assert.NoError(t, err)
+		assert.Len(t, metadata, 0)
+	}
+	// metadata are ignored if the setstat mode is 1
+	oldSetStatMode := Config.SetstatMode
+	Config.SetstatMode = 1
+	err = conn.handleSetCustomMetadata(vfs.NewOsFs("", homeDir, ""), filepath.Join(homeDir, "missing.txt"), "/",
+		map[string]string{"key": "value"})
+	assert.NoError(t, err)
+	Config.SetstatMode = 2
+	err = conn.handleSetCustomMetadata(newMockOsFs(false, "", homeDir, ""), filepath.Join(homeDir, "file.txt"), "/",
+		map[string]string{"key": "value"})
+	assert.NoError(t, err)
+	Config.SetstatMode = oldSetStatMode
+	err = conn.handleSetCustomMetadata(newMockOsFs(false, "", homeDir, ""), filepath.Join(homeDir, "file.txt"), "/",
+		map[string]string{"key": "value"})
+	assert.ErrorIs(t, err, ErrOpUnsupported)
+
+	err = os.RemoveAll(homeDir)
+	assert.NoError(t, err)
+}
+
 func TestCheckParentDirsErrors(t *testing.T) {
 	permissions := make(map[string][]string)
 	permissions["/"] = []string{dataprovider.PermAny}
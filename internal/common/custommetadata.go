@@ -0,0 +1,89 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"path"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// GetCustomMetadata returns the custom metadata for the file or directory
+// at the specified virtual path
+func (c *BaseConnection) GetCustomMetadata(virtualPath string) (map[string]string, error) {
+	if !c.User.HasAnyPerm([]string{dataprovider.PermListItems, dataprovider.PermDownload}, path.Dir(virtualPath)) {
+		return nil, c.GetPermissionDeniedError()
+	}
+	if ok, policy := c.User.IsFileAllowed(virtualPath); !ok {
+		c.Log(logger.LevelWarn, "reading the metadata for file %q is not allowed", virtualPath)
+		return nil, c.GetErrorForDeniedFile(policy)
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	handler, ok := fs.(vfs.FsCustomMetadataHandler)
+	if !ok {
+		return nil, c.GetOpUnsupportedError()
+	}
+	metadata, err := handler.GetCustomMetadata(fsPath)
+	if err != nil {
+		c.Log(logger.LevelDebug, "unable to get metadata for path %q: %+v", virtualPath, err)
+		return nil, c.GetFsError(handler, err)
+	}
+	return metadata, nil
+}
+
+// SetCustomMetadata updates the custom metadata for the file or directory at
+// the specified virtual path. Keys with an empty value are removed
+func (c *BaseConnection) SetCustomMetadata(virtualPath string, metadata map[string]string) error {
+	return c.SetStat(virtualPath, &StatAttributes{
+		Flags:    StatAttrMetadata,
+		Metadata: metadata,
+	})
+}
+
+func (c *BaseConnection) handleSetCustomMetadata(fs vfs.Fs, fsPath, pathForPerms string, metadata map[string]string) error {
+	if !c.User.HasPerm(dataprovider.PermOverwrite, pathForPerms) {
+		return c.GetPermissionDeniedError()
+	}
+	metadata, err := vfs.ValidateCustomMetadata(metadata)
+	if err != nil {
+		return err
+	}
+	if len(metadata) == 0 || Config.SetstatMode == 1 {
+		return nil
+	}
+	handler, ok := fs.(vfs.FsCustomMetadataHandler)
+	if !ok {
+		if Config.SetstatMode == 2 {
+			return nil
+		}
+		return c.GetOpUnsupportedError()
+	}
+	if err := handler.SetCustomMetadata(fsPath, metadata); err != nil {
+		if errors.Is(err, vfs.ErrVfsUnsupported) && Config.SetstatMode == 2 {
+			return nil
+		}
+		c.Log(logger.LevelError, "failed to set metadata for path %q: %+v", fsPath, err)
+		return c.GetFsError(fs, err)
+	}
+	logger.CommandLog(metadataLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "",
+		"", -1, c.localAddr, c.remoteAddr, c.impersonator)
+	return nil
+}
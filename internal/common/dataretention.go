@@ -369,7 +369,7 @@ func (c *RetentionCheck) sendEmailNotification(errCheck error) error {
 		})
 	}
 	var files []mail.File
-	f, err := params.getRetentionReportsAsMailAttachment()
+	f, err := params.getReportsAsMailAttachment(dataprovider.RetentionReportPlaceHolder)
 	if err != nil {
 		c.conn.Log(logger.LevelError, "unable to get retention report as mail attachment: %v", err)
 		return err
@@ -457,7 +457,7 @@ func (c *RetentionCheck) sendHookNotification(elapsed time.Duration, errCheck er
 	cmd := exec.CommandContext(ctx, Config.DataRetentionHook, args...)
 	cmd.Env = append(env,
 		fmt.Sprintf("SFTPGO_DATA_RETENTION_RESULT=%v", string(jsonData)))
-	err := cmd.Run()
+	err := command.Run(cmd, command.HookDataRetention)
 
 	c.conn.Log(logger.LevelDebug, "notified result using command: %v, elapsed: %v err: %v",
 		Config.DataRetentionHook, time.Since(startTime), err)
@@ -0,0 +1,126 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// ReplayDeadLetter sends again the dead letter with the specified ID.
+// The dead letter is removed if the delivery succeeds
+func ReplayDeadLetter(id int64) error {
+	entry, err := dataprovider.GetDeadLetter(id)
+	if err != nil {
+		return err
+	}
+	startTime := time.Now()
+	var statusCode int
+	switch entry.Source {
+	case dataprovider.DeadLetterSourceEventAction:
+		statusCode, err = replayEventActionDeadLetter(&entry)
+	default:
+		statusCode, err = replayHookDeadLetter(&entry)
+	}
+	if err != nil {
+		logger.Debug(logSender, "", "unable to replay dead letter %d, source %q, name %q, elapsed: %s, err: %v",
+			entry.ID, entry.Source, entry.Name, time.Since(startTime), err)
+		return err
+	}
+	logger.Debug(logSender, "", "dead letter %d replayed, source %q, name %q, elapsed: %s, status code: %d",
+		entry.ID, entry.Source, entry.Name, time.Since(startTime), statusCode)
+	if statusCode < http.StatusOK || statusCode > http.StatusNoContent {
+		return fmt.Errorf("unexpected status code: %d", statusCode)
+	}
+	return dataprovider.DeleteDeadLetter(entry.ID)
+}
+
+// replayHookDeadLetter sends the dead letter using the global HTTP clients
+// configuration, as for the hooks
+func replayHookDeadLetter(entry *dataprovider.DeadLetter) (int, error) {
+	resp, err := httpclient.Post(entry.URL, getDeadLetterHeader(entry, "Content-Type"), strings.NewReader(entry.Body))
+	if err != nil {
+		return 0, fmt.Errorf("error sending HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+// replayEventActionDeadLetter sends the dead letter using the HTTP settings,
+// password and signing secret, of the event action that generated it
+func replayEventActionDeadLetter(entry *dataprovider.DeadLetter) (int, error) {
+	action, err := dataprovider.EventActionExists(entry.Name)
+	if err != nil {
+		return 0, util.NewValidationError(fmt.Sprintf("unable to replay dead letter, event action %q: %v", entry.Name, err))
+	}
+	if action.Type != dataprovider.ActionTypeHTTP {
+		return 0, util.NewValidationError(fmt.Sprintf("unable to replay dead letter, event action %q is not an HTTP action",
+			entry.Name))
+	}
+	c := action.Options.HTTPConfig
+	if err := c.TryDecryptPassword(); err != nil {
+		return 0, err
+	}
+	if err := c.TryDecryptSigningSecret(); err != nil {
+		return 0, err
+	}
+	ctx, cancel := c.GetContext()
+	defer cancel()
+
+	var body io.Reader
+	if entry.Body != "" {
+		body = strings.NewReader(entry.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, entry.Method, entry.URL, body)
+	if err != nil {
+		return 0, err
+	}
+	for _, keyVal := range entry.Headers {
+		req.Header.Set(keyVal.Key, keyVal.Value)
+	}
+	if entry.Username != "" {
+		req.SetBasicAuth(entry.Username, c.Password.GetPayload())
+	}
+	signHTTPRuleActionRequest(c, req, httpclient.GetPayloadHash([]byte(entry.Body)))
+
+	client := c.GetHTTPClient()
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error sending HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+func getDeadLetterHeader(entry *dataprovider.DeadLetter, key string) string {
+	for _, keyVal := range entry.Headers {
+		if http.CanonicalHeaderKey(keyVal.Key) == key {
+			return keyVal.Value
+		}
+	}
+	return ""
+}
@@ -47,6 +47,10 @@ const (
 	DefenderDriverProvider = "provider"
 )
 
+const (
+	defenderRolloutSeed = "defender"
+)
+
 var (
 	supportedDefenderDrivers = []string{DefenderDriverMemory, DefenderDriverProvider}
 )
@@ -106,6 +110,14 @@ type DefenderConfig struct {
 	// List of IP addresses and/or networks to always ban.
 	// For large lists prefer BlockListFile
 	BlockList []string `json:"blocklist" mapstructure:"blocklist"`
+	// Rollout allows to enforce the automatic bans only on a subset of cluster
+	// nodes and/or for a percentage of the client IP addresses. The events for
+	// the other hosts are still tracked, so you can check the defender behavior
+	// before the full activation. Safe and block lists are always enforced
+	Rollout dataprovider.Rollout `json:"rollout" mapstructure:"rollout"`
+	// External IP reputation feeds to periodically download and merge into the
+	// deny list
+	Feeds []DefenderFeed `json:"feeds" mapstructure:"feeds"`
 }
 
 type baseDefender struct {
@@ -145,11 +157,27 @@ func (d *baseDefender) isBanned(ip string) bool {
 		// permanent ban
 		return true
 	}
+	if d.safeList != nil && d.safeList.isListed(ip) {
+		return false
+	}
+
+	return defenderFeeds.getScore(ip) >= d.config.Threshold
+}
+
+// isEnforced returns false if the automatic bans for the specified IP are not
+// enforced on this node because of a staged rollout
+func (d *baseDefender) isEnforced(ip string) bool {
+	return d.config.Rollout.IsActive(defenderRolloutSeed, ip)
+}
 
-	return false
+func (d *baseDefender) logNotEnforcedBan(ip string) {
+	logger.Info(logSender, "", "host %q exceeded the defender threshold, the ban is not enforced: the host is not included in the rollout",
+		ip)
 }
 
-func (d *baseDefender) getScore(event HostEvent) int {
+// getScore returns the score for the specified event, the reputation score
+// from the external feeds, if any, is added
+func (d *baseDefender) getScore(ip string, event HostEvent) int {
 	var score int
 
 	switch event {
@@ -160,7 +188,22 @@ func (d *baseDefender) getScore(event HostEvent) int {
 	case HostEventUserNotFound, HostEventNoLoginTried:
 		score = d.config.ScoreInvalid
 	}
-	return score
+	return score + defenderFeeds.getScore(ip)
+}
+
+func getHostEventName(event HostEvent) string {
+	switch event {
+	case HostEventLoginFailed:
+		return "login_failed"
+	case HostEventUserNotFound:
+		return "user_not_found"
+	case HostEventNoLoginTried:
+		return "no_login_tried"
+	case HostEventLimitExceeded:
+		return "limit_exceeded"
+	default:
+		return "unknown"
+	}
 }
 
 // HostListFile defines the structure expected for safe/block list files
@@ -227,6 +270,9 @@ func (c *DefenderConfig) validate() error {
 	if c.EntriesHardLimit <= c.EntriesSoftLimit {
 		return fmt.Errorf("invalid entries_hard_limit %v must be > %v", c.EntriesHardLimit, c.EntriesSoftLimit)
 	}
+	if err := c.Rollout.Validate(); err != nil {
+		return fmt.Errorf("invalid defender rollout: %w", err)
+	}
 
 	return nil
 }
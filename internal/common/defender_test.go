@@ -20,6 +20,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -29,6 +31,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/yl2chen/cidranger"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 )
 
 func TestBasicDefender(t *testing.T) {
@@ -713,3 +717,180 @@ func inc(ip net.IP) {
 		}
 	}
 }
+
+func TestDefenderRollout(t *testing.T) {
+	config := &DefenderConfig{
+		Enabled:          true,
+		BanTime:          10,
+		BanTimeIncrement: 2,
+		Threshold:        5,
+		ScoreInvalid:     2,
+		ScoreValid:       1,
+		ObservationTime:  15,
+		EntriesSoftLimit: 10,
+		EntriesHardLimit: 20,
+		Rollout: dataprovider.Rollout{
+			Nodes: []string{" other_node ", "other_node"},
+		},
+	}
+	err := config.validate()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other_node"}, config.Rollout.Nodes)
+
+	d, err := newInMemoryDefender(config)
+	require.NoError(t, err)
+	defender := d.(*memoryDefender)
+
+	testIP := "12.34.56.79"
+	for i := 0; i < 3; i++ {
+		defender.AddEvent(testIP, HostEventUserNotFound)
+	}
+	// the host is banned but the ban is not enforced on this node
+	assert.Equal(t, 1, defender.countBanned())
+	assert.False(t, defender.IsBanned(testIP))
+
+	defender.config.Rollout.Nodes = nil
+	assert.True(t, defender.IsBanned(testIP))
+
+	config.Rollout.Percentage = 101
+	err = config.validate()
+	assert.ErrorContains(t, err, "invalid defender rollout")
+	config.Rollout.Percentage = 100
+	err = config.validate()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, config.Rollout.Percentage)
+}
+
+func TestDefenderFeeds(t *testing.T) {
+	const apiKey = "abuseipdb_key"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/drop.txt":
+			fmt.Fprint(w, "; Spamhaus DROP List\n1.10.16.0/20 ; SBL256894\n# comment\n\n1.2.3.4\ninvalid\n5.6.7.8/33\n")
+		case "/blacklist":
+			if r.Header.Get("Key") != apiKey {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, "10.8.0.1\n10.8.0.2\n")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	feeds := []DefenderFeed{
+		{
+			Name:            "drop",
+			URL:             server.URL + "/drop.txt",
+			Format:          DefenderFeedFormatCIDR,
+			RefreshInterval: 60,
+			Score:           20,
+		},
+		{
+			Name:            "abuseipdb",
+			URL:             server.URL + "/blacklist",
+			Format:          DefenderFeedFormatAbuseIPDB,
+			APIKey:          apiKey,
+			RefreshInterval: 60,
+			Score:           3,
+			TTL:             120,
+		},
+	}
+	for _, f := range []DefenderFeed{
+		{},
+		{Name: "feed", URL: "ftp://example.com"},
+		{Name: "feed", URL: server.URL, Format: "unknown"},
+		{Name: "feed", URL: server.URL, Format: DefenderFeedFormatAbuseIPDB},
+		{Name: "feed", URL: server.URL, Format: DefenderFeedFormatCIDR},
+		{Name: "feed", URL: server.URL, Format: DefenderFeedFormatCIDR, RefreshInterval: 10},
+		{Name: "feed", URL: server.URL, Format: DefenderFeedFormatCIDR, RefreshInterval: 10, Score: 1, TTL: -1},
+		{Name: "feed", URL: server.URL, Format: DefenderFeedFormatCIDR, RefreshInterval: 10, Score: 1, TTL: 5},
+	} {
+		err := f.validate()
+		assert.Error(t, err, "feed %+v", f)
+	}
+	_, err := newDefenderFeedsManager([]DefenderFeed{feeds[0], feeds[0]})
+	assert.ErrorContains(t, err, "duplicated")
+	m, err := newDefenderFeedsManager(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, m)
+	assert.Equal(t, 0, m.getScore("1.2.3.4"))
+
+	m, err = newDefenderFeedsManager(feeds)
+	require.NoError(t, err)
+	for _, f := range m.feeds {
+		err = f.refresh()
+		assert.NoError(t, err)
+	}
+	status := m.getStatus()
+	if assert.Len(t, status, 2) {
+		assert.Equal(t, "drop", status[0].Name)
+		assert.Equal(t, 1, status[0].IPAddresses)
+		assert.Equal(t, 1, status[0].Networks)
+		assert.Greater(t, status[0].LastUpdate, int64(0))
+		assert.Equal(t, int64(0), status[0].ExpiresAt)
+		assert.Equal(t, 2, status[1].IPAddresses)
+		assert.Equal(t, 0, status[1].Networks)
+		assert.Greater(t, status[1].ExpiresAt, status[1].LastUpdate)
+		assert.False(t, status[1].Expired)
+	}
+	assert.Equal(t, 20, m.getScore("1.10.16.5"))
+	assert.Equal(t, 20, m.getScore("1.2.3.4"))
+	assert.Equal(t, 3, m.getScore("10.8.0.2"))
+	assert.Equal(t, 0, m.getScore("10.8.0.3"))
+
+	defenderFeeds = m
+	defer func() {
+		defenderFeeds = nil
+	}()
+
+	config := &DefenderConfig{
+		Enabled:          true,
+		BanTime:          10,
+		BanTimeIncrement: 2,
+		Threshold:        8,
+		ScoreInvalid:     2,
+		ScoreValid:       1,
+		ObservationTime:  15,
+		EntriesSoftLimit: 10,
+		EntriesHardLimit: 20,
+		SafeList:         []string{"1.2.3.4"},
+	}
+	d, err := newInMemoryDefender(config)
+	require.NoError(t, err)
+	defender := d.(*memoryDefender)
+	// hosts listed with a score above the threshold are always banned
+	assert.True(t, defender.IsBanned("1.10.16.5"))
+	// the safe list has precedence
+	assert.False(t, defender.IsBanned("1.2.3.4"))
+	// a lower score is added to the events score
+	assert.False(t, defender.IsBanned("10.8.0.1"))
+	defender.AddEvent("10.8.0.1", HostEventUserNotFound)
+	score, err := defender.GetScore("10.8.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, score)
+	defender.AddEvent("10.8.0.1", HostEventUserNotFound)
+	assert.True(t, defender.IsBanned("10.8.0.1"))
+	defender.AddEvent("10.8.0.3", HostEventUserNotFound)
+	score, err = defender.GetScore("10.8.0.3")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, score)
+
+	// a failed refresh preserves the previous entries until they expire
+	abuseFeed := m.feeds[1]
+	abuseFeed.config.APIKey = "invalid"
+	err = abuseFeed.refresh()
+	assert.ErrorContains(t, err, "unexpected status code")
+	assert.Equal(t, 3, m.getScore("10.8.0.2"))
+	abuseFeed.mu.Lock()
+	abuseFeed.lastUpdate = time.Now().Add(-3 * time.Hour)
+	abuseFeed.mu.Unlock()
+	assert.Equal(t, 0, m.getScore("10.8.0.2"))
+	status = m.getStatus()
+	if assert.Len(t, status, 2) {
+		assert.True(t, status[1].Expired)
+		assert.Contains(t, status[1].LastError, "unexpected status code")
+		assert.Equal(t, 2, status[1].IPAddresses)
+	}
+}
@@ -67,6 +67,9 @@ func (d *dbDefender) IsBanned(ip string) bool {
 	}
 	d.RUnlock()
 
+	if !d.isEnforced(ip) {
+		return false
+	}
 	_, err := dataprovider.IsDefenderHostBanned(ip)
 	if err != nil {
 		// not found or another error, we allow this host
@@ -98,7 +101,7 @@ func (d *dbDefender) AddEvent(ip string, event HostEvent) {
 	}
 	d.RUnlock()
 
-	score := d.baseDefender.getScore(event)
+	score := d.baseDefender.getScore(ip, event)
 
 	host, err := dataprovider.AddDefenderEvent(ip, score, d.getStartObservationTime())
 	if err != nil {
@@ -108,12 +111,18 @@ func (d *dbDefender) AddEvent(ip string, event HostEvent) {
 		banTime := time.Now().Add(time.Duration(d.config.BanTime) * time.Minute)
 		err = dataprovider.SetDefenderBanTime(ip, util.GetTimeAsMsSinceEpoch(banTime))
 		if err == nil {
-			eventManager.handleIPBlockedEvent(EventParams{
-				Event:     ipBlockedEventName,
-				IP:        ip,
-				Timestamp: time.Now().UnixNano(),
-				Status:    1,
-			})
+			if d.isEnforced(ip) {
+				summaryReports.addBan(ip)
+				logger.SyslogDefenderEvent(ip, getHostEventName(event), banTime)
+				eventManager.handleIPBlockedEvent(EventParams{
+					Event:     ipBlockedEventName,
+					IP:        ip,
+					Timestamp: time.Now().UnixNano(),
+					Status:    1,
+				})
+			} else {
+				d.logNotEnforcedBan(ip)
+			}
 		}
 	}
 
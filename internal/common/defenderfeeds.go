@@ -0,0 +1,343 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yl2chen/cidranger"
+
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/jobs"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported defender feed formats
+const (
+	// plain text list of IP addresses and/or CIDR networks, one per line.
+	// Anything after "#" or ";" is considered a comment. This format is
+	// compatible with lists such as Spamhaus DROP
+	DefenderFeedFormatCIDR = "cidr"
+	// AbuseIPDB blacklist API, the API key is sent using the "Key" header
+	// and the list is requested in plain text format
+	DefenderFeedFormatAbuseIPDB = "abuseipdb"
+)
+
+const (
+	defenderFeedJobPrefix = "defender_feed_"
+	// opinionated max size for a feed response
+	defenderFeedMaxSize = 20 * 1048576
+)
+
+var (
+	supportedDefenderFeedFormats = []string{DefenderFeedFormatCIDR, DefenderFeedFormatAbuseIPDB}
+	// defenderFeeds holds the configured external reputation feeds, nil if none
+	defenderFeeds *defenderFeedsManager
+)
+
+// DefenderFeed defines an external IP reputation feed. The listed hosts are
+// merged into the defender deny list
+type DefenderFeed struct {
+	// Unique feed name
+	Name string `json:"name" mapstructure:"name"`
+	// URL to download the feed from
+	URL string `json:"url" mapstructure:"url"`
+	// Feed format, supported values: "cidr", "abuseipdb"
+	Format string `json:"format" mapstructure:"format"`
+	// API key, required for the "abuseipdb" format
+	APIKey string `json:"api_key" mapstructure:"api_key"`
+	// Interval, as minutes, between two feed downloads
+	RefreshInterval int `json:"refresh_interval" mapstructure:"refresh_interval"`
+	// Score to assign to the listed hosts. Hosts with a score greater than or
+	// equal to the defender threshold are always banned, a lower score is added
+	// to the score of each event generated by the listed hosts so they are banned
+	// sooner
+	Score int `json:"score" mapstructure:"score"`
+	// Time, as minutes, after which the downloaded entries are ignored if the feed
+	// cannot be refreshed. 0 means the entries never expire
+	TTL int `json:"ttl" mapstructure:"ttl"`
+}
+
+func (f *DefenderFeed) validate() error {
+	if f.Name == "" {
+		return errors.New("defender feed name is required")
+	}
+	if !strings.HasPrefix(f.URL, "http") {
+		return fmt.Errorf("defender feed %q: invalid URL %q", f.Name, f.URL)
+	}
+	if !util.Contains(supportedDefenderFeedFormats, f.Format) {
+		return fmt.Errorf("defender feed %q: unsupported format %q", f.Name, f.Format)
+	}
+	if f.Format == DefenderFeedFormatAbuseIPDB && f.APIKey == "" {
+		return fmt.Errorf("defender feed %q: the API key is required for format %q", f.Name, f.Format)
+	}
+	if f.RefreshInterval <= 0 {
+		return fmt.Errorf("defender feed %q: invalid refresh interval %d", f.Name, f.RefreshInterval)
+	}
+	if f.Score <= 0 {
+		return fmt.Errorf("defender feed %q: invalid score %d", f.Name, f.Score)
+	}
+	if f.TTL < 0 {
+		return fmt.Errorf("defender feed %q: invalid TTL %d", f.Name, f.TTL)
+	}
+	if f.TTL > 0 && f.TTL < f.RefreshInterval {
+		return fmt.Errorf("defender feed %q: the TTL %d cannot be lower than the refresh interval %d",
+			f.Name, f.TTL, f.RefreshInterval)
+	}
+	return nil
+}
+
+// DefenderFeedStatus defines the status of an external reputation feed
+type DefenderFeedStatus struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	Score  int    `json:"score"`
+	// number of IP addresses and networks loaded from the last successful download
+	IPAddresses int `json:"ip_addresses"`
+	Networks    int `json:"networks"`
+	// last successful download as unix timestamp in milliseconds
+	LastUpdate int64 `json:"last_update,omitempty"`
+	// last download attempt as unix timestamp in milliseconds
+	LastCheck int64  `json:"last_check,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+	// expiration for the loaded entries as unix timestamp in milliseconds
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	Expired   bool  `json:"expired"`
+}
+
+type defenderFeed struct {
+	config DefenderFeed
+
+	mu          sync.RWMutex
+	list        *HostList
+	ipAddresses int
+	networks    int
+	lastUpdate  time.Time
+	lastCheck   time.Time
+	lastError   error
+}
+
+func (f *defenderFeed) getExpiration() time.Time {
+	if f.config.TTL == 0 || f.lastUpdate.IsZero() {
+		return time.Time{}
+	}
+	return f.lastUpdate.Add(time.Duration(f.config.TTL) * time.Minute)
+}
+
+func (f *defenderFeed) isExpired() bool {
+	expiresAt := f.getExpiration()
+	return !expiresAt.IsZero() && expiresAt.Before(time.Now())
+}
+
+func (f *defenderFeed) isListed(ip string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.list == nil || f.isExpired() {
+		return false
+	}
+	return f.list.isListed(ip)
+}
+
+func (f *defenderFeed) getStatus() DefenderFeedStatus {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	status := DefenderFeedStatus{
+		Name:        f.config.Name,
+		Format:      f.config.Format,
+		Score:       f.config.Score,
+		IPAddresses: f.ipAddresses,
+		Networks:    f.networks,
+		Expired:     f.isExpired(),
+	}
+	if !f.lastUpdate.IsZero() {
+		status.LastUpdate = util.GetTimeAsMsSinceEpoch(f.lastUpdate)
+	}
+	if !f.lastCheck.IsZero() {
+		status.LastCheck = util.GetTimeAsMsSinceEpoch(f.lastCheck)
+	}
+	if f.lastError != nil {
+		status.LastError = f.lastError.Error()
+	}
+	if expiresAt := f.getExpiration(); !expiresAt.IsZero() {
+		status.ExpiresAt = util.GetTimeAsMsSinceEpoch(expiresAt)
+	}
+	return status
+}
+
+// refresh downloads the feed and replaces the loaded entries. On error the
+// previous entries are preserved until they expire
+func (f *defenderFeed) refresh() error {
+	startTime := time.Now()
+	list, ipAddresses, networks, err := f.download()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lastCheck = time.Now()
+	f.lastError = err
+	if err != nil {
+		logger.Warn(logSender, "", "unable to refresh defender feed %q: %v", f.config.Name, err)
+		return err
+	}
+	f.list = list
+	f.ipAddresses = ipAddresses
+	f.networks = networks
+	f.lastUpdate = f.lastCheck
+	logger.Info(logSender, "", "defender feed %q refreshed, ip addresses: %d, networks: %d, elapsed: %s",
+		f.config.Name, ipAddresses, networks, time.Since(startTime))
+	return nil
+}
+
+func (f *defenderFeed) download() (*HostList, int, int, error) {
+	req, err := http.NewRequest(http.MethodGet, f.config.URL, nil)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if f.config.Format == DefenderFeedFormatAbuseIPDB {
+		req.Header.Set("Key", f.config.APIKey)
+		req.Header.Set("Accept", "text/plain")
+	}
+	resp, err := httpclient.GetHTTPClient().Do(req)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return parseDefenderFeed(io.LimitReader(resp.Body, defenderFeedMaxSize))
+}
+
+// parseDefenderFeed parses a plain text list with an IP address or a CIDR
+// network per line. Invalid lines are ignored
+func parseDefenderFeed(r io.Reader) (*HostList, int, int, error) {
+	list := &HostList{
+		IPAddresses: make(map[string]bool),
+		Ranges:      cidranger.NewPCTrieRanger(),
+	}
+	ipAddresses := 0
+	networks := 0
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.IndexAny(line, "#;"); idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		entry := fields[0]
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				continue
+			}
+			if err := list.Ranges.Insert(cidranger.NewBasicRangerEntry(*network)); err == nil {
+				networks++
+			}
+			continue
+		}
+		if net.ParseIP(entry) == nil {
+			continue
+		}
+		list.IPAddresses[entry] = true
+		ipAddresses++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+	return list, ipAddresses, networks, nil
+}
+
+type defenderFeedsManager struct {
+	feeds []*defenderFeed
+}
+
+// getScore returns the highest score of the feeds listing the specified IP
+func (m *defenderFeedsManager) getScore(ip string) int {
+	if m == nil {
+		return 0
+	}
+	var score int
+	for _, f := range m.feeds {
+		if f.config.Score > score && f.isListed(ip) {
+			score = f.config.Score
+		}
+	}
+	return score
+}
+
+func (m *defenderFeedsManager) getStatus() []DefenderFeedStatus {
+	result := make([]DefenderFeedStatus, 0, len(m.feeds))
+	for _, f := range m.feeds {
+		result = append(result, f.getStatus())
+	}
+	return result
+}
+
+func newDefenderFeedsManager(feeds []DefenderFeed) (*defenderFeedsManager, error) {
+	if len(feeds) == 0 {
+		return nil, nil
+	}
+	names := make(map[string]bool)
+	m := &defenderFeedsManager{}
+	for _, cfg := range feeds {
+		if err := cfg.validate(); err != nil {
+			return nil, err
+		}
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("defender feed %q is duplicated", cfg.Name)
+		}
+		names[cfg.Name] = true
+		m.feeds = append(m.feeds, &defenderFeed{config: cfg})
+	}
+	return m, nil
+}
+
+// startDefenderFeeds schedules the periodic refresh of the configured feeds.
+// The first download is done in the background so a slow or unreachable feed
+// does not delay the service startup
+func startDefenderFeeds(feeds []DefenderFeed) error {
+	defenderFeeds = nil
+	m, err := newDefenderFeedsManager(feeds)
+	if err != nil || m == nil {
+		return err
+	}
+	for _, f := range m.feeds {
+		spec := fmt.Sprintf("@every %dm", f.config.RefreshInterval)
+		if err := jobs.Add(eventScheduler, defenderFeedJobPrefix+f.config.Name, spec, f.refresh); err != nil {
+			return err
+		}
+		go f.refresh() //nolint:errcheck
+		logger.Info(logSender, "", "scheduled defender feed %q, format %q, schedule %q", f.config.Name,
+			f.config.Format, spec)
+	}
+	defenderFeeds = m
+	return nil
+}
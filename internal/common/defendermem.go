@@ -19,6 +19,7 @@ import (
 	"time"
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
@@ -89,7 +90,7 @@ func (d *memoryDefender) GetHost(ip string) (dataprovider.DefenderEntry, error)
 	d.RLock()
 	defer d.RUnlock()
 
-	if banTime, ok := d.banned[ip]; ok {
+	if banTime, ok := d.banned[ip]; ok && d.isEnforced(ip) {
 		if banTime.After(time.Now()) {
 			return dataprovider.DefenderEntry{
 				IP:      ip,
@@ -122,7 +123,7 @@ func (d *memoryDefender) GetHost(ip string) (dataprovider.DefenderEntry, error)
 func (d *memoryDefender) IsBanned(ip string) bool {
 	d.RLock()
 
-	if banTime, ok := d.banned[ip]; ok {
+	if banTime, ok := d.banned[ip]; ok && d.isEnforced(ip) {
 		if banTime.After(time.Now()) {
 			increment := d.config.BanTime * d.config.BanTimeIncrement / 100
 			if increment == 0 {
@@ -184,7 +185,7 @@ func (d *memoryDefender) AddEvent(ip string, event HostEvent) {
 		delete(d.banned, ip)
 	}
 
-	score := d.baseDefender.getScore(event)
+	score := d.baseDefender.getScore(ip, event)
 
 	ev := hostEvent{
 		dateTime: time.Now(),
@@ -209,12 +210,18 @@ func (d *memoryDefender) AddEvent(ip string, event HostEvent) {
 			d.banned[ip] = time.Now().Add(time.Duration(d.config.BanTime) * time.Minute)
 			delete(d.hosts, ip)
 			d.cleanupBanned()
-			eventManager.handleIPBlockedEvent(EventParams{
-				Event:     ipBlockedEventName,
-				IP:        ip,
-				Timestamp: time.Now().UnixNano(),
-				Status:    1,
-			})
+			if d.isEnforced(ip) {
+				summaryReports.addBan(ip)
+				logger.SyslogDefenderEvent(ip, getHostEventName(event), d.banned[ip])
+				eventManager.handleIPBlockedEvent(EventParams{
+					Event:     ipBlockedEventName,
+					IP:        ip,
+					Timestamp: time.Now().UnixNano(),
+					Status:    1,
+				})
+			} else {
+				d.logNotEnforcedBan(ip)
+			}
 		} else {
 			d.hosts[ip] = hs
 		}
@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -29,11 +30,13 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/klauspost/compress/zip"
 	"github.com/robfig/cron/v3"
@@ -41,8 +44,11 @@ import (
 	"github.com/sftpgo/sdk"
 	mail "github.com/xhit/go-simple-mail/v2"
 
+	"github.com/drakkan/sftpgo/v2/internal/command"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/mq"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
@@ -50,14 +56,32 @@ import (
 )
 
 const (
-	ipBlockedEventName = "IP Blocked"
-	maxAttachmentsSize = int64(10 * 1024 * 1024)
+	ipBlockedEventName      = "IP Blocked"
+	onDemandEventName       = "On demand"
+	maxOnDemandPlaceholders = 50
+	// maximum size of the output of an action usable by the next actions
+	maxActionOutputSize = 32 * 1024
+)
+
+// Status of executed actions, usable in action conditions using the
+// {{ActionStatus.<action name>}} placeholder
+const (
+	actionStatusOK      = "OK"
+	actionStatusKO      = "KO"
+	actionStatusSkipped = "SKIPPED"
 )
 
 var (
 	// eventManager handle the supported event rules actions
-	eventManager          eventRulesContainer
-	multipartQuoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+	eventManager             eventRulesContainer
+	multipartQuoteEscaper    = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+	onDemandPlaceholderRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+	// wait times between the HTTP actions retries
+	httpActionRetryWaitMin = time.Second
+	httpActionRetryWaitMax = 30 * time.Second
+	// maximum time for the HTTP actions retries executed synchronously, the
+	// connection that triggered the event is blocked while waiting
+	httpActionSyncRetryMaxTime = 10 * time.Second
 )
 
 func init() {
@@ -78,10 +102,23 @@ func init() {
 				IP:         ip,
 				Timestamp:  time.Now().UnixNano(),
 				Object:     object,
+				Metadata:   getObjectMetadata(object),
 			})
 		})
 }
 
+// metadataGetter is implemented by the provider objects supporting custom metadata
+type metadataGetter interface {
+	GetMetadata() util.Metadata
+}
+
+func getObjectMetadata(object plugin.Renderer) util.Metadata {
+	if m, ok := object.(metadataGetter); ok {
+		return m.GetMetadata()
+	}
+	return nil
+}
+
 // HandleCertificateEvent checks and executes action rules for certificate events
 func HandleCertificateEvent(params EventParams) {
 	eventManager.handleCertificateEvent(params)
@@ -96,8 +133,10 @@ type eventRulesContainer struct {
 	Schedules         []dataprovider.EventRule
 	IPBlockedEvents   []dataprovider.EventRule
 	CertificateEvents []dataprovider.EventRule
-	schedulesMapping  map[string][]cron.EntryID
-	concurrencyGuard  chan struct{}
+	// ResourceLimitsEvents are triggered when the process resource limits are exceeded or restored
+	ResourceLimitsEvents []dataprovider.EventRule
+	schedulesMapping     map[string][]cron.EntryID
+	concurrencyGuard     chan struct{}
 }
 
 func (r *eventRulesContainer) addAsyncTask() {
@@ -163,6 +202,15 @@ func (r *eventRulesContainer) removeRuleInternal(name string) {
 			return
 		}
 	}
+	for idx := range r.ResourceLimitsEvents {
+		if r.ResourceLimitsEvents[idx].Name == name {
+			lastIdx := len(r.ResourceLimitsEvents) - 1
+			r.ResourceLimitsEvents[idx] = r.ResourceLimitsEvents[lastIdx]
+			r.ResourceLimitsEvents = r.ResourceLimitsEvents[:lastIdx]
+			eventManagerLog(logger.LevelDebug, "removed rule %q from resource limits events", name)
+			return
+		}
+	}
 	for idx := range r.Schedules {
 		if r.Schedules[idx].Name == name {
 			if schedules, ok := r.schedulesMapping[name]; ok {
@@ -205,6 +253,12 @@ func (r *eventRulesContainer) addUpdateRuleInternal(rule dataprovider.EventRule)
 	case dataprovider.EventTriggerCertificate:
 		r.CertificateEvents = append(r.CertificateEvents, rule)
 		eventManagerLog(logger.LevelDebug, "added rule %q to certificate events", rule.Name)
+	case dataprovider.EventTriggerResourceLimits:
+		r.ResourceLimitsEvents = append(r.ResourceLimitsEvents, rule)
+		eventManagerLog(logger.LevelDebug, "added rule %q to resource limits events", rule.Name)
+	case dataprovider.EventTriggerOnDemand:
+		// on demand rules are loaded from the data provider when executed
+		eventManagerLog(logger.LevelDebug, "rule %q can be executed on demand", rule.Name)
 	case dataprovider.EventTriggerSchedule:
 		for _, schedule := range rule.Conditions.Schedules {
 			cronSpec := schedule.GetCronSpec()
@@ -245,8 +299,9 @@ func (r *eventRulesContainer) loadRules() {
 			r.addUpdateRuleInternal(rule)
 		}
 	}
-	eventManagerLog(logger.LevelDebug, "event rules updated, fs events: %d, provider events: %d, schedules: %d, ip blocked events: %d, certificate events: %d",
-		len(r.FsEvents), len(r.ProviderEvents), len(r.Schedules), len(r.IPBlockedEvents), len(r.CertificateEvents))
+	eventManagerLog(logger.LevelDebug, "event rules updated, fs events: %d, provider events: %d, schedules: %d, ip blocked events: %d, certificate events: %d, resource limits events: %d",
+		len(r.FsEvents), len(r.ProviderEvents), len(r.Schedules), len(r.IPBlockedEvents), len(r.CertificateEvents),
+		len(r.ResourceLimitsEvents))
 
 	r.setLastLoadTime(modTime)
 }
@@ -261,7 +316,7 @@ func (r *eventRulesContainer) checkProviderEventMatch(conditions dataprovider.Ev
 	if len(conditions.Options.ProviderObjects) > 0 && !util.Contains(conditions.Options.ProviderObjects, params.ObjectType) {
 		return false
 	}
-	return true
+	return checkEventCountryCondition(params.IP, conditions.Options.Countries)
 }
 
 func (r *eventRulesContainer) checkFsEventMatch(conditions dataprovider.EventConditions, params EventParams) bool {
@@ -282,7 +337,10 @@ func (r *eventRulesContainer) checkFsEventMatch(conditions dataprovider.EventCon
 	if len(conditions.Options.Protocols) > 0 && !util.Contains(conditions.Options.Protocols, params.Protocol) {
 		return false
 	}
-	if params.Event == operationUpload || params.Event == operationDownload {
+	if !checkEventCountryCondition(params.IP, conditions.Options.Countries) {
+		return false
+	}
+	if params.Event == OperationUpload || params.Event == operationDownload {
 		if conditions.Options.MinFileSize > 0 {
 			if params.FileSize < conditions.Options.MinFileSize {
 				return false
@@ -314,7 +372,7 @@ func (r *eventRulesContainer) handleFsEvent(params EventParams) error {
 
 	var rulesWithSyncActions, rulesAsync []dataprovider.EventRule
 	for _, rule := range r.FsEvents {
-		if r.checkFsEventMatch(rule.Conditions, params) {
+		if r.checkFsEventMatch(rule.Conditions, params) && rule.IsActiveFor(params.Name) {
 			if err := rule.CheckActionsConsistency(""); err != nil {
 				eventManagerLog(logger.LevelWarn, "rule %q skipped: %v, event %q",
 					rule.Name, err, params.Event)
@@ -355,7 +413,7 @@ func (r *eventRulesContainer) handleProviderEvent(params EventParams) {
 
 	var rules []dataprovider.EventRule
 	for _, rule := range r.ProviderEvents {
-		if r.checkProviderEventMatch(rule.Conditions, params) {
+		if r.checkProviderEventMatch(rule.Conditions, params) && rule.IsActiveFor(params.Name) {
 			if err := rule.CheckActionsConsistency(params.ObjectType); err == nil {
 				rules = append(rules, rule)
 			} else {
@@ -380,6 +438,9 @@ func (r *eventRulesContainer) handleIPBlockedEvent(params EventParams) {
 	}
 	var rules []dataprovider.EventRule
 	for _, rule := range r.IPBlockedEvents {
+		if !rule.IsActiveFor("") {
+			continue
+		}
 		if err := rule.CheckActionsConsistency(""); err == nil {
 			rules = append(rules, rule)
 		} else {
@@ -402,6 +463,34 @@ func (r *eventRulesContainer) handleCertificateEvent(params EventParams) {
 	}
 	var rules []dataprovider.EventRule
 	for _, rule := range r.CertificateEvents {
+		if !rule.IsActiveFor("") {
+			continue
+		}
+		if err := rule.CheckActionsConsistency(""); err == nil {
+			rules = append(rules, rule)
+		} else {
+			eventManagerLog(logger.LevelWarn, "rule %q skipped: %v, event %q",
+				rule.Name, err, params.Event)
+		}
+	}
+
+	if len(rules) > 0 {
+		go executeAsyncRulesActions(rules, params)
+	}
+}
+
+func (r *eventRulesContainer) handleResourceLimitsEvent(params EventParams) {
+	r.RLock()
+	defer r.RUnlock()
+
+	if len(r.ResourceLimitsEvents) == 0 {
+		return
+	}
+	var rules []dataprovider.EventRule
+	for _, rule := range r.ResourceLimitsEvents {
+		if !rule.IsActiveFor("") {
+			continue
+		}
 		if err := rule.CheckActionsConsistency(""); err == nil {
 			rules = append(rules, rule)
 		} else {
@@ -438,10 +527,28 @@ type EventParams struct {
 	IP                    string
 	Timestamp             int64
 	Object                plugin.Renderer
+	Metadata              util.Metadata
+	Impersonator          string
 	sender                string
 	updateStatusFromError bool
 	errors                []string
 	retentionChecks       []executedRetentionCheck
+	storageTransitions    []executedStorageTransition
+	integrityChecks       []executedIntegrityCheck
+	// placeholders provided by the caller for on demand rules
+	customPlaceholders map[string]string
+	// results of the already executed actions, by action name
+	actionResults map[string]actionResult
+	// output of the action in execution
+	actionOutput string
+	// true while executing the sync actions
+	syncExecution bool
+}
+
+// actionResult defines the status and the output of an executed action
+type actionResult struct {
+	Status string
+	Output string
 }
 
 func (p *EventParams) getACopy() *EventParams {
@@ -459,10 +566,44 @@ func (p *EventParams) getACopy() *EventParams {
 		retentionChecks = append(retentionChecks, executedCheck)
 	}
 	params.retentionChecks = retentionChecks
+	storageTransitions := make([]executedStorageTransition, 0, len(p.storageTransitions))
+	for idx := range p.storageTransitions {
+		storageTransitions = append(storageTransitions, p.storageTransitions[idx].getACopy())
+	}
+	params.storageTransitions = storageTransitions
+	integrityChecks := make([]executedIntegrityCheck, 0, len(p.integrityChecks))
+	for idx := range p.integrityChecks {
+		integrityChecks = append(integrityChecks, p.integrityChecks[idx].getACopy())
+	}
+	params.integrityChecks = integrityChecks
+	if p.actionResults != nil {
+		params.actionResults = make(map[string]actionResult, len(p.actionResults))
+		for k, v := range p.actionResults {
+			params.actionResults[k] = v
+		}
+	}
 
 	return &params
 }
 
+func (p *EventParams) setActionResult(name, status string) {
+	if p.actionResults == nil {
+		p.actionResults = make(map[string]actionResult)
+	}
+	p.actionResults[name] = actionResult{
+		Status: status,
+		Output: p.actionOutput,
+	}
+	p.actionOutput = ""
+}
+
+func (p *EventParams) setActionOutput(output []byte) {
+	if len(output) > maxActionOutputSize {
+		output = output[:maxActionOutputSize]
+	}
+	p.actionOutput = strings.TrimSpace(string(output))
+}
+
 // AddError adds a new error to the event params and update the status if needed
 func (p *EventParams) AddError(err error) {
 	if err == nil {
@@ -527,26 +668,37 @@ func (p *EventParams) getCompressedDataRetentionReport() ([]byte, error) {
 	var b bytes.Buffer
 	wr := zip.NewWriter(&b)
 	for _, check := range p.retentionChecks {
-		if size := int64(len(b.Bytes())); size > maxAttachmentsSize {
-			eventManagerLog(logger.LevelError, "unable to get retention report, size too large: %s", util.ByteCountIEC(size))
-			return nil, fmt.Errorf("unable to get retention report, size too large: %s", util.ByteCountIEC(size))
-		}
 		data, err := getCSVRetentionReport(check.Results)
 		if err != nil {
 			return nil, fmt.Errorf("unable to get CSV report: %w", err)
 		}
-		fh := &zip.FileHeader{
-			Name:     fmt.Sprintf("%s-%s.csv", check.ActionName, check.Username),
-			Method:   zip.Deflate,
-			Modified: time.Now().UTC(),
+		err = addReportToZip(wr, &b, fmt.Sprintf("%s-%s.csv", check.ActionName, check.Username), data, "retention")
+		if err != nil {
+			return nil, err
 		}
-		f, err := wr.CreateHeader(fh)
+	}
+	if err := wr.Close(); err != nil {
+		return nil, fmt.Errorf("unable to close zip writer: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+func (p *EventParams) getCompressedStorageTransitionReport() ([]byte, error) {
+	if len(p.storageTransitions) == 0 {
+		return nil, errors.New("no storage transition report available")
+	}
+	var b bytes.Buffer
+	wr := zip.NewWriter(&b)
+	for idx := range p.storageTransitions {
+		transition := &p.storageTransitions[idx]
+		data, err := transition.getCSVReport()
 		if err != nil {
-			return nil, fmt.Errorf("unable to create zip header for file %q: %w", fh.Name, err)
+			return nil, fmt.Errorf("unable to get CSV report: %w", err)
 		}
-		_, err = io.Copy(f, bytes.NewBuffer(data))
+		err = addReportToZip(wr, &b, fmt.Sprintf("%s-%s.csv", transition.ActionName, transition.Username), data,
+			"storage transition")
 		if err != nil {
-			return nil, fmt.Errorf("unable to write content to zip file %q: %w", fh.Name, err)
+			return nil, err
 		}
 	}
 	if err := wr.Close(); err != nil {
@@ -555,17 +707,81 @@ func (p *EventParams) getCompressedDataRetentionReport() ([]byte, error) {
 	return b.Bytes(), nil
 }
 
-func (p *EventParams) getRetentionReportsAsMailAttachment() (mail.File, error) {
+func (p *EventParams) getCompressedIntegrityCheckReport() ([]byte, error) {
+	if len(p.integrityChecks) == 0 {
+		return nil, errors.New("no integrity check report available")
+	}
+	var b bytes.Buffer
+	wr := zip.NewWriter(&b)
+	for idx := range p.integrityChecks {
+		check := &p.integrityChecks[idx]
+		data, err := check.getCSVReport()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get CSV report: %w", err)
+		}
+		err = addReportToZip(wr, &b, fmt.Sprintf("%s-%s.csv", check.ActionName, check.Username), data,
+			"integrity check")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := wr.Close(); err != nil {
+		return nil, fmt.Errorf("unable to close zip writer: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// getCompressedReport returns the compressed reports for the specified placeholder
+func (p *EventParams) getCompressedReport(placeholder string) ([]byte, error) {
+	switch placeholder {
+	case dataprovider.StorageTransitionReportPlaceHolder:
+		return p.getCompressedStorageTransitionReport()
+	case dataprovider.IntegrityCheckReportPlaceHolder:
+		return p.getCompressedIntegrityCheckReport()
+	default:
+		return p.getCompressedDataRetentionReport()
+	}
+}
+
+func (p *EventParams) getReportsAsMailAttachment(placeholder string) (mail.File, error) {
 	var result mail.File
-	data, err := p.getCompressedDataRetentionReport()
+	data, err := p.getCompressedReport(placeholder)
 	if err != nil {
 		return result, err
 	}
-	result.Name = "retention-reports.zip"
+	switch placeholder {
+	case dataprovider.StorageTransitionReportPlaceHolder:
+		result.Name = "storage-transition-reports.zip"
+	case dataprovider.IntegrityCheckReportPlaceHolder:
+		result.Name = "integrity-check-reports.zip"
+	default:
+		result.Name = "retention-reports.zip"
+	}
 	result.Data = data
 	return result, nil
 }
 
+func addReportToZip(wr *zip.Writer, b *bytes.Buffer, name string, data []byte, reportType string) error {
+	if size := int64(b.Len()); size > smtp.GetMaxAttachmentsSize() {
+		eventManagerLog(logger.LevelError, "unable to get %s report, size too large: %s", reportType, util.ByteCountIEC(size))
+		return fmt.Errorf("unable to get %s report, size too large: %s", reportType, util.ByteCountIEC(size))
+	}
+	fh := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now().UTC(),
+	}
+	f, err := wr.CreateHeader(fh)
+	if err != nil {
+		return fmt.Errorf("unable to create zip header for file %q: %w", fh.Name, err)
+	}
+	_, err = io.Copy(f, bytes.NewBuffer(data))
+	if err != nil {
+		return fmt.Errorf("unable to write content to zip file %q: %w", fh.Name, err)
+	}
+	return nil
+}
+
 func (p *EventParams) getStringReplacements(addObjectData bool) []string {
 	replacements := []string{
 		"{{Name}}", p.Name,
@@ -580,24 +796,48 @@ func (p *EventParams) getStringReplacements(addObjectData bool) []string {
 		"{{FileSize}}", fmt.Sprintf("%d", p.FileSize),
 		"{{Protocol}}", p.Protocol,
 		"{{IP}}", p.IP,
+		"{{Country}}", GetCountryForIP(p.IP),
 		"{{Timestamp}}", fmt.Sprintf("%d", p.Timestamp),
 		"{{StatusString}}", p.getStatusString(),
+		"{{Impersonator}}", p.Impersonator,
 	}
 	if len(p.errors) > 0 {
 		replacements = append(replacements, "{{ErrorString}}", strings.Join(p.errors, ", "))
 	} else {
 		replacements = append(replacements, "{{ErrorString}}", "")
 	}
+	for _, k := range p.Metadata.GetKeys() {
+		replacements = append(replacements, fmt.Sprintf("{{Metadata.%s}}", k), p.Metadata[k].Value)
+	}
 	replacements = append(replacements, "{{ObjectData}}", "")
 	if addObjectData {
-		data, err := p.Object.RenderAsJSON(p.Event != operationDelete)
+		data, err := p.Object.RenderAsJSON(p.Event != OperationDelete)
 		if err == nil {
 			replacements[len(replacements)-1] = string(data)
 		}
 	}
+	// custom placeholders are validated and cannot override the built-in ones
+	for k, v := range p.customPlaceholders {
+		replacements = append(replacements, fmt.Sprintf("{{%s}}", k), v)
+	}
+	for name, result := range p.actionResults {
+		replacements = append(replacements, fmt.Sprintf("{{ActionStatus.%s}}", name), result.Status)
+		replacements = append(replacements, fmt.Sprintf("{{ActionOutput.%s}}", name), result.Output)
+	}
 	return replacements
 }
 
+// getTemplateData converts the placeholder replacements to a map usable as
+// template data, for example {{Name}} can be referenced as {{.Name}}
+func getTemplateData(replacements []string) map[string]string {
+	data := make(map[string]string, len(replacements)/2)
+	for idx := 0; idx < len(replacements)-1; idx += 2 {
+		key := strings.TrimSuffix(strings.TrimPrefix(replacements[idx], "{{"), "}}")
+		data[key] = replacements[idx+1]
+	}
+	return data
+}
+
 func getCSVRetentionReport(results []folderRetentionCheckResult) ([]byte, error) {
 	var b bytes.Buffer
 	csvWriter := csv.NewWriter(&b)
@@ -633,7 +873,7 @@ func closeWriterAndUpdateQuota(w io.WriteCloser, conn *BaseConnection, virtualPa
 			if errTransfer == nil {
 				errTransfer = errWrite
 			}
-			ExecuteActionNotification(conn, operationUpload, fsPath, virtualPath, "", "", "", info.Size(), errTransfer) //nolint:errcheck
+			ExecuteActionNotification(conn, OperationUpload, fsPath, virtualPath, "", "", "", info.Size(), errTransfer) //nolint:errcheck
 		}
 	} else {
 		eventManagerLog(logger.LevelWarn, "unable to update quota after writing %q: %v", virtualPath, err)
@@ -647,7 +887,7 @@ func updateUserQuotaAfterFileWrite(conn *BaseConnection, virtualPath string, num
 		dataprovider.UpdateUserQuota(&conn.User, numFiles, fileSize, false) //nolint:errcheck
 		return
 	}
-	dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, numFiles, fileSize, false) //nolint:errcheck
+	dataprovider.UpdateVirtualFolderQuotaForUser(&vfolder, conn.User.Username, numFiles, fileSize) //nolint:errcheck
 	if vfolder.IsIncludedInUserQuota() {
 		dataprovider.UpdateUserQuota(&conn.User, numFiles, fileSize, false) //nolint:errcheck
 	}
@@ -684,7 +924,7 @@ func getFileWriter(conn *BaseConnection, virtualPath string) (io.WriteCloser, in
 	vfs.SetPathPermissions(fs, fsPath, conn.User.GetUID(), conn.User.GetGID())
 
 	if isFileOverwrite {
-		if vfs.HasTruncateSupport(fs) || vfs.IsCryptOsFs(fs) {
+		if vfs.HasTruncateSupport(fs) || vfs.IsCryptOsFs(fs) || vfs.IsCompressedOsFs(fs) || vfs.IsDedupFs(fs) {
 			updateUserQuotaAfterFileWrite(conn, virtualPath, numFiles, -fileSize)
 			truncatedSize = 0
 		}
@@ -781,10 +1021,18 @@ func getFileReader(conn *BaseConnection, virtualPath string) (io.ReadCloser, fun
 	if err != nil {
 		return nil, nil, err
 	}
-	f, r, cancelFn, err := fs.Open(fsPath, 0)
+	reader, cancelFn, err := getFsFileReader(fs, fsPath)
 	if err != nil {
 		return nil, nil, conn.GetFsError(fs, err)
 	}
+	return reader, cancelFn, nil
+}
+
+func getFsFileReader(fs vfs.Fs, fsPath string) (io.ReadCloser, func(), error) {
+	f, r, cancelFn, err := fs.Open(fsPath, 0)
+	if err != nil {
+		return nil, nil, err
+	}
 	if cancelFn == nil {
 		cancelFn = func() {}
 	}
@@ -808,56 +1056,50 @@ func writeFileContent(conn *BaseConnection, virtualPath string, w io.Writer) err
 	return err
 }
 
-func getFileContent(conn *BaseConnection, virtualPath string, expectedSize int) ([]byte, error) {
-	reader, cancelFn, err := getFileReader(conn, virtualPath)
-	if err != nil {
-		return nil, err
-	}
-
-	defer cancelFn()
-	defer reader.Close()
-
-	data := make([]byte, expectedSize)
-	_, err = io.ReadFull(reader, data)
-	return data, err
-}
-
-func getMailAttachments(user dataprovider.User, attachments []string, replacer *strings.Replacer) ([]mail.File, error) {
-	var files []mail.File
+// getMailAttachments returns the specified files as email attachments, their
+// content is read while sending the email. The returned function releases the
+// user filesystem and must be called after sending the email
+func getMailAttachments(user dataprovider.User, attachments []string, replacer *strings.Replacer,
+) ([]smtp.FileAttachment, func(), error) {
+	var files []smtp.FileAttachment
 	user, err := getUserForEventAction(user)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	connectionID := fmt.Sprintf("%s_%s", protocolEventAction, xid.New().String())
 	err = user.CheckFsRoot(connectionID)
-	defer user.CloseFs() //nolint:errcheck
+	closeFn := func() {
+		user.CloseFs() //nolint:errcheck
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error getting email attachments, unable to check root fs for user %q: %w", user.Username, err)
+		closeFn()
+		return nil, nil, fmt.Errorf("error getting email attachments, unable to check root fs for user %q: %w", user.Username, err)
 	}
 	conn := NewBaseConnection(connectionID, protocolEventAction, "", "", user)
 	totalSize := int64(0)
 	for _, virtualPath := range replacePathsPlaceholders(attachments, replacer) {
 		info, err := conn.DoStat(virtualPath, 0, false)
 		if err != nil {
-			return nil, fmt.Errorf("unable to get info for file %q, user %q: %w", virtualPath, conn.User.Username, err)
+			closeFn()
+			return nil, nil, fmt.Errorf("unable to get info for file %q, user %q: %w", virtualPath, conn.User.Username, err)
 		}
 		if !info.Mode().IsRegular() {
-			return nil, fmt.Errorf("cannot attach non regular file %q", virtualPath)
+			closeFn()
+			return nil, nil, fmt.Errorf("cannot attach non regular file %q", virtualPath)
 		}
 		totalSize += info.Size()
-		if totalSize > maxAttachmentsSize {
-			return nil, fmt.Errorf("unable to send files as attachment, size too large: %s", util.ByteCountIEC(totalSize))
+		if totalSize > smtp.GetMaxAttachmentsSize() {
+			closeFn()
+			return nil, nil, fmt.Errorf("unable to send files as attachment, size too large: %s", util.ByteCountIEC(totalSize))
 		}
-		data, err := getFileContent(conn, virtualPath, int(info.Size()))
+		fs, fsPath, err := conn.GetFsAndResolvedPath(virtualPath)
 		if err != nil {
-			return nil, fmt.Errorf("unable to get content for file %q, user %q: %w", virtualPath, conn.User.Username, err)
+			closeFn()
+			return nil, nil, fmt.Errorf("unable to get fs for file %q, user %q: %w", virtualPath, conn.User.Username, err)
 		}
-		files = append(files, mail.File{
-			Name: path.Base(virtualPath),
-			Data: data,
-		})
+		files = append(files, smtp.NewFileAttachment(fs, fsPath, path.Base(virtualPath), info.Size()))
 	}
-	return files, nil
+	return files, closeFn, nil
 }
 
 func replaceWithReplacer(input string, replacer *strings.Replacer) string {
@@ -880,6 +1122,15 @@ func checkEventConditionPattern(p dataprovider.ConditionPattern, name string) bo
 }
 
 // checkConditionPatterns returns false if patterns are defined and no match is found
+// checkEventCountryCondition returns true if no country is set or if the
+// country for the specified IP is listed
+func checkEventCountryCondition(ip string, countries []string) bool {
+	if len(countries) == 0 {
+		return true
+	}
+	return util.IsCountryAllowed(GetCountryForIP(ip), countries, nil)
+}
+
 func checkEventConditionPatterns(name string, patterns []dataprovider.ConditionPattern) bool {
 	if len(patterns) == 0 {
 		return true
@@ -942,8 +1193,8 @@ func writeHTTPPart(m *multipart.Writer, part dataprovider.HTTPPart, h textproto.
 		}
 		return nil
 	}
-	if part.Filepath == dataprovider.RetentionReportPlaceHolder {
-		data, err := params.getCompressedDataRetentionReport()
+	if dataprovider.IsReportPlaceHolder(part.Filepath) {
+		data, err := params.getCompressedReport(part.Filepath)
 		if err != nil {
 			return err
 		}
@@ -970,8 +1221,8 @@ func getHTTPRuleActionBody(c dataprovider.EventActionHTTPConfig, replacer *strin
 		return body, "", nil
 	}
 	if c.Body != "" {
-		if c.Body == dataprovider.RetentionReportPlaceHolder {
-			data, err := params.getCompressedDataRetentionReport()
+		if dataprovider.IsReportPlaceHolder(c.Body) {
+			data, err := params.getCompressedReport(c.Body)
 			if err != nil {
 				return body, "", err
 			}
@@ -979,6 +1230,13 @@ func getHTTPRuleActionBody(c dataprovider.EventActionHTTPConfig, replacer *strin
 		}
 		return io.NopCloser(bytes.NewBufferString(replaceWithReplacer(c.Body, replacer))), "", nil
 	}
+	if c.Transform.IsEnabled() {
+		data, err := getTransformedHTTPBody(c.Transform, replacer, user, params)
+		if err != nil {
+			return body, "", err
+		}
+		return io.NopCloser(bytes.NewReader(data)), "application/json", nil
+	}
 	if len(c.Parts) > 0 {
 		r, w := io.Pipe()
 		m := multipart.NewWriter(w)
@@ -1034,38 +1292,100 @@ func getHTTPRuleActionBody(c dataprovider.EventActionHTTPConfig, replacer *strin
 	return body, "", nil
 }
 
-func executeHTTPRuleAction(c dataprovider.EventActionHTTPConfig, params *EventParams) error {
-	if err := c.TryDecryptPassword(); err != nil {
-		return err
+// signHTTPRuleActionRequest signs the given request using the action signing
+// secret, if any, or the global HTTP clients configuration
+func signHTTPRuleActionRequest(c dataprovider.EventActionHTTPConfig, req *http.Request, payloadHash string) {
+	if c.HasSigningSecret() {
+		httpclient.SignRequestWithSecret(req, c.SigningSecret.GetPayload(), payloadHash)
+		return
 	}
-	addObjectData := false
-	if params.Object != nil {
-		addObjectData = c.HasObjectData()
+	httpclient.SignRequest(req, payloadHash)
+}
+
+// httpActionDeliveryError is returned if an HTTP notification cannot be delivered
+type httpActionDeliveryError struct {
+	err        error
+	statusCode int
+}
+
+func (e *httpActionDeliveryError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("error sending HTTP request: %v", e.err)
 	}
+	return fmt.Sprintf("unexpected status code: %d", e.statusCode)
+}
 
-	replacements := params.getStringReplacements(addObjectData)
-	replacer := strings.NewReplacer(replacements...)
-	endpoint, err := getHTTPRuleActionEndpoint(c, replacer)
+func (e *httpActionDeliveryError) Unwrap() error {
+	return e.err
+}
+
+// isRetryable returns true for network errors, too many requests and server errors
+func (e *httpActionDeliveryError) isRetryable() bool {
+	if e.err != nil {
+		return true
+	}
+	return e.statusCode == http.StatusTooManyRequests || e.statusCode >= http.StatusInternalServerError
+}
+
+// getHTTPActionRetryWait returns the wait time before the specified retry,
+// the wait time doubles for each retry
+func getHTTPActionRetryWait(retry int) time.Duration {
+	wait := httpActionRetryWaitMin
+	for i := 1; i < retry && wait < httpActionRetryWaitMax; i++ {
+		wait *= 2
+	}
+	if wait > httpActionRetryWaitMax {
+		return httpActionRetryWaitMax
+	}
+	return wait
+}
+
+// getHTTPRuleActionPayload returns the whole request body for the actions
+// without file attachments, so the same payload can be sent for each attempt
+func getHTTPRuleActionPayload(c dataprovider.EventActionHTTPConfig, replacer *strings.Replacer,
+	user dataprovider.User, params *EventParams,
+) ([]byte, string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	body, contentType, err := getHTTPRuleActionBody(c, replacer, cancel, user, params)
+	if err != nil || body == nil {
+		return nil, contentType, err
+	}
+	defer body.Close()
+
+	payload, err := io.ReadAll(body)
 	if err != nil {
-		return err
+		return nil, contentType, err
+	}
+	if ctx.Err() != nil {
+		return nil, contentType, errors.New("unable to build the request body")
 	}
+	return payload, contentType, nil
+}
 
+func sendHTTPRuleActionRequest(c dataprovider.EventActionHTTPConfig, client *http.Client, endpoint string,
+	replacer *strings.Replacer, user dataprovider.User, params *EventParams, payload []byte, contentType string,
+) error {
 	ctx, cancel := c.GetContext()
 	defer cancel()
 
-	var user dataprovider.User
+	var body io.Reader
+	payloadHash := httpclient.GetPayloadHash(payload)
 	if c.HasMultipartFiles() {
-		user, err = params.getUserFromSender()
+		// file attachments are streamed, the body must be built for each attempt
+		// and cannot be hashed
+		multipartBody, multipartContentType, err := getHTTPRuleActionBody(c, replacer, cancel, user, params)
 		if err != nil {
 			return err
 		}
-	}
-	body, contentType, err := getHTTPRuleActionBody(c, replacer, cancel, user, params)
-	if err != nil {
-		return err
-	}
-	if body != nil {
-		defer body.Close()
+		defer multipartBody.Close()
+
+		body = multipartBody
+		contentType = multipartContentType
+		payloadHash = httpclient.UnsignedPayload
+	} else if payload != nil {
+		body = bytes.NewReader(payload)
 	}
 	req, err := http.NewRequestWithContext(ctx, c.Method, endpoint, body)
 	if err != nil {
@@ -1080,24 +1400,227 @@ func executeHTTPRuleAction(c dataprovider.EventActionHTTPConfig, params *EventPa
 	for _, keyVal := range c.Headers {
 		req.Header.Set(keyVal.Key, replaceWithReplacer(keyVal.Value, replacer))
 	}
-	client := c.GetHTTPClient()
-	defer client.CloseIdleConnections()
+	signHTTPRuleActionRequest(c, req, payloadHash)
 
 	startTime := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
 		eventManagerLog(logger.LevelDebug, "unable to send http notification, endpoint: %s, elapsed: %s, err: %v",
 			endpoint, time.Since(startTime), err)
-		return fmt.Errorf("error sending HTTP request: %w", err)
+		return &httpActionDeliveryError{err: err}
 	}
 	defer resp.Body.Close()
 
 	eventManagerLog(logger.LevelDebug, "http notification sent, endpoint: %s, elapsed: %s, status code: %d",
 		endpoint, time.Since(startTime), resp.StatusCode)
+	if output, err := io.ReadAll(io.LimitReader(resp.Body, maxActionOutputSize)); err == nil {
+		params.setActionOutput(output)
+	}
 	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusNoContent {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return &httpActionDeliveryError{statusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// addHTTPRuleActionDeadLetter stores a failed notification in the dead letters.
+// Notifications with file attachments and binary payloads are not stored
+func addHTTPRuleActionDeadLetter(c dataprovider.EventActionHTTPConfig, actionName, endpoint string,
+	replacer *strings.Replacer, payload []byte, contentType string, deliveryErr error, attempts int,
+) {
+	if !dataprovider.IsDeadLettersEnabled() || c.HasMultipartFiles() {
+		return
+	}
+	if !utf8.Valid(payload) {
+		eventManagerLog(logger.LevelDebug, "binary payload for action %q not stored in dead letters", actionName)
+		return
+	}
+	var headers []dataprovider.KeyValue
+	if contentType != "" {
+		headers = append(headers, dataprovider.KeyValue{Key: "Content-Type", Value: contentType})
+	}
+	for _, keyVal := range c.Headers {
+		headers = append(headers, dataprovider.KeyValue{
+			Key:   keyVal.Key,
+			Value: replaceWithReplacer(keyVal.Value, replacer),
+		})
+	}
+	var username string
+	if c.Username != "" {
+		username = replaceWithReplacer(c.Username, replacer)
+	}
+	dataprovider.AddDeadLetter(&dataprovider.DeadLetter{ //nolint:errcheck
+		Source:   dataprovider.DeadLetterSourceEventAction,
+		Name:     actionName,
+		Method:   c.Method,
+		URL:      endpoint,
+		Username: username,
+		Headers:  headers,
+		Body:     string(payload),
+		Error:    deliveryErr.Error(),
+		Attempts: attempts,
+	})
+}
+
+func executeHTTPRuleAction(c dataprovider.EventActionHTTPConfig, params *EventParams, actionName string) error {
+	if err := c.TryDecryptPassword(); err != nil {
+		return err
+	}
+	if err := c.TryDecryptSigningSecret(); err != nil {
+		return err
+	}
+	addObjectData := false
+	if params.Object != nil {
+		addObjectData = c.HasObjectData()
+	}
+
+	replacements := params.getStringReplacements(addObjectData)
+	replacer := strings.NewReplacer(replacements...)
+	endpoint, err := getHTTPRuleActionEndpoint(c, replacer)
+	if err != nil {
+		return err
+	}
+
+	var user dataprovider.User
+	if c.HasMultipartFiles() || c.Transform.IsEnabled() {
+		user, err = params.getUserFromSender()
+		if err != nil {
+			return err
+		}
+	}
+	var payload []byte
+	var contentType string
+	if !c.HasMultipartFiles() {
+		payload, contentType, err = getHTTPRuleActionPayload(c, replacer, user, params)
+		if err != nil {
+			return err
+		}
+	}
+	client := c.GetHTTPClient()
+	defer client.CloseIdleConnections()
+
+	var retryDeadline time.Time
+	if params.syncExecution {
+		retryDeadline = time.Now().Add(httpActionSyncRetryMaxTime)
+	}
+	attempts := 0
+	for {
+		attempts++
+		err = sendHTTPRuleActionRequest(c, client, endpoint, replacer, user, params, payload, contentType)
+		var deliveryErr *httpActionDeliveryError
+		if err == nil || !errors.As(err, &deliveryErr) {
+			return err
+		}
+		if !deliveryErr.isRetryable() || attempts > c.Retries {
+			break
+		}
+		wait := getHTTPActionRetryWait(attempts)
+		if !retryDeadline.IsZero() && time.Now().Add(wait).After(retryDeadline) {
+			eventManagerLog(logger.LevelDebug, "http notification to %s failed, attempt %d, sync retry time exceeded: %v",
+				endpoint, attempts, err)
+			break
+		}
+		eventManagerLog(logger.LevelDebug, "http notification to %s failed, attempt %d, retrying in %s: %v",
+			endpoint, attempts, wait, err)
+		time.Sleep(wait)
 	}
+	addHTTPRuleActionDeadLetter(c, actionName, endpoint, replacer, payload, contentType, err, attempts)
+	return err
+}
 
+// eventPublishPayload defines the default payload for the messages published to a broker
+type eventPublishPayload struct {
+	Event             string          `json:"event"`
+	Name              string          `json:"name"`
+	Status            int             `json:"status"`
+	VirtualPath       string          `json:"virtual_path,omitempty"`
+	FsPath            string          `json:"fs_path,omitempty"`
+	VirtualTargetPath string          `json:"virtual_target_path,omitempty"`
+	FsTargetPath      string          `json:"fs_target_path,omitempty"`
+	ObjectName        string          `json:"object_name,omitempty"`
+	ObjectType        string          `json:"object_type,omitempty"`
+	FileSize          int64           `json:"file_size,omitempty"`
+	Protocol          string          `json:"protocol,omitempty"`
+	IP                string          `json:"ip,omitempty"`
+	Timestamp         int64           `json:"timestamp"`
+	Errors            []string        `json:"errors,omitempty"`
+	Object            json.RawMessage `json:"object,omitempty"`
+}
+
+func (p *EventParams) getPublishPayload() ([]byte, error) {
+	payload := eventPublishPayload{
+		Event:             p.Event,
+		Name:              p.Name,
+		Status:            p.Status,
+		VirtualPath:       p.VirtualPath,
+		FsPath:            p.FsPath,
+		VirtualTargetPath: p.VirtualTargetPath,
+		FsTargetPath:      p.FsTargetPath,
+		ObjectName:        p.ObjectName,
+		ObjectType:        p.ObjectType,
+		FileSize:          p.FileSize,
+		Protocol:          p.Protocol,
+		IP:                p.IP,
+		Timestamp:         p.Timestamp,
+		Errors:            p.errors,
+	}
+	if p.Object != nil {
+		data, err := p.Object.RenderAsJSON(p.Event != OperationDelete)
+		if err == nil {
+			payload.Object = data
+		}
+	}
+	return json.Marshal(payload)
+}
+
+func executePublishRuleAction(c dataprovider.EventActionPublishConfig, params *EventParams) error {
+	if err := c.TryDecryptPassword(); err != nil {
+		return err
+	}
+	addObjectData := false
+	if params.Object != nil {
+		addObjectData = c.HasObjectData()
+	}
+	replacements := params.getStringReplacements(addObjectData)
+	replacer := strings.NewReplacer(replacements...)
+
+	msg := &mq.Message{
+		Topic:    replaceWithReplacer(c.Topic, replacer),
+		Exchange: c.Exchange,
+	}
+	if c.Key != "" {
+		msg.Key = []byte(replaceWithReplacer(c.Key, replacer))
+	}
+	for _, kv := range c.Headers {
+		msg.Headers = append(msg.Headers, mq.Header{
+			Key:   kv.Key,
+			Value: replaceWithReplacer(kv.Value, replacer),
+		})
+	}
+	if c.Body != "" {
+		msg.Body = []byte(replaceWithReplacer(c.Body, replacer))
+	} else {
+		body, err := params.getPublishPayload()
+		if err != nil {
+			return fmt.Errorf("unable to marshal the event payload: %w", err)
+		}
+		msg.Body = body
+	}
+	msg.ContentType = "text/plain"
+	if json.Valid(msg.Body) {
+		msg.ContentType = "application/json"
+	}
+
+	ctx, cancel := c.GetContext()
+	defer cancel()
+
+	startTime := time.Now()
+	if err := mq.Publish(ctx, c.GetMQConfig(), msg); err != nil {
+		eventManagerLog(logger.LevelDebug, "unable to publish message, broker: %s, topic: %q, elapsed: %s, err: %v",
+			c.Broker, msg.Topic, time.Since(startTime), err)
+		return fmt.Errorf("unable to publish message: %w", err)
+	}
+	eventManagerLog(logger.LevelDebug, "message published, broker: %s, topic: %q, elapsed: %s",
+		c.Broker, msg.Topic, time.Since(startTime))
 	return nil
 }
 
@@ -1128,11 +1651,15 @@ func executeCommandRuleAction(c dataprovider.EventActionCommandConfig, params *E
 		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", keyVal.Key, replaceWithReplacer(keyVal.Value, replacer)))
 	}
 
+	output := &limitedBuffer{limit: maxActionOutputSize}
+	cmd.Stdout = output
+
 	startTime := time.Now()
-	err := cmd.Run()
+	err := command.Run(cmd, command.HookEventAction)
 
 	eventManagerLog(logger.LevelDebug, "executed command %q, elapsed: %s, error: %v",
 		c.Cmd, time.Since(startTime), err)
+	params.setActionOutput(output.Bytes())
 
 	return err
 }
@@ -1140,20 +1667,30 @@ func executeCommandRuleAction(c dataprovider.EventActionCommandConfig, params *E
 func executeEmailRuleAction(c dataprovider.EventActionEmailConfig, params *EventParams) error {
 	addObjectData := false
 	if params.Object != nil {
-		if strings.Contains(c.Body, "{{ObjectData}}") {
+		if c.Template != "" || strings.Contains(c.Body, "{{ObjectData}}") {
 			addObjectData = true
 		}
 	}
 	replacements := params.getStringReplacements(addObjectData)
 	replacer := strings.NewReplacer(replacements...)
-	body := replaceWithReplacer(c.Body, replacer)
+	body := smtp.EmailBody{
+		Content:     replaceWithReplacer(c.Body, replacer),
+		ContentType: smtp.EmailContentTypeTextPlain,
+	}
 	subject := replaceWithReplacer(c.Subject, replacer)
+	if c.Template != "" {
+		var err error
+		body, err = smtp.RenderLocalizedTemplateBody(c.Template, c.Language, getTemplateData(replacements))
+		if err != nil {
+			return fmt.Errorf("unable to render email template: %w", err)
+		}
+	}
 	startTime := time.Now()
 	var files []mail.File
 	fileAttachments := make([]string, 0, len(c.Attachments))
 	for _, attachment := range c.Attachments {
-		if attachment == dataprovider.RetentionReportPlaceHolder {
-			f, err := params.getRetentionReportsAsMailAttachment()
+		if dataprovider.IsReportPlaceHolder(attachment) {
+			f, err := params.getReportsAsMailAttachment(attachment)
 			if err != nil {
 				return err
 			}
@@ -1162,18 +1699,27 @@ func executeEmailRuleAction(c dataprovider.EventActionEmailConfig, params *Event
 		}
 		fileAttachments = append(fileAttachments, attachment)
 	}
+	addresses := smtp.EmailAddresses{
+		To:      c.Recipients,
+		Cc:      c.Cc,
+		Bcc:     c.Bcc,
+		ReplyTo: c.ReplyTo,
+	}
+	var err error
 	if len(fileAttachments) > 0 {
-		user, err := params.getUserFromSender()
-		if err != nil {
-			return err
+		user, errUser := params.getUserFromSender()
+		if errUser != nil {
+			return errUser
 		}
-		res, err := getMailAttachments(user, fileAttachments, replacer)
-		if err != nil {
-			return err
+		res, closeFn, errAttachments := getMailAttachments(user, fileAttachments, replacer)
+		if errAttachments != nil {
+			return errAttachments
 		}
-		files = append(files, res...)
+		err = smtp.SendEmailWithFiles(addresses, subject, body, res, files...)
+		closeFn()
+	} else {
+		err = smtp.SendNotification(addresses, subject, body, files...)
 	}
-	err := smtp.SendEmail(c.Recipients, subject, body, smtp.EmailContentTypeTextPlain, files...)
 	eventManagerLog(logger.LevelDebug, "executed email notification action, elapsed: %s, error: %v",
 		time.Since(startTime), err)
 	if err != nil {
@@ -1194,6 +1740,7 @@ func getUserForEventAction(user dataprovider.User) (dataprovider.User, error) {
 	user.Filters.DisableFsChecks = false
 	user.Filters.FilePatterns = nil
 	user.Filters.BandwidthLimits = nil
+	user.Filters.BandwidthSchedules = nil
 	user.Filters.DataTransferLimits = nil
 	for k := range user.Permissions {
 		user.Permissions[k] = []string{dataprovider.PermAny}
@@ -1831,6 +2378,163 @@ func executeDataRetentionCheckRuleAction(config dataprovider.EventActionDataRete
 	return nil
 }
 
+func executeIntegrityCheckForUser(user dataprovider.User, config dataprovider.EventActionIntegrityCheckConfig,
+	params *EventParams, actionName string,
+) error {
+	user, err := getUserForEventAction(user)
+	if err != nil {
+		return err
+	}
+	if !integrityChecks.add(user.Username) {
+		eventManagerLog(logger.LevelError, "another integrity check is already in progress for user %q", user.Username)
+		return fmt.Errorf("another integrity check is in progress for user %q", user.Username)
+	}
+	defer integrityChecks.remove(user.Username)
+
+	connectionID := fmt.Sprintf("%s_%s", protocolEventAction, xid.New().String())
+	err = user.CheckFsRoot(connectionID)
+	defer user.CloseFs() //nolint:errcheck
+	if err != nil {
+		return fmt.Errorf("integrity check error, unable to check root fs for user %q: %w", user.Username, err)
+	}
+	check := integrityCheck{
+		conn:             NewBaseConnection(connectionID, protocolEventAction, "", "", user),
+		paths:            config.Paths,
+		samplePercentage: config.SamplePercentage,
+		manifestsPath:    config.ManifestsPath,
+		result: executedIntegrityCheck{
+			Username:   user.Username,
+			ActionName: actionName,
+		},
+	}
+	defer func() {
+		params.integrityChecks = append(params.integrityChecks, check.result)
+	}()
+	if err := check.start(); err != nil {
+		eventManagerLog(logger.LevelError, "error executing integrity check for user %q: %v", user.Username, err)
+		return fmt.Errorf("error executing integrity check for user %q: %w", user.Username, err)
+	}
+	return nil
+}
+
+func executeIntegrityCheckRuleAction(config dataprovider.EventActionIntegrityCheckConfig,
+	conditions dataprovider.ConditionOptions, params *EventParams, actionName string,
+) error {
+	users, err := params.getUsers()
+	if err != nil {
+		return fmt.Errorf("unable to get users: %w", err)
+	}
+	var failures []string
+	executed := 0
+	for _, user := range users {
+		// if sender is set, the conditions have already been evaluated
+		if params.sender == "" {
+			if !checkEventConditionPatterns(user.Username, conditions.Names) {
+				eventManagerLog(logger.LevelDebug, "skipping integrity check for user %s, name conditions don't match",
+					user.Username)
+				continue
+			}
+			if !checkEventGroupConditionPatters(user.Groups, conditions.GroupNames) {
+				eventManagerLog(logger.LevelDebug, "skipping integrity check for user %s, group name conditions don't match",
+					user.Username)
+				continue
+			}
+		}
+		executed++
+		if err = executeIntegrityCheckForUser(user, config, params, actionName); err != nil {
+			failures = append(failures, user.Username)
+			params.AddError(err)
+			continue
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("integrity check failed for users: %+v", failures)
+	}
+	if executed == 0 {
+		eventManagerLog(logger.LevelError, "no integrity check executed")
+		return errors.New("no integrity check executed")
+	}
+	return nil
+}
+
+func executeStorageTransitionForUser(user dataprovider.User, config dataprovider.EventActionStorageTransitionConfig,
+	params *EventParams, actionName string,
+) error {
+	user, err := getUserForEventAction(user)
+	if err != nil {
+		return err
+	}
+	if !storageTransitions.add(user.Username) {
+		eventManagerLog(logger.LevelError, "another storage transition is already in progress for user %q", user.Username)
+		return fmt.Errorf("another storage transition is in progress for user %q", user.Username)
+	}
+	defer storageTransitions.remove(user.Username)
+
+	connectionID := fmt.Sprintf("%s_%s", protocolEventAction, xid.New().String())
+	err = user.CheckFsRoot(connectionID)
+	defer user.CloseFs() //nolint:errcheck
+	if err != nil {
+		return fmt.Errorf("storage transition error, unable to check root fs for user %q: %w", user.Username, err)
+	}
+	check := storageTransitionCheck{
+		conn:    NewBaseConnection(connectionID, protocolEventAction, "", "", user),
+		folders: config.Folders,
+		dryRun:  config.DryRun,
+		result: executedStorageTransition{
+			Username:   user.Username,
+			ActionName: actionName,
+		},
+	}
+	defer func() {
+		params.storageTransitions = append(params.storageTransitions, check.result)
+	}()
+	if err := check.start(); err != nil {
+		eventManagerLog(logger.LevelError, "error executing storage transition for user %q: %v", user.Username, err)
+		return fmt.Errorf("error executing storage transition for user %q: %w", user.Username, err)
+	}
+	return nil
+}
+
+func executeStorageTransitionRuleAction(config dataprovider.EventActionStorageTransitionConfig,
+	conditions dataprovider.ConditionOptions, params *EventParams, actionName string,
+) error {
+	users, err := params.getUsers()
+	if err != nil {
+		return fmt.Errorf("unable to get users: %w", err)
+	}
+	var failures []string
+	executed := 0
+	for _, user := range users {
+		// if sender is set, the conditions have already been evaluated
+		if params.sender == "" {
+			if !checkEventConditionPatterns(user.Username, conditions.Names) {
+				eventManagerLog(logger.LevelDebug, "skipping storage transition for user %s, name conditions don't match",
+					user.Username)
+				continue
+			}
+			if !checkEventGroupConditionPatters(user.Groups, conditions.GroupNames) {
+				eventManagerLog(logger.LevelDebug, "skipping storage transition for user %s, group name conditions don't match",
+					user.Username)
+				continue
+			}
+		}
+		executed++
+		if err = executeStorageTransitionForUser(user, config, params, actionName); err != nil {
+			failures = append(failures, user.Username)
+			params.AddError(err)
+			continue
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("storage transition failed for users: %+v", failures)
+	}
+	if executed == 0 {
+		eventManagerLog(logger.LevelError, "no storage transition executed")
+		return errors.New("no storage transition executed")
+	}
+	return nil
+}
+
 func executeMetadataCheckForUser(user dataprovider.User) error {
 	if err := user.LoadAndApplyGroupSettings(); err != nil {
 		eventManagerLog(logger.LevelDebug, "skipping scheduled quota reset for user %s, cannot apply group settings: %v",
@@ -1895,7 +2599,7 @@ func executeRuleAction(action dataprovider.BaseEventAction, params *EventParams,
 
 	switch action.Type {
 	case dataprovider.ActionTypeHTTP:
-		err = executeHTTPRuleAction(action.Options.HTTPConfig, params)
+		err = executeHTTPRuleAction(action.Options.HTTPConfig, params, action.Name)
 	case dataprovider.ActionTypeCommand:
 		err = executeCommandRuleAction(action.Options.CmdConfig, params)
 	case dataprovider.ActionTypeEmail:
@@ -1914,6 +2618,14 @@ func executeRuleAction(action dataprovider.BaseEventAction, params *EventParams,
 		err = executeMetadataCheckRuleAction(conditions, params)
 	case dataprovider.ActionTypeFilesystem:
 		err = executeFsRuleAction(action.Options.FsConfig, conditions, params)
+	case dataprovider.ActionTypeStorageTransition:
+		err = executeStorageTransitionRuleAction(action.Options.TransitionConfig, conditions, params, action.Name)
+	case dataprovider.ActionTypeIntegrityCheck:
+		err = executeIntegrityCheckRuleAction(action.Options.IntegrityConfig, conditions, params, action.Name)
+	case dataprovider.ActionTypePublish:
+		err = executePublishRuleAction(action.Options.PublishConfig, params)
+	case dataprovider.ActionTypeSummaryReport:
+		err = executeSummaryReportRuleAction(action.Options.SummaryReportConfig, conditions)
 	default:
 		err = fmt.Errorf("unsupported action type: %d", action.Type)
 	}
@@ -1925,16 +2637,108 @@ func executeRuleAction(action dataprovider.BaseEventAction, params *EventParams,
 	return err
 }
 
+// limitedBuffer is an io.Writer that keeps at most limit bytes and silently
+// discards the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - b.Len(); remaining > 0 {
+		if len(p) > remaining {
+			b.Buffer.Write(p[:remaining])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func checkActionCondition(condition dataprovider.ActionCondition, params *EventParams) bool {
+	value := replaceWithReplacer(condition.Value, strings.NewReplacer(params.getStringReplacements(false)...))
+
+	switch condition.Operator {
+	case dataprovider.ActionConditionOperatorEqual:
+		return value == condition.Operand
+	case dataprovider.ActionConditionOperatorNotEqual:
+		return value != condition.Operand
+	case dataprovider.ActionConditionOperatorMatch, dataprovider.ActionConditionOperatorNotMatch:
+		matched, err := path.Match(condition.Operand, value)
+		if err != nil {
+			eventManagerLog(logger.LevelError, "unable to check action condition %q: %v", condition.String(), err)
+			return false
+		}
+		return matched == (condition.Operator == dataprovider.ActionConditionOperatorMatch)
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		eventManagerLog(logger.LevelDebug, "action condition %q, value %q is not a number", condition.String(), value)
+		return false
+	}
+	operand, err := strconv.ParseFloat(condition.Operand, 64)
+	if err != nil {
+		eventManagerLog(logger.LevelError, "action condition %q, operand is not a number", condition.String())
+		return false
+	}
+	switch condition.Operator {
+	case dataprovider.ActionConditionOperatorGreater:
+		return number > operand
+	case dataprovider.ActionConditionOperatorGreaterOrEqual:
+		return number >= operand
+	case dataprovider.ActionConditionOperatorLess:
+		return number < operand
+	case dataprovider.ActionConditionOperatorLessOrEqual:
+		return number <= operand
+	default:
+		eventManagerLog(logger.LevelError, "unsupported action condition operator %q", condition.Operator)
+		return false
+	}
+}
+
+// checkActionConditions returns true if the action must be executed.
+// If the conditions don't match the action is marked as skipped
+func checkActionConditions(action dataprovider.EventAction, params *EventParams) bool {
+	for _, condition := range action.Options.Conditions {
+		if !checkActionCondition(condition, params) {
+			eventManagerLog(logger.LevelDebug, "condition %q not matched, skipping action %q",
+				condition.String(), action.Name)
+			params.setActionResult(action.Name, actionStatusSkipped)
+			return false
+		}
+	}
+	return true
+}
+
+// executeRuleActionWithResult executes the specified action and saves its
+// result so it can be referenced by the next actions
+func executeRuleActionWithResult(action dataprovider.EventAction, params *EventParams,
+	conditions dataprovider.ConditionOptions,
+) error {
+	params.actionOutput = ""
+	err := executeRuleAction(action.BaseEventAction, params, conditions)
+	if err != nil {
+		params.setActionResult(action.Name, actionStatusKO)
+	} else {
+		params.setActionResult(action.Name, actionStatusOK)
+	}
+	return err
+}
+
 func executeSyncRulesActions(rules []dataprovider.EventRule, params EventParams) error {
 	var errRes error
 
 	for _, rule := range rules {
 		var failedActions []string
 		paramsCopy := params.getACopy()
+		paramsCopy.syncExecution = true
 		for _, action := range rule.Actions {
 			if !action.Options.IsFailureAction && action.Options.ExecuteSync {
+				if !checkActionConditions(action, paramsCopy) {
+					continue
+				}
 				startTime := time.Now()
-				if err := executeRuleAction(action.BaseEventAction, paramsCopy, rule.Conditions.Options); err != nil {
+				if err := executeRuleActionWithResult(action, paramsCopy, rule.Conditions.Options); err != nil {
 					eventManagerLog(logger.LevelError, "unable to execute sync action %q for rule %q, elapsed %s, err: %v",
 						action.Name, rule.Name, time.Since(startTime), err)
 					failedActions = append(failedActions, action.Name)
@@ -1949,6 +2753,7 @@ func executeSyncRulesActions(rules []dataprovider.EventRule, params EventParams)
 				}
 			}
 		}
+		paramsCopy.syncExecution = false
 		// execute async actions if any, including failure actions
 		go executeRuleAsyncActions(rule, paramsCopy, failedActions)
 	}
@@ -1968,8 +2773,11 @@ func executeAsyncRulesActions(rules []dataprovider.EventRule, params EventParams
 func executeRuleAsyncActions(rule dataprovider.EventRule, params *EventParams, failedActions []string) {
 	for _, action := range rule.Actions {
 		if !action.Options.IsFailureAction && !action.Options.ExecuteSync {
+			if !checkActionConditions(action, params) {
+				continue
+			}
 			startTime := time.Now()
-			if err := executeRuleAction(action.BaseEventAction, params, rule.Conditions.Options); err != nil {
+			if err := executeRuleActionWithResult(action, params, rule.Conditions.Options); err != nil {
 				eventManagerLog(logger.LevelError, "unable to execute action %q for rule %q, elapsed %s, err: %v",
 					action.Name, rule.Name, time.Since(startTime), err)
 				failedActions = append(failedActions, action.Name)
@@ -1987,8 +2795,11 @@ func executeRuleAsyncActions(rule dataprovider.EventRule, params *EventParams, f
 		// execute failure actions
 		for _, action := range rule.Actions {
 			if action.Options.IsFailureAction {
+				if !checkActionConditions(action, params) {
+					continue
+				}
 				startTime := time.Now()
-				if err := executeRuleAction(action.BaseEventAction, params, rule.Conditions.Options); err != nil {
+				if err := executeRuleActionWithResult(action, params, rule.Conditions.Options); err != nil {
 					eventManagerLog(logger.LevelError, "unable to execute failure action %q for rule %q, elapsed %s, err: %v",
 						action.Name, rule.Name, time.Since(startTime), err)
 					if action.Options.StopOnFailure {
@@ -2038,6 +2849,11 @@ func (j *eventCronJob) Run() {
 		eventManagerLog(logger.LevelError, "unable to load rule with name %q", j.ruleName)
 		return
 	}
+	if !rule.IsActiveFor("") {
+		eventManagerLog(logger.LevelDebug, "scheduled rule %q is not active on this node, rollout: %+v",
+			rule.Name, rule.Conditions.Options.Rollout)
+		return
+	}
 	if err = rule.CheckActionsConsistency(""); err != nil {
 		eventManagerLog(logger.LevelWarn, "scheduled rule %q skipped: %v", rule.Name, err)
 		return
@@ -2088,6 +2904,88 @@ func (j *eventCronJob) Run() {
 	eventManagerLog(logger.LevelDebug, "execution for scheduled rule %q finished", j.ruleName)
 }
 
+// OnDemandRuleParams defines the parameters to execute an on demand event rule
+type OnDemandRuleParams struct {
+	// Optional username, if set the actions are executed for this user
+	Username string `json:"username,omitempty"`
+	// Optional virtual path, available as {{VirtualPath}} placeholder
+	Path string `json:"path,omitempty"`
+	// Custom placeholders, a key "OrderID" can be referenced as {{OrderID}}
+	Placeholders map[string]string `json:"placeholders,omitempty"`
+}
+
+func (p *OnDemandRuleParams) validate() error {
+	if len(p.Placeholders) > maxOnDemandPlaceholders {
+		return util.NewValidationError(fmt.Sprintf("too many placeholders, max allowed: %d", maxOnDemandPlaceholders))
+	}
+	builtinPlaceholders := (&EventParams{}).getStringReplacements(false)
+	for k := range p.Placeholders {
+		if !onDemandPlaceholderRegex.MatchString(k) {
+			return util.NewValidationError(fmt.Sprintf("invalid placeholder name %q", k))
+		}
+		if util.Contains(builtinPlaceholders, fmt.Sprintf("{{%s}}", k)) {
+			return util.NewValidationError(fmt.Sprintf("placeholder %q conflicts with a built-in placeholder", k))
+		}
+	}
+	if p.Path != "" {
+		p.Path = util.CleanPath(p.Path)
+	}
+	return nil
+}
+
+// RunOnDemandRule executes the actions defined for the on demand rule with
+// the specified name. The actions are executed asynchronously
+func RunOnDemandRule(name string, params OnDemandRuleParams, executor, ip string) error {
+	if err := params.validate(); err != nil {
+		return err
+	}
+	rule, err := dataprovider.EventRuleExists(name)
+	if err != nil {
+		return err
+	}
+	if rule.Trigger != dataprovider.EventTriggerOnDemand {
+		return util.NewValidationError(fmt.Sprintf("rule %q cannot be executed on demand", name))
+	}
+	eventParams := EventParams{
+		Name:                  executor,
+		Event:                 onDemandEventName,
+		Status:                1,
+		Protocol:              ProtocolHTTP,
+		IP:                    ip,
+		Timestamp:             time.Now().UnixNano(),
+		updateStatusFromError: true,
+		customPlaceholders:    params.Placeholders,
+	}
+	if params.Path != "" {
+		eventParams.VirtualPath = params.Path
+		eventParams.ObjectName = path.Base(params.Path)
+	}
+	objectType := ""
+	if params.Username != "" {
+		user, err := dataprovider.UserExists(params.Username)
+		if err != nil {
+			return err
+		}
+		if !checkEventConditionPatterns(user.Username, rule.Conditions.Options.Names) ||
+			!checkEventGroupConditionPatters(user.Groups, rule.Conditions.Options.GroupNames) {
+			return util.NewValidationError(fmt.Sprintf("user %q does not match the conditions for rule %q",
+				user.Username, name))
+		}
+		eventParams.Name = user.Username
+		eventParams.Groups = user.Groups
+		eventParams.Metadata = user.Metadata
+		eventParams.sender = user.Username
+		objectType = "user"
+	}
+	if err := rule.CheckActionsConsistency(objectType); err != nil {
+		return util.NewValidationError(fmt.Sprintf("rule %q cannot be executed: %v", name, err))
+	}
+	eventManagerLog(logger.LevelDebug, "executing on demand rule %q, executor: %q, user: %q", rule.Name, executor,
+		params.Username)
+	go executeAsyncRulesActions([]dataprovider.EventRule{rule}, eventParams)
+	return nil
+}
+
 type zipWriterWrapper struct {
 	Name    string
 	Entries map[string]bool
@@ -16,27 +16,39 @@ package common
 
 import (
 	"bytes"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/klauspost/compress/zip"
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
 	"github.com/sftpgo/sdk"
 	sdkkms "github.com/sftpgo/sdk/kms"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
@@ -83,7 +95,7 @@ func TestEventRuleMatch(t *testing.T) {
 	assert.True(t, res)
 	// now test fs events
 	conditions = dataprovider.EventConditions{
-		FsEvents: []string{operationUpload, operationDownload},
+		FsEvents: []string{OperationUpload, operationDownload},
 		Options: dataprovider.ConditionOptions{
 			Names: []dataprovider.ConditionPattern{
 				{
@@ -105,7 +117,7 @@ func TestEventRuleMatch(t *testing.T) {
 	}
 	params := EventParams{
 		Name:        "tester4",
-		Event:       operationDelete,
+		Event:       OperationDelete,
 		VirtualPath: "/path.txt",
 		Protocol:    ProtocolSFTP,
 		ObjectName:  "path.txt",
@@ -153,7 +165,7 @@ func TestEventRuleMatch(t *testing.T) {
 	assert.False(t, res)
 	// check fs events with group name filters
 	conditions = dataprovider.EventConditions{
-		FsEvents: []string{operationUpload, operationDownload},
+		FsEvents: []string{OperationUpload, operationDownload},
 		Options: dataprovider.ConditionOptions{
 			GroupNames: []dataprovider.ConditionPattern{
 				{
@@ -167,7 +179,7 @@ func TestEventRuleMatch(t *testing.T) {
 	}
 	params = EventParams{
 		Name:  "user1",
-		Event: operationUpload,
+		Event: OperationUpload,
 	}
 	res = eventManager.checkFsEventMatch(conditions, params)
 	assert.False(t, res)
@@ -197,6 +209,49 @@ func TestEventRuleMatch(t *testing.T) {
 	assert.True(t, res)
 }
 
+func TestEventRuleRollout(t *testing.T) {
+	rule := dataprovider.EventRule{
+		Name:    "rollout rule",
+		Trigger: dataprovider.EventTriggerFsEvent,
+	}
+	assert.True(t, rule.IsActiveFor("user"))
+	assert.True(t, rule.IsActiveFor(""))
+
+	rule.Conditions.Options.Rollout.Nodes = []string{"node1"}
+	assert.False(t, rule.IsActiveFor("user"))
+	assert.False(t, rule.IsActiveFor(""))
+	status := rule.GetRolloutStatus()
+	assert.True(t, status.IsStaged)
+	assert.Equal(t, 100, status.Percentage)
+	if assert.Len(t, status.Nodes, 2) {
+		assert.True(t, status.Nodes[0].Current)
+		assert.False(t, status.Nodes[0].Active)
+		assert.Equal(t, "node1", status.Nodes[1].Name)
+		assert.True(t, status.Nodes[1].Active)
+		assert.False(t, status.Nodes[1].Online)
+	}
+
+	rule.Conditions.Options.Rollout.Nodes = nil
+	rule.Conditions.Options.Rollout.Percentage = 50
+	numActive := 0
+	for i := 0; i < 1000; i++ {
+		username := fmt.Sprintf("user%d", i)
+		isActive := rule.IsActiveFor(username)
+		// the selection must be stable
+		assert.Equal(t, isActive, rule.IsActiveFor(username))
+		if isActive {
+			numActive++
+		}
+	}
+	assert.Greater(t, numActive, 400)
+	assert.Less(t, numActive, 600)
+	// the percentage applies to the users for filesystem events
+	status = rule.GetRolloutStatus()
+	if assert.Len(t, status.Nodes, 1) {
+		assert.True(t, status.Nodes[0].Active)
+	}
+}
+
 func TestEventManager(t *testing.T) {
 	startEventScheduler()
 	action := &dataprovider.BaseEventAction{
@@ -216,7 +271,7 @@ func TestEventManager(t *testing.T) {
 		Name:    "rule",
 		Trigger: dataprovider.EventTriggerFsEvent,
 		Conditions: dataprovider.EventConditions{
-			FsEvents: []string{operationUpload},
+			FsEvents: []string{OperationUpload},
 		},
 		Actions: []dataprovider.EventAction{
 			{
@@ -411,7 +466,7 @@ func TestEventManagerErrors(t *testing.T) {
 		},
 	})
 	assert.Error(t, err)
-	_, err = getMailAttachments(dataprovider.User{
+	_, _, err = getMailAttachments(dataprovider.User{
 		Groups: []sdk.GroupMapping{
 			{
 				Name: groupName,
@@ -1084,6 +1139,11 @@ func TestEventRuleActionsNoGroupMatching(t *testing.T) {
 	if assert.Error(t, err) {
 		assert.Contains(t, err.Error(), "no retention check executed")
 	}
+	err = executeStorageTransitionRuleAction(dataprovider.EventActionStorageTransitionConfig{}, conditions,
+		&EventParams{}, "")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no storage transition executed")
+	}
 
 	err = dataprovider.DeleteUser(username, "", "")
 	assert.NoError(t, err)
@@ -1110,31 +1170,34 @@ func TestGetFileContent(t *testing.T) {
 	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.txt"), fileContent, 0666)
 	assert.NoError(t, err)
 	replacer := strings.NewReplacer("old", "new")
-	files, err := getMailAttachments(user, []string{"/file.txt"}, replacer)
+	files, closeFn, err := getMailAttachments(user, []string{"/file.txt"}, replacer)
 	assert.NoError(t, err)
 	if assert.Len(t, files, 1) {
-		assert.Equal(t, fileContent, files[0].Data)
+		assert.Equal(t, "file.txt", files[0].Name)
+		assert.Equal(t, int64(len(fileContent)), files[0].Size)
 	}
+	closeFn()
 	// missing file
-	_, err = getMailAttachments(user, []string{"/file1.txt"}, replacer)
+	_, _, err = getMailAttachments(user, []string{"/file1.txt"}, replacer)
 	assert.Error(t, err)
 	// directory
-	_, err = getMailAttachments(user, []string{"/"}, replacer)
+	_, _, err = getMailAttachments(user, []string{"/"}, replacer)
 	assert.Error(t, err)
 	// files too large
-	content := make([]byte, maxAttachmentsSize/2+1)
+	content := make([]byte, smtp.GetMaxAttachmentsSize()/2+1)
 	_, err = rand.Read(content)
 	assert.NoError(t, err)
 	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file1.txt"), content, 0666)
 	assert.NoError(t, err)
 	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file2.txt"), content, 0666)
 	assert.NoError(t, err)
-	files, err = getMailAttachments(user, []string{"/file1.txt"}, replacer)
+	files, closeFn, err = getMailAttachments(user, []string{"/file1.txt"}, replacer)
 	assert.NoError(t, err)
 	if assert.Len(t, files, 1) {
-		assert.Equal(t, content, files[0].Data)
+		assert.Equal(t, int64(len(content)), files[0].Size)
 	}
-	_, err = getMailAttachments(user, []string{"/file1.txt", "/file2.txt"}, replacer)
+	closeFn()
+	_, _, err = getMailAttachments(user, []string{"/file1.txt", "/file2.txt"}, replacer)
 	if assert.Error(t, err) {
 		assert.Contains(t, err.Error(), "size too large")
 	}
@@ -1143,16 +1206,351 @@ func TestGetFileContent(t *testing.T) {
 	user.FsConfig.CryptConfig.Passphrase = kms.NewPlainSecret("pwd")
 	err = dataprovider.UpdateUser(&user, "", "")
 	assert.NoError(t, err)
-	// the file is not encrypted so reading the encryption header will fail
-	_, err = getMailAttachments(user, []string{"/file.txt"}, replacer)
+	// the file is not encrypted, the content is read while sending the email
+	// so the error will be detected at that time
+	files, closeFn, err = getMailAttachments(user, []string{"/file.txt"}, replacer)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	closeFn()
+
+	err = dataprovider.DeleteUser(username, "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestActionConditions(t *testing.T) {
+	params := &EventParams{
+		Name:        "user",
+		FileSize:    100,
+		VirtualPath: "/dir/file.csv",
+	}
+	testCases := []struct {
+		condition dataprovider.ActionCondition
+		expected  bool
+	}{
+		{dataprovider.ActionCondition{Value: "{{Name}}", Operator: "eq", Operand: "user"}, true},
+		{dataprovider.ActionCondition{Value: "{{Name}}", Operator: "ne", Operand: "user"}, false},
+		{dataprovider.ActionCondition{Value: "{{FileSize}}", Operator: "gt", Operand: "99"}, true},
+		{dataprovider.ActionCondition{Value: "{{FileSize}}", Operator: "gt", Operand: "100"}, false},
+		{dataprovider.ActionCondition{Value: "{{FileSize}}", Operator: "ge", Operand: "100"}, true},
+		{dataprovider.ActionCondition{Value: "{{FileSize}}", Operator: "lt", Operand: "100.5"}, true},
+		{dataprovider.ActionCondition{Value: "{{FileSize}}", Operator: "le", Operand: "99"}, false},
+		{dataprovider.ActionCondition{Value: "{{Name}}", Operator: "lt", Operand: "10"}, false},
+		{dataprovider.ActionCondition{Value: "{{FileSize}}", Operator: "lt", Operand: "a"}, false},
+		{dataprovider.ActionCondition{Value: "{{VirtualPath}}", Operator: "match", Operand: "/dir/*.csv"}, true},
+		{dataprovider.ActionCondition{Value: "{{VirtualPath}}", Operator: "not_match", Operand: "/dir/*.csv"}, false},
+		{dataprovider.ActionCondition{Value: "{{VirtualPath}}", Operator: "match", Operand: "[]a]"}, false},
+		{dataprovider.ActionCondition{Value: "{{Name}}", Operator: "unknown", Operand: "1"}, false},
+		{dataprovider.ActionCondition{Value: "{{ActionStatus.missing}}", Operator: "eq", Operand: "KO"}, false},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, checkActionCondition(tc.condition, params), tc.condition.String())
+	}
+
+	var received []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		mu.Lock()
+		received = append(received, r.URL.Path+":"+string(body))
+		mu.Unlock()
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, err = w.Write([]byte(" ready\n"))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	getHTTPAction := func(name, path, body string, order int, conditions ...dataprovider.ActionCondition) dataprovider.EventAction {
+		action := dataprovider.EventAction{
+			BaseEventAction: dataprovider.BaseEventAction{
+				Name: name,
+				Type: dataprovider.ActionTypeHTTP,
+				Options: dataprovider.BaseEventActionOptions{
+					HTTPConfig: dataprovider.EventActionHTTPConfig{
+						Endpoint: server.URL + path,
+						Method:   http.MethodPost,
+						Body:     body,
+						Timeout:  5,
+					},
+				},
+			},
+			Order: order,
+			Options: dataprovider.EventActionOptions{
+				Conditions: conditions,
+			},
+		}
+		action.BaseEventAction.Options.SetEmptySecretsIfNil()
+		return action
+	}
+	actionCheck := getHTTPAction("check", "/check", "{{Name}}", 1)
+	actionNotify := getHTTPAction("notify", "/notify", "{{ActionStatus.check}} {{ActionOutput.check}}", 2,
+		dataprovider.ActionCondition{Value: "{{ActionOutput.check}}", Operator: "eq", Operand: "ready"})
+	actionLarge := getHTTPAction("large file", "/large", "", 3,
+		dataprovider.ActionCondition{Value: "{{FileSize}}", Operator: "gt", Operand: "1048576"})
+	actionFail := getHTTPAction("fail", "/fail", "", 4)
+	actionAfterFail := getHTTPAction("after fail", "/afterfail", "{{ActionStatus.fail}}", 5,
+		dataprovider.ActionCondition{Value: "{{ActionStatus.fail}}", Operator: "eq", Operand: "KO"},
+		dataprovider.ActionCondition{Value: "{{ActionStatus.large file}}", Operator: "eq", Operand: "SKIPPED"})
+	actionFailure := getHTTPAction("failure", "/failure", "", 6,
+		dataprovider.ActionCondition{Value: "{{ActionStatus.notify}}", Operator: "ne", Operand: "OK"})
+	actionFailure.Options.IsFailureAction = true
+	rule := dataprovider.EventRule{
+		Name:    "rule with conditions",
+		Actions: []dataprovider.EventAction{actionCheck, actionNotify, actionLarge, actionFail, actionAfterFail, actionFailure},
+	}
+	executeRuleAsyncActions(rule, params, nil)
+	assert.Equal(t, []string{"/check:user", "/notify:OK ready", "/fail:", "/afterfail:KO"}, received)
+	assert.Equal(t, actionStatusOK, params.actionResults["check"].Status)
+	assert.Equal(t, "ready", params.actionResults["check"].Output)
+	assert.Equal(t, actionStatusOK, params.actionResults["notify"].Status)
+	assert.Equal(t, actionStatusSkipped, params.actionResults["large file"].Status)
+	assert.Equal(t, actionStatusKO, params.actionResults["fail"].Status)
+	assert.Empty(t, params.actionResults["fail"].Output)
+	assert.Equal(t, actionStatusSkipped, params.actionResults["failure"].Status)
+	// the results are copied
+	paramsCopy := params.getACopy()
+	paramsCopy.setActionResult("check", actionStatusKO)
+	assert.Equal(t, actionStatusOK, params.actionResults["check"].Status)
+	// stop on failure
+	received = nil
+	actionFail.Options.StopOnFailure = true
+	rule.Actions = []dataprovider.EventAction{actionCheck, actionFail, actionAfterFail}
+	params = &EventParams{Name: "user"}
+	executeRuleAsyncActions(rule, params, nil)
+	assert.Equal(t, []string{"/check:user", "/fail:"}, received)
+	assert.NotContains(t, params.actionResults, "after fail")
+	// command output
+	if runtime.GOOS != osWindows {
+		params = &EventParams{Name: "user"}
+		action := dataprovider.BaseEventAction{
+			Name: "cmd",
+			Type: dataprovider.ActionTypeCommand,
+			Options: dataprovider.BaseEventActionOptions{
+				CmdConfig: dataprovider.EventActionCommandConfig{
+					Cmd:     "/bin/echo",
+					Args:    []string{"{{Name}}"},
+					Timeout: 10,
+				},
+			},
+		}
+		err := executeRuleActionWithResult(dataprovider.EventAction{BaseEventAction: action}, params,
+			dataprovider.ConditionOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "user", params.actionResults["cmd"].Output)
+	}
+	buf := &limitedBuffer{limit: 3}
+	n, err := buf.Write([]byte("ab"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = buf.Write([]byte("cd"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = buf.Write([]byte("ef"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "abc", buf.String())
+}
+
+func TestHTTPTransformConversion(t *testing.T) {
+	data, err := convertFileContent("csv", []byte("\xEF\xBB\xBFid,name\n1,file1\n2\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{
+		{"id": "1", "name": "file1"},
+		{"id": "2", "name": ""},
+	}, data)
+	data, err = convertFileContent("csv", []byte("id,name\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{}, data)
+	_, err = convertFileContent("csv", nil)
+	assert.Error(t, err)
+	_, err = convertFileContent("csv", []byte("id,\"name\n"))
 	assert.Error(t, err)
 
+	xmlContent := `<?xml version="1.0"?><orders source="sftp"><order id="1">a</order><order id="2">b</order><total>2</total></orders>`
+	data, err = convertFileContent("xml", []byte(xmlContent))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"orders": map[string]any{
+			"@source": "sftp",
+			"order": []any{
+				map[string]any{"@id": "1", "#text": "a"},
+				map[string]any{"@id": "2", "#text": "b"},
+			},
+			"total": "2",
+		},
+	}, data)
+	_, err = convertFileContent("xml", []byte("<?xml version=\"1.0\"?>"))
+	assert.Error(t, err)
+	_, err = convertFileContent("xml", []byte("<orders><order>"))
+	assert.Error(t, err)
+	_, err = convertFileContent("json", []byte("{}"))
+	assert.Error(t, err)
+
+	params := &EventParams{
+		Name:        "user",
+		VirtualPath: "/orders.csv",
+	}
+	rows := []map[string]string{{"id": "1"}}
+	body, err := renderTransformedData(dataprovider.HTTPTransform{}, rows, params)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"id":"1"}]`, string(body))
+	body, err = renderTransformedData(dataprovider.HTTPTransform{
+		Template: `{"user":"{{.Name}}","file":"{{.VirtualPath}}","items":{{json .Data}}}`,
+	}, rows, params)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"user":"user","file":"/orders.csv","items":[{"id":"1"}]}`, string(body))
+	_, err = renderTransformedData(dataprovider.HTTPTransform{
+		Template: "{{.Data",
+	}, rows, params)
+	assert.Error(t, err)
+	_, err = renderTransformedData(dataprovider.HTTPTransform{
+		Template: "{{index .Data 5}}",
+	}, rows, params)
+	assert.Error(t, err)
+}
+
+func TestHTTPTransformRuleAction(t *testing.T) {
+	username := "test_user_http_transform"
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+			HomeDir: filepath.Join(os.TempDir(), username),
+		},
+	}
+	err := dataprovider.AddUser(&user, "", "")
+	assert.NoError(t, err)
+	err = os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.csv"), []byte("a,b\n1,2\n"), 0666)
+	assert.NoError(t, err)
+
+	c := dataprovider.EventActionHTTPConfig{
+		Endpoint: "http://127.0.0.1:9999/",
+		Method:   http.MethodPost,
+		Transform: dataprovider.HTTPTransform{
+			Filepath: "/{{VirtualPath}}",
+			Format:   "csv",
+		},
+	}
+	params := &EventParams{
+		Name:        username,
+		VirtualPath: "file.csv",
+		sender:      username,
+	}
+	replacer := strings.NewReplacer(params.getStringReplacements(false)...)
+	body, err := getTransformedHTTPBody(c.Transform, replacer, user, params)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"a":"1","b":"2"}]`, string(body))
+	// missing file
+	params.VirtualPath = "missing.csv"
+	err = executeHTTPRuleAction(c, params, "")
+	assert.Error(t, err)
+	// invalid content
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.xml"), []byte("<a>"), 0666)
+	assert.NoError(t, err)
+	params.VirtualPath = "file.xml"
+	c.Transform.Format = "xml"
+	err = executeHTTPRuleAction(c, params, "")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to convert")
+	}
+	// file too large
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.xml"), make([]byte, maxTransformFileSize+1), 0666)
+	assert.NoError(t, err)
+	err = executeHTTPRuleAction(c, params, "")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "maximum allowed size")
+	}
+	// the user does not exist anymore
 	err = dataprovider.DeleteUser(username, "", "")
 	assert.NoError(t, err)
+	err = executeHTTPRuleAction(c, params, "")
+	assert.Error(t, err)
+
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
 }
 
+func TestPublishRuleAction(t *testing.T) {
+	natsServer, err := natsserver.NewServer(&natsserver.Options{
+		Host:   "127.0.0.1",
+		Port:   -1,
+		NoLog:  true,
+		NoSigs: true,
+	})
+	require.NoError(t, err)
+	go natsServer.Start()
+	defer natsServer.Shutdown()
+	require.True(t, natsServer.ReadyForConnections(5*time.Second))
+
+	nc, err := nats.Connect(natsServer.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+	sub, err := nc.SubscribeSync("sftpgo.>")
+	require.NoError(t, err)
+	require.NoError(t, nc.Flush())
+
+	c := dataprovider.EventActionPublishConfig{
+		Broker:   "nats",
+		Endpoint: natsServer.ClientURL(),
+		Topic:    "sftpgo.{{Event}}",
+		Headers: []dataprovider.KeyValue{
+			{
+				Key:   "user",
+				Value: "{{Name}}",
+			},
+		},
+		Timeout: 5,
+	}
+	params := &EventParams{
+		Name:        "test_user",
+		Event:       OperationUpload,
+		Status:      1,
+		VirtualPath: "/dir/file.txt",
+		FileSize:    123,
+		Protocol:    ProtocolSFTP,
+		Timestamp:   time.Now().UnixNano(),
+	}
+	err = executePublishRuleAction(c, params)
+	assert.NoError(t, err)
+	msg, err := sub.NextMsg(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "sftpgo.upload", msg.Subject)
+	assert.Equal(t, "test_user", msg.Header.Get("user"))
+	var payload map[string]any
+	err = json.Unmarshal(msg.Data, &payload)
+	assert.NoError(t, err)
+	assert.Equal(t, OperationUpload, payload["event"])
+	assert.Equal(t, "test_user", payload["name"])
+	assert.Equal(t, "/dir/file.txt", payload["virtual_path"])
+	assert.Equal(t, float64(123), payload["file_size"])
+	assert.NotContains(t, payload, "object")
+	// custom body
+	c.Body = "{{Name}} {{VirtualPath}}"
+	err = executePublishRuleAction(c, params)
+	assert.NoError(t, err)
+	msg, err = sub.NextMsg(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "test_user /dir/file.txt", string(msg.Data))
+	// invalid password
+	c.Password = kms.NewSecret(sdkkms.SecretStatusSecretBox, "payload", "key", "data")
+	err = executePublishRuleAction(c, params)
+	assert.Error(t, err)
+	// unreachable broker
+	c.Password = kms.NewEmptySecret()
+	c.Endpoint = "nats://127.0.0.1:4"
+	err = executePublishRuleAction(c, params)
+	assert.ErrorContains(t, err, "unable to publish message")
+}
+
 func TestFilesystemActionErrors(t *testing.T) {
 	err := executeFsRuleAction(dataprovider.EventActionFilesystemConfig{}, dataprovider.ConditionOptions{}, &EventParams{})
 	if assert.Error(t, err) {
@@ -1188,6 +1586,14 @@ func TestFilesystemActionErrors(t *testing.T) {
 		sender: username,
 	})
 	assert.Error(t, err)
+	err = executeEmailRuleAction(dataprovider.EventActionEmailConfig{
+		Recipients: []string{"test@example.net"},
+		Subject:    "subject",
+		Template:   "missing.html",
+	}, &EventParams{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to render email template")
+	}
 	conn := NewBaseConnection("", protocolEventAction, "", "", user)
 	err = executeDeleteFileFsAction(conn, "", nil)
 	assert.Error(t, err)
@@ -1215,8 +1621,6 @@ func TestFilesystemActionErrors(t *testing.T) {
 		sender: username,
 	})
 	assert.Error(t, err)
-	_, err = getFileContent(NewBaseConnection("", protocolEventAction, "", "", user), "/f.txt", 1234)
-	assert.Error(t, err)
 	err = executeHTTPRuleAction(dataprovider.EventActionHTTPConfig{
 		Endpoint: "http://127.0.0.1:9999/",
 		Method:   http.MethodPost,
@@ -1228,7 +1632,7 @@ func TestFilesystemActionErrors(t *testing.T) {
 		},
 	}, &EventParams{
 		sender: username,
-	})
+	}, "")
 	assert.Error(t, err)
 	user.FsConfig.Provider = sdk.LocalFilesystemProvider
 	user.Permissions["/"] = []string{dataprovider.PermUpload}
@@ -1505,11 +1909,13 @@ func TestScheduledActions(t *testing.T) {
 
 func TestEventParamsCopy(t *testing.T) {
 	params := EventParams{
-		Name:            "name",
-		Event:           "event",
-		Status:          1,
-		errors:          []string{"error1"},
-		retentionChecks: []executedRetentionCheck{},
+		Name:               "name",
+		Event:              "event",
+		Status:             1,
+		errors:             []string{"error1"},
+		retentionChecks:    []executedRetentionCheck{},
+		storageTransitions: []executedStorageTransition{},
+		integrityChecks:    []executedIntegrityCheck{},
 	}
 	paramsCopy := params.getACopy()
 	assert.Equal(t, params, *paramsCopy)
@@ -1555,6 +1961,392 @@ func TestEventParamsCopy(t *testing.T) {
 	assert.Equal(t, "a_copy", paramsCopy.retentionChecks[0].ActionName)
 	assert.Equal(t, "p_copy", paramsCopy.retentionChecks[0].Results[0].Path)
 	assert.Equal(t, 2, paramsCopy.retentionChecks[0].Results[0].Retention)
+	params = EventParams{
+		storageTransitions: []executedStorageTransition{
+			{
+				Username:   "u",
+				ActionName: "a",
+				TotalFiles: 1,
+				Results: []storageTransitionResult{
+					{
+						Path: "p",
+						Size: 10,
+					},
+				},
+			},
+		},
+	}
+	paramsCopy = params.getACopy()
+	require.Len(t, paramsCopy.storageTransitions, 1)
+	paramsCopy.storageTransitions[0].Username = "u_copy"
+	require.Len(t, paramsCopy.storageTransitions[0].Results, 1)
+	paramsCopy.storageTransitions[0].Results[0].Path = "p_copy"
+	assert.Equal(t, "u", params.storageTransitions[0].Username)
+	assert.Equal(t, "p", params.storageTransitions[0].Results[0].Path)
+	assert.Equal(t, "u_copy", paramsCopy.storageTransitions[0].Username)
+	assert.Equal(t, "p_copy", paramsCopy.storageTransitions[0].Results[0].Path)
+	assert.Equal(t, 1, paramsCopy.storageTransitions[0].TotalFiles)
+	params = EventParams{
+		integrityChecks: []executedIntegrityCheck{
+			{
+				Username:     "u",
+				CheckedFiles: 1,
+				Results: []integrityCheckResult{
+					{
+						Path:   "p",
+						Status: integrityStatusCorrupted,
+					},
+				},
+			},
+		},
+	}
+	paramsCopy = params.getACopy()
+	require.Len(t, paramsCopy.integrityChecks, 1)
+	paramsCopy.integrityChecks[0].Username = "u_copy"
+	require.Len(t, paramsCopy.integrityChecks[0].Results, 1)
+	paramsCopy.integrityChecks[0].Results[0].Path = "p_copy"
+	assert.Equal(t, "u", params.integrityChecks[0].Username)
+	assert.Equal(t, "p", params.integrityChecks[0].Results[0].Path)
+	assert.Equal(t, "u_copy", paramsCopy.integrityChecks[0].Username)
+	assert.Equal(t, "p_copy", paramsCopy.integrityChecks[0].Results[0].Path)
+	assert.Equal(t, 1, paramsCopy.integrityChecks[0].CheckedFiles)
+}
+
+func TestStorageTransitionSavings(t *testing.T) {
+	savings, ok := getStorageTransitionSavings(sdk.S3FilesystemProvider, "STANDARD", "GLACIER", 1024*1024*1024)
+	assert.True(t, ok)
+	assert.InDelta(t, 0.0194, savings, 0.00001)
+	_, ok = getStorageTransitionSavings(sdk.S3FilesystemProvider, "DEEP_ARCHIVE", "STANDARD_IA", 1024)
+	assert.False(t, ok)
+	_, ok = getStorageTransitionSavings(sdk.AzureBlobFilesystemProvider, "Cool", "Cool", 1024)
+	assert.False(t, ok)
+	savings, ok = getStorageTransitionSavings(sdk.GCSFilesystemProvider, "STANDARD", "NEARLINE", 10*1024*1024*1024)
+	assert.True(t, ok)
+	assert.InDelta(t, 0.1, savings, 0.00001)
+	// unknown storage classes are allowed with no savings estimation
+	savings, ok = getStorageTransitionSavings(sdk.S3FilesystemProvider, "CUSTOM", "GLACIER", 1024)
+	assert.True(t, ok)
+	assert.Equal(t, float64(0), savings)
+	savings, ok = getStorageTransitionSavings(sdk.LocalFilesystemProvider, "STANDARD", "GLACIER", 1024)
+	assert.True(t, ok)
+	assert.Equal(t, float64(0), savings)
+
+	transition := executedStorageTransition{
+		Username:   "user",
+		ActionName: "action",
+	}
+	transition.addResult(storageTransitionResult{
+		Path:               "/file1",
+		Size:               100,
+		ModTime:            time.Now(),
+		StorageClass:       "STANDARD",
+		TargetStorageClass: "GLACIER",
+		EstimatedSavings:   0.5,
+		Status:             storageTransitionStatusDryRun,
+	})
+	transition.addResult(storageTransitionResult{
+		Path:               "/file2",
+		Size:               50,
+		TargetStorageClass: "GLACIER",
+		Status:             storageTransitionStatusFailed,
+		Error:              "unable to get storage class",
+	})
+	assert.Equal(t, 2, transition.TotalFiles)
+	assert.Equal(t, int64(150), transition.TotalSize)
+	assert.InDelta(t, 0.5, transition.EstimatedSavings, 0.00001)
+	data, err := transition.getCSVReport()
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "/file1,100,")
+	assert.Contains(t, string(data), "STANDARD,GLACIER,0.5000,dry run,")
+	assert.Contains(t, string(data), "failed,unable to get storage class")
+	assert.Contains(t, string(data), "total,150,,,,0.5000,2 objects,")
+
+	params := EventParams{}
+	_, err = params.getCompressedReport(dataprovider.StorageTransitionReportPlaceHolder)
+	assert.Error(t, err)
+	params.storageTransitions = append(params.storageTransitions, transition)
+	f, err := params.getReportsAsMailAttachment(dataprovider.StorageTransitionReportPlaceHolder)
+	assert.NoError(t, err)
+	assert.Equal(t, "storage-transition-reports.zip", f.Name)
+	assert.Greater(t, len(f.Data), 0)
+	_, err = params.getReportsAsMailAttachment(dataprovider.RetentionReportPlaceHolder)
+	assert.Error(t, err)
+}
+
+func TestStorageTransitionRuleAction(t *testing.T) {
+	username := "test_user_storage_transition"
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+			HomeDir: filepath.Join(os.TempDir(), username),
+		},
+	}
+	err := dataprovider.AddUser(&user, "", "")
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "sub"), os.ModePerm)
+	assert.NoError(t, err)
+	filePath := filepath.Join(user.GetHomeDir(), "sub", "file.txt")
+	err = os.WriteFile(filePath, []byte("content"), 0666)
+	assert.NoError(t, err)
+	oldTime := time.Now().Add(-240 * time.Hour)
+	err = os.Chtimes(filePath, oldTime, oldTime)
+	assert.NoError(t, err)
+
+	config := dataprovider.EventActionStorageTransitionConfig{
+		Folders: []dataprovider.FolderStorageTransition{
+			{
+				Path:           "/",
+				Age:            1,
+				S3StorageClass: "GLACIER",
+			},
+			{
+				Path: "/sub",
+				Age:  0,
+			},
+			{
+				Path:           "/missing",
+				Age:            1,
+				S3StorageClass: "GLACIER",
+			},
+		},
+		DryRun: true,
+	}
+	params := &EventParams{
+		sender: username,
+	}
+	// the local filesystem does not support storage classes, nothing to do
+	err = executeStorageTransitionRuleAction(config, dataprovider.ConditionOptions{}, params, "transition")
+	assert.NoError(t, err)
+	if assert.Len(t, params.storageTransitions, 1) {
+		assert.Equal(t, username, params.storageTransitions[0].Username)
+		assert.Equal(t, "transition", params.storageTransitions[0].ActionName)
+		assert.Equal(t, 0, params.storageTransitions[0].TotalFiles)
+	}
+	_, err = params.getCompressedReport(dataprovider.StorageTransitionReportPlaceHolder)
+	assert.NoError(t, err)
+
+	assert.True(t, storageTransitions.add(username))
+	err = executeStorageTransitionRuleAction(config, dataprovider.ConditionOptions{}, &EventParams{sender: username},
+		"transition")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "storage transition failed for users")
+	}
+	storageTransitions.remove(username)
+
+	conditions := dataprovider.ConditionOptions{
+		Names: []dataprovider.ConditionPattern{
+			{
+				Pattern: "no_match",
+			},
+		},
+	}
+	err = executeStorageTransitionRuleAction(config, conditions, &EventParams{}, "transition")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no storage transition executed")
+	}
+
+	err = dataprovider.DeleteUser(username, "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestIntegrityCheckReport(t *testing.T) {
+	check := executedIntegrityCheck{
+		Username:      "user",
+		ActionName:    "action",
+		CheckedFiles:  3,
+		CheckedSize:   150,
+		VerifiedFiles: 1,
+	}
+	check.addResult(integrityCheckResult{
+		Path:      "/file1",
+		Size:      100,
+		ModTime:   time.Now(),
+		Status:    integrityStatusCorrupted,
+		Algorithm: vfs.ChecksumAlgoMD5,
+		Expected:  "expected",
+		Actual:    "actual",
+	})
+	check.addResult(integrityCheckResult{
+		Path:   "/file2",
+		Size:   50,
+		Status: integrityStatusFailed,
+		Error:  "unable to read file",
+	})
+	check.addResult(integrityCheckResult{
+		Path:   "/file3",
+		Status: integrityStatusMissing,
+	})
+	assert.Equal(t, 1, check.CorruptedFiles)
+	assert.Equal(t, 1, check.FailedFiles)
+	assert.Equal(t, 1, check.MissingFiles)
+	assert.Equal(t, 3, check.getNumProblems())
+	data, err := check.getCSVReport()
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "/file1,100,")
+	assert.Contains(t, string(data), "corrupted,md5,expected,actual,")
+	assert.Contains(t, string(data), "failed,,,,unable to read file")
+	assert.Contains(t, string(data), "/file3,0,,missing,")
+	assert.Contains(t, string(data), "total,150,,\"3 checked, 1 verified, 0 recorded, 0 skipped, 1 corrupted, 1 missing, 1 failed\"")
+
+	params := EventParams{}
+	_, err = params.getCompressedReport(dataprovider.IntegrityCheckReportPlaceHolder)
+	assert.Error(t, err)
+	params.integrityChecks = append(params.integrityChecks, check)
+	f, err := params.getReportsAsMailAttachment(dataprovider.IntegrityCheckReportPlaceHolder)
+	assert.NoError(t, err)
+	assert.Equal(t, "integrity-check-reports.zip", f.Name)
+	assert.Greater(t, len(f.Data), 0)
+
+	_, err = getChecksumHasher("unknown")
+	assert.Error(t, err)
+}
+
+func TestIntegrityCheckRuleAction(t *testing.T) {
+	username := "test_user_integrity_check"
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+			HomeDir: filepath.Join(os.TempDir(), username),
+		},
+	}
+	err := dataprovider.AddUser(&user, "", "")
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "sub"), os.ModePerm)
+	assert.NoError(t, err)
+	file1 := filepath.Join(user.GetHomeDir(), "file1.txt")
+	file2 := filepath.Join(user.GetHomeDir(), "sub", "file2.txt")
+	err = os.WriteFile(file1, []byte("content1"), 0666)
+	assert.NoError(t, err)
+	err = os.WriteFile(file2, []byte("content2"), 0666)
+	assert.NoError(t, err)
+	manifestsPath := filepath.Join(os.TempDir(), "integrity_manifests")
+
+	// the local filesystem does not record checksums and no manifest is configured, nothing to verify
+	params := &EventParams{
+		sender: username,
+	}
+	err = executeIntegrityCheckRuleAction(dataprovider.EventActionIntegrityCheckConfig{Paths: []string{"/"}},
+		dataprovider.ConditionOptions{}, params, "integrity")
+	assert.NoError(t, err)
+	if assert.Len(t, params.integrityChecks, 1) {
+		assert.Equal(t, username, params.integrityChecks[0].Username)
+		assert.Equal(t, "integrity", params.integrityChecks[0].ActionName)
+		assert.Equal(t, 2, params.integrityChecks[0].CheckedFiles)
+		assert.Equal(t, 2, params.integrityChecks[0].SkippedFiles)
+	}
+
+	config := dataprovider.EventActionIntegrityCheckConfig{
+		Paths:         []string{"/", "/sub"},
+		ManifestsPath: manifestsPath,
+	}
+	params = &EventParams{
+		sender: username,
+	}
+	err = executeIntegrityCheckRuleAction(config, dataprovider.ConditionOptions{}, params, "integrity")
+	assert.NoError(t, err)
+	if assert.Len(t, params.integrityChecks, 1) {
+		assert.Equal(t, 2, params.integrityChecks[0].CheckedFiles)
+		assert.Equal(t, 2, params.integrityChecks[0].RecordedFiles)
+		assert.Equal(t, 0, params.integrityChecks[0].VerifiedFiles)
+	}
+	assert.FileExists(t, getIntegrityManifestPath(manifestsPath, username))
+
+	params = &EventParams{
+		sender: username,
+	}
+	err = executeIntegrityCheckRuleAction(config, dataprovider.ConditionOptions{}, params, "integrity")
+	assert.NoError(t, err)
+	if assert.Len(t, params.integrityChecks, 1) {
+		assert.Equal(t, 2, params.integrityChecks[0].VerifiedFiles)
+		assert.Equal(t, 0, params.integrityChecks[0].RecordedFiles)
+	}
+	// change the content and preserve size and modification time
+	info, err := os.Stat(file1)
+	assert.NoError(t, err)
+	err = os.WriteFile(file1, []byte("content3"), 0666)
+	assert.NoError(t, err)
+	err = os.Chtimes(file1, info.ModTime(), info.ModTime())
+	assert.NoError(t, err)
+	err = os.Remove(file2)
+	assert.NoError(t, err)
+	params = &EventParams{
+		sender: username,
+	}
+	err = executeIntegrityCheckRuleAction(config, dataprovider.ConditionOptions{}, params, "integrity")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "integrity check failed for users")
+	}
+	if assert.Len(t, params.integrityChecks, 1) {
+		assert.Equal(t, 1, params.integrityChecks[0].CorruptedFiles)
+		assert.Equal(t, 1, params.integrityChecks[0].MissingFiles)
+		if assert.Len(t, params.integrityChecks[0].Results, 2) {
+			for _, result := range params.integrityChecks[0].Results {
+				switch result.Path {
+				case "/file1.txt":
+					assert.Equal(t, integrityStatusCorrupted, result.Status)
+				case "/sub/file2.txt":
+					assert.Equal(t, integrityStatusMissing, result.Status)
+				default:
+					t.Errorf("unexpected path %q", result.Path)
+				}
+			}
+		}
+	}
+	_, err = params.getCompressedReport(dataprovider.IntegrityCheckReportPlaceHolder)
+	assert.NoError(t, err)
+	// a modified file is recorded again
+	err = os.Chtimes(file1, time.Now(), time.Now())
+	assert.NoError(t, err)
+	params = &EventParams{
+		sender: username,
+	}
+	err = executeIntegrityCheckRuleAction(config, dataprovider.ConditionOptions{}, params, "integrity")
+	assert.NoError(t, err)
+	if assert.Len(t, params.integrityChecks, 1) {
+		assert.Equal(t, 1, params.integrityChecks[0].RecordedFiles)
+		assert.Equal(t, 0, params.integrityChecks[0].getNumProblems())
+	}
+
+	err = os.WriteFile(getIntegrityManifestPath(manifestsPath, username), []byte("invalid json"), 0600)
+	assert.NoError(t, err)
+	err = executeIntegrityCheckRuleAction(config, dataprovider.ConditionOptions{}, &EventParams{sender: username},
+		"integrity")
+	assert.Error(t, err)
+
+	assert.True(t, integrityChecks.add(username))
+	err = executeIntegrityCheckRuleAction(config, dataprovider.ConditionOptions{}, &EventParams{sender: username},
+		"integrity")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "integrity check failed for users")
+	}
+	integrityChecks.remove(username)
+
+	conditions := dataprovider.ConditionOptions{
+		Names: []dataprovider.ConditionPattern{
+			{
+				Pattern: "no_match",
+			},
+		},
+	}
+	err = executeIntegrityCheckRuleAction(config, conditions, &EventParams{}, "integrity")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no integrity check executed")
+	}
+
+	err = dataprovider.DeleteUser(username, "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(manifestsPath)
+	assert.NoError(t, err)
 }
 
 func TestEventParamsStatusFromError(t *testing.T) {
@@ -1567,6 +2359,27 @@ func TestEventParamsStatusFromError(t *testing.T) {
 	assert.Equal(t, 2, params.Status)
 }
 
+func TestEventParamsMetadata(t *testing.T) {
+	metadata := util.Metadata{
+		"crm_id":   {Value: "C-1234"},
+		"billable": {Type: util.MetadataTypeBoolean, Value: "true"},
+	}
+	params := EventParams{
+		Name:     "user",
+		Metadata: metadata,
+	}
+	replacer := strings.NewReplacer(params.getStringReplacements(false)...)
+	assert.Equal(t, "user C-1234 true {{Metadata.missing}}",
+		replacer.Replace("{{Name}} {{Metadata.crm_id}} {{Metadata.billable}} {{Metadata.missing}}"))
+	data := getTemplateData(params.getStringReplacements(false))
+	assert.Equal(t, "C-1234", data["Metadata.crm_id"])
+
+	assert.Equal(t, metadata, getObjectMetadata(&dataprovider.User{Metadata: metadata}))
+	assert.Equal(t, metadata, getObjectMetadata(&dataprovider.Group{Metadata: metadata}))
+	assert.Nil(t, getObjectMetadata(&dataprovider.Admin{}))
+	assert.Nil(t, getObjectMetadata(nil))
+}
+
 type testWriter struct {
 	errTest  error
 	sentinel string
@@ -1598,6 +2411,325 @@ func TestWriteHTTPPartsError(t *testing.T) {
 	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
 }
 
+func TestSignedHTTPRuleAction(t *testing.T) {
+	httpConfig := httpclient.Config{
+		Timeout: 5,
+		Signature: httpclient.SignatureConfig{
+			Secret: "hook signature secret",
+		},
+	}
+	err := httpConfig.Initialize(configDir)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		httpConfig.Signature.Secret = ""
+		err := httpConfig.Initialize(configDir)
+		assert.NoError(t, err)
+	})
+
+	params := &EventParams{
+		Name:  "user",
+		Event: "upload",
+	}
+	endpoint := "http://127.0.0.1:9999/signed"
+	err = executeHTTPRuleAction(dataprovider.EventActionHTTPConfig{
+		Endpoint: endpoint,
+		Method:   http.MethodGet,
+		Timeout:  10,
+	}, params, "")
+	assert.NoError(t, err)
+	err = executeHTTPRuleAction(dataprovider.EventActionHTTPConfig{
+		Endpoint: endpoint,
+		Method:   http.MethodPost,
+		Timeout:  10,
+		Body:     `{"event":"{{Event}}","name":"{{Name}}"}`,
+	}, params, "")
+	assert.NoError(t, err)
+	err = executeHTTPRuleAction(dataprovider.EventActionHTTPConfig{
+		Endpoint: endpoint,
+		Method:   http.MethodPost,
+		Timeout:  10,
+		Parts: []dataprovider.HTTPPart{
+			{
+				Name: "p1",
+				Body: "{{Event}}",
+			},
+		},
+	}, params, "")
+	assert.NoError(t, err)
+}
+
+func TestHTTPRuleActionRetriesAndDeadLetters(t *testing.T) {
+	oldWaitMin := httpActionRetryWaitMin
+	oldWaitMax := httpActionRetryWaitMax
+	httpActionRetryWaitMin = time.Millisecond
+	httpActionRetryWaitMax = 5 * time.Millisecond
+	t.Cleanup(func() {
+		httpActionRetryWaitMin = oldWaitMin
+		httpActionRetryWaitMax = oldWaitMax
+	})
+	oldProviderConf := dataprovider.GetProviderConfig()
+	providerConf := dataprovider.GetProviderConfig()
+	providerConf.DeadLetters.Enabled = true
+	err := dataprovider.Close()
+	assert.NoError(t, err)
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	require.NoError(t, err)
+	startTimestamp := util.GetTimeAsMsSinceEpoch(time.Now())
+
+	signingSecret := "action signing secret"
+	actionPassword := "action password"
+	var replayEnabled atomic.Bool
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		mu.Lock()
+		attempts[r.URL.Path]++
+		count := attempts[r.URL.Path]
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/unavailable":
+			if count < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/bad":
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		case "/down":
+			if !replayEnabled.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if _, pwd, ok := r.BasicAuth(); !ok || pwd != actionPassword {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		case "/signed":
+			mac := hmac.New(sha256.New, []byte(signingSecret))
+			mac.Write([]byte(strings.Join([]string{r.Header.Get("X-SFTPGo-Timestamp"), r.Header.Get("X-SFTPGo-Nonce"),
+				r.Method, r.URL.RequestURI(), httpclient.GetPayloadHash(body)}, "\n")))
+			expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+			if !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-SFTPGo-Signature"))) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	params := &EventParams{
+		Name:  "user",
+		Event: "upload",
+	}
+	err = executeHTTPRuleAction(dataprovider.EventActionHTTPConfig{
+		Endpoint: server.URL + "/unavailable",
+		Method:   http.MethodPost,
+		Timeout:  5,
+		Body:     "{{Name}}",
+		Retries:  2,
+	}, params, "unavailable")
+	assert.NoError(t, err)
+	err = executeHTTPRuleAction(dataprovider.EventActionHTTPConfig{
+		Endpoint: server.URL + "/bad",
+		Method:   http.MethodPost,
+		Timeout:  5,
+		Retries:  3,
+	}, params, "bad")
+	assert.ErrorContains(t, err, "unexpected status code: 400")
+	err = executeHTTPRuleAction(dataprovider.EventActionHTTPConfig{
+		Endpoint:      server.URL + "/signed",
+		Method:        http.MethodPost,
+		Timeout:       5,
+		Body:          `{"event":"{{Event}}"}`,
+		SigningSecret: kms.NewPlainSecret(signingSecret),
+	}, params, "signed")
+	assert.NoError(t, err)
+	err = executeHTTPRuleAction(dataprovider.EventActionHTTPConfig{
+		Endpoint: server.URL + "/signed",
+		Method:   http.MethodPost,
+		Timeout:  5,
+		Body:     `{"event":"{{Event}}"}`,
+	}, params, "unsigned")
+	assert.ErrorContains(t, err, "unexpected status code: 403")
+
+	action := dataprovider.BaseEventAction{
+		Name: "down action",
+		Type: dataprovider.ActionTypeHTTP,
+		Options: dataprovider.BaseEventActionOptions{
+			HTTPConfig: dataprovider.EventActionHTTPConfig{
+				Endpoint: server.URL + "/down",
+				Username: "{{Name}}",
+				Password: kms.NewPlainSecret(actionPassword),
+				Headers: []dataprovider.KeyValue{
+					{
+						Key:   "X-Event",
+						Value: "{{Event}}",
+					},
+				},
+				Method:  http.MethodPost,
+				Timeout: 5,
+				Body:    `{"name":"{{Name}}"}`,
+				Retries: 1,
+			},
+		},
+	}
+	err = dataprovider.AddEventAction(&action, "", "")
+	require.NoError(t, err)
+	action, err = dataprovider.EventActionExists(action.Name)
+	require.NoError(t, err)
+	err = executeHTTPRuleAction(action.Options.HTTPConfig, params, action.Name)
+	assert.ErrorContains(t, err, "unexpected status code: 503")
+
+	mu.Lock()
+	assert.Equal(t, 3, attempts["/unavailable"])
+	assert.Equal(t, 1, attempts["/bad"])
+	assert.Equal(t, 2, attempts["/down"])
+	mu.Unlock()
+
+	entries, err := dataprovider.GetDeadLetters(dataprovider.DeadLetterFilters{
+		Source:         dataprovider.DeadLetterSourceEventAction,
+		StartTimestamp: startTimestamp,
+	}, 100, 0, dataprovider.OrderASC)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "bad", entries[0].Name)
+	assert.Equal(t, 1, entries[0].Attempts)
+	assert.Equal(t, "unsigned", entries[1].Name)
+	entry := entries[2]
+	assert.Equal(t, action.Name, entry.Name)
+	assert.Equal(t, server.URL+"/down", entry.URL)
+	assert.Equal(t, http.MethodPost, entry.Method)
+	assert.Equal(t, "user", entry.Username)
+	assert.Equal(t, `{"name":"user"}`, entry.Body)
+	assert.Equal(t, "unexpected status code: 503", entry.Error)
+	assert.Equal(t, 2, entry.Attempts)
+	assert.Contains(t, entry.Headers, dataprovider.KeyValue{Key: "X-Event", Value: "upload"})
+	for _, h := range entry.Headers {
+		assert.NotEqual(t, "Authorization", h.Key)
+	}
+	// the receiver is still down
+	err = ReplayDeadLetter(entry.ID)
+	assert.ErrorContains(t, err, "unexpected status code: 503")
+	replayEnabled.Store(true)
+	err = ReplayDeadLetter(entry.ID)
+	assert.NoError(t, err)
+	_, err = dataprovider.GetDeadLetter(entry.ID)
+	assert.ErrorAs(t, err, new(*util.RecordNotFoundError))
+	// the action for this dead letter does not exist
+	err = ReplayDeadLetter(entries[0].ID)
+	assert.ErrorContains(t, err, "unable to replay dead letter")
+	err = ReplayDeadLetter(entry.ID)
+	assert.ErrorAs(t, err, new(*util.RecordNotFoundError))
+
+	dataprovider.AddHookDeadLetter(dataprovider.DeadLetterSourceFsHook, "upload", server.URL+"/hook",
+		[]byte(`{"action":"upload"}`), http.StatusServiceUnavailable, errors.New("unexpected response"))
+	entries, err = dataprovider.GetDeadLetters(dataprovider.DeadLetterFilters{
+		Source:         dataprovider.DeadLetterSourceFsHook,
+		StartTimestamp: startTimestamp,
+	}, 100, 0, dataprovider.OrderASC)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "unexpected status code: 503", entries[0].Error)
+	err = ReplayDeadLetter(entries[0].ID)
+	assert.NoError(t, err)
+	mu.Lock()
+	assert.Equal(t, 1, attempts["/hook"])
+	mu.Unlock()
+
+	entries, err = dataprovider.GetDeadLetters(dataprovider.DeadLetterFilters{
+		StartTimestamp: startTimestamp,
+	}, 100, 0, dataprovider.OrderASC)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+	for _, entry := range entries {
+		err = dataprovider.DeleteDeadLetter(entry.ID)
+		assert.NoError(t, err)
+	}
+	err = dataprovider.DeleteEventAction(action.Name, "", "")
+	assert.NoError(t, err)
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = dataprovider.Initialize(oldProviderConf, configDir, true)
+	assert.NoError(t, err)
+}
+
+func TestHTTPRuleActionSyncRetries(t *testing.T) {
+	oldWaitMin := httpActionRetryWaitMin
+	oldWaitMax := httpActionRetryWaitMax
+	oldSyncMaxTime := httpActionSyncRetryMaxTime
+	httpActionRetryWaitMin = 200 * time.Millisecond
+	httpActionRetryWaitMax = time.Second
+	httpActionSyncRetryMaxTime = 500 * time.Millisecond
+	t.Cleanup(func() {
+		httpActionRetryWaitMin = oldWaitMin
+		httpActionRetryWaitMax = oldWaitMax
+		httpActionSyncRetryMaxTime = oldSyncMaxTime
+	})
+
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	httpConfig := dataprovider.EventActionHTTPConfig{
+		Endpoint: server.URL,
+		Method:   http.MethodGet,
+		Timeout:  5,
+		Retries:  10,
+	}
+	// the second retry would exceed the maximum sync retry time
+	params := &EventParams{
+		Name:          "user",
+		syncExecution: true,
+	}
+	startTime := time.Now()
+	err := executeHTTPRuleAction(httpConfig, params, "")
+	assert.ErrorContains(t, err, "unexpected status code: 503")
+	assert.Less(t, time.Since(startTime), httpActionSyncRetryMaxTime)
+	assert.Equal(t, int32(2), attempts.Load())
+	// async actions are retried up to the configured limit
+	attempts.Store(0)
+	httpConfig.Retries = 3
+	params.syncExecution = false
+	startTime = time.Now()
+	err = executeHTTPRuleAction(httpConfig, params, "")
+	assert.ErrorContains(t, err, "unexpected status code: 503")
+	assert.GreaterOrEqual(t, time.Since(startTime), 200*time.Millisecond+400*time.Millisecond+800*time.Millisecond)
+	assert.Equal(t, int32(4), attempts.Load())
+	// sync rules actions
+	attempts.Store(0)
+	httpConfig.Retries = 10
+	rules := []dataprovider.EventRule{
+		{
+			Name: "sync rule",
+			Actions: []dataprovider.EventAction{
+				{
+					BaseEventAction: dataprovider.BaseEventAction{
+						Name: "sync action",
+						Type: dataprovider.ActionTypeHTTP,
+						Options: dataprovider.BaseEventActionOptions{
+							HTTPConfig: httpConfig,
+						},
+					},
+					Options: dataprovider.EventActionOptions{
+						ExecuteSync: true,
+					},
+				},
+			},
+		},
+	}
+	startTime = time.Now()
+	err = executeSyncRulesActions(rules, EventParams{Name: "user"})
+	assert.ErrorContains(t, err, "unexpected status code: 503")
+	assert.Less(t, time.Since(startTime), httpActionSyncRetryMaxTime)
+	assert.Equal(t, int32(2), attempts.Load())
+}
+
 func TestReplacePathsPlaceholders(t *testing.T) {
 	replacer := strings.NewReplacer("{{VirtualPath}}", "/path1")
 	paths := []string{"{{VirtualPath}}", "/path1"}
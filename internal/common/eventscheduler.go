@@ -19,6 +19,7 @@ import (
 
 	"github.com/robfig/cron/v3"
 
+	"github.com/drakkan/sftpgo/v2/internal/jobs"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
@@ -29,6 +30,7 @@ var (
 func stopEventScheduler() {
 	if eventScheduler != nil {
 		eventScheduler.Stop()
+		jobs.RemoveScheduler(eventScheduler)
 		eventScheduler = nil
 	}
 }
@@ -37,7 +39,10 @@ func startEventScheduler() {
 	stopEventScheduler()
 
 	eventScheduler = cron.New(cron.WithLocation(time.UTC))
-	_, err := eventScheduler.AddFunc("@every 10m", eventManager.loadRules)
+	err := jobs.Add(eventScheduler, "event_rules_reload", "@every 10m", func() error {
+		eventManager.loadRules()
+		return nil
+	})
 	util.PanicOnError(err)
 	eventScheduler.Start()
 }
@@ -0,0 +1,219 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// files to transform are loaded in memory, larger files are refused
+	maxTransformFileSize = 10 * 1024 * 1024
+)
+
+var (
+	utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+)
+
+// getTransformedHTTPBody reads the configured file, as the user that triggered
+// the event, and converts it to JSON
+func getTransformedHTTPBody(t dataprovider.HTTPTransform, replacer *strings.Replacer, user dataprovider.User,
+	params *EventParams,
+) ([]byte, error) {
+	user, err := getUserForEventAction(user)
+	if err != nil {
+		return nil, err
+	}
+	connectionID := fmt.Sprintf("%s_%s", protocolEventAction, xid.New().String())
+	err = user.CheckFsRoot(connectionID)
+	defer user.CloseFs() //nolint:errcheck
+	if err != nil {
+		return nil, fmt.Errorf("error getting file to transform, unable to check root fs for user %q: %w",
+			user.Username, err)
+	}
+	conn := NewBaseConnection(connectionID, protocolEventAction, "", "", user)
+	virtualPath := util.CleanPath(replacer.Replace(t.Filepath))
+	reader, cancelFn, err := getFileReader(conn, virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cancelFn()
+	defer reader.Close()
+
+	content, err := io.ReadAll(io.LimitReader(reader, maxTransformFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxTransformFileSize {
+		return nil, fmt.Errorf("unable to transform %q: the file exceeds the maximum allowed size", virtualPath)
+	}
+	data, err := convertFileContent(t.Format, content)
+	if err != nil {
+		eventManagerLog(logger.LevelError, "unable to convert %q from %s: %v", virtualPath, t.Format, err)
+		return nil, fmt.Errorf("unable to convert %q from %s: %w", virtualPath, t.Format, err)
+	}
+	return renderTransformedData(t, data, params)
+}
+
+func convertFileContent(format string, content []byte) (any, error) {
+	content = bytes.TrimPrefix(content, utf8BOM)
+	switch format {
+	case "csv":
+		return convertCSVContent(content)
+	case "xml":
+		return convertXMLContent(content)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// renderTransformedData returns the converted data as JSON or the configured
+// template rendered using the converted data
+func renderTransformedData(t dataprovider.HTTPTransform, data any, params *EventParams) ([]byte, error) {
+	if t.Template == "" {
+		return json.Marshal(data)
+	}
+	tpl, err := t.ParseTemplate()
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse transformation template: %w", err)
+	}
+	tplData := make(map[string]any)
+	for k, v := range getTemplateData(params.getStringReplacements(false)) {
+		tplData[k] = v
+	}
+	tplData["Data"] = data
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, tplData); err != nil {
+		return nil, fmt.Errorf("unable to render transformation template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// convertCSVContent returns the CSV records as a list of objects, the first
+// record defines the object keys
+func convertCSVContent(content []byte) ([]map[string]string, error) {
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("the CSV file has no header")
+		}
+		return nil, err
+	}
+	var result []map[string]string
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		row := make(map[string]string, len(header))
+		for idx, key := range header {
+			if idx < len(record) {
+				row[key] = record[idx]
+			} else {
+				row[key] = ""
+			}
+		}
+		result = append(result, row)
+	}
+	if result == nil {
+		result = []map[string]string{}
+	}
+	return result, nil
+}
+
+// convertXMLContent converts an XML document to a generic object. Attributes
+// are prefixed with "@", the text of elements with attributes or children is
+// stored as "#text" and repeated elements are converted to lists
+func convertXMLContent(content []byte) (map[string]any, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("the XML document has no root element")
+			}
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			value, err := convertXMLElement(decoder, start)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{start.Name.Local: value}, nil
+		}
+	}
+}
+
+func convertXMLElement(decoder *xml.Decoder, start xml.StartElement) (any, error) {
+	result := make(map[string]any)
+	for _, attr := range start.Attr {
+		result["@"+attr.Name.Local] = attr.Value
+	}
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := convertXMLElement(decoder, t)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			switch existing := result[name].(type) {
+			case nil:
+				result[name] = child
+			case []any:
+				result[name] = append(existing, child)
+			default:
+				result[name] = []any{existing, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			value := strings.TrimSpace(text.String())
+			if len(result) == 0 {
+				return value, nil
+			}
+			if value != "" {
+				result["#text"] = value
+			}
+			return result, nil
+		}
+	}
+}
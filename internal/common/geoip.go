@@ -0,0 +1,431 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// MaxMind DB data types, see https://maxmind.github.io/MaxMind-DB/
+const (
+	mmdbTypeExtended = iota
+	mmdbTypePointer
+	mmdbTypeString
+	mmdbTypeDouble
+	mmdbTypeBytes
+	mmdbTypeUint16
+	mmdbTypeUint32
+	mmdbTypeMap
+	mmdbTypeInt32
+	mmdbTypeUint64
+	mmdbTypeUint128
+	mmdbTypeArray
+	mmdbTypeContainer
+	mmdbTypeEndMarker
+	mmdbTypeBool
+	mmdbTypeFloat
+)
+
+const (
+	mmdbDataSectionSeparatorSize = 16
+	mmdbMaxDecodingDepth         = 32
+)
+
+var (
+	mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+	geoIP              geoIPResolver
+)
+
+// GeoIPConfig defines the configuration for the geolocation of the client IP addresses
+type GeoIPConfig struct {
+	// Absolute path to a MaxMind GeoIP2/GeoLite2 Country or City database in
+	// MMDB format. Empty means disabled. The database is reloaded on SIGHUP
+	DatabasePath string `json:"database_path" mapstructure:"database_path"`
+}
+
+func (c *GeoIPConfig) load() error {
+	if c.DatabasePath == "" {
+		geoIP.set(nil)
+		return nil
+	}
+	if !util.IsFileInputValid(c.DatabasePath) || !filepath.IsAbs(c.DatabasePath) {
+		return fmt.Errorf("invalid GeoIP database path %q", c.DatabasePath)
+	}
+	db, err := openGeoIPDatabase(c.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("unable to load GeoIP database %q: %w", c.DatabasePath, err)
+	}
+	geoIP.set(db)
+	logger.Info(logSender, "", "GeoIP database %q loaded, type %q, IP version %d, nodes %d", c.DatabasePath,
+		db.databaseType, db.ipVersion, db.nodeCount)
+	return nil
+}
+
+type geoIPResolver struct {
+	sync.RWMutex
+	db *geoIPDatabase
+}
+
+func (r *geoIPResolver) set(db *geoIPDatabase) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.db = db
+}
+
+func (r *geoIPResolver) getCountry(ip string) string {
+	r.RLock()
+	defer r.RUnlock()
+
+	if r.db == nil {
+		return ""
+	}
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return ""
+	}
+	country, err := r.db.getCountry(parsedIP)
+	if err != nil {
+		logger.Debug(logSender, "", "unable to get the country for IP %q: %v", ip, err)
+		return ""
+	}
+	return country
+}
+
+// GetCountryForIP returns the ISO 3166-1 alpha-2 country code for the specified
+// IP address or an empty string if the country is unknown or no GeoIP
+// database is configured
+func GetCountryForIP(ip string) string {
+	return geoIP.getCountry(ip)
+}
+
+// IsCountryAllowed returns true if the country of the specified IP address is
+// allowed by the given lists
+func IsCountryAllowed(ip string, allowed, denied []string) bool {
+	if len(allowed) == 0 && len(denied) == 0 {
+		return true
+	}
+	return util.IsCountryAllowed(GetCountryForIP(ip), allowed, denied)
+}
+
+// geoIPDatabase is a minimal MaxMind DB reader. Only the lookups required to
+// get the country for an IP address are implemented
+type geoIPDatabase struct {
+	buffer       []byte
+	nodeCount    uint
+	recordSize   uint
+	ipVersion    uint
+	databaseType string
+	// decoder for the data section
+	data      mmdbDecoder
+	ipv4Start uint
+}
+
+func openGeoIPDatabase(name string) (*geoIPDatabase, error) {
+	buffer, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return newGeoIPDatabase(buffer)
+}
+
+func newGeoIPDatabase(buffer []byte) (*geoIPDatabase, error) {
+	metadataStart := bytes.LastIndex(buffer, mmdbMetadataMarker)
+	if metadataStart == -1 {
+		return nil, errors.New("invalid MaxMind DB file, metadata not found")
+	}
+	metadataDecoder := mmdbDecoder{buffer: buffer[metadataStart+len(mmdbMetadataMarker):]}
+	val, _, err := metadataDecoder.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode metadata: %w", err)
+	}
+	metadata, ok := val.(map[string]any)
+	if !ok {
+		return nil, errors.New("invalid metadata")
+	}
+	db := &geoIPDatabase{
+		buffer:     buffer,
+		nodeCount:  getMMDBUint(metadata["node_count"]),
+		recordSize: getMMDBUint(metadata["record_size"]),
+		ipVersion:  getMMDBUint(metadata["ip_version"]),
+	}
+	db.databaseType, _ = metadata["database_type"].(string)
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", db.ipVersion)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	dataStart := treeSize + mmdbDataSectionSeparatorSize
+	if dataStart > uint(metadataStart) {
+		return nil, errors.New("invalid MaxMind DB file, the search tree exceeds the file size")
+	}
+	db.data = mmdbDecoder{buffer: buffer[dataStart:metadataStart]}
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.readNode(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+func (db *geoIPDatabase) readNode(node, bit uint) uint {
+	offset := node * db.recordSize / 4
+	b := db.buffer
+	switch db.recordSize {
+	case 24:
+		offset += bit * 3
+		return uint(b[offset])<<16 | uint(b[offset+1])<<8 | uint(b[offset+2])
+	case 28:
+		if bit == 0 {
+			return uint(b[offset+3]&0xF0)<<20 | uint(b[offset])<<16 | uint(b[offset+1])<<8 | uint(b[offset+2])
+		}
+		return uint(b[offset+3]&0x0F)<<24 | uint(b[offset+4])<<16 | uint(b[offset+5])<<8 | uint(b[offset+6])
+	default:
+		offset += bit * 4
+		return uint(binary.BigEndian.Uint32(b[offset : offset+4]))
+	}
+}
+
+func (db *geoIPDatabase) lookup(ip net.IP) (map[string]any, error) {
+	var node uint
+	ipBytes := ip.To4()
+	if ipBytes != nil {
+		node = db.ipv4Start
+	} else {
+		if db.ipVersion == 4 {
+			return nil, fmt.Errorf("cannot look up IPv6 address %s in an IPv4-only database", ip)
+		}
+		ipBytes = ip.To16()
+	}
+	bitCount := uint(len(ipBytes) * 8)
+	for i := uint(0); i < bitCount && node < db.nodeCount; i++ {
+		bit := uint(ipBytes[i>>3]>>(7-(i%8))) & 1
+		node = db.readNode(node, bit)
+	}
+	if node == db.nodeCount {
+		return nil, nil
+	}
+	if node < db.nodeCount {
+		return nil, errors.New("invalid search tree")
+	}
+	offset := node - db.nodeCount - mmdbDataSectionSeparatorSize
+	val, _, err := db.data.decode(offset, 0)
+	if err != nil {
+		return nil, err
+	}
+	record, ok := val.(map[string]any)
+	if !ok {
+		return nil, errors.New("unexpected record type")
+	}
+	return record, nil
+}
+
+func (db *geoIPDatabase) getCountry(ip net.IP) (string, error) {
+	record, err := db.lookup(ip)
+	if err != nil || record == nil {
+		return "", err
+	}
+	for _, key := range []string{"country", "registered_country"} {
+		if country, ok := record[key].(map[string]any); ok {
+			if isoCode, ok := country["iso_code"].(string); ok && isoCode != "" {
+				return strings.ToUpper(isoCode), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+type mmdbDecoder struct {
+	buffer []byte
+}
+
+// decode decodes the field at the specified offset and returns the decoded
+// value and the offset for the next field
+func (d *mmdbDecoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > mmdbMaxDecodingDepth {
+		return nil, 0, errors.New("maximum data structure depth exceeded")
+	}
+	if offset >= uint(len(d.buffer)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	ctrl := d.buffer[offset]
+	offset++
+	dataType := uint(ctrl >> 5)
+	if dataType == mmdbTypePointer {
+		pointer, next, err := d.decodePointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		val, _, err := d.decode(pointer, depth+1)
+		return val, next, err
+	}
+	if dataType == mmdbTypeExtended {
+		if offset >= uint(len(d.buffer)) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		dataType = 7 + uint(d.buffer[offset])
+		offset++
+	}
+	size, offset, err := d.decodeSize(ctrl, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch dataType {
+	case mmdbTypeMap:
+		return d.decodeMap(size, offset, depth)
+	case mmdbTypeArray:
+		return d.decodeArray(size, offset, depth)
+	case mmdbTypeBool:
+		return size != 0, offset, nil
+	}
+	if offset+size > uint(len(d.buffer)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	payload := d.buffer[offset : offset+size]
+	next := offset + size
+	switch dataType {
+	case mmdbTypeString:
+		return string(payload), next, nil
+	case mmdbTypeBytes:
+		return payload, next, nil
+	case mmdbTypeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid size %d for a double", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), next, nil
+	case mmdbTypeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid size %d for a float", size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(payload)), next, nil
+	case mmdbTypeUint16, mmdbTypeUint32, mmdbTypeUint64, mmdbTypeInt32:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("invalid size %d for an integer", size)
+		}
+		var val uint64
+		for _, b := range payload {
+			val = val<<8 | uint64(b)
+		}
+		if dataType == mmdbTypeInt32 {
+			return int64(int32(val)), next, nil
+		}
+		return val, next, nil
+	case mmdbTypeUint128:
+		// not used for the country lookups
+		return payload, next, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported data type %d", dataType)
+	}
+}
+
+func (d *mmdbDecoder) decodePointer(ctrl byte, offset uint) (uint, uint, error) {
+	size := uint((ctrl>>3)&0x03) + 1
+	if offset+size > uint(len(d.buffer)) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	var pointer uint
+	if size != 4 {
+		pointer = uint(ctrl & 0x07)
+	}
+	for _, b := range d.buffer[offset : offset+size] {
+		pointer = pointer<<8 | uint(b)
+	}
+	switch size {
+	case 2:
+		pointer += 2048
+	case 3:
+		pointer += 526336
+	}
+	return pointer, offset + size, nil
+}
+
+func (d *mmdbDecoder) decodeSize(ctrl byte, offset uint) (uint, uint, error) {
+	size := uint(ctrl & 0x1F)
+	if size < 29 {
+		return size, offset, nil
+	}
+	numBytes := size - 28
+	if offset+numBytes > uint(len(d.buffer)) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	var val uint
+	for _, b := range d.buffer[offset : offset+numBytes] {
+		val = val<<8 | uint(b)
+	}
+	switch size {
+	case 29:
+		size = 29 + val
+	case 30:
+		size = 285 + val
+	default:
+		size = 65821 + val
+	}
+	return size, offset + numBytes, nil
+}
+
+func (d *mmdbDecoder) decodeMap(size, offset uint, depth int) (any, uint, error) {
+	result := make(map[string]any)
+	for i := uint(0); i < size; i++ {
+		key, next, err := d.decode(offset, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		k, ok := key.(string)
+		if !ok {
+			return nil, 0, errors.New("invalid map key")
+		}
+		val, next, err := d.decode(next, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		result[k] = val
+		offset = next
+	}
+	return result, offset, nil
+}
+
+func (d *mmdbDecoder) decodeArray(size, offset uint, depth int) (any, uint, error) {
+	var result []any
+	for i := uint(0); i < size; i++ {
+		val, next, err := d.decode(offset, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		result = append(result, val)
+		offset = next
+	}
+	return result, offset, nil
+}
+
+func getMMDBUint(val any) uint {
+	if v, ok := val.(uint64); ok {
+		return uint(v)
+	}
+	return 0
+}
@@ -0,0 +1,398 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+)
+
+type testGeoIPNetwork struct {
+	cidr string
+	// top level key for the country record, "country" or "registered_country"
+	key     string
+	isoCode string
+}
+
+// testMMDBWriter builds a minimal MaxMind DB with a search tree mapping the
+// configured networks to country records
+type testMMDBWriter struct {
+	ipVersion  int
+	recordSize int
+	// node records: -1 means empty, values >= 0 are node indexes and values
+	// < -1 are data section offsets encoded as -2-offset
+	nodes [][2]int
+	data  bytes.Buffer
+}
+
+func (w *testMMDBWriter) encodeString(s string) []byte {
+	return append([]byte{byte(mmdbTypeString<<5 | len(s))}, s...)
+}
+
+func (w *testMMDBWriter) encodeUint(dataType, size int, val uint64) []byte {
+	result := []byte{byte(dataType<<5 | size)}
+	for i := size - 1; i >= 0; i-- {
+		result = append(result, byte(val>>(8*i)))
+	}
+	return result
+}
+
+func (w *testMMDBWriter) encodeMap(size int) []byte {
+	return []byte{byte(mmdbTypeMap<<5 | size)}
+}
+
+func (w *testMMDBWriter) addNetwork(t *testing.T, network testGeoIPNetwork) {
+	_, ipNet, err := net.ParseCIDR(network.cidr)
+	require.NoError(t, err)
+	ones, _ := ipNet.Mask.Size()
+	ipBytes := ipNet.IP.To4()
+	if w.ipVersion == 6 {
+		// IPv4 addresses are stored in the ::/96 subtree
+		ipBytes = append(make([]byte, 12), ipBytes...)
+		ones += 96
+	}
+	offset := w.data.Len()
+	w.data.Write(w.encodeMap(1))
+	w.data.Write(w.encodeString(network.key))
+	w.data.Write(w.encodeMap(1))
+	w.data.Write(w.encodeString("iso_code"))
+	w.data.Write(w.encodeString(network.isoCode))
+
+	node := 0
+	for i := 0; i < ones; i++ {
+		bit := int(ipBytes[i>>3]>>(7-(i%8))) & 1
+		if i == ones-1 {
+			w.nodes[node][bit] = -2 - offset
+			break
+		}
+		if w.nodes[node][bit] < 0 {
+			w.nodes = append(w.nodes, [2]int{-1, -1})
+			w.nodes[node][bit] = len(w.nodes) - 1
+		}
+		node = w.nodes[node][bit]
+	}
+}
+
+func (w *testMMDBWriter) resolveRecord(record int) uint32 {
+	nodeCount := len(w.nodes)
+	switch {
+	case record == -1:
+		return uint32(nodeCount)
+	case record < -1:
+		return uint32(nodeCount + mmdbDataSectionSeparatorSize + (-2 - record))
+	default:
+		return uint32(record)
+	}
+}
+
+func (w *testMMDBWriter) build(t *testing.T, networks []testGeoIPNetwork) []byte {
+	w.nodes = [][2]int{{-1, -1}}
+	for _, network := range networks {
+		w.addNetwork(t, network)
+	}
+	var result bytes.Buffer
+	for _, node := range w.nodes {
+		left := w.resolveRecord(node[0])
+		right := w.resolveRecord(node[1])
+		switch w.recordSize {
+		case 24:
+			result.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left)})
+			result.Write([]byte{byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			result.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left),
+				byte((left>>24)<<4) | byte(right>>24&0x0F), byte(right >> 16), byte(right >> 8), byte(right)})
+		default:
+			record := make([]byte, 8)
+			binary.BigEndian.PutUint32(record, left)
+			binary.BigEndian.PutUint32(record[4:], right)
+			result.Write(record)
+		}
+	}
+	result.Write(make([]byte, mmdbDataSectionSeparatorSize))
+	result.Write(w.data.Bytes())
+	result.Write(mmdbMetadataMarker)
+	result.Write(w.encodeMap(4))
+	result.Write(w.encodeString("node_count"))
+	result.Write(w.encodeUint(mmdbTypeUint32, 4, uint64(len(w.nodes))))
+	result.Write(w.encodeString("record_size"))
+	result.Write(w.encodeUint(mmdbTypeUint16, 2, uint64(w.recordSize)))
+	result.Write(w.encodeString("ip_version"))
+	result.Write(w.encodeUint(mmdbTypeUint16, 2, uint64(w.ipVersion)))
+	result.Write(w.encodeString("database_type"))
+	result.Write(w.encodeString("GeoLite2-Country"))
+	return result.Bytes()
+}
+
+func getTestGeoIPNetworks() []testGeoIPNetwork {
+	return []testGeoIPNetwork{
+		{
+			cidr:    "1.0.0.0/8",
+			key:     "country",
+			isoCode: "IT",
+		},
+		{
+			cidr:    "2.2.0.0/16",
+			key:     "registered_country",
+			isoCode: "de",
+		},
+		{
+			cidr:    "3.3.3.3/32",
+			key:     "continent",
+			isoCode: "EU",
+		},
+	}
+}
+
+func createTestGeoIPDatabase(t *testing.T, ipVersion, recordSize int) string {
+	w := testMMDBWriter{
+		ipVersion:  ipVersion,
+		recordSize: recordSize,
+	}
+	dbPath := filepath.Join(t.TempDir(), "geoip.mmdb")
+	err := os.WriteFile(dbPath, w.build(t, getTestGeoIPNetworks()), 0600)
+	require.NoError(t, err)
+	return dbPath
+}
+
+func TestGeoIPDatabase(t *testing.T) {
+	for _, ipVersion := range []int{4, 6} {
+		for _, recordSize := range []int{24, 28, 32} {
+			db, err := openGeoIPDatabase(createTestGeoIPDatabase(t, ipVersion, recordSize))
+			require.NoError(t, err)
+			assert.Equal(t, "GeoLite2-Country", db.databaseType)
+			assert.Equal(t, uint(ipVersion), db.ipVersion)
+			assert.Equal(t, uint(recordSize), db.recordSize)
+
+			country, err := db.getCountry(net.ParseIP("1.2.3.4"))
+			assert.NoError(t, err)
+			assert.Equal(t, "IT", country)
+			country, err = db.getCountry(net.ParseIP("2.2.100.1"))
+			assert.NoError(t, err)
+			assert.Equal(t, "DE", country)
+			country, err = db.getCountry(net.ParseIP("2.3.100.1"))
+			assert.NoError(t, err)
+			assert.Empty(t, country)
+			country, err = db.getCountry(net.ParseIP("3.3.3.3"))
+			assert.NoError(t, err)
+			assert.Empty(t, country)
+			country, err = db.getCountry(net.ParseIP("192.168.1.1"))
+			assert.NoError(t, err)
+			assert.Empty(t, country)
+			country, err = db.getCountry(net.ParseIP("2001:db8::1"))
+			if ipVersion == 4 {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Empty(t, country)
+		}
+	}
+
+	_, err := newGeoIPDatabase([]byte("invalid database"))
+	assert.Error(t, err)
+	w := testMMDBWriter{
+		ipVersion:  4,
+		recordSize: 16,
+	}
+	_, err = newGeoIPDatabase(w.build(t, nil))
+	assert.ErrorContains(t, err, "unsupported record size")
+	w = testMMDBWriter{
+		ipVersion:  5,
+		recordSize: 24,
+	}
+	_, err = newGeoIPDatabase(w.build(t, nil))
+	assert.ErrorContains(t, err, "unsupported IP version")
+	w = testMMDBWriter{
+		ipVersion:  4,
+		recordSize: 24,
+	}
+	buffer := w.build(t, getTestGeoIPNetworks())
+	_, err = newGeoIPDatabase(buffer[bytes.Index(buffer, mmdbMetadataMarker)-10:])
+	assert.ErrorContains(t, err, "exceeds the file size")
+	metadata := append([]byte{}, mmdbMetadataMarker...)
+	_, err = newGeoIPDatabase(append(metadata, w.encodeString("metadata")...))
+	assert.ErrorContains(t, err, "invalid metadata")
+	_, err = newGeoIPDatabase(append(metadata, w.encodeMap(1)...))
+	assert.Error(t, err)
+}
+
+func TestMMDBDecoder(t *testing.T) {
+	w := testMMDBWriter{}
+	// a map with a key pointing to a previously encoded string
+	var buf bytes.Buffer
+	buf.Write(w.encodeString("value"))
+	buf.Write(w.encodeMap(3))
+	buf.Write(w.encodeString("key"))
+	buf.Write([]byte{byte(mmdbTypePointer << 5), 0})
+	buf.Write(w.encodeString("num"))
+	buf.Write([]byte{byte(mmdbTypeExtended<<5 | 1), byte(mmdbTypeInt32 - 7), 0xFF})
+	buf.Write(w.encodeString("list"))
+	buf.Write([]byte{byte(mmdbTypeExtended<<5 | 2), byte(mmdbTypeArray - 7)})
+	buf.Write([]byte{byte(mmdbTypeExtended<<5 | 1), byte(mmdbTypeBool - 7)})
+	buf.Write([]byte{byte(mmdbTypeDouble<<5 | 8), 0x3F, 0xF0, 0, 0, 0, 0, 0, 0})
+
+	d := mmdbDecoder{buffer: buf.Bytes()}
+	val, _, err := d.decode(6, 0)
+	require.NoError(t, err)
+	record, ok := val.(map[string]any)
+	require.True(t, ok)
+	assert.Equal(t, "value", record["key"])
+	assert.Equal(t, int64(255), record["num"])
+	assert.Equal(t, []any{true, float64(1)}, record["list"])
+	// a pointer to itself must not cause an infinite recursion
+	d = mmdbDecoder{buffer: []byte{byte(mmdbTypePointer << 5), 0}}
+	_, _, err = d.decode(0, 0)
+	assert.ErrorContains(t, err, "depth exceeded")
+	// truncated data
+	d = mmdbDecoder{buffer: []byte{byte(mmdbTypeString<<5 | 10), 'a'}}
+	_, _, err = d.decode(0, 0)
+	assert.Error(t, err)
+	d = mmdbDecoder{buffer: []byte{byte(mmdbTypeString<<5 | 30)}}
+	_, _, err = d.decode(0, 0)
+	assert.Error(t, err)
+	d = mmdbDecoder{buffer: []byte{byte(mmdbTypeDouble<<5 | 2), 0, 0}}
+	_, _, err = d.decode(0, 0)
+	assert.Error(t, err)
+	d = mmdbDecoder{buffer: []byte{byte(mmdbTypeMap<<5 | 1), byte(mmdbTypeUint16<<5 | 1), 1}}
+	_, _, err = d.decode(0, 0)
+	assert.ErrorContains(t, err, "invalid map key")
+}
+
+func TestGeoIPConfig(t *testing.T) {
+	defer geoIP.set(nil)
+
+	c := GeoIPConfig{}
+	err := c.load()
+	assert.NoError(t, err)
+	assert.Empty(t, GetCountryForIP("1.1.1.1"))
+	assert.True(t, IsCountryAllowed("1.1.1.1", nil, nil))
+	assert.True(t, IsCountryAllowed("1.1.1.1", nil, []string{"IT"}))
+	assert.False(t, IsCountryAllowed("1.1.1.1", []string{"IT"}, nil))
+
+	c.DatabasePath = "relative.mmdb"
+	err = c.load()
+	assert.ErrorContains(t, err, "invalid GeoIP database path")
+	c.DatabasePath = filepath.Join(os.TempDir(), "missing.mmdb")
+	err = c.load()
+	assert.Error(t, err)
+
+	c.DatabasePath = createTestGeoIPDatabase(t, 6, 24)
+	err = c.load()
+	assert.NoError(t, err)
+	assert.Equal(t, "IT", GetCountryForIP("1.1.1.1"))
+	assert.Equal(t, "DE", GetCountryForIP("2.2.2.2"))
+	assert.Empty(t, GetCountryForIP("10.1.1.1"))
+	assert.Empty(t, GetCountryForIP("invalid"))
+	assert.True(t, IsCountryAllowed("1.1.1.1", []string{"IT"}, nil))
+	assert.False(t, IsCountryAllowed("1.1.1.1", nil, []string{"IT"}))
+	assert.False(t, IsCountryAllowed("1.1.1.1", []string{"IT"}, []string{"IT"}))
+	assert.True(t, IsCountryAllowed("2.2.2.2", nil, []string{"IT"}))
+	assert.False(t, IsCountryAllowed("2.2.2.2", []string{"IT"}, nil))
+	assert.False(t, IsCountryAllowed("10.1.1.1", []string{"IT"}, nil))
+	assert.True(t, IsCountryAllowed("10.1.1.1", nil, []string{"IT"}))
+	// a load error must not replace the current database
+	err = os.WriteFile(c.DatabasePath, []byte("invalid"), 0600)
+	assert.NoError(t, err)
+	err = c.load()
+	assert.Error(t, err)
+	assert.Equal(t, "IT", GetCountryForIP("1.1.1.1"))
+
+	c.DatabasePath = ""
+	err = c.load()
+	assert.NoError(t, err)
+	assert.Empty(t, GetCountryForIP("1.1.1.1"))
+}
+
+func TestUserCountryFilters(t *testing.T) {
+	defer geoIP.set(nil)
+
+	db, err := openGeoIPDatabase(createTestGeoIPDatabase(t, 4, 24))
+	require.NoError(t, err)
+	geoIP.set(db)
+	dataprovider.SetCountryResolver(GetCountryForIP)
+
+	user := dataprovider.User{}
+	user.Filters.DeniedCountries = []string{"DE"}
+	assert.True(t, user.IsLoginFromAddrAllowed("1.1.1.1:2022"))
+	assert.False(t, user.IsLoginFromAddrAllowed("2.2.2.2:2022"))
+	assert.True(t, user.IsLoginFromAddrAllowed("192.168.1.1:2022"))
+	user.Filters.AllowedCountries = []string{"IT"}
+	assert.True(t, user.IsLoginFromAddrAllowed("1.1.1.1:2022"))
+	assert.False(t, user.IsLoginFromAddrAllowed("2.2.2.2:2022"))
+	assert.False(t, user.IsLoginFromAddrAllowed("192.168.1.1:2022"))
+	user.Filters.AllowedIP = []string{"192.168.1.0/24"}
+	assert.False(t, user.IsLoginFromAddrAllowed("1.1.1.1:2022"))
+	// the IP and the country must be both allowed
+	user.Filters.AllowedCountries = nil
+	assert.True(t, user.IsLoginFromAddrAllowed("192.168.1.1:2022"))
+}
+
+func TestEventRuleCountryCondition(t *testing.T) {
+	defer geoIP.set(nil)
+
+	db, err := openGeoIPDatabase(createTestGeoIPDatabase(t, 4, 28))
+	require.NoError(t, err)
+	geoIP.set(db)
+
+	conditions := dataprovider.EventConditions{
+		ProviderEvents: []string{"add"},
+		Options: dataprovider.ConditionOptions{
+			Countries: []string{"IT"},
+		},
+	}
+	params := EventParams{
+		Name:  "user",
+		Event: "add",
+		IP:    "1.1.1.1",
+	}
+	assert.True(t, eventManager.checkProviderEventMatch(conditions, params))
+	params.IP = "2.2.2.2"
+	assert.False(t, eventManager.checkProviderEventMatch(conditions, params))
+	params.IP = ""
+	assert.False(t, eventManager.checkProviderEventMatch(conditions, params))
+
+	conditions = dataprovider.EventConditions{
+		FsEvents: []string{OperationUpload},
+		Options: dataprovider.ConditionOptions{
+			Countries: []string{"IT", "DE"},
+		},
+	}
+	params = EventParams{
+		Name:        "user",
+		Event:       OperationUpload,
+		VirtualPath: "/file.txt",
+		Protocol:    ProtocolSFTP,
+		IP:          "2.2.2.2",
+	}
+	assert.True(t, eventManager.checkFsEventMatch(conditions, params))
+	params.IP = "10.0.0.1"
+	assert.False(t, eventManager.checkFsEventMatch(conditions, params))
+	conditions.Options.Countries = nil
+	assert.True(t, eventManager.checkFsEventMatch(conditions, params))
+
+	params.IP = "1.1.1.1"
+	replacer := strings.NewReplacer(params.getStringReplacements(false)...)
+	assert.Equal(t, "country IT", replacer.Replace("country {{Country}}"))
+}
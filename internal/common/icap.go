@@ -0,0 +1,306 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/icap"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/smtp"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Supported actions for the files in which the ICAP server detects a threat
+const (
+	ICAPActionDelete     = "delete"
+	ICAPActionQuarantine = "quarantine"
+)
+
+var icapScanner icapScannerHolder
+
+// ICAPConfig defines the configuration to scan the uploaded files using an ICAP
+// server, for example c-icap with ClamAV or a Symantec/McAfee gateway.
+// Files are scanned after the upload completes and before executing the upload
+// hooks and the event rules
+type ICAPConfig struct {
+	// ICAP service URL, for example "icap://127.0.0.1:1344/avscan". Use the
+	// "icaps" scheme for TLS connections. Empty means disabled
+	URL string `json:"url" mapstructure:"url"`
+	// ICAP method, "RESPMOD" or "REQMOD". Empty means "RESPMOD"
+	Method string `json:"method" mapstructure:"method"`
+	// Timeout, as seconds, for each scan
+	Timeout int `json:"timeout" mapstructure:"timeout"`
+	// Files bigger than this size, as bytes, are not scanned. 0 means no limit
+	MaxSize int64 `json:"max_size" mapstructure:"max_size"`
+	// Skip the TLS certificate verification for the "icaps" scheme
+	SkipTLSVerify bool `json:"skip_tls_verify" mapstructure:"skip_tls_verify"`
+	// Action for the infected files: "delete" or "quarantine"
+	Action string `json:"action" mapstructure:"action"`
+	// Absolute path to a local directory, infected files are moved here if the
+	// action is "quarantine". A sub directory is created for each user
+	QuarantinePath string `json:"quarantine_path" mapstructure:"quarantine_path"`
+	// If enabled the user who uploaded an infected file is disabled and
+	// disconnected
+	DisableUser bool `json:"disable_user" mapstructure:"disable_user"`
+	// Email addresses to notify when a threat is detected. SMTP must be configured
+	NotifyEmails []string `json:"notify_emails" mapstructure:"notify_emails"`
+	// If enabled uploads are rejected, and the files removed, if they cannot be
+	// scanned, for example because the ICAP server is not reachable.
+	// By default they are accepted
+	RejectOnError bool `json:"reject_on_error" mapstructure:"reject_on_error"`
+}
+
+func (c *ICAPConfig) isEnabled() bool {
+	return c.URL != ""
+}
+
+func (c *ICAPConfig) validate() error {
+	if !c.isEnabled() {
+		return nil
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("invalid ICAP timeout: %d", c.Timeout)
+	}
+	if c.MaxSize < 0 {
+		return fmt.Errorf("invalid ICAP max size: %d", c.MaxSize)
+	}
+	switch c.Action {
+	case "", ICAPActionDelete:
+		c.Action = ICAPActionDelete
+	case ICAPActionQuarantine:
+		if !util.IsFileInputValid(c.QuarantinePath) || !filepath.IsAbs(c.QuarantinePath) {
+			return fmt.Errorf("invalid ICAP quarantine path %q, it must be an absolute path", c.QuarantinePath)
+		}
+	default:
+		return fmt.Errorf("unsupported ICAP action %q", c.Action)
+	}
+	for _, email := range c.NotifyEmails {
+		if !util.IsEmailValid(email) {
+			return fmt.Errorf("invalid ICAP notification email %q", email)
+		}
+	}
+	return nil
+}
+
+func (c *ICAPConfig) getClient() *icap.Client {
+	return &icap.Client{
+		URL:           c.URL,
+		Method:        c.Method,
+		SkipTLSVerify: c.SkipTLSVerify,
+		Timeout:       time.Duration(c.Timeout) * time.Second,
+	}
+}
+
+func (c *ICAPConfig) initialize() error {
+	if !c.isEnabled() {
+		icapScanner.set(nil)
+		return nil
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	client := c.getClient()
+	if err := client.Validate(); err != nil {
+		return err
+	}
+	if c.Action == ICAPActionQuarantine {
+		if err := os.MkdirAll(c.QuarantinePath, 0700); err != nil {
+			return fmt.Errorf("unable to create the ICAP quarantine path %q: %w", c.QuarantinePath, err)
+		}
+	}
+	icapScanner.set(&icapFileScanner{
+		config: *c,
+		client: client,
+	})
+	logger.Info(logSender, "", "ICAP scanning enabled, URL %q, method %q, action %q", c.URL, client.Method, c.Action)
+	return nil
+}
+
+type icapFileScanner struct {
+	config ICAPConfig
+	client *icap.Client
+}
+
+type icapScannerHolder struct {
+	sync.RWMutex
+	scanner *icapFileScanner
+}
+
+func (h *icapScannerHolder) set(scanner *icapFileScanner) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.scanner = scanner
+}
+
+func (h *icapScannerHolder) get() *icapFileScanner {
+	h.RLock()
+	defer h.RUnlock()
+
+	return h.scanner
+}
+
+func (s *icapFileScanner) isScanRequired(fileSize int64) bool {
+	if fileSize <= 0 {
+		return false
+	}
+	return s.config.MaxSize == 0 || fileSize <= s.config.MaxSize
+}
+
+func (s *icapFileScanner) scan(fs vfs.Fs, fsPath string, fileSize int64) (icap.Result, error) {
+	reader, cancelFn, err := getFsFileReader(fs, fsPath)
+	if err != nil {
+		return icap.Result{}, fmt.Errorf("unable to open the file to scan: %w", err)
+	}
+	defer cancelFn()
+	defer reader.Close()
+
+	return s.client.Scan(context.Background(), reader, path.Base(fsPath), fileSize)
+}
+
+// quarantine copies the infected file to the quarantine path, the caller is
+// responsible for removing the original file
+func (s *icapFileScanner) quarantine(fs vfs.Fs, username, fsPath string) (string, error) {
+	dir := filepath.Join(s.config.QuarantinePath, username)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s_%s_%s", time.Now().UTC().Format("20060102T150405"), xid.New().String(),
+		path.Base(fsPath))
+	dst := filepath.Join(dir, name)
+	reader, cancelFn, err := getFsFileReader(fs, fsPath)
+	if err != nil {
+		return "", err
+	}
+	defer cancelFn()
+	defer reader.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, reader)
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		os.Remove(dst)
+		return "", err
+	}
+	return dst, nil
+}
+
+func (s *icapFileScanner) disableUser(username, ipAddress string) {
+	user, err := dataprovider.UserExists(username)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to disable user %q after threat detection: %v", username, err)
+		return
+	}
+	if user.Status != 0 {
+		user.Status = 0
+		if err := dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSystem, ipAddress); err != nil {
+			logger.Warn(logSender, "", "unable to disable user %q after threat detection: %v", username, err)
+			return
+		}
+		logger.Info(logSender, "", "user %q disabled after threat detection", username)
+	}
+	for _, stat := range Connections.GetStats() {
+		if stat.Username == username {
+			Connections.Close(stat.ConnectionID)
+		}
+	}
+}
+
+func (s *icapFileScanner) notify(username, ipAddress, virtualPath, threat, action string) {
+	if len(s.config.NotifyEmails) == 0 {
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString("A threat was detected in an uploaded file.\n\n")
+	sb.WriteString(fmt.Sprintf("User: %s\n", username))
+	sb.WriteString(fmt.Sprintf("IP address: %s\n", ipAddress))
+	sb.WriteString(fmt.Sprintf("Path: %s\n", virtualPath))
+	sb.WriteString(fmt.Sprintf("Threat: %s\n", threat))
+	sb.WriteString(fmt.Sprintf("Action: %s\n", action))
+	if s.config.DisableUser {
+		sb.WriteString("The user has been disabled.\n")
+	}
+	err := smtp.SendEmail(s.config.NotifyEmails, fmt.Sprintf("SFTPGo: threat detected for user %q", username),
+		sb.String(), smtp.EmailContentTypeTextPlain)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to send the ICAP detection notification: %v", err)
+	}
+}
+
+// scanUpload scans the uploaded file using the configured ICAP server, if any,
+// and returns the updated number of files and file size. Infected files are
+// deleted or moved to the quarantine path
+func (t *BaseTransfer) scanUpload(numFiles int, fileSize int64) (int, int64) {
+	scanner := icapScanner.get()
+	if scanner == nil || t.ErrTransfer != nil || !scanner.isScanRequired(fileSize) {
+		return numFiles, fileSize
+	}
+	startTime := time.Now()
+	result, err := scanner.scan(t.Fs, t.fsPath, fileSize)
+	if err != nil {
+		t.Connection.Log(logger.LevelError, "unable to scan file %q, elapsed: %s, error: %v",
+			t.fsPath, time.Since(startTime), err)
+		if scanner.config.RejectOnError {
+			t.ErrTransfer = fmt.Errorf("unable to scan the uploaded file: %w", ErrGenericFailure)
+			return t.removeUploadedFile(numFiles, fileSize, "scan failure")
+		}
+		return numFiles, fileSize
+	}
+	if !result.Infected {
+		t.Connection.Log(logger.LevelDebug, "file %q scanned, no threat found, elapsed: %s, ICAP status: %d",
+			t.fsPath, time.Since(startTime), result.StatusCode)
+		return numFiles, fileSize
+	}
+	t.Connection.Log(logger.LevelWarn, "threat %q detected in file %q, action: %s", result.Threat, t.fsPath,
+		scanner.config.Action)
+	t.ErrTransfer = ErrInfectedFile
+	if scanner.config.Action == ICAPActionQuarantine {
+		dst, err := scanner.quarantine(t.Fs, t.Connection.User.Username, t.fsPath)
+		if err != nil {
+			t.Connection.Log(logger.LevelError, "unable to quarantine file %q: %v", t.fsPath, err)
+		} else {
+			t.Connection.Log(logger.LevelInfo, "file %q quarantined as %q", t.fsPath, dst)
+		}
+	}
+	numFiles, fileSize = t.removeUploadedFile(numFiles, fileSize, "threat detection")
+	username := t.Connection.User.Username
+	ipAddress := t.Connection.GetRemoteIP()
+	virtualPath := t.requestPath
+	go func() {
+		if scanner.config.DisableUser {
+			scanner.disableUser(username, ipAddress)
+		}
+		scanner.notify(username, ipAddress, virtualPath, result.Threat, scanner.config.Action)
+	}()
+	return numFiles, fileSize
+}
@@ -0,0 +1,261 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	icapResponseClean    = "ICAP/1.0 204 No Content\r\nEncapsulated: null-body=0\r\n\r\n"
+	icapResponseInfected = "ICAP/1.0 200 OK\r\nX-Virus-ID: Eicar-Test-Signature\r\nEncapsulated: null-body=0\r\n\r\n"
+)
+
+// startTestICAPServer starts an ICAP server that replies with the specified
+// response after reading the whole request
+func startTestICAPServer(t *testing.T, response string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				var data []byte
+				buf := make([]byte, 4096)
+				for !bytes.HasSuffix(data, []byte("0\r\n\r\n")) {
+					n, err := conn.Read(buf)
+					if err != nil {
+						return
+					}
+					data = append(data, buf[:n]...)
+				}
+				conn.Write([]byte(response)) //nolint:errcheck
+			}(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	return "icap://" + listener.Addr().String() + "/avscan"
+}
+
+func getICAPTestTransfer(t *testing.T, user dataprovider.User, fileName string) *BaseTransfer {
+	testFile := filepath.Join(user.HomeDir, fileName)
+	err := os.WriteFile(testFile, []byte("test data"), os.ModePerm)
+	require.NoError(t, err)
+	fs := vfs.NewOsFs("id", user.HomeDir, "")
+	conn := NewBaseConnection("id", ProtocolSFTP, "", "", user)
+	return NewBaseTransfer(nil, conn, nil, testFile, testFile, "/"+fileName, TransferUpload, 0, 0, 0, 0, true,
+		fs, dataprovider.TransferQuota{})
+}
+
+func TestICAPConfigValidation(t *testing.T) {
+	c := ICAPConfig{}
+	assert.NoError(t, c.validate())
+	err := c.initialize()
+	assert.NoError(t, err)
+	assert.Nil(t, icapScanner.get())
+	c.URL = "icap://127.0.0.1/avscan"
+	err = c.validate()
+	assert.ErrorContains(t, err, "invalid ICAP timeout")
+	c.Timeout = 10
+	c.MaxSize = -1
+	err = c.validate()
+	assert.ErrorContains(t, err, "invalid ICAP max size")
+	c.MaxSize = 0
+	c.Action = "unknown"
+	err = c.validate()
+	assert.ErrorContains(t, err, "unsupported ICAP action")
+	c.Action = ICAPActionQuarantine
+	c.QuarantinePath = "relative"
+	err = c.validate()
+	assert.ErrorContains(t, err, "invalid ICAP quarantine path")
+	c.Action = ""
+	c.NotifyEmails = []string{"not an email"}
+	err = c.validate()
+	assert.ErrorContains(t, err, "invalid ICAP notification email")
+	c.NotifyEmails = []string{"admin@example.com"}
+	err = c.validate()
+	assert.NoError(t, err)
+	assert.Equal(t, ICAPActionDelete, c.Action)
+	c.URL = "http://127.0.0.1/avscan"
+	err = c.initialize()
+	assert.ErrorContains(t, err, "supported schemes")
+	c.URL = "icap://127.0.0.1/avscan"
+	c.Action = ICAPActionQuarantine
+	c.QuarantinePath = filepath.Join(os.TempDir(), "icap_quarantine")
+	err = c.initialize()
+	assert.NoError(t, err)
+	assert.DirExists(t, c.QuarantinePath)
+	scanner := icapScanner.get()
+	require.NotNil(t, scanner)
+	assert.False(t, scanner.isScanRequired(0))
+	assert.True(t, scanner.isScanRequired(100))
+	scanner.config.MaxSize = 10
+	assert.False(t, scanner.isScanRequired(100))
+
+	c.URL = ""
+	err = c.initialize()
+	assert.NoError(t, err)
+	assert.Nil(t, icapScanner.get())
+	err = os.RemoveAll(filepath.Join(os.TempDir(), "icap_quarantine"))
+	assert.NoError(t, err)
+}
+
+func TestICAPScanUpload(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "icap_home")
+	quarantinePath := filepath.Join(os.TempDir(), "icap_quarantine")
+	err := os.MkdirAll(homeDir, os.ModePerm)
+	require.NoError(t, err)
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "icap_user",
+			Password: "pwd",
+			HomeDir:  homeDir,
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	err = dataprovider.AddUser(&user, "", "")
+	require.NoError(t, err)
+	// no scanner configured
+	transfer := getICAPTestTransfer(t, user, "file1.txt")
+	numFiles, fileSize := transfer.scanUpload(1, 9)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(9), fileSize)
+	// clean file
+	c := ICAPConfig{
+		URL:     startTestICAPServer(t, icapResponseClean),
+		Timeout: 5,
+	}
+	err = c.initialize()
+	require.NoError(t, err)
+	numFiles, fileSize = transfer.scanUpload(1, 9)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(9), fileSize)
+	assert.NoError(t, transfer.ErrTransfer)
+	assert.FileExists(t, transfer.fsPath)
+	// infected file, delete action
+	c.URL = startTestICAPServer(t, icapResponseInfected)
+	err = c.initialize()
+	require.NoError(t, err)
+	numFiles, fileSize = transfer.scanUpload(1, 9)
+	assert.Equal(t, 0, numFiles)
+	assert.Equal(t, int64(0), fileSize)
+	assert.ErrorIs(t, transfer.ErrTransfer, ErrInfectedFile)
+	assert.NoFileExists(t, transfer.fsPath)
+	// infected file, quarantine action and user disabled
+	c.Action = ICAPActionQuarantine
+	c.QuarantinePath = quarantinePath
+	c.DisableUser = true
+	err = c.initialize()
+	require.NoError(t, err)
+	transfer = getICAPTestTransfer(t, user, "file2.txt")
+	numFiles, _ = transfer.scanUpload(1, 9)
+	assert.Equal(t, 0, numFiles)
+	assert.ErrorIs(t, transfer.ErrTransfer, ErrInfectedFile)
+	assert.NoFileExists(t, transfer.fsPath)
+	entries, err := os.ReadDir(filepath.Join(quarantinePath, user.Username))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	content, err := os.ReadFile(filepath.Join(quarantinePath, user.Username, entries[0].Name()))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("test data"), content)
+	assert.Eventually(t, func() bool {
+		u, err := dataprovider.UserExists(user.Username)
+		return err == nil && u.Status == 0
+	}, 2*time.Second, 50*time.Millisecond)
+	// scan errors
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	c.URL = "icap://" + listener.Addr().String() + "/avscan"
+	err = listener.Close()
+	require.NoError(t, err)
+	c.Action = ICAPActionDelete
+	c.DisableUser = false
+	err = c.initialize()
+	require.NoError(t, err)
+	transfer = getICAPTestTransfer(t, user, "file3.txt")
+	numFiles, _ = transfer.scanUpload(1, 9)
+	assert.Equal(t, 1, numFiles)
+	assert.NoError(t, transfer.ErrTransfer)
+	assert.FileExists(t, transfer.fsPath)
+	c.RejectOnError = true
+	err = c.initialize()
+	require.NoError(t, err)
+	numFiles, _ = transfer.scanUpload(1, 9)
+	assert.Equal(t, 0, numFiles)
+	assert.ErrorIs(t, transfer.ErrTransfer, ErrGenericFailure)
+	assert.NoFileExists(t, transfer.fsPath)
+	// the file does not exist
+	transfer.ErrTransfer = nil
+	numFiles, _ = transfer.scanUpload(1, 9)
+	assert.Equal(t, 1, numFiles)
+	assert.Error(t, transfer.ErrTransfer)
+
+	c.URL = ""
+	err = c.initialize()
+	assert.NoError(t, err)
+	err = dataprovider.DeleteUser(user.Username, "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(homeDir)
+	assert.NoError(t, err)
+	err = os.RemoveAll(quarantinePath)
+	assert.NoError(t, err)
+}
+
+func TestICAPQuarantineErrors(t *testing.T) {
+	scanner := &icapFileScanner{
+		config: ICAPConfig{
+			QuarantinePath: filepath.Join(os.TempDir(), "icap_quarantine"),
+		},
+	}
+	fs := vfs.NewOsFs("id", os.TempDir(), "")
+	_, err := scanner.quarantine(fs, "user", filepath.Join(os.TempDir(), "missing_icap_file"))
+	assert.Error(t, err)
+	_, err = scanner.scan(fs, filepath.Join(os.TempDir(), "missing_icap_file"), 10)
+	assert.ErrorContains(t, err, "unable to open the file to scan")
+	// the user does not exist
+	scanner.disableUser("missing_icap_user", "127.0.0.1")
+	// SMTP is not configured
+	scanner.config.NotifyEmails = []string{"admin@example.com"}
+	scanner.config.DisableUser = true
+	scanner.notify("user", "127.0.0.1", "/file", "threat", ICAPActionDelete)
+	reader, cancelFn, err := getFsFileReader(fs, filepath.Join(os.TempDir(), "missing_icap_file"))
+	assert.Error(t, err)
+	assert.Nil(t, reader)
+	assert.Nil(t, cancelFn)
+	err = os.RemoveAll(filepath.Join(os.TempDir(), "icap_quarantine"))
+	assert.NoError(t, err)
+}
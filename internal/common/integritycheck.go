@@ -0,0 +1,464 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	// max number of objects detailed in an integrity check report for each user,
+	// the totals always include all the checked objects
+	maxIntegrityCheckReportEntries = 50000
+	integrityStatusCorrupted       = "corrupted"
+	integrityStatusMissing         = "missing"
+	integrityStatusFailed          = "failed"
+	manifestChecksumAlgo           = "sha256"
+)
+
+var (
+	integrityChecks activeUserChecks
+)
+
+type integrityManifestEntry struct {
+	Size int64 `json:"size"`
+	// modification time as unix timestamp in milliseconds
+	ModTime  int64  `json:"mtime"`
+	Checksum string `json:"sha256"`
+}
+
+// integrityManifest stores the checksums computed for the files of a user,
+// the keys are virtual paths
+type integrityManifest struct {
+	Username  string                            `json:"username"`
+	UpdatedAt int64                             `json:"updated_at"`
+	Files     map[string]integrityManifestEntry `json:"files"`
+}
+
+func getIntegrityManifestPath(manifestsPath, username string) string {
+	return filepath.Join(manifestsPath, url.PathEscape(username)+".json")
+}
+
+func loadIntegrityManifest(manifestsPath, username string) (*integrityManifest, error) {
+	manifest := &integrityManifest{
+		Username: username,
+		Files:    make(map[string]integrityManifestEntry),
+	}
+	data, err := os.ReadFile(getIntegrityManifestPath(manifestsPath, username))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return manifest, nil
+		}
+		return nil, fmt.Errorf("unable to read the integrity manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("unable to decode the integrity manifest: %w", err)
+	}
+	if manifest.Files == nil {
+		manifest.Files = make(map[string]integrityManifestEntry)
+	}
+	return manifest, nil
+}
+
+func (m *integrityManifest) save(manifestsPath string) error {
+	if err := os.MkdirAll(manifestsPath, 0700); err != nil {
+		return fmt.Errorf("unable to create the integrity manifests dir: %w", err)
+	}
+	m.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("unable to encode the integrity manifest: %w", err)
+	}
+	manifestPath := getIntegrityManifestPath(manifestsPath, m.Username)
+	tempPath := manifestPath + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0600); err != nil {
+		return fmt.Errorf("unable to write the integrity manifest: %w", err)
+	}
+	if err := os.Rename(tempPath, manifestPath); err != nil {
+		return fmt.Errorf("unable to save the integrity manifest: %w", err)
+	}
+	return nil
+}
+
+type integrityCheckResult struct {
+	Path      string
+	Size      int64
+	ModTime   time.Time
+	Status    string
+	Algorithm string
+	Expected  string
+	Actual    string
+	Error     string
+}
+
+type executedIntegrityCheck struct {
+	Username       string
+	ActionName     string
+	CheckedFiles   int
+	CheckedSize    int64
+	VerifiedFiles  int
+	RecordedFiles  int
+	SkippedFiles   int
+	CorruptedFiles int
+	MissingFiles   int
+	FailedFiles    int
+	Results        []integrityCheckResult
+}
+
+func (c *executedIntegrityCheck) getACopy() executedIntegrityCheck {
+	results := make([]integrityCheckResult, len(c.Results))
+	copy(results, c.Results)
+
+	res := *c
+	res.Results = results
+	return res
+}
+
+func (c *executedIntegrityCheck) addResult(result integrityCheckResult) {
+	switch result.Status {
+	case integrityStatusCorrupted:
+		c.CorruptedFiles++
+	case integrityStatusMissing:
+		c.MissingFiles++
+	default:
+		c.FailedFiles++
+	}
+	if len(c.Results) < maxIntegrityCheckReportEntries {
+		c.Results = append(c.Results, result)
+	}
+}
+
+func (c *executedIntegrityCheck) getNumProblems() int {
+	return c.CorruptedFiles + c.MissingFiles + c.FailedFiles
+}
+
+func (c *executedIntegrityCheck) getCSVReport() ([]byte, error) {
+	var b bytes.Buffer
+	csvWriter := csv.NewWriter(&b)
+	err := csvWriter.Write([]string{"path", "size (bytes)", "modification time", "status", "algorithm",
+		"expected checksum", "actual checksum", "error"})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range c.Results {
+		modTime := ""
+		if !result.ModTime.IsZero() {
+			modTime = result.ModTime.UTC().Format(time.RFC3339)
+		}
+		err = csvWriter.Write([]string{result.Path, strconv.FormatInt(result.Size, 10), modTime, result.Status,
+			result.Algorithm, result.Expected, result.Actual, result.Error})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = csvWriter.Write([]string{"total", strconv.FormatInt(c.CheckedSize, 10), "",
+		fmt.Sprintf("%d checked, %d verified, %d recorded, %d skipped, %d corrupted, %d missing, %d failed",
+			c.CheckedFiles, c.VerifiedFiles, c.RecordedFiles, c.SkippedFiles, c.CorruptedFiles, c.MissingFiles,
+			c.FailedFiles), "", "", "", ""})
+	if err != nil {
+		return nil, err
+	}
+
+	csvWriter.Flush()
+	err = csvWriter.Error()
+	return b.Bytes(), err
+}
+
+func getChecksumHasher(algo string) (hash.Hash, error) {
+	switch algo {
+	case vfs.ChecksumAlgoMD5:
+		return md5.New(), nil
+	case vfs.ChecksumAlgoCRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli)), nil
+	case manifestChecksumAlgo:
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+}
+
+type integrityCheck struct {
+	conn             *BaseConnection
+	paths            []string
+	samplePercentage int
+	manifestsPath    string
+	manifest         *integrityManifest
+	// virtual paths found while walking the filesystem
+	seenFiles map[string]bool
+	result    executedIntegrityCheck
+}
+
+func (c *integrityCheck) isSampled() bool {
+	return c.samplePercentage > 0 && c.samplePercentage < 100
+}
+
+// computeChecksums reads the specified file and returns the checksums for the specified algorithms
+func (c *integrityCheck) computeChecksums(virtualPath string, algos []string) (map[string]string, error) {
+	hashers := make(map[string]hash.Hash)
+	writers := make([]io.Writer, 0, len(algos))
+	for _, algo := range algos {
+		hasher, err := getChecksumHasher(algo)
+		if err != nil {
+			return nil, err
+		}
+		hashers[algo] = hasher
+		writers = append(writers, hasher)
+	}
+	if err := writeFileContent(c.conn, virtualPath, io.MultiWriter(writers...)); err != nil {
+		return nil, fmt.Errorf("unable to read file: %w", err)
+	}
+	checksums := make(map[string]string)
+	for algo, hasher := range hashers {
+		checksums[algo] = hex.EncodeToString(hasher.Sum(nil))
+	}
+	return checksums, nil
+}
+
+func (c *integrityCheck) getRecordedChecksum(virtualPath string) (vfs.Checksum, error) {
+	fs, fsPath, err := c.conn.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return vfs.Checksum{}, err
+	}
+	getter, ok := fs.(vfs.FsChecksumGetter)
+	if !ok {
+		return vfs.Checksum{}, nil
+	}
+	return getter.GetRecordedChecksum(fsPath)
+}
+
+func (c *integrityCheck) checkFile(virtualPath string, info os.FileInfo) {
+	if c.seenFiles != nil {
+		c.seenFiles[virtualPath] = true
+	}
+	if c.isSampled() && rand.Intn(100) >= c.samplePercentage { //nolint:gosec
+		return
+	}
+	c.result.CheckedFiles++
+	c.result.CheckedSize += info.Size()
+	result := integrityCheckResult{
+		Path:    virtualPath,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	recordedChecksum, err := c.getRecordedChecksum(virtualPath)
+	if err != nil {
+		if errors.Is(err, vfs.ErrObjectArchived) {
+			c.result.SkippedFiles++
+			return
+		}
+		result.Status = integrityStatusFailed
+		result.Error = fmt.Sprintf("unable to get the recorded checksum: %v", err)
+		c.result.addResult(result)
+		return
+	}
+	var algos []string
+	if !recordedChecksum.IsEmpty() {
+		algos = append(algos, recordedChecksum.Algorithm)
+	}
+	var manifestEntry integrityManifestEntry
+	hasManifestEntry := false
+	if c.manifest != nil {
+		manifestEntry, hasManifestEntry = c.manifest.Files[virtualPath]
+		if hasManifestEntry && (manifestEntry.Size != info.Size() ||
+			manifestEntry.ModTime != util.GetTimeAsMsSinceEpoch(info.ModTime())) {
+			// the file was modified after the last check
+			hasManifestEntry = false
+		}
+		if recordedChecksum.Algorithm != manifestChecksumAlgo {
+			algos = append(algos, manifestChecksumAlgo)
+		}
+	}
+	if len(algos) == 0 {
+		c.result.SkippedFiles++
+		return
+	}
+	checksums, err := c.computeChecksums(virtualPath, algos)
+	if err != nil {
+		result.Status = integrityStatusFailed
+		result.Error = err.Error()
+		c.conn.Log(logger.LevelError, "integrity check failed for %q: %v", virtualPath, err)
+		c.result.addResult(result)
+		return
+	}
+	if !recordedChecksum.IsEmpty() && checksums[recordedChecksum.Algorithm] != recordedChecksum.Value {
+		result.Status = integrityStatusCorrupted
+		result.Algorithm = recordedChecksum.Algorithm
+		result.Expected = recordedChecksum.Value
+		result.Actual = checksums[recordedChecksum.Algorithm]
+		c.conn.Log(logger.LevelError, "file %q is corrupted, %s checksum mismatch, expected: %q, actual: %q",
+			virtualPath, result.Algorithm, result.Expected, result.Actual)
+		c.result.addResult(result)
+		return
+	}
+	if hasManifestEntry && checksums[manifestChecksumAlgo] != manifestEntry.Checksum {
+		result.Status = integrityStatusCorrupted
+		result.Algorithm = manifestChecksumAlgo
+		result.Expected = manifestEntry.Checksum
+		result.Actual = checksums[manifestChecksumAlgo]
+		c.conn.Log(logger.LevelError, "file %q is corrupted, manifest checksum mismatch, expected: %q, actual: %q",
+			virtualPath, result.Expected, result.Actual)
+		c.result.addResult(result)
+		return
+	}
+	if !recordedChecksum.IsEmpty() || hasManifestEntry {
+		c.result.VerifiedFiles++
+	}
+	if c.manifest != nil && !hasManifestEntry {
+		c.manifest.Files[virtualPath] = integrityManifestEntry{
+			Size:     info.Size(),
+			ModTime:  util.GetTimeAsMsSinceEpoch(info.ModTime()),
+			Checksum: checksums[manifestChecksumAlgo],
+		}
+		c.result.RecordedFiles++
+	}
+}
+
+func (c *integrityCheck) checkFolder(folderPath string) error {
+	files, err := c.conn.ListDir(folderPath)
+	if err != nil {
+		if err == c.conn.GetNotExistError() {
+			c.conn.Log(logger.LevelDebug, "folder %q does not exist, integrity check skipped", folderPath)
+			return nil
+		}
+		return fmt.Errorf("unable to list directory %q: %w", folderPath, err)
+	}
+	for _, info := range files {
+		virtualPath := path.Join(folderPath, info.Name())
+		if info.IsDir() {
+			if err := c.checkFolder(virtualPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if info.Mode().IsRegular() {
+			c.checkFile(virtualPath, info)
+		}
+	}
+	return nil
+}
+
+// isPathChecked returns true if the specified virtual path is inside one of the checked paths
+func (c *integrityCheck) isPathChecked(virtualPath string) bool {
+	for _, p := range c.paths {
+		if p == "/" || virtualPath == p || strings.HasPrefix(virtualPath, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// getPathsToWalk returns the configured paths excluding the ones included in other paths
+func (c *integrityCheck) getPathsToWalk() []string {
+	var paths []string
+	for _, p := range c.paths {
+		included := false
+		for _, dir := range util.GetDirsForVirtualPath(p) {
+			if dir != p && util.Contains(c.paths, dir) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+func (c *integrityCheck) checkMissingFiles() {
+	for virtualPath, entry := range c.manifest.Files {
+		if c.seenFiles[virtualPath] || !c.isPathChecked(virtualPath) {
+			continue
+		}
+		c.conn.Log(logger.LevelError, "file %q is missing", virtualPath)
+		c.result.addResult(integrityCheckResult{
+			Path:      virtualPath,
+			Size:      entry.Size,
+			ModTime:   util.GetTimeFromMsecSinceEpoch(entry.ModTime),
+			Status:    integrityStatusMissing,
+			Algorithm: manifestChecksumAlgo,
+			Expected:  entry.Checksum,
+		})
+		delete(c.manifest.Files, virtualPath)
+	}
+}
+
+func (c *integrityCheck) start() error {
+	startTime := time.Now()
+	if c.manifestsPath != "" {
+		manifest, err := loadIntegrityManifest(c.manifestsPath, c.conn.User.Username)
+		if err != nil {
+			return err
+		}
+		c.manifest = manifest
+		if !c.isSampled() {
+			c.seenFiles = make(map[string]bool)
+		}
+	}
+	var walkErr error
+	for _, p := range c.getPathsToWalk() {
+		if err := c.checkFolder(p); err != nil {
+			c.conn.Log(logger.LevelError, "integrity check failed for path %q: %v", p, err)
+			walkErr = err
+			break
+		}
+	}
+	if c.manifest != nil {
+		if walkErr == nil && c.seenFiles != nil {
+			c.checkMissingFiles()
+		}
+		if err := c.manifest.save(c.manifestsPath); err != nil {
+			c.conn.Log(logger.LevelError, "unable to save integrity manifest: %v", err)
+			if walkErr == nil {
+				walkErr = err
+			}
+		}
+	}
+	c.conn.Log(logger.LevelInfo, "integrity check completed, checked: %d, size: %d, verified: %d, recorded: %d, "+
+		"skipped: %d, corrupted: %d, missing: %d, failed: %d, elapsed: %s", c.result.CheckedFiles,
+		c.result.CheckedSize, c.result.VerifiedFiles, c.result.RecordedFiles, c.result.SkippedFiles,
+		c.result.CorruptedFiles, c.result.MissingFiles, c.result.FailedFiles, time.Since(startTime))
+	if walkErr != nil {
+		return walkErr
+	}
+	if c.result.getNumProblems() > 0 {
+		return fmt.Errorf("integrity check failed, corrupted files: %d, missing files: %d, failed checks: %d",
+			c.result.CorruptedFiles, c.result.MissingFiles, c.result.FailedFiles)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/jobs"
+	"github.com/drakkan/sftpgo/v2/internal/latency"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+)
+
+// Supported latency throttling modes
+const (
+	LatencyThrottlingModeDisabled = iota
+	LatencyThrottlingModeDelay
+	LatencyThrottlingModeReject
+)
+
+const latencyThrottlingCheckInterval = 10 * time.Second
+
+var (
+	// ErrServerBusy is returned when new connections are rejected because the backends are too slow
+	ErrServerBusy = errors.New("the server is busy, please try later")
+	// latencyThrottled is 1 if the new connections must be throttled
+	latencyThrottled int32
+)
+
+// LatencyThrottlingConfig defines the configuration for the adaptive throttling of
+// new connections based on the latency of the backend operations
+type LatencyThrottlingConfig struct {
+	// 0 disabled, 1 delay new connections, 2 reject new connections while throttling is active
+	Mode int `json:"mode" mapstructure:"mode"`
+	// Delay, as milliseconds, applied to new connections if mode is 1
+	Delay int `json:"delay" mapstructure:"delay"`
+	// Throttling is activated if the 95th percentile latency, as milliseconds, of the S3
+	// PUT requests exceeds this threshold. 0 means no threshold
+	S3PutThreshold int `json:"s3_put_threshold" mapstructure:"s3_put_threshold"`
+	// Throttling is activated if the 95th percentile latency, as milliseconds, of the data
+	// provider queries exceeds this threshold. 0 means no threshold
+	DataProviderThreshold int `json:"data_provider_threshold" mapstructure:"data_provider_threshold"`
+	// Time window, as seconds, for the samples used to compute the percentiles
+	Window int `json:"window" mapstructure:"window"`
+	// Minimum number of samples within the window required to evaluate a threshold
+	MinSamples int `json:"min_samples" mapstructure:"min_samples"`
+	// Throttling is deactivated when all the percentiles fall below this percentage of the
+	// related thresholds. This hysteresis avoids continuous toggling near the thresholds
+	RecoveryPercent int `json:"recovery_percent" mapstructure:"recovery_percent"`
+}
+
+func (c *LatencyThrottlingConfig) isEnabled() bool {
+	return c.Mode != LatencyThrottlingModeDisabled
+}
+
+func (c *LatencyThrottlingConfig) validate() error {
+	if c.Mode < LatencyThrottlingModeDisabled || c.Mode > LatencyThrottlingModeReject {
+		return fmt.Errorf("invalid latency throttling mode: %d", c.Mode)
+	}
+	if !c.isEnabled() {
+		return nil
+	}
+	if c.Mode == LatencyThrottlingModeDelay && c.Delay <= 0 {
+		return errors.New("latency throttling delay must be greater than 0")
+	}
+	if c.S3PutThreshold < 0 || c.DataProviderThreshold < 0 {
+		return errors.New("latency throttling thresholds cannot be negative")
+	}
+	if c.S3PutThreshold == 0 && c.DataProviderThreshold == 0 {
+		return errors.New("at least a latency throttling threshold is required")
+	}
+	if c.Window <= 0 {
+		return errors.New("latency throttling window must be greater than 0")
+	}
+	if c.MinSamples <= 0 {
+		return errors.New("latency throttling min samples must be greater than 0")
+	}
+	if c.RecoveryPercent <= 0 || c.RecoveryPercent > 100 {
+		return fmt.Errorf("invalid latency throttling recovery percent: %d", c.RecoveryPercent)
+	}
+	return nil
+}
+
+func (c *LatencyThrottlingConfig) getThreshold(backend string) time.Duration {
+	switch backend {
+	case latency.BackendS3Put:
+		return time.Duration(c.S3PutThreshold) * time.Millisecond
+	case latency.BackendDataProvider:
+		return time.Duration(c.DataProviderThreshold) * time.Millisecond
+	default:
+		return 0
+	}
+}
+
+// evaluate returns true if the new connections must be throttled.
+// isThrottled is the current status, it is used to apply the hysteresis
+func (c *LatencyThrottlingConfig) evaluate(isThrottled bool) bool {
+	window := time.Duration(c.Window) * time.Second
+	exceeded := false
+	recovered := true
+	for _, backend := range latency.SupportedBackends {
+		p95, samples := latency.GetP95(backend, window)
+		metric.UpdateBackendLatency(backend, p95)
+		threshold := c.getThreshold(backend)
+		if threshold == 0 || samples < c.MinSamples {
+			continue
+		}
+		if p95 > threshold {
+			exceeded = true
+		}
+		if p95 > threshold*time.Duration(c.RecoveryPercent)/100 {
+			recovered = false
+		}
+	}
+	if isThrottled {
+		return !recovered
+	}
+	return exceeded
+}
+
+func (c *LatencyThrottlingConfig) checkLatency() {
+	isThrottled := atomic.LoadInt32(&latencyThrottled) == 1
+	shouldThrottle := c.evaluate(isThrottled)
+	if shouldThrottle == isThrottled {
+		return
+	}
+	if shouldThrottle {
+		atomic.StoreInt32(&latencyThrottled, 1)
+		logger.Warn(logSender, "", "backend latency thresholds exceeded, new connections will be throttled")
+	} else {
+		atomic.StoreInt32(&latencyThrottled, 0)
+		logger.Info(logSender, "", "backend latency recovered, new connections are no longer throttled")
+	}
+	metric.UpdateLatencyThrottling(shouldThrottle)
+}
+
+func startLatencyThrottlingCheck(c LatencyThrottlingConfig) error {
+	atomic.StoreInt32(&latencyThrottled, 0)
+	metric.UpdateLatencyThrottling(false)
+	if !c.isEnabled() {
+		return nil
+	}
+	spec := fmt.Sprintf("@every %s", latencyThrottlingCheckInterval)
+	err := jobs.Add(eventScheduler, "latency_throttling_check", spec, func() error {
+		c.checkLatency()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info(logSender, "", "scheduled latency throttling check, schedule %q, config: %+v", spec, c)
+	return nil
+}
+
+// ThrottleByLatency delays or rejects new connections if the backend latency
+// thresholds are exceeded. It returns ErrServerBusy if the connection must
+// be rejected
+func ThrottleByLatency(protocol, ip string) error {
+	if atomic.LoadInt32(&latencyThrottled) == 0 {
+		return nil
+	}
+	switch Config.LatencyThrottling.Mode {
+	case LatencyThrottlingModeDelay:
+		logger.Debug(logSender, "", "protocol %v ip %v: delaying connection, backend latency thresholds exceeded",
+			protocol, ip)
+		metric.AddLatencyThrottledConnection(false)
+		time.Sleep(time.Duration(Config.LatencyThrottling.Delay) * time.Millisecond)
+		return nil
+	case LatencyThrottlingModeReject:
+		logger.Debug(logSender, "", "protocol %v ip %v: rejecting connection, backend latency thresholds exceeded",
+			protocol, ip)
+		metric.AddLatencyThrottledConnection(true)
+		return ErrServerBusy
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/latency"
+)
+
+func TestLatencyThrottlingConfig(t *testing.T) {
+	c := LatencyThrottlingConfig{}
+	assert.NoError(t, c.validate())
+	c.Mode = 3
+	assert.Error(t, c.validate())
+	c.Mode = LatencyThrottlingModeDelay
+	assert.Error(t, c.validate())
+	c.Delay = 100
+	assert.Error(t, c.validate())
+	c.S3PutThreshold = -1
+	assert.Error(t, c.validate())
+	c.S3PutThreshold = 500
+	assert.Error(t, c.validate())
+	c.Window = 60
+	assert.Error(t, c.validate())
+	c.MinSamples = 10
+	assert.Error(t, c.validate())
+	c.RecoveryPercent = 101
+	assert.Error(t, c.validate())
+	c.RecoveryPercent = 80
+	assert.NoError(t, c.validate())
+	c.Mode = LatencyThrottlingModeReject
+	c.Delay = 0
+	assert.NoError(t, c.validate())
+}
+
+func TestLatencyThrottling(t *testing.T) {
+	latency.Reset()
+	oldConfig := Config
+	defer func() {
+		Config = oldConfig
+		atomic.StoreInt32(&latencyThrottled, 0)
+		latency.Reset()
+	}()
+
+	Config.LatencyThrottling = LatencyThrottlingConfig{
+		Mode:                  LatencyThrottlingModeReject,
+		DataProviderThreshold: 100,
+		Window:                60,
+		MinSamples:            5,
+		RecoveryPercent:       50,
+	}
+	require.NoError(t, Config.LatencyThrottling.validate())
+	// not enough samples
+	for i := 0; i < 4; i++ {
+		latency.Record(latency.BackendDataProvider, time.Second)
+	}
+	Config.LatencyThrottling.checkLatency()
+	assert.NoError(t, ThrottleByLatency(ProtocolSSH, "127.0.0.1"))
+	// S3 has no threshold
+	for i := 0; i < 10; i++ {
+		latency.Record(latency.BackendS3Put, time.Second)
+	}
+	Config.LatencyThrottling.checkLatency()
+	assert.NoError(t, ThrottleByLatency(ProtocolSSH, "127.0.0.1"))
+
+	latency.Record(latency.BackendDataProvider, time.Second)
+	Config.LatencyThrottling.checkLatency()
+	assert.ErrorIs(t, ThrottleByLatency(ProtocolFTP, "127.0.0.1"), ErrServerBusy)
+	// below the threshold but above the recovery limit, throttling is still active
+	latency.Reset()
+	for i := 0; i < 10; i++ {
+		latency.Record(latency.BackendDataProvider, 80*time.Millisecond)
+	}
+	Config.LatencyThrottling.checkLatency()
+	assert.ErrorIs(t, ThrottleByLatency(ProtocolWebDAV, "127.0.0.1"), ErrServerBusy)
+	latency.Reset()
+	for i := 0; i < 10; i++ {
+		latency.Record(latency.BackendDataProvider, 20*time.Millisecond)
+	}
+	Config.LatencyThrottling.checkLatency()
+	assert.NoError(t, ThrottleByLatency(ProtocolHTTP, "127.0.0.1"))
+	// 80ms does not activate throttling
+	latency.Reset()
+	for i := 0; i < 10; i++ {
+		latency.Record(latency.BackendDataProvider, 80*time.Millisecond)
+	}
+	Config.LatencyThrottling.checkLatency()
+	assert.NoError(t, ThrottleByLatency(ProtocolHTTP, "127.0.0.1"))
+	// delay mode
+	Config.LatencyThrottling.Mode = LatencyThrottlingModeDelay
+	Config.LatencyThrottling.Delay = 50
+	latency.Record(latency.BackendDataProvider, time.Second)
+	latency.Record(latency.BackendDataProvider, time.Second)
+	Config.LatencyThrottling.checkLatency()
+	startTime := time.Now()
+	assert.NoError(t, ThrottleByLatency(ProtocolSSH, "127.0.0.1"))
+	assert.GreaterOrEqual(t, time.Since(startTime), 50*time.Millisecond)
+}
@@ -17,18 +17,26 @@ package common_test
 import (
 	"bufio"
 	"bytes"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"mime"
+	"mime/multipart"
 	"net"
 	"net/http"
+	netmail "net/mail"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -47,6 +55,7 @@ import (
 	"github.com/sftpgo/sdk"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	mail "github.com/xhit/go-simple-mail/v2"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
@@ -54,6 +63,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/httpdtest"
+	"github.com/drakkan/sftpgo/v2/internal/jobs"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
@@ -74,6 +84,7 @@ const (
 	osWindows           = "windows"
 	testFileName        = "test_file_common_sftp.dat"
 	testDir             = "test_dir_common"
+	hookSignatureSecret = "hook signature secret"
 )
 
 var (
@@ -84,6 +95,7 @@ var (
 	backupsPath       string
 	testFileContent   = []byte("test data")
 	lastReceivedEmail receivedEmail
+	hookNonces        sync.Map
 )
 
 func TestMain(m *testing.M) {
@@ -181,6 +193,14 @@ func TestMain(m *testing.M) {
 			defer r.MultipartForm.RemoveAll() //nolint:errcheck
 			fmt.Fprintf(w, "OK\n")
 		})
+		http.HandleFunc("/signed", func(w http.ResponseWriter, r *http.Request) {
+			if !verifyHookSignature(r) {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprintf(w, "KO\n")
+				return
+			}
+			fmt.Fprintf(w, "OK\n")
+		})
 		if err := http.ListenAndServe(httpAddr, nil); err != nil {
 			logger.ErrorToConsole("could not start HTTP notification server: %v", err)
 			os.Exit(1)
@@ -1472,6 +1492,98 @@ func TestVirtualFoldersQuotaValues(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestVirtualFoldersUserQuota(t *testing.T) {
+	mappedPath := filepath.Join(os.TempDir(), "vdir")
+	vdirPath := "/vdir"
+	folderName := filepath.Base(mappedPath)
+	u1 := getTestUser()
+	u1.VirtualFolders = append(u1.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name:       folderName,
+			MappedPath: mappedPath,
+		},
+		VirtualPath:    vdirPath,
+		QuotaFiles:     0,
+		QuotaSize:      0,
+		UserQuotaFiles: 1,
+	})
+	user1, _, err := httpdtest.AddUser(u1, http.StatusCreated)
+	assert.NoError(t, err)
+	u2 := getTestUser()
+	u2.Username += "_1"
+	u2.VirtualFolders = u1.VirtualFolders
+	user2, _, err := httpdtest.AddUser(u2, http.StatusCreated)
+	assert.NoError(t, err)
+	testFileSize := int64(131072)
+	conn, client, err := getSftpClient(user1)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = writeSFTPFile(path.Join(vdirPath, testFileName), testFileSize, client)
+		assert.NoError(t, err)
+		// overwriting an existing file is allowed
+		err = writeSFTPFile(path.Join(vdirPath, testFileName), testFileSize, client)
+		assert.NoError(t, err)
+		err = writeSFTPFile(path.Join(vdirPath, testFileName+"1"), testFileSize, client)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), common.ErrQuotaExceeded.Error())
+		}
+		quota, _, err := httpdtest.GetUserFoldersQuota(user1.Username, http.StatusOK)
+		assert.NoError(t, err)
+		if assert.Len(t, quota, 1) {
+			assert.Equal(t, folderName, quota[0].FolderName)
+			assert.Equal(t, vdirPath, quota[0].VirtualPath)
+			assert.Equal(t, 1, quota[0].QuotaFiles)
+			assert.Equal(t, 1, quota[0].UsedQuotaFiles)
+			assert.Equal(t, testFileSize, quota[0].UsedQuotaSize)
+		}
+	}
+	// the per-user limit does not affect the other users
+	conn2, client2, err := getSftpClient(user2)
+	if assert.NoError(t, err) {
+		defer conn2.Close()
+		defer client2.Close()
+
+		err = writeSFTPFile(path.Join(vdirPath, testFileName+"2"), testFileSize, client2)
+		assert.NoError(t, err)
+		f, _, err := httpdtest.GetFolderByName(folderName, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, f.UsedQuotaFiles)
+		assert.Equal(t, 2*testFileSize, f.UsedQuotaSize)
+		quota, _, err := httpdtest.GetUserFoldersQuota(user2.Username, http.StatusOK)
+		assert.NoError(t, err)
+		if assert.Len(t, quota, 1) {
+			assert.Equal(t, 1, quota[0].UsedQuotaFiles)
+		}
+	}
+	if client != nil {
+		err = client.Remove(path.Join(vdirPath, testFileName))
+		assert.NoError(t, err)
+		quota, _, err := httpdtest.GetUserFoldersQuota(user1.Username, http.StatusOK)
+		assert.NoError(t, err)
+		if assert.Len(t, quota, 1) {
+			assert.Equal(t, 0, quota[0].UsedQuotaFiles)
+			assert.Equal(t, int64(0), quota[0].UsedQuotaSize)
+		}
+		err = writeSFTPFile(path.Join(vdirPath, testFileName+"1"), testFileSize, client)
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user2, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName}, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user1.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(user2.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+}
+
 func TestQuotaRenameInsideSameVirtualFolder(t *testing.T) {
 	u := getTestUser()
 	u.QuotaFiles = 100
@@ -2664,6 +2776,108 @@ func TestCrossFolderRename(t *testing.T) {
 	}
 }
 
+func TestCrossBackendMove(t *testing.T) {
+	oldRenameMode := common.Config.RenameMode
+	common.Config.RenameMode = 1
+	defer func() {
+		common.Config.RenameMode = oldRenameMode
+	}()
+
+	folderName := "cryptfolder"
+	vdirPath := "/vdir"
+	u := getTestUser()
+	u.QuotaFiles = 100
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name:       folderName,
+			MappedPath: filepath.Join(os.TempDir(), folderName),
+			FsConfig: vfs.Filesystem{
+				Provider: sdk.CryptedFilesystemProvider,
+				CryptConfig: vfs.CryptFsConfig{
+					Passphrase: kms.NewPlainSecret(defaultPassword),
+				},
+			},
+		},
+		VirtualPath: vdirPath,
+		QuotaSize:   -1,
+		QuotaFiles:  -1,
+	})
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		fileSize := int64(65535)
+		err = writeSFTPFile(testFileName, fileSize, client)
+		assert.NoError(t, err)
+		err = client.Rename(testFileName, path.Join(vdirPath, testFileName))
+		assert.NoError(t, err)
+		_, err = client.Stat(testFileName)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		info, err := client.Stat(path.Join(vdirPath, testFileName))
+		if assert.NoError(t, err) {
+			assert.Equal(t, fileSize, info.Size())
+		}
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, user.UsedQuotaFiles)
+		folder, _, err := httpdtest.GetFolderByName(folderName, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, folder.UsedQuotaFiles)
+		assert.Greater(t, folder.UsedQuotaSize, fileSize)
+		// move back overwriting an existing file
+		err = writeSFTPFile(testFileName, 100, client)
+		assert.NoError(t, err)
+		err = client.PosixRename(path.Join(vdirPath, testFileName), testFileName)
+		assert.NoError(t, err)
+		info, err = client.Stat(testFileName)
+		if assert.NoError(t, err) {
+			assert.Equal(t, fileSize, info.Size())
+		}
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, user.UsedQuotaFiles)
+		folder, _, err = httpdtest.GetFolderByName(folderName, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, folder.UsedQuotaFiles)
+		// directories cannot be moved between different backends
+		err = client.Mkdir(path.Join(vdirPath, "subdir"))
+		assert.NoError(t, err)
+		err = client.Rename(path.Join(vdirPath, "subdir"), "/subdir")
+		assert.ErrorIs(t, err, os.ErrPermission)
+		common.Config.RenameMode = 0
+		err = client.Rename(testFileName, path.Join(vdirPath, testFileName))
+		assert.ErrorIs(t, err, os.ErrPermission)
+		common.Config.RenameMode = 1
+	}
+	// the move is denied if the target has not enough space
+	u.QuotaFiles = 0
+	u.QuotaSize = 100
+	_, _, err = httpdtest.UpdateUser(u, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = client.Rename(testFileName, path.Join(vdirPath, testFileName))
+		assert.Error(t, err)
+		_, err = client.Stat(testFileName)
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName}, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(filepath.Join(os.TempDir(), folderName))
+	assert.NoError(t, err)
+}
+
 func TestDirs(t *testing.T) {
 	u := getTestUser()
 	mappedPath := filepath.Join(os.TempDir(), "vdir")
@@ -3218,6 +3432,9 @@ func TestEventRule(t *testing.T) {
 		Options: dataprovider.BaseEventActionOptions{
 			EmailConfig: dataprovider.EventActionEmailConfig{
 				Recipients: []string{"failure@example.com"},
+				Cc:         []string{"ops@example.com"},
+				Bcc:        []string{"audit@example.com"},
+				ReplyTo:    "noreply@example.com",
 				Subject:    `Failed "{{Event}}" from "{{Name}}"`,
 				Body:       "Fs path {{FsPath}}, protocol: {{Protocol}}, IP: {{IP}} {{ErrorString}}",
 			},
@@ -3429,8 +3646,13 @@ func TestEventRule(t *testing.T) {
 			return lastReceivedEmail.get().From != ""
 		}, 3000*time.Millisecond, 100*time.Millisecond)
 		email = lastReceivedEmail.get()
-		assert.Len(t, email.To, 1)
+		assert.Len(t, email.To, 3)
 		assert.True(t, util.Contains(email.To, "failure@example.com"))
+		assert.True(t, util.Contains(email.To, "ops@example.com"))
+		assert.True(t, util.Contains(email.To, "audit@example.com"))
+		assert.Contains(t, email.Data, "Cc: <ops@example.com>")
+		assert.Contains(t, email.Data, "Reply-To: <noreply@example.com>")
+		assert.NotContains(t, email.Data, "audit@example.com")
 		assert.Contains(t, email.Data, fmt.Sprintf(`Subject: Failed "upload" from "%s"`, user.Username))
 		assert.Contains(t, email.Data, fmt.Sprintf(`action %q failed`, action1.Name))
 		// now test the download rule
@@ -3506,6 +3728,108 @@ func TestEventRule(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestEmailRateLimitAndDigest(t *testing.T) {
+	smtpCfg := smtp.Config{
+		Host:               "127.0.0.1",
+		Port:               2525,
+		From:               "notification@example.com",
+		TemplatesPath:      "templates",
+		MaxEmailsPerMinute: -1,
+	}
+	err := smtpCfg.Initialize(configDir)
+	assert.Error(t, err)
+	smtpCfg.MaxEmailsPerMinute = 0
+	smtpCfg.DigestInterval = -1
+	err = smtpCfg.Initialize(configDir)
+	assert.Error(t, err)
+
+	smtpCfg.MaxEmailsPerMinute = 2
+	smtpCfg.DigestInterval = 0
+	err = smtpCfg.Initialize(configDir)
+	require.NoError(t, err)
+	lastReceivedEmail.reset()
+	err = smtp.SendEmail([]string{"test@example.com"}, "test", "body", smtp.EmailContentTypeTextPlain)
+	assert.NoError(t, err)
+	err = smtp.SendEmail([]string{"test@example.com"}, "test", "body", smtp.EmailContentTypeTextPlain)
+	assert.NoError(t, err)
+	err = smtp.SendEmail([]string{"test@example.com"}, "test", "body", smtp.EmailContentTypeTextPlain)
+	assert.ErrorIs(t, err, smtp.ErrRateLimited)
+	// invalid emails do not consume the rate limit
+	err = smtp.SendEmail(nil, "test", "body", smtp.EmailContentTypeTextPlain)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, smtp.ErrRateLimited)
+
+	smtpCfg.MaxEmailsPerMinute = 0
+	smtpCfg.DigestInterval = 1
+	err = smtpCfg.Initialize(configDir)
+	require.NoError(t, err)
+	addresses := smtp.EmailAddresses{
+		To: []string{"digest@example.com"},
+	}
+	lastReceivedEmail.reset()
+	err = smtp.SendNotification(addresses, "upload", smtp.EmailBody{Content: "first notification", ContentType: smtp.EmailContentTypeTextPlain})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return lastReceivedEmail.get().From != ""
+	}, 1000*time.Millisecond, 50*time.Millisecond)
+	email := lastReceivedEmail.get()
+	assert.True(t, util.Contains(email.To, "digest@example.com"))
+	assert.Contains(t, email.Data, "Subject: upload\r\n")
+	assert.Contains(t, email.Data, "first notification")
+	lastReceivedEmail.reset()
+	err = smtp.SendNotification(addresses, "upload", smtp.EmailBody{Content: "second notification", ContentType: smtp.EmailContentTypeTextPlain})
+	assert.NoError(t, err)
+	err = smtp.SendNotification(addresses, "upload", smtp.EmailBody{Content: "third notification", ContentType: smtp.EmailContentTypeTextPlain})
+	assert.NoError(t, err)
+	err = smtp.SendNotification(smtp.EmailAddresses{}, "upload", smtp.EmailBody{Content: "body", ContentType: smtp.EmailContentTypeTextPlain})
+	assert.Error(t, err)
+	time.Sleep(200 * time.Millisecond)
+	assert.Empty(t, lastReceivedEmail.get().From)
+	assert.Eventually(t, func() bool {
+		return lastReceivedEmail.get().From != ""
+	}, 2000*time.Millisecond, 50*time.Millisecond)
+	email = lastReceivedEmail.get()
+	assert.True(t, util.Contains(email.To, "digest@example.com"))
+	assert.Contains(t, email.Data, "Subject: upload (2 notifications)")
+	assert.Contains(t, email.Data, "second notification")
+	assert.Contains(t, email.Data, "third notification")
+	assert.NotContains(t, email.Data, "first notification")
+	// notifications with attachments are never coalesced
+	lastReceivedEmail.reset()
+	err = smtp.SendNotification(addresses, "upload", smtp.EmailBody{Content: "attachment notification", ContentType: smtp.EmailContentTypeTextPlain},
+		mail.File{Name: "file.txt", Data: []byte("data")})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return lastReceivedEmail.get().From != ""
+	}, 1000*time.Millisecond, 50*time.Millisecond)
+	assert.Contains(t, lastReceivedEmail.get().Data, "attachment notification")
+	lastReceivedEmail.reset()
+	// HTML bodies with a plain text alternative are sent as multipart/alternative
+	err = smtp.SendNotification(addresses, "alternative", smtp.EmailBody{
+		Content:     "<p>html notification</p>",
+		ContentType: smtp.EmailContentTypeTextHTML,
+		Text:        "text notification",
+	})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return lastReceivedEmail.get().From != ""
+	}, 1000*time.Millisecond, 50*time.Millisecond)
+	email = lastReceivedEmail.get()
+	assert.Contains(t, email.Data, "multipart/alternative")
+	assert.Contains(t, email.Data, "text/plain")
+	assert.Contains(t, email.Data, "text notification")
+	assert.Contains(t, email.Data, "text/html")
+	assert.Contains(t, email.Data, "<p>html notification</p>")
+	assert.Less(t, strings.Index(email.Data, "text notification"), strings.Index(email.Data, "<p>html notification</p>"))
+	lastReceivedEmail.reset()
+
+	smtpCfg = smtp.Config{}
+	err = smtpCfg.Initialize(configDir)
+	require.NoError(t, err)
+	err = smtp.SendNotification(addresses, "upload", smtp.EmailBody{Content: "body", ContentType: smtp.EmailContentTypeTextPlain})
+	assert.Error(t, err)
+}
+
 func TestEventRuleProviderEvents(t *testing.T) {
 	if runtime.GOOS == osWindows {
 		t.Skip("this test is not available on Windows")
@@ -4670,55 +4994,273 @@ func TestEventActionEmailAttachments(t *testing.T) {
 	require.NoError(t, err)
 }
 
-func TestEventActionsRetentionReports(t *testing.T) {
+func TestEmailFileAttachments(t *testing.T) {
 	smtpCfg := smtp.Config{
-		Host:          "127.0.0.1",
-		Port:          2525,
-		From:          "notify@example.com",
-		TemplatesPath: "templates",
+		Host:               "127.0.0.1",
+		Port:               2525,
+		From:               "SFTPGo <notify@example.com>",
+		TemplatesPath:      "templates",
+		MaxAttachmentsSize: 1,
 	}
 	err := smtpCfg.Initialize(configDir)
 	require.NoError(t, err)
+	assert.Equal(t, int64(1024*1024), smtp.GetMaxAttachmentsSize())
 
-	testDir := "/d"
-	a1 := dataprovider.BaseEventAction{
-		Name: "action1",
-		Type: dataprovider.ActionTypeDataRetentionCheck,
-		Options: dataprovider.BaseEventActionOptions{
-			RetentionConfig: dataprovider.EventActionDataRetentionConfig{
-				Folders: []dataprovider.FolderRetention{
-					{
-						Path:                  testDir,
-						Retention:             1,
-						DeleteEmptyDirs:       true,
-						IgnoreUserPermissions: true,
-					},
-				},
-			},
-		},
+	baseDir := filepath.Join(os.TempDir(), "email_attachments")
+	err = os.MkdirAll(baseDir, os.ModePerm)
+	assert.NoError(t, err)
+	// the test SMTP server removes the quoted-printable soft line breaks,
+	// so we use sizes that don't require base64 padding
+	content := make([]byte, 65538)
+	_, err = rand.Read(content)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(baseDir, "file.bin"), content, 0666)
+	assert.NoError(t, err)
+	largeContent := make([]byte, smtp.GetMaxAttachmentsSize()+1)
+	err = os.WriteFile(filepath.Join(baseDir, "large.bin"), largeContent, 0666)
+	assert.NoError(t, err)
+	fs := vfs.NewOsFs(xid.New().String(), baseDir, "")
+	addresses := smtp.EmailAddresses{
+		To:      []string{"to@example.com"},
+		Cc:      []string{"cc@example.com"},
+		Bcc:     []string{"bcc@example.com"},
+		ReplyTo: "reply@example.com",
 	}
-	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
+	body := smtp.EmailBody{
+		Content:     "<p>html body</p>",
+		ContentType: smtp.EmailContentTypeTextHTML,
+		Text:        "text body",
+	}
+
+	lastReceivedEmail.reset()
+	err = smtp.SendEmailWithFiles(addresses, "streamed attachments", body,
+		[]smtp.FileAttachment{smtp.NewFileAttachment(fs, filepath.Join(baseDir, "file.bin"), "file.bin", int64(len(content)))},
+		mail.File{Name: "file.txt", Data: []byte("sftpgo")})
 	assert.NoError(t, err)
-	a2 := dataprovider.BaseEventAction{
-		Name: "action2",
+	assert.Eventually(t, func() bool {
+		return lastReceivedEmail.get().From != ""
+	}, 1000*time.Millisecond, 50*time.Millisecond)
+	email := lastReceivedEmail.get()
+	assert.Equal(t, "notify@example.com", email.From)
+	assert.Len(t, email.To, 3)
+	msg, err := netmail.ReadMessage(strings.NewReader(email.Data))
+	require.NoError(t, err)
+	assert.Equal(t, "streamed attachments", msg.Header.Get("Subject"))
+	assert.Equal(t, "<reply@example.com>", msg.Header.Get("Reply-To"))
+	assert.Empty(t, msg.Header.Get("Bcc"))
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+	attachments := make(map[string][]byte)
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if part.FileName() == "" {
+			assert.Contains(t, part.Header.Get("Content-Type"), "multipart/alternative")
+			data, err := io.ReadAll(part)
+			assert.NoError(t, err)
+			assert.Contains(t, string(data), "text body")
+			assert.Contains(t, string(data), "<p>html body</p>")
+			continue
+		}
+		data, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+		assert.NoError(t, err)
+		attachments[part.FileName()] = data
+	}
+	assert.Len(t, attachments, 2)
+	assert.Equal(t, []byte("sftpgo"), attachments["file.txt"])
+	assert.Equal(t, content, attachments["file.bin"])
+	// the limit is checked before sending using the expected size
+	lastReceivedEmail.reset()
+	err = smtp.SendEmailWithFiles(addresses, "too large", body,
+		[]smtp.FileAttachment{smtp.NewFileAttachment(fs, filepath.Join(baseDir, "large.bin"), "large.bin", int64(len(largeContent)))})
+	assert.ErrorIs(t, err, smtp.ErrAttachmentsTooLarge)
+	// and while reading the files
+	err = smtp.SendEmailWithFiles(addresses, "too large", body,
+		[]smtp.FileAttachment{smtp.NewFileAttachment(fs, filepath.Join(baseDir, "large.bin"), "large.bin", 10)})
+	assert.ErrorIs(t, err, smtp.ErrAttachmentsTooLarge)
+	// missing file
+	err = smtp.SendEmailWithFiles(addresses, "missing", body,
+		[]smtp.FileAttachment{smtp.NewFileAttachment(fs, filepath.Join(baseDir, "missing.bin"), "missing.bin", 10)})
+	assert.Error(t, err)
+	err = smtp.SendEmailWithFiles(addresses, "no fs", body, []smtp.FileAttachment{{Name: "file.bin"}})
+	assert.Error(t, err)
+	// the aborted transactions must not deliver any email
+	time.Sleep(200 * time.Millisecond)
+	assert.Empty(t, lastReceivedEmail.get().From)
+
+	smtpCfg.MaxAttachmentsSize = -1
+	err = smtpCfg.Initialize(configDir)
+	assert.Error(t, err)
+	smtpCfg = smtp.Config{}
+	err = smtpCfg.Initialize(configDir)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10*1024*1024), smtp.GetMaxAttachmentsSize())
+	err = smtp.SendEmailWithFiles(addresses, "not configured", body, nil)
+	assert.Error(t, err)
+
+	err = os.RemoveAll(baseDir)
+	assert.NoError(t, err)
+}
+
+func TestEventActionEmailTemplate(t *testing.T) {
+	customTemplatesPath := filepath.Join(os.TempDir(), "custom_templates")
+	smtpCfg := smtp.Config{
+		Host:                "127.0.0.1",
+		Port:                2525,
+		From:                "notify@example.com",
+		TemplatesPath:       "templates",
+		CustomTemplatesPath: customTemplatesPath,
+	}
+	err := smtpCfg.Initialize(configDir)
+	require.NoError(t, err)
+	err = smtp.SaveCustomTemplate("upload.html", "", []byte(`<p>{{.ObjectName}} uploaded "{{.VirtualPath}}" via {{.Protocol}}</p>`))
+	require.NoError(t, err)
+	err = smtp.SaveCustomTemplate("upload.html", "it", []byte(`<p>{{.ObjectName}} ha caricato "{{.VirtualPath}}"</p>`))
+	require.NoError(t, err)
+
+	a1 := dataprovider.BaseEventAction{
+		Name: "action1",
 		Type: dataprovider.ActionTypeEmail,
 		Options: dataprovider.BaseEventActionOptions{
 			EmailConfig: dataprovider.EventActionEmailConfig{
-				Recipients:  []string{"test@example.com"},
-				Subject:     `"{{Event}}" from "{{Name}}"`,
-				Body:        "Fs path {{FsPath}}, size: {{FileSize}}, protocol: {{Protocol}}, IP: {{IP}}",
-				Attachments: []string{dataprovider.RetentionReportPlaceHolder},
+				Recipients: []string{"test@example.com"},
+				Subject:    `"{{Event}}" from "{{Name}}"`,
+				Template:   "upload.html",
 			},
 		},
 	}
-	action2, _, err := httpdtest.AddEventAction(a2, http.StatusCreated)
+	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
 	assert.NoError(t, err)
-	a3 := dataprovider.BaseEventAction{
-		Name: "action3",
-		Type: dataprovider.ActionTypeHTTP,
-		Options: dataprovider.BaseEventActionOptions{
-			HTTPConfig: dataprovider.EventActionHTTPConfig{
-				Endpoint: fmt.Sprintf("http://%s/", httpAddr),
+	r1 := dataprovider.EventRule{
+		Name:    "test email template",
+		Trigger: dataprovider.EventTriggerFsEvent,
+		Conditions: dataprovider.EventConditions{
+			FsEvents: []string{"upload"},
+		},
+		Actions: []dataprovider.EventAction{
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: action1.Name,
+				},
+				Order: 1,
+			},
+		},
+	}
+	rule1, _, err := httpdtest.AddEventRule(r1, http.StatusCreated)
+	assert.NoError(t, err)
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		lastReceivedEmail.reset()
+		err = writeSFTPFile(testFileName, 100, client)
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			return lastReceivedEmail.get().From != ""
+		}, 1500*time.Millisecond, 100*time.Millisecond)
+		email := lastReceivedEmail.get()
+		assert.True(t, util.Contains(email.To, "test@example.com"))
+		assert.Contains(t, email.Data, `Subject: "upload" from`)
+		assert.Contains(t, email.Data, "text/html")
+		assert.Contains(t, email.Data, fmt.Sprintf(`uploaded "/%s" via SFTP`, testFileName))
+		// update the template, the new content is used without a restart
+		err = smtp.SaveCustomTemplate("upload.html", "", []byte(`<p>updated {{.Name}}</p>`))
+		assert.NoError(t, err)
+		lastReceivedEmail.reset()
+		err = writeSFTPFile(testFileName, 100, client)
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			return lastReceivedEmail.get().From != ""
+		}, 1500*time.Millisecond, 100*time.Millisecond)
+		email = lastReceivedEmail.get()
+		assert.Contains(t, email.Data, fmt.Sprintf("updated %s", user.Username))
+		// use the localized template
+		action1.Options.EmailConfig.Language = "it-IT"
+		_, _, err = httpdtest.UpdateEventAction(action1, http.StatusOK)
+		assert.NoError(t, err)
+		lastReceivedEmail.reset()
+		err = writeSFTPFile(testFileName, 100, client)
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			return lastReceivedEmail.get().From != ""
+		}, 1500*time.Millisecond, 100*time.Millisecond)
+		email = lastReceivedEmail.get()
+		assert.Contains(t, email.Data, fmt.Sprintf(`ha caricato "/%s"`, testFileName))
+	}
+
+	_, err = httpdtest.RemoveEventRule(rule1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveEventAction(action1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(customTemplatesPath)
+	assert.NoError(t, err)
+
+	smtpCfg = smtp.Config{}
+	err = smtpCfg.Initialize(configDir)
+	require.NoError(t, err)
+}
+
+func TestEventActionsRetentionReports(t *testing.T) {
+	smtpCfg := smtp.Config{
+		Host:          "127.0.0.1",
+		Port:          2525,
+		From:          "notify@example.com",
+		TemplatesPath: "templates",
+	}
+	err := smtpCfg.Initialize(configDir)
+	require.NoError(t, err)
+
+	testDir := "/d"
+	a1 := dataprovider.BaseEventAction{
+		Name: "action1",
+		Type: dataprovider.ActionTypeDataRetentionCheck,
+		Options: dataprovider.BaseEventActionOptions{
+			RetentionConfig: dataprovider.EventActionDataRetentionConfig{
+				Folders: []dataprovider.FolderRetention{
+					{
+						Path:                  testDir,
+						Retention:             1,
+						DeleteEmptyDirs:       true,
+						IgnoreUserPermissions: true,
+					},
+				},
+			},
+		},
+	}
+	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
+	assert.NoError(t, err)
+	a2 := dataprovider.BaseEventAction{
+		Name: "action2",
+		Type: dataprovider.ActionTypeEmail,
+		Options: dataprovider.BaseEventActionOptions{
+			EmailConfig: dataprovider.EventActionEmailConfig{
+				Recipients:  []string{"test@example.com"},
+				Subject:     `"{{Event}}" from "{{Name}}"`,
+				Body:        "Fs path {{FsPath}}, size: {{FileSize}}, protocol: {{Protocol}}, IP: {{IP}}",
+				Attachments: []string{dataprovider.RetentionReportPlaceHolder},
+			},
+		},
+	}
+	action2, _, err := httpdtest.AddEventAction(a2, http.StatusCreated)
+	assert.NoError(t, err)
+	a3 := dataprovider.BaseEventAction{
+		Name: "action3",
+		Type: dataprovider.ActionTypeHTTP,
+		Options: dataprovider.BaseEventActionOptions{
+			HTTPConfig: dataprovider.EventActionHTTPConfig{
+				Endpoint: fmt.Sprintf("http://%s/", httpAddr),
 				Timeout:  20,
 				Method:   http.MethodPost,
 				Body:     dataprovider.RetentionReportPlaceHolder,
@@ -5171,6 +5713,151 @@ func TestEventRuleCertificate(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestEventRuleOnDemand(t *testing.T) {
+	smtpCfg := smtp.Config{
+		Host:          "127.0.0.1",
+		Port:          2525,
+		From:          "notify@example.com",
+		TemplatesPath: "templates",
+	}
+	err := smtpCfg.Initialize(configDir)
+	require.NoError(t, err)
+	lastReceivedEmail.reset()
+
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	a1 := dataprovider.BaseEventAction{
+		Name: "action1",
+		Type: dataprovider.ActionTypeEmail,
+		Options: dataprovider.BaseEventActionOptions{
+			EmailConfig: dataprovider.EventActionEmailConfig{
+				Recipients: []string{"test@example.com"},
+				Subject:    `"{{Event}} {{StatusString}}"`,
+				Body:       "Name: {{Name}} Path: {{VirtualPath}} Object: {{ObjectName}} Order: {{OrderID}}",
+			},
+		},
+	}
+	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
+	assert.NoError(t, err)
+	r1 := dataprovider.EventRule{
+		Name:    "test rule on demand",
+		Trigger: dataprovider.EventTriggerOnDemand,
+		Conditions: dataprovider.EventConditions{
+			Options: dataprovider.ConditionOptions{
+				Names: []dataprovider.ConditionPattern{
+					{
+						Pattern: user.Username,
+					},
+				},
+			},
+		},
+		Actions: []dataprovider.EventAction{
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: action1.Name,
+				},
+				Order: 1,
+			},
+		},
+	}
+	rule1, _, err := httpdtest.AddEventRule(r1, http.StatusCreated)
+	assert.NoError(t, err)
+	r2 := dataprovider.EventRule{
+		Name:    "test rule certificate",
+		Trigger: dataprovider.EventTriggerCertificate,
+		Actions: []dataprovider.EventAction{
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: action1.Name,
+				},
+				Order: 1,
+			},
+		},
+	}
+	rule2, _, err := httpdtest.AddEventRule(r2, http.StatusCreated)
+	assert.NoError(t, err)
+
+	err = common.RunOnDemandRule(rule1.Name, common.OnDemandRuleParams{
+		Username: user.Username,
+		Path:     "orders/../orders/123.csv",
+		Placeholders: map[string]string{
+			"OrderID": "123",
+		},
+	}, "admin", "127.0.0.1")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return lastReceivedEmail.get().From != ""
+	}, 3000*time.Millisecond, 100*time.Millisecond)
+	email := lastReceivedEmail.get()
+	assert.Len(t, email.To, 1)
+	assert.True(t, util.Contains(email.To, "test@example.com"))
+	assert.Contains(t, email.Data, `Subject: "On demand OK"`)
+	assert.Contains(t, email.Data, fmt.Sprintf("Name: %s Path: /orders/123.csv Object: 123.csv Order: 123", user.Username))
+
+	lastReceivedEmail.reset()
+	err = common.RunOnDemandRule(rule1.Name, common.OnDemandRuleParams{}, "admin", "127.0.0.1")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return lastReceivedEmail.get().From != ""
+	}, 3000*time.Millisecond, 100*time.Millisecond)
+	email = lastReceivedEmail.get()
+	assert.Contains(t, email.Data, "Name: admin Path:  Object:  Order: {{OrderID}}")
+
+	err = common.RunOnDemandRule(rule1.Name, common.OnDemandRuleParams{
+		Placeholders: map[string]string{
+			"Name": "value",
+		},
+	}, "admin", "127.0.0.1")
+	assert.ErrorContains(t, err, "conflicts with a built-in placeholder")
+	err = common.RunOnDemandRule(rule1.Name, common.OnDemandRuleParams{
+		Placeholders: map[string]string{
+			"1invalid": "value",
+		},
+	}, "admin", "127.0.0.1")
+	assert.ErrorContains(t, err, "invalid placeholder name")
+	placeholders := make(map[string]string)
+	for i := 0; i < 51; i++ {
+		placeholders[fmt.Sprintf("P%d", i)] = "value"
+	}
+	err = common.RunOnDemandRule(rule1.Name, common.OnDemandRuleParams{
+		Placeholders: placeholders,
+	}, "admin", "127.0.0.1")
+	assert.ErrorContains(t, err, "too many placeholders")
+	err = common.RunOnDemandRule(rule1.Name, common.OnDemandRuleParams{
+		Username: "missing user",
+	}, "admin", "127.0.0.1")
+	_, ok := err.(*util.RecordNotFoundError)
+	assert.True(t, ok, "unexpected error: %v", err)
+	err = common.RunOnDemandRule("missing rule", common.OnDemandRuleParams{}, "admin", "127.0.0.1")
+	_, ok = err.(*util.RecordNotFoundError)
+	assert.True(t, ok, "unexpected error: %v", err)
+	err = common.RunOnDemandRule(rule2.Name, common.OnDemandRuleParams{}, "admin", "127.0.0.1")
+	assert.ErrorContains(t, err, "cannot be executed on demand")
+
+	rule1.Conditions.Options.Names[0].InverseMatch = true
+	_, _, err = httpdtest.UpdateEventRule(rule1, http.StatusOK)
+	assert.NoError(t, err)
+	err = common.RunOnDemandRule(rule1.Name, common.OnDemandRuleParams{
+		Username: user.Username,
+	}, "admin", "127.0.0.1")
+	assert.ErrorContains(t, err, "does not match the conditions")
+
+	_, err = httpdtest.RemoveEventRule(rule1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveEventRule(rule2, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveEventAction(action1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	smtpCfg = smtp.Config{}
+	err = smtpCfg.Initialize(configDir)
+	require.NoError(t, err)
+}
+
 func TestEventRuleIPBlocked(t *testing.T) {
 	oldConfig := config.GetCommonConfig()
 
@@ -5294,6 +5981,93 @@ func TestEventRuleIPBlocked(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestTempFilesCleanup(t *testing.T) {
+	oldConfig := config.GetCommonConfig()
+
+	tempPath := filepath.Join(os.TempDir(), "temp_cleanup")
+	err := os.MkdirAll(tempPath, os.ModePerm)
+	require.NoError(t, err)
+	cfg := config.GetCommonConfig()
+	cfg.TempPath = tempPath
+	cfg.TempFilesCleanup.Interval = 60
+	cfg.TempFilesCleanup.MaxAge = 60
+	cfg.TempFilesCleanup.ScanStorages = true
+	err = common.Initialize(cfg, 0)
+	require.NoError(t, err)
+
+	mappedPath := filepath.Join(os.TempDir(), "vdir_cleanup")
+	folderName := filepath.Base(mappedPath)
+	u := getTestUser()
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name:       folderName,
+			MappedPath: mappedPath,
+		},
+		VirtualPath: "/vdir",
+	})
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	oldTime := time.Now().Add(-2 * time.Hour)
+	staleFiles := []string{
+		filepath.Join(tempPath, "pipefile1234"),
+		filepath.Join(user.GetHomeDir(), "sub", ".sftpgo-upload.cdi6on3c1e8cn6o0k4sg.file"),
+		filepath.Join(mappedPath, ".sftpgo-upload.cdi6on3c1e8cn6o0k4sh.file"),
+	}
+	keptFiles := []string{
+		filepath.Join(tempPath, "file"),
+		filepath.Join(user.GetHomeDir(), "file"),
+		filepath.Join(mappedPath, "sub", "file"),
+	}
+	for _, name := range append(staleFiles, keptFiles...) {
+		err = os.MkdirAll(filepath.Dir(name), os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(name, []byte("data"), 0666)
+		require.NoError(t, err)
+		err = os.Chtimes(name, oldTime, oldTime)
+		require.NoError(t, err)
+	}
+	recentFile := filepath.Join(user.GetHomeDir(), ".sftpgo-upload.cdi6on3c1e8cn6o0k4si.file")
+	err = os.WriteFile(recentFile, []byte("data"), 0666)
+	require.NoError(t, err)
+	keptFiles = append(keptFiles, recentFile)
+
+	status, err := jobs.GetJob("temp_files_cleanup")
+	require.NoError(t, err)
+	runs := status.Runs
+	err = jobs.Run("temp_files_cleanup")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		status, err := jobs.GetJob("temp_files_cleanup")
+		return err == nil && status.Runs > runs && status.Status != jobs.StatusRunning
+	}, 2*time.Second, 50*time.Millisecond)
+	status, err = jobs.GetJob("temp_files_cleanup")
+	assert.NoError(t, err)
+	assert.Equal(t, jobs.ResultSuccess, status.LastResult)
+	for _, name := range staleFiles {
+		assert.NoFileExists(t, name)
+	}
+	for _, name := range keptFiles {
+		assert.FileExists(t, name)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName}, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+	err = os.RemoveAll(tempPath)
+	assert.NoError(t, err)
+
+	err = common.Initialize(oldConfig, 0)
+	assert.NoError(t, err)
+	_, err = jobs.GetJob("temp_files_cleanup")
+	assert.Error(t, err)
+}
+
 func TestSyncUploadAction(t *testing.T) {
 	if runtime.GOOS == osWindows {
 		t.Skip("this test is not available on Windows")
@@ -5411,6 +6185,86 @@ func TestQuotaTrackDisabled(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestAuthCircuitBreaker(t *testing.T) {
+	err := dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	providerConf.CircuitBreaker.FailureThreshold = 2
+	providerConf.CircuitBreaker.OpenTimeout = 1
+	providerConf.CircuitBreaker.ReadOnlyCacheTTL = 60
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		err = writeSFTPFile(testFileName, 32, client)
+		assert.NoError(t, err)
+		client.Close()
+		conn.Close()
+	}
+	assert.False(t, dataprovider.IsAuthCircuitOpen())
+	// simulate a data provider outage
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+
+	_, err = dataprovider.CheckUserAndPass(user.Username, defaultPassword, "127.0.0.1", common.ProtocolSSH)
+	assert.NoError(t, err)
+	_, err = dataprovider.CheckUserAndPass(user.Username, "wrong", "127.0.0.1", common.ProtocolSSH)
+	assert.ErrorIs(t, err, dataprovider.ErrProviderUnavailable)
+	assert.True(t, dataprovider.IsAuthCircuitOpen())
+	// cached credentials are not used for HTTP
+	_, err = dataprovider.CheckUserAndPass(user.Username, defaultPassword, "127.0.0.1", common.ProtocolHTTP)
+	assert.ErrorIs(t, err, dataprovider.ErrProviderUnavailable)
+	_, err = dataprovider.CheckUserAndPass("missing user", defaultPassword, "127.0.0.1", common.ProtocolSSH)
+	assert.ErrorIs(t, err, dataprovider.ErrProviderUnavailable)
+
+	cachedUser, err := dataprovider.CheckUserAndPass(user.Username, defaultPassword, "127.0.0.1", common.ProtocolSSH)
+	if assert.NoError(t, err) {
+		assert.True(t, cachedUser.IsReadOnlyFallback())
+		assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermDownload}, cachedUser.Permissions["/"])
+	}
+	conn, client, err = getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		info, err := client.Stat(testFileName)
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(32), info.Size())
+		}
+		err = writeSFTPFile(testFileName+"1", 32, client)
+		assert.ErrorIs(t, err, os.ErrPermission)
+		err = client.Remove(testFileName)
+		assert.ErrorIs(t, err, os.ErrPermission)
+	}
+
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	assert.False(t, dataprovider.IsAuthCircuitOpen())
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	providerConf.CircuitBreaker.OpenTimeout = 0
+	providerConf.CircuitBreaker.FailureThreshold = 1
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	providerConf = config.GetProviderConf()
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+}
+
 func TestGetQuotaError(t *testing.T) {
 	if dataprovider.GetProviderStatus().Driver == "memory" {
 		t.Skip("this test is not available with the memory provider")
@@ -6253,6 +7107,103 @@ func TestGetFsError(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestHookSignature(t *testing.T) {
+	httpConfig := config.GetHTTPConfig()
+	httpConfig.Timeout = 5
+	httpConfig.RetryMax = 0
+	httpConfig.Signature.Secret = hookSignatureSecret
+	httpConfig.Signature.NonceHeader = httpConfig.Signature.TimestampHeader
+	err := httpConfig.Initialize(configDir)
+	assert.Error(t, err)
+	httpConfig.Signature.NonceHeader = "x-custom-nonce"
+	err = httpConfig.Initialize(configDir)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		httpConfig.Signature.Secret = ""
+		err := httpConfig.Initialize(configDir)
+		assert.NoError(t, err)
+	})
+	assert.True(t, httpclient.IsSignatureEnabled())
+
+	signedURL := fmt.Sprintf("http://%s/signed?ip=%s&protocol=%s", httpAddr, "127.0.0.1", common.ProtocolSSH)
+	resp, err := httpclient.Get(signedURL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, resp.Request.Header.Get("X-Custom-Nonce"))
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+	resp, err = httpclient.RetryableGet(signedURL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	signedReqHeaders := resp.Request.Header.Clone()
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+	resp, err = httpclient.Post(signedURL, "application/json", bytes.NewBufferString(`{"key":"value"}`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+	resp, err = httpclient.RetryablePost(signedURL, "application/json", bytes.NewBufferString(`{"key":"value"}`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+	// replayed and tampered requests must be rejected
+	for _, reqURL := range []string{signedURL, strings.Replace(signedURL, common.ProtocolSSH, common.ProtocolFTP, 1)} {
+		req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+		require.NoError(t, err)
+		req.Header = signedReqHeaders.Clone()
+		resp, err := httpclient.GetHTTPClient().Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		err = resp.Body.Close()
+		assert.NoError(t, err)
+	}
+	// unsigned requests must be rejected
+	req, err := http.NewRequest(http.MethodGet, signedURL, nil)
+	require.NoError(t, err)
+	resp, err = httpclient.GetHTTPClient().Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+}
+
+func verifyHookSignature(r *http.Request) bool {
+	timestamp := r.Header.Get("X-SFTPGo-Timestamp")
+	nonce := r.Header.Get("X-Custom-Nonce")
+	if nonce == "" {
+		nonce = r.Header.Get("X-SFTPGo-Nonce")
+	}
+	signature := r.Header.Get("X-SFTPGo-Signature")
+	if timestamp == "" || nonce == "" || signature == "" {
+		return false
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || math.Abs(float64(time.Now().Unix()-ts)) > 300 {
+		return false
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return false
+	}
+	payloadHash := sha256.Sum256(body)
+	hashes := []string{hex.EncodeToString(payloadHash[:])}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		hashes = append(hashes, httpclient.UnsignedPayload)
+	}
+	for _, h := range hashes {
+		mac := hmac.New(sha256.New, []byte(hookSignatureSecret))
+		mac.Write([]byte(strings.Join([]string{timestamp, nonce, r.Method, r.URL.RequestURI(), h}, "\n")))
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if hmac.Equal([]byte(expected), []byte(signature)) {
+			_, loaded := hookNonces.LoadOrStore(nonce, true)
+			return !loaded
+		}
+	}
+	return false
+}
+
 func waitTCPListening(address string) {
 	for {
 		conn, err := net.Dial("tcp", address)
@@ -6419,7 +7370,8 @@ func isDbDefenderSupported() bool {
 	// to use it outside test cases
 	switch dataprovider.GetProviderStatus().Driver {
 	case dataprovider.MySQLDataProviderName, dataprovider.PGSQLDataProviderName,
-		dataprovider.CockroachDataProviderName, dataprovider.SQLiteDataProviderName:
+		dataprovider.CockroachDataProviderName, dataprovider.SQLiteDataProviderName,
+		dataprovider.MongoDBDataProviderName:
 		return true
 	default:
 		return false
@@ -0,0 +1,59 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func TestReadCacheConfig(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	c := vfs.ReadCacheConfig{}
+	assert.NoError(t, vfs.InitializeReadCache(c))
+	c.Path = "relative"
+	assert.Error(t, vfs.InitializeReadCache(c))
+	c.Path = cacheDir
+	assert.Error(t, vfs.InitializeReadCache(c))
+	c.MaxSize = 10
+	c.MaxObjectSize = 20
+	assert.Error(t, vfs.InitializeReadCache(c))
+	c.MaxObjectSize = 5
+	c.TTL = -1
+	assert.Error(t, vfs.InitializeReadCache(c))
+	c.TTL = 10
+	c.ConsistencyMode = 2
+	assert.Error(t, vfs.InitializeReadCache(c))
+	c.ConsistencyMode = vfs.ReadCacheConsistencyValidate
+	assert.NoError(t, vfs.InitializeReadCache(c))
+	assert.DirExists(t, cacheDir)
+	// objects cached by a previous run are removed
+	staleFile := filepath.Join(cacheDir, "sftpgo-cache-123456")
+	otherFile := filepath.Join(cacheDir, "file.txt")
+	for _, name := range []string{staleFile, otherFile} {
+		err := os.WriteFile(name, []byte("data"), 0666)
+		require.NoError(t, err)
+	}
+	assert.NoError(t, vfs.InitializeReadCache(c))
+	assert.NoFileExists(t, staleFile)
+	assert.FileExists(t, otherFile)
+
+	assert.NoError(t, vfs.InitializeReadCache(vfs.ReadCacheConfig{}))
+}
@@ -0,0 +1,168 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/jobs"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+)
+
+const (
+	resourceLimitsRecoveryPercent = 90
+	resourceLimitsEventExceeded   = "Resource limits exceeded"
+	resourceLimitsEventRestored   = "Resource limits restored"
+)
+
+var (
+	// resourceLimitsExceeded is 1 if new connections must be rejected
+	// because the configured resource limits are exceeded
+	resourceLimitsExceeded int32
+	// allow to mock the resource usage in test cases
+	getResourceUsage = readResourceUsage
+)
+
+// ResourceLimitsConfig defines soft limits for the resources used by the SFTPGo process.
+// While a limit is exceeded new connections are rejected, existing connections are
+// not affected. The limits are checked periodically and new connections are allowed
+// again once the resource usage falls below 90% of all the configured limits
+type ResourceLimitsConfig struct {
+	// Maximum resident set size, as MB. 0 means no limit
+	MaxRSS int `json:"max_rss" mapstructure:"max_rss"`
+	// Maximum number of goroutines. 0 means no limit
+	MaxGoroutines int `json:"max_goroutines" mapstructure:"max_goroutines"`
+	// Check interval as seconds
+	CheckInterval int `json:"check_interval" mapstructure:"check_interval"`
+}
+
+func (c *ResourceLimitsConfig) isEnabled() bool {
+	return c.MaxRSS > 0 || c.MaxGoroutines > 0
+}
+
+func (c *ResourceLimitsConfig) validate() error {
+	if c.MaxRSS < 0 || c.MaxGoroutines < 0 {
+		return errors.New("resource limits cannot be negative")
+	}
+	if c.isEnabled() && c.CheckInterval <= 0 {
+		return errors.New("resource limits check interval must be greater than 0")
+	}
+	return nil
+}
+
+type resourceUsage struct {
+	// resident set size as bytes
+	rss        uint64
+	goroutines int
+}
+
+func readResourceUsage() (resourceUsage, error) {
+	rss, err := getProcessRSS()
+	return resourceUsage{
+		rss:        rss,
+		goroutines: runtime.NumGoroutine(),
+	}, err
+}
+
+// evaluate returns true if the resource limits are exceeded and a description
+// of the exceeded limits. isExceeded is the current status, it is used to apply
+// the hysteresis
+func (c *ResourceLimitsConfig) evaluate(usage resourceUsage, isExceeded bool) (bool, string) {
+	var exceeded []string
+	recovered := true
+
+	if c.MaxRSS > 0 {
+		maxRSS := uint64(c.MaxRSS) * 1048576
+		if usage.rss > maxRSS {
+			exceeded = append(exceeded, fmt.Sprintf("RSS %d MB, limit %d MB", usage.rss/1048576, c.MaxRSS))
+		}
+		if usage.rss > maxRSS*resourceLimitsRecoveryPercent/100 {
+			recovered = false
+		}
+	}
+	if c.MaxGoroutines > 0 {
+		if usage.goroutines > c.MaxGoroutines {
+			exceeded = append(exceeded, fmt.Sprintf("goroutines %d, limit %d", usage.goroutines, c.MaxGoroutines))
+		}
+		if usage.goroutines > c.MaxGoroutines*resourceLimitsRecoveryPercent/100 {
+			recovered = false
+		}
+	}
+	if isExceeded {
+		return !recovered, strings.Join(exceeded, ", ")
+	}
+	return len(exceeded) > 0, strings.Join(exceeded, ", ")
+}
+
+func (c *ResourceLimitsConfig) checkUsage() {
+	usage, err := getResourceUsage()
+	if err != nil {
+		logger.Warn(logSender, "", "unable to get the resource usage: %v", err)
+		return
+	}
+	isExceeded := atomic.LoadInt32(&resourceLimitsExceeded) == 1
+	shouldReject, details := c.evaluate(usage, isExceeded)
+	if shouldReject == isExceeded {
+		return
+	}
+	params := EventParams{
+		Name:      "sftpgo",
+		Timestamp: time.Now().UnixNano(),
+	}
+	if shouldReject {
+		atomic.StoreInt32(&resourceLimitsExceeded, 1)
+		logger.Error(logSender, "", "resource limits exceeded: %s, new connections will be rejected", details)
+		params.Event = resourceLimitsEventExceeded
+		params.Status = 2
+		params.AddError(fmt.Errorf("resource limits exceeded: %s", details))
+	} else {
+		atomic.StoreInt32(&resourceLimitsExceeded, 0)
+		logger.Info(logSender, "", "resource usage recovered, RSS %d MB, goroutines %d, new connections are allowed",
+			usage.rss/1048576, usage.goroutines)
+		params.Event = resourceLimitsEventRestored
+		params.Status = 1
+	}
+	metric.UpdateResourceLimitsExceeded(shouldReject)
+	eventManager.handleResourceLimitsEvent(params)
+}
+
+func startResourceLimitsCheck(c ResourceLimitsConfig) error {
+	atomic.StoreInt32(&resourceLimitsExceeded, 0)
+	metric.UpdateResourceLimitsExceeded(false)
+	if !c.isEnabled() {
+		return nil
+	}
+	spec := fmt.Sprintf("@every %s", time.Duration(c.CheckInterval)*time.Second)
+	err := jobs.Add(eventScheduler, "resource_limits_check", spec, func() error {
+		c.checkUsage()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info(logSender, "", "scheduled resource limits check, schedule %q, config: %+v", spec, c)
+	return nil
+}
+
+// AreResourceLimitsExceeded returns true if the configured resource limits are exceeded
+func AreResourceLimitsExceeded() bool {
+	return atomic.LoadInt32(&resourceLimitsExceeded) == 1
+}
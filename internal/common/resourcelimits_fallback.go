@@ -0,0 +1,43 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !linux
+// +build !linux
+
+package common
+
+import (
+	"runtime/metrics"
+)
+
+// getProcessRSS returns an estimate of the resident set size, as bytes, of the
+// current process: the memory mapped by the Go runtime minus the memory released
+// to the operating system. Memory allocated outside the Go runtime is not included
+func getProcessRSS() (uint64, error) {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	var values [2]uint64
+	for idx := range samples {
+		if samples[idx].Value.Kind() == metrics.KindUint64 {
+			values[idx] = samples[idx].Value.Uint64()
+		}
+	}
+	if values[1] > values[0] {
+		return 0, nil
+	}
+	return values[0] - values[1], nil
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+)
+
+func TestResourceLimitsConfig(t *testing.T) {
+	c := ResourceLimitsConfig{}
+	assert.NoError(t, c.validate())
+	assert.False(t, c.isEnabled())
+	c.MaxRSS = -1
+	assert.Error(t, c.validate())
+	c.MaxRSS = 1024
+	assert.True(t, c.isEnabled())
+	assert.Error(t, c.validate())
+	c.CheckInterval = 10
+	assert.NoError(t, c.validate())
+	c.MaxGoroutines = -1
+	assert.Error(t, c.validate())
+	c.MaxGoroutines = 100
+	assert.NoError(t, c.validate())
+}
+
+func TestResourceUsage(t *testing.T) {
+	usage, err := readResourceUsage()
+	require.NoError(t, err)
+	assert.Greater(t, usage.rss, uint64(0))
+	assert.Greater(t, usage.goroutines, 0)
+}
+
+func TestResourceLimits(t *testing.T) {
+	oldConfig := Config
+	oldGetResourceUsage := getResourceUsage
+	defer func() {
+		Config = oldConfig
+		getResourceUsage = oldGetResourceUsage
+		atomic.StoreInt32(&resourceLimitsExceeded, 0)
+	}()
+
+	usage := resourceUsage{
+		rss:        512 * 1048576,
+		goroutines: 50,
+	}
+	getResourceUsage = func() (resourceUsage, error) {
+		return usage, nil
+	}
+	Config.MaxTotalConnections = 0
+	Config.MaxPerHostConnections = 0
+	Config.ResourceLimits = ResourceLimitsConfig{
+		MaxRSS:        1024,
+		MaxGoroutines: 100,
+		CheckInterval: 10,
+	}
+	require.NoError(t, Config.ResourceLimits.validate())
+	Config.ResourceLimits.checkUsage()
+	assert.True(t, Connections.IsNewConnectionAllowed("127.0.0.1"))
+
+	usage.goroutines = 101
+	Config.ResourceLimits.checkUsage()
+	assert.False(t, Connections.IsNewConnectionAllowed("127.0.0.1"))
+	// below the limit but above the recovery threshold
+	usage.goroutines = 95
+	Config.ResourceLimits.checkUsage()
+	assert.False(t, Connections.IsNewConnectionAllowed("127.0.0.1"))
+	usage.goroutines = 80
+	Config.ResourceLimits.checkUsage()
+	assert.True(t, Connections.IsNewConnectionAllowed("127.0.0.1"))
+
+	usage.rss = 1025 * 1048576
+	exceeded, details := Config.ResourceLimits.evaluate(usage, false)
+	assert.True(t, exceeded)
+	assert.Contains(t, details, "RSS 1025 MB, limit 1024 MB")
+	Config.ResourceLimits.checkUsage()
+	assert.False(t, Connections.IsNewConnectionAllowed("127.0.0.1"))
+	usage.rss = 950 * 1048576
+	Config.ResourceLimits.checkUsage()
+	assert.False(t, Connections.IsNewConnectionAllowed("127.0.0.1"))
+	usage.rss = 900 * 1048576
+	Config.ResourceLimits.checkUsage()
+	assert.True(t, Connections.IsNewConnectionAllowed("127.0.0.1"))
+	// errors reading the usage do not change the status
+	usage.goroutines = 200
+	Config.ResourceLimits.checkUsage()
+	assert.False(t, Connections.IsNewConnectionAllowed("127.0.0.1"))
+	getResourceUsage = func() (resourceUsage, error) {
+		return resourceUsage{}, errors.New("unable to read usage")
+	}
+	Config.ResourceLimits.checkUsage()
+	assert.False(t, Connections.IsNewConnectionAllowed("127.0.0.1"))
+	// starting the check resets the status
+	err := startResourceLimitsCheck(ResourceLimitsConfig{})
+	assert.NoError(t, err)
+	assert.True(t, Connections.IsNewConnectionAllowed("127.0.0.1"))
+}
+
+func TestResourceLimitsEventRules(t *testing.T) {
+	r := eventRulesContainer{
+		schedulesMapping: make(map[string][]cron.EntryID),
+	}
+	rule := dataprovider.EventRule{
+		Name:    "resource limits rule",
+		Trigger: dataprovider.EventTriggerResourceLimits,
+	}
+	r.addUpdateRuleInternal(rule)
+	assert.Len(t, r.ResourceLimitsEvents, 1)
+	r.removeRuleInternal(rule.Name)
+	assert.Len(t, r.ResourceLimitsEvents, 0)
+	// no rules, nothing to do
+	r.handleResourceLimitsEvent(EventParams{Event: resourceLimitsEventExceeded})
+}
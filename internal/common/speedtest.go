@@ -0,0 +1,125 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// DefaultSpeedTestSize defines the size, in bytes, used for download speed tests
+	// if no size is specified
+	DefaultSpeedTestSize int64 = 10 * 1048576
+	// MaxSpeedTestSize defines the maximum size, in bytes, allowed for speed tests
+	MaxSpeedTestSize    int64 = 1024 * 1048576
+	speedTestBufferSize       = 65536
+)
+
+// speedTestData is generated once at startup, the same random block is repeated
+// for each speed test so generating the data has a negligible cost and it is not
+// compressible
+var speedTestData = newSpeedTestData()
+
+func newSpeedTestData() []byte {
+	data := make([]byte, speedTestBufferSize)
+	rand.Read(data) //nolint:errcheck
+	return data
+}
+
+// SpeedTestResult defines the result of a speed test
+type SpeedTestResult struct {
+	// transferred bytes
+	Bytes int64 `json:"bytes"`
+	// elapsed time as milliseconds
+	Elapsed int64 `json:"elapsed"`
+	// throughput as bytes per second
+	Throughput int64 `json:"throughput"`
+}
+
+// NewSpeedTestResult returns a speed test result for the specified transferred bytes and elapsed time
+func NewSpeedTestResult(bytes int64, elapsed time.Duration) SpeedTestResult {
+	result := SpeedTestResult{
+		Bytes:   bytes,
+		Elapsed: elapsed.Milliseconds(),
+	}
+	if elapsed > 0 {
+		result.Throughput = int64(float64(bytes) / elapsed.Seconds())
+	}
+	return result
+}
+
+// String returns a human readable representation of the result
+func (r *SpeedTestResult) String() string {
+	return fmt.Sprintf("%s transferred in %d ms, throughput: %s/s", util.ByteCountIEC(r.Bytes), r.Elapsed,
+		util.ByteCountIEC(r.Throughput))
+}
+
+// GetSpeedTestSize parses and validates the requested speed test size.
+// An empty value means DefaultSpeedTestSize
+func GetSpeedTestSize(val string) (int64, error) {
+	if val == "" {
+		return DefaultSpeedTestSize, nil
+	}
+	size, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, util.NewValidationError(fmt.Sprintf("invalid speed test size %q", val))
+	}
+	if size <= 0 || size > MaxSpeedTestSize {
+		return 0, util.NewValidationError(fmt.Sprintf("invalid speed test size %d, it must be between 1 and %d bytes",
+			size, MaxSpeedTestSize))
+	}
+	return size, nil
+}
+
+// WriteSpeedTestData writes size bytes of generated data to w without touching
+// any storage backend
+func WriteSpeedTestData(w io.Writer, size int64) (SpeedTestResult, error) {
+	startTime := time.Now()
+	var written int64
+	for written < size {
+		chunk := speedTestData
+		if remaining := size - written; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		n, err := w.Write(chunk)
+		written += int64(n)
+		if err != nil {
+			return NewSpeedTestResult(written, time.Since(startTime)), err
+		}
+	}
+	return NewSpeedTestResult(written, time.Since(startTime)), nil
+}
+
+// DiscardSpeedTestData reads and discards the data from r until EOF.
+// An error is returned if more than MaxSpeedTestSize bytes are received
+func DiscardSpeedTestData(r io.Reader) (SpeedTestResult, error) {
+	startTime := time.Now()
+	read, err := io.Copy(io.Discard, io.LimitReader(r, MaxSpeedTestSize+1))
+	result := NewSpeedTestResult(read, time.Since(startTime))
+	if err != nil {
+		return result, err
+	}
+	if read > MaxSpeedTestSize {
+		return result, util.NewValidationError(fmt.Sprintf("speed test size exceeded, the maximum allowed size is %d bytes",
+			MaxSpeedTestSize))
+	}
+	return result, nil
+}
@@ -0,0 +1,340 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	// max number of objects detailed in a storage transition report for each user,
+	// the totals always include all the affected objects
+	maxStorageTransitionReportEntries = 50000
+	storageTransitionStatusDryRun     = "dry run"
+	storageTransitionStatusOK         = "transitioned"
+	storageTransitionStatusFailed     = "failed"
+)
+
+var (
+	storageTransitions activeUserChecks
+	// indicative storage prices as USD per GB per month, based on the public list
+	// prices for the cheapest regions. They are only used to estimate savings
+	storageClassPrices = map[sdk.FilesystemProvider]map[string]float64{
+		sdk.S3FilesystemProvider: {
+			"STANDARD":            0.023,
+			"REDUCED_REDUNDANCY":  0.024,
+			"INTELLIGENT_TIERING": 0.023,
+			"STANDARD_IA":         0.0125,
+			"ONEZONE_IA":          0.01,
+			"GLACIER_IR":          0.004,
+			"GLACIER":             0.0036,
+			"DEEP_ARCHIVE":        0.00099,
+		},
+		sdk.GCSFilesystemProvider: {
+			"STANDARD":                     0.02,
+			"MULTI_REGIONAL":               0.026,
+			"REGIONAL":                     0.02,
+			"DURABLE_REDUCED_AVAILABILITY": 0.02,
+			"NEARLINE":                     0.01,
+			"COLDLINE":                     0.004,
+			"ARCHIVE":                      0.0012,
+		},
+		sdk.AzureBlobFilesystemProvider: {
+			"Hot":     0.0184,
+			"Cool":    0.01,
+			"Cold":    0.0036,
+			"Archive": 0.00099,
+		},
+	}
+)
+
+// getStorageTransitionSavings returns the estimated monthly savings, in USD, for moving
+// an object with the specified size between the specified storage classes.
+// It returns false if the target storage class is not cheaper than the current one.
+// Transitions between unknown storage classes are allowed with no estimated savings
+func getStorageTransitionSavings(provider sdk.FilesystemProvider, storageClass, targetStorageClass string,
+	size int64,
+) (float64, bool) {
+	prices := storageClassPrices[provider]
+	currentPrice, ok := prices[storageClass]
+	if !ok {
+		return 0, true
+	}
+	targetPrice, ok := prices[targetStorageClass]
+	if !ok {
+		return 0, true
+	}
+	if targetPrice >= currentPrice {
+		return 0, false
+	}
+	return float64(size) / (1024 * 1024 * 1024) * (currentPrice - targetPrice), true
+}
+
+// activeUserChecks tracks the users with a check in progress, it is used to avoid
+// concurrent executions of the same check type for a user
+type activeUserChecks struct {
+	sync.Mutex
+	usernames map[string]bool
+}
+
+// add returns false if a check is already in progress for the specified user
+func (t *activeUserChecks) add(username string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.usernames == nil {
+		t.usernames = make(map[string]bool)
+	}
+	if t.usernames[username] {
+		return false
+	}
+	t.usernames[username] = true
+	return true
+}
+
+func (t *activeUserChecks) remove(username string) {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.usernames, username)
+}
+
+type storageTransitionResult struct {
+	Path               string
+	Size               int64
+	ModTime            time.Time
+	StorageClass       string
+	TargetStorageClass string
+	EstimatedSavings   float64
+	Status             string
+	Error              string
+}
+
+type executedStorageTransition struct {
+	Username         string
+	ActionName       string
+	TotalFiles       int
+	TotalSize        int64
+	EstimatedSavings float64
+	Results          []storageTransitionResult
+}
+
+func (t *executedStorageTransition) getACopy() executedStorageTransition {
+	results := make([]storageTransitionResult, len(t.Results))
+	copy(results, t.Results)
+
+	return executedStorageTransition{
+		Username:         t.Username,
+		ActionName:       t.ActionName,
+		TotalFiles:       t.TotalFiles,
+		TotalSize:        t.TotalSize,
+		EstimatedSavings: t.EstimatedSavings,
+		Results:          results,
+	}
+}
+
+func (t *executedStorageTransition) addResult(result storageTransitionResult) {
+	t.TotalFiles++
+	t.TotalSize += result.Size
+	t.EstimatedSavings += result.EstimatedSavings
+	if len(t.Results) < maxStorageTransitionReportEntries {
+		t.Results = append(t.Results, result)
+	}
+}
+
+func (t *executedStorageTransition) getCSVReport() ([]byte, error) {
+	var b bytes.Buffer
+	csvWriter := csv.NewWriter(&b)
+	err := csvWriter.Write([]string{"path", "size (bytes)", "modification time", "storage class",
+		"target storage class", "estimated monthly savings (USD)", "status", "error"})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range t.Results {
+		err = csvWriter.Write([]string{result.Path, strconv.FormatInt(result.Size, 10),
+			result.ModTime.UTC().Format(time.RFC3339), result.StorageClass, result.TargetStorageClass,
+			strconv.FormatFloat(result.EstimatedSavings, 'f', 4, 64), result.Status, result.Error})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = csvWriter.Write([]string{"total", strconv.FormatInt(t.TotalSize, 10), "", "", "",
+		strconv.FormatFloat(t.EstimatedSavings, 'f', 4, 64), fmt.Sprintf("%d objects", t.TotalFiles), ""})
+	if err != nil {
+		return nil, err
+	}
+
+	csvWriter.Flush()
+	err = csvWriter.Error()
+	return b.Bytes(), err
+}
+
+type storageTransitionCheck struct {
+	conn      *BaseConnection
+	folders   []dataprovider.FolderStorageTransition
+	dryRun    bool
+	numErrors int
+	result    executedStorageTransition
+}
+
+func (c *storageTransitionCheck) isFolderConfigured(folderPath string) bool {
+	for _, folder := range c.folders {
+		if folder.Path == folderPath {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *storageTransitionCheck) getFolderTransition(folderPath string) (dataprovider.FolderStorageTransition, error) {
+	dirsForPath := util.GetDirsForVirtualPath(folderPath)
+	for _, dirPath := range dirsForPath {
+		for _, folder := range c.folders {
+			if folder.Path == dirPath {
+				return folder, nil
+			}
+		}
+	}
+
+	return dataprovider.FolderStorageTransition{}, fmt.Errorf("unable to find storage transition for %q", folderPath)
+}
+
+func (c *storageTransitionCheck) checkFile(folder dataprovider.FolderStorageTransition, virtualPath string,
+	modTime time.Time, size int64,
+) {
+	fs, fsPath, err := c.conn.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		c.conn.Log(logger.LevelWarn, "storage transition skipped for %q, unable to resolve path: %v", virtualPath, err)
+		return
+	}
+	transitioner, ok := fs.(vfs.FsStorageClassTransitioner)
+	if !ok {
+		return
+	}
+	provider := transitioner.GetStorageProvider()
+	targetStorageClass := folder.GetStorageClass(provider)
+	if targetStorageClass == "" {
+		return
+	}
+	result := storageTransitionResult{
+		Path:               virtualPath,
+		Size:               size,
+		ModTime:            modTime,
+		TargetStorageClass: targetStorageClass,
+	}
+	storageClass, err := transitioner.GetStorageClass(fsPath)
+	if err != nil {
+		c.numErrors++
+		result.Status = storageTransitionStatusFailed
+		result.Error = fmt.Sprintf("unable to get storage class: %v", err)
+		c.result.addResult(result)
+		return
+	}
+	if storageClass == targetStorageClass {
+		return
+	}
+	result.StorageClass = storageClass
+	savings, ok := getStorageTransitionSavings(provider, storageClass, targetStorageClass, size)
+	if !ok {
+		return
+	}
+	result.EstimatedSavings = savings
+	if c.dryRun {
+		result.Status = storageTransitionStatusDryRun
+		c.result.addResult(result)
+		return
+	}
+	if err := transitioner.SetStorageClass(fsPath, targetStorageClass); err != nil {
+		c.numErrors++
+		result.Status = storageTransitionStatusFailed
+		result.EstimatedSavings = 0
+		result.Error = err.Error()
+		c.conn.Log(logger.LevelError, "unable to move %q from storage class %q to %q: %v",
+			virtualPath, storageClass, targetStorageClass, err)
+	} else {
+		result.Status = storageTransitionStatusOK
+		c.conn.Log(logger.LevelDebug, "moved %q from storage class %q to %q, modification time: %v",
+			virtualPath, storageClass, targetStorageClass, modTime)
+	}
+	c.result.addResult(result)
+}
+
+func (c *storageTransitionCheck) checkFolder(folderPath string) error {
+	folder, err := c.getFolderTransition(folderPath)
+	if err != nil {
+		return err
+	}
+	if folder.Age == 0 {
+		return nil
+	}
+	files, err := c.conn.ListDir(folderPath)
+	if err != nil {
+		if err == c.conn.GetNotExistError() {
+			c.conn.Log(logger.LevelDebug, "folder %q does not exist, storage transition skipped", folderPath)
+			return nil
+		}
+		return fmt.Errorf("unable to list directory %q: %w", folderPath, err)
+	}
+	for _, info := range files {
+		virtualPath := path.Join(folderPath, info.Name())
+		if info.IsDir() {
+			// configured sub folders are checked using their own settings
+			if c.isFolderConfigured(virtualPath) {
+				continue
+			}
+			if err := c.checkFolder(virtualPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if info.ModTime().Add(time.Duration(folder.Age) * 24 * time.Hour).Before(time.Now()) {
+			c.checkFile(folder, virtualPath, info.ModTime(), info.Size())
+		}
+	}
+	return nil
+}
+
+func (c *storageTransitionCheck) start() error {
+	startTime := time.Now()
+	for _, folder := range c.folders {
+		if folder.Age > 0 {
+			if err := c.checkFolder(folder.Path); err != nil {
+				c.conn.Log(logger.LevelError, "storage transition failed for folder %q: %v", folder.Path, err)
+				return err
+			}
+		}
+	}
+	c.conn.Log(logger.LevelInfo, "storage transition completed, dry run? %t, objects: %d, size: %d, "+
+		"estimated monthly savings: %.4f USD, errors: %d, elapsed: %s", c.dryRun, c.result.TotalFiles,
+		c.result.TotalSize, c.result.EstimatedSavings, c.numErrors, time.Since(startTime))
+	if c.numErrors > 0 {
+		return fmt.Errorf("unable to transition %d objects", c.numErrors)
+	}
+	return nil
+}
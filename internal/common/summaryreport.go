@@ -0,0 +1,433 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/smtp"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// maxSummaryReportEntries defines the maximum number of users or IP addresses
+// tracked for each hour and statistic, additional entries are only counted in
+// the totals
+const maxSummaryReportEntries = 10000
+
+var summaryReports = newSummaryReportsTracker(dataprovider.MaxSummaryReportPeriod)
+
+type summaryReportTransfers struct {
+	uploadSize   int64
+	downloadSize int64
+	uploads      int64
+	downloads    int64
+}
+
+// summaryReportBucket holds the statistics collected within an hour
+type summaryReportBucket struct {
+	transfers    map[string]*summaryReportTransfers
+	failedLogins map[string]int64
+	bans         map[string]int64
+	// totals including the entries not tracked because of the limits
+	totalTransfers    summaryReportTransfers
+	totalFailedLogins int64
+	totalBans         int64
+}
+
+func newSummaryReportBucket() *summaryReportBucket {
+	return &summaryReportBucket{
+		transfers:    make(map[string]*summaryReportTransfers),
+		failedLogins: make(map[string]int64),
+		bans:         make(map[string]int64),
+	}
+}
+
+// summaryReportsTracker collects, in memory, the statistics used to generate
+// the summary reports. The statistics are grouped by hour and are lost on
+// restart
+type summaryReportsTracker struct {
+	maxHours int
+	mu       sync.Mutex
+	// hour, as unix timestamp truncated to the hour, -> bucket
+	buckets map[int64]*summaryReportBucket
+}
+
+func newSummaryReportsTracker(maxHours int) *summaryReportsTracker {
+	return &summaryReportsTracker{
+		maxHours: maxHours,
+		buckets:  make(map[int64]*summaryReportBucket),
+	}
+}
+
+// getBucket returns the bucket for the current hour, the lock must be held
+func (t *summaryReportsTracker) getBucket() *summaryReportBucket {
+	hour := time.Now().Truncate(time.Hour).Unix()
+	bucket, ok := t.buckets[hour]
+	if !ok {
+		t.removeExpired(hour)
+		bucket = newSummaryReportBucket()
+		t.buckets[hour] = bucket
+	}
+	return bucket
+}
+
+func (t *summaryReportsTracker) removeExpired(hour int64) {
+	limit := hour - int64(t.maxHours)*3600
+	for k := range t.buckets {
+		if k <= limit {
+			delete(t.buckets, k)
+		}
+	}
+}
+
+func (t *summaryReportsTracker) addTransfer(username string, uploadSize, downloadSize, uploads, downloads int64) {
+	if uploadSize == 0 && downloadSize == 0 && uploads == 0 && downloads == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bucket := t.getBucket()
+	bucket.totalTransfers.uploadSize += uploadSize
+	bucket.totalTransfers.downloadSize += downloadSize
+	bucket.totalTransfers.uploads += uploads
+	bucket.totalTransfers.downloads += downloads
+	stats, ok := bucket.transfers[username]
+	if !ok {
+		if len(bucket.transfers) >= maxSummaryReportEntries {
+			return
+		}
+		stats = &summaryReportTransfers{}
+		bucket.transfers[username] = stats
+	}
+	stats.uploadSize += uploadSize
+	stats.downloadSize += downloadSize
+	stats.uploads += uploads
+	stats.downloads += downloads
+}
+
+func (t *summaryReportsTracker) addFailedLogin(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bucket := t.getBucket()
+	bucket.totalFailedLogins++
+	if _, ok := bucket.failedLogins[ip]; ok || len(bucket.failedLogins) < maxSummaryReportEntries {
+		bucket.failedLogins[ip]++
+	}
+}
+
+func (t *summaryReportsTracker) addBan(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bucket := t.getBucket()
+	bucket.totalBans++
+	if _, ok := bucket.bans[ip]; ok || len(bucket.bans) < maxSummaryReportEntries {
+		bucket.bans[ip]++
+	}
+}
+
+// get returns the statistics collected after the specified time, aggregated
+// by user and IP address
+func (t *summaryReportsTracker) get(from time.Time) (summaryReportTransfers, map[string]*summaryReportTransfers,
+	int64, map[string]int64, int64, map[string]int64,
+) {
+	var totalTransfers summaryReportTransfers
+	var totalFailedLogins, totalBans int64
+	transfers := make(map[string]*summaryReportTransfers)
+	failedLogins := make(map[string]int64)
+	bans := make(map[string]int64)
+	start := from.Truncate(time.Hour).Unix()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for hour, bucket := range t.buckets {
+		if hour < start {
+			continue
+		}
+		totalTransfers.uploadSize += bucket.totalTransfers.uploadSize
+		totalTransfers.downloadSize += bucket.totalTransfers.downloadSize
+		totalTransfers.uploads += bucket.totalTransfers.uploads
+		totalTransfers.downloads += bucket.totalTransfers.downloads
+		totalFailedLogins += bucket.totalFailedLogins
+		totalBans += bucket.totalBans
+		for username, stats := range bucket.transfers {
+			s, ok := transfers[username]
+			if !ok {
+				s = &summaryReportTransfers{}
+				transfers[username] = s
+			}
+			s.uploadSize += stats.uploadSize
+			s.downloadSize += stats.downloadSize
+			s.uploads += stats.uploads
+			s.downloads += stats.downloads
+		}
+		for ip, count := range bucket.failedLogins {
+			failedLogins[ip] += count
+		}
+		for ip, count := range bucket.bans {
+			bans[ip] += count
+		}
+	}
+	return totalTransfers, transfers, totalFailedLogins, failedLogins, totalBans, bans
+}
+
+func (t *summaryReportsTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buckets = make(map[int64]*summaryReportBucket)
+}
+
+// SummaryReportTransfers defines the transfer volumes for a user
+type SummaryReportTransfers struct {
+	Username     string
+	UploadSize   string
+	DownloadSize string
+	Uploads      int64
+	Downloads    int64
+	totalSize    int64
+}
+
+// SummaryReportQuota defines the quota utilization for a user
+type SummaryReportQuota struct {
+	Username   string
+	UsedSize   string
+	QuotaSize  string
+	UsedFiles  int
+	QuotaFiles int
+	// Highest utilization percentage between size and files
+	Percentage int
+}
+
+// SummaryReportHost defines the number of failed logins or bans for an IP address
+type SummaryReportHost struct {
+	IP    string
+	Count int64
+}
+
+// SummaryReport defines the data available in the summary report templates
+type SummaryReport struct {
+	// Report period formatted using RFC 3339
+	From string
+	To   string
+	// Period in hours
+	Period            int
+	TotalUploadSize   string
+	TotalDownloadSize string
+	TotalUploads      int64
+	TotalDownloads    int64
+	Transfers         []SummaryReportTransfers
+	Quotas            []SummaryReportQuota
+	TotalFailedLogins int64
+	FailedLogins      []SummaryReportHost
+	TotalBans         int64
+	Bans              []SummaryReportHost
+}
+
+func getSummaryReportHosts(hosts map[string]int64, topCount int) []SummaryReportHost {
+	result := make([]SummaryReportHost, 0, len(hosts))
+	for ip, count := range hosts {
+		result = append(result, SummaryReportHost{
+			IP:    ip,
+			Count: count,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count == result[j].Count {
+			return result[i].IP < result[j].IP
+		}
+		return result[i].Count > result[j].Count
+	})
+	if len(result) > topCount {
+		result = result[:topCount]
+	}
+	return result
+}
+
+func getSummaryReportTransfers(transfers map[string]*summaryReportTransfers, topCount int,
+	isUserIncluded func(string) bool,
+) []SummaryReportTransfers {
+	result := make([]SummaryReportTransfers, 0, len(transfers))
+	for username, stats := range transfers {
+		if !isUserIncluded(username) {
+			continue
+		}
+		result = append(result, SummaryReportTransfers{
+			Username:     username,
+			UploadSize:   util.ByteCountIEC(stats.uploadSize),
+			DownloadSize: util.ByteCountIEC(stats.downloadSize),
+			Uploads:      stats.uploads,
+			Downloads:    stats.downloads,
+			totalSize:    stats.uploadSize + stats.downloadSize,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].totalSize == result[j].totalSize {
+			return result[i].Username < result[j].Username
+		}
+		return result[i].totalSize > result[j].totalSize
+	})
+	if len(result) > topCount {
+		result = result[:topCount]
+	}
+	return result
+}
+
+func getQuotaPercentage(used, quota int64) int {
+	if quota <= 0 {
+		return 0
+	}
+	return int(used * 100 / quota)
+}
+
+func getSummaryReportQuotas(users []dataprovider.User, topCount int) []SummaryReportQuota {
+	result := make([]SummaryReportQuota, 0, len(users))
+	for _, user := range users {
+		if user.QuotaSize <= 0 && user.QuotaFiles <= 0 {
+			continue
+		}
+		percentage := getQuotaPercentage(user.UsedQuotaSize, user.QuotaSize)
+		if filesPercentage := getQuotaPercentage(int64(user.UsedQuotaFiles), int64(user.QuotaFiles)); filesPercentage > percentage {
+			percentage = filesPercentage
+		}
+		quota := SummaryReportQuota{
+			Username:   user.Username,
+			UsedSize:   util.ByteCountIEC(user.UsedQuotaSize),
+			UsedFiles:  user.UsedQuotaFiles,
+			QuotaFiles: user.QuotaFiles,
+			Percentage: percentage,
+		}
+		if user.QuotaSize > 0 {
+			quota.QuotaSize = util.ByteCountIEC(user.QuotaSize)
+		}
+		result = append(result, quota)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Percentage == result[j].Percentage {
+			return result[i].Username < result[j].Username
+		}
+		return result[i].Percentage > result[j].Percentage
+	})
+	if len(result) > topCount {
+		result = result[:topCount]
+	}
+	return result
+}
+
+func getSummaryReport(c dataprovider.EventActionSummaryReportConfig, conditions dataprovider.ConditionOptions,
+) (SummaryReport, error) {
+	users, err := dataprovider.DumpUsers()
+	if err != nil {
+		eventManagerLog(logger.LevelError, "unable to get users: %+v", err)
+		return SummaryReport{}, errors.New("unable to get users")
+	}
+	hasUserConditions := len(conditions.Names) > 0 || len(conditions.GroupNames) > 0
+	includedUsers := make(map[string]bool)
+	filteredUsers := make([]dataprovider.User, 0, len(users))
+	for _, user := range users {
+		if !checkEventConditionPatterns(user.Username, conditions.Names) {
+			continue
+		}
+		if !checkEventGroupConditionPatters(user.Groups, conditions.GroupNames) {
+			continue
+		}
+		includedUsers[user.Username] = true
+		filteredUsers = append(filteredUsers, user)
+	}
+	isUserIncluded := func(username string) bool {
+		if !hasUserConditions {
+			return true
+		}
+		return includedUsers[username]
+	}
+
+	to := time.Now()
+	from := to.Add(-time.Duration(c.Period) * time.Hour)
+	totalTransfers, transfers, totalFailedLogins, failedLogins, totalBans, bans := summaryReports.get(from)
+
+	return SummaryReport{
+		From:              from.UTC().Format(time.RFC3339),
+		To:                to.UTC().Format(time.RFC3339),
+		Period:            c.Period,
+		TotalUploadSize:   util.ByteCountIEC(totalTransfers.uploadSize),
+		TotalDownloadSize: util.ByteCountIEC(totalTransfers.downloadSize),
+		TotalUploads:      totalTransfers.uploads,
+		TotalDownloads:    totalTransfers.downloads,
+		Transfers:         getSummaryReportTransfers(transfers, c.TopCount, isUserIncluded),
+		Quotas:            getSummaryReportQuotas(filteredUsers, c.TopCount),
+		TotalFailedLogins: totalFailedLogins,
+		FailedLogins:      getSummaryReportHosts(failedLogins, c.TopCount),
+		TotalBans:         totalBans,
+		Bans:              getSummaryReportHosts(bans, c.TopCount),
+	}, nil
+}
+
+// getSummaryReportRecipients returns the configured recipients and the email
+// addresses of the configured admins. Admins without an email are skipped
+func getSummaryReportRecipients(c dataprovider.EventActionSummaryReportConfig) ([]string, error) {
+	recipients := make([]string, 0, len(c.Recipients)+len(c.Admins))
+	recipients = append(recipients, c.Recipients...)
+	for _, username := range c.Admins {
+		admin, err := dataprovider.AdminExists(username)
+		if err != nil {
+			eventManagerLog(logger.LevelWarn, "unable to get admin %q for summary report: %v", username, err)
+			continue
+		}
+		if admin.Email == "" {
+			eventManagerLog(logger.LevelWarn, "admin %q has no email, summary report not sent", username)
+			continue
+		}
+		recipients = append(recipients, admin.Email)
+	}
+	recipients = util.RemoveDuplicates(recipients, false)
+	if len(recipients) == 0 {
+		return nil, errors.New("no summary report recipient available")
+	}
+	return recipients, nil
+}
+
+func executeSummaryReportRuleAction(c dataprovider.EventActionSummaryReportConfig,
+	conditions dataprovider.ConditionOptions,
+) error {
+	recipients, err := getSummaryReportRecipients(c)
+	if err != nil {
+		return err
+	}
+	report, err := getSummaryReport(c, conditions)
+	if err != nil {
+		return err
+	}
+	body, err := smtp.RenderSummaryReportBody(c.Language, report)
+	if err != nil {
+		return fmt.Errorf("unable to render summary report: %w", err)
+	}
+	startTime := time.Now()
+	err = smtp.SendEmailBody(smtp.EmailAddresses{To: recipients}, c.Subject, body)
+	eventManagerLog(logger.LevelDebug, "executed summary report action, recipients: %+v, elapsed: %s, error: %v",
+		recipients, time.Since(startTime), err)
+	if err != nil {
+		return fmt.Errorf("unable to send summary report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package common
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+)
+
+func TestSummaryReportsTracker(t *testing.T) {
+	tracker := newSummaryReportsTracker(2)
+	currentHour := time.Now().Truncate(time.Hour)
+	// add expired and valid buckets for the previous hours
+	expired := newSummaryReportBucket()
+	expired.totalBans = 10
+	expired.bans["10.0.0.1"] = 10
+	tracker.buckets[currentHour.Add(-3*time.Hour).Unix()] = expired
+	previous := newSummaryReportBucket()
+	previous.totalFailedLogins = 2
+	previous.failedLogins["10.0.0.2"] = 2
+	tracker.buckets[currentHour.Add(-time.Hour).Unix()] = previous
+
+	tracker.addTransfer("user1", 100, 0, 1, 0)
+	tracker.addTransfer("user1", 0, 50, 0, 1)
+	tracker.addTransfer("user2", 0, 0, 0, 0)
+	tracker.addFailedLogin("10.0.0.2")
+	tracker.addFailedLogin("10.0.0.3")
+	tracker.addBan("10.0.0.3")
+	require.Len(t, tracker.buckets, 2)
+
+	totalTransfers, transfers, totalFailedLogins, failedLogins, totalBans, bans := tracker.get(
+		time.Now().Add(-2 * time.Hour))
+	assert.Equal(t, int64(100), totalTransfers.uploadSize)
+	assert.Equal(t, int64(50), totalTransfers.downloadSize)
+	assert.Equal(t, int64(1), totalTransfers.uploads)
+	assert.Equal(t, int64(1), totalTransfers.downloads)
+	require.Len(t, transfers, 1)
+	assert.Equal(t, int64(100), transfers["user1"].uploadSize)
+	assert.Equal(t, int64(4), totalFailedLogins)
+	assert.Equal(t, int64(3), failedLogins["10.0.0.2"])
+	assert.Equal(t, int64(1), failedLogins["10.0.0.3"])
+	assert.Equal(t, int64(1), totalBans)
+	assert.Len(t, bans, 1)
+	// the previous hour is excluded
+	_, _, totalFailedLogins, _, _, _ = tracker.get(time.Now()) //nolint:dogsled
+	assert.Equal(t, int64(2), totalFailedLogins)
+	// entries exceeding the limit are only counted in the totals
+	bucket := tracker.buckets[currentHour.Unix()]
+	require.NotNil(t, bucket)
+	for i := 0; i < maxSummaryReportEntries; i++ {
+		bucket.bans[fmt.Sprintf("192.168.%d.%d", i/256, i%256)] = 1
+	}
+	tracker.addBan("10.0.0.4")
+	assert.Len(t, bucket.bans, maxSummaryReportEntries+1)
+	assert.NotContains(t, bucket.bans, "10.0.0.4")
+	assert.Equal(t, int64(2), bucket.totalBans)
+	tracker.addBan("10.0.0.3")
+	assert.Equal(t, int64(2), bucket.bans["10.0.0.3"])
+	tracker.reset()
+	assert.Len(t, tracker.buckets, 0)
+}
+
+func TestSummaryReportSections(t *testing.T) {
+	hosts := getSummaryReportHosts(map[string]int64{"10.0.0.1": 1, "10.0.0.2": 5, "10.0.0.3": 5}, 2)
+	require.Len(t, hosts, 2)
+	assert.Equal(t, "10.0.0.2", hosts[0].IP)
+	assert.Equal(t, "10.0.0.3", hosts[1].IP)
+
+	transfers := getSummaryReportTransfers(map[string]*summaryReportTransfers{
+		"user1": {uploadSize: 10},
+		"user2": {uploadSize: 1024, downloadSize: 1024, uploads: 1, downloads: 2},
+		"user3": {downloadSize: 100},
+	}, 5, func(username string) bool {
+		return username != "user3"
+	})
+	require.Len(t, transfers, 2)
+	assert.Equal(t, "user2", transfers[0].Username)
+	assert.Equal(t, "1.0 KiB", transfers[0].UploadSize)
+	assert.Equal(t, int64(2), transfers[0].Downloads)
+	assert.Equal(t, "user1", transfers[1].Username)
+
+	quotas := getSummaryReportQuotas([]dataprovider.User{
+		{
+			BaseUser: sdk.BaseUser{
+				Username: "user1",
+			},
+		},
+		{
+			BaseUser: sdk.BaseUser{
+				Username:       "user2",
+				QuotaSize:      1000,
+				UsedQuotaSize:  500,
+				QuotaFiles:     10,
+				UsedQuotaFiles: 9,
+			},
+		},
+		{
+			BaseUser: sdk.BaseUser{
+				Username:      "user3",
+				QuotaSize:     1000,
+				UsedQuotaSize: 950,
+			},
+		},
+		{
+			BaseUser: sdk.BaseUser{
+				Username:       "user4",
+				QuotaFiles:     10,
+				UsedQuotaFiles: 1,
+			},
+		},
+	}, 2)
+	require.Len(t, quotas, 2)
+	assert.Equal(t, "user3", quotas[0].Username)
+	assert.Equal(t, 95, quotas[0].Percentage)
+	assert.Equal(t, "user2", quotas[1].Username)
+	assert.Equal(t, 90, quotas[1].Percentage)
+}
+
+func TestSummaryReportRuleAction(t *testing.T) {
+	username := "summary_report_user"
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			Password: "pwd",
+			HomeDir:  "/tmp",
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+			QuotaFiles:     100,
+			UsedQuotaFiles: 0,
+		},
+	}
+	err := dataprovider.AddUser(&user, "", "")
+	require.NoError(t, err)
+
+	summaryReports.addTransfer(username, 1024, 0, 1, 0)
+	summaryReports.addTransfer("other_user", 2048, 0, 1, 0)
+	config := dataprovider.EventActionSummaryReportConfig{
+		Period:   1,
+		TopCount: 10,
+	}
+	report, err := getSummaryReport(config, dataprovider.ConditionOptions{
+		Names: []dataprovider.ConditionPattern{
+			{
+				Pattern: username,
+			},
+		},
+	})
+	assert.NoError(t, err)
+	require.Len(t, report.Transfers, 1)
+	assert.Equal(t, username, report.Transfers[0].Username)
+	require.Len(t, report.Quotas, 1)
+	assert.Equal(t, username, report.Quotas[0].Username)
+	assert.Equal(t, 1, report.Period)
+	assert.NotEmpty(t, report.From)
+
+	_, err = getSummaryReportRecipients(dataprovider.EventActionSummaryReportConfig{
+		Admins: []string{"missing admin"},
+	})
+	assert.ErrorContains(t, err, "no summary report recipient available")
+	recipients, err := getSummaryReportRecipients(dataprovider.EventActionSummaryReportConfig{
+		Recipients: []string{"report@example.com"},
+		Admins:     []string{"missing admin"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"report@example.com"}, recipients)
+	err = executeSummaryReportRuleAction(dataprovider.EventActionSummaryReportConfig{
+		Admins: []string{"missing admin"},
+	}, dataprovider.ConditionOptions{})
+	assert.Error(t, err)
+	// SMTP is not configured
+	err = executeSummaryReportRuleAction(dataprovider.EventActionSummaryReportConfig{
+		Recipients: []string{"report@example.com"},
+		Subject:    "report",
+		Period:     24,
+		TopCount:   10,
+	}, dataprovider.ConditionOptions{})
+	assert.Error(t, err)
+
+	err = dataprovider.DeleteUser(username, "", "")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,211 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/jobs"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	tempFilesCleanupJobName = "temp_files_cleanup"
+	// label used for the files within the configured "temp_path"
+	tempFilesCleanupTempPath = "temp_path"
+	tempFilesCleanupPageSize = 100
+)
+
+// TempFilesCleanupConfig defines the configuration for the periodic removal of the
+// stale temporary files, for example the leftovers of interrupted atomic uploads
+type TempFilesCleanupConfig struct {
+	// Interval, as minutes, between two cleanups. 0 means disabled
+	Interval int `json:"interval" mapstructure:"interval"`
+	// Temporary files older than this age, as minutes, are removed
+	MaxAge int `json:"max_age" mapstructure:"max_age"`
+	// If enabled the users and virtual folders storages are scanned too, otherwise
+	// only the configured "temp_path" is checked. Scanning the storages can be
+	// expensive: the local and SFTP filesystems are walked recursively
+	ScanStorages bool `json:"scan_storages" mapstructure:"scan_storages"`
+}
+
+func (c *TempFilesCleanupConfig) isEnabled() bool {
+	return c.Interval > 0
+}
+
+func (c *TempFilesCleanupConfig) validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("invalid temporary files cleanup interval: %d", c.Interval)
+	}
+	if !c.isEnabled() {
+		return nil
+	}
+	if c.MaxAge <= 0 {
+		return errors.New("temporary files cleanup max age must be greater than 0")
+	}
+	return nil
+}
+
+func (c *TempFilesCleanupConfig) getMaxAge() time.Duration {
+	return time.Duration(c.MaxAge) * time.Minute
+}
+
+// tempFilesCleanupReport holds the removed files and the errors for each backend,
+// the map keys are the backend names
+type tempFilesCleanupReport struct {
+	results map[string]vfs.TempFilesCleanupResult
+	errors  int
+}
+
+func (r *tempFilesCleanupReport) add(backend string, result vfs.TempFilesCleanupResult, err error) {
+	if result.Files > 0 {
+		res := r.results[backend]
+		res.Add(result)
+		r.results[backend] = res
+		metric.AddTempFilesCleanup(backend, result.Files, result.Size)
+	}
+	if err != nil {
+		r.errors++
+		metric.AddTempFilesCleanupError(backend)
+	}
+}
+
+func (r *tempFilesCleanupReport) getTotal() vfs.TempFilesCleanupResult {
+	var total vfs.TempFilesCleanupResult
+	for _, res := range r.results {
+		total.Add(res)
+	}
+	return total
+}
+
+// cleanup removes the stale temporary files and returns an error if some
+// backends cannot be cleaned up
+func (c *TempFilesCleanupConfig) cleanup() error {
+	startTime := time.Now()
+	report := &tempFilesCleanupReport{
+		results: make(map[string]vfs.TempFilesCleanupResult),
+	}
+	maxAge := c.getMaxAge()
+	if tempPath := vfs.GetTempPath(); tempPath != "" {
+		result, err := vfs.RemoveStaleLocalTempFiles(tempPath, maxAge)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Warn(logSender, "", "unable to cleanup the temporary files in %q: %v", tempPath, err)
+			report.add(tempFilesCleanupTempPath, result, err)
+		} else {
+			report.add(tempFilesCleanupTempPath, result, nil)
+		}
+	}
+	if c.ScanStorages {
+		if err := c.cleanupStorages(report); err != nil {
+			logger.Warn(logSender, "", "unable to cleanup the temporary files in the users storages: %v", err)
+			return err
+		}
+	}
+	total := report.getTotal()
+	logger.Info(logSender, "", "temporary files cleanup completed, removed files: %d, size: %d, per backend: %+v, "+
+		"errors: %d, elapsed: %s", total.Files, total.Size, report.results, report.errors, time.Since(startTime))
+	if report.errors > 0 {
+		return fmt.Errorf("unable to cleanup the temporary files for %d storages", report.errors)
+	}
+	return nil
+}
+
+func (c *TempFilesCleanupConfig) cleanupStorages(report *tempFilesCleanupReport) error {
+	scannedFolders := make(map[string]bool)
+	for offset := 0; ; offset += tempFilesCleanupPageSize {
+		users, err := dataprovider.GetUsers(tempFilesCleanupPageSize, offset, dataprovider.OrderASC)
+		if err != nil {
+			return err
+		}
+		for idx := range users {
+			// the listed users have the secrets hidden and no group settings applied
+			user, err := dataprovider.GetUserWithGroupSettings(users[idx].Username)
+			if err != nil {
+				logger.Warn(logSender, "", "unable to get user %q for temporary files cleanup: %v",
+					users[idx].Username, err)
+				continue
+			}
+			c.cleanupUserStorages(&user, scannedFolders, report)
+		}
+		if len(users) < tempFilesCleanupPageSize {
+			return nil
+		}
+	}
+}
+
+func (c *TempFilesCleanupConfig) cleanupUserStorages(user *dataprovider.User, scannedFolders map[string]bool,
+	report *tempFilesCleanupReport,
+) {
+	connectionID := fmt.Sprintf("%s_%s", tempFilesCleanupJobName, xid.New().String())
+	defer user.CloseFs() //nolint:errcheck
+
+	c.cleanupFs(user, "/", user.FsConfig.Provider, connectionID, report)
+	for idx := range user.VirtualFolders {
+		folder := &user.VirtualFolders[idx]
+		if scannedFolders[folder.Name] {
+			continue
+		}
+		scannedFolders[folder.Name] = true
+		c.cleanupFs(user, folder.VirtualPath, folder.FsConfig.Provider, connectionID, report)
+	}
+}
+
+func (c *TempFilesCleanupConfig) cleanupFs(user *dataprovider.User, virtualPath string,
+	provider sdk.FilesystemProvider, connectionID string, report *tempFilesCleanupReport,
+) {
+	fs, err := user.GetFilesystemForPath(virtualPath, connectionID)
+	if err != nil {
+		logger.Warn(logSender, connectionID, "unable to get the filesystem for user %q, path %q: %v",
+			user.Username, virtualPath, err)
+		report.add(provider.Name(), vfs.TempFilesCleanupResult{}, err)
+		return
+	}
+	cleaner, ok := fs.(vfs.TempFilesCleaner)
+	if !ok {
+		return
+	}
+	result, err := cleaner.RemoveStaleTempFiles(c.getMaxAge())
+	if err != nil {
+		logger.Warn(logSender, connectionID, "unable to cleanup the temporary files for user %q, path %q: %v",
+			user.Username, virtualPath, err)
+	} else if result.Files > 0 {
+		logger.Debug(logSender, connectionID, "removed %d temporary files, size %s, for user %q, path %q",
+			result.Files, util.ByteCountIEC(result.Size), user.Username, virtualPath)
+	}
+	report.add(provider.Name(), result, err)
+}
+
+func startTempFilesCleanup(c TempFilesCleanupConfig) error {
+	if !c.isEnabled() {
+		return nil
+	}
+	spec := fmt.Sprintf("@every %dm", c.Interval)
+	err := jobs.Add(eventScheduler, tempFilesCleanupJobName, spec, c.cleanup)
+	if err != nil {
+		return err
+	}
+	logger.Info(logSender, "", "scheduled temporary files cleanup, schedule %q, config: %+v", spec, c)
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func TestTempFilesCleanupConfig(t *testing.T) {
+	c := TempFilesCleanupConfig{}
+	assert.NoError(t, c.validate())
+	c.Interval = -1
+	assert.Error(t, c.validate())
+	c.Interval = 10
+	assert.Error(t, c.validate())
+	c.MaxAge = 60
+	assert.NoError(t, c.validate())
+	assert.Equal(t, time.Hour, c.getMaxAge())
+}
+
+func TestRemoveStaleLocalTempFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	oldTime := time.Now().Add(-2 * time.Hour)
+	staleFiles := []string{".sftpgo-upload.cdi6on3c1e8cn6o0k4sg.file", "pipefile123456"}
+	recentFiles := []string{".sftpgo-upload.cdi6on3c1e8cn6o0k4sh.file", "pipefile654321"}
+	otherFiles := []string{"file.txt"}
+	for _, name := range append(append(staleFiles, recentFiles...), otherFiles...) {
+		err := os.WriteFile(filepath.Join(tempDir, name), []byte("data"), 0666)
+		require.NoError(t, err)
+	}
+	for _, name := range append(staleFiles, otherFiles...) {
+		err := os.Chtimes(filepath.Join(tempDir, name), oldTime, oldTime)
+		require.NoError(t, err)
+	}
+	err := os.Mkdir(filepath.Join(tempDir, ".sftpgo-upload.dir"), 0755)
+	require.NoError(t, err)
+
+	result, err := vfs.RemoveStaleLocalTempFiles(tempDir, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Files)
+	assert.Equal(t, int64(8), result.Size)
+	for _, name := range staleFiles {
+		assert.NoFileExists(t, filepath.Join(tempDir, name))
+	}
+	for _, name := range append(recentFiles, otherFiles...) {
+		assert.FileExists(t, filepath.Join(tempDir, name))
+	}
+	assert.DirExists(t, filepath.Join(tempDir, ".sftpgo-upload.dir"))
+
+	_, err = vfs.RemoveStaleLocalTempFiles(filepath.Join(tempDir, "missing"), time.Hour)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	oldTempPath := vfs.GetTempPath()
+	vfs.SetTempPath(filepath.Join(tempDir, "missing"))
+	defer vfs.SetTempPath(oldTempPath)
+
+	c := TempFilesCleanupConfig{
+		Interval: 10,
+		MaxAge:   60,
+	}
+	assert.NoError(t, c.cleanup())
+
+	report := &tempFilesCleanupReport{
+		results: make(map[string]vfs.TempFilesCleanupResult),
+	}
+	report.add("osfs", vfs.TempFilesCleanupResult{Files: 1, Size: 10}, nil)
+	report.add("osfs", vfs.TempFilesCleanupResult{Files: 2, Size: 20}, os.ErrPermission)
+	report.add("s3fs", vfs.TempFilesCleanupResult{Files: 3}, nil)
+	assert.Equal(t, 1, report.errors)
+	assert.Equal(t, vfs.TempFilesCleanupResult{Files: 3, Size: 30}, report.results["osfs"])
+	assert.Equal(t, vfs.TempFilesCleanupResult{Files: 6, Size: 30}, report.getTotal())
+}
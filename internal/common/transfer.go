@@ -16,6 +16,7 @@ package common
 
 import (
 	"errors"
+	"fmt"
 	"path"
 	"sync"
 	"sync/atomic"
@@ -24,6 +25,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
@@ -56,6 +58,8 @@ type BaseTransfer struct { //nolint:maligned
 	aTime           time.Time
 	mTime           time.Time
 	transferQuota   dataprovider.TransferQuota
+	throttle        transferThrottle
+	span            *tracing.Span
 	sync.Mutex
 	errAbort    error
 	ErrTransfer error
@@ -87,11 +91,27 @@ func NewBaseTransfer(file vfs.File, conn *BaseConnection, cancelFn func(), fsPat
 	t.AbortTransfer.Store(false)
 	t.BytesSent.Store(0)
 	t.BytesReceived.Store(0)
+	t.startSpan()
 
 	conn.AddTransfer(t)
 	return t
 }
 
+func (t *BaseTransfer) startSpan() {
+	operation := OperationUpload
+	if t.transferType == TransferDownload {
+		operation = operationDownload
+	}
+	_, t.span = tracing.Start(t.Connection.GetTraceContext(), fmt.Sprintf("transfer %s", operation), tracing.SpanKindInternal)
+	t.span.SetAttribute("sftpgo.operation", operation)
+	t.span.SetAttribute("sftpgo.path", t.requestPath)
+	t.span.SetAttribute("sftpgo.protocol", t.Connection.protocol)
+	t.span.SetAttribute("enduser.id", t.Connection.User.Username)
+	if t.Fs != nil {
+		t.span.SetAttribute("sftpgo.vfs", t.Fs.Name())
+	}
+}
+
 // GetTransferQuota returns data transfer quota limits
 func (t *BaseTransfer) GetTransferQuota() dataprovider.TransferQuota {
 	return t.transferQuota
@@ -263,6 +283,8 @@ func (t *BaseTransfer) Truncate(fsPath string, size int64) (int64, error) {
 					t.MaxWriteSize += sizeDiff
 					metric.TransferCompleted(t.BytesSent.Load(), t.BytesReceived.Load(),
 						t.transferType, t.ErrTransfer, vfs.IsSFTPFs(t.Fs))
+					// the received bytes are reset below, the sent ones are recorded on close
+					summaryReports.addTransfer(t.Connection.User.Username, t.BytesReceived.Load(), 0, 0, 0)
 					if t.transferQuota.HasSizeLimits() {
 						go func(ulSize, dlSize int64, user dataprovider.User) {
 							dataprovider.UpdateUserTransferQuota(&user, ulSize, dlSize, false) //nolint:errcheck
@@ -314,10 +336,10 @@ func (t *BaseTransfer) getUploadFileSize() (int64, int, error) {
 	if err == nil {
 		fileSize = info.Size()
 	}
-	if t.ErrTransfer != nil && vfs.IsCryptOsFs(t.Fs) {
+	if t.ErrTransfer != nil && (vfs.IsCryptOsFs(t.Fs) || vfs.IsCompressedOsFs(t.Fs) || vfs.IsDedupFs(t.Fs)) {
 		errDelete := t.Fs.Remove(t.fsPath, false)
 		if errDelete != nil {
-			t.Connection.Log(logger.LevelWarn, "error removing partial crypto file %#v: %v", t.fsPath, errDelete)
+			t.Connection.Log(logger.LevelWarn, "error removing partial crypto/compressed/dedup file %#v: %v", t.fsPath, errDelete)
 		} else {
 			fileSize = 0
 			deletedFiles = 1
@@ -345,6 +367,34 @@ func (t *BaseTransfer) checkUploadOutsideHomeDir(err error) int {
 	return 1
 }
 
+func (t *BaseTransfer) updateSummaryReport() {
+	var uploads, downloads int64
+	if t.ErrTransfer == nil {
+		if t.transferType == TransferUpload {
+			uploads = 1
+		} else {
+			downloads = 1
+		}
+	}
+	summaryReports.addTransfer(t.Connection.User.Username, t.BytesReceived.Load(), t.BytesSent.Load(),
+		uploads, downloads)
+}
+
+func (t *BaseTransfer) updateUserMetrics() {
+	if t.ErrTransfer == nil {
+		bytes := t.BytesReceived.Load()
+		if t.transferType == TransferDownload {
+			bytes = t.BytesSent.Load()
+		}
+		metric.AddTransferThroughput(t.Connection.protocol, t.transferType, bytes, time.Since(t.start))
+	}
+	if metric.IsUserMetricsEnabled() {
+		metric.UserTransferCompleted(t.Connection.User.Username, t.Connection.protocol,
+			t.Connection.getFolderNameForPath(t.requestPath), t.BytesSent.Load(), t.BytesReceived.Load(),
+			t.transferType, t.ErrTransfer)
+	}
+}
+
 // Close it is called when the transfer is completed.
 // It logs the transfer info, updates the user quota (for uploads)
 // and executes any defined action.
@@ -357,6 +407,8 @@ func (t *BaseTransfer) Close() error {
 	numFiles := t.getUploadedFiles()
 	metric.TransferCompleted(t.BytesSent.Load(), t.BytesReceived.Load(),
 		t.transferType, t.ErrTransfer, vfs.IsSFTPFs(t.Fs))
+	t.updateUserMetrics()
+	t.updateSummaryReport()
 	if t.transferQuota.HasSizeLimits() {
 		dataprovider.UpdateUserTransferQuota(&t.Connection.User, t.BytesReceived.Load(), //nolint:errcheck
 			t.BytesSent.Load(), false)
@@ -391,7 +443,8 @@ func (t *BaseTransfer) Close() error {
 	var uploadFileSize int64
 	if t.transferType == TransferDownload {
 		logger.TransferLog(downloadLogSender, t.fsPath, elapsed, t.BytesSent.Load(), t.Connection.User.Username,
-			t.Connection.ID, t.Connection.protocol, t.Connection.localAddr, t.Connection.remoteAddr, t.ftpMode)
+			t.Connection.ID, t.Connection.protocol, t.Connection.localAddr, t.Connection.remoteAddr, t.ftpMode,
+			t.Connection.impersonator)
 		ExecuteActionNotification(t.Connection, operationDownload, t.fsPath, t.requestPath, "", "", "", //nolint:errcheck
 			t.BytesSent.Load(), t.ErrTransfer)
 	} else {
@@ -408,11 +461,13 @@ func (t *BaseTransfer) Close() error {
 		numFiles -= deletedFiles
 		t.Connection.Log(logger.LevelDebug, "upload file size %d, num files %d, deleted files %d, fs path %q",
 			uploadFileSize, numFiles, deletedFiles, t.fsPath)
+		numFiles, uploadFileSize = t.scanUpload(numFiles, uploadFileSize)
 		numFiles, uploadFileSize = t.executeUploadHook(numFiles, uploadFileSize)
 		t.updateQuota(numFiles, uploadFileSize)
 		t.updateTimes()
 		logger.TransferLog(uploadLogSender, t.fsPath, elapsed, t.BytesReceived.Load(), t.Connection.User.Username,
-			t.Connection.ID, t.Connection.protocol, t.Connection.localAddr, t.Connection.remoteAddr, t.ftpMode)
+			t.Connection.ID, t.Connection.protocol, t.Connection.localAddr, t.Connection.remoteAddr, t.ftpMode,
+			t.Connection.impersonator)
 	}
 	if t.ErrTransfer != nil {
 		t.Connection.Log(logger.LevelError, "transfer error: %v, path: %#v", t.ErrTransfer, t.fsPath)
@@ -421,6 +476,9 @@ func (t *BaseTransfer) Close() error {
 		}
 	}
 	t.updateTransferTimestamps(uploadFileSize)
+	t.span.SetAttribute("sftpgo.bytes_sent", t.BytesSent.Load())
+	t.span.SetAttribute("sftpgo.bytes_received", t.BytesReceived.Load())
+	t.span.EndWithError(err)
 	return err
 }
 
@@ -448,22 +506,28 @@ func (t *BaseTransfer) updateTransferTimestamps(uploadFileSize int64) {
 }
 
 func (t *BaseTransfer) executeUploadHook(numFiles int, fileSize int64) (int, int64) {
-	err := ExecuteActionNotification(t.Connection, operationUpload, t.fsPath, t.requestPath, "", "", "",
+	err := ExecuteActionNotification(t.Connection, OperationUpload, t.fsPath, t.requestPath, "", "", "",
 		fileSize, t.ErrTransfer)
 	if err != nil {
 		if t.ErrTransfer == nil {
 			t.ErrTransfer = err
 		}
-		// try to remove the uploaded file
-		err = t.Fs.Remove(t.fsPath, false)
-		if err == nil {
-			numFiles--
-			fileSize = 0
-			t.BytesReceived.Store(0)
-			t.MinWriteOffset = 0
-		} else {
-			t.Connection.Log(logger.LevelWarn, "unable to remove path %q after upload hook failure: %v", t.fsPath, err)
-		}
+		numFiles, fileSize = t.removeUploadedFile(numFiles, fileSize, "upload hook failure")
+	}
+	return numFiles, fileSize
+}
+
+// removeUploadedFile tries to remove the uploaded file and returns the updated
+// number of files and file size
+func (t *BaseTransfer) removeUploadedFile(numFiles int, fileSize int64, reason string) (int, int64) {
+	err := t.Fs.Remove(t.fsPath, false)
+	if err == nil {
+		numFiles--
+		fileSize = 0
+		t.BytesReceived.Store(0)
+		t.MinWriteOffset = 0
+	} else {
+		t.Connection.Log(logger.LevelWarn, "unable to remove path %q after %s: %v", t.fsPath, reason, err)
 	}
 	return numFiles, fileSize
 }
@@ -493,8 +557,8 @@ func (t *BaseTransfer) updateQuota(numFiles int, fileSize int64) bool {
 	if t.transferType == TransferUpload && (numFiles != 0 || sizeDiff != 0) {
 		vfolder, err := t.Connection.User.GetVirtualFolderForPath(path.Dir(t.requestPath))
 		if err == nil {
-			dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, numFiles, //nolint:errcheck
-				sizeDiff, false)
+			dataprovider.UpdateVirtualFolderQuotaForUser(&vfolder, t.Connection.User.Username, //nolint:errcheck
+				numFiles, sizeDiff)
 			if vfolder.IsIncludedInUserQuota() {
 				dataprovider.UpdateUserQuota(&t.Connection.User, numFiles, sizeDiff, false) //nolint:errcheck
 			}
@@ -506,22 +570,55 @@ func (t *BaseTransfer) updateQuota(numFiles int, fileSize int64) bool {
 	return false
 }
 
+// transferThrottle tracks the bandwidth applied to a transfer. The bandwidth
+// is evaluated again each minute so bandwidth schedules are applied to
+// running transfers too
+type transferThrottle struct {
+	sync.Mutex
+	bandwidth int64
+	checkedAt time.Time
+	start     time.Time
+	bytes     int64
+}
+
+func (t *BaseTransfer) getThrottleParams(transferredBytes int64) (int64, time.Time, int64) {
+	t.throttle.Lock()
+	defer t.throttle.Unlock()
+
+	now := time.Now()
+	minute := now.Truncate(time.Minute)
+	if !minute.Equal(t.throttle.checkedAt) {
+		ul, dl := t.Connection.GetBandwidth(now)
+		bandwidth := ul
+		if t.transferType == TransferDownload {
+			bandwidth = dl
+		}
+		if t.throttle.checkedAt.IsZero() {
+			t.throttle.start = t.start
+		} else if bandwidth != t.throttle.bandwidth {
+			t.throttle.start = now
+			t.throttle.bytes = transferredBytes
+		}
+		t.throttle.bandwidth = bandwidth
+		t.throttle.checkedAt = minute
+	}
+	return t.throttle.bandwidth, t.throttle.start, t.throttle.bytes
+}
+
 // HandleThrottle manage bandwidth throttling
 func (t *BaseTransfer) HandleThrottle() {
-	var wantedBandwidth int64
 	var trasferredBytes int64
 	if t.transferType == TransferDownload {
-		wantedBandwidth = t.Connection.User.DownloadBandwidth
 		trasferredBytes = t.BytesSent.Load()
 	} else {
-		wantedBandwidth = t.Connection.User.UploadBandwidth
 		trasferredBytes = t.BytesReceived.Load()
 	}
+	wantedBandwidth, start, startBytes := t.getThrottleParams(trasferredBytes)
 	if wantedBandwidth > 0 {
 		// real and wanted elapsed as milliseconds, bytes as kilobytes
-		realElapsed := time.Since(t.start).Nanoseconds() / 1000000
+		realElapsed := time.Since(start).Nanoseconds() / 1000000
 		// trasferredBytes / 1024 = KB/s, we multiply for 1000 to get milliseconds
-		wantedElapsed := 1000 * (trasferredBytes / 1024) / wantedBandwidth
+		wantedElapsed := 1000 * ((trasferredBytes - startBytes) / 1024) / wantedBandwidth
 		if wantedElapsed > realElapsed {
 			toSleep := time.Duration(wantedElapsed - realElapsed)
 			time.Sleep(toSleep * time.Millisecond)
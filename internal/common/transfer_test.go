@@ -15,7 +15,10 @@
 package common
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -104,6 +107,67 @@ func TestTransferThrottling(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestTransferThrottlingSchedules(t *testing.T) {
+	u := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:          "test",
+			UploadBandwidth:   50,
+			DownloadBandwidth: 40,
+		},
+		Filters: dataprovider.UserFilters{
+			BandwidthSchedules: []dataprovider.BandwidthSchedule{
+				{
+					Hours:             "9-17",
+					DayOfWeek:         "1-5",
+					UploadBandwidth:   100,
+					DownloadBandwidth: 0,
+				},
+				{
+					Hours:           "9-12",
+					UploadBandwidth: 200,
+				},
+			},
+		},
+	}
+	// Monday
+	ul, dl := u.GetBandwidthForTime(time.Date(2022, 10, 3, 10, 30, 0, 0, time.UTC), u.UploadBandwidth, u.DownloadBandwidth)
+	assert.Equal(t, int64(100), ul)
+	assert.Equal(t, int64(0), dl)
+	ul, dl = u.GetBandwidthForTime(time.Date(2022, 10, 3, 18, 0, 0, 0, time.UTC), u.UploadBandwidth, u.DownloadBandwidth)
+	assert.Equal(t, int64(50), ul)
+	assert.Equal(t, int64(40), dl)
+	// Saturday
+	ul, dl = u.GetBandwidthForTime(time.Date(2022, 10, 8, 10, 30, 0, 0, time.UTC), u.UploadBandwidth, u.DownloadBandwidth)
+	assert.Equal(t, int64(200), ul)
+	assert.Equal(t, int64(0), dl)
+	ul, _ = u.GetBandwidthForTime(time.Date(2022, 10, 8, 10, 30, 0, 0, time.FixedZone("CEST", 2*3600)),
+		u.UploadBandwidth, u.DownloadBandwidth)
+	assert.Equal(t, int64(50), ul)
+	// an always active schedule without limits
+	u.Filters.BandwidthSchedules = []dataprovider.BandwidthSchedule{{}}
+	fs := vfs.NewOsFs("", os.TempDir(), "")
+	testFileSize := int64(131072)
+	conn := NewBaseConnection("id", ProtocolSCP, "", "", u)
+	transfer := NewBaseTransfer(nil, conn, nil, "", "", "", TransferUpload, 0, 0, 0, 0, true, fs, dataprovider.TransferQuota{})
+	transfer.BytesReceived.Store(testFileSize)
+	startTime := time.Now()
+	transfer.HandleThrottle()
+	assert.Less(t, time.Since(startTime), 500*time.Millisecond)
+	// the schedule no longer matches, the user limit is applied to the bytes
+	// transferred after the change
+	conn.User.Filters.BandwidthSchedules[0].Hours = fmt.Sprintf("%d", (time.Now().UTC().Hour()+2)%24)
+	transfer.throttle.checkedAt = transfer.throttle.checkedAt.Add(-time.Minute)
+	transfer.HandleThrottle()
+	transfer.BytesReceived.Store(testFileSize + 10240)
+	startTime = time.Now()
+	transfer.HandleThrottle()
+	elapsed := time.Since(startTime)
+	assert.GreaterOrEqual(t, elapsed, 150*time.Millisecond)
+	assert.Less(t, elapsed, 2*time.Second)
+	err := transfer.Close()
+	assert.NoError(t, err)
+}
+
 func TestRealPath(t *testing.T) {
 	testFile := filepath.Join(os.TempDir(), "afile.txt")
 	fs := vfs.NewOsFs("123", os.TempDir(), "")
@@ -138,6 +202,92 @@ func TestRealPath(t *testing.T) {
 	assert.Len(t, conn.GetTransfers(), 0)
 }
 
+func TestZeroCopyDownload(t *testing.T) {
+	zeroCopyConfig := Config.ZeroCopyDownloads
+	defer func() {
+		Config.ZeroCopyDownloads = zeroCopyConfig
+	}()
+
+	testFile := filepath.Join(os.TempDir(), "zerocopy.dat")
+	data := bytes.Repeat([]byte("1234567890"), 262144)
+	err := os.WriteFile(testFile, data, os.ModePerm)
+	require.NoError(t, err)
+	fs := vfs.NewOsFs("", os.TempDir(), "")
+	u := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "user",
+			HomeDir:  os.TempDir(),
+		},
+	}
+	conn := NewBaseConnection(fs.ConnectionID(), ProtocolFTP, "", "", u)
+	newTransfer := func() (*BaseTransfer, *os.File) {
+		file, err := os.Open(testFile)
+		require.NoError(t, err)
+		transfer := NewBaseTransfer(file, conn, nil, testFile, testFile, "/zerocopy.dat", TransferDownload,
+			0, 0, 0, 0, false, fs, dataprovider.TransferQuota{})
+		return transfer, file
+	}
+
+	Config.ZeroCopyDownloads.Enabled = false
+	transfer, file := newTransfer()
+	var buf bytes.Buffer
+	_, err = transfer.ZeroCopyTo(&buf, -1)
+	assert.ErrorIs(t, err, ErrOpUnsupported)
+	assert.NoError(t, transfer.Close())
+	assert.NoError(t, file.Close())
+
+	Config.ZeroCopyDownloads.Enabled = true
+	transfer, file = newTransfer()
+	n, err := transfer.ZeroCopyTo(&buf, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, int64(len(data)), transfer.BytesSent.Load())
+	assert.Equal(t, data, buf.Bytes())
+	// a writer without ReadFrom is not eligible
+	_, err = transfer.ZeroCopyTo(struct{ io.Writer }{&buf}, -1)
+	assert.ErrorIs(t, err, ErrOpUnsupported)
+	assert.NoError(t, transfer.Close())
+	assert.NoError(t, file.Close())
+
+	buf.Reset()
+	transfer, file = newTransfer()
+	n, err = transfer.ZeroCopyTo(&buf, zeroCopyChunkSize+10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(zeroCopyChunkSize+10), n)
+	assert.Equal(t, data[:zeroCopyChunkSize+10], buf.Bytes())
+	errAbort := errors.New("transfer aborted")
+	transfer.SignalClose(errAbort)
+	_, err = transfer.ZeroCopyTo(&buf, -1)
+	assert.ErrorIs(t, err, errAbort)
+	assert.ErrorIs(t, transfer.Close(), errAbort)
+	assert.NoError(t, file.Close())
+
+	conn.User.DownloadBandwidth = 1024
+	transfer, file = newTransfer()
+	_, err = transfer.ZeroCopyTo(&buf, -1)
+	assert.ErrorIs(t, err, ErrOpUnsupported)
+	assert.NoError(t, transfer.Close())
+	assert.NoError(t, file.Close())
+	Config.ZeroCopyDownloads.MinBandwidth = 1024
+	buf.Reset()
+	transfer, file = newTransfer()
+	_, err = transfer.ZeroCopyTo(&buf, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, data[:100], buf.Bytes())
+	assert.NoError(t, transfer.Close())
+	assert.NoError(t, file.Close())
+
+	transfer = NewBaseTransfer(nil, conn, nil, testFile, testFile, "/zerocopy.dat", TransferUpload,
+		0, 0, 0, 0, false, fs, dataprovider.TransferQuota{})
+	_, err = transfer.ZeroCopyTo(&buf, -1)
+	assert.ErrorIs(t, err, ErrOpUnsupported)
+	assert.NoError(t, transfer.Close())
+
+	err = os.Remove(testFile)
+	assert.NoError(t, err)
+	assert.Len(t, conn.GetTransfers(), 0)
+}
+
 func TestTruncate(t *testing.T) {
 	testFile := filepath.Join(os.TempDir(), "transfer_test_file")
 	fs := vfs.NewOsFs("123", os.TempDir(), "")
@@ -0,0 +1,126 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"path"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func (c *BaseConnection) getFsVersioner(virtualPath string) (vfs.FsVersioner, string, error) {
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualPath)) {
+		return nil, "", c.GetPermissionDeniedError()
+	}
+	if ok, policy := c.User.IsFileAllowed(virtualPath); !ok {
+		c.Log(logger.LevelWarn, "accessing the versions for file %q is not allowed", virtualPath)
+		return nil, "", c.GetErrorForDeniedFile(policy)
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil, "", err
+	}
+	versioner, ok := fs.(vfs.FsVersioner)
+	if !ok {
+		return nil, "", c.GetOpUnsupportedError()
+	}
+	return versioner, fsPath, nil
+}
+
+// ListFileVersions returns the versions for the file at the specified virtual path,
+// newest first. Versioning must be enabled on the bucket/container
+func (c *BaseConnection) ListFileVersions(virtualPath string) ([]vfs.FileVersion, error) {
+	versioner, fsPath, err := c.getFsVersioner(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := versioner.ListVersions(fsPath)
+	if err != nil {
+		c.Log(logger.LevelDebug, "unable to list versions for path %q: %+v", virtualPath, err)
+		return nil, c.GetFsError(versioner, err)
+	}
+	return versions, nil
+}
+
+// GetFileVersion returns the filesystem, the resolved path and the requested
+// version for the file at the specified virtual path
+func (c *BaseConnection) GetFileVersion(virtualPath, versionID string) (vfs.FsVersioner, string, vfs.FileVersion, error) {
+	versioner, fsPath, err := c.getFsVersioner(virtualPath)
+	if err != nil {
+		return nil, "", vfs.FileVersion{}, err
+	}
+	versions, err := versioner.ListVersions(fsPath)
+	if err != nil {
+		c.Log(logger.LevelDebug, "unable to list versions for path %q: %+v", virtualPath, err)
+		return nil, "", vfs.FileVersion{}, c.GetFsError(versioner, err)
+	}
+	for _, version := range versions {
+		if version.ID == versionID {
+			return versioner, fsPath, version, nil
+		}
+	}
+	c.Log(logger.LevelDebug, "version %q not found for path %q", versionID, virtualPath)
+	return nil, "", vfs.FileVersion{}, c.GetNotExistError()
+}
+
+// RestoreFileVersion makes the specified version the current one for the file
+// at the specified virtual path. The restore is handled as an upload, so quota
+// limits are enforced and upload actions are executed
+func (c *BaseConnection) RestoreFileVersion(virtualPath, versionID string) error {
+	versioner, fsPath, version, err := c.GetFileVersion(virtualPath, versionID)
+	if err != nil {
+		return err
+	}
+	if version.IsLatest {
+		return nil
+	}
+	numFiles := 1
+	initialSize := int64(0)
+	if info, err := versioner.Lstat(fsPath); err == nil {
+		if !info.Mode().IsRegular() {
+			return c.GetOpUnsupportedError()
+		}
+		if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(virtualPath)) {
+			return c.GetPermissionDeniedError()
+		}
+		initialSize = info.Size()
+		numFiles = 0
+	} else if versioner.IsNotExist(err) {
+		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(virtualPath)) {
+			return c.GetPermissionDeniedError()
+		}
+	} else {
+		return c.GetFsError(versioner, err)
+	}
+	if err := c.hasSpaceForCopy(virtualPath, numFiles, version.Size-initialSize, version.Size); err != nil {
+		return err
+	}
+	if err := ExecutePreAction(c, OperationPreUpload, fsPath, virtualPath, initialSize, 0); err != nil {
+		c.Log(logger.LevelDebug, "restore of version %q for %q denied by pre action: %v", versionID, virtualPath, err)
+		return c.GetPermissionDeniedError()
+	}
+	if err := versioner.RestoreVersion(fsPath, versionID); err != nil {
+		c.Log(logger.LevelError, "unable to restore version %q for %q: %+v", versionID, virtualPath, err)
+		return c.GetFsError(versioner, err)
+	}
+	c.Log(logger.LevelDebug, "version %q restored for %q", versionID, virtualPath)
+	updateUserQuotaAfterFileWrite(c, virtualPath, numFiles, version.Size-initialSize)
+	ExecuteActionNotification(c, OperationUpload, fsPath, virtualPath, "", "", "", version.Size, nil) //nolint:errcheck
+	logger.CommandLog(versionLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "",
+		"", "", version.Size, c.localAddr, c.remoteAddr, c.impersonator)
+	return nil
+}
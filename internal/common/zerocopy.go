@@ -0,0 +1,108 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// the data are sent in chunks so we can check for aborted transfers,
+// update the transferred bytes and apply the quota limits while sending
+const zeroCopyChunkSize = 1048576
+
+// ZeroCopyConfig defines the configuration for the zero-copy downloads.
+// If enabled, downloads from the local filesystem are sent to the client
+// using sendfile/splice, so the data are not copied in user space.
+// This is only possible if the contents are not transformed before being
+// sent: encrypted and compressed filesystems, ASCII FTP transfers and TLS
+// connections always use the regular copy path. SFTP/SCP downloads are never
+// eligible since SSH encrypts the data stream
+type ZeroCopyConfig struct {
+	// Set to true to enable zero-copy downloads
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Bandwidth limited downloads use the regular copy path so the throttling
+	// is applied with a fine granularity. Downloads limited to a bandwidth, as
+	// KB/s, greater than or equal to this value use the zero-copy path anyway.
+	// 0 means that bandwidth limited downloads never use the zero-copy path
+	MinBandwidth int64 `json:"min_bandwidth" mapstructure:"min_bandwidth"`
+}
+
+func (t *BaseTransfer) getZeroCopyTarget(w io.Writer) (*os.File, io.ReaderFrom, bool) {
+	if !Config.ZeroCopyDownloads.Enabled || t.transferType != TransferDownload {
+		return nil, nil, false
+	}
+	if t.Fs == nil || !vfs.IsLocalOsFs(t.Fs) {
+		return nil, nil, false
+	}
+	f, ok := t.File.(*os.File)
+	if !ok {
+		return nil, nil, false
+	}
+	rf, ok := w.(io.ReaderFrom)
+	if !ok {
+		return nil, nil, false
+	}
+	_, dl := t.Connection.GetBandwidth(time.Now())
+	if dl > 0 && (Config.ZeroCopyDownloads.MinBandwidth <= 0 || dl < Config.ZeroCopyDownloads.MinBandwidth) {
+		return nil, nil, false
+	}
+	return f, rf, true
+}
+
+// ZeroCopyTo sends up to size bytes of the downloaded file to w, a negative
+// size means until EOF. The data are sent using sendfile/splice if supported
+// by w. ErrOpUnsupported is returned, and nothing is sent, if the transfer is
+// not eligible for the zero-copy path, the caller must use the regular path
+func (t *BaseTransfer) ZeroCopyTo(w io.Writer, size int64) (int64, error) {
+	f, rf, ok := t.getZeroCopyTarget(w)
+	if !ok {
+		return 0, ErrOpUnsupported
+	}
+	var written int64
+	for size < 0 || written < size {
+		if t.AbortTransfer.Load() {
+			err := t.GetAbortError()
+			t.TransferError(err)
+			return written, err
+		}
+		chunk := int64(zeroCopyChunkSize)
+		if size >= 0 && size-written < chunk {
+			chunk = size - written
+		}
+		t.Connection.UpdateLastActivity()
+
+		n, err := rf.ReadFrom(&io.LimitedReader{R: f, N: chunk})
+		written += n
+		t.BytesSent.Add(n)
+
+		if err == nil {
+			err = t.CheckRead()
+		}
+		if err != nil {
+			t.TransferError(err)
+			return written, err
+		}
+		t.HandleThrottle()
+		if n < chunk {
+			// EOF
+			break
+		}
+	}
+	return written, nil
+}
@@ -16,6 +16,7 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -27,6 +28,7 @@ import (
 	"github.com/subosito/gotenv"
 
 	"github.com/drakkan/sftpgo/v2/internal/acme"
+	"github.com/drakkan/sftpgo/v2/internal/auditlog"
 	"github.com/drakkan/sftpgo/v2/internal/command"
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
@@ -37,11 +39,15 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/resolver"
+	"github.com/drakkan/sftpgo/v2/internal/s3d"
 	"github.com/drakkan/sftpgo/v2/internal/sftpd"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/telemetry"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/version"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 	"github.com/drakkan/sftpgo/v2/internal/webdavd"
 )
 
@@ -61,9 +67,15 @@ var (
 	defaultFTPDBanner      = fmt.Sprintf("SFTPGo %v ready", version.Get().Version)
 	defaultInstallCodeHint = "Installation code"
 	defaultSFTPDBinding    = sftpd.Binding{
-		Address:          "",
-		Port:             2022,
-		ApplyProxyConfig: true,
+		Address:           "",
+		Port:              2022,
+		ApplyProxyConfig:  true,
+		KeepaliveInterval: 0,
+		KeepaliveMaxCount: 3,
+		ClientPolicy:      dataprovider.ClientPolicy{},
+		UniformAuthErrors: false,
+		AllowedCountries:  nil,
+		DeniedCountries:   nil,
 	}
 	defaultFTPDBinding = ftpd.Binding{
 		Address:                    "",
@@ -75,11 +87,18 @@ var (
 		MinTLSVersion:              12,
 		ForcePassiveIP:             "",
 		PassiveIPOverrides:         nil,
+		PassivePortRange:           ftpd.PortRange{},
 		ClientAuthType:             0,
 		TLSCipherSuites:            nil,
 		PassiveConnectionsSecurity: 0,
 		ActiveConnectionsSecurity:  0,
 		Debug:                      false,
+		ClientPolicy:               dataprovider.ClientPolicy{},
+		UniformAuthErrors:          false,
+		AllowedCountries:           nil,
+		DeniedCountries:            nil,
+		EnableModeZ:                false,
+		ModeZLevel:                 0,
 	}
 	defaultWebDAVDBinding = webdavd.Binding{
 		Address:              "",
@@ -89,12 +108,31 @@ var (
 		CertificateKeyFile:   "",
 		MinTLSVersion:        12,
 		ClientAuthType:       0,
+		EnableHTTP3:          false,
 		TLSCipherSuites:      nil,
 		Prefix:               "",
 		ProxyAllowed:         nil,
 		ClientIPProxyHeader:  "",
 		ClientIPHeaderDepth:  0,
 		DisableWWWAuthHeader: false,
+		ClientPolicy:         dataprovider.ClientPolicy{},
+		UniformAuthErrors:    false,
+		AllowedCountries:     nil,
+		DeniedCountries:      nil,
+	}
+	defaultS3DBinding = s3d.Binding{
+		Address:             "",
+		Port:                0,
+		EnableHTTPS:         false,
+		CertificateFile:     "",
+		CertificateKeyFile:  "",
+		MinTLSVersion:       12,
+		TLSCipherSuites:     nil,
+		ProxyAllowed:        nil,
+		ClientIPProxyHeader: "",
+		ClientIPHeaderDepth: 0,
+		AllowedCountries:    nil,
+		DeniedCountries:     nil,
 	}
 	defaultHTTPDBinding = httpd.Binding{
 		Address:               "",
@@ -108,6 +146,7 @@ var (
 		CertificateKeyFile:    "",
 		MinTLSVersion:         12,
 		ClientAuthType:        0,
+		EnableHTTP3:           false,
 		TLSCipherSuites:       nil,
 		ProxyAllowed:          nil,
 		ClientIPProxyHeader:   "",
@@ -128,6 +167,29 @@ var (
 			InsecureSkipSignatureCheck: false,
 			Debug:                      false,
 		},
+		SAML: httpd.SAML{
+			IDPMetadataURL:     "",
+			IDPMetadataFile:    "",
+			BaseURL:            "",
+			EntityID:           "",
+			CertificateFile:    "",
+			CertificateKeyFile: "",
+			UsernameAttribute:  "",
+			RoleAttribute:      "",
+			ImplicitRoles:      false,
+			GroupAttribute:     "",
+			GroupMappings:      nil,
+			CustomAttributes:   []string{},
+			AutoProvisioning:   false,
+			HomeDirTemplate:    "",
+			Permissions:        []string{"*"},
+			Debug:              false,
+		},
+		MagicLink: httpd.MagicLink{
+			Enabled:  false,
+			BaseURL:  "",
+			Lifespan: 15,
+		},
 		Security: httpd.SecurityConf{
 			Enabled:                 false,
 			AllowedHosts:            nil,
@@ -144,15 +206,34 @@ var (
 			PermissionsPolicy:       "",
 			CrossOriginOpenerPolicy: "",
 			ExpectCTHeader:          "",
+			FrameAncestors:          nil,
+			ReferrerPolicy:          "",
 		},
-		Branding: httpd.Branding{},
+		Cors: httpd.CorsConfig{
+			Enabled:              false,
+			AllowedOrigins:       nil,
+			AllowedMethods:       nil,
+			AllowedHeaders:       nil,
+			ExposedHeaders:       nil,
+			AllowCredentials:     false,
+			MaxAge:               0,
+			OptionsPassthrough:   false,
+			OptionsSuccessStatus: 0,
+			AllowPrivateNetwork:  false,
+			Rules:                nil,
+		},
+		Branding:          httpd.Branding{},
+		ClientPolicy:      dataprovider.ClientPolicy{},
+		UniformAuthErrors: false,
+		AllowedCountries:  nil,
+		DeniedCountries:   nil,
 	}
 	defaultRateLimiter = common.RateLimiterConfig{
 		Average:                0,
 		Period:                 1000,
 		Burst:                  1,
 		Type:                   2,
-		Protocols:              []string{common.ProtocolSSH, common.ProtocolFTP, common.ProtocolWebDAV, common.ProtocolHTTP},
+		Protocols:              []string{common.ProtocolSSH, common.ProtocolFTP, common.ProtocolWebDAV, common.ProtocolHTTP, common.ProtocolS3},
 		AllowList:              []string{},
 		GenerateDefenderEvents: false,
 		EntriesSoftLimit:       100,
@@ -171,13 +252,18 @@ type globalConfig struct {
 	SFTPD           sftpd.Configuration   `json:"sftpd" mapstructure:"sftpd"`
 	FTPD            ftpd.Configuration    `json:"ftpd" mapstructure:"ftpd"`
 	WebDAVD         webdavd.Configuration `json:"webdavd" mapstructure:"webdavd"`
+	S3D             s3d.Configuration     `json:"s3d" mapstructure:"s3d"`
 	ProviderConf    dataprovider.Config   `json:"data_provider" mapstructure:"data_provider"`
 	HTTPDConfig     httpd.Conf            `json:"httpd" mapstructure:"httpd"`
 	HTTPConfig      httpclient.Config     `json:"http" mapstructure:"http"`
+	DNSConfig       resolver.Config       `json:"dns" mapstructure:"dns"`
 	CommandConfig   command.Config        `json:"command" mapstructure:"command"`
 	KMSConfig       kms.Configuration     `json:"kms" mapstructure:"kms"`
 	MFAConfig       mfa.Config            `json:"mfa" mapstructure:"mfa"`
 	TelemetryConfig telemetry.Conf        `json:"telemetry" mapstructure:"telemetry"`
+	TracingConfig   tracing.Config        `json:"tracing" mapstructure:"tracing"`
+	AuditLogConfig  auditlog.Config       `json:"audit_log" mapstructure:"audit_log"`
+	SyslogConfig    logger.SyslogConfig   `json:"syslog" mapstructure:"syslog"`
 	PluginsConfig   []plugin.Config       `json:"plugins" mapstructure:"plugins"`
 	SMTPConfig      smtp.Config           `json:"smtp" mapstructure:"smtp"`
 }
@@ -201,6 +287,7 @@ func Init() {
 				Hook:        "",
 			},
 			SetstatMode:           0,
+			RenameMode:            0,
 			TempPath:              "",
 			ProxyProtocol:         0,
 			ProxyAllowed:          []string{},
@@ -227,8 +314,63 @@ func Init() {
 				BlockListFile:      "",
 				SafeList:           []string{},
 				BlockList:          []string{},
+				Rollout: dataprovider.Rollout{
+					Nodes:      []string{},
+					Percentage: 0,
+				},
+				Feeds: nil,
+			},
+			GeoIP: common.GeoIPConfig{
+				DatabasePath: "",
 			},
 			RateLimitersConfig: []common.RateLimiterConfig{defaultRateLimiter},
+			LatencyThrottling: common.LatencyThrottlingConfig{
+				Mode:                  common.LatencyThrottlingModeDisabled,
+				Delay:                 2000,
+				S3PutThreshold:        0,
+				DataProviderThreshold: 0,
+				Window:                60,
+				MinSamples:            20,
+				RecoveryPercent:       80,
+			},
+			TempFilesCleanup: common.TempFilesCleanupConfig{
+				Interval:     0,
+				MaxAge:       1440,
+				ScanStorages: false,
+			},
+			ResourceLimits: common.ResourceLimitsConfig{
+				MaxRSS:        0,
+				MaxGoroutines: 0,
+				CheckInterval: 10,
+			},
+			ReadCache: vfs.ReadCacheConfig{
+				Path:            "",
+				MaxSize:         1024,
+				MaxObjectSize:   0,
+				TTL:             0,
+				ConsistencyMode: 0,
+			},
+			MultipartUploads: vfs.MultipartUploadsConfig{
+				MaxPartSize:    0,
+				MaxConcurrency: 0,
+				AutoTune:       false,
+			},
+			ZeroCopyDownloads: common.ZeroCopyConfig{
+				Enabled:      false,
+				MinBandwidth: 0,
+			},
+			ICAP: common.ICAPConfig{
+				URL:            "",
+				Method:         "RESPMOD",
+				Timeout:        60,
+				MaxSize:        0,
+				SkipTLSVerify:  false,
+				Action:         common.ICAPActionDelete,
+				QuarantinePath: "",
+				DisableUser:    false,
+				NotifyEmails:   []string{},
+				RejectOnError:  false,
+			},
 		},
 		ACME: acme.Configuration{
 			Email:      "",
@@ -264,6 +406,20 @@ func Init() {
 			KeyboardInteractiveHook:           "",
 			PasswordAuthentication:            true,
 			FolderPrefix:                      "",
+			CopyExtensions:                    false,
+			CustomMetadata:                    false,
+			Kerberos: sftpd.KerberosConfig{
+				Keytab:            "",
+				ServicePrincipal:  "",
+				MaxClockSkew:      0,
+				PrincipalMappings: []sftpd.KerberosPrincipalMapping{},
+			},
+			UserCA: sftpd.UserCAConfig{
+				PrivateKey:        "",
+				DefaultTTL:        60,
+				MaxTTL:            1440,
+				PrincipalMappings: []sftpd.UserCAPrincipalMapping{},
+			},
 		},
 		FTPD: ftpd.Configuration{
 			Bindings:                 []ftpd.Binding{defaultFTPDBinding},
@@ -311,6 +467,18 @@ func Init() {
 					MaxSize: 1000,
 				},
 			},
+			Locks: webdavd.LocksConfig{
+				Driver:     webdavd.LockDriverMemory,
+				MaxTimeout: 0,
+			},
+			CustomMetadata: false,
+		},
+		S3D: s3d.Configuration{
+			Bindings:            []s3d.Binding{defaultS3DBinding},
+			CertificateFile:     "",
+			CertificateKeyFile:  "",
+			Region:              "us-east-1",
+			MultipartExpiration: 1440,
 		},
 		ProviderConf: dataprovider.Config{
 			Driver:             "sqlite",
@@ -361,6 +529,7 @@ func Init() {
 					MinEntropy: 0,
 				},
 			},
+			PasswordPolicies:   nil,
 			PasswordCaching:    true,
 			UpdateMode:         0,
 			DelayedQuotaUpdate: 0,
@@ -372,7 +541,53 @@ func Init() {
 				Port:  0,
 				Proto: "http",
 			},
-			BackupsPath: "backups",
+			BackupsPath:         "backups",
+			SoftDeleteRetention: 0,
+			CircuitBreaker: dataprovider.CircuitBreakerConfig{
+				FailureThreshold:   0,
+				SlowQueryThreshold: 0,
+				OpenTimeout:        30,
+				ReadOnlyCacheTTL:   0,
+			},
+			ReadReplicas: dataprovider.ReadReplicasConfig{
+				ConnectionStrings: nil,
+				PoolSize:          0,
+				MaxLag:            10,
+			},
+			Audit: dataprovider.AuditConfig{
+				Enabled:       false,
+				RetentionDays: 0,
+			},
+			DeadLetters: dataprovider.DeadLettersConfig{
+				Enabled:       false,
+				RetentionDays: 0,
+			},
+			LDAP: dataprovider.LDAPConfig{
+				URL:             "",
+				StartTLS:        false,
+				SkipTLSVerify:   false,
+				BindDN:          "",
+				BindPassword:    "",
+				BaseDN:          "",
+				UserFilter:      "(&(objectClass=person)(uid=%s))",
+				GroupAttribute:  "memberOf",
+				EmailAttribute:  "mail",
+				HomeDirTemplate: "",
+				Permissions:     []string{dataprovider.PermAny},
+				GroupMappings:   nil,
+				Timeout:         10,
+				CacheTTL:        0,
+			},
+			CacheInvalidation: dataprovider.CacheInvalidationConfig{
+				Driver:  "",
+				URL:     "",
+				Channel: "sftpgo_cache_invalidation",
+			},
+			SQLite: dataprovider.SQLiteConfig{
+				JournalMode: "",
+				BusyTimeout: 0,
+				Synchronous: "",
+			},
 		},
 		HTTPDConfig: httpd.Conf{
 			Bindings:           []httpd.Binding{defaultHTTPDBinding},
@@ -404,6 +619,41 @@ func Init() {
 				InstallationCodeHint: defaultInstallCodeHint,
 			},
 			HideSupportLink: false,
+			Approvals: httpd.ApprovalConfig{
+				Operations:   []string{},
+				ValidityTime: 30,
+			},
+			Downloads: httpd.DownloadsConfig{
+				MimeTypes:            []string{},
+				AttachmentExtensions: []string{},
+				InlineExtensions:     []string{},
+				SafeMode:             false,
+			},
+			TUS: httpd.TUSConfig{
+				Enabled:        false,
+				ExpirationTime: 1440,
+				StagingPath:    "",
+			},
+			Uploads: httpd.UploadsConfig{
+				BufferSize:   256,
+				MemoryBudget: 0,
+			},
+			I18n: httpd.I18nConfig{
+				DefaultLanguage: "en",
+				CatalogsPath:    "",
+			},
+			Compliance: httpd.ComplianceConfig{
+				SigningKey: "",
+				Retention:  24,
+			},
+			GRPC: httpd.GRPCConfig{
+				Port:               0,
+				Address:            "",
+				CertificateFile:    "",
+				CertificateKeyFile: "",
+				MinTLSVersion:      12,
+				EventsBufferSize:   256,
+			},
 		},
 		HTTPConfig: httpclient.Config{
 			Timeout:        20,
@@ -414,11 +664,32 @@ func Init() {
 			Certificates:   nil,
 			SkipTLSVerify:  false,
 			Headers:        nil,
+			Signature: httpclient.SignatureConfig{
+				Secret:          "",
+				SignatureHeader: "X-SFTPGo-Signature",
+				TimestampHeader: "X-SFTPGo-Timestamp",
+				NonceHeader:     "X-SFTPGo-Nonce",
+			},
+		},
+		DNSConfig: resolver.Config{
+			Servers:          nil,
+			Timeout:          5,
+			CacheTTL:         0,
+			NegativeCacheTTL: 0,
+			StaticHosts:      nil,
 		},
 		CommandConfig: command.Config{
 			Timeout:  30,
 			Env:      nil,
 			Commands: nil,
+			Limits: command.Limits{
+				CPUTime:       0,
+				MaxMemory:     0,
+				MaxOutputSize: 0,
+				WorkingDir:    "",
+				EnvAllowlist:  []string{},
+			},
+			Cgroup: "",
 		},
 		KMSConfig: kms.Configuration{
 			Secrets: kms.Secrets{
@@ -429,6 +700,31 @@ func Init() {
 		},
 		MFAConfig: mfa.Config{
 			TOTP: []mfa.TOTPConfig{defaultTOTP},
+			WebAuthn: mfa.WebAuthnConfig{
+				RPID:             "",
+				RPDisplayName:    "",
+				RPOrigin:         "",
+				ResidentKey:      "",
+				UserVerification: "",
+				Timeout:          60,
+			},
+			AdminPolicy: 0,
+			RecoveryCodes: mfa.RecoveryCodesConfig{
+				RequireReverification: false,
+				AllowEmailRecovery:    false,
+			},
+			Push: mfa.PushConfig{
+				Provider: "",
+				Timeout:  45,
+				Duo: mfa.DuoConfig{
+					APIHostname:    "",
+					IntegrationKey: "",
+					SecretKey:      "",
+				},
+				Webhook: mfa.PushWebhookConfig{
+					URL: "",
+				},
+			},
 		},
 		TelemetryConfig: telemetry.Conf{
 			BindPort:           0,
@@ -439,17 +735,64 @@ func Init() {
 			CertificateKeyFile: "",
 			MinTLSVersion:      12,
 			TLSCipherSuites:    nil,
+			Push: telemetry.PushConfig{
+				URL:      "",
+				Job:      "sftpgo",
+				Interval: 60,
+				Labels:   nil,
+				Username: "",
+				Password: "",
+			},
+			UserMetrics: telemetry.UserMetricsConfig{
+				Enabled:    false,
+				MaxUsers:   100,
+				MaxFolders: 100,
+			},
+		},
+		TracingConfig: tracing.Config{
+			Endpoint:       "",
+			ServiceName:    "sftpgo",
+			SampleRatio:    1,
+			Headers:        nil,
+			ExportInterval: 5,
+			Timeout:        10,
+			SkipTLSVerify:  false,
+		},
+		AuditLogConfig: auditlog.Config{
+			FilePath: "",
+			Events:   nil,
+		},
+		SyslogConfig: logger.SyslogConfig{
+			Address:       "",
+			Network:       "udp",
+			Format:        "cef",
+			Facility:      "local0",
+			Events:        nil,
+			FieldMapping:  nil,
+			SkipTLSVerify: false,
 		},
 		SMTPConfig: smtp.Config{
-			Host:          "",
-			Port:          25,
-			From:          "",
-			User:          "",
-			Password:      "",
-			AuthType:      0,
-			Encryption:    0,
-			Domain:        "",
-			TemplatesPath: "templates",
+			Host:                "",
+			Port:                25,
+			From:                "",
+			User:                "",
+			Password:            "",
+			AuthType:            0,
+			Encryption:          0,
+			Domain:              "",
+			TemplatesPath:       "templates",
+			CustomTemplatesPath: "",
+			Provider:            smtp.ProviderSMTP,
+			API: smtp.APIConfig{
+				Key:      "",
+				Secret:   "",
+				Region:   "",
+				Domain:   "",
+				Endpoint: "",
+			},
+			MaxEmailsPerMinute: 0,
+			DigestInterval:     0,
+			MaxAttachmentsSize: 10,
 		},
 		PluginsConfig: nil,
 	}
@@ -503,6 +846,16 @@ func SetWebDAVDConfig(config webdavd.Configuration) {
 	globalConf.WebDAVD = config
 }
 
+// GetS3DConfig returns the configuration for the S3 gateway
+func GetS3DConfig() s3d.Configuration {
+	return globalConf.S3D
+}
+
+// SetS3DConfig sets the configuration for the S3 gateway
+func SetS3DConfig(config s3d.Configuration) {
+	globalConf.S3D = config
+}
+
 // GetHTTPDConfig returns the configuration for the HTTP server
 func GetHTTPDConfig() httpd.Conf {
 	return globalConf.HTTPDConfig
@@ -528,6 +881,11 @@ func GetHTTPConfig() httpclient.Config {
 	return globalConf.HTTPConfig
 }
 
+// GetDNSConfig returns the DNS configuration for outbound connections
+func GetDNSConfig() resolver.Config {
+	return globalConf.DNSConfig
+}
+
 // GetCommandConfig returns the configuration for external commands
 func GetCommandConfig() command.Config {
 	return globalConf.CommandConfig
@@ -553,6 +911,21 @@ func SetTelemetryConfig(config telemetry.Conf) {
 	globalConf.TelemetryConfig = config
 }
 
+// GetTracingConfig returns the tracing configuration
+func GetTracingConfig() tracing.Config {
+	return globalConf.TracingConfig
+}
+
+// GetAuditLogConfig returns the security audit log configuration
+func GetAuditLogConfig() auditlog.Config {
+	return globalConf.AuditLogConfig
+}
+
+// GetSyslogConfig returns the configuration for the syslog output
+func GetSyslogConfig() logger.SyslogConfig {
+	return globalConf.SyslogConfig
+}
+
 // GetPluginsConfig returns the plugins configuration
 func GetPluginsConfig() []plugin.Config {
 	return globalConf.PluginsConfig
@@ -579,7 +952,7 @@ func GetACMEConfig() acme.Configuration {
 }
 
 // HasServicesToStart returns true if the config defines at least a service to start.
-// Supported services are SFTP, FTP and WebDAV
+// Supported services are SFTP, FTP, WebDAV and the S3 gateway
 func HasServicesToStart() bool {
 	if globalConf.SFTPD.ShouldBind() {
 		return true
@@ -590,6 +963,9 @@ func HasServicesToStart() bool {
 	if globalConf.WebDAVD.ShouldBind() {
 		return true
 	}
+	if globalConf.S3D.ShouldBind() {
+		return true
+	}
 	return false
 }
 
@@ -617,6 +993,8 @@ func getRedactedGlobalConf() globalConfig {
 	conf.ProviderConf.PostLoginHook = util.GetRedactedURL(conf.ProviderConf.PostLoginHook)
 	conf.ProviderConf.CheckPasswordHook = util.GetRedactedURL(conf.ProviderConf.CheckPasswordHook)
 	conf.SMTPConfig.Password = getRedactedPassword(conf.SMTPConfig.Password)
+	conf.SMTPConfig.API.Key = getRedactedPassword(conf.SMTPConfig.API.Key)
+	conf.SMTPConfig.API.Secret = getRedactedPassword(conf.SMTPConfig.API.Secret)
 	conf.HTTPDConfig.Bindings = nil
 	for _, binding := range globalConf.HTTPDConfig.Bindings {
 		binding.OIDC.ClientID = getRedactedPassword(binding.OIDC.ClientID)
@@ -626,6 +1004,16 @@ func getRedactedGlobalConf() globalConfig {
 	return conf
 }
 
+// WriteConfig writes the current configuration, JSON encoded, to the specified file.
+// Sensitive values are not redacted so the file is readable by the owner only
+func WriteConfig(configFile string) error {
+	data, err := json.MarshalIndent(globalConf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal the configuration: %w", err)
+	}
+	return os.WriteFile(configFile, data, 0600)
+}
+
 func setConfigFile(configDir, configFile string) {
 	if configFile == "" {
 		return
@@ -685,6 +1073,13 @@ func checkOverrideDefaultSettings() {
 						}
 					}
 				}
+				if val, ok := binding["saml"]; ok {
+					if saml, ok := val.(map[string]any); ok {
+						if _, ok := saml["permissions"]; ok {
+							globalConf.HTTPDConfig.Bindings[0].SAML.Permissions = nil
+						}
+					}
+				}
 			}
 		}
 	}
@@ -791,20 +1186,36 @@ func resetInvalidConfigs() {
 			logger.WarnToConsole("Non-fatal configuration error: %v", warn)
 		}
 	}
+	if globalConf.WebDAVD.Locks.Driver == webdavd.LockDriverProvider && !globalConf.ProviderConf.IsSharedSessionSupported() {
+		warn := fmt.Sprintf("provider based WebDAV locks are not supported with data provider %#v, "+
+			"the memory locks implementation will be used. If you want to use the provider locks "+
+			"implementation please switch to a data provider supporting shared sessions",
+			globalConf.ProviderConf.Driver)
+		globalConf.WebDAVD.Locks.Driver = webdavd.LockDriverMemory
+		logger.Warn(logSender, "", "Non-fatal configuration error: %v", warn)
+		logger.WarnToConsole("Non-fatal configuration error: %v", warn)
+	}
 }
 
 func loadBindingsFromEnv() {
 	for idx := 0; idx < 10; idx++ {
 		getTOTPFromEnv(idx)
 		getRateLimitersFromEnv(idx)
+		getDefenderFeedsFromEnv(idx)
 		getPluginsFromEnv(idx)
 		getSFTPDBindindFromEnv(idx)
 		getFTPDBindingFromEnv(idx)
 		getWebDAVDBindingFromEnv(idx)
+		getS3DBindingFromEnv(idx)
 		getHTTPDBindingFromEnv(idx)
 		getHTTPClientCertificatesFromEnv(idx)
 		getHTTPClientHeadersFromEnv(idx)
+		getDNSStaticHostsFromEnv(idx)
 		getCommandConfigsFromEnv(idx)
+		getLDAPGroupMappingsFromEnv(idx)
+		getPasswordPoliciesFromEnv(idx)
+		getKerberosPrincipalMappingsFromEnv(idx)
+		getUserCAPrincipalMappingsFromEnv(idx)
 	}
 }
 
@@ -914,6 +1325,65 @@ func getRateLimitersFromEnv(idx int) {
 	}
 }
 
+func getDefenderFeedsFromEnv(idx int) {
+	feed := common.DefenderFeed{}
+	if len(globalConf.Common.DefenderConfig.Feeds) > idx {
+		feed = globalConf.Common.DefenderConfig.Feeds[idx]
+	}
+
+	isSet := false
+
+	name, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_COMMON__DEFENDER__FEEDS__%v__NAME", idx))
+	if ok {
+		feed.Name = name
+		isSet = true
+	}
+
+	url, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_COMMON__DEFENDER__FEEDS__%v__URL", idx))
+	if ok {
+		feed.URL = url
+		isSet = true
+	}
+
+	format, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_COMMON__DEFENDER__FEEDS__%v__FORMAT", idx))
+	if ok {
+		feed.Format = format
+		isSet = true
+	}
+
+	apiKey, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_COMMON__DEFENDER__FEEDS__%v__API_KEY", idx))
+	if ok {
+		feed.APIKey = apiKey
+		isSet = true
+	}
+
+	refreshInterval, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_COMMON__DEFENDER__FEEDS__%v__REFRESH_INTERVAL", idx))
+	if ok {
+		feed.RefreshInterval = int(refreshInterval)
+		isSet = true
+	}
+
+	score, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_COMMON__DEFENDER__FEEDS__%v__SCORE", idx))
+	if ok {
+		feed.Score = int(score)
+		isSet = true
+	}
+
+	ttl, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_COMMON__DEFENDER__FEEDS__%v__TTL", idx))
+	if ok {
+		feed.TTL = int(ttl)
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.Common.DefenderConfig.Feeds) > idx {
+			globalConf.Common.DefenderConfig.Feeds[idx] = feed
+		} else {
+			globalConf.Common.DefenderConfig.Feeds = append(globalConf.Common.DefenderConfig.Feeds, feed)
+		}
+	}
+}
+
 func getKMSPluginFromEnv(idx int, pluginConfig *plugin.Config) bool {
 	isSet := false
 
@@ -1039,6 +1509,42 @@ func getPluginsFromEnv(idx int) {
 	}
 }
 
+func getClientPolicyFromEnv(prefix string, policy *dataprovider.ClientPolicy) bool {
+	isSet := false
+
+	allowed, ok := lookupStringListFromEnv(fmt.Sprintf("%s__CLIENT_POLICY__ALLOWED", prefix))
+	if ok {
+		policy.Allowed = allowed
+		isSet = true
+	}
+
+	denied, ok := lookupStringListFromEnv(fmt.Sprintf("%s__CLIENT_POLICY__DENIED", prefix))
+	if ok {
+		policy.Denied = denied
+		isSet = true
+	}
+
+	return isSet
+}
+
+func getCountriesFromEnv(prefix string, allowed, denied *[]string) bool {
+	isSet := false
+
+	allowedCountries, ok := lookupStringListFromEnv(fmt.Sprintf("%s__ALLOWED_COUNTRIES", prefix))
+	if ok {
+		*allowed = allowedCountries
+		isSet = true
+	}
+
+	deniedCountries, ok := lookupStringListFromEnv(fmt.Sprintf("%s__DENIED_COUNTRIES", prefix))
+	if ok {
+		*denied = deniedCountries
+		isSet = true
+	}
+
+	return isSet
+}
+
 func getSFTPDBindindFromEnv(idx int) {
 	binding := defaultSFTPDBinding
 	if len(globalConf.SFTPD.Bindings) > idx {
@@ -1065,6 +1571,33 @@ func getSFTPDBindindFromEnv(idx int) {
 		isSet = true
 	}
 
+	keepaliveInterval, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__KEEPALIVE_INTERVAL", idx))
+	if ok {
+		binding.KeepaliveInterval = int(keepaliveInterval)
+		isSet = true
+	}
+
+	keepaliveMaxCount, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__KEEPALIVE_MAX_COUNT", idx))
+	if ok {
+		binding.KeepaliveMaxCount = int(keepaliveMaxCount)
+		isSet = true
+	}
+
+	if getClientPolicyFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v", idx), &binding.ClientPolicy) {
+		isSet = true
+	}
+
+	if getCountriesFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v", idx), &binding.AllowedCountries,
+		&binding.DeniedCountries) {
+		isSet = true
+	}
+
+	uniformAuthErrors, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__UNIFORM_AUTH_ERRORS", idx))
+	if ok {
+		binding.UniformAuthErrors = uniformAuthErrors
+		isSet = true
+	}
+
 	if isSet {
 		if len(globalConf.SFTPD.Bindings) > idx {
 			globalConf.SFTPD.Bindings[idx] = binding
@@ -1177,6 +1710,10 @@ func getFTPDBindingFromEnv(idx int) {
 		isSet = true
 	}
 
+	if getFTPDBindingPassivePortRangeFromEnv(idx, &binding) {
+		isSet = true
+	}
+
 	clientAuthType, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__CLIENT_AUTH_TYPE", idx))
 	if ok {
 		binding.ClientAuthType = int(clientAuthType)
@@ -1207,9 +1744,64 @@ func getFTPDBindingFromEnv(idx int) {
 		isSet = true
 	}
 
+	if getClientPolicyFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v", idx), &binding.ClientPolicy) {
+		isSet = true
+	}
+
+	if getCountriesFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v", idx), &binding.AllowedCountries,
+		&binding.DeniedCountries) {
+		isSet = true
+	}
+
+	uniformAuthErrors, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__UNIFORM_AUTH_ERRORS", idx))
+	if ok {
+		binding.UniformAuthErrors = uniformAuthErrors
+		isSet = true
+	}
+
+	if getFTPDBindingModeZFromEnv(idx, &binding) {
+		isSet = true
+	}
+
 	applyFTPDBindingFromEnv(idx, isSet, binding)
 }
 
+func getFTPDBindingModeZFromEnv(idx int, binding *ftpd.Binding) bool {
+	isSet := false
+
+	enableModeZ, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__ENABLE_MODE_Z", idx))
+	if ok {
+		binding.EnableModeZ = enableModeZ
+		isSet = true
+	}
+
+	modeZLevel, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__MODE_Z_LEVEL", idx))
+	if ok {
+		binding.ModeZLevel = int(modeZLevel)
+		isSet = true
+	}
+
+	return isSet
+}
+
+func getFTPDBindingPassivePortRangeFromEnv(idx int, binding *ftpd.Binding) bool {
+	isSet := false
+
+	start, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PASSIVE_PORT_RANGE__START", idx))
+	if ok {
+		binding.PassivePortRange.Start = int(start)
+		isSet = true
+	}
+
+	end, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PASSIVE_PORT_RANGE__END", idx))
+	if ok {
+		binding.PassivePortRange.End = int(end)
+		isSet = true
+	}
+
+	return isSet
+}
+
 func applyFTPDBindingFromEnv(idx int, isSet bool, binding ftpd.Binding) {
 	if isSet {
 		if len(globalConf.FTPD.Bindings) > idx {
@@ -1270,6 +1862,12 @@ func getWebDAVDBindingFromEnv(idx int) {
 		isSet = true
 	}
 
+	enableHTTP3, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__ENABLE_HTTP3", idx))
+	if ok {
+		binding.EnableHTTP3 = enableHTTP3
+		isSet = true
+	}
+
 	certificateFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__CERTIFICATE_FILE", idx))
 	if ok {
 		binding.CertificateFile = certificateFile
@@ -1316,6 +1914,21 @@ func getWebDAVDBindingFromEnv(idx int) {
 		isSet = true
 	}
 
+	if getClientPolicyFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v", idx), &binding.ClientPolicy) {
+		isSet = true
+	}
+
+	if getCountriesFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v", idx), &binding.AllowedCountries,
+		&binding.DeniedCountries) {
+		isSet = true
+	}
+
+	uniformAuthErrors, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__UNIFORM_AUTH_ERRORS", idx))
+	if ok {
+		binding.UniformAuthErrors = uniformAuthErrors
+		isSet = true
+	}
+
 	if isSet {
 		if len(globalConf.WebDAVD.Bindings) > idx {
 			globalConf.WebDAVD.Bindings[idx] = binding
@@ -1325,57 +1938,149 @@ func getWebDAVDBindingFromEnv(idx int) {
 	}
 }
 
-func getHTTPDSecurityProxyHeadersFromEnv(idx int) []httpd.HTTPSProxyHeader {
-	var httpsProxyHeaders []httpd.HTTPSProxyHeader
-	if len(globalConf.HTTPDConfig.Bindings) > idx {
-		httpsProxyHeaders = globalConf.HTTPDConfig.Bindings[idx].Security.HTTPSProxyHeaders
-	}
-
-	for subIdx := 0; subIdx < 10; subIdx++ {
-		var httpsProxyHeader httpd.HTTPSProxyHeader
-		var replace bool
-		if len(globalConf.HTTPDConfig.Bindings) > idx &&
-			len(globalConf.HTTPDConfig.Bindings[idx].Security.HTTPSProxyHeaders) > subIdx {
-			httpsProxyHeader = httpsProxyHeaders[subIdx]
-			replace = true
-		}
-		proxyKey, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SECURITY__HTTPS_PROXY_HEADERS__%v__KEY",
-			idx, subIdx))
-		if ok {
-			httpsProxyHeader.Key = proxyKey
-		}
-		proxyVal, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SECURITY__HTTPS_PROXY_HEADERS__%v__VALUE",
-			idx, subIdx))
-		if ok {
-			httpsProxyHeader.Value = proxyVal
-		}
-		if httpsProxyHeader.Key != "" && httpsProxyHeader.Value != "" {
-			if replace {
-				httpsProxyHeaders[subIdx] = httpsProxyHeader
-			} else {
-				httpsProxyHeaders = append(httpsProxyHeaders, httpsProxyHeader)
-			}
-		}
-	}
-	return httpsProxyHeaders
-}
-
-func getHTTPDSecurityConfFromEnv(idx int) (httpd.SecurityConf, bool) { //nolint:gocyclo
-	result := defaultHTTPDBinding.Security
-	if len(globalConf.HTTPDConfig.Bindings) > idx {
-		result = globalConf.HTTPDConfig.Bindings[idx].Security
-	}
+func getS3DBindingProxyConfigsFromEnv(idx int, binding *s3d.Binding) bool {
 	isSet := false
 
-	enabled, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SECURITY__ENABLED", idx))
+	proxyAllowed, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__PROXY_ALLOWED", idx))
 	if ok {
-		result.Enabled = enabled
+		binding.ProxyAllowed = proxyAllowed
 		isSet = true
 	}
 
-	allowedHosts, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SECURITY__ALLOWED_HOSTS", idx))
+	clientIPProxyHeader, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__CLIENT_IP_PROXY_HEADER", idx))
 	if ok {
-		result.AllowedHosts = allowedHosts
+		binding.ClientIPProxyHeader = clientIPProxyHeader
+		isSet = true
+	}
+
+	clientIPHeaderDepth, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__CLIENT_IP_HEADER_DEPTH", idx))
+	if ok {
+		binding.ClientIPHeaderDepth = int(clientIPHeaderDepth)
+		isSet = true
+	}
+
+	return isSet
+}
+
+func getS3DBindingFromEnv(idx int) {
+	binding := defaultS3DBinding
+	if len(globalConf.S3D.Bindings) > idx {
+		binding = globalConf.S3D.Bindings[idx]
+	}
+
+	isSet := false
+
+	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__PORT", idx))
+	if ok {
+		binding.Port = int(port)
+		isSet = true
+	}
+
+	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__ADDRESS", idx))
+	if ok {
+		binding.Address = address
+		isSet = true
+	}
+
+	enableHTTPS, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__ENABLE_HTTPS", idx))
+	if ok {
+		binding.EnableHTTPS = enableHTTPS
+		isSet = true
+	}
+
+	certificateFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__CERTIFICATE_FILE", idx))
+	if ok {
+		binding.CertificateFile = certificateFile
+		isSet = true
+	}
+
+	certificateKeyFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__CERTIFICATE_KEY_FILE", idx))
+	if ok {
+		binding.CertificateKeyFile = certificateKeyFile
+		isSet = true
+	}
+
+	tlsVer, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__MIN_TLS_VERSION", idx))
+	if ok {
+		binding.MinTLSVersion = int(tlsVer)
+		isSet = true
+	}
+
+	tlsCiphers, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v__TLS_CIPHER_SUITES", idx))
+	if ok {
+		binding.TLSCipherSuites = tlsCiphers
+		isSet = true
+	}
+
+	if getS3DBindingProxyConfigsFromEnv(idx, &binding) {
+		isSet = true
+	}
+
+	if getCountriesFromEnv(fmt.Sprintf("SFTPGO_S3D__BINDINGS__%v", idx), &binding.AllowedCountries,
+		&binding.DeniedCountries) {
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.S3D.Bindings) > idx {
+			globalConf.S3D.Bindings[idx] = binding
+		} else {
+			globalConf.S3D.Bindings = append(globalConf.S3D.Bindings, binding)
+		}
+	}
+}
+
+func getHTTPDSecurityProxyHeadersFromEnv(idx int) []httpd.HTTPSProxyHeader {
+	var httpsProxyHeaders []httpd.HTTPSProxyHeader
+	if len(globalConf.HTTPDConfig.Bindings) > idx {
+		httpsProxyHeaders = globalConf.HTTPDConfig.Bindings[idx].Security.HTTPSProxyHeaders
+	}
+
+	for subIdx := 0; subIdx < 10; subIdx++ {
+		var httpsProxyHeader httpd.HTTPSProxyHeader
+		var replace bool
+		if len(globalConf.HTTPDConfig.Bindings) > idx &&
+			len(globalConf.HTTPDConfig.Bindings[idx].Security.HTTPSProxyHeaders) > subIdx {
+			httpsProxyHeader = httpsProxyHeaders[subIdx]
+			replace = true
+		}
+		proxyKey, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SECURITY__HTTPS_PROXY_HEADERS__%v__KEY",
+			idx, subIdx))
+		if ok {
+			httpsProxyHeader.Key = proxyKey
+		}
+		proxyVal, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SECURITY__HTTPS_PROXY_HEADERS__%v__VALUE",
+			idx, subIdx))
+		if ok {
+			httpsProxyHeader.Value = proxyVal
+		}
+		if httpsProxyHeader.Key != "" && httpsProxyHeader.Value != "" {
+			if replace {
+				httpsProxyHeaders[subIdx] = httpsProxyHeader
+			} else {
+				httpsProxyHeaders = append(httpsProxyHeaders, httpsProxyHeader)
+			}
+		}
+	}
+	return httpsProxyHeaders
+}
+
+func getHTTPDSecurityConfFromEnv(idx int) (httpd.SecurityConf, bool) { //nolint:gocyclo
+	result := defaultHTTPDBinding.Security
+	if len(globalConf.HTTPDConfig.Bindings) > idx {
+		result = globalConf.HTTPDConfig.Bindings[idx].Security
+	}
+	isSet := false
+
+	enabled, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SECURITY__ENABLED", idx))
+	if ok {
+		result.Enabled = enabled
+		isSet = true
+	}
+
+	allowedHosts, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SECURITY__ALLOWED_HOSTS", idx))
+	if ok {
+		result.AllowedHosts = allowedHosts
 		isSet = true
 	}
 
@@ -1457,6 +2162,339 @@ func getHTTPDSecurityConfFromEnv(idx int) (httpd.SecurityConf, bool) { //nolint:
 		isSet = true
 	}
 
+	frameAncestors, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SECURITY__FRAME_ANCESTORS", idx))
+	if ok {
+		result.FrameAncestors = frameAncestors
+		isSet = true
+	}
+
+	referrerPolicy, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SECURITY__REFERRER_POLICY", idx))
+	if ok {
+		result.ReferrerPolicy = referrerPolicy
+		isSet = true
+	}
+
+	return result, isSet
+}
+
+func getHTTPDCorsRulesFromEnv(idx int, rules []httpd.CorsRule) ([]httpd.CorsRule, bool) {
+	isSet := false
+
+	for subIdx := 0; subIdx < 10; subIdx++ {
+		var rule httpd.CorsRule
+		var replace bool
+		if len(rules) > subIdx {
+			rule = rules[subIdx]
+			replace = true
+		}
+		prefix := fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__CORS__RULES__%v", idx, subIdx)
+		ruleSet := false
+
+		paths, ok := lookupStringListFromEnv(fmt.Sprintf("%s__PATHS", prefix))
+		if ok {
+			rule.Paths = paths
+			ruleSet = true
+		}
+
+		allowedOrigins, ok := lookupStringListFromEnv(fmt.Sprintf("%s__ALLOWED_ORIGINS", prefix))
+		if ok {
+			rule.AllowedOrigins = allowedOrigins
+			ruleSet = true
+		}
+
+		allowedMethods, ok := lookupStringListFromEnv(fmt.Sprintf("%s__ALLOWED_METHODS", prefix))
+		if ok {
+			rule.AllowedMethods = allowedMethods
+			ruleSet = true
+		}
+
+		allowedHeaders, ok := lookupStringListFromEnv(fmt.Sprintf("%s__ALLOWED_HEADERS", prefix))
+		if ok {
+			rule.AllowedHeaders = allowedHeaders
+			ruleSet = true
+		}
+
+		exposedHeaders, ok := lookupStringListFromEnv(fmt.Sprintf("%s__EXPOSED_HEADERS", prefix))
+		if ok {
+			rule.ExposedHeaders = exposedHeaders
+			ruleSet = true
+		}
+
+		allowCredentials, ok := lookupBoolFromEnv(fmt.Sprintf("%s__ALLOW_CREDENTIALS", prefix))
+		if ok {
+			rule.AllowCredentials = allowCredentials
+			ruleSet = true
+		}
+
+		maxAge, ok := lookupIntFromEnv(fmt.Sprintf("%s__MAX_AGE", prefix))
+		if ok {
+			rule.MaxAge = int(maxAge)
+			ruleSet = true
+		}
+
+		if ruleSet {
+			if replace {
+				rules[subIdx] = rule
+			} else {
+				rules = append(rules, rule)
+			}
+			isSet = true
+		}
+	}
+
+	return rules, isSet
+}
+
+func getHTTPDCorsFromEnv(idx int) (httpd.CorsConfig, bool) {
+	result := defaultHTTPDBinding.Cors
+	if len(globalConf.HTTPDConfig.Bindings) > idx {
+		result = globalConf.HTTPDConfig.Bindings[idx].Cors
+	}
+	isSet := false
+
+	enabled, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__CORS__ENABLED", idx))
+	if ok {
+		result.Enabled = enabled
+		isSet = true
+	}
+
+	allowedOrigins, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__CORS__ALLOWED_ORIGINS", idx))
+	if ok {
+		result.AllowedOrigins = allowedOrigins
+		isSet = true
+	}
+
+	allowedMethods, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__CORS__ALLOWED_METHODS", idx))
+	if ok {
+		result.AllowedMethods = allowedMethods
+		isSet = true
+	}
+
+	allowedHeaders, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__CORS__ALLOWED_HEADERS", idx))
+	if ok {
+		result.AllowedHeaders = allowedHeaders
+		isSet = true
+	}
+
+	exposedHeaders, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__CORS__EXPOSED_HEADERS", idx))
+	if ok {
+		result.ExposedHeaders = exposedHeaders
+		isSet = true
+	}
+
+	allowCredentials, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__CORS__ALLOW_CREDENTIALS", idx))
+	if ok {
+		result.AllowCredentials = allowCredentials
+		isSet = true
+	}
+
+	maxAge, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__CORS__MAX_AGE", idx))
+	if ok {
+		result.MaxAge = int(maxAge)
+		isSet = true
+	}
+
+	optionsPassthrough, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__CORS__OPTIONS_PASSTHROUGH", idx))
+	if ok {
+		result.OptionsPassthrough = optionsPassthrough
+		isSet = true
+	}
+
+	optionsSuccessStatus, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__CORS__OPTIONS_SUCCESS_STATUS", idx))
+	if ok {
+		result.OptionsSuccessStatus = int(optionsSuccessStatus)
+		isSet = true
+	}
+
+	allowPrivateNetwork, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__CORS__ALLOW_PRIVATE_NETWORK", idx))
+	if ok {
+		result.AllowPrivateNetwork = allowPrivateNetwork
+		isSet = true
+	}
+
+	rules, ok := getHTTPDCorsRulesFromEnv(idx, result.Rules)
+	if ok {
+		result.Rules = rules
+		isSet = true
+	}
+
+	return result, isSet
+}
+
+func getHTTPDMagicLinkFromEnv(idx int) (httpd.MagicLink, bool) {
+	result := defaultHTTPDBinding.MagicLink
+	if len(globalConf.HTTPDConfig.Bindings) > idx {
+		result = globalConf.HTTPDConfig.Bindings[idx].MagicLink
+	}
+	isSet := false
+
+	enabled, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__MAGIC_LINK__ENABLED", idx))
+	if ok {
+		result.Enabled = enabled
+		isSet = true
+	}
+
+	baseURL, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__MAGIC_LINK__BASE_URL", idx))
+	if ok {
+		result.BaseURL = baseURL
+		isSet = true
+	}
+
+	lifespan, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__MAGIC_LINK__LIFESPAN", idx))
+	if ok {
+		result.Lifespan = int(lifespan)
+		isSet = true
+	}
+
+	return result, isSet
+}
+
+func getHTTPDSAMLGroupMappingsFromEnv(idx int, mappings []httpd.SAMLGroupMapping) ([]httpd.SAMLGroupMapping, bool) {
+	isSet := false
+
+	for subIdx := 0; subIdx < 10; subIdx++ {
+		var mapping httpd.SAMLGroupMapping
+		var replace bool
+		if len(mappings) > subIdx {
+			mapping = mappings[subIdx]
+			replace = true
+		}
+		isMappingSet := false
+
+		value, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__GROUP_MAPPINGS__%v__VALUE", idx, subIdx))
+		if ok {
+			mapping.Value = value
+			isMappingSet = true
+		}
+
+		group, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__GROUP_MAPPINGS__%v__GROUP", idx, subIdx))
+		if ok {
+			mapping.Group = group
+			isMappingSet = true
+		}
+
+		groupType, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__GROUP_MAPPINGS__%v__TYPE", idx, subIdx))
+		if ok {
+			mapping.Type = int(groupType)
+			isMappingSet = true
+		}
+
+		if isMappingSet {
+			if replace {
+				mappings[subIdx] = mapping
+			} else {
+				mappings = append(mappings, mapping)
+			}
+			isSet = true
+		}
+	}
+
+	return mappings, isSet
+}
+
+func getHTTPDSAMLFromEnv(idx int) (httpd.SAML, bool) {
+	result := defaultHTTPDBinding.SAML
+	if len(globalConf.HTTPDConfig.Bindings) > idx {
+		result = globalConf.HTTPDConfig.Bindings[idx].SAML
+	}
+	isSet := false
+
+	idpMetadataURL, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__IDP_METADATA_URL", idx))
+	if ok {
+		result.IDPMetadataURL = idpMetadataURL
+		isSet = true
+	}
+
+	idpMetadataFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__IDP_METADATA_FILE", idx))
+	if ok {
+		result.IDPMetadataFile = idpMetadataFile
+		isSet = true
+	}
+
+	baseURL, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__BASE_URL", idx))
+	if ok {
+		result.BaseURL = baseURL
+		isSet = true
+	}
+
+	entityID, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__ENTITY_ID", idx))
+	if ok {
+		result.EntityID = entityID
+		isSet = true
+	}
+
+	certificateFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__CERTIFICATE_FILE", idx))
+	if ok {
+		result.CertificateFile = certificateFile
+		isSet = true
+	}
+
+	certificateKeyFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__CERTIFICATE_KEY_FILE", idx))
+	if ok {
+		result.CertificateKeyFile = certificateKeyFile
+		isSet = true
+	}
+
+	usernameAttribute, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__USERNAME_ATTRIBUTE", idx))
+	if ok {
+		result.UsernameAttribute = usernameAttribute
+		isSet = true
+	}
+
+	roleAttribute, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__ROLE_ATTRIBUTE", idx))
+	if ok {
+		result.RoleAttribute = roleAttribute
+		isSet = true
+	}
+
+	implicitRoles, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__IMPLICIT_ROLES", idx))
+	if ok {
+		result.ImplicitRoles = implicitRoles
+		isSet = true
+	}
+
+	groupAttribute, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__GROUP_ATTRIBUTE", idx))
+	if ok {
+		result.GroupAttribute = groupAttribute
+		isSet = true
+	}
+
+	groupMappings, ok := getHTTPDSAMLGroupMappingsFromEnv(idx, result.GroupMappings)
+	if ok {
+		result.GroupMappings = groupMappings
+		isSet = true
+	}
+
+	customAttributes, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__CUSTOM_ATTRIBUTES", idx))
+	if ok {
+		result.CustomAttributes = customAttributes
+		isSet = true
+	}
+
+	autoProvisioning, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__AUTO_PROVISIONING", idx))
+	if ok {
+		result.AutoProvisioning = autoProvisioning
+		isSet = true
+	}
+
+	homeDirTemplate, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__HOME_DIR_TEMPLATE", idx))
+	if ok {
+		result.HomeDirTemplate = homeDirTemplate
+		isSet = true
+	}
+
+	permissions, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__PERMISSIONS", idx))
+	if ok {
+		result.Permissions = permissions
+		isSet = true
+	}
+
+	debug, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__DEBUG", idx))
+	if ok {
+		result.Debug = debug
+		isSet = true
+	}
+
 	return result, isSet
 }
 
@@ -1681,12 +2719,30 @@ func getHTTPDNestedObjectsFromEnv(idx int, binding *httpd.Binding) bool {
 		isSet = true
 	}
 
+	saml, ok := getHTTPDSAMLFromEnv(idx)
+	if ok {
+		binding.SAML = saml
+		isSet = true
+	}
+
+	magicLink, ok := getHTTPDMagicLinkFromEnv(idx)
+	if ok {
+		binding.MagicLink = magicLink
+		isSet = true
+	}
+
 	securityConf, ok := getHTTPDSecurityConfFromEnv(idx)
 	if ok {
 		binding.Security = securityConf
 		isSet = true
 	}
 
+	corsConf, ok := getHTTPDCorsFromEnv(idx)
+	if ok {
+		binding.Cors = corsConf
+		isSet = true
+	}
+
 	brandingConf, ok := getHTTPDBrandingFromEnv(idx)
 	if ok {
 		binding.Branding = brandingConf
@@ -1784,6 +2840,12 @@ func getHTTPDBindingFromEnv(idx int) { //nolint:gocyclo
 		isSet = true
 	}
 
+	enableHTTP3, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__ENABLE_HTTP3", idx))
+	if ok {
+		binding.EnableHTTP3 = enableHTTP3
+		isSet = true
+	}
+
 	tlsVer, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__MIN_TLS_VERSION", idx))
 	if ok {
 		binding.MinTLSVersion = int(tlsVer)
@@ -1816,6 +2878,21 @@ func getHTTPDBindingFromEnv(idx int) { //nolint:gocyclo
 		isSet = true
 	}
 
+	if getClientPolicyFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v", idx), &binding.ClientPolicy) {
+		isSet = true
+	}
+
+	if getCountriesFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v", idx), &binding.AllowedCountries,
+		&binding.DeniedCountries) {
+		isSet = true
+	}
+
+	uniformAuthErrors, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__UNIFORM_AUTH_ERRORS", idx))
+	if ok {
+		binding.UniformAuthErrors = uniformAuthErrors
+		isSet = true
+	}
+
 	setHTTPDBinding(isSet, binding, idx)
 }
 
@@ -1884,6 +2961,197 @@ func getHTTPClientHeadersFromEnv(idx int) {
 	}
 }
 
+func getDNSStaticHostsFromEnv(idx int) {
+	staticHost := resolver.StaticHost{}
+	if len(globalConf.DNSConfig.StaticHosts) > idx {
+		staticHost = globalConf.DNSConfig.StaticHosts[idx]
+	}
+
+	host, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DNS__STATIC_HOSTS__%v__HOST", idx))
+	if ok {
+		staticHost.Host = host
+	}
+
+	addresses, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_DNS__STATIC_HOSTS__%v__ADDRESSES", idx))
+	if ok {
+		staticHost.Addresses = addresses
+	}
+
+	if staticHost.Host != "" {
+		if len(globalConf.DNSConfig.StaticHosts) > idx {
+			globalConf.DNSConfig.StaticHosts[idx] = staticHost
+		} else {
+			globalConf.DNSConfig.StaticHosts = append(globalConf.DNSConfig.StaticHosts, staticHost)
+		}
+	}
+}
+
+func getLDAPGroupMappingsFromEnv(idx int) {
+	mapping := dataprovider.LDAPGroupMapping{}
+	if len(globalConf.ProviderConf.LDAP.GroupMappings) > idx {
+		mapping = globalConf.ProviderConf.LDAP.GroupMappings[idx]
+	}
+	isSet := false
+
+	ldapGroup, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP__GROUP_MAPPINGS__%v__LDAP_GROUP", idx))
+	if ok {
+		mapping.LDAPGroup = ldapGroup
+		isSet = true
+	}
+
+	group, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP__GROUP_MAPPINGS__%v__GROUP", idx))
+	if ok {
+		mapping.Group = group
+		isSet = true
+	}
+
+	groupType, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP__GROUP_MAPPINGS__%v__TYPE", idx))
+	if ok {
+		mapping.Type = int(groupType)
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.ProviderConf.LDAP.GroupMappings) > idx {
+			globalConf.ProviderConf.LDAP.GroupMappings[idx] = mapping
+		} else {
+			globalConf.ProviderConf.LDAP.GroupMappings = append(globalConf.ProviderConf.LDAP.GroupMappings, mapping)
+		}
+	}
+}
+
+func getPasswordPoliciesFromEnv(idx int) {
+	policy := dataprovider.PasswordPolicy{}
+	if len(globalConf.ProviderConf.PasswordPolicies) > idx {
+		policy = globalConf.ProviderConf.PasswordPolicies[idx]
+	}
+	isSet := false
+
+	name, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__%v__NAME", idx))
+	if ok {
+		policy.Name = name
+		isSet = true
+	}
+
+	minLength, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__%v__MIN_LENGTH", idx))
+	if ok {
+		policy.MinLength = int(minLength)
+		isSet = true
+	}
+
+	requireUppercase, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__%v__REQUIRE_UPPERCASE", idx))
+	if ok {
+		policy.RequireUppercase = requireUppercase
+		isSet = true
+	}
+
+	requireLowercase, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__%v__REQUIRE_LOWERCASE", idx))
+	if ok {
+		policy.RequireLowercase = requireLowercase
+		isSet = true
+	}
+
+	requireDigit, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__%v__REQUIRE_DIGIT", idx))
+	if ok {
+		policy.RequireDigit = requireDigit
+		isSet = true
+	}
+
+	requireSpecial, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__%v__REQUIRE_SPECIAL", idx))
+	if ok {
+		policy.RequireSpecial = requireSpecial
+		isSet = true
+	}
+
+	dictionaryFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__%v__DICTIONARY_FILE", idx))
+	if ok {
+		policy.DictionaryFile = dictionaryFile
+		isSet = true
+	}
+
+	breachCheckURL, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__%v__BREACH_CHECK_URL", idx))
+	if ok {
+		policy.BreachCheckURL = breachCheckURL
+		isSet = true
+	}
+
+	expirationDays, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__%v__EXPIRATION_DAYS", idx))
+	if ok {
+		policy.ExpirationDays = int(expirationDays)
+		isSet = true
+	}
+
+	history, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__%v__HISTORY", idx))
+	if ok {
+		policy.History = int(history)
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.ProviderConf.PasswordPolicies) > idx {
+			globalConf.ProviderConf.PasswordPolicies[idx] = policy
+		} else {
+			globalConf.ProviderConf.PasswordPolicies = append(globalConf.ProviderConf.PasswordPolicies, policy)
+		}
+	}
+}
+
+func getKerberosPrincipalMappingsFromEnv(idx int) {
+	mapping := sftpd.KerberosPrincipalMapping{}
+	if len(globalConf.SFTPD.Kerberos.PrincipalMappings) > idx {
+		mapping = globalConf.SFTPD.Kerberos.PrincipalMappings[idx]
+	}
+	isSet := false
+
+	pattern, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SFTPD__KERBEROS__PRINCIPAL_MAPPINGS__%v__PATTERN", idx))
+	if ok {
+		mapping.Pattern = pattern
+		isSet = true
+	}
+
+	username, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SFTPD__KERBEROS__PRINCIPAL_MAPPINGS__%v__USERNAME", idx))
+	if ok {
+		mapping.Username = username
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.SFTPD.Kerberos.PrincipalMappings) > idx {
+			globalConf.SFTPD.Kerberos.PrincipalMappings[idx] = mapping
+		} else {
+			globalConf.SFTPD.Kerberos.PrincipalMappings = append(globalConf.SFTPD.Kerberos.PrincipalMappings, mapping)
+		}
+	}
+}
+
+func getUserCAPrincipalMappingsFromEnv(idx int) {
+	mapping := sftpd.UserCAPrincipalMapping{}
+	if len(globalConf.SFTPD.UserCA.PrincipalMappings) > idx {
+		mapping = globalConf.SFTPD.UserCA.PrincipalMappings[idx]
+	}
+	isSet := false
+
+	group, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SFTPD__USER_CA__PRINCIPAL_MAPPINGS__%v__GROUP", idx))
+	if ok {
+		mapping.Group = group
+		isSet = true
+	}
+
+	principals, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_SFTPD__USER_CA__PRINCIPAL_MAPPINGS__%v__PRINCIPALS", idx))
+	if ok {
+		mapping.Principals = principals
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.SFTPD.UserCA.PrincipalMappings) > idx {
+			globalConf.SFTPD.UserCA.PrincipalMappings[idx] = mapping
+		} else {
+			globalConf.SFTPD.UserCA.PrincipalMappings = append(globalConf.SFTPD.UserCA.PrincipalMappings, mapping)
+		}
+	}
+}
+
 func getCommandConfigsFromEnv(idx int) {
 	cfg := command.Command{}
 	if len(globalConf.CommandConfig.Commands) > idx {
@@ -1921,6 +3189,7 @@ func setViperDefaults() {
 	viper.SetDefault("common.actions.execute_sync", globalConf.Common.Actions.ExecuteSync)
 	viper.SetDefault("common.actions.hook", globalConf.Common.Actions.Hook)
 	viper.SetDefault("common.setstat_mode", globalConf.Common.SetstatMode)
+	viper.SetDefault("common.rename_mode", globalConf.Common.RenameMode)
 	viper.SetDefault("common.temp_path", globalConf.Common.TempPath)
 	viper.SetDefault("common.proxy_protocol", globalConf.Common.ProxyProtocol)
 	viper.SetDefault("common.proxy_allowed", globalConf.Common.ProxyAllowed)
@@ -1946,6 +3215,42 @@ func setViperDefaults() {
 	viper.SetDefault("common.defender.blocklist_file", globalConf.Common.DefenderConfig.BlockListFile)
 	viper.SetDefault("common.defender.safelist", globalConf.Common.DefenderConfig.SafeList)
 	viper.SetDefault("common.defender.blocklist", globalConf.Common.DefenderConfig.BlockList)
+	viper.SetDefault("common.defender.rollout.nodes", globalConf.Common.DefenderConfig.Rollout.Nodes)
+	viper.SetDefault("common.defender.rollout.percentage", globalConf.Common.DefenderConfig.Rollout.Percentage)
+	viper.SetDefault("common.geoip.database_path", globalConf.Common.GeoIP.DatabasePath)
+	viper.SetDefault("common.latency_throttling.mode", globalConf.Common.LatencyThrottling.Mode)
+	viper.SetDefault("common.latency_throttling.delay", globalConf.Common.LatencyThrottling.Delay)
+	viper.SetDefault("common.latency_throttling.s3_put_threshold", globalConf.Common.LatencyThrottling.S3PutThreshold)
+	viper.SetDefault("common.latency_throttling.data_provider_threshold", globalConf.Common.LatencyThrottling.DataProviderThreshold)
+	viper.SetDefault("common.latency_throttling.window", globalConf.Common.LatencyThrottling.Window)
+	viper.SetDefault("common.latency_throttling.min_samples", globalConf.Common.LatencyThrottling.MinSamples)
+	viper.SetDefault("common.latency_throttling.recovery_percent", globalConf.Common.LatencyThrottling.RecoveryPercent)
+	viper.SetDefault("common.temp_files_cleanup.interval", globalConf.Common.TempFilesCleanup.Interval)
+	viper.SetDefault("common.temp_files_cleanup.max_age", globalConf.Common.TempFilesCleanup.MaxAge)
+	viper.SetDefault("common.temp_files_cleanup.scan_storages", globalConf.Common.TempFilesCleanup.ScanStorages)
+	viper.SetDefault("common.resource_limits.max_rss", globalConf.Common.ResourceLimits.MaxRSS)
+	viper.SetDefault("common.resource_limits.max_goroutines", globalConf.Common.ResourceLimits.MaxGoroutines)
+	viper.SetDefault("common.resource_limits.check_interval", globalConf.Common.ResourceLimits.CheckInterval)
+	viper.SetDefault("common.read_cache.path", globalConf.Common.ReadCache.Path)
+	viper.SetDefault("common.read_cache.max_size", globalConf.Common.ReadCache.MaxSize)
+	viper.SetDefault("common.read_cache.max_object_size", globalConf.Common.ReadCache.MaxObjectSize)
+	viper.SetDefault("common.read_cache.ttl", globalConf.Common.ReadCache.TTL)
+	viper.SetDefault("common.read_cache.consistency_mode", globalConf.Common.ReadCache.ConsistencyMode)
+	viper.SetDefault("common.multipart_uploads.max_part_size", globalConf.Common.MultipartUploads.MaxPartSize)
+	viper.SetDefault("common.multipart_uploads.max_concurrency", globalConf.Common.MultipartUploads.MaxConcurrency)
+	viper.SetDefault("common.multipart_uploads.auto_tune", globalConf.Common.MultipartUploads.AutoTune)
+	viper.SetDefault("common.zero_copy_downloads.enabled", globalConf.Common.ZeroCopyDownloads.Enabled)
+	viper.SetDefault("common.zero_copy_downloads.min_bandwidth", globalConf.Common.ZeroCopyDownloads.MinBandwidth)
+	viper.SetDefault("common.icap.url", globalConf.Common.ICAP.URL)
+	viper.SetDefault("common.icap.method", globalConf.Common.ICAP.Method)
+	viper.SetDefault("common.icap.timeout", globalConf.Common.ICAP.Timeout)
+	viper.SetDefault("common.icap.max_size", globalConf.Common.ICAP.MaxSize)
+	viper.SetDefault("common.icap.skip_tls_verify", globalConf.Common.ICAP.SkipTLSVerify)
+	viper.SetDefault("common.icap.action", globalConf.Common.ICAP.Action)
+	viper.SetDefault("common.icap.quarantine_path", globalConf.Common.ICAP.QuarantinePath)
+	viper.SetDefault("common.icap.disable_user", globalConf.Common.ICAP.DisableUser)
+	viper.SetDefault("common.icap.notify_emails", globalConf.Common.ICAP.NotifyEmails)
+	viper.SetDefault("common.icap.reject_on_error", globalConf.Common.ICAP.RejectOnError)
 	viper.SetDefault("acme.email", globalConf.ACME.Email)
 	viper.SetDefault("acme.key_type", globalConf.ACME.KeyType)
 	viper.SetDefault("acme.certs_path", globalConf.ACME.CertsPath)
@@ -1972,6 +3277,14 @@ func setViperDefaults() {
 	viper.SetDefault("sftpd.keyboard_interactive_auth_hook", globalConf.SFTPD.KeyboardInteractiveHook)
 	viper.SetDefault("sftpd.password_authentication", globalConf.SFTPD.PasswordAuthentication)
 	viper.SetDefault("sftpd.folder_prefix", globalConf.SFTPD.FolderPrefix)
+	viper.SetDefault("sftpd.copy_extensions", globalConf.SFTPD.CopyExtensions)
+	viper.SetDefault("sftpd.custom_metadata", globalConf.SFTPD.CustomMetadata)
+	viper.SetDefault("sftpd.kerberos.keytab", globalConf.SFTPD.Kerberos.Keytab)
+	viper.SetDefault("sftpd.kerberos.service_principal", globalConf.SFTPD.Kerberos.ServicePrincipal)
+	viper.SetDefault("sftpd.kerberos.max_clock_skew", globalConf.SFTPD.Kerberos.MaxClockSkew)
+	viper.SetDefault("sftpd.user_ca.private_key", globalConf.SFTPD.UserCA.PrivateKey)
+	viper.SetDefault("sftpd.user_ca.default_ttl", globalConf.SFTPD.UserCA.DefaultTTL)
+	viper.SetDefault("sftpd.user_ca.max_ttl", globalConf.SFTPD.UserCA.MaxTTL)
 	viper.SetDefault("ftpd.banner", globalConf.FTPD.Banner)
 	viper.SetDefault("ftpd.banner_file", globalConf.FTPD.BannerFile)
 	viper.SetDefault("ftpd.active_transfers_port_non_20", globalConf.FTPD.ActiveTransfersPortNon20)
@@ -2003,6 +3316,13 @@ func setViperDefaults() {
 	viper.SetDefault("webdavd.cache.users.max_size", globalConf.WebDAVD.Cache.Users.MaxSize)
 	viper.SetDefault("webdavd.cache.mime_types.enabled", globalConf.WebDAVD.Cache.MimeTypes.Enabled)
 	viper.SetDefault("webdavd.cache.mime_types.max_size", globalConf.WebDAVD.Cache.MimeTypes.MaxSize)
+	viper.SetDefault("webdavd.locks.driver", globalConf.WebDAVD.Locks.Driver)
+	viper.SetDefault("webdavd.locks.max_timeout", globalConf.WebDAVD.Locks.MaxTimeout)
+	viper.SetDefault("webdavd.custom_metadata", globalConf.WebDAVD.CustomMetadata)
+	viper.SetDefault("s3d.certificate_file", globalConf.S3D.CertificateFile)
+	viper.SetDefault("s3d.certificate_key_file", globalConf.S3D.CertificateKeyFile)
+	viper.SetDefault("s3d.region", globalConf.S3D.Region)
+	viper.SetDefault("s3d.multipart_expiration", globalConf.S3D.MultipartExpiration)
 	viper.SetDefault("data_provider.driver", globalConf.ProviderConf.Driver)
 	viper.SetDefault("data_provider.name", globalConf.ProviderConf.Name)
 	viper.SetDefault("data_provider.host", globalConf.ProviderConf.Host)
@@ -2047,6 +3367,37 @@ func setViperDefaults() {
 	viper.SetDefault("data_provider.node.port", globalConf.ProviderConf.Node.Port)
 	viper.SetDefault("data_provider.node.proto", globalConf.ProviderConf.Node.Proto)
 	viper.SetDefault("data_provider.backups_path", globalConf.ProviderConf.BackupsPath)
+	viper.SetDefault("data_provider.soft_delete_retention", globalConf.ProviderConf.SoftDeleteRetention)
+	viper.SetDefault("data_provider.circuit_breaker.failure_threshold", globalConf.ProviderConf.CircuitBreaker.FailureThreshold)
+	viper.SetDefault("data_provider.circuit_breaker.slow_query_threshold", globalConf.ProviderConf.CircuitBreaker.SlowQueryThreshold)
+	viper.SetDefault("data_provider.circuit_breaker.open_timeout", globalConf.ProviderConf.CircuitBreaker.OpenTimeout)
+	viper.SetDefault("data_provider.circuit_breaker.read_only_cache_ttl", globalConf.ProviderConf.CircuitBreaker.ReadOnlyCacheTTL)
+	viper.SetDefault("data_provider.read_replicas.connection_strings", globalConf.ProviderConf.ReadReplicas.ConnectionStrings)
+	viper.SetDefault("data_provider.read_replicas.pool_size", globalConf.ProviderConf.ReadReplicas.PoolSize)
+	viper.SetDefault("data_provider.read_replicas.max_lag", globalConf.ProviderConf.ReadReplicas.MaxLag)
+	viper.SetDefault("data_provider.audit.enabled", globalConf.ProviderConf.Audit.Enabled)
+	viper.SetDefault("data_provider.audit.retention_days", globalConf.ProviderConf.Audit.RetentionDays)
+	viper.SetDefault("data_provider.dead_letters.enabled", globalConf.ProviderConf.DeadLetters.Enabled)
+	viper.SetDefault("data_provider.dead_letters.retention_days", globalConf.ProviderConf.DeadLetters.RetentionDays)
+	viper.SetDefault("data_provider.ldap.url", globalConf.ProviderConf.LDAP.URL)
+	viper.SetDefault("data_provider.ldap.start_tls", globalConf.ProviderConf.LDAP.StartTLS)
+	viper.SetDefault("data_provider.ldap.skip_tls_verify", globalConf.ProviderConf.LDAP.SkipTLSVerify)
+	viper.SetDefault("data_provider.ldap.bind_dn", globalConf.ProviderConf.LDAP.BindDN)
+	viper.SetDefault("data_provider.ldap.bind_password", globalConf.ProviderConf.LDAP.BindPassword)
+	viper.SetDefault("data_provider.ldap.base_dn", globalConf.ProviderConf.LDAP.BaseDN)
+	viper.SetDefault("data_provider.ldap.user_filter", globalConf.ProviderConf.LDAP.UserFilter)
+	viper.SetDefault("data_provider.ldap.group_attribute", globalConf.ProviderConf.LDAP.GroupAttribute)
+	viper.SetDefault("data_provider.ldap.email_attribute", globalConf.ProviderConf.LDAP.EmailAttribute)
+	viper.SetDefault("data_provider.ldap.home_dir_template", globalConf.ProviderConf.LDAP.HomeDirTemplate)
+	viper.SetDefault("data_provider.ldap.permissions", globalConf.ProviderConf.LDAP.Permissions)
+	viper.SetDefault("data_provider.ldap.timeout", globalConf.ProviderConf.LDAP.Timeout)
+	viper.SetDefault("data_provider.ldap.cache_ttl", globalConf.ProviderConf.LDAP.CacheTTL)
+	viper.SetDefault("data_provider.cache_invalidation.driver", globalConf.ProviderConf.CacheInvalidation.Driver)
+	viper.SetDefault("data_provider.cache_invalidation.url", globalConf.ProviderConf.CacheInvalidation.URL)
+	viper.SetDefault("data_provider.cache_invalidation.channel", globalConf.ProviderConf.CacheInvalidation.Channel)
+	viper.SetDefault("data_provider.sqlite.journal_mode", globalConf.ProviderConf.SQLite.JournalMode)
+	viper.SetDefault("data_provider.sqlite.busy_timeout", globalConf.ProviderConf.SQLite.BusyTimeout)
+	viper.SetDefault("data_provider.sqlite.synchronous", globalConf.ProviderConf.SQLite.Synchronous)
 	viper.SetDefault("httpd.templates_path", globalConf.HTTPDConfig.TemplatesPath)
 	viper.SetDefault("httpd.static_files_path", globalConf.HTTPDConfig.StaticFilesPath)
 	viper.SetDefault("httpd.openapi_path", globalConf.HTTPDConfig.OpenAPIPath)
@@ -2071,14 +3422,49 @@ func setViperDefaults() {
 	viper.SetDefault("httpd.setup.installation_code", globalConf.HTTPDConfig.Setup.InstallationCode)
 	viper.SetDefault("httpd.setup.installation_code_hint", globalConf.HTTPDConfig.Setup.InstallationCodeHint)
 	viper.SetDefault("httpd.hide_support_link", globalConf.HTTPDConfig.HideSupportLink)
+	viper.SetDefault("httpd.approvals.operations", globalConf.HTTPDConfig.Approvals.Operations)
+	viper.SetDefault("httpd.approvals.validity_time", globalConf.HTTPDConfig.Approvals.ValidityTime)
+	viper.SetDefault("httpd.downloads.mime_types", globalConf.HTTPDConfig.Downloads.MimeTypes)
+	viper.SetDefault("httpd.downloads.attachment_extensions", globalConf.HTTPDConfig.Downloads.AttachmentExtensions)
+	viper.SetDefault("httpd.downloads.inline_extensions", globalConf.HTTPDConfig.Downloads.InlineExtensions)
+	viper.SetDefault("httpd.downloads.safe_mode", globalConf.HTTPDConfig.Downloads.SafeMode)
+	viper.SetDefault("httpd.tus.enabled", globalConf.HTTPDConfig.TUS.Enabled)
+	viper.SetDefault("httpd.tus.expiration_time", globalConf.HTTPDConfig.TUS.ExpirationTime)
+	viper.SetDefault("httpd.tus.staging_path", globalConf.HTTPDConfig.TUS.StagingPath)
+	viper.SetDefault("httpd.uploads.buffer_size", globalConf.HTTPDConfig.Uploads.BufferSize)
+	viper.SetDefault("httpd.uploads.memory_budget", globalConf.HTTPDConfig.Uploads.MemoryBudget)
+	viper.SetDefault("httpd.i18n.default_language", globalConf.HTTPDConfig.I18n.DefaultLanguage)
+	viper.SetDefault("httpd.i18n.catalogs_path", globalConf.HTTPDConfig.I18n.CatalogsPath)
+	viper.SetDefault("httpd.compliance.signing_key", globalConf.HTTPDConfig.Compliance.SigningKey)
+	viper.SetDefault("httpd.compliance.retention", globalConf.HTTPDConfig.Compliance.Retention)
+	viper.SetDefault("httpd.grpc.port", globalConf.HTTPDConfig.GRPC.Port)
+	viper.SetDefault("httpd.grpc.address", globalConf.HTTPDConfig.GRPC.Address)
+	viper.SetDefault("httpd.grpc.certificate_file", globalConf.HTTPDConfig.GRPC.CertificateFile)
+	viper.SetDefault("httpd.grpc.certificate_key_file", globalConf.HTTPDConfig.GRPC.CertificateKeyFile)
+	viper.SetDefault("httpd.grpc.min_tls_version", globalConf.HTTPDConfig.GRPC.MinTLSVersion)
+	viper.SetDefault("httpd.grpc.events_buffer_size", globalConf.HTTPDConfig.GRPC.EventsBufferSize)
 	viper.SetDefault("http.timeout", globalConf.HTTPConfig.Timeout)
 	viper.SetDefault("http.retry_wait_min", globalConf.HTTPConfig.RetryWaitMin)
 	viper.SetDefault("http.retry_wait_max", globalConf.HTTPConfig.RetryWaitMax)
 	viper.SetDefault("http.retry_max", globalConf.HTTPConfig.RetryMax)
 	viper.SetDefault("http.ca_certificates", globalConf.HTTPConfig.CACertificates)
 	viper.SetDefault("http.skip_tls_verify", globalConf.HTTPConfig.SkipTLSVerify)
+	viper.SetDefault("http.signature.secret", globalConf.HTTPConfig.Signature.Secret)
+	viper.SetDefault("http.signature.signature_header", globalConf.HTTPConfig.Signature.SignatureHeader)
+	viper.SetDefault("http.signature.timestamp_header", globalConf.HTTPConfig.Signature.TimestampHeader)
+	viper.SetDefault("http.signature.nonce_header", globalConf.HTTPConfig.Signature.NonceHeader)
+	viper.SetDefault("dns.servers", globalConf.DNSConfig.Servers)
+	viper.SetDefault("dns.timeout", globalConf.DNSConfig.Timeout)
+	viper.SetDefault("dns.cache_ttl", globalConf.DNSConfig.CacheTTL)
+	viper.SetDefault("dns.negative_cache_ttl", globalConf.DNSConfig.NegativeCacheTTL)
 	viper.SetDefault("command.timeout", globalConf.CommandConfig.Timeout)
 	viper.SetDefault("command.env", globalConf.CommandConfig.Env)
+	viper.SetDefault("command.limits.cpu_time", globalConf.CommandConfig.Limits.CPUTime)
+	viper.SetDefault("command.limits.max_memory", globalConf.CommandConfig.Limits.MaxMemory)
+	viper.SetDefault("command.limits.max_output_size", globalConf.CommandConfig.Limits.MaxOutputSize)
+	viper.SetDefault("command.limits.working_dir", globalConf.CommandConfig.Limits.WorkingDir)
+	viper.SetDefault("command.limits.env_allowlist", globalConf.CommandConfig.Limits.EnvAllowlist)
+	viper.SetDefault("command.cgroup", globalConf.CommandConfig.Cgroup)
 	viper.SetDefault("kms.secrets.url", globalConf.KMSConfig.Secrets.URL)
 	viper.SetDefault("kms.secrets.master_key", globalConf.KMSConfig.Secrets.MasterKeyString)
 	viper.SetDefault("kms.secrets.master_key_path", globalConf.KMSConfig.Secrets.MasterKeyPath)
@@ -2090,6 +3476,46 @@ func setViperDefaults() {
 	viper.SetDefault("telemetry.certificate_key_file", globalConf.TelemetryConfig.CertificateKeyFile)
 	viper.SetDefault("telemetry.min_tls_version", globalConf.TelemetryConfig.MinTLSVersion)
 	viper.SetDefault("telemetry.tls_cipher_suites", globalConf.TelemetryConfig.TLSCipherSuites)
+	viper.SetDefault("telemetry.push.url", globalConf.TelemetryConfig.Push.URL)
+	viper.SetDefault("telemetry.push.job", globalConf.TelemetryConfig.Push.Job)
+	viper.SetDefault("telemetry.push.interval", globalConf.TelemetryConfig.Push.Interval)
+	viper.SetDefault("telemetry.push.labels", globalConf.TelemetryConfig.Push.Labels)
+	viper.SetDefault("telemetry.push.username", globalConf.TelemetryConfig.Push.Username)
+	viper.SetDefault("telemetry.push.password", globalConf.TelemetryConfig.Push.Password)
+	viper.SetDefault("telemetry.user_metrics.enabled", globalConf.TelemetryConfig.UserMetrics.Enabled)
+	viper.SetDefault("telemetry.user_metrics.max_users", globalConf.TelemetryConfig.UserMetrics.MaxUsers)
+	viper.SetDefault("telemetry.user_metrics.max_folders", globalConf.TelemetryConfig.UserMetrics.MaxFolders)
+	viper.SetDefault("tracing.endpoint", globalConf.TracingConfig.Endpoint)
+	viper.SetDefault("tracing.service_name", globalConf.TracingConfig.ServiceName)
+	viper.SetDefault("tracing.sample_ratio", globalConf.TracingConfig.SampleRatio)
+	viper.SetDefault("tracing.headers", globalConf.TracingConfig.Headers)
+	viper.SetDefault("tracing.export_interval", globalConf.TracingConfig.ExportInterval)
+	viper.SetDefault("tracing.timeout", globalConf.TracingConfig.Timeout)
+	viper.SetDefault("tracing.skip_tls_verify", globalConf.TracingConfig.SkipTLSVerify)
+	viper.SetDefault("audit_log.file_path", globalConf.AuditLogConfig.FilePath)
+	viper.SetDefault("audit_log.events", globalConf.AuditLogConfig.Events)
+	viper.SetDefault("syslog.address", globalConf.SyslogConfig.Address)
+	viper.SetDefault("syslog.network", globalConf.SyslogConfig.Network)
+	viper.SetDefault("syslog.format", globalConf.SyslogConfig.Format)
+	viper.SetDefault("syslog.facility", globalConf.SyslogConfig.Facility)
+	viper.SetDefault("syslog.events", globalConf.SyslogConfig.Events)
+	viper.SetDefault("syslog.field_mapping", globalConf.SyslogConfig.FieldMapping)
+	viper.SetDefault("syslog.skip_tls_verify", globalConf.SyslogConfig.SkipTLSVerify)
+	viper.SetDefault("mfa.webauthn.rp_id", globalConf.MFAConfig.WebAuthn.RPID)
+	viper.SetDefault("mfa.webauthn.rp_display_name", globalConf.MFAConfig.WebAuthn.RPDisplayName)
+	viper.SetDefault("mfa.webauthn.rp_origin", globalConf.MFAConfig.WebAuthn.RPOrigin)
+	viper.SetDefault("mfa.webauthn.resident_key", globalConf.MFAConfig.WebAuthn.ResidentKey)
+	viper.SetDefault("mfa.webauthn.user_verification", globalConf.MFAConfig.WebAuthn.UserVerification)
+	viper.SetDefault("mfa.webauthn.timeout", globalConf.MFAConfig.WebAuthn.Timeout)
+	viper.SetDefault("mfa.admin_policy", globalConf.MFAConfig.AdminPolicy)
+	viper.SetDefault("mfa.recovery_codes.require_reverification", globalConf.MFAConfig.RecoveryCodes.RequireReverification)
+	viper.SetDefault("mfa.recovery_codes.allow_email_recovery", globalConf.MFAConfig.RecoveryCodes.AllowEmailRecovery)
+	viper.SetDefault("mfa.push.provider", globalConf.MFAConfig.Push.Provider)
+	viper.SetDefault("mfa.push.timeout", globalConf.MFAConfig.Push.Timeout)
+	viper.SetDefault("mfa.push.duo.api_hostname", globalConf.MFAConfig.Push.Duo.APIHostname)
+	viper.SetDefault("mfa.push.duo.integration_key", globalConf.MFAConfig.Push.Duo.IntegrationKey)
+	viper.SetDefault("mfa.push.duo.secret_key", globalConf.MFAConfig.Push.Duo.SecretKey)
+	viper.SetDefault("mfa.push.webhook.url", globalConf.MFAConfig.Push.Webhook.URL)
 	viper.SetDefault("smtp.host", globalConf.SMTPConfig.Host)
 	viper.SetDefault("smtp.port", globalConf.SMTPConfig.Port)
 	viper.SetDefault("smtp.from", globalConf.SMTPConfig.From)
@@ -2099,6 +3525,16 @@ func setViperDefaults() {
 	viper.SetDefault("smtp.encryption", globalConf.SMTPConfig.Encryption)
 	viper.SetDefault("smtp.domain", globalConf.SMTPConfig.Domain)
 	viper.SetDefault("smtp.templates_path", globalConf.SMTPConfig.TemplatesPath)
+	viper.SetDefault("smtp.custom_templates_path", globalConf.SMTPConfig.CustomTemplatesPath)
+	viper.SetDefault("smtp.provider", globalConf.SMTPConfig.Provider)
+	viper.SetDefault("smtp.api.key", globalConf.SMTPConfig.API.Key)
+	viper.SetDefault("smtp.api.secret", globalConf.SMTPConfig.API.Secret)
+	viper.SetDefault("smtp.api.region", globalConf.SMTPConfig.API.Region)
+	viper.SetDefault("smtp.api.domain", globalConf.SMTPConfig.API.Domain)
+	viper.SetDefault("smtp.api.endpoint", globalConf.SMTPConfig.API.Endpoint)
+	viper.SetDefault("smtp.max_emails_per_minute", globalConf.SMTPConfig.MaxEmailsPerMinute)
+	viper.SetDefault("smtp.digest_interval", globalConf.SMTPConfig.DigestInterval)
+	viper.SetDefault("smtp.max_attachments_size", globalConf.SMTPConfig.MaxAttachmentsSize)
 }
 
 func lookupBoolFromEnv(envName string) (bool, bool) {
@@ -35,9 +35,11 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/httpd"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/resolver"
 	"github.com/drakkan/sftpgo/v2/internal/sftpd"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/webdavd"
 )
 
 const (
@@ -88,7 +90,7 @@ func TestLoadConfigFileNotFound(t *testing.T) {
 	mfaConf := config.GetMFAConfig()
 	require.Len(t, mfaConf.TOTP, 1)
 	require.Len(t, config.GetCommonConfig().RateLimitersConfig, 1)
-	require.Len(t, config.GetCommonConfig().RateLimitersConfig[0].Protocols, 4)
+	require.Len(t, config.GetCommonConfig().RateLimitersConfig[0].Protocols, 5)
 	require.Len(t, config.GetHTTPDConfig().Bindings, 1)
 	require.Len(t, config.GetHTTPDConfig().Bindings[0].OIDC.Scopes, 3)
 }
@@ -116,6 +118,38 @@ func TestReadEnvFiles(t *testing.T) {
 	os.RemoveAll(envd)
 }
 
+func TestWriteConfig(t *testing.T) {
+	reset()
+
+	confName := tempConfigName + ".json"
+	configFilePath := filepath.Join(configDir, confName)
+	providerConf := config.GetProviderConf()
+	providerConf.Driver = dataprovider.BoltDataProviderName
+	providerConf.Name = "sftpgo.bolt"
+	config.SetProviderConf(providerConf)
+	sftpdConf := config.GetSFTPDConfig()
+	sftpdConf.HostKeys = []string{"id_ed25519"}
+	config.SetSFTPDConfig(sftpdConf)
+	err := config.WriteConfig(configFilePath)
+	assert.NoError(t, err)
+
+	reset()
+
+	err = config.LoadConfig(configDir, confName)
+	assert.NoError(t, err)
+	assert.Equal(t, dataprovider.BoltDataProviderName, config.GetProviderConf().Driver)
+	assert.Equal(t, "sftpgo.bolt", config.GetProviderConf().Name)
+	assert.Equal(t, []string{"id_ed25519"}, config.GetSFTPDConfig().HostKeys)
+	assert.Len(t, config.GetHTTPDConfig().Bindings, 1)
+	assert.NotEmpty(t, strings.TrimSpace(config.GetSFTPDConfig().Banner))
+
+	err = config.WriteConfig(filepath.Join(configDir, "missing", confName))
+	assert.Error(t, err)
+
+	err = os.Remove(configFilePath)
+	assert.NoError(t, err)
+}
+
 func TestEmptyBanner(t *testing.T) {
 	reset()
 
@@ -323,6 +357,45 @@ func TestDefenderProviderDriver(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestWebDAVLocksProviderDriver(t *testing.T) {
+	if config.GetProviderConf().Driver != dataprovider.SQLiteDataProviderName {
+		t.Skip("this test is not supported with the current database provider")
+	}
+	reset()
+
+	confName := tempConfigName + ".json"
+	configFilePath := filepath.Join(configDir, confName)
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	assert.Equal(t, webdavd.LockDriverMemory, config.GetWebDAVDConfig().Locks.Driver)
+	assert.Equal(t, 0, config.GetWebDAVDConfig().Locks.MaxTimeout)
+	os.Setenv("SFTPGO_WEBDAVD__LOCKS__DRIVER", webdavd.LockDriverProvider)
+	os.Setenv("SFTPGO_WEBDAVD__LOCKS__MAX_TIMEOUT", "3600")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_WEBDAVD__LOCKS__DRIVER")
+		os.Unsetenv("SFTPGO_WEBDAVD__LOCKS__MAX_TIMEOUT")
+	})
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	assert.Equal(t, webdavd.LockDriverProvider, config.GetWebDAVDConfig().Locks.Driver)
+	assert.Equal(t, 3600, config.GetWebDAVDConfig().Locks.MaxTimeout)
+
+	providerConf := config.GetProviderConf()
+	providerConf.Driver = dataprovider.BoltDataProviderName
+	c := make(map[string]any)
+	c["data_provider"] = providerConf
+	jsonConf, err := json.Marshal(c)
+	assert.NoError(t, err)
+	err = os.WriteFile(configFilePath, jsonConf, os.ModePerm)
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, confName)
+	assert.NoError(t, err)
+	assert.Equal(t, dataprovider.BoltDataProviderName, config.GetProviderConf().Driver)
+	assert.Equal(t, webdavd.LockDriverMemory, config.GetWebDAVDConfig().Locks.Driver)
+	err = os.Remove(configFilePath)
+	assert.NoError(t, err)
+}
+
 func TestSetGetConfig(t *testing.T) {
 	reset()
 
@@ -409,8 +482,12 @@ func TestSSHCommandsFromEnv(t *testing.T) {
 	reset()
 
 	os.Setenv("SFTPGO_SFTPD__ENABLED_SSH_COMMANDS", "cd,scp")
+	os.Setenv("SFTPGO_SFTPD__COPY_EXTENSIONS", "true")
+	os.Setenv("SFTPGO_SFTPD__CUSTOM_METADATA", "true")
 	t.Cleanup(func() {
 		os.Unsetenv("SFTPGO_SFTPD__ENABLED_SSH_COMMANDS")
+		os.Unsetenv("SFTPGO_SFTPD__COPY_EXTENSIONS")
+		os.Unsetenv("SFTPGO_SFTPD__CUSTOM_METADATA")
 	})
 
 	err := config.LoadConfig(configDir, "")
@@ -421,6 +498,8 @@ func TestSSHCommandsFromEnv(t *testing.T) {
 		assert.Equal(t, "cd", sftpdConf.EnabledSSHCommands[0])
 		assert.Equal(t, "scp", sftpdConf.EnabledSSHCommands[1])
 	}
+	assert.True(t, sftpdConf.CopyExtensions)
+	assert.True(t, sftpdConf.CustomMetadata)
 }
 
 func TestSMTPFromEnv(t *testing.T) {
@@ -428,9 +507,21 @@ func TestSMTPFromEnv(t *testing.T) {
 
 	os.Setenv("SFTPGO_SMTP__HOST", "smtp.example.com")
 	os.Setenv("SFTPGO_SMTP__PORT", "587")
+	os.Setenv("SFTPGO_SMTP__PROVIDER", smtp.ProviderMailgun)
+	os.Setenv("SFTPGO_SMTP__API__KEY", "key")
+	os.Setenv("SFTPGO_SMTP__API__DOMAIN", "mg.example.com")
+	os.Setenv("SFTPGO_SMTP__MAX_EMAILS_PER_MINUTE", "30")
+	os.Setenv("SFTPGO_SMTP__DIGEST_INTERVAL", "300")
+	os.Setenv("SFTPGO_SMTP__MAX_ATTACHMENTS_SIZE", "25")
 	t.Cleanup(func() {
 		os.Unsetenv("SFTPGO_SMTP__HOST")
 		os.Unsetenv("SFTPGO_SMTP__PORT")
+		os.Unsetenv("SFTPGO_SMTP__PROVIDER")
+		os.Unsetenv("SFTPGO_SMTP__API__KEY")
+		os.Unsetenv("SFTPGO_SMTP__API__DOMAIN")
+		os.Unsetenv("SFTPGO_SMTP__MAX_EMAILS_PER_MINUTE")
+		os.Unsetenv("SFTPGO_SMTP__DIGEST_INTERVAL")
+		os.Unsetenv("SFTPGO_SMTP__MAX_ATTACHMENTS_SIZE")
 	})
 
 	err := config.LoadConfig(configDir, "")
@@ -438,6 +529,13 @@ func TestSMTPFromEnv(t *testing.T) {
 	smtpConfig := config.GetSMTPConfig()
 	assert.Equal(t, "smtp.example.com", smtpConfig.Host)
 	assert.Equal(t, 587, smtpConfig.Port)
+	assert.Equal(t, smtp.ProviderMailgun, smtpConfig.Provider)
+	assert.Equal(t, "key", smtpConfig.API.Key)
+	assert.Equal(t, "mg.example.com", smtpConfig.API.Domain)
+	assert.Empty(t, smtpConfig.API.Region)
+	assert.Equal(t, 30, smtpConfig.MaxEmailsPerMinute)
+	assert.Equal(t, 300, smtpConfig.DigestInterval)
+	assert.Equal(t, 25, smtpConfig.MaxAttachmentsSize)
 }
 
 func TestMFAFromEnv(t *testing.T) {
@@ -447,11 +545,37 @@ func TestMFAFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_MFA__TOTP__1__NAME", "additional_name")
 	os.Setenv("SFTPGO_MFA__TOTP__1__ISSUER", "additional_issuer")
 	os.Setenv("SFTPGO_MFA__TOTP__1__ALGO", "sha256")
+	os.Setenv("SFTPGO_MFA__WEBAUTHN__RP_ID", "sftp.example.com")
+	os.Setenv("SFTPGO_MFA__WEBAUTHN__RP_ORIGIN", "https://sftp.example.com:8443")
+	os.Setenv("SFTPGO_MFA__WEBAUTHN__RESIDENT_KEY", "preferred")
+	os.Setenv("SFTPGO_MFA__WEBAUTHN__TIMEOUT", "120")
+	os.Setenv("SFTPGO_MFA__ADMIN_POLICY", "2")
+	os.Setenv("SFTPGO_MFA__RECOVERY_CODES__REQUIRE_REVERIFICATION", "true")
+	os.Setenv("SFTPGO_MFA__RECOVERY_CODES__ALLOW_EMAIL_RECOVERY", "true")
+	os.Setenv("SFTPGO_MFA__PUSH__PROVIDER", "duo")
+	os.Setenv("SFTPGO_MFA__PUSH__TIMEOUT", "30")
+	os.Setenv("SFTPGO_MFA__PUSH__DUO__API_HOSTNAME", "api-test.duosecurity.com")
+	os.Setenv("SFTPGO_MFA__PUSH__DUO__INTEGRATION_KEY", "ikey")
+	os.Setenv("SFTPGO_MFA__PUSH__DUO__SECRET_KEY", "skey")
+	os.Setenv("SFTPGO_MFA__PUSH__WEBHOOK__URL", "http://127.0.0.1:8000/approve")
 	t.Cleanup(func() {
 		os.Unsetenv("SFTPGO_MFA__TOTP__0__NAME")
 		os.Unsetenv("SFTPGO_MFA__TOTP__1__NAME")
 		os.Unsetenv("SFTPGO_MFA__TOTP__1__ISSUER")
 		os.Unsetenv("SFTPGO_MFA__TOTP__1__ALGO")
+		os.Unsetenv("SFTPGO_MFA__WEBAUTHN__RP_ID")
+		os.Unsetenv("SFTPGO_MFA__WEBAUTHN__RP_ORIGIN")
+		os.Unsetenv("SFTPGO_MFA__WEBAUTHN__RESIDENT_KEY")
+		os.Unsetenv("SFTPGO_MFA__WEBAUTHN__TIMEOUT")
+		os.Unsetenv("SFTPGO_MFA__ADMIN_POLICY")
+		os.Unsetenv("SFTPGO_MFA__RECOVERY_CODES__REQUIRE_REVERIFICATION")
+		os.Unsetenv("SFTPGO_MFA__RECOVERY_CODES__ALLOW_EMAIL_RECOVERY")
+		os.Unsetenv("SFTPGO_MFA__PUSH__PROVIDER")
+		os.Unsetenv("SFTPGO_MFA__PUSH__TIMEOUT")
+		os.Unsetenv("SFTPGO_MFA__PUSH__DUO__API_HOSTNAME")
+		os.Unsetenv("SFTPGO_MFA__PUSH__DUO__INTEGRATION_KEY")
+		os.Unsetenv("SFTPGO_MFA__PUSH__DUO__SECRET_KEY")
+		os.Unsetenv("SFTPGO_MFA__PUSH__WEBHOOK__URL")
 	})
 
 	err := config.LoadConfig(configDir, "")
@@ -464,6 +588,20 @@ func TestMFAFromEnv(t *testing.T) {
 	require.Equal(t, "additional_name", mfaConf.TOTP[1].Name)
 	require.Equal(t, "additional_issuer", mfaConf.TOTP[1].Issuer)
 	require.Equal(t, "sha256", mfaConf.TOTP[1].Algo)
+	require.Equal(t, "sftp.example.com", mfaConf.WebAuthn.RPID)
+	require.Equal(t, "https://sftp.example.com:8443", mfaConf.WebAuthn.RPOrigin)
+	require.Equal(t, "preferred", mfaConf.WebAuthn.ResidentKey)
+	require.Empty(t, mfaConf.WebAuthn.UserVerification)
+	require.Equal(t, 120, mfaConf.WebAuthn.Timeout)
+	require.Equal(t, 2, mfaConf.AdminPolicy)
+	require.True(t, mfaConf.RecoveryCodes.RequireReverification)
+	require.True(t, mfaConf.RecoveryCodes.AllowEmailRecovery)
+	require.Equal(t, "duo", mfaConf.Push.Provider)
+	require.Equal(t, 30, mfaConf.Push.Timeout)
+	require.Equal(t, "api-test.duosecurity.com", mfaConf.Push.Duo.APIHostname)
+	require.Equal(t, "ikey", mfaConf.Push.Duo.IntegrationKey)
+	require.Equal(t, "skey", mfaConf.Push.Duo.SecretKey)
+	require.Equal(t, "http://127.0.0.1:8000/approve", mfaConf.Push.Webhook.URL)
 }
 
 func TestDisabledMFAConfig(t *testing.T) {
@@ -528,7 +666,7 @@ func TestOverrideSliceValues(t *testing.T) {
 	assert.NoError(t, err)
 	require.Len(t, config.GetCommonConfig().RateLimitersConfig, 1)
 	rl := config.GetCommonConfig().RateLimitersConfig[0]
-	require.Equal(t, []string{"SSH", "FTP", "DAV", "HTTP"}, rl.Protocols)
+	require.Equal(t, []string{"SSH", "FTP", "DAV", "HTTP", "S3"}, rl.Protocols)
 	require.Equal(t, int64(1000), rl.Period)
 
 	reset()
@@ -842,11 +980,12 @@ func TestRateLimitersFromEnv(t *testing.T) {
 	require.Equal(t, 1, limiters[1].Burst)
 	require.Equal(t, 2, limiters[1].Type)
 	protocols = limiters[1].Protocols
-	require.Len(t, protocols, 4)
+	require.Len(t, protocols, 5)
 	require.True(t, util.Contains(protocols, common.ProtocolFTP))
 	require.True(t, util.Contains(protocols, common.ProtocolSSH))
 	require.True(t, util.Contains(protocols, common.ProtocolWebDAV))
 	require.True(t, util.Contains(protocols, common.ProtocolHTTP))
+	require.True(t, util.Contains(protocols, common.ProtocolS3))
 	require.False(t, limiters[1].GenerateDefenderEvents)
 	require.Equal(t, 100, limiters[1].EntriesSoftLimit)
 	require.Equal(t, 150, limiters[1].EntriesHardLimit)
@@ -860,12 +999,26 @@ func TestSFTPDBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_SFTPD__BINDINGS__0__APPLY_PROXY_CONFIG", "false")
 	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__ADDRESS", "127.0.1.1")
 	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__PORT", "2203")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__KEEPALIVE_INTERVAL", "30")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__KEEPALIVE_MAX_COUNT", "5")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__CLIENT_POLICY__ALLOWED", "SSH-2.0-OpenSSH_*,SSH-2.0-PuTTY*")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__CLIENT_POLICY__DENIED", "*libssh*")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__UNIFORM_AUTH_ERRORS", "true")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__ALLOWED_COUNTRIES", "IT,DE")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__DENIED_COUNTRIES", "CN")
 	t.Cleanup(func() {
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__0__ADDRESS")
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__0__PORT")
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__0__APPLY_PROXY_CONFIG")
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__ADDRESS")
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__PORT")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__KEEPALIVE_INTERVAL")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__KEEPALIVE_MAX_COUNT")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__CLIENT_POLICY__ALLOWED")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__CLIENT_POLICY__DENIED")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__UNIFORM_AUTH_ERRORS")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__ALLOWED_COUNTRIES")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__DENIED_COUNTRIES")
 	})
 
 	err := config.LoadConfig(configDir, "")
@@ -875,9 +1028,22 @@ func TestSFTPDBindingsFromEnv(t *testing.T) {
 	require.Equal(t, 2200, bindings[0].Port)
 	require.Equal(t, "127.0.0.1", bindings[0].Address)
 	require.False(t, bindings[0].ApplyProxyConfig)
+	require.Equal(t, 0, bindings[0].KeepaliveInterval)
+	require.Equal(t, 3, bindings[0].KeepaliveMaxCount)
+	require.False(t, bindings[0].ClientPolicy.IsEnabled())
+	require.False(t, bindings[0].UniformAuthErrors)
+	require.Len(t, bindings[0].AllowedCountries, 0)
+	require.Len(t, bindings[0].DeniedCountries, 0)
 	require.Equal(t, 2203, bindings[1].Port)
 	require.Equal(t, "127.0.1.1", bindings[1].Address)
 	require.True(t, bindings[1].ApplyProxyConfig) // default value
+	require.Equal(t, 30, bindings[1].KeepaliveInterval)
+	require.Equal(t, 5, bindings[1].KeepaliveMaxCount)
+	require.Equal(t, []string{"SSH-2.0-OpenSSH_*", "SSH-2.0-PuTTY*"}, bindings[1].ClientPolicy.Allowed)
+	require.Equal(t, []string{"*libssh*"}, bindings[1].ClientPolicy.Denied)
+	require.True(t, bindings[1].UniformAuthErrors)
+	require.Equal(t, []string{"IT", "DE"}, bindings[1].AllowedCountries)
+	require.Equal(t, []string{"CN"}, bindings[1].DeniedCountries)
 }
 
 func TestCommandsFromEnv(t *testing.T) {
@@ -965,6 +1131,10 @@ func TestFTPDBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_FTPD__BINDINGS__9__ACTIVE_CONNECTIONS_SECURITY", "1")
 	os.Setenv("SFTPGO_FTPD__BINDINGS__9__CERTIFICATE_FILE", "cert.crt")
 	os.Setenv("SFTPGO_FTPD__BINDINGS__9__CERTIFICATE_KEY_FILE", "cert.key")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__9__PASSIVE_PORT_RANGE__START", "51000")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__9__PASSIVE_PORT_RANGE__END", "51100")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__9__ENABLE_MODE_Z", "true")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__9__MODE_Z_LEVEL", "6")
 
 	t.Cleanup(func() {
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__ADDRESS")
@@ -987,6 +1157,10 @@ func TestFTPDBindingsFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__ACTIVE_CONNECTIONS_SECURITY")
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__CERTIFICATE_FILE")
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__CERTIFICATE_KEY_FILE")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__PASSIVE_PORT_RANGE__START")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__PASSIVE_PORT_RANGE__END")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__ENABLE_MODE_Z")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__MODE_Z_LEVEL")
 	})
 
 	err := config.LoadConfig(configDir, "")
@@ -1007,6 +1181,9 @@ func TestFTPDBindingsFromEnv(t *testing.T) {
 	require.False(t, bindings[0].Debug)
 	require.Equal(t, 1, bindings[0].PassiveConnectionsSecurity)
 	require.Equal(t, 0, bindings[0].ActiveConnectionsSecurity)
+	require.False(t, bindings[0].HasPassivePortRange())
+	require.False(t, bindings[0].EnableModeZ)
+	require.Equal(t, 0, bindings[0].ModeZLevel)
 	require.Equal(t, 2203, bindings[1].Port)
 	require.Equal(t, "127.0.1.1", bindings[1].Address)
 	require.True(t, bindings[1].ApplyProxyConfig) // default value
@@ -1025,6 +1202,10 @@ func TestFTPDBindingsFromEnv(t *testing.T) {
 	require.True(t, bindings[1].Debug)
 	require.Equal(t, "cert.crt", bindings[1].CertificateFile)
 	require.Equal(t, "cert.key", bindings[1].CertificateKeyFile)
+	require.Equal(t, 51000, bindings[1].PassivePortRange.Start)
+	require.Equal(t, 51100, bindings[1].PassivePortRange.End)
+	require.True(t, bindings[1].EnableModeZ)
+	require.Equal(t, 6, bindings[1].ModeZLevel)
 }
 
 func TestWebDAVBindingsFromEnv(t *testing.T) {
@@ -1040,12 +1221,14 @@ func TestWebDAVBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__ADDRESS", "127.0.1.1")
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__PORT", "9000")
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__ENABLE_HTTPS", "1")
+	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__ENABLE_HTTP3", "1")
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__MIN_TLS_VERSION", "13")
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__CLIENT_AUTH_TYPE", "1")
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__PREFIX", "/dav2")
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__CERTIFICATE_FILE", "webdav.crt")
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__CERTIFICATE_KEY_FILE", "webdav.key")
 	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__DISABLE_WWW_AUTH_HEADER", "1")
+	os.Setenv("SFTPGO_WEBDAVD__BINDINGS__2__UNIFORM_AUTH_ERRORS", "1")
 
 	t.Cleanup(func() {
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__1__ADDRESS")
@@ -1058,12 +1241,14 @@ func TestWebDAVBindingsFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__ADDRESS")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__PORT")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__ENABLE_HTTPS")
+		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__ENABLE_HTTP3")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__MIN_TLS_VERSION")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__CLIENT_AUTH_TYPE")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__PREFIX")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__CERTIFICATE_FILE")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__CERTIFICATE_KEY_FILE")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__DISABLE_WWW_AUTH_HEADER")
+		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__2__UNIFORM_AUTH_ERRORS")
 	})
 
 	err := config.LoadConfig(configDir, "")
@@ -1081,6 +1266,7 @@ func TestWebDAVBindingsFromEnv(t *testing.T) {
 	require.Equal(t, 8000, bindings[1].Port)
 	require.Equal(t, "127.0.0.1", bindings[1].Address)
 	require.False(t, bindings[1].EnableHTTPS)
+	require.False(t, bindings[1].EnableHTTP3)
 	require.Equal(t, 12, bindings[1].MinTLSVersion)
 	require.Equal(t, 0, bindings[1].ClientAuthType)
 	require.Len(t, bindings[1].TLSCipherSuites, 1)
@@ -1090,9 +1276,11 @@ func TestWebDAVBindingsFromEnv(t *testing.T) {
 	require.Equal(t, 2, bindings[1].ClientIPHeaderDepth)
 	require.Empty(t, bindings[1].Prefix)
 	require.False(t, bindings[1].DisableWWWAuthHeader)
+	require.False(t, bindings[1].UniformAuthErrors)
 	require.Equal(t, 9000, bindings[2].Port)
 	require.Equal(t, "127.0.1.1", bindings[2].Address)
 	require.True(t, bindings[2].EnableHTTPS)
+	require.True(t, bindings[2].EnableHTTP3)
 	require.Equal(t, 13, bindings[2].MinTLSVersion)
 	require.Equal(t, 1, bindings[2].ClientAuthType)
 	require.Nil(t, bindings[2].TLSCipherSuites)
@@ -1101,6 +1289,74 @@ func TestWebDAVBindingsFromEnv(t *testing.T) {
 	require.Equal(t, "webdav.key", bindings[2].CertificateKeyFile)
 	require.Equal(t, 0, bindings[2].ClientIPHeaderDepth)
 	require.True(t, bindings[2].DisableWWWAuthHeader)
+	require.True(t, bindings[2].UniformAuthErrors)
+}
+
+func TestS3DBindingsFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_S3D__BINDINGS__1__ADDRESS", "127.0.0.1")
+	os.Setenv("SFTPGO_S3D__BINDINGS__1__PORT", "9010")
+	os.Setenv("SFTPGO_S3D__BINDINGS__1__ENABLE_HTTPS", "0")
+	os.Setenv("SFTPGO_S3D__BINDINGS__1__TLS_CIPHER_SUITES", "TLS_RSA_WITH_AES_128_CBC_SHA ")
+	os.Setenv("SFTPGO_S3D__BINDINGS__1__PROXY_ALLOWED", "192.168.10.1")
+	os.Setenv("SFTPGO_S3D__BINDINGS__1__CLIENT_IP_PROXY_HEADER", "X-Forwarded-For")
+	os.Setenv("SFTPGO_S3D__BINDINGS__1__CLIENT_IP_HEADER_DEPTH", "2")
+	os.Setenv("SFTPGO_S3D__BINDINGS__2__ADDRESS", "127.0.1.1")
+	os.Setenv("SFTPGO_S3D__BINDINGS__2__PORT", "9011")
+	os.Setenv("SFTPGO_S3D__BINDINGS__2__ENABLE_HTTPS", "1")
+	os.Setenv("SFTPGO_S3D__BINDINGS__2__MIN_TLS_VERSION", "13")
+	os.Setenv("SFTPGO_S3D__BINDINGS__2__CERTIFICATE_FILE", "s3.crt")
+	os.Setenv("SFTPGO_S3D__BINDINGS__2__CERTIFICATE_KEY_FILE", "s3.key")
+	os.Setenv("SFTPGO_S3D__REGION", "eu-central-1")
+	os.Setenv("SFTPGO_S3D__MULTIPART_EXPIRATION", "60")
+
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__1__ADDRESS")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__1__PORT")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__1__ENABLE_HTTPS")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__1__TLS_CIPHER_SUITES")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__1__PROXY_ALLOWED")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__1__CLIENT_IP_PROXY_HEADER")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__1__CLIENT_IP_HEADER_DEPTH")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__2__ADDRESS")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__2__PORT")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__2__ENABLE_HTTPS")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__2__MIN_TLS_VERSION")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__2__CERTIFICATE_FILE")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__2__CERTIFICATE_KEY_FILE")
+		os.Unsetenv("SFTPGO_S3D__REGION")
+		os.Unsetenv("SFTPGO_S3D__MULTIPART_EXPIRATION")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	s3dConf := config.GetS3DConfig()
+	require.Equal(t, "eu-central-1", s3dConf.Region)
+	require.Equal(t, 60, s3dConf.MultipartExpiration)
+	bindings := s3dConf.Bindings
+	require.Len(t, bindings, 3)
+	require.Equal(t, 0, bindings[0].Port)
+	require.Empty(t, bindings[0].Address)
+	require.False(t, bindings[0].EnableHTTPS)
+	require.Equal(t, 12, bindings[0].MinTLSVersion)
+	require.Len(t, bindings[0].TLSCipherSuites, 0)
+	require.Equal(t, 9010, bindings[1].Port)
+	require.Equal(t, "127.0.0.1", bindings[1].Address)
+	require.False(t, bindings[1].EnableHTTPS)
+	require.Equal(t, 12, bindings[1].MinTLSVersion)
+	require.Len(t, bindings[1].TLSCipherSuites, 1)
+	require.Equal(t, "TLS_RSA_WITH_AES_128_CBC_SHA", bindings[1].TLSCipherSuites[0])
+	require.Equal(t, "192.168.10.1", bindings[1].ProxyAllowed[0])
+	require.Equal(t, "X-Forwarded-For", bindings[1].ClientIPProxyHeader)
+	require.Equal(t, 2, bindings[1].ClientIPHeaderDepth)
+	require.Equal(t, 9011, bindings[2].Port)
+	require.Equal(t, "127.0.1.1", bindings[2].Address)
+	require.True(t, bindings[2].EnableHTTPS)
+	require.Equal(t, 13, bindings[2].MinTLSVersion)
+	require.Nil(t, bindings[2].TLSCipherSuites)
+	require.Equal(t, "s3.crt", bindings[2].CertificateFile)
+	require.Equal(t, "s3.key", bindings[2].CertificateKeyFile)
 }
 
 func TestHTTPDBindingsFromEnv(t *testing.T) {
@@ -1125,6 +1381,7 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__ENABLED_LOGIN_METHODS", "3")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__RENDER_OPENAPI", "0")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__ENABLE_HTTPS", "1 ")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__ENABLE_HTTP3", "true")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__MIN_TLS_VERSION", "13")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CLIENT_AUTH_TYPE", "1")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__TLS_CIPHER_SUITES", " TLS_AES_256_GCM_SHA384 , TLS_CHACHA20_POLY1305_SHA256")
@@ -1147,6 +1404,22 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__CUSTOM_FIELDS", "field1,field2")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__INSECURE_SKIP_SIGNATURE_CHECK", "1")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__DEBUG", "1")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__IDP_METADATA_URL", "https://idp.example.com/metadata")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__BASE_URL", "https://sftpgo.example.com")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__USERNAME_ATTRIBUTE", "uid")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__ROLE_ATTRIBUTE", "sftpgo_role")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__GROUP_ATTRIBUTE", "memberOf")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__GROUP_MAPPINGS__0__VALUE", "sftp-users")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__GROUP_MAPPINGS__0__GROUP", "users")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__GROUP_MAPPINGS__0__TYPE", "1")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__CUSTOM_ATTRIBUTES", "mail,cn")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__AUTO_PROVISIONING", "1")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__HOME_DIR_TEMPLATE", "/srv/%username%")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__PERMISSIONS", "list,download")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__DEBUG", "1")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__MAGIC_LINK__ENABLED", "1")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__MAGIC_LINK__BASE_URL", "https://sftpgo.example.com")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__MAGIC_LINK__LIFESPAN", "30")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__ENABLED", "true")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__ALLOWED_HOSTS", "*.example.com,*.example.net")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__ALLOWED_HOSTS_ARE_REGEX", "1")
@@ -1163,6 +1436,25 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__PERMISSIONS_POLICY", "fullscreen=(), geolocation=()")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__CROSS_ORIGIN_OPENER_POLICY", "same-origin")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__EXPECT_CT_HEADER", `max-age=86400, enforce, report-uri="https://foo.example/report"`)
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__FRAME_ANCESTORS", "'self',https://portal.example.com")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__REFERRER_POLICY", "no-referrer")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__ENABLED", "1")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__ALLOWED_ORIGINS", "https://*.example.com")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__ALLOWED_METHODS", "GET,POST")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__ALLOWED_HEADERS", "Authorization")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__EXPOSED_HEADERS", "X-Custom")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__ALLOW_CREDENTIALS", "true")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__MAX_AGE", "600")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__OPTIONS_PASSTHROUGH", "1")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__OPTIONS_SUCCESS_STATUS", "204")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__ALLOW_PRIVATE_NETWORK", "1")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__RULES__0__PATHS", "/api/v2/user,/api/v2/shares")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__RULES__0__ALLOWED_ORIGINS", "https://app.example.com")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__RULES__0__ALLOWED_METHODS", "GET,PUT,DELETE")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__RULES__0__ALLOWED_HEADERS", "Authorization,Content-Type")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__RULES__0__EXPOSED_HEADERS", "Content-Disposition")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__RULES__0__ALLOW_CREDENTIALS", "1")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__CORS__RULES__0__MAX_AGE", "300")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__EXTRA_CSS__0__PATH", "path1")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__EXTRA_CSS__1__PATH", "path2")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__BRANDING__WEB_ADMIN__FAVICON_PATH", "favicon.ico")
@@ -1189,6 +1481,7 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__ADDRESS")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__PORT")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__ENABLE_HTTPS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__ENABLE_HTTP3")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__MIN_TLS_VERSION")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__ENABLE_WEB_ADMIN")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__ENABLE_WEB_CLIENT")
@@ -1216,6 +1509,22 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__CUSTOM_FIELDS")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__INSECURE_SKIP_SIGNATURE_CHECK")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__DEBUG")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__IDP_METADATA_URL")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__BASE_URL")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__USERNAME_ATTRIBUTE")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__ROLE_ATTRIBUTE")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__GROUP_ATTRIBUTE")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__GROUP_MAPPINGS__0__VALUE")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__GROUP_MAPPINGS__0__GROUP")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__GROUP_MAPPINGS__0__TYPE")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__CUSTOM_ATTRIBUTES")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__AUTO_PROVISIONING")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__HOME_DIR_TEMPLATE")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__PERMISSIONS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__DEBUG")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__MAGIC_LINK__ENABLED")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__MAGIC_LINK__BASE_URL")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__MAGIC_LINK__LIFESPAN")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__ENABLED")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__ALLOWED_HOSTS")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__ALLOWED_HOSTS_ARE_REGEX")
@@ -1232,6 +1541,25 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__PERMISSIONS_POLICY")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__CROSS_ORIGIN_OPENER_POLICY")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__EXPECT_CT_HEADER")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__FRAME_ANCESTORS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__REFERRER_POLICY")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__ENABLED")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__ALLOWED_ORIGINS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__ALLOWED_METHODS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__ALLOWED_HEADERS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__EXPOSED_HEADERS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__ALLOW_CREDENTIALS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__MAX_AGE")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__OPTIONS_PASSTHROUGH")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__OPTIONS_SUCCESS_STATUS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__ALLOW_PRIVATE_NETWORK")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__RULES__0__PATHS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__RULES__0__ALLOWED_ORIGINS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__RULES__0__ALLOWED_METHODS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__RULES__0__ALLOWED_HEADERS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__RULES__0__EXPOSED_HEADERS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__RULES__0__ALLOW_CREDENTIALS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__CORS__RULES__0__MAX_AGE")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__EXTRA_CSS__0__PATH")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__EXTRA_CSS__1__PATH")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__BRANDING__WEB_ADMIN__FAVICON_PATH")
@@ -1263,13 +1591,20 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	require.Equal(t, "TLS_AES_128_GCM_SHA256", bindings[0].TLSCipherSuites[0])
 	require.Equal(t, 0, bindings[0].HideLoginURL)
 	require.False(t, bindings[0].Security.Enabled)
+	require.False(t, bindings[0].Cors.Enabled)
 	require.Equal(t, 0, bindings[0].ClientIPHeaderDepth)
 	require.Len(t, bindings[0].OIDC.Scopes, 3)
 	require.False(t, bindings[0].OIDC.InsecureSkipSignatureCheck)
 	require.False(t, bindings[0].OIDC.Debug)
+	require.Empty(t, bindings[0].SAML.IDPMetadataURL)
+	require.False(t, bindings[0].SAML.AutoProvisioning)
+	require.Equal(t, []string{"*"}, bindings[0].SAML.Permissions)
+	require.False(t, bindings[0].MagicLink.Enabled)
+	require.Equal(t, 15, bindings[0].MagicLink.Lifespan)
 	require.Equal(t, 8000, bindings[1].Port)
 	require.Equal(t, "127.0.0.1", bindings[1].Address)
 	require.False(t, bindings[1].EnableHTTPS)
+	require.False(t, bindings[1].EnableHTTP3)
 	require.Equal(t, 12, bindings[0].MinTLSVersion)
 	require.True(t, bindings[1].EnableWebAdmin)
 	require.True(t, bindings[1].EnableWebClient)
@@ -1289,6 +1624,7 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	require.Equal(t, 9000, bindings[2].Port)
 	require.Equal(t, "127.0.1.1", bindings[2].Address)
 	require.True(t, bindings[2].EnableHTTPS)
+	require.True(t, bindings[2].EnableHTTP3)
 	require.Equal(t, 13, bindings[2].MinTLSVersion)
 	require.False(t, bindings[2].EnableWebAdmin)
 	require.False(t, bindings[2].EnableWebClient)
@@ -1322,6 +1658,23 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	require.Equal(t, "field2", bindings[2].OIDC.CustomFields[1])
 	require.True(t, bindings[2].OIDC.InsecureSkipSignatureCheck)
 	require.True(t, bindings[2].OIDC.Debug)
+	require.Equal(t, "https://idp.example.com/metadata", bindings[2].SAML.IDPMetadataURL)
+	require.Equal(t, "https://sftpgo.example.com", bindings[2].SAML.BaseURL)
+	require.Equal(t, "uid", bindings[2].SAML.UsernameAttribute)
+	require.Equal(t, "sftpgo_role", bindings[2].SAML.RoleAttribute)
+	require.Equal(t, "memberOf", bindings[2].SAML.GroupAttribute)
+	require.Len(t, bindings[2].SAML.GroupMappings, 1)
+	require.Equal(t, "sftp-users", bindings[2].SAML.GroupMappings[0].Value)
+	require.Equal(t, "users", bindings[2].SAML.GroupMappings[0].Group)
+	require.Equal(t, 1, bindings[2].SAML.GroupMappings[0].Type)
+	require.Equal(t, []string{"mail", "cn"}, bindings[2].SAML.CustomAttributes)
+	require.True(t, bindings[2].SAML.AutoProvisioning)
+	require.Equal(t, "/srv/%username%", bindings[2].SAML.HomeDirTemplate)
+	require.Equal(t, []string{"list", "download"}, bindings[2].SAML.Permissions)
+	require.True(t, bindings[2].SAML.Debug)
+	require.True(t, bindings[2].MagicLink.Enabled)
+	require.Equal(t, "https://sftpgo.example.com", bindings[2].MagicLink.BaseURL)
+	require.Equal(t, 30, bindings[2].MagicLink.Lifespan)
 	require.True(t, bindings[2].Security.Enabled)
 	require.Len(t, bindings[2].Security.AllowedHosts, 2)
 	require.Equal(t, "*.example.com", bindings[2].Security.AllowedHosts[0])
@@ -1342,6 +1695,26 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	require.Equal(t, "fullscreen=(), geolocation=()", bindings[2].Security.PermissionsPolicy)
 	require.Equal(t, "same-origin", bindings[2].Security.CrossOriginOpenerPolicy)
 	require.Equal(t, `max-age=86400, enforce, report-uri="https://foo.example/report"`, bindings[2].Security.ExpectCTHeader)
+	require.Equal(t, []string{"'self'", "https://portal.example.com"}, bindings[2].Security.FrameAncestors)
+	require.Equal(t, "no-referrer", bindings[2].Security.ReferrerPolicy)
+	require.True(t, bindings[2].Cors.Enabled)
+	require.Equal(t, []string{"https://*.example.com"}, bindings[2].Cors.AllowedOrigins)
+	require.Equal(t, []string{"GET", "POST"}, bindings[2].Cors.AllowedMethods)
+	require.Equal(t, []string{"Authorization"}, bindings[2].Cors.AllowedHeaders)
+	require.Equal(t, []string{"X-Custom"}, bindings[2].Cors.ExposedHeaders)
+	require.True(t, bindings[2].Cors.AllowCredentials)
+	require.Equal(t, 600, bindings[2].Cors.MaxAge)
+	require.True(t, bindings[2].Cors.OptionsPassthrough)
+	require.Equal(t, 204, bindings[2].Cors.OptionsSuccessStatus)
+	require.True(t, bindings[2].Cors.AllowPrivateNetwork)
+	require.Len(t, bindings[2].Cors.Rules, 1)
+	require.Equal(t, []string{"/api/v2/user", "/api/v2/shares"}, bindings[2].Cors.Rules[0].Paths)
+	require.Equal(t, []string{"https://app.example.com"}, bindings[2].Cors.Rules[0].AllowedOrigins)
+	require.Equal(t, []string{"GET", "PUT", "DELETE"}, bindings[2].Cors.Rules[0].AllowedMethods)
+	require.Equal(t, []string{"Authorization", "Content-Type"}, bindings[2].Cors.Rules[0].AllowedHeaders)
+	require.Equal(t, []string{"Content-Disposition"}, bindings[2].Cors.Rules[0].ExposedHeaders)
+	require.True(t, bindings[2].Cors.Rules[0].AllowCredentials)
+	require.Equal(t, 300, bindings[2].Cors.Rules[0].MaxAge)
 	require.Equal(t, "favicon.ico", bindings[2].Branding.WebAdmin.FaviconPath)
 	require.Equal(t, "logo.png", bindings[2].Branding.WebClient.LogoPath)
 	require.Equal(t, "login_image.png", bindings[2].Branding.WebAdmin.LoginImagePath)
@@ -1415,6 +1788,203 @@ func TestHTTPClientCertificatesFromEnv(t *testing.T) {
 	require.Equal(t, "key9", config.GetHTTPConfig().Certificates[1].Key)
 }
 
+func TestDNSStaticHostsFromEnv(t *testing.T) {
+	reset()
+
+	confName := tempConfigName + ".json"
+	configFilePath := filepath.Join(configDir, confName)
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	dnsConf := config.GetDNSConfig()
+	assert.Equal(t, 5, dnsConf.Timeout)
+	assert.Len(t, dnsConf.StaticHosts, 0)
+	dnsConf.StaticHosts = append(dnsConf.StaticHosts, resolver.StaticHost{
+		Host:      "s3.example.com",
+		Addresses: []string{"192.168.1.1"},
+	})
+	c := make(map[string]resolver.Config)
+	c["dns"] = dnsConf
+	jsonConf, err := json.Marshal(c)
+	require.NoError(t, err)
+	err = os.WriteFile(configFilePath, jsonConf, os.ModePerm)
+	require.NoError(t, err)
+	err = config.LoadConfig(configDir, confName)
+	require.NoError(t, err)
+	require.Len(t, config.GetDNSConfig().StaticHosts, 1)
+	require.Equal(t, "s3.example.com", config.GetDNSConfig().StaticHosts[0].Host)
+	require.Equal(t, []string{"192.168.1.1"}, config.GetDNSConfig().StaticHosts[0].Addresses)
+
+	os.Setenv("SFTPGO_DNS__SERVERS", "tls://dns.example.com,192.168.1.53")
+	os.Setenv("SFTPGO_DNS__CACHE_TTL", "60")
+	os.Setenv("SFTPGO_DNS__STATIC_HOSTS__0__ADDRESSES", "192.168.1.2,::1")
+	os.Setenv("SFTPGO_DNS__STATIC_HOSTS__8__ADDRESSES", "192.168.1.8")
+	os.Setenv("SFTPGO_DNS__STATIC_HOSTS__9__HOST", "hooks.example.com")
+	os.Setenv("SFTPGO_DNS__STATIC_HOSTS__9__ADDRESSES", "10.0.0.9")
+
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_DNS__SERVERS")
+		os.Unsetenv("SFTPGO_DNS__CACHE_TTL")
+		os.Unsetenv("SFTPGO_DNS__STATIC_HOSTS__0__ADDRESSES")
+		os.Unsetenv("SFTPGO_DNS__STATIC_HOSTS__8__ADDRESSES")
+		os.Unsetenv("SFTPGO_DNS__STATIC_HOSTS__9__HOST")
+		os.Unsetenv("SFTPGO_DNS__STATIC_HOSTS__9__ADDRESSES")
+	})
+
+	err = config.LoadConfig(configDir, confName)
+	require.NoError(t, err)
+	dnsConf = config.GetDNSConfig()
+	require.Equal(t, []string{"tls://dns.example.com", "192.168.1.53"}, dnsConf.Servers)
+	require.Equal(t, 60, dnsConf.CacheTTL)
+	require.Len(t, dnsConf.StaticHosts, 2)
+	require.Equal(t, "s3.example.com", dnsConf.StaticHosts[0].Host)
+	require.Equal(t, []string{"192.168.1.2", "::1"}, dnsConf.StaticHosts[0].Addresses)
+	require.Equal(t, "hooks.example.com", dnsConf.StaticHosts[1].Host)
+	require.Equal(t, []string{"10.0.0.9"}, dnsConf.StaticHosts[1].Addresses)
+
+	err = os.Remove(configFilePath)
+	assert.NoError(t, err)
+}
+
+func TestPasswordPoliciesFromEnv(t *testing.T) {
+	reset()
+
+	confName := tempConfigName + ".json"
+	configFilePath := filepath.Join(configDir, confName)
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	assert.Len(t, providerConf.PasswordPolicies, 0)
+	providerConf.PasswordPolicies = append(providerConf.PasswordPolicies, dataprovider.PasswordPolicy{
+		Name:      "standard",
+		MinLength: 8,
+	})
+	c := make(map[string]dataprovider.Config)
+	c["data_provider"] = providerConf
+	jsonConf, err := json.Marshal(c)
+	require.NoError(t, err)
+	err = os.WriteFile(configFilePath, jsonConf, os.ModePerm)
+	require.NoError(t, err)
+	err = config.LoadConfig(configDir, confName)
+	require.NoError(t, err)
+	require.Len(t, config.GetProviderConf().PasswordPolicies, 1)
+	require.Equal(t, "standard", config.GetProviderConf().PasswordPolicies[0].Name)
+	require.Equal(t, 8, config.GetProviderConf().PasswordPolicies[0].MinLength)
+
+	os.Setenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__0__MIN_LENGTH", "10")
+	os.Setenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__0__REQUIRE_DIGIT", "true")
+	os.Setenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__NAME", "strict")
+	os.Setenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__MIN_LENGTH", "14")
+	os.Setenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__REQUIRE_UPPERCASE", "1")
+	os.Setenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__REQUIRE_LOWERCASE", "1")
+	os.Setenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__REQUIRE_SPECIAL", "1")
+	os.Setenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__DICTIONARY_FILE", "common_passwords.txt")
+	os.Setenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__BREACH_CHECK_URL", "https://api.pwnedpasswords.com/range/")
+	os.Setenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__EXPIRATION_DAYS", "90")
+	os.Setenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__HISTORY", "5")
+
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__0__MIN_LENGTH")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__0__REQUIRE_DIGIT")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__NAME")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__MIN_LENGTH")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__REQUIRE_UPPERCASE")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__REQUIRE_LOWERCASE")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__REQUIRE_SPECIAL")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__DICTIONARY_FILE")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__BREACH_CHECK_URL")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__EXPIRATION_DAYS")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__PASSWORD_POLICIES__1__HISTORY")
+	})
+
+	err = config.LoadConfig(configDir, confName)
+	require.NoError(t, err)
+	policies := config.GetProviderConf().PasswordPolicies
+	require.Len(t, policies, 2)
+	require.Equal(t, "standard", policies[0].Name)
+	require.Equal(t, 10, policies[0].MinLength)
+	require.True(t, policies[0].RequireDigit)
+	require.False(t, policies[0].RequireUppercase)
+	require.Equal(t, "strict", policies[1].Name)
+	require.Equal(t, 14, policies[1].MinLength)
+	require.True(t, policies[1].RequireUppercase)
+	require.True(t, policies[1].RequireLowercase)
+	require.True(t, policies[1].RequireSpecial)
+	require.False(t, policies[1].RequireDigit)
+	require.Equal(t, "common_passwords.txt", policies[1].DictionaryFile)
+	require.Equal(t, "https://api.pwnedpasswords.com/range/", policies[1].BreachCheckURL)
+	require.Equal(t, 90, policies[1].ExpirationDays)
+	require.Equal(t, 5, policies[1].History)
+
+	err = os.Remove(configFilePath)
+	assert.NoError(t, err)
+}
+
+func TestDefenderFeedsFromEnv(t *testing.T) {
+	reset()
+
+	confName := tempConfigName + ".json"
+	configFilePath := filepath.Join(configDir, confName)
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	commonConf := config.GetCommonConfig()
+	assert.Len(t, commonConf.DefenderConfig.Feeds, 0)
+	commonConf.DefenderConfig.Feeds = append(commonConf.DefenderConfig.Feeds, common.DefenderFeed{
+		Name:            "drop",
+		URL:             "https://www.spamhaus.org/drop/drop.txt",
+		Format:          common.DefenderFeedFormatCIDR,
+		RefreshInterval: 720,
+		Score:           100,
+	})
+	c := make(map[string]common.Configuration)
+	c["common"] = commonConf
+	jsonConf, err := json.Marshal(c)
+	require.NoError(t, err)
+	err = os.WriteFile(configFilePath, jsonConf, os.ModePerm)
+	require.NoError(t, err)
+	err = config.LoadConfig(configDir, confName)
+	require.NoError(t, err)
+	require.Len(t, config.GetCommonConfig().DefenderConfig.Feeds, 1)
+	require.Equal(t, "drop", config.GetCommonConfig().DefenderConfig.Feeds[0].Name)
+
+	os.Setenv("SFTPGO_COMMON__DEFENDER__FEEDS__0__TTL", "2880")
+	os.Setenv("SFTPGO_COMMON__DEFENDER__FEEDS__1__NAME", "abuseipdb")
+	os.Setenv("SFTPGO_COMMON__DEFENDER__FEEDS__1__URL", "https://api.abuseipdb.com/api/v2/blacklist")
+	os.Setenv("SFTPGO_COMMON__DEFENDER__FEEDS__1__FORMAT", "abuseipdb")
+	os.Setenv("SFTPGO_COMMON__DEFENDER__FEEDS__1__API_KEY", "key")
+	os.Setenv("SFTPGO_COMMON__DEFENDER__FEEDS__1__REFRESH_INTERVAL", "60")
+	os.Setenv("SFTPGO_COMMON__DEFENDER__FEEDS__1__SCORE", "5")
+
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_COMMON__DEFENDER__FEEDS__0__TTL")
+		os.Unsetenv("SFTPGO_COMMON__DEFENDER__FEEDS__1__NAME")
+		os.Unsetenv("SFTPGO_COMMON__DEFENDER__FEEDS__1__URL")
+		os.Unsetenv("SFTPGO_COMMON__DEFENDER__FEEDS__1__FORMAT")
+		os.Unsetenv("SFTPGO_COMMON__DEFENDER__FEEDS__1__API_KEY")
+		os.Unsetenv("SFTPGO_COMMON__DEFENDER__FEEDS__1__REFRESH_INTERVAL")
+		os.Unsetenv("SFTPGO_COMMON__DEFENDER__FEEDS__1__SCORE")
+	})
+
+	err = config.LoadConfig(configDir, confName)
+	require.NoError(t, err)
+	feeds := config.GetCommonConfig().DefenderConfig.Feeds
+	require.Len(t, feeds, 2)
+	require.Equal(t, "drop", feeds[0].Name)
+	require.Equal(t, common.DefenderFeedFormatCIDR, feeds[0].Format)
+	require.Equal(t, 720, feeds[0].RefreshInterval)
+	require.Equal(t, 100, feeds[0].Score)
+	require.Equal(t, 2880, feeds[0].TTL)
+	require.Equal(t, "abuseipdb", feeds[1].Name)
+	require.Equal(t, "https://api.abuseipdb.com/api/v2/blacklist", feeds[1].URL)
+	require.Equal(t, common.DefenderFeedFormatAbuseIPDB, feeds[1].Format)
+	require.Equal(t, "key", feeds[1].APIKey)
+	require.Equal(t, 60, feeds[1].RefreshInterval)
+	require.Equal(t, 5, feeds[1].Score)
+	require.Equal(t, 0, feeds[1].TTL)
+
+	err = os.Remove(configFilePath)
+	assert.NoError(t, err)
+}
+
 func TestHTTPClientHeadersFromEnv(t *testing.T) {
 	reset()
 
@@ -1485,6 +2055,37 @@ func TestHTTPClientHeadersFromEnv(t *testing.T) {
 	require.Equal(t, "url9", config.GetHTTPConfig().Headers[1].URL)
 }
 
+func TestHTTPClientSignatureFromEnv(t *testing.T) {
+	reset()
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	signature := config.GetHTTPConfig().Signature
+	assert.Empty(t, signature.Secret)
+	assert.Equal(t, "X-SFTPGo-Signature", signature.SignatureHeader)
+	assert.Equal(t, "X-SFTPGo-Timestamp", signature.TimestampHeader)
+	assert.Equal(t, "X-SFTPGo-Nonce", signature.NonceHeader)
+
+	os.Setenv("SFTPGO_HTTP__SIGNATURE__SECRET", "secret")
+	os.Setenv("SFTPGO_HTTP__SIGNATURE__SIGNATURE_HEADER", "X-Signature")
+	os.Setenv("SFTPGO_HTTP__SIGNATURE__TIMESTAMP_HEADER", "X-Timestamp")
+	os.Setenv("SFTPGO_HTTP__SIGNATURE__NONCE_HEADER", "X-Nonce")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_HTTP__SIGNATURE__SECRET")
+		os.Unsetenv("SFTPGO_HTTP__SIGNATURE__SIGNATURE_HEADER")
+		os.Unsetenv("SFTPGO_HTTP__SIGNATURE__TIMESTAMP_HEADER")
+		os.Unsetenv("SFTPGO_HTTP__SIGNATURE__NONCE_HEADER")
+	})
+
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	signature = config.GetHTTPConfig().Signature
+	assert.Equal(t, "secret", signature.Secret)
+	assert.Equal(t, "X-Signature", signature.SignatureHeader)
+	assert.Equal(t, "X-Timestamp", signature.TimestampHeader)
+	assert.Equal(t, "X-Nonce", signature.NonceHeader)
+}
+
 func TestConfigFromEnv(t *testing.T) {
 	reset()
 
@@ -1494,11 +2095,72 @@ func TestConfigFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_DATA_PROVIDER__POOL_SIZE", "10")
 	os.Setenv("SFTPGO_DATA_PROVIDER__IS_SHARED", "1")
 	os.Setenv("SFTPGO_DATA_PROVIDER__ACTIONS__EXECUTE_ON", "add")
+	os.Setenv("SFTPGO_DATA_PROVIDER__SOFT_DELETE_RETENTION", "72")
+	os.Setenv("SFTPGO_DATA_PROVIDER__READ_REPLICAS__CONNECTION_STRINGS", "postgres://replica1/sftpgo,postgres://replica2/sftpgo")
+	os.Setenv("SFTPGO_DATA_PROVIDER__READ_REPLICAS__MAX_LAG", "5")
+	os.Setenv("SFTPGO_DATA_PROVIDER__AUDIT__ENABLED", "true")
+	os.Setenv("SFTPGO_DATA_PROVIDER__AUDIT__RETENTION_DAYS", "90")
+	os.Setenv("SFTPGO_DATA_PROVIDER__DEAD_LETTERS__ENABLED", "true")
+	os.Setenv("SFTPGO_DATA_PROVIDER__DEAD_LETTERS__RETENTION_DAYS", "30")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP__URL", "ldaps://ldap.example.com")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP__CACHE_TTL", "3600")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP__GROUP_MAPPINGS__0__LDAP_GROUP", "sftp-users")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP__GROUP_MAPPINGS__0__GROUP", "users")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP__GROUP_MAPPINGS__0__TYPE", "1")
+	os.Setenv("SFTPGO_DATA_PROVIDER__CACHE_INVALIDATION__DRIVER", "nats")
+	os.Setenv("SFTPGO_DATA_PROVIDER__CACHE_INVALIDATION__URL", "nats://127.0.0.1:4222")
+	os.Setenv("SFTPGO_DATA_PROVIDER__SQLITE__JOURNAL_MODE", "WAL")
+	os.Setenv("SFTPGO_DATA_PROVIDER__SQLITE__BUSY_TIMEOUT", "10000")
+	os.Setenv("SFTPGO_SFTPD__KERBEROS__KEYTAB", "sftpgo.keytab")
+	os.Setenv("SFTPGO_SFTPD__KERBEROS__MAX_CLOCK_SKEW", "120")
+	os.Setenv("SFTPGO_SFTPD__KERBEROS__PRINCIPAL_MAPPINGS__0__PATTERN", "^(.+)@EXAMPLE\\.COM$")
+	os.Setenv("SFTPGO_SFTPD__KERBEROS__PRINCIPAL_MAPPINGS__0__USERNAME", "$1")
+	os.Setenv("SFTPGO_SFTPD__USER_CA__PRIVATE_KEY", "user_ca")
+	os.Setenv("SFTPGO_SFTPD__USER_CA__MAX_TTL", "720")
+	os.Setenv("SFTPGO_SFTPD__USER_CA__PRINCIPAL_MAPPINGS__0__GROUP", "ops")
+	os.Setenv("SFTPGO_SFTPD__USER_CA__PRINCIPAL_MAPPINGS__0__PRINCIPALS", "ops, root")
 	os.Setenv("SFTPGO_KMS__SECRETS__URL", "local")
 	os.Setenv("SFTPGO_KMS__SECRETS__MASTER_KEY_PATH", "path")
 	os.Setenv("SFTPGO_TELEMETRY__TLS_CIPHER_SUITES", "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA")
+	os.Setenv("SFTPGO_TELEMETRY__PUSH__URL", "http://pushgateway:9091")
+	os.Setenv("SFTPGO_TELEMETRY__PUSH__LABELS", "region=eu,instance=node1")
+	os.Setenv("SFTPGO_TELEMETRY__USER_METRICS__ENABLED", "true")
+	os.Setenv("SFTPGO_TELEMETRY__USER_METRICS__MAX_USERS", "500")
+	os.Setenv("SFTPGO_TRACING__ENDPOINT", "http://otel-collector:4318")
+	os.Setenv("SFTPGO_TRACING__SAMPLE_RATIO", "0.25")
+	os.Setenv("SFTPGO_AUDIT_LOG__FILE_PATH", "audit.jsonl")
+	os.Setenv("SFTPGO_AUDIT_LOG__EVENTS", "login,admin_login")
+	os.Setenv("SFTPGO_SYSLOG__ADDRESS", "siem:6514")
+	os.Setenv("SFTPGO_SYSLOG__NETWORK", "tls")
+	os.Setenv("SFTPGO_SYSLOG__FIELD_MAPPING", "username=duser,country=")
 	os.Setenv("SFTPGO_HTTPD__SETUP__INSTALLATION_CODE", "123")
+	os.Setenv("SFTPGO_HTTPD__TUS__ENABLED", "true")
+	os.Setenv("SFTPGO_HTTPD__TUS__EXPIRATION_TIME", "60")
+	os.Setenv("SFTPGO_HTTPD__TUS__STAGING_PATH", "/srv/shared/sftpgo")
+	os.Setenv("SFTPGO_HTTPD__UPLOADS__BUFFER_SIZE", "512")
+	os.Setenv("SFTPGO_HTTPD__UPLOADS__MEMORY_BUDGET", "64")
+	os.Setenv("SFTPGO_HTTPD__I18N__DEFAULT_LANGUAGE", "it")
+	os.Setenv("SFTPGO_HTTPD__I18N__CATALOGS_PATH", "catalogs")
+	os.Setenv("SFTPGO_HTTPD__COMPLIANCE__SIGNING_KEY", "compliance_key")
+	os.Setenv("SFTPGO_HTTPD__COMPLIANCE__RETENTION", "48")
+	os.Setenv("SFTPGO_HTTPD__GRPC__PORT", "9443")
+	os.Setenv("SFTPGO_HTTPD__GRPC__CERTIFICATE_FILE", "grpc.crt")
+	os.Setenv("SFTPGO_HTTPD__GRPC__EVENTS_BUFFER_SIZE", "1024")
 	os.Setenv("SFTPGO_ACME__HTTP01_CHALLENGE__PORT", "5002")
+	os.Setenv("SFTPGO_COMMON__LATENCY_THROTTLING__MODE", "2")
+	os.Setenv("SFTPGO_COMMON__LATENCY_THROTTLING__S3_PUT_THRESHOLD", "1500")
+	os.Setenv("SFTPGO_COMMON__TEMP_FILES_CLEANUP__INTERVAL", "60")
+	os.Setenv("SFTPGO_COMMON__TEMP_FILES_CLEANUP__SCAN_STORAGES", "true")
+	os.Setenv("SFTPGO_COMMON__RESOURCE_LIMITS__MAX_RSS", "2048")
+	os.Setenv("SFTPGO_COMMON__RESOURCE_LIMITS__MAX_GOROUTINES", "50000")
+	os.Setenv("SFTPGO_COMMON__READ_CACHE__PATH", "/tmp/sftpgo_cache")
+	os.Setenv("SFTPGO_COMMON__READ_CACHE__TTL", "30")
+	os.Setenv("SFTPGO_COMMON__READ_CACHE__CONSISTENCY_MODE", "1")
+	os.Setenv("SFTPGO_COMMON__MULTIPART_UPLOADS__MAX_PART_SIZE", "100")
+	os.Setenv("SFTPGO_COMMON__MULTIPART_UPLOADS__MAX_CONCURRENCY", "8")
+	os.Setenv("SFTPGO_COMMON__MULTIPART_UPLOADS__AUTO_TUNE", "true")
+	os.Setenv("SFTPGO_COMMON__ZERO_COPY_DOWNLOADS__ENABLED", "true")
+	os.Setenv("SFTPGO_COMMON__ZERO_COPY_DOWNLOADS__MIN_BANDWIDTH", "51200")
 	t.Cleanup(func() {
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__0__ADDRESS")
 		os.Unsetenv("SFTPGO_WEBDAVD__BINDINGS__0__PORT")
@@ -1506,16 +2168,117 @@ func TestConfigFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_DATA_PROVIDER__POOL_SIZE")
 		os.Unsetenv("SFTPGO_DATA_PROVIDER__IS_SHARED")
 		os.Unsetenv("SFTPGO_DATA_PROVIDER__ACTIONS__EXECUTE_ON")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__SOFT_DELETE_RETENTION")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__READ_REPLICAS__CONNECTION_STRINGS")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__READ_REPLICAS__MAX_LAG")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__AUDIT__ENABLED")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__AUDIT__RETENTION_DAYS")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__DEAD_LETTERS__ENABLED")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__DEAD_LETTERS__RETENTION_DAYS")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP__URL")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP__CACHE_TTL")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP__GROUP_MAPPINGS__0__LDAP_GROUP")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP__GROUP_MAPPINGS__0__GROUP")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP__GROUP_MAPPINGS__0__TYPE")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__CACHE_INVALIDATION__DRIVER")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__CACHE_INVALIDATION__URL")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__SQLITE__JOURNAL_MODE")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__SQLITE__BUSY_TIMEOUT")
+		os.Unsetenv("SFTPGO_SFTPD__KERBEROS__KEYTAB")
+		os.Unsetenv("SFTPGO_SFTPD__KERBEROS__MAX_CLOCK_SKEW")
+		os.Unsetenv("SFTPGO_SFTPD__KERBEROS__PRINCIPAL_MAPPINGS__0__PATTERN")
+		os.Unsetenv("SFTPGO_SFTPD__KERBEROS__PRINCIPAL_MAPPINGS__0__USERNAME")
+		os.Unsetenv("SFTPGO_SFTPD__USER_CA__PRIVATE_KEY")
+		os.Unsetenv("SFTPGO_SFTPD__USER_CA__MAX_TTL")
+		os.Unsetenv("SFTPGO_SFTPD__USER_CA__PRINCIPAL_MAPPINGS__0__GROUP")
+		os.Unsetenv("SFTPGO_SFTPD__USER_CA__PRINCIPAL_MAPPINGS__0__PRINCIPALS")
 		os.Unsetenv("SFTPGO_KMS__SECRETS__URL")
 		os.Unsetenv("SFTPGO_KMS__SECRETS__MASTER_KEY_PATH")
 		os.Unsetenv("SFTPGO_TELEMETRY__TLS_CIPHER_SUITES")
+		os.Unsetenv("SFTPGO_TELEMETRY__PUSH__URL")
+		os.Unsetenv("SFTPGO_TELEMETRY__PUSH__LABELS")
+		os.Unsetenv("SFTPGO_TELEMETRY__USER_METRICS__ENABLED")
+		os.Unsetenv("SFTPGO_TELEMETRY__USER_METRICS__MAX_USERS")
+		os.Unsetenv("SFTPGO_TRACING__ENDPOINT")
+		os.Unsetenv("SFTPGO_TRACING__SAMPLE_RATIO")
+		os.Unsetenv("SFTPGO_AUDIT_LOG__FILE_PATH")
+		os.Unsetenv("SFTPGO_AUDIT_LOG__EVENTS")
+		os.Unsetenv("SFTPGO_SYSLOG__ADDRESS")
+		os.Unsetenv("SFTPGO_SYSLOG__NETWORK")
+		os.Unsetenv("SFTPGO_SYSLOG__FIELD_MAPPING")
 		os.Unsetenv("SFTPGO_HTTPD__SETUP__INSTALLATION_CODE")
+		os.Unsetenv("SFTPGO_HTTPD__TUS__ENABLED")
+		os.Unsetenv("SFTPGO_HTTPD__TUS__EXPIRATION_TIME")
+		os.Unsetenv("SFTPGO_HTTPD__TUS__STAGING_PATH")
+		os.Unsetenv("SFTPGO_HTTPD__UPLOADS__BUFFER_SIZE")
+		os.Unsetenv("SFTPGO_HTTPD__UPLOADS__MEMORY_BUDGET")
+		os.Unsetenv("SFTPGO_HTTPD__I18N__DEFAULT_LANGUAGE")
+		os.Unsetenv("SFTPGO_HTTPD__I18N__CATALOGS_PATH")
+		os.Unsetenv("SFTPGO_HTTPD__COMPLIANCE__SIGNING_KEY")
+		os.Unsetenv("SFTPGO_HTTPD__COMPLIANCE__RETENTION")
+		os.Unsetenv("SFTPGO_HTTPD__GRPC__PORT")
+		os.Unsetenv("SFTPGO_HTTPD__GRPC__CERTIFICATE_FILE")
+		os.Unsetenv("SFTPGO_HTTPD__GRPC__EVENTS_BUFFER_SIZE")
 		os.Unsetenv("SFTPGO_ACME__HTTP01_CHALLENGE_PORT")
+		os.Unsetenv("SFTPGO_COMMON__LATENCY_THROTTLING__MODE")
+		os.Unsetenv("SFTPGO_COMMON__LATENCY_THROTTLING__S3_PUT_THRESHOLD")
+		os.Unsetenv("SFTPGO_COMMON__TEMP_FILES_CLEANUP__INTERVAL")
+		os.Unsetenv("SFTPGO_COMMON__TEMP_FILES_CLEANUP__SCAN_STORAGES")
+		os.Unsetenv("SFTPGO_COMMON__RESOURCE_LIMITS__MAX_RSS")
+		os.Unsetenv("SFTPGO_COMMON__RESOURCE_LIMITS__MAX_GOROUTINES")
+		os.Unsetenv("SFTPGO_COMMON__READ_CACHE__PATH")
+		os.Unsetenv("SFTPGO_COMMON__READ_CACHE__TTL")
+		os.Unsetenv("SFTPGO_COMMON__READ_CACHE__CONSISTENCY_MODE")
+		os.Unsetenv("SFTPGO_COMMON__MULTIPART_UPLOADS__MAX_PART_SIZE")
+		os.Unsetenv("SFTPGO_COMMON__MULTIPART_UPLOADS__MAX_CONCURRENCY")
+		os.Unsetenv("SFTPGO_COMMON__MULTIPART_UPLOADS__AUTO_TUNE")
+		os.Unsetenv("SFTPGO_COMMON__ZERO_COPY_DOWNLOADS__ENABLED")
+		os.Unsetenv("SFTPGO_COMMON__ZERO_COPY_DOWNLOADS__MIN_BANDWIDTH")
 	})
 	err := config.LoadConfig(".", "invalid config")
 	assert.NoError(t, err)
+	latencyThrottling := config.GetCommonConfig().LatencyThrottling
+	assert.Equal(t, 2, latencyThrottling.Mode)
+	assert.Equal(t, 1500, latencyThrottling.S3PutThreshold)
+	assert.Equal(t, 0, latencyThrottling.DataProviderThreshold)
+	assert.Equal(t, 60, latencyThrottling.Window)
+	tempFilesCleanup := config.GetCommonConfig().TempFilesCleanup
+	assert.Equal(t, 60, tempFilesCleanup.Interval)
+	assert.Equal(t, 1440, tempFilesCleanup.MaxAge)
+	assert.True(t, tempFilesCleanup.ScanStorages)
+	resourceLimits := config.GetCommonConfig().ResourceLimits
+	assert.Equal(t, 2048, resourceLimits.MaxRSS)
+	assert.Equal(t, 50000, resourceLimits.MaxGoroutines)
+	assert.Equal(t, 10, resourceLimits.CheckInterval)
+	readCache := config.GetCommonConfig().ReadCache
+	assert.Equal(t, "/tmp/sftpgo_cache", readCache.Path)
+	assert.Equal(t, int64(1024), readCache.MaxSize)
+	assert.Equal(t, int64(0), readCache.MaxObjectSize)
+	assert.Equal(t, 30, readCache.TTL)
+	assert.Equal(t, 1, readCache.ConsistencyMode)
+	multipartUploads := config.GetCommonConfig().MultipartUploads
+	assert.Equal(t, int64(100), multipartUploads.MaxPartSize)
+	assert.Equal(t, 8, multipartUploads.MaxConcurrency)
+	assert.True(t, multipartUploads.AutoTune)
+	zeroCopy := config.GetCommonConfig().ZeroCopyDownloads
+	assert.True(t, zeroCopy.Enabled)
+	assert.Equal(t, int64(51200), zeroCopy.MinBandwidth)
 	sftpdConfig := config.GetSFTPDConfig()
 	assert.Equal(t, "127.0.0.1", sftpdConfig.Bindings[0].Address)
+	assert.Equal(t, "sftpgo.keytab", sftpdConfig.Kerberos.Keytab)
+	assert.Empty(t, sftpdConfig.Kerberos.ServicePrincipal)
+	assert.Equal(t, 120, sftpdConfig.Kerberos.MaxClockSkew)
+	if assert.Len(t, sftpdConfig.Kerberos.PrincipalMappings, 1) {
+		assert.Equal(t, `^(.+)@EXAMPLE\.COM$`, sftpdConfig.Kerberos.PrincipalMappings[0].Pattern)
+		assert.Equal(t, "$1", sftpdConfig.Kerberos.PrincipalMappings[0].Username)
+	}
+	assert.Equal(t, "user_ca", sftpdConfig.UserCA.PrivateKey)
+	assert.Equal(t, 60, sftpdConfig.UserCA.DefaultTTL)
+	assert.Equal(t, 720, sftpdConfig.UserCA.MaxTTL)
+	if assert.Len(t, sftpdConfig.UserCA.PrincipalMappings, 1) {
+		assert.Equal(t, "ops", sftpdConfig.UserCA.PrincipalMappings[0].Group)
+		assert.Equal(t, []string{"ops", "root"}, sftpdConfig.UserCA.PrincipalMappings[0].Principals)
+	}
 	assert.Equal(t, 12000, config.GetWebDAVDConfig().Bindings[0].Port)
 	dataProviderConf := config.GetProviderConf()
 	assert.Equal(t, uint32(41), dataProviderConf.PasswordHashing.Argon2Options.Iterations)
@@ -1523,6 +2286,31 @@ func TestConfigFromEnv(t *testing.T) {
 	assert.Equal(t, 1, dataProviderConf.IsShared)
 	assert.Len(t, dataProviderConf.Actions.ExecuteOn, 1)
 	assert.Contains(t, dataProviderConf.Actions.ExecuteOn, "add")
+	assert.Equal(t, 72, dataProviderConf.SoftDeleteRetention)
+	assert.Equal(t, []string{"postgres://replica1/sftpgo", "postgres://replica2/sftpgo"},
+		dataProviderConf.ReadReplicas.ConnectionStrings)
+	assert.Equal(t, 0, dataProviderConf.ReadReplicas.PoolSize)
+	assert.Equal(t, 5, dataProviderConf.ReadReplicas.MaxLag)
+	assert.True(t, dataProviderConf.Audit.Enabled)
+	assert.Equal(t, 90, dataProviderConf.Audit.RetentionDays)
+	assert.True(t, dataProviderConf.DeadLetters.Enabled)
+	assert.Equal(t, 30, dataProviderConf.DeadLetters.RetentionDays)
+	assert.Equal(t, "ldaps://ldap.example.com", dataProviderConf.LDAP.URL)
+	assert.Equal(t, 3600, dataProviderConf.LDAP.CacheTTL)
+	assert.Equal(t, 10, dataProviderConf.LDAP.Timeout)
+	assert.Equal(t, "memberOf", dataProviderConf.LDAP.GroupAttribute)
+	assert.Equal(t, []string{"*"}, dataProviderConf.LDAP.Permissions)
+	if assert.Len(t, dataProviderConf.LDAP.GroupMappings, 1) {
+		assert.Equal(t, "sftp-users", dataProviderConf.LDAP.GroupMappings[0].LDAPGroup)
+		assert.Equal(t, "users", dataProviderConf.LDAP.GroupMappings[0].Group)
+		assert.Equal(t, 1, dataProviderConf.LDAP.GroupMappings[0].Type)
+	}
+	assert.Equal(t, "nats", dataProviderConf.CacheInvalidation.Driver)
+	assert.Equal(t, "nats://127.0.0.1:4222", dataProviderConf.CacheInvalidation.URL)
+	assert.Equal(t, "sftpgo_cache_invalidation", dataProviderConf.CacheInvalidation.Channel)
+	assert.Equal(t, "WAL", dataProviderConf.SQLite.JournalMode)
+	assert.Equal(t, 10000, dataProviderConf.SQLite.BusyTimeout)
+	assert.Empty(t, dataProviderConf.SQLite.Synchronous)
 	kmsConfig := config.GetKMSConfig()
 	assert.Equal(t, "local", kmsConfig.Secrets.URL)
 	assert.Equal(t, "path", kmsConfig.Secrets.MasterKeyPath)
@@ -1530,7 +2318,60 @@ func TestConfigFromEnv(t *testing.T) {
 	assert.Len(t, telemetryConfig.TLSCipherSuites, 2)
 	assert.Equal(t, "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA", telemetryConfig.TLSCipherSuites[0])
 	assert.Equal(t, "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA", telemetryConfig.TLSCipherSuites[1])
+	assert.Equal(t, "http://pushgateway:9091", telemetryConfig.Push.URL)
+	assert.Equal(t, "sftpgo", telemetryConfig.Push.Job)
+	assert.Equal(t, 60, telemetryConfig.Push.Interval)
+	assert.Equal(t, []string{"region=eu", "instance=node1"}, telemetryConfig.Push.Labels)
+	assert.True(t, telemetryConfig.UserMetrics.Enabled)
+	assert.Equal(t, 500, telemetryConfig.UserMetrics.MaxUsers)
+	assert.Equal(t, 100, telemetryConfig.UserMetrics.MaxFolders)
+	tracingConfig := config.GetTracingConfig()
+	assert.Equal(t, "http://otel-collector:4318", tracingConfig.Endpoint)
+	assert.Equal(t, "sftpgo", tracingConfig.ServiceName)
+	assert.Equal(t, 0.25, tracingConfig.SampleRatio)
+	assert.Equal(t, 5, tracingConfig.ExportInterval)
+	auditLogConfig := config.GetAuditLogConfig()
+	assert.Equal(t, "audit.jsonl", auditLogConfig.FilePath)
+	assert.Equal(t, []string{"login", "admin_login"}, auditLogConfig.Events)
+	syslogConfig := config.GetSyslogConfig()
+	assert.Equal(t, "siem:6514", syslogConfig.Address)
+	assert.Equal(t, "tls", syslogConfig.Network)
+	assert.Equal(t, "cef", syslogConfig.Format)
+	assert.Equal(t, "local0", syslogConfig.Facility)
+	assert.Equal(t, []string{"username=duser", "country="}, syslogConfig.FieldMapping)
 	assert.Equal(t, "123", config.GetHTTPDConfig().Setup.InstallationCode)
+	assert.True(t, config.GetHTTPDConfig().TUS.Enabled)
+	assert.Equal(t, 60, config.GetHTTPDConfig().TUS.ExpirationTime)
+	assert.Equal(t, "/srv/shared/sftpgo", config.GetHTTPDConfig().TUS.StagingPath)
+	assert.Equal(t, int64(512), config.GetHTTPDConfig().Uploads.BufferSize)
+	assert.Equal(t, int64(64), config.GetHTTPDConfig().Uploads.MemoryBudget)
+	assert.Equal(t, "it", config.GetHTTPDConfig().I18n.DefaultLanguage)
+	assert.Equal(t, "catalogs", config.GetHTTPDConfig().I18n.CatalogsPath)
+	assert.Equal(t, "compliance_key", config.GetHTTPDConfig().Compliance.SigningKey)
+	assert.Equal(t, 48, config.GetHTTPDConfig().Compliance.Retention)
+	assert.Equal(t, 9443, config.GetHTTPDConfig().GRPC.Port)
+	assert.Equal(t, "grpc.crt", config.GetHTTPDConfig().GRPC.CertificateFile)
+	assert.Equal(t, 12, config.GetHTTPDConfig().GRPC.MinTLSVersion)
+	assert.Equal(t, 1024, config.GetHTTPDConfig().GRPC.EventsBufferSize)
 	acmeConfig := config.GetACMEConfig()
 	assert.Equal(t, 5002, acmeConfig.HTTP01Challenge.Port)
 }
+
+func TestGeoIPFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_COMMON__GEOIP__DATABASE_PATH", "/var/lib/GeoIP/GeoLite2-Country.mmdb")
+	os.Setenv("SFTPGO_S3D__BINDINGS__0__DENIED_COUNTRIES", "RU,CN")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_COMMON__GEOIP__DATABASE_PATH")
+		os.Unsetenv("SFTPGO_S3D__BINDINGS__0__DENIED_COUNTRIES")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	require.NoError(t, err)
+	require.Equal(t, "/var/lib/GeoIP/GeoLite2-Country.mmdb", config.GetCommonConfig().GeoIP.DatabasePath)
+	bindings := config.GetS3DConfig().Bindings
+	require.Len(t, bindings, 1)
+	require.Len(t, bindings[0].AllowedCountries, 0)
+	require.Equal(t, []string{"RU", "CN"}, bindings[0].DeniedCountries)
+}
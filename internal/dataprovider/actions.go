@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os/exec"
 	"path/filepath"
@@ -26,7 +27,9 @@ import (
 
 	"github.com/sftpgo/sdk/plugin/notifier"
 
+	"github.com/drakkan/sftpgo/v2/internal/auditlog"
 	"github.com/drakkan/sftpgo/v2/internal/command"
+	"github.com/drakkan/sftpgo/v2/internal/eventstream"
 	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
@@ -58,6 +61,16 @@ var (
 )
 
 func executeAction(operation, executor, ip, objectType, objectName string, object plugin.Renderer) {
+	auditlog.Add(auditlog.Record{
+		Event:    auditlog.EventAdminAction,
+		Username: executor,
+		IP:       ip,
+		Details: map[string]string{
+			"action":      operation,
+			"object_type": objectType,
+			"object_name": objectName,
+		},
+	})
 	if plugin.Handler.HasNotifiers() {
 		plugin.Handler.NotifyProviderEvent(&notifier.ProviderEvent{
 			Action:     operation,
@@ -68,6 +81,16 @@ func executeAction(operation, executor, ip, objectType, objectName string, objec
 			Timestamp:  time.Now().UnixNano(),
 		}, object)
 	}
+	if eventstream.HasSubscribers() {
+		eventstream.PublishProviderEvent(&notifier.ProviderEvent{
+			Action:     operation,
+			Username:   executor,
+			ObjectType: objectType,
+			ObjectName: objectName,
+			IP:         ip,
+			Timestamp:  time.Now().UnixNano(),
+		})
+	}
 	if fnHandleRuleForProviderEvent != nil {
 		fnHandleRuleForProviderEvent(operation, executor, ip, objectType, objectName, object)
 	}
@@ -112,9 +135,16 @@ func executeAction(operation, executor, ip, objectType, objectName string, objec
 			if err == nil {
 				respCode = resp.StatusCode
 				resp.Body.Close()
+
+				if respCode < http.StatusOK || respCode > http.StatusNoContent {
+					err = fmt.Errorf("unexpected status code: %d", respCode)
+				}
 			}
 			providerLog(logger.LevelDebug, "notified operation %#v to URL: %v status code: %v, elapsed: %v err: %v",
 				operation, url.Redacted(), respCode, time.Since(startTime), err)
+			if err != nil {
+				AddHookDeadLetter(DeadLetterSourceProviderHook, operation, url.String(), dataAsJSON, respCode, err)
+			}
 		} else {
 			executeNotificationCommand(operation, executor, ip, objectType, objectName, dataAsJSON) //nolint:errcheck // the error is used in test cases only
 		}
@@ -143,7 +173,7 @@ func executeNotificationCommand(operation, executor, ip, objectType, objectName
 		fmt.Sprintf("SFTPGO_PROVIDER_OBJECT=%s", string(objectAsJSON)))
 
 	startTime := time.Now()
-	err := cmd.Run()
+	err := command.Run(cmd, command.HookProviderActions)
 	providerLog(logger.LevelDebug, "executed command %#v, elapsed: %v, error: %v", config.Actions.Hook,
 		time.Since(startTime), err)
 	return err
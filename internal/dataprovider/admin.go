@@ -57,6 +57,18 @@ const (
 	PermAdminMetadataChecks   = "metadata_checks"
 	PermAdminViewEvents       = "view_events"
 	PermAdminManageEventRules = "manage_event_rules"
+	PermAdminImpersonateUsers = "impersonate_users"
+	// PermAdminAuditor is a built-in read-only role, it cannot be combined
+	// with other permissions
+	PermAdminAuditor = "auditor"
+	// PermAdminExportData allows to download data backups. It cannot be assigned
+	// directly, it is granted by the manage_system permission. Backups include
+	// credentials and secrets so this permission is not granted to auditors
+	PermAdminExportData = "export_data"
+	// PermAdminComplianceExport allows to generate and download compliance
+	// evidence bundles. It cannot be assigned directly, it is granted by the
+	// manage_system permission and by the auditor role
+	PermAdminComplianceExport = "compliance_export"
 )
 
 const (
@@ -73,9 +85,25 @@ var (
 		PermAdminViewUsers, PermAdminManageGroups, PermAdminViewConnections, PermAdminCloseConnections,
 		PermAdminViewServerStatus, PermAdminManageAdmins, PermAdminManageAPIKeys, PermAdminQuotaScans,
 		PermAdminManageSystem, PermAdminManageDefender, PermAdminViewDefender, PermAdminRetentionChecks,
-		PermAdminMetadataChecks, PermAdminViewEvents}
+		PermAdminMetadataChecks, PermAdminViewEvents, PermAdminImpersonateUsers, PermAdminAuditor}
+	auditorAdminPerms = []string{PermAdminViewUsers, PermAdminViewConnections, PermAdminViewServerStatus,
+		PermAdminViewDefender, PermAdminViewEvents, PermAdminComplianceExport}
 )
 
+// HasAdminPermission returns true if the specified permissions grant perm
+func HasAdminPermission(permissions []string, perm string) bool {
+	if util.Contains(permissions, PermAdminAny) {
+		return true
+	}
+	if util.Contains(permissions, PermAdminAuditor) {
+		return util.Contains(auditorAdminPerms, perm)
+	}
+	if perm == PermAdminExportData || perm == PermAdminComplianceExport {
+		return util.Contains(permissions, PermAdminManageSystem)
+	}
+	return util.Contains(permissions, perm)
+}
+
 // AdminTOTPConfig defines the time-based one time password configuration
 type AdminTOTPConfig struct {
 	Enabled    bool        `json:"enabled,omitempty"`
@@ -107,6 +135,20 @@ func (c *AdminTOTPConfig) validate(username string) error {
 	return nil
 }
 
+// AdminPushConfig defines the push-based second factor configuration.
+// If enabled the login to the WebAdmin, and the REST API tokens, must be
+// approved using the push provider configured in the MFA section
+type AdminPushConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+func (c *AdminPushConfig) validate() error {
+	if c.Enabled && !mfa.IsPushEnabled() {
+		return util.NewValidationError("push: push authentication is not enabled")
+	}
+	return nil
+}
+
 // AdminPreferences defines the admin preferences
 type AdminPreferences struct {
 	// Allow to hide some sections from the user page.
@@ -124,6 +166,9 @@ type AdminPreferences struct {
 	//
 	// The settings can be combined
 	HideUserPageSections int `json:"hide_user_page_sections,omitempty"`
+	// Theme for the WebAdmin UI: "dark" or "high-contrast".
+	// Empty means the default light theme
+	Theme string `json:"theme,omitempty"`
 }
 
 // HideGroups returns true if the groups section should be hidden
@@ -197,8 +242,16 @@ type AdminFilters struct {
 	// Recovery codes to use if the user loses access to their second factor auth device.
 	// Each code can only be used once, you should use these codes to login and disable or
 	// reset 2FA for your account
-	RecoveryCodes []RecoveryCode   `json:"recovery_codes,omitempty"`
-	Preferences   AdminPreferences `json:"preferences"`
+	RecoveryCodes []RecoveryCode `json:"recovery_codes,omitempty"`
+	// WebAuthn credentials, for example hardware security keys, usable as second
+	// factor for the WebAdmin
+	WebAuthnCredentials []mfa.WebAuthnCredential `json:"webauthn_credentials,omitempty"`
+	// Push-based second factor configuration
+	PushConfig  AdminPushConfig  `json:"push_config,omitempty"`
+	Preferences AdminPreferences `json:"preferences"`
+	// Preferred language for emails and the web interfaces, for example "de" or "pt-br".
+	// Empty means the default language
+	Language string `json:"language,omitempty"`
 }
 
 // AdminGroupMappingOptions defines the options for admin/group mapping
@@ -317,6 +370,8 @@ func (a *Admin) validatePermissions() error {
 	}
 	if util.Contains(a.Permissions, PermAdminAny) {
 		a.Permissions = []string{PermAdminAny}
+	} else if util.Contains(a.Permissions, PermAdminAuditor) {
+		a.Permissions = []string{PermAdminAuditor}
 	}
 	for _, perm := range a.Permissions {
 		if !util.Contains(validAdminPerms, perm) {
@@ -365,6 +420,18 @@ func (a *Admin) validate() error {
 	if err := a.validateRecoveryCodes(); err != nil {
 		return err
 	}
+	if err := validateWebAuthnCredentials(a.Filters.WebAuthnCredentials); err != nil {
+		return err
+	}
+	if err := a.Filters.PushConfig.validate(); err != nil {
+		return err
+	}
+	if err := validateLanguage(&a.Filters.Language); err != nil {
+		return err
+	}
+	if err := validateUITheme(&a.Filters.Preferences.Theme); err != nil {
+		return err
+	}
 	if config.NamingRules&1 == 0 && !usernameRegex.MatchString(a.Username) {
 		return util.NewValidationError(fmt.Sprintf("username %#v is not valid, the following characters are allowed: a-zA-Z0-9-_.~", a.Username))
 	}
@@ -513,10 +580,7 @@ func (a *Admin) SetNilSecretsIfEmpty() {
 
 // HasPermission returns true if the admin has the specified permission
 func (a *Admin) HasPermission(perm string) bool {
-	if util.Contains(a.Permissions, PermAdminAny) {
-		return true
-	}
-	return util.Contains(a.Permissions, perm)
+	return HasAdminPermission(a.Permissions, perm)
 }
 
 // GetPermissionsAsString returns permission as string
@@ -544,7 +608,41 @@ func (a *Admin) GetValidPerms() []string {
 
 // CanManageMFA returns true if the admin can add a multi-factor authentication configuration
 func (a *Admin) CanManageMFA() bool {
-	return len(mfa.GetAvailableTOTPConfigs()) > 0
+	return len(mfa.GetAvailableTOTPConfigs()) > 0 || mfa.IsWebAuthnEnabled()
+}
+
+// HasWebAuthnCredentials returns true if the admin can login to the WebAdmin
+// using a WebAuthn credential as second factor
+func (a *Admin) HasWebAuthnCredentials() bool {
+	return mfa.IsWebAuthnEnabled() && len(a.Filters.WebAuthnCredentials) > 0
+}
+
+// IsPushEnabled returns true if the push-based second factor is enabled
+// for this admin
+func (a *Admin) IsPushEnabled() bool {
+	return mfa.IsPushEnabled() && a.Filters.PushConfig.Enabled
+}
+
+// MustSetSecondFactor returns true if the configured admin policy requires a
+// second factor that the admin has not configured yet
+func (a *Admin) MustSetSecondFactor() bool {
+	switch mfa.GetAdminPolicy() {
+	case mfa.AdminPolicyRequireTwoFactor:
+		return !a.Filters.TOTPConfig.Enabled && !a.HasWebAuthnCredentials() && !a.IsPushEnabled()
+	case mfa.AdminPolicyRequireWebAuthn:
+		return !a.HasWebAuthnCredentials()
+	default:
+		return false
+	}
+}
+
+// GetWebAuthnUser returns the WebAuthn representation of this admin
+func (a *Admin) GetWebAuthnUser() *mfa.WebAuthnUser {
+	return &mfa.WebAuthnUser{
+		Name:        a.Username,
+		IsAdmin:     true,
+		Credentials: a.Filters.WebAuthnCredentials,
+	}
 }
 
 // GetSignature returns a signature for this admin.
@@ -577,9 +675,13 @@ func (a *Admin) getACopy() Admin {
 			Used:   code.Used,
 		})
 	}
+	filters.WebAuthnCredentials = copyWebAuthnCredentials(a.Filters.WebAuthnCredentials)
+	filters.PushConfig.Enabled = a.Filters.PushConfig.Enabled
 	filters.Preferences = AdminPreferences{
 		HideUserPageSections: a.Filters.Preferences.HideUserPageSections,
+		Theme:                a.Filters.Preferences.Theme,
 	}
+	filters.Language = a.Filters.Language
 	groups := make([]AdminGroupMapping, 0, len(a.Groups))
 	for _, g := range a.Groups {
 		groups = append(groups, AdminGroupMapping{
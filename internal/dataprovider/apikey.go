@@ -38,6 +38,56 @@ const (
 	APIKeyScopeUser
 )
 
+// Resources that can be used to define granular API key scopes.
+// A granular scope is in the form "<resource>:<access>", for example
+// "users:read" or "eventrules:write". Write access implies read access
+const (
+	APIKeyResourceUsers       = "users"
+	APIKeyResourceFolders     = "folders"
+	APIKeyResourceGroups      = "groups"
+	APIKeyResourceAdmins      = "admins"
+	APIKeyResourceQuota       = "quota"
+	APIKeyResourceConnections = "connections"
+	APIKeyResourceDefender    = "defender"
+	APIKeyResourceEventRules  = "eventrules"
+	APIKeyResourceEvents      = "events"
+	APIKeyResourceRetention   = "retention"
+	APIKeyResourceMetadata    = "metadata"
+	APIKeyResourceStatus      = "status"
+	APIKeyResourceSystem      = "system"
+	APIKeyResourceFiles       = "files"
+	APIKeyResourceShares      = "shares"
+)
+
+// Supported access levels for granular API key scopes
+const (
+	APIKeyAccessRead  = "read"
+	APIKeyAccessWrite = "write"
+)
+
+var (
+	apiKeyAdminResources = []string{APIKeyResourceUsers, APIKeyResourceFolders, APIKeyResourceGroups,
+		APIKeyResourceAdmins, APIKeyResourceQuota, APIKeyResourceConnections, APIKeyResourceDefender,
+		APIKeyResourceEventRules, APIKeyResourceEvents, APIKeyResourceRetention, APIKeyResourceMetadata,
+		APIKeyResourceStatus, APIKeyResourceSystem}
+	apiKeyUserResources = []string{APIKeyResourceFiles, APIKeyResourceShares, APIKeyResourceQuota}
+)
+
+// GetAPIKeyScopes returns the granular scopes supported for the specified
+// API key scope
+func GetAPIKeyScopes(scope APIKeyScope) []string {
+	resources := apiKeyAdminResources
+	if scope == APIKeyScopeUser {
+		resources = apiKeyUserResources
+	}
+	scopes := make([]string, 0, 2*len(resources))
+	for _, resource := range resources {
+		scopes = append(scopes, fmt.Sprintf("%s:%s", resource, APIKeyAccessRead),
+			fmt.Sprintf("%s:%s", resource, APIKeyAccessWrite))
+	}
+	return scopes
+}
+
 // APIKey defines a SFTPGo API key.
 // API keys can be used as authentication alternative to short lived tokens
 // for REST API
@@ -66,6 +116,9 @@ type APIKey struct {
 	// Admin username associated with this API key.
 	// If empty and the scope is APIKeyScopeAdmin the key is valid for any admin
 	Admin string `json:"admin,omitempty"`
+	// Granular scopes, for example "users:read", restricting the REST API
+	// routes allowed for this key. Empty means no restrictions
+	Scopes []string `json:"scopes"`
 	// these fields are for internal use
 	userID   int64
 	adminID  int64
@@ -73,6 +126,9 @@ type APIKey struct {
 }
 
 func (k *APIKey) getACopy() APIKey {
+	scopes := make([]string, len(k.Scopes))
+	copy(scopes, k.Scopes)
+
 	return APIKey{
 		ID:          k.ID,
 		KeyID:       k.KeyID,
@@ -86,6 +142,7 @@ func (k *APIKey) getACopy() APIKey {
 		Description: k.Description,
 		User:        k.User,
 		Admin:       k.Admin,
+		Scopes:      scopes,
 		userID:      k.userID,
 		adminID:     k.adminID,
 	}
@@ -139,6 +196,56 @@ func (k *APIKey) generateKey() {
 	k.plainKey = k.Key
 }
 
+// GetLastUseAsString returns the last use date as string
+func (k *APIKey) GetLastUseAsString() string {
+	if k.LastUseAt > 0 {
+		return util.GetTimeFromMsecSinceEpoch(k.LastUseAt).UTC().Format(iso8601UTCFormat)
+	}
+	return ""
+}
+
+// GetExpirationAsString returns the expiration date as string
+func (k *APIKey) GetExpirationAsString() string {
+	if k.ExpiresAt > 0 {
+		return util.GetTimeFromMsecSinceEpoch(k.ExpiresAt).UTC().Format(iso8601UTCFormat)
+	}
+	return ""
+}
+
+// GetScopesAsString returns the granular scopes as comma separated string
+func (k *APIKey) GetScopesAsString() string {
+	if len(k.Scopes) == 0 {
+		return "*"
+	}
+	return strings.Join(k.Scopes, ", ")
+}
+
+// HasScopeAccess returns true if the key can access the specified resource.
+// Keys without granular scopes can access any resource
+func (k *APIKey) HasScopeAccess(resource string, write bool) bool {
+	if len(k.Scopes) == 0 {
+		return true
+	}
+	if util.Contains(k.Scopes, fmt.Sprintf("%s:%s", resource, APIKeyAccessWrite)) {
+		return true
+	}
+	if write {
+		return false
+	}
+	return util.Contains(k.Scopes, fmt.Sprintf("%s:%s", resource, APIKeyAccessRead))
+}
+
+func (k *APIKey) validateScopes() error {
+	k.Scopes = util.RemoveDuplicates(k.Scopes, true)
+	validScopes := GetAPIKeyScopes(k.Scope)
+	for _, scope := range k.Scopes {
+		if !util.Contains(validScopes, scope) {
+			return util.NewValidationError(fmt.Sprintf("invalid API key scope %q", scope))
+		}
+	}
+	return nil
+}
+
 // DisplayKey returns the key to show to the user
 func (k *APIKey) DisplayKey() string {
 	return fmt.Sprintf("%v.%v", k.KeyID, k.plainKey)
@@ -151,6 +258,9 @@ func (k *APIKey) validate() error {
 	if k.Scope != APIKeyScopeAdmin && k.Scope != APIKeyScopeUser {
 		return util.NewValidationError(fmt.Sprintf("invalid scope: %v", k.Scope))
 	}
+	if err := k.validateScopes(); err != nil {
+		return err
+	}
 	k.generateKey()
 	if err := k.hashKey(); err != nil {
 		return err
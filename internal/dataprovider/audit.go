@@ -0,0 +1,406 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	auditRedactedValue = "[redacted]"
+	// auditObjectSSHCertificate identifies the SSH user certificates issued by
+	// the built-in certificate authority
+	auditObjectSSHCertificate = "ssh_certificate"
+	auditActionIssue          = "issue"
+)
+
+var (
+	// object types recorded in the audit trail
+	auditObjectTypes = []string{actionObjectUser, actionObjectGroup, actionObjectFolder, actionObjectAdmin,
+		actionObjectEventRule, auditObjectSSHCertificate}
+	// fields updated by the system and not by the executor of an action
+	auditIgnoredFields = []string{"id", "created_at", "updated_at", "last_login", "last_quota_update",
+		"used_quota_size", "used_quota_files", "used_upload_data_transfer", "used_download_data_transfer",
+		"first_download", "first_upload", "deleted_at", "filters.password_changed_at"}
+	// fields whose values are never stored, only the fact that they changed
+	auditRedactedFields = []string{"password"}
+)
+
+// AuditConfig defines the configuration for the audit trail
+type AuditConfig struct {
+	// Set to true to record the changes to users, groups, folders, admins and
+	// event rules in the data provider
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Number of days the audit entries are retained, older entries are
+	// automatically removed. 0 means the entries are never removed
+	RetentionDays int `json:"retention_days" mapstructure:"retention_days"`
+}
+
+func (c *AuditConfig) validate() error {
+	if c.RetentionDays < 0 {
+		return fmt.Errorf("invalid audit retention days: %d", c.RetentionDays)
+	}
+	return nil
+}
+
+// AuditChange defines a changed field. Nested fields are identified using a
+// dot separated path, for example "filters.denied_protocols"
+type AuditChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// AuditEntry defines a change to an object recorded in the audit trail
+type AuditEntry struct {
+	ID int64 `json:"id"`
+	// Unix timestamp in milliseconds
+	Timestamp  int64  `json:"timestamp"`
+	Action     string `json:"action"`
+	ObjectType string `json:"object_type"`
+	ObjectName string `json:"object_name"`
+	// Username of the admin, or user, that executed the action
+	Executor string        `json:"executor"`
+	IP       string        `json:"ip,omitempty"`
+	Changes  []AuditChange `json:"changes,omitempty"`
+}
+
+func (e *AuditEntry) getChangesAsJSON() (string, error) {
+	if len(e.Changes) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(e.Changes)
+	return string(data), err
+}
+
+func (e *AuditEntry) setChangesFromJSON(data string) error {
+	if data == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(data), &e.Changes)
+}
+
+// AuditFilters defines the supported filters for the audit trail queries.
+// Empty values are ignored
+type AuditFilters struct {
+	ObjectType string
+	ObjectName string
+	Executor   string
+	Action     string
+	// Unix timestamp in milliseconds, entries recorded before this time are excluded
+	StartTimestamp int64
+	// Unix timestamp in milliseconds, entries recorded after this time are excluded
+	EndTimestamp int64
+}
+
+// Validate returns an error if the filters are not valid
+func (f *AuditFilters) Validate() error {
+	if f.ObjectType != "" && !util.Contains(auditObjectTypes, f.ObjectType) {
+		return util.NewValidationError(fmt.Sprintf("invalid object type %q", f.ObjectType))
+	}
+	if f.EndTimestamp > 0 && f.StartTimestamp > f.EndTimestamp {
+		return util.NewValidationError("the start timestamp cannot be after the end timestamp")
+	}
+	return nil
+}
+
+func (f *AuditFilters) matches(e *AuditEntry) bool {
+	if f.ObjectType != "" && f.ObjectType != e.ObjectType {
+		return false
+	}
+	if f.ObjectName != "" && f.ObjectName != e.ObjectName {
+		return false
+	}
+	if f.Executor != "" && f.Executor != e.Executor {
+		return false
+	}
+	if f.Action != "" && f.Action != e.Action {
+		return false
+	}
+	if f.StartTimestamp > 0 && e.Timestamp < f.StartTimestamp {
+		return false
+	}
+	if f.EndTimestamp > 0 && e.Timestamp > f.EndTimestamp {
+		return false
+	}
+	return true
+}
+
+// IsAuditEnabled returns true if the audit trail is enabled
+func IsAuditEnabled() bool {
+	return config.Audit.Enabled
+}
+
+// GetAuditEntries returns the audit entries matching the specified filters
+// respecting limit and offset
+func GetAuditEntries(filters AuditFilters, limit, offset int, order string) ([]AuditEntry, error) {
+	if err := filters.Validate(); err != nil {
+		return nil, err
+	}
+	return provider.getAuditEntries(filters, limit, offset, order)
+}
+
+// auditState is the state of an object flattened as field path -> JSON value
+type auditState map[string]any
+
+// getAuditState returns the current state of the specified object. It returns
+// nil if the audit trail is disabled or the object cannot be loaded
+func getAuditState(objectType, objectName string) auditState {
+	if !config.Audit.Enabled {
+		return nil
+	}
+	var object any
+	var password string
+
+	switch objectType {
+	case actionObjectUser:
+		user, err := provider.userExists(objectName)
+		if err != nil {
+			providerLog(logger.LevelWarn, "audit: unable to load user %q: %v", objectName, err)
+			return nil
+		}
+		password = user.Password
+		user.PrepareForRendering()
+		object = &user
+	case actionObjectGroup:
+		group, err := provider.groupExists(objectName)
+		if err != nil {
+			providerLog(logger.LevelWarn, "audit: unable to load group %q: %v", objectName, err)
+			return nil
+		}
+		group.PrepareForRendering()
+		object = &group
+	case actionObjectFolder:
+		folder, err := provider.getFolderByName(objectName)
+		if err != nil {
+			providerLog(logger.LevelWarn, "audit: unable to load folder %q: %v", objectName, err)
+			return nil
+		}
+		folder.PrepareForRendering()
+		object = &folder
+	case actionObjectAdmin:
+		admin, err := provider.adminExists(objectName)
+		if err != nil {
+			providerLog(logger.LevelWarn, "audit: unable to load admin %q: %v", objectName, err)
+			return nil
+		}
+		password = admin.Password
+		admin.HideConfidentialData()
+		object = &admin
+	case actionObjectEventRule:
+		rule, err := provider.eventRuleExists(objectName)
+		if err != nil {
+			providerLog(logger.LevelWarn, "audit: unable to load event rule %q: %v", objectName, err)
+			return nil
+		}
+		rule.PrepareForRendering()
+		object = &rule
+	default:
+		return nil
+	}
+	state, err := newAuditState(object)
+	if err != nil {
+		providerLog(logger.LevelError, "audit: unable to get the state for %s %q: %v", objectType, objectName, err)
+		return nil
+	}
+	if password != "" {
+		// the password hash is not stored, we only need to detect changes
+		digest := sha256.Sum256([]byte(password))
+		state["password"] = hex.EncodeToString(digest[:])
+	}
+	return state
+}
+
+func newAuditState(object any) (auditState, error) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	state := make(auditState)
+	state.flatten("", fields)
+	return state, nil
+}
+
+// flatten adds the specified fields to the state, nested objects are expanded
+// while lists are stored as a single value
+func (s auditState) flatten(prefix string, fields map[string]any) {
+	for k, v := range fields {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if nested, ok := v.(map[string]any); ok && len(nested) > 0 {
+			s.flatten(key, nested)
+			continue
+		}
+		s[key] = v
+	}
+}
+
+// getAuditChanges returns the differences between two states, a nil state
+// means that the object does not exist
+func getAuditChanges(before, after auditState) []AuditChange {
+	var changes []AuditChange
+
+	for field, value := range after {
+		if util.Contains(auditIgnoredFields, field) {
+			continue
+		}
+		oldValue, ok := before[field]
+		if ok && reflect.DeepEqual(oldValue, value) {
+			continue
+		}
+		changes = append(changes, newAuditChange(field, oldValue, value))
+	}
+	for field, value := range before {
+		if util.Contains(auditIgnoredFields, field) {
+			continue
+		}
+		if _, ok := after[field]; !ok {
+			changes = append(changes, newAuditChange(field, value, nil))
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+func newAuditChange(field string, before, after any) AuditChange {
+	if util.Contains(auditRedactedFields, field) {
+		if before != nil {
+			before = auditRedactedValue
+		}
+		if after != nil {
+			after = auditRedactedValue
+		}
+	}
+	return AuditChange{
+		Field:  field,
+		Before: before,
+		After:  after,
+	}
+}
+
+// addAuditEntry records an action in the audit trail. The state after the
+// action is loaded from the data provider, before is the state returned by
+// getAuditState before the action was executed
+func addAuditEntry(action, executor, ip, objectType, objectName string, before auditState) {
+	if !config.Audit.Enabled {
+		return
+	}
+	var after auditState
+	if action != operationDelete {
+		after = getAuditState(objectType, objectName)
+	}
+	entry := AuditEntry{
+		Timestamp:  util.GetTimeAsMsSinceEpoch(time.Now()),
+		Action:     action,
+		ObjectType: objectType,
+		ObjectName: objectName,
+		Executor:   executor,
+		IP:         ip,
+		Changes:    getAuditChanges(before, after),
+	}
+	if err := provider.addAuditEntry(&entry); err != nil {
+		providerLog(logger.LevelError, "unable to add audit entry for action %q, %s %q, executor %q: %v",
+			action, objectType, objectName, executor, err)
+	}
+}
+
+// AddSSHCertificateAuditEntry records an SSH certificate issued for the specified
+// user in the audit trail. The certificate details are recorded as changes with
+// no before value
+func AddSSHCertificateAuditEntry(username, executor, ip string, details map[string]any) {
+	if !config.Audit.Enabled {
+		return
+	}
+	entry := AuditEntry{
+		Timestamp:  util.GetTimeAsMsSinceEpoch(time.Now()),
+		Action:     auditActionIssue,
+		ObjectType: auditObjectSSHCertificate,
+		ObjectName: username,
+		Executor:   executor,
+		IP:         ip,
+		Changes:    getAuditChanges(nil, details),
+	}
+	if err := provider.addAuditEntry(&entry); err != nil {
+		providerLog(logger.LevelError, "unable to add audit entry for the SSH certificate issued for user %q, executor %q: %v",
+			username, executor, err)
+	}
+}
+
+func removeExpiredAuditEntries() error {
+	before := util.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Duration(config.Audit.RetentionDays) * 24 * time.Hour))
+	err := provider.cleanupAuditEntries(before)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to remove expired audit entries: %v", err)
+	} else {
+		providerLog(logger.LevelDebug, "expired audit entries removed, before: %s", util.GetTimeFromMsecSinceEpoch(before))
+	}
+	return err
+}
+
+// getAuditEntriesFromList returns the entries, ordered by ID, matching the
+// specified filters respecting limit and offset. It is used by the providers
+// that cannot filter the entries within the queries
+func getAuditEntriesFromList(entries []AuditEntry, filters AuditFilters, limit, offset int, order string) []AuditEntry {
+	result := make([]AuditEntry, 0, limit)
+	iterate := func(idx int) bool {
+		if !filters.matches(&entries[idx]) {
+			return true
+		}
+		if offset > 0 {
+			offset--
+			return true
+		}
+		result = append(result, entries[idx])
+		return len(result) < limit
+	}
+	if order == OrderASC {
+		for idx := 0; idx < len(entries); idx++ {
+			if !iterate(idx) {
+				break
+			}
+		}
+	} else {
+		for idx := len(entries) - 1; idx >= 0; idx-- {
+			if !iterate(idx) {
+				break
+			}
+		}
+	}
+	return result
+}
+
+// getSequenceKey returns the key used to store the objects identified by a
+// sequence, such as audit entries and dead letters, within the
+// key/value based providers, keys are sorted as the IDs
+func getSequenceKey(id int64) []byte {
+	return []byte(fmt.Sprintf("%020d", id))
+}
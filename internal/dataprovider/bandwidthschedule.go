@@ -0,0 +1,114 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// BandwidthSchedule defines the bandwidth limits to apply within a time window.
+// The window uses the same cron-like syntax as the event rules schedules, for
+// example hour "9-17" and day of week "1-5" means from 9:00 to 17:59 UTC,
+// Monday to Friday. Empty fields mean "*"
+type BandwidthSchedule struct {
+	Hours      string `json:"hour,omitempty"`
+	DayOfWeek  string `json:"day_of_week,omitempty"`
+	DayOfMonth string `json:"day_of_month,omitempty"`
+	Month      string `json:"month,omitempty"`
+	// Maximum upload bandwidth as KB/s, 0 means unlimited
+	UploadBandwidth int64 `json:"upload_bandwidth"`
+	// Maximum download bandwidth as KB/s, 0 means unlimited
+	DownloadBandwidth int64 `json:"download_bandwidth"`
+}
+
+func (s *BandwidthSchedule) getCronSpec() string {
+	fields := []string{s.Hours, s.DayOfMonth, s.Month, s.DayOfWeek}
+	for idx := range fields {
+		if fields[idx] == "" {
+			fields[idx] = "*"
+		}
+	}
+	return fmt.Sprintf("* %s %s %s %s", fields[0], fields[1], fields[2], fields[3])
+}
+
+func (s *BandwidthSchedule) validate() error {
+	if _, err := cron.ParseStandard(s.getCronSpec()); err != nil {
+		return util.NewValidationError(fmt.Sprintf("invalid bandwidth schedule, hour: %q, day of month: %q, month: %q, day of week: %q",
+			s.Hours, s.DayOfMonth, s.Month, s.DayOfWeek))
+	}
+	if s.UploadBandwidth < 0 || s.DownloadBandwidth < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid bandwidth schedule %q, negative limits are not allowed",
+			s.getCronSpec()))
+	}
+	return nil
+}
+
+// IsActive returns true if the specified time is within the schedule window
+func (s *BandwidthSchedule) IsActive(t time.Time) bool {
+	schedule, err := cron.ParseStandard(s.getCronSpec())
+	if err != nil {
+		return false
+	}
+	minute := t.UTC().Truncate(time.Minute)
+	return schedule.Next(minute.Add(-time.Second)).Equal(minute)
+}
+
+func (s *BandwidthSchedule) getACopy() BandwidthSchedule {
+	return BandwidthSchedule{
+		Hours:             s.Hours,
+		DayOfWeek:         s.DayOfWeek,
+		DayOfMonth:        s.DayOfMonth,
+		Month:             s.Month,
+		UploadBandwidth:   s.UploadBandwidth,
+		DownloadBandwidth: s.DownloadBandwidth,
+	}
+}
+
+func copyBandwidthSchedules(schedules []BandwidthSchedule) []BandwidthSchedule {
+	if len(schedules) == 0 {
+		return nil
+	}
+	result := make([]BandwidthSchedule, 0, len(schedules))
+	for idx := range schedules {
+		result = append(result, schedules[idx].getACopy())
+	}
+	return result
+}
+
+func validateBandwidthSchedules(schedules []BandwidthSchedule) error {
+	for idx := range schedules {
+		if err := schedules[idx].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetBandwidthForTime returns the upload and download bandwidth to apply at the
+// specified time. The first active bandwidth schedule overrides the given limits
+func (u *User) GetBandwidthForTime(t time.Time, uploadBandwidth, downloadBandwidth int64) (int64, int64) {
+	for idx := range u.Filters.BandwidthSchedules {
+		schedule := &u.Filters.BandwidthSchedules[idx]
+		if schedule.IsActive(t) {
+			return schedule.UploadBandwidth, schedule.DownloadBandwidth
+		}
+	}
+	return uploadBandwidth, downloadBandwidth
+}
@@ -0,0 +1,129 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nobolt
+// +build !nobolt
+
+package dataprovider
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func initializeBoltTestProvider(t *testing.T) *BoltProvider {
+	cnf := getTestProviderConfig(BoltDataProviderName, filepath.Join(t.TempDir(), "sftpgo_test.db"))
+	err := InitializeDatabase(cnf, t.TempDir())
+	require.NoError(t, err)
+	p, ok := provider.(*BoltProvider)
+	require.True(t, ok)
+	t.Cleanup(func() {
+		assert.NoError(t, p.close())
+	})
+	return p
+}
+
+func hasBoltTestBucket(t *testing.T, p *BoltProvider, name string) bool {
+	var found bool
+	err := p.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket([]byte(name)) != nil
+		return nil
+	})
+	require.NoError(t, err)
+	return found
+}
+
+func TestBoltProviderCRUD(t *testing.T) {
+	p := initializeBoltTestProvider(t)
+
+	testProviderUsersFoldersGroups(t, p)
+	testProviderAdmins(t, p)
+}
+
+func TestBoltTransactions(t *testing.T) {
+	p := initializeBoltTestProvider(t)
+
+	testKVStoreTransactions(t, p.dbHandle)
+}
+
+func TestBoltAuditAndDeadLetters(t *testing.T) {
+	p := initializeBoltTestProvider(t)
+
+	testProviderAuditAndDeadLetters(t, p)
+}
+
+func TestBoltMigrations(t *testing.T) {
+	p := initializeBoltTestProvider(t)
+
+	dbVersion, err := getBoltDatabaseVersion(p.db)
+	require.NoError(t, err)
+	assert.Equal(t, boltDatabaseVersion, dbVersion.Version)
+	err = p.migrateDatabase()
+	assert.ErrorIs(t, err, ErrNoInitRequired)
+
+	err = p.addDeadLetter(&DeadLetter{Source: DeadLetterSourceFsHook, Name: "upload"})
+	require.NoError(t, err)
+	err = p.revertDatabase(25)
+	require.NoError(t, err)
+	dbVersion, err = getBoltDatabaseVersion(p.db)
+	require.NoError(t, err)
+	assert.Equal(t, 25, dbVersion.Version)
+	for _, name := range []string{kvAuditTrailBucket, kvDeadLettersBucket, kvUsersFoldersQuotaBucket} {
+		assert.False(t, hasBoltTestBucket(t, p, name), name)
+	}
+	assert.True(t, hasBoltTestBucket(t, p, kvRulesBucket))
+	// the removed buckets are handled as empty ones
+	letters, err := p.getDeadLetters(DeadLetterFilters{}, 10, 0, OrderASC)
+	require.NoError(t, err)
+	assert.Len(t, letters, 0)
+	_, err = p.getDeadLetter(1)
+	assertNotFoundError(t, err)
+
+	err = p.migrateDatabase()
+	require.NoError(t, err)
+	dbVersion, err = getBoltDatabaseVersion(p.db)
+	require.NoError(t, err)
+	assert.Equal(t, boltDatabaseVersion, dbVersion.Version)
+	// and they are created again on the first write
+	err = p.addDeadLetter(&DeadLetter{Source: DeadLetterSourceFsHook, Name: "upload"})
+	require.NoError(t, err)
+	assert.True(t, hasBoltTestBucket(t, p, kvDeadLettersBucket))
+
+	err = updateBoltDatabaseVersion(p.db, 18)
+	require.NoError(t, err)
+	err = p.migrateDatabase()
+	assert.ErrorContains(t, err, "too old")
+	err = updateBoltDatabaseVersion(p.db, boltDatabaseVersion+1)
+	require.NoError(t, err)
+	err = p.migrateDatabase()
+	assert.NoError(t, err)
+	err = updateBoltDatabaseVersion(p.db, boltDatabaseVersion)
+	require.NoError(t, err)
+
+	err = p.resetDatabase()
+	require.NoError(t, err)
+	for _, name := range boltBuckets {
+		assert.False(t, hasBoltTestBucket(t, p, name), name)
+	}
+	_, err = p.userExists("missing")
+	assertNotFoundError(t, err)
+	_, err = getBoltDatabaseVersion(p.db)
+	assert.Error(t, err)
+	err = p.backupDatabase("")
+	assert.ErrorIs(t, err, ErrNotImplemented)
+}
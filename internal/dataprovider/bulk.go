@@ -0,0 +1,658 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Supported formats for bulk import and export
+const (
+	BulkFormatCSV    = "csv"
+	BulkFormatNDJSON = "ndjson"
+)
+
+// Supported object types for bulk import and export
+const (
+	BulkObjectUsers   = "users"
+	BulkObjectGroups  = "groups"
+	BulkObjectFolders = "folders"
+)
+
+const (
+	maxBulkRecordSize   = 1024 * 1024
+	maxBulkImportErrors = 500
+)
+
+var (
+	bulkFormats     = []string{BulkFormatCSV, BulkFormatNDJSON}
+	bulkObjectTypes = []string{BulkObjectUsers, BulkObjectGroups, BulkObjectFolders}
+	// CSV columns, the first one identifies the object
+	bulkUserCSVColumns = []string{"username", "status", "email", "description", "password", "home_dir", "uid", "gid",
+		"max_sessions", "quota_size", "quota_files", "upload_bandwidth", "download_bandwidth", "expiration_date",
+		"permissions", "primary_group", "secondary_groups", "membership_groups", "additional_info"}
+	bulkGroupCSVColumns = []string{"name", "description", "home_dir", "max_sessions", "quota_size", "quota_files",
+		"upload_bandwidth", "download_bandwidth", "permissions"}
+	bulkFolderCSVColumns = []string{"name", "description", "mapped_path"}
+)
+
+// BulkImportError defines a record that cannot be imported
+type BulkImportError struct {
+	// Line number within the input. For CSV the header is the first line
+	Line int `json:"line"`
+	// Name of the object, if it can be determined
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error"`
+}
+
+// BulkImportResult defines the validation report for a bulk import
+type BulkImportResult struct {
+	DryRun bool `json:"dry_run"`
+	// Number of processed records
+	Total int `json:"total"`
+	// Number of added objects. For a dry run the objects that would be added
+	Added int `json:"added"`
+	// Number of updated objects. For a dry run the objects that would be updated
+	Updated int `json:"updated"`
+	Failed  int `json:"failed"`
+	// Errors for the failed records, only the first 500 errors are reported
+	Errors []BulkImportError `json:"errors,omitempty"`
+}
+
+func (r *BulkImportResult) addResult(line int, name string, exists bool, err error) {
+	r.Total++
+	if err != nil {
+		r.Failed++
+		if len(r.Errors) < maxBulkImportErrors {
+			r.Errors = append(r.Errors, BulkImportError{
+				Line:  line,
+				Name:  name,
+				Error: err.Error(),
+			})
+		}
+		return
+	}
+	if exists {
+		r.Updated++
+	} else {
+		r.Added++
+	}
+}
+
+// ValidateBulkOptions returns an error if the object type or the format are
+// not supported for bulk import and export
+func ValidateBulkOptions(objectType, format string) error {
+	if !util.Contains(bulkObjectTypes, objectType) {
+		return util.NewValidationError(fmt.Sprintf("unsupported object type %q, supported values: %s",
+			objectType, strings.Join(bulkObjectTypes, ", ")))
+	}
+	if !util.Contains(bulkFormats, format) {
+		return util.NewValidationError(fmt.Sprintf("unsupported format %q, supported values: %s",
+			format, strings.Join(bulkFormats, ", ")))
+	}
+	return nil
+}
+
+// ExportBulk writes all the objects of the specified type to w using the
+// specified format. NDJSON exports contain the complete objects, as in backups,
+// including hashed passwords and encrypted secrets. CSV exports contain only
+// the fields supported for CSV imports
+func ExportBulk(w io.Writer, objectType, format string) error {
+	if err := ValidateBulkOptions(objectType, format); err != nil {
+		return err
+	}
+	switch objectType {
+	case BulkObjectUsers:
+		users, err := provider.dumpUsers()
+		if err != nil {
+			return err
+		}
+		if format == BulkFormatNDJSON {
+			return writeBulkNDJSON(w, len(users), func(idx int) any {
+				return &users[idx]
+			})
+		}
+		return writeBulkCSV(w, bulkUserCSVColumns, len(users), func(idx int) []string {
+			return users[idx].getCSVRecord()
+		})
+	case BulkObjectGroups:
+		groups, err := provider.dumpGroups()
+		if err != nil {
+			return err
+		}
+		if format == BulkFormatNDJSON {
+			return writeBulkNDJSON(w, len(groups), func(idx int) any {
+				return &groups[idx]
+			})
+		}
+		return writeBulkCSV(w, bulkGroupCSVColumns, len(groups), func(idx int) []string {
+			return groups[idx].getCSVRecord()
+		})
+	default:
+		folders, err := provider.dumpFolders()
+		if err != nil {
+			return err
+		}
+		if format == BulkFormatNDJSON {
+			return writeBulkNDJSON(w, len(folders), func(idx int) any {
+				return &folders[idx]
+			})
+		}
+		return writeBulkCSV(w, bulkFolderCSVColumns, len(folders), func(idx int) []string {
+			folder := &folders[idx]
+			return []string{folder.Name, folder.Description, folder.MappedPath}
+		})
+	}
+}
+
+func writeBulkNDJSON(w io.Writer, count int, getObject func(idx int) any) error {
+	enc := json.NewEncoder(w)
+	for idx := 0; idx < count; idx++ {
+		if err := enc.Encode(getObject(idx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBulkCSV(w io.Writer, header []string, count int, getRecord func(idx int) []string) error {
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.Write(header); err != nil {
+		return err
+	}
+	for idx := 0; idx < count; idx++ {
+		if err := csvWriter.Write(getRecord(idx)); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// ImportBulk adds or updates the objects of the specified type read from r.
+// Each NDJSON line must contain a complete object, as in backups: existing
+// objects are replaced. CSV records update only the fields for the columns
+// defined in the header, so existing objects can be partially updated.
+// Invalid records do not stop the import and are reported in the result.
+// If dryRun is true the records are validated without saving them, the
+// references to other objects, for example groups, are not checked
+func ImportBulk(r io.Reader, objectType, format string, dryRun bool, executor, ipAddress string) (BulkImportResult, error) {
+	result := BulkImportResult{
+		DryRun: dryRun,
+	}
+	if err := ValidateBulkOptions(objectType, format); err != nil {
+		return result, err
+	}
+	importer := &bulkImporter{
+		objectType: objectType,
+		dryRun:     dryRun,
+		executor:   executor,
+		ipAddress:  ipAddress,
+		result:     &result,
+	}
+	var err error
+	if format == BulkFormatNDJSON {
+		err = importer.importNDJSON(r)
+	} else {
+		err = importer.importCSV(r)
+	}
+	providerLog(logger.LevelInfo, "bulk import completed, object type: %q, format: %q, dry run: %t, total: %d, added: %d, "+
+		"updated: %d, failed: %d, error: %v", objectType, format, dryRun, result.Total, result.Added, result.Updated,
+		result.Failed, err)
+	return result, err
+}
+
+type bulkImporter struct {
+	objectType string
+	dryRun     bool
+	executor   string
+	ipAddress  string
+	result     *BulkImportResult
+}
+
+func (i *bulkImporter) importNDJSON(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBulkRecordSize)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		name, exists, err := i.importJSONRecord(line)
+		i.result.addResult(lineNumber, name, exists, err)
+	}
+	if err := scanner.Err(); err != nil {
+		return util.NewValidationError(fmt.Sprintf("line %d: unable to read the input: %v", lineNumber+1, err))
+	}
+	return nil
+}
+
+func (i *bulkImporter) importJSONRecord(line []byte) (string, bool, error) {
+	switch i.objectType {
+	case BulkObjectUsers:
+		var user User
+		if err := json.Unmarshal(line, &user); err != nil {
+			return "", false, fmt.Errorf("invalid user: %w", err)
+		}
+		existing, exists, err := getBulkUser(user.Username)
+		if err != nil {
+			return user.Username, exists, err
+		}
+		if exists {
+			user.ID = existing.ID
+			user.Username = existing.Username
+		}
+		return user.Username, exists, i.saveUser(&user, exists)
+	case BulkObjectGroups:
+		var group Group
+		if err := json.Unmarshal(line, &group); err != nil {
+			return "", false, fmt.Errorf("invalid group: %w", err)
+		}
+		existing, exists, err := getBulkGroup(group.Name)
+		if err != nil {
+			return group.Name, exists, err
+		}
+		if exists {
+			group.ID = existing.ID
+			group.Name = existing.Name
+		}
+		return group.Name, exists, i.saveGroup(&group, existing.Users, exists)
+	default:
+		var folder vfs.BaseVirtualFolder
+		if err := json.Unmarshal(line, &folder); err != nil {
+			return "", false, fmt.Errorf("invalid folder: %w", err)
+		}
+		existing, exists, err := getBulkFolder(folder.Name)
+		if err != nil {
+			return folder.Name, exists, err
+		}
+		if exists {
+			folder.ID = existing.ID
+			folder.Name = existing.Name
+		}
+		return folder.Name, exists, i.saveFolder(&folder, existing.Users, existing.Groups, exists)
+	}
+}
+
+func (i *bulkImporter) getCSVColumns() []string {
+	switch i.objectType {
+	case BulkObjectUsers:
+		return bulkUserCSVColumns
+	case BulkObjectGroups:
+		return bulkGroupCSVColumns
+	default:
+		return bulkFolderCSVColumns
+	}
+}
+
+func (i *bulkImporter) validateCSVHeader(header []string) error {
+	columns := i.getCSVColumns()
+	for idx, column := range header {
+		header[idx] = strings.ToLower(strings.TrimSpace(column))
+		if !util.Contains(columns, header[idx]) {
+			return util.NewValidationError(fmt.Sprintf("invalid CSV header, unsupported column %q", column))
+		}
+		if util.Contains(header[:idx], header[idx]) {
+			return util.NewValidationError(fmt.Sprintf("invalid CSV header, duplicated column %q", column))
+		}
+	}
+	if !util.Contains(header, columns[0]) {
+		return util.NewValidationError(fmt.Sprintf("invalid CSV header, the %q column is mandatory", columns[0]))
+	}
+	return nil
+}
+
+func (i *bulkImporter) importCSV(r io.Reader) error {
+	csvReader := csv.NewReader(r)
+	csvReader.ReuseRecord = true
+	header, err := csvReader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return util.NewValidationError("invalid CSV input, the header is missing")
+		}
+		return util.NewValidationError(fmt.Sprintf("invalid CSV header: %v", err))
+	}
+	header = append([]string(nil), header...)
+	if err := i.validateCSVHeader(header); err != nil {
+		return err
+	}
+	for {
+		record, err := csvReader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return err
+			}
+			i.result.addResult(parseErr.StartLine, "", false, parseErr.Err)
+			continue
+		}
+		line, _ := csvReader.FieldPos(0)
+		values := make(map[string]string, len(header))
+		for idx, column := range header {
+			values[column] = record[idx]
+		}
+		name, exists, err := i.importCSVRecord(values)
+		i.result.addResult(line, name, exists, err)
+	}
+}
+
+func (i *bulkImporter) importCSVRecord(values map[string]string) (string, bool, error) {
+	switch i.objectType {
+	case BulkObjectUsers:
+		user, exists, err := getBulkUser(strings.TrimSpace(values["username"]))
+		if err != nil {
+			return user.Username, exists, err
+		}
+		if err := user.applyCSVValues(values); err != nil {
+			return user.Username, exists, err
+		}
+		return user.Username, exists, i.saveUser(&user, exists)
+	case BulkObjectGroups:
+		group, exists, err := getBulkGroup(strings.TrimSpace(values["name"]))
+		if err != nil {
+			return group.Name, exists, err
+		}
+		if err := group.applyCSVValues(values); err != nil {
+			return group.Name, exists, err
+		}
+		return group.Name, exists, i.saveGroup(&group, group.Users, exists)
+	default:
+		folder, exists, err := getBulkFolder(strings.TrimSpace(values["name"]))
+		if err != nil {
+			return folder.Name, exists, err
+		}
+		for column, value := range values {
+			switch column {
+			case "description":
+				folder.Description = strings.TrimSpace(value)
+			case "mapped_path":
+				folder.MappedPath = strings.TrimSpace(value)
+			}
+		}
+		return folder.Name, exists, i.saveFolder(&folder, folder.Users, folder.Groups, exists)
+	}
+}
+
+func (i *bulkImporter) saveUser(user *User, exists bool) error {
+	if i.dryRun {
+		return ValidateUser(user)
+	}
+	if exists {
+		return UpdateUser(user, i.executor, i.ipAddress)
+	}
+	return AddUser(user, i.executor, i.ipAddress)
+}
+
+func (i *bulkImporter) saveGroup(group *Group, users []string, exists bool) error {
+	if i.dryRun {
+		return group.validate()
+	}
+	if exists {
+		return UpdateGroup(group, users, i.executor, i.ipAddress)
+	}
+	return AddGroup(group, i.executor, i.ipAddress)
+}
+
+func (i *bulkImporter) saveFolder(folder *vfs.BaseVirtualFolder, users, groups []string, exists bool) error {
+	if i.dryRun {
+		return ValidateFolder(folder)
+	}
+	if exists {
+		return UpdateFolder(folder, users, groups, i.executor, i.ipAddress)
+	}
+	folder.Users = nil
+	folder.Groups = nil
+	return AddFolder(folder, i.executor, i.ipAddress)
+}
+
+// getBulkUser returns the user with the specified username, if it exists,
+// or a new user with the specified username
+func getBulkUser(username string) (User, bool, error) {
+	if username == "" {
+		return User{}, false, util.NewValidationError("username is mandatory")
+	}
+	user, err := UserExists(username)
+	if err == nil {
+		return user, true, nil
+	}
+	if _, ok := err.(*util.RecordNotFoundError); !ok {
+		return User{}, false, err
+	}
+	user = User{}
+	user.Username = username
+	return user, false, nil
+}
+
+func getBulkGroup(name string) (Group, bool, error) {
+	if name == "" {
+		return Group{}, false, util.NewValidationError("name is mandatory")
+	}
+	group, err := GroupExists(name)
+	if err == nil {
+		return group, true, nil
+	}
+	if _, ok := err.(*util.RecordNotFoundError); !ok {
+		return Group{}, false, err
+	}
+	group = Group{}
+	group.Name = name
+	return group, false, nil
+}
+
+func getBulkFolder(name string) (vfs.BaseVirtualFolder, bool, error) {
+	if name == "" {
+		return vfs.BaseVirtualFolder{}, false, util.NewValidationError("name is mandatory")
+	}
+	folder, err := GetFolderByName(name)
+	if err == nil {
+		return folder, true, nil
+	}
+	if _, ok := err.(*util.RecordNotFoundError); !ok {
+		return vfs.BaseVirtualFolder{}, false, err
+	}
+	return vfs.BaseVirtualFolder{
+		Name: name,
+	}, false, nil
+}
+
+func (u *User) getCSVRecord() []string {
+	var primaryGroup string
+	var secondaryGroups, membershipGroups []string
+	for _, g := range u.Groups {
+		switch g.Type {
+		case sdk.GroupTypePrimary:
+			primaryGroup = g.Name
+		case sdk.GroupTypeSecondary:
+			secondaryGroups = append(secondaryGroups, g.Name)
+		case sdk.GroupTypeMembership:
+			membershipGroups = append(membershipGroups, g.Name)
+		}
+	}
+	return []string{u.Username, strconv.Itoa(u.Status), u.Email, u.Description, u.Password, u.HomeDir,
+		strconv.Itoa(u.UID), strconv.Itoa(u.GID), strconv.Itoa(u.MaxSessions), strconv.FormatInt(u.QuotaSize, 10),
+		strconv.Itoa(u.QuotaFiles), strconv.FormatInt(u.UploadBandwidth, 10), strconv.FormatInt(u.DownloadBandwidth, 10),
+		strconv.FormatInt(u.ExpirationDate, 10), strings.Join(u.Permissions["/"], ","), primaryGroup,
+		strings.Join(secondaryGroups, ","), strings.Join(membershipGroups, ","), u.AdditionalInfo}
+}
+
+func (u *User) applyCSVValues(values map[string]string) error {
+	var err error
+	for column, value := range values {
+		if column != "password" {
+			value = strings.TrimSpace(value)
+		}
+		switch column {
+		case "status":
+			u.Status, err = parseCSVInt(column, value)
+		case "email":
+			u.Email = value
+		case "description":
+			u.Description = value
+		case "password":
+			// an empty value does not change the existing password
+			if value != "" {
+				u.Password = value
+			}
+		case "home_dir":
+			u.HomeDir = value
+		case "uid":
+			u.UID, err = parseCSVInt(column, value)
+		case "gid":
+			u.GID, err = parseCSVInt(column, value)
+		case "max_sessions":
+			u.MaxSessions, err = parseCSVInt(column, value)
+		case "quota_size":
+			u.QuotaSize, err = parseCSVInt64(column, value)
+		case "quota_files":
+			u.QuotaFiles, err = parseCSVInt(column, value)
+		case "upload_bandwidth":
+			u.UploadBandwidth, err = parseCSVInt64(column, value)
+		case "download_bandwidth":
+			u.DownloadBandwidth, err = parseCSVInt64(column, value)
+		case "expiration_date":
+			u.ExpirationDate, err = parseCSVInt64(column, value)
+		case "permissions":
+			u.Permissions = setCSVRootPermissions(u.Permissions, value)
+		case "primary_group":
+			u.Groups = setCSVGroups(u.Groups, sdk.GroupTypePrimary, value)
+		case "secondary_groups":
+			u.Groups = setCSVGroups(u.Groups, sdk.GroupTypeSecondary, value)
+		case "membership_groups":
+			u.Groups = setCSVGroups(u.Groups, sdk.GroupTypeMembership, value)
+		case "additional_info":
+			u.AdditionalInfo = value
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Group) getCSVRecord() []string {
+	settings := &g.UserSettings
+	return []string{g.Name, g.Description, settings.HomeDir, strconv.Itoa(settings.MaxSessions),
+		strconv.FormatInt(settings.QuotaSize, 10), strconv.Itoa(settings.QuotaFiles),
+		strconv.FormatInt(settings.UploadBandwidth, 10), strconv.FormatInt(settings.DownloadBandwidth, 10),
+		strings.Join(settings.Permissions["/"], ",")}
+}
+
+func (g *Group) applyCSVValues(values map[string]string) error {
+	var err error
+	settings := &g.UserSettings
+	for column, value := range values {
+		value = strings.TrimSpace(value)
+		switch column {
+		case "description":
+			g.Description = value
+		case "home_dir":
+			settings.HomeDir = value
+		case "max_sessions":
+			settings.MaxSessions, err = parseCSVInt(column, value)
+		case "quota_size":
+			settings.QuotaSize, err = parseCSVInt64(column, value)
+		case "quota_files":
+			settings.QuotaFiles, err = parseCSVInt(column, value)
+		case "upload_bandwidth":
+			settings.UploadBandwidth, err = parseCSVInt64(column, value)
+		case "download_bandwidth":
+			settings.DownloadBandwidth, err = parseCSVInt64(column, value)
+		case "permissions":
+			settings.Permissions = setCSVRootPermissions(settings.Permissions, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseCSVInt64(column, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	val, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, util.NewValidationError(fmt.Sprintf("invalid %s %q", column, value))
+	}
+	return val, nil
+}
+
+func parseCSVInt(column, value string) (int, error) {
+	val, err := parseCSVInt64(column, value)
+	return int(val), err
+}
+
+// setCSVRootPermissions sets the permissions for the root directory, the
+// permissions for the other directories are preserved
+func setCSVRootPermissions(permissions map[string][]string, value string) map[string][]string {
+	if permissions == nil {
+		permissions = make(map[string][]string)
+	}
+	perms := util.RemoveDuplicates(getCSVList(value), false)
+	if len(perms) == 0 {
+		delete(permissions, "/")
+		return permissions
+	}
+	permissions["/"] = perms
+	return permissions
+}
+
+// setCSVGroups replaces the groups of the specified type
+func setCSVGroups(groups []sdk.GroupMapping, groupType int, value string) []sdk.GroupMapping {
+	result := make([]sdk.GroupMapping, 0, len(groups))
+	for _, g := range groups {
+		if g.Type != groupType {
+			result = append(result, g)
+		}
+	}
+	for _, name := range util.RemoveDuplicates(getCSVList(value), false) {
+		result = append(result, sdk.GroupMapping{
+			Name: name,
+			Type: groupType,
+		})
+	}
+	return result
+}
+
+func getCSVList(value string) []string {
+	var result []string
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,356 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+// Supported cache invalidation drivers
+const (
+	CacheInvalidationDriverPostgreSQL = "postgresql"
+	CacheInvalidationDriverNATS       = "nats"
+)
+
+const (
+	// PostgreSQL notifications payloads must be shorter than 8000 bytes,
+	// bigger messages are replaced with a refresh request
+	maxCacheInvalidationPayload = 7000
+	cacheInvalidationTimeout    = 10 * time.Second
+)
+
+var (
+	cacheInvalidator cacheInvalidationBroker
+	// cacheInvalidationNodeID allows to ignore the messages sent by this instance
+	cacheInvalidationNodeID = xid.New().String()
+)
+
+// CacheInvalidationConfig defines a publish/subscribe channel used to notify
+// the changes to users and event rules to the other SFTPGo instances sharing
+// the same data provider, so they can update their caches immediately
+type CacheInvalidationConfig struct {
+	// Driver to use, "postgresql" for PostgreSQL LISTEN/NOTIFY or "nats".
+	// Empty means disabled
+	Driver string `json:"driver" mapstructure:"driver"`
+	// Connection URL. For the postgresql driver, empty means the data provider
+	// connection, this is only supported for the PostgreSQL data provider
+	URL string `json:"url" mapstructure:"url"`
+	// PostgreSQL channel or NATS subject
+	Channel string `json:"channel" mapstructure:"channel"`
+}
+
+// IsEnabled returns true if the cache invalidation channel is configured
+func (c *CacheInvalidationConfig) IsEnabled() bool {
+	return c.Driver != ""
+}
+
+func (c *CacheInvalidationConfig) validate() error {
+	if !c.IsEnabled() {
+		return nil
+	}
+	if c.Channel == "" {
+		return errors.New("cache invalidation channel is required")
+	}
+	switch c.Driver {
+	case CacheInvalidationDriverPostgreSQL:
+		if c.URL == "" && config.Driver != PGSQLDataProviderName {
+			return fmt.Errorf("a cache invalidation URL is required for the %q data provider", config.Driver)
+		}
+	case CacheInvalidationDriverNATS:
+		if c.URL == "" {
+			return errors.New("cache invalidation URL is required for the nats driver")
+		}
+	default:
+		return fmt.Errorf("unsupported cache invalidation driver %q", c.Driver)
+	}
+	return nil
+}
+
+type cacheInvalidationMessage struct {
+	Node       string   `json:"node"`
+	ObjectType string   `json:"object_type"`
+	Action     string   `json:"action"`
+	Names      []string `json:"names,omitempty"`
+}
+
+type cacheInvalidationBroker interface {
+	publish(data []byte) error
+	close()
+}
+
+func startCacheInvalidation() error {
+	stopCacheInvalidation()
+
+	if !config.CacheInvalidation.IsEnabled() {
+		return nil
+	}
+	var err error
+	switch config.CacheInvalidation.Driver {
+	case CacheInvalidationDriverPostgreSQL:
+		url := config.CacheInvalidation.URL
+		if url == "" {
+			url = getPGSQLConnectionString(false)
+		}
+		cacheInvalidator, err = newPGCacheInvalidator(url, config.CacheInvalidation.Channel)
+	default:
+		cacheInvalidator, err = newNATSCacheInvalidator(config.CacheInvalidation.URL, config.CacheInvalidation.Channel)
+	}
+	if err != nil {
+		cacheInvalidator = nil
+		return fmt.Errorf("unable to start cache invalidation: %w", err)
+	}
+	providerLog(logger.LevelDebug, "cache invalidation started, driver %q, channel %q, node id %q",
+		config.CacheInvalidation.Driver, config.CacheInvalidation.Channel, cacheInvalidationNodeID)
+	return nil
+}
+
+func stopCacheInvalidation() {
+	if cacheInvalidator != nil {
+		cacheInvalidator.close()
+		cacheInvalidator = nil
+	}
+}
+
+// notifyUsersChanged notifies the other instances that the specified users
+// were updated or deleted
+func notifyUsersChanged(action string, usernames ...string) {
+	if len(usernames) == 0 {
+		return
+	}
+	publishCacheInvalidation(actionObjectUser, action, usernames)
+}
+
+// notifyRulesChanged notifies the other instances that the event rules were
+// updated or that the specified rule was deleted
+func notifyRulesChanged(action string, names ...string) {
+	publishCacheInvalidation(actionObjectEventRule, action, names)
+}
+
+func publishCacheInvalidation(objectType, action string, names []string) {
+	if cacheInvalidator == nil {
+		return
+	}
+	msg := cacheInvalidationMessage{
+		Node:       cacheInvalidationNodeID,
+		ObjectType: objectType,
+		Action:     action,
+		Names:      names,
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to marshal cache invalidation message: %v", err)
+		return
+	}
+	if len(data) > maxCacheInvalidationPayload {
+		// the receivers will refresh the recently updated objects
+		msg.Names = nil
+		data, err = json.Marshal(msg)
+		if err != nil {
+			providerLog(logger.LevelError, "unable to marshal cache invalidation message: %v", err)
+			return
+		}
+	}
+	if err := cacheInvalidator.publish(data); err != nil {
+		providerLog(logger.LevelError, "unable to publish cache invalidation for %s %+v: %v", objectType, names, err)
+	}
+}
+
+func handleCacheInvalidationMessage(data []byte) {
+	var msg cacheInvalidationMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		providerLog(logger.LevelWarn, "invalid cache invalidation message %q: %v", string(data), err)
+		return
+	}
+	if msg.Node == cacheInvalidationNodeID {
+		return
+	}
+	providerLog(logger.LevelDebug, "cache invalidation from node %q, %s %s %+v", msg.Node, msg.ObjectType,
+		msg.Action, msg.Names)
+	switch msg.ObjectType {
+	case actionObjectUser:
+		if len(msg.Names) == 0 {
+			checkCacheUpdates() //nolint:errcheck
+			return
+		}
+		for _, username := range msg.Names {
+			if msg.Action == operationDelete {
+				removeUserCaches(username)
+				continue
+			}
+			user, err := provider.userExists(username)
+			if err != nil {
+				providerLog(logger.LevelDebug, "unable to get updated user %q: %v", username, err)
+				removeUserCaches(username)
+				continue
+			}
+			updateUserCaches(&user)
+		}
+	case actionObjectEventRule:
+		if msg.Action == operationDelete && fnRemoveRule != nil {
+			for _, name := range msg.Names {
+				fnRemoveRule(name)
+			}
+			return
+		}
+		setLastRuleUpdate()
+		if fnReloadRules != nil {
+			fnReloadRules()
+		}
+	}
+}
+
+// refreshCaches refreshes the recently updated objects, some notifications
+// could be lost while the cache invalidation channel was disconnected
+func refreshCaches() {
+	checkCacheUpdates() //nolint:errcheck
+	setLastRuleUpdate()
+	if fnReloadRules != nil {
+		fnReloadRules()
+	}
+}
+
+type pgCacheInvalidator struct {
+	connString string
+	channel    string
+	pool       *pgxpool.Pool
+	cancel     context.CancelFunc
+}
+
+func newPGCacheInvalidator(connString, channel string) (*pgCacheInvalidator, error) {
+	pool, err := pgxpool.New(context.Background(), connString)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &pgCacheInvalidator{
+		connString: connString,
+		channel:    channel,
+		pool:       pool,
+		cancel:     cancel,
+	}
+	conn, err := c.listen(ctx)
+	if err != nil {
+		cancel()
+		pool.Close()
+		return nil, err
+	}
+	go c.waitForNotifications(ctx, conn)
+	return c, nil
+}
+
+func (c *pgCacheInvalidator) listen(ctx context.Context) (*pgx.Conn, error) {
+	connectCtx, cancel := context.WithTimeout(ctx, cacheInvalidationTimeout)
+	defer cancel()
+
+	conn, err := pgx.Connect(connectCtx, c.connString)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Exec(connectCtx, "LISTEN "+pgx.Identifier{c.channel}.Sanitize()); err != nil {
+		conn.Close(context.Background())
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *pgCacheInvalidator) waitForNotifications(ctx context.Context, conn *pgx.Conn) {
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err == nil {
+			handleCacheInvalidationMessage([]byte(notification.Payload))
+			continue
+		}
+		conn.Close(context.Background())
+		for {
+			select {
+			case <-ctx.Done():
+				providerLog(logger.LevelDebug, "PostgreSQL cache invalidation listener stopped")
+				return
+			case <-time.After(2 * time.Second):
+			}
+			providerLog(logger.LevelWarn, "PostgreSQL cache invalidation listener terminated, reconnecting: %v", err)
+			conn, err = c.listen(ctx)
+			if err == nil {
+				break
+			}
+		}
+		refreshCaches()
+	}
+}
+
+func (c *pgCacheInvalidator) publish(data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheInvalidationTimeout)
+	defer cancel()
+
+	_, err := c.pool.Exec(ctx, "SELECT pg_notify($1, $2)", c.channel, string(data))
+	return err
+}
+
+func (c *pgCacheInvalidator) close() {
+	c.cancel()
+	c.pool.Close()
+}
+
+type natsCacheInvalidator struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func newNATSCacheInvalidator(url, subject string) (*natsCacheInvalidator, error) {
+	conn, err := nats.Connect(url, nats.Name("SFTPGo"), nats.Timeout(cacheInvalidationTimeout),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				providerLog(logger.LevelWarn, "NATS cache invalidation disconnected: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(_ *nats.Conn) {
+			providerLog(logger.LevelInfo, "NATS cache invalidation reconnected")
+			refreshCaches()
+		}))
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Subscribe(subject, func(msg *nats.Msg) {
+		handleCacheInvalidationMessage(msg.Data)
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &natsCacheInvalidator{
+		conn:    conn,
+		subject: subject,
+	}, nil
+}
+
+func (c *natsCacheInvalidator) publish(data []byte) error {
+	return c.conn.Publish(c.subject, data)
+}
+
+func (c *natsCacheInvalidator) close() {
+	c.conn.Close()
+}
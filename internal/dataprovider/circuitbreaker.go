@@ -0,0 +1,436 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+const maxCachedCredentials = 10000
+
+var (
+	// ErrProviderUnavailable defines the error to return if users cannot be
+	// authenticated because the data provider is down or too slow
+	ErrProviderUnavailable = errors.New("service unavailable, please retry later")
+	authCircuitBreaker     = &circuitBreaker{}
+	authCredentialsCache   = newCredentialsCache()
+)
+
+// CircuitBreakerConfig defines the circuit breaker for the queries executed
+// to authenticate users. If the data provider is down or slow, after the
+// configured number of consecutive failures, the authentication requests
+// fail fast without querying the data provider until the circuit is closed
+// again
+type CircuitBreakerConfig struct {
+	// Number of consecutive failed or slow queries that open the circuit.
+	// 0 means disabled
+	FailureThreshold int `json:"failure_threshold" mapstructure:"failure_threshold"`
+	// Queries slower than this threshold, in milliseconds, are counted as
+	// failures. 0 means that only errors are counted
+	SlowQueryThreshold int `json:"slow_query_threshold" mapstructure:"slow_query_threshold"`
+	// Time, in seconds, to wait before allowing a new query when the circuit is
+	// open. If the query succeeds the circuit is closed, otherwise it is opened
+	// again
+	OpenTimeout int `json:"open_timeout" mapstructure:"open_timeout"`
+	// If greater than 0, the credentials of the users that successfully logged
+	// in using a password or a public key are cached, in memory, for the
+	// specified number of seconds. When the data provider is unavailable, the
+	// users with cached credentials can still login with read-only permissions.
+	// 0 means disabled
+	ReadOnlyCacheTTL int `json:"read_only_cache_ttl" mapstructure:"read_only_cache_ttl"`
+}
+
+// IsEnabled returns true if the circuit breaker is enabled
+func (c *CircuitBreakerConfig) IsEnabled() bool {
+	return c.FailureThreshold > 0
+}
+
+func (c *CircuitBreakerConfig) validate() error {
+	if c.FailureThreshold < 0 {
+		return fmt.Errorf("invalid circuit breaker failure threshold: %d", c.FailureThreshold)
+	}
+	if c.SlowQueryThreshold < 0 {
+		return fmt.Errorf("invalid circuit breaker slow query threshold: %d", c.SlowQueryThreshold)
+	}
+	if c.ReadOnlyCacheTTL < 0 {
+		return fmt.Errorf("invalid circuit breaker read only cache TTL: %d", c.ReadOnlyCacheTTL)
+	}
+	if c.IsEnabled() && c.OpenTimeout < 1 {
+		return fmt.Errorf("invalid circuit breaker open timeout: %d", c.OpenTimeout)
+	}
+	return nil
+}
+
+type circuitBreaker struct {
+	mu       sync.Mutex
+	config   CircuitBreakerConfig
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func (b *circuitBreaker) reset(config CircuitBreakerConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.config = config
+	b.state = circuitClosed
+	b.failures = 0
+	b.openedAt = time.Time{}
+	b.probing = false
+}
+
+func (b *circuitBreaker) isEnabled() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.config.IsEnabled()
+}
+
+// allow returns true if a query can be executed. If the circuit is open and
+// the timeout is elapsed a single probe query is allowed
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < time.Duration(b.config.OpenTimeout)*time.Second {
+			return false
+		}
+		providerLog(logger.LevelInfo, "authentication circuit breaker half-open, probing the data provider")
+		b.state = circuitHalfOpen
+		b.probing = true
+		return true
+	case circuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *circuitBreaker) isFailure(err error, elapsed time.Duration) bool {
+	if err != nil {
+		if _, ok := err.(*util.RecordNotFoundError); !ok {
+			return true
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.config.SlowQueryThreshold > 0 && elapsed > time.Duration(b.config.SlowQueryThreshold)*time.Millisecond
+}
+
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !failed {
+		if b.state != circuitClosed {
+			providerLog(logger.LevelInfo, "authentication circuit breaker closed, the data provider is available")
+		}
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.config.FailureThreshold {
+		if b.state != circuitOpen {
+			providerLog(logger.LevelWarn, "authentication circuit breaker opened after %d consecutive failures", b.failures)
+		}
+		b.state = circuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *circuitBreaker) getState() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// lookupUserForAuth loads the user to authenticate using the provided function
+// and records the result for the authentication circuit breaker.
+// ErrProviderUnavailable is returned, wrapped, if the circuit is open or
+// the data provider fails
+func lookupUserForAuth(username string, lookup func(string) (User, error)) (User, error) {
+	if !authCircuitBreaker.isEnabled() {
+		return traceUserLookup(username, lookup)
+	}
+	if !authCircuitBreaker.allow() {
+		return User{}, ErrProviderUnavailable
+	}
+	startTime := time.Now()
+	user, err := traceUserLookup(username, lookup)
+	failed := authCircuitBreaker.isFailure(err, time.Since(startTime))
+	authCircuitBreaker.record(failed)
+	if err != nil {
+		if _, ok := err.(*util.RecordNotFoundError); !ok {
+			return user, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+		}
+	}
+	return user, err
+}
+
+// IsAuthCircuitOpen returns true if the authentication circuit breaker is
+// open and so the users cannot be authenticated using the data provider
+func IsAuthCircuitOpen() bool {
+	return authCircuitBreaker.getState() == circuitOpen
+}
+
+type cachedCredentials struct {
+	user       User
+	password   []byte
+	publicKeys map[string]string
+	expiresAt  time.Time
+}
+
+// credentialsCache stores the credentials of recently authenticated users.
+// Only HMACs of the credentials are stored
+type credentialsCache struct {
+	sync.RWMutex
+	ttl     time.Duration
+	key     []byte
+	entries map[string]*cachedCredentials
+}
+
+func newCredentialsCache() *credentialsCache {
+	return &credentialsCache{
+		key:     util.GenerateRandomBytes(32),
+		entries: make(map[string]*cachedCredentials),
+	}
+}
+
+func (c *credentialsCache) reset(ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.ttl = ttl
+	c.entries = make(map[string]*cachedCredentials)
+}
+
+func (c *credentialsCache) isEnabled() bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.ttl > 0
+}
+
+func (c *credentialsCache) getHMAC(value []byte) []byte {
+	h := hmac.New(sha256.New, c.key)
+	h.Write(value)
+	return h.Sum(nil)
+}
+
+func (c *credentialsCache) getEntry(user *User) *cachedCredentials {
+	entry, ok := c.entries[user.Username]
+	if !ok || time.Now().After(entry.expiresAt) {
+		if len(c.entries) >= maxCachedCredentials {
+			c.removeExpired()
+		}
+		entry = &cachedCredentials{
+			publicKeys: make(map[string]string),
+		}
+		c.entries[user.Username] = entry
+	}
+	entry.user = user.getACopy()
+	entry.expiresAt = time.Now().Add(c.ttl)
+	return entry
+}
+
+func (c *credentialsCache) removeExpired() {
+	now := time.Now()
+	for k, v := range c.entries {
+		if now.After(v.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	if len(c.entries) >= maxCachedCredentials {
+		providerLog(logger.LevelDebug, "credentials cache is full, removing all the entries")
+		c.entries = make(map[string]*cachedCredentials)
+	}
+}
+
+func (c *credentialsCache) addPassword(user *User, password string) {
+	if !c.isEnabled() || password == "" {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	entry := c.getEntry(user)
+	entry.password = c.getHMAC([]byte(password))
+}
+
+func (c *credentialsCache) addPublicKey(user *User, pubKey []byte, keyID string) {
+	if !c.isEnabled() || len(pubKey) == 0 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	entry := c.getEntry(user)
+	entry.publicKeys[string(c.getHMAC(pubKey))] = keyID
+}
+
+func (c *credentialsCache) get(username string) (*cachedCredentials, bool) {
+	entry, ok := c.entries[username]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry, true
+}
+
+func (c *credentialsCache) getUserByPassword(username, password string) (User, bool) {
+	if !c.isEnabled() || password == "" {
+		return User{}, false
+	}
+	c.RLock()
+	defer c.RUnlock()
+
+	entry, ok := c.get(username)
+	if !ok || len(entry.password) == 0 || !hmac.Equal(entry.password, c.getHMAC([]byte(password))) {
+		return User{}, false
+	}
+	return entry.user.getACopy(), true
+}
+
+func (c *credentialsCache) getUserByPublicKey(username string, pubKey []byte) (User, string, bool) {
+	if !c.isEnabled() || len(pubKey) == 0 {
+		return User{}, "", false
+	}
+	c.RLock()
+	defer c.RUnlock()
+
+	entry, ok := c.get(username)
+	if !ok {
+		return User{}, "", false
+	}
+	keyID, ok := entry.publicKeys[string(c.getHMAC(pubKey))]
+	if !ok {
+		return User{}, "", false
+	}
+	return entry.user.getACopy(), keyID, true
+}
+
+func (c *credentialsCache) remove(username string) {
+	if !c.isEnabled() {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.entries, username)
+}
+
+// canCacheCredentials returns true if the credentials used to authenticate the
+// specified user can be cached. The credentials are not cached if a second
+// factor is required, the one time passcode cannot be reused
+func canCacheCredentials(user *User, protocol string) bool {
+	if user.Filters.IsAnonymous {
+		return false
+	}
+	if user.Filters.TOTPConfig.Enabled && util.Contains(user.Filters.TOTPConfig.Protocols, protocol) {
+		return false
+	}
+	return true
+}
+
+// getReadOnlyUser returns the cached user, if its login conditions are still
+// valid, with read-only permissions
+func getReadOnlyUser(user User) (User, error) {
+	if err := user.CheckLoginConditions(); err != nil {
+		return user, err
+	}
+	permissions := make(map[string][]string)
+	for dir, perms := range user.Permissions {
+		readPerms := []string{}
+		for _, perm := range []string{PermListItems, PermDownload} {
+			if util.Contains(perms, PermAny) || util.Contains(perms, perm) {
+				readPerms = append(readPerms, perm)
+			}
+		}
+		permissions[dir] = readPerms
+	}
+	user.Permissions = permissions
+	user.readOnlyFallback = true
+	return user, nil
+}
+
+func handlePasswordAuthResult(user User, username, password, protocol string, err error) (User, error) {
+	if err == nil {
+		if canCacheCredentials(&user, protocol) {
+			authCredentialsCache.addPassword(&user, password)
+		}
+		return user, nil
+	}
+	if errors.Is(err, ErrProviderUnavailable) {
+		// the web client and the REST API load the user from the data provider
+		// for each request, so the cached credentials are useless for HTTP
+		if protocol != protocolHTTP {
+			if cachedUser, ok := authCredentialsCache.getUserByPassword(username, password); ok {
+				providerLog(logger.LevelInfo, "data provider unavailable, user %q authenticated using cached credentials, "+
+					"protocol %s, read-only permissions granted", username, protocol)
+				return getReadOnlyUser(cachedUser)
+			}
+		}
+		providerLog(logger.LevelWarn, "unable to authenticate user %q, protocol %s: %v", username, protocol, err)
+		return user, ErrProviderUnavailable
+	}
+	return user, err
+}
+
+func handlePublicKeyAuthResult(user User, username string, pubKey []byte, isSSHCert bool, keyID string,
+	err error,
+) (User, string, error) {
+	if err == nil {
+		if !isSSHCert {
+			authCredentialsCache.addPublicKey(&user, pubKey, keyID)
+		}
+		return user, keyID, nil
+	}
+	if errors.Is(err, ErrProviderUnavailable) {
+		if cachedUser, cachedKeyID, ok := authCredentialsCache.getUserByPublicKey(username, pubKey); ok {
+			providerLog(logger.LevelInfo, "data provider unavailable, user %q authenticated using a cached public key, "+
+				"read-only permissions granted", username)
+			user, err = getReadOnlyUser(cachedUser)
+			return user, cachedKeyID, err
+		}
+		providerLog(logger.LevelWarn, "unable to authenticate user %q using a public key: %v", username, err)
+		return user, keyID, ErrProviderUnavailable
+	}
+	return user, keyID, err
+}
@@ -0,0 +1,155 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package dataprovider
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// MaxClientVersionLength defines the maximum length for the client identification
+// strings matched against the client policies and recorded in the observed versions
+// report, longer strings are truncated. 255 is the limit for SSH version strings
+const MaxClientVersionLength = 255
+
+// ClientPolicy defines the client software allowed or denied based on the client
+// identification string: the SSH client version, the FTP CLNT value or the HTTP
+// User-Agent. Patterns can contain the "*" and "?" wildcards and are matched,
+// case insensitive, against the whole identification string
+type ClientPolicy struct {
+	// Only clients matching at least one of these patterns are allowed.
+	// Empty means any client
+	Allowed []string `json:"allowed,omitempty" mapstructure:"allowed"`
+	// Clients matching any of these patterns are denied, the denied
+	// patterns take precedence over the allowed ones
+	Denied []string `json:"denied,omitempty" mapstructure:"denied"`
+}
+
+// IsEnabled returns true if at least an allowed or denied pattern is defined
+func (p *ClientPolicy) IsEnabled() bool {
+	return p != nil && (len(p.Allowed) > 0 || len(p.Denied) > 0)
+}
+
+// IsClientAllowed returns true if the specified client identification string
+// is allowed. Clients that do not send an identification string are matched
+// as empty string, so they are denied if allowed patterns are defined unless
+// "*" is one of them
+func (p *ClientPolicy) IsClientAllowed(clientVersion string) bool {
+	if !p.IsEnabled() {
+		return true
+	}
+	clientVersion = strings.ToLower(TruncateClientVersion(clientVersion))
+	for _, pattern := range p.Denied {
+		if matchWildcardPattern(clientVersion, strings.ToLower(pattern)) {
+			return false
+		}
+	}
+	if len(p.Allowed) == 0 {
+		return true
+	}
+	for _, pattern := range p.Allowed {
+		if matchWildcardPattern(clientVersion, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAllowedAsString returns the allowed patterns as comma separated string
+func (p *ClientPolicy) GetAllowedAsString() string {
+	if p == nil {
+		return ""
+	}
+	return strings.Join(p.Allowed, ",")
+}
+
+// GetDeniedAsString returns the denied patterns as comma separated string
+func (p *ClientPolicy) GetDeniedAsString() string {
+	if p == nil {
+		return ""
+	}
+	return strings.Join(p.Denied, ",")
+}
+
+// Validate returns an error if the policy is not valid, empty
+// and duplicate patterns are removed
+func (p *ClientPolicy) Validate() error {
+	allowed, err := validateClientPatterns(p.Allowed)
+	if err != nil {
+		return util.NewValidationError(fmt.Sprintf("invalid allowed client: %v", err))
+	}
+	p.Allowed = allowed
+	denied, err := validateClientPatterns(p.Denied)
+	if err != nil {
+		return util.NewValidationError(fmt.Sprintf("invalid denied client: %v", err))
+	}
+	p.Denied = denied
+	return nil
+}
+
+func (p *ClientPolicy) getACopy() *ClientPolicy {
+	if p == nil {
+		return nil
+	}
+	allowed := make([]string, len(p.Allowed))
+	copy(allowed, p.Allowed)
+	denied := make([]string, len(p.Denied))
+	copy(denied, p.Denied)
+
+	return &ClientPolicy{
+		Allowed: allowed,
+		Denied:  denied,
+	}
+}
+
+// TruncateClientVersion returns the client identification string
+// truncated to MaxClientVersionLength
+func TruncateClientVersion(clientVersion string) string {
+	if len(clientVersion) > MaxClientVersionLength {
+		return clientVersion[:MaxClientVersionLength]
+	}
+	return clientVersion
+}
+
+func validateUserClientPolicy(user *User) error {
+	if user.Filters.ClientPolicy == nil {
+		return nil
+	}
+	if err := user.Filters.ClientPolicy.Validate(); err != nil {
+		return err
+	}
+	if !user.Filters.ClientPolicy.IsEnabled() {
+		user.Filters.ClientPolicy = nil
+	}
+	return nil
+}
+
+func validateClientPatterns(patterns []string) ([]string, error) {
+	var result []string
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if len(pattern) > MaxClientVersionLength {
+			return nil, fmt.Errorf("pattern %q is too long, max allowed length: %d", pattern, MaxClientVersionLength)
+		}
+		if !util.Contains(result, pattern) {
+			result = append(result, pattern)
+		}
+	}
+	return result, nil
+}
@@ -62,12 +62,15 @@ import (
 	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/crypto/ssh"
 
+	"github.com/drakkan/sftpgo/v2/internal/auditlog"
 	"github.com/drakkan/sftpgo/v2/internal/command"
 	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
@@ -85,6 +88,10 @@ const (
 	MemoryDataProviderName = "memory"
 	// CockroachDataProviderName defines the for CockroachDB provider
 	CockroachDataProviderName = "cockroachdb"
+	// EtcdDataProviderName defines the name for etcd key/value store provider
+	EtcdDataProviderName = "etcd"
+	// MongoDBDataProviderName defines the name for MongoDB database provider
+	MongoDBDataProviderName = "mongodb"
 	// DumpVersion defines the version for the dump.
 	// For restore/load we support the current version and the previous one
 	DumpVersion = 13
@@ -104,8 +111,12 @@ const (
 	operationAdd              = "add"
 	operationUpdate           = "update"
 	operationDelete           = "delete"
+	operationCryptoShred      = "crypto-shred"
+	operationSoftDelete       = "soft-delete"
+	operationRestore          = "restore"
 	sqlPrefixValidChars       = "abcdefghijklmnopqrstuvwxyz_0123456789"
 	maxHookResponseSize       = 1048576 // 1MB
+	maxWebAuthnCredentials    = 20
 	iso8601UTCFormat          = "2006-01-02T15:04:05Z"
 )
 
@@ -116,6 +127,13 @@ const (
 	HashingAlgoArgon2ID = "argon2id"
 )
 
+// Supported themes for the WebAdmin and WebClient UIs.
+// An empty theme means the default light theme
+const (
+	UIThemeDark         = "dark"
+	UIThemeHighContrast = "high-contrast"
+)
+
 // ordering constants
 const (
 	OrderASC  = "ASC"
@@ -127,12 +145,14 @@ const (
 	protocolFTP    = "FTP"
 	protocolWebDAV = "DAV"
 	protocolHTTP   = "HTTP"
+	protocolS3     = "S3"
 )
 
 var (
 	// SupportedProviders defines the supported data providers
 	SupportedProviders = []string{SQLiteDataProviderName, PGSQLDataProviderName, MySQLDataProviderName,
-		BoltDataProviderName, MemoryDataProviderName, CockroachDataProviderName}
+		BoltDataProviderName, MemoryDataProviderName, CockroachDataProviderName, EtcdDataProviderName,
+		MongoDBDataProviderName}
 	// ValidPerms defines all the valid permissions for a user
 	ValidPerms = []string{PermAny, PermListItems, PermDownload, PermUpload, PermOverwrite, PermCreateDirs, PermRename,
 		PermRenameFiles, PermRenameDirs, PermDelete, PermDeleteFiles, PermDeleteDirs, PermCreateSymlinks, PermChmod,
@@ -140,7 +160,7 @@ var (
 	// ValidLoginMethods defines all the valid login methods
 	ValidLoginMethods = []string{SSHLoginMethodPublicKey, LoginMethodPassword, SSHLoginMethodPassword,
 		SSHLoginMethodKeyboardInteractive, SSHLoginMethodKeyAndPassword, SSHLoginMethodKeyAndKeyboardInt,
-		LoginMethodTLSCertificate, LoginMethodTLSCertificateAndPwd}
+		LoginMethodTLSCertificate, LoginMethodTLSCertificateAndPwd, SSHLoginMethodGSSAPI}
 	// SSHMultiStepsLoginMethods defines the supported Multi-Step Authentications
 	SSHMultiStepsLoginMethods = []string{SSHLoginMethodKeyAndPassword, SSHLoginMethodKeyAndKeyboardInt}
 	// ErrNoAuthTryed defines the error for connection closed before authentication
@@ -148,9 +168,13 @@ var (
 	// ErrNotImplemented defines the error for features not supported for a particular data provider
 	ErrNotImplemented = errors.New("feature not supported with the configured data provider")
 	// ValidProtocols defines all the valid protcols
-	ValidProtocols = []string{protocolSSH, protocolFTP, protocolWebDAV, protocolHTTP}
+	ValidProtocols = []string{protocolSSH, protocolFTP, protocolWebDAV, protocolHTTP, protocolS3}
 	// MFAProtocols defines the supported protocols for multi-factor authentication
 	MFAProtocols = []string{protocolHTTP, protocolSSH, protocolFTP}
+	// PushMFAProtocols defines the supported protocols for push-based second factor authentication
+	PushMFAProtocols = []string{protocolHTTP, protocolSSH, protocolFTP, protocolWebDAV}
+	// UIThemes defines the supported themes for the web UIs
+	UIThemes = []string{UIThemeDark, UIThemeHighContrast}
 	// ErrNoInitRequired defines the error returned by InitProvider if no inizialization/update is required
 	ErrNoInitRequired = errors.New("the data provider is up to date")
 	// ErrInvalidCredentials defines the error to return if the supplied credentials are invalid
@@ -169,7 +193,7 @@ var (
 	pbkdfPwdPrefixes             = []string{pbkdf2SHA1Prefix, pbkdf2SHA256Prefix, pbkdf2SHA512Prefix, pbkdf2SHA256B64SaltPrefix}
 	pbkdfPwdB64SaltPrefixes      = []string{pbkdf2SHA256B64SaltPrefix}
 	unixPwdPrefixes              = []string{md5cryptPwdPrefix, md5cryptApr1PwdPrefix, sha256cryptPwdPrefix, sha512cryptPwdPrefix}
-	sharedProviders              = []string{PGSQLDataProviderName, MySQLDataProviderName, CockroachDataProviderName}
+	sharedProviders              = []string{PGSQLDataProviderName, MySQLDataProviderName, CockroachDataProviderName, EtcdDataProviderName, MongoDBDataProviderName}
 	logSender                    = "dataprovider"
 	sqlTableUsers                string
 	sqlTableFolders              string
@@ -190,15 +214,22 @@ var (
 	sqlTableRulesActionsMapping  string
 	sqlTableTasks                string
 	sqlTableNodes                string
+	sqlTableAuditTrail           string
+	sqlTableDeadLetters          string
+	sqlTableUsersFoldersQuota    string
 	sqlTableSchemaVersion        string
 	argon2Params                 *argon2id.Params
 	lastLoginMinDelay            = 10 * time.Minute
 	usernameRegex                = regexp.MustCompile("^[a-zA-Z0-9-_.~]+$")
+	hostnameRegex                = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
 	tempPath                     string
 	allowSelfConnections         int
+	countryResolver              func(ip string) string
 	fnReloadRules                FnReloadRules
 	fnRemoveRule                 FnRemoveRule
 	fnHandleRuleForProviderEvent FnHandleRuleForProviderEvent
+	dummyPasswordHash            string
+	dummyPasswordHashOnce        sync.Once
 )
 
 func initSQLTables() {
@@ -221,6 +252,9 @@ func initSQLTables() {
 	sqlTableRulesActionsMapping = "rules_actions_mapping"
 	sqlTableTasks = "tasks"
 	sqlTableNodes = "nodes"
+	sqlTableAuditTrail = "audit_trail"
+	sqlTableDeadLetters = "dead_letters"
+	sqlTableUsersFoldersQuota = "users_folders_quota"
 	sqlTableSchemaVersion = "schema_version"
 }
 
@@ -299,6 +333,10 @@ func (w *wrappedFolder) RenderAsJSON(reload bool) ([]byte, error) {
 	return json.Marshal(w.Folder)
 }
 
+func (w *wrappedFolder) GetMetadata() util.Metadata {
+	return w.Folder.Metadata
+}
+
 // ObjectsActions defines the action to execute on user create, update, delete for the specified objects
 type ObjectsActions struct {
 	// Valid values are add, update, delete. Empty slice to disable
@@ -435,6 +473,9 @@ type Config struct {
 	PasswordHashing PasswordHashing `json:"password_hashing" mapstructure:"password_hashing"`
 	// PasswordValidation defines the password validation rules
 	PasswordValidation PasswordValidation `json:"password_validation" mapstructure:"password_validation"`
+	// PasswordPolicies defines the named password policies that can be assigned
+	// to protocol users directly or through their primary group
+	PasswordPolicies []PasswordPolicy `json:"password_policies" mapstructure:"password_policies"`
 	// Verifying argon2 passwords has a high memory and computational cost,
 	// by enabling, in memory, password caching you reduce this cost.
 	PasswordCaching bool `json:"password_caching" mapstructure:"password_caching"`
@@ -475,6 +516,27 @@ type Config struct {
 	Node NodeConfig `json:"node" mapstructure:"node"`
 	// Path to the backup directory. This can be an absolute path or a path relative to the config dir
 	BackupsPath string `json:"backups_path" mapstructure:"backups_path"`
+	// Number of hours a deleted user or folder is retained, disabled and hidden from the
+	// listings, before its permanent removal. Soft deleted users and folders can be
+	// restored within this period. 0 means they are permanently deleted immediately
+	SoftDeleteRetention int `json:"soft_delete_retention" mapstructure:"soft_delete_retention"`
+	// CircuitBreaker defines the circuit breaker for the user authentication queries
+	CircuitBreaker CircuitBreakerConfig `json:"circuit_breaker" mapstructure:"circuit_breaker"`
+	// ReadReplicas defines the read-only replicas for SQL based data providers
+	ReadReplicas ReadReplicasConfig `json:"read_replicas" mapstructure:"read_replicas"`
+	// Audit defines the audit trail for the changes to the provider objects
+	Audit AuditConfig `json:"audit" mapstructure:"audit"`
+	// DeadLetters defines the store for the HTTP notifications that failed
+	// after all the retries
+	DeadLetters DeadLettersConfig `json:"dead_letters" mapstructure:"dead_letters"`
+	// LDAP defines the LDAP server to use to authenticate users with a password
+	LDAP LDAPConfig `json:"ldap" mapstructure:"ldap"`
+	// CacheInvalidation defines the channel used to notify the changes to the
+	// other instances sharing the same data provider
+	CacheInvalidation CacheInvalidationConfig `json:"cache_invalidation" mapstructure:"cache_invalidation"`
+	// SQLite defines the journal mode, the busy timeout and the synchronous
+	// level for the SQLite data provider
+	SQLite SQLiteConfig `json:"sqlite" mapstructure:"sqlite"`
 }
 
 // GetShared returns the provider share mode.
@@ -503,13 +565,23 @@ func (c *Config) convertName(name string) string {
 // IsDefenderSupported returns true if the configured provider supports the defender
 func (c *Config) IsDefenderSupported() bool {
 	switch c.Driver {
-	case MySQLDataProviderName, PGSQLDataProviderName, CockroachDataProviderName:
+	case MySQLDataProviderName, PGSQLDataProviderName, CockroachDataProviderName, MongoDBDataProviderName:
 		return true
 	default:
 		return false
 	}
 }
 
+// IsSharedSessionSupported returns true if the configured provider supports shared sessions
+func (c *Config) IsSharedSessionSupported() bool {
+	switch c.Driver {
+	case MemoryDataProviderName, BoltDataProviderName:
+		return false
+	default:
+		return true
+	}
+}
+
 func (c *Config) requireCustomTLSForMySQL() bool {
 	if config.DisableSNI {
 		return config.SSLMode != 0
@@ -698,6 +770,11 @@ func HasUsersBaseDir() bool {
 	return config.UsersBaseDir != ""
 }
 
+// IsSoftDeleteEnabled returns true if deleted users and folders are retained for a grace period
+func IsSoftDeleteEnabled() bool {
+	return config.SoftDeleteRetention > 0
+}
+
 // Provider defines the interface that data providers must implement.
 type Provider interface {
 	validateUserAndPass(username, password, ip, protocol string) (User, error)
@@ -705,13 +782,16 @@ type Provider interface {
 	validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error)
 	updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error
 	updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error
+	resetUserTransferQuota(username string, scheduledAt int64) (bool, error)
 	getUsedQuota(username string) (int, int64, int64, int64, error)
 	userExists(username string) (User, error)
 	addUser(user *User) error
 	updateUser(user *User) error
 	deleteUser(user User, softDelete bool) error
-	updateUserPassword(username, password string) error
+	updateUserPassword(username, password string, state *userPasswordState) error
 	getUsers(limit int, offset int, order string) ([]User, error)
+	getSoftDeletedUsers(limit int, offset int, order string) ([]User, error)
+	setUserSoftDeletedAt(username string, softDeletedAt int64) error
 	dumpUsers() ([]User, error)
 	getRecentlyUpdatedUsers(after int64) ([]User, error)
 	getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error)
@@ -719,12 +799,16 @@ type Provider interface {
 	updateAdminLastLogin(username string) error
 	setUpdatedAt(username string)
 	getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error)
+	getSoftDeletedFolders(limit, offset int, order string) ([]vfs.BaseVirtualFolder, error)
+	setFolderSoftDeletedAt(name string, softDeletedAt int64) error
 	getFolderByName(name string) (vfs.BaseVirtualFolder, error)
 	addFolder(folder *vfs.BaseVirtualFolder) error
 	updateFolder(folder *vfs.BaseVirtualFolder) error
 	deleteFolder(folder vfs.BaseVirtualFolder) error
 	updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error
 	getUsedFolderQuota(name string) (int, int64, error)
+	updateUserFolderQuota(username, folderName string, filesAdd int, sizeAdd int64, reset bool) error
+	getUserFoldersQuota(username string) ([]UserFolderQuota, error)
 	dumpFolders() ([]vfs.BaseVirtualFolder, error)
 	getGroups(limit, offset int, order string, minimal bool) ([]Group, error)
 	getGroupsWithNames(names []string) ([]Group, error)
@@ -771,6 +855,7 @@ type Provider interface {
 	addSharedSession(session Session) error
 	deleteSharedSession(key string) error
 	getSharedSession(key string) (Session, error)
+	getSharedSessions(sessionType SessionType, after int64) ([]Session, error)
 	cleanupSharedSessions(sessionType SessionType, before int64) error
 	getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error)
 	dumpEventActions() ([]BaseEventAction, error)
@@ -796,6 +881,14 @@ type Provider interface {
 	getNodes() ([]Node, error)
 	updateNodeTimestamp() error
 	cleanupNodes() error
+	addAuditEntry(entry *AuditEntry) error
+	getAuditEntries(filters AuditFilters, limit, offset int, order string) ([]AuditEntry, error)
+	cleanupAuditEntries(before int64) error
+	addDeadLetter(entry *DeadLetter) error
+	getDeadLetters(filters DeadLetterFilters, limit, offset int, order string) ([]DeadLetter, error)
+	getDeadLetter(id int64) (DeadLetter, error)
+	deleteDeadLetter(id int64) error
+	cleanupDeadLetters(before int64) error
 	checkAvailability() error
 	close() error
 	reloadConfig() error
@@ -803,6 +896,7 @@ type Provider interface {
 	migrateDatabase() error
 	revertDatabase(targetVersion int) error
 	resetDatabase() error
+	backupDatabase(outputFile string) error
 }
 
 // SetAllowSelfConnections sets the desired behaviour for self connections
@@ -810,6 +904,12 @@ func SetAllowSelfConnections(value int) {
 	allowSelfConnections = value
 }
 
+// SetCountryResolver sets the function to use to get the country code
+// for an IP address
+func SetCountryResolver(fn func(ip string) string) {
+	countryResolver = fn
+}
+
 // SetTempPath sets the path for temporary files
 func SetTempPath(fsPath string) {
 	tempPath = fsPath
@@ -840,6 +940,33 @@ func Initialize(cnf Config, basePath string, checkAdmins bool) error {
 	if err := validateHooks(); err != nil {
 		return err
 	}
+	if err := config.CircuitBreaker.validate(); err != nil {
+		return err
+	}
+	if err := config.ReadReplicas.validate(); err != nil {
+		return err
+	}
+	if err := config.Audit.validate(); err != nil {
+		return err
+	}
+	if err := config.DeadLetters.validate(); err != nil {
+		return err
+	}
+	if err := config.LDAP.validate(); err != nil {
+		return err
+	}
+	if err := config.CacheInvalidation.validate(); err != nil {
+		return err
+	}
+	if err := config.SQLite.validate(); err != nil {
+		return err
+	}
+	if err := validatePasswordPolicies(basePath); err != nil {
+		return err
+	}
+	authCircuitBreaker.reset(config.CircuitBreaker)
+	authCredentialsCache.reset(time.Duration(config.CircuitBreaker.ReadOnlyCacheTTL) * time.Second)
+	ldapCredentialsCache.reset(time.Duration(config.LDAP.CacheTTL) * time.Second)
 	if err := createProvider(basePath); err != nil {
 		return err
 	}
@@ -855,6 +982,9 @@ func Initialize(cnf Config, basePath string, checkAdmins bool) error {
 		return err
 	}
 	delayedQuotaUpdater.start()
+	if err := startCacheInvalidation(); err != nil {
+		return err
+	}
 	return startScheduler()
 }
 
@@ -968,16 +1098,20 @@ func validateSQLTablesPrefix() error {
 		sqlTableRulesActionsMapping = config.SQLTablesPrefix + sqlTableRulesActionsMapping
 		sqlTableTasks = config.SQLTablesPrefix + sqlTableTasks
 		sqlTableNodes = config.SQLTablesPrefix + sqlTableNodes
+		sqlTableAuditTrail = config.SQLTablesPrefix + sqlTableAuditTrail
+		sqlTableDeadLetters = config.SQLTablesPrefix + sqlTableDeadLetters
+		sqlTableUsersFoldersQuota = config.SQLTablesPrefix + sqlTableUsersFoldersQuota
 		sqlTableSchemaVersion = config.SQLTablesPrefix + sqlTableSchemaVersion
 		providerLog(logger.LevelDebug, "sql table for users %q, folders %q users folders mapping %q admins %q "+
 			"api keys %q shares %q defender hosts %q defender events %q transfers %q  groups %q "+
 			"users groups mapping %q admins groups mapping %q groups folders mapping %q shared sessions %q "+
-			"schema version %q events actions %q events rules %q rules actions mapping %q tasks %q nodes %q",
+			"schema version %q events actions %q events rules %q rules actions mapping %q tasks %q nodes %q audit trail %q "+
+			"dead letters %q users folders quota %q",
 			sqlTableUsers, sqlTableFolders, sqlTableUsersFoldersMapping, sqlTableAdmins, sqlTableAPIKeys,
 			sqlTableShares, sqlTableDefenderHosts, sqlTableDefenderEvents, sqlTableActiveTransfers, sqlTableGroups,
 			sqlTableUsersGroupsMapping, sqlTableAdminsGroupsMapping, sqlTableGroupsFoldersMapping, sqlTableSharedSessions,
 			sqlTableSchemaVersion, sqlTableEventsActions, sqlTableEventsRules, sqlTableRulesActionsMapping,
-			sqlTableTasks, sqlTableNodes)
+			sqlTableTasks, sqlTableNodes, sqlTableAuditTrail, sqlTableDeadLetters, sqlTableUsersFoldersQuota)
 	}
 	return nil
 }
@@ -1046,7 +1180,12 @@ func ResetDatabase(cnf Config, basePath string) error {
 // CheckAdminAndPass validates the given admin and password connecting from ip
 func CheckAdminAndPass(username, password, ip string) (Admin, error) {
 	username = config.convertName(username)
-	return provider.validateAdminAndPass(username, password, ip)
+	span := startQuerySpan("validate_admin_and_pass", username)
+	admin, err := provider.validateAdminAndPass(username, password, ip)
+	endQuerySpan(span, err)
+	auditlog.AddLogin(auditlog.EventAdminLogin, username, ip, protocolHTTP, LoginMethodPassword, err)
+	logger.SyslogLoginEvent(true, username, ip, protocolHTTP, LoginMethodPassword, err)
+	return admin, err
 }
 
 // CheckCachedUserCredentials checks the credentials for a cached user
@@ -1114,6 +1253,8 @@ func CheckCompositeCredentials(username, password, ip, loginMethod, protocol str
 			user, err = doPluginAuth(username, password, nil, ip, protocol, nil, plugin.AuthScopePassword)
 		} else if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&1 != 0) {
 			user, err = doExternalAuth(username, password, nil, "", ip, protocol, nil)
+		} else if config.LDAP.IsEnabled() {
+			user, err = doLDAPAuth(username, password, ip, protocol)
 		} else if config.PreLoginHook != "" {
 			user, err = executePreLoginHook(username, LoginMethodPassword, ip, protocol, nil)
 		}
@@ -1121,6 +1262,9 @@ func CheckCompositeCredentials(username, password, ip, loginMethod, protocol str
 			return user, loginMethod, err
 		}
 		user, err = checkUserAndPass(&user, password, ip, protocol)
+		if err == nil {
+			err = checkUserPushApproval(&user, ip, protocol)
+		}
 	}
 	return user, loginMethod, err
 }
@@ -1152,7 +1296,7 @@ func CheckUserBeforeTLSAuth(username, ip, protocol string, tlsCert *x509.Certifi
 		err = user.LoadAndApplyGroupSettings()
 		return user, err
 	}
-	user, err := UserExists(username)
+	user, err := lookupUserForAuth(username, provider.userExists)
 	if err != nil {
 		return user, err
 	}
@@ -1163,6 +1307,13 @@ func CheckUserBeforeTLSAuth(username, ip, protocol string, tlsCert *x509.Certifi
 // CheckUserAndTLSCert returns the SFTPGo user with the given username and check if the
 // given TLS certificate allow authentication without password
 func CheckUserAndTLSCert(username, ip, protocol string, tlsCert *x509.Certificate) (User, error) {
+	startTime := time.Now()
+	user, err := doCheckUserAndTLSCert(username, ip, protocol, tlsCert)
+	metric.AddLoginDuration(protocol, LoginMethodTLSCertificate, time.Since(startTime), err)
+	return user, err
+}
+
+func doCheckUserAndTLSCert(username, ip, protocol string, tlsCert *x509.Certificate) (User, error) {
 	username = config.convertName(username)
 	if plugin.Handler.HasAuthScope(plugin.AuthScopeTLSCertificate) {
 		user, err := doPluginAuth(username, "", nil, ip, protocol, tlsCert, plugin.AuthScopeTLSCertificate)
@@ -1185,11 +1336,66 @@ func CheckUserAndTLSCert(username, ip, protocol string, tlsCert *x509.Certificat
 		}
 		return checkUserAndTLSCertificate(&user, protocol, tlsCert)
 	}
-	return provider.validateUserAndTLSCert(username, protocol, tlsCert)
+	span := startQuerySpan("validate_user_and_tls_cert", username)
+	user, err := provider.validateUserAndTLSCert(username, protocol, tlsCert)
+	endQuerySpan(span, err)
+	return user, err
 }
 
 // CheckUserAndPass retrieves the SFTPGo user with the given username and password if a match is found or an error
 func CheckUserAndPass(username, password, ip, protocol string) (User, error) {
+	startTime := time.Now()
+	user, err := doCheckUserAndPass(username, password, ip, protocol)
+	if err == nil && (protocol == protocolFTP || protocol == protocolWebDAV) {
+		err = checkUserPushApproval(&user, ip, protocol)
+	}
+	metric.AddLoginDuration(protocol, LoginMethodPassword, time.Since(startTime), err)
+	return user, err
+}
+
+// RequestUserPushApproval sends a login request for the specified user to the
+// configured push provider and waits for the approval
+func RequestUserPushApproval(user *User, ip, protocol string) error {
+	err := mfa.RequestPushApproval(mfa.PushRequest{
+		Username: user.Username,
+		Role:     "user",
+		IP:       ip,
+		Protocol: protocol,
+	})
+	if err != nil {
+		providerLog(logger.LevelWarn, "push approval failed for user %q, ip %v, protocol %v: %v",
+			user.Username, ip, protocol, err)
+		return ErrInvalidCredentials
+	}
+	providerLog(logger.LevelDebug, "push approval received for user %q, ip %v, protocol %v", user.Username, ip, protocol)
+	return nil
+}
+
+// RequestAdminPushApproval sends a login request for the specified admin to
+// the configured push provider and waits for the approval
+func RequestAdminPushApproval(admin *Admin, ip string) error {
+	err := mfa.RequestPushApproval(mfa.PushRequest{
+		Username: admin.Username,
+		Role:     "admin",
+		IP:       ip,
+		Protocol: protocolHTTP,
+	})
+	if err != nil {
+		providerLog(logger.LevelWarn, "push approval failed for admin %q, ip %v: %v", admin.Username, ip, err)
+		return ErrInvalidCredentials
+	}
+	providerLog(logger.LevelDebug, "push approval received for admin %q, ip %v", admin.Username, ip)
+	return nil
+}
+
+func checkUserPushApproval(user *User, ip, protocol string) error {
+	if !user.IsPushEnabledForProtocol(protocol) {
+		return nil
+	}
+	return RequestUserPushApproval(user, ip, protocol)
+}
+
+func doCheckUserAndPass(username, password, ip, protocol string) (User, error) {
 	username = config.convertName(username)
 	if plugin.Handler.HasAuthScope(plugin.AuthScopePassword) {
 		user, err := doPluginAuth(username, password, nil, ip, protocol, nil, plugin.AuthScopePassword)
@@ -1205,6 +1411,13 @@ func CheckUserAndPass(username, password, ip, protocol string) (User, error) {
 		}
 		return checkUserAndPass(&user, password, ip, protocol)
 	}
+	if config.LDAP.IsEnabled() {
+		user, err := doLDAPAuth(username, password, ip, protocol)
+		if err != nil {
+			return user, err
+		}
+		return checkUserAndPass(&user, password, ip, protocol)
+	}
 	if config.PreLoginHook != "" {
 		user, err := executePreLoginHook(username, LoginMethodPassword, ip, protocol, nil)
 		if err != nil {
@@ -1212,11 +1425,21 @@ func CheckUserAndPass(username, password, ip, protocol string) (User, error) {
 		}
 		return checkUserAndPass(&user, password, ip, protocol)
 	}
-	return provider.validateUserAndPass(username, password, ip, protocol)
+	span := startQuerySpan("validate_user_and_pass", username)
+	user, err := provider.validateUserAndPass(username, password, ip, protocol)
+	endQuerySpan(span, err)
+	return handlePasswordAuthResult(user, username, password, protocol, err)
 }
 
 // CheckUserAndPubKey retrieves the SFTP user with the given username and public key if a match is found or an error
 func CheckUserAndPubKey(username string, pubKey []byte, ip, protocol string, isSSHCert bool) (User, string, error) {
+	startTime := time.Now()
+	user, keyID, err := doCheckUserAndPubKey(username, pubKey, ip, protocol, isSSHCert)
+	metric.AddLoginDuration(protocol, SSHLoginMethodPublicKey, time.Since(startTime), err)
+	return user, keyID, err
+}
+
+func doCheckUserAndPubKey(username string, pubKey []byte, ip, protocol string, isSSHCert bool) (User, string, error) {
 	username = config.convertName(username)
 	if plugin.Handler.HasAuthScope(plugin.AuthScopePublicKey) {
 		user, err := doPluginAuth(username, "", pubKey, ip, protocol, nil, plugin.AuthScopePublicKey)
@@ -1239,12 +1462,22 @@ func CheckUserAndPubKey(username string, pubKey []byte, ip, protocol string, isS
 		}
 		return checkUserAndPubKey(&user, pubKey, isSSHCert)
 	}
-	return provider.validateUserAndPubKey(username, pubKey, isSSHCert)
+	span := startQuerySpan("validate_user_and_pub_key", username)
+	user, keyID, err := provider.validateUserAndPubKey(username, pubKey, isSSHCert)
+	endQuerySpan(span, err)
+	return handlePublicKeyAuthResult(user, username, pubKey, isSSHCert, keyID, err)
 }
 
 // CheckKeyboardInteractiveAuth checks the keyboard interactive authentication and returns
 // the authenticated user or an error
 func CheckKeyboardInteractiveAuth(username, authHook string, client ssh.KeyboardInteractiveChallenge, ip, protocol string) (User, error) {
+	startTime := time.Now()
+	user, err := doCheckKeyboardInteractiveAuth(username, authHook, client, ip, protocol)
+	metric.AddLoginDuration(protocol, SSHLoginMethodKeyboardInteractive, time.Since(startTime), err)
+	return user, err
+}
+
+func doCheckKeyboardInteractiveAuth(username, authHook string, client ssh.KeyboardInteractiveChallenge, ip, protocol string) (User, error) {
 	var user User
 	var err error
 	username = config.convertName(username)
@@ -1255,7 +1488,7 @@ func CheckKeyboardInteractiveAuth(username, authHook string, client ssh.Keyboard
 	} else if config.PreLoginHook != "" {
 		user, err = executePreLoginHook(username, SSHLoginMethodKeyboardInteractive, ip, protocol, nil)
 	} else {
-		user, err = provider.userExists(username)
+		user, err = lookupUserForAuth(username, provider.userExists)
 	}
 	if err != nil {
 		return user, err
@@ -1263,6 +1496,29 @@ func CheckKeyboardInteractiveAuth(username, authHook string, client ssh.Keyboard
 	return doKeyboardInteractiveAuth(&user, authHook, client, ip, protocol)
 }
 
+// CheckUserAfterGSSAPIAuth returns the SFTPGo user with the specified username
+// after a successful Kerberos authentication.
+// If a pre-login hook is defined it will be executed so the SFTPGo user
+// can be created if it does not exist
+func CheckUserAfterGSSAPIAuth(username, ip, protocol string) (User, error) {
+	var user User
+	var err error
+	username = config.convertName(username)
+	if config.PreLoginHook != "" {
+		user, err = executePreLoginHook(username, SSHLoginMethodGSSAPI, ip, protocol, nil)
+	} else {
+		user, err = lookupUserForAuth(username, provider.userExists)
+	}
+	if err != nil {
+		return user, err
+	}
+	if err = user.LoadAndApplyGroupSettings(); err != nil {
+		return user, err
+	}
+	err = user.CheckLoginConditions()
+	return user, err
+}
+
 // GetFTPPreAuthUser returns the SFTPGo user with the specified username
 // after receiving the FTP "USER" command.
 // If a pre-login hook is defined it will be executed so the SFTPGo user
@@ -1531,6 +1787,7 @@ func AddGroup(group *Group, executor, ipAddress string) error {
 	group.Name = config.convertName(group.Name)
 	err := provider.addGroup(group)
 	if err == nil {
+		addAuditEntry(operationAdd, executor, ipAddress, actionObjectGroup, group.Name, nil)
 		executeAction(operationAdd, executor, ipAddress, actionObjectGroup, group.Name, group)
 	}
 	return err
@@ -1538,8 +1795,10 @@ func AddGroup(group *Group, executor, ipAddress string) error {
 
 // UpdateGroup updates an existing Group
 func UpdateGroup(group *Group, users []string, executor, ipAddress string) error {
+	auditState := getAuditState(actionObjectGroup, group.Name)
 	err := provider.updateGroup(group)
 	if err == nil {
+		addAuditEntry(operationUpdate, executor, ipAddress, actionObjectGroup, group.Name, auditState)
 		for _, user := range users {
 			provider.setUpdatedAt(user)
 			u, err := provider.userExists(user)
@@ -1550,6 +1809,7 @@ func UpdateGroup(group *Group, users []string, executor, ipAddress string) error
 				RemoveCachedWebDAVUser(user)
 			}
 		}
+		notifyUsersChanged(operationUpdate, users...)
 		executeAction(operationUpdate, executor, ipAddress, actionObjectGroup, group.Name, group)
 	}
 	return err
@@ -1566,8 +1826,10 @@ func DeleteGroup(name string, executor, ipAddress string) error {
 		errorString := fmt.Sprintf("the group %#v is referenced, it cannot be removed", group.Name)
 		return util.NewValidationError(errorString)
 	}
+	auditState := getAuditState(actionObjectGroup, group.Name)
 	err = provider.deleteGroup(group)
 	if err == nil {
+		addAuditEntry(operationDelete, executor, ipAddress, actionObjectGroup, group.Name, auditState)
 		for _, user := range group.Users {
 			provider.setUpdatedAt(user)
 			u, err := provider.userExists(user)
@@ -1576,6 +1838,7 @@ func DeleteGroup(name string, executor, ipAddress string) error {
 			}
 			RemoveCachedWebDAVUser(user)
 		}
+		notifyUsersChanged(operationUpdate, group.Users...)
 		executeAction(operationDelete, executor, ipAddress, actionObjectGroup, group.Name, &group)
 	}
 	return err
@@ -1654,6 +1917,7 @@ func UpdateEventAction(action *BaseEventAction, executor, ipAddress string) erro
 		if fnReloadRules != nil {
 			fnReloadRules()
 		}
+		notifyRulesChanged(operationUpdate)
 		executeAction(operationUpdate, executor, ipAddress, actionObjectEventAction, action.Name, action)
 	}
 	return err
@@ -1698,9 +1962,11 @@ func AddEventRule(rule *EventRule, executor, ipAddress string) error {
 	rule.Name = config.convertName(rule.Name)
 	err := provider.addEventRule(rule)
 	if err == nil {
+		addAuditEntry(operationAdd, executor, ipAddress, actionObjectEventRule, rule.Name, nil)
 		if fnReloadRules != nil {
 			fnReloadRules()
 		}
+		notifyRulesChanged(operationUpdate)
 		executeAction(operationAdd, executor, ipAddress, actionObjectEventRule, rule.Name, rule)
 	}
 	return err
@@ -1708,11 +1974,14 @@ func AddEventRule(rule *EventRule, executor, ipAddress string) error {
 
 // UpdateEventRule updates an existing event rule
 func UpdateEventRule(rule *EventRule, executor, ipAddress string) error {
+	auditState := getAuditState(actionObjectEventRule, rule.Name)
 	err := provider.updateEventRule(rule)
 	if err == nil {
+		addAuditEntry(operationUpdate, executor, ipAddress, actionObjectEventRule, rule.Name, auditState)
 		if fnReloadRules != nil {
 			fnReloadRules()
 		}
+		notifyRulesChanged(operationUpdate)
 		executeAction(operationUpdate, executor, ipAddress, actionObjectEventRule, rule.Name, rule)
 	}
 	return err
@@ -1725,11 +1994,14 @@ func DeleteEventRule(name string, executor, ipAddress string) error {
 	if err != nil {
 		return err
 	}
+	auditState := getAuditState(actionObjectEventRule, rule.Name)
 	err = provider.deleteEventRule(rule, config.IsShared == 1)
 	if err == nil {
+		addAuditEntry(operationDelete, executor, ipAddress, actionObjectEventRule, rule.Name, auditState)
 		if fnRemoveRule != nil {
 			fnRemoveRule(rule.Name)
 		}
+		notifyRulesChanged(operationDelete, rule.Name)
 		executeAction(operationDelete, executor, ipAddress, actionObjectEventRule, rule.Name, &rule)
 	}
 	return err
@@ -1792,6 +2064,7 @@ func HasAdmin() bool {
 // AddAdmin adds a new SFTPGo admin
 func AddAdmin(admin *Admin, executor, ipAddress string) error {
 	admin.Filters.RecoveryCodes = nil
+	admin.Filters.WebAuthnCredentials = nil
 	admin.Filters.TOTPConfig = AdminTOTPConfig{
 		Enabled: false,
 	}
@@ -1799,6 +2072,7 @@ func AddAdmin(admin *Admin, executor, ipAddress string) error {
 	err := provider.addAdmin(admin)
 	if err == nil {
 		isAdminCreated.Store(true)
+		addAuditEntry(operationAdd, executor, ipAddress, actionObjectAdmin, admin.Username, nil)
 		executeAction(operationAdd, executor, ipAddress, actionObjectAdmin, admin.Username, admin)
 	}
 	return err
@@ -1806,8 +2080,10 @@ func AddAdmin(admin *Admin, executor, ipAddress string) error {
 
 // UpdateAdmin updates an existing SFTPGo admin
 func UpdateAdmin(admin *Admin, executor, ipAddress string) error {
+	auditState := getAuditState(actionObjectAdmin, admin.Username)
 	err := provider.updateAdmin(admin)
 	if err == nil {
+		addAuditEntry(operationUpdate, executor, ipAddress, actionObjectAdmin, admin.Username, auditState)
 		executeAction(operationUpdate, executor, ipAddress, actionObjectAdmin, admin.Username, admin)
 	}
 	return err
@@ -1820,8 +2096,10 @@ func DeleteAdmin(username, executor, ipAddress string) error {
 	if err != nil {
 		return err
 	}
+	auditState := getAuditState(actionObjectAdmin, admin.Username)
 	err = provider.deleteAdmin(admin)
 	if err == nil {
+		addAuditEntry(operationDelete, executor, ipAddress, actionObjectAdmin, admin.Username, auditState)
 		executeAction(operationDelete, executor, ipAddress, actionObjectAdmin, admin.Username, &admin)
 	}
 	return err
@@ -1836,14 +2114,14 @@ func AdminExists(username string) (Admin, error) {
 // UserExists checks if the given SFTPGo username exists, returns an error if no match is found
 func UserExists(username string) (User, error) {
 	username = config.convertName(username)
-	return provider.userExists(username)
+	return traceUserLookup(username, provider.userExists)
 }
 
 // GetUserWithGroupSettings tries to return the user with the specified username
 // loading also the group settings
 func GetUserWithGroupSettings(username string) (User, error) {
 	username = config.convertName(username)
-	user, err := provider.userExists(username)
+	user, err := traceUserLookup(username, provider.userExists)
 	if err != nil {
 		return user, err
 	}
@@ -1867,28 +2145,42 @@ func GetUserVariants(username string) (User, User, error) {
 // AddUser adds a new SFTPGo user.
 func AddUser(user *User, executor, ipAddress string) error {
 	user.Filters.RecoveryCodes = nil
+	user.Filters.WebAuthnCredentials = nil
 	user.Filters.TOTPConfig = UserTOTPConfig{
 		Enabled: false,
 	}
 	user.Username = config.convertName(user.Username)
 	err := provider.addUser(user)
 	if err == nil {
+		addAuditEntry(operationAdd, executor, ipAddress, actionObjectUser, user.Username, nil)
 		executeAction(operationAdd, executor, ipAddress, actionObjectUser, user.Username, user)
 	}
 	return err
 }
 
-// UpdateUserPassword updates the user password
+// UpdateUserPassword updates the user password.
+// The password policy assigned to the user, if any, is enforced
 func UpdateUserPassword(username, plainPwd, executor, ipAddress string) error {
-	hashedPwd, err := hashPlainPassword(plainPwd)
+	user, err := provider.userExists(username)
+	if err != nil {
+		return util.NewGenericError(fmt.Sprintf("unable to set the new password: %v", err))
+	}
+	hashedPwd, state, err := hashUserPassword(&user, plainPwd)
 	if err != nil {
+		if _, ok := err.(*util.ValidationError); ok {
+			return err
+		}
 		return util.NewGenericError(fmt.Sprintf("unable to set the new password: %v", err))
 	}
-	err = provider.updateUserPassword(username, hashedPwd)
+	auditState := getAuditState(actionObjectUser, username)
+	err = provider.updateUserPassword(username, hashedPwd, state)
 	if err != nil {
 		return util.NewGenericError(fmt.Sprintf("unable to set the new password: %v", err))
 	}
 	cachedPasswords.Remove(username)
+	authCredentialsCache.remove(username)
+	notifyUsersChanged(operationUpdate, username)
+	addAuditEntry(operationUpdate, executor, ipAddress, actionObjectUser, username, auditState)
 	executeAction(operationUpdate, executor, ipAddress, actionObjectUser, username, &User{})
 	return nil
 }
@@ -1898,32 +2190,144 @@ func UpdateUser(user *User, executor, ipAddress string) error {
 	if user.groupSettingsApplied {
 		return errors.New("cannot save a user with group settings applied")
 	}
+	auditState := getAuditState(actionObjectUser, user.Username)
 	err := provider.updateUser(user)
 	if err == nil {
+		addAuditEntry(operationUpdate, executor, ipAddress, actionObjectUser, user.Username, auditState)
 		webDAVUsersCache.swap(user)
 		cachedPasswords.Remove(user.Username)
+		authCredentialsCache.remove(user.Username)
+		notifyUsersChanged(operationUpdate, user.Username)
 		executeAction(operationUpdate, executor, ipAddress, actionObjectUser, user.Username, user)
 	}
 	return err
 }
 
 // DeleteUser deletes an existing SFTPGo user.
+// If a soft delete retention is configured, the user is soft deleted and
+// permanently removed after the retention period, deleting a soft deleted
+// user removes it immediately
 func DeleteUser(username, executor, ipAddress string) error {
 	username = config.convertName(username)
 	user, err := provider.userExists(username)
 	if err != nil {
 		return err
 	}
+	if config.SoftDeleteRetention > 0 && !user.IsSoftDeleted() {
+		return softDeleteUser(user, executor, ipAddress)
+	}
+	auditState := getAuditState(actionObjectUser, user.Username)
 	err = provider.deleteUser(user, config.IsShared == 1)
 	if err == nil {
+		addAuditEntry(operationDelete, executor, ipAddress, actionObjectUser, user.Username, auditState)
 		RemoveCachedWebDAVUser(user.Username)
 		delayedQuotaUpdater.resetUserQuota(user.Username)
 		cachedPasswords.Remove(username)
+		authCredentialsCache.remove(username)
+		ldapCredentialsCache.remove(username)
+		notifyUsersChanged(operationDelete, username)
 		executeAction(operationDelete, executor, ipAddress, actionObjectUser, user.Username, &user)
 	}
 	return err
 }
 
+func softDeleteUser(user User, executor, ipAddress string) error {
+	auditState := getAuditState(actionObjectUser, user.Username)
+	user.SoftDeletedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	err := provider.setUserSoftDeletedAt(user.Username, user.SoftDeletedAt)
+	if err != nil {
+		return err
+	}
+	addAuditEntry(operationSoftDelete, executor, ipAddress, actionObjectUser, user.Username, auditState)
+	RemoveCachedWebDAVUser(user.Username)
+	cachedPasswords.Remove(user.Username)
+	authCredentialsCache.remove(user.Username)
+	ldapCredentialsCache.remove(user.Username)
+	notifyUsersChanged(operationUpdate, user.Username)
+	providerLog(logger.LevelInfo, "user %q soft deleted, executor %q, ip %q", user.Username, executor, ipAddress)
+	executeAction(operationSoftDelete, executor, ipAddress, actionObjectUser, user.Username, &user)
+	return nil
+}
+
+// RestoreUser restores a soft deleted user with all its settings
+func RestoreUser(username, executor, ipAddress string) error {
+	username = config.convertName(username)
+	user, err := provider.userExists(username)
+	if err != nil {
+		return err
+	}
+	if !user.IsSoftDeleted() {
+		return util.NewValidationError(fmt.Sprintf("user %q is not deleted", user.Username))
+	}
+	auditState := getAuditState(actionObjectUser, user.Username)
+	if err := provider.setUserSoftDeletedAt(user.Username, 0); err != nil {
+		return err
+	}
+	addAuditEntry(operationRestore, executor, ipAddress, actionObjectUser, user.Username, auditState)
+	user.SoftDeletedAt = 0
+	notifyUsersChanged(operationUpdate, user.Username)
+	providerLog(logger.LevelInfo, "user %q restored, executor %q, ip %q", user.Username, executor, ipAddress)
+	executeAction(operationRestore, executor, ipAddress, actionObjectUser, user.Username, &user)
+	return nil
+}
+
+// CryptoShredUser makes the files of a user with a local encrypted filesystem
+// irrecoverable, even with the passphrase, without waiting for their removal.
+// The nested virtual folders are not affected
+func CryptoShredUser(username, executor, ipAddress string) error {
+	username = config.convertName(username)
+	user, err := provider.userExists(username)
+	if err != nil {
+		return err
+	}
+	effectiveUser := user.getACopy()
+	if err := effectiveUser.LoadAndApplyGroupSettings(); err != nil {
+		return err
+	}
+	if effectiveUser.FsConfig.Provider != sdk.CryptedFilesystemProvider {
+		return util.NewValidationError("crypto-shredding is only supported for local encrypted filesystems")
+	}
+	var excludedDirs []string
+	for _, folder := range effectiveUser.VirtualFolders {
+		if folder.MappedPath != "" {
+			excludedDirs = append(excludedDirs, folder.MappedPath)
+		}
+	}
+	shredded, err := vfs.ShredCryptFsKeys(effectiveUser.GetHomeDir(), excludedDirs)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to crypto-shred user %q, shredded files: %d, executor %q, ip %q: %v",
+			user.Username, shredded, executor, ipAddress, err)
+		return err
+	}
+	providerLog(logger.LevelInfo, "user %q crypto-shredded, shredded files: %d, executor %q, ip %q",
+		user.Username, shredded, executor, ipAddress)
+	executeAction(operationCryptoShred, executor, ipAddress, actionObjectUser, user.Username, &user)
+	return nil
+}
+
+// CryptoShredFolder makes the files of a virtual folder with a local encrypted
+// filesystem irrecoverable, even with the passphrase, without waiting for their removal
+func CryptoShredFolder(folderName, executor, ipAddress string) error {
+	folderName = config.convertName(folderName)
+	folder, err := provider.getFolderByName(folderName)
+	if err != nil {
+		return err
+	}
+	if folder.FsConfig.Provider != sdk.CryptedFilesystemProvider {
+		return util.NewValidationError("crypto-shredding is only supported for local encrypted filesystems")
+	}
+	shredded, err := vfs.ShredCryptFsKeys(folder.MappedPath, nil)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to crypto-shred folder %q, shredded files: %d, executor %q, ip %q: %v",
+			folder.Name, shredded, executor, ipAddress, err)
+		return err
+	}
+	providerLog(logger.LevelInfo, "folder %q crypto-shredded, shredded files: %d, executor %q, ip %q",
+		folder.Name, shredded, executor, ipAddress)
+	executeAction(operationCryptoShred, executor, ipAddress, actionObjectFolder, folder.Name, &wrappedFolder{Folder: folder})
+	return nil
+}
+
 // AddActiveTransfer stores the specified transfer
 func AddActiveTransfer(transfer ActiveTransfer) {
 	if err := provider.addActiveTransfer(transfer); err != nil {
@@ -1988,6 +2392,12 @@ func GetSharedSession(key string) (Session, error) {
 	return provider.getSharedSession(key)
 }
 
+// GetSharedSessions returns the shared sessions with the specified type and
+// a timestamp not before the specified time
+func GetSharedSessions(sessionType SessionType, after time.Time) ([]Session, error) {
+	return provider.getSharedSessions(sessionType, util.GetTimeAsMsSinceEpoch(after))
+}
+
 // CleanupSharedSessions removes the shared session with the specified type and
 // before the specified time
 func CleanupSharedSessions(sessionType SessionType, before time.Time) error {
@@ -2032,6 +2442,11 @@ func GetUsers(limit, offset int, order string) ([]User, error) {
 	return provider.getUsers(limit, offset, order)
 }
 
+// GetSoftDeletedUsers returns an array of soft deleted users respecting limit and offset
+func GetSoftDeletedUsers(limit, offset int, order string) ([]User, error) {
+	return provider.getSoftDeletedUsers(limit, offset, order)
+}
+
 // GetUsersForQuotaCheck returns the users with the fields required for a quota check
 func GetUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
 	return provider.getUsersForQuotaCheck(toFetch)
@@ -2042,6 +2457,7 @@ func AddFolder(folder *vfs.BaseVirtualFolder, executor, ipAddress string) error
 	folder.Name = config.convertName(folder.Name)
 	err := provider.addFolder(folder)
 	if err == nil {
+		addAuditEntry(operationAdd, executor, ipAddress, actionObjectFolder, folder.Name, nil)
 		executeAction(operationAdd, executor, ipAddress, actionObjectFolder, folder.Name, &wrappedFolder{Folder: *folder})
 	}
 	return err
@@ -2049,8 +2465,10 @@ func AddFolder(folder *vfs.BaseVirtualFolder, executor, ipAddress string) error
 
 // UpdateFolder updates the specified virtual folder
 func UpdateFolder(folder *vfs.BaseVirtualFolder, users []string, groups []string, executor, ipAddress string) error {
+	auditState := getAuditState(actionObjectFolder, folder.Name)
 	err := provider.updateFolder(folder)
 	if err == nil {
+		addAuditEntry(operationUpdate, executor, ipAddress, actionObjectFolder, folder.Name, auditState)
 		executeAction(operationUpdate, executor, ipAddress, actionObjectFolder, folder.Name, &wrappedFolder{Folder: *folder})
 		usersInGroups, errGrp := provider.getUsersInGroups(groups)
 		if errGrp == nil {
@@ -2069,41 +2487,94 @@ func UpdateFolder(folder *vfs.BaseVirtualFolder, users []string, groups []string
 				RemoveCachedWebDAVUser(user)
 			}
 		}
+		notifyUsersChanged(operationUpdate, users...)
 	}
 	return err
 }
 
 // DeleteFolder deletes an existing folder.
+// If a soft delete retention is configured, the folder is soft deleted and
+// permanently removed after the retention period, deleting a soft deleted
+// folder removes it immediately
 func DeleteFolder(folderName, executor, ipAddress string) error {
 	folderName = config.convertName(folderName)
 	folder, err := provider.getFolderByName(folderName)
 	if err != nil {
 		return err
 	}
+	if config.SoftDeleteRetention > 0 && !folder.IsSoftDeleted() {
+		return softDeleteFolder(folder, executor, ipAddress)
+	}
+	auditState := getAuditState(actionObjectFolder, folder.Name)
 	err = provider.deleteFolder(folder)
 	if err == nil {
+		addAuditEntry(operationDelete, executor, ipAddress, actionObjectFolder, folder.Name, auditState)
 		executeAction(operationDelete, executor, ipAddress, actionObjectFolder, folder.Name, &wrappedFolder{Folder: folder})
-		users := folder.Users
-		usersInGroups, errGrp := provider.getUsersInGroups(folder.Groups)
-		if errGrp == nil {
-			users = append(users, usersInGroups...)
-			users = util.RemoveDuplicates(users, false)
-		} else {
-			providerLog(logger.LevelWarn, "unable to get users in groups %+v: %v", folder.Groups, errGrp)
-		}
-		for _, user := range users {
-			provider.setUpdatedAt(user)
-			u, err := provider.userExists(user)
-			if err == nil {
-				executeAction(operationUpdate, executor, ipAddress, actionObjectUser, u.Username, &u)
-			}
-			RemoveCachedWebDAVUser(user)
-		}
+		updateFolderRelatedUsers(folder, executor, ipAddress)
 		delayedQuotaUpdater.resetFolderQuota(folderName)
 	}
 	return err
 }
 
+func softDeleteFolder(folder vfs.BaseVirtualFolder, executor, ipAddress string) error {
+	auditState := getAuditState(actionObjectFolder, folder.Name)
+	folder.SoftDeletedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	err := provider.setFolderSoftDeletedAt(folder.Name, folder.SoftDeletedAt)
+	if err != nil {
+		return err
+	}
+	addAuditEntry(operationSoftDelete, executor, ipAddress, actionObjectFolder, folder.Name, auditState)
+	providerLog(logger.LevelInfo, "folder %q soft deleted, executor %q, ip %q", folder.Name, executor, ipAddress)
+	executeAction(operationSoftDelete, executor, ipAddress, actionObjectFolder, folder.Name, &wrappedFolder{Folder: folder})
+	updateFolderRelatedUsers(folder, executor, ipAddress)
+	return nil
+}
+
+// RestoreFolder restores a soft deleted folder, the folder is available again
+// to the users and groups it was mapped to
+func RestoreFolder(folderName, executor, ipAddress string) error {
+	folderName = config.convertName(folderName)
+	folder, err := provider.getFolderByName(folderName)
+	if err != nil {
+		return err
+	}
+	if !folder.IsSoftDeleted() {
+		return util.NewValidationError(fmt.Sprintf("folder %q is not deleted", folder.Name))
+	}
+	auditState := getAuditState(actionObjectFolder, folder.Name)
+	if err := provider.setFolderSoftDeletedAt(folder.Name, 0); err != nil {
+		return err
+	}
+	addAuditEntry(operationRestore, executor, ipAddress, actionObjectFolder, folder.Name, auditState)
+	folder.SoftDeletedAt = 0
+	providerLog(logger.LevelInfo, "folder %q restored, executor %q, ip %q", folder.Name, executor, ipAddress)
+	executeAction(operationRestore, executor, ipAddress, actionObjectFolder, folder.Name, &wrappedFolder{Folder: folder})
+	updateFolderRelatedUsers(folder, executor, ipAddress)
+	return nil
+}
+
+// updateFolderRelatedUsers notifies the users, directly or through groups,
+// mapped to a folder that is no longer, or again, available
+func updateFolderRelatedUsers(folder vfs.BaseVirtualFolder, executor, ipAddress string) {
+	users := folder.Users
+	usersInGroups, errGrp := provider.getUsersInGroups(folder.Groups)
+	if errGrp == nil {
+		users = append(users, usersInGroups...)
+		users = util.RemoveDuplicates(users, false)
+	} else {
+		providerLog(logger.LevelWarn, "unable to get users in groups %+v: %v", folder.Groups, errGrp)
+	}
+	for _, user := range users {
+		provider.setUpdatedAt(user)
+		u, err := provider.userExists(user)
+		if err == nil {
+			executeAction(operationUpdate, executor, ipAddress, actionObjectUser, u.Username, &u)
+		}
+		RemoveCachedWebDAVUser(user)
+	}
+	notifyUsersChanged(operationUpdate, users...)
+}
+
 // GetFolderByName returns the folder with the specified name if any
 func GetFolderByName(name string) (vfs.BaseVirtualFolder, error) {
 	name = config.convertName(name)
@@ -2115,6 +2586,11 @@ func GetFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtua
 	return provider.getFolders(limit, offset, order, minimal)
 }
 
+// GetSoftDeletedFolders returns an array of soft deleted folders respecting limit and offset
+func GetSoftDeletedFolders(limit, offset int, order string) ([]vfs.BaseVirtualFolder, error) {
+	return provider.getSoftDeletedFolders(limit, offset, order)
+}
+
 // DumpUsers returns all users, including confidential data
 func DumpUsers() ([]User, error) {
 	return provider.dumpUsers()
@@ -2125,6 +2601,18 @@ func DumpFolders() ([]vfs.BaseVirtualFolder, error) {
 	return provider.dumpFolders()
 }
 
+// BackupDatabase creates a copy of the database to outputFile without stopping
+// the service. It is only supported for the SQLite data provider
+func BackupDatabase(outputFile string) error {
+	err := provider.backupDatabase(outputFile)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to backup the database to %q: %v", outputFile, err)
+		return err
+	}
+	providerLog(logger.LevelInfo, "database backup saved to %q", outputFile)
+	return nil
+}
+
 // DumpData returns all users, groups, folders, admins, api keys, shares, actions, rules
 func DumpData() (BackupData, error) {
 	var data BackupData
@@ -2204,6 +2692,7 @@ func GetProviderStatus() ProviderStatus {
 // Closing an uninitialized provider is not supported
 func Close() error {
 	stopScheduler()
+	stopCacheInvalidation()
 	return provider.close()
 }
 
@@ -2224,6 +2713,10 @@ func createProvider(basePath string) error {
 		return initializeMySQLProvider()
 	case BoltDataProviderName:
 		return initializeBoltProvider(basePath)
+	case EtcdDataProviderName:
+		return initializeEtcdProvider()
+	case MongoDBDataProviderName:
+		return initializeMongoDBProvider()
 	case MemoryDataProviderName:
 		initializeMemoryProvider(basePath)
 		return nil
@@ -2294,7 +2787,8 @@ func buildUserHomeDir(user *User) {
 			return
 		}
 		switch user.FsConfig.Provider {
-		case sdk.SFTPFilesystemProvider, sdk.S3FilesystemProvider, sdk.AzureBlobFilesystemProvider, sdk.GCSFilesystemProvider, sdk.HTTPFilesystemProvider:
+		case sdk.SFTPFilesystemProvider, sdk.S3FilesystemProvider, sdk.AzureBlobFilesystemProvider, sdk.GCSFilesystemProvider, sdk.HTTPFilesystemProvider,
+			vfs.SMBFilesystemProvider, vfs.WebDAVFilesystemProvider:
 			if tempPath != "" {
 				user.HomeDir = filepath.Join(tempPath, user.Username)
 			} else {
@@ -2336,6 +2830,14 @@ func validateFolderQuotaLimits(folder vfs.VirtualFolder) error {
 		return util.NewValidationError(fmt.Sprintf("virtual folder quota_size and quota_files must be both -1 or >= 0, quota_size: %v quota_files: %v",
 			folder.QuotaFiles, folder.QuotaSize))
 	}
+	if folder.UserQuotaSize < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid user_quota_size: %v folder path %#v", folder.UserQuotaSize,
+			folder.MappedPath))
+	}
+	if folder.UserQuotaFiles < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid user_quota_files: %v folder path %#v", folder.UserQuotaFiles,
+			folder.MappedPath))
+	}
 	return nil
 }
 
@@ -2417,6 +2919,8 @@ func validateAssociatedVirtualFolders(vfolders []vfs.VirtualFolder) ([]vfs.Virtu
 			VirtualPath:       cleanedVPath,
 			QuotaSize:         v.QuotaSize,
 			QuotaFiles:        v.QuotaFiles,
+			UserQuotaSize:     v.UserQuotaSize,
+			UserQuotaFiles:    v.UserQuotaFiles,
 		})
 		folderNames[folder.Name] = true
 	}
@@ -2456,6 +2960,109 @@ func validateUserTOTPConfig(c *UserTOTPConfig, username string) error {
 	return nil
 }
 
+func validateUserPushConfig(c *UserPushConfig) error {
+	if !c.Enabled {
+		c.Protocols = nil
+		return nil
+	}
+	if !mfa.IsPushEnabled() {
+		return util.NewValidationError("push: push authentication is not enabled")
+	}
+	if len(c.Protocols) == 0 {
+		return util.NewValidationError("push: specify at least one protocol")
+	}
+	c.Protocols = util.RemoveDuplicates(c.Protocols, false)
+	for _, protocol := range c.Protocols {
+		if !util.Contains(PushMFAProtocols, protocol) {
+			return util.NewValidationError(fmt.Sprintf("push: invalid protocol %q", protocol))
+		}
+	}
+	return nil
+}
+
+func validateUserSFTPCredentials(user *User) error {
+	folderNames := make(map[string]bool)
+	for idx := range user.Filters.SFTPCredentials {
+		credentials := &user.Filters.SFTPCredentials[idx]
+		if err := credentials.validate(user.GetEncryptionAdditionalData()); err != nil {
+			return err
+		}
+		if folderNames[credentials.FolderName] {
+			return util.NewValidationError(fmt.Sprintf("duplicated SFTP remote credentials for folder %q",
+				credentials.FolderName))
+		}
+		folderNames[credentials.FolderName] = true
+	}
+	return nil
+}
+
+func validateUserS3SecretAccessKey(user *User) error {
+	if user.Filters.S3SecretAccessKey == nil {
+		return nil
+	}
+	if user.Filters.S3SecretAccessKey.IsEmpty() {
+		user.Filters.S3SecretAccessKey = nil
+		return nil
+	}
+	if user.Filters.S3SecretAccessKey.IsPlain() {
+		if len(user.Filters.S3SecretAccessKey.GetPayload()) < 16 {
+			return util.NewValidationError("the S3 secret access key must be at least 16 characters long")
+		}
+		user.Filters.S3SecretAccessKey.SetAdditionalData(user.Username)
+		if err := user.Filters.S3SecretAccessKey.Encrypt(); err != nil {
+			return util.NewValidationError(fmt.Sprintf("unable to encrypt the S3 secret access key: %v", err))
+		}
+	}
+	return nil
+}
+
+func validateLanguage(lang *string) error {
+	*lang = util.NormalizeLanguage(*lang)
+	if *lang != "" && !util.IsLanguageValid(*lang) {
+		return util.NewValidationError(fmt.Sprintf("invalid language %q", *lang))
+	}
+	return nil
+}
+
+func validateUITheme(theme *string) error {
+	*theme = strings.ToLower(strings.TrimSpace(*theme))
+	if *theme != "" && !util.Contains(UIThemes, *theme) {
+		return util.NewValidationError(fmt.Sprintf("invalid theme %q", *theme))
+	}
+	return nil
+}
+
+func validateCountryCodes(allowed, denied *[]string) error {
+	var err error
+	*allowed, err = util.NormalizeCountryCodes(*allowed)
+	if err != nil {
+		return util.NewValidationError(fmt.Sprintf("invalid allowed countries: %v", err))
+	}
+	*denied, err = util.NormalizeCountryCodes(*denied)
+	if err != nil {
+		return util.NewValidationError(fmt.Sprintf("invalid denied countries: %v", err))
+	}
+	return nil
+}
+
+func validateFTPPassiveHost(host *string) error {
+	*host = strings.TrimSpace(*host)
+	if *host == "" {
+		return nil
+	}
+	if ip := net.ParseIP(*host); ip != nil {
+		if ip.To4() == nil {
+			return util.NewValidationError(fmt.Sprintf("the FTP passive IP %q is not a valid IPv4 address", *host))
+		}
+		*host = ip.To4().String()
+		return nil
+	}
+	if len(*host) > 253 || !hostnameRegex.MatchString(*host) {
+		return util.NewValidationError(fmt.Sprintf("invalid FTP passive host %q", *host))
+	}
+	return nil
+}
+
 func validateUserRecoveryCodes(user *User) error {
 	for i := 0; i < len(user.Filters.RecoveryCodes); i++ {
 		code := &user.Filters.RecoveryCodes[i]
@@ -2472,6 +3079,48 @@ func validateUserRecoveryCodes(user *User) error {
 	return nil
 }
 
+func validateWebAuthnCredentials(credentials []mfa.WebAuthnCredential) error {
+	if len(credentials) > maxWebAuthnCredentials {
+		return util.NewValidationError(fmt.Sprintf("webauthn: too many credentials, max allowed: %d", maxWebAuthnCredentials))
+	}
+	ids := make(map[string]bool)
+	names := make(map[string]bool)
+	for idx := range credentials {
+		credential := &credentials[idx]
+		credential.Name = strings.TrimSpace(credential.Name)
+		if len(credential.ID) == 0 || len(credential.PublicKey) == 0 {
+			return util.NewValidationError("webauthn: credential ID and public key are mandatory")
+		}
+		if credential.Name == "" {
+			return util.NewValidationError("webauthn: credential name is mandatory")
+		}
+		if ids[string(credential.ID)] {
+			return util.NewValidationError("webauthn: duplicated credential")
+		}
+		if names[credential.Name] {
+			return util.NewValidationError(fmt.Sprintf("webauthn: duplicated credential name %q", credential.Name))
+		}
+		ids[string(credential.ID)] = true
+		names[credential.Name] = true
+	}
+	return nil
+}
+
+func copyWebAuthnCredentials(credentials []mfa.WebAuthnCredential) []mfa.WebAuthnCredential {
+	if len(credentials) == 0 {
+		return nil
+	}
+	result := make([]mfa.WebAuthnCredential, 0, len(credentials))
+	for _, c := range credentials {
+		c.ID = append([]byte(nil), c.ID...)
+		c.PublicKey = append([]byte(nil), c.PublicKey...)
+		c.AAGUID = append([]byte(nil), c.AAGUID...)
+		c.Transports = append([]string(nil), c.Transports...)
+		result = append(result, c)
+	}
+	return result
+}
+
 func validateUserPermissions(permsToCheck map[string][]string) (map[string][]string, error) {
 	permissions := make(map[string][]string)
 	for dir, perms := range permsToCheck {
@@ -2530,10 +3179,13 @@ func validatePublicKeys(user *User) error {
 		if k == "" {
 			continue
 		}
-		_, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		_, _, options, _, err := ssh.ParseAuthorizedKey([]byte(k))
 		if err != nil {
 			return util.NewValidationError(fmt.Sprintf("could not parse key nr. %d: %s", i+1, err))
 		}
+		if _, err := ParsePublicKeyOptions(options); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid options for key nr. %d: %s", i+1, err))
+		}
 		validatedKeys = append(validatedKeys, k)
 	}
 	user.PublicKeys = util.RemoveDuplicates(validatedKeys, false)
@@ -2773,7 +3425,16 @@ func validateBaseParams(user *User) error {
 	if user.Filters.IsAnonymous {
 		user.setAnonymousSettings()
 	}
-	return user.FsConfig.Validate(user.GetEncryptionAdditionalData())
+	if user.FsConfig.Provider == sdk.SFTPFilesystemProvider && user.FsConfig.SFTPConfig.UserCredentials {
+		return util.NewValidationError("per-user remote credentials are supported for virtual folders only")
+	}
+	if err := user.FsConfig.Validate(user.GetEncryptionAdditionalData()); err != nil {
+		return err
+	}
+	if user.FsConfig.Provider == vfs.DedupFilesystemProvider {
+		return user.FsConfig.DedupConfig.ValidateRootDir(user.HomeDir)
+	}
+	return nil
 }
 
 func hashPlainPassword(plainPwd string) (string, error) {
@@ -2793,20 +3454,46 @@ func hashPlainPassword(plainPwd string) (string, error) {
 
 func createUserPasswordHash(user *User) error {
 	if user.Password != "" && !user.IsPasswordHashed() {
-		if config.PasswordValidation.Users.MinEntropy > 0 {
-			if err := passwordvalidator.Validate(user.Password, config.PasswordValidation.Users.MinEntropy); err != nil {
-				return util.NewValidationError(err.Error())
-			}
-		}
-		hashedPwd, err := hashPlainPassword(user.Password)
+		hashedPwd, state, err := hashUserPassword(user, user.Password)
 		if err != nil {
 			return err
 		}
 		user.Password = hashedPwd
+		state.apply(user)
 	}
 	return nil
 }
 
+// hashUserPassword validates the specified plain text password against the
+// validation rules and the password policy for the user and returns its hash
+func hashUserPassword(user *User, password string) (string, *userPasswordState, error) {
+	if config.PasswordValidation.Users.MinEntropy > 0 {
+		if err := passwordvalidator.Validate(password, config.PasswordValidation.Users.MinEntropy); err != nil {
+			return "", nil, util.NewValidationError(err.Error())
+		}
+	}
+	policy, err := getUserPasswordPolicy(user)
+	if err != nil {
+		return "", nil, err
+	}
+	if policy != nil {
+		if err := policy.checkPassword(password, user.Filters.PasswordHistory); err != nil {
+			return "", nil, err
+		}
+	}
+	hashedPwd, err := hashPlainPassword(password)
+	if err != nil {
+		return "", nil, err
+	}
+	if policy == nil {
+		return hashedPwd, nil, nil
+	}
+	return hashedPwd, &userPasswordState{
+		changedAt: util.GetTimeAsMsSinceEpoch(time.Now()),
+		history:   policy.getHistory(hashedPwd, user.Filters.PasswordHistory),
+	}, nil
+}
+
 // ValidateFolder returns an error if the folder is not valid
 // FIXME: this should be defined as Folder struct method
 func ValidateFolder(folder *vfs.BaseVirtualFolder) error {
@@ -2818,8 +3505,7 @@ func ValidateFolder(folder *vfs.BaseVirtualFolder) error {
 		return util.NewValidationError(fmt.Sprintf("folder name %#v is not valid, the following characters are allowed: a-zA-Z0-9-_.~",
 			folder.Name))
 	}
-	if folder.FsConfig.Provider == sdk.LocalFilesystemProvider || folder.FsConfig.Provider == sdk.CryptedFilesystemProvider ||
-		folder.MappedPath != "" {
+	if folder.IsLocalOrLocalCrypted() || folder.MappedPath != "" {
 		cleanedMPath := filepath.Clean(folder.MappedPath)
 		if !filepath.IsAbs(cleanedMPath) {
 			return util.NewValidationError(fmt.Sprintf("invalid folder mapped path %#v", folder.MappedPath))
@@ -2829,7 +3515,16 @@ func ValidateFolder(folder *vfs.BaseVirtualFolder) error {
 	if folder.HasRedactedSecret() {
 		return errors.New("cannot save a folder with a redacted secret")
 	}
-	return folder.FsConfig.Validate(folder.GetEncryptionAdditionalData())
+	if err := folder.FsConfig.Validate(folder.GetEncryptionAdditionalData()); err != nil {
+		return err
+	}
+	if err := folder.Metadata.Validate(); err != nil {
+		return err
+	}
+	if folder.FsConfig.Provider == vfs.DedupFilesystemProvider {
+		return folder.FsConfig.DedupConfig.ValidateRootDir(folder.MappedPath)
+	}
+	return nil
 }
 
 // ValidateUser returns an error if the user is not valid
@@ -2853,6 +3548,54 @@ func ValidateUser(user *User) error {
 	if err := validateUserRecoveryCodes(user); err != nil {
 		return err
 	}
+	if err := validateWebAuthnCredentials(user.Filters.WebAuthnCredentials); err != nil {
+		return err
+	}
+	if err := validateUserPushConfig(&user.Filters.PushConfig); err != nil {
+		return err
+	}
+	if err := validateLanguage(&user.Filters.Language); err != nil {
+		return err
+	}
+	if err := validateUITheme(&user.Filters.Theme); err != nil {
+		return err
+	}
+	if err := validateUserS3SecretAccessKey(user); err != nil {
+		return err
+	}
+	if err := validateUserSFTPCredentials(user); err != nil {
+		return err
+	}
+	if err := validateFTPPassiveHost(&user.Filters.FTPPassiveHost); err != nil {
+		return err
+	}
+	if err := validatePasswordPolicyName(&user.Filters.PasswordPolicy); err != nil {
+		return err
+	}
+	if err := validateCountryCodes(&user.Filters.AllowedCountries, &user.Filters.DeniedCountries); err != nil {
+		return err
+	}
+	if err := validateUserPortForwarding(user); err != nil {
+		return err
+	}
+	if err := validateUserClientPolicy(user); err != nil {
+		return err
+	}
+	if err := validateUserUploadSizeLimits(user); err != nil {
+		return err
+	}
+	if err := validateUserMultipartUploads(user); err != nil {
+		return err
+	}
+	if err := validateBandwidthSchedules(user.Filters.BandwidthSchedules); err != nil {
+		return err
+	}
+	if err := user.Filters.TransferQuotaReset.validate(); err != nil {
+		return err
+	}
+	if err := user.Metadata.Validate(); err != nil {
+		return err
+	}
 	vfolders, err := validateAssociatedVirtualFolders(user.VirtualFolders)
 	if err != nil {
 		return err
@@ -2930,7 +3673,7 @@ func isPasswordOK(user *User, password string) (bool, error) {
 func convertUserPassword(username, plainPwd string) {
 	hashedPwd, err := hashPlainPassword(plainPwd)
 	if err == nil {
-		err = provider.updateUserPassword(username, hashedPwd)
+		err = provider.updateUserPassword(username, hashedPwd, nil)
 	}
 	if err != nil {
 		providerLog(logger.LevelWarn, "unable to convert password for user %s: %v", username, err)
@@ -2939,6 +3682,29 @@ func convertUserPassword(username, plainPwd string) {
 	}
 }
 
+// SimulatePasswordCheck compares the given password with a dummy hash generated
+// using the configured hashing algorithm. It is used to make the authentication
+// failures for unknown users take about the same time of the failures for existing
+// users with a wrong password
+func SimulatePasswordCheck(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		hash, err := hashPlainPassword(util.GenerateUniqueID())
+		if err != nil {
+			providerLog(logger.LevelError, "unable to generate the dummy password hash: %v", err)
+			return
+		}
+		dummyPasswordHash = hash
+	})
+	if dummyPasswordHash == "" {
+		return
+	}
+	if strings.HasPrefix(dummyPasswordHash, bcryptPwdPrefix) {
+		bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password)) //nolint:errcheck
+		return
+	}
+	argon2id.ComparePasswordAndHash(password, dummyPasswordHash) //nolint:errcheck
+}
+
 func checkUserAndTLSCertificate(user *User, protocol string, tlsCert *x509.Certificate) (User, error) {
 	err := user.LoadAndApplyGroupSettings()
 	if err != nil {
@@ -3011,6 +3777,9 @@ func checkUserAndPass(user *User, password, ip, protocol string) (User, error) {
 	if !match {
 		err = ErrInvalidCredentials
 	}
+	if err == nil {
+		err = checkUserPasswordExpiration(user)
+	}
 	return *user, err
 }
 
@@ -3209,7 +3978,7 @@ func doBuiltinKeyboardInteractiveAuth(user *User, client ssh.KeyboardInteractive
 		return 0, err
 	}
 	if !user.Filters.TOTPConfig.Enabled || !util.Contains(user.Filters.TOTPConfig.Protocols, protocolSSH) {
-		return 1, nil
+		return checkKeyboardInteractivePushApproval(user, client, ip, protocol)
 	}
 	err = user.Filters.TOTPConfig.Secret.TryDecrypt()
 	if err != nil {
@@ -3231,6 +4000,20 @@ func doBuiltinKeyboardInteractiveAuth(user *User, client ssh.KeyboardInteractive
 			user.Username, protocol, err)
 		return 0, util.NewValidationError("invalid passcode")
 	}
+	return checkKeyboardInteractivePushApproval(user, client, ip, protocol)
+}
+
+func checkKeyboardInteractivePushApproval(user *User, client ssh.KeyboardInteractiveChallenge, ip, protocol string) (int, error) {
+	if !user.IsPushEnabledForProtocol(protocolSSH) {
+		return 1, nil
+	}
+	// no questions, the instruction is displayed by the client
+	if _, err := client("", "Approve the login request sent to your device", nil, nil); err != nil {
+		return 0, err
+	}
+	if err := RequestUserPushApproval(user, ip, protocol); err != nil {
+		return 0, err
+	}
 	return 1, nil
 }
 
@@ -3403,7 +4186,7 @@ func executeKeyboardInteractiveProgram(user *User, authHook string, client ssh.K
 	if err != nil {
 		return authResult, err
 	}
-	err = cmd.Start()
+	cleanup, err := command.Start(cmd, command.HookKeyboardInteractive)
 	if err != nil {
 		return authResult, err
 	}
@@ -3440,6 +4223,7 @@ func executeKeyboardInteractiveProgram(user *User, authHook string, client ssh.K
 		if err != nil {
 			providerLog(logger.LevelWarn, "error waiting for #%v process to exit: %v", authHook, err)
 		}
+		cleanup()
 	}()
 
 	return authResult, err
@@ -3530,7 +4314,7 @@ func getPasswordHookResponse(username, password, ip, protocol string) ([]byte, e
 		fmt.Sprintf("SFTPGO_AUTHD_IP=%v", ip),
 		fmt.Sprintf("SFTPGO_AUTHD_PROTOCOL=%v", protocol),
 	)
-	return cmd.Output()
+	return command.Output(cmd, command.HookCheckPassword)
 }
 
 func executeCheckPasswordHook(username, password, ip, protocol string) (checkPasswordResponse, error) {
@@ -3591,7 +4375,7 @@ func getPreLoginHookResponse(loginMethod, ip, protocol string, userAsJSON []byte
 		fmt.Sprintf("SFTPGO_LOGIND_IP=%v", ip),
 		fmt.Sprintf("SFTPGO_LOGIND_PROTOCOL=%v", protocol),
 	)
-	return cmd.Output()
+	return command.Output(cmd, command.HookPreLogin)
 }
 
 func executePreLoginHook(username, loginMethod, ip, protocol string, oidcTokenFields *map[string]any) (User, error) {
@@ -3631,6 +4415,7 @@ func executePreLoginHook(username, loginMethod, ip, protocol string, oidcTokenFi
 	userCreatedAt := u.CreatedAt
 	totpConfig := u.Filters.TOTPConfig
 	recoveryCodes := u.Filters.RecoveryCodes
+	webAuthnCredentials := u.Filters.WebAuthnCredentials
 	err = json.Unmarshal(out, &u)
 	if err != nil {
 		return u, fmt.Errorf("invalid pre-login hook response %#v, error: %v", string(out), err)
@@ -3649,15 +4434,18 @@ func executePreLoginHook(username, loginMethod, ip, protocol string, oidcTokenFi
 		err = provider.addUser(&u)
 	} else {
 		u.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
-		// preserve TOTP config and recovery codes
+		// preserve TOTP config, recovery codes and WebAuthn credentials
 		u.Filters.TOTPConfig = totpConfig
 		u.Filters.RecoveryCodes = recoveryCodes
+		u.Filters.WebAuthnCredentials = webAuthnCredentials
 		err = provider.updateUser(&u)
 		if err == nil {
 			webDAVUsersCache.swap(&u)
 			if u.Password != userPwd {
 				cachedPasswords.Remove(username)
 			}
+			authCredentialsCache.remove(username)
+			notifyUsersChanged(operationUpdate, username)
 		}
 	}
 	if err != nil {
@@ -3672,6 +4460,8 @@ func executePreLoginHook(username, loginMethod, ip, protocol string, oidcTokenFi
 
 // ExecutePostLoginHook executes the post login hook if defined
 func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err error) {
+	auditlog.AddLogin(auditlog.EventLogin, user.Username, ip, protocol, loginMethod, err)
+	logger.SyslogLoginEvent(false, user.Username, ip, protocol, loginMethod, err)
 	if config.PostLoginHook == "" {
 		return
 	}
@@ -3736,7 +4526,7 @@ func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err erro
 			fmt.Sprintf("SFTPGO_LOGIND_STATUS=%v", status),
 			fmt.Sprintf("SFTPGO_LOGIND_PROTOCOL=%v", protocol))
 		startTime := time.Now()
-		err = cmd.Run()
+		err = command.Run(cmd, command.HookPostLogin)
 		providerLog(logger.LevelDebug, "post login hook executed for user %#v, ip %v, protocol %v, elapsed %v err: %v",
 			user.Username, ip, protocol, time.Since(startTime), err)
 	}()
@@ -3806,7 +4596,7 @@ func getExternalAuthResponse(username, password, pkey, keyboardInteractive, ip,
 		fmt.Sprintf("SFTPGO_AUTHD_PROTOCOL=%v", protocol),
 		fmt.Sprintf("SFTPGO_AUTHD_TLS_CERT=%v", strings.ReplaceAll(tlsCert, "\n", "\\n")),
 		fmt.Sprintf("SFTPGO_AUTHD_KEYBOARD_INTERACTIVE=%v", keyboardInteractive))
-	return cmd.Output()
+	return command.Output(cmd, command.HookExternalAuth)
 }
 
 func updateUserFromExtAuthResponse(user *User, password, pkey string) {
@@ -3883,13 +4673,15 @@ func doExternalAuth(username, password string, pubKey []byte, keyboardInteractiv
 		user.FirstUpload = u.FirstUpload
 		user.CreatedAt = u.CreatedAt
 		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
-		// preserve TOTP config and recovery codes
+		// preserve TOTP config, recovery codes and WebAuthn credentials
 		user.Filters.TOTPConfig = u.Filters.TOTPConfig
 		user.Filters.RecoveryCodes = u.Filters.RecoveryCodes
+		user.Filters.WebAuthnCredentials = u.Filters.WebAuthnCredentials
 		err = provider.updateUser(&user)
 		if err == nil {
 			webDAVUsersCache.swap(&user)
 			cachedPasswords.Add(user.Username, password)
+			notifyUsersChanged(operationUpdate, user.Username)
 		}
 		return user, err
 	}
@@ -3955,13 +4747,15 @@ func doPluginAuth(username, password string, pubKey []byte, ip, protocol string,
 		user.LastLogin = u.LastLogin
 		user.FirstDownload = u.FirstDownload
 		user.FirstUpload = u.FirstUpload
-		// preserve TOTP config and recovery codes
+		// preserve TOTP config, recovery codes and WebAuthn credentials
 		user.Filters.TOTPConfig = u.Filters.TOTPConfig
 		user.Filters.RecoveryCodes = u.Filters.RecoveryCodes
+		user.Filters.WebAuthnCredentials = u.Filters.WebAuthnCredentials
 		err = provider.updateUser(&user)
 		if err == nil {
 			webDAVUsersCache.swap(&user)
 			cachedPasswords.Add(user.Username, password)
+			notifyUsersChanged(operationUpdate, user.Username)
 		}
 		return user, err
 	}
@@ -4036,3 +4830,29 @@ func checkReservedUsernames(username string) error {
 func providerLog(level logger.LogLevel, format string, v ...any) {
 	logger.Log(level, logSender, "", format, v...)
 }
+
+// startQuerySpan starts a span for a data provider query, it returns nil if
+// tracing is disabled
+func startQuerySpan(operation, username string) *tracing.Span {
+	_, span := tracing.Start(context.Background(), fmt.Sprintf("dataprovider %s", operation), tracing.SpanKindClient)
+	span.SetAttribute("db.system", config.Driver)
+	span.SetAttribute("db.operation", operation)
+	span.SetAttribute("enduser.id", username)
+	return span
+}
+
+// endQuerySpan ends a data provider query span, not found errors are
+// expected and so they are not reported as span errors
+func endQuerySpan(span *tracing.Span, err error) {
+	if _, ok := err.(*util.RecordNotFoundError); !ok {
+		span.SetError(err)
+	}
+	span.End()
+}
+
+func traceUserLookup(username string, lookup func(string) (User, error)) (User, error) {
+	span := startQuerySpan("user_exists", username)
+	user, err := lookup(username)
+	endQuerySpan(span, err)
+	return user, err
+}
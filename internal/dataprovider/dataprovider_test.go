@@ -0,0 +1,461 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func getTestProviderConfig(driver, name string) Config {
+	return Config{
+		Driver: driver,
+		Name:   name,
+		PasswordHashing: PasswordHashing{
+			Algo: HashingAlgoBcrypt,
+			BcryptOptions: BcryptOptions{
+				Cost: 4,
+			},
+		},
+	}
+}
+
+func assertNotFoundError(t *testing.T, err error) {
+	var nfErr *util.RecordNotFoundError
+	assert.ErrorAs(t, err, &nfErr)
+}
+
+// testProviderUsersFoldersGroups executes the CRUD operations for users, groups
+// and folders and checks the relations between them
+func testProviderUsersFoldersGroups(t *testing.T, p Provider) {
+	folder := vfs.BaseVirtualFolder{
+		Name:       "test_provider_folder",
+		MappedPath: filepath.Join(os.TempDir(), "test_provider_folder"),
+	}
+	err := p.addFolder(&folder)
+	require.NoError(t, err)
+	err = p.addFolder(&folder)
+	assert.Error(t, err)
+	group := Group{
+		BaseGroup: sdk.BaseGroup{
+			Name: "test_provider_group",
+		},
+		VirtualFolders: []vfs.VirtualFolder{
+			{
+				BaseVirtualFolder: vfs.BaseVirtualFolder{
+					Name: folder.Name,
+				},
+				VirtualPath: "/vdir_group",
+			},
+		},
+	}
+	err = p.addGroup(&group)
+	require.NoError(t, err)
+	err = p.addGroup(&group)
+	assert.Error(t, err)
+	user := User{
+		BaseUser: sdk.BaseUser{
+			Username: "test_provider_user",
+			Password: "password",
+			HomeDir:  filepath.Join(os.TempDir(), "test_provider_user"),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {PermAny},
+			},
+		},
+		Groups: []sdk.GroupMapping{
+			{
+				Name: group.Name,
+				Type: sdk.GroupTypePrimary,
+			},
+		},
+		VirtualFolders: []vfs.VirtualFolder{
+			{
+				BaseVirtualFolder: vfs.BaseVirtualFolder{
+					Name: folder.Name,
+				},
+				VirtualPath: "/vdir",
+			},
+		},
+	}
+	err = p.addUser(&user)
+	require.NoError(t, err)
+	err = p.addUser(&user)
+	assert.Error(t, err)
+
+	user, err = p.userExists(user.Username)
+	require.NoError(t, err)
+	assert.NotEqual(t, "password", user.Password)
+	assert.Greater(t, user.CreatedAt, int64(0))
+	if assert.Len(t, user.Groups, 1) {
+		assert.Equal(t, group.Name, user.Groups[0].Name)
+		assert.Equal(t, sdk.GroupTypePrimary, user.Groups[0].Type)
+	}
+	if assert.Len(t, user.VirtualFolders, 1) {
+		assert.Equal(t, folder.Name, user.VirtualFolders[0].Name)
+		assert.Equal(t, "/vdir", user.VirtualFolders[0].VirtualPath)
+	}
+	_, err = p.validateUserAndPass(user.Username, "password", "127.0.0.1", protocolSSH)
+	assert.NoError(t, err)
+	_, err = p.validateUserAndPass(user.Username, "wrong password", "127.0.0.1", protocolSSH)
+	assert.Error(t, err)
+	_, err = p.userExists("missing_user")
+	assertNotFoundError(t, err)
+
+	user.Description = "updated description"
+	err = p.updateUser(&user)
+	require.NoError(t, err)
+	user, err = p.userExists(user.Username)
+	require.NoError(t, err)
+	assert.Equal(t, "updated description", user.Description)
+	users, err := p.getUsers(100, 0, OrderASC)
+	assert.NoError(t, err)
+	found := false
+	for _, u := range users {
+		if u.Username == user.Username {
+			found = true
+			assert.Equal(t, user.Description, u.Description)
+		}
+	}
+	assert.True(t, found)
+
+	folder, err = p.getFolderByName(folder.Name)
+	require.NoError(t, err)
+	assert.Equal(t, []string{user.Username}, folder.Users)
+	assert.Equal(t, []string{group.Name}, folder.Groups)
+	group, err = p.groupExists(group.Name)
+	require.NoError(t, err)
+	assert.Equal(t, []string{user.Username}, group.Users)
+	if assert.Len(t, group.VirtualFolders, 1) {
+		assert.Equal(t, "/vdir_group", group.VirtualFolders[0].VirtualPath)
+	}
+	usernames, err := p.getUsersInGroups([]string{group.Name})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{user.Username}, usernames)
+
+	group.Description = "updated group"
+	err = p.updateGroup(&group)
+	require.NoError(t, err)
+	group, err = p.groupExists(group.Name)
+	require.NoError(t, err)
+	assert.Equal(t, "updated group", group.Description)
+	folder.Description = "updated folder"
+	err = p.updateFolder(&folder)
+	require.NoError(t, err)
+	folder, err = p.getFolderByName(folder.Name)
+	require.NoError(t, err)
+	assert.Equal(t, "updated folder", folder.Description)
+
+	err = p.deleteUser(user, false)
+	require.NoError(t, err)
+	_, err = p.userExists(user.Username)
+	assertNotFoundError(t, err)
+	err = p.deleteUser(user, false)
+	assertNotFoundError(t, err)
+	group, err = p.groupExists(group.Name)
+	require.NoError(t, err)
+	assert.Len(t, group.Users, 0)
+	err = p.deleteGroup(group)
+	require.NoError(t, err)
+	_, err = p.groupExists(group.Name)
+	assertNotFoundError(t, err)
+	folder, err = p.getFolderByName(folder.Name)
+	require.NoError(t, err)
+	assert.Len(t, folder.Users, 0)
+	assert.Len(t, folder.Groups, 0)
+	err = p.deleteFolder(folder)
+	require.NoError(t, err)
+	_, err = p.getFolderByName(folder.Name)
+	assertNotFoundError(t, err)
+}
+
+// testProviderAdmins executes the CRUD operations for admins
+func testProviderAdmins(t *testing.T, p Provider) {
+	admin := Admin{
+		Username:    "test_provider_admin",
+		Password:    "password",
+		Status:      1,
+		Permissions: []string{PermAdminAny},
+	}
+	err := p.addAdmin(&admin)
+	require.NoError(t, err)
+	err = p.addAdmin(&admin)
+	assert.Error(t, err)
+	admin, err = p.adminExists(admin.Username)
+	require.NoError(t, err)
+	assert.NotEqual(t, "password", admin.Password)
+	_, err = p.validateAdminAndPass(admin.Username, "password", "127.0.0.1")
+	assert.NoError(t, err)
+	_, err = p.validateAdminAndPass(admin.Username, "wrong password", "127.0.0.1")
+	assert.Error(t, err)
+
+	admin.Description = "updated admin"
+	err = p.updateAdmin(&admin)
+	require.NoError(t, err)
+	admins, err := p.getAdmins(100, 0, OrderASC)
+	assert.NoError(t, err)
+	found := false
+	for _, a := range admins {
+		if a.Username == admin.Username {
+			found = true
+			assert.Equal(t, "updated admin", a.Description)
+		}
+	}
+	assert.True(t, found)
+
+	err = p.deleteAdmin(admin)
+	require.NoError(t, err)
+	_, err = p.adminExists(admin.Username)
+	assertNotFoundError(t, err)
+	err = p.deleteAdmin(admin)
+	assertNotFoundError(t, err)
+}
+
+// testKVStoreTransactions checks the read and read-write transactions for a kvStore
+func testKVStoreTransactions(t *testing.T, db kvStore) {
+	bucketName := "test_bucket"
+	errRollback := errors.New("rollback")
+	err := db.Update(func(tx kvTx) error {
+		bucket := tx.Bucket(bucketName)
+		if err := bucket.Put([]byte("key1"), []byte("value1")); err != nil {
+			return err
+		}
+		// the buffered writes are visible within the transaction
+		assert.Equal(t, []byte("value1"), bucket.Get([]byte("key1")))
+		return errRollback
+	})
+	assert.ErrorIs(t, err, errRollback)
+	err = db.View(func(tx kvTx) error {
+		assert.Nil(t, tx.Bucket(bucketName).Get([]byte("key1")))
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = db.Update(func(tx kvTx) error {
+		bucket := tx.Bucket(bucketName)
+		for _, key := range []string{"key2", "key1", "key3"} {
+			if err := bucket.Put([]byte(key), []byte("value_"+key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	err = db.Update(func(tx kvTx) error {
+		bucket := tx.Bucket(bucketName)
+		if err := bucket.Delete([]byte("key2")); err != nil {
+			return err
+		}
+		assert.Nil(t, bucket.Get([]byte("key2")))
+		seq1, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		seq2, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		assert.Greater(t, seq2, seq1)
+		return nil
+	})
+	assert.NoError(t, err)
+	err = db.View(func(tx kvTx) error {
+		bucket := tx.Bucket(bucketName)
+		assert.Nil(t, bucket.Get([]byte("key2")))
+		var keys []string
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			keys = append(keys, string(k))
+			assert.Equal(t, "value_"+string(k), string(v))
+		}
+		assert.Equal(t, []string{"key1", "key3"}, keys)
+		k, _ := cursor.Last()
+		assert.Equal(t, []byte("key3"), k)
+		k, _ = cursor.Prev()
+		assert.Equal(t, []byte("key1"), k)
+		k, _ = cursor.Prev()
+		assert.Nil(t, k)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+// testProviderSharedSessions checks the shared sessions using the configured provider
+func testProviderSharedSessions(t *testing.T) {
+	err := AddSharedSession(Session{
+		Type: SessionTypeApproval,
+	})
+	assert.Error(t, err)
+	err = AddSharedSession(Session{
+		Key: "key",
+	})
+	assert.Error(t, err)
+
+	now := time.Now()
+	sessions := []Session{
+		{
+			Key:       "approval1",
+			Data:      map[string]string{"id": "approval1"},
+			Type:      SessionTypeApproval,
+			Timestamp: util.GetTimeAsMsSinceEpoch(now.Add(-2 * time.Hour)),
+		},
+		{
+			Key:       "approval2",
+			Data:      map[string]string{"id": "approval2"},
+			Type:      SessionTypeApproval,
+			Timestamp: util.GetTimeAsMsSinceEpoch(now.Add(-1 * time.Minute)),
+		},
+		{
+			Key:       "reset1",
+			Data:      "reset code",
+			Type:      SessionTypeResetCode,
+			Timestamp: util.GetTimeAsMsSinceEpoch(now),
+		},
+	}
+	for _, session := range sessions {
+		err = AddSharedSession(session)
+		require.NoError(t, err)
+	}
+	session, err := GetSharedSession("approval1")
+	require.NoError(t, err)
+	assert.Equal(t, SessionTypeApproval, session.Type)
+	assert.Equal(t, sessions[0].Timestamp, session.Timestamp)
+	data, err := json.Marshal(sessions[0].Data)
+	require.NoError(t, err)
+	assert.Equal(t, data, session.Data)
+	_, err = GetSharedSession("missing")
+	assertNotFoundError(t, err)
+	// adding an existing key replaces the session
+	sessions[0].Data = map[string]string{"id": "approval1", "status": "updated"}
+	err = AddSharedSession(sessions[0])
+	require.NoError(t, err)
+	session, err = GetSharedSession("approval1")
+	require.NoError(t, err)
+	data, err = json.Marshal(sessions[0].Data)
+	require.NoError(t, err)
+	assert.Equal(t, data, session.Data)
+
+	result, err := GetSharedSessions(SessionTypeApproval, time.Time{})
+	require.NoError(t, err)
+	if assert.Len(t, result, 2) {
+		// the sessions are sorted by timestamp
+		assert.Equal(t, "approval1", result[0].Key)
+		assert.Equal(t, "approval2", result[1].Key)
+	}
+	result, err = GetSharedSessions(SessionTypeApproval, now.Add(-1*time.Hour))
+	require.NoError(t, err)
+	if assert.Len(t, result, 1) {
+		assert.Equal(t, "approval2", result[0].Key)
+	}
+	result, err = GetSharedSessions(SessionTypeResetCode, time.Time{})
+	require.NoError(t, err)
+	if assert.Len(t, result, 1) {
+		assert.Equal(t, "reset1", result[0].Key)
+	}
+	result, err = GetSharedSessions(SessionTypeWebAuthn, time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, result, 0)
+	// the cleanup only affects the specified type
+	err = CleanupSharedSessions(SessionTypeApproval, now.Add(-1*time.Hour))
+	require.NoError(t, err)
+	_, err = GetSharedSession("approval1")
+	assertNotFoundError(t, err)
+	_, err = GetSharedSession("approval2")
+	assert.NoError(t, err)
+	err = CleanupSharedSessions(SessionTypeResetCode, now.Add(-1*time.Hour))
+	require.NoError(t, err)
+	_, err = GetSharedSession("reset1")
+	assert.NoError(t, err)
+
+	err = DeleteSharedSession("approval2")
+	assert.NoError(t, err)
+	err = DeleteSharedSession("approval2")
+	assertNotFoundError(t, err)
+	_, err = GetSharedSession("approval2")
+	assertNotFoundError(t, err)
+}
+
+// testProviderAuditAndDeadLetters checks the audit trail and the dead letters
+// for a provider with no other entries
+func testProviderAuditAndDeadLetters(t *testing.T, p Provider) {
+	for i := 1; i <= 3; i++ {
+		err := p.addAuditEntry(&AuditEntry{
+			Timestamp:  int64(i * 1000),
+			Action:     operationAdd,
+			ObjectType: actionObjectUser,
+			ObjectName: "user",
+			Executor:   "admin",
+		})
+		require.NoError(t, err)
+		err = p.addDeadLetter(&DeadLetter{
+			Timestamp: int64(i * 1000),
+			Source:    DeadLetterSourceFsHook,
+			Name:      "upload",
+		})
+		require.NoError(t, err)
+	}
+	entries, err := p.getAuditEntries(AuditFilters{}, 10, 0, OrderDESC)
+	require.NoError(t, err)
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, int64(3), entries[0].ID)
+		assert.Equal(t, int64(1), entries[2].ID)
+	}
+	entries, err = p.getAuditEntries(AuditFilters{}, 1, 1, OrderASC)
+	require.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, int64(2), entries[0].ID)
+	}
+	err = p.cleanupAuditEntries(3000)
+	require.NoError(t, err)
+	entries, err = p.getAuditEntries(AuditFilters{}, 10, 0, OrderASC)
+	require.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, int64(3), entries[0].ID)
+	}
+
+	letters, err := p.getDeadLetters(DeadLetterFilters{Source: DeadLetterSourceFsHook}, 10, 0, OrderASC)
+	require.NoError(t, err)
+	assert.Len(t, letters, 3)
+	letters, err = p.getDeadLetters(DeadLetterFilters{Source: DeadLetterSourceEventAction}, 10, 0, OrderASC)
+	require.NoError(t, err)
+	assert.Len(t, letters, 0)
+	letter, err := p.getDeadLetter(2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2000), letter.Timestamp)
+	err = p.deleteDeadLetter(2)
+	require.NoError(t, err)
+	_, err = p.getDeadLetter(2)
+	assertNotFoundError(t, err)
+	err = p.deleteDeadLetter(2)
+	assertNotFoundError(t, err)
+	err = p.cleanupDeadLetters(2000)
+	require.NoError(t, err)
+	letters, err = p.getDeadLetters(DeadLetterFilters{}, 10, 0, OrderASC)
+	require.NoError(t, err)
+	if assert.Len(t, letters, 1) {
+		assert.Equal(t, int64(3), letters[0].ID)
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported dead letter sources
+const (
+	// HTTP event actions
+	DeadLetterSourceEventAction = "event_action"
+	// HTTP hook for filesystem actions
+	DeadLetterSourceFsHook = "fs_hook"
+	// HTTP hook for data provider actions
+	DeadLetterSourceProviderHook = "provider_hook"
+)
+
+// MaxDeadLetterBodySize defines the maximum size for the request bodies stored
+// in the dead letters, deliveries with bigger bodies are not stored
+const MaxDeadLetterBodySize = 1048576
+
+var (
+	// SupportedDeadLetterSources defines the supported dead letter sources
+	SupportedDeadLetterSources = []string{DeadLetterSourceEventAction, DeadLetterSourceFsHook,
+		DeadLetterSourceProviderHook}
+)
+
+// DeadLettersConfig defines the configuration for the dead letters store
+type DeadLettersConfig struct {
+	// Set to true to store the HTTP notifications, sent by event actions and
+	// hooks, that failed after all the retries
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Number of days the dead letters are retained, older entries are
+	// automatically removed. 0 means the entries are never removed
+	RetentionDays int `json:"retention_days" mapstructure:"retention_days"`
+}
+
+func (c *DeadLettersConfig) validate() error {
+	if c.RetentionDays < 0 {
+		return fmt.Errorf("invalid dead letters retention days: %d", c.RetentionDays)
+	}
+	return nil
+}
+
+// DeadLetter defines an HTTP notification that failed after all the retries.
+// The request can be replayed later
+type DeadLetter struct {
+	ID int64 `json:"id"`
+	// Unix timestamp in milliseconds
+	Timestamp int64 `json:"timestamp"`
+	// Source of the notification, see SupportedDeadLetterSources
+	Source string `json:"source"`
+	// Event action name for event actions and action name for hooks,
+	// for example "upload" or "add"
+	Name   string `json:"name"`
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	// Username for HTTP basic authentication, the password is never stored.
+	// Event actions reuse the password of the action when a dead letter is
+	// replayed
+	Username string `json:"username,omitempty"`
+	// Request headers. The authorization and signature headers are not stored,
+	// requests are signed again when replayed
+	Headers []KeyValue `json:"headers,omitempty"`
+	Body    string     `json:"body,omitempty"`
+	// Error for the last attempt
+	Error string `json:"error"`
+	// Number of delivery attempts
+	Attempts int `json:"attempts"`
+}
+
+func (d *DeadLetter) getHeadersAsJSON() (string, error) {
+	if len(d.Headers) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(d.Headers)
+	return string(data), err
+}
+
+func (d *DeadLetter) setHeadersFromJSON(data string) error {
+	if data == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(data), &d.Headers)
+}
+
+// DeadLetterFilters defines the supported filters for the dead letters
+// queries. Empty values are ignored
+type DeadLetterFilters struct {
+	Source string
+	Name   string
+	// Unix timestamp in milliseconds, entries recorded before this time are excluded
+	StartTimestamp int64
+	// Unix timestamp in milliseconds, entries recorded after this time are excluded
+	EndTimestamp int64
+}
+
+// Validate returns an error if the filters are not valid
+func (f *DeadLetterFilters) Validate() error {
+	if f.Source != "" && !util.Contains(SupportedDeadLetterSources, f.Source) {
+		return util.NewValidationError(fmt.Sprintf("invalid source %q", f.Source))
+	}
+	if f.EndTimestamp > 0 && f.StartTimestamp > f.EndTimestamp {
+		return util.NewValidationError("the start timestamp cannot be after the end timestamp")
+	}
+	return nil
+}
+
+func (f *DeadLetterFilters) matches(d *DeadLetter) bool {
+	if f.Source != "" && f.Source != d.Source {
+		return false
+	}
+	if f.Name != "" && f.Name != d.Name {
+		return false
+	}
+	if f.StartTimestamp > 0 && d.Timestamp < f.StartTimestamp {
+		return false
+	}
+	if f.EndTimestamp > 0 && d.Timestamp > f.EndTimestamp {
+		return false
+	}
+	return true
+}
+
+// IsDeadLettersEnabled returns true if the dead letters store is enabled
+func IsDeadLettersEnabled() bool {
+	return config.DeadLetters.Enabled
+}
+
+// AddDeadLetter stores a failed HTTP notification, if the dead letters store
+// is enabled
+func AddDeadLetter(entry *DeadLetter) error {
+	if !config.DeadLetters.Enabled {
+		return nil
+	}
+	if len(entry.Body) > MaxDeadLetterBodySize {
+		return util.NewValidationError(fmt.Sprintf("the request body is too large: %d bytes", len(entry.Body)))
+	}
+	if entry.Timestamp == 0 {
+		entry.Timestamp = util.GetTimeAsMsSinceEpoch(time.Now())
+	}
+	err := provider.addDeadLetter(entry)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to add dead letter, source %q, name %q: %v", entry.Source, entry.Name, err)
+	}
+	return err
+}
+
+// AddHookDeadLetter stores a failed hook notification, if the dead letters
+// store is enabled. Hooks are sent as POST requests with a JSON body using the
+// retryable HTTP client, statusCode is 0 if no response was received
+func AddHookDeadLetter(source, name, hookURL string, payload []byte, statusCode int, err error) {
+	if !config.DeadLetters.Enabled {
+		return
+	}
+	lastError := err.Error()
+	attempts := 1
+	if statusCode > 0 {
+		lastError = fmt.Sprintf("unexpected status code: %d", statusCode)
+	}
+	if statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError {
+		attempts += httpclient.GetRetryMax()
+	}
+	AddDeadLetter(&DeadLetter{ //nolint:errcheck
+		Source:   source,
+		Name:     name,
+		Method:   http.MethodPost,
+		URL:      hookURL,
+		Headers:  []KeyValue{{Key: "Content-Type", Value: "application/json"}},
+		Body:     string(payload),
+		Error:    lastError,
+		Attempts: attempts,
+	})
+}
+
+// GetDeadLetters returns the dead letters matching the specified filters
+// respecting limit and offset
+func GetDeadLetters(filters DeadLetterFilters, limit, offset int, order string) ([]DeadLetter, error) {
+	if err := filters.Validate(); err != nil {
+		return nil, err
+	}
+	return provider.getDeadLetters(filters, limit, offset, order)
+}
+
+// GetDeadLetter returns the dead letter with the specified ID
+func GetDeadLetter(id int64) (DeadLetter, error) {
+	return provider.getDeadLetter(id)
+}
+
+// DeleteDeadLetter removes the dead letter with the specified ID
+func DeleteDeadLetter(id int64) error {
+	return provider.deleteDeadLetter(id)
+}
+
+func removeExpiredDeadLetters() error {
+	before := util.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Duration(config.DeadLetters.RetentionDays) * 24 * time.Hour))
+	err := provider.cleanupDeadLetters(before)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to remove expired dead letters: %v", err)
+	} else {
+		providerLog(logger.LevelDebug, "expired dead letters removed, before: %s", util.GetTimeFromMsecSinceEpoch(before))
+	}
+	return err
+}
+
+// getDeadLettersFromList returns the entries, ordered by ID, matching the
+// specified filters respecting limit and offset. It is used by the providers
+// that cannot filter the entries within the queries
+func getDeadLettersFromList(entries []DeadLetter, filters DeadLetterFilters, limit, offset int, order string) []DeadLetter {
+	result := make([]DeadLetter, 0, limit)
+	iterate := func(idx int) bool {
+		if !filters.matches(&entries[idx]) {
+			return true
+		}
+		if offset > 0 {
+			offset--
+			return true
+		}
+		result = append(result, entries[idx])
+		return len(result) < limit
+	}
+	if order == OrderASC {
+		for idx := 0; idx < len(entries); idx++ {
+			if !iterate(idx) {
+				break
+			}
+		}
+	} else {
+		for idx := len(entries) - 1; idx >= 0; idx-- {
+			if !iterate(idx) {
+				break
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,1158 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !noetcd
+// +build !noetcd
+
+package dataprovider
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"go.uber.org/zap"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	etcdDatabaseVersion = 25
+	etcdDefaultPrefix   = "sftpgo"
+	etcdDefaultPort     = 2379
+	etcdOpTimeout       = 15 * time.Second
+	etcdSessionTTL      = 10
+	// etcd refuses transactions with more than 128 operations using its default settings
+	etcdMaxTxnOps = 128
+)
+
+const (
+	etcdSequencesBucket = "sequences"
+	etcdLockKey         = "lock"
+)
+
+var (
+	etcdDBVersionKey = []byte("version")
+)
+
+// EtcdProvider defines the auth provider for etcd key/value store
+type EtcdProvider struct {
+	kvProvider
+	db     *etcdDB
+	cancel context.CancelFunc
+}
+
+func init() {
+	version.AddFeature("+etcd")
+}
+
+func initializeEtcdProvider() error {
+	tlsConfig, err := getEtcdTLSConfig()
+	if err != nil {
+		return err
+	}
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   getEtcdEndpoints(),
+		Username:    config.Username,
+		Password:    config.Password,
+		DialTimeout: 10 * time.Second,
+		TLS:         tlsConfig,
+		Logger:      zap.NewNop(),
+	})
+	if err != nil {
+		providerLog(logger.LevelError, "error creating etcd key/value store handler: %v", err)
+		return err
+	}
+	prefix := strings.Trim(config.Name, "/")
+	if prefix == "" {
+		prefix = etcdDefaultPrefix
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	db := &etcdDB{
+		client: client,
+		prefix: prefix + "/",
+	}
+	p := &EtcdProvider{
+		kvProvider: kvProvider{dbHandle: db},
+		db:         db,
+		cancel:     cancel,
+	}
+	if err := p.db.ping(); err != nil {
+		cancel()
+		client.Close()
+		providerLog(logger.LevelError, "unable to connect to etcd: %v", err)
+		return err
+	}
+	providerLog(logger.LevelDebug, "etcd key store handle created, keys prefix %q", prefix)
+	provider = p
+	go p.watchChanges(ctx)
+	return nil
+}
+
+func getEtcdEndpoints() []string {
+	hosts := config.Host
+	if config.ConnectionString != "" {
+		hosts = config.ConnectionString
+	}
+	var endpoints []string
+	for _, host := range strings.Split(hosts, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		if config.ConnectionString == "" && !strings.Contains(host, "://") && !strings.Contains(host, ":") {
+			port := config.Port
+			if port <= 0 {
+				port = etcdDefaultPort
+			}
+			host = fmt.Sprintf("%s:%d", host, port)
+		}
+		endpoints = append(endpoints, host)
+	}
+	return endpoints
+}
+
+func getEtcdTLSConfig() (*tls.Config, error) {
+	if config.SSLMode == 0 {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if config.RootCert != "" {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		rootCrt, err := os.ReadFile(config.RootCert)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load root certificate %q: %v", config.RootCert, err)
+		}
+		if !rootCAs.AppendCertsFromPEM(rootCrt) {
+			return nil, fmt.Errorf("unable to parse root certificate %q", config.RootCert)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	if config.ClientCert != "" && config.ClientKey != "" {
+		tlsCert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load key pair %q, %q: %v", config.ClientCert, config.ClientKey, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{tlsCert}
+	}
+	if config.SSLMode == 2 {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig, nil
+}
+
+// etcdDB implements kvStore on top of etcd. Keys are stored as "<prefix>/<bucket>/<key>".
+// Read-only transactions read from a consistent snapshot. Read-write transactions
+// are serialized across all the SFTPGo instances using a distributed lock, the
+// writes are buffered and applied when the transaction function returns without
+// errors
+type etcdDB struct {
+	client *clientv3.Client
+	prefix string
+	// serializes read-write transactions within this instance
+	mu      sync.Mutex
+	session *concurrency.Session
+}
+
+func (db *etcdDB) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdOpTimeout)
+	defer cancel()
+
+	_, err := db.client.Get(ctx, db.bucketPrefix(kvDBVersionBucket)+string(etcdDBVersionKey))
+	return err
+}
+
+func (db *etcdDB) bucketPrefix(name string) string {
+	return db.prefix + name + "/"
+}
+
+func (db *etcdDB) getSession() (*concurrency.Session, error) {
+	if db.session != nil {
+		select {
+		case <-db.session.Done():
+			providerLog(logger.LevelWarn, "etcd session expired, creating a new one")
+			db.session = nil
+		default:
+			return db.session, nil
+		}
+	}
+	session, err := concurrency.NewSession(db.client, concurrency.WithTTL(etcdSessionTTL))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create etcd session: %w", err)
+	}
+	db.session = session
+	return session, nil
+}
+
+// View executes a read-only transaction
+func (db *etcdDB) View(fn func(kvTx) error) error {
+	tx := &etcdTx{
+		db: db,
+	}
+	err := fn(tx)
+	if tx.err != nil {
+		return tx.err
+	}
+	return err
+}
+
+// Update executes a read-write transaction
+func (db *etcdDB) Update(fn func(kvTx) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	session, err := db.getSession()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdOpTimeout)
+	defer cancel()
+
+	mutex := concurrency.NewMutex(session, db.prefix+etcdLockKey)
+	if err := mutex.Lock(ctx); err != nil {
+		return fmt.Errorf("unable to acquire the etcd lock: %w", err)
+	}
+	defer func() {
+		unlockCtx, unlockCancel := context.WithTimeout(context.Background(), etcdOpTimeout)
+		defer unlockCancel()
+
+		if err := mutex.Unlock(unlockCtx); err != nil {
+			providerLog(logger.LevelError, "unable to release the etcd lock: %v", err)
+		}
+	}()
+
+	tx := &etcdTx{
+		db:       db,
+		writable: true,
+		pending:  make(map[string][]byte),
+	}
+	err = fn(tx)
+	if tx.err != nil {
+		return tx.err
+	}
+	if err != nil {
+		return err
+	}
+	return tx.commit(ctx, mutex)
+}
+
+// Close closes the etcd client
+func (db *etcdDB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.session != nil {
+		db.session.Close()
+		db.session = nil
+	}
+	return db.client.Close()
+}
+
+type etcdTx struct {
+	db       *etcdDB
+	writable bool
+	// revision for the snapshot used by read-only transactions
+	revision int64
+	// buffered writes, a nil value means the key was deleted
+	pending    map[string][]byte
+	pendingKey []string
+	// first read error, bbolt reads cannot fail so the error is
+	// returned when the transaction completes
+	err error
+}
+
+func (tx *etcdTx) getOptions(opts ...clientv3.OpOption) []clientv3.OpOption {
+	if tx.revision > 0 {
+		opts = append(opts, clientv3.WithRev(tx.revision))
+	}
+	return opts
+}
+
+func (tx *etcdTx) setRevision(resp *clientv3.GetResponse) {
+	if !tx.writable && tx.revision == 0 && resp.Header != nil {
+		tx.revision = resp.Header.Revision
+	}
+}
+
+func (tx *etcdTx) setError(err error) {
+	if tx.err == nil {
+		tx.err = err
+	}
+}
+
+func (tx *etcdTx) get(bucket, key string) ([]byte, error) {
+	fullKey := tx.db.bucketPrefix(bucket) + key
+	if tx.writable {
+		if v, ok := tx.pending[fullKey]; ok {
+			return v, nil
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdOpTimeout)
+	defer cancel()
+
+	resp, err := tx.db.client.Get(ctx, fullKey, tx.getOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	tx.setRevision(resp)
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+func (tx *etcdTx) list(bucket string) ([]etcdKV, error) {
+	prefix := tx.db.bucketPrefix(bucket)
+	ctx, cancel := context.WithTimeout(context.Background(), etcdOpTimeout)
+	defer cancel()
+
+	resp, err := tx.db.client.Get(ctx, prefix, tx.getOptions(clientv3.WithPrefix())...)
+	if err != nil {
+		return nil, err
+	}
+	tx.setRevision(resp)
+	result := make([]etcdKV, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		result = append(result, etcdKV{
+			key:   kv.Key[len(prefix):],
+			value: kv.Value,
+		})
+	}
+	if !tx.writable {
+		return result, nil
+	}
+	var needSort bool
+	for k, v := range tx.pending {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		key := []byte(k[len(prefix):])
+		idx := sort.Search(len(result), func(i int) bool {
+			return string(result[i].key) >= string(key)
+		})
+		found := idx < len(result) && string(result[idx].key) == string(key)
+		switch {
+		case found && v == nil:
+			result = append(result[:idx], result[idx+1:]...)
+		case found:
+			result[idx].value = v
+		case v != nil:
+			result = append(result, etcdKV{key: key, value: v})
+			needSort = true
+		}
+	}
+	if needSort {
+		sort.Slice(result, func(i, j int) bool {
+			return string(result[i].key) < string(result[j].key)
+		})
+	}
+	return result, nil
+}
+
+func (tx *etcdTx) put(bucket string, key []byte, value []byte) error {
+	if !tx.writable {
+		return errors.New("unable to write within a read-only transaction")
+	}
+	if len(key) == 0 {
+		return errors.New("unable to write an empty key")
+	}
+	fullKey := tx.db.bucketPrefix(bucket) + string(key)
+	if _, ok := tx.pending[fullKey]; !ok {
+		tx.pendingKey = append(tx.pendingKey, fullKey)
+	}
+	tx.pending[fullKey] = value
+	return nil
+}
+
+func (tx *etcdTx) delete(bucket string, key []byte) error {
+	if !tx.writable {
+		return errors.New("unable to delete within a read-only transaction")
+	}
+	fullKey := tx.db.bucketPrefix(bucket) + string(key)
+	if _, ok := tx.pending[fullKey]; !ok {
+		tx.pendingKey = append(tx.pendingKey, fullKey)
+	}
+	tx.pending[fullKey] = nil
+	return nil
+}
+
+// Bucket returns the bucket with the specified name, buckets are just key
+// prefixes so they always exist
+func (tx *etcdTx) Bucket(name string) kvBucket {
+	return &etcdBucket{
+		tx:   tx,
+		name: name,
+	}
+}
+
+func (tx *etcdTx) commit(ctx context.Context, mutex *concurrency.Mutex) error {
+	ops := make([]clientv3.Op, 0, len(tx.pendingKey))
+	for _, k := range tx.pendingKey {
+		v := tx.pending[k]
+		if v == nil {
+			ops = append(ops, clientv3.OpDelete(k))
+		} else {
+			ops = append(ops, clientv3.OpPut(k, string(v)))
+		}
+	}
+	// transactions exceeding the etcd limits are splitted, this is safe since
+	// we own the lock
+	for len(ops) > 0 {
+		numOps := etcdMaxTxnOps
+		if numOps > len(ops) {
+			numOps = len(ops)
+		}
+		resp, err := tx.db.client.Txn(ctx).If(mutex.IsOwner()).Then(ops[:numOps]...).Commit()
+		if err != nil {
+			return err
+		}
+		if !resp.Succeeded {
+			return errors.New("unable to commit the transaction, the etcd lock is no longer owned")
+		}
+		ops = ops[numOps:]
+	}
+	return nil
+}
+
+type etcdKV struct {
+	key   []byte
+	value []byte
+}
+
+type etcdBucket struct {
+	tx   *etcdTx
+	name string
+}
+
+// Get returns the value for the specified key or nil if the key does not exist
+func (b *etcdBucket) Get(key []byte) []byte {
+	v, err := b.tx.get(b.name, string(key))
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get key %q from bucket %q: %v", key, b.name, err)
+		b.tx.setError(err)
+		return nil
+	}
+	return v
+}
+
+// Put sets the value for the specified key
+func (b *etcdBucket) Put(key []byte, value []byte) error {
+	return b.tx.put(b.name, key, value)
+}
+
+// Delete removes the specified key, it is not an error if the key does not exist
+func (b *etcdBucket) Delete(key []byte) error {
+	return b.tx.delete(b.name, key)
+}
+
+// NextSequence returns an autoincrementing integer for the bucket
+func (b *etcdBucket) NextSequence() (uint64, error) {
+	var seq uint64
+	v, err := b.tx.get(etcdSequencesBucket, b.name)
+	if err != nil {
+		return 0, err
+	}
+	if v != nil {
+		seq, err = strconv.ParseUint(string(v), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid sequence for bucket %q: %w", b.name, err)
+		}
+	}
+	seq++
+	err = b.tx.put(etcdSequencesBucket, []byte(b.name), []byte(strconv.FormatUint(seq, 10)))
+	return seq, err
+}
+
+// Cursor returns a cursor to iterate over the bucket keys in sorted order
+func (b *etcdBucket) Cursor() kvCursor {
+	kvs, err := b.tx.list(b.name)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to list keys for bucket %q: %v", b.name, err)
+		b.tx.setError(err)
+	}
+	return &etcdCursor{
+		kvs: kvs,
+		idx: -1,
+	}
+}
+
+type etcdCursor struct {
+	kvs []etcdKV
+	idx int
+}
+
+func (c *etcdCursor) current() ([]byte, []byte) {
+	if c.idx < 0 || c.idx >= len(c.kvs) {
+		return nil, nil
+	}
+	return c.kvs[c.idx].key, c.kvs[c.idx].value
+}
+
+// First moves the cursor to the first item
+func (c *etcdCursor) First() ([]byte, []byte) {
+	c.idx = 0
+	return c.current()
+}
+
+// Last moves the cursor to the last item
+func (c *etcdCursor) Last() ([]byte, []byte) {
+	c.idx = len(c.kvs) - 1
+	return c.current()
+}
+
+// Next moves the cursor to the next item
+func (c *etcdCursor) Next() ([]byte, []byte) {
+	if c.idx < len(c.kvs) {
+		c.idx++
+	}
+	return c.current()
+}
+
+// Prev moves the cursor to the previous item
+func (c *etcdCursor) Prev() ([]byte, []byte) {
+	if c.idx >= 0 {
+		c.idx--
+	}
+	return c.current()
+}
+
+func (p *EtcdProvider) checkAvailability() error {
+	return p.db.ping()
+}
+
+func (p *EtcdProvider) getDefenderHosts(from int64, limit int) ([]DefenderEntry, error) {
+	return nil, ErrNotImplemented
+}
+
+func (p *EtcdProvider) getDefenderHostByIP(ip string, from int64) (DefenderEntry, error) {
+	return DefenderEntry{}, ErrNotImplemented
+}
+
+func (p *EtcdProvider) isDefenderHostBanned(ip string) (DefenderEntry, error) {
+	return DefenderEntry{}, ErrNotImplemented
+}
+
+func (p *EtcdProvider) updateDefenderBanTime(ip string, minutes int) error {
+	return ErrNotImplemented
+}
+
+func (p *EtcdProvider) deleteDefenderHost(ip string) error {
+	return ErrNotImplemented
+}
+
+func (p *EtcdProvider) addDefenderEvent(ip string, score int) error {
+	return ErrNotImplemented
+}
+
+func (p *EtcdProvider) setDefenderBanTime(ip string, banTime int64) error {
+	return ErrNotImplemented
+}
+
+func (p *EtcdProvider) cleanupDefender(from int64) error {
+	return ErrNotImplemented
+}
+
+func (p *EtcdProvider) addActiveTransfer(transfer ActiveTransfer) error {
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	transfer.CreatedAt = now
+	transfer.UpdatedAt = now
+	return p.putJSON(p.getActiveTransferKey(transfer.ID, transfer.ConnID), transfer)
+}
+
+func (p *EtcdProvider) updateActiveTransferSizes(ulSize, dlSize, transferID int64, connectionID string) error {
+	key := p.getActiveTransferKey(transferID, connectionID)
+	var transfer ActiveTransfer
+	if _, err := p.getJSON(key, &transfer); err != nil {
+		return err
+	}
+	transfer.CurrentULSize = ulSize
+	transfer.CurrentDLSize = dlSize
+	transfer.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	return p.putJSON(key, transfer)
+}
+
+func (p *EtcdProvider) removeActiveTransfer(transferID int64, connectionID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdOpTimeout)
+	defer cancel()
+
+	_, err := p.db.client.Delete(ctx, p.getActiveTransferKey(transferID, connectionID))
+	return err
+}
+
+func (p *EtcdProvider) cleanupActiveTransfers(before time.Time) error {
+	limit := util.GetTimeAsMsSinceEpoch(before)
+	return p.cleanupBucket(kvActiveTransfersBucket, func(v []byte) (bool, error) {
+		var transfer ActiveTransfer
+		if err := json.Unmarshal(v, &transfer); err != nil {
+			return false, err
+		}
+		return transfer.UpdatedAt < limit, nil
+	})
+}
+
+func (p *EtcdProvider) getActiveTransfers(from time.Time) ([]ActiveTransfer, error) {
+	transfers := make([]ActiveTransfer, 0, 30)
+	limit := util.GetTimeAsMsSinceEpoch(from)
+	err := p.db.View(func(tx kvTx) error {
+		cursor := tx.Bucket(kvActiveTransfersBucket).Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var transfer ActiveTransfer
+			if err := json.Unmarshal(v, &transfer); err != nil {
+				return err
+			}
+			if transfer.UpdatedAt > limit {
+				transfers = append(transfers, transfer)
+			}
+		}
+		return nil
+	})
+	return transfers, err
+}
+
+func (p *EtcdProvider) addSharedSession(session Session) error {
+	if err := session.validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(session.Data)
+	if err != nil {
+		return err
+	}
+	return p.putJSON(p.db.bucketPrefix(kvSharedSessionsBucket)+session.Key, etcdSession{
+		Key:       session.Key,
+		Data:      data,
+		Type:      session.Type,
+		Timestamp: session.Timestamp,
+	})
+}
+
+func (p *EtcdProvider) deleteSharedSession(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdOpTimeout)
+	defer cancel()
+
+	resp, err := p.db.client.Delete(ctx, p.db.bucketPrefix(kvSharedSessionsBucket)+key)
+	if err != nil {
+		return err
+	}
+	if resp.Deleted == 0 {
+		return util.NewRecordNotFoundError(fmt.Sprintf("session %q does not exist", key))
+	}
+	return nil
+}
+
+func (p *EtcdProvider) getSharedSession(key string) (Session, error) {
+	var session etcdSession
+	if _, err := p.getJSON(p.db.bucketPrefix(kvSharedSessionsBucket)+key, &session); err != nil {
+		return Session{}, err
+	}
+	return Session{
+		Key:       session.Key,
+		Data:      session.Data,
+		Type:      session.Type,
+		Timestamp: session.Timestamp,
+	}, nil
+}
+
+func (p *EtcdProvider) getSharedSessions(sessionType SessionType, after int64) ([]Session, error) {
+	var sessions []Session
+	err := p.db.View(func(tx kvTx) error {
+		cursor := tx.Bucket(kvSharedSessionsBucket).Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var session etcdSession
+			if err := json.Unmarshal(v, &session); err != nil {
+				return err
+			}
+			if session.Type == sessionType && session.Timestamp >= after {
+				sessions = append(sessions, Session{
+					Key:       session.Key,
+					Data:      session.Data,
+					Type:      session.Type,
+					Timestamp: session.Timestamp,
+				})
+			}
+		}
+		return nil
+	})
+	return sessions, err
+}
+
+func (p *EtcdProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
+	return p.cleanupBucket(kvSharedSessionsBucket, func(v []byte) (bool, error) {
+		var session etcdSession
+		if err := json.Unmarshal(v, &session); err != nil {
+			return false, err
+		}
+		return session.Type == sessionType && session.Timestamp < before, nil
+	})
+}
+
+func (p *EtcdProvider) getTaskByName(name string) (Task, error) {
+	var task Task
+	_, err := p.getJSON(p.db.bucketPrefix(kvTasksBucket)+name, &task)
+	return task, err
+}
+
+func (p *EtcdProvider) addTask(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdOpTimeout)
+	defer cancel()
+
+	key := p.db.bucketPrefix(kvTasksBucket) + name
+	buf, err := json.Marshal(Task{
+		Name:     name,
+		UpdateAt: util.GetTimeAsMsSinceEpoch(time.Now()),
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := p.db.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(buf))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return fmt.Errorf("task %q already exists", name)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) updateTask(name string, version int64) error {
+	return p.updateTaskIf(name, func(task *Task) bool {
+		if task.Version != version {
+			return false
+		}
+		task.Version++
+		return true
+	})
+}
+
+func (p *EtcdProvider) updateTaskTimestamp(name string) error {
+	return p.updateTaskIf(name, func(task *Task) bool {
+		return true
+	})
+}
+
+func (p *EtcdProvider) addNode() error {
+	if err := currentNode.validate(); err != nil {
+		return fmt.Errorf("unable to register cluster node: %w", err)
+	}
+	key := p.db.bucketPrefix(kvNodesBucket) + currentNode.Name
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	node := Node{
+		Name:      currentNode.Name,
+		Data:      currentNode.Data,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	var existing Node
+	if _, err := p.getJSON(key, &existing); err == nil {
+		node.CreatedAt = existing.CreatedAt
+	} else if _, ok := err.(*util.RecordNotFoundError); !ok {
+		return fmt.Errorf("unable to register cluster node: %w", err)
+	}
+	if err := p.putJSON(key, node); err != nil {
+		return fmt.Errorf("unable to register cluster node: %w", err)
+	}
+	providerLog(logger.LevelInfo, "registered as cluster node %q, port: %d, proto: %s",
+		currentNode.Name, currentNode.Data.Port, currentNode.Data.Proto)
+
+	return nil
+}
+
+func (p *EtcdProvider) getNodeByName(name string) (Node, error) {
+	var node Node
+	if _, err := p.getJSON(p.db.bucketPrefix(kvNodesBucket)+name, &node); err != nil {
+		return node, err
+	}
+	if node.UpdatedAt <= util.GetTimeAsMsSinceEpoch(time.Now().Add(activeNodeTimeDiff)) {
+		return Node{}, util.NewRecordNotFoundError(fmt.Sprintf("node %q is not active", name))
+	}
+	return node, nil
+}
+
+func (p *EtcdProvider) getNodes() ([]Node, error) {
+	var nodes []Node
+	limit := util.GetTimeAsMsSinceEpoch(time.Now().Add(activeNodeTimeDiff))
+	err := p.db.View(func(tx kvTx) error {
+		cursor := tx.Bucket(kvNodesBucket).Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var node Node
+			if err := json.Unmarshal(v, &node); err != nil {
+				return err
+			}
+			if node.Name != currentNode.Name && node.UpdatedAt > limit {
+				nodes = append(nodes, node)
+			}
+		}
+		return nil
+	})
+	return nodes, err
+}
+
+func (p *EtcdProvider) updateNodeTimestamp() error {
+	key := p.db.bucketPrefix(kvNodesBucket) + currentNode.Name
+	var node Node
+	if _, err := p.getJSON(key, &node); err != nil {
+		return err
+	}
+	node.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	return p.putJSON(key, node)
+}
+
+func (p *EtcdProvider) cleanupNodes() error {
+	limit := util.GetTimeAsMsSinceEpoch(time.Now().Add(10 * activeNodeTimeDiff))
+	return p.cleanupBucket(kvNodesBucket, func(v []byte) (bool, error) {
+		var node Node
+		if err := json.Unmarshal(v, &node); err != nil {
+			return false, err
+		}
+		return node.UpdatedAt < limit, nil
+	})
+}
+
+// cleanupAuditEntries overrides the shared implementation to avoid a single
+// transaction with too many operations
+func (p *EtcdProvider) cleanupAuditEntries(before int64) error {
+	return p.cleanupBucket(kvAuditTrailBucket, func(v []byte) (bool, error) {
+		var entry AuditEntry
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return false, err
+		}
+		return entry.Timestamp < before, nil
+	})
+}
+
+// cleanupDeadLetters overrides the shared implementation to avoid a single
+// transaction with too many operations
+func (p *EtcdProvider) cleanupDeadLetters(before int64) error {
+	return p.cleanupBucket(kvDeadLettersBucket, func(v []byte) (bool, error) {
+		var entry DeadLetter
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return false, err
+		}
+		return entry.Timestamp < before, nil
+	})
+}
+
+func (p *EtcdProvider) close() error {
+	p.cancel()
+	return p.db.Close()
+}
+
+// initializeDatabase stores the current schema version if the key/value store is empty
+func (p *EtcdProvider) initializeDatabase() error {
+	return p.db.Update(func(tx kvTx) error {
+		bucket := tx.Bucket(kvDBVersionBucket)
+		if v := bucket.Get(etcdDBVersionKey); v != nil {
+			return ErrNoInitRequired
+		}
+		buf, err := json.Marshal(schemaVersion{
+			Version: etcdDatabaseVersion,
+		})
+		if err != nil {
+			return err
+		}
+		logger.InfoToConsole("creating initial database schema, version %d", etcdDatabaseVersion)
+		providerLog(logger.LevelInfo, "creating initial database schema, version %d", etcdDatabaseVersion)
+		return bucket.Put(etcdDBVersionKey, buf)
+	})
+}
+
+func (p *EtcdProvider) migrateDatabase() error {
+	dbVersion, err := getEtcdDatabaseVersion(p.db)
+	if err != nil {
+		return err
+	}
+	switch version := dbVersion.Version; {
+	case version == etcdDatabaseVersion:
+		providerLog(logger.LevelDebug, "etcd database is up to date, current version: %v", version)
+		return ErrNoInitRequired
+	case version > etcdDatabaseVersion:
+		providerLog(logger.LevelError, "database schema version %v is newer than the supported one: %v", version,
+			etcdDatabaseVersion)
+		logger.WarnToConsole("database schema version %v is newer than the supported one: %v", version,
+			etcdDatabaseVersion)
+		return nil
+	default:
+		return fmt.Errorf("database schema version not handled: %v", version)
+	}
+}
+
+func (p *EtcdProvider) revertDatabase(targetVersion int) error {
+	dbVersion, err := getEtcdDatabaseVersion(p.db)
+	if err != nil {
+		return err
+	}
+	if dbVersion.Version == targetVersion {
+		return errors.New("current version match target version, nothing to do")
+	}
+	return fmt.Errorf("database schema version not handled: %v", dbVersion.Version)
+}
+
+func (p *EtcdProvider) resetDatabase() error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdOpTimeout)
+	defer cancel()
+
+	_, err := p.db.client.Delete(ctx, p.db.prefix, clientv3.WithPrefix())
+	return err
+}
+
+func (p *EtcdProvider) backupDatabase(outputFile string) error {
+	return ErrNotImplemented
+}
+
+// watchChanges keeps the local caches in sync with the changes made by other
+// SFTPGo instances sharing the same etcd cluster
+func (p *EtcdProvider) watchChanges(ctx context.Context) {
+	for {
+		p.watchBuckets(ctx)
+
+		select {
+		case <-ctx.Done():
+			providerLog(logger.LevelDebug, "etcd watcher stopped")
+			return
+		case <-time.After(2 * time.Second):
+		}
+		// some events could be lost while the watcher was not active, force a
+		// refresh for the recently updated objects
+		setLastUserUpdate()
+		setLastRuleUpdate()
+		if fnReloadRules != nil {
+			fnReloadRules()
+		}
+	}
+}
+
+func (p *EtcdProvider) watchBuckets(ctx context.Context) {
+	ctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+
+	usersPrefix := p.db.bucketPrefix(kvUsersBucket)
+	rulesPrefix := p.db.bucketPrefix(kvRulesBucket)
+	usersCh := p.db.client.Watch(ctx, usersPrefix, clientv3.WithPrefix(), clientv3.WithPrevKV())
+	rulesCh := p.db.client.Watch(ctx, rulesPrefix, clientv3.WithPrefix(), clientv3.WithPrevKV())
+
+	providerLog(logger.LevelDebug, "etcd watcher started")
+	for {
+		select {
+		case resp, ok := <-usersCh:
+			if !ok || resp.Err() != nil {
+				providerLog(logger.LevelWarn, "etcd users watcher terminated: %v", resp.Err())
+				return
+			}
+			for _, ev := range resp.Events {
+				p.onUserEvent(strings.TrimPrefix(string(ev.Kv.Key), usersPrefix), ev)
+			}
+		case resp, ok := <-rulesCh:
+			if !ok || resp.Err() != nil {
+				providerLog(logger.LevelWarn, "etcd rules watcher terminated: %v", resp.Err())
+				return
+			}
+			for _, ev := range resp.Events {
+				p.onRuleEvent(strings.TrimPrefix(string(ev.Kv.Key), rulesPrefix), ev)
+			}
+		}
+	}
+}
+
+func (p *EtcdProvider) onUserEvent(username string, ev *clientv3.Event) {
+	switch ev.Type {
+	case mvccpb.DELETE:
+		removeUserCaches(username)
+	case mvccpb.PUT:
+		if !isEtcdObjectUpdated(ev) {
+			return
+		}
+		setLastUserUpdate()
+		user, err := p.userExists(username)
+		if err != nil {
+			providerLog(logger.LevelDebug, "unable to get updated user %q: %v", username, err)
+			removeUserCaches(username)
+			return
+		}
+		updateUserCaches(&user)
+	}
+}
+
+func (p *EtcdProvider) onRuleEvent(name string, ev *clientv3.Event) {
+	switch ev.Type {
+	case mvccpb.DELETE:
+		providerLog(logger.LevelDebug, "event rule %q removed", name)
+		if fnRemoveRule != nil {
+			fnRemoveRule(name)
+		}
+	case mvccpb.PUT:
+		if !isEtcdObjectUpdated(ev) {
+			return
+		}
+		providerLog(logger.LevelDebug, "event rule %q updated", name)
+		setLastRuleUpdate()
+		if fnReloadRules != nil {
+			fnReloadRules()
+		}
+	}
+}
+
+// isEtcdObjectUpdated returns false if the update time is unchanged, this happens
+// for internal updates such as quota and last login
+func isEtcdObjectUpdated(ev *clientv3.Event) bool {
+	if ev.PrevKv == nil {
+		return true
+	}
+	var current, previous struct {
+		UpdatedAt int64 `json:"updated_at"`
+	}
+	if err := json.Unmarshal(ev.Kv.Value, &current); err != nil {
+		return true
+	}
+	if err := json.Unmarshal(ev.PrevKv.Value, &previous); err != nil {
+		return true
+	}
+	return current.UpdatedAt != previous.UpdatedAt
+}
+
+// etcdSession defines how the shared sessions are stored
+type etcdSession struct {
+	Key       string      `json:"key"`
+	Data      []byte      `json:"data"`
+	Type      SessionType `json:"type"`
+	Timestamp int64       `json:"timestamp"`
+}
+
+func (p *EtcdProvider) getActiveTransferKey(transferID int64, connectionID string) string {
+	return fmt.Sprintf("%s%s/%d", p.db.bucketPrefix(kvActiveTransfersBucket), connectionID, transferID)
+}
+
+// getJSON reads the given key, outside any transaction, and unmarshals its value
+func (p *EtcdProvider) getJSON(key string, v any) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdOpTimeout)
+	defer cancel()
+
+	resp, err := p.db.client.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, util.NewRecordNotFoundError(fmt.Sprintf("key %q does not exist", key))
+	}
+	return resp.Kvs[0].ModRevision, json.Unmarshal(resp.Kvs[0].Value, v)
+}
+
+// putJSON stores the given value, outside any transaction
+func (p *EtcdProvider) putJSON(key string, v any) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdOpTimeout)
+	defer cancel()
+
+	_, err = p.db.client.Put(ctx, key, string(buf))
+	return err
+}
+
+// cleanupBucket removes, outside any transaction, the keys for which the
+// provided function returns true
+func (p *EtcdProvider) cleanupBucket(bucket string, toRemove func([]byte) (bool, error)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdOpTimeout)
+	defer cancel()
+
+	resp, err := p.db.client.Get(ctx, p.db.bucketPrefix(bucket), clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	for _, kv := range resp.Kvs {
+		remove, err := toRemove(kv.Value)
+		if err != nil {
+			providerLog(logger.LevelWarn, "unable to check key %q for removal: %v", kv.Key, err)
+			continue
+		}
+		if !remove {
+			continue
+		}
+		// the key is removed only if it was not modified in the meantime
+		_, err = p.db.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(string(kv.Key)), "=", kv.ModRevision)).
+			Then(clientv3.OpDelete(string(kv.Key))).
+			Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateTaskIf updates the task with the specified name if the provided function
+// returns true. The task update time is always refreshed. The update is atomic
+func (p *EtcdProvider) updateTaskIf(name string, fn func(*Task) bool) error {
+	key := p.db.bucketPrefix(kvTasksBucket) + name
+	var task Task
+	modRevision, err := p.getJSON(key, &task)
+	if err != nil {
+		return err
+	}
+	if !fn(&task) {
+		return util.NewRecordNotFoundError(fmt.Sprintf("task %q with version %d does not exist", name, task.Version))
+	}
+	task.UpdateAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	buf, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdOpTimeout)
+	defer cancel()
+
+	resp, err := p.db.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", modRevision)).
+		Then(clientv3.OpPut(key, string(buf))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return util.NewRecordNotFoundError(fmt.Sprintf("task %q was concurrently modified", name))
+	}
+	return nil
+}
+
+func getEtcdDatabaseVersion(dbHandle *etcdDB) (schemaVersion, error) {
+	var dbVersion schemaVersion
+	err := dbHandle.View(func(tx kvTx) error {
+		v := tx.Bucket(kvDBVersionBucket).Get(etcdDBVersionKey)
+		if v == nil {
+			return errors.New("unable to find database schema version, please run the \"initprovider\" command")
+		}
+		return json.Unmarshal(v, &dbVersion)
+	})
+	return dbVersion, err
+}
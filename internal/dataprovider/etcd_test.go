@@ -0,0 +1,166 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !noetcd
+// +build !noetcd
+
+package dataprovider
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+func getEtcdTestURL(t *testing.T) url.URL {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	return url.URL{Scheme: "http", Host: addr}
+}
+
+// startEmbeddedEtcd starts a single node etcd server, the data are stored
+// within a temporary directory
+func startEmbeddedEtcd(t *testing.T) string {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL := getEtcdTestURL(t)
+	peerURL := getEtcdTestURL(t)
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.InitialCluster = fmt.Sprintf("%s=%s", cfg.Name, peerURL.String())
+
+	server, err := embed.StartEtcd(cfg)
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		server.Server.Stop()
+		require.FailNow(t, "etcd server not ready")
+	}
+	return clientURL.String()
+}
+
+func initializeEtcdTestProvider(t *testing.T) *EtcdProvider {
+	cnf := getTestProviderConfig(EtcdDataProviderName, "sftpgo_test")
+	cnf.ConnectionString = startEmbeddedEtcd(t)
+	err := InitializeDatabase(cnf, t.TempDir())
+	if !errors.Is(err, ErrNoInitRequired) {
+		require.NoError(t, err)
+	}
+	p, ok := provider.(*EtcdProvider)
+	require.True(t, ok)
+	t.Cleanup(func() {
+		assert.NoError(t, p.close())
+	})
+	return p
+}
+
+func TestEtcdProviderCRUD(t *testing.T) {
+	p := initializeEtcdTestProvider(t)
+
+	testProviderUsersFoldersGroups(t, p)
+	testProviderAdmins(t, p)
+}
+
+func TestEtcdTransactions(t *testing.T) {
+	p := initializeEtcdTestProvider(t)
+
+	testKVStoreTransactions(t, p.db)
+}
+
+func TestEtcdConcurrentUpdates(t *testing.T) {
+	p := initializeEtcdTestProvider(t)
+
+	user := User{}
+	user.Username = "test_etcd_quota"
+	user.Password = "password"
+	user.HomeDir = t.TempDir()
+	user.Status = 1
+	user.Permissions = map[string][]string{"/": {PermAny}}
+	err := p.addUser(&user)
+	require.NoError(t, err)
+	// the read-write transactions are serialized, no update is lost
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			assert.NoError(t, p.updateQuota(user.Username, 1, 10, false))
+		}()
+	}
+	wg.Wait()
+	files, size, _, _, err := p.getUsedQuota(user.Username)
+	require.NoError(t, err)
+	assert.Equal(t, 10, files)
+	assert.Equal(t, int64(100), size)
+}
+
+func TestEtcdAuditAndDeadLetters(t *testing.T) {
+	p := initializeEtcdTestProvider(t)
+
+	testProviderAuditAndDeadLetters(t, p)
+}
+
+func TestEtcdSharedSessions(t *testing.T) {
+	initializeEtcdTestProvider(t)
+
+	testProviderSharedSessions(t)
+}
+
+func TestEtcdMigrations(t *testing.T) {
+	p := initializeEtcdTestProvider(t)
+
+	dbVersion, err := getEtcdDatabaseVersion(p.db)
+	require.NoError(t, err)
+	assert.Equal(t, etcdDatabaseVersion, dbVersion.Version)
+	err = p.initializeDatabase()
+	assert.ErrorIs(t, err, ErrNoInitRequired)
+	err = p.migrateDatabase()
+	assert.ErrorIs(t, err, ErrNoInitRequired)
+	err = p.revertDatabase(etcdDatabaseVersion)
+	assert.ErrorContains(t, err, "current version match target version")
+	err = p.revertDatabase(etcdDatabaseVersion - 1)
+	assert.ErrorContains(t, err, "database schema version not handled")
+
+	err = p.resetDatabase()
+	require.NoError(t, err)
+	_, err = p.userExists("missing")
+	assertNotFoundError(t, err)
+	_, err = getEtcdDatabaseVersion(p.db)
+	assert.Error(t, err)
+	err = p.migrateDatabase()
+	assert.Error(t, err)
+	err = p.initializeDatabase()
+	require.NoError(t, err)
+	dbVersion, err = getEtcdDatabaseVersion(p.db)
+	require.NoError(t, err)
+	assert.Equal(t, etcdDatabaseVersion, dbVersion.Version)
+	err = p.backupDatabase("")
+	assert.ErrorIs(t, err, ErrNotImplemented)
+}
@@ -23,13 +23,18 @@ import (
 	"net/http"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/robfig/cron/v3"
+	"github.com/sftpgo/sdk"
 
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/mq"
+	"github.com/drakkan/sftpgo/v2/internal/resolver"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
@@ -45,12 +50,17 @@ const (
 	ActionTypeDataRetentionCheck
 	ActionTypeFilesystem
 	ActionTypeMetadataCheck
+	ActionTypeStorageTransition
+	ActionTypeIntegrityCheck
+	ActionTypePublish
+	ActionTypeSummaryReport
 )
 
 var (
 	supportedEventActions = []int{ActionTypeHTTP, ActionTypeCommand, ActionTypeEmail, ActionTypeFilesystem,
 		ActionTypeBackup, ActionTypeUserQuotaReset, ActionTypeFolderQuotaReset, ActionTypeTransferQuotaReset,
-		ActionTypeDataRetentionCheck, ActionTypeMetadataCheck}
+		ActionTypeDataRetentionCheck, ActionTypeMetadataCheck, ActionTypeStorageTransition, ActionTypeIntegrityCheck,
+		ActionTypePublish, ActionTypeSummaryReport}
 )
 
 func isActionTypeValid(action int) bool {
@@ -77,6 +87,14 @@ func getActionTypeAsString(action int) string {
 		return "Metadata check"
 	case ActionTypeFilesystem:
 		return "Filesystem"
+	case ActionTypeStorageTransition:
+		return "Storage class transition"
+	case ActionTypeIntegrityCheck:
+		return "Integrity check"
+	case ActionTypePublish:
+		return "Message publish"
+	case ActionTypeSummaryReport:
+		return "Summary report"
 	default:
 		return "Command"
 	}
@@ -91,11 +109,15 @@ const (
 	EventTriggerSchedule
 	EventTriggerIPBlocked
 	EventTriggerCertificate
+	// Process resource limits exceeded or restored
+	EventTriggerResourceLimits
+	// Rules executed on demand using the REST API
+	EventTriggerOnDemand
 )
 
 var (
 	supportedEventTriggers = []int{EventTriggerFsEvent, EventTriggerProviderEvent, EventTriggerSchedule,
-		EventTriggerIPBlocked, EventTriggerCertificate}
+		EventTriggerIPBlocked, EventTriggerCertificate, EventTriggerResourceLimits, EventTriggerOnDemand}
 )
 
 func isEventTriggerValid(trigger int) bool {
@@ -112,6 +134,10 @@ func getTriggerTypeAsString(trigger int) string {
 		return "IP blocked"
 	case EventTriggerCertificate:
 		return "Certificate renewal"
+	case EventTriggerResourceLimits:
+		return "Resource limits"
+	case EventTriggerOnDemand:
+		return "On demand"
 	default:
 		return "Schedule"
 	}
@@ -129,8 +155,28 @@ const (
 const (
 	// RetentionReportPlaceHolder defines the placeholder for data retention reports
 	RetentionReportPlaceHolder = "{{RetentionReports}}"
+	// StorageTransitionReportPlaceHolder defines the placeholder for storage class transition reports
+	StorageTransitionReportPlaceHolder = "{{StorageTransitionReports}}"
+	// IntegrityCheckReportPlaceHolder defines the placeholder for integrity check reports
+	IntegrityCheckReportPlaceHolder = "{{IntegrityCheckReports}}"
 )
 
+// maxHTTPActionRetries defines the maximum number of retries for HTTP actions
+const maxHTTPActionRetries = 10
+
+const (
+	// MaxSummaryReportPeriod defines the maximum period, in hours, covered by
+	// the summary reports. Older statistics are discarded
+	MaxSummaryReportPeriod   = 744
+	maxSummaryReportTopCount = 100
+)
+
+// IsReportPlaceHolder returns true if the specified value is a report placeholder
+func IsReportPlaceHolder(val string) bool {
+	return val == RetentionReportPlaceHolder || val == StorageTransitionReportPlaceHolder ||
+		val == IntegrityCheckReportPlaceHolder
+}
+
 var (
 	supportedFsActions = []int{FilesystemActionRename, FilesystemActionDelete, FilesystemActionMkdirs,
 		FilesystemActionCompress, FilesystemActionExist}
@@ -159,9 +205,10 @@ func getFsActionTypeAsString(value int) string {
 var (
 	// SupportedFsEvents defines the supported filesystem events
 	SupportedFsEvents = []string{"upload", "first-upload", "download", "first-download", "delete", "rename",
-		"mkdir", "rmdir", "ssh_cmd"}
+		"mkdir", "rmdir", "ssh_cmd", "port_forward"}
 	// SupportedProviderEvents defines the supported provider events
-	SupportedProviderEvents = []string{operationAdd, operationUpdate, operationDelete}
+	SupportedProviderEvents = []string{operationAdd, operationUpdate, operationDelete, operationCryptoShred,
+		operationSoftDelete, operationRestore}
 	// SupportedRuleConditionProtocols defines the supported protcols for rule conditions
 	SupportedRuleConditionProtocols = []string{"SFTP", "SCP", "SSH", "FTP", "DAV", "HTTP", "HTTPShare",
 		"OIDC"}
@@ -170,6 +217,19 @@ var (
 		actionObjectAdmin, actionObjectAPIKey, actionObjectShare, actionObjectEventRule, actionObjectEventAction}
 	// SupportedHTTPActionMethods defines the supported methods for HTTP actions
 	SupportedHTTPActionMethods = []string{http.MethodPost, http.MethodGet, http.MethodPut}
+	// SupportedHTTPTransformFormats defines the supported input formats for HTTP
+	// body transformations
+	SupportedHTTPTransformFormats = []string{"csv", "xml"}
+	// SupportedS3TransitionStorageClasses defines the supported target storage classes
+	// for S3 storage class transitions
+	SupportedS3TransitionStorageClasses = []string{"STANDARD_IA", "ONEZONE_IA", "GLACIER_IR", "GLACIER",
+		"DEEP_ARCHIVE"}
+	// SupportedGCSTransitionStorageClasses defines the supported target storage classes
+	// for Google Cloud Storage storage class transitions
+	SupportedGCSTransitionStorageClasses = []string{"NEARLINE", "COLDLINE", "ARCHIVE"}
+	// SupportedAzureTransitionAccessTiers defines the supported target access tiers
+	// for Azure Blob storage access tier transitions
+	SupportedAzureTransitionAccessTiers = []string{"Cool", "Cold", "Archive"}
 )
 
 // enum mappings
@@ -240,13 +300,59 @@ func (p *HTTPPart) validate() error {
 		}
 	} else {
 		p.Body = ""
-		if p.Filepath != RetentionReportPlaceHolder {
+		if !IsReportPlaceHolder(p.Filepath) {
 			p.Filepath = util.CleanPath(p.Filepath)
 		}
 	}
 	return nil
 }
 
+// HTTPTransform defines how to convert a file to JSON and send it as HTTP request body
+type HTTPTransform struct {
+	// Path to the file to convert, placeholders are supported
+	Filepath string `json:"filepath,omitempty"`
+	// Format of the file to convert, see SupportedHTTPTransformFormats
+	Format string `json:"format,omitempty"`
+	// Go text template used to build the request body. The converted file
+	// is available as {{.Data}} and the placeholders as {{.Name}},
+	// {{.VirtualPath}} and so on. If empty, the converted file is sent as is
+	Template string `json:"template,omitempty"`
+}
+
+// IsEnabled returns true if a file to convert is defined
+func (t *HTTPTransform) IsEnabled() bool {
+	return t.Filepath != ""
+}
+
+// ParseTemplate parses the configured template. The "json" function is
+// available to encode values as JSON
+func (t *HTTPTransform) ParseTemplate() (*template.Template, error) {
+	return template.New("transform").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(t.Template)
+}
+
+func (t *HTTPTransform) validate() error {
+	if !t.IsEnabled() {
+		t.Format = ""
+		t.Template = ""
+		return nil
+	}
+	t.Filepath = util.CleanPath(t.Filepath)
+	if !util.Contains(SupportedHTTPTransformFormats, t.Format) {
+		return util.NewValidationError(fmt.Sprintf("unsupported transformation format %q", t.Format))
+	}
+	if t.Template != "" {
+		if _, err := t.ParseTemplate(); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid transformation template: %v", err))
+		}
+	}
+	return nil
+}
+
 // EventActionHTTPConfig defines the configuration for an HTTP event target
 type EventActionHTTPConfig struct {
 	Endpoint        string      `json:"endpoint,omitempty"`
@@ -259,6 +365,14 @@ type EventActionHTTPConfig struct {
 	QueryParameters []KeyValue  `json:"query_parameters,omitempty"`
 	Body            string      `json:"body,omitempty"`
 	Parts           []HTTPPart  `json:"parts,omitempty"`
+	// Transform allows to send a file converted to JSON as request body
+	Transform HTTPTransform `json:"transform"`
+	// SigningSecret is used to sign the requests with an HMAC-SHA256 signature.
+	// If empty the global signature configuration for HTTP clients is used
+	SigningSecret *kms.Secret `json:"signing_secret,omitempty"`
+	// Retries defines the number of retries, with exponential backoff, for
+	// connection errors and 429 and 5xx responses
+	Retries int `json:"retries,omitempty"`
 }
 
 func (c *EventActionHTTPConfig) isTimeoutNotValid() bool {
@@ -294,6 +408,22 @@ func (c *EventActionHTTPConfig) validateMultiparts() error {
 	return nil
 }
 
+func (c *EventActionHTTPConfig) validateTransform() error {
+	if err := c.Transform.validate(); err != nil {
+		return err
+	}
+	if !c.Transform.IsEnabled() {
+		return nil
+	}
+	if c.Body != "" || len(c.Parts) > 0 {
+		return util.NewValidationError("file transformations require no body and no parts. The request body is built from the converted file")
+	}
+	if c.Method == http.MethodGet {
+		return util.NewValidationError("file transformations are not supported for GET requests")
+	}
+	return nil
+}
+
 func (c *EventActionHTTPConfig) validate(additionalData string) error {
 	if c.Endpoint == "" {
 		return util.NewValidationError("HTTP endpoint is required")
@@ -312,7 +442,10 @@ func (c *EventActionHTTPConfig) validate(additionalData string) error {
 	if err := c.validateMultiparts(); err != nil {
 		return err
 	}
-	if c.Password.IsRedacted() {
+	if err := c.validateTransform(); err != nil {
+		return err
+	}
+	if c.Password.IsRedacted() || c.SigningSecret.IsRedacted() {
 		return util.NewValidationError("cannot save HTTP configuration with a redacted secret")
 	}
 	if c.Password.IsPlain() {
@@ -322,6 +455,17 @@ func (c *EventActionHTTPConfig) validate(additionalData string) error {
 			return util.NewValidationError(fmt.Sprintf("could not encrypt HTTP password: %v", err))
 		}
 	}
+	if c.SigningSecret.IsPlain() {
+		c.SigningSecret.SetAdditionalData(additionalData)
+		err := c.SigningSecret.Encrypt()
+		if err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt HTTP signing secret: %v", err))
+		}
+	}
+	if c.Retries < 0 || c.Retries > maxHTTPActionRetries {
+		return util.NewValidationError(fmt.Sprintf("invalid HTTP retries %d, allowed range 0-%d", c.Retries,
+			maxHTTPActionRetries))
+	}
 	if !util.Contains(SupportedHTTPActionMethods, c.Method) {
 		return util.NewValidationError(fmt.Sprintf("unsupported HTTP method: %s", c.Method))
 	}
@@ -357,7 +501,7 @@ func (c *EventActionHTTPConfig) HasObjectData() bool {
 // HasMultipartFiles returns true if at least a file must be uploaded via a multipart request
 func (c *EventActionHTTPConfig) HasMultipartFiles() bool {
 	for _, part := range c.Parts {
-		if part.Filepath != "" && part.Filepath != RetentionReportPlaceHolder {
+		if part.Filepath != "" && !IsReportPlaceHolder(part.Filepath) {
 			return true
 		}
 	}
@@ -374,17 +518,35 @@ func (c *EventActionHTTPConfig) TryDecryptPassword() error {
 	return nil
 }
 
+// TryDecryptSigningSecret decrypts the signing secret if encrypted
+func (c *EventActionHTTPConfig) TryDecryptSigningSecret() error {
+	if c.SigningSecret != nil && !c.SigningSecret.IsEmpty() {
+		if err := c.SigningSecret.TryDecrypt(); err != nil {
+			return fmt.Errorf("unable to decrypt HTTP signing secret: %w", err)
+		}
+	}
+	return nil
+}
+
+// HasSigningSecret returns true if a signing secret is defined
+func (c *EventActionHTTPConfig) HasSigningSecret() bool {
+	return c.SigningSecret != nil && !c.SigningSecret.IsEmpty()
+}
+
 // GetHTTPClient returns an HTTP client based on the config
 func (c *EventActionHTTPConfig) GetHTTPClient() *http.Client {
 	client := &http.Client{}
-	if c.SkipTLSVerify {
+	if c.SkipTLSVerify || resolver.IsEnabled() {
 		transport := http.DefaultTransport.(*http.Transport).Clone()
-		if transport.TLSClientConfig != nil {
-			transport.TLSClientConfig.InsecureSkipVerify = true
-		} else {
-			transport.TLSClientConfig = &tls.Config{
-				NextProtos:         []string{"http/1.1", "h2"},
-				InsecureSkipVerify: true,
+		transport.DialContext = resolver.WrapDialContext(transport.DialContext)
+		if c.SkipTLSVerify {
+			if transport.TLSClientConfig != nil {
+				transport.TLSClientConfig.InsecureSkipVerify = true
+			} else {
+				transport.TLSClientConfig = &tls.Config{
+					NextProtos:         []string{"http/1.1", "h2"},
+					InsecureSkipVerify: true,
+				}
 			}
 		}
 		client.Transport = transport
@@ -431,9 +593,18 @@ func (c EventActionCommandConfig) GetArgumentsAsString() string {
 
 // EventActionEmailConfig defines the configuration options for SMTP event actions
 type EventActionEmailConfig struct {
-	Recipients  []string `json:"recipients,omitempty"`
-	Subject     string   `json:"subject,omitempty"`
-	Body        string   `json:"body,omitempty"`
+	Recipients []string `json:"recipients,omitempty"`
+	Cc         []string `json:"cc,omitempty"`
+	Bcc        []string `json:"bcc,omitempty"`
+	ReplyTo    string   `json:"reply_to,omitempty"`
+	Subject    string   `json:"subject,omitempty"`
+	Body       string   `json:"body,omitempty"`
+	// Template is the name of an email template to render the body from,
+	// if set the body is ignored
+	Template string `json:"template,omitempty"`
+	// Language of the recipients, the localized version of the template is
+	// used, if available
+	Language    string   `json:"language,omitempty"`
 	Attachments []string `json:"attachments,omitempty"`
 }
 
@@ -442,6 +613,16 @@ func (c EventActionEmailConfig) GetRecipientsAsString() string {
 	return strings.Join(c.Recipients, ",")
 }
 
+// GetCcAsString returns the list of Cc recipients as comma separated string
+func (c EventActionEmailConfig) GetCcAsString() string {
+	return strings.Join(c.Cc, ",")
+}
+
+// GetBccAsString returns the list of Bcc recipients as comma separated string
+func (c EventActionEmailConfig) GetBccAsString() string {
+	return strings.Join(c.Bcc, ",")
+}
+
 // GetAttachmentsAsString returns the list of attachments as comma separated string
 func (c EventActionEmailConfig) GetAttachmentsAsString() string {
 	return strings.Join(c.Attachments, ",")
@@ -449,7 +630,7 @@ func (c EventActionEmailConfig) GetAttachmentsAsString() string {
 
 func (c *EventActionEmailConfig) hasFilesAttachments() bool {
 	for _, a := range c.Attachments {
-		if a != RetentionReportPlaceHolder {
+		if !IsReportPlaceHolder(a) {
 			return true
 		}
 	}
@@ -466,18 +647,43 @@ func (c *EventActionEmailConfig) validate() error {
 			return util.NewValidationError("invalid email recipients")
 		}
 	}
+	c.Cc = util.RemoveDuplicates(c.Cc, false)
+	for _, r := range c.Cc {
+		if r == "" {
+			return util.NewValidationError("invalid email cc recipients")
+		}
+	}
+	c.Bcc = util.RemoveDuplicates(c.Bcc, false)
+	for _, r := range c.Bcc {
+		if r == "" {
+			return util.NewValidationError("invalid email bcc recipients")
+		}
+	}
+	c.ReplyTo = strings.TrimSpace(c.ReplyTo)
 	if c.Subject == "" {
 		return util.NewValidationError("email subject is required")
 	}
-	if c.Body == "" {
-		return util.NewValidationError("email body is required")
+	c.Template = strings.TrimSpace(c.Template)
+	if c.Template != "" {
+		if filepath.Ext(c.Template) != ".html" && filepath.Ext(c.Template) != ".txt" {
+			return util.NewValidationError(fmt.Sprintf("invalid email template %q", c.Template))
+		}
+		c.Body = ""
+		if err := validateLanguage(&c.Language); err != nil {
+			return err
+		}
+	} else {
+		if c.Body == "" {
+			return util.NewValidationError("email body is required")
+		}
+		c.Language = ""
 	}
 	for idx, val := range c.Attachments {
 		val = strings.TrimSpace(val)
 		if val == "" {
 			return util.NewValidationError("invalid path to attach")
 		}
-		if val == RetentionReportPlaceHolder {
+		if IsReportPlaceHolder(val) {
 			c.Attachments[idx] = val
 		} else {
 			c.Attachments[idx] = util.CleanPath(val)
@@ -542,6 +748,169 @@ func (c *EventActionDataRetentionConfig) validate() error {
 	return nil
 }
 
+// FolderStorageTransition defines a storage class transition configuration for a folder
+type FolderStorageTransition struct {
+	// Path is the exposed virtual directory path. As for data retention, the most
+	// specific path applies, so a configuration for "/sub" overrides the one for "/"
+	// for any file inside the "/sub" directory
+	Path string `json:"path"`
+	// Files with a modification time older than the specified number of days are
+	// moved to the configured storage class. 0 means exclude this path
+	Age int `json:"age"`
+	// Target storage class for S3 filesystems, empty means skip S3 filesystems
+	S3StorageClass string `json:"s3_storage_class,omitempty"`
+	// Target storage class for Google Cloud Storage filesystems, empty means skip GCS filesystems
+	GCSStorageClass string `json:"gcs_storage_class,omitempty"`
+	// Target access tier for Azure Blob filesystems, empty means skip Azure Blob filesystems
+	AzureAccessTier string `json:"azure_access_tier,omitempty"`
+}
+
+// GetStorageClass returns the target storage class for the specified filesystem provider
+func (f *FolderStorageTransition) GetStorageClass(provider sdk.FilesystemProvider) string {
+	switch provider {
+	case sdk.S3FilesystemProvider:
+		return f.S3StorageClass
+	case sdk.GCSFilesystemProvider:
+		return f.GCSStorageClass
+	case sdk.AzureBlobFilesystemProvider:
+		return f.AzureAccessTier
+	default:
+		return ""
+	}
+}
+
+func (f *FolderStorageTransition) validate() error {
+	f.Path = util.CleanPath(f.Path)
+	f.S3StorageClass = strings.TrimSpace(f.S3StorageClass)
+	f.GCSStorageClass = strings.TrimSpace(f.GCSStorageClass)
+	f.AzureAccessTier = strings.TrimSpace(f.AzureAccessTier)
+	if f.Age < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid age %d for path %q, it must be greater or equal to zero",
+			f.Age, f.Path))
+	}
+	if f.Age == 0 {
+		f.S3StorageClass = ""
+		f.GCSStorageClass = ""
+		f.AzureAccessTier = ""
+		return nil
+	}
+	if f.S3StorageClass == "" && f.GCSStorageClass == "" && f.AzureAccessTier == "" {
+		return util.NewValidationError(fmt.Sprintf("no target storage class defined for path %q", f.Path))
+	}
+	if f.S3StorageClass != "" && !util.Contains(SupportedS3TransitionStorageClasses, f.S3StorageClass) {
+		return util.NewValidationError(fmt.Sprintf("invalid S3 storage class %q", f.S3StorageClass))
+	}
+	if f.GCSStorageClass != "" && !util.Contains(SupportedGCSTransitionStorageClasses, f.GCSStorageClass) {
+		return util.NewValidationError(fmt.Sprintf("invalid GCS storage class %q", f.GCSStorageClass))
+	}
+	if f.AzureAccessTier != "" && !util.Contains(SupportedAzureTransitionAccessTiers, f.AzureAccessTier) {
+		return util.NewValidationError(fmt.Sprintf("invalid Azure access tier %q", f.AzureAccessTier))
+	}
+	return nil
+}
+
+// EventActionStorageTransitionConfig defines the configuration for a storage class transition
+type EventActionStorageTransitionConfig struct {
+	Folders []FolderStorageTransition `json:"folders,omitempty"`
+	// If enabled, the affected objects are only reported, no transition is executed
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+func (c *EventActionStorageTransitionConfig) validate() error {
+	folderPaths := make(map[string]bool)
+	nothingToDo := true
+	for idx := range c.Folders {
+		f := &c.Folders[idx]
+		if err := f.validate(); err != nil {
+			return err
+		}
+		if f.Age > 0 {
+			nothingToDo = false
+		}
+		if _, ok := folderPaths[f.Path]; ok {
+			return util.NewValidationError(fmt.Sprintf("duplicated folder path %q", f.Path))
+		}
+		folderPaths[f.Path] = true
+	}
+	if nothingToDo {
+		return util.NewValidationError("nothing to transition!")
+	}
+	return nil
+}
+
+func (c *EventActionStorageTransitionConfig) getACopy() EventActionStorageTransitionConfig {
+	folders := make([]FolderStorageTransition, len(c.Folders))
+	copy(folders, c.Folders)
+
+	return EventActionStorageTransitionConfig{
+		Folders: folders,
+		DryRun:  c.DryRun,
+	}
+}
+
+// EventActionIntegrityCheckConfig defines the configuration for an integrity check
+type EventActionIntegrityCheckConfig struct {
+	// Virtual paths to check recursively, empty means the whole user filesystem
+	Paths []string `json:"paths,omitempty"`
+	// Percentage of randomly sampled files to check, 0 means check all the files.
+	// Missing files are detected only if all the files are checked
+	SamplePercentage int `json:"sample_percentage,omitempty"`
+	// Absolute path to a local directory to store the checksum manifests. They are used
+	// to verify the files without a checksum recorded by the storage backend and to
+	// detect missing files. Empty means only verify checksums recorded by the backends
+	ManifestsPath string `json:"manifests_path,omitempty"`
+}
+
+func (c *EventActionIntegrityCheckConfig) validate() error {
+	if c.SamplePercentage < 0 || c.SamplePercentage > 100 {
+		return util.NewValidationError(fmt.Sprintf("invalid sample percentage %d, it must be between 0 and 100",
+			c.SamplePercentage))
+	}
+	if c.SamplePercentage == 100 {
+		c.SamplePercentage = 0
+	}
+	c.ManifestsPath = strings.TrimSpace(c.ManifestsPath)
+	if c.ManifestsPath != "" {
+		if !filepath.IsAbs(c.ManifestsPath) {
+			return util.NewValidationError(fmt.Sprintf("manifests path %q must be an absolute path", c.ManifestsPath))
+		}
+		c.ManifestsPath = filepath.Clean(c.ManifestsPath)
+	}
+	paths := make([]string, 0, len(c.Paths))
+	for _, p := range c.Paths {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		p = util.CleanPath(p)
+		if !util.Contains(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		paths = append(paths, "/")
+	}
+	c.Paths = paths
+	return nil
+}
+
+// GetPathsAsString returns the paths to check as comma separated string.
+// Using a pointer receiver will not work in web templates
+func (c EventActionIntegrityCheckConfig) GetPathsAsString() string {
+	return strings.Join(c.Paths, ",")
+}
+
+func (c *EventActionIntegrityCheckConfig) getACopy() EventActionIntegrityCheckConfig {
+	paths := make([]string, len(c.Paths))
+	copy(paths, c.Paths)
+
+	return EventActionIntegrityCheckConfig{
+		Paths:            paths,
+		SamplePercentage: c.SamplePercentage,
+		ManifestsPath:    c.ManifestsPath,
+	}
+}
+
 // EventActionFsCompress defines the configuration for the compress filesystem action
 type EventActionFsCompress struct {
 	// Archive path
@@ -755,6 +1124,204 @@ func (c *EventActionFilesystemConfig) getACopy() EventActionFilesystemConfig {
 	}
 }
 
+// EventActionPublishConfig defines the configuration to publish event messages to Kafka, NATS or AMQP brokers
+type EventActionPublishConfig struct {
+	// Broker type: "kafka", "nats" or "amqp"
+	Broker string `json:"broker,omitempty"`
+	// Kafka: comma separated list of bootstrap brokers, for example "kafka1:9092,kafka2:9092".
+	// NATS: comma separated list of server URLs, for example "nats://127.0.0.1:4222".
+	// AMQP: server URL including the optional virtual host, for example "amqp://127.0.0.1:5672/vhost"
+	Endpoint      string      `json:"endpoint,omitempty"`
+	Username      string      `json:"username,omitempty"`
+	Password      *kms.Secret `json:"password,omitempty"`
+	TLS           bool        `json:"tls,omitempty"`
+	SkipTLSVerify bool        `json:"skip_tls_verify,omitempty"`
+	// Kafka topic, NATS subject or AMQP routing key. Placeholders are supported
+	Topic string `json:"topic,omitempty"`
+	// AMQP exchange, empty means the default exchange
+	Exchange string `json:"exchange,omitempty"`
+	// Kafka message key, used to select the partition. Placeholders are supported
+	Key string `json:"key,omitempty"`
+	// Message headers, placeholders are supported for the values
+	Headers []KeyValue `json:"headers,omitempty"`
+	// Message body, placeholders are supported. Empty means the default JSON event payload
+	Body string `json:"body,omitempty"`
+	// 0 at most once, 1 at least once
+	Delivery int `json:"delivery,omitempty"`
+	// Number of idle connections to keep and reuse. 0 means a new connection for each message
+	PoolSize int `json:"pool_size,omitempty"`
+	// Timeout in seconds
+	Timeout int `json:"timeout,omitempty"`
+}
+
+func (c *EventActionPublishConfig) validate(additionalData string) error {
+	mqConfig := c.GetMQConfig()
+	if err := mqConfig.Validate(); err != nil {
+		return util.NewValidationError(fmt.Sprintf("invalid publish configuration: %v", err))
+	}
+	c.Endpoint = mqConfig.Endpoint
+	c.Topic = strings.TrimSpace(c.Topic)
+	if c.Topic == "" {
+		return util.NewValidationError("publish topic is required")
+	}
+	if c.Broker != mq.BrokerAMQP {
+		c.Exchange = ""
+	}
+	if c.Broker != mq.BrokerKafka {
+		c.Key = ""
+	}
+	if c.Timeout < 1 || c.Timeout > 180 {
+		return util.NewValidationError(fmt.Sprintf("invalid publish timeout %d", c.Timeout))
+	}
+	for _, kv := range c.Headers {
+		if kv.isNotValid() {
+			return util.NewValidationError("invalid publish headers")
+		}
+	}
+	if c.Password.IsRedacted() {
+		return util.NewValidationError("cannot save publish configuration with a redacted secret")
+	}
+	if c.Password.IsPlain() {
+		c.Password.SetAdditionalData(additionalData)
+		err := c.Password.Encrypt()
+		if err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt publish password: %v", err))
+		}
+	}
+	return nil
+}
+
+// GetMQConfig returns the broker connection settings. The password must be decrypted
+func (c *EventActionPublishConfig) GetMQConfig() mq.Config {
+	config := mq.Config{
+		Broker:        c.Broker,
+		Endpoint:      c.Endpoint,
+		Username:      c.Username,
+		TLS:           c.TLS,
+		SkipTLSVerify: c.SkipTLSVerify,
+		Delivery:      c.Delivery,
+		PoolSize:      c.PoolSize,
+	}
+	if c.Password != nil {
+		config.Password = c.Password.GetPayload()
+	}
+	return config
+}
+
+// GetContext returns the context and the cancel func to use for publishing
+func (c *EventActionPublishConfig) GetContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Duration(c.Timeout)*time.Second)
+}
+
+// HasObjectData returns true if the {{ObjectData}} placeholder is defined or
+// the default payload is used
+func (c *EventActionPublishConfig) HasObjectData() bool {
+	return c.Body == "" || strings.Contains(c.Body, "{{ObjectData}}")
+}
+
+// TryDecryptPassword decrypts the password if encrypted
+func (c *EventActionPublishConfig) TryDecryptPassword() error {
+	if c.Password != nil && !c.Password.IsEmpty() {
+		if err := c.Password.TryDecrypt(); err != nil {
+			return fmt.Errorf("unable to decrypt publish password: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *EventActionPublishConfig) getACopy() EventActionPublishConfig {
+	return EventActionPublishConfig{
+		Broker:        c.Broker,
+		Endpoint:      c.Endpoint,
+		Username:      c.Username,
+		Password:      c.Password.Clone(),
+		TLS:           c.TLS,
+		SkipTLSVerify: c.SkipTLSVerify,
+		Topic:         c.Topic,
+		Exchange:      c.Exchange,
+		Key:           c.Key,
+		Headers:       cloneKeyValues(c.Headers),
+		Body:          c.Body,
+		Delivery:      c.Delivery,
+		PoolSize:      c.PoolSize,
+		Timeout:       c.Timeout,
+	}
+}
+
+// EventActionSummaryReportConfig defines the configuration for the summary reports
+// emailed to the specified recipients and admins
+type EventActionSummaryReportConfig struct {
+	// Email addresses to send the report to
+	Recipients []string `json:"recipients,omitempty"`
+	// Admin usernames, the report is sent to their email addresses
+	Admins []string `json:"admins,omitempty"`
+	// Email subject, a default subject is used if empty
+	Subject string `json:"subject,omitempty"`
+	// Language for the report, the localized version of the template is used, if available
+	Language string `json:"language,omitempty"`
+	// Period, in hours, covered by the report. For example 24 for a daily report
+	Period int `json:"period,omitempty"`
+	// Maximum number of entries, for example users or IP addresses, for each report section
+	TopCount int `json:"top_count,omitempty"`
+}
+
+// GetRecipientsAsString returns the list of recipients as comma separated string
+func (c EventActionSummaryReportConfig) GetRecipientsAsString() string {
+	return strings.Join(c.Recipients, ",")
+}
+
+// GetAdminsAsString returns the list of admins as comma separated string
+func (c EventActionSummaryReportConfig) GetAdminsAsString() string {
+	return strings.Join(c.Admins, ",")
+}
+
+func (c *EventActionSummaryReportConfig) validate() error {
+	c.Recipients = util.RemoveDuplicates(c.Recipients, true)
+	for _, r := range c.Recipients {
+		if r == "" {
+			return util.NewValidationError("invalid summary report recipients")
+		}
+	}
+	c.Admins = util.RemoveDuplicates(c.Admins, true)
+	for _, a := range c.Admins {
+		if a == "" {
+			return util.NewValidationError("invalid summary report admins")
+		}
+	}
+	if len(c.Recipients) == 0 && len(c.Admins) == 0 {
+		return util.NewValidationError("at least one summary report recipient or admin is required")
+	}
+	c.Subject = strings.TrimSpace(c.Subject)
+	if c.Subject == "" {
+		c.Subject = "SFTPGo summary report"
+	}
+	if c.Period < 1 || c.Period > MaxSummaryReportPeriod {
+		return util.NewValidationError(fmt.Sprintf("invalid summary report period %d, it must be between 1 and %d hours",
+			c.Period, MaxSummaryReportPeriod))
+	}
+	if c.TopCount < 1 || c.TopCount > maxSummaryReportTopCount {
+		return util.NewValidationError(fmt.Sprintf("invalid summary report top count %d, it must be between 1 and %d",
+			c.TopCount, maxSummaryReportTopCount))
+	}
+	return validateLanguage(&c.Language)
+}
+
+func (c *EventActionSummaryReportConfig) getACopy() EventActionSummaryReportConfig {
+	recipients := make([]string, len(c.Recipients))
+	copy(recipients, c.Recipients)
+	admins := make([]string, len(c.Admins))
+	copy(admins, c.Admins)
+
+	return EventActionSummaryReportConfig{
+		Recipients: recipients,
+		Admins:     admins,
+		Subject:    c.Subject,
+		Language:   c.Language,
+		Period:     c.Period,
+		TopCount:   c.TopCount,
+	}
+}
+
 // BaseEventActionOptions defines the supported configuration options for a base event actions
 type BaseEventActionOptions struct {
 	HTTPConfig      EventActionHTTPConfig          `json:"http_config"`
@@ -762,12 +1329,24 @@ type BaseEventActionOptions struct {
 	EmailConfig     EventActionEmailConfig         `json:"email_config"`
 	RetentionConfig EventActionDataRetentionConfig `json:"retention_config"`
 	FsConfig        EventActionFilesystemConfig    `json:"fs_config"`
+	// storage class transition configuration
+	TransitionConfig EventActionStorageTransitionConfig `json:"transition_config"`
+	// integrity check configuration
+	IntegrityConfig EventActionIntegrityCheckConfig `json:"integrity_config"`
+	// message publish configuration
+	PublishConfig EventActionPublishConfig `json:"publish_config"`
+	// summary report configuration
+	SummaryReportConfig EventActionSummaryReportConfig `json:"summary_report_config"`
 }
 
 func (o *BaseEventActionOptions) getACopy() BaseEventActionOptions {
 	o.SetEmptySecretsIfNil()
 	emailRecipients := make([]string, len(o.EmailConfig.Recipients))
 	copy(emailRecipients, o.EmailConfig.Recipients)
+	emailCc := make([]string, len(o.EmailConfig.Cc))
+	copy(emailCc, o.EmailConfig.Cc)
+	emailBcc := make([]string, len(o.EmailConfig.Bcc))
+	copy(emailBcc, o.EmailConfig.Bcc)
 	emailAttachments := make([]string, len(o.EmailConfig.Attachments))
 	copy(emailAttachments, o.EmailConfig.Attachments)
 	cmdArgs := make([]string, len(o.CmdConfig.Args))
@@ -803,6 +1382,9 @@ func (o *BaseEventActionOptions) getACopy() BaseEventActionOptions {
 			QueryParameters: cloneKeyValues(o.HTTPConfig.QueryParameters),
 			Body:            o.HTTPConfig.Body,
 			Parts:           httpParts,
+			Transform:       o.HTTPConfig.Transform,
+			SigningSecret:   o.HTTPConfig.SigningSecret.Clone(),
+			Retries:         o.HTTPConfig.Retries,
 		},
 		CmdConfig: EventActionCommandConfig{
 			Cmd:     o.CmdConfig.Cmd,
@@ -812,6 +1394,11 @@ func (o *BaseEventActionOptions) getACopy() BaseEventActionOptions {
 		},
 		EmailConfig: EventActionEmailConfig{
 			Recipients:  emailRecipients,
+			Cc:          emailCc,
+			Bcc:         emailBcc,
+			ReplyTo:     o.EmailConfig.ReplyTo,
+			Template:    o.EmailConfig.Template,
+			Language:    o.EmailConfig.Language,
 			Subject:     o.EmailConfig.Subject,
 			Body:        o.EmailConfig.Body,
 			Attachments: emailAttachments,
@@ -819,7 +1406,11 @@ func (o *BaseEventActionOptions) getACopy() BaseEventActionOptions {
 		RetentionConfig: EventActionDataRetentionConfig{
 			Folders: folders,
 		},
-		FsConfig: o.FsConfig.getACopy(),
+		FsConfig:            o.FsConfig.getACopy(),
+		TransitionConfig:    o.TransitionConfig.getACopy(),
+		IntegrityConfig:     o.IntegrityConfig.getACopy(),
+		PublishConfig:       o.PublishConfig.getACopy(),
+		SummaryReportConfig: o.SummaryReportConfig.getACopy(),
 	}
 }
 
@@ -828,18 +1419,36 @@ func (o *BaseEventActionOptions) SetEmptySecretsIfNil() {
 	if o.HTTPConfig.Password == nil {
 		o.HTTPConfig.Password = kms.NewEmptySecret()
 	}
+	if o.HTTPConfig.SigningSecret == nil {
+		o.HTTPConfig.SigningSecret = kms.NewEmptySecret()
+	}
+	if o.PublishConfig.Password == nil {
+		o.PublishConfig.Password = kms.NewEmptySecret()
+	}
 }
 
 func (o *BaseEventActionOptions) setNilSecretsIfEmpty() {
 	if o.HTTPConfig.Password != nil && o.HTTPConfig.Password.IsEmpty() {
 		o.HTTPConfig.Password = nil
 	}
+	if o.HTTPConfig.SigningSecret != nil && o.HTTPConfig.SigningSecret.IsEmpty() {
+		o.HTTPConfig.SigningSecret = nil
+	}
+	if o.PublishConfig.Password != nil && o.PublishConfig.Password.IsEmpty() {
+		o.PublishConfig.Password = nil
+	}
 }
 
 func (o *BaseEventActionOptions) hideConfidentialData() {
 	if o.HTTPConfig.Password != nil {
 		o.HTTPConfig.Password.Hide()
 	}
+	if o.HTTPConfig.SigningSecret != nil {
+		o.HTTPConfig.SigningSecret.Hide()
+	}
+	if o.PublishConfig.Password != nil {
+		o.PublishConfig.Password.Hide()
+	}
 }
 
 func (o *BaseEventActionOptions) validate(action int, name string) error {
@@ -850,37 +1459,101 @@ func (o *BaseEventActionOptions) validate(action int, name string) error {
 		o.EmailConfig = EventActionEmailConfig{}
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
+		o.TransitionConfig = EventActionStorageTransitionConfig{}
+		o.IntegrityConfig = EventActionIntegrityCheckConfig{}
+		o.PublishConfig = EventActionPublishConfig{}
+		o.SummaryReportConfig = EventActionSummaryReportConfig{}
 		return o.HTTPConfig.validate(name)
 	case ActionTypeCommand:
 		o.HTTPConfig = EventActionHTTPConfig{}
 		o.EmailConfig = EventActionEmailConfig{}
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
+		o.TransitionConfig = EventActionStorageTransitionConfig{}
+		o.IntegrityConfig = EventActionIntegrityCheckConfig{}
+		o.PublishConfig = EventActionPublishConfig{}
+		o.SummaryReportConfig = EventActionSummaryReportConfig{}
 		return o.CmdConfig.validate()
 	case ActionTypeEmail:
 		o.HTTPConfig = EventActionHTTPConfig{}
 		o.CmdConfig = EventActionCommandConfig{}
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
+		o.TransitionConfig = EventActionStorageTransitionConfig{}
+		o.IntegrityConfig = EventActionIntegrityCheckConfig{}
+		o.PublishConfig = EventActionPublishConfig{}
+		o.SummaryReportConfig = EventActionSummaryReportConfig{}
 		return o.EmailConfig.validate()
 	case ActionTypeDataRetentionCheck:
 		o.HTTPConfig = EventActionHTTPConfig{}
 		o.CmdConfig = EventActionCommandConfig{}
 		o.EmailConfig = EventActionEmailConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
+		o.TransitionConfig = EventActionStorageTransitionConfig{}
+		o.IntegrityConfig = EventActionIntegrityCheckConfig{}
+		o.PublishConfig = EventActionPublishConfig{}
+		o.SummaryReportConfig = EventActionSummaryReportConfig{}
 		return o.RetentionConfig.validate()
 	case ActionTypeFilesystem:
 		o.HTTPConfig = EventActionHTTPConfig{}
 		o.CmdConfig = EventActionCommandConfig{}
 		o.EmailConfig = EventActionEmailConfig{}
 		o.RetentionConfig = EventActionDataRetentionConfig{}
+		o.TransitionConfig = EventActionStorageTransitionConfig{}
+		o.IntegrityConfig = EventActionIntegrityCheckConfig{}
+		o.PublishConfig = EventActionPublishConfig{}
+		o.SummaryReportConfig = EventActionSummaryReportConfig{}
 		return o.FsConfig.validate()
+	case ActionTypeStorageTransition:
+		o.HTTPConfig = EventActionHTTPConfig{}
+		o.CmdConfig = EventActionCommandConfig{}
+		o.EmailConfig = EventActionEmailConfig{}
+		o.RetentionConfig = EventActionDataRetentionConfig{}
+		o.FsConfig = EventActionFilesystemConfig{}
+		o.IntegrityConfig = EventActionIntegrityCheckConfig{}
+		o.PublishConfig = EventActionPublishConfig{}
+		o.SummaryReportConfig = EventActionSummaryReportConfig{}
+		return o.TransitionConfig.validate()
+	case ActionTypeIntegrityCheck:
+		o.HTTPConfig = EventActionHTTPConfig{}
+		o.CmdConfig = EventActionCommandConfig{}
+		o.EmailConfig = EventActionEmailConfig{}
+		o.RetentionConfig = EventActionDataRetentionConfig{}
+		o.FsConfig = EventActionFilesystemConfig{}
+		o.TransitionConfig = EventActionStorageTransitionConfig{}
+		o.PublishConfig = EventActionPublishConfig{}
+		o.SummaryReportConfig = EventActionSummaryReportConfig{}
+		return o.IntegrityConfig.validate()
+	case ActionTypePublish:
+		o.HTTPConfig = EventActionHTTPConfig{}
+		o.CmdConfig = EventActionCommandConfig{}
+		o.EmailConfig = EventActionEmailConfig{}
+		o.RetentionConfig = EventActionDataRetentionConfig{}
+		o.FsConfig = EventActionFilesystemConfig{}
+		o.TransitionConfig = EventActionStorageTransitionConfig{}
+		o.IntegrityConfig = EventActionIntegrityCheckConfig{}
+		o.SummaryReportConfig = EventActionSummaryReportConfig{}
+		return o.PublishConfig.validate(name)
+	case ActionTypeSummaryReport:
+		o.HTTPConfig = EventActionHTTPConfig{}
+		o.CmdConfig = EventActionCommandConfig{}
+		o.EmailConfig = EventActionEmailConfig{}
+		o.RetentionConfig = EventActionDataRetentionConfig{}
+		o.FsConfig = EventActionFilesystemConfig{}
+		o.TransitionConfig = EventActionStorageTransitionConfig{}
+		o.IntegrityConfig = EventActionIntegrityCheckConfig{}
+		o.PublishConfig = EventActionPublishConfig{}
+		return o.SummaryReportConfig.validate()
 	default:
 		o.HTTPConfig = EventActionHTTPConfig{}
 		o.CmdConfig = EventActionCommandConfig{}
 		o.EmailConfig = EventActionEmailConfig{}
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
+		o.TransitionConfig = EventActionStorageTransitionConfig{}
+		o.IntegrityConfig = EventActionIntegrityCheckConfig{}
+		o.PublishConfig = EventActionPublishConfig{}
+		o.SummaryReportConfig = EventActionSummaryReportConfig{}
 	}
 	return nil
 }
@@ -957,11 +1630,105 @@ func (a *BaseEventAction) validate() error {
 	return a.Options.validate(a.Type, a.Name)
 }
 
+// Supported operators for action conditions
+const (
+	ActionConditionOperatorEqual          = "eq"
+	ActionConditionOperatorNotEqual       = "ne"
+	ActionConditionOperatorGreater        = "gt"
+	ActionConditionOperatorGreaterOrEqual = "ge"
+	ActionConditionOperatorLess           = "lt"
+	ActionConditionOperatorLessOrEqual    = "le"
+	ActionConditionOperatorMatch          = "match"
+	ActionConditionOperatorNotMatch       = "not_match"
+)
+
+var (
+	// SupportedActionConditionOperators defines the supported operators for action conditions
+	SupportedActionConditionOperators = []string{ActionConditionOperatorEqual, ActionConditionOperatorNotEqual,
+		ActionConditionOperatorGreater, ActionConditionOperatorGreaterOrEqual, ActionConditionOperatorLess,
+		ActionConditionOperatorLessOrEqual, ActionConditionOperatorMatch, ActionConditionOperatorNotMatch}
+	numericActionConditionOperators = []string{ActionConditionOperatorGreater, ActionConditionOperatorGreaterOrEqual,
+		ActionConditionOperatorLess, ActionConditionOperatorLessOrEqual}
+)
+
+// ActionCondition defines a condition to check before executing an action.
+// Value supports placeholders, for example {{FileSize}}, and the output
+// variables of the previously executed actions, for example
+// {{ActionStatus.<action name>}} or {{ActionOutput.<action name>}}
+type ActionCondition struct {
+	Value    string `json:"value"`
+	Operator string `json:"operator"`
+	Operand  string `json:"operand"`
+}
+
+// IsNumeric returns true if the operator compares numbers
+func (c *ActionCondition) IsNumeric() bool {
+	return util.Contains(numericActionConditionOperators, c.Operator)
+}
+
+func (c *ActionCondition) validate() error {
+	c.Value = strings.TrimSpace(c.Value)
+	if c.Value == "" {
+		return util.NewValidationError("action condition value is required")
+	}
+	if !util.Contains(SupportedActionConditionOperators, c.Operator) {
+		return util.NewValidationError(fmt.Sprintf("invalid action condition operator %q", c.Operator))
+	}
+	if c.IsNumeric() {
+		if _, err := strconv.ParseFloat(c.Operand, 64); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid action condition operand %q, a number is required "+
+				"for the operator %q", c.Operand, c.Operator))
+		}
+	}
+	if c.Operator == ActionConditionOperatorMatch || c.Operator == ActionConditionOperatorNotMatch {
+		if _, err := path.Match(c.Operand, "abc"); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid action condition pattern %q", c.Operand))
+		}
+	}
+	return nil
+}
+
+// String returns the condition in the format "<value> <operator> <operand>"
+func (c *ActionCondition) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", c.Value, c.Operator, c.Operand))
+}
+
+// ParseActionCondition parses a condition in the format "<value> <operator> <operand>",
+// the operator is the first word that matches a supported operator
+func ParseActionCondition(val string) (ActionCondition, error) {
+	fields := strings.Fields(val)
+	for idx := 1; idx < len(fields); idx++ {
+		if util.Contains(SupportedActionConditionOperators, fields[idx]) {
+			return ActionCondition{
+				Value:    strings.Join(fields[:idx], " "),
+				Operator: fields[idx],
+				Operand:  strings.Join(fields[idx+1:], " "),
+			}, nil
+		}
+	}
+	return ActionCondition{}, util.NewValidationError(fmt.Sprintf("invalid action condition %q", val))
+}
+
 // EventActionOptions defines the supported configuration options for an event action
 type EventActionOptions struct {
 	IsFailureAction bool `json:"is_failure_action"`
-	StopOnFailure   bool `json:"stop_on_failure"`
-	ExecuteSync     bool `json:"execute_sync"`
+	// StopOnFailure defines the policy to apply if the action fails:
+	// true means that the next actions are not executed, false means that
+	// the execution continues with the next action
+	StopOnFailure bool `json:"stop_on_failure"`
+	ExecuteSync   bool `json:"execute_sync"`
+	// Conditions to check before executing the action, all the conditions
+	// must match. If they don't match the action is skipped
+	Conditions []ActionCondition `json:"conditions,omitempty"`
+}
+
+// GetConditionsAsString returns the conditions as a semicolon separated string
+func (o *EventActionOptions) GetConditionsAsString() string {
+	conditions := make([]string, 0, len(o.Conditions))
+	for idx := range o.Conditions {
+		conditions = append(conditions, o.Conditions[idx].String())
+	}
+	return strings.Join(conditions, "; ")
 }
 
 // EventAction defines an event action
@@ -973,6 +1740,11 @@ type EventAction struct {
 }
 
 func (a *EventAction) getACopy() EventAction {
+	var conditions []ActionCondition
+	if len(a.Options.Conditions) > 0 {
+		conditions = make([]ActionCondition, len(a.Options.Conditions))
+		copy(conditions, a.Options.Conditions)
+	}
 	return EventAction{
 		BaseEventAction: a.BaseEventAction.getACopy(),
 		Order:           a.Order,
@@ -980,11 +1752,17 @@ func (a *EventAction) getACopy() EventAction {
 			IsFailureAction: a.Options.IsFailureAction,
 			StopOnFailure:   a.Options.StopOnFailure,
 			ExecuteSync:     a.Options.ExecuteSync,
+			Conditions:      conditions,
 		},
 	}
 }
 
 func (a *EventAction) validateAssociation(trigger int, fsEvents []string) error {
+	for idx := range a.Options.Conditions {
+		if err := a.Options.Conditions[idx].validate(); err != nil {
+			return err
+		}
+	}
 	if a.Options.IsFailureAction {
 		if a.Options.ExecuteSync {
 			return util.NewValidationError("sync execution is not supported for failure actions")
@@ -1027,8 +1805,13 @@ type ConditionOptions struct {
 	ProviderObjects []string           `json:"provider_objects,omitempty"`
 	MinFileSize     int64              `json:"min_size,omitempty"`
 	MaxFileSize     int64              `json:"max_size,omitempty"`
+	// ISO 3166-1 alpha-2 country codes for the client IP address, resolved
+	// using the GeoIP database
+	Countries []string `json:"countries,omitempty"`
 	// allow to execute scheduled tasks concurrently from multiple instances
 	ConcurrentExecution bool `json:"concurrent_execution,omitempty"`
+	// Rollout allows to activate the rule for a subset of cluster nodes or users
+	Rollout Rollout `json:"rollout"`
 }
 
 func (f *ConditionOptions) getACopy() ConditionOptions {
@@ -1036,6 +1819,8 @@ func (f *ConditionOptions) getACopy() ConditionOptions {
 	copy(protocols, f.Protocols)
 	providerObjects := make([]string, len(f.ProviderObjects))
 	copy(providerObjects, f.ProviderObjects)
+	countries := make([]string, len(f.Countries))
+	copy(countries, f.Countries)
 
 	return ConditionOptions{
 		Names:               cloneConditionPatterns(f.Names),
@@ -1045,7 +1830,9 @@ func (f *ConditionOptions) getACopy() ConditionOptions {
 		ProviderObjects:     providerObjects,
 		MinFileSize:         f.MinFileSize,
 		MaxFileSize:         f.MaxFileSize,
+		Countries:           countries,
 		ConcurrentExecution: f.ConcurrentExecution,
+		Rollout:             f.Rollout.getACopy(),
 	}
 }
 
@@ -1081,6 +1868,14 @@ func (f *ConditionOptions) validate() error {
 				util.ByteCountSI(f.MaxFileSize), util.ByteCountSI(f.MinFileSize)))
 		}
 	}
+	countries, err := util.NormalizeCountryCodes(f.Countries)
+	if err != nil {
+		return util.NewValidationError(fmt.Sprintf("invalid condition countries: %v", err))
+	}
+	f.Countries = countries
+	if err := f.Rollout.Validate(); err != nil {
+		return err
+	}
 	if config.IsShared == 0 {
 		f.ConcurrentExecution = false
 	}
@@ -1187,7 +1982,16 @@ func (c *EventConditions) validate(trigger int) error {
 				return err
 			}
 		}
-	case EventTriggerIPBlocked, EventTriggerCertificate:
+	case EventTriggerOnDemand:
+		c.FsEvents = nil
+		c.ProviderEvents = nil
+		c.Options.FsPaths = nil
+		c.Options.Protocols = nil
+		c.Options.MinFileSize = 0
+		c.Options.MaxFileSize = 0
+		c.Options.ProviderObjects = nil
+		c.Schedules = nil
+	case EventTriggerIPBlocked, EventTriggerCertificate, EventTriggerResourceLimits:
 		c.FsEvents = nil
 		c.ProviderEvents = nil
 		c.Options.Names = nil
@@ -1261,6 +2065,19 @@ func (r *EventRule) GuardFromConcurrentExecution() bool {
 	return !r.Conditions.Options.ConcurrentExecution
 }
 
+// IsActiveFor returns true if the rule is active, on the current node, for the
+// specified user. An empty username means events not related to users, for
+// these events the rollout percentage applies to the cluster nodes
+func (r *EventRule) IsActiveFor(username string) bool {
+	return r.Conditions.Options.Rollout.IsActive(r.Name, username)
+}
+
+// GetRolloutStatus returns the rollout status of the rule for the cluster nodes
+func (r *EventRule) GetRolloutStatus() RolloutStatus {
+	nodesPercentage := r.Trigger != EventTriggerFsEvent && r.Trigger != EventTriggerProviderEvent
+	return r.Conditions.Options.Rollout.GetStatus(r.Name, nodesPercentage)
+}
+
 // GetTriggerAsString returns the rule trigger as string
 func (r *EventRule) GetTriggerAsString() string {
 	return getTriggerTypeAsString(r.Trigger)
@@ -1319,7 +2136,8 @@ func (r *EventRule) validate() error {
 
 func (r *EventRule) checkIPBlockedAndCertificateActions() error {
 	unavailableActions := []int{ActionTypeUserQuotaReset, ActionTypeFolderQuotaReset, ActionTypeTransferQuotaReset,
-		ActionTypeDataRetentionCheck, ActionTypeMetadataCheck, ActionTypeFilesystem}
+		ActionTypeDataRetentionCheck, ActionTypeMetadataCheck, ActionTypeFilesystem, ActionTypeStorageTransition,
+		ActionTypeIntegrityCheck}
 	for _, action := range r.Actions {
 		if util.Contains(unavailableActions, action.Type) {
 			return fmt.Errorf("action %q, type %q is not supported for event trigger %q",
@@ -1330,11 +2148,12 @@ func (r *EventRule) checkIPBlockedAndCertificateActions() error {
 }
 
 func (r *EventRule) checkProviderEventActions(providerObjectType string) error {
-	// user quota reset, transfer quota reset, data retention check and filesystem actions
-	// can be executed only if we modify a user. They will be executed for the
+	// user quota reset, transfer quota reset, data retention check, storage class transition,
+	// integrity check and filesystem actions can be executed only if we modify a user. They will be executed for the
 	// affected user. Folder quota reset can be executed only for folders.
 	userSpecificActions := []int{ActionTypeUserQuotaReset, ActionTypeTransferQuotaReset,
-		ActionTypeDataRetentionCheck, ActionTypeMetadataCheck, ActionTypeFilesystem}
+		ActionTypeDataRetentionCheck, ActionTypeMetadataCheck, ActionTypeFilesystem, ActionTypeStorageTransition,
+		ActionTypeIntegrityCheck}
 	for _, action := range r.Actions {
 		if util.Contains(userSpecificActions, action.Type) && providerObjectType != actionObjectUser {
 			return fmt.Errorf("action %q, type %q is only supported for provider user events",
@@ -1354,6 +2173,9 @@ func (r *EventRule) hasUserAssociated(providerObjectType string) bool {
 		return providerObjectType == actionObjectUser
 	case EventTriggerFsEvent:
 		return true
+	case EventTriggerOnDemand:
+		// on demand rules can be executed for a specific user
+		return providerObjectType == actionObjectUser
 	}
 	return false
 }
@@ -1373,7 +2195,7 @@ func (r *EventRule) CheckActionsConsistency(providerObjectType string) error {
 					action.Name, getActionTypeAsString(action.Type))
 			}
 		}
-	case EventTriggerIPBlocked, EventTriggerCertificate:
+	case EventTriggerIPBlocked, EventTriggerCertificate, EventTriggerResourceLimits:
 		if err := r.checkIPBlockedAndCertificateActions(); err != nil {
 			return err
 		}
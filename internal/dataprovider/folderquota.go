@@ -0,0 +1,173 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// UserFolderQuota defines the quota limits and usage for a user within a
+// virtual folder. The per-user limits are independent from the quota shared
+// among all the users mapped to the folder
+type UserFolderQuota struct {
+	// Folder name
+	FolderName string `json:"folder_name"`
+	// Virtual path the folder is mapped to
+	VirtualPath string `json:"virtual_path,omitempty"`
+	// Maximum size allowed for the user, as bytes. 0 means unlimited
+	QuotaSize int64 `json:"quota_size"`
+	// Maximum number of files allowed for the user. 0 means unlimited
+	QuotaFiles int `json:"quota_files"`
+	// Size used by the user, as bytes
+	UsedQuotaSize int64 `json:"used_quota_size"`
+	// Number of files uploaded by the user
+	UsedQuotaFiles int `json:"used_quota_files"`
+	// Last usage update as unix timestamp in milliseconds
+	LastQuotaUpdate int64 `json:"last_quota_update"`
+}
+
+// userFoldersQuota defines how the per-user folders usage is stored within
+// the key/value and memory providers, one record for each user
+type userFoldersQuota struct {
+	Username string            `json:"username"`
+	Folders  []UserFolderQuota `json:"folders"`
+}
+
+func (q *userFoldersQuota) update(folderName string, filesAdd int, sizeAdd int64, reset bool) {
+	idx := -1
+	for i := range q.Folders {
+		if q.Folders[i].FolderName == folderName {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		q.Folders = append(q.Folders, UserFolderQuota{FolderName: folderName})
+		idx = len(q.Folders) - 1
+	}
+	folder := &q.Folders[idx]
+	if reset {
+		folder.UsedQuotaSize = sizeAdd
+		folder.UsedQuotaFiles = filesAdd
+	} else {
+		folder.UsedQuotaSize += sizeAdd
+		folder.UsedQuotaFiles += filesAdd
+	}
+	folder.LastQuotaUpdate = util.GetTimeAsMsSinceEpoch(time.Now())
+}
+
+// removeFolder removes the usage for the specified folder and returns true
+// if it was found
+func (q *userFoldersQuota) removeFolder(folderName string) bool {
+	for idx := range q.Folders {
+		if q.Folders[idx].FolderName == folderName {
+			q.Folders = append(q.Folders[:idx], q.Folders[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateUserFolderQuota updates the quota used by the specified user within the
+// given virtual folder. If reset is true filesAdd and sizeAdd indicates the
+// actual usage instead of the difference
+func UpdateUserFolderQuota(username, folderName string, filesAdd int, sizeAdd int64, reset bool) error {
+	if config.TrackQuota == 0 {
+		return util.NewMethodDisabledError(trackQuotaDisabledError)
+	}
+	if filesAdd == 0 && sizeAdd == 0 && !reset {
+		return nil
+	}
+	return provider.updateUserFolderQuota(username, folderName, filesAdd, sizeAdd, reset)
+}
+
+// UpdateVirtualFolderQuotaForUser updates the quota shared among the users
+// mapped to the given virtual folder and, if the folder mapping defines per-user
+// limits, the quota used by the specified user within the folder
+func UpdateVirtualFolderQuotaForUser(vfolder *vfs.VirtualFolder, username string, filesAdd int, sizeAdd int64) error {
+	err := UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, filesAdd, sizeAdd, false)
+	if !vfolder.HasUserQuotaRestrictions() {
+		return err
+	}
+	if errUser := UpdateUserFolderQuota(username, vfolder.Name, filesAdd, sizeAdd, false); errUser != nil {
+		providerLog(logger.LevelError, "unable to update the quota for user %q, folder %q: %v", username, vfolder.Name,
+			errUser)
+		if err == nil {
+			err = errUser
+		}
+	}
+	return err
+}
+
+// GetUsedUserFolderQuota returns the quota used by the specified user within
+// the given virtual folder
+func GetUsedUserFolderQuota(username, folderName string) (int, int64, error) {
+	if config.TrackQuota == 0 {
+		return 0, 0, util.NewMethodDisabledError(trackQuotaDisabledError)
+	}
+	usage, err := provider.getUserFoldersQuota(username)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, q := range usage {
+		if q.FolderName == folderName {
+			return q.UsedQuotaFiles, q.UsedQuotaSize, nil
+		}
+	}
+	return 0, 0, nil
+}
+
+// GetUserFoldersQuota returns the per-user quota limits and usage for the virtual
+// folders mapped to the given user. Only the folders with per-user limits are
+// returned. The user must include the settings inherited from groups
+func GetUserFoldersQuota(user *User) ([]UserFolderQuota, error) {
+	result := make([]UserFolderQuota, 0)
+	if config.TrackQuota == 0 {
+		return result, util.NewMethodDisabledError(trackQuotaDisabledError)
+	}
+	for idx := range user.VirtualFolders {
+		folder := &user.VirtualFolders[idx]
+		if folder.HasUserQuotaRestrictions() {
+			result = append(result, UserFolderQuota{
+				FolderName:  folder.Name,
+				VirtualPath: folder.VirtualPath,
+				QuotaSize:   folder.UserQuotaSize,
+				QuotaFiles:  folder.UserQuotaFiles,
+			})
+		}
+	}
+	if len(result) == 0 {
+		return result, nil
+	}
+	usage, err := provider.getUserFoldersQuota(user.Username)
+	if err != nil {
+		return result, err
+	}
+	for idx := range result {
+		for _, q := range usage {
+			if q.FolderName == result[idx].FolderName {
+				result[idx].UsedQuotaSize = q.UsedQuotaSize
+				result[idx].UsedQuotaFiles = q.UsedQuotaFiles
+				result[idx].LastQuotaUpdate = q.LastQuotaUpdate
+				break
+			}
+		}
+	}
+	return result, nil
+}
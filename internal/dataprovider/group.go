@@ -33,6 +33,22 @@ type GroupUserSettings struct {
 	sdk.BaseGroupUserSettings
 	// Filesystem configuration details
 	FsConfig vfs.Filesystem `json:"filesystem"`
+	// External IP address or hostname to advertise for FTP passive data connections
+	FTPPassiveHost string `json:"ftp_passive_host,omitempty"`
+	// Allow MODE Z compression for FTP transfers
+	FTPModeZ bool `json:"ftp_mode_z,omitempty"`
+	// Name of the password policy to apply to users without a specific policy
+	PasswordPolicy string `json:"password_policy,omitempty"`
+	// ISO 3166-1 alpha-2 country codes allowed/denied to login, they are
+	// added to the ones defined for the member users
+	AllowedCountries []string `json:"allowed_countries,omitempty"`
+	DeniedCountries  []string `json:"denied_countries,omitempty"`
+	// Time windows with specific bandwidth limits, they are added to the ones
+	// defined for the member users
+	BandwidthSchedules []BandwidthSchedule `json:"bandwidth_schedules,omitempty"`
+	// Policy to automatically reset the used data transfer for the users
+	// without a specific policy
+	TransferQuotaReset *TransferQuotaReset `json:"transfer_quota_reset,omitempty"`
 }
 
 // Group defines an SFTPGo group.
@@ -43,6 +59,8 @@ type Group struct {
 	UserSettings GroupUserSettings `json:"user_settings,omitempty"`
 	// Mapping between virtual paths and virtual folders
 	VirtualFolders []vfs.VirtualFolder `json:"virtual_folders,omitempty"`
+	// Custom key/value metadata, for example external system IDs or billing codes
+	Metadata util.Metadata `json:"metadata,omitempty"`
 }
 
 // GetPermissions returns the permissions as list
@@ -115,6 +133,11 @@ func (g *Group) RenderAsJSON(reload bool) ([]byte, error) {
 	return json.Marshal(g)
 }
 
+// GetMetadata returns the custom metadata for this group
+func (g *Group) GetMetadata() util.Metadata {
+	return g.Metadata
+}
+
 // GetEncryptionAdditionalData returns the additional data to use for AEAD
 func (g *Group) GetEncryptionAdditionalData() string {
 	return fmt.Sprintf("group_%v", g.Name)
@@ -148,6 +171,9 @@ func (g *Group) validate() error {
 		return err
 	}
 	g.VirtualFolders = vfolders
+	if err := g.Metadata.Validate(); err != nil {
+		return err
+	}
 	return g.validateUserSettings()
 }
 
@@ -159,6 +185,9 @@ func (g *Group) validateUserSettings() error {
 				g.UserSettings.HomeDir))
 		}
 	}
+	if g.UserSettings.FsConfig.Provider == sdk.SFTPFilesystemProvider && g.UserSettings.FsConfig.SFTPConfig.UserCredentials {
+		return util.NewValidationError("per-user remote credentials are supported for virtual folders only")
+	}
 	if err := g.UserSettings.FsConfig.Validate(g.GetEncryptionAdditionalData()); err != nil {
 		return err
 	}
@@ -177,6 +206,21 @@ func (g *Group) validateUserSettings() error {
 	if err := validateBaseFilters(&g.UserSettings.Filters); err != nil {
 		return err
 	}
+	if err := validateFTPPassiveHost(&g.UserSettings.FTPPassiveHost); err != nil {
+		return err
+	}
+	if err := validatePasswordPolicyName(&g.UserSettings.PasswordPolicy); err != nil {
+		return err
+	}
+	if err := validateCountryCodes(&g.UserSettings.AllowedCountries, &g.UserSettings.DeniedCountries); err != nil {
+		return err
+	}
+	if err := validateBandwidthSchedules(g.UserSettings.BandwidthSchedules); err != nil {
+		return err
+	}
+	if err := g.UserSettings.TransferQuotaReset.validate(); err != nil {
+		return err
+	}
 	if !g.HasExternalAuth() {
 		g.UserSettings.Filters.ExternalAuthCacheTime = 0
 	}
@@ -200,6 +244,10 @@ func (g *Group) getACopy() Group {
 		copy(perms, v)
 		permissions[k] = perms
 	}
+	allowedCountries := make([]string, len(g.UserSettings.AllowedCountries))
+	copy(allowedCountries, g.UserSettings.AllowedCountries)
+	deniedCountries := make([]string, len(g.UserSettings.DeniedCountries))
+	copy(deniedCountries, g.UserSettings.DeniedCountries)
 
 	return Group{
 		BaseGroup: sdk.BaseGroup{
@@ -225,9 +273,17 @@ func (g *Group) getACopy() Group {
 				TotalDataTransfer:    g.UserSettings.TotalDataTransfer,
 				Filters:              copyBaseUserFilters(g.UserSettings.Filters),
 			},
-			FsConfig: g.UserSettings.FsConfig.GetACopy(),
+			FsConfig:           g.UserSettings.FsConfig.GetACopy(),
+			FTPPassiveHost:     g.UserSettings.FTPPassiveHost,
+			FTPModeZ:           g.UserSettings.FTPModeZ,
+			PasswordPolicy:     g.UserSettings.PasswordPolicy,
+			AllowedCountries:   allowedCountries,
+			DeniedCountries:    deniedCountries,
+			BandwidthSchedules: copyBandwidthSchedules(g.UserSettings.BandwidthSchedules),
+			TransferQuotaReset: g.UserSettings.TransferQuotaReset.getACopy(),
 		},
 		VirtualFolders: virtualFolders,
+		Metadata:       g.Metadata.GetACopy(),
 	}
 }
 
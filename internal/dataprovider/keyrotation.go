@@ -0,0 +1,248 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// object type for the runtime SSH host keys stored as shared session
+const keyRotationObjectHostKeys = "host_keys"
+
+// KeyRotationProgress defines the outcome of the key rotation for a single object
+type KeyRotationProgress struct {
+	// Object type: user, group, folder, admin, event_action or host_keys
+	ObjectType string
+	Name       string
+	// Number of re-encrypted secrets
+	Rotated int
+	Err     error
+}
+
+// KeyRotationResult defines the outcome of the key rotation
+type KeyRotationResult struct {
+	// Number of updated objects
+	Updated int
+	// Number of re-encrypted secrets
+	Rotated int
+	// Objects that cannot be updated
+	Failed []KeyRotationProgress
+}
+
+func (r *KeyRotationResult) add(progress KeyRotationProgress) {
+	if progress.Err != nil {
+		r.Failed = append(r.Failed, progress)
+		return
+	}
+	if progress.Rotated > 0 {
+		r.Updated++
+		r.Rotated += progress.Rotated
+	}
+}
+
+// RotateKMSSecrets re-encrypts, using the current KMS configuration, the
+// secrets stored for users, groups, folders, admins, event actions and the
+// runtime SSH host keys and encrypted using the configuration of the given rotator. A failure for an
+// object does not stop the rotation. The progress function, if not nil, is
+// called for each object. If dryRun is true the objects are not updated.
+// This function is meant to be used while SFTPGo is not running
+func RotateKMSSecrets(rotator *kms.KeyRotator, dryRun bool, progress func(KeyRotationProgress)) (KeyRotationResult, error) {
+	var result KeyRotationResult
+
+	done := func(p KeyRotationProgress) {
+		if p.Err != nil {
+			providerLog(logger.LevelError, "unable to rotate the KMS secrets for %s %q: %v", p.ObjectType, p.Name, p.Err)
+		}
+		result.add(p)
+		if progress != nil {
+			progress(p)
+		}
+	}
+	report := func(objectType, name string, secrets []*kms.Secret, update func() error) {
+		p := KeyRotationProgress{
+			ObjectType: objectType,
+			Name:       name,
+		}
+		for _, secret := range secrets {
+			rotated, err := rotator.Rotate(secret)
+			if err != nil {
+				p.Err = fmt.Errorf("unable to rotate secret: %w", err)
+				break
+			}
+			if rotated {
+				p.Rotated++
+			}
+		}
+		if p.Err == nil && p.Rotated > 0 && !dryRun {
+			p.Err = update()
+		}
+		done(p)
+	}
+
+	users, err := provider.dumpUsers()
+	if err != nil {
+		return result, fmt.Errorf("unable to get users: %w", err)
+	}
+	for idx := range users {
+		user := &users[idx]
+		// folders are updated together with the user
+		secrets := user.getSecrets()
+		for fIdx := range user.VirtualFolders {
+			secrets = append(secrets, user.VirtualFolders[fIdx].FsConfig.GetSecrets()...)
+		}
+		report(actionObjectUser, user.Username, secrets, func() error {
+			return provider.updateUser(user)
+		})
+	}
+	groups, err := provider.dumpGroups()
+	if err != nil {
+		return result, fmt.Errorf("unable to get groups: %w", err)
+	}
+	for idx := range groups {
+		group := &groups[idx]
+		secrets := group.UserSettings.FsConfig.GetSecrets()
+		for fIdx := range group.VirtualFolders {
+			secrets = append(secrets, group.VirtualFolders[fIdx].FsConfig.GetSecrets()...)
+		}
+		report(actionObjectGroup, group.Name, secrets, func() error {
+			return provider.updateGroup(group)
+		})
+	}
+	folders, err := provider.dumpFolders()
+	if err != nil {
+		return result, fmt.Errorf("unable to get folders: %w", err)
+	}
+	for idx := range folders {
+		folder := &folders[idx]
+		report(actionObjectFolder, folder.Name, folder.FsConfig.GetSecrets(), func() error {
+			return provider.updateFolder(folder)
+		})
+	}
+	admins, err := provider.dumpAdmins()
+	if err != nil {
+		return result, fmt.Errorf("unable to get admins: %w", err)
+	}
+	for idx := range admins {
+		admin := &admins[idx]
+		report(actionObjectAdmin, admin.Username, admin.getSecrets(), func() error {
+			return provider.updateAdmin(admin)
+		})
+	}
+	actions, err := provider.dumpEventActions()
+	if err != nil {
+		return result, fmt.Errorf("unable to get event actions: %w", err)
+	}
+	for idx := range actions {
+		action := &actions[idx]
+		report(actionObjectEventAction, action.Name, action.Options.getSecrets(), func() error {
+			return provider.updateEventAction(action)
+		})
+	}
+	// the data providers not supporting shared sessions keep the runtime host
+	// keys in memory only
+	sessions, err := provider.getSharedSessions(SessionTypeHostKeys, 0)
+	if err != nil && !errors.Is(err, ErrNotImplemented) {
+		return result, fmt.Errorf("unable to get the runtime host keys: %w", err)
+	}
+	for idx := range sessions {
+		session := sessions[idx]
+		keys, secrets, err := getHostKeysSecrets(session)
+		if err != nil {
+			done(KeyRotationProgress{
+				ObjectType: keyRotationObjectHostKeys,
+				Name:       session.Key,
+				Err:        err,
+			})
+			continue
+		}
+		report(keyRotationObjectHostKeys, session.Key, secrets, func() error {
+			for i, secret := range secrets {
+				if _, ok := keys[i]["private_key"]; !ok {
+					continue
+				}
+				data, err := json.Marshal(secret)
+				if err != nil {
+					return err
+				}
+				keys[i]["private_key"] = data
+			}
+			session.Data = keys
+			session.Timestamp = util.GetTimeAsMsSinceEpoch(time.Now())
+			return provider.addSharedSession(session)
+		})
+	}
+	return result, nil
+}
+
+// getHostKeysSecrets parses the runtime SSH host keys stored by the SFTP
+// server and returns them together with their encrypted private keys.
+// The other fields are preserved as they are
+func getHostKeysSecrets(session Session) ([]map[string]json.RawMessage, []*kms.Secret, error) {
+	var data []byte
+	switch v := session.Data.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return nil, nil, fmt.Errorf("invalid host keys data type %T", session.Data)
+	}
+	var keys []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse the host keys: %w", err)
+	}
+	secrets := make([]*kms.Secret, 0, len(keys))
+	for _, key := range keys {
+		secret := kms.NewEmptySecret()
+		if payload, ok := key["private_key"]; ok {
+			if err := json.Unmarshal(payload, secret); err != nil {
+				return nil, nil, fmt.Errorf("unable to parse the host key secret: %w", err)
+			}
+		}
+		secrets = append(secrets, secret)
+	}
+	return keys, secrets, nil
+}
+
+func (u *User) getSecrets() []*kms.Secret {
+	secrets := u.FsConfig.GetSecrets()
+	secrets = append(secrets, u.Filters.TOTPConfig.Secret, u.Filters.S3SecretAccessKey)
+	for idx := range u.Filters.RecoveryCodes {
+		secrets = append(secrets, u.Filters.RecoveryCodes[idx].Secret)
+	}
+	for idx := range u.Filters.SFTPCredentials {
+		secrets = append(secrets, u.Filters.SFTPCredentials[idx].getSecrets()...)
+	}
+	return secrets
+}
+
+func (a *Admin) getSecrets() []*kms.Secret {
+	secrets := []*kms.Secret{a.Filters.TOTPConfig.Secret}
+	for idx := range a.Filters.RecoveryCodes {
+		secrets = append(secrets, a.Filters.RecoveryCodes[idx].Secret)
+	}
+	return secrets
+}
+
+func (o *BaseEventActionOptions) getSecrets() []*kms.Secret {
+	return []*kms.Secret{o.HTTPConfig.Password, o.HTTPConfig.SigningSecret, o.PublishConfig.Password}
+}
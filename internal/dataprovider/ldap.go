@@ -0,0 +1,408 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	errLDAPUnavailable   = errors.New("LDAP server unavailable")
+	ldapAttributeRegex   = regexp.MustCompile(`%ldap\.([a-zA-Z0-9;-]+)%`)
+	ldapCredentialsCache = newCredentialsCache()
+)
+
+// LDAPGroupMapping maps an LDAP group to an SFTPGo group
+type LDAPGroupMapping struct {
+	// LDAP group, it can be the group DN or its common name
+	LDAPGroup string `json:"ldap_group" mapstructure:"ldap_group"`
+	// Name of the SFTPGo group
+	Group string `json:"group" mapstructure:"group"`
+	// Group type: 1 primary, 2 secondary, 3 membership only
+	Type int `json:"type" mapstructure:"type"`
+}
+
+func (m *LDAPGroupMapping) matches(ldapGroup string) bool {
+	if strings.EqualFold(m.LDAPGroup, ldapGroup) {
+		return true
+	}
+	dn, err := ldap.ParseDN(ldapGroup)
+	if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return false
+	}
+	attr := dn.RDNs[0].Attributes[0]
+	return strings.EqualFold(attr.Type, "cn") && strings.EqualFold(m.LDAPGroup, attr.Value)
+}
+
+// LDAPConfig defines the configuration to authenticate users against an LDAP
+// server, for example Active Directory. Users authenticated using a password are
+// searched on the LDAP server and then verified binding as the found entry.
+// Authenticated users are automatically added or updated inside the data provider,
+// so they can also use the other authentication methods, for example public keys
+type LDAPConfig struct {
+	// LDAP server URL, for example "ldaps://ldap.example.com" or "ldap://ldap.example.com:389".
+	// Empty means disabled
+	URL string `json:"url" mapstructure:"url"`
+	// Upgrade ldap:// connections to TLS using the StartTLS operation
+	StartTLS bool `json:"start_tls" mapstructure:"start_tls"`
+	// Set to true to skip the TLS certificate verification, for testing only
+	SkipTLSVerify bool `json:"skip_tls_verify" mapstructure:"skip_tls_verify"`
+	// DN and password used to search the users. Empty means anonymous search
+	BindDN       string `json:"bind_dn" mapstructure:"bind_dn"`
+	BindPassword string `json:"bind_password" mapstructure:"bind_password"`
+	// Base DN for the users search
+	BaseDN string `json:"base_dn" mapstructure:"base_dn"`
+	// Filter to search the users, "%s" is replaced with the escaped username.
+	// For Active Directory you can use "(&(objectClass=user)(sAMAccountName=%s))"
+	UserFilter string `json:"user_filter" mapstructure:"user_filter"`
+	// Attribute listing the groups the user is a member of
+	GroupAttribute string `json:"group_attribute" mapstructure:"group_attribute"`
+	// Attribute with the user email, empty means the email is not synced
+	EmailAttribute string `json:"email_attribute" mapstructure:"email_attribute"`
+	// Template for the home directory. The "%username%" placeholder is replaced
+	// with the username and "%ldap.<attribute>%" placeholders with the LDAP
+	// attributes, for example "/srv/sftpgo/%ldap.department%/%username%".
+	// Empty means that the home directory is set from "users_base_dir" for new users
+	HomeDirTemplate string `json:"home_dir_template" mapstructure:"home_dir_template"`
+	// Permissions for the root directory granted to new users
+	Permissions []string `json:"permissions" mapstructure:"permissions"`
+	// Mappings between LDAP groups and SFTPGo groups. If configured, the SFTPGo
+	// groups are synced on each login
+	GroupMappings []LDAPGroupMapping `json:"group_mappings" mapstructure:"group_mappings"`
+	// Timeout for LDAP operations, in seconds
+	Timeout int `json:"timeout" mapstructure:"timeout"`
+	// If greater than 0, the credentials of the users successfully authenticated
+	// against the LDAP server are cached, in memory, for the specified number of
+	// seconds. When the LDAP server is unreachable, the users with cached
+	// credentials can still login using the local copy of their account.
+	// 0 means disabled
+	CacheTTL int `json:"cache_ttl" mapstructure:"cache_ttl"`
+}
+
+// IsEnabled returns true if LDAP authentication is configured
+func (c *LDAPConfig) IsEnabled() bool {
+	return c.URL != ""
+}
+
+func (c *LDAPConfig) validate() error {
+	if !c.IsEnabled() {
+		return nil
+	}
+	if !strings.HasPrefix(c.URL, "ldap://") && !strings.HasPrefix(c.URL, "ldaps://") {
+		return fmt.Errorf("invalid LDAP URL %q, the supported schemes are ldap and ldaps", c.URL)
+	}
+	if c.BaseDN == "" {
+		return errors.New("LDAP base DN is required")
+	}
+	if strings.Count(c.UserFilter, "%s") != 1 {
+		return fmt.Errorf("invalid LDAP user filter %q, it must contain exactly one %%s placeholder", c.UserFilter)
+	}
+	if _, err := ldap.CompileFilter(fmt.Sprintf(c.UserFilter, "username")); err != nil {
+		return fmt.Errorf("invalid LDAP user filter %q: %w", c.UserFilter, err)
+	}
+	if c.Timeout < 1 {
+		return fmt.Errorf("invalid LDAP timeout: %d", c.Timeout)
+	}
+	if c.CacheTTL < 0 {
+		return fmt.Errorf("invalid LDAP cache TTL: %d", c.CacheTTL)
+	}
+	if len(c.GroupMappings) > 0 && c.GroupAttribute == "" {
+		return errors.New("LDAP group attribute is required to map groups")
+	}
+	if _, err := validateUserPermissions(map[string][]string{"/": c.Permissions}); err != nil {
+		return fmt.Errorf("invalid LDAP permissions: %w", err)
+	}
+	for idx, m := range c.GroupMappings {
+		if m.LDAPGroup == "" || m.Group == "" {
+			return fmt.Errorf("invalid LDAP group mapping %d: LDAP and SFTPGo groups are required", idx)
+		}
+		if m.Type < sdk.GroupTypePrimary || m.Type > sdk.GroupTypeMembership {
+			return fmt.Errorf("invalid LDAP group mapping %d: invalid group type %d", idx, m.Type)
+		}
+	}
+	return nil
+}
+
+func (c *LDAPConfig) getTimeout() time.Duration {
+	return time.Duration(c.Timeout) * time.Second
+}
+
+func (c *LDAPConfig) getTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.SkipTLSVerify,
+	}
+}
+
+func (c *LDAPConfig) getSearchAttributes() []string {
+	attributes := []string{"dn"}
+	if c.GroupAttribute != "" {
+		attributes = append(attributes, c.GroupAttribute)
+	}
+	if c.EmailAttribute != "" {
+		attributes = append(attributes, c.EmailAttribute)
+	}
+	for _, match := range ldapAttributeRegex.FindAllStringSubmatch(c.HomeDirTemplate, -1) {
+		attributes = append(attributes, match[1])
+	}
+	return util.RemoveDuplicates(attributes, false)
+}
+
+func (c *LDAPConfig) connect() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(c.URL, ldap.DialWithDialer(&net.Dialer{Timeout: c.getTimeout()}),
+		ldap.DialWithTLSConfig(c.getTLSConfig()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errLDAPUnavailable, err)
+	}
+	conn.SetTimeout(c.getTimeout())
+	if c.StartTLS {
+		if err := conn.StartTLS(c.getTLSConfig()); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to start TLS: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// authenticate searches the user with the specified username and verifies the
+// password binding as the found entry
+func (c *LDAPConfig) authenticate(username, password string) (*ldap.Entry, error) {
+	if password == "" {
+		// an empty password means an unauthenticated bind for most LDAP servers
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if c.BindDN != "" {
+		if err := conn.Bind(c.BindDN, c.BindPassword); err != nil {
+			return nil, getLDAPError(fmt.Errorf("unable to bind as %q: %w", c.BindDN, err))
+		}
+	}
+	req := ldap.NewSearchRequest(c.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, c.Timeout, false,
+		fmt.Sprintf(c.UserFilter, ldap.EscapeFilter(username)), c.getSearchAttributes(), nil)
+	res, err := conn.Search(req)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist in LDAP", username))
+		}
+		return nil, getLDAPError(fmt.Errorf("unable to search user %q: %w", username, err))
+	}
+	switch len(res.Entries) {
+	case 0:
+		return nil, util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist in LDAP", username))
+	case 1:
+	default:
+		return nil, fmt.Errorf("the LDAP search for username %q returned multiple entries", username)
+	}
+	entry := res.Entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, getLDAPError(fmt.Errorf("unable to bind as %q: %w", entry.DN, err))
+	}
+	return entry, nil
+}
+
+func (c *LDAPConfig) getHomeDir(username string, entry *ldap.Entry) (string, error) {
+	if c.HomeDirTemplate == "" {
+		return "", nil
+	}
+	var err error
+	homeDir := ldapAttributeRegex.ReplaceAllStringFunc(c.HomeDirTemplate, func(placeholder string) string {
+		attribute := ldapAttributeRegex.FindStringSubmatch(placeholder)[1]
+		value := entry.GetAttributeValue(attribute)
+		if value == "" && err == nil {
+			err = fmt.Errorf("LDAP attribute %q, required for the home directory, is empty for user %q",
+				attribute, username)
+		}
+		return value
+	})
+	return strings.ReplaceAll(homeDir, "%username%", username), err
+}
+
+func (c *LDAPConfig) getGroups(entry *ldap.Entry) []sdk.GroupMapping {
+	var groups []sdk.GroupMapping
+	var hasPrimaryGroup bool
+
+	ldapGroups := entry.GetAttributeValues(c.GroupAttribute)
+	for _, m := range c.GroupMappings {
+		for _, ldapGroup := range ldapGroups {
+			if !m.matches(ldapGroup) {
+				continue
+			}
+			groupType := m.Type
+			if groupType == sdk.GroupTypePrimary {
+				if hasPrimaryGroup {
+					// only one primary group is allowed
+					groupType = sdk.GroupTypeSecondary
+				}
+				hasPrimaryGroup = true
+			}
+			groups = append(groups, sdk.GroupMapping{
+				Name: m.Group,
+				Type: groupType,
+			})
+			break
+		}
+	}
+	return groups
+}
+
+// getLDAPError returns an error wrapping errLDAPUnavailable for network errors
+func getLDAPError(err error) error {
+	if ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		return fmt.Errorf("%w: %v", errLDAPUnavailable, err)
+	}
+	return err
+}
+
+func isSameGroups(groups1, groups2 []sdk.GroupMapping) bool {
+	if len(groups1) != len(groups2) {
+		return false
+	}
+	for idx := range groups1 {
+		if groups1[idx].Name != groups2[idx].Name || groups1[idx].Type != groups2[idx].Type {
+			return false
+		}
+	}
+	return true
+}
+
+// updateUserFromLDAPEntry updates the user with the settings from the LDAP
+// entry and returns true if the user was modified
+func updateUserFromLDAPEntry(user *User, password string, entry *ldap.Entry) (bool, error) {
+	var modified bool
+
+	if ok, _ := isPasswordOK(user, password); !ok {
+		user.Password = password
+		modified = true
+	}
+	if config.LDAP.EmailAttribute != "" {
+		if email := entry.GetAttributeValue(config.LDAP.EmailAttribute); email != user.Email {
+			user.Email = email
+			modified = true
+		}
+	}
+	if len(config.LDAP.GroupMappings) > 0 {
+		if groups := config.LDAP.getGroups(entry); !isSameGroups(groups, user.Groups) {
+			user.Groups = groups
+			modified = true
+		}
+	}
+	homeDir, err := config.LDAP.getHomeDir(user.Username, entry)
+	if err != nil {
+		return modified, err
+	}
+	if homeDir != "" && homeDir != user.HomeDir {
+		user.HomeDir = homeDir
+		modified = true
+	}
+	return modified, nil
+}
+
+func doLDAPAuth(username, password, ip, protocol string) (User, error) {
+	u, mergedUser, err := getUserForHook(username, nil)
+	if err != nil {
+		return u, err
+	}
+
+	if mergedUser.Filters.Hooks.ExternalAuthDisabled {
+		return u, nil
+	}
+
+	if mergedUser.isExternalAuthCached() {
+		return u, nil
+	}
+
+	startTime := time.Now()
+	entry, err := config.LDAP.authenticate(username, password)
+	if err != nil {
+		if errors.Is(err, errLDAPUnavailable) && u.ID > 0 {
+			if _, ok := ldapCredentialsCache.getUserByPassword(username, password); ok {
+				providerLog(logger.LevelInfo, "%v, user %q authenticated using cached credentials, ip %s, protocol %s",
+					err, username, ip, protocol)
+				return u, nil
+			}
+		}
+		if errors.Is(err, ErrInvalidCredentials) {
+			ldapCredentialsCache.remove(username)
+			return u, err
+		}
+		if _, ok := err.(*util.RecordNotFoundError); ok {
+			return u, err
+		}
+		return u, fmt.Errorf("LDAP auth error for user %q: %w, elapsed: %s", username, err, time.Since(startTime))
+	}
+	providerLog(logger.LevelDebug, "LDAP auth completed for user %q, DN %q, elapsed: %s", username, entry.DN,
+		time.Since(startTime))
+
+	if u.ID == 0 {
+		user := User{
+			BaseUser: sdk.BaseUser{
+				Username: username,
+				Status:   1,
+				Permissions: map[string][]string{
+					"/": config.LDAP.Permissions,
+				},
+			},
+		}
+		if _, err := updateUserFromLDAPEntry(&user, password, entry); err != nil {
+			return user, err
+		}
+		if err := provider.addUser(&user); err != nil {
+			return user, err
+		}
+		user, err = provider.userExists(username)
+		if err == nil {
+			ldapCredentialsCache.addPassword(&user, password)
+		}
+		return user, err
+	}
+	user := u.getACopy()
+	modified, err := updateUserFromLDAPEntry(&user, password, entry)
+	if err != nil {
+		return u, err
+	}
+	if modified {
+		if err := provider.updateUser(&user); err != nil {
+			return u, err
+		}
+		webDAVUsersCache.swap(&user)
+		cachedPasswords.Add(user.Username, password)
+		notifyUsersChanged(operationUpdate, user.Username)
+		if user, err = provider.userExists(username); err != nil {
+			return user, err
+		}
+	}
+	ldapCredentialsCache.addPassword(&user, password)
+	return user, nil
+}
@@ -70,6 +70,16 @@ type memoryProviderHandle struct {
 	rules map[string]EventRule
 	// slice with ordered rules
 	rulesNames []string
+	// audit trail entries ordered by ID
+	auditTrail []AuditEntry
+	// last audit trail entry ID
+	auditTrailID int64
+	// dead letters ordered by ID
+	deadLetters []DeadLetter
+	// last dead letter ID
+	deadLetterID int64
+	// map for the per-user folders quota usage, username is the key
+	usersFoldersQuota map[string]userFoldersQuota
 }
 
 // MemoryProvider defines the auth provider for a memory store
@@ -87,24 +97,25 @@ func initializeMemoryProvider(basePath string) {
 	}
 	provider = &MemoryProvider{
 		dbHandle: &memoryProviderHandle{
-			isClosed:        false,
-			usernames:       []string{},
-			users:           make(map[string]User),
-			groupnames:      []string{},
-			groups:          make(map[string]Group),
-			vfolders:        make(map[string]vfs.BaseVirtualFolder),
-			vfoldersNames:   []string{},
-			admins:          make(map[string]Admin),
-			adminsUsernames: []string{},
-			apiKeys:         make(map[string]APIKey),
-			apiKeysIDs:      []string{},
-			shares:          make(map[string]Share),
-			sharesIDs:       []string{},
-			actions:         make(map[string]BaseEventAction),
-			actionsNames:    []string{},
-			rules:           make(map[string]EventRule),
-			rulesNames:      []string{},
-			configFile:      configFile,
+			isClosed:          false,
+			usernames:         []string{},
+			users:             make(map[string]User),
+			groupnames:        []string{},
+			groups:            make(map[string]Group),
+			vfolders:          make(map[string]vfs.BaseVirtualFolder),
+			vfoldersNames:     []string{},
+			admins:            make(map[string]Admin),
+			adminsUsernames:   []string{},
+			apiKeys:           make(map[string]APIKey),
+			apiKeysIDs:        []string{},
+			shares:            make(map[string]Share),
+			sharesIDs:         []string{},
+			actions:           make(map[string]BaseEventAction),
+			actionsNames:      []string{},
+			rules:             make(map[string]EventRule),
+			rulesNames:        []string{},
+			configFile:        configFile,
+			usersFoldersQuota: make(map[string]userFoldersQuota),
 		},
 	}
 	if err := provider.reloadConfig(); err != nil {
@@ -137,7 +148,7 @@ func (p *MemoryProvider) validateUserAndTLSCert(username, protocol string, tlsCe
 	if tlsCert == nil {
 		return user, errors.New("TLS certificate cannot be null or empty")
 	}
-	user, err := p.userExists(username)
+	user, err := lookupUserForAuth(username, p.userExists)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error authenticating user %#v: %v", username, err)
 		return user, err
@@ -146,7 +157,7 @@ func (p *MemoryProvider) validateUserAndTLSCert(username, protocol string, tlsCe
 }
 
 func (p *MemoryProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
-	user, err := p.userExists(username)
+	user, err := lookupUserForAuth(username, p.userExists)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error authenticating user %#v: %v", username, err)
 		return user, err
@@ -159,7 +170,7 @@ func (p *MemoryProvider) validateUserAndPubKey(username string, pubKey []byte, i
 	if len(pubKey) == 0 {
 		return user, "", errors.New("credentials cannot be null or empty")
 	}
-	user, err := p.userExists(username)
+	user, err := lookupUserForAuth(username, p.userExists)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error authenticating user %#v: %v", username, err)
 		return user, "", err
@@ -262,6 +273,27 @@ func (p *MemoryProvider) updateTransferQuota(username string, uploadSize, downlo
 	return nil
 }
 
+func (p *MemoryProvider) resetUserTransferQuota(username string, scheduledAt int64) (bool, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return false, errMemoryProviderClosed
+	}
+	user, err := p.userExistsInternal(username)
+	if err != nil {
+		return false, err
+	}
+	if user.LastTransferQuotaReset >= scheduledAt {
+		return false, nil
+	}
+	user.UsedUploadDataTransfer = 0
+	user.UsedDownloadDataTransfer = 0
+	user.LastQuotaUpdate = util.GetTimeAsMsSinceEpoch(time.Now())
+	user.LastTransferQuotaReset = user.LastQuotaUpdate
+	p.dbHandle.users[user.Username] = user
+	return true, nil
+}
+
 func (p *MemoryProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -328,6 +360,8 @@ func (p *MemoryProvider) addUser(user *User) error {
 	user.LastLogin = 0
 	user.FirstUpload = 0
 	user.FirstDownload = 0
+	user.SoftDeletedAt = 0
+	user.LastTransferQuotaReset = 0
 	user.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 	user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 	var mappedGroups []string
@@ -393,6 +427,8 @@ func (p *MemoryProvider) updateUser(user *User) error {
 	user.LastLogin = u.LastLogin
 	user.FirstDownload = u.FirstDownload
 	user.FirstUpload = u.FirstUpload
+	user.SoftDeletedAt = u.SoftDeletedAt
+	user.LastTransferQuotaReset = u.LastTransferQuotaReset
 	user.CreatedAt = u.CreatedAt
 	user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 	user.ID = u.ID
@@ -427,10 +463,11 @@ func (p *MemoryProvider) deleteUser(user User, softDelete bool) error {
 	sort.Strings(p.dbHandle.usernames)
 	p.deleteAPIKeysWithUser(user.Username)
 	p.deleteSharesWithUser(user.Username)
+	delete(p.dbHandle.usersFoldersQuota, user.Username)
 	return nil
 }
 
-func (p *MemoryProvider) updateUserPassword(username, password string) error {
+func (p *MemoryProvider) updateUserPassword(username, password string, state *userPasswordState) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
 	if p.dbHandle.isClosed {
@@ -442,6 +479,7 @@ func (p *MemoryProvider) updateUserPassword(username, password string) error {
 		return err
 	}
 	user.Password = password
+	state.apply(&user)
 	p.dbHandle.users[username] = user
 	return nil
 }
@@ -456,6 +494,9 @@ func (p *MemoryProvider) dumpUsers() ([]User, error) {
 	}
 	for _, username := range p.dbHandle.usernames {
 		u := p.dbHandle.users[username]
+		if u.IsSoftDeleted() {
+			continue
+		}
 		user := u.getACopy()
 		p.addVirtualFoldersToUser(&user)
 		users = append(users, user)
@@ -471,6 +512,9 @@ func (p *MemoryProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 		return folders, errMemoryProviderClosed
 	}
 	for _, f := range p.dbHandle.vfolders {
+		if f.IsSoftDeleted() {
+			continue
+		}
 		folders = append(folders, f)
 	}
 	return folders, nil
@@ -547,6 +591,14 @@ func (p *MemoryProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User,
 }
 
 func (p *MemoryProvider) getUsers(limit int, offset int, order string) ([]User, error) {
+	return p.getUsersWithSoftDeleteFilter(limit, offset, order, false)
+}
+
+func (p *MemoryProvider) getSoftDeletedUsers(limit int, offset int, order string) ([]User, error) {
+	return p.getUsersWithSoftDeleteFilter(limit, offset, order, true)
+}
+
+func (p *MemoryProvider) getUsersWithSoftDeleteFilter(limit int, offset int, order string, softDeleted bool) ([]User, error) {
 	users := make([]User, 0, limit)
 	var err error
 	p.dbHandle.Lock()
@@ -558,41 +610,47 @@ func (p *MemoryProvider) getUsers(limit int, offset int, order string) ([]User,
 		return users, err
 	}
 	itNum := 0
-	if order == OrderASC {
-		for _, username := range p.dbHandle.usernames {
-			itNum++
-			if itNum <= offset {
-				continue
-			}
-			u := p.dbHandle.users[username]
-			user := u.getACopy()
-			p.addVirtualFoldersToUser(&user)
-			user.PrepareForRendering()
-			users = append(users, user)
-			if len(users) >= limit {
-				break
-			}
+	for i := range p.dbHandle.usernames {
+		username := p.dbHandle.usernames[i]
+		if order != OrderASC {
+			username = p.dbHandle.usernames[len(p.dbHandle.usernames)-1-i]
 		}
-	} else {
-		for i := len(p.dbHandle.usernames) - 1; i >= 0; i-- {
-			itNum++
-			if itNum <= offset {
-				continue
-			}
-			username := p.dbHandle.usernames[i]
-			u := p.dbHandle.users[username]
-			user := u.getACopy()
-			p.addVirtualFoldersToUser(&user)
-			user.PrepareForRendering()
-			users = append(users, user)
-			if len(users) >= limit {
-				break
-			}
+		u := p.dbHandle.users[username]
+		if u.IsSoftDeleted() != softDeleted {
+			continue
+		}
+		itNum++
+		if itNum <= offset {
+			continue
+		}
+		user := u.getACopy()
+		p.addVirtualFoldersToUser(&user)
+		user.PrepareForRendering()
+		users = append(users, user)
+		if len(users) >= limit {
+			break
 		}
 	}
 	return users, err
 }
 
+func (p *MemoryProvider) setUserSoftDeletedAt(username string, softDeletedAt int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	user, err := p.userExistsInternal(username)
+	if err != nil {
+		return err
+	}
+	user.SoftDeletedAt = softDeletedAt
+	user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.users[user.Username] = user
+	setLastUserUpdate()
+	return nil
+}
+
 func (p *MemoryProvider) userExists(username string) (User, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -832,6 +890,41 @@ func (p *MemoryProvider) updateFolderQuota(name string, filesAdd int, sizeAdd in
 	return nil
 }
 
+func (p *MemoryProvider) updateUserFolderQuota(username, folderName string, filesAdd int, sizeAdd int64, reset bool) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, err := p.userExistsInternal(username); err != nil {
+		providerLog(logger.LevelError, "unable to update folder %q quota for user %q error: %v", folderName, username, err)
+		return err
+	}
+	if _, err := p.folderExistsInternal(folderName); err != nil {
+		providerLog(logger.LevelError, "unable to update folder %q quota for user %q error: %v", folderName, username, err)
+		return err
+	}
+	quota, ok := p.dbHandle.usersFoldersQuota[username]
+	if !ok {
+		quota = userFoldersQuota{Username: username}
+	}
+	quota.update(folderName, filesAdd, sizeAdd, reset)
+	p.dbHandle.usersFoldersQuota[username] = quota
+	return nil
+}
+
+func (p *MemoryProvider) getUserFoldersQuota(username string) ([]UserFolderQuota, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	quota := p.dbHandle.usersFoldersQuota[username]
+	result := make([]UserFolderQuota, len(quota.Folders))
+	copy(result, quota.Folders)
+	return result, nil
+}
+
 func (p *MemoryProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -1058,7 +1151,7 @@ func (p *MemoryProvider) addVirtualFoldersToGroup(group *Group) {
 		for idx := range group.VirtualFolders {
 			folder := &group.VirtualFolders[idx]
 			baseFolder, err := p.folderExistsInternal(folder.Name)
-			if err != nil {
+			if err != nil || baseFolder.IsSoftDeleted() {
 				continue
 			}
 			folder.BaseVirtualFolder = baseFolder.GetACopy()
@@ -1202,7 +1295,7 @@ func (p *MemoryProvider) addVirtualFoldersToUser(user *User) {
 		for idx := range user.VirtualFolders {
 			folder := &user.VirtualFolders[idx]
 			baseFolder, err := p.folderExistsInternal(folder.Name)
-			if err != nil {
+			if err != nil || baseFolder.IsSoftDeleted() {
 				continue
 			}
 			folder.BaseVirtualFolder = baseFolder.GetACopy()
@@ -1289,6 +1382,14 @@ func (p *MemoryProvider) folderExistsInternal(name string) (vfs.BaseVirtualFolde
 }
 
 func (p *MemoryProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
+	return p.getFoldersWithSoftDeleteFilter(limit, offset, order, false)
+}
+
+func (p *MemoryProvider) getSoftDeletedFolders(limit, offset int, order string) ([]vfs.BaseVirtualFolder, error) {
+	return p.getFoldersWithSoftDeleteFilter(limit, offset, order, true)
+}
+
+func (p *MemoryProvider) getFoldersWithSoftDeleteFilter(limit, offset int, order string, softDeleted bool) ([]vfs.BaseVirtualFolder, error) {
 	folders := make([]vfs.BaseVirtualFolder, 0, limit)
 	var err error
 	p.dbHandle.Lock()
@@ -1300,39 +1401,44 @@ func (p *MemoryProvider) getFolders(limit, offset int, order string, minimal boo
 		return folders, err
 	}
 	itNum := 0
-	if order == OrderASC {
-		for _, name := range p.dbHandle.vfoldersNames {
-			itNum++
-			if itNum <= offset {
-				continue
-			}
-			f := p.dbHandle.vfolders[name]
-			folder := f.GetACopy()
-			folder.PrepareForRendering()
-			folders = append(folders, folder)
-			if len(folders) >= limit {
-				break
-			}
+	for i := range p.dbHandle.vfoldersNames {
+		name := p.dbHandle.vfoldersNames[i]
+		if order != OrderASC {
+			name = p.dbHandle.vfoldersNames[len(p.dbHandle.vfoldersNames)-1-i]
 		}
-	} else {
-		for i := len(p.dbHandle.vfoldersNames) - 1; i >= 0; i-- {
-			itNum++
-			if itNum <= offset {
-				continue
-			}
-			name := p.dbHandle.vfoldersNames[i]
-			f := p.dbHandle.vfolders[name]
-			folder := f.GetACopy()
-			folder.PrepareForRendering()
-			folders = append(folders, folder)
-			if len(folders) >= limit {
-				break
-			}
+		f := p.dbHandle.vfolders[name]
+		if f.IsSoftDeleted() != softDeleted {
+			continue
+		}
+		itNum++
+		if itNum <= offset {
+			continue
+		}
+		folder := f.GetACopy()
+		folder.PrepareForRendering()
+		folders = append(folders, folder)
+		if len(folders) >= limit {
+			break
 		}
 	}
 	return folders, err
 }
 
+func (p *MemoryProvider) setFolderSoftDeletedAt(name string, softDeletedAt int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	folder, err := p.folderExistsInternal(name)
+	if err != nil {
+		return err
+	}
+	folder.SoftDeletedAt = softDeletedAt
+	p.dbHandle.vfolders[folder.Name] = folder
+	return nil
+}
+
 func (p *MemoryProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -1365,6 +1471,7 @@ func (p *MemoryProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
 	folder.ID = p.getNextFolderID()
 	folder.Users = nil
 	folder.Groups = nil
+	folder.SoftDeletedAt = 0
 	p.dbHandle.vfolders[folder.Name] = folder.GetACopy()
 	p.dbHandle.vfoldersNames = append(p.dbHandle.vfoldersNames, folder.Name)
 	sort.Strings(p.dbHandle.vfoldersNames)
@@ -1392,6 +1499,7 @@ func (p *MemoryProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
 	folder.UsedQuotaSize = f.UsedQuotaSize
 	folder.Users = f.Users
 	folder.Groups = f.Groups
+	folder.SoftDeletedAt = f.SoftDeletedAt
 	p.dbHandle.vfolders[folder.Name] = folder.GetACopy()
 	// now update the related users
 	for _, username := range folder.Users {
@@ -1451,6 +1559,11 @@ func (p *MemoryProvider) deleteFolder(f vfs.BaseVirtualFolder) error {
 			p.dbHandle.groups[group.Name] = group
 		}
 	}
+	for username, quota := range p.dbHandle.usersFoldersQuota {
+		if quota.removeFolder(folder.Name) {
+			p.dbHandle.usersFoldersQuota[username] = quota
+		}
+	}
 	delete(p.dbHandle.vfolders, folder.Name)
 	p.dbHandle.vfoldersNames = []string{}
 	for name := range p.dbHandle.vfolders {
@@ -1936,6 +2049,10 @@ func (p *MemoryProvider) getSharedSession(key string) (Session, error) {
 	return Session{}, ErrNotImplemented
 }
 
+func (p *MemoryProvider) getSharedSessions(sessionType SessionType, after int64) ([]Session, error) {
+	return nil, ErrNotImplemented
+}
+
 func (p *MemoryProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return ErrNotImplemented
 }
@@ -2322,6 +2439,119 @@ func (*MemoryProvider) cleanupNodes() error {
 	return ErrNotImplemented
 }
 
+func (p *MemoryProvider) addAuditEntry(entry *AuditEntry) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	p.dbHandle.auditTrailID++
+	entry.ID = p.dbHandle.auditTrailID
+	p.dbHandle.auditTrail = append(p.dbHandle.auditTrail, *entry)
+	return nil
+}
+
+func (p *MemoryProvider) getAuditEntries(filters AuditFilters, limit, offset int, order string) ([]AuditEntry, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	return getAuditEntriesFromList(p.dbHandle.auditTrail, filters, limit, offset, order), nil
+}
+
+func (p *MemoryProvider) cleanupAuditEntries(before int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	idx := sort.Search(len(p.dbHandle.auditTrail), func(i int) bool {
+		return p.dbHandle.auditTrail[i].Timestamp >= before
+	})
+	p.dbHandle.auditTrail = append([]AuditEntry(nil), p.dbHandle.auditTrail[idx:]...)
+	return nil
+}
+
+func (p *MemoryProvider) addDeadLetter(entry *DeadLetter) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	p.dbHandle.deadLetterID++
+	entry.ID = p.dbHandle.deadLetterID
+	p.dbHandle.deadLetters = append(p.dbHandle.deadLetters, *entry)
+	return nil
+}
+
+func (p *MemoryProvider) getDeadLetters(filters DeadLetterFilters, limit, offset int, order string) ([]DeadLetter, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	return getDeadLettersFromList(p.dbHandle.deadLetters, filters, limit, offset, order), nil
+}
+
+func (p *MemoryProvider) findDeadLetter(id int64) (int, bool) {
+	idx := sort.Search(len(p.dbHandle.deadLetters), func(i int) bool {
+		return p.dbHandle.deadLetters[i].ID >= id
+	})
+	return idx, idx < len(p.dbHandle.deadLetters) && p.dbHandle.deadLetters[idx].ID == id
+}
+
+func (p *MemoryProvider) getDeadLetter(id int64) (DeadLetter, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	if p.dbHandle.isClosed {
+		return DeadLetter{}, errMemoryProviderClosed
+	}
+	idx, ok := p.findDeadLetter(id)
+	if !ok {
+		return DeadLetter{}, util.NewRecordNotFoundError(fmt.Sprintf("dead letter %d does not exist", id))
+	}
+	return p.dbHandle.deadLetters[idx], nil
+}
+
+func (p *MemoryProvider) deleteDeadLetter(id int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	idx, ok := p.findDeadLetter(id)
+	if !ok {
+		return util.NewRecordNotFoundError(fmt.Sprintf("dead letter %d does not exist", id))
+	}
+	p.dbHandle.deadLetters = append(p.dbHandle.deadLetters[:idx], p.dbHandle.deadLetters[idx+1:]...)
+	return nil
+}
+
+func (p *MemoryProvider) cleanupDeadLetters(before int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	var deadLetters []DeadLetter
+	for _, entry := range p.dbHandle.deadLetters {
+		if entry.Timestamp >= before {
+			deadLetters = append(deadLetters, entry)
+		}
+	}
+	p.dbHandle.deadLetters = deadLetters
+	return nil
+}
+
 func (p *MemoryProvider) setFirstDownloadTimestamp(username string) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -2433,6 +2663,7 @@ func (p *MemoryProvider) clear() {
 	p.dbHandle.apiKeysIDs = []string{}
 	p.dbHandle.shares = make(map[string]Share)
 	p.dbHandle.sharesIDs = []string{}
+	p.dbHandle.usersFoldersQuota = make(map[string]userFoldersQuota)
 }
 
 func (p *MemoryProvider) reloadConfig() error {
@@ -2706,3 +2937,7 @@ func (p *MemoryProvider) revertDatabase(targetVersion int) error {
 func (p *MemoryProvider) resetDatabase() error {
 	return errors.New("memory provider does not store data, reset not possible")
 }
+
+func (p *MemoryProvider) backupDatabase(outputFile string) error {
+	return ErrNotImplemented
+}
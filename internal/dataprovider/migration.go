@@ -0,0 +1,147 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// minSchemaVersion is the oldest schema version supported for upgrades and
+	// the oldest version a data provider can be reverted to
+	minSchemaVersion = 19
+)
+
+var (
+	// migrationPlan is not nil while planning a migration, the SQL statements
+	// are recorded instead of being executed
+	migrationPlan *MigrationPlan
+)
+
+// MigrationStep defines the statements required to migrate the data provider
+// schema from a version to the next, or previous, one
+type MigrationStep struct {
+	FromVersion int      `json:"from_version"`
+	ToVersion   int      `json:"to_version"`
+	Statements  []string `json:"statements"`
+}
+
+// MigrationPlan defines the steps required to initialize, update or revert
+// the data provider schema
+type MigrationPlan struct {
+	Driver         string          `json:"driver"`
+	CurrentVersion int             `json:"current_version"`
+	TargetVersion  int             `json:"target_version"`
+	Steps          []MigrationStep `json:"steps"`
+}
+
+// IsEmpty returns true if the data provider schema is up to date
+func (p *MigrationPlan) IsEmpty() bool {
+	return len(p.Steps) == 0
+}
+
+// SQL returns the planned statements as an SQL script
+func (p *MigrationPlan) SQL() string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("-- %s: schema version %d -> %d\n", p.Driver, p.CurrentVersion, p.TargetVersion))
+	for _, step := range p.Steps {
+		sb.WriteString(fmt.Sprintf("\n-- %d -> %d\n", step.FromVersion, step.ToVersion))
+		for _, stmt := range step.Statements {
+			sb.WriteString(stmt)
+			sb.WriteString(";\n")
+		}
+	}
+	return sb.String()
+}
+
+func (p *MigrationPlan) getVersion() int {
+	if len(p.Steps) > 0 {
+		return p.Steps[len(p.Steps)-1].ToVersion
+	}
+	return p.CurrentVersion
+}
+
+func (p *MigrationPlan) addStep(sqlQueries []string, newVersion int) {
+	step := MigrationStep{
+		FromVersion: p.getVersion(),
+		ToVersion:   newVersion,
+	}
+	for _, q := range sqlQueries {
+		// a query can contain multiple statements
+		for _, stmt := range strings.Split(q, ";") {
+			stmt = strings.TrimSpace(stmt)
+			if stmt != "" {
+				step.Statements = append(step.Statements, stmt)
+			}
+		}
+	}
+	step.Statements = append(step.Statements, fmt.Sprintf("UPDATE %s SET version=%d", sqlTableSchemaVersion, newVersion))
+	p.Steps = append(p.Steps, step)
+}
+
+// PlanDatabaseMigration returns the steps that InitializeDatabase would execute
+// to create or update the data provider schema, the data provider is not modified.
+// Only SQL based data providers are supported
+func PlanDatabaseMigration(cnf Config, basePath string) (MigrationPlan, error) {
+	return planMigration(cnf, func() error {
+		return InitializeDatabase(cnf, basePath)
+	})
+}
+
+// PlanDatabaseRevert returns the steps that RevertDatabase would execute to
+// revert the data provider schema to the specified version, the data provider
+// is not modified. Only SQL based data providers are supported
+func PlanDatabaseRevert(cnf Config, basePath string, targetVersion int) (MigrationPlan, error) {
+	return planMigration(cnf, func() error {
+		return RevertDatabase(cnf, basePath, targetVersion)
+	})
+}
+
+func planMigration(cnf Config, fn func() error) (MigrationPlan, error) {
+	if !util.Contains([]string{SQLiteDataProviderName, MySQLDataProviderName, PGSQLDataProviderName,
+		CockroachDataProviderName}, cnf.Driver) {
+		return MigrationPlan{}, fmt.Errorf("migration plans are not supported for the %q data provider", cnf.Driver)
+	}
+	plan := &MigrationPlan{
+		Driver: cnf.Driver,
+	}
+	migrationPlan = plan
+	defer func() {
+		migrationPlan = nil
+	}()
+
+	err := fn()
+	if err != nil && !errors.Is(err, ErrNoInitRequired) {
+		return *plan, err
+	}
+	plan.TargetVersion = plan.getVersion()
+	return *plan, nil
+}
+
+func validateRevertTargetVersion(currentVersion, targetVersion int) error {
+	if currentVersion == targetVersion {
+		return errors.New("current version match target version, nothing to do")
+	}
+	if targetVersion < minSchemaVersion || targetVersion > currentVersion {
+		return fmt.Errorf("unable to revert the database schema version %d to version %d, supported target versions: %d-%d",
+			currentVersion, targetVersion, minSchemaVersion, currentVersion-1)
+	}
+	return nil
+}
@@ -0,0 +1,1708 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nomongodb
+// +build !nomongodb
+
+package dataprovider
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	mongoDatabaseVersion = 27
+	mongoDefaultDatabase = "sftpgo"
+	mongoDefaultPort     = 27017
+	mongoOpTimeout       = 15 * time.Second
+)
+
+const (
+	mongoSchemaVersionCollection  = "schema_version"
+	mongoSequencesCollection      = "sequences"
+	mongoLocksCollection          = "locks"
+	mongoDefenderHostsCollection  = "defender_hosts"
+	mongoDefenderEventsCollection = "defender_events"
+	mongoSchemaVersionID          = "version"
+	mongoLockID                   = "lock"
+)
+
+// MongoDBProvider defines the auth provider for MongoDB database
+type MongoDBProvider struct {
+	kvProvider
+	db     *mongoDB
+	cancel context.CancelFunc
+}
+
+func init() {
+	version.AddFeature("+mongodb")
+}
+
+func initializeMongoDBProvider() error {
+	clientOptions, err := getMongoDBClientOptions()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		providerLog(logger.LevelError, "error creating MongoDB database handler: %v", err)
+		return err
+	}
+	dbName := config.Name
+	if dbName == "" {
+		dbName = mongoDefaultDatabase
+	}
+	db := &mongoDB{
+		client:   client,
+		database: client.Database(dbName),
+	}
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(ctx) //nolint:errcheck
+		providerLog(logger.LevelError, "unable to connect to MongoDB: %v", err)
+		return err
+	}
+	db.transactions = db.isTransactionSupported(ctx)
+	if !db.transactions && config.IsShared == 1 {
+		providerLog(logger.LevelWarn, "MongoDB transactions are not supported, a replica set is required to "+
+			"safely share the data provider between multiple instances")
+	}
+	providerLog(logger.LevelDebug, "MongoDB database handle created, database %q, transactions supported: %t",
+		dbName, db.transactions)
+	watchCtx, watchCancel := context.WithCancel(context.Background())
+	p := &MongoDBProvider{
+		kvProvider: kvProvider{dbHandle: db},
+		db:         db,
+		cancel:     watchCancel,
+	}
+	provider = p
+	// updated objects are detected using the update time, change streams
+	// are only used to detect removed objects
+	if db.transactions && config.IsShared == 1 {
+		go p.watchDeletes(watchCtx)
+	}
+	return nil
+}
+
+func getMongoDBClientOptions() (*options.ClientOptions, error) {
+	clientOptions := options.Client()
+	if config.ConnectionString != "" {
+		clientOptions.ApplyURI(config.ConnectionString)
+	} else {
+		port := config.Port
+		if port <= 0 {
+			port = mongoDefaultPort
+		}
+		var hosts []string
+		for _, host := range strings.Split(config.Host, ",") {
+			host = strings.TrimSpace(host)
+			if host == "" {
+				continue
+			}
+			if !strings.Contains(host, ":") {
+				host = fmt.Sprintf("%s:%d", host, port)
+			}
+			hosts = append(hosts, host)
+		}
+		clientOptions.SetHosts(hosts)
+		if config.Username != "" {
+			clientOptions.SetAuth(options.Credential{
+				Username: config.Username,
+				Password: config.Password,
+			})
+		}
+	}
+	if config.PoolSize > 0 {
+		clientOptions.SetMaxPoolSize(uint64(config.PoolSize))
+	}
+	tlsConfig, err := getMongoDBTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+	return clientOptions, clientOptions.Validate()
+}
+
+func getMongoDBTLSConfig() (*tls.Config, error) {
+	if config.SSLMode == 0 {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if config.RootCert != "" {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		rootCrt, err := os.ReadFile(config.RootCert)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load root certificate %q: %v", config.RootCert, err)
+		}
+		if !rootCAs.AppendCertsFromPEM(rootCrt) {
+			return nil, fmt.Errorf("unable to parse root certificate %q", config.RootCert)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	if config.ClientCert != "" && config.ClientKey != "" {
+		tlsCert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load key pair %q, %q: %v", config.ClientCert, config.ClientKey, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{tlsCert}
+	}
+	if config.SSLMode == 2 {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig, nil
+}
+
+// mongoDB implements kvStore on top of MongoDB, each bucket is stored in its
+// own collection. Writes are buffered and applied when the transaction function
+// returns without errors. If the server supports transactions (replica set or
+// sharded cluster) the reads and the writes are executed within a MongoDB
+// transaction and the read-write transactions are serialized across all the
+// SFTPGo instances, otherwise they are only serialized within this instance
+type mongoDB struct {
+	client       *mongo.Client
+	database     *mongo.Database
+	transactions bool
+	// serializes read-write transactions within this instance
+	mu sync.Mutex
+}
+
+// mongoKV defines how the key/value pairs are stored. The JSON serialized object
+// is stored as is, the update time is duplicated so it can be indexed
+type mongoKV struct {
+	Key       string `bson:"_id"`
+	Data      string `bson:"data"`
+	UpdatedAt int64  `bson:"updated_at"`
+}
+
+func (db *mongoDB) collection(name string) *mongo.Collection {
+	return db.database.Collection(name)
+}
+
+func (db *mongoDB) isTransactionSupported(ctx context.Context) bool {
+	var result struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := db.database.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&result); err != nil {
+		providerLog(logger.LevelDebug, "unable to check MongoDB topology: %v", err)
+		return false
+	}
+	return result.SetName != "" || result.Msg == "isdbgrid"
+}
+
+// View executes a read-only transaction
+func (db *mongoDB) View(fn func(kvTx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	return newMongoTx(ctx, db, false).run(fn)
+}
+
+// Update executes a read-write transaction
+func (db *mongoDB) Update(fn func(kvTx) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	if !db.transactions {
+		tx := newMongoTx(ctx, db, true)
+		if err := tx.run(fn); err != nil {
+			return err
+		}
+		return tx.commit()
+	}
+	session, err := db.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("unable to start MongoDB session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (any, error) {
+		// all the read-write transactions update the same document so they conflict
+		// and are serialized, MongoDB aborts and we retry the conflicting ones
+		_, err := db.collection(mongoLocksCollection).UpdateOne(sessCtx, bson.M{"_id": mongoLockID},
+			bson.M{"$inc": bson.M{"counter": 1}}, options.Update().SetUpsert(true))
+		if err != nil {
+			return nil, err
+		}
+		tx := newMongoTx(sessCtx, db, true)
+		if err := tx.run(fn); err != nil {
+			return nil, err
+		}
+		return nil, tx.commit()
+	})
+	return err
+}
+
+// Close closes the MongoDB client
+func (db *mongoDB) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	return db.client.Disconnect(ctx)
+}
+
+type mongoPendingKey struct {
+	bucket string
+	key    string
+}
+
+type mongoTx struct {
+	ctx      context.Context
+	db       *mongoDB
+	writable bool
+	// buffered writes, a nil value means the key was deleted
+	pending     map[mongoPendingKey][]byte
+	pendingKeys []mongoPendingKey
+	// first read error, bbolt reads cannot fail so the error is
+	// returned when the transaction completes
+	err error
+}
+
+func newMongoTx(ctx context.Context, db *mongoDB, writable bool) *mongoTx {
+	tx := &mongoTx{
+		ctx:      ctx,
+		db:       db,
+		writable: writable,
+	}
+	if writable {
+		tx.pending = make(map[mongoPendingKey][]byte)
+	}
+	return tx
+}
+
+func (tx *mongoTx) run(fn func(kvTx) error) error {
+	err := fn(tx)
+	if tx.err != nil {
+		return tx.err
+	}
+	return err
+}
+
+func (tx *mongoTx) setError(err error) {
+	if tx.err == nil {
+		tx.err = err
+	}
+}
+
+func (tx *mongoTx) get(bucket, key string) ([]byte, error) {
+	if tx.writable {
+		if v, ok := tx.pending[mongoPendingKey{bucket: bucket, key: key}]; ok {
+			return v, nil
+		}
+	}
+	var kv mongoKV
+	err := tx.db.collection(bucket).FindOne(tx.ctx, bson.M{"_id": key}).Decode(&kv)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return []byte(kv.Data), nil
+}
+
+func (tx *mongoTx) list(bucket string) ([]mongoKV, error) {
+	cursor, err := tx.db.collection(bucket).Find(tx.ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var result []mongoKV
+	if err := cursor.All(tx.ctx, &result); err != nil {
+		return nil, err
+	}
+	if !tx.writable {
+		return result, nil
+	}
+	var needSort bool
+	for k, v := range tx.pending {
+		if k.bucket != bucket {
+			continue
+		}
+		idx := sort.Search(len(result), func(i int) bool {
+			return result[i].Key >= k.key
+		})
+		found := idx < len(result) && result[idx].Key == k.key
+		switch {
+		case found && v == nil:
+			result = append(result[:idx], result[idx+1:]...)
+		case found:
+			result[idx].Data = string(v)
+		case v != nil:
+			result = append(result, mongoKV{Key: k.key, Data: string(v)})
+			needSort = true
+		}
+	}
+	if needSort {
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].Key < result[j].Key
+		})
+	}
+	return result, nil
+}
+
+func (tx *mongoTx) put(bucket string, key []byte, value []byte) error {
+	if !tx.writable {
+		return errors.New("unable to write within a read-only transaction")
+	}
+	if len(key) == 0 {
+		return errors.New("unable to write an empty key")
+	}
+	k := mongoPendingKey{bucket: bucket, key: string(key)}
+	if _, ok := tx.pending[k]; !ok {
+		tx.pendingKeys = append(tx.pendingKeys, k)
+	}
+	tx.pending[k] = value
+	return nil
+}
+
+func (tx *mongoTx) delete(bucket string, key []byte) error {
+	if !tx.writable {
+		return errors.New("unable to delete within a read-only transaction")
+	}
+	k := mongoPendingKey{bucket: bucket, key: string(key)}
+	if _, ok := tx.pending[k]; !ok {
+		tx.pendingKeys = append(tx.pendingKeys, k)
+	}
+	tx.pending[k] = nil
+	return nil
+}
+
+// Bucket returns the bucket with the specified name, collections are created
+// on first write so they always exist
+func (tx *mongoTx) Bucket(name string) kvBucket {
+	return &mongoBucket{
+		tx:   tx,
+		name: name,
+	}
+}
+
+func (tx *mongoTx) commit() error {
+	models := make(map[string][]mongo.WriteModel)
+	var buckets []string
+	for _, k := range tx.pendingKeys {
+		v := tx.pending[k]
+		if _, ok := models[k.bucket]; !ok {
+			buckets = append(buckets, k.bucket)
+		}
+		if v == nil {
+			models[k.bucket] = append(models[k.bucket], mongo.NewDeleteOneModel().SetFilter(bson.M{"_id": k.key}))
+			continue
+		}
+		var obj struct {
+			UpdatedAt int64 `json:"updated_at"`
+		}
+		// not all the objects have an update time, this is not an error
+		json.Unmarshal(v, &obj) //nolint:errcheck
+		models[k.bucket] = append(models[k.bucket], mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": k.key}).
+			SetReplacement(mongoKV{Key: k.key, Data: string(v), UpdatedAt: obj.UpdatedAt}).
+			SetUpsert(true))
+	}
+	for _, bucket := range buckets {
+		_, err := tx.db.collection(bucket).BulkWrite(tx.ctx, models[bucket], options.BulkWrite().SetOrdered(true))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type mongoBucket struct {
+	tx   *mongoTx
+	name string
+}
+
+// Get returns the value for the specified key or nil if the key does not exist
+func (b *mongoBucket) Get(key []byte) []byte {
+	v, err := b.tx.get(b.name, string(key))
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get key %q from collection %q: %v", key, b.name, err)
+		b.tx.setError(err)
+		return nil
+	}
+	return v
+}
+
+// Put sets the value for the specified key
+func (b *mongoBucket) Put(key []byte, value []byte) error {
+	return b.tx.put(b.name, key, value)
+}
+
+// Delete removes the specified key, it is not an error if the key does not exist
+func (b *mongoBucket) Delete(key []byte) error {
+	return b.tx.delete(b.name, key)
+}
+
+// NextSequence returns an autoincrementing integer for the bucket. The sequence
+// is updated immediately, a rolled back transaction can leave a gap
+func (b *mongoBucket) NextSequence() (uint64, error) {
+	if !b.tx.writable {
+		return 0, errors.New("unable to update a sequence within a read-only transaction")
+	}
+	seq, err := b.tx.db.nextSequence(b.tx.ctx, b.name)
+	return uint64(seq), err
+}
+
+// Cursor returns a cursor to iterate over the bucket keys in sorted order
+func (b *mongoBucket) Cursor() kvCursor {
+	kvs, err := b.tx.list(b.name)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to list keys for collection %q: %v", b.name, err)
+		b.tx.setError(err)
+	}
+	return &mongoCursor{
+		kvs: kvs,
+		idx: -1,
+	}
+}
+
+type mongoCursor struct {
+	kvs []mongoKV
+	idx int
+}
+
+func (c *mongoCursor) current() ([]byte, []byte) {
+	if c.idx < 0 || c.idx >= len(c.kvs) {
+		return nil, nil
+	}
+	return []byte(c.kvs[c.idx].Key), []byte(c.kvs[c.idx].Data)
+}
+
+// First moves the cursor to the first item
+func (c *mongoCursor) First() ([]byte, []byte) {
+	c.idx = 0
+	return c.current()
+}
+
+// Last moves the cursor to the last item
+func (c *mongoCursor) Last() ([]byte, []byte) {
+	c.idx = len(c.kvs) - 1
+	return c.current()
+}
+
+// Next moves the cursor to the next item
+func (c *mongoCursor) Next() ([]byte, []byte) {
+	if c.idx < len(c.kvs) {
+		c.idx++
+	}
+	return c.current()
+}
+
+// Prev moves the cursor to the previous item
+func (c *mongoCursor) Prev() ([]byte, []byte) {
+	if c.idx >= 0 {
+		c.idx--
+	}
+	return c.current()
+}
+
+// mongoDefenderHost defines how the defender hosts are stored
+type mongoDefenderHost struct {
+	IP        string `bson:"_id"`
+	BanTime   int64  `bson:"ban_time"`
+	UpdatedAt int64  `bson:"updated_at"`
+}
+
+// mongoDefenderEvent defines how the defender events are stored
+type mongoDefenderEvent struct {
+	IP       string `bson:"ip"`
+	DateTime int64  `bson:"date_time"`
+	Score    int    `bson:"score"`
+}
+
+// mongoSession defines how the shared sessions are stored
+type mongoSession struct {
+	Key       string      `bson:"_id"`
+	Data      []byte      `bson:"data"`
+	Type      SessionType `bson:"type"`
+	Timestamp int64       `bson:"timestamp"`
+}
+
+// mongoActiveTransfer defines how the active transfers are stored
+type mongoActiveTransfer struct {
+	Key           string `bson:"_id"`
+	ID            int64  `bson:"transfer_id"`
+	Type          int    `bson:"transfer_type"`
+	ConnID        string `bson:"connection_id"`
+	Username      string `bson:"username"`
+	FolderName    string `bson:"folder_name"`
+	IP            string `bson:"ip"`
+	TruncatedSize int64  `bson:"truncated_size"`
+	CurrentULSize int64  `bson:"current_ul_size"`
+	CurrentDLSize int64  `bson:"current_dl_size"`
+	CreatedAt     int64  `bson:"created_at"`
+	UpdatedAt     int64  `bson:"updated_at"`
+}
+
+func (t *mongoActiveTransfer) getActiveTransfer() ActiveTransfer {
+	return ActiveTransfer{
+		ID:            t.ID,
+		Type:          t.Type,
+		ConnID:        t.ConnID,
+		Username:      t.Username,
+		FolderName:    t.FolderName,
+		IP:            t.IP,
+		TruncatedSize: t.TruncatedSize,
+		CurrentULSize: t.CurrentULSize,
+		CurrentDLSize: t.CurrentDLSize,
+		CreatedAt:     t.CreatedAt,
+		UpdatedAt:     t.UpdatedAt,
+	}
+}
+
+// mongoTask defines how the tasks are stored
+type mongoTask struct {
+	Name     string `bson:"_id"`
+	UpdateAt int64  `bson:"updated_at"`
+	Version  int64  `bson:"version"`
+}
+
+// mongoNode defines how the cluster nodes are stored, node data are serialized
+// as JSON, as for the SQL providers
+type mongoNode struct {
+	Name      string `bson:"_id"`
+	Data      string `bson:"data"`
+	CreatedAt int64  `bson:"created_at"`
+	UpdatedAt int64  `bson:"updated_at"`
+}
+
+// mongoAuditEntry defines how the audit trail entries are stored
+type mongoAuditEntry struct {
+	ID         int64  `bson:"_id"`
+	Timestamp  int64  `bson:"timestamp"`
+	Action     string `bson:"action"`
+	ObjectType string `bson:"object_type"`
+	ObjectName string `bson:"object_name"`
+	Executor   string `bson:"executor"`
+	IP         string `bson:"ip"`
+	Changes    string `bson:"changes"`
+}
+
+func (e *mongoAuditEntry) getAuditEntry() (AuditEntry, error) {
+	entry := AuditEntry{
+		ID:         e.ID,
+		Timestamp:  e.Timestamp,
+		Action:     e.Action,
+		ObjectType: e.ObjectType,
+		ObjectName: e.ObjectName,
+		Executor:   e.Executor,
+		IP:         e.IP,
+	}
+	err := entry.setChangesFromJSON(e.Changes)
+	return entry, err
+}
+
+// mongoDeadLetter defines how the dead letters are stored
+type mongoDeadLetter struct {
+	ID        int64  `bson:"_id"`
+	Timestamp int64  `bson:"timestamp"`
+	Source    string `bson:"source"`
+	Name      string `bson:"name"`
+	Method    string `bson:"method"`
+	URL       string `bson:"url"`
+	Username  string `bson:"username"`
+	Headers   string `bson:"headers"`
+	Body      string `bson:"body"`
+	Error     string `bson:"last_error"`
+	Attempts  int    `bson:"attempts"`
+}
+
+func (d *mongoDeadLetter) getDeadLetter() (DeadLetter, error) {
+	entry := DeadLetter{
+		ID:        d.ID,
+		Timestamp: d.Timestamp,
+		Source:    d.Source,
+		Name:      d.Name,
+		Method:    d.Method,
+		URL:       d.URL,
+		Username:  d.Username,
+		Body:      d.Body,
+		Error:     d.Error,
+		Attempts:  d.Attempts,
+	}
+	err := entry.setHeadersFromJSON(d.Headers)
+	return entry, err
+}
+
+func (n *mongoNode) getNode() (Node, error) {
+	node := Node{
+		Name:      n.Name,
+		CreatedAt: n.CreatedAt,
+		UpdatedAt: n.UpdatedAt,
+	}
+	err := json.Unmarshal([]byte(n.Data), &node.Data)
+	return node, err
+}
+
+// nextSequence returns an autoincrementing integer for the specified collection
+func (db *mongoDB) nextSequence(ctx context.Context, name string) (int64, error) {
+	var result struct {
+		Value int64 `bson:"value"`
+	}
+	err := db.collection(mongoSequencesCollection).FindOneAndUpdate(ctx, bson.M{"_id": name},
+		bson.M{"$inc": bson.M{"value": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&result)
+	if err != nil {
+		return 0, fmt.Errorf("unable to update the sequence for collection %q: %w", name, err)
+	}
+	return result.Value, nil
+}
+
+// hasUpdatesAfter returns true if the specified collection has objects updated
+// after the given time, also by other SFTPGo instances
+func (db *mongoDB) hasUpdatesAfter(collection string, after int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	count, err := db.collection(collection).CountDocuments(ctx, bson.M{"updated_at": bson.M{"$gte": after}},
+		options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+func getMongoActiveTransferKey(transferID int64, connectionID string) string {
+	return fmt.Sprintf("%s_%d", connectionID, transferID)
+}
+
+func (p *MongoDBProvider) checkAvailability() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	return p.db.client.Ping(ctx, readpref.Primary())
+}
+
+func (p *MongoDBProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
+	updated, err := p.db.hasUpdatesAfter(kvUsersBucket, after)
+	if err != nil || !updated {
+		return nil, err
+	}
+	setLastUserUpdate()
+	return p.kvProvider.getRecentlyUpdatedUsers(after)
+}
+
+func (p *MongoDBProvider) getRecentlyUpdatedRules(after int64) ([]EventRule, error) {
+	updated, err := p.db.hasUpdatesAfter(kvRulesBucket, after)
+	if err != nil || !updated {
+		return nil, err
+	}
+	setLastRuleUpdate()
+	return p.kvProvider.getRecentlyUpdatedRules(after)
+}
+
+func (p *MongoDBProvider) getDefenderHosts(from int64, limit int) ([]DefenderEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{"updated_at": bson.M{"$gte": from}},
+			bson.M{"ban_time": bson.M{"$gt": 0}},
+		},
+	}
+	cursor, err := p.db.collection(mongoDefenderHostsCollection).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}}).SetLimit(int64(limit)))
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get defender hosts: %v", err)
+		return nil, err
+	}
+	var hosts []mongoDefenderHost
+	if err := cursor.All(ctx, &hosts); err != nil {
+		providerLog(logger.LevelError, "unable to iterate over defender hosts: %v", err)
+		return nil, err
+	}
+	return p.getDefenderHostsWithScores(ctx, hosts, from)
+}
+
+func (p *MongoDBProvider) getDefenderHostByIP(ip string, from int64) (DefenderEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"_id": ip,
+		"$or": bson.A{
+			bson.M{"updated_at": bson.M{"$gte": from}},
+			bson.M{"ban_time": bson.M{"$gt": 0}},
+		},
+	}
+	var host mongoDefenderHost
+	if err := p.db.collection(mongoDefenderHostsCollection).FindOne(ctx, filter).Decode(&host); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return DefenderEntry{}, util.NewRecordNotFoundError("host not found")
+		}
+		providerLog(logger.LevelError, "unable to get host for ip %q: %v", ip, err)
+		return DefenderEntry{}, err
+	}
+	hosts, err := p.getDefenderHostsWithScores(ctx, []mongoDefenderHost{host}, from)
+	if err != nil {
+		return DefenderEntry{}, err
+	}
+	if len(hosts) == 0 {
+		return DefenderEntry{}, util.NewRecordNotFoundError("host not found")
+	}
+	return hosts[0], nil
+}
+
+// getDefenderHostsWithScores returns the given hosts with their score, banned
+// hosts and hosts with a positive score are returned
+func (p *MongoDBProvider) getDefenderHostsWithScores(ctx context.Context, hosts []mongoDefenderHost, from int64) (
+	[]DefenderEntry, error,
+) {
+	result := make([]DefenderEntry, 0, len(hosts))
+	var ipForScores []string
+	for _, host := range hosts {
+		entry := DefenderEntry{
+			IP: host.IP,
+		}
+		if host.BanTime > 0 {
+			hostBanTime := util.GetTimeFromMsecSinceEpoch(host.BanTime)
+			if hostBanTime.After(time.Now()) {
+				entry.BanTime = hostBanTime
+			}
+		}
+		if entry.BanTime.IsZero() {
+			ipForScores = append(ipForScores, host.IP)
+		}
+		result = append(result, entry)
+	}
+	if len(ipForScores) == 0 {
+		return result, nil
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"ip": bson.M{"$in": ipForScores}, "date_time": bson.M{"$gt": from}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$ip", "score": bson.M{"$sum": "$score"}}}},
+	}
+	cursor, err := p.db.collection(mongoDefenderEventsCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get score for hosts %+v: %v", ipForScores, err)
+		return nil, err
+	}
+	var scores []struct {
+		IP    string `bson:"_id"`
+		Score int    `bson:"score"`
+	}
+	if err := cursor.All(ctx, &scores); err != nil {
+		providerLog(logger.LevelError, "unable to iterate over host scores: %v", err)
+		return nil, err
+	}
+	hostsWithScores := make(map[string]int)
+	for _, s := range scores {
+		if s.Score > 0 {
+			hostsWithScores[s.IP] = s.Score
+		}
+	}
+	entries := make([]DefenderEntry, 0, len(result))
+	for idx := range result {
+		result[idx].Score = hostsWithScores[result[idx].IP]
+		if result[idx].Score > 0 || !result[idx].BanTime.IsZero() {
+			entries = append(entries, result[idx])
+		}
+	}
+	return entries, nil
+}
+
+func (p *MongoDBProvider) isDefenderHostBanned(ip string) (DefenderEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":      ip,
+		"ban_time": bson.M{"$gte": util.GetTimeAsMsSinceEpoch(time.Now())},
+	}
+	var host mongoDefenderHost
+	if err := p.db.collection(mongoDefenderHostsCollection).FindOne(ctx, filter).Decode(&host); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return DefenderEntry{}, util.NewRecordNotFoundError("host not found")
+		}
+		providerLog(logger.LevelError, "unable to check ban status for host %q: %v", ip, err)
+		return DefenderEntry{}, err
+	}
+	return DefenderEntry{IP: host.IP}, nil
+}
+
+func (p *MongoDBProvider) updateDefenderBanTime(ip string, minutes int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	_, err := p.db.collection(mongoDefenderHostsCollection).UpdateOne(ctx, bson.M{"_id": ip},
+		bson.M{"$inc": bson.M{"ban_time": int64(minutes) * 60000}})
+	if err == nil {
+		providerLog(logger.LevelDebug, "ban time updated for ip %q, increment (minutes): %v", ip, minutes)
+	} else {
+		providerLog(logger.LevelError, "error updating ban time for ip %q: %v", ip, err)
+	}
+	return err
+}
+
+func (p *MongoDBProvider) deleteDefenderHost(ip string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	res, err := p.db.collection(mongoDefenderHostsCollection).DeleteOne(ctx, bson.M{"_id": ip})
+	if err != nil {
+		providerLog(logger.LevelError, "unable to delete defender host %q: %v", ip, err)
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return util.NewRecordNotFoundError("no host was deleted")
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) addDefenderEvent(ip string, score int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	_, err := p.db.collection(mongoDefenderHostsCollection).UpdateOne(ctx, bson.M{"_id": ip},
+		bson.M{
+			"$set":         bson.M{"updated_at": now},
+			"$setOnInsert": bson.M{"ban_time": int64(0)},
+		}, options.Update().SetUpsert(true))
+	if err != nil {
+		providerLog(logger.LevelError, "unable to add defender host %q: %v", ip, err)
+		return err
+	}
+	_, err = p.db.collection(mongoDefenderEventsCollection).InsertOne(ctx, mongoDefenderEvent{
+		IP:       ip,
+		DateTime: now,
+		Score:    score,
+	})
+	if err != nil {
+		providerLog(logger.LevelError, "unable to add defender event for %q: %v", ip, err)
+	}
+	return err
+}
+
+func (p *MongoDBProvider) setDefenderBanTime(ip string, banTime int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	_, err := p.db.collection(mongoDefenderHostsCollection).UpdateOne(ctx, bson.M{"_id": ip},
+		bson.M{"$set": bson.M{"ban_time": banTime}})
+	if err == nil {
+		providerLog(logger.LevelDebug, "ip %q banned until %v", ip, util.GetTimeFromMsecSinceEpoch(banTime))
+	} else {
+		providerLog(logger.LevelError, "error setting ban time for ip %q: %v", ip, err)
+	}
+	return err
+}
+
+func (p *MongoDBProvider) cleanupDefender(from int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	events := p.db.collection(mongoDefenderEventsCollection)
+	if _, err := events.DeleteMany(ctx, bson.M{"date_time": bson.M{"$lt": from}}); err != nil {
+		providerLog(logger.LevelError, "unable to cleanup defender events: %v", err)
+		return err
+	}
+	ipWithEvents, err := events.Distinct(ctx, "ip", bson.M{"date_time": bson.M{"$gt": from}})
+	if err != nil {
+		providerLog(logger.LevelError, "unable to cleanup defender hosts: %v", err)
+		return err
+	}
+	_, err = p.db.collection(mongoDefenderHostsCollection).DeleteMany(ctx, bson.M{
+		"ban_time": bson.M{"$lt": util.GetTimeAsMsSinceEpoch(time.Now())},
+		"_id":      bson.M{"$nin": ipWithEvents},
+	})
+	if err != nil {
+		providerLog(logger.LevelError, "unable to cleanup defender hosts: %v", err)
+	}
+	return err
+}
+
+func (p *MongoDBProvider) addActiveTransfer(transfer ActiveTransfer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	_, err := p.db.collection(kvActiveTransfersBucket).InsertOne(ctx, mongoActiveTransfer{
+		Key:           getMongoActiveTransferKey(transfer.ID, transfer.ConnID),
+		ID:            transfer.ID,
+		Type:          transfer.Type,
+		ConnID:        transfer.ConnID,
+		Username:      transfer.Username,
+		FolderName:    transfer.FolderName,
+		IP:            transfer.IP,
+		TruncatedSize: transfer.TruncatedSize,
+		CurrentULSize: transfer.CurrentULSize,
+		CurrentDLSize: transfer.CurrentDLSize,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	})
+	return err
+}
+
+func (p *MongoDBProvider) updateActiveTransferSizes(ulSize, dlSize, transferID int64, connectionID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	_, err := p.db.collection(kvActiveTransfersBucket).UpdateOne(ctx,
+		bson.M{"_id": getMongoActiveTransferKey(transferID, connectionID)},
+		bson.M{"$set": bson.M{
+			"current_ul_size": ulSize,
+			"current_dl_size": dlSize,
+			"updated_at":      util.GetTimeAsMsSinceEpoch(time.Now()),
+		}})
+	return err
+}
+
+func (p *MongoDBProvider) removeActiveTransfer(transferID int64, connectionID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	_, err := p.db.collection(kvActiveTransfersBucket).DeleteOne(ctx,
+		bson.M{"_id": getMongoActiveTransferKey(transferID, connectionID)})
+	return err
+}
+
+func (p *MongoDBProvider) cleanupActiveTransfers(before time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	_, err := p.db.collection(kvActiveTransfersBucket).DeleteMany(ctx,
+		bson.M{"updated_at": bson.M{"$lt": util.GetTimeAsMsSinceEpoch(before)}})
+	return err
+}
+
+func (p *MongoDBProvider) getActiveTransfers(from time.Time) ([]ActiveTransfer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	cursor, err := p.db.collection(kvActiveTransfersBucket).Find(ctx,
+		bson.M{"updated_at": bson.M{"$gt": util.GetTimeAsMsSinceEpoch(from)}})
+	if err != nil {
+		return nil, err
+	}
+	var results []mongoActiveTransfer
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	transfers := make([]ActiveTransfer, 0, len(results))
+	for idx := range results {
+		transfers = append(transfers, results[idx].getActiveTransfer())
+	}
+	return transfers, nil
+}
+
+func (p *MongoDBProvider) addSharedSession(session Session) error {
+	if err := session.validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(session.Data)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	_, err = p.db.collection(kvSharedSessionsBucket).ReplaceOne(ctx, bson.M{"_id": session.Key}, mongoSession{
+		Key:       session.Key,
+		Data:      data,
+		Type:      session.Type,
+		Timestamp: session.Timestamp,
+	}, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (p *MongoDBProvider) deleteSharedSession(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	res, err := p.db.collection(kvSharedSessionsBucket).DeleteOne(ctx, bson.M{"_id": key})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return util.NewRecordNotFoundError(fmt.Sprintf("session %q does not exist", key))
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) getSharedSession(key string) (Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	var session mongoSession
+	if err := p.db.collection(kvSharedSessionsBucket).FindOne(ctx, bson.M{"_id": key}).Decode(&session); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return Session{}, util.NewRecordNotFoundError(fmt.Sprintf("session %q does not exist", key))
+		}
+		return Session{}, err
+	}
+	return Session{
+		Key:       session.Key,
+		Data:      session.Data,
+		Type:      session.Type,
+		Timestamp: session.Timestamp,
+	}, nil
+}
+
+func (p *MongoDBProvider) getSharedSessions(sessionType SessionType, after int64) ([]Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	cursor, err := p.db.collection(kvSharedSessionsBucket).Find(ctx, bson.M{
+		"type":      sessionType,
+		"timestamp": bson.M{"$gte": after},
+	}, options.Find().SetSort(bson.M{"timestamp": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var results []mongoSession
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(results))
+	for idx := range results {
+		sessions = append(sessions, Session{
+			Key:       results[idx].Key,
+			Data:      results[idx].Data,
+			Type:      results[idx].Type,
+			Timestamp: results[idx].Timestamp,
+		})
+	}
+	return sessions, nil
+}
+
+func (p *MongoDBProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	_, err := p.db.collection(kvSharedSessionsBucket).DeleteMany(ctx, bson.M{
+		"type":      sessionType,
+		"timestamp": bson.M{"$lt": before},
+	})
+	return err
+}
+
+func (p *MongoDBProvider) getTaskByName(name string) (Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	var task mongoTask
+	if err := p.db.collection(kvTasksBucket).FindOne(ctx, bson.M{"_id": name}).Decode(&task); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return Task{}, util.NewRecordNotFoundError(fmt.Sprintf("task %q does not exist", name))
+		}
+		return Task{}, err
+	}
+	return Task{
+		Name:     task.Name,
+		UpdateAt: task.UpdateAt,
+		Version:  task.Version,
+	}, nil
+}
+
+func (p *MongoDBProvider) addTask(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	_, err := p.db.collection(kvTasksBucket).InsertOne(ctx, mongoTask{
+		Name:     name,
+		UpdateAt: util.GetTimeAsMsSinceEpoch(time.Now()),
+	})
+	return err
+}
+
+func (p *MongoDBProvider) updateTask(name string, version int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	res, err := p.db.collection(kvTasksBucket).UpdateOne(ctx, bson.M{"_id": name, "version": version},
+		bson.M{
+			"$inc": bson.M{"version": 1},
+			"$set": bson.M{"updated_at": util.GetTimeAsMsSinceEpoch(time.Now())},
+		})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return util.NewRecordNotFoundError(fmt.Sprintf("task %q with version %d does not exist", name, version))
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) updateTaskTimestamp(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	res, err := p.db.collection(kvTasksBucket).UpdateOne(ctx, bson.M{"_id": name},
+		bson.M{"$set": bson.M{"updated_at": util.GetTimeAsMsSinceEpoch(time.Now())}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return util.NewRecordNotFoundError(fmt.Sprintf("task %q does not exist", name))
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) addNode() error {
+	if err := currentNode.validate(); err != nil {
+		return fmt.Errorf("unable to register cluster node: %w", err)
+	}
+	data, err := json.Marshal(currentNode.Data)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	_, err = p.db.collection(kvNodesBucket).UpdateOne(ctx, bson.M{"_id": currentNode.Name},
+		bson.M{
+			"$set":         bson.M{"data": string(data), "updated_at": now},
+			"$setOnInsert": bson.M{"created_at": now},
+		}, options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("unable to register cluster node: %w", err)
+	}
+	providerLog(logger.LevelInfo, "registered as cluster node %q, port: %d, proto: %s",
+		currentNode.Name, currentNode.Data.Port, currentNode.Data.Proto)
+
+	return nil
+}
+
+func (p *MongoDBProvider) getNodeByName(name string) (Node, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	var node mongoNode
+	err := p.db.collection(kvNodesBucket).FindOne(ctx, bson.M{
+		"_id":        name,
+		"updated_at": bson.M{"$gt": util.GetTimeAsMsSinceEpoch(time.Now().Add(activeNodeTimeDiff))},
+	}).Decode(&node)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return Node{}, util.NewRecordNotFoundError(fmt.Sprintf("node %q does not exist", name))
+		}
+		return Node{}, err
+	}
+	return node.getNode()
+}
+
+func (p *MongoDBProvider) getNodes() ([]Node, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	cursor, err := p.db.collection(kvNodesBucket).Find(ctx, bson.M{
+		"_id":        bson.M{"$ne": currentNode.Name},
+		"updated_at": bson.M{"$gt": util.GetTimeAsMsSinceEpoch(time.Now().Add(activeNodeTimeDiff))},
+	}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var results []mongoNode
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	var nodes []Node
+	for idx := range results {
+		node, err := results[idx].getNode()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (p *MongoDBProvider) updateNodeTimestamp() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	res, err := p.db.collection(kvNodesBucket).UpdateOne(ctx, bson.M{"_id": currentNode.Name},
+		bson.M{"$set": bson.M{"updated_at": util.GetTimeAsMsSinceEpoch(time.Now())}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return util.NewRecordNotFoundError(fmt.Sprintf("node %q does not exist", currentNode.Name))
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) cleanupNodes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	_, err := p.db.collection(kvNodesBucket).DeleteMany(ctx, bson.M{
+		"updated_at": bson.M{"$lt": util.GetTimeAsMsSinceEpoch(time.Now().Add(10 * activeNodeTimeDiff))},
+	})
+	return err
+}
+
+func (p *MongoDBProvider) addAuditEntry(entry *AuditEntry) error {
+	changes, err := entry.getChangesAsJSON()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	entry.ID, err = p.db.nextSequence(ctx, kvAuditTrailBucket)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.collection(kvAuditTrailBucket).InsertOne(ctx, mongoAuditEntry{
+		ID:         entry.ID,
+		Timestamp:  entry.Timestamp,
+		Action:     entry.Action,
+		ObjectType: entry.ObjectType,
+		ObjectName: entry.ObjectName,
+		Executor:   entry.Executor,
+		IP:         entry.IP,
+		Changes:    changes,
+	})
+	return err
+}
+
+func (p *MongoDBProvider) getAuditEntries(filters AuditFilters, limit, offset int, order string) ([]AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	filter := bson.M{}
+	for field, value := range map[string]string{
+		"object_type": filters.ObjectType,
+		"object_name": filters.ObjectName,
+		"executor":    filters.Executor,
+		"action":      filters.Action,
+	} {
+		if value != "" {
+			filter[field] = value
+		}
+	}
+	timestamp := bson.M{}
+	if filters.StartTimestamp > 0 {
+		timestamp["$gte"] = filters.StartTimestamp
+	}
+	if filters.EndTimestamp > 0 {
+		timestamp["$lte"] = filters.EndTimestamp
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+	sortOrder := -1
+	if order == OrderASC {
+		sortOrder = 1
+	}
+	cursor, err := p.db.collection(kvAuditTrailBucket).Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "_id", Value: sortOrder}}).SetSkip(int64(offset)).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	var results []mongoAuditEntry
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	entries := make([]AuditEntry, 0, len(results))
+	for idx := range results {
+		entry, err := results[idx].getAuditEntry()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (p *MongoDBProvider) cleanupAuditEntries(before int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	_, err := p.db.collection(kvAuditTrailBucket).DeleteMany(ctx, bson.M{
+		"timestamp": bson.M{"$lt": before},
+	})
+	return err
+}
+
+func (p *MongoDBProvider) addDeadLetter(entry *DeadLetter) error {
+	headers, err := entry.getHeadersAsJSON()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	entry.ID, err = p.db.nextSequence(ctx, kvDeadLettersBucket)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.collection(kvDeadLettersBucket).InsertOne(ctx, mongoDeadLetter{
+		ID:        entry.ID,
+		Timestamp: entry.Timestamp,
+		Source:    entry.Source,
+		Name:      entry.Name,
+		Method:    entry.Method,
+		URL:       entry.URL,
+		Username:  entry.Username,
+		Headers:   headers,
+		Body:      entry.Body,
+		Error:     entry.Error,
+		Attempts:  entry.Attempts,
+	})
+	return err
+}
+
+func (p *MongoDBProvider) getDeadLetters(filters DeadLetterFilters, limit, offset int, order string) ([]DeadLetter, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	filter := bson.M{}
+	if filters.Source != "" {
+		filter["source"] = filters.Source
+	}
+	if filters.Name != "" {
+		filter["name"] = filters.Name
+	}
+	timestamp := bson.M{}
+	if filters.StartTimestamp > 0 {
+		timestamp["$gte"] = filters.StartTimestamp
+	}
+	if filters.EndTimestamp > 0 {
+		timestamp["$lte"] = filters.EndTimestamp
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+	sortOrder := -1
+	if order == OrderASC {
+		sortOrder = 1
+	}
+	cursor, err := p.db.collection(kvDeadLettersBucket).Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "_id", Value: sortOrder}}).SetSkip(int64(offset)).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	var results []mongoDeadLetter
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	entries := make([]DeadLetter, 0, len(results))
+	for idx := range results {
+		entry, err := results[idx].getDeadLetter()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (p *MongoDBProvider) getDeadLetter(id int64) (DeadLetter, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	var result mongoDeadLetter
+	err := p.db.collection(kvDeadLettersBucket).FindOne(ctx, bson.M{"_id": id}).Decode(&result)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return DeadLetter{}, util.NewRecordNotFoundError(fmt.Sprintf("dead letter %d does not exist", id))
+		}
+		return DeadLetter{}, err
+	}
+	return result.getDeadLetter()
+}
+
+func (p *MongoDBProvider) deleteDeadLetter(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	res, err := p.db.collection(kvDeadLettersBucket).DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return util.NewRecordNotFoundError(fmt.Sprintf("dead letter %d does not exist", id))
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) cleanupDeadLetters(before int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	_, err := p.db.collection(kvDeadLettersBucket).DeleteMany(ctx, bson.M{
+		"timestamp": bson.M{"$lt": before},
+	})
+	return err
+}
+
+func (p *MongoDBProvider) close() error {
+	p.cancel()
+	return p.db.Close()
+}
+
+// initializeDatabase creates the indexes and stores the current schema version
+// if the database is not initialized
+func (p *MongoDBProvider) initializeDatabase() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	_, err := getMongoDBDatabaseVersion(ctx, p.db)
+	if err == nil {
+		return ErrNoInitRequired
+	}
+	if _, ok := err.(*util.RecordNotFoundError); !ok {
+		return err
+	}
+	logger.InfoToConsole("creating initial database schema, version %d", mongoDatabaseVersion)
+	providerLog(logger.LevelInfo, "creating initial database schema, version %d", mongoDatabaseVersion)
+	if err := createMongoDBIndexes(ctx, p.db); err != nil {
+		return err
+	}
+	_, err = p.db.collection(mongoLocksCollection).UpdateOne(ctx, bson.M{"_id": mongoLockID},
+		bson.M{"$setOnInsert": bson.M{"counter": int64(0)}}, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	return setMongoDBDatabaseVersion(ctx, p.db, mongoDatabaseVersion)
+}
+
+func (p *MongoDBProvider) migrateDatabase() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	dbVersion, err := getMongoDBDatabaseVersion(ctx, p.db)
+	if err != nil {
+		return err
+	}
+	switch version := dbVersion.Version; {
+	case version == mongoDatabaseVersion:
+		providerLog(logger.LevelDebug, "MongoDB database is up to date, current version: %v", version)
+		return ErrNoInitRequired
+	case version == 25, version == 26:
+		logger.InfoToConsole("updating database schema version: %d -> 27", version)
+		providerLog(logger.LevelInfo, "updating database schema version: %d -> 27", version)
+		if err := createMongoDBIndexes(ctx, p.db); err != nil {
+			return err
+		}
+		return setMongoDBDatabaseVersion(ctx, p.db, 27)
+	case version > mongoDatabaseVersion:
+		providerLog(logger.LevelError, "database schema version %v is newer than the supported one: %v", version,
+			mongoDatabaseVersion)
+		logger.WarnToConsole("database schema version %v is newer than the supported one: %v", version,
+			mongoDatabaseVersion)
+		return nil
+	default:
+		return fmt.Errorf("database schema version not handled: %v", version)
+	}
+}
+
+func (p *MongoDBProvider) revertDatabase(targetVersion int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	dbVersion, err := getMongoDBDatabaseVersion(ctx, p.db)
+	if err != nil {
+		return err
+	}
+	if dbVersion.Version == targetVersion {
+		return errors.New("current version match target version, nothing to do")
+	}
+	switch dbVersion.Version {
+	case 26, 27:
+		if targetVersion != 25 && targetVersion != 26 {
+			return fmt.Errorf("unsupported target version %d, 25 and 26 are the only supported ones", targetVersion)
+		}
+		if targetVersion > dbVersion.Version {
+			return fmt.Errorf("target version %d is newer than the current one", targetVersion)
+		}
+		logger.InfoToConsole("downgrading database schema version: %d -> %d", dbVersion.Version, targetVersion)
+		providerLog(logger.LevelInfo, "downgrading database schema version: %d -> %d", dbVersion.Version, targetVersion)
+		var collections []string
+		if targetVersion < 27 {
+			collections = append(collections, kvDeadLettersBucket)
+		}
+		if targetVersion < 26 {
+			collections = append(collections, kvAuditTrailBucket)
+		}
+		for _, collection := range collections {
+			if err := p.db.collection(collection).Drop(ctx); err != nil {
+				return err
+			}
+			if _, err := p.db.collection(mongoSequencesCollection).DeleteOne(ctx, bson.M{"_id": collection}); err != nil {
+				return err
+			}
+		}
+		return setMongoDBDatabaseVersion(ctx, p.db, targetVersion)
+	default:
+		return fmt.Errorf("database schema version not handled: %v", dbVersion.Version)
+	}
+}
+
+func (p *MongoDBProvider) resetDatabase() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	return p.db.database.Drop(ctx)
+}
+
+func (p *MongoDBProvider) backupDatabase(outputFile string) error {
+	return ErrNotImplemented
+}
+
+func getMongoDBDatabaseVersion(ctx context.Context, db *mongoDB) (schemaVersion, error) {
+	var dbVersion struct {
+		Version int `bson:"version"`
+	}
+	err := db.collection(mongoSchemaVersionCollection).FindOne(ctx, bson.M{"_id": mongoSchemaVersionID}).Decode(&dbVersion)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return schemaVersion{}, util.NewRecordNotFoundError("unable to find database schema version, please run the \"initprovider\" command")
+		}
+		return schemaVersion{}, err
+	}
+	return schemaVersion{Version: dbVersion.Version}, nil
+}
+
+func setMongoDBDatabaseVersion(ctx context.Context, db *mongoDB, version int) error {
+	_, err := db.collection(mongoSchemaVersionCollection).UpdateOne(ctx, bson.M{"_id": mongoSchemaVersionID},
+		bson.M{"$set": bson.M{"version": version}}, options.Update().SetUpsert(true))
+	return err
+}
+
+// createMongoDBIndexes creates the secondary indexes, creating an existing
+// index is a no-op
+func createMongoDBIndexes(ctx context.Context, db *mongoDB) error {
+	indexes := map[string][]mongo.IndexModel{
+		kvUsersBucket: {
+			{Keys: bson.D{{Key: "updated_at", Value: 1}}},
+		},
+		kvRulesBucket: {
+			{Keys: bson.D{{Key: "updated_at", Value: 1}}},
+		},
+		kvSharedSessionsBucket: {
+			{Keys: bson.D{{Key: "type", Value: 1}, {Key: "timestamp", Value: 1}}},
+		},
+		kvActiveTransfersBucket: {
+			{Keys: bson.D{{Key: "updated_at", Value: 1}}},
+		},
+		kvNodesBucket: {
+			{Keys: bson.D{{Key: "updated_at", Value: 1}}},
+		},
+		kvAuditTrailBucket: {
+			{Keys: bson.D{{Key: "timestamp", Value: 1}}},
+			{Keys: bson.D{{Key: "object_type", Value: 1}, {Key: "object_name", Value: 1}}},
+		},
+		kvDeadLettersBucket: {
+			{Keys: bson.D{{Key: "timestamp", Value: 1}}},
+			{Keys: bson.D{{Key: "source", Value: 1}, {Key: "name", Value: 1}}},
+		},
+		mongoDefenderHostsCollection: {
+			{Keys: bson.D{{Key: "updated_at", Value: 1}}},
+			{Keys: bson.D{{Key: "ban_time", Value: 1}}},
+		},
+		mongoDefenderEventsCollection: {
+			{Keys: bson.D{{Key: "ip", Value: 1}, {Key: "date_time", Value: 1}}},
+			{Keys: bson.D{{Key: "date_time", Value: 1}}},
+		},
+	}
+	for collection, models := range indexes {
+		if _, err := db.collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			providerLog(logger.LevelError, "unable to create indexes for collection %q: %v", collection, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// watchDeletes removes from the local caches the objects deleted by other SFTPGo
+// instances sharing the same database
+func (p *MongoDBProvider) watchDeletes(ctx context.Context) {
+	for {
+		if err := p.watchCollections(ctx); err != nil {
+			providerLog(logger.LevelWarn, "MongoDB change stream terminated: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			providerLog(logger.LevelDebug, "MongoDB change stream stopped")
+			return
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func (p *MongoDBProvider) watchCollections(ctx context.Context) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"operationType": "delete",
+			"ns.coll":       bson.M{"$in": bson.A{kvUsersBucket, kvRulesBucket}},
+		}}},
+	}
+	stream, err := p.db.database.Watch(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background()) //nolint:errcheck
+
+	providerLog(logger.LevelDebug, "MongoDB change stream started")
+	for stream.Next(ctx) {
+		var ev struct {
+			NS struct {
+				Coll string `bson:"coll"`
+			} `bson:"ns"`
+			DocumentKey struct {
+				ID string `bson:"_id"`
+			} `bson:"documentKey"`
+		}
+		if err := stream.Decode(&ev); err != nil {
+			providerLog(logger.LevelWarn, "unable to decode MongoDB change event: %v", err)
+			continue
+		}
+		switch ev.NS.Coll {
+		case kvUsersBucket:
+			removeUserCaches(ev.DocumentKey.ID)
+		case kvRulesBucket:
+			providerLog(logger.LevelDebug, "event rule %q removed", ev.DocumentKey.ID)
+			if fnRemoveRule != nil {
+				fnRemoveRule(ev.DocumentKey.ID)
+			}
+		}
+	}
+	return stream.Err()
+}
@@ -0,0 +1,231 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nomongodb
+// +build !nomongodb
+
+package dataprovider
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	mongoTestDatabase = "sftpgo_provider_test"
+)
+
+// initializeMongoDBTestProvider initializes a MongoDB provider using a dedicated
+// database. The tests are executed only if MongoDB is the configured data provider,
+// the connection settings are read from the same environment variables used to
+// configure SFTPGo
+func initializeMongoDBTestProvider(t *testing.T) *MongoDBProvider {
+	if os.Getenv("SFTPGO_DATA_PROVIDER__DRIVER") != MongoDBDataProviderName {
+		t.Skip("MongoDB is not the configured data provider")
+	}
+	cnf := getTestProviderConfig(MongoDBDataProviderName, mongoTestDatabase)
+	cnf.ConnectionString = os.Getenv("SFTPGO_DATA_PROVIDER__CONNECTION_STRING")
+	cnf.Host = os.Getenv("SFTPGO_DATA_PROVIDER__HOST")
+	if cnf.Host == "" {
+		cnf.Host = "127.0.0.1"
+	}
+	cnf.Port, _ = strconv.Atoi(os.Getenv("SFTPGO_DATA_PROVIDER__PORT"))
+	cnf.Username = os.Getenv("SFTPGO_DATA_PROVIDER__USERNAME")
+	cnf.Password = os.Getenv("SFTPGO_DATA_PROVIDER__PASSWORD")
+	err := InitializeDatabase(cnf, t.TempDir())
+	if !errors.Is(err, ErrNoInitRequired) {
+		require.NoError(t, err)
+	}
+	p, ok := provider.(*MongoDBProvider)
+	require.True(t, ok)
+	t.Cleanup(func() {
+		assert.NoError(t, p.resetDatabase())
+		assert.NoError(t, p.close())
+	})
+	return p
+}
+
+func getMongoDBTestCollections(t *testing.T, p *MongoDBProvider) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	names, err := p.db.database.ListCollectionNames(ctx, bson.M{})
+	require.NoError(t, err)
+	return names
+}
+
+func getMongoDBTestVersion(t *testing.T, p *MongoDBProvider) int {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	dbVersion, err := getMongoDBDatabaseVersion(ctx, p.db)
+	require.NoError(t, err)
+	return dbVersion.Version
+}
+
+func setMongoDBTestVersion(t *testing.T, p *MongoDBProvider, version int) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	err := setMongoDBDatabaseVersion(ctx, p.db, version)
+	require.NoError(t, err)
+}
+
+func TestMongoDBClientOptions(t *testing.T) {
+	c := config
+	defer func() {
+		config = c
+	}()
+
+	config = Config{
+		Host:     "host1, host2:27018,",
+		Port:     27019,
+		Username: "user",
+		Password: "pwd",
+		PoolSize: 5,
+	}
+	opts, err := getMongoDBClientOptions()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"host1:27019", "host2:27018"}, opts.Hosts)
+	if assert.NotNil(t, opts.Auth) {
+		assert.Equal(t, "user", opts.Auth.Username)
+		assert.Equal(t, "pwd", opts.Auth.Password)
+	}
+	if assert.NotNil(t, opts.MaxPoolSize) {
+		assert.Equal(t, uint64(5), *opts.MaxPoolSize)
+	}
+	assert.Nil(t, opts.TLSConfig)
+
+	config = Config{
+		Host: "host",
+	}
+	opts, err = getMongoDBClientOptions()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"host:27017"}, opts.Hosts)
+	assert.Nil(t, opts.Auth)
+
+	config = Config{
+		ConnectionString: "mongodb://db1:27000,db2:27001/?replicaSet=rs0",
+		SSLMode:          2,
+	}
+	opts, err = getMongoDBClientOptions()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"db1:27000", "db2:27001"}, opts.Hosts)
+	if assert.NotNil(t, opts.TLSConfig) {
+		assert.True(t, opts.TLSConfig.InsecureSkipVerify)
+	}
+
+	config.SSLMode = 1
+	config.RootCert = "missing_root.crt"
+	_, err = getMongoDBClientOptions()
+	assert.ErrorContains(t, err, "unable to load root certificate")
+	config.RootCert = ""
+	config.ClientCert = "missing.crt"
+	config.ClientKey = "missing.key"
+	_, err = getMongoDBClientOptions()
+	assert.ErrorContains(t, err, "unable to load key pair")
+}
+
+func TestMongoDBProviderCRUD(t *testing.T) {
+	p := initializeMongoDBTestProvider(t)
+
+	testProviderUsersFoldersGroups(t, p)
+	testProviderAdmins(t, p)
+}
+
+func TestMongoDBTransactions(t *testing.T) {
+	p := initializeMongoDBTestProvider(t)
+
+	testKVStoreTransactions(t, p.db)
+}
+
+func TestMongoDBMigrations(t *testing.T) {
+	p := initializeMongoDBTestProvider(t)
+
+	assert.Equal(t, mongoDatabaseVersion, getMongoDBTestVersion(t, p))
+	err := p.initializeDatabase()
+	assert.ErrorIs(t, err, ErrNoInitRequired)
+	err = p.migrateDatabase()
+	assert.ErrorIs(t, err, ErrNoInitRequired)
+	collections := getMongoDBTestCollections(t, p)
+	assert.Contains(t, collections, kvDeadLettersBucket)
+	assert.Contains(t, collections, kvAuditTrailBucket)
+
+	err = p.revertDatabase(mongoDatabaseVersion)
+	assert.ErrorContains(t, err, "current version match target version")
+	err = p.revertDatabase(24)
+	assert.ErrorContains(t, err, "unsupported target version")
+
+	err = p.revertDatabase(26)
+	require.NoError(t, err)
+	assert.Equal(t, 26, getMongoDBTestVersion(t, p))
+	collections = getMongoDBTestCollections(t, p)
+	assert.NotContains(t, collections, kvDeadLettersBucket)
+	assert.Contains(t, collections, kvAuditTrailBucket)
+	err = p.revertDatabase(27)
+	assert.ErrorContains(t, err, "is newer than the current one")
+
+	err = p.revertDatabase(25)
+	require.NoError(t, err)
+	assert.Equal(t, 25, getMongoDBTestVersion(t, p))
+	collections = getMongoDBTestCollections(t, p)
+	assert.NotContains(t, collections, kvDeadLettersBucket)
+	assert.NotContains(t, collections, kvAuditTrailBucket)
+	err = p.revertDatabase(26)
+	assert.ErrorContains(t, err, "database schema version not handled")
+
+	err = p.migrateDatabase()
+	require.NoError(t, err)
+	assert.Equal(t, mongoDatabaseVersion, getMongoDBTestVersion(t, p))
+	collections = getMongoDBTestCollections(t, p)
+	assert.Contains(t, collections, kvDeadLettersBucket)
+	assert.Contains(t, collections, kvAuditTrailBucket)
+	// newer schema versions are accepted
+	setMongoDBTestVersion(t, p, mongoDatabaseVersion+1)
+	err = p.migrateDatabase()
+	assert.NoError(t, err)
+	setMongoDBTestVersion(t, p, 20)
+	err = p.migrateDatabase()
+	assert.ErrorContains(t, err, "database schema version not handled")
+	setMongoDBTestVersion(t, p, mongoDatabaseVersion)
+
+	err = p.resetDatabase()
+	require.NoError(t, err)
+	_, err = p.userExists("missing")
+	assertNotFoundError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+	_, err = getMongoDBDatabaseVersion(ctx, p.db)
+	assertNotFoundError(t, err)
+	err = p.migrateDatabase()
+	assertNotFoundError(t, err)
+	err = p.initializeDatabase()
+	require.NoError(t, err)
+	assert.Equal(t, mongoDatabaseVersion, getMongoDBTestVersion(t, p))
+	err = p.backupDatabase("")
+	assert.ErrorIs(t, err, ErrNotImplemented)
+}
+
+func TestMongoDBSharedSessions(t *testing.T) {
+	initializeMongoDBTestProvider(t)
+
+	testProviderSharedSessions(t)
+}
@@ -0,0 +1,123 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// MultipartUploadSettings overrides the multipart upload part size and
+// concurrency defined in the S3 and Azure Blob Storage filesystem configs.
+// The settings are applied to the home directory, if Path is "/", or to the
+// virtual folder mounted on Path. The settings defined for the nearest
+// directory are applied, so the settings for "/" are also inherited by the
+// virtual folders without specific settings. The server side limits, if any,
+// are always applied
+type MultipartUploadSettings struct {
+	// Virtual path, "/" or the path of a virtual folder
+	Path string `json:"path"`
+	// Part size as MB, 0 means the part size defined in the filesystem config
+	PartSize int64 `json:"part_size,omitempty"`
+	// Number of parts uploaded in parallel, 0 means the concurrency defined
+	// in the filesystem config
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+func (s *MultipartUploadSettings) validate() error {
+	if s.Path == "" {
+		return util.NewValidationError("multipart upload settings: path is mandatory")
+	}
+	if !path.IsAbs(s.Path) {
+		return util.NewValidationError(fmt.Sprintf("multipart upload settings: invalid path %q, it must be an absolute path",
+			s.Path))
+	}
+	s.Path = util.CleanPath(s.Path)
+	if s.PartSize != 0 && (s.PartSize < 5 || s.PartSize > 5000) {
+		return util.NewValidationError(fmt.Sprintf("multipart upload settings for %q: invalid part size %d, it must be 0 or between 5 and 5000 (MB)",
+			s.Path, s.PartSize))
+	}
+	if s.Concurrency < 0 || s.Concurrency > 64 {
+		return util.NewValidationError(fmt.Sprintf("multipart upload settings for %q: invalid concurrency %d", s.Path,
+			s.Concurrency))
+	}
+	if s.PartSize == 0 && s.Concurrency == 0 {
+		return util.NewValidationError(fmt.Sprintf("multipart upload settings for %q: part size or concurrency is required",
+			s.Path))
+	}
+	return nil
+}
+
+func validateUserMultipartUploads(user *User) error {
+	seen := make(map[string]bool)
+	for idx := range user.Filters.MultipartUploads {
+		settings := &user.Filters.MultipartUploads[idx]
+		if err := settings.validate(); err != nil {
+			return err
+		}
+		if seen[settings.Path] {
+			return util.NewValidationError(fmt.Sprintf("multipart upload settings for %q are duplicated", settings.Path))
+		}
+		seen[settings.Path] = true
+	}
+	return nil
+}
+
+// GetMultipartUploadSettings returns the multipart upload settings to apply
+// to the filesystem mounted on the specified virtual path, "/" for the home
+// directory. nil means that the filesystem config is used as is
+func (u *User) GetMultipartUploadSettings(mountPath string) *MultipartUploadSettings {
+	if len(u.Filters.MultipartUploads) == 0 {
+		return nil
+	}
+	for _, dir := range util.GetDirsForVirtualPath(mountPath) {
+		for idx := range u.Filters.MultipartUploads {
+			if u.Filters.MultipartUploads[idx].Path == dir {
+				return &u.Filters.MultipartUploads[idx]
+			}
+		}
+	}
+	return nil
+}
+
+// applyMultipartUploadSettings overrides the multipart upload settings in the
+// specified filesystem config, mounted on the specified virtual path
+func (u *User) applyMultipartUploadSettings(mountPath string, fsConfig *vfs.Filesystem) {
+	settings := u.GetMultipartUploadSettings(mountPath)
+	if settings == nil {
+		return
+	}
+	switch fsConfig.Provider {
+	case sdk.S3FilesystemProvider:
+		if settings.PartSize > 0 {
+			fsConfig.S3Config.UploadPartSize = settings.PartSize
+		}
+		if settings.Concurrency > 0 {
+			fsConfig.S3Config.UploadConcurrency = settings.Concurrency
+		}
+	case sdk.AzureBlobFilesystemProvider:
+		if settings.PartSize > 0 {
+			fsConfig.AzBlobConfig.UploadPartSize = settings.PartSize
+		}
+		if settings.Concurrency > 0 {
+			fsConfig.AzBlobConfig.UploadConcurrency = settings.Concurrency
+		}
+	}
+}
@@ -40,6 +40,7 @@ const (
 	mysqlResetSQL = "DROP TABLE IF EXISTS `{{api_keys}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{folders_mapping}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{users_folders_mapping}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{users_folders_quota}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{users_groups_mapping}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{admins_groups_mapping}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{groups_folders_mapping}}` CASCADE;" +
@@ -57,6 +58,8 @@ const (
 		"DROP TABLE IF EXISTS `{{events_rules}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{tasks}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{nodes}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{audit_trail}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{dead_letters}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{schema_version}}` CASCADE;"
 	mysqlInitialSQL = "CREATE TABLE `{{schema_version}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `version` integer NOT NULL);" +
 		"CREATE TABLE `{{admins}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `username` varchar(255) NOT NULL UNIQUE, " +
@@ -187,11 +190,69 @@ const (
 		"`name` varchar(255) NOT NULL UNIQUE, `data` longtext NOT NULL, `created_at` bigint NOT NULL, " +
 		"`updated_at` bigint NOT NULL);"
 	mysqlV23DownSQL = "DROP TABLE `{{nodes}}` CASCADE;"
+	mysqlV24SQL     = "ALTER TABLE `{{users}}` ADD COLUMN `metadata` longtext NULL; " +
+		"ALTER TABLE `{{groups}}` ADD COLUMN `metadata` longtext NULL; " +
+		"ALTER TABLE `{{folders}}` ADD COLUMN `metadata` longtext NULL;"
+	mysqlV24DownSQL = "ALTER TABLE `{{folders}}` DROP COLUMN `metadata`; " +
+		"ALTER TABLE `{{groups}}` DROP COLUMN `metadata`; " +
+		"ALTER TABLE `{{users}}` DROP COLUMN `metadata`;"
+	mysqlV25SQL = "ALTER TABLE `{{users}}` ADD COLUMN `soft_deleted_at` bigint DEFAULT 0 NOT NULL; " +
+		"ALTER TABLE `{{users}}` ALTER COLUMN `soft_deleted_at` DROP DEFAULT; " +
+		"CREATE INDEX `{{prefix}}users_soft_deleted_at_idx` ON `{{users}}` (`soft_deleted_at`);"
+	mysqlV25DownSQL = "DROP INDEX `{{prefix}}users_soft_deleted_at_idx` ON `{{users}}`; " +
+		"ALTER TABLE `{{users}}` DROP COLUMN `soft_deleted_at`;"
+	mysqlV26SQL = "CREATE TABLE `{{audit_trail}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`timestamp` bigint NOT NULL, `action` varchar(32) NOT NULL, `object_type` varchar(50) NOT NULL, " +
+		"`object_name` varchar(255) NOT NULL, `executor` varchar(255) NOT NULL, `ip` varchar(50) NOT NULL, " +
+		"`changes` longtext NULL); " +
+		"CREATE INDEX `{{prefix}}audit_trail_timestamp_idx` ON `{{audit_trail}}` (`timestamp`); " +
+		"CREATE INDEX `{{prefix}}audit_trail_object_idx` ON `{{audit_trail}}` (`object_type`, `object_name`);"
+	mysqlV26DownSQL = "DROP TABLE `{{audit_trail}}` CASCADE;"
+	mysqlV27SQL     = "ALTER TABLE `{{folders}}` ADD COLUMN `soft_deleted_at` bigint DEFAULT 0 NOT NULL; " +
+		"ALTER TABLE `{{folders}}` ALTER COLUMN `soft_deleted_at` DROP DEFAULT; " +
+		"CREATE INDEX `{{prefix}}folders_soft_deleted_at_idx` ON `{{folders}}` (`soft_deleted_at`);"
+	mysqlV27DownSQL = "DROP INDEX `{{prefix}}folders_soft_deleted_at_idx` ON `{{folders}}`; " +
+		"ALTER TABLE `{{folders}}` DROP COLUMN `soft_deleted_at`;"
+	mysqlV28SQL = "CREATE TABLE `{{dead_letters}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`timestamp` bigint NOT NULL, `source` varchar(50) NOT NULL, `name` varchar(255) NOT NULL, " +
+		"`method` varchar(10) NOT NULL, `url` longtext NOT NULL, `username` varchar(255) NULL, " +
+		"`headers` longtext NULL, `body` longtext NULL, `last_error` longtext NULL, `attempts` integer NOT NULL); " +
+		"CREATE INDEX `{{prefix}}dead_letters_timestamp_idx` ON `{{dead_letters}}` (`timestamp`); " +
+		"CREATE INDEX `{{prefix}}dead_letters_source_name_idx` ON `{{dead_letters}}` (`source`, `name`);"
+	mysqlV28DownSQL = "DROP TABLE `{{dead_letters}}` CASCADE;"
+	mysqlV29SQL     = "ALTER TABLE `{{users_folders_mapping}}` ADD COLUMN `user_quota_size` bigint DEFAULT 0 NOT NULL; " +
+		"ALTER TABLE `{{users_folders_mapping}}` ALTER COLUMN `user_quota_size` DROP DEFAULT; " +
+		"ALTER TABLE `{{users_folders_mapping}}` ADD COLUMN `user_quota_files` integer DEFAULT 0 NOT NULL; " +
+		"ALTER TABLE `{{users_folders_mapping}}` ALTER COLUMN `user_quota_files` DROP DEFAULT; " +
+		"ALTER TABLE `{{groups_folders_mapping}}` ADD COLUMN `user_quota_size` bigint DEFAULT 0 NOT NULL; " +
+		"ALTER TABLE `{{groups_folders_mapping}}` ALTER COLUMN `user_quota_size` DROP DEFAULT; " +
+		"ALTER TABLE `{{groups_folders_mapping}}` ADD COLUMN `user_quota_files` integer DEFAULT 0 NOT NULL; " +
+		"ALTER TABLE `{{groups_folders_mapping}}` ALTER COLUMN `user_quota_files` DROP DEFAULT; " +
+		"CREATE TABLE `{{users_folders_quota}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`user_id` integer NOT NULL, `folder_id` integer NOT NULL, `used_quota_size` bigint NOT NULL, " +
+		"`used_quota_files` integer NOT NULL, `last_quota_update` bigint NOT NULL); " +
+		"ALTER TABLE `{{users_folders_quota}}` ADD CONSTRAINT `{{prefix}}unique_user_folder_quota` " +
+		"UNIQUE (`user_id`, `folder_id`); " +
+		"ALTER TABLE `{{users_folders_quota}}` ADD CONSTRAINT `{{prefix}}users_folders_quota_user_id_fk_users_id` " +
+		"FOREIGN KEY (`user_id`) REFERENCES `{{users}}` (`id`) ON DELETE CASCADE; " +
+		"ALTER TABLE `{{users_folders_quota}}` ADD CONSTRAINT `{{prefix}}users_folders_quota_folder_id_fk_folders_id` " +
+		"FOREIGN KEY (`folder_id`) REFERENCES `{{folders}}` (`id`) ON DELETE CASCADE;"
+	mysqlV29DownSQL = "DROP TABLE `{{users_folders_quota}}` CASCADE; " +
+		"ALTER TABLE `{{groups_folders_mapping}}` DROP COLUMN `user_quota_files`; " +
+		"ALTER TABLE `{{groups_folders_mapping}}` DROP COLUMN `user_quota_size`; " +
+		"ALTER TABLE `{{users_folders_mapping}}` DROP COLUMN `user_quota_files`; " +
+		"ALTER TABLE `{{users_folders_mapping}}` DROP COLUMN `user_quota_size`;"
+	mysqlV30SQL = "ALTER TABLE `{{users}}` ADD COLUMN `last_transfer_quota_reset` bigint DEFAULT 0 NOT NULL; " +
+		"ALTER TABLE `{{users}}` ALTER COLUMN `last_transfer_quota_reset` DROP DEFAULT;"
+	mysqlV30DownSQL = "ALTER TABLE `{{users}}` DROP COLUMN `last_transfer_quota_reset`;"
+	mysqlV31SQL     = "ALTER TABLE `{{api_keys}}` ADD COLUMN `scopes` longtext NULL;"
+	mysqlV31DownSQL = "ALTER TABLE `{{api_keys}}` DROP COLUMN `scopes`;"
 )
 
 // MySQLProvider defines the auth provider for MySQL/MariaDB database
 type MySQLProvider struct {
 	dbHandle *sql.DB
+	replicas *sqlReadReplicas
 }
 
 func init() {
@@ -221,7 +282,12 @@ func initializeMySQLProvider() error {
 		}
 		dbHandle.SetConnMaxLifetime(240 * time.Second)
 		dbHandle.SetConnMaxIdleTime(120 * time.Second)
-		provider = &MySQLProvider{dbHandle: dbHandle}
+		replicas, err := newSQLReadReplicas("mysql")
+		if err != nil {
+			dbHandle.Close()
+			return err
+		}
+		provider = &MySQLProvider{dbHandle: dbHandle, replicas: replicas}
 	} else {
 		providerLog(logger.LevelError, "error creating mysql database handler, connection string: %#v, error: %v",
 			redactedConnString, err)
@@ -289,25 +355,30 @@ func registerMySQLCustomTLSConfig() error {
 }
 
 func (p *MySQLProvider) checkAvailability() error {
+	p.replicas.checkAvailability()
 	return sqlCommonCheckAvailability(p.dbHandle)
 }
 
 func (p *MySQLProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
-	return sqlCommonValidateUserAndPass(username, password, ip, protocol, p.dbHandle)
+	return sqlCommonValidateUserAndPass(username, password, ip, protocol, p.replicas, p.dbHandle)
 }
 
 func (p *MySQLProvider) validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error) {
-	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.dbHandle)
+	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.replicas, p.dbHandle)
 }
 
 func (p *MySQLProvider) validateUserAndPubKey(username string, publicKey []byte, isSSHCert bool) (User, string, error) {
-	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.dbHandle)
+	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.replicas, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error {
 	return sqlCommonUpdateTransferQuota(username, uploadSize, downloadSize, reset, p.dbHandle)
 }
 
+func (p *MySQLProvider) resetUserTransferQuota(username string, scheduledAt int64) (bool, error) {
+	return sqlCommonResetUserTransferQuota(username, scheduledAt, p.dbHandle)
+}
+
 func (p *MySQLProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
 	return sqlCommonUpdateQuota(username, filesAdd, sizeAdd, reset, p.dbHandle)
 }
@@ -329,23 +400,29 @@ func (p *MySQLProvider) updateAdminLastLogin(username string) error {
 }
 
 func (p *MySQLProvider) userExists(username string) (User, error) {
-	return sqlCommonGetUserByUsername(username, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, username, func(dbHandle sqlQuerier) (User, error) {
+		return sqlCommonGetUserByUsername(username, dbHandle)
+	})
 }
 
 func (p *MySQLProvider) addUser(user *User) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonAddUser(user, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateUser(user *User) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonUpdateUser(user, p.dbHandle)
 }
 
 func (p *MySQLProvider) deleteUser(user User, softDelete bool) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonDeleteUser(user, softDelete, p.dbHandle)
 }
 
-func (p *MySQLProvider) updateUserPassword(username, password string) error {
-	return sqlCommonUpdateUserPassword(username, password, p.dbHandle)
+func (p *MySQLProvider) updateUserPassword(username, password string, state *userPasswordState) error {
+	defer p.replicas.trackWrite(username)
+	return sqlCommonUpdateUserPassword(username, password, state, p.dbHandle)
 }
 
 func (p *MySQLProvider) dumpUsers() ([]User, error) {
@@ -357,7 +434,13 @@ func (p *MySQLProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
 }
 
 func (p *MySQLProvider) getUsers(limit int, offset int, order string) ([]User, error) {
-	return sqlCommonGetUsers(limit, offset, order, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]User, error) {
+		return sqlCommonGetUsers(limit, offset, order, false, dbHandle)
+	})
+}
+
+func (p *MySQLProvider) getSoftDeletedUsers(limit int, offset int, order string) ([]User, error) {
+	return sqlCommonGetUsers(limit, offset, order, true, p.dbHandle)
 }
 
 func (p *MySQLProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
@@ -369,7 +452,18 @@ func (p *MySQLProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 }
 
 func (p *MySQLProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
-	return sqlCommonGetFolders(limit, offset, order, minimal, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error) {
+		return sqlCommonGetFolders(limit, offset, order, minimal, false, dbHandle)
+	})
+}
+
+func (p *MySQLProvider) getSoftDeletedFolders(limit, offset int, order string) ([]vfs.BaseVirtualFolder, error) {
+	return sqlCommonGetFolders(limit, offset, order, false, true, p.dbHandle)
+}
+
+func (p *MySQLProvider) setFolderSoftDeletedAt(name string, softDeletedAt int64) error {
+	defer p.replicas.trackWrite("")
+	return sqlCommonSetFolderSoftDeletedAt(name, softDeletedAt, p.dbHandle)
 }
 
 func (p *MySQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
@@ -379,14 +473,17 @@ func (p *MySQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, err
 }
 
 func (p *MySQLProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonAddFolder(folder, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonUpdateFolder(folder, p.dbHandle)
 }
 
 func (p *MySQLProvider) deleteFolder(folder vfs.BaseVirtualFolder) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonDeleteFolder(folder, p.dbHandle)
 }
 
@@ -394,16 +491,28 @@ func (p *MySQLProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int
 	return sqlCommonUpdateFolderQuota(name, filesAdd, sizeAdd, reset, p.dbHandle)
 }
 
+func (p *MySQLProvider) updateUserFolderQuota(username, folderName string, filesAdd int, sizeAdd int64, reset bool) error {
+	return sqlCommonUpdateUserFolderQuota(username, folderName, filesAdd, sizeAdd, reset, p.dbHandle)
+}
+
+func (p *MySQLProvider) getUserFoldersQuota(username string) ([]UserFolderQuota, error) {
+	return sqlCommonGetUserFoldersQuota(username, p.dbHandle)
+}
+
 func (p *MySQLProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return sqlCommonGetFolderUsedQuota(name, p.dbHandle)
 }
 
 func (p *MySQLProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
-	return sqlCommonGetGroups(limit, offset, order, minimal, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]Group, error) {
+		return sqlCommonGetGroups(limit, offset, order, minimal, dbHandle)
+	})
 }
 
 func (p *MySQLProvider) getGroupsWithNames(names []string) ([]Group, error) {
-	return sqlCommonGetGroupsWithNames(names, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]Group, error) {
+		return sqlCommonGetGroupsWithNames(names, dbHandle)
+	})
 }
 
 func (p *MySQLProvider) getUsersInGroups(names []string) ([]string, error) {
@@ -411,18 +520,23 @@ func (p *MySQLProvider) getUsersInGroups(names []string) ([]string, error) {
 }
 
 func (p *MySQLProvider) groupExists(name string) (Group, error) {
-	return sqlCommonGetGroupByName(name, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) (Group, error) {
+		return sqlCommonGetGroupByName(name, dbHandle)
+	})
 }
 
 func (p *MySQLProvider) addGroup(group *Group) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonAddGroup(group, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateGroup(group *Group) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonUpdateGroup(group, p.dbHandle)
 }
 
 func (p *MySQLProvider) deleteGroup(group Group) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonDeleteGroup(group, p.dbHandle)
 }
 
@@ -435,19 +549,24 @@ func (p *MySQLProvider) adminExists(username string) (Admin, error) {
 }
 
 func (p *MySQLProvider) addAdmin(admin *Admin) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonAddAdmin(admin, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateAdmin(admin *Admin) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonUpdateAdmin(admin, p.dbHandle)
 }
 
 func (p *MySQLProvider) deleteAdmin(admin Admin) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonDeleteAdmin(admin, p.dbHandle)
 }
 
 func (p *MySQLProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
-	return sqlCommonGetAdmins(limit, offset, order, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]Admin, error) {
+		return sqlCommonGetAdmins(limit, offset, order, dbHandle)
+	})
 }
 
 func (p *MySQLProvider) dumpAdmins() ([]Admin, error) {
@@ -463,19 +582,24 @@ func (p *MySQLProvider) apiKeyExists(keyID string) (APIKey, error) {
 }
 
 func (p *MySQLProvider) addAPIKey(apiKey *APIKey) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonAddAPIKey(apiKey, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateAPIKey(apiKey *APIKey) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonUpdateAPIKey(apiKey, p.dbHandle)
 }
 
 func (p *MySQLProvider) deleteAPIKey(apiKey APIKey) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonDeleteAPIKey(apiKey, p.dbHandle)
 }
 
 func (p *MySQLProvider) getAPIKeys(limit int, offset int, order string) ([]APIKey, error) {
-	return sqlCommonGetAPIKeys(limit, offset, order, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]APIKey, error) {
+		return sqlCommonGetAPIKeys(limit, offset, order, dbHandle)
+	})
 }
 
 func (p *MySQLProvider) dumpAPIKeys() ([]APIKey, error) {
@@ -491,19 +615,24 @@ func (p *MySQLProvider) shareExists(shareID, username string) (Share, error) {
 }
 
 func (p *MySQLProvider) addShare(share *Share) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonAddShare(share, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateShare(share *Share) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonUpdateShare(share, p.dbHandle)
 }
 
 func (p *MySQLProvider) deleteShare(share Share) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonDeleteShare(share, p.dbHandle)
 }
 
 func (p *MySQLProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
-	return sqlCommonGetShares(limit, offset, order, username, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]Share, error) {
+		return sqlCommonGetShares(limit, offset, order, username, dbHandle)
+	})
 }
 
 func (p *MySQLProvider) dumpShares() ([]Share, error) {
@@ -578,6 +707,10 @@ func (p *MySQLProvider) getSharedSession(key string) (Session, error) {
 	return sqlCommonGetSession(key, p.dbHandle)
 }
 
+func (p *MySQLProvider) getSharedSessions(sessionType SessionType, after int64) ([]Session, error) {
+	return sqlCommonGetSessions(sessionType, after, p.dbHandle)
+}
+
 func (p *MySQLProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
@@ -670,15 +803,59 @@ func (p *MySQLProvider) cleanupNodes() error {
 	return sqlCommonCleanupNodes(p.dbHandle)
 }
 
+func (p *MySQLProvider) addAuditEntry(entry *AuditEntry) error {
+	return sqlCommonAddAuditEntry(entry, p.dbHandle)
+}
+
+func (p *MySQLProvider) getAuditEntries(filters AuditFilters, limit, offset int, order string) ([]AuditEntry, error) {
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]AuditEntry, error) {
+		return sqlCommonGetAuditEntries(filters, limit, offset, order, dbHandle)
+	})
+}
+
+func (p *MySQLProvider) cleanupAuditEntries(before int64) error {
+	return sqlCommonCleanupAuditEntries(before, p.dbHandle)
+}
+
+func (p *MySQLProvider) addDeadLetter(entry *DeadLetter) error {
+	return sqlCommonAddDeadLetter(entry, p.dbHandle)
+}
+
+func (p *MySQLProvider) getDeadLetters(filters DeadLetterFilters, limit, offset int, order string) ([]DeadLetter, error) {
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]DeadLetter, error) {
+		return sqlCommonGetDeadLetters(filters, limit, offset, order, dbHandle)
+	})
+}
+
+func (p *MySQLProvider) getDeadLetter(id int64) (DeadLetter, error) {
+	return sqlCommonGetDeadLetter(id, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteDeadLetter(id int64) error {
+	return sqlCommonDeleteDeadLetter(id, p.dbHandle)
+}
+
+func (p *MySQLProvider) cleanupDeadLetters(before int64) error {
+	return sqlCommonCleanupDeadLetters(before, p.dbHandle)
+}
+
 func (p *MySQLProvider) setFirstDownloadTimestamp(username string) error {
+	defer p.replicas.trackWrite(username)
 	return sqlCommonSetFirstDownloadTimestamp(username, p.dbHandle)
 }
 
 func (p *MySQLProvider) setFirstUploadTimestamp(username string) error {
+	defer p.replicas.trackWrite(username)
 	return sqlCommonSetFirstUploadTimestamp(username, p.dbHandle)
 }
 
+func (p *MySQLProvider) setUserSoftDeletedAt(username string, softDeletedAt int64) error {
+	defer p.replicas.trackWrite("")
+	return sqlCommonSetUserSoftDeletedAt(username, softDeletedAt, p.dbHandle)
+}
+
 func (p *MySQLProvider) close() error {
+	p.replicas.close()
 	return p.dbHandle.Close()
 }
 
@@ -725,6 +902,22 @@ func (p *MySQLProvider) migrateDatabase() error { //nolint:dupl
 		return updateMySQLDatabaseFromV21(p.dbHandle)
 	case version == 22:
 		return updateMySQLDatabaseFromV22(p.dbHandle)
+	case version == 23:
+		return updateMySQLDatabaseFromV23(p.dbHandle)
+	case version == 24:
+		return updateMySQLDatabaseFromV24(p.dbHandle)
+	case version == 25:
+		return updateMySQLDatabaseFromV25(p.dbHandle)
+	case version == 26:
+		return updateMySQLDatabaseFromV26(p.dbHandle)
+	case version == 27:
+		return updateMySQLDatabaseFromV27(p.dbHandle)
+	case version == 28:
+		return updateMySQLDatabaseFromV28(p.dbHandle)
+	case version == 29:
+		return updateMySQLDatabaseFromV29(p.dbHandle)
+	case version == 30:
+		return updateMySQLDatabaseFromV30(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %v is newer than the supported one: %v", version,
@@ -742,19 +935,35 @@ func (p *MySQLProvider) revertDatabase(targetVersion int) error {
 	if err != nil {
 		return err
 	}
-	if dbVersion.Version == targetVersion {
-		return errors.New("current version match target version, nothing to do")
+	if err := validateRevertTargetVersion(dbVersion.Version, targetVersion); err != nil {
+		return err
 	}
 
 	switch dbVersion.Version {
 	case 20:
 		return downgradeMySQLDatabaseFromV20(p.dbHandle)
 	case 21:
-		return downgradeMySQLDatabaseFromV21(p.dbHandle)
+		return downgradeMySQLDatabaseFromV21(p.dbHandle, targetVersion)
 	case 22:
-		return downgradeMySQLDatabaseFromV22(p.dbHandle)
+		return downgradeMySQLDatabaseFromV22(p.dbHandle, targetVersion)
 	case 23:
-		return downgradeMySQLDatabaseFromV23(p.dbHandle)
+		return downgradeMySQLDatabaseFromV23(p.dbHandle, targetVersion)
+	case 24:
+		return downgradeMySQLDatabaseFromV24(p.dbHandle, targetVersion)
+	case 25:
+		return downgradeMySQLDatabaseFromV25(p.dbHandle, targetVersion)
+	case 26:
+		return downgradeMySQLDatabaseFromV26(p.dbHandle, targetVersion)
+	case 27:
+		return downgradeMySQLDatabaseFromV27(p.dbHandle, targetVersion)
+	case 28:
+		return downgradeMySQLDatabaseFromV28(p.dbHandle, targetVersion)
+	case 29:
+		return downgradeMySQLDatabaseFromV29(p.dbHandle, targetVersion)
+	case 30:
+		return downgradeMySQLDatabaseFromV30(p.dbHandle, targetVersion)
+	case 31:
+		return downgradeMySQLDatabaseFromV31(p.dbHandle, targetVersion)
 	default:
 		return fmt.Errorf("database schema version not handled: %v", dbVersion.Version)
 	}
@@ -765,6 +974,10 @@ func (p *MySQLProvider) resetDatabase() error {
 	return sqlCommonExecSQLAndUpdateDBVersion(p.dbHandle, strings.Split(sql, ";"), 0, false)
 }
 
+func (p *MySQLProvider) backupDatabase(outputFile string) error {
+	return ErrNotImplemented
+}
+
 func updateMySQLDatabaseFromV19(dbHandle *sql.DB) error {
 	if err := updateMySQLDatabaseFrom19To20(dbHandle); err != nil {
 		return err
@@ -787,32 +1000,177 @@ func updateMySQLDatabaseFromV21(dbHandle *sql.DB) error {
 }
 
 func updateMySQLDatabaseFromV22(dbHandle *sql.DB) error {
-	return updateMySQLDatabaseFrom22To23(dbHandle)
+	if err := updateMySQLDatabaseFrom22To23(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV23(dbHandle)
+}
+
+func updateMySQLDatabaseFromV23(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom23To24(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV24(dbHandle)
+}
+
+func updateMySQLDatabaseFromV24(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom24To25(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV25(dbHandle)
+}
+
+func updateMySQLDatabaseFromV25(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom25To26(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV26(dbHandle)
+}
+
+func updateMySQLDatabaseFromV26(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom26To27(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV27(dbHandle)
+}
+
+func updateMySQLDatabaseFromV27(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom27To28(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV28(dbHandle)
+}
+
+func updateMySQLDatabaseFromV28(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom28To29(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV29(dbHandle)
+}
+
+func updateMySQLDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom29To30(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV30(dbHandle)
+}
+
+func updateMySQLDatabaseFromV30(dbHandle *sql.DB) error {
+	return updateMySQLDatabaseFrom30To31(dbHandle)
 }
 
 func downgradeMySQLDatabaseFromV20(dbHandle *sql.DB) error {
 	return downgradeMySQLDatabaseFrom20To19(dbHandle)
 }
 
-func downgradeMySQLDatabaseFromV21(dbHandle *sql.DB) error {
+func downgradeMySQLDatabaseFromV21(dbHandle *sql.DB, targetVersion int) error {
 	if err := downgradeMySQLDatabaseFrom21To20(dbHandle); err != nil {
 		return err
 	}
+	if targetVersion == 20 {
+		return nil
+	}
 	return downgradeMySQLDatabaseFromV20(dbHandle)
 }
 
-func downgradeMySQLDatabaseFromV22(dbHandle *sql.DB) error {
+func downgradeMySQLDatabaseFromV22(dbHandle *sql.DB, targetVersion int) error {
 	if err := downgradeMySQLDatabaseFrom22To21(dbHandle); err != nil {
 		return err
 	}
-	return downgradeMySQLDatabaseFromV21(dbHandle)
+	if targetVersion == 21 {
+		return nil
+	}
+	return downgradeMySQLDatabaseFromV21(dbHandle, targetVersion)
 }
 
-func downgradeMySQLDatabaseFromV23(dbHandle *sql.DB) error {
+func downgradeMySQLDatabaseFromV23(dbHandle *sql.DB, targetVersion int) error {
 	if err := downgradeMySQLDatabaseFrom23To22(dbHandle); err != nil {
 		return err
 	}
-	return downgradeMySQLDatabaseFromV22(dbHandle)
+	if targetVersion == 22 {
+		return nil
+	}
+	return downgradeMySQLDatabaseFromV22(dbHandle, targetVersion)
+}
+
+func downgradeMySQLDatabaseFromV24(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradeMySQLDatabaseFrom24To23(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 23 {
+		return nil
+	}
+	return downgradeMySQLDatabaseFromV23(dbHandle, targetVersion)
+}
+
+func downgradeMySQLDatabaseFromV25(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradeMySQLDatabaseFrom25To24(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 24 {
+		return nil
+	}
+	return downgradeMySQLDatabaseFromV24(dbHandle, targetVersion)
+}
+
+func downgradeMySQLDatabaseFromV26(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradeMySQLDatabaseFrom26To25(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 25 {
+		return nil
+	}
+	return downgradeMySQLDatabaseFromV25(dbHandle, targetVersion)
+}
+
+func downgradeMySQLDatabaseFromV27(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradeMySQLDatabaseFrom27To26(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 26 {
+		return nil
+	}
+	return downgradeMySQLDatabaseFromV26(dbHandle, targetVersion)
+}
+
+func downgradeMySQLDatabaseFromV28(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradeMySQLDatabaseFrom28To27(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 27 {
+		return nil
+	}
+	return downgradeMySQLDatabaseFromV27(dbHandle, targetVersion)
+}
+
+func downgradeMySQLDatabaseFromV29(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradeMySQLDatabaseFrom29To28(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 28 {
+		return nil
+	}
+	return downgradeMySQLDatabaseFromV28(dbHandle, targetVersion)
+}
+
+func downgradeMySQLDatabaseFromV30(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradeMySQLDatabaseFrom30To29(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 29 {
+		return nil
+	}
+	return downgradeMySQLDatabaseFromV29(dbHandle, targetVersion)
+}
+
+func downgradeMySQLDatabaseFromV31(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradeMySQLDatabaseFrom31To30(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 30 {
+		return nil
+	}
+	return downgradeMySQLDatabaseFromV30(dbHandle, targetVersion)
 }
 
 func updateMySQLDatabaseFrom19To20(dbHandle *sql.DB) error {
@@ -851,6 +1209,68 @@ func updateMySQLDatabaseFrom22To23(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 23, true)
 }
 
+func updateMySQLDatabaseFrom23To24(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 23 -> 24")
+	providerLog(logger.LevelInfo, "updating database schema version: 23 -> 24")
+	sql := strings.ReplaceAll(mysqlV24SQL, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{groups}}", sqlTableGroups)
+	sql = strings.ReplaceAll(sql, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 24, true)
+}
+
+func updateMySQLDatabaseFrom24To25(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 24 -> 25")
+	providerLog(logger.LevelInfo, "updating database schema version: 24 -> 25")
+	sql := strings.ReplaceAll(mysqlV25SQL, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 25, true)
+}
+
+func updateMySQLDatabaseFrom25To26(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 25 -> 26")
+	providerLog(logger.LevelInfo, "updating database schema version: 25 -> 26")
+	sql := strings.ReplaceAll(mysqlV26SQL, "{{audit_trail}}", sqlTableAuditTrail)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 26, true)
+}
+
+func updateMySQLDatabaseFrom26To27(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 26 -> 27")
+	providerLog(logger.LevelInfo, "updating database schema version: 26 -> 27")
+	sql := strings.ReplaceAll(mysqlV27SQL, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 27, true)
+}
+
+func updateMySQLDatabaseFrom27To28(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 27 -> 28")
+	providerLog(logger.LevelInfo, "updating database schema version: 27 -> 28")
+	sql := strings.ReplaceAll(mysqlV28SQL, "{{dead_letters}}", sqlTableDeadLetters)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 28, true)
+}
+
+func updateMySQLDatabaseFrom28To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 28 -> 29")
+	providerLog(logger.LevelInfo, "updating database schema version: 28 -> 29")
+	sql := sqlReplaceAll(mysqlV29SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 29, true)
+}
+
+func updateMySQLDatabaseFrom29To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 29 -> 30")
+	providerLog(logger.LevelInfo, "updating database schema version: 29 -> 30")
+	sql := sqlReplaceAll(mysqlV30SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 30, true)
+}
+
+func updateMySQLDatabaseFrom30To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 30 -> 31")
+	providerLog(logger.LevelInfo, "updating database schema version: 30 -> 31")
+	sql := sqlReplaceAll(mysqlV31SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 31, true)
+}
+
 func downgradeMySQLDatabaseFrom20To19(dbHandle *sql.DB) error {
 	logger.InfoToConsole("downgrading database schema version: 20 -> 19")
 	providerLog(logger.LevelInfo, "downgrading database schema version: 20 -> 19")
@@ -883,3 +1303,63 @@ func downgradeMySQLDatabaseFrom23To22(dbHandle *sql.DB) error {
 	sql := strings.ReplaceAll(mysqlV23DownSQL, "{{nodes}}", sqlTableNodes)
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 22, false)
 }
+
+func downgradeMySQLDatabaseFrom24To23(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 24 -> 23")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 24 -> 23")
+	sql := strings.ReplaceAll(mysqlV24DownSQL, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{groups}}", sqlTableGroups)
+	sql = strings.ReplaceAll(sql, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 23, false)
+}
+
+func downgradeMySQLDatabaseFrom25To24(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 25 -> 24")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 25 -> 24")
+	sql := strings.ReplaceAll(mysqlV25DownSQL, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 24, false)
+}
+
+func downgradeMySQLDatabaseFrom26To25(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 26 -> 25")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 26 -> 25")
+	sql := strings.ReplaceAll(mysqlV26DownSQL, "{{audit_trail}}", sqlTableAuditTrail)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 25, false)
+}
+
+func downgradeMySQLDatabaseFrom27To26(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 27 -> 26")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 27 -> 26")
+	sql := strings.ReplaceAll(mysqlV27DownSQL, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 26, false)
+}
+
+func downgradeMySQLDatabaseFrom28To27(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 28 -> 27")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 28 -> 27")
+	sql := strings.ReplaceAll(mysqlV28DownSQL, "{{dead_letters}}", sqlTableDeadLetters)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 27, false)
+}
+
+func downgradeMySQLDatabaseFrom29To28(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 29 -> 28")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 29 -> 28")
+	sql := sqlReplaceAll(mysqlV29DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 28, false)
+}
+
+func downgradeMySQLDatabaseFrom30To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 30 -> 29")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 30 -> 29")
+	sql := sqlReplaceAll(mysqlV30DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 29, false)
+}
+
+func downgradeMySQLDatabaseFrom31To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 31 -> 30")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 31 -> 30")
+	sql := sqlReplaceAll(mysqlV31DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 30, false)
+}
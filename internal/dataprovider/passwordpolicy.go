@@ -0,0 +1,353 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/sftpgo/sdk"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	maxPasswordHistory = 24
+)
+
+var (
+	// ErrPasswordExpired defines the error to return if the user password is expired
+	ErrPasswordExpired = errors.New("password expired")
+)
+
+// userPasswordState defines the password related properties to update
+// when a new password is set
+type userPasswordState struct {
+	changedAt int64
+	history   []string
+}
+
+func (s *userPasswordState) apply(user *User) {
+	if s == nil {
+		return
+	}
+	user.Filters.PasswordChangedAt = s.changedAt
+	user.Filters.PasswordHistory = s.history
+}
+
+// PasswordPolicy defines a named set of rules for the passwords of the protocol
+// users. A policy can be assigned to users and groups
+type PasswordPolicy struct {
+	// Unique policy name
+	Name string `json:"name" mapstructure:"name"`
+	// Minimum password length. 0 means no limit
+	MinLength int `json:"min_length" mapstructure:"min_length"`
+	// Require at least one uppercase letter
+	RequireUppercase bool `json:"require_uppercase" mapstructure:"require_uppercase"`
+	// Require at least one lowercase letter
+	RequireLowercase bool `json:"require_lowercase" mapstructure:"require_lowercase"`
+	// Require at least one digit
+	RequireDigit bool `json:"require_digit" mapstructure:"require_digit"`
+	// Require at least one character that is not a letter or a digit
+	RequireSpecial bool `json:"require_special" mapstructure:"require_special"`
+	// Path to a file with the forbidden passwords, one per line.
+	// The comparison is case insensitive. This can be an absolute path
+	// or a path relative to the config dir
+	DictionaryFile string `json:"dictionary_file" mapstructure:"dictionary_file"`
+	// Base URL of a service implementing the k-anonymity range API, for example
+	// "https://api.pwnedpasswords.com/range/". The first five characters of the
+	// SHA-1 hash of the password are appended to this URL and the password is
+	// rejected if the full hash is included in the response. Empty means disabled
+	BreachCheckURL string `json:"breach_check_url" mapstructure:"breach_check_url"`
+	// Number of days after which a password expires and must be changed.
+	// 0 means no expiration
+	ExpirationDays int `json:"expiration_days" mapstructure:"expiration_days"`
+	// Number of previous passwords that cannot be reused. 0 means disabled
+	History int `json:"history" mapstructure:"history"`
+
+	dictionary map[string]bool
+}
+
+func (p *PasswordPolicy) validate(configDir string) error {
+	if p.Name == "" {
+		return errors.New("password policy name is required")
+	}
+	if p.MinLength < 0 {
+		return fmt.Errorf("password policy %q: invalid min length %d", p.Name, p.MinLength)
+	}
+	if p.ExpirationDays < 0 {
+		return fmt.Errorf("password policy %q: invalid expiration days %d", p.Name, p.ExpirationDays)
+	}
+	if p.History < 0 || p.History > maxPasswordHistory {
+		return fmt.Errorf("password policy %q: invalid history %d, allowed range 0-%d", p.Name, p.History,
+			maxPasswordHistory)
+	}
+	if p.BreachCheckURL != "" && !strings.HasPrefix(p.BreachCheckURL, "http") {
+		return fmt.Errorf("password policy %q: invalid breach check URL %q", p.Name, p.BreachCheckURL)
+	}
+	p.dictionary = nil
+	if p.DictionaryFile != "" {
+		dictionaryFile := getConfigPath(p.DictionaryFile, configDir)
+		if dictionaryFile == "" {
+			return fmt.Errorf("password policy %q: invalid dictionary file %q", p.Name, p.DictionaryFile)
+		}
+		if err := p.loadDictionary(dictionaryFile); err != nil {
+			return fmt.Errorf("password policy %q: unable to load dictionary file %q: %w", p.Name, dictionaryFile, err)
+		}
+	}
+	return nil
+}
+
+func (p *PasswordPolicy) loadDictionary(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	p.dictionary = make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word != "" {
+			p.dictionary[strings.ToLower(word)] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	providerLog(logger.LevelDebug, "password policy %q, %d words loaded from dictionary %q", p.Name,
+		len(p.dictionary), name)
+	return nil
+}
+
+func (p *PasswordPolicy) checkPassword(password string, history []string) error {
+	if p.MinLength > 0 && utf8.RuneCountInString(password) < p.MinLength {
+		return util.NewValidationError(fmt.Sprintf("the password must be at least %d characters long", p.MinLength))
+	}
+	if err := p.checkCharClasses(password); err != nil {
+		return err
+	}
+	if p.dictionary[strings.ToLower(password)] {
+		return util.NewValidationError("the password is too common, please choose a different one")
+	}
+	for idx, hash := range history {
+		if idx >= p.History {
+			break
+		}
+		if isPasswordInHistory(hash, password) {
+			return util.NewValidationError(fmt.Sprintf("the password must be different from the last %d passwords",
+				p.History))
+		}
+	}
+	if p.BreachCheckURL != "" {
+		breached, err := p.isBreached(password)
+		if err != nil {
+			// we don't want to block the password changes if the service is unavailable
+			providerLog(logger.LevelWarn, "password policy %q, unable to check for breached password: %v",
+				p.Name, err)
+		} else if breached {
+			return util.NewValidationError("the password was found in a data breach, please choose a different one")
+		}
+	}
+	return nil
+}
+
+func (p *PasswordPolicy) checkCharClasses(password string) error {
+	var hasUpper, hasLower, hasDigit, hasSpecial bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSpecial = true
+		}
+	}
+	if p.RequireUppercase && !hasUpper {
+		return util.NewValidationError("the password must contain at least one uppercase letter")
+	}
+	if p.RequireLowercase && !hasLower {
+		return util.NewValidationError("the password must contain at least one lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		return util.NewValidationError("the password must contain at least one digit")
+	}
+	if p.RequireSpecial && !hasSpecial {
+		return util.NewValidationError("the password must contain at least one special character")
+	}
+	return nil
+}
+
+func (p *PasswordPolicy) isBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	resp, err := httpclient.Get(p.BreachCheckURL + hash[:5])
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		suffix, _, _ := strings.Cut(scanner.Text(), ":")
+		if strings.EqualFold(strings.TrimSpace(suffix), hash[5:]) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// getHistory returns the password history to store after setting the specified hash
+func (p *PasswordPolicy) getHistory(hash string, history []string) []string {
+	if p.History == 0 {
+		return nil
+	}
+	result := []string{hash}
+	for _, h := range history {
+		if len(result) >= p.History {
+			break
+		}
+		result = append(result, h)
+	}
+	return result
+}
+
+func (p *PasswordPolicy) isExpired(user *User) bool {
+	if p.ExpirationDays == 0 {
+		return false
+	}
+	changedAt := user.Filters.PasswordChangedAt
+	if changedAt == 0 {
+		changedAt = user.CreatedAt
+	}
+	if changedAt == 0 {
+		return false
+	}
+	expiresAt := util.GetTimeFromMsecSinceEpoch(changedAt).Add(time.Duration(p.ExpirationDays) * 24 * time.Hour)
+	return expiresAt.Before(time.Now())
+}
+
+func isPasswordInHistory(hash, password string) bool {
+	if strings.HasPrefix(hash, bcryptPwdPrefix) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	if strings.HasPrefix(hash, argonPwdPrefix) {
+		match, err := argon2id.ComparePasswordAndHash(password, hash)
+		return err == nil && match
+	}
+	return false
+}
+
+func validatePasswordPolicies(configDir string) error {
+	names := make(map[string]bool)
+	for idx := range config.PasswordPolicies {
+		policy := &config.PasswordPolicies[idx]
+		if err := policy.validate(configDir); err != nil {
+			return err
+		}
+		if names[policy.Name] {
+			return fmt.Errorf("password policy %q is duplicated", policy.Name)
+		}
+		names[policy.Name] = true
+	}
+	return nil
+}
+
+func getPasswordPolicy(name string) *PasswordPolicy {
+	if name == "" {
+		return nil
+	}
+	for idx := range config.PasswordPolicies {
+		if config.PasswordPolicies[idx].Name == name {
+			return &config.PasswordPolicies[idx]
+		}
+	}
+	return nil
+}
+
+func validatePasswordPolicyName(name *string) error {
+	*name = strings.TrimSpace(*name)
+	if *name == "" {
+		return nil
+	}
+	if getPasswordPolicy(*name) == nil {
+		return util.NewValidationError(fmt.Sprintf("password policy %q does not exist", *name))
+	}
+	return nil
+}
+
+// getUserPasswordPolicy returns the password policy for a user without the
+// group settings applied. The policy set for the user has precedence over the
+// one set for the primary group
+func getUserPasswordPolicy(user *User) (*PasswordPolicy, error) {
+	if user.Filters.PasswordPolicy != "" {
+		return getPasswordPolicy(user.Filters.PasswordPolicy), nil
+	}
+	for _, g := range user.Groups {
+		if g.Type != sdk.GroupTypePrimary {
+			continue
+		}
+		group, err := provider.groupExists(g.Name)
+		if err != nil {
+			if _, ok := err.(*util.RecordNotFoundError); ok {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return getPasswordPolicy(group.UserSettings.PasswordPolicy), nil
+	}
+	return nil, nil
+}
+
+// GetPasswordPolicyNames returns the names of the configured password policies
+func GetPasswordPolicyNames() []string {
+	names := make([]string, 0, len(config.PasswordPolicies))
+	for _, p := range config.PasswordPolicies {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// checkUserPasswordExpiration returns ErrPasswordExpired if the password of the
+// specified user, with the group settings applied, is expired
+func checkUserPasswordExpiration(user *User) error {
+	policy := getPasswordPolicy(user.Filters.PasswordPolicy)
+	if policy == nil || !policy.isExpired(user) {
+		return nil
+	}
+	providerLog(logger.LevelInfo, "the password for user %q is expired, policy %q", user.Username, policy.Name)
+	return ErrPasswordExpired
+}
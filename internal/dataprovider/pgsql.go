@@ -38,6 +38,7 @@ const (
 	pgsqlResetSQL = `DROP TABLE IF EXISTS "{{api_keys}}" CASCADE;
 DROP TABLE IF EXISTS "{{folders_mapping}}" CASCADE;
 DROP TABLE IF EXISTS "{{users_folders_mapping}}" CASCADE;
+DROP TABLE IF EXISTS "{{users_folders_quota}}" CASCADE;
 DROP TABLE IF EXISTS "{{users_groups_mapping}}" CASCADE;
 DROP TABLE IF EXISTS "{{admins_groups_mapping}}" CASCADE;
 DROP TABLE IF EXISTS "{{groups_folders_mapping}}" CASCADE;
@@ -55,6 +56,8 @@ DROP TABLE IF EXISTS "{{events_actions}}" CASCADE;
 DROP TABLE IF EXISTS "{{events_rules}}" CASCADE;
 DROP TABLE IF EXISTS "{{tasks}}" CASCADE;
 DROP TABLE IF EXISTS "{{nodes}}" CASCADE;
+DROP TABLE IF EXISTS "{{audit_trail}}" CASCADE;
+DROP TABLE IF EXISTS "{{dead_letters}}" CASCADE;
 DROP TABLE IF EXISTS "{{schema_version}}" CASCADE;
 `
 	pgsqlInitial = `CREATE TABLE "{{schema_version}}" ("id" serial NOT NULL PRIMARY KEY, "version" integer NOT NULL);
@@ -202,11 +205,67 @@ DROP TABLE "{{admins_groups_mapping}}" CASCADE;
 	pgsqlV23SQL = `CREATE TABLE "{{nodes}}" ("id" serial NOT NULL PRIMARY KEY, "name" varchar(255) NOT NULL UNIQUE,
 "data" text NOT NULL, "created_at" bigint NOT NULL, "updated_at" bigint NOT NULL);`
 	pgsqlV23DownSQL = `DROP TABLE "{{nodes}}" CASCADE;`
+	pgsqlV24SQL     = `ALTER TABLE "{{users}}" ADD COLUMN "metadata" text NULL;
+ALTER TABLE "{{groups}}" ADD COLUMN "metadata" text NULL;
+ALTER TABLE "{{folders}}" ADD COLUMN "metadata" text NULL;`
+	pgsqlV24DownSQL = `ALTER TABLE "{{folders}}" DROP COLUMN "metadata" CASCADE;
+ALTER TABLE "{{groups}}" DROP COLUMN "metadata" CASCADE;
+ALTER TABLE "{{users}}" DROP COLUMN "metadata" CASCADE;`
+	pgsqlV25SQL = `ALTER TABLE "{{users}}" ADD COLUMN "soft_deleted_at" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{users}}" ALTER COLUMN "soft_deleted_at" DROP DEFAULT;
+CREATE INDEX "{{prefix}}users_soft_deleted_at_idx" ON "{{users}}" ("soft_deleted_at");`
+	pgsqlV25DownSQL = `DROP INDEX IF EXISTS "{{prefix}}users_soft_deleted_at_idx";
+ALTER TABLE "{{users}}" DROP COLUMN "soft_deleted_at" CASCADE;`
+	pgsqlV26SQL = `CREATE TABLE "{{audit_trail}}" ("id" bigserial NOT NULL PRIMARY KEY, "timestamp" bigint NOT NULL,
+"action" varchar(32) NOT NULL, "object_type" varchar(50) NOT NULL, "object_name" varchar(255) NOT NULL,
+"executor" varchar(255) NOT NULL, "ip" varchar(50) NOT NULL, "changes" text NULL);
+CREATE INDEX "{{prefix}}audit_trail_timestamp_idx" ON "{{audit_trail}}" ("timestamp");
+CREATE INDEX "{{prefix}}audit_trail_object_idx" ON "{{audit_trail}}" ("object_type", "object_name");`
+	pgsqlV26DownSQL = `DROP TABLE "{{audit_trail}}" CASCADE;`
+	pgsqlV27SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "soft_deleted_at" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{folders}}" ALTER COLUMN "soft_deleted_at" DROP DEFAULT;
+CREATE INDEX "{{prefix}}folders_soft_deleted_at_idx" ON "{{folders}}" ("soft_deleted_at");`
+	pgsqlV27DownSQL = `DROP INDEX IF EXISTS "{{prefix}}folders_soft_deleted_at_idx";
+ALTER TABLE "{{folders}}" DROP COLUMN "soft_deleted_at" CASCADE;`
+	pgsqlV28SQL = `CREATE TABLE "{{dead_letters}}" ("id" bigserial NOT NULL PRIMARY KEY, "timestamp" bigint NOT NULL,
+"source" varchar(50) NOT NULL, "name" varchar(255) NOT NULL, "method" varchar(10) NOT NULL, "url" text NOT NULL,
+"username" varchar(255) NULL, "headers" text NULL, "body" text NULL, "last_error" text NULL, "attempts" integer NOT NULL);
+CREATE INDEX "{{prefix}}dead_letters_timestamp_idx" ON "{{dead_letters}}" ("timestamp");
+CREATE INDEX "{{prefix}}dead_letters_source_name_idx" ON "{{dead_letters}}" ("source", "name");`
+	pgsqlV28DownSQL = `DROP TABLE "{{dead_letters}}" CASCADE;`
+	pgsqlV29SQL     = `ALTER TABLE "{{users_folders_mapping}}" ADD COLUMN "user_quota_size" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{users_folders_mapping}}" ALTER COLUMN "user_quota_size" DROP DEFAULT;
+ALTER TABLE "{{users_folders_mapping}}" ADD COLUMN "user_quota_files" integer DEFAULT 0 NOT NULL;
+ALTER TABLE "{{users_folders_mapping}}" ALTER COLUMN "user_quota_files" DROP DEFAULT;
+ALTER TABLE "{{groups_folders_mapping}}" ADD COLUMN "user_quota_size" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{groups_folders_mapping}}" ALTER COLUMN "user_quota_size" DROP DEFAULT;
+ALTER TABLE "{{groups_folders_mapping}}" ADD COLUMN "user_quota_files" integer DEFAULT 0 NOT NULL;
+ALTER TABLE "{{groups_folders_mapping}}" ALTER COLUMN "user_quota_files" DROP DEFAULT;
+CREATE TABLE "{{users_folders_quota}}" ("id" serial NOT NULL PRIMARY KEY, "user_id" integer NOT NULL,
+"folder_id" integer NOT NULL, "used_quota_size" bigint NOT NULL, "used_quota_files" integer NOT NULL,
+"last_quota_update" bigint NOT NULL);
+ALTER TABLE "{{users_folders_quota}}" ADD CONSTRAINT "{{prefix}}unique_user_folder_quota" UNIQUE ("user_id", "folder_id");
+ALTER TABLE "{{users_folders_quota}}" ADD CONSTRAINT "{{prefix}}users_folders_quota_user_id_fk_users_id"
+FOREIGN KEY ("user_id") REFERENCES "{{users}}" ("id") MATCH SIMPLE ON UPDATE NO ACTION ON DELETE CASCADE;
+ALTER TABLE "{{users_folders_quota}}" ADD CONSTRAINT "{{prefix}}users_folders_quota_folder_id_fk_folders_id"
+FOREIGN KEY ("folder_id") REFERENCES "{{folders}}" ("id") MATCH SIMPLE ON UPDATE NO ACTION ON DELETE CASCADE;
+CREATE INDEX "{{prefix}}users_folders_quota_folder_id_idx" ON "{{users_folders_quota}}" ("folder_id");`
+	pgsqlV29DownSQL = `DROP TABLE "{{users_folders_quota}}" CASCADE;
+ALTER TABLE "{{groups_folders_mapping}}" DROP COLUMN "user_quota_files" CASCADE;
+ALTER TABLE "{{groups_folders_mapping}}" DROP COLUMN "user_quota_size" CASCADE;
+ALTER TABLE "{{users_folders_mapping}}" DROP COLUMN "user_quota_files" CASCADE;
+ALTER TABLE "{{users_folders_mapping}}" DROP COLUMN "user_quota_size" CASCADE;`
+	pgsqlV30SQL = `ALTER TABLE "{{users}}" ADD COLUMN "last_transfer_quota_reset" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{users}}" ALTER COLUMN "last_transfer_quota_reset" DROP DEFAULT;`
+	pgsqlV30DownSQL = `ALTER TABLE "{{users}}" DROP COLUMN "last_transfer_quota_reset" CASCADE;`
+	pgsqlV31SQL     = `ALTER TABLE "{{api_keys}}" ADD COLUMN "scopes" text NULL;`
+	pgsqlV31DownSQL = `ALTER TABLE "{{api_keys}}" DROP COLUMN "scopes" CASCADE;`
 )
 
 // PGSQLProvider defines the auth provider for PostgreSQL database
 type PGSQLProvider struct {
 	dbHandle *sql.DB
+	replicas *sqlReadReplicas
 }
 
 func init() {
@@ -227,7 +286,12 @@ func initializePGSQLProvider() error {
 		}
 		dbHandle.SetConnMaxLifetime(240 * time.Second)
 		dbHandle.SetConnMaxIdleTime(120 * time.Second)
-		provider = &PGSQLProvider{dbHandle: dbHandle}
+		replicas, err := newSQLReadReplicas("pgx")
+		if err != nil {
+			dbHandle.Close()
+			return err
+		}
+		provider = &PGSQLProvider{dbHandle: dbHandle, replicas: replicas}
 	} else {
 		providerLog(logger.LevelError, "error creating postgres database handler, connection string: %#v, error: %v",
 			getPGSQLConnectionString(true), err)
@@ -263,25 +327,30 @@ func getPGSQLConnectionString(redactedPwd bool) string {
 }
 
 func (p *PGSQLProvider) checkAvailability() error {
+	p.replicas.checkAvailability()
 	return sqlCommonCheckAvailability(p.dbHandle)
 }
 
 func (p *PGSQLProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
-	return sqlCommonValidateUserAndPass(username, password, ip, protocol, p.dbHandle)
+	return sqlCommonValidateUserAndPass(username, password, ip, protocol, p.replicas, p.dbHandle)
 }
 
 func (p *PGSQLProvider) validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error) {
-	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.dbHandle)
+	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.replicas, p.dbHandle)
 }
 
 func (p *PGSQLProvider) validateUserAndPubKey(username string, publicKey []byte, isSSHCert bool) (User, string, error) {
-	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.dbHandle)
+	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.replicas, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error {
 	return sqlCommonUpdateTransferQuota(username, uploadSize, downloadSize, reset, p.dbHandle)
 }
 
+func (p *PGSQLProvider) resetUserTransferQuota(username string, scheduledAt int64) (bool, error) {
+	return sqlCommonResetUserTransferQuota(username, scheduledAt, p.dbHandle)
+}
+
 func (p *PGSQLProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
 	return sqlCommonUpdateQuota(username, filesAdd, sizeAdd, reset, p.dbHandle)
 }
@@ -303,23 +372,29 @@ func (p *PGSQLProvider) updateAdminLastLogin(username string) error {
 }
 
 func (p *PGSQLProvider) userExists(username string) (User, error) {
-	return sqlCommonGetUserByUsername(username, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, username, func(dbHandle sqlQuerier) (User, error) {
+		return sqlCommonGetUserByUsername(username, dbHandle)
+	})
 }
 
 func (p *PGSQLProvider) addUser(user *User) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonAddUser(user, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateUser(user *User) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonUpdateUser(user, p.dbHandle)
 }
 
 func (p *PGSQLProvider) deleteUser(user User, softDelete bool) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonDeleteUser(user, softDelete, p.dbHandle)
 }
 
-func (p *PGSQLProvider) updateUserPassword(username, password string) error {
-	return sqlCommonUpdateUserPassword(username, password, p.dbHandle)
+func (p *PGSQLProvider) updateUserPassword(username, password string, state *userPasswordState) error {
+	defer p.replicas.trackWrite(username)
+	return sqlCommonUpdateUserPassword(username, password, state, p.dbHandle)
 }
 
 func (p *PGSQLProvider) dumpUsers() ([]User, error) {
@@ -331,7 +406,13 @@ func (p *PGSQLProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
 }
 
 func (p *PGSQLProvider) getUsers(limit int, offset int, order string) ([]User, error) {
-	return sqlCommonGetUsers(limit, offset, order, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]User, error) {
+		return sqlCommonGetUsers(limit, offset, order, false, dbHandle)
+	})
+}
+
+func (p *PGSQLProvider) getSoftDeletedUsers(limit int, offset int, order string) ([]User, error) {
+	return sqlCommonGetUsers(limit, offset, order, true, p.dbHandle)
 }
 
 func (p *PGSQLProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
@@ -343,7 +424,18 @@ func (p *PGSQLProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 }
 
 func (p *PGSQLProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
-	return sqlCommonGetFolders(limit, offset, order, minimal, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error) {
+		return sqlCommonGetFolders(limit, offset, order, minimal, false, dbHandle)
+	})
+}
+
+func (p *PGSQLProvider) getSoftDeletedFolders(limit, offset int, order string) ([]vfs.BaseVirtualFolder, error) {
+	return sqlCommonGetFolders(limit, offset, order, false, true, p.dbHandle)
+}
+
+func (p *PGSQLProvider) setFolderSoftDeletedAt(name string, softDeletedAt int64) error {
+	defer p.replicas.trackWrite("")
+	return sqlCommonSetFolderSoftDeletedAt(name, softDeletedAt, p.dbHandle)
 }
 
 func (p *PGSQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
@@ -353,14 +445,17 @@ func (p *PGSQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, err
 }
 
 func (p *PGSQLProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonAddFolder(folder, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonUpdateFolder(folder, p.dbHandle)
 }
 
 func (p *PGSQLProvider) deleteFolder(folder vfs.BaseVirtualFolder) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonDeleteFolder(folder, p.dbHandle)
 }
 
@@ -368,16 +463,28 @@ func (p *PGSQLProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int
 	return sqlCommonUpdateFolderQuota(name, filesAdd, sizeAdd, reset, p.dbHandle)
 }
 
+func (p *PGSQLProvider) updateUserFolderQuota(username, folderName string, filesAdd int, sizeAdd int64, reset bool) error {
+	return sqlCommonUpdateUserFolderQuota(username, folderName, filesAdd, sizeAdd, reset, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getUserFoldersQuota(username string) ([]UserFolderQuota, error) {
+	return sqlCommonGetUserFoldersQuota(username, p.dbHandle)
+}
+
 func (p *PGSQLProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return sqlCommonGetFolderUsedQuota(name, p.dbHandle)
 }
 
 func (p *PGSQLProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
-	return sqlCommonGetGroups(limit, offset, order, minimal, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]Group, error) {
+		return sqlCommonGetGroups(limit, offset, order, minimal, dbHandle)
+	})
 }
 
 func (p *PGSQLProvider) getGroupsWithNames(names []string) ([]Group, error) {
-	return sqlCommonGetGroupsWithNames(names, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]Group, error) {
+		return sqlCommonGetGroupsWithNames(names, dbHandle)
+	})
 }
 
 func (p *PGSQLProvider) getUsersInGroups(names []string) ([]string, error) {
@@ -385,18 +492,23 @@ func (p *PGSQLProvider) getUsersInGroups(names []string) ([]string, error) {
 }
 
 func (p *PGSQLProvider) groupExists(name string) (Group, error) {
-	return sqlCommonGetGroupByName(name, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) (Group, error) {
+		return sqlCommonGetGroupByName(name, dbHandle)
+	})
 }
 
 func (p *PGSQLProvider) addGroup(group *Group) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonAddGroup(group, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateGroup(group *Group) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonUpdateGroup(group, p.dbHandle)
 }
 
 func (p *PGSQLProvider) deleteGroup(group Group) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonDeleteGroup(group, p.dbHandle)
 }
 
@@ -409,19 +521,24 @@ func (p *PGSQLProvider) adminExists(username string) (Admin, error) {
 }
 
 func (p *PGSQLProvider) addAdmin(admin *Admin) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonAddAdmin(admin, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateAdmin(admin *Admin) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonUpdateAdmin(admin, p.dbHandle)
 }
 
 func (p *PGSQLProvider) deleteAdmin(admin Admin) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonDeleteAdmin(admin, p.dbHandle)
 }
 
 func (p *PGSQLProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
-	return sqlCommonGetAdmins(limit, offset, order, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]Admin, error) {
+		return sqlCommonGetAdmins(limit, offset, order, dbHandle)
+	})
 }
 
 func (p *PGSQLProvider) dumpAdmins() ([]Admin, error) {
@@ -437,19 +554,24 @@ func (p *PGSQLProvider) apiKeyExists(keyID string) (APIKey, error) {
 }
 
 func (p *PGSQLProvider) addAPIKey(apiKey *APIKey) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonAddAPIKey(apiKey, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateAPIKey(apiKey *APIKey) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonUpdateAPIKey(apiKey, p.dbHandle)
 }
 
 func (p *PGSQLProvider) deleteAPIKey(apiKey APIKey) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonDeleteAPIKey(apiKey, p.dbHandle)
 }
 
 func (p *PGSQLProvider) getAPIKeys(limit int, offset int, order string) ([]APIKey, error) {
-	return sqlCommonGetAPIKeys(limit, offset, order, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]APIKey, error) {
+		return sqlCommonGetAPIKeys(limit, offset, order, dbHandle)
+	})
 }
 
 func (p *PGSQLProvider) dumpAPIKeys() ([]APIKey, error) {
@@ -465,19 +587,24 @@ func (p *PGSQLProvider) shareExists(shareID, username string) (Share, error) {
 }
 
 func (p *PGSQLProvider) addShare(share *Share) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonAddShare(share, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateShare(share *Share) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonUpdateShare(share, p.dbHandle)
 }
 
 func (p *PGSQLProvider) deleteShare(share Share) error {
+	defer p.replicas.trackWrite("")
 	return sqlCommonDeleteShare(share, p.dbHandle)
 }
 
 func (p *PGSQLProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
-	return sqlCommonGetShares(limit, offset, order, username, p.dbHandle)
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]Share, error) {
+		return sqlCommonGetShares(limit, offset, order, username, dbHandle)
+	})
 }
 
 func (p *PGSQLProvider) dumpShares() ([]Share, error) {
@@ -552,6 +679,10 @@ func (p *PGSQLProvider) getSharedSession(key string) (Session, error) {
 	return sqlCommonGetSession(key, p.dbHandle)
 }
 
+func (p *PGSQLProvider) getSharedSessions(sessionType SessionType, after int64) ([]Session, error) {
+	return sqlCommonGetSessions(sessionType, after, p.dbHandle)
+}
+
 func (p *PGSQLProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
@@ -644,15 +775,59 @@ func (p *PGSQLProvider) cleanupNodes() error {
 	return sqlCommonCleanupNodes(p.dbHandle)
 }
 
+func (p *PGSQLProvider) addAuditEntry(entry *AuditEntry) error {
+	return sqlCommonAddAuditEntry(entry, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getAuditEntries(filters AuditFilters, limit, offset int, order string) ([]AuditEntry, error) {
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]AuditEntry, error) {
+		return sqlCommonGetAuditEntries(filters, limit, offset, order, dbHandle)
+	})
+}
+
+func (p *PGSQLProvider) cleanupAuditEntries(before int64) error {
+	return sqlCommonCleanupAuditEntries(before, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addDeadLetter(entry *DeadLetter) error {
+	return sqlCommonAddDeadLetter(entry, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getDeadLetters(filters DeadLetterFilters, limit, offset int, order string) ([]DeadLetter, error) {
+	return sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) ([]DeadLetter, error) {
+		return sqlCommonGetDeadLetters(filters, limit, offset, order, dbHandle)
+	})
+}
+
+func (p *PGSQLProvider) getDeadLetter(id int64) (DeadLetter, error) {
+	return sqlCommonGetDeadLetter(id, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteDeadLetter(id int64) error {
+	return sqlCommonDeleteDeadLetter(id, p.dbHandle)
+}
+
+func (p *PGSQLProvider) cleanupDeadLetters(before int64) error {
+	return sqlCommonCleanupDeadLetters(before, p.dbHandle)
+}
+
 func (p *PGSQLProvider) setFirstDownloadTimestamp(username string) error {
+	defer p.replicas.trackWrite(username)
 	return sqlCommonSetFirstDownloadTimestamp(username, p.dbHandle)
 }
 
 func (p *PGSQLProvider) setFirstUploadTimestamp(username string) error {
+	defer p.replicas.trackWrite(username)
 	return sqlCommonSetFirstUploadTimestamp(username, p.dbHandle)
 }
 
+func (p *PGSQLProvider) setUserSoftDeletedAt(username string, softDeletedAt int64) error {
+	defer p.replicas.trackWrite("")
+	return sqlCommonSetUserSoftDeletedAt(username, softDeletedAt, p.dbHandle)
+}
+
 func (p *PGSQLProvider) close() error {
+	p.replicas.close()
 	return p.dbHandle.Close()
 }
 
@@ -698,7 +873,23 @@ func (p *PGSQLProvider) migrateDatabase() error { //nolint:dupl
 	case version == 21:
 		return updatePgSQLDatabaseFromV21(p.dbHandle)
 	case version == 22:
-		return updatePgSQLDatabaseFromV21(p.dbHandle)
+		return updatePgSQLDatabaseFromV22(p.dbHandle)
+	case version == 23:
+		return updatePgSQLDatabaseFromV23(p.dbHandle)
+	case version == 24:
+		return updatePgSQLDatabaseFromV24(p.dbHandle)
+	case version == 25:
+		return updatePgSQLDatabaseFromV25(p.dbHandle)
+	case version == 26:
+		return updatePgSQLDatabaseFromV26(p.dbHandle)
+	case version == 27:
+		return updatePgSQLDatabaseFromV27(p.dbHandle)
+	case version == 28:
+		return updatePgSQLDatabaseFromV28(p.dbHandle)
+	case version == 29:
+		return updatePgSQLDatabaseFromV29(p.dbHandle)
+	case version == 30:
+		return updatePgSQLDatabaseFromV30(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %v is newer than the supported one: %v", version,
@@ -716,19 +907,35 @@ func (p *PGSQLProvider) revertDatabase(targetVersion int) error {
 	if err != nil {
 		return err
 	}
-	if dbVersion.Version == targetVersion {
-		return errors.New("current version match target version, nothing to do")
+	if err := validateRevertTargetVersion(dbVersion.Version, targetVersion); err != nil {
+		return err
 	}
 
 	switch dbVersion.Version {
 	case 20:
 		return downgradePgSQLDatabaseFromV20(p.dbHandle)
 	case 21:
-		return downgradePgSQLDatabaseFromV21(p.dbHandle)
+		return downgradePgSQLDatabaseFromV21(p.dbHandle, targetVersion)
 	case 22:
-		return downgradePgSQLDatabaseFromV22(p.dbHandle)
+		return downgradePgSQLDatabaseFromV22(p.dbHandle, targetVersion)
 	case 23:
-		return downgradePgSQLDatabaseFromV23(p.dbHandle)
+		return downgradePgSQLDatabaseFromV23(p.dbHandle, targetVersion)
+	case 24:
+		return downgradePgSQLDatabaseFromV24(p.dbHandle, targetVersion)
+	case 25:
+		return downgradePgSQLDatabaseFromV25(p.dbHandle, targetVersion)
+	case 26:
+		return downgradePgSQLDatabaseFromV26(p.dbHandle, targetVersion)
+	case 27:
+		return downgradePgSQLDatabaseFromV27(p.dbHandle, targetVersion)
+	case 28:
+		return downgradePgSQLDatabaseFromV28(p.dbHandle, targetVersion)
+	case 29:
+		return downgradePgSQLDatabaseFromV29(p.dbHandle, targetVersion)
+	case 30:
+		return downgradePgSQLDatabaseFromV30(p.dbHandle, targetVersion)
+	case 31:
+		return downgradePgSQLDatabaseFromV31(p.dbHandle, targetVersion)
 	default:
 		return fmt.Errorf("database schema version not handled: %v", dbVersion.Version)
 	}
@@ -739,6 +946,10 @@ func (p *PGSQLProvider) resetDatabase() error {
 	return sqlCommonExecSQLAndUpdateDBVersion(p.dbHandle, []string{sql}, 0, false)
 }
 
+func (p *PGSQLProvider) backupDatabase(outputFile string) error {
+	return ErrNotImplemented
+}
+
 func updatePgSQLDatabaseFromV19(dbHandle *sql.DB) error {
 	if err := updatePgSQLDatabaseFrom19To20(dbHandle); err != nil {
 		return err
@@ -761,32 +972,177 @@ func updatePgSQLDatabaseFromV21(dbHandle *sql.DB) error {
 }
 
 func updatePgSQLDatabaseFromV22(dbHandle *sql.DB) error {
-	return updatePgSQLDatabaseFrom22To23(dbHandle)
+	if err := updatePgSQLDatabaseFrom22To23(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV23(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV23(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom23To24(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV24(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV24(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom24To25(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV25(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV25(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom25To26(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV26(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV26(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom26To27(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV27(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV27(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom27To28(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV28(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV28(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom28To29(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV29(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom29To30(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV30(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV30(dbHandle *sql.DB) error {
+	return updatePgSQLDatabaseFrom30To31(dbHandle)
 }
 
 func downgradePgSQLDatabaseFromV20(dbHandle *sql.DB) error {
 	return downgradePgSQLDatabaseFrom20To19(dbHandle)
 }
 
-func downgradePgSQLDatabaseFromV21(dbHandle *sql.DB) error {
+func downgradePgSQLDatabaseFromV21(dbHandle *sql.DB, targetVersion int) error {
 	if err := downgradePgSQLDatabaseFrom21To20(dbHandle); err != nil {
 		return err
 	}
+	if targetVersion == 20 {
+		return nil
+	}
 	return downgradePgSQLDatabaseFromV20(dbHandle)
 }
 
-func downgradePgSQLDatabaseFromV22(dbHandle *sql.DB) error {
+func downgradePgSQLDatabaseFromV22(dbHandle *sql.DB, targetVersion int) error {
 	if err := downgradePgSQLDatabaseFrom22To21(dbHandle); err != nil {
 		return err
 	}
-	return downgradePgSQLDatabaseFromV21(dbHandle)
+	if targetVersion == 21 {
+		return nil
+	}
+	return downgradePgSQLDatabaseFromV21(dbHandle, targetVersion)
 }
 
-func downgradePgSQLDatabaseFromV23(dbHandle *sql.DB) error {
+func downgradePgSQLDatabaseFromV23(dbHandle *sql.DB, targetVersion int) error {
 	if err := downgradePgSQLDatabaseFrom23To22(dbHandle); err != nil {
 		return err
 	}
-	return downgradePgSQLDatabaseFromV22(dbHandle)
+	if targetVersion == 22 {
+		return nil
+	}
+	return downgradePgSQLDatabaseFromV22(dbHandle, targetVersion)
+}
+
+func downgradePgSQLDatabaseFromV24(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradePgSQLDatabaseFrom24To23(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 23 {
+		return nil
+	}
+	return downgradePgSQLDatabaseFromV23(dbHandle, targetVersion)
+}
+
+func downgradePgSQLDatabaseFromV25(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradePgSQLDatabaseFrom25To24(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 24 {
+		return nil
+	}
+	return downgradePgSQLDatabaseFromV24(dbHandle, targetVersion)
+}
+
+func downgradePgSQLDatabaseFromV26(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradePgSQLDatabaseFrom26To25(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 25 {
+		return nil
+	}
+	return downgradePgSQLDatabaseFromV25(dbHandle, targetVersion)
+}
+
+func downgradePgSQLDatabaseFromV27(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradePgSQLDatabaseFrom27To26(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 26 {
+		return nil
+	}
+	return downgradePgSQLDatabaseFromV26(dbHandle, targetVersion)
+}
+
+func downgradePgSQLDatabaseFromV28(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradePgSQLDatabaseFrom28To27(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 27 {
+		return nil
+	}
+	return downgradePgSQLDatabaseFromV27(dbHandle, targetVersion)
+}
+
+func downgradePgSQLDatabaseFromV29(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradePgSQLDatabaseFrom29To28(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 28 {
+		return nil
+	}
+	return downgradePgSQLDatabaseFromV28(dbHandle, targetVersion)
+}
+
+func downgradePgSQLDatabaseFromV30(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradePgSQLDatabaseFrom30To29(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 29 {
+		return nil
+	}
+	return downgradePgSQLDatabaseFromV29(dbHandle, targetVersion)
+}
+
+func downgradePgSQLDatabaseFromV31(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradePgSQLDatabaseFrom31To30(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 30 {
+		return nil
+	}
+	return downgradePgSQLDatabaseFromV30(dbHandle, targetVersion)
 }
 
 func updatePgSQLDatabaseFrom19To20(dbHandle *sql.DB) error {
@@ -834,6 +1190,81 @@ func updatePgSQLDatabaseFrom22To23(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 23, true)
 }
 
+func updatePgSQLDatabaseFrom23To24(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 23 -> 24")
+	providerLog(logger.LevelInfo, "updating database schema version: 23 -> 24")
+	sql := strings.ReplaceAll(pgsqlV24SQL, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{groups}}", sqlTableGroups)
+	sql = strings.ReplaceAll(sql, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 24, true)
+}
+
+func updatePgSQLDatabaseFrom24To25(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 24 -> 25")
+	providerLog(logger.LevelInfo, "updating database schema version: 24 -> 25")
+	sql := strings.ReplaceAll(pgsqlV25SQL, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 25, true)
+}
+
+func updatePgSQLDatabaseFrom25To26(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 25 -> 26")
+	providerLog(logger.LevelInfo, "updating database schema version: 25 -> 26")
+	sql := strings.ReplaceAll(pgsqlV26SQL, "{{audit_trail}}", sqlTableAuditTrail)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 26, true)
+}
+
+func updatePgSQLDatabaseFrom26To27(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 26 -> 27")
+	providerLog(logger.LevelInfo, "updating database schema version: 26 -> 27")
+	sql := strings.ReplaceAll(pgsqlV27SQL, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 27, true)
+}
+
+func updatePgSQLDatabaseFrom27To28(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 27 -> 28")
+	providerLog(logger.LevelInfo, "updating database schema version: 27 -> 28")
+	sql := strings.ReplaceAll(pgsqlV28SQL, "{{dead_letters}}", sqlTableDeadLetters)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 28, true)
+}
+
+func updatePgSQLDatabaseFrom28To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 28 -> 29")
+	providerLog(logger.LevelInfo, "updating database schema version: 28 -> 29")
+	sql := pgsqlV29SQL
+	if config.Driver == CockroachDataProviderName {
+		for _, table := range []string{"users_folders_mapping", "groups_folders_mapping"} {
+			for _, column := range []string{"user_quota_size", "user_quota_files"} {
+				sql = strings.ReplaceAll(sql, fmt.Sprintf(`ALTER TABLE "{{%s}}" ALTER COLUMN "%s" DROP DEFAULT;`,
+					table, column), "")
+			}
+		}
+	}
+	sql = sqlReplaceAll(sql)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 29, true)
+}
+
+func updatePgSQLDatabaseFrom29To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 29 -> 30")
+	providerLog(logger.LevelInfo, "updating database schema version: 29 -> 30")
+	sql := pgsqlV30SQL
+	if config.Driver == CockroachDataProviderName {
+		sql = strings.ReplaceAll(sql, `ALTER TABLE "{{users}}" ALTER COLUMN "last_transfer_quota_reset" DROP DEFAULT;`, "")
+	}
+	sql = sqlReplaceAll(sql)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, true)
+}
+
+func updatePgSQLDatabaseFrom30To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 30 -> 31")
+	providerLog(logger.LevelInfo, "updating database schema version: 30 -> 31")
+	sql := sqlReplaceAll(pgsqlV31SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 31, true)
+}
+
 func downgradePgSQLDatabaseFrom20To19(dbHandle *sql.DB) error {
 	logger.InfoToConsole("downgrading database schema version: 20 -> 19")
 	providerLog(logger.LevelInfo, "downgrading database schema version: 20 -> 19")
@@ -871,3 +1302,63 @@ func downgradePgSQLDatabaseFrom23To22(dbHandle *sql.DB) error {
 	sql := strings.ReplaceAll(pgsqlV23DownSQL, "{{nodes}}", sqlTableNodes)
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 22, false)
 }
+
+func downgradePgSQLDatabaseFrom24To23(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 24 -> 23")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 24 -> 23")
+	sql := strings.ReplaceAll(pgsqlV24DownSQL, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{groups}}", sqlTableGroups)
+	sql = strings.ReplaceAll(sql, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 23, false)
+}
+
+func downgradePgSQLDatabaseFrom25To24(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 25 -> 24")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 25 -> 24")
+	sql := strings.ReplaceAll(pgsqlV25DownSQL, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 24, false)
+}
+
+func downgradePgSQLDatabaseFrom26To25(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 26 -> 25")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 26 -> 25")
+	sql := strings.ReplaceAll(pgsqlV26DownSQL, "{{audit_trail}}", sqlTableAuditTrail)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 25, false)
+}
+
+func downgradePgSQLDatabaseFrom27To26(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 27 -> 26")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 27 -> 26")
+	sql := strings.ReplaceAll(pgsqlV27DownSQL, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 26, false)
+}
+
+func downgradePgSQLDatabaseFrom28To27(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 28 -> 27")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 28 -> 27")
+	sql := strings.ReplaceAll(pgsqlV28DownSQL, "{{dead_letters}}", sqlTableDeadLetters)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 27, false)
+}
+
+func downgradePgSQLDatabaseFrom29To28(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 29 -> 28")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 29 -> 28")
+	sql := sqlReplaceAll(pgsqlV29DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 28, false)
+}
+
+func downgradePgSQLDatabaseFrom30To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 30 -> 29")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 30 -> 29")
+	sql := sqlReplaceAll(pgsqlV30DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 29, false)
+}
+
+func downgradePgSQLDatabaseFrom31To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 31 -> 30")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 31 -> 30")
+	sql := sqlReplaceAll(pgsqlV31DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, false)
+}
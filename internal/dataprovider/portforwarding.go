@@ -0,0 +1,187 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package dataprovider
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// SSHPortForwarding defines the TCP port forwarding allowed within SSH connections.
+// Port forwarding is disabled if both local and remote forwarding are not allowed
+type SSHPortForwarding struct {
+	// Allow local port forwarding, the client asks SFTPGo to connect to a destination
+	AllowLocal bool `json:"allow_local,omitempty"`
+	// Allow remote port forwarding, the client asks SFTPGo to listen on a port and
+	// to forward the accepted connections
+	AllowRemote bool `json:"allow_remote,omitempty"`
+	// Allowed destinations for local forwarding as "host:port". The host can contain
+	// the "*" and "?" wildcards or be in CIDR notation, the port can be "*".
+	// Empty means any destination
+	PermitOpen []string `json:"permit_open,omitempty"`
+	// Allowed listen addresses for remote forwarding as "host:port", same format
+	// as PermitOpen. Empty means any address
+	PermitListen []string `json:"permit_listen,omitempty"`
+	// Maximum bandwidth as KB/s for each forwarded connection and direction, 0 means no limit
+	Bandwidth int64 `json:"bandwidth,omitempty"`
+}
+
+// IsEnabled returns true if local or remote port forwarding is allowed
+func (p *SSHPortForwarding) IsEnabled() bool {
+	return p != nil && (p.AllowLocal || p.AllowRemote)
+}
+
+// IsDestinationAllowed returns true if local forwarding to the specified host and port is allowed.
+// The host can be a hostname or an IP address
+func (p *SSHPortForwarding) IsDestinationAllowed(host string, port int) bool {
+	if p == nil || !p.AllowLocal {
+		return false
+	}
+	return matchHostPortPatterns(p.PermitOpen, host, port)
+}
+
+// IsListenAllowed returns true if remote forwarding from the specified bind address and port
+// is allowed. Port 0 means a port dynamically allocated and it is only allowed for "*" ports
+func (p *SSHPortForwarding) IsListenAllowed(host string, port int) bool {
+	if p == nil || !p.AllowRemote {
+		return false
+	}
+	return matchHostPortPatterns(p.PermitListen, host, port)
+}
+
+// GetPermitOpenAsString returns the allowed destinations as comma separated string
+func (p SSHPortForwarding) GetPermitOpenAsString() string {
+	return strings.Join(p.PermitOpen, ",")
+}
+
+// GetPermitListenAsString returns the allowed listen addresses as comma separated string
+func (p SSHPortForwarding) GetPermitListenAsString() string {
+	return strings.Join(p.PermitListen, ",")
+}
+
+func (p *SSHPortForwarding) getACopy() *SSHPortForwarding {
+	if p == nil {
+		return nil
+	}
+	permitOpen := make([]string, len(p.PermitOpen))
+	copy(permitOpen, p.PermitOpen)
+	permitListen := make([]string, len(p.PermitListen))
+	copy(permitListen, p.PermitListen)
+
+	return &SSHPortForwarding{
+		AllowLocal:   p.AllowLocal,
+		AllowRemote:  p.AllowRemote,
+		PermitOpen:   permitOpen,
+		PermitListen: permitListen,
+		Bandwidth:    p.Bandwidth,
+	}
+}
+
+func (p *SSHPortForwarding) validate() error {
+	if p.Bandwidth < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid port forwarding bandwidth: %d", p.Bandwidth))
+	}
+	permitOpen, err := validateHostPortPatterns(p.PermitOpen)
+	if err != nil {
+		return util.NewValidationError(fmt.Sprintf("invalid port forwarding destination: %v", err))
+	}
+	p.PermitOpen = permitOpen
+	permitListen, err := validateHostPortPatterns(p.PermitListen)
+	if err != nil {
+		return util.NewValidationError(fmt.Sprintf("invalid port forwarding listen address: %v", err))
+	}
+	p.PermitListen = permitListen
+	return nil
+}
+
+func validateUserPortForwarding(user *User) error {
+	if !user.Filters.PortForwarding.IsEnabled() {
+		user.Filters.PortForwarding = nil
+		return nil
+	}
+	return user.Filters.PortForwarding.validate()
+}
+
+func validateHostPortPatterns(patterns []string) ([]string, error) {
+	var result []string
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		host, port, err := splitHostPortPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(host, "/") {
+			if _, _, err := net.ParseCIDR(host); err != nil {
+				return nil, fmt.Errorf("invalid CIDR in %q: %w", pattern, err)
+			}
+		}
+		if port != "*" {
+			p, err := strconv.Atoi(port)
+			if err != nil || p < 1 || p > 65535 {
+				return nil, fmt.Errorf("invalid port in %q", pattern)
+			}
+		}
+		if !util.Contains(result, pattern) {
+			result = append(result, pattern)
+		}
+	}
+	return result, nil
+}
+
+func splitHostPortPattern(pattern string) (string, string, error) {
+	host, port, err := net.SplitHostPort(pattern)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid pattern %q, the expected format is host:port", pattern)
+	}
+	if host == "" || port == "" {
+		return "", "", fmt.Errorf("invalid pattern %q, host and port are required", pattern)
+	}
+	return host, port, nil
+}
+
+func matchHostPortPatterns(patterns []string, host string, port int) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	host = strings.ToLower(host)
+	parsedIP := net.ParseIP(host)
+	for _, pattern := range patterns {
+		patternHost, patternPort, err := splitHostPortPattern(pattern)
+		if err != nil {
+			continue
+		}
+		if patternPort != "*" && patternPort != strconv.Itoa(port) {
+			continue
+		}
+		if parsedIP != nil {
+			if patternIP := net.ParseIP(patternHost); patternIP != nil {
+				if patternIP.Equal(parsedIP) {
+					return true
+				}
+				continue
+			}
+		}
+		if matchAddressPattern(host, parsedIP, strings.ToLower(patternHost)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// ForcedCommandSFTP is the only forced command supported within the authorized
+// keys options, it restricts the key to the SFTP subsystem as in OpenSSH
+const ForcedCommandSFTP = "internal-sftp"
+
+// supported OpenSSH authorized_keys options without a value.
+// "no-port-forwarding" and "restrict" deny the TCP/IP port forwarding requests
+// for the connections authenticated using the key, the SFTP service enforces
+// them. SFTPGo never allocates a PTY nor supports agent/X11 forwarding and user
+// rc files, so the other restrictions are honored without further checks
+var supportedPublicKeyFlags = []string{"restrict", "no-pty", "no-port-forwarding", "no-agent-forwarding",
+	"no-x11-forwarding", "no-user-rc"}
+
+// PublicKeyOptions defines the supported OpenSSH authorized_keys options for a public key
+type PublicKeyOptions struct {
+	// From is the list of source address patterns as in the "from" option.
+	// Patterns can contain the "*" and "?" wildcards or be in CIDR notation and
+	// can be negated by prefixing them with "!"
+	From []string
+	// ExpiryTime is the key expiration, the zero value means no expiration
+	ExpiryTime time.Time
+	// Command is the forced command, only "internal-sftp" is supported
+	Command string
+	// NoPTY is true if the "no-pty" or the "restrict" options are set
+	NoPTY bool
+	// NoPortForwarding is true if the "no-port-forwarding" or the "restrict" options are set
+	NoPortForwarding bool
+}
+
+// IsSFTPOnly returns true if the key can only be used for the SFTP subsystem
+func (o *PublicKeyOptions) IsSFTPOnly() bool {
+	return o.Command == ForcedCommandSFTP
+}
+
+// IsExpired returns true if the key is expired
+func (o *PublicKeyOptions) IsExpired() bool {
+	return !o.ExpiryTime.IsZero() && o.ExpiryTime.Before(time.Now())
+}
+
+// IsSourceAllowed returns true if the specified IP address matches the "from"
+// patterns. As in OpenSSH a negated match always denies the login
+func (o *PublicKeyOptions) IsSourceAllowed(ip string) bool {
+	if len(o.From) == 0 {
+		return true
+	}
+	parsedIP := net.ParseIP(ip)
+	allowed := false
+	for _, pattern := range o.From {
+		negated := strings.HasPrefix(pattern, "!")
+		if negated {
+			pattern = pattern[1:]
+		}
+		if matchAddressPattern(ip, parsedIP, pattern) {
+			if negated {
+				return false
+			}
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+// CheckLoginConditions returns an error if the key cannot be used to login from the specified IP
+func (o *PublicKeyOptions) CheckLoginConditions(ip string) error {
+	if o.IsExpired() {
+		return fmt.Errorf("public key expired on %s", o.ExpiryTime.Format(time.RFC3339))
+	}
+	if !o.IsSourceAllowed(ip) {
+		return fmt.Errorf("public key not allowed from address %q", ip)
+	}
+	return nil
+}
+
+func matchAddressPattern(ip string, parsedIP net.IP, pattern string) bool {
+	if strings.Contains(pattern, "/") {
+		_, ipNet, err := net.ParseCIDR(pattern)
+		return err == nil && parsedIP != nil && ipNet.Contains(parsedIP)
+	}
+	return matchWildcardPattern(ip, pattern)
+}
+
+// matchWildcardPattern matches s against a pattern with the "*" and "?" wildcards
+func matchWildcardPattern(s, pattern string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchWildcardPattern(s[i:], pattern) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		s = s[1:]
+		pattern = pattern[1:]
+	}
+	return s == ""
+}
+
+// ParsePublicKeyOptions parses the options returned by ssh.ParseAuthorizedKey.
+// An error is returned for unsupported or invalid options
+func ParsePublicKeyOptions(options []string) (PublicKeyOptions, error) {
+	var result PublicKeyOptions
+	for _, option := range options {
+		name, value, hasValue := strings.Cut(option, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !hasValue {
+			if !util.Contains(supportedPublicKeyFlags, name) {
+				return result, fmt.Errorf("unsupported option %q", option)
+			}
+			if name == "restrict" || name == "no-pty" {
+				result.NoPTY = true
+			}
+			if name == "restrict" || name == "no-port-forwarding" {
+				result.NoPortForwarding = true
+			}
+			continue
+		}
+		value, err := unquotePublicKeyOptionValue(value)
+		if err != nil {
+			return result, fmt.Errorf("invalid option %q: %w", option, err)
+		}
+		switch name {
+		case "from":
+			if len(result.From) > 0 {
+				return result, errors.New("the \"from\" option cannot be specified multiple times")
+			}
+			for _, pattern := range strings.Split(value, ",") {
+				pattern = strings.TrimSpace(pattern)
+				if pattern == "" || pattern == "!" {
+					return result, fmt.Errorf("invalid empty pattern in option %q", option)
+				}
+				if strings.Contains(pattern, "/") {
+					if _, _, err := net.ParseCIDR(strings.TrimPrefix(pattern, "!")); err != nil {
+						return result, fmt.Errorf("invalid CIDR pattern %q: %w", pattern, err)
+					}
+				}
+				result.From = append(result.From, pattern)
+			}
+		case "expiry-time":
+			expiryTime, err := parsePublicKeyExpiryTime(value)
+			if err != nil {
+				return result, err
+			}
+			result.ExpiryTime = expiryTime
+		case "command":
+			if value != ForcedCommandSFTP {
+				return result, fmt.Errorf("unsupported forced command %q, only %q is supported", value, ForcedCommandSFTP)
+			}
+			result.Command = value
+		default:
+			return result, fmt.Errorf("unsupported option %q", option)
+		}
+	}
+	return result, nil
+}
+
+func unquotePublicKeyOptionValue(value string) (string, error) {
+	if len(value) < 2 || !strings.HasPrefix(value, `"`) || !strings.HasSuffix(value, `"`) {
+		return "", errors.New("the option value must be enclosed in double quotes")
+	}
+	return strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`), nil
+}
+
+// parsePublicKeyExpiryTime parses an expiry time in the OpenSSH format
+// YYYYMMDD[HHMM[SS]], the time is local unless the "Z" suffix is used
+func parsePublicKeyExpiryTime(value string) (time.Time, error) {
+	loc := time.Local
+	if strings.HasSuffix(value, "Z") || strings.HasSuffix(value, "z") {
+		loc = time.UTC
+		value = value[:len(value)-1]
+	}
+	var layout string
+	switch len(value) {
+	case 8:
+		layout = "20060102"
+	case 12:
+		layout = "200601021504"
+	case 14:
+		layout = "20060102150405"
+	default:
+		return time.Time{}, fmt.Errorf("invalid expiry time %q", value)
+	}
+	t, err := time.ParseInLocation(layout, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry time %q: %w", value, err)
+	}
+	return t, nil
+}
+
+// GetPublicKeyOptions returns the authorized_keys options for the first stored
+// public key matching the specified one. The same key is used for authentication
+func (u *User) GetPublicKeyOptions(pubKey []byte) (PublicKeyOptions, error) {
+	for i, k := range u.PublicKeys {
+		storedPubKey, _, options, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		if err != nil {
+			return PublicKeyOptions{}, fmt.Errorf("error parsing stored public key %d: %w", i, err)
+		}
+		if bytes.Equal(storedPubKey.Marshal(), pubKey) {
+			return ParsePublicKeyOptions(options)
+		}
+	}
+	return PublicKeyOptions{}, ErrInvalidCredentials
+}
@@ -0,0 +1,177 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Rollout defines a staged activation, it allows to enable a configuration
+// object, such as an event rule, for a subset of cluster nodes and/or for a
+// percentage of users before the full activation
+type Rollout struct {
+	// Names of the cluster nodes where the object is active.
+	// Empty means all the nodes
+	Nodes []string `json:"nodes,omitempty" mapstructure:"nodes"`
+	// Percentage of the targets, for example the users, for which the object is active.
+	// The targets are selected using a stable hash so a target is always included
+	// or excluded for the same percentage. 0 and 100 mean all the targets
+	Percentage int `json:"percentage,omitempty" mapstructure:"percentage"`
+}
+
+// RolloutNodeStatus defines the rollout status for a cluster node
+type RolloutNodeStatus struct {
+	// Node name, empty for a single node setup
+	Name string `json:"name"`
+	// true if the object is active on this node
+	Active bool `json:"active"`
+	// true if this is the node that generated the report
+	Current bool `json:"current"`
+	// false if the node is included in the rollout but it is not running
+	Online bool `json:"online"`
+}
+
+// RolloutStatus defines the rollout status for a configuration object
+type RolloutStatus struct {
+	// true if a staged rollout is configured
+	IsStaged   bool                `json:"is_staged"`
+	Percentage int                 `json:"percentage"`
+	Nodes      []RolloutNodeStatus `json:"nodes"`
+}
+
+// IsStaged returns true if the object is not active for all the nodes and targets
+func (r *Rollout) IsStaged() bool {
+	return len(r.Nodes) > 0 || r.hasPercentage()
+}
+
+func (r *Rollout) hasPercentage() bool {
+	return r.Percentage > 0 && r.Percentage < 100
+}
+
+// IsActiveOnNode returns true if the object is active on the specified node
+func (r *Rollout) IsActiveOnNode(node string) bool {
+	if len(r.Nodes) == 0 {
+		return true
+	}
+	return util.Contains(r.Nodes, node)
+}
+
+// IsActive returns true if the object identified by the specified seed is active,
+// on the current node, for the specified target. An empty target means the
+// current node, so the percentage applies to the cluster nodes
+func (r *Rollout) IsActive(seed, target string) bool {
+	node := GetNodeName()
+	if !r.IsActiveOnNode(node) {
+		return false
+	}
+	if !r.hasPercentage() {
+		return true
+	}
+	if target == "" {
+		target = node
+	}
+	return r.isIncluded(seed, target)
+}
+
+// isIncluded returns true if the target is included in the configured percentage
+func (r *Rollout) isIncluded(seed, target string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(seed))
+	h.Write([]byte{0})
+	h.Write([]byte(target))
+	return int(h.Sum32()%100) < r.Percentage
+}
+
+// GetStatus returns the rollout status for the current node and for the
+// other cluster nodes. If nodesPercentage is true the percentage applies to
+// the cluster nodes, otherwise to the targets handled by each node
+func (r *Rollout) GetStatus(seed string, nodesPercentage bool) RolloutStatus {
+	status := RolloutStatus{
+		IsStaged:   r.IsStaged(),
+		Percentage: r.Percentage,
+	}
+	if status.Percentage <= 0 {
+		status.Percentage = 100
+	}
+	isActive := func(node string) bool {
+		if !r.IsActiveOnNode(node) {
+			return false
+		}
+		if nodesPercentage && r.hasPercentage() {
+			return r.isIncluded(seed, node)
+		}
+		return true
+	}
+	current := GetNodeName()
+	status.Nodes = append(status.Nodes, RolloutNodeStatus{
+		Name:    current,
+		Active:  isActive(current),
+		Current: true,
+		Online:  true,
+	})
+	names := []string{current}
+	nodes, _ := GetNodes()
+	for _, node := range nodes {
+		status.Nodes = append(status.Nodes, RolloutNodeStatus{
+			Name:   node.Name,
+			Active: isActive(node.Name),
+			Online: true,
+		})
+		names = append(names, node.Name)
+	}
+	for _, name := range r.Nodes {
+		if !util.Contains(names, name) {
+			status.Nodes = append(status.Nodes, RolloutNodeStatus{
+				Name:   name,
+				Active: isActive(name),
+			})
+		}
+	}
+	return status
+}
+
+// Validate returns an error if the rollout is not valid
+func (r *Rollout) Validate() error {
+	if r.Percentage < 0 || r.Percentage > 100 {
+		return util.NewValidationError(fmt.Sprintf("invalid rollout percentage: %d", r.Percentage))
+	}
+	if r.Percentage == 100 {
+		r.Percentage = 0
+	}
+	var nodes []string
+	for _, node := range r.Nodes {
+		node = strings.TrimSpace(node)
+		if node == "" {
+			return util.NewValidationError("rollout node names cannot be empty")
+		}
+		nodes = append(nodes, node)
+	}
+	r.Nodes = util.RemoveDuplicates(nodes, false)
+	return nil
+}
+
+func (r *Rollout) getACopy() Rollout {
+	nodes := make([]string, len(r.Nodes))
+	copy(nodes, r.Nodes)
+
+	return Rollout{
+		Nodes:      nodes,
+		Percentage: r.Percentage,
+	}
+}
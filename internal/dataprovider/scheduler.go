@@ -21,9 +21,11 @@ import (
 
 	"github.com/robfig/cron/v3"
 
+	"github.com/drakkan/sftpgo/v2/internal/jobs"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 var (
@@ -38,6 +40,7 @@ var (
 func stopScheduler() {
 	if scheduler != nil {
 		scheduler.Stop()
+		jobs.RemoveScheduler(scheduler)
 		scheduler = nil
 	}
 }
@@ -46,7 +49,7 @@ func startScheduler() error {
 	stopScheduler()
 
 	scheduler = cron.New(cron.WithLocation(time.UTC))
-	_, err := scheduler.AddFunc("@every 55s", checkDataprovider)
+	err := jobs.Add(scheduler, "provider_availability_check", "@every 55s", checkDataprovider)
 	if err != nil {
 		return fmt.Errorf("unable to schedule dataprovider availability check: %w", err)
 	}
@@ -57,14 +60,43 @@ func startScheduler() error {
 	if fnReloadRules != nil {
 		fnReloadRules()
 	}
+	if config.SoftDeleteRetention > 0 {
+		err = jobs.Add(scheduler, "provider_soft_deleted_users_cleanup", "@every 30m", removeExpiredSoftDeletedUsers)
+		if err != nil {
+			return fmt.Errorf("unable to schedule soft deleted users cleanup: %w", err)
+		}
+		err = jobs.Add(scheduler, "provider_soft_deleted_folders_cleanup", "@every 30m", removeExpiredSoftDeletedFolders)
+		if err != nil {
+			return fmt.Errorf("unable to schedule soft deleted folders cleanup: %w", err)
+		}
+	}
+	if config.TrackQuota > 0 {
+		err = jobs.Add(scheduler, "provider_transfer_quota_resets", "@every 5m", checkTransferQuotaResets)
+		if err != nil {
+			return fmt.Errorf("unable to schedule transfer quota resets: %w", err)
+		}
+	}
+	if config.Audit.Enabled && config.Audit.RetentionDays > 0 {
+		err = jobs.Add(scheduler, "provider_audit_trail_cleanup", "@every 1h", removeExpiredAuditEntries)
+		if err != nil {
+			return fmt.Errorf("unable to schedule audit trail cleanup: %w", err)
+		}
+	}
+	if config.DeadLetters.Enabled && config.DeadLetters.RetentionDays > 0 {
+		err = jobs.Add(scheduler, "provider_dead_letters_cleanup", "@every 1h", removeExpiredDeadLetters)
+		if err != nil {
+			return fmt.Errorf("unable to schedule dead letters cleanup: %w", err)
+		}
+	}
 	if currentNode != nil {
-		_, err = scheduler.AddFunc("@every 30m", func() {
+		err = jobs.Add(scheduler, "provider_nodes_cleanup", "@every 30m", func() error {
 			err := provider.cleanupNodes()
 			if err != nil {
 				providerLog(logger.LevelError, "unable to cleanup nodes: %v", err)
 			} else {
 				providerLog(logger.LevelDebug, "cleanup nodes ok")
 			}
+			return err
 		})
 	}
 	if err != nil {
@@ -76,14 +108,14 @@ func startScheduler() error {
 
 func addScheduledCacheUpdates() error {
 	lastUserCacheUpdate.Store(util.GetTimeAsMsSinceEpoch(time.Now()))
-	_, err := scheduler.AddFunc("@every 10m", checkCacheUpdates)
+	err := jobs.Add(scheduler, "provider_cache_updates", "@every 10m", checkCacheUpdates)
 	if err != nil {
 		return fmt.Errorf("unable to schedule cache updates: %w", err)
 	}
 	return nil
 }
 
-func checkDataprovider() {
+func checkDataprovider() error {
 	if currentNode != nil {
 		if err := provider.updateNodeTimestamp(); err != nil {
 			providerLog(logger.LevelError, "unable to update node timestamp: %v", err)
@@ -96,34 +128,114 @@ func checkDataprovider() {
 		providerLog(logger.LevelError, "check availability error: %v", err)
 	}
 	metric.UpdateDataProviderAvailability(err)
+	return err
 }
 
-func checkCacheUpdates() {
+func checkCacheUpdates() error {
 	providerLog(logger.LevelDebug, "start user cache check, update time %v", util.GetTimeFromMsecSinceEpoch(lastUserCacheUpdate.Load()))
 	checkTime := util.GetTimeAsMsSinceEpoch(time.Now())
 	users, err := provider.getRecentlyUpdatedUsers(lastUserCacheUpdate.Load())
 	if err != nil {
 		providerLog(logger.LevelError, "unable to get recently updated users: %v", err)
-		return
-	}
-	for _, user := range users {
-		providerLog(logger.LevelDebug, "invalidate caches for user %q", user.Username)
-		if user.DeletedAt > 0 {
-			deletedAt := util.GetTimeFromMsecSinceEpoch(user.DeletedAt)
-			if deletedAt.Add(30 * time.Minute).Before(time.Now()) {
-				providerLog(logger.LevelDebug, "removing user %q deleted at %s", user.Username, deletedAt)
-				go provider.deleteUser(user, false) //nolint:errcheck
-			}
-			webDAVUsersCache.remove(user.Username)
-			delayedQuotaUpdater.resetUserQuota(user.Username)
-		} else {
-			webDAVUsersCache.swap(&user)
-		}
-		cachedPasswords.Remove(user.Username)
+		return err
+	}
+	for idx := range users {
+		updateUserCaches(&users[idx])
 	}
 
 	lastUserCacheUpdate.Store(checkTime)
 	providerLog(logger.LevelDebug, "end user cache check, new update time %v", util.GetTimeFromMsecSinceEpoch(lastUserCacheUpdate.Load()))
+	return nil
+}
+
+func updateUserCaches(user *User) {
+	providerLog(logger.LevelDebug, "invalidate caches for user %q", user.Username)
+	if user.DeletedAt > 0 {
+		deletedAt := util.GetTimeFromMsecSinceEpoch(user.DeletedAt)
+		if deletedAt.Add(30 * time.Minute).Before(time.Now()) {
+			providerLog(logger.LevelDebug, "removing user %q deleted at %s", user.Username, deletedAt)
+			go provider.deleteUser(*user, false) //nolint:errcheck
+		}
+		webDAVUsersCache.remove(user.Username)
+		delayedQuotaUpdater.resetUserQuota(user.Username)
+	} else if user.IsSoftDeleted() {
+		webDAVUsersCache.remove(user.Username)
+		authCredentialsCache.remove(user.Username)
+		ldapCredentialsCache.remove(user.Username)
+	} else {
+		webDAVUsersCache.swap(user)
+	}
+	cachedPasswords.Remove(user.Username)
+}
+
+// removeUserCaches removes any cached data for a user that no longer exists
+func removeUserCaches(username string) {
+	providerLog(logger.LevelDebug, "remove caches for user %q", username)
+	webDAVUsersCache.remove(username)
+	delayedQuotaUpdater.resetUserQuota(username)
+	authCredentialsCache.remove(username)
+	ldapCredentialsCache.remove(username)
+	cachedPasswords.Remove(username)
+}
+
+func removeExpiredSoftDeletedUsers() error {
+	limit := 100
+	expiredBefore := util.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Duration(config.SoftDeleteRetention) * time.Hour))
+	var expired []User
+	for offset := 0; ; offset += limit {
+		users, err := provider.getSoftDeletedUsers(limit, offset, OrderASC)
+		if err != nil {
+			providerLog(logger.LevelError, "unable to get soft deleted users: %v", err)
+			return err
+		}
+		for _, user := range users {
+			if user.SoftDeletedAt < expiredBefore {
+				expired = append(expired, user)
+			}
+		}
+		if len(users) < limit {
+			break
+		}
+	}
+	for _, user := range expired {
+		if err := DeleteUser(user.Username, ActionExecutorSystem, ""); err != nil {
+			providerLog(logger.LevelError, "unable to remove soft deleted user %q: %v", user.Username, err)
+			continue
+		}
+		providerLog(logger.LevelInfo, "soft deleted user %q permanently removed, deleted at: %s", user.Username,
+			util.GetTimeFromMsecSinceEpoch(user.SoftDeletedAt))
+	}
+	return nil
+}
+
+func removeExpiredSoftDeletedFolders() error {
+	limit := 100
+	expiredBefore := util.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Duration(config.SoftDeleteRetention) * time.Hour))
+	var expired []vfs.BaseVirtualFolder
+	for offset := 0; ; offset += limit {
+		folders, err := provider.getSoftDeletedFolders(limit, offset, OrderASC)
+		if err != nil {
+			providerLog(logger.LevelError, "unable to get soft deleted folders: %v", err)
+			return err
+		}
+		for _, folder := range folders {
+			if folder.SoftDeletedAt < expiredBefore {
+				expired = append(expired, folder)
+			}
+		}
+		if len(folders) < limit {
+			break
+		}
+	}
+	for _, folder := range expired {
+		if err := DeleteFolder(folder.Name, ActionExecutorSystem, ""); err != nil {
+			providerLog(logger.LevelError, "unable to remove soft deleted folder %q: %v", folder.Name, err)
+			continue
+		}
+		providerLog(logger.LevelInfo, "soft deleted folder %q permanently removed, deleted at: %s", folder.Name,
+			util.GetTimeFromMsecSinceEpoch(folder.SoftDeletedAt))
+	}
+	return nil
 }
 
 func setLastUserUpdate() {
@@ -27,6 +27,11 @@ const (
 	SessionTypeOIDCAuth SessionType = iota + 1
 	SessionTypeOIDCToken
 	SessionTypeResetCode
+	SessionTypeApproval
+	SessionTypeHostKeys
+	SessionTypeWebDAVLocks
+	SessionTypeTUSUpload
+	SessionTypeWebAuthn
 )
 
 // Session defines a shared session persisted in the data provider
@@ -41,7 +46,7 @@ func (s *Session) validate() error {
 	if s.Key == "" {
 		return errors.New("unable to save a session with an empty key")
 	}
-	if s.Type < SessionTypeOIDCAuth || s.Type > SessionTypeResetCode {
+	if s.Type < SessionTypeOIDCAuth || s.Type > SessionTypeWebAuthn {
 		return fmt.Errorf("invalid session type: %v", s.Type)
 	}
 	return nil
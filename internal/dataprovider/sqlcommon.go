@@ -28,13 +28,14 @@ import (
 	"github.com/cockroachdb/cockroach-go/v2/crdb"
 	"github.com/sftpgo/sdk"
 
+	"github.com/drakkan/sftpgo/v2/internal/latency"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 const (
-	sqlDatabaseVersion     = 23
+	sqlDatabaseVersion     = 31
 	defaultSQLQueryTimeout = 10 * time.Second
 	longSQLQueryTimeout    = 60 * time.Second
 )
@@ -78,6 +79,9 @@ func sqlReplaceAll(sql string) string {
 	sql = strings.ReplaceAll(sql, "{{rules_actions_mapping}}", sqlTableRulesActionsMapping)
 	sql = strings.ReplaceAll(sql, "{{tasks}}", sqlTableTasks)
 	sql = strings.ReplaceAll(sql, "{{nodes}}", sqlTableNodes)
+	sql = strings.ReplaceAll(sql, "{{audit_trail}}", sqlTableAuditTrail)
+	sql = strings.ReplaceAll(sql, "{{dead_letters}}", sqlTableDeadLetters)
+	sql = strings.ReplaceAll(sql, "{{users_folders_quota}}", sqlTableUsersFoldersQuota)
 	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
 	return sql
 }
@@ -285,10 +289,15 @@ func sqlCommonAddAPIKey(apiKey *APIKey, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
+	scopes, err := getAPIKeyScopesForDb(apiKey)
+	if err != nil {
+		return err
+	}
+
 	q := getAddAPIKeyQuery()
 	_, err = dbHandle.ExecContext(ctx, q, apiKey.KeyID, apiKey.Name, apiKey.Key, apiKey.Scope,
 		util.GetTimeAsMsSinceEpoch(time.Now()), util.GetTimeAsMsSinceEpoch(time.Now()), apiKey.LastUseAt,
-		apiKey.ExpiresAt, apiKey.Description, userID, adminID)
+		apiKey.ExpiresAt, apiKey.Description, userID, adminID, scopes)
 	return err
 }
 
@@ -306,9 +315,14 @@ func sqlCommonUpdateAPIKey(apiKey *APIKey, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
+	scopes, err := getAPIKeyScopesForDb(apiKey)
+	if err != nil {
+		return err
+	}
+
 	q := getUpdateAPIKeyQuery()
 	_, err = dbHandle.ExecContext(ctx, q, apiKey.Name, apiKey.Scope, apiKey.ExpiresAt, userID, adminID,
-		apiKey.Description, util.GetTimeAsMsSinceEpoch(time.Now()), apiKey.KeyID)
+		apiKey.Description, scopes, util.GetTimeAsMsSinceEpoch(time.Now()), apiKey.KeyID)
 	return err
 }
 
@@ -390,6 +404,7 @@ func sqlCommonDumpAPIKeys(dbHandle sqlQuerier) ([]APIKey, error) {
 func sqlCommonGetAdminByUsername(username string, dbHandle sqlQuerier) (Admin, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
+	defer recordQueryLatency(time.Now())
 
 	q := getAdminByUsernameQuery()
 	row := dbHandle.QueryRowContext(ctx, q, username)
@@ -714,7 +729,7 @@ func sqlCommonAddGroup(group *Group, dbHandle *sql.DB) error {
 	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
 		q := getAddGroupQuery()
 		_, err := tx.ExecContext(ctx, q, group.Name, group.Description, util.GetTimeAsMsSinceEpoch(time.Now()),
-			util.GetTimeAsMsSinceEpoch(time.Now()), string(settings))
+			util.GetTimeAsMsSinceEpoch(time.Now()), string(settings), getMetadataAsNullString(group.Metadata))
 		if err != nil {
 			return err
 		}
@@ -736,7 +751,8 @@ func sqlCommonUpdateGroup(group *Group, dbHandle *sql.DB) error {
 
 	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
 		q := getUpdateGroupQuery()
-		_, err := tx.ExecContext(ctx, q, group.Description, settings, util.GetTimeAsMsSinceEpoch(time.Now()), group.Name)
+		_, err := tx.ExecContext(ctx, q, group.Description, settings, getMetadataAsNullString(group.Metadata),
+			util.GetTimeAsMsSinceEpoch(time.Now()), group.Name)
 		if err != nil {
 			return err
 		}
@@ -759,6 +775,7 @@ func sqlCommonDeleteGroup(group Group, dbHandle *sql.DB) error {
 func sqlCommonGetUserByUsername(username string, dbHandle sqlQuerier) (User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
+	defer recordQueryLatency(time.Now())
 
 	q := getUserByUsernameQuery()
 	row := dbHandle.QueryRowContext(ctx, q, username)
@@ -773,8 +790,12 @@ func sqlCommonGetUserByUsername(username string, dbHandle sqlQuerier) (User, err
 	return getUserWithGroups(ctx, user, dbHandle)
 }
 
-func sqlCommonValidateUserAndPass(username, password, ip, protocol string, dbHandle *sql.DB) (User, error) {
-	user, err := sqlCommonGetUserByUsername(username, dbHandle)
+func sqlCommonValidateUserAndPass(username, password, ip, protocol string, replicas *sqlReadReplicas, dbHandle *sql.DB) (User, error) {
+	user, err := lookupUserForAuth(username, func(name string) (User, error) {
+		return sqlReplicaRead(replicas, dbHandle, name, func(dbHandle sqlQuerier) (User, error) {
+			return sqlCommonGetUserByUsername(name, dbHandle)
+		})
+	})
 	if err != nil {
 		providerLog(logger.LevelWarn, "error authenticating user %#v: %v", username, err)
 		return user, err
@@ -782,12 +803,16 @@ func sqlCommonValidateUserAndPass(username, password, ip, protocol string, dbHan
 	return checkUserAndPass(&user, password, ip, protocol)
 }
 
-func sqlCommonValidateUserAndTLSCertificate(username, protocol string, tlsCert *x509.Certificate, dbHandle *sql.DB) (User, error) {
+func sqlCommonValidateUserAndTLSCertificate(username, protocol string, tlsCert *x509.Certificate, replicas *sqlReadReplicas, dbHandle *sql.DB) (User, error) {
 	var user User
 	if tlsCert == nil {
 		return user, errors.New("TLS certificate cannot be null or empty")
 	}
-	user, err := sqlCommonGetUserByUsername(username, dbHandle)
+	user, err := lookupUserForAuth(username, func(name string) (User, error) {
+		return sqlReplicaRead(replicas, dbHandle, name, func(dbHandle sqlQuerier) (User, error) {
+			return sqlCommonGetUserByUsername(name, dbHandle)
+		})
+	})
 	if err != nil {
 		providerLog(logger.LevelWarn, "error authenticating user %#v: %v", username, err)
 		return user, err
@@ -795,12 +820,16 @@ func sqlCommonValidateUserAndTLSCertificate(username, protocol string, tlsCert *
 	return checkUserAndTLSCertificate(&user, protocol, tlsCert)
 }
 
-func sqlCommonValidateUserAndPubKey(username string, pubKey []byte, isSSHCert bool, dbHandle *sql.DB) (User, string, error) {
+func sqlCommonValidateUserAndPubKey(username string, pubKey []byte, isSSHCert bool, replicas *sqlReadReplicas, dbHandle *sql.DB) (User, string, error) {
 	var user User
 	if len(pubKey) == 0 {
 		return user, "", errors.New("credentials cannot be null or empty")
 	}
-	user, err := sqlCommonGetUserByUsername(username, dbHandle)
+	user, err := lookupUserForAuth(username, func(name string) (User, error) {
+		return sqlReplicaRead(replicas, dbHandle, name, func(dbHandle sqlQuerier) (User, error) {
+			return sqlCommonGetUserByUsername(name, dbHandle)
+		})
+	})
 	if err != nil {
 		providerLog(logger.LevelWarn, "error authenticating user %#v: %v", username, err)
 		return user, "", err
@@ -808,6 +837,12 @@ func sqlCommonValidateUserAndPubKey(username string, pubKey []byte, isSSHCert bo
 	return checkUserAndPubKey(&user, pubKey, isSSHCert)
 }
 
+// recordQueryLatency records the latency for the queries executed to authenticate
+// users and admins, it is used for the latency based throttling
+func recordQueryLatency(startTime time.Time) {
+	latency.Record(latency.BackendDataProvider, time.Since(startTime))
+}
+
 func sqlCommonCheckAvailability(dbHandle *sql.DB) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -838,6 +873,24 @@ func sqlCommonUpdateTransferQuota(username string, uploadSize, downloadSize int6
 	return err
 }
 
+func sqlCommonResetUserTransferQuota(username string, scheduledAt int64, dbHandle *sql.DB) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	q := getResetTransferQuotaQuery()
+	res, err := dbHandle.ExecContext(ctx, q, now, now, username, scheduledAt)
+	if err != nil {
+		providerLog(logger.LevelError, "error resetting transfer quota for user %q: %v", username, err)
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
 func sqlCommonUpdateQuota(username string, filesAdd int, sizeAdd int64, reset bool, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -947,6 +1000,18 @@ func sqlCommonSetFirstUploadTimestamp(username string, dbHandle *sql.DB) error {
 	return sqlCommonRequireRowAffected(res)
 }
 
+func sqlCommonSetUserSoftDeletedAt(username string, softDeletedAt int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getSetUserSoftDeletedAtQuery()
+	res, err := dbHandle.ExecContext(ctx, q, softDeletedAt, util.GetTimeAsMsSinceEpoch(time.Now()), username)
+	if err != nil {
+		return err
+	}
+	return sqlCommonRequireRowAffected(res)
+}
+
 func sqlCommonUpdateLastLogin(username string, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -992,7 +1057,7 @@ func sqlCommonAddUser(user *User, dbHandle *sql.DB) error {
 			user.MaxSessions, user.QuotaSize, user.QuotaFiles, string(permissions), user.UploadBandwidth,
 			user.DownloadBandwidth, user.Status, user.ExpirationDate, string(filters), string(fsConfig), user.AdditionalInfo,
 			user.Description, user.Email, util.GetTimeAsMsSinceEpoch(time.Now()), util.GetTimeAsMsSinceEpoch(time.Now()),
-			user.UploadDataTransfer, user.DownloadDataTransfer, user.TotalDataTransfer)
+			user.UploadDataTransfer, user.DownloadDataTransfer, user.TotalDataTransfer, getMetadataAsNullString(user.Metadata))
 		if err != nil {
 			return err
 		}
@@ -1003,13 +1068,40 @@ func sqlCommonAddUser(user *User, dbHandle *sql.DB) error {
 	})
 }
 
-func sqlCommonUpdateUserPassword(username, password string, dbHandle *sql.DB) error {
+func sqlCommonUpdateUserPassword(username, password string, state *userPasswordState, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getUpdateUserPasswordQuery()
-	_, err := dbHandle.ExecContext(ctx, q, password, username)
-	return err
+	if state == nil {
+		q := getUpdateUserPasswordQuery()
+		_, err := dbHandle.ExecContext(ctx, q, password, username)
+		return err
+	}
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		var user User
+		var filters sql.NullString
+
+		q := getUserFiltersQuery()
+		if err := tx.QueryRowContext(ctx, q, username).Scan(&filters); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+			}
+			return err
+		}
+		if filters.Valid {
+			if err := json.Unmarshal([]byte(filters.String), &user.Filters); err != nil {
+				return err
+			}
+		}
+		state.apply(&user)
+		userFilters, err := user.GetFiltersAsJSON()
+		if err != nil {
+			return err
+		}
+		q = getUpdateUserPasswordAndFiltersQuery()
+		_, err = tx.ExecContext(ctx, q, password, string(userFilters), username)
+		return err
+	})
 }
 
 func sqlCommonUpdateUser(user *User, dbHandle *sql.DB) error {
@@ -1043,7 +1135,7 @@ func sqlCommonUpdateUser(user *User, dbHandle *sql.DB) error {
 			user.QuotaSize, user.QuotaFiles, string(permissions), user.UploadBandwidth, user.DownloadBandwidth, user.Status,
 			user.ExpirationDate, string(filters), string(fsConfig), user.AdditionalInfo, user.Description, user.Email,
 			util.GetTimeAsMsSinceEpoch(time.Now()), user.UploadDataTransfer, user.DownloadDataTransfer, user.TotalDataTransfer,
-			user.ID)
+			getMetadataAsNullString(user.Metadata), user.ID)
 		if err != nil {
 			return err
 		}
@@ -1347,12 +1439,12 @@ func sqlCommonGetActiveTransfers(from time.Time, dbHandle sqlQuerier) ([]ActiveT
 	return transfers, rows.Err()
 }
 
-func sqlCommonGetUsers(limit int, offset int, order string, dbHandle sqlQuerier) ([]User, error) {
+func sqlCommonGetUsers(limit int, offset int, order string, softDeleted bool, dbHandle sqlQuerier) ([]User, error) {
 	users := make([]User, 0, limit)
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getUsersQuery(order)
+	q := getUsersQuery(order, softDeleted)
 	rows, err := dbHandle.QueryContext(ctx, q, limit, offset)
 	if err != nil {
 		return users, err
@@ -1628,10 +1720,10 @@ func getShareFromDbRow(row sqlScanner) (Share, error) {
 func getAPIKeyFromDbRow(row sqlScanner) (APIKey, error) {
 	var apiKey APIKey
 	var userID, adminID sql.NullInt64
-	var description sql.NullString
+	var description, scopes sql.NullString
 
 	err := row.Scan(&apiKey.KeyID, &apiKey.Name, &apiKey.Key, &apiKey.Scope, &apiKey.CreatedAt, &apiKey.UpdatedAt,
-		&apiKey.LastUseAt, &apiKey.ExpiresAt, &description, &userID, &adminID)
+		&apiKey.LastUseAt, &apiKey.ExpiresAt, &description, &userID, &adminID, &scopes)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -1649,6 +1741,13 @@ func getAPIKeyFromDbRow(row sqlScanner) (APIKey, error) {
 	if description.Valid {
 		apiKey.Description = description.String
 	}
+	if scopes.Valid && scopes.String != "" {
+		var list []string
+		if err := json.Unmarshal([]byte(scopes.String), &list); err != nil {
+			return apiKey, errors.New("unable to decode API key scopes")
+		}
+		apiKey.Scopes = list
+	}
 
 	return apiKey, nil
 }
@@ -1748,9 +1847,9 @@ func getEventRuleFromDbRow(row sqlScanner) (EventRule, error) {
 
 func getGroupFromDbRow(row sqlScanner) (Group, error) {
 	var group Group
-	var userSettings, description sql.NullString
+	var userSettings, description, metadata sql.NullString
 
-	err := row.Scan(&group.ID, &group.Name, &description, &group.CreatedAt, &group.UpdatedAt, &userSettings)
+	err := row.Scan(&group.ID, &group.Name, &description, &group.CreatedAt, &group.UpdatedAt, &userSettings, &metadata)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return group, util.NewRecordNotFoundError(err.Error())
@@ -1767,6 +1866,7 @@ func getGroupFromDbRow(row sqlScanner) (Group, error) {
 			group.UserSettings = settings
 		}
 	}
+	group.Metadata = getMetadataFromNullString(metadata)
 
 	return group, nil
 }
@@ -1778,14 +1878,14 @@ func getUserFromDbRow(row sqlScanner) (User, error) {
 	var publicKey sql.NullString
 	var filters sql.NullString
 	var fsConfig sql.NullString
-	var additionalInfo, description, email sql.NullString
+	var additionalInfo, description, email, metadata sql.NullString
 
 	err := row.Scan(&user.ID, &user.Username, &password, &publicKey, &user.HomeDir, &user.UID, &user.GID, &user.MaxSessions,
 		&user.QuotaSize, &user.QuotaFiles, &permissions, &user.UsedQuotaSize, &user.UsedQuotaFiles, &user.LastQuotaUpdate,
 		&user.UploadBandwidth, &user.DownloadBandwidth, &user.ExpirationDate, &user.LastLogin, &user.Status, &filters, &fsConfig,
 		&additionalInfo, &description, &email, &user.CreatedAt, &user.UpdatedAt, &user.UploadDataTransfer, &user.DownloadDataTransfer,
 		&user.TotalDataTransfer, &user.UsedUploadDataTransfer, &user.UsedDownloadDataTransfer, &user.DeletedAt, &user.FirstDownload,
-		&user.FirstUpload)
+		&user.FirstUpload, &metadata, &user.SoftDeletedAt, &user.LastTransferQuotaReset)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return user, util.NewRecordNotFoundError(err.Error())
@@ -1837,17 +1937,41 @@ func getUserFromDbRow(row sqlScanner) (User, error) {
 	if email.Valid {
 		user.Email = email.String
 	}
+	user.Metadata = getMetadataFromNullString(metadata)
 	user.SetEmptySecretsIfNil()
 	return user, nil
 }
 
+func getMetadataAsNullString(metadata util.Metadata) sql.NullString {
+	if len(metadata) == 0 {
+		return sql.NullString{}
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(data), Valid: true}
+}
+
+// we do a relaxed check here, invalid metadata are ignored
+func getMetadataFromNullString(metadata sql.NullString) util.Metadata {
+	if !metadata.Valid || metadata.String == "" {
+		return nil
+	}
+	var result util.Metadata
+	if err := json.Unmarshal([]byte(metadata.String), &result); err != nil {
+		return nil
+	}
+	return result
+}
+
 func sqlCommonGetFolder(ctx context.Context, name string, dbHandle sqlQuerier) (vfs.BaseVirtualFolder, error) {
 	var folder vfs.BaseVirtualFolder
 	q := getFolderByNameQuery()
 	row := dbHandle.QueryRowContext(ctx, q, name)
-	var mappedPath, description, fsConfig sql.NullString
+	var mappedPath, description, fsConfig, metadata sql.NullString
 	err := row.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles, &folder.LastQuotaUpdate,
-		&folder.Name, &description, &fsConfig)
+		&folder.Name, &description, &fsConfig, &metadata, &folder.SoftDeletedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return folder, util.NewRecordNotFoundError(err.Error())
@@ -1867,6 +1991,7 @@ func sqlCommonGetFolder(ctx context.Context, name string, dbHandle sqlQuerier) (
 			folder.FsConfig = fs
 		}
 	}
+	folder.Metadata = getMetadataFromNullString(metadata)
 	return folder, err
 }
 
@@ -1901,7 +2026,7 @@ func sqlCommonAddOrUpdateFolder(ctx context.Context, baseFolder *vfs.BaseVirtual
 	}
 	q := getUpsertFolderQuery()
 	_, err = dbHandle.ExecContext(ctx, q, baseFolder.MappedPath, usedQuotaSize, usedQuotaFiles,
-		lastQuotaUpdate, baseFolder.Name, baseFolder.Description, string(fsConfig))
+		lastQuotaUpdate, baseFolder.Name, baseFolder.Description, string(fsConfig), getMetadataAsNullString(baseFolder.Metadata))
 	return err
 }
 
@@ -1919,7 +2044,7 @@ func sqlCommonAddFolder(folder *vfs.BaseVirtualFolder, dbHandle sqlQuerier) erro
 
 	q := getAddFolderQuery()
 	_, err = dbHandle.ExecContext(ctx, q, folder.MappedPath, folder.UsedQuotaSize, folder.UsedQuotaFiles,
-		folder.LastQuotaUpdate, folder.Name, folder.Description, string(fsConfig))
+		folder.LastQuotaUpdate, folder.Name, folder.Description, string(fsConfig), getMetadataAsNullString(folder.Metadata))
 	return err
 }
 
@@ -1936,7 +2061,8 @@ func sqlCommonUpdateFolder(folder *vfs.BaseVirtualFolder, dbHandle sqlQuerier) e
 	defer cancel()
 
 	q := getUpdateFolderQuery()
-	_, err = dbHandle.ExecContext(ctx, q, folder.MappedPath, folder.Description, string(fsConfig), folder.Name)
+	_, err = dbHandle.ExecContext(ctx, q, folder.MappedPath, folder.Description, string(fsConfig),
+		getMetadataAsNullString(folder.Metadata), folder.Name)
 	return err
 }
 
@@ -1952,6 +2078,18 @@ func sqlCommonDeleteFolder(folder vfs.BaseVirtualFolder, dbHandle sqlQuerier) er
 	return sqlCommonRequireRowAffected(res)
 }
 
+func sqlCommonSetFolderSoftDeletedAt(name string, softDeletedAt int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getSetFolderSoftDeletedAtQuery()
+	res, err := dbHandle.ExecContext(ctx, q, softDeletedAt, name)
+	if err != nil {
+		return err
+	}
+	return sqlCommonRequireRowAffected(res)
+}
+
 func sqlCommonDumpFolders(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error) {
 	folders := make([]vfs.BaseVirtualFolder, 0, 50)
 	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
@@ -1965,9 +2103,9 @@ func sqlCommonDumpFolders(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error)
 	defer rows.Close()
 	for rows.Next() {
 		var folder vfs.BaseVirtualFolder
-		var mappedPath, description, fsConfig sql.NullString
+		var mappedPath, description, fsConfig, metadata sql.NullString
 		err = rows.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-			&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig)
+			&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig, &metadata, &folder.SoftDeletedAt)
 		if err != nil {
 			return folders, err
 		}
@@ -1984,17 +2122,19 @@ func sqlCommonDumpFolders(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error)
 				folder.FsConfig = fs
 			}
 		}
+		folder.Metadata = getMetadataFromNullString(metadata)
 		folders = append(folders, folder)
 	}
 	return folders, rows.Err()
 }
 
-func sqlCommonGetFolders(limit, offset int, order string, minimal bool, dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error) {
+func sqlCommonGetFolders(limit, offset int, order string, minimal, softDeleted bool, dbHandle sqlQuerier,
+) ([]vfs.BaseVirtualFolder, error) {
 	folders := make([]vfs.BaseVirtualFolder, 0, limit)
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getFoldersQuery(order, minimal)
+	q := getFoldersQuery(order, minimal, softDeleted)
 	rows, err := dbHandle.QueryContext(ctx, q, limit, offset)
 	if err != nil {
 		return folders, err
@@ -2008,9 +2148,9 @@ func sqlCommonGetFolders(limit, offset int, order string, minimal bool, dbHandle
 				return folders, err
 			}
 		} else {
-			var mappedPath, description, fsConfig sql.NullString
+			var mappedPath, description, fsConfig, metadata sql.NullString
 			err = rows.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-				&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig)
+				&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig, &metadata, &folder.SoftDeletedAt)
 			if err != nil {
 				return folders, err
 			}
@@ -2027,6 +2167,7 @@ func sqlCommonGetFolders(limit, offset int, order string, minimal bool, dbHandle
 					folder.FsConfig = fs
 				}
 			}
+			folder.Metadata = getMetadataFromNullString(metadata)
 		}
 		folder.PrepareForRendering()
 		folders = append(folders, folder)
@@ -2066,7 +2207,8 @@ func sqlCommonClearUserGroupMapping(ctx context.Context, user *User, dbHandle sq
 
 func sqlCommonAddUserFolderMapping(ctx context.Context, user *User, folder *vfs.VirtualFolder, dbHandle sqlQuerier) error {
 	q := getAddUserFolderMappingQuery()
-	_, err := dbHandle.ExecContext(ctx, q, folder.VirtualPath, folder.QuotaSize, folder.QuotaFiles, folder.Name, user.Username)
+	_, err := dbHandle.ExecContext(ctx, q, folder.VirtualPath, folder.QuotaSize, folder.QuotaFiles,
+		folder.UserQuotaSize, folder.UserQuotaFiles, folder.Name, user.Username)
 	return err
 }
 
@@ -2078,7 +2220,8 @@ func sqlCommonClearAdminGroupMapping(ctx context.Context, admin *Admin, dbHandle
 
 func sqlCommonAddGroupFolderMapping(ctx context.Context, group *Group, folder *vfs.VirtualFolder, dbHandle sqlQuerier) error {
 	q := getAddGroupFolderMappingQuery()
-	_, err := dbHandle.ExecContext(ctx, q, folder.VirtualPath, folder.QuotaSize, folder.QuotaFiles, folder.Name, group.Name)
+	_, err := dbHandle.ExecContext(ctx, q, folder.VirtualPath, folder.QuotaSize, folder.QuotaFiles,
+		folder.UserQuotaSize, folder.UserQuotaFiles, folder.Name, group.Name)
 	return err
 }
 
@@ -2291,10 +2434,10 @@ func getUsersWithVirtualFolders(ctx context.Context, users []User, dbHandle sqlQ
 	for rows.Next() {
 		var folder vfs.VirtualFolder
 		var userID int64
-		var mappedPath, fsConfig, description sql.NullString
+		var mappedPath, fsConfig, description, metadata sql.NullString
 		err = rows.Scan(&folder.ID, &folder.Name, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-			&folder.LastQuotaUpdate, &folder.VirtualPath, &folder.QuotaSize, &folder.QuotaFiles, &userID, &fsConfig,
-			&description)
+			&folder.LastQuotaUpdate, &folder.VirtualPath, &folder.QuotaSize, &folder.QuotaFiles, &folder.UserQuotaSize,
+			&folder.UserQuotaFiles, &userID, &fsConfig, &description, &metadata)
 		if err != nil {
 			return users, err
 		}
@@ -2311,6 +2454,7 @@ func getUsersWithVirtualFolders(ctx context.Context, users []User, dbHandle sqlQ
 				folder.FsConfig = fs
 			}
 		}
+		folder.Metadata = getMetadataFromNullString(metadata)
 		usersVirtualFolders[userID] = append(usersVirtualFolders[userID], folder)
 	}
 	err = rows.Err()
@@ -2421,10 +2565,10 @@ func getGroupsWithVirtualFolders(ctx context.Context, groups []Group, dbHandle s
 	for rows.Next() {
 		var groupID int64
 		var folder vfs.VirtualFolder
-		var mappedPath, fsConfig, description sql.NullString
+		var mappedPath, fsConfig, description, metadata sql.NullString
 		err = rows.Scan(&folder.ID, &folder.Name, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-			&folder.LastQuotaUpdate, &folder.VirtualPath, &folder.QuotaSize, &folder.QuotaFiles, &groupID, &fsConfig,
-			&description)
+			&folder.LastQuotaUpdate, &folder.VirtualPath, &folder.QuotaSize, &folder.QuotaFiles, &folder.UserQuotaSize,
+			&folder.UserQuotaFiles, &groupID, &fsConfig, &description, &metadata)
 		if err != nil {
 			return groups, err
 		}
@@ -2441,6 +2585,7 @@ func getGroupsWithVirtualFolders(ctx context.Context, groups []Group, dbHandle s
 				folder.FsConfig = fs
 			}
 		}
+		folder.Metadata = getMetadataFromNullString(metadata)
 		groupsVirtualFolders[groupID] = append(groupsVirtualFolders[groupID], folder)
 	}
 	err = rows.Err()
@@ -2631,6 +2776,70 @@ func sqlCommonGetFolderUsedQuota(mappedPath string, dbHandle *sql.DB) (int, int6
 	return usedFiles, usedSize, err
 }
 
+func sqlCommonUpdateUserFolderQuota(username, folderName string, filesAdd int, sizeAdd int64, reset bool,
+	dbHandle *sql.DB,
+) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	res, err := dbHandle.ExecContext(ctx, getUpdateUserFolderQuotaQuery(reset), sizeAdd, filesAdd, now, username,
+		folderName)
+	if err == nil {
+		var rows int64
+		rows, err = res.RowsAffected()
+		if err == nil && rows == 0 {
+			// first update for this user and folder
+			_, err = dbHandle.ExecContext(ctx, getAddUserFolderQuotaQuery(), sizeAdd, filesAdd, now, username, folderName)
+			if err != nil {
+				// the row could be added concurrently, retry the update
+				res, errUpdate := dbHandle.ExecContext(ctx, getUpdateUserFolderQuotaQuery(reset), sizeAdd, filesAdd, now,
+					username, folderName)
+				if errUpdate == nil {
+					if rows, errUpdate = res.RowsAffected(); errUpdate == nil {
+						if rows > 0 {
+							err = nil
+						} else {
+							// the insert fails if the user or the folder does not exist
+							err = util.NewRecordNotFoundError(fmt.Sprintf("unable to update quota for user %q, folder %q: %v",
+								username, folderName, err))
+						}
+					}
+				}
+			}
+		}
+	}
+	if err == nil {
+		providerLog(logger.LevelDebug, "quota updated for user %q, folder %q, files increment: %v size increment: %v is reset? %v",
+			username, folderName, filesAdd, sizeAdd, reset)
+	} else {
+		providerLog(logger.LevelWarn, "error updating quota for user %q, folder %q: %v", username, folderName, err)
+	}
+	return err
+}
+
+func sqlCommonGetUserFoldersQuota(username string, dbHandle *sql.DB) ([]UserFolderQuota, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	rows, err := dbHandle.QueryContext(ctx, getUserFoldersQuotaQuery(), username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []UserFolderQuota
+	for rows.Next() {
+		var quota UserFolderQuota
+		if err := rows.Scan(&quota.FolderName, &quota.UsedQuotaSize, &quota.UsedQuotaFiles,
+			&quota.LastQuotaUpdate); err != nil {
+			return result, err
+		}
+		result = append(result, quota)
+	}
+	return result, rows.Err()
+}
+
 func getAPIKeyWithRelatedFields(ctx context.Context, apiKey APIKey, dbHandle sqlQuerier) (APIKey, error) {
 	var apiKeys []APIKey
 	var err error
@@ -2713,6 +2922,20 @@ func sqlCommonGetAPIKeyRelatedIDs(apiKey *APIKey) (sql.NullInt64, sql.NullInt64,
 	return userID, adminID, nil
 }
 
+func getAPIKeyScopesForDb(apiKey *APIKey) (sql.NullString, error) {
+	var scopes sql.NullString
+	if len(apiKey.Scopes) == 0 {
+		return scopes, nil
+	}
+	data, err := json.Marshal(apiKey.Scopes)
+	if err != nil {
+		return scopes, err
+	}
+	scopes.Valid = true
+	scopes.String = string(data)
+	return scopes, nil
+}
+
 func sqlCommonAddSession(session Session, dbHandle *sql.DB) error {
 	if err := session.validate(); err != nil {
 		return err
@@ -2744,6 +2967,30 @@ func sqlCommonGetSession(key string, dbHandle sqlQuerier) (Session, error) {
 	return session, nil
 }
 
+func sqlCommonGetSessions(sessionType SessionType, after int64, dbHandle sqlQuerier) ([]Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getSessionsQuery()
+	rows, err := dbHandle.QueryContext(ctx, q, sessionType, after)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		var data []byte
+		if err := rows.Scan(&session.Key, &data, &session.Type, &session.Timestamp); err != nil {
+			return nil, err
+		}
+		session.Data = data
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
 func sqlCommonDeleteSession(key string, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -3346,8 +3593,162 @@ func sqlCommonCleanupNodes(dbHandle *sql.DB) error {
 	return err
 }
 
+func sqlCommonAddAuditEntry(entry *AuditEntry, dbHandle *sql.DB) error {
+	changes, err := entry.getChangesAsJSON()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getAddAuditEntryQuery()
+	_, err = dbHandle.ExecContext(ctx, q, entry.Timestamp, entry.Action, entry.ObjectType, entry.ObjectName,
+		entry.Executor, entry.IP, changes)
+	return err
+}
+
+func sqlCommonGetAuditEntries(filters AuditFilters, limit, offset int, order string, dbHandle sqlQuerier) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0, limit)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q, args := getAuditEntriesQuery(filters, order)
+	args = append(args, limit, offset)
+	rows, err := dbHandle.QueryContext(ctx, q, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry AuditEntry
+		var changes sql.NullString
+
+		err = rows.Scan(&entry.ID, &entry.Timestamp, &entry.Action, &entry.ObjectType, &entry.ObjectName,
+			&entry.Executor, &entry.IP, &changes)
+		if err != nil {
+			return entries, err
+		}
+		if changes.Valid {
+			if err = entry.setChangesFromJSON(changes.String); err != nil {
+				return entries, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func sqlCommonCleanupAuditEntries(before int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q := getCleanupAuditEntriesQuery()
+	_, err := dbHandle.ExecContext(ctx, q, before)
+	return err
+}
+
+func sqlCommonAddDeadLetter(entry *DeadLetter, dbHandle *sql.DB) error {
+	headers, err := entry.getHeadersAsJSON()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getAddDeadLetterQuery()
+	_, err = dbHandle.ExecContext(ctx, q, entry.Timestamp, entry.Source, entry.Name, entry.Method, entry.URL,
+		entry.Username, headers, entry.Body, entry.Error, entry.Attempts)
+	return err
+}
+
+func getDeadLetterFromDbRow(row sqlScanner) (DeadLetter, error) {
+	var entry DeadLetter
+	var username, headers, body, errorString sql.NullString
+
+	err := row.Scan(&entry.ID, &entry.Timestamp, &entry.Source, &entry.Name, &entry.Method, &entry.URL,
+		&username, &headers, &body, &errorString, &entry.Attempts)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entry, util.NewRecordNotFoundError(err.Error())
+		}
+		return entry, err
+	}
+	if username.Valid {
+		entry.Username = username.String
+	}
+	if body.Valid {
+		entry.Body = body.String
+	}
+	if errorString.Valid {
+		entry.Error = errorString.String
+	}
+	if headers.Valid {
+		err = entry.setHeadersFromJSON(headers.String)
+	}
+	return entry, err
+}
+
+func sqlCommonGetDeadLetters(filters DeadLetterFilters, limit, offset int, order string, dbHandle sqlQuerier) ([]DeadLetter, error) {
+	entries := make([]DeadLetter, 0, limit)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q, args := getDeadLettersQuery(filters, order)
+	args = append(args, limit, offset)
+	rows, err := dbHandle.QueryContext(ctx, q, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry, err := getDeadLetterFromDbRow(rows)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func sqlCommonGetDeadLetter(id int64, dbHandle sqlQuerier) (DeadLetter, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getDeadLetterByIDQuery()
+	row := dbHandle.QueryRowContext(ctx, q, id)
+	return getDeadLetterFromDbRow(row)
+}
+
+func sqlCommonDeleteDeadLetter(id int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getDeleteDeadLetterQuery()
+	res, err := dbHandle.ExecContext(ctx, q, id)
+	if err != nil {
+		return err
+	}
+	return sqlCommonRequireRowAffected(res)
+}
+
+func sqlCommonCleanupDeadLetters(before int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q := getCleanupDeadLettersQuery()
+	_, err := dbHandle.ExecContext(ctx, q, before)
+	return err
+}
+
 func sqlCommonGetDatabaseVersion(dbHandle sqlQuerier, showInitWarn bool) (schemaVersion, error) {
 	var result schemaVersion
+	if migrationPlan != nil && !migrationPlan.IsEmpty() {
+		// the planned steps are not executed, return the version they would set
+		result.Version = migrationPlan.getVersion()
+		return result, nil
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
@@ -3363,6 +3764,9 @@ func sqlCommonGetDatabaseVersion(dbHandle sqlQuerier, showInitWarn bool) (schema
 	defer stmt.Close()
 	row := stmt.QueryRowContext(ctx)
 	err = row.Scan(&result.Version)
+	if err == nil && migrationPlan != nil {
+		migrationPlan.CurrentVersion = result.Version
+	}
 	return result, err
 }
 
@@ -3383,6 +3787,10 @@ func sqlCommonUpdateDatabaseVersion(ctx context.Context, dbHandle sqlQuerier, ve
 }
 
 func sqlCommonExecSQLAndUpdateDBVersion(dbHandle *sql.DB, sqlQueries []string, newVersion int, isUp bool) error {
+	if migrationPlan != nil && newVersion > 0 {
+		migrationPlan.addStep(sqlQueries, newVersion)
+		return nil
+	}
 	if err := sqlAcquireLock(dbHandle); err != nil {
 		return err
 	}
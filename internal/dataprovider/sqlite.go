@@ -23,12 +23,13 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	// we import go-sqlite3 here to be able to disable SQLite support using a build tag
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
@@ -40,6 +41,7 @@ const (
 	sqliteResetSQL = `DROP TABLE IF EXISTS "{{api_keys}}";
 DROP TABLE IF EXISTS "{{folders_mapping}}";
 DROP TABLE IF EXISTS "{{users_folders_mapping}}";
+DROP TABLE IF EXISTS "{{users_folders_quota}}";
 DROP TABLE IF EXISTS "{{users_groups_mapping}}";
 DROP TABLE IF EXISTS "{{admins_groups_mapping}}";
 DROP TABLE IF EXISTS "{{groups_folders_mapping}}";
@@ -56,6 +58,8 @@ DROP TABLE IF EXISTS "{{rules_actions_mapping}}";
 DROP TABLE IF EXISTS "{{events_rules}}";
 DROP TABLE IF EXISTS "{{events_actions}}";
 DROP TABLE IF EXISTS "{{tasks}}";
+DROP TABLE IF EXISTS "{{audit_trail}}";
+DROP TABLE IF EXISTS "{{dead_letters}}";
 DROP TABLE IF EXISTS "{{schema_version}}";
 `
 	sqliteInitialSQL = `CREATE TABLE "{{schema_version}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "version" integer NOT NULL);
@@ -177,8 +181,57 @@ CREATE INDEX "{{prefix}}admins_groups_mapping_admin_id_idx" ON "{{admins_groups_
 CREATE INDEX "{{prefix}}admins_groups_mapping_group_id_idx" ON "{{admins_groups_mapping}}" ("group_id");
 `
 	sqliteV22DownSQL = `DROP TABLE "{{admins_groups_mapping}}";`
+	sqliteV24SQL     = `ALTER TABLE "{{users}}" ADD COLUMN "metadata" text NULL;
+ALTER TABLE "{{groups}}" ADD COLUMN "metadata" text NULL;
+ALTER TABLE "{{folders}}" ADD COLUMN "metadata" text NULL;`
+	sqliteV24DownSQL = `ALTER TABLE "{{folders}}" DROP COLUMN "metadata";
+ALTER TABLE "{{groups}}" DROP COLUMN "metadata";
+ALTER TABLE "{{users}}" DROP COLUMN "metadata";`
+	sqliteV25SQL = `ALTER TABLE "{{users}}" ADD COLUMN "soft_deleted_at" bigint DEFAULT 0 NOT NULL;
+CREATE INDEX "{{prefix}}users_soft_deleted_at_idx" ON "{{users}}" ("soft_deleted_at");`
+	sqliteV25DownSQL = `DROP INDEX IF EXISTS "{{prefix}}users_soft_deleted_at_idx";
+ALTER TABLE "{{users}}" DROP COLUMN "soft_deleted_at";`
+	sqliteV26SQL = `CREATE TABLE "{{audit_trail}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"timestamp" bigint NOT NULL, "action" varchar(32) NOT NULL, "object_type" varchar(50) NOT NULL,
+"object_name" varchar(255) NOT NULL, "executor" varchar(255) NOT NULL, "ip" varchar(50) NOT NULL, "changes" text NULL);
+CREATE INDEX "{{prefix}}audit_trail_timestamp_idx" ON "{{audit_trail}}" ("timestamp");
+CREATE INDEX "{{prefix}}audit_trail_object_idx" ON "{{audit_trail}}" ("object_type", "object_name");`
+	sqliteV26DownSQL = `DROP TABLE IF EXISTS "{{audit_trail}}";`
+	sqliteV27SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "soft_deleted_at" bigint DEFAULT 0 NOT NULL;
+CREATE INDEX "{{prefix}}folders_soft_deleted_at_idx" ON "{{folders}}" ("soft_deleted_at");`
+	sqliteV27DownSQL = `DROP INDEX IF EXISTS "{{prefix}}folders_soft_deleted_at_idx";
+ALTER TABLE "{{folders}}" DROP COLUMN "soft_deleted_at";`
+	sqliteV28SQL = `CREATE TABLE "{{dead_letters}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"timestamp" bigint NOT NULL, "source" varchar(50) NOT NULL, "name" varchar(255) NOT NULL, "method" varchar(10) NOT NULL,
+"url" text NOT NULL, "username" varchar(255) NULL, "headers" text NULL, "body" text NULL, "last_error" text NULL,
+"attempts" integer NOT NULL);
+CREATE INDEX "{{prefix}}dead_letters_timestamp_idx" ON "{{dead_letters}}" ("timestamp");
+CREATE INDEX "{{prefix}}dead_letters_source_name_idx" ON "{{dead_letters}}" ("source", "name");`
+	sqliteV28DownSQL = `DROP TABLE IF EXISTS "{{dead_letters}}";`
+	sqliteV29SQL     = `ALTER TABLE "{{users_folders_mapping}}" ADD COLUMN "user_quota_size" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{users_folders_mapping}}" ADD COLUMN "user_quota_files" integer DEFAULT 0 NOT NULL;
+ALTER TABLE "{{groups_folders_mapping}}" ADD COLUMN "user_quota_size" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{groups_folders_mapping}}" ADD COLUMN "user_quota_files" integer DEFAULT 0 NOT NULL;
+CREATE TABLE "{{users_folders_quota}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"user_id" integer NOT NULL REFERENCES "{{users}}" ("id") ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+"folder_id" integer NOT NULL REFERENCES "{{folders}}" ("id") ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+"used_quota_size" bigint NOT NULL, "used_quota_files" integer NOT NULL, "last_quota_update" bigint NOT NULL,
+CONSTRAINT "{{prefix}}unique_user_folder_quota" UNIQUE ("user_id", "folder_id"));
+CREATE INDEX "{{prefix}}users_folders_quota_folder_id_idx" ON "{{users_folders_quota}}" ("folder_id");`
+	sqliteV29DownSQL = `DROP TABLE IF EXISTS "{{users_folders_quota}}";
+ALTER TABLE "{{groups_folders_mapping}}" DROP COLUMN "user_quota_files";
+ALTER TABLE "{{groups_folders_mapping}}" DROP COLUMN "user_quota_size";
+ALTER TABLE "{{users_folders_mapping}}" DROP COLUMN "user_quota_files";
+ALTER TABLE "{{users_folders_mapping}}" DROP COLUMN "user_quota_size";`
+	sqliteV30SQL     = `ALTER TABLE "{{users}}" ADD COLUMN "last_transfer_quota_reset" bigint DEFAULT 0 NOT NULL;`
+	sqliteV30DownSQL = `ALTER TABLE "{{users}}" DROP COLUMN "last_transfer_quota_reset";`
+	sqliteV31SQL     = `ALTER TABLE "{{api_keys}}" ADD COLUMN "scopes" text NULL;`
+	sqliteV31DownSQL = `ALTER TABLE "{{api_keys}}" DROP COLUMN "scopes";`
 )
 
+// number of database pages copied for each online backup step
+const sqliteBackupPagesPerStep = 1024
+
 // SQLiteProvider defines the auth provider for SQLite database
 type SQLiteProvider struct {
 	dbHandle *sql.DB
@@ -200,7 +253,7 @@ func initializeSQLiteProvider(basePath string) error {
 		if !filepath.IsAbs(dbPath) {
 			dbPath = filepath.Join(basePath, dbPath)
 		}
-		connectionString = fmt.Sprintf("file:%v?cache=shared&_foreign_keys=1", dbPath)
+		connectionString = fmt.Sprintf("file:%v?cache=shared&_foreign_keys=1%s", dbPath, config.SQLite.getConnectionParams())
 	} else {
 		connectionString = config.ConnectionString
 	}
@@ -221,21 +274,25 @@ func (p *SQLiteProvider) checkAvailability() error {
 }
 
 func (p *SQLiteProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
-	return sqlCommonValidateUserAndPass(username, password, ip, protocol, p.dbHandle)
+	return sqlCommonValidateUserAndPass(username, password, ip, protocol, nil, p.dbHandle)
 }
 
 func (p *SQLiteProvider) validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error) {
-	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.dbHandle)
+	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, nil, p.dbHandle)
 }
 
 func (p *SQLiteProvider) validateUserAndPubKey(username string, publicKey []byte, isSSHCert bool) (User, string, error) {
-	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.dbHandle)
+	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, nil, p.dbHandle)
 }
 
 func (p *SQLiteProvider) updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error {
 	return sqlCommonUpdateTransferQuota(username, uploadSize, downloadSize, reset, p.dbHandle)
 }
 
+func (p *SQLiteProvider) resetUserTransferQuota(username string, scheduledAt int64) (bool, error) {
+	return sqlCommonResetUserTransferQuota(username, scheduledAt, p.dbHandle)
+}
+
 func (p *SQLiteProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
 	return sqlCommonUpdateQuota(username, filesAdd, sizeAdd, reset, p.dbHandle)
 }
@@ -272,8 +329,8 @@ func (p *SQLiteProvider) deleteUser(user User, softDelete bool) error {
 	return sqlCommonDeleteUser(user, softDelete, p.dbHandle)
 }
 
-func (p *SQLiteProvider) updateUserPassword(username, password string) error {
-	return sqlCommonUpdateUserPassword(username, password, p.dbHandle)
+func (p *SQLiteProvider) updateUserPassword(username, password string, state *userPasswordState) error {
+	return sqlCommonUpdateUserPassword(username, password, state, p.dbHandle)
 }
 
 func (p *SQLiteProvider) dumpUsers() ([]User, error) {
@@ -285,7 +342,11 @@ func (p *SQLiteProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
 }
 
 func (p *SQLiteProvider) getUsers(limit int, offset int, order string) ([]User, error) {
-	return sqlCommonGetUsers(limit, offset, order, p.dbHandle)
+	return sqlCommonGetUsers(limit, offset, order, false, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getSoftDeletedUsers(limit int, offset int, order string) ([]User, error) {
+	return sqlCommonGetUsers(limit, offset, order, true, p.dbHandle)
 }
 
 func (p *SQLiteProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
@@ -297,7 +358,15 @@ func (p *SQLiteProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 }
 
 func (p *SQLiteProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
-	return sqlCommonGetFolders(limit, offset, order, minimal, p.dbHandle)
+	return sqlCommonGetFolders(limit, offset, order, minimal, false, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getSoftDeletedFolders(limit, offset int, order string) ([]vfs.BaseVirtualFolder, error) {
+	return sqlCommonGetFolders(limit, offset, order, false, true, p.dbHandle)
+}
+
+func (p *SQLiteProvider) setFolderSoftDeletedAt(name string, softDeletedAt int64) error {
+	return sqlCommonSetFolderSoftDeletedAt(name, softDeletedAt, p.dbHandle)
 }
 
 func (p *SQLiteProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
@@ -322,6 +391,14 @@ func (p *SQLiteProvider) updateFolderQuota(name string, filesAdd int, sizeAdd in
 	return sqlCommonUpdateFolderQuota(name, filesAdd, sizeAdd, reset, p.dbHandle)
 }
 
+func (p *SQLiteProvider) updateUserFolderQuota(username, folderName string, filesAdd int, sizeAdd int64, reset bool) error {
+	return sqlCommonUpdateUserFolderQuota(username, folderName, filesAdd, sizeAdd, reset, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getUserFoldersQuota(username string) ([]UserFolderQuota, error) {
+	return sqlCommonGetUserFoldersQuota(username, p.dbHandle)
+}
+
 func (p *SQLiteProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return sqlCommonGetFolderUsedQuota(name, p.dbHandle)
 }
@@ -506,6 +583,10 @@ func (p *SQLiteProvider) getSharedSession(key string) (Session, error) {
 	return sqlCommonGetSession(key, p.dbHandle)
 }
 
+func (p *SQLiteProvider) getSharedSessions(sessionType SessionType, after int64) ([]Session, error) {
+	return sqlCommonGetSessions(sessionType, after, p.dbHandle)
+}
+
 func (p *SQLiteProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
@@ -598,6 +679,38 @@ func (*SQLiteProvider) cleanupNodes() error {
 	return ErrNotImplemented
 }
 
+func (p *SQLiteProvider) addAuditEntry(entry *AuditEntry) error {
+	return sqlCommonAddAuditEntry(entry, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getAuditEntries(filters AuditFilters, limit, offset int, order string) ([]AuditEntry, error) {
+	return sqlCommonGetAuditEntries(filters, limit, offset, order, p.dbHandle)
+}
+
+func (p *SQLiteProvider) cleanupAuditEntries(before int64) error {
+	return sqlCommonCleanupAuditEntries(before, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addDeadLetter(entry *DeadLetter) error {
+	return sqlCommonAddDeadLetter(entry, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getDeadLetters(filters DeadLetterFilters, limit, offset int, order string) ([]DeadLetter, error) {
+	return sqlCommonGetDeadLetters(filters, limit, offset, order, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getDeadLetter(id int64) (DeadLetter, error) {
+	return sqlCommonGetDeadLetter(id, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteDeadLetter(id int64) error {
+	return sqlCommonDeleteDeadLetter(id, p.dbHandle)
+}
+
+func (p *SQLiteProvider) cleanupDeadLetters(before int64) error {
+	return sqlCommonCleanupDeadLetters(before, p.dbHandle)
+}
+
 func (p *SQLiteProvider) setFirstDownloadTimestamp(username string) error {
 	return sqlCommonSetFirstDownloadTimestamp(username, p.dbHandle)
 }
@@ -606,6 +719,10 @@ func (p *SQLiteProvider) setFirstUploadTimestamp(username string) error {
 	return sqlCommonSetFirstUploadTimestamp(username, p.dbHandle)
 }
 
+func (p *SQLiteProvider) setUserSoftDeletedAt(username string, softDeletedAt int64) error {
+	return sqlCommonSetUserSoftDeletedAt(username, softDeletedAt, p.dbHandle)
+}
+
 func (p *SQLiteProvider) close() error {
 	return p.dbHandle.Close()
 }
@@ -653,6 +770,22 @@ func (p *SQLiteProvider) migrateDatabase() error { //nolint:dupl
 		return updateSQLiteDatabaseFromV21(p.dbHandle)
 	case version == 22:
 		return updateSQLiteDatabaseFromV22(p.dbHandle)
+	case version == 23:
+		return updateSQLiteDatabaseFromV23(p.dbHandle)
+	case version == 24:
+		return updateSQLiteDatabaseFromV24(p.dbHandle)
+	case version == 25:
+		return updateSQLiteDatabaseFromV25(p.dbHandle)
+	case version == 26:
+		return updateSQLiteDatabaseFromV26(p.dbHandle)
+	case version == 27:
+		return updateSQLiteDatabaseFromV27(p.dbHandle)
+	case version == 28:
+		return updateSQLiteDatabaseFromV28(p.dbHandle)
+	case version == 29:
+		return updateSQLiteDatabaseFromV29(p.dbHandle)
+	case version == 30:
+		return updateSQLiteDatabaseFromV30(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %v is newer than the supported one: %v", version,
@@ -670,19 +803,35 @@ func (p *SQLiteProvider) revertDatabase(targetVersion int) error {
 	if err != nil {
 		return err
 	}
-	if dbVersion.Version == targetVersion {
-		return errors.New("current version match target version, nothing to do")
+	if err := validateRevertTargetVersion(dbVersion.Version, targetVersion); err != nil {
+		return err
 	}
 
 	switch dbVersion.Version {
 	case 20:
 		return downgradeSQLiteDatabaseFromV20(p.dbHandle)
 	case 21:
-		return downgradeSQLiteDatabaseFromV21(p.dbHandle)
+		return downgradeSQLiteDatabaseFromV21(p.dbHandle, targetVersion)
 	case 22:
-		return downgradeSQLiteDatabaseFromV22(p.dbHandle)
+		return downgradeSQLiteDatabaseFromV22(p.dbHandle, targetVersion)
 	case 23:
-		return downgradeSQLiteDatabaseFromV23(p.dbHandle)
+		return downgradeSQLiteDatabaseFromV23(p.dbHandle, targetVersion)
+	case 24:
+		return downgradeSQLiteDatabaseFromV24(p.dbHandle, targetVersion)
+	case 25:
+		return downgradeSQLiteDatabaseFromV25(p.dbHandle, targetVersion)
+	case 26:
+		return downgradeSQLiteDatabaseFromV26(p.dbHandle, targetVersion)
+	case 27:
+		return downgradeSQLiteDatabaseFromV27(p.dbHandle, targetVersion)
+	case 28:
+		return downgradeSQLiteDatabaseFromV28(p.dbHandle, targetVersion)
+	case 29:
+		return downgradeSQLiteDatabaseFromV29(p.dbHandle, targetVersion)
+	case 30:
+		return downgradeSQLiteDatabaseFromV30(p.dbHandle, targetVersion)
+	case 31:
+		return downgradeSQLiteDatabaseFromV31(p.dbHandle, targetVersion)
 	default:
 		return fmt.Errorf("database schema version not handled: %v", dbVersion.Version)
 	}
@@ -693,6 +842,69 @@ func (p *SQLiteProvider) resetDatabase() error {
 	return sqlCommonExecSQLAndUpdateDBVersion(p.dbHandle, []string{sql}, 0, false)
 }
 
+// backupDatabase creates a consistent copy of the database using the SQLite
+// online backup API. The database connection is held while copying, so
+// concurrent queries wait for the backup to complete
+func (p *SQLiteProvider) backupDatabase(outputFile string) error {
+	// an existing file is overwritten, the backup API requires a valid
+	// database or an empty file as destination
+	if err := os.Remove(outputFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	destHandle, err := sql.Open("sqlite3", fmt.Sprintf("file:%v", outputFile))
+	if err != nil {
+		return err
+	}
+	defer destHandle.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	destConn, err := destHandle.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(destDriverConn any) error {
+		dest, ok := destDriverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected destination connection %T", destDriverConn)
+		}
+		srcConn, err := p.dbHandle.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer srcConn.Close()
+
+		return srcConn.Raw(func(srcDriverConn any) error {
+			src, ok := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected source connection %T", srcDriverConn)
+			}
+			backup, err := dest.Backup("main", src, "main")
+			if err != nil {
+				return err
+			}
+			for {
+				done, err := backup.Step(sqliteBackupPagesPerStep)
+				if err != nil {
+					backup.Close() //nolint:errcheck
+					return err
+				}
+				if done {
+					break
+				}
+				if err := ctx.Err(); err != nil {
+					backup.Close() //nolint:errcheck
+					return err
+				}
+			}
+			return backup.Finish()
+		})
+	})
+}
+
 func updateSQLiteDatabaseFromV19(dbHandle *sql.DB) error {
 	if err := updateSQLiteDatabaseFrom19To20(dbHandle); err != nil {
 		return err
@@ -715,32 +927,177 @@ func updateSQLiteDatabaseFromV21(dbHandle *sql.DB) error {
 }
 
 func updateSQLiteDatabaseFromV22(dbHandle *sql.DB) error {
-	return updateSQLiteDatabaseFrom22To23(dbHandle)
+	if err := updateSQLiteDatabaseFrom22To23(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV23(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV23(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom23To24(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV24(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV24(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom24To25(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV25(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV25(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom25To26(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV26(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV26(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom26To27(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV27(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV27(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom27To28(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV28(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV28(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom28To29(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV29(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom29To30(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV30(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV30(dbHandle *sql.DB) error {
+	return updateSQLiteDatabaseFrom30To31(dbHandle)
 }
 
 func downgradeSQLiteDatabaseFromV20(dbHandle *sql.DB) error {
 	return downgradeSQLiteDatabaseFrom20To19(dbHandle)
 }
 
-func downgradeSQLiteDatabaseFromV21(dbHandle *sql.DB) error {
+func downgradeSQLiteDatabaseFromV21(dbHandle *sql.DB, targetVersion int) error {
 	if err := downgradeSQLiteDatabaseFrom21To20(dbHandle); err != nil {
 		return err
 	}
+	if targetVersion == 20 {
+		return nil
+	}
 	return downgradeSQLiteDatabaseFromV20(dbHandle)
 }
 
-func downgradeSQLiteDatabaseFromV22(dbHandle *sql.DB) error {
+func downgradeSQLiteDatabaseFromV22(dbHandle *sql.DB, targetVersion int) error {
 	if err := downgradeSQLiteDatabaseFrom22To21(dbHandle); err != nil {
 		return err
 	}
-	return downgradeSQLiteDatabaseFromV21(dbHandle)
+	if targetVersion == 21 {
+		return nil
+	}
+	return downgradeSQLiteDatabaseFromV21(dbHandle, targetVersion)
 }
 
-func downgradeSQLiteDatabaseFromV23(dbHandle *sql.DB) error {
+func downgradeSQLiteDatabaseFromV23(dbHandle *sql.DB, targetVersion int) error {
 	if err := downgradeSQLiteDatabaseFrom23To22(dbHandle); err != nil {
 		return err
 	}
-	return downgradeSQLiteDatabaseFromV22(dbHandle)
+	if targetVersion == 22 {
+		return nil
+	}
+	return downgradeSQLiteDatabaseFromV22(dbHandle, targetVersion)
+}
+
+func downgradeSQLiteDatabaseFromV24(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradeSQLiteDatabaseFrom24To23(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 23 {
+		return nil
+	}
+	return downgradeSQLiteDatabaseFromV23(dbHandle, targetVersion)
+}
+
+func downgradeSQLiteDatabaseFromV25(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradeSQLiteDatabaseFrom25To24(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 24 {
+		return nil
+	}
+	return downgradeSQLiteDatabaseFromV24(dbHandle, targetVersion)
+}
+
+func downgradeSQLiteDatabaseFromV26(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradeSQLiteDatabaseFrom26To25(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 25 {
+		return nil
+	}
+	return downgradeSQLiteDatabaseFromV25(dbHandle, targetVersion)
+}
+
+func downgradeSQLiteDatabaseFromV27(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradeSQLiteDatabaseFrom27To26(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 26 {
+		return nil
+	}
+	return downgradeSQLiteDatabaseFromV26(dbHandle, targetVersion)
+}
+
+func downgradeSQLiteDatabaseFromV28(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradeSQLiteDatabaseFrom28To27(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 27 {
+		return nil
+	}
+	return downgradeSQLiteDatabaseFromV27(dbHandle, targetVersion)
+}
+
+func downgradeSQLiteDatabaseFromV29(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradeSQLiteDatabaseFrom29To28(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 28 {
+		return nil
+	}
+	return downgradeSQLiteDatabaseFromV28(dbHandle, targetVersion)
+}
+
+func downgradeSQLiteDatabaseFromV30(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradeSQLiteDatabaseFrom30To29(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 29 {
+		return nil
+	}
+	return downgradeSQLiteDatabaseFromV29(dbHandle, targetVersion)
+}
+
+func downgradeSQLiteDatabaseFromV31(dbHandle *sql.DB, targetVersion int) error {
+	if err := downgradeSQLiteDatabaseFrom31To30(dbHandle); err != nil {
+		return err
+	}
+	if targetVersion == 30 {
+		return nil
+	}
+	return downgradeSQLiteDatabaseFromV30(dbHandle, targetVersion)
 }
 
 func updateSQLiteDatabaseFrom19To20(dbHandle *sql.DB) error {
@@ -779,6 +1136,68 @@ func updateSQLiteDatabaseFrom22To23(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{`SELECT 1`}, 23, true)
 }
 
+func updateSQLiteDatabaseFrom23To24(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 23 -> 24")
+	providerLog(logger.LevelInfo, "updating database schema version: 23 -> 24")
+	sql := strings.ReplaceAll(sqliteV24SQL, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{groups}}", sqlTableGroups)
+	sql = strings.ReplaceAll(sql, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 24, true)
+}
+
+func updateSQLiteDatabaseFrom24To25(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 24 -> 25")
+	providerLog(logger.LevelInfo, "updating database schema version: 24 -> 25")
+	sql := strings.ReplaceAll(sqliteV25SQL, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 25, true)
+}
+
+func updateSQLiteDatabaseFrom25To26(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 25 -> 26")
+	providerLog(logger.LevelInfo, "updating database schema version: 25 -> 26")
+	sql := strings.ReplaceAll(sqliteV26SQL, "{{audit_trail}}", sqlTableAuditTrail)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 26, true)
+}
+
+func updateSQLiteDatabaseFrom26To27(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 26 -> 27")
+	providerLog(logger.LevelInfo, "updating database schema version: 26 -> 27")
+	sql := strings.ReplaceAll(sqliteV27SQL, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 27, true)
+}
+
+func updateSQLiteDatabaseFrom27To28(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 27 -> 28")
+	providerLog(logger.LevelInfo, "updating database schema version: 27 -> 28")
+	sql := strings.ReplaceAll(sqliteV28SQL, "{{dead_letters}}", sqlTableDeadLetters)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 28, true)
+}
+
+func updateSQLiteDatabaseFrom28To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 28 -> 29")
+	providerLog(logger.LevelInfo, "updating database schema version: 28 -> 29")
+	sql := sqlReplaceAll(sqliteV29SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 29, true)
+}
+
+func updateSQLiteDatabaseFrom29To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 29 -> 30")
+	providerLog(logger.LevelInfo, "updating database schema version: 29 -> 30")
+	sql := sqlReplaceAll(sqliteV30SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, true)
+}
+
+func updateSQLiteDatabaseFrom30To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 30 -> 31")
+	providerLog(logger.LevelInfo, "updating database schema version: 30 -> 31")
+	sql := sqlReplaceAll(sqliteV31SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 31, true)
+}
+
 func downgradeSQLiteDatabaseFrom20To19(dbHandle *sql.DB) error {
 	logger.InfoToConsole("downgrading database schema version: 20 -> 19")
 	providerLog(logger.LevelInfo, "downgrading database schema version: 20 -> 19")
@@ -811,6 +1230,66 @@ func downgradeSQLiteDatabaseFrom23To22(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{`SELECT 1`}, 22, false)
 }
 
+func downgradeSQLiteDatabaseFrom24To23(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 24 -> 23")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 24 -> 23")
+	sql := strings.ReplaceAll(sqliteV24DownSQL, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{groups}}", sqlTableGroups)
+	sql = strings.ReplaceAll(sql, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 23, false)
+}
+
+func downgradeSQLiteDatabaseFrom25To24(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 25 -> 24")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 25 -> 24")
+	sql := strings.ReplaceAll(sqliteV25DownSQL, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 24, false)
+}
+
+func downgradeSQLiteDatabaseFrom26To25(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 26 -> 25")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 26 -> 25")
+	sql := strings.ReplaceAll(sqliteV26DownSQL, "{{audit_trail}}", sqlTableAuditTrail)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 25, false)
+}
+
+func downgradeSQLiteDatabaseFrom27To26(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 27 -> 26")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 27 -> 26")
+	sql := strings.ReplaceAll(sqliteV27DownSQL, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 26, false)
+}
+
+func downgradeSQLiteDatabaseFrom28To27(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 28 -> 27")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 28 -> 27")
+	sql := strings.ReplaceAll(sqliteV28DownSQL, "{{dead_letters}}", sqlTableDeadLetters)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 27, false)
+}
+
+func downgradeSQLiteDatabaseFrom29To28(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 29 -> 28")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 29 -> 28")
+	sql := sqlReplaceAll(sqliteV29DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 28, false)
+}
+
+func downgradeSQLiteDatabaseFrom30To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 30 -> 29")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 30 -> 29")
+	sql := sqlReplaceAll(sqliteV30DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 29, false)
+}
+
+func downgradeSQLiteDatabaseFrom31To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 31 -> 30")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 31 -> 30")
+	sql := sqlReplaceAll(sqliteV31DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, false)
+}
+
 /*func setPragmaFK(dbHandle *sql.DB, value string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
 	defer cancel()
@@ -0,0 +1,78 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	sqliteJournalModes      = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL"}
+	sqliteSynchronousLevels = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+)
+
+// SQLiteConfig defines the SQLite specific settings. They are ignored if a
+// custom connection string is set, in this case you can add the equivalent
+// driver parameters to the connection string
+type SQLiteConfig struct {
+	// Journal mode: "DELETE", "TRUNCATE", "PERSIST", "MEMORY" or "WAL".
+	// WAL allows readers to proceed concurrently with a writer and it is
+	// recommended to avoid "database is locked" errors under load.
+	// Empty means the SQLite default, "DELETE"
+	JournalMode string `json:"journal_mode" mapstructure:"journal_mode"`
+	// Time, as milliseconds, to wait for a lock to be released before
+	// returning a "database is locked" error. 0 means the driver default, 5000
+	BusyTimeout int `json:"busy_timeout" mapstructure:"busy_timeout"`
+	// Synchronous level: "OFF", "NORMAL", "FULL" or "EXTRA". "NORMAL" is safe
+	// in WAL mode and it is much faster than "FULL". Empty means the driver
+	// default, "NORMAL"
+	Synchronous string `json:"synchronous" mapstructure:"synchronous"`
+}
+
+func (c *SQLiteConfig) validate() error {
+	c.JournalMode = strings.ToUpper(strings.TrimSpace(c.JournalMode))
+	c.Synchronous = strings.ToUpper(strings.TrimSpace(c.Synchronous))
+	if c.JournalMode != "" && !util.Contains(sqliteJournalModes, c.JournalMode) {
+		return fmt.Errorf("invalid SQLite journal mode %q", c.JournalMode)
+	}
+	if c.BusyTimeout < 0 {
+		return fmt.Errorf("invalid SQLite busy timeout: %d", c.BusyTimeout)
+	}
+	if c.Synchronous != "" && !util.Contains(sqliteSynchronousLevels, c.Synchronous) {
+		return fmt.Errorf("invalid SQLite synchronous level %q", c.Synchronous)
+	}
+	return nil
+}
+
+// getConnectionParams returns the driver parameters to add to the
+// connection string
+func (c *SQLiteConfig) getConnectionParams() string {
+	var params strings.Builder
+	if c.JournalMode != "" {
+		params.WriteString("&_journal_mode=")
+		params.WriteString(c.JournalMode)
+	}
+	if c.BusyTimeout > 0 {
+		params.WriteString(fmt.Sprintf("&_busy_timeout=%d", c.BusyTimeout))
+	}
+	if c.Synchronous != "" {
+		params.WriteString("&_synchronous=")
+		params.WriteString(c.Synchronous)
+	}
+	return params.String()
+}
@@ -26,13 +26,15 @@ const (
 	selectUserFields = "id,username,password,public_keys,home_dir,uid,gid,max_sessions,quota_size,quota_files,permissions,used_quota_size," +
 		"used_quota_files,last_quota_update,upload_bandwidth,download_bandwidth,expiration_date,last_login,status,filters,filesystem," +
 		"additional_info,description,email,created_at,updated_at,upload_data_transfer,download_data_transfer,total_data_transfer," +
-		"used_upload_data_transfer,used_download_data_transfer,deleted_at,first_download,first_upload"
-	selectFolderFields = "id,path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem"
+		"used_upload_data_transfer,used_download_data_transfer,deleted_at,first_download,first_upload,metadata," +
+		"soft_deleted_at,last_transfer_quota_reset"
+	selectFolderFields = "id,path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem,metadata," +
+		"soft_deleted_at"
 	selectAdminFields  = "id,username,password,status,email,permissions,filters,additional_info,description,created_at,updated_at,last_login"
-	selectAPIKeyFields = "key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id"
+	selectAPIKeyFields = "key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id,scopes"
 	selectShareFields  = "s.share_id,s.name,s.description,s.scope,s.paths,u.username,s.created_at,s.updated_at,s.last_use_at," +
 		"s.expires_at,s.password,s.max_tokens,s.used_tokens,s.allow_from"
-	selectGroupFields       = "id,name,description,created_at,updated_at,user_settings"
+	selectGroupFields       = "id,name,description,created_at,updated_at,user_settings,metadata"
 	selectEventActionFields = "id,name,description,type,options"
 	selectMinimalFields     = "id,name"
 )
@@ -92,6 +94,15 @@ func getSessionQuery() string {
 		sqlPlaceholders[0])
 }
 
+func getSessionsQuery() string {
+	if config.Driver == MySQLDataProviderName {
+		return fmt.Sprintf("SELECT `key`,`data`,`type`,`timestamp` FROM %s WHERE `type` = %s AND `timestamp` >= %s "+
+			"ORDER BY `timestamp` ASC", sqlTableSharedSessions, sqlPlaceholders[0], sqlPlaceholders[1])
+	}
+	return fmt.Sprintf(`SELECT key,data,type,timestamp FROM %s WHERE type = %s AND timestamp >= %s ORDER BY timestamp ASC`,
+		sqlTableSharedSessions, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
 func getCleanupSessionsQuery() string {
 	return fmt.Sprintf(`DELETE from %s WHERE type = %s AND timestamp < %s`,
 		sqlTableSharedSessions, sqlPlaceholders[0], sqlPlaceholders[1])
@@ -228,15 +239,15 @@ func getDumpGroupsQuery() string {
 }
 
 func getAddGroupQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (name,description,created_at,updated_at,user_settings)
-		VALUES (%s,%s,%s,%s,%s)`, getSQLQuotedName(sqlTableGroups), sqlPlaceholders[0], sqlPlaceholders[1],
-		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4])
+	return fmt.Sprintf(`INSERT INTO %s (name,description,created_at,updated_at,user_settings,metadata)
+		VALUES (%s,%s,%s,%s,%s,%s)`, getSQLQuotedName(sqlTableGroups), sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5])
 }
 
 func getUpdateGroupQuery() string {
-	return fmt.Sprintf(`UPDATE %s SET description=%s,user_settings=%s,updated_at=%s
+	return fmt.Sprintf(`UPDATE %s SET description=%s,user_settings=%s,metadata=%s,updated_at=%s
 		WHERE name = %s`, getSQLQuotedName(sqlTableGroups), sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
-		sqlPlaceholders[3])
+		sqlPlaceholders[3], sqlPlaceholders[4])
 }
 
 func getDeleteGroupQuery() string {
@@ -335,16 +346,17 @@ func getDumpAPIKeysQuery() string {
 }
 
 func getAddAPIKeyQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id)
-		VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableAPIKeys, sqlPlaceholders[0], sqlPlaceholders[1],
+	return fmt.Sprintf(`INSERT INTO %s (key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id,
+		scopes) VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableAPIKeys, sqlPlaceholders[0], sqlPlaceholders[1],
 		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6],
-		sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10])
+		sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11])
 }
 
 func getUpdateAPIKeyQuery() string {
-	return fmt.Sprintf(`UPDATE %s SET name=%s,scope=%s,expires_at=%s,user_id=%s,admin_id=%s,description=%s,updated_at=%s
-		WHERE key_id = %s`, sqlTableAPIKeys, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
-		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7])
+	return fmt.Sprintf(`UPDATE %s SET name=%s,scope=%s,expires_at=%s,user_id=%s,admin_id=%s,description=%s,scopes=%s,
+		updated_at=%s WHERE key_id = %s`, sqlTableAPIKeys, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
+		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7],
+		sqlPlaceholders[8])
 }
 
 func getDeleteAPIKeyQuery() string {
@@ -398,9 +410,13 @@ func getUserByUsernameQuery() string {
 		selectUserFields, sqlTableUsers, sqlPlaceholders[0])
 }
 
-func getUsersQuery(order string) string {
-	return fmt.Sprintf(`SELECT %s FROM %s WHERE deleted_at = 0 ORDER BY username %s LIMIT %s OFFSET %s`,
-		selectUserFields, sqlTableUsers, order, sqlPlaceholders[0], sqlPlaceholders[1])
+func getUsersQuery(order string, softDeleted bool) string {
+	softDeleteFilter := "soft_deleted_at = 0"
+	if softDeleted {
+		softDeleteFilter = "soft_deleted_at > 0"
+	}
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE deleted_at = 0 AND %s ORDER BY username %s LIMIT %s OFFSET %s`,
+		selectUserFields, sqlTableUsers, softDeleteFilter, order, sqlPlaceholders[0], sqlPlaceholders[1])
 }
 
 func getUsersForQuotaCheckQuery(numArgs int) string {
@@ -427,11 +443,11 @@ func getRecentlyUpdatedUsersQuery() string {
 }
 
 func getDumpUsersQuery() string {
-	return fmt.Sprintf(`SELECT %s FROM %s WHERE deleted_at = 0`, selectUserFields, sqlTableUsers)
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE deleted_at = 0 AND soft_deleted_at = 0`, selectUserFields, sqlTableUsers)
 }
 
 func getDumpFoldersQuery() string {
-	return fmt.Sprintf(`SELECT %s FROM %s`, selectFolderFields, sqlTableFolders)
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE soft_deleted_at = 0`, selectFolderFields, sqlTableFolders)
 }
 
 func getUpdateTransferQuotaQuery(reset bool) string {
@@ -444,6 +460,12 @@ func getUpdateTransferQuotaQuery(reset bool) string {
 		WHERE username = %s`, sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
 }
 
+func getResetTransferQuotaQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET used_upload_data_transfer = 0,used_download_data_transfer = 0,last_quota_update = %s,
+		last_transfer_quota_reset = %s WHERE username = %s AND last_transfer_quota_reset < %s`, sqlTableUsers,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
 func getUpdateQuotaQuery(reset bool) string {
 	if reset {
 		return fmt.Sprintf(`UPDATE %s SET used_quota_size = %s,used_quota_files = %s,last_quota_update = %s
@@ -494,31 +516,46 @@ func getAddUserQuery() string {
 	return fmt.Sprintf(`INSERT INTO %s (username,password,public_keys,home_dir,uid,gid,max_sessions,quota_size,quota_files,permissions,
 		used_quota_size,used_quota_files,last_quota_update,upload_bandwidth,download_bandwidth,status,last_login,expiration_date,filters,
 		filesystem,additional_info,description,email,created_at,updated_at,upload_data_transfer,download_data_transfer,total_data_transfer,
-		used_upload_data_transfer,used_download_data_transfer,deleted_at,first_download,first_upload)
-		VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,0,0,0,%s,%s,%s,0,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,0,0,0,0,0)`,
+		used_upload_data_transfer,used_download_data_transfer,deleted_at,first_download,first_upload,metadata,soft_deleted_at,
+		last_transfer_quota_reset)
+		VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,0,0,0,%s,%s,%s,0,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,0,0,0,0,0,%s,0,0)`,
 		sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4],
 		sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9],
 		sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12], sqlPlaceholders[13], sqlPlaceholders[14],
 		sqlPlaceholders[15], sqlPlaceholders[16], sqlPlaceholders[17], sqlPlaceholders[18], sqlPlaceholders[19],
-		sqlPlaceholders[20], sqlPlaceholders[21], sqlPlaceholders[22], sqlPlaceholders[23])
+		sqlPlaceholders[20], sqlPlaceholders[21], sqlPlaceholders[22], sqlPlaceholders[23], sqlPlaceholders[24])
 }
 
 func getUpdateUserQuery() string {
 	return fmt.Sprintf(`UPDATE %s SET password=%s,public_keys=%s,home_dir=%s,uid=%s,gid=%s,max_sessions=%s,quota_size=%s,
 		quota_files=%s,permissions=%s,upload_bandwidth=%s,download_bandwidth=%s,status=%s,expiration_date=%s,filters=%s,filesystem=%s,
 		additional_info=%s,description=%s,email=%s,updated_at=%s,upload_data_transfer=%s,download_data_transfer=%s,
-		total_data_transfer=%s WHERE id = %s`,
+		total_data_transfer=%s,metadata=%s WHERE id = %s`,
 		sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4],
 		sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9],
 		sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12], sqlPlaceholders[13], sqlPlaceholders[14],
 		sqlPlaceholders[15], sqlPlaceholders[16], sqlPlaceholders[17], sqlPlaceholders[18], sqlPlaceholders[19],
-		sqlPlaceholders[20], sqlPlaceholders[21], sqlPlaceholders[22])
+		sqlPlaceholders[20], sqlPlaceholders[21], sqlPlaceholders[22], sqlPlaceholders[23])
+}
+
+func getSetUserSoftDeletedAtQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET soft_deleted_at = %s,updated_at = %s WHERE username = %s`, sqlTableUsers,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
 }
 
 func getUpdateUserPasswordQuery() string {
 	return fmt.Sprintf(`UPDATE %s SET password=%s WHERE username = %s`, sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1])
 }
 
+func getUserFiltersQuery() string {
+	return fmt.Sprintf(`SELECT filters FROM %s WHERE username = %s`, sqlTableUsers, sqlPlaceholders[0])
+}
+
+func getUpdateUserPasswordAndFiltersQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET password=%s,filters=%s WHERE username = %s`, sqlTableUsers, sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
 func getDeleteUserQuery(softDelete bool) string {
 	if softDelete {
 		return fmt.Sprintf(`UPDATE %s SET updated_at=%s,deleted_at=%s WHERE username = %s`,
@@ -532,14 +569,19 @@ func getFolderByNameQuery() string {
 }
 
 func getAddFolderQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem)
-		VALUES (%s,%s,%s,%s,%s,%s,%s)`, sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
-		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6])
+	return fmt.Sprintf(`INSERT INTO %s (path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem,
+		metadata) VALUES (%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
+		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7])
 }
 
 func getUpdateFolderQuery() string {
-	return fmt.Sprintf(`UPDATE %s SET path=%s,description=%s,filesystem=%s WHERE name = %s`, sqlTableFolders, sqlPlaceholders[0],
-		sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+	return fmt.Sprintf(`UPDATE %s SET path=%s,description=%s,filesystem=%s,metadata=%s WHERE name = %s`, sqlTableFolders,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4])
+}
+
+func getSetFolderSoftDeletedAtQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET soft_deleted_at = %s WHERE name = %s`, sqlTableFolders, sqlPlaceholders[0],
+		sqlPlaceholders[1])
 }
 
 func getDeleteFolderQuery() string {
@@ -549,15 +591,16 @@ func getDeleteFolderQuery() string {
 func getUpsertFolderQuery() string {
 	if config.Driver == MySQLDataProviderName {
 		return fmt.Sprintf("INSERT INTO %s (`path`,`used_quota_size`,`used_quota_files`,`last_quota_update`,`name`,"+
-			"`description`,`filesystem`) VALUES (%s,%s,%s,%s,%s,%s,%s) ON DUPLICATE KEY UPDATE "+
+			"`description`,`filesystem`,`metadata`) VALUES (%s,%s,%s,%s,%s,%s,%s,%s) ON DUPLICATE KEY UPDATE "+
 			"`path`=VALUES(`path`),`description`=VALUES(`description`),`filesystem`=VALUES(`filesystem`)",
 			sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4],
-			sqlPlaceholders[5], sqlPlaceholders[6])
+			sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7])
 	}
-	return fmt.Sprintf(`INSERT INTO %s (path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem)
-		VALUES (%s,%s,%s,%s,%s,%s,%s) ON CONFLICT (name) DO UPDATE SET path = EXCLUDED.path,description=EXCLUDED.description,
-		filesystem=EXCLUDED.filesystem`, sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
-		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6])
+	return fmt.Sprintf(`INSERT INTO %s (path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem,
+		metadata) VALUES (%s,%s,%s,%s,%s,%s,%s,%s) ON CONFLICT (name) DO UPDATE SET path = EXCLUDED.path,
+		description=EXCLUDED.description,filesystem=EXCLUDED.filesystem`, sqlTableFolders,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5],
+		sqlPlaceholders[6], sqlPlaceholders[7])
 }
 
 func getClearUserGroupMappingQuery() string {
@@ -590,10 +633,10 @@ func getClearGroupFolderMappingQuery() string {
 }
 
 func getAddGroupFolderMappingQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (virtual_path,quota_size,quota_files,folder_id,group_id)
-		VALUES (%s,%s,%s,(SELECT id FROM %s WHERE name = %s),(SELECT id FROM %s WHERE name = %s))`,
-		sqlTableGroupsFoldersMapping, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlTableFolders,
-		sqlPlaceholders[3], getSQLQuotedName(sqlTableGroups), sqlPlaceholders[4])
+	return fmt.Sprintf(`INSERT INTO %s (virtual_path,quota_size,quota_files,user_quota_size,user_quota_files,folder_id,group_id)
+		VALUES (%s,%s,%s,%s,%s,(SELECT id FROM %s WHERE name = %s),(SELECT id FROM %s WHERE name = %s))`,
+		sqlTableGroupsFoldersMapping, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlPlaceholders[4], sqlTableFolders, sqlPlaceholders[5], getSQLQuotedName(sqlTableGroups), sqlPlaceholders[6])
 }
 
 func getClearUserFolderMappingQuery() string {
@@ -602,21 +645,25 @@ func getClearUserFolderMappingQuery() string {
 }
 
 func getAddUserFolderMappingQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (virtual_path,quota_size,quota_files,folder_id,user_id)
-		VALUES (%s,%s,%s,(SELECT id FROM %s WHERE name = %s),(SELECT id FROM %s WHERE username = %s))`,
-		sqlTableUsersFoldersMapping, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlTableFolders,
-		sqlPlaceholders[3], sqlTableUsers, sqlPlaceholders[4])
+	return fmt.Sprintf(`INSERT INTO %s (virtual_path,quota_size,quota_files,user_quota_size,user_quota_files,folder_id,user_id)
+		VALUES (%s,%s,%s,%s,%s,(SELECT id FROM %s WHERE name = %s),(SELECT id FROM %s WHERE username = %s))`,
+		sqlTableUsersFoldersMapping, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlPlaceholders[4], sqlTableFolders, sqlPlaceholders[5], sqlTableUsers, sqlPlaceholders[6])
 }
 
-func getFoldersQuery(order string, minimal bool) string {
+func getFoldersQuery(order string, minimal, softDeleted bool) string {
 	var fieldSelection string
 	if minimal {
 		fieldSelection = selectMinimalFields
 	} else {
 		fieldSelection = selectFolderFields
 	}
-	return fmt.Sprintf(`SELECT %s FROM %s ORDER BY name %s LIMIT %s OFFSET %s`, fieldSelection, sqlTableFolders,
-		order, sqlPlaceholders[0], sqlPlaceholders[1])
+	softDeleteFilter := "soft_deleted_at = 0"
+	if softDeleted {
+		softDeleteFilter = "soft_deleted_at > 0"
+	}
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE %s ORDER BY name %s LIMIT %s OFFSET %s`, fieldSelection, sqlTableFolders,
+		softDeleteFilter, order, sqlPlaceholders[0], sqlPlaceholders[1])
 }
 
 func getUpdateFolderQuotaQuery(reset bool) string {
@@ -633,6 +680,32 @@ func getQuotaFolderQuery() string {
 		sqlPlaceholders[0])
 }
 
+func getUpdateUserFolderQuotaQuery(reset bool) string {
+	if reset {
+		return fmt.Sprintf(`UPDATE %s SET used_quota_size = %s,used_quota_files = %s,last_quota_update = %s
+			WHERE user_id = (SELECT id FROM %s WHERE username = %s) AND folder_id = (SELECT id FROM %s WHERE name = %s)`,
+			sqlTableUsersFoldersQuota, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlTableUsers,
+			sqlPlaceholders[3], sqlTableFolders, sqlPlaceholders[4])
+	}
+	return fmt.Sprintf(`UPDATE %s SET used_quota_size = used_quota_size + %s,used_quota_files = used_quota_files + %s,
+		last_quota_update = %s WHERE user_id = (SELECT id FROM %s WHERE username = %s) AND
+		folder_id = (SELECT id FROM %s WHERE name = %s)`, sqlTableUsersFoldersQuota, sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2], sqlTableUsers, sqlPlaceholders[3], sqlTableFolders, sqlPlaceholders[4])
+}
+
+func getAddUserFolderQuotaQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (used_quota_size,used_quota_files,last_quota_update,user_id,folder_id)
+		VALUES (%s,%s,%s,(SELECT id FROM %s WHERE username = %s),(SELECT id FROM %s WHERE name = %s))`,
+		sqlTableUsersFoldersQuota, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlTableUsers,
+		sqlPlaceholders[3], sqlTableFolders, sqlPlaceholders[4])
+}
+
+func getUserFoldersQuotaQuery() string {
+	return fmt.Sprintf(`SELECT f.name,q.used_quota_size,q.used_quota_files,q.last_quota_update FROM %s q
+		INNER JOIN %s f ON q.folder_id = f.id INNER JOIN %s u ON q.user_id = u.id WHERE u.username = %s
+		ORDER BY f.name ASC`, sqlTableUsersFoldersQuota, sqlTableFolders, sqlTableUsers, sqlPlaceholders[0])
+}
+
 func getRelatedGroupsForUsersQuery(users []User) string {
 	var sb strings.Builder
 	for _, u := range users {
@@ -681,8 +754,8 @@ func getRelatedFoldersForUsersQuery(users []User) string {
 		sb.WriteString(")")
 	}
 	return fmt.Sprintf(`SELECT f.id,f.name,f.path,f.used_quota_size,f.used_quota_files,f.last_quota_update,fm.virtual_path,
-		fm.quota_size,fm.quota_files,fm.user_id,f.filesystem,f.description FROM %s f INNER JOIN %s fm ON f.id = fm.folder_id WHERE
-		fm.user_id IN %s ORDER BY fm.user_id`, sqlTableFolders, sqlTableUsersFoldersMapping, sb.String())
+		fm.quota_size,fm.quota_files,fm.user_quota_size,fm.user_quota_files,fm.user_id,f.filesystem,f.description,f.metadata FROM %s f INNER JOIN %s fm ON f.id = fm.folder_id WHERE
+		fm.user_id IN %s AND f.soft_deleted_at = 0 ORDER BY fm.user_id`, sqlTableFolders, sqlTableUsersFoldersMapping, sb.String())
 }
 
 func getRelatedUsersForFoldersQuery(folders []vfs.BaseVirtualFolder) string {
@@ -768,8 +841,8 @@ func getRelatedFoldersForGroupsQuery(groups []Group) string {
 		sb.WriteString(")")
 	}
 	return fmt.Sprintf(`SELECT f.id,f.name,f.path,f.used_quota_size,f.used_quota_files,f.last_quota_update,fm.virtual_path,
-		fm.quota_size,fm.quota_files,fm.group_id,f.filesystem,f.description FROM %s f INNER JOIN %s fm ON f.id = fm.folder_id WHERE
-		fm.group_id IN %s ORDER BY fm.group_id`, sqlTableFolders, sqlTableGroupsFoldersMapping, sb.String())
+		fm.quota_size,fm.quota_files,fm.user_quota_size,fm.user_quota_files,fm.group_id,f.filesystem,f.description,f.metadata FROM %s f INNER JOIN %s fm ON f.id = fm.folder_id WHERE
+		fm.group_id IN %s AND f.soft_deleted_at = 0 ORDER BY fm.group_id`, sqlTableFolders, sqlTableGroupsFoldersMapping, sb.String())
 }
 
 func getActiveTransfersQuery() string {
@@ -980,6 +1053,109 @@ func getCleanupNodesQuery() string {
 	return fmt.Sprintf(`DELETE FROM %s WHERE updated_at < %s`, sqlTableNodes, sqlPlaceholders[0])
 }
 
+func getAddAuditEntryQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (%s,action,object_type,object_name,executor,ip,changes) VALUES (%s,%s,%s,%s,%s,%s,%s)`,
+		sqlTableAuditTrail, getSQLQuotedName("timestamp"), sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
+		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6])
+}
+
+func getAuditEntriesQuery(filters AuditFilters, order string) (string, []any) {
+	var conditions []string
+	var args []any
+
+	addCondition := func(field, operator string, value any) {
+		conditions = append(conditions, fmt.Sprintf("%s %s %s", field, operator, sqlPlaceholders[len(args)]))
+		args = append(args, value)
+	}
+	if filters.ObjectType != "" {
+		addCondition("object_type", "=", filters.ObjectType)
+	}
+	if filters.ObjectName != "" {
+		addCondition("object_name", "=", filters.ObjectName)
+	}
+	if filters.Executor != "" {
+		addCondition("executor", "=", filters.Executor)
+	}
+	if filters.Action != "" {
+		addCondition("action", "=", filters.Action)
+	}
+	if filters.StartTimestamp > 0 {
+		addCondition(getSQLQuotedName("timestamp"), ">=", filters.StartTimestamp)
+	}
+	if filters.EndTimestamp > 0 {
+		addCondition(getSQLQuotedName("timestamp"), "<=", filters.EndTimestamp)
+	}
+	var where string
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	q := fmt.Sprintf(`SELECT id,%s,action,object_type,object_name,executor,ip,changes FROM %s %s ORDER BY id %s LIMIT %s OFFSET %s`,
+		getSQLQuotedName("timestamp"), sqlTableAuditTrail, where, order, sqlPlaceholders[len(args)],
+		sqlPlaceholders[len(args)+1])
+	return q, args
+}
+
+func getCleanupAuditEntriesQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE %s < %s`, sqlTableAuditTrail, getSQLQuotedName("timestamp"),
+		sqlPlaceholders[0])
+}
+
+const selectDeadLetterFields = "id,%s,source,name,method,url,username,headers,body,last_error,attempts"
+
+func getDeadLetterSelectFields() string {
+	return fmt.Sprintf(selectDeadLetterFields, getSQLQuotedName("timestamp"))
+}
+
+func getAddDeadLetterQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (%s,source,name,method,url,username,headers,body,last_error,attempts)
+		VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableDeadLetters, getSQLQuotedName("timestamp"), sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5],
+		sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9])
+}
+
+func getDeadLettersQuery(filters DeadLetterFilters, order string) (string, []any) {
+	var conditions []string
+	var args []any
+
+	addCondition := func(field, operator string, value any) {
+		conditions = append(conditions, fmt.Sprintf("%s %s %s", field, operator, sqlPlaceholders[len(args)]))
+		args = append(args, value)
+	}
+	if filters.Source != "" {
+		addCondition("source", "=", filters.Source)
+	}
+	if filters.Name != "" {
+		addCondition("name", "=", filters.Name)
+	}
+	if filters.StartTimestamp > 0 {
+		addCondition(getSQLQuotedName("timestamp"), ">=", filters.StartTimestamp)
+	}
+	if filters.EndTimestamp > 0 {
+		addCondition(getSQLQuotedName("timestamp"), "<=", filters.EndTimestamp)
+	}
+	var where string
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	q := fmt.Sprintf(`SELECT %s FROM %s %s ORDER BY id %s LIMIT %s OFFSET %s`, getDeadLetterSelectFields(),
+		sqlTableDeadLetters, where, order, sqlPlaceholders[len(args)], sqlPlaceholders[len(args)+1])
+	return q, args
+}
+
+func getDeadLetterByIDQuery() string {
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE id = %s`, getDeadLetterSelectFields(), sqlTableDeadLetters,
+		sqlPlaceholders[0])
+}
+
+func getDeleteDeadLetterQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE id = %s`, sqlTableDeadLetters, sqlPlaceholders[0])
+}
+
+func getCleanupDeadLettersQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE %s < %s`, sqlTableDeadLetters, getSQLQuotedName("timestamp"),
+		sqlPlaceholders[0])
+}
+
 func getDatabaseVersionQuery() string {
 	return fmt.Sprintf("SELECT version from %s LIMIT 1", sqlTableSchemaVersion)
 }
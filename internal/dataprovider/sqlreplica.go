@@ -0,0 +1,220 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// ReadReplicasConfig defines the read-only replicas for the MySQL, PostgreSQL
+// and CockroachDB data providers. Read-only queries, such as the user lookups
+// on login and the listings, are executed on the replicas while writes, and any
+// other query, are always executed on the primary database
+type ReadReplicasConfig struct {
+	// Connection strings for the read-only replicas, in the same format of the
+	// data provider connection_string. Empty means read replicas are disabled
+	ConnectionStrings []string `json:"connection_strings" mapstructure:"connection_strings"`
+	// Maximum number of open connections for each replica. 0 means unlimited
+	PoolSize int `json:"pool_size" mapstructure:"pool_size"`
+	// Maximum expected replication lag, in seconds. After a write executed by
+	// this instance, the affected objects are read from the primary database
+	// for this interval to avoid stale reads
+	MaxLag int `json:"max_lag" mapstructure:"max_lag"`
+}
+
+// IsEnabled returns true if at least a read replica is configured
+func (c *ReadReplicasConfig) IsEnabled() bool {
+	return len(c.ConnectionStrings) > 0
+}
+
+func (c *ReadReplicasConfig) validate() error {
+	if !c.IsEnabled() {
+		return nil
+	}
+	if !util.Contains([]string{MySQLDataProviderName, PGSQLDataProviderName, CockroachDataProviderName}, config.Driver) {
+		return fmt.Errorf("read replicas are not supported for the %q data provider", config.Driver)
+	}
+	for _, connString := range c.ConnectionStrings {
+		if connString == "" {
+			return errors.New("invalid read replica: empty connection string")
+		}
+	}
+	if c.PoolSize < 0 {
+		return fmt.Errorf("invalid read replicas pool size: %d", c.PoolSize)
+	}
+	if c.MaxLag < 0 {
+		return fmt.Errorf("invalid read replicas max lag: %d", c.MaxLag)
+	}
+	return nil
+}
+
+type sqlReadReplica struct {
+	dbHandle    *sql.DB
+	isAvailable atomic.Bool
+}
+
+// sqlReadReplicas routes the read-only queries to the configured replicas.
+// A nil value is valid and means that all the queries are executed on the
+// primary database
+type sqlReadReplicas struct {
+	replicas  []*sqlReadReplica
+	next      atomic.Uint64
+	maxLag    time.Duration
+	lastWrite atomic.Int64
+	// last write timestamp for user specific writes
+	userWrites sync.Map
+}
+
+func newSQLReadReplicas(driverName string) (*sqlReadReplicas, error) {
+	if !config.ReadReplicas.IsEnabled() {
+		return nil, nil
+	}
+	r := &sqlReadReplicas{
+		maxLag: time.Duration(config.ReadReplicas.MaxLag) * time.Second,
+	}
+	for idx, connString := range config.ReadReplicas.ConnectionStrings {
+		dbHandle, err := sql.Open(driverName, connString)
+		if err != nil {
+			providerLog(logger.LevelError, "error creating read replica %d database handle: %v", idx, err)
+			r.close()
+			return nil, fmt.Errorf("unable to create read replica %d: %w", idx, err)
+		}
+		dbHandle.SetMaxOpenConns(config.ReadReplicas.PoolSize)
+		if config.ReadReplicas.PoolSize > 0 {
+			dbHandle.SetMaxIdleConns(config.ReadReplicas.PoolSize)
+		} else {
+			dbHandle.SetMaxIdleConns(2)
+		}
+		dbHandle.SetConnMaxLifetime(240 * time.Second)
+		dbHandle.SetConnMaxIdleTime(120 * time.Second)
+		replica := &sqlReadReplica{dbHandle: dbHandle}
+		replica.isAvailable.Store(true)
+		r.replicas = append(r.replicas, replica)
+	}
+	providerLog(logger.LevelDebug, "%d read replicas configured, pool size: %d, max lag: %s",
+		len(r.replicas), config.ReadReplicas.PoolSize, r.maxLag)
+	return r, nil
+}
+
+// trackWrite records a write executed on the primary database. An empty
+// username means a write that could affect any object
+func (r *sqlReadReplicas) trackWrite(username string) {
+	if r == nil {
+		return
+	}
+	now := time.Now().UnixNano()
+	if username == "" {
+		r.lastWrite.Store(now)
+		return
+	}
+	r.userWrites.Store(username, now)
+}
+
+func (r *sqlReadReplicas) isRecentWrite(ts int64) bool {
+	return time.Since(time.Unix(0, ts)) < r.maxLag
+}
+
+func (r *sqlReadReplicas) isStale(username string) bool {
+	if r.isRecentWrite(r.lastWrite.Load()) {
+		return true
+	}
+	if username != "" {
+		if val, ok := r.userWrites.Load(username); ok {
+			return r.isRecentWrite(val.(int64))
+		}
+	}
+	return false
+}
+
+// getReplica returns the replica to use for a read query affecting the
+// specified username, if any, or nil if the query must be executed on the
+// primary database
+func (r *sqlReadReplicas) getReplica(username string) *sqlReadReplica {
+	if r == nil || r.isStale(username) {
+		return nil
+	}
+	numReplicas := uint64(len(r.replicas))
+	start := r.next.Add(1)
+	for idx := uint64(0); idx < numReplicas; idx++ {
+		replica := r.replicas[(start+idx)%numReplicas]
+		if replica.isAvailable.Load() {
+			return replica
+		}
+	}
+	return nil
+}
+
+// checkAvailability checks the replicas, a replica marked as unavailable
+// is used again as soon as it is reachable. Expired write timestamps are
+// removed too
+func (r *sqlReadReplicas) checkAvailability() {
+	if r == nil {
+		return
+	}
+	for idx, replica := range r.replicas {
+		err := sqlCommonCheckAvailability(replica.dbHandle)
+		if err != nil {
+			providerLog(logger.LevelError, "read replica %d is not available: %v", idx, err)
+		} else if !replica.isAvailable.Load() {
+			providerLog(logger.LevelInfo, "read replica %d is available again", idx)
+		}
+		replica.isAvailable.Store(err == nil)
+	}
+	r.userWrites.Range(func(key, value any) bool {
+		if !r.isRecentWrite(value.(int64)) {
+			r.userWrites.Delete(key)
+		}
+		return true
+	})
+}
+
+func (r *sqlReadReplicas) close() {
+	if r == nil {
+		return
+	}
+	for _, replica := range r.replicas {
+		replica.dbHandle.Close()
+	}
+}
+
+// sqlReplicaRead executes the specified read query on a replica, if available.
+// The query is executed again on the primary database if the replica returns
+// an error. A not found error could be caused by the replication lag so the
+// object is searched on the primary database too, other errors mark the
+// replica as unavailable until the next availability check
+func sqlReplicaRead[T any](r *sqlReadReplicas, primary *sql.DB, username string, fn func(dbHandle sqlQuerier) (T, error)) (T, error) {
+	replica := r.getReplica(username)
+	if replica == nil {
+		return fn(primary)
+	}
+	result, err := fn(replica.dbHandle)
+	if err == nil {
+		return result, nil
+	}
+	var nfErr *util.RecordNotFoundError
+	if !errors.As(err, &nfErr) {
+		providerLog(logger.LevelWarn, "read replica query failed, the replica will not be used until the next availability check: %v", err)
+		replica.isAvailable.Store(false)
+	}
+	return fn(primary)
+}
@@ -0,0 +1,405 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nosqlite && !nomysql
+// +build !nosqlite,!nomysql
+
+package dataprovider
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	replicaTestDriverName = "sqlite3_replica_test"
+)
+
+var replicaTestDriver = &queryRecorderDriver{
+	queries: make(map[string][]string),
+}
+
+func init() {
+	sql.Register(replicaTestDriverName, replicaTestDriver)
+}
+
+// queryRecorderDriver wraps the SQLite driver and records the queries
+// executed on each database
+type queryRecorderDriver struct {
+	mu      sync.Mutex
+	queries map[string][]string
+}
+
+func (d *queryRecorderDriver) Open(name string) (driver.Conn, error) {
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &queryRecorderConn{Conn: conn, dsn: name, driver: d}, nil
+}
+
+func (d *queryRecorderDriver) record(dsn, query string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.queries[dsn] = append(d.queries[dsn], query)
+}
+
+func (d *queryRecorderDriver) getQueries(dsn string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.queries[dsn]...)
+}
+
+func (d *queryRecorderDriver) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.queries = make(map[string][]string)
+}
+
+type queryRecorderConn struct {
+	driver.Conn
+	dsn    string
+	driver *queryRecorderDriver
+}
+
+func (c *queryRecorderConn) Prepare(query string) (driver.Stmt, error) {
+	c.driver.record(c.dsn, query)
+	return c.Conn.Prepare(query)
+}
+
+func (c *queryRecorderConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.driver.record(c.dsn, query)
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (c *queryRecorderConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.driver.record(c.dsn, query)
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+func getReplicaTestDSN(dbPath string) string {
+	return fmt.Sprintf("file:%s?cache=shared&_foreign_keys=1", dbPath)
+}
+
+func copyReplicaTestDatabase(t *testing.T, src, dst string) {
+	srcFile, err := os.Open(src)
+	require.NoError(t, err)
+	defer srcFile.Close()
+
+	dstFile, err := os.Create(dst)
+	require.NoError(t, err)
+	defer dstFile.Close()
+
+	_, err = io.Copy(dstFile, srcFile)
+	require.NoError(t, err)
+}
+
+// initializeReplicaTestProvider initializes a SQLite database and uses it as
+// primary, the replicas are copies of the initialized database. A MySQL provider
+// is used to execute the queries, the syntax for the SQL queries used within the
+// tests is the same for SQLite and MySQL
+func initializeReplicaTestProvider(t *testing.T, numReplicas int) (*MySQLProvider, string, []string) {
+	dataDir := t.TempDir()
+	primaryPath := filepath.Join(dataDir, "primary.db")
+	err := InitializeDatabase(Config{
+		Driver: SQLiteDataProviderName,
+		Name:   primaryPath,
+		PasswordHashing: PasswordHashing{
+			Algo: HashingAlgoBcrypt,
+			BcryptOptions: BcryptOptions{
+				Cost: 4,
+			},
+		},
+	}, dataDir)
+	require.NoError(t, err)
+	err = provider.close()
+	require.NoError(t, err)
+
+	var replicaDSNs []string
+	for idx := 0; idx < numReplicas; idx++ {
+		replicaPath := filepath.Join(dataDir, fmt.Sprintf("replica%d.db", idx))
+		copyReplicaTestDatabase(t, primaryPath, replicaPath)
+		replicaDSNs = append(replicaDSNs, getReplicaTestDSN(replicaPath))
+	}
+	config.ReadReplicas = ReadReplicasConfig{
+		ConnectionStrings: replicaDSNs,
+		MaxLag:            60,
+	}
+	primaryDSN := getReplicaTestDSN(primaryPath)
+	dbHandle, err := sql.Open(replicaTestDriverName, primaryDSN)
+	require.NoError(t, err)
+	replicas, err := newSQLReadReplicas(replicaTestDriverName)
+	require.NoError(t, err)
+	p := &MySQLProvider{dbHandle: dbHandle, replicas: replicas}
+	replicaTestDriver.reset()
+
+	t.Cleanup(func() {
+		p.close() //nolint:errcheck
+		config.ReadReplicas = ReadReplicasConfig{}
+	})
+	return p, primaryDSN, replicaDSNs
+}
+
+func getReplicaTestUser(username string) User {
+	return User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			Password: "password",
+			HomeDir:  filepath.Join(os.TempDir(), username),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {PermAny},
+			},
+		},
+	}
+}
+
+func TestReadReplicasConfig(t *testing.T) {
+	driver := config.Driver
+	defer func() {
+		config.Driver = driver
+	}()
+
+	c := ReadReplicasConfig{}
+	assert.False(t, c.IsEnabled())
+	assert.NoError(t, c.validate())
+
+	c.ConnectionStrings = []string{"replica"}
+	assert.True(t, c.IsEnabled())
+	config.Driver = SQLiteDataProviderName
+	err := c.validate()
+	assert.ErrorContains(t, err, "read replicas are not supported")
+	config.Driver = BoltDataProviderName
+	err = c.validate()
+	assert.ErrorContains(t, err, "read replicas are not supported")
+	for _, name := range []string{MySQLDataProviderName, PGSQLDataProviderName, CockroachDataProviderName} {
+		config.Driver = name
+		assert.NoError(t, c.validate())
+	}
+	c.ConnectionStrings = []string{"replica", ""}
+	err = c.validate()
+	assert.ErrorContains(t, err, "empty connection string")
+	c.ConnectionStrings = []string{"replica"}
+	c.PoolSize = -1
+	err = c.validate()
+	assert.ErrorContains(t, err, "invalid read replicas pool size")
+	c.PoolSize = 10
+	c.MaxLag = -1
+	err = c.validate()
+	assert.ErrorContains(t, err, "invalid read replicas max lag")
+}
+
+func TestReadReplicaSelection(t *testing.T) {
+	var r *sqlReadReplicas
+	assert.Nil(t, r.getReplica(""))
+	r.trackWrite("user")
+	r.checkAvailability()
+	r.close()
+
+	p, _, _ := initializeReplicaTestProvider(t, 2)
+	r = p.replicas
+	require.Len(t, r.replicas, 2)
+	// the replicas are used in round robin
+	first := r.getReplica("")
+	second := r.getReplica("")
+	require.NotNil(t, first)
+	require.NotNil(t, second)
+	assert.NotSame(t, first, second)
+	assert.Same(t, first, r.getReplica(""))
+	assert.Same(t, second, r.getReplica(""))
+	// unavailable replicas are skipped
+	first.isAvailable.Store(false)
+	for i := 0; i < 4; i++ {
+		assert.Same(t, second, r.getReplica("user"))
+	}
+	second.isAvailable.Store(false)
+	assert.Nil(t, r.getReplica(""))
+	// the availability check restores the reachable replicas
+	r.checkAvailability()
+	assert.True(t, first.isAvailable.Load())
+	assert.True(t, second.isAvailable.Load())
+	// user specific writes only affect the queries for that user
+	r.trackWrite("user")
+	assert.Nil(t, r.getReplica("user"))
+	assert.NotNil(t, r.getReplica("other"))
+	assert.NotNil(t, r.getReplica(""))
+	// writes that could affect any object affect all the queries
+	r.trackWrite("")
+	assert.Nil(t, r.getReplica(""))
+	assert.Nil(t, r.getReplica("other"))
+	// after the max lag the replicas are used again and the expired
+	// write timestamps are removed
+	r.lastWrite.Store(0)
+	r.userWrites.Store("user", int64(0))
+	assert.NotNil(t, r.getReplica(""))
+	assert.NotNil(t, r.getReplica("user"))
+	r.checkAvailability()
+	_, ok := r.userWrites.Load("user")
+	assert.False(t, ok)
+}
+
+func TestReadReplicaFallback(t *testing.T) {
+	p, primaryDSN, replicaDSNs := initializeReplicaTestProvider(t, 1)
+	replica := p.replicas.replicas[0]
+	errReplica := errors.New("replica error")
+
+	var handles []sqlQuerier
+	readFn := func(replicaErr error) func(dbHandle sqlQuerier) (int, error) {
+		return func(dbHandle sqlQuerier) (int, error) {
+			handles = append(handles, dbHandle)
+			if dbHandle == replica.dbHandle && replicaErr != nil {
+				return 0, replicaErr
+			}
+			return len(handles), nil
+		}
+	}
+	res, err := sqlReplicaRead(p.replicas, p.dbHandle, "", readFn(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res)
+	assert.Equal(t, []sqlQuerier{replica.dbHandle}, handles)
+	// a not found error could be caused by the replication lag, the
+	// primary is queried and the replica is still used
+	handles = nil
+	res, err = sqlReplicaRead(p.replicas, p.dbHandle, "", readFn(util.NewRecordNotFoundError("not found")))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res)
+	assert.Equal(t, []sqlQuerier{replica.dbHandle, p.dbHandle}, handles)
+	assert.True(t, replica.isAvailable.Load())
+	// other errors mark the replica as unavailable
+	handles = nil
+	res, err = sqlReplicaRead(p.replicas, p.dbHandle, "", readFn(errReplica))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res)
+	assert.Equal(t, []sqlQuerier{replica.dbHandle, p.dbHandle}, handles)
+	assert.False(t, replica.isAvailable.Load())
+	handles = nil
+	res, err = sqlReplicaRead(p.replicas, p.dbHandle, "", readFn(errReplica))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res)
+	assert.Equal(t, []sqlQuerier{p.dbHandle}, handles)
+	// the primary error is returned
+	handles = nil
+	_, err = sqlReplicaRead(p.replicas, p.dbHandle, "", func(dbHandle sqlQuerier) (int, error) {
+		return 0, errReplica
+	})
+	assert.ErrorIs(t, err, errReplica)
+	// a replica that cannot be reached
+	replica.isAvailable.Store(true)
+	err = replica.dbHandle.Close()
+	assert.NoError(t, err)
+	replicaTestDriver.reset()
+	_, err = p.getUsers(10, 0, OrderASC)
+	assert.NoError(t, err)
+	assert.False(t, replica.isAvailable.Load())
+	assert.Empty(t, replicaTestDriver.getQueries(replicaDSNs[0]))
+	assert.NotEmpty(t, replicaTestDriver.getQueries(primaryDSN))
+	p.replicas.checkAvailability()
+	assert.False(t, replica.isAvailable.Load())
+}
+
+func TestReadReplicaWrites(t *testing.T) {
+	p, primaryDSN, replicaDSNs := initializeReplicaTestProvider(t, 2)
+	// the replicas have no data, a query executed on a replica returns
+	// a not found error or an empty result
+	assertReplicasNotUsed := func() {
+		for _, dsn := range replicaDSNs {
+			assert.Empty(t, replicaTestDriver.getQueries(dsn))
+		}
+	}
+	username := "replica_user"
+	user := getReplicaTestUser(username)
+	err := p.addUser(&user)
+	require.NoError(t, err)
+	assertReplicasNotUsed()
+	assert.NotEmpty(t, replicaTestDriver.getQueries(primaryDSN))
+	// read after write
+	user, err = p.userExists(username)
+	assert.NoError(t, err)
+	users, err := p.getUsers(10, 0, OrderASC)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+	_, err = p.validateUserAndPass(username, "password", "127.0.0.1", protocolSSH)
+	assert.NoError(t, err)
+	assertReplicasNotUsed()
+	user.Description = "updated"
+	err = p.updateUser(&user)
+	assert.NoError(t, err)
+	err = p.updateUserPassword(username, "new password", nil)
+	assert.NoError(t, err)
+	err = p.setFirstUploadTimestamp(username)
+	assert.NoError(t, err)
+	assertReplicasNotUsed()
+	// the max lag is elapsed, the reads are executed on the replicas and the
+	// user is found on the primary database since the replication is not enabled
+	p.replicas.lastWrite.Store(0)
+	p.replicas.userWrites.Range(func(key, _ any) bool {
+		p.replicas.userWrites.Delete(key)
+		return true
+	})
+	replicaTestDriver.reset()
+	user, err = p.userExists(username)
+	assert.NoError(t, err)
+	assert.Equal(t, "updated", user.Description)
+	numReplicaQueries := 0
+	for _, dsn := range replicaDSNs {
+		numReplicaQueries += len(replicaTestDriver.getQueries(dsn))
+	}
+	assert.Greater(t, numReplicaQueries, 0)
+	assert.NotEmpty(t, replicaTestDriver.getQueries(primaryDSN))
+	for _, replica := range p.replicas.replicas {
+		assert.True(t, replica.isAvailable.Load())
+	}
+	// a user specific write only affects the reads for that user
+	err = p.setFirstDownloadTimestamp(username)
+	assert.NoError(t, err)
+	replicaTestDriver.reset()
+	_, err = p.userExists(username)
+	assert.NoError(t, err)
+	assertReplicasNotUsed()
+	users, err = p.getUsers(10, 0, OrderASC)
+	assert.NoError(t, err)
+	assert.Len(t, users, 0)
+	numReplicaQueries = 0
+	for _, dsn := range replicaDSNs {
+		numReplicaQueries += len(replicaTestDriver.getQueries(dsn))
+	}
+	assert.Greater(t, numReplicaQueries, 0)
+	// deletes are executed on the primary
+	replicaTestDriver.reset()
+	err = p.deleteUser(user, false)
+	assert.NoError(t, err)
+	assertReplicasNotUsed()
+	_, err = p.userExists(username)
+	var nfErr *util.RecordNotFoundError
+	assert.ErrorAs(t, err, &nfErr)
+	assertReplicasNotUsed()
+}
@@ -0,0 +1,209 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported transfer quota reset periods
+const (
+	TransferQuotaResetDaily   = "daily"
+	TransferQuotaResetWeekly  = "weekly"
+	TransferQuotaResetMonthly = "monthly"
+)
+
+var (
+	transferQuotaResetPeriods = []string{TransferQuotaResetDaily, TransferQuotaResetWeekly, TransferQuotaResetMonthly}
+	// used if the anchor date is not set: resets happen at 00:00 UTC, on Monday
+	// for weekly resets and on the first day of the month for monthly ones
+	defaultTransferQuotaResetAnchor = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// TransferQuotaReset defines the policy to automatically reset the used data transfer
+type TransferQuotaReset struct {
+	// Reset period: daily, weekly, monthly
+	Period string `json:"period"`
+	// Anchor date as unix timestamp in milliseconds. It defines the time of the
+	// resets and, for weekly and monthly resets, the day of the week or of the
+	// month. The first reset happens at the anchor date. 0 means 00:00 UTC,
+	// Monday for weekly resets and the first day of the month for monthly ones.
+	// For months without the anchor day the reset happens on their last day
+	AnchorDate int64 `json:"anchor_date,omitempty"`
+}
+
+// IsEnabled returns true if a reset period is defined
+func (r *TransferQuotaReset) IsEnabled() bool {
+	return r != nil && r.Period != ""
+}
+
+func (r *TransferQuotaReset) validate() error {
+	if r == nil {
+		return nil
+	}
+	if !util.Contains(transferQuotaResetPeriods, r.Period) {
+		return util.NewValidationError(fmt.Sprintf("invalid transfer quota reset period %q", r.Period))
+	}
+	if r.AnchorDate < 0 {
+		return util.NewValidationError("invalid transfer quota reset anchor date")
+	}
+	return nil
+}
+
+func (r *TransferQuotaReset) getACopy() *TransferQuotaReset {
+	if r == nil {
+		return nil
+	}
+	return &TransferQuotaReset{
+		Period:     r.Period,
+		AnchorDate: r.AnchorDate,
+	}
+}
+
+func (r *TransferQuotaReset) getAnchor() time.Time {
+	if r.AnchorDate > 0 {
+		return util.GetTimeFromMsecSinceEpoch(r.AnchorDate).UTC()
+	}
+	return defaultTransferQuotaResetAnchor
+}
+
+// GetAnchorDateAsString returns the anchor date formatted as YYYY-MM-DD HH:MM:SS
+func (r *TransferQuotaReset) GetAnchorDateAsString() string {
+	if r == nil || r.AnchorDate == 0 {
+		return ""
+	}
+	return util.GetTimeFromMsecSinceEpoch(r.AnchorDate).UTC().Format("2006-01-02 15:04:05")
+}
+
+// GetLastResetTime returns the most recent scheduled reset before or equal to
+// the specified time. The zero time is returned if no reset is scheduled
+// before the specified time
+func (r *TransferQuotaReset) GetLastResetTime(t time.Time) time.Time {
+	if !r.IsEnabled() {
+		return time.Time{}
+	}
+	t = t.UTC()
+	anchor := r.getAnchor()
+	if t.Before(anchor) {
+		return time.Time{}
+	}
+	hour, minute, sec := anchor.Clock()
+	var last time.Time
+	switch r.Period {
+	case TransferQuotaResetDaily:
+		last = time.Date(t.Year(), t.Month(), t.Day(), hour, minute, sec, 0, time.UTC)
+		if last.After(t) {
+			last = last.AddDate(0, 0, -1)
+		}
+	case TransferQuotaResetWeekly:
+		days := (int(t.Weekday()) - int(anchor.Weekday()) + 7) % 7
+		last = time.Date(t.Year(), t.Month(), t.Day()-days, hour, minute, sec, 0, time.UTC)
+		if last.After(t) {
+			last = last.AddDate(0, 0, -7)
+		}
+	default:
+		last = getMonthlyResetTime(t.Year(), t.Month(), anchor.Day(), hour, minute, sec)
+		if last.After(t) {
+			last = getMonthlyResetTime(t.Year(), t.Month()-1, anchor.Day(), hour, minute, sec)
+		}
+	}
+	if last.Before(anchor) {
+		return time.Time{}
+	}
+	return last
+}
+
+func getMonthlyResetTime(year int, month time.Month, day, hour, minute, sec int) time.Time {
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(year, month, day, hour, minute, sec, 0, time.UTC)
+}
+
+// getScheduledTransferQuotaReset returns the scheduled reset not yet applied
+// for the specified user, if any. Group settings must be applied
+func (u *User) getScheduledTransferQuotaReset(t time.Time) (time.Time, bool) {
+	last := u.Filters.TransferQuotaReset.GetLastResetTime(t)
+	if last.IsZero() {
+		return last, false
+	}
+	// users without a previous reset are checked against their creation time
+	lastApplied := u.LastTransferQuotaReset
+	if lastApplied == 0 {
+		lastApplied = u.CreatedAt
+	}
+	return last, util.GetTimeAsMsSinceEpoch(last) > lastApplied
+}
+
+// GetLastTransferQuotaResetAsString returns the last automatic transfer quota
+// reset as string
+func (u *User) GetLastTransferQuotaResetAsString() string {
+	if u.LastTransferQuotaReset > 0 {
+		return util.GetTimeFromMsecSinceEpoch(u.LastTransferQuotaReset).UTC().Format(iso8601UTCFormat)
+	}
+	return ""
+}
+
+func checkTransferQuotaResets() error {
+	now := time.Now()
+	limit := 100
+	var toReset []User
+	for offset := 0; ; offset += limit {
+		users, err := provider.getUsers(limit, offset, OrderASC)
+		if err != nil {
+			providerLog(logger.LevelError, "unable to get users to check for transfer quota resets: %v", err)
+			return err
+		}
+		for idx := range users {
+			user := &users[idx]
+			if err := user.LoadAndApplyGroupSettings(); err != nil {
+				providerLog(logger.LevelError, "unable to apply group settings to user %q: %v", user.Username, err)
+				continue
+			}
+			if _, ok := user.getScheduledTransferQuotaReset(now); ok {
+				toReset = append(toReset, *user)
+			}
+		}
+		if len(users) < limit {
+			break
+		}
+	}
+	for idx := range toReset {
+		resetUserTransferQuota(&toReset[idx], now)
+	}
+	return nil
+}
+
+func resetUserTransferQuota(user *User, t time.Time) {
+	scheduledAt, ok := user.getScheduledTransferQuotaReset(t)
+	if !ok {
+		return
+	}
+	delayedQuotaUpdater.resetUserTransferQuota(user.Username)
+	reset, err := provider.resetUserTransferQuota(user.Username, util.GetTimeAsMsSinceEpoch(scheduledAt))
+	if err != nil {
+		providerLog(logger.LevelError, "unable to reset transfer quota for user %q: %v", user.Username, err)
+		return
+	}
+	if reset {
+		providerLog(logger.LevelInfo, "transfer quota reset for user %q, period %q, scheduled at %s", user.Username,
+			user.Filters.TransferQuotaReset.Period, scheduledAt)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// UploadSizeLimit defines the max upload file size for the files uploaded to
+// a virtual directory, and its sub directories, optionally restricted to the
+// specified file extensions. The limit defined for the nearest directory is
+// applied and, for the same directory, limits for specific extensions take
+// precedence over the generic ones
+type UploadSizeLimit struct {
+	// Virtual directory, for example "/" or "/media"
+	Path string `json:"path"`
+	// File extensions, for example ".mp4", matched case insensitive.
+	// Empty means any file
+	Extensions []string `json:"extensions,omitempty"`
+	// Max upload file size in bytes, 0 means no limit
+	MaxSize int64 `json:"max_size"`
+}
+
+// GetExtensionsAsString returns the extensions as comma separated string
+func (l *UploadSizeLimit) GetExtensionsAsString() string {
+	return strings.Join(l.Extensions, ",")
+}
+
+func (l *UploadSizeLimit) isExtensionMatched(fileName string) bool {
+	ext := strings.ToLower(path.Ext(fileName))
+	return ext != "" && util.Contains(l.Extensions, ext)
+}
+
+func (l *UploadSizeLimit) validate() error {
+	if l.Path == "" {
+		return util.NewValidationError("upload size limit: path is mandatory")
+	}
+	if !path.IsAbs(l.Path) {
+		return util.NewValidationError(fmt.Sprintf("upload size limit: invalid path %q, it must be an absolute path",
+			l.Path))
+	}
+	l.Path = util.CleanPath(l.Path)
+	if l.MaxSize < 0 {
+		return util.NewValidationError(fmt.Sprintf("upload size limit for %q: invalid max size %d", l.Path,
+			l.MaxSize))
+	}
+	var extensions []string
+	for _, ext := range l.Extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if ext == "." || strings.ContainsAny(ext, "/*?") {
+			return util.NewValidationError(fmt.Sprintf("upload size limit for %q: invalid extension %q", l.Path, ext))
+		}
+		if !util.Contains(extensions, ext) {
+			extensions = append(extensions, ext)
+		}
+	}
+	l.Extensions = extensions
+	return nil
+}
+
+func (l *UploadSizeLimit) getACopy() UploadSizeLimit {
+	extensions := make([]string, len(l.Extensions))
+	copy(extensions, l.Extensions)
+
+	return UploadSizeLimit{
+		Path:       l.Path,
+		Extensions: extensions,
+		MaxSize:    l.MaxSize,
+	}
+}
+
+func validateUserUploadSizeLimits(user *User) error {
+	// the same extension cannot be limited twice for the same path
+	seen := make(map[string]bool)
+	generics := make(map[string]bool)
+	for idx := range user.Filters.UploadSizeLimits {
+		limit := &user.Filters.UploadSizeLimits[idx]
+		if err := limit.validate(); err != nil {
+			return err
+		}
+		if len(limit.Extensions) == 0 {
+			if generics[limit.Path] {
+				return util.NewValidationError(fmt.Sprintf("upload size limit for %q is duplicated", limit.Path))
+			}
+			generics[limit.Path] = true
+			continue
+		}
+		for _, ext := range limit.Extensions {
+			key := fmt.Sprintf("%s|%s", limit.Path, ext)
+			if seen[key] {
+				return util.NewValidationError(fmt.Sprintf("upload size limit for %q, extension %q is duplicated",
+					limit.Path, ext))
+			}
+			seen[key] = true
+		}
+	}
+	return nil
+}
@@ -83,9 +83,12 @@ const (
 	SSHLoginMethodKeyboardInteractive = "keyboard-interactive"
 	SSHLoginMethodKeyAndPassword      = "publickey+password"
 	SSHLoginMethodKeyAndKeyboardInt   = "publickey+keyboard-interactive"
+	SSHLoginMethodGSSAPI              = "gssapi-with-mic"
 	LoginMethodTLSCertificate         = "TLSCertificate"
 	LoginMethodTLSCertificateAndPwd   = "TLSCertificate+password"
 	LoginMethodIDP                    = "IDP"
+	LoginMethodMagicLink              = "magic-link"
+	LoginMethodS3Signature            = "S3Signature"
 )
 
 var (
@@ -115,6 +118,18 @@ type UserTOTPConfig struct {
 	Protocols []string `json:"protocols,omitempty"`
 }
 
+// UserPushConfig defines the push-based second factor configuration.
+// The login is approved using the push provider configured in the MFA section
+type UserPushConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// The approval will be requested for the specified protocols.
+	// SSH protocol will request the approval if the client uses keyboard
+	// interactive authentication, FTP and WebDAV after the password
+	// authentication and HTTP on the WebClient two-factor authentication page
+	// and for the REST API tokens
+	Protocols []string `json:"protocols,omitempty"`
+}
+
 // UserFilters defines additional restrictions for a user
 // TODO: rename to UserOptions in v3
 type UserFilters struct {
@@ -125,6 +140,148 @@ type UserFilters struct {
 	// Each code can only be used once, you should use these codes to login and disable or
 	// reset 2FA for your account
 	RecoveryCodes []RecoveryCode `json:"recovery_codes,omitempty"`
+	// WebAuthn credentials, for example hardware security keys, usable as second
+	// factor for the WebClient
+	WebAuthnCredentials []mfa.WebAuthnCredential `json:"webauthn_credentials,omitempty"`
+	// Push-based second factor configuration
+	PushConfig UserPushConfig `json:"push_config,omitempty"`
+	// Preferred language for emails and the web interfaces, for example "de" or "pt-br".
+	// Empty means the default language
+	Language string `json:"language,omitempty"`
+	// Theme for the WebClient UI: "dark" or "high-contrast".
+	// Empty means the default light theme
+	Theme string `json:"theme,omitempty"`
+	// Secret access key for the S3 gateway, the access key ID is the username.
+	// If not set, the user cannot authenticate to the S3 gateway
+	S3SecretAccessKey *kms.Secret `json:"s3_secret_access_key,omitempty"`
+	// Remote credentials for the SFTP virtual folders configured to resolve
+	// them for the authenticated user
+	SFTPCredentials []SFTPRemoteCredentials `json:"sftp_credentials,omitempty"`
+	// External IP address or hostname to advertise for FTP passive data connections.
+	// If set, it overrides the passive IP configured for the FTP bindings
+	FTPPassiveHost string `json:"ftp_passive_host,omitempty"`
+	// Allow MODE Z compression for FTP transfers, MODE Z must be enabled for
+	// the FTP binding too
+	FTPModeZ bool `json:"ftp_mode_z,omitempty"`
+	// Name of the password policy to enforce. If empty, the policy defined for
+	// the primary group, if any, is applied
+	PasswordPolicy string `json:"password_policy,omitempty"`
+	// ISO 3166-1 alpha-2 country codes allowed to login. If set, only clients
+	// geolocated in the listed countries can login
+	AllowedCountries []string `json:"allowed_countries,omitempty"`
+	// ISO 3166-1 alpha-2 country codes denied to login
+	DeniedCountries []string `json:"denied_countries,omitempty"`
+	// Last password change as unix timestamp in milliseconds
+	PasswordChangedAt int64 `json:"password_changed_at,omitempty"`
+	// Hashes of the most recent passwords, they cannot be reused if the
+	// password policy defines a history
+	PasswordHistory []string `json:"password_history,omitempty"`
+	// TCP port forwarding allowed within SSH connections, nil means disabled
+	PortForwarding *SSHPortForwarding `json:"port_forwarding,omitempty"`
+	// Client software allowed to login, nil means any client
+	ClientPolicy *ClientPolicy `json:"client_policy,omitempty"`
+	// Max upload file sizes for specific directories and/or file extensions,
+	// they override the max upload file size for the matching files
+	UploadSizeLimits []UploadSizeLimit `json:"upload_size_limits,omitempty"`
+	// Multipart upload part size and concurrency for the S3 and Azure Blob
+	// Storage filesystems, they override the filesystem configs
+	MultipartUploads []MultipartUploadSettings `json:"multipart_uploads,omitempty"`
+	// Time windows with specific bandwidth limits, the first active schedule
+	// overrides the user and per-source bandwidth limits
+	BandwidthSchedules []BandwidthSchedule `json:"bandwidth_schedules,omitempty"`
+	// Policy to automatically reset the used data transfer. If nil, the policy
+	// defined for the primary group, if any, is applied
+	TransferQuotaReset *TransferQuotaReset `json:"transfer_quota_reset,omitempty"`
+}
+
+// SFTPRemoteCredentials defines the credentials to use for connecting to the
+// SFTP server of a virtual folder with per-user remote credentials enabled.
+// The secrets are encrypted using the username as additional data
+type SFTPRemoteCredentials struct {
+	// Virtual folder name
+	FolderName string `json:"folder_name"`
+	// Remote username, if empty the username defined in the folder is used
+	Username      string      `json:"username,omitempty"`
+	Password      *kms.Secret `json:"password,omitempty"`
+	PrivateKey    *kms.Secret `json:"private_key,omitempty"`
+	KeyPassphrase *kms.Secret `json:"key_passphrase,omitempty"`
+}
+
+func (c *SFTPRemoteCredentials) getSecrets() []*kms.Secret {
+	return []*kms.Secret{c.Password, c.PrivateKey, c.KeyPassphrase}
+}
+
+func (c *SFTPRemoteCredentials) hideConfidentialData() {
+	for _, secret := range c.getSecrets() {
+		if secret != nil {
+			secret.Hide()
+		}
+	}
+}
+
+func (c *SFTPRemoteCredentials) hasRedactedSecret() bool {
+	for _, secret := range c.getSecrets() {
+		if secret != nil && secret.IsRedacted() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *SFTPRemoteCredentials) getACopy() SFTPRemoteCredentials {
+	cloneSecret := func(secret *kms.Secret) *kms.Secret {
+		if secret == nil {
+			return nil
+		}
+		return secret.Clone()
+	}
+	return SFTPRemoteCredentials{
+		FolderName:    c.FolderName,
+		Username:      c.Username,
+		Password:      cloneSecret(c.Password),
+		PrivateKey:    cloneSecret(c.PrivateKey),
+		KeyPassphrase: cloneSecret(c.KeyPassphrase),
+	}
+}
+
+func (c *SFTPRemoteCredentials) validate(additionalData string) error {
+	if c.FolderName == "" {
+		return util.NewValidationError("folder name is mandatory for SFTP remote credentials")
+	}
+	if c.Password != nil && c.Password.IsEmpty() {
+		c.Password = nil
+	}
+	if c.PrivateKey != nil && c.PrivateKey.IsEmpty() {
+		c.PrivateKey = nil
+	}
+	if c.KeyPassphrase != nil && c.KeyPassphrase.IsEmpty() {
+		c.KeyPassphrase = nil
+	}
+	if c.Password == nil && c.PrivateKey == nil {
+		return util.NewValidationError(fmt.Sprintf("a password or a private key is required for the SFTP remote credentials of folder %q",
+			c.FolderName))
+	}
+	for _, secret := range c.getSecrets() {
+		if secret == nil {
+			continue
+		}
+		if secret.IsEncrypted() && !secret.IsValid() {
+			return util.NewValidationError(fmt.Sprintf("invalid encrypted secret in the SFTP remote credentials of folder %q",
+				c.FolderName))
+		}
+		if !secret.IsValidInput() {
+			return util.NewValidationError(fmt.Sprintf("invalid secret in the SFTP remote credentials of folder %q",
+				c.FolderName))
+		}
+		if secret.IsPlain() {
+			secret.SetAdditionalData(additionalData)
+			if err := secret.Encrypt(); err != nil {
+				return util.NewValidationError(fmt.Sprintf("could not encrypt the SFTP remote credentials of folder %q: %v",
+					c.FolderName, err))
+			}
+		}
+	}
+	return nil
 }
 
 // User defines a SFTPGo user
@@ -138,10 +295,20 @@ type User struct {
 	FsConfig vfs.Filesystem `json:"filesystem"`
 	// groups associated with this user
 	Groups []sdk.GroupMapping `json:"groups,omitempty"`
+	// Custom key/value metadata, for example external system IDs or billing codes
+	Metadata util.Metadata `json:"metadata,omitempty"`
+	// Timestamp, as unix milliseconds, of the soft deletion. A soft deleted
+	// user cannot login and is permanently removed after the retention period
+	SoftDeletedAt int64 `json:"soft_deleted_at,omitempty"`
+	// Last automatic transfer quota reset as unix timestamp in milliseconds
+	LastTransferQuotaReset int64 `json:"last_transfer_quota_reset,omitempty"`
 	// we store the filesystem here using the base path as key.
 	fsCache map[string]vfs.Fs `json:"-"`
 	// true if group settings are already applied for this user
 	groupSettingsApplied bool `json:"-"`
+	// true if the user was authenticated using cached credentials, while the
+	// data provider was unavailable, and so it has read-only permissions
+	readOnlyFallback bool `json:"-"`
 	// in multi node setups we mark the user as deleted to be able to update the webdav cache
 	DeletedAt int64 `json:"-"`
 }
@@ -154,11 +321,15 @@ func (u *User) GetFilesystem(connectionID string) (fs vfs.Fs, err error) {
 func (u *User) getRootFs(connectionID string) (fs vfs.Fs, err error) {
 	switch u.FsConfig.Provider {
 	case sdk.S3FilesystemProvider:
-		return vfs.NewS3Fs(connectionID, u.GetHomeDir(), "", u.FsConfig.S3Config)
+		fsConfig := u.FsConfig
+		u.applyMultipartUploadSettings("/", &fsConfig)
+		return vfs.NewS3Fs(connectionID, u.GetHomeDir(), "", fsConfig.S3Config)
 	case sdk.GCSFilesystemProvider:
 		return vfs.NewGCSFs(connectionID, u.GetHomeDir(), "", u.FsConfig.GCSConfig)
 	case sdk.AzureBlobFilesystemProvider:
-		return vfs.NewAzBlobFs(connectionID, u.GetHomeDir(), "", u.FsConfig.AzBlobConfig)
+		fsConfig := u.FsConfig
+		u.applyMultipartUploadSettings("/", &fsConfig)
+		return vfs.NewAzBlobFs(connectionID, u.GetHomeDir(), "", fsConfig.AzBlobConfig)
 	case sdk.CryptedFilesystemProvider:
 		return vfs.NewCryptFs(connectionID, u.GetHomeDir(), "", u.FsConfig.CryptConfig)
 	case sdk.SFTPFilesystemProvider:
@@ -170,6 +341,14 @@ func (u *User) getRootFs(connectionID string) (fs vfs.Fs, err error) {
 		return vfs.NewSFTPFs(connectionID, "", u.GetHomeDir(), forbiddenSelfUsers, u.FsConfig.SFTPConfig)
 	case sdk.HTTPFilesystemProvider:
 		return vfs.NewHTTPFs(connectionID, u.GetHomeDir(), "", u.FsConfig.HTTPConfig)
+	case vfs.SMBFilesystemProvider:
+		return vfs.NewSMBFs(connectionID, "", u.GetHomeDir(), u.FsConfig.SMBConfig)
+	case vfs.WebDAVFilesystemProvider:
+		return vfs.NewWebDAVFs(connectionID, "", u.GetHomeDir(), u.FsConfig.WebDAVConfig)
+	case vfs.CompressedFilesystemProvider:
+		return vfs.NewCompressedFs(connectionID, u.GetHomeDir(), "", u.FsConfig.CompressConfig)
+	case vfs.DedupFilesystemProvider:
+		return vfs.NewDedupFs(connectionID, u.GetHomeDir(), "", u.FsConfig.DedupConfig)
 	default:
 		return vfs.NewOsFs(connectionID, u.GetHomeDir(), ""), nil
 	}
@@ -213,7 +392,8 @@ func (u *User) checkDirWithParents(virtualDirPath, connectionID string) error {
 
 func (u *User) checkLocalHomeDir(connectionID string) {
 	switch u.FsConfig.Provider {
-	case sdk.LocalFilesystemProvider, sdk.CryptedFilesystemProvider:
+	case sdk.LocalFilesystemProvider, sdk.CryptedFilesystemProvider, vfs.CompressedFilesystemProvider,
+		vfs.DedupFilesystemProvider:
 		return
 	default:
 		osFs := vfs.NewOsFs(connectionID, u.GetHomeDir(), "")
@@ -336,6 +516,9 @@ func (u *User) CheckLoginConditions() error {
 	if u.Status < 1 {
 		return fmt.Errorf("user %#v is disabled", u.Username)
 	}
+	if u.IsSoftDeleted() {
+		return fmt.Errorf("user %q is deleted", u.Username)
+	}
 	if u.ExpirationDate > 0 && u.ExpirationDate < util.GetTimeAsMsSinceEpoch(time.Now()) {
 		return fmt.Errorf("user %#v is expired, expiration timestamp: %v current timestamp: %v", u.Username,
 			u.ExpirationDate, util.GetTimeAsMsSinceEpoch(time.Now()))
@@ -343,9 +526,24 @@ func (u *User) CheckLoginConditions() error {
 	return nil
 }
 
+// IsSoftDeleted returns true if the user is soft deleted and waiting for
+// permanent removal
+func (u *User) IsSoftDeleted() bool {
+	return u.SoftDeletedAt > 0
+}
+
+// GetSoftDeletedAtAsString returns the soft deletion time as string
+func (u *User) GetSoftDeletedAtAsString() string {
+	if u.SoftDeletedAt > 0 {
+		return util.GetTimeFromMsecSinceEpoch(u.SoftDeletedAt).UTC().Format(iso8601UTCFormat)
+	}
+	return ""
+}
+
 // hideConfidentialData hides user confidential data
 func (u *User) hideConfidentialData() {
 	u.Password = ""
+	u.Filters.PasswordHistory = nil
 	u.FsConfig.HideConfidentialData()
 	if u.Filters.TOTPConfig.Secret != nil {
 		u.Filters.TOTPConfig.Secret.Hide()
@@ -355,6 +553,12 @@ func (u *User) hideConfidentialData() {
 			code.Secret.Hide()
 		}
 	}
+	if u.Filters.S3SecretAccessKey != nil {
+		u.Filters.S3SecretAccessKey.Hide()
+	}
+	for idx := range u.Filters.SFTPCredentials {
+		u.Filters.SFTPCredentials[idx].hideConfidentialData()
+	}
 }
 
 // GetSubDirPermissions returns permissions for sub directories
@@ -377,7 +581,7 @@ func (u *User) setAnonymousSettings() {
 	for k := range u.Permissions {
 		u.Permissions[k] = []string{PermListItems, PermDownload}
 	}
-	u.Filters.DeniedProtocols = append(u.Filters.DeniedProtocols, protocolSSH, protocolHTTP)
+	u.Filters.DeniedProtocols = append(u.Filters.DeniedProtocols, protocolSSH, protocolHTTP, protocolS3)
 	u.Filters.DeniedProtocols = util.RemoveDuplicates(u.Filters.DeniedProtocols, false)
 	for _, method := range ValidLoginMethods {
 		if method != LoginMethodPassword {
@@ -402,6 +606,11 @@ func (u *User) RenderAsJSON(reload bool) ([]byte, error) {
 	return json.Marshal(u)
 }
 
+// GetMetadata returns the custom metadata for this user
+func (u *User) GetMetadata() util.Metadata {
+	return u.Metadata
+}
+
 // PrepareForRendering prepares a user for rendering.
 // It hides confidential data and set to nil the empty secrets
 // so they are not serialized
@@ -427,6 +636,16 @@ func (u *User) hasRedactedSecret() bool {
 		}
 	}
 
+	if u.Filters.S3SecretAccessKey != nil && u.Filters.S3SecretAccessKey.IsRedacted() {
+		return true
+	}
+
+	for idx := range u.Filters.SFTPCredentials {
+		if u.Filters.SFTPCredentials[idx].hasRedactedSecret() {
+			return true
+		}
+	}
+
 	return u.Filters.TOTPConfig.Secret.IsRedacted()
 }
 
@@ -468,6 +687,8 @@ func (u *User) SetEmptySecrets() {
 		folder.FsConfig.SetEmptySecrets()
 	}
 	u.Filters.TOTPConfig.Secret = kms.NewEmptySecret()
+	u.Filters.S3SecretAccessKey = nil
+	u.Filters.SFTPCredentials = nil
 }
 
 // GetPermissionsForPath returns the permissions for the given path.
@@ -551,6 +772,10 @@ func (u *User) GetFilesystemForPath(virtualPath, connectionID string) (vfs.Fs, e
 			if fs, ok := u.fsCache[folder.VirtualPath]; ok {
 				return fs, nil
 			}
+			if err := u.applySFTPRemoteCredentials(&folder); err != nil {
+				return nil, err
+			}
+			u.applyMultipartUploadSettings(folder.VirtualPath, &folder.FsConfig)
 			forbiddenSelfUsers := []string{u.Username}
 			if folder.FsConfig.Provider == sdk.SFTPFilesystemProvider {
 				forbiddens, err := u.getForbiddenSFTPSelfUsers(folder.FsConfig.SFTPConfig.Username)
@@ -578,6 +803,32 @@ func (u *User) GetFilesystemForPath(virtualPath, connectionID string) (vfs.Fs, e
 	return fs, err
 }
 
+// applySFTPRemoteCredentials sets the remote credentials defined for this user
+// if the specified folder must resolve them at login time
+func (u *User) applySFTPRemoteCredentials(folder *vfs.VirtualFolder) error {
+	if !folder.HasUserCredentials() {
+		return nil
+	}
+	for idx := range u.Filters.SFTPCredentials {
+		if u.Filters.SFTPCredentials[idx].FolderName != folder.Name {
+			continue
+		}
+		credentials := u.Filters.SFTPCredentials[idx].getACopy()
+		if credentials.Username != "" {
+			folder.FsConfig.SFTPConfig.Username = credentials.Username
+		}
+		if folder.FsConfig.SFTPConfig.Username == "" {
+			return fmt.Errorf("no remote username defined for folder %q", folder.Name)
+		}
+		folder.FsConfig.SFTPConfig.Password = credentials.Password
+		folder.FsConfig.SFTPConfig.PrivateKey = credentials.PrivateKey
+		folder.FsConfig.SFTPConfig.KeyPassphrase = credentials.KeyPassphrase
+		folder.FsConfig.SetEmptySecretsIfNil()
+		return nil
+	}
+	return fmt.Errorf("no SFTP remote credentials defined for folder %q", folder.Name)
+}
+
 // GetVirtualFolderForPath returns the virtual folder containing the specified virtual path.
 // If the path is not inside a virtual folder an error is returned
 func (u *User) GetVirtualFolderForPath(virtualPath string) (vfs.VirtualFolder, error) {
@@ -942,7 +1193,7 @@ func (u *User) IsPartialAuth(loginMethod string) bool {
 	}
 	for _, method := range u.GetAllowedLoginMethods() {
 		if method == LoginMethodTLSCertificate || method == LoginMethodTLSCertificateAndPwd ||
-			method == SSHLoginMethodPassword {
+			method == SSHLoginMethodPassword || method == SSHLoginMethodGSSAPI {
 			continue
 		}
 		if !util.Contains(SSHMultiStepsLoginMethods, method) {
@@ -986,6 +1237,51 @@ func (u *User) getPatternsFilterForPath(virtualPath string) sdk.PatternsFilter {
 	return filter
 }
 
+// GetMaxUploadFileSize returns the max upload file size for the specified virtual
+// path. The upload size limit defined for the nearest directory is used, limits for
+// the file extension take precedence over the generic ones for the same directory.
+// If no limit matches the max upload file size filter is returned. 0 means no limit
+func (u *User) GetMaxUploadFileSize(virtualPath string) int64 {
+	if len(u.Filters.UploadSizeLimits) == 0 {
+		return u.Filters.MaxUploadFileSize
+	}
+	for _, dir := range util.GetDirsForVirtualPath(path.Dir(virtualPath)) {
+		var generic *UploadSizeLimit
+		for idx := range u.Filters.UploadSizeLimits {
+			limit := &u.Filters.UploadSizeLimits[idx]
+			if limit.Path != dir {
+				continue
+			}
+			if len(limit.Extensions) == 0 {
+				generic = limit
+				continue
+			}
+			if limit.isExtensionMatched(virtualPath) {
+				return limit.MaxSize
+			}
+		}
+		if generic != nil {
+			return generic.MaxSize
+		}
+	}
+	return u.Filters.MaxUploadFileSize
+}
+
+// GetHighestMaxUploadFileSize returns the highest max upload file size allowed
+// for any path, it is useful if the upload path is not yet known. 0 means no limit
+func (u *User) GetHighestMaxUploadFileSize() int64 {
+	result := u.Filters.MaxUploadFileSize
+	for _, limit := range u.Filters.UploadSizeLimits {
+		if result == 0 || limit.MaxSize == 0 {
+			return 0
+		}
+		if limit.MaxSize > result {
+			result = limit.MaxSize
+		}
+	}
+	return result
+}
+
 func (u *User) isDirHidden(virtualPath string) bool {
 	if len(u.Filters.FilePatterns) == 0 {
 		return false
@@ -1020,7 +1316,27 @@ func (u *User) CanManageMFA() bool {
 	if util.Contains(u.Filters.WebClient, sdk.WebClientMFADisabled) {
 		return false
 	}
-	return len(mfa.GetAvailableTOTPConfigs()) > 0
+	return len(mfa.GetAvailableTOTPConfigs()) > 0 || mfa.IsWebAuthnEnabled()
+}
+
+// HasWebAuthnCredentials returns true if the user can login to the WebClient
+// using a WebAuthn credential as second factor
+func (u *User) HasWebAuthnCredentials() bool {
+	return mfa.IsWebAuthnEnabled() && len(u.Filters.WebAuthnCredentials) > 0
+}
+
+// IsPushEnabledForProtocol returns true if the push-based second factor is
+// enabled for the specified protocol
+func (u *User) IsPushEnabledForProtocol(protocol string) bool {
+	return mfa.IsPushEnabled() && u.Filters.PushConfig.Enabled && util.Contains(u.Filters.PushConfig.Protocols, protocol)
+}
+
+// GetWebAuthnUser returns the WebAuthn representation of this user
+func (u *User) GetWebAuthnUser() *mfa.WebAuthnUser {
+	return &mfa.WebAuthnUser{
+		Name:        u.Username,
+		Credentials: u.Filters.WebAuthnCredentials,
+	}
 }
 
 func (u *User) isExternalAuthCached() bool {
@@ -1092,6 +1408,15 @@ func (u *User) CanRenameFromWeb(src, dest string) bool {
 	return u.HasAnyPerm(permsRenameAny, src) && u.HasAnyPerm(permsRenameAny, dest)
 }
 
+// CanCopyFromWeb returns true if the client can copy files from the web UI.
+// The specified src and dest are the source and target directories for the copy
+func (u *User) CanCopyFromWeb(src, dest string) bool {
+	if util.Contains(u.Filters.WebClient, sdk.WebClientWriteDisabled) {
+		return false
+	}
+	return u.HasPerm(PermDownload, src) && u.HasPerm(PermUpload, dest)
+}
+
 // CanDeleteFromWeb returns true if the client can delete objects from the web UI.
 // The specified target is the parent directory for the object to delete
 func (u *User) CanDeleteFromWeb(target string) bool {
@@ -1101,14 +1426,40 @@ func (u *User) CanDeleteFromWeb(target string) bool {
 	return u.HasAnyPerm(permsDeleteAny, target)
 }
 
+// CanRestoreArchivedFromWeb returns true if the client can restore archived objects
+// from the web UI. The specified target is the parent directory for the objects to restore
+func (u *User) CanRestoreArchivedFromWeb(target string) bool {
+	if !u.HasPerm(PermDownload, target) {
+		return false
+	}
+	switch u.GetFsConfigForPath(target).Provider {
+	case sdk.S3FilesystemProvider, sdk.AzureBlobFilesystemProvider:
+		return true
+	default:
+		return false
+	}
+}
+
+// CanManageVersionsFromWeb returns true if the client can list, download and restore
+// object versions from the web UI. The specified target is the parent directory
+// for the objects. Versioning must also be enabled on the bucket/container
+func (u *User) CanManageVersionsFromWeb(target string) bool {
+	if !u.HasPerm(PermDownload, target) {
+		return false
+	}
+	switch u.GetFsConfigForPath(target).Provider {
+	case sdk.S3FilesystemProvider, sdk.GCSFilesystemProvider, sdk.AzureBlobFilesystemProvider:
+		return true
+	default:
+		return false
+	}
+}
+
 // MustSetSecondFactor returns true if the user must set a second factor authentication
 func (u *User) MustSetSecondFactor() bool {
 	if len(u.Filters.TwoFactorAuthProtocols) > 0 {
-		if !u.Filters.TOTPConfig.Enabled {
-			return true
-		}
 		for _, p := range u.Filters.TwoFactorAuthProtocols {
-			if !util.Contains(u.Filters.TOTPConfig.Protocols, p) {
+			if !u.isTOTPEnabledForProtocol(p) && !u.IsPushEnabledForProtocol(p) {
 				return true
 			}
 		}
@@ -1120,16 +1471,15 @@ func (u *User) MustSetSecondFactor() bool {
 // for the specified protocol
 func (u *User) MustSetSecondFactorForProtocol(protocol string) bool {
 	if util.Contains(u.Filters.TwoFactorAuthProtocols, protocol) {
-		if !u.Filters.TOTPConfig.Enabled {
-			return true
-		}
-		if !util.Contains(u.Filters.TOTPConfig.Protocols, protocol) {
-			return true
-		}
+		return !u.isTOTPEnabledForProtocol(protocol) && !u.IsPushEnabledForProtocol(protocol)
 	}
 	return false
 }
 
+func (u *User) isTOTPEnabledForProtocol(protocol string) bool {
+	return u.Filters.TOTPConfig.Enabled && util.Contains(u.Filters.TOTPConfig.Protocols, protocol)
+}
+
 // GetSignature returns a signature for this admin.
 // It could change after an update
 func (u *User) GetSignature() string {
@@ -1165,7 +1515,32 @@ func (u *User) GetBandwidthForIP(clientIP, connectionID string) (int64, int64) {
 // If AllowedIP is defined only the specified IP/Mask can login.
 // If DeniedIP is defined the specified IP/Mask cannot login.
 // If an IP is both allowed and denied then login will be allowed
+// If AllowedCountries and/or DeniedCountries are defined the country for the
+// remote address, resolved using the GeoIP database, is checked too
 func (u *User) IsLoginFromAddrAllowed(remoteAddr string) bool {
+	if !u.isLoginFromIPAllowed(remoteAddr) {
+		return false
+	}
+	return u.isLoginFromCountryAllowed(remoteAddr)
+}
+
+func (u *User) isLoginFromCountryAllowed(remoteAddr string) bool {
+	if len(u.Filters.AllowedCountries) == 0 && len(u.Filters.DeniedCountries) == 0 {
+		return true
+	}
+	var country string
+	if countryResolver != nil {
+		country = countryResolver(util.GetIPFromRemoteAddress(remoteAddr))
+	}
+	if !util.IsCountryAllowed(country, u.Filters.AllowedCountries, u.Filters.DeniedCountries) {
+		logger.Debug(logSender, "", "login not allowed for user %q from remote address %q, country %q",
+			u.Username, remoteAddr, country)
+		return false
+	}
+	return true
+}
+
+func (u *User) isLoginFromIPAllowed(remoteAddr string) bool {
 	if len(u.Filters.AllowedIP) == 0 && len(u.Filters.DeniedIP) == 0 {
 		return true
 	}
@@ -1417,6 +1792,14 @@ func (u *User) GetStorageDescrition() string {
 		return fmt.Sprintf("SFTP: %v", u.FsConfig.SFTPConfig.Endpoint)
 	case sdk.HTTPFilesystemProvider:
 		return fmt.Sprintf("HTTP: %v", u.FsConfig.HTTPConfig.Endpoint)
+	case vfs.SMBFilesystemProvider:
+		return fmt.Sprintf("SMB: %v/%v", u.FsConfig.SMBConfig.Endpoint, u.FsConfig.SMBConfig.Share)
+	case vfs.WebDAVFilesystemProvider:
+		return fmt.Sprintf("WebDAV: %v", u.FsConfig.WebDAVConfig.Endpoint)
+	case vfs.CompressedFilesystemProvider:
+		return fmt.Sprintf("Compressed: %v", u.GetHomeDir())
+	case vfs.DedupFilesystemProvider:
+		return fmt.Sprintf("Deduplicated: %v", u.GetHomeDir())
 	default:
 		return ""
 	}
@@ -1503,6 +1886,38 @@ func (u *User) GetDeniedIPAsString() string {
 	return strings.Join(u.Filters.DeniedIP, ",")
 }
 
+// GetPortForwarding returns the SSH port forwarding settings,
+// the zero value is returned if port forwarding is disabled
+func (u *User) GetPortForwarding() SSHPortForwarding {
+	if u.Filters.PortForwarding == nil {
+		return SSHPortForwarding{}
+	}
+	return *u.Filters.PortForwarding
+}
+
+// IsReadOnlyFallback returns true if the user was authenticated using cached
+// credentials while the data provider was unavailable. These users have
+// read-only permissions and must not be cached
+func (u *User) IsReadOnlyFallback() bool {
+	return u.readOnlyFallback
+}
+
+// GetAllowedClientsAsString returns the allowed client patterns as comma separated string
+func (u *User) GetAllowedClientsAsString() string {
+	return u.Filters.ClientPolicy.GetAllowedAsString()
+}
+
+// GetDeniedClientsAsString returns the denied client patterns as comma separated string
+func (u *User) GetDeniedClientsAsString() string {
+	return u.Filters.ClientPolicy.GetDeniedAsString()
+}
+
+// IsClientAllowed returns true if the user can login using the client software
+// with the specified identification string
+func (u *User) IsClientAllowed(clientVersion string) bool {
+	return u.Filters.ClientPolicy.IsClientAllowed(clientVersion)
+}
+
 // HasExternalAuth returns true if the external authentication is globally enabled
 // and it is not disabled for this user
 func (u *User) HasExternalAuth() bool {
@@ -1675,6 +2090,12 @@ func (u *User) replaceFsConfigPlaceholders(fsConfig vfs.Filesystem, replacer *st
 		fsConfig.SFTPConfig.Prefix = u.replacePlaceholder(fsConfig.SFTPConfig.Prefix, replacer)
 	case sdk.HTTPFilesystemProvider:
 		fsConfig.HTTPConfig.Username = u.replacePlaceholder(fsConfig.HTTPConfig.Username, replacer)
+	case vfs.SMBFilesystemProvider:
+		fsConfig.SMBConfig.Username = u.replacePlaceholder(fsConfig.SMBConfig.Username, replacer)
+		fsConfig.SMBConfig.Prefix = u.replacePlaceholder(fsConfig.SMBConfig.Prefix, replacer)
+	case vfs.WebDAVFilesystemProvider:
+		fsConfig.WebDAVConfig.Username = u.replacePlaceholder(fsConfig.WebDAVConfig.Username, replacer)
+		fsConfig.WebDAVConfig.Prefix = u.replacePlaceholder(fsConfig.WebDAVConfig.Prefix, replacer)
 	}
 	return fsConfig
 }
@@ -1706,6 +2127,18 @@ func (u *User) mergeWithPrimaryGroup(group Group, replacer *strings.Replacer) {
 		u.DownloadDataTransfer = group.UserSettings.DownloadDataTransfer
 		u.TotalDataTransfer = group.UserSettings.TotalDataTransfer
 	}
+	if u.Filters.FTPPassiveHost == "" {
+		u.Filters.FTPPassiveHost = group.UserSettings.FTPPassiveHost
+	}
+	if !u.Filters.FTPModeZ {
+		u.Filters.FTPModeZ = group.UserSettings.FTPModeZ
+	}
+	if u.Filters.PasswordPolicy == "" {
+		u.Filters.PasswordPolicy = group.UserSettings.PasswordPolicy
+	}
+	if !u.Filters.TransferQuotaReset.IsEnabled() {
+		u.Filters.TransferQuotaReset = group.UserSettings.TransferQuotaReset.getACopy()
+	}
 	u.mergePrimaryGroupFilters(group.UserSettings.Filters, replacer)
 	u.mergeAdditiveProperties(group, sdk.GroupTypePrimary, replacer)
 }
@@ -1754,9 +2187,13 @@ func (u *User) mergeAdditiveProperties(group Group, groupType int, replacer *str
 	u.mergePermissions(group, groupType, replacer)
 	u.mergeFilePatterns(group, groupType, replacer)
 	u.Filters.BandwidthLimits = append(u.Filters.BandwidthLimits, group.UserSettings.Filters.BandwidthLimits...)
+	u.Filters.BandwidthSchedules = append(u.Filters.BandwidthSchedules,
+		copyBandwidthSchedules(group.UserSettings.BandwidthSchedules)...)
 	u.Filters.DataTransferLimits = append(u.Filters.DataTransferLimits, group.UserSettings.Filters.DataTransferLimits...)
 	u.Filters.AllowedIP = append(u.Filters.AllowedIP, group.UserSettings.Filters.AllowedIP...)
 	u.Filters.DeniedIP = append(u.Filters.DeniedIP, group.UserSettings.Filters.DeniedIP...)
+	u.Filters.AllowedCountries = append(u.Filters.AllowedCountries, group.UserSettings.AllowedCountries...)
+	u.Filters.DeniedCountries = append(u.Filters.DeniedCountries, group.UserSettings.DeniedCountries...)
 	u.Filters.DeniedLoginMethods = append(u.Filters.DeniedLoginMethods, group.UserSettings.Filters.DeniedLoginMethods...)
 	u.Filters.DeniedProtocols = append(u.Filters.DeniedProtocols, group.UserSettings.Filters.DeniedProtocols...)
 	u.Filters.WebClient = append(u.Filters.WebClient, group.UserSettings.Filters.WebClient...)
@@ -1820,6 +2257,8 @@ func (u *User) mergeFilePatterns(group Group, groupType int, replacer *strings.R
 func (u *User) removeDuplicatesAfterGroupMerge() {
 	u.Filters.AllowedIP = util.RemoveDuplicates(u.Filters.AllowedIP, false)
 	u.Filters.DeniedIP = util.RemoveDuplicates(u.Filters.DeniedIP, false)
+	u.Filters.AllowedCountries = util.RemoveDuplicates(u.Filters.AllowedCountries, false)
+	u.Filters.DeniedCountries = util.RemoveDuplicates(u.Filters.DeniedCountries, false)
 	u.Filters.DeniedLoginMethods = util.RemoveDuplicates(u.Filters.DeniedLoginMethods, false)
 	u.Filters.DeniedProtocols = util.RemoveDuplicates(u.Filters.DeniedProtocols, false)
 	u.Filters.WebClient = util.RemoveDuplicates(u.Filters.WebClient, false)
@@ -1868,6 +2307,45 @@ func (u *User) getACopy() User {
 			Used:   code.Used,
 		})
 	}
+	filters.WebAuthnCredentials = copyWebAuthnCredentials(u.Filters.WebAuthnCredentials)
+	filters.PushConfig.Enabled = u.Filters.PushConfig.Enabled
+	filters.PushConfig.Protocols = make([]string, len(u.Filters.PushConfig.Protocols))
+	copy(filters.PushConfig.Protocols, u.Filters.PushConfig.Protocols)
+	filters.Language = u.Filters.Language
+	filters.Theme = u.Filters.Theme
+	filters.FTPPassiveHost = u.Filters.FTPPassiveHost
+	filters.FTPModeZ = u.Filters.FTPModeZ
+	filters.PasswordPolicy = u.Filters.PasswordPolicy
+	filters.AllowedCountries = make([]string, len(u.Filters.AllowedCountries))
+	copy(filters.AllowedCountries, u.Filters.AllowedCountries)
+	filters.DeniedCountries = make([]string, len(u.Filters.DeniedCountries))
+	copy(filters.DeniedCountries, u.Filters.DeniedCountries)
+	filters.PasswordChangedAt = u.Filters.PasswordChangedAt
+	filters.PasswordHistory = make([]string, len(u.Filters.PasswordHistory))
+	copy(filters.PasswordHistory, u.Filters.PasswordHistory)
+	filters.PortForwarding = u.Filters.PortForwarding.getACopy()
+	filters.ClientPolicy = u.Filters.ClientPolicy.getACopy()
+	if len(u.Filters.UploadSizeLimits) > 0 {
+		filters.UploadSizeLimits = make([]UploadSizeLimit, 0, len(u.Filters.UploadSizeLimits))
+		for idx := range u.Filters.UploadSizeLimits {
+			filters.UploadSizeLimits = append(filters.UploadSizeLimits, u.Filters.UploadSizeLimits[idx].getACopy())
+		}
+	}
+	if len(u.Filters.MultipartUploads) > 0 {
+		filters.MultipartUploads = make([]MultipartUploadSettings, len(u.Filters.MultipartUploads))
+		copy(filters.MultipartUploads, u.Filters.MultipartUploads)
+	}
+	filters.BandwidthSchedules = copyBandwidthSchedules(u.Filters.BandwidthSchedules)
+	filters.TransferQuotaReset = u.Filters.TransferQuotaReset.getACopy()
+	if u.Filters.S3SecretAccessKey != nil {
+		filters.S3SecretAccessKey = u.Filters.S3SecretAccessKey.Clone()
+	}
+	if len(u.Filters.SFTPCredentials) > 0 {
+		filters.SFTPCredentials = make([]SFTPRemoteCredentials, 0, len(u.Filters.SFTPCredentials))
+		for idx := range u.Filters.SFTPCredentials {
+			filters.SFTPCredentials = append(filters.SFTPCredentials, u.Filters.SFTPCredentials[idx].getACopy())
+		}
+	}
 
 	return User{
 		BaseUser: sdk.BaseUser{
@@ -1903,11 +2381,15 @@ func (u *User) getACopy() User {
 			CreatedAt:                u.CreatedAt,
 			UpdatedAt:                u.UpdatedAt,
 		},
-		Filters:              filters,
-		VirtualFolders:       virtualFolders,
-		Groups:               groups,
-		FsConfig:             u.FsConfig.GetACopy(),
-		groupSettingsApplied: u.groupSettingsApplied,
+		Filters:                filters,
+		VirtualFolders:         virtualFolders,
+		Groups:                 groups,
+		FsConfig:               u.FsConfig.GetACopy(),
+		Metadata:               u.Metadata.GetACopy(),
+		SoftDeletedAt:          u.SoftDeletedAt,
+		LastTransferQuotaReset: u.LastTransferQuotaReset,
+		groupSettingsApplied:   u.groupSettingsApplied,
+		readOnlyFallback:       u.readOnlyFallback,
 	}
 }
 
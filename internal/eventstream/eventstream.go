@@ -0,0 +1,124 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package eventstream fans out filesystem and provider events to in-process
+// subscribers. Events are delivered on a best effort basis: a subscriber that
+// does not keep up loses the events that do not fit in its buffer
+package eventstream
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/sftpgo/sdk/plugin/notifier"
+)
+
+const defaultBufferSize = 256
+
+var (
+	mu          sync.RWMutex
+	subscribers = make(map[*Subscription]struct{})
+	numSubs     atomic.Int32
+)
+
+// Event defines a filesystem or a provider event, only one of the two
+// fields is set
+type Event struct {
+	FsEvent       *notifier.FsEvent
+	ProviderEvent *notifier.ProviderEvent
+}
+
+// Subscription receives the published events until closed
+type Subscription struct {
+	ch      chan Event
+	dropped atomic.Int64
+	once    sync.Once
+}
+
+// Events returns the channel to receive events from.
+// The channel is closed when the subscription is closed
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Dropped returns the number of events not delivered because the buffer was full
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close removes the subscription
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		delete(subscribers, s)
+		numSubs.Add(-1)
+		close(s.ch)
+	})
+}
+
+func (s *Subscription) send(event Event) {
+	select {
+	case s.ch <- event:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Subscribe returns a new subscription buffering up to bufferSize events.
+// If bufferSize is not positive a default value is used
+func Subscribe(bufferSize int) *Subscription {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	s := &Subscription{
+		ch: make(chan Event, bufferSize),
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	subscribers[s] = struct{}{}
+	numSubs.Add(1)
+	return s
+}
+
+// HasSubscribers returns true if there is at least a subscriber
+func HasSubscribers() bool {
+	return numSubs.Load() > 0
+}
+
+// PublishFsEvent sends the specified filesystem event to all the subscribers
+func PublishFsEvent(event *notifier.FsEvent) {
+	publish(Event{FsEvent: event})
+}
+
+// PublishProviderEvent sends the specified provider event to all the subscribers
+func PublishProviderEvent(event *notifier.ProviderEvent) {
+	publish(Event{ProviderEvent: event})
+}
+
+func publish(event Event) {
+	if !HasSubscribers() {
+		return
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for s := range subscribers {
+		s.send(event)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package eventstream
+
+import (
+	"testing"
+
+	"github.com/sftpgo/sdk/plugin/notifier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptions(t *testing.T) {
+	assert.False(t, HasSubscribers())
+	// no subscribers, this is a no-op
+	PublishFsEvent(&notifier.FsEvent{Action: "upload"})
+
+	s1 := Subscribe(0)
+	assert.Equal(t, defaultBufferSize, cap(s1.ch))
+	s2 := Subscribe(1)
+	assert.True(t, HasSubscribers())
+
+	PublishFsEvent(&notifier.FsEvent{Action: "upload", Username: "user"})
+	PublishProviderEvent(&notifier.ProviderEvent{Action: "add", ObjectType: "user", ObjectName: "user"})
+
+	event := <-s1.Events()
+	require.NotNil(t, event.FsEvent)
+	assert.Nil(t, event.ProviderEvent)
+	assert.Equal(t, "user", event.FsEvent.Username)
+	event = <-s1.Events()
+	require.NotNil(t, event.ProviderEvent)
+	assert.Nil(t, event.FsEvent)
+	assert.Equal(t, "add", event.ProviderEvent.Action)
+	assert.Equal(t, int64(0), s1.Dropped())
+	// the second subscriber has a buffer of 1, the provider event is dropped
+	event = <-s2.Events()
+	require.NotNil(t, event.FsEvent)
+	assert.Equal(t, int64(1), s2.Dropped())
+
+	s1.Close()
+	s1.Close()
+	_, ok := <-s1.Events()
+	assert.False(t, ok)
+	assert.True(t, HasSubscribers())
+	s2.Close()
+	assert.False(t, HasSubscribers())
+	// publishing after close must not panic
+	PublishProviderEvent(&notifier.ProviderEvent{Action: "delete"})
+}
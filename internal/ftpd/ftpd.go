@@ -25,6 +25,7 @@ import (
 	ftpserver "github.com/fclairamb/ftpserverlib"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
@@ -73,6 +74,9 @@ type Binding struct {
 	// PassiveIPOverrides allows to define different IP addresses to expose for passive connections
 	// based on the client IP address
 	PassiveIPOverrides []PassiveIPOverride `json:"passive_ip_overrides" mapstructure:"passive_ip_overrides"`
+	// Port range for passive data connections for this binding.
+	// If not set, the global passive port range will be used
+	PassivePortRange PortRange `json:"passive_port_range" mapstructure:"passive_port_range"`
 	// Set to 1 to require client certificate authentication.
 	// Set to 2 to require a client certificate and verfify it if given. In this mode
 	// the client is allowed not to send a certificate.
@@ -100,8 +104,27 @@ type Binding struct {
 	// on active data connections, so change the default value only if you are on a trusted/internal network
 	ActiveConnectionsSecurity int `json:"active_connections_security" mapstructure:"active_connections_security"`
 	// Debug enables the FTP debug mode. In debug mode, every FTP command will be logged
-	Debug   bool `json:"debug" mapstructure:"debug"`
-	ciphers []uint16
+	Debug bool `json:"debug" mapstructure:"debug"`
+	// Client software allowed or denied for this binding. The client identification is
+	// the value sent using the CLNT command, empty if the client does not send it.
+	// The client identification is checked at login
+	ClientPolicy dataprovider.ClientPolicy `json:"client_policy" mapstructure:"client_policy"`
+	// Set to true to return the same error for all the failed logins, regardless of
+	// the failure reason, and to check the password of unknown users against a dummy
+	// hash, so that the existing users cannot be enumerated
+	UniformAuthErrors bool `json:"uniform_auth_errors" mapstructure:"uniform_auth_errors"`
+	ciphers           []uint16
+	// ISO 3166-1 alpha-2 country codes allowed to connect to this binding. If set,
+	// only clients geolocated in the listed countries are allowed
+	AllowedCountries []string `json:"allowed_countries" mapstructure:"allowed_countries"`
+	// ISO 3166-1 alpha-2 country codes denied to connect to this binding
+	DeniedCountries []string `json:"denied_countries" mapstructure:"denied_countries"`
+	// Set to true to support the MODE Z command, transfer compression must be
+	// allowed for the users too. MODE Z is not available after AUTH TLS
+	EnableModeZ bool `json:"enable_mode_z" mapstructure:"enable_mode_z"`
+	// Compression level for MODE Z transfers, from 1 (best speed) to 9 (best compression).
+	// 0 means the default compression level
+	ModeZLevel int `json:"mode_z_level" mapstructure:"mode_z_level"`
 }
 
 func (b *Binding) setCiphers() {
@@ -135,6 +158,22 @@ func (b *Binding) checkSecuritySettings() error {
 	return nil
 }
 
+func (b *Binding) checkModeZ() error {
+	if b.ModeZLevel < 0 || b.ModeZLevel > 9 {
+		return fmt.Errorf("invalid mode_z_level: %v", b.ModeZLevel)
+	}
+	if !b.EnableModeZ {
+		return nil
+	}
+	if b.TLSMode == 1 {
+		return errors.New("MODE Z cannot be enabled if explicit TLS is required")
+	}
+	if b.TLSMode == 2 && b.isMutualTLSEnabled() {
+		return errors.New("MODE Z cannot be enabled with implicit TLS and client certificates")
+	}
+	return nil
+}
+
 func (b *Binding) checkPassiveIP() error {
 	if b.ForcePassiveIP != "" {
 		ip, err := parsePassiveIP(b.ForcePassiveIP)
@@ -166,6 +205,31 @@ func (b *Binding) checkPassiveIP() error {
 	return nil
 }
 
+// HasPassivePortRange returns true if a passive port range is defined for this binding
+func (b *Binding) HasPassivePortRange() bool {
+	return b.PassivePortRange.Start > 0 || b.PassivePortRange.End > 0
+}
+
+func (b *Binding) checkPassivePortRange() error {
+	if !b.HasPassivePortRange() {
+		return nil
+	}
+	if !b.PassivePortRange.isValid() {
+		return fmt.Errorf("invalid passive port range %v for binding %q", b.PassivePortRange.String(), b.GetAddress())
+	}
+	return nil
+}
+
+func (b *Binding) getPassivePortRange(globalRange PortRange) *PortRange {
+	if b.HasPassivePortRange() {
+		return &b.PassivePortRange
+	}
+	if globalRange.Start > 0 && globalRange.End > globalRange.Start {
+		return &globalRange
+	}
+	return nil
+}
+
 func (b *Binding) getPassiveIP(cc ftpserver.ClientContext) string {
 	if b.ForcePassiveIP != "" {
 		return b.ForcePassiveIP
@@ -220,6 +284,23 @@ type PortRange struct {
 	End int `json:"end" mapstructure:"end"`
 }
 
+func (r *PortRange) isValid() bool {
+	return r.Start > 0 && r.End > r.Start && r.End <= 65535
+}
+
+func (r *PortRange) overlaps(other *PortRange) bool {
+	return r.Start <= other.End && other.Start <= r.End
+}
+
+func (r *PortRange) contains(port int) bool {
+	return port >= r.Start && port <= r.End
+}
+
+// String returns the port range as string
+func (r *PortRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
 // ServiceStatus defines the service status
 type ServiceStatus struct {
 	IsActive         bool      `json:"is_active"`
@@ -306,12 +387,71 @@ func (c *Configuration) getKeyPairs(configDir string) []common.TLSKeyPair {
 	return keyPairs
 }
 
+// checkPassivePortRanges returns an error if a passive port range is not valid or
+// if it conflicts with another configured range or with a listening port.
+// Bindings without a specific range share the global one
+func (c *Configuration) checkPassivePortRanges() error {
+	type bindingRange struct {
+		portRange *PortRange
+		owner     string
+	}
+	var ranges []bindingRange
+	usesGlobalRange := false
+
+	for idx := range c.Bindings {
+		binding := &c.Bindings[idx]
+		if !binding.IsValid() {
+			continue
+		}
+		if err := binding.checkPassivePortRange(); err != nil {
+			return err
+		}
+		portRange := binding.getPassivePortRange(c.PassivePortRange)
+		if portRange == nil {
+			continue
+		}
+		if !binding.HasPassivePortRange() {
+			usesGlobalRange = true
+			continue
+		}
+		ranges = append(ranges, bindingRange{
+			portRange: portRange,
+			owner:     fmt.Sprintf("binding %q", binding.GetAddress()),
+		})
+	}
+	if usesGlobalRange {
+		ranges = append(ranges, bindingRange{
+			portRange: &c.PassivePortRange,
+			owner:     "the global configuration",
+		})
+	}
+
+	for idx, r := range ranges {
+		for _, other := range ranges[idx+1:] {
+			if r.portRange.overlaps(other.portRange) {
+				return fmt.Errorf("passive port range %v defined for %s overlaps with range %v defined for %s",
+					r.portRange.String(), r.owner, other.portRange.String(), other.owner)
+			}
+		}
+		for _, binding := range c.Bindings {
+			if binding.IsValid() && r.portRange.contains(binding.Port) {
+				return fmt.Errorf("passive port range %v defined for %s includes the port %d used by binding %q",
+					r.portRange.String(), r.owner, binding.Port, binding.GetAddress())
+			}
+		}
+	}
+	return nil
+}
+
 // Initialize configures and starts the FTP server
 func (c *Configuration) Initialize(configDir string) error {
 	logger.Info(logSender, "", "initializing FTP server with config %+v", *c)
 	if !c.ShouldBind() {
 		return common.ErrNoBinding
 	}
+	if err := c.checkPassivePortRanges(); err != nil {
+		return err
+	}
 
 	keyPairs := c.getKeyPairs(configDir)
 	if len(keyPairs) > 0 {
@@ -385,6 +525,23 @@ func parsePassiveIP(passiveIP string) (string, error) {
 	return ip.String(), nil
 }
 
+// resolvePassiveHost returns the IPv4 address for the specified passive IP or hostname
+func resolvePassiveHost(host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return parsePassiveIP(host)
+	}
+	addrs, err := net.LookupIP(host)
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if ip := addr.To4(); ip != nil {
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no IPv4 address found for host %q", host)
+}
+
 func getConfigPath(name, configDir string) string {
 	if !util.IsFileInputValid(name) {
 		return ""
@@ -15,6 +15,8 @@
 package ftpd_test
 
 import (
+	"bytes"
+	"compress/zlib"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/tls"
@@ -62,6 +64,7 @@ const (
 	ftpServerAddr   = "127.0.0.1:2121"
 	sftpServerAddr  = "127.0.0.1:2122"
 	ftpSrvAddrTLS   = "127.0.0.1:2124" // ftp server with implicit tls
+	ftpSrvAddrModeZ = "127.0.0.1:2125" // ftp server with MODE Z enabled
 	defaultUsername = "test_user_ftp"
 	defaultPassword = "test_password"
 	osWindows       = "windows"
@@ -412,6 +415,10 @@ func TestMain(m *testing.M) {
 			Port:    2124,
 			TLSMode: 2,
 		},
+		{
+			Port:        2125,
+			EnableModeZ: true,
+		},
 	}
 	ftpdConf.CertificateFile = certPath
 	ftpdConf.CertificateKeyFile = keyPath
@@ -431,6 +438,7 @@ func TestMain(m *testing.M) {
 	}()
 
 	waitTCPListening(ftpdConf.Bindings[0].GetAddress())
+	waitTCPListening(ftpdConf.Bindings[1].GetAddress())
 	waitNoConnections()
 	startHTTPFs()
 
@@ -898,11 +906,11 @@ func TestAnonymousUser(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, user.Filters.IsAnonymous)
 	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermDownload}, user.Permissions["/"])
-	assert.Equal(t, []string{common.ProtocolSSH, common.ProtocolHTTP}, user.Filters.DeniedProtocols)
+	assert.Equal(t, []string{common.ProtocolSSH, common.ProtocolHTTP, common.ProtocolS3}, user.Filters.DeniedProtocols)
 	assert.Equal(t, []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
 		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndPassword,
 		dataprovider.SSHLoginMethodKeyAndKeyboardInt, dataprovider.LoginMethodTLSCertificate,
-		dataprovider.LoginMethodTLSCertificateAndPwd}, user.Filters.DeniedLoginMethods)
+		dataprovider.LoginMethodTLSCertificateAndPwd, dataprovider.SSHLoginMethodGSSAPI}, user.Filters.DeniedLoginMethods)
 
 	user.Password = emptyPwdPlaceholder
 	client, err := getFTPClient(user, true, nil)
@@ -1379,11 +1387,11 @@ func TestPreLoginHookReturningAnonymousUser(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, user.Filters.IsAnonymous)
 	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermDownload}, user.Permissions["/"])
-	assert.Equal(t, []string{common.ProtocolSSH, common.ProtocolHTTP}, user.Filters.DeniedProtocols)
+	assert.Equal(t, []string{common.ProtocolSSH, common.ProtocolHTTP, common.ProtocolS3}, user.Filters.DeniedProtocols)
 	assert.Equal(t, []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
 		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndPassword,
 		dataprovider.SSHLoginMethodKeyAndKeyboardInt, dataprovider.LoginMethodTLSCertificate,
-		dataprovider.LoginMethodTLSCertificateAndPwd}, user.Filters.DeniedLoginMethods)
+		dataprovider.LoginMethodTLSCertificateAndPwd, dataprovider.SSHLoginMethodGSSAPI}, user.Filters.DeniedLoginMethods)
 	// now the same with an existing user
 	client, err = getFTPClient(u, false, nil)
 	if assert.NoError(t, err) {
@@ -2107,6 +2115,49 @@ func TestResume(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestZeroCopyDownload(t *testing.T) {
+	zeroCopyConfig := common.Config.ZeroCopyDownloads
+	common.Config.ZeroCopyDownloads.Enabled = true
+	defer func() {
+		common.Config.ZeroCopyDownloads = zeroCopyConfig
+	}()
+
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	for _, useTLS := range []bool{false, true} {
+		client, err := getFTPClient(user, useTLS, nil)
+		if assert.NoError(t, err) {
+			testFilePath := filepath.Join(homeBasePath, testFileName)
+			testFileSize := int64(2621440)
+			err = createTestFile(testFilePath, testFileSize)
+			assert.NoError(t, err)
+			err = ftpUploadFile(testFilePath, testFileName, testFileSize, client, 0)
+			assert.NoError(t, err)
+			localDownloadPath := filepath.Join(homeBasePath, testDLFileName)
+			err = ftpDownloadFile(testFileName, localDownloadPath, testFileSize, client, 0)
+			assert.NoError(t, err)
+			expectedHash, err := computeHashForFile(sha256.New(), testFilePath)
+			assert.NoError(t, err)
+			actualHash, err := computeHashForFile(sha256.New(), localDownloadPath)
+			assert.NoError(t, err)
+			assert.Equal(t, expectedHash, actualHash)
+			// resume
+			err = ftpDownloadFile(testFileName, localDownloadPath, testFileSize-1024, client, 1024)
+			assert.NoError(t, err)
+			err = client.Quit()
+			assert.NoError(t, err)
+			err = os.Remove(testFilePath)
+			assert.NoError(t, err)
+			err = os.Remove(localDownloadPath)
+			assert.NoError(t, err)
+		}
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 //nolint:dupl
 func TestDeniedLoginMethod(t *testing.T) {
 	u := getTestUser()
@@ -2260,6 +2311,48 @@ func TestQuotaLimits(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestUploadDeclaredSize(t *testing.T) {
+	u := getTestUser()
+	u.QuotaSize = 10000
+	u.Filters.MaxUploadFileSize = 65536
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	testFileSize := int64(5000)
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+	client, err := getFTPClient(user, true, nil)
+	if assert.NoError(t, err) {
+		code, _, err := client.SendCustomCommand("ALLO 70000")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFileUnavailable, code)
+		code, _, err = client.SendCustomCommand("ALLO 20000")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		// the declared size exceeds the quota, the upload is denied before transferring any data
+		err = ftpUploadFile(testFilePath, testFileName, testFileSize, client, 0)
+		assert.Error(t, err)
+		_, err = client.FileSize(testFileName)
+		assert.Error(t, err)
+		// the allocated size applies to the next upload only
+		err = ftpUploadFile(testFilePath, testFileName, testFileSize, client, 0)
+		assert.NoError(t, err)
+		code, _, err = client.SendCustomCommand("ALLO 5000")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		err = ftpUploadFile(testFilePath, testFileName+"1", testFileSize, client, 0)
+		assert.NoError(t, err)
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestUploadMaxSize(t *testing.T) {
 	testFileSize := int64(65535)
 	u := getTestUser()
@@ -2769,6 +2862,14 @@ func TestAllocateAvailable(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, ftp.StatusCommandOK, code)
 		assert.Equal(t, "Done !", response)
+		// the allocated size exceeds the quota
+		err = ftpUploadFile(testFilePath, testFileName, testFileSize, client, 0)
+		assert.Error(t, err)
+
+		code, response, err = client.SendCustomCommand(fmt.Sprintf("allo %d", testFileSize))
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		assert.Equal(t, "Done !", response)
 
 		err = ftpUploadFile(testFilePath, testFileName, testFileSize, client, 0)
 		assert.NoError(t, err)
@@ -2837,10 +2938,11 @@ func TestAllocateAvailable(t *testing.T) {
 	assert.NoError(t, err)
 	client, err = getFTPClient(user, false, nil)
 	if assert.NoError(t, err) {
+		// the allocated size exceeds the max upload file size
 		code, response, err := client.SendCustomCommand("allo 10000")
 		assert.NoError(t, err)
-		assert.Equal(t, ftp.StatusCommandOK, code)
-		assert.Equal(t, "Done !", response)
+		assert.Equal(t, ftp.StatusFileUnavailable, code)
+		assert.Contains(t, response, ftpserver.ErrStorageExceeded.Error())
 
 		code, response, err = client.SendCustomCommand("AVBL")
 		assert.NoError(t, err)
@@ -3146,6 +3248,108 @@ func TestSITEDisabled(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestModeZ(t *testing.T) {
+	u := getTestUser()
+	u.QuotaSize = 10 * 1024 * 1024
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	client, err := getFTPClientModeZ(user)
+	if assert.NoError(t, err) {
+		code, response, err := client.SendCustomCommand("MODE Z")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusNotImplementedParameter, code)
+		assert.Contains(t, response, "MODE Z is not allowed")
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+	user.Filters.FTPModeZ = true
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	client, err = getFTPClientModeZ(user)
+	if assert.NoError(t, err) {
+		code, _, err := client.SendCustomCommand("MODE Z")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+
+		content := bytes.Repeat([]byte("compressed FTP transfer "), 20000)
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		_, err = zw.Write(content)
+		assert.NoError(t, err)
+		err = zw.Close()
+		assert.NoError(t, err)
+		err = client.Stor(testFileName, bytes.NewReader(compressed.Bytes()))
+		assert.NoError(t, err)
+		info, err := os.Stat(filepath.Join(user.GetHomeDir(), testFileName))
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(len(content)), info.Size())
+		}
+		// quota is updated using the uncompressed size
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, user.UsedQuotaFiles)
+		assert.Equal(t, int64(len(content)), user.UsedQuotaSize)
+
+		resp, err := client.Retr(testFileName)
+		if assert.NoError(t, err) {
+			data, err := io.ReadAll(resp)
+			assert.NoError(t, err)
+			err = resp.Close()
+			assert.NoError(t, err)
+			assert.Less(t, len(data), len(content))
+			zr, err := zlib.NewReader(bytes.NewReader(data))
+			if assert.NoError(t, err) {
+				data, err = io.ReadAll(zr)
+				assert.NoError(t, err)
+				assert.Equal(t, content, data)
+			}
+		}
+		// HASH is computed on the uncompressed contents
+		hash := sha256.Sum256(content)
+		code, response, err := client.SendCustomCommand(fmt.Sprintf("XSHA256 %v", testFileName))
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusRequestedFileActionOK, code)
+		assert.Contains(t, response, hex.EncodeToString(hash[:]))
+
+		_, err = client.List("/")
+		assert.Error(t, err)
+		_, err = client.RetrFrom(testFileName, 10)
+		assert.ErrorContains(t, err, "resuming transfers is not supported in MODE Z")
+		err = client.Stor(testFileName+"_1", bytes.NewReader([]byte("uncompressed data")))
+		assert.Error(t, err)
+
+		code, _, err = client.SendCustomCommand("TYPE A")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		_, err = client.Retr(testFileName)
+		assert.ErrorContains(t, err, "ASCII transfers are not supported in MODE Z")
+		code, _, err = client.SendCustomCommand("TYPE I")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+
+		code, _, err = client.SendCustomCommand("MODE S")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		resp, err = client.Retr(testFileName)
+		if assert.NoError(t, err) {
+			data, err := io.ReadAll(resp)
+			assert.NoError(t, err)
+			err = resp.Close()
+			assert.NoError(t, err)
+			assert.Equal(t, content, data)
+		}
+		_, err = client.List("/")
+		assert.NoError(t, err)
+
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestHASH(t *testing.T) {
 	u := getTestUser()
 	localUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
@@ -3769,6 +3973,22 @@ func getFTPClientImplicitTLS(user dataprovider.User) (*ftp.ServerConn, error) {
 	return client, err
 }
 
+func getFTPClientModeZ(user dataprovider.User) (*ftp.ServerConn, error) {
+	client, err := ftp.Dial(ftpSrvAddrModeZ, ftp.DialWithTimeout(5*time.Second))
+	if err != nil {
+		return nil, err
+	}
+	pwd := defaultPassword
+	if user.Password != "" {
+		pwd = user.Password
+	}
+	err = client.Login(user.Username, pwd)
+	if err != nil {
+		return nil, err
+	}
+	return client, err
+}
+
 func getFTPClient(user dataprovider.User, useTLS bool, tlsConfig *tls.Config, dialOptions ...ftp.DialOption,
 ) (*ftp.ServerConn, error) {
 	ftpOptions := []ftp.DialOption{ftp.DialWithTimeout(5 * time.Second)}
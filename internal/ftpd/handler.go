@@ -21,6 +21,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	ftpserver "github.com/fclairamb/ftpserverlib"
@@ -29,6 +30,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
@@ -43,6 +45,10 @@ type Connection struct {
 	*common.BaseConnection
 	clientContext     ftpserver.ClientContext
 	doWildcardListDir bool
+	// size declared using the ALLO command, it applies to the next upload
+	allocatedSize atomic.Int64
+	// control connection wrapper, set if MODE Z is enabled for the binding
+	modeZ *modeZConn
 }
 
 func (c *Connection) getFTPMode() string {
@@ -226,11 +232,13 @@ func (c *Connection) GetAvailableSpace(dirName string) (int64, error) {
 	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
 		return 0, nil
 	}
+	// the file name is not known, limits for specific extensions are ignored
+	maxUploadFileSize := c.User.GetMaxUploadFileSize(path.Join(dirName, "fakefile"))
 
 	if diskQuota.AllowedSize == 0 && transferQuota.AllowedULSize == 0 && transferQuota.AllowedTotalSize == 0 {
 		// no quota restrictions
-		if c.User.Filters.MaxUploadFileSize > 0 {
-			return c.User.Filters.MaxUploadFileSize, nil
+		if maxUploadFileSize > 0 {
+			return maxUploadFileSize, nil
 		}
 
 		fs, p, err := c.GetFsAndResolvedPath(dirName)
@@ -260,19 +268,31 @@ func (c *Connection) GetAvailableSpace(dirName string) (int64, error) {
 	}
 	// the available space is the minimum between MaxUploadFileSize, if setted,
 	// and quota allowed size
-	if c.User.Filters.MaxUploadFileSize > 0 {
-		if c.User.Filters.MaxUploadFileSize < allowedSize {
-			return c.User.Filters.MaxUploadFileSize, nil
+	if maxUploadFileSize > 0 {
+		if maxUploadFileSize < allowedSize {
+			return maxUploadFileSize, nil
 		}
 	}
 
 	return allowedSize, nil
 }
 
-// AllocateSpace implements ClientDriverExtensionAllocate interface
+// AllocateSpace implements ClientDriverExtensionAllocate interface.
+// The declared size is checked against the user's limits and the next
+// upload is denied before transferring any data if it does not fit
 func (c *Connection) AllocateSpace(size int) error {
 	c.UpdateLastActivity()
-	// we treat ALLO as NOOP see RFC 959
+
+	c.allocatedSize.Store(0)
+	if size <= 0 {
+		return nil
+	}
+	// the upload path is not yet known, the path specific limits are checked when the upload starts
+	maxUploadFileSize := c.User.GetHighestMaxUploadFileSize()
+	if err := c.CheckDeclaredUploadSize(int64(size), maxUploadFileSize, c.GetTransferQuota()); err != nil {
+		return err
+	}
+	c.allocatedSize.Store(int64(size))
 	return nil
 }
 
@@ -321,12 +341,53 @@ func (c *Connection) GetHandle(name string, flags int, offset int64) (ftpserver.
 		return nil, errCOMBNotSupported
 	}
 
+	if c.isModeZTransfer() {
+		return c.getModeZHandle(fs, p, name, flags, offset)
+	}
+
 	if flags&os.O_WRONLY != 0 {
 		return c.uploadFile(fs, p, name, flags)
 	}
 	return c.downloadFile(fs, p, name, offset)
 }
 
+// isModeZTransfer returns true if MODE Z is enabled and the handle is
+// requested for a data transfer, HASH and COMB use uncompressed handles
+func (c *Connection) isModeZTransfer() bool {
+	if c.modeZ == nil || !c.modeZ.isEnabled() {
+		return false
+	}
+	return util.Contains([]string{"RETR", "STOR", "APPE"}, c.GetCommand())
+}
+
+func (c *Connection) getModeZHandle(fs vfs.Fs, fsPath, ftpPath string, flags int, offset int64) (ftpserver.FileTransfer, error) {
+	if offset > 0 {
+		return nil, errModeZRestart
+	}
+	if c.modeZ.isASCII() {
+		return nil, errModeZASCII
+	}
+	if flags&os.O_WRONLY != 0 {
+		handle, err := c.uploadFile(fs, fsPath, ftpPath, flags)
+		if err != nil {
+			return nil, err
+		}
+		return newModeZUpload(handle.(*transfer)), nil
+	}
+	handle, err := c.downloadFile(fs, fsPath, ftpPath, offset)
+	if err != nil {
+		return nil, err
+	}
+	t := handle.(*transfer)
+	d, err := newModeZDownload(t, c.modeZ.level)
+	if err != nil {
+		t.TransferError(err)
+		t.Close() //nolint:errcheck
+		return nil, err
+	}
+	return d, nil
+}
+
 func (c *Connection) downloadFile(fs vfs.Fs, fsPath, ftpPath string, offset int64) (ftpserver.FileTransfer, error) {
 	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(ftpPath)) {
 		return nil, c.GetPermissionDeniedError()
@@ -362,6 +423,7 @@ func (c *Connection) downloadFile(fs vfs.Fs, fsPath, ftpPath string, offset int6
 }
 
 func (c *Connection) uploadFile(fs vfs.Fs, fsPath, ftpPath string, flags int) (ftpserver.FileTransfer, error) {
+	declaredSize := c.allocatedSize.Swap(0)
 	if ok, _ := c.User.IsFileAllowed(ftpPath); !ok {
 		c.Log(logger.LevelWarn, "writing file %#v is not allowed", ftpPath)
 		return nil, ftpserver.ErrFileNameNotAllowed
@@ -377,7 +439,7 @@ func (c *Connection) uploadFile(fs vfs.Fs, fsPath, ftpPath string, flags int) (f
 		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(ftpPath)) {
 			return nil, fmt.Errorf("%w, no upload permission", ftpserver.ErrFileNameNotAllowed)
 		}
-		return c.handleFTPUploadToNewFile(fs, flags, fsPath, filePath, ftpPath)
+		return c.handleFTPUploadToNewFile(fs, flags, fsPath, filePath, ftpPath, declaredSize)
 	}
 
 	if statErr != nil {
@@ -395,20 +457,27 @@ func (c *Connection) uploadFile(fs vfs.Fs, fsPath, ftpPath string, flags int) (f
 		return nil, fmt.Errorf("%w, no overwrite permission", ftpserver.ErrFileNameNotAllowed)
 	}
 
-	return c.handleFTPUploadToExistingFile(fs, flags, fsPath, filePath, stat.Size(), ftpPath)
+	return c.handleFTPUploadToExistingFile(fs, flags, fsPath, filePath, stat.Size(), ftpPath, declaredSize)
 }
 
-func (c *Connection) handleFTPUploadToNewFile(fs vfs.Fs, flags int, resolvedPath, filePath, requestPath string) (ftpserver.FileTransfer, error) {
+func (c *Connection) handleFTPUploadToNewFile(fs vfs.Fs, flags int, resolvedPath, filePath, requestPath string,
+	declaredSize int64,
+) (ftpserver.FileTransfer, error) {
 	diskQuota, transferQuota := c.HasSpace(true, false, requestPath)
 	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
 		c.Log(logger.LevelInfo, "denying file write due to quota limits")
 		return nil, ftpserver.ErrStorageExceeded
 	}
+	// we can get an error only for resume
+	maxWriteSize, _ := c.GetMaxWriteSize(requestPath, diskQuota, false, 0, fs.IsUploadResumeSupported())
+	if err := c.CheckDeclaredUploadSize(declaredSize, maxWriteSize, transferQuota); err != nil {
+		return nil, err
+	}
 	if err := common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath, 0, 0); err != nil {
 		c.Log(logger.LevelDebug, "upload for file %#v denied by pre action: %v", requestPath, err)
 		return nil, fmt.Errorf("%w, denied by pre-upload action", ftpserver.ErrFileNameNotAllowed)
 	}
-	file, w, cancelFn, err := fs.Create(filePath, flags)
+	file, w, cancelFn, err := vfs.CreateWithSize(fs, filePath, flags, declaredSize)
 	if err != nil {
 		c.Log(logger.LevelError, "error creating file %#v, flags %v: %+v", resolvedPath, flags, err)
 		return nil, c.GetFsError(fs, err)
@@ -416,9 +485,6 @@ func (c *Connection) handleFTPUploadToNewFile(fs vfs.Fs, flags int, resolvedPath
 
 	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
 
-	// we can get an error only for resume
-	maxWriteSize, _ := c.GetMaxWriteSize(diskQuota, false, 0, fs.IsUploadResumeSupported())
-
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, filePath, requestPath,
 		common.TransferUpload, 0, 0, maxWriteSize, 0, true, fs, transferQuota)
 	baseTransfer.SetFtpMode(c.getFTPMode())
@@ -428,7 +494,7 @@ func (c *Connection) handleFTPUploadToNewFile(fs vfs.Fs, flags int, resolvedPath
 }
 
 func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolvedPath, filePath string, fileSize int64,
-	requestPath string) (ftpserver.FileTransfer, error) {
+	requestPath string, declaredSize int64) (ftpserver.FileTransfer, error) {
 	var err error
 	diskQuota, transferQuota := c.HasSpace(false, false, requestPath)
 	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
@@ -444,11 +510,14 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 	isResume := flags&os.O_TRUNC == 0
 	// if there is a size limit remaining size cannot be 0 here, since quotaResult.HasSpace
 	// will return false in this case and we deny the upload before
-	maxWriteSize, err := c.GetMaxWriteSize(diskQuota, isResume, fileSize, fs.IsUploadResumeSupported())
+	maxWriteSize, err := c.GetMaxWriteSize(requestPath, diskQuota, isResume, fileSize, fs.IsUploadResumeSupported())
 	if err != nil {
 		c.Log(logger.LevelDebug, "unable to get max write size: %v", err)
 		return nil, err
 	}
+	if err := c.CheckDeclaredUploadSize(declaredSize, maxWriteSize, transferQuota); err != nil {
+		return nil, err
+	}
 	if err := common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath, fileSize, flags); err != nil {
 		c.Log(logger.LevelDebug, "upload for file %#v denied by pre action: %v", requestPath, err)
 		return nil, fmt.Errorf("%w, denied by pre-upload action", ftpserver.ErrFileNameNotAllowed)
@@ -463,7 +532,7 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 		}
 	}
 
-	file, w, cancelFn, err := fs.Create(filePath, flags)
+	file, w, cancelFn, err := vfs.CreateWithSize(fs, filePath, flags, declaredSize)
 	if err != nil {
 		c.Log(logger.LevelError, "error opening existing file, flags: %v, source: %#v, err: %+v", flags, filePath, err)
 		return nil, c.GetFsError(fs, err)
@@ -483,7 +552,7 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 		if vfs.HasTruncateSupport(fs) {
 			vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
 			if err == nil {
-				dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
+				dataprovider.UpdateVirtualFolderQuotaForUser(&vfolder, c.User.Username, 0, -fileSize) //nolint:errcheck
 				if vfolder.IsIncludedInUserQuota() {
 					dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
 				}
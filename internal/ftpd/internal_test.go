@@ -15,15 +15,20 @@
 package ftpd
 
 import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"net"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -781,7 +786,7 @@ func TestUploadOverwriteErrors(t *testing.T) {
 	}
 	flags := 0
 	flags |= os.O_APPEND
-	_, err := connection.handleFTPUploadToExistingFile(fs, flags, "", "", 0, "")
+	_, err := connection.handleFTPUploadToExistingFile(fs, flags, "", "", 0, "", 0)
 	if assert.Error(t, err) {
 		assert.EqualError(t, err, common.ErrOpUnsupported.Error())
 	}
@@ -793,7 +798,7 @@ func TestUploadOverwriteErrors(t *testing.T) {
 	flags = 0
 	flags |= os.O_CREATE
 	flags |= os.O_TRUNC
-	tr, err := connection.handleFTPUploadToExistingFile(fs, flags, f.Name(), f.Name(), 123, f.Name())
+	tr, err := connection.handleFTPUploadToExistingFile(fs, flags, f.Name(), f.Name(), 123, f.Name(), 0)
 	if assert.NoError(t, err) {
 		transfer := tr.(*transfer)
 		transfers := connection.GetTransfers()
@@ -809,10 +814,10 @@ func TestUploadOverwriteErrors(t *testing.T) {
 	assert.NoError(t, err)
 
 	_, err = connection.handleFTPUploadToExistingFile(fs, os.O_TRUNC, filepath.Join(os.TempDir(), "sub", "file"),
-		filepath.Join(os.TempDir(), "sub", "file1"), 0, "/sub/file1")
+		filepath.Join(os.TempDir(), "sub", "file1"), 0, "/sub/file1", 0)
 	assert.Error(t, err)
 	fs = vfs.NewOsFs(connID, user.GetHomeDir(), "")
-	_, err = connection.handleFTPUploadToExistingFile(fs, 0, "missing1", "missing2", 0, "missing")
+	_, err = connection.handleFTPUploadToExistingFile(fs, 0, "missing1", "missing2", 0, "missing", 0)
 	assert.Error(t, err)
 }
 
@@ -1034,6 +1039,136 @@ func TestPassiveIPResolver(t *testing.T) {
 	assert.Equal(t, b.ForcePassiveIP, passiveIP)
 }
 
+func TestUserPassiveHost(t *testing.T) {
+	b := Binding{
+		Port:           2121,
+		ForcePassiveIP: "192.168.2.1",
+	}
+	c := &Configuration{
+		Bindings: []Binding{b},
+	}
+	server := NewServer(c, configDir, b, 0)
+	mockCC := mockFTPClientContext{}
+	passiveIP, err := server.passiveIPResolver(mockCC)
+	assert.NoError(t, err)
+	assert.Equal(t, b.ForcePassiveIP, passiveIP)
+	server.setPassiveHost(mockCC.ID(), "172.16.1.2")
+	passiveIP, err = server.passiveIPResolver(mockCC)
+	assert.NoError(t, err)
+	assert.Equal(t, "172.16.1.2", passiveIP)
+	server.setPassiveHost(mockCC.ID(), "localhost")
+	passiveIP, err = server.passiveIPResolver(mockCC)
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", passiveIP)
+	// unresolvable hosts fallback to the binding settings
+	server.setPassiveHost(mockCC.ID(), "invalid.invalid")
+	passiveIP, err = server.passiveIPResolver(mockCC)
+	assert.NoError(t, err)
+	assert.Equal(t, b.ForcePassiveIP, passiveIP)
+	server.cleanTLSConnVerification(mockCC.ID())
+	assert.Empty(t, server.getPassiveHost(mockCC.ID()))
+	server.setPassiveHost(mockCC.ID(), "172.16.1.2")
+	server.setPassiveHost(mockCC.ID(), "")
+	assert.Empty(t, server.getPassiveHost(mockCC.ID()))
+
+	_, err = resolvePassiveHost("::1")
+	assert.Error(t, err)
+}
+
+func TestPassivePortRanges(t *testing.T) {
+	c := &Configuration{
+		Bindings: []Binding{
+			{
+				Port: 2121,
+			},
+			{
+				Port: 2122,
+				PassivePortRange: PortRange{
+					Start: 51000,
+					End:   51100,
+				},
+			},
+			{
+				Port: 2123,
+				PassivePortRange: PortRange{
+					Start: 52000,
+					End:   52100,
+				},
+			},
+		},
+		PassivePortRange: PortRange{
+			Start: 50000,
+			End:   50100,
+		},
+	}
+	assert.NoError(t, c.checkPassivePortRanges())
+	assert.Equal(t, c.PassivePortRange, *c.Bindings[0].getPassivePortRange(c.PassivePortRange))
+	assert.Equal(t, c.Bindings[1].PassivePortRange, *c.Bindings[1].getPassivePortRange(c.PassivePortRange))
+
+	c.Bindings[2].PassivePortRange.Start = 51100
+	err := c.checkPassivePortRanges()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "overlaps with range 51100-52100")
+	}
+	c.Bindings[2].PassivePortRange = PortRange{
+		Start: 50050,
+		End:   50150,
+	}
+	err = c.checkPassivePortRanges()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "the global configuration")
+	}
+	// the global range is not used if all the bindings have their own range
+	c.Bindings[0].PassivePortRange = PortRange{
+		Start: 53000,
+		End:   53100,
+	}
+	assert.NoError(t, c.checkPassivePortRanges())
+	c.Bindings[2].PassivePortRange = PortRange{
+		Start: 2100,
+		End:   2200,
+	}
+	err = c.checkPassivePortRanges()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "includes the port 2121")
+	}
+	c.Bindings[2].PassivePortRange = PortRange{
+		Start: 0,
+		End:   52100,
+	}
+	err = c.checkPassivePortRanges()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid passive port range")
+	}
+	c.Bindings[2].PassivePortRange = PortRange{
+		Start: 65000,
+		End:   65536,
+	}
+	err = c.checkPassivePortRanges()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid passive port range")
+	}
+	server := NewServer(c, configDir, c.Bindings[2], 2)
+	_, err = server.GetSettings()
+	assert.Error(t, err)
+
+	c.Bindings[2].PassivePortRange = PortRange{}
+	server = NewServer(c, configDir, c.Bindings[1], 1)
+	settings, err := server.GetSettings()
+	require.NoError(t, err)
+	require.NotNil(t, settings.PassiveTransferPortRange)
+	assert.Equal(t, 51000, settings.PassiveTransferPortRange.Start)
+	assert.Equal(t, 51100, settings.PassiveTransferPortRange.End)
+	c.Bindings[2].PassivePortRange = PortRange{
+		Start: 51050,
+		End:   51150,
+	}
+	err = c.Initialize(configDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "overlaps with range")
+	}
+}
+
 func TestRelativePath(t *testing.T) {
 	rel := getPathRelativeTo("/testpath", "/testpath")
 	assert.Empty(t, rel)
@@ -1058,3 +1193,245 @@ func TestRelativePath(t *testing.T) {
 	rel = getPathRelativeTo("/dir3", "dir3")
 	assert.Equal(t, "dir3", rel)
 }
+
+func TestModeZBinding(t *testing.T) {
+	b := Binding{
+		ModeZLevel: 10,
+	}
+	err := b.checkModeZ()
+	assert.ErrorContains(t, err, "invalid mode_z_level")
+	b.ModeZLevel = -1
+	err = b.checkModeZ()
+	assert.ErrorContains(t, err, "invalid mode_z_level")
+	b.ModeZLevel = 9
+	b.TLSMode = 1
+	err = b.checkModeZ()
+	assert.NoError(t, err)
+	b.EnableModeZ = true
+	err = b.checkModeZ()
+	assert.ErrorContains(t, err, "explicit TLS is required")
+	b.TLSMode = 2
+	b.ClientAuthType = 1
+	err = b.checkModeZ()
+	assert.ErrorContains(t, err, "client certificates")
+	b.ClientAuthType = 0
+	err = b.checkModeZ()
+	assert.NoError(t, err)
+
+	b = Binding{
+		Address:     "127.0.0.1",
+		Port:        0,
+		EnableModeZ: true,
+		ModeZLevel:  10,
+	}
+	c := &Configuration{
+		Bindings: []Binding{b},
+	}
+	server := NewServer(c, configDir, b, 0)
+	_, err = server.GetSettings()
+	assert.ErrorContains(t, err, "invalid mode_z_level")
+	server.binding.ModeZLevel = 0
+	settings, err := server.GetSettings()
+	require.NoError(t, err)
+	listener, ok := settings.Listener.(*modeZListener)
+	require.True(t, ok)
+	defer listener.Close()
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer clientConn.Close()
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	modeZ, ok := conn.(*modeZConn)
+	require.True(t, ok)
+	assert.Equal(t, zlib.DefaultCompression, modeZ.level)
+	assert.Equal(t, modeZ, server.getModeZConn(clientConn.LocalAddr().String()))
+	err = conn.Close()
+	assert.NoError(t, err)
+	assert.Nil(t, server.getModeZConn(clientConn.LocalAddr().String()))
+}
+
+func TestModeZConn(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	closed := make(chan bool, 2)
+	c := newModeZConn(serverConn, zlib.BestSpeed, func() {
+		closed <- true
+	})
+	replies := make(chan string, 10)
+	go func() {
+		r := bufio.NewReader(clientConn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(replies)
+				return
+			}
+			replies <- line
+		}
+	}()
+	sendCommands := func(commands string) {
+		go func() {
+			_, err := clientConn.Write([]byte(commands))
+			assert.NoError(t, err)
+		}()
+	}
+	r := bufio.NewReader(c)
+	readLine := func() string {
+		line, err := r.ReadString('\n')
+		assert.NoError(t, err)
+		return line
+	}
+
+	sendCommands("NOOP\r\nmode z\r\nMODE S\r\nMODE B\r\nMODE\r\nTYPE A\r\nLIST\r\n")
+	assert.Equal(t, "NOOP\r\n", readLine())
+	assert.Equal(t, "TYPE A\r\n", readLine())
+	assert.Equal(t, "LIST\r\n", readLine())
+	assert.Equal(t, "530 Please login with USER and PASS\r\n", <-replies)
+	assert.Equal(t, "200 Using stream mode\r\n", <-replies)
+	assert.Equal(t, "504 Unsupported mode \"B\"\r\n", <-replies)
+	assert.Equal(t, "501 Missing mode\r\n", <-replies)
+	assert.True(t, c.isASCII())
+	assert.False(t, c.isEnabled())
+
+	c.setUser(false)
+	sendCommands("MODE Z\r\nTYPE I\r\n")
+	assert.Equal(t, "TYPE I\r\n", readLine())
+	assert.Equal(t, "504 MODE Z is not allowed\r\n", <-replies)
+	assert.False(t, c.isASCII())
+	assert.False(t, c.isEnabled())
+
+	c.setUser(true)
+	sendCommands("MODE Z\r\nLIST\r\nnlst\r\nMLSD /\r\nPWD\r\n")
+	assert.Equal(t, "PWD\r\n", readLine())
+	assert.Equal(t, "200 MODE Z ok\r\n", <-replies)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "504 Directory listings are not supported in MODE Z, use MODE S\r\n", <-replies)
+	}
+	assert.True(t, c.isEnabled())
+	// long lines are passed through as is
+	longLine := strings.Repeat("a", modeZMaxLineLength+100) + "\r\n"
+	sendCommands(longLine)
+	assert.Equal(t, longLine, readLine())
+	// after AUTH the connection is passed through
+	sendCommands("AUTH TLS\r\nMODE S\r\n")
+	assert.Equal(t, "AUTH TLS\r\n", readLine())
+	assert.Equal(t, "MODE S\r\n", readLine())
+	assert.True(t, c.isPassthrough())
+	sendCommands("MODE Z\r\n")
+	assert.Equal(t, "MODE Z\r\n", readLine())
+
+	err := clientConn.Close()
+	assert.NoError(t, err)
+	_, err = r.ReadString('\n')
+	assert.ErrorIs(t, err, io.EOF)
+	c.Close() //nolint:errcheck
+	c.Close() //nolint:errcheck
+	assert.Len(t, closed, 1)
+	_, ok := <-replies
+	assert.False(t, ok)
+}
+
+func TestModeZTransfers(t *testing.T) {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "user",
+			HomeDir:  filepath.Clean(os.TempDir()),
+		},
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	mockCC := mockFTPClientContext{}
+	connID := fmt.Sprintf("%v", mockCC.ID())
+	fs := newMockOsFs(nil, nil, false, connID, user.GetHomeDir())
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connID, common.ProtocolFTP, "", "", user),
+		clientContext:  mockCC,
+	}
+	testfile := filepath.Join(user.HomeDir, "modezfile")
+	content := bytes.Repeat([]byte("sftpgo MODE Z test content "), 10000)
+	err := os.WriteFile(testfile, content, os.ModePerm)
+	require.NoError(t, err)
+
+	file, err := os.Open(testfile)
+	require.NoError(t, err)
+	baseTransfer := common.NewBaseTransfer(file, connection.BaseConnection, nil, testfile, testfile, "/modezfile",
+		common.TransferDownload, 0, 0, 0, 0, false, fs, dataprovider.TransferQuota{})
+	_, err = newModeZDownload(newTransfer(baseTransfer, nil, nil, 0), 10)
+	assert.Error(t, err)
+	download, err := newModeZDownload(newTransfer(baseTransfer, nil, nil, 0), zlib.BestCompression)
+	require.NoError(t, err)
+	compressed, err := io.ReadAll(download)
+	require.NoError(t, err)
+	assert.Less(t, len(compressed), len(content))
+	_, err = download.Write([]byte("a"))
+	assert.ErrorIs(t, err, common.ErrOpUnsupported)
+	_, err = download.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	_, err = download.Seek(10, io.SeekStart)
+	assert.ErrorIs(t, err, common.ErrOpUnsupported)
+	err = download.Close()
+	assert.NoError(t, err)
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+	assert.Len(t, connection.GetTransfers(), 0)
+
+	file, err = os.Create(testfile)
+	require.NoError(t, err)
+	baseTransfer = common.NewBaseTransfer(file, connection.BaseConnection, nil, testfile, testfile, "/modezfile",
+		common.TransferUpload, 0, 0, 0, 0, true, fs, dataprovider.TransferQuota{})
+	upload := newModeZUpload(newTransfer(baseTransfer, nil, nil, 0))
+	_, err = upload.Read(make([]byte, 10))
+	assert.ErrorIs(t, err, common.ErrOpUnsupported)
+	_, err = upload.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	_, err = upload.Seek(10, io.SeekCurrent)
+	assert.ErrorIs(t, err, common.ErrOpUnsupported)
+	// the data sent after the end of the compressed stream is ignored
+	_, err = upload.Write(append(compressed, []byte("trailing data")...))
+	assert.NoError(t, err)
+	err = upload.Close()
+	assert.NoError(t, err)
+	data, err = os.ReadFile(testfile)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	file, err = os.Create(testfile)
+	require.NoError(t, err)
+	baseTransfer = common.NewBaseTransfer(file, connection.BaseConnection, nil, testfile, testfile, "/modezfile",
+		common.TransferUpload, 0, 0, 0, 0, true, fs, dataprovider.TransferQuota{})
+	upload = newModeZUpload(newTransfer(baseTransfer, nil, nil, 0))
+	err = upload.Close()
+	assert.NoError(t, err)
+	info, err := os.Stat(testfile)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size())
+
+	file, err = os.Create(testfile)
+	require.NoError(t, err)
+	baseTransfer = common.NewBaseTransfer(file, connection.BaseConnection, nil, testfile, testfile, "/modezfile",
+		common.TransferUpload, 0, 0, 0, 0, true, fs, dataprovider.TransferQuota{})
+	upload = newModeZUpload(newTransfer(baseTransfer, nil, nil, 0))
+	_, err = upload.Write([]byte("invalid compressed data"))
+	assert.NoError(t, err)
+	err = upload.Close()
+	assert.Error(t, err)
+
+	file, err = os.Create(testfile)
+	require.NoError(t, err)
+	baseTransfer = common.NewBaseTransfer(file, connection.BaseConnection, nil, testfile, testfile, "/modezfile",
+		common.TransferUpload, 0, 0, 0, 0, true, fs, dataprovider.TransferQuota{})
+	upload = newModeZUpload(newTransfer(baseTransfer, nil, nil, 0))
+	errFake := errors.New("fake transfer error")
+	upload.TransferError(errFake)
+	_, err = upload.Write(compressed)
+	assert.Error(t, err)
+	err = upload.Close()
+	assert.Error(t, err)
+	assert.Len(t, connection.GetTransfers(), 0)
+
+	err = os.Remove(testfile)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,393 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ftpd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+)
+
+const (
+	// the maximum length for a control connection line, longer lines are
+	// passed to ftpserverlib as is
+	modeZMaxLineLength = 4096
+	modeZBufferSize    = 32768
+)
+
+var (
+	errModeZRestart = errors.New("resuming transfers is not supported in MODE Z")
+	errModeZASCII   = errors.New("ASCII transfers are not supported in MODE Z")
+)
+
+// modeZListener wraps the accepted connections so that the MODE command can
+// be handled before the control connection reaches ftpserverlib
+type modeZListener struct {
+	net.Listener
+	server *Server
+}
+
+// Accept implements the net.Listener interface
+func (l *modeZListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	return l.server.addModeZConn(conn), nil
+}
+
+// modeZConn is an FTP control connection that handles the MODE command and
+// passes anything else to ftpserverlib. Once the client sends AUTH, the
+// connection is passed through as is
+type modeZConn struct {
+	net.Conn
+	level   int
+	onClose func()
+	// writeMu serializes our replies with the ones sent by ftpserverlib
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+	readBuf   []byte
+	line      []byte
+	pending   []byte
+	readErr   error
+
+	mu          sync.RWMutex
+	passthrough bool
+	loggedIn    bool
+	allowed     bool
+	enabled     bool
+	ascii       bool
+}
+
+func newModeZConn(conn net.Conn, level int, onClose func()) *modeZConn {
+	return &modeZConn{
+		Conn:    conn,
+		level:   level,
+		onClose: onClose,
+		readBuf: make([]byte, 4096),
+	}
+}
+
+// Read implements the net.Conn interface
+func (c *modeZConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		if c.isPassthrough() && len(c.line) == 0 {
+			return c.Conn.Read(p)
+		}
+		n, err := c.Conn.Read(c.readBuf)
+		if n > 0 {
+			c.parse(c.readBuf[:n])
+		}
+		if err != nil {
+			c.pending = append(c.pending, c.line...)
+			c.line = nil
+			c.readErr = err
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write implements the net.Conn interface
+func (c *modeZConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return c.Conn.Write(p)
+}
+
+// Close implements the net.Conn interface
+func (c *modeZConn) Close() error {
+	c.closeOnce.Do(func() {
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return c.Conn.Close()
+}
+
+func (c *modeZConn) parse(data []byte) {
+	c.line = append(c.line, data...)
+	for len(c.line) > 0 {
+		if c.isPassthrough() {
+			c.pending = append(c.pending, c.line...)
+			c.line = nil
+			return
+		}
+		idx := bytes.IndexByte(c.line, '\n')
+		if idx < 0 {
+			if len(c.line) > modeZMaxLineLength {
+				c.pending = append(c.pending, c.line...)
+				c.line = nil
+			}
+			return
+		}
+		line := c.line[:idx+1]
+		if !c.handleLine(string(line)) {
+			c.pending = append(c.pending, line...)
+		}
+		c.line = c.line[idx+1:]
+	}
+}
+
+// handleLine returns true if the line was handled and must not be passed to
+// ftpserverlib
+func (c *modeZConn) handleLine(line string) bool {
+	command, param, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+	param = strings.TrimSpace(param)
+
+	switch strings.ToUpper(command) {
+	case "MODE":
+		c.handleMODE(strings.ToUpper(param))
+		return true
+	case "AUTH":
+		c.mu.Lock()
+		c.passthrough = true
+		c.mu.Unlock()
+	case "TYPE":
+		c.mu.Lock()
+		c.ascii = strings.HasPrefix(strings.ToUpper(param), "A")
+		c.mu.Unlock()
+	case "LIST", "NLST", "MLSD":
+		if c.isEnabled() {
+			c.writeReply(504, "Directory listings are not supported in MODE Z, use MODE S")
+			return true
+		}
+	}
+	return false
+}
+
+func (c *modeZConn) handleMODE(param string) {
+	switch param {
+	case "S":
+		c.mu.Lock()
+		c.enabled = false
+		c.mu.Unlock()
+		c.writeReply(200, "Using stream mode")
+	case "Z":
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if !c.loggedIn {
+			c.writeReply(530, "Please login with USER and PASS")
+			return
+		}
+		if !c.allowed {
+			c.writeReply(504, "MODE Z is not allowed")
+			return
+		}
+		c.enabled = true
+		c.writeReply(200, "MODE Z ok")
+	case "":
+		c.writeReply(501, "Missing mode")
+	default:
+		c.writeReply(504, fmt.Sprintf("Unsupported mode %q", param))
+	}
+}
+
+func (c *modeZConn) writeReply(code int, message string) {
+	c.Write([]byte(fmt.Sprintf("%d %s\r\n", code, message))) //nolint:errcheck
+}
+
+func (c *modeZConn) setUser(allowed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.loggedIn = true
+	c.allowed = allowed
+	c.enabled = false
+}
+
+func (c *modeZConn) isPassthrough() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.passthrough
+}
+
+func (c *modeZConn) isEnabled() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.enabled
+}
+
+func (c *modeZConn) isASCII() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.ascii
+}
+
+// modeZDownload compresses the contents read from the wrapped transfer.
+// Quotas and bandwidth limits apply to the uncompressed data
+type modeZDownload struct {
+	transfer *transfer
+	zw       *zlib.Writer
+	buf      bytes.Buffer
+	readBuf  []byte
+	finished bool
+}
+
+func newModeZDownload(t *transfer, level int) (*modeZDownload, error) {
+	d := &modeZDownload{
+		transfer: t,
+		readBuf:  make([]byte, modeZBufferSize),
+	}
+	zw, err := zlib.NewWriterLevel(&d.buf, level)
+	if err != nil {
+		return nil, err
+	}
+	d.zw = zw
+	return d, nil
+}
+
+// Read returns the compressed contents to download
+func (d *modeZDownload) Read(p []byte) (int, error) {
+	for d.buf.Len() == 0 && !d.finished {
+		n, err := d.transfer.Read(d.readBuf)
+		if n > 0 {
+			if _, errWrite := d.zw.Write(d.readBuf[:n]); errWrite != nil {
+				d.transfer.TransferError(errWrite)
+				return 0, errWrite
+			}
+		}
+		if err == io.EOF {
+			if errClose := d.zw.Close(); errClose != nil {
+				d.transfer.TransferError(errClose)
+				return 0, errClose
+			}
+			d.finished = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	if d.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return d.buf.Read(p)
+}
+
+// Write is not supported for downloads
+func (d *modeZDownload) Write(_ []byte) (int, error) {
+	return 0, common.ErrOpUnsupported
+}
+
+// Seek is not supported, only the initial offset is accepted
+func (d *modeZDownload) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekStart {
+		return 0, nil
+	}
+	return 0, common.ErrOpUnsupported
+}
+
+// TransferError implements the FileTransferError interface
+func (d *modeZDownload) TransferError(err error) {
+	d.transfer.TransferError(err)
+}
+
+// Close is called when the transfer is completed
+func (d *modeZDownload) Close() error {
+	return d.transfer.Close()
+}
+
+// modeZUpload decompresses the uploaded contents and writes them to the
+// wrapped transfer. Quotas and bandwidth limits apply to the uncompressed data
+type modeZUpload struct {
+	transfer *transfer
+	pw       *io.PipeWriter
+	done     chan error
+}
+
+func newModeZUpload(t *transfer) *modeZUpload {
+	pr, pw := io.Pipe()
+	u := &modeZUpload{
+		transfer: t,
+		pw:       pw,
+		done:     make(chan error, 1),
+	}
+	go func() {
+		err := u.decompress(pr)
+		pr.CloseWithError(err) //nolint:errcheck
+		u.done <- err
+	}()
+	return u
+}
+
+func (u *modeZUpload) decompress(r io.Reader) error {
+	br := bufio.NewReaderSize(r, modeZBufferSize)
+	if _, err := br.Peek(1); err == io.EOF {
+		// empty upload
+		return nil
+	}
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(u.transfer, zr); err != nil {
+		return err
+	}
+	if err = zr.Close(); err != nil {
+		return err
+	}
+	// ignore any data sent after the end of the compressed stream
+	_, err = io.Copy(io.Discard, br)
+	return err
+}
+
+// Read is not supported for uploads
+func (u *modeZUpload) Read(_ []byte) (int, error) {
+	return 0, common.ErrOpUnsupported
+}
+
+// Write writes the compressed uploaded contents
+func (u *modeZUpload) Write(p []byte) (int, error) {
+	return u.pw.Write(p)
+}
+
+// Seek is not supported, only the initial offset is accepted
+func (u *modeZUpload) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekStart {
+		return 0, nil
+	}
+	return 0, common.ErrOpUnsupported
+}
+
+// TransferError implements the FileTransferError interface
+func (u *modeZUpload) TransferError(err error) {
+	u.transfer.TransferError(err)
+	u.pw.CloseWithError(err) //nolint:errcheck
+}
+
+// Close is called when the transfer is completed
+func (u *modeZUpload) Close() error {
+	u.pw.Close() //nolint:errcheck
+	if err := <-u.done; err != nil {
+		u.transfer.TransferError(err)
+	}
+	return u.transfer.Close()
+}
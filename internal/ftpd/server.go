@@ -15,6 +15,8 @@
 package ftpd
 
 import (
+	"compress/zlib"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -30,6 +32,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/version"
 )
@@ -44,6 +47,17 @@ type Server struct {
 	tlsConfig        *tls.Config
 	mu               sync.RWMutex
 	verifiedTLSConns map[uint32]bool
+	// passive host overrides for the authenticated users
+	passiveHosts map[uint32]string
+	// trace context for the client connections, if tracing is enabled
+	traces map[uint32]connectionTrace
+	// control connections wrapped to handle MODE Z, keyed by remote address
+	modeZConns map[string]*modeZConn
+}
+
+type connectionTrace struct {
+	ctx  context.Context
+	span *tracing.Span
 }
 
 // NewServer returns a new FTP server driver
@@ -56,6 +70,9 @@ func NewServer(config *Configuration, configDir string, binding Binding, id int)
 		binding:          binding,
 		ID:               id,
 		verifiedTLSConns: make(map[uint32]bool),
+		passiveHosts:     make(map[uint32]string),
+		traces:           make(map[uint32]connectionTrace),
+		modeZConns:       make(map[string]*modeZConn),
 	}
 	if config.BannerFile != "" {
 		bannerFilePath := config.BannerFile
@@ -93,6 +110,98 @@ func (s *Server) cleanTLSConnVerification(id uint32) {
 	defer s.mu.Unlock()
 
 	delete(s.verifiedTLSConns, id)
+	delete(s.passiveHosts, id)
+	if trace, ok := s.traces[id]; ok {
+		trace.span.End()
+		delete(s.traces, id)
+	}
+}
+
+func (s *Server) startConnectionTrace(cc ftpserver.ClientContext, ipAddr string) context.Context {
+	ctx, span := tracing.Start(context.Background(), "FTP connection", tracing.SpanKindServer)
+	if span == nil {
+		return ctx
+	}
+	span.SetAttribute("net.peer.ip", ipAddr)
+	span.SetAttribute("sftpgo.protocol", common.ProtocolFTP)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.traces[cc.ID()] = connectionTrace{ctx: ctx, span: span}
+	return ctx
+}
+
+func (s *Server) getTraceContext(id uint32) context.Context {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if trace, ok := s.traces[id]; ok {
+		return trace.ctx
+	}
+	return context.Background()
+}
+
+func (s *Server) setPassiveHost(id uint32, host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if host == "" {
+		delete(s.passiveHosts, id)
+		return
+	}
+	s.passiveHosts[id] = host
+}
+
+func (s *Server) getPassiveHost(id uint32) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.passiveHosts[id]
+}
+
+func (s *Server) addModeZConn(conn net.Conn) net.Conn {
+	level := s.binding.ModeZLevel
+	if level == 0 {
+		level = zlib.DefaultCompression
+	}
+	remoteAddr := conn.RemoteAddr().String()
+	var c *modeZConn
+	c = newModeZConn(conn, level, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.modeZConns[remoteAddr] == c {
+			delete(s.modeZConns, remoteAddr)
+		}
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.modeZConns[remoteAddr] = c
+	return c
+}
+
+func (s *Server) getModeZConn(remoteAddr string) *modeZConn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.modeZConns[remoteAddr]
+}
+
+// passiveIPResolver returns the passive IP configured for the authenticated user,
+// if any, otherwise the one configured for the binding
+func (s *Server) passiveIPResolver(cc ftpserver.ClientContext) (string, error) {
+	if host := s.getPassiveHost(cc.ID()); host != "" {
+		ip, err := resolvePassiveHost(host)
+		if err == nil {
+			return ip, nil
+		}
+		logger.Warn(logSender, fmt.Sprintf("%v_%v_%v", common.ProtocolFTP, s.ID, cc.ID()),
+			"unable to resolve the user passive host %q, using the binding settings: %v", host, err)
+	}
+	return s.binding.passiveIPResolver(cc)
 }
 
 // GetSettings returns FTP server settings
@@ -103,28 +212,43 @@ func (s *Server) GetSettings() (*ftpserver.Settings, error) {
 	if err := s.binding.checkSecuritySettings(); err != nil {
 		return nil, err
 	}
+	if err := s.binding.ClientPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	if err := s.binding.checkPassivePortRange(); err != nil {
+		return nil, err
+	}
+	if err := s.binding.checkModeZ(); err != nil {
+		return nil, err
+	}
 	var portRange *ftpserver.PortRange
-	if s.config.PassivePortRange.Start > 0 && s.config.PassivePortRange.End > s.config.PassivePortRange.Start {
+	if pasvRange := s.binding.getPassivePortRange(s.config.PassivePortRange); pasvRange != nil {
 		portRange = &ftpserver.PortRange{
-			Start: s.config.PassivePortRange.Start,
-			End:   s.config.PassivePortRange.End,
+			Start: pasvRange.Start,
+			End:   pasvRange.End,
 		}
 	}
 	var ftpListener net.Listener
-	if s.binding.HasProxy() {
+	if s.binding.HasProxy() || s.binding.EnableModeZ {
 		listener, err := net.Listen("tcp", s.binding.GetAddress())
 		if err != nil {
 			logger.Warn(logSender, "", "error starting listener on address %v: %v", s.binding.GetAddress(), err)
 			return nil, err
 		}
-		ftpListener, err = common.Config.GetProxyListener(listener)
-		if err != nil {
-			logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
-			return nil, err
+		ftpListener = listener
+		if s.binding.HasProxy() {
+			ftpListener, err = common.Config.GetProxyListener(listener)
+			if err != nil {
+				logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
+				return nil, err
+			}
 		}
 		if s.binding.TLSMode == 2 && s.tlsConfig != nil {
 			ftpListener = tls.NewListener(ftpListener, s.tlsConfig)
 		}
+		if s.binding.EnableModeZ {
+			ftpListener = &modeZListener{Listener: ftpListener, server: s}
+		}
 	}
 
 	if s.binding.TLSMode < 0 || s.binding.TLSMode > 2 {
@@ -138,7 +262,7 @@ func (s *Server) GetSettings() (*ftpserver.Settings, error) {
 	return &ftpserver.Settings{
 		Listener:                 ftpListener,
 		ListenAddr:               s.binding.GetAddress(),
-		PublicIPResolver:         s.binding.passiveIPResolver,
+		PublicIPResolver:         s.passiveIPResolver,
 		PassiveTransferPortRange: portRange,
 		ActiveTransferPortNon20:  s.config.ActiveTransfersPortNon20,
 		IdleTimeout:              -1,
@@ -168,10 +292,17 @@ func (s *Server) ClientConnected(cc ftpserver.ClientContext) (string, error) {
 		logger.Log(logger.LevelDebug, common.ProtocolFTP, "", fmt.Sprintf("connection not allowed from ip %#v", ipAddr))
 		return "Access denied", common.ErrConnectionDenied
 	}
+	if !common.IsCountryAllowed(ipAddr, s.binding.AllowedCountries, s.binding.DeniedCountries) {
+		logger.Log(logger.LevelDebug, common.ProtocolFTP, "", "connection refused, country not allowed for ip %q", ipAddr)
+		return "Access denied", common.ErrConnectionDenied
+	}
 	_, err := common.LimitRate(common.ProtocolFTP, ipAddr)
 	if err != nil {
 		return fmt.Sprintf("Access denied: %v", err.Error()), err
 	}
+	if err := common.ThrottleByLatency(common.ProtocolFTP, ipAddr); err != nil {
+		return fmt.Sprintf("Access denied: %v", err.Error()), err
+	}
 	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolFTP); err != nil {
 		return "Access denied by post connect hook", err
 	}
@@ -182,6 +313,7 @@ func (s *Server) ClientConnected(cc ftpserver.ClientContext) (string, error) {
 			cc.RemoteAddr().String(), user),
 		clientContext: cc,
 	}
+	connection.SetTraceContext(s.startConnectionTrace(cc, ipAddr))
 	err = common.Connections.Add(connection)
 	return s.initialMsg, err
 }
@@ -201,10 +333,19 @@ func (s *Server) AuthUser(cc ftpserver.ClientContext, username, password string)
 		loginMethod = dataprovider.LoginMethodTLSCertificateAndPwd
 	}
 	ipAddr := util.GetIPFromRemoteAddress(cc.RemoteAddr().String())
+	_, span := tracing.Start(s.getTraceContext(cc.ID()), "FTP login", tracing.SpanKindInternal)
+	span.SetAttribute("sftpgo.login_method", loginMethod)
 	user, err := dataprovider.CheckUserAndPass(username, password, ipAddr, common.ProtocolFTP)
 	if err != nil {
+		span.EndWithError(err)
 		user.Username = username
 		updateLoginMetrics(&user, ipAddr, loginMethod, err)
+		if errors.Is(err, dataprovider.ErrProviderUnavailable) {
+			return nil, dataprovider.ErrProviderUnavailable
+		}
+		if _, ok := err.(*util.RecordNotFoundError); ok && s.binding.UniformAuthErrors {
+			dataprovider.SimulatePasswordCheck(password)
+		}
 		return nil, dataprovider.ErrInvalidCredentials
 	}
 
@@ -212,7 +353,12 @@ func (s *Server) AuthUser(cc ftpserver.ClientContext, username, password string)
 
 	defer updateLoginMetrics(&user, ipAddr, loginMethod, err)
 
+	span.SetAttribute("enduser.id", user.Username)
+	span.EndWithError(err)
 	if err != nil {
+		if s.binding.UniformAuthErrors {
+			return nil, dataprovider.ErrInvalidCredentials
+		}
 		return nil, err
 	}
 	setStartDirectory(user.Filters.StartDirectory, cc)
@@ -393,6 +539,9 @@ func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext
 			user.Username, remoteAddr)
 		return nil, fmt.Errorf("login for user %#v is not allowed from this address: %v", user.Username, remoteAddr)
 	}
+	if err := s.checkClientVersion(&user, cc, connectionID); err != nil {
+		return nil, err
+	}
 	err := user.CheckFsRoot(connectionID)
 	if err != nil {
 		errClose := user.CloseFs()
@@ -404,15 +553,38 @@ func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext
 			cc.LocalAddr().String(), remoteAddr, user),
 		clientContext: cc,
 	}
+	connection.SetTraceContext(s.getTraceContext(cc.ID()))
 	err = common.Connections.Swap(connection)
 	if err != nil {
 		errClose := user.CloseFs()
 		logger.Warn(logSender, connectionID, "unable to swap connection: %v, close fs error: %v", err, errClose)
 		return nil, err
 	}
+	s.setPassiveHost(cc.ID(), user.Filters.FTPPassiveHost)
+	if c := s.getModeZConn(remoteAddr); c != nil {
+		c.setUser(user.Filters.FTPModeZ)
+		connection.modeZ = c
+	}
 	return connection, nil
 }
 
+func (s *Server) checkClientVersion(user *dataprovider.User, cc ftpserver.ClientContext, connectionID string) error {
+	clientVersion := cc.GetClientVersion()
+	if !s.binding.ClientPolicy.IsClientAllowed(clientVersion) {
+		common.AddDeniedClientVersion(common.ProtocolFTP, clientVersion)
+		logger.Info(logSender, connectionID, "cannot login user %q, client %q is not allowed for binding %q",
+			user.Username, clientVersion, s.binding.GetAddress())
+		return common.ErrClientNotAllowed
+	}
+	if !user.IsClientAllowed(clientVersion) {
+		common.AddDeniedClientVersion(common.ProtocolFTP, clientVersion)
+		logger.Info(logSender, connectionID, "cannot login user %q, client %q is not allowed", user.Username, clientVersion)
+		return fmt.Errorf("client %q is not allowed for user %q", clientVersion, user.Username)
+	}
+	common.AddClientVersion(common.ProtocolFTP, clientVersion)
+	return nil
+}
+
 func setStartDirectory(startDirectory string, cc ftpserver.ClientContext) {
 	if startDirectory == "" {
 		return
@@ -423,13 +595,15 @@ func setStartDirectory(startDirectory string, cc ftpserver.ClientContext) {
 func updateLoginMetrics(user *dataprovider.User, ip, loginMethod string, err error) {
 	metric.AddLoginAttempt(loginMethod)
 	if err != nil && err != common.ErrInternalFailure {
-		logger.ConnectionFailedLog(user.Username, ip, loginMethod,
+		logger.ConnectionFailedLog(user.Username, ip, common.GetCountryForIP(ip), loginMethod,
 			common.ProtocolFTP, err.Error())
 		event := common.HostEventLoginFailed
 		if _, ok := err.(*util.RecordNotFoundError); ok {
 			event = common.HostEventUserNotFound
 		}
-		common.AddDefenderEvent(ip, event)
+		if !errors.Is(err, dataprovider.ErrProviderUnavailable) {
+			common.AddDefenderEvent(ip, event)
+		}
 	}
 	metric.AddLoginResult(loginMethod, err)
 	dataprovider.ExecutePostLoginHook(user, loginMethod, ip, common.ProtocolFTP, err)
@@ -73,6 +73,18 @@ func (t *transfer) Read(p []byte) (n int, err error) {
 	return
 }
 
+// WriteTo sends the contents to download to w, it is called by io.Copy.
+// The zero-copy path is used if possible, for example it is not possible
+// for ASCII transfers or TLS data connections
+func (t *transfer) WriteTo(w io.Writer) (int64, error) {
+	n, err := t.ZeroCopyTo(w, -1)
+	if errors.Is(err, common.ErrOpUnsupported) {
+		// hide WriteTo to avoid an infinite recursion
+		return io.Copy(w, struct{ io.Reader }{t})
+	}
+	return n, err
+}
+
 // Write writes the uploaded contents.
 func (t *transfer) Write(p []byte) (n int, err error) {
 	t.Connection.UpdateLastActivity()
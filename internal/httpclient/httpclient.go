@@ -29,6 +29,7 @@ import (
 	"github.com/hashicorp/go-retryablehttp"
 
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/resolver"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
@@ -72,7 +73,9 @@ type Config struct {
 	// This should be used only for testing.
 	SkipTLSVerify bool `json:"skip_tls_verify" mapstructure:"skip_tls_verify"`
 	// Headers defines a list of http headers to add to each request
-	Headers         []Header `json:"headers" mapstructure:"headers"`
+	Headers []Header `json:"headers" mapstructure:"headers"`
+	// Signature defines the configuration to sign the outgoing requests
+	Signature       SignatureConfig `json:"signature" mapstructure:"signature"`
 	customTransport *http.Transport
 }
 
@@ -85,6 +88,9 @@ func (c *Config) Initialize(configDir string) error {
 	if c.Timeout <= 0 {
 		return fmt.Errorf("invalid timeout: %v", c.Timeout)
 	}
+	if err := c.Signature.validate(); err != nil {
+		return err
+	}
 	rootCAs, err := c.loadCACerts(configDir)
 	if err != nil {
 		return err
@@ -99,6 +105,7 @@ func (c *Config) Initialize(configDir string) error {
 		}
 	}
 	customTransport.TLSClientConfig.InsecureSkipVerify = c.SkipTLSVerify
+	customTransport.DialContext = resolver.WrapDialContext(customTransport.DialContext)
 	c.customTransport = customTransport
 
 	err = c.loadCertificates(configDir)
@@ -195,7 +202,9 @@ func GetHTTPClient() *http.Client {
 func GetRetraybleHTTPClient() *retryablehttp.Client {
 	client := retryablehttp.NewClient()
 	client.HTTPClient.Timeout = time.Duration(httpConfig.Timeout * float64(time.Second))
-	client.HTTPClient.Transport.(*http.Transport).TLSClientConfig = httpConfig.customTransport.TLSClientConfig
+	transport := client.HTTPClient.Transport.(*http.Transport)
+	transport.TLSClientConfig = httpConfig.customTransport.TLSClientConfig
+	transport.DialContext = resolver.WrapDialContext(transport.DialContext)
 	client.Logger = &logger.LeveledLogger{Sender: "RetryableHTTPClient"}
 	client.RetryWaitMin = time.Duration(httpConfig.RetryWaitMin) * time.Second
 	client.RetryWaitMax = time.Duration(httpConfig.RetryWaitMax) * time.Second
@@ -204,6 +213,11 @@ func GetRetraybleHTTPClient() *retryablehttp.Client {
 	return client
 }
 
+// GetRetryMax returns the configured maximum number of retries
+func GetRetryMax() int {
+	return httpConfig.RetryMax
+}
+
 // Get issues a GET to the specified URL
 func Get(url string) (*http.Response, error) {
 	req, err := http.NewRequest(http.MethodGet, url, nil)
@@ -211,6 +225,7 @@ func Get(url string) (*http.Response, error) {
 		return nil, err
 	}
 	addHeaders(req, url)
+	SignRequest(req, GetPayloadHash(nil))
 	client := GetHTTPClient()
 	defer client.CloseIdleConnections()
 
@@ -219,12 +234,17 @@ func Get(url string) (*http.Response, error) {
 
 // Post issues a POST to the specified URL
 func Post(url string, contentType string, body io.Reader) (*http.Response, error) {
+	payload, body, err := readBody(body)
+	if err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequest(http.MethodPost, url, body)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", contentType)
 	addHeaders(req, url)
+	SignRequest(req, GetPayloadHash(payload))
 	client := GetHTTPClient()
 	defer client.CloseIdleConnections()
 
@@ -239,6 +259,7 @@ func RetryableGet(url string) (*http.Response, error) {
 	}
 	addHeadersToRetryableReq(req, url)
 	client := GetRetraybleHTTPClient()
+	addRetryableSignature(client, nil)
 	defer client.HTTPClient.CloseIdleConnections()
 
 	return client.Do(req)
@@ -246,6 +267,10 @@ func RetryableGet(url string) (*http.Response, error) {
 
 // RetryablePost issues a POST to the specified URL using the retryable client
 func RetryablePost(url string, contentType string, body io.Reader) (*http.Response, error) {
+	payload, body, err := readBody(body)
+	if err != nil {
+		return nil, err
+	}
 	req, err := retryablehttp.NewRequest(http.MethodPost, url, body)
 	if err != nil {
 		return nil, err
@@ -253,6 +278,7 @@ func RetryablePost(url string, contentType string, body io.Reader) (*http.Respon
 	req.Header.Set("Content-Type", contentType)
 	addHeadersToRetryableReq(req, url)
 	client := GetRetraybleHTTPClient()
+	addRetryableSignature(client, payload)
 	defer client.HTTPClient.CloseIdleConnections()
 
 	return client.Do(req)
@@ -275,3 +301,15 @@ func addHeadersToRetryableReq(req *retryablehttp.Request, url string) {
 		}
 	}
 }
+
+// addRetryableSignature signs each attempt, so that every retry has its own
+// timestamp and nonce
+func addRetryableSignature(client *retryablehttp.Client, payload []byte) {
+	if !httpConfig.Signature.IsEnabled() {
+		return
+	}
+	payloadHash := GetPayloadHash(payload)
+	client.RequestLogHook = func(_ retryablehttp.Logger, req *http.Request, _ int) {
+		SignRequest(req, payloadHash)
+	}
+}
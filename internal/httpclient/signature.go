@@ -0,0 +1,157 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpclient
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// UnsignedPayload is used in place of the payload hash for streamed request
+	// bodies, such as multipart requests with file attachments
+	UnsignedPayload        = "UNSIGNED-PAYLOAD"
+	signatureAlgo          = "sha256"
+	defaultSignatureHeader = "X-SFTPGo-Signature"
+	defaultTimestampHeader = "X-SFTPGo-Timestamp"
+	defaultNonceHeader     = "X-SFTPGo-Nonce"
+)
+
+// SignatureConfig defines the configuration to sign the outgoing hook requests.
+// Receivers can use the signature to authenticate the requests and the timestamp
+// and nonce to protect against replay attacks
+type SignatureConfig struct {
+	// Secret used to compute the HMAC-SHA256 signature. Empty means disabled
+	Secret string `json:"secret" mapstructure:"secret"`
+	// SignatureHeader is the name of the header containing the signature
+	SignatureHeader string `json:"signature_header" mapstructure:"signature_header"`
+	// TimestampHeader is the name of the header containing the request timestamp
+	// as Unix epoch seconds
+	TimestampHeader string `json:"timestamp_header" mapstructure:"timestamp_header"`
+	// NonceHeader is the name of the header containing a random value,
+	// unique for each request
+	NonceHeader string `json:"nonce_header" mapstructure:"nonce_header"`
+}
+
+// IsEnabled returns true if the outgoing requests must be signed
+func (c *SignatureConfig) IsEnabled() bool {
+	return c.Secret != ""
+}
+
+func (c *SignatureConfig) validate() error {
+	if !c.IsEnabled() {
+		return nil
+	}
+	if c.SignatureHeader == "" {
+		c.SignatureHeader = defaultSignatureHeader
+	}
+	if c.TimestampHeader == "" {
+		c.TimestampHeader = defaultTimestampHeader
+	}
+	if c.NonceHeader == "" {
+		c.NonceHeader = defaultNonceHeader
+	}
+	c.SignatureHeader = http.CanonicalHeaderKey(strings.TrimSpace(c.SignatureHeader))
+	c.TimestampHeader = http.CanonicalHeaderKey(strings.TrimSpace(c.TimestampHeader))
+	c.NonceHeader = http.CanonicalHeaderKey(strings.TrimSpace(c.NonceHeader))
+	if c.SignatureHeader == c.TimestampHeader || c.SignatureHeader == c.NonceHeader ||
+		c.TimestampHeader == c.NonceHeader {
+		return fmt.Errorf("signature, timestamp and nonce headers must be different, got %q, %q, %q",
+			c.SignatureHeader, c.TimestampHeader, c.NonceHeader)
+	}
+	return nil
+}
+
+// getStringToSign returns the string to sign for the given parameters.
+// The string to sign is composed by the following elements separated by a new line:
+// timestamp, nonce, request method, request URI (path and query) and the hex
+// encoded SHA256 hash of the request body
+func getStringToSign(timestamp, nonce, method, requestURI, payloadHash string) string {
+	return strings.Join([]string{timestamp, nonce, method, requestURI, payloadHash}, "\n")
+}
+
+func (c *SignatureConfig) sign(req *http.Request, payloadHash string) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := util.GenerateUniqueID()
+	stringToSign := getStringToSign(timestamp, nonce, req.Method, req.URL.RequestURI(), payloadHash)
+
+	mac := hmac.New(sha256.New, []byte(c.Secret))
+	mac.Write([]byte(stringToSign))
+
+	req.Header.Set(c.TimestampHeader, timestamp)
+	req.Header.Set(c.NonceHeader, nonce)
+	req.Header.Set(c.SignatureHeader, fmt.Sprintf("%s=%s", signatureAlgo, hex.EncodeToString(mac.Sum(nil))))
+}
+
+// GetPayloadHash returns the hex encoded SHA256 hash of the given payload
+func GetPayloadHash(payload []byte) string {
+	h := sha256.Sum256(payload)
+	return hex.EncodeToString(h[:])
+}
+
+// IsSignatureEnabled returns true if the outgoing requests must be signed
+func IsSignatureEnabled() bool {
+	return httpConfig.Signature.IsEnabled()
+}
+
+// SignRequest adds the signature, timestamp and nonce headers to the given
+// request if signing is enabled. payloadHash is the value returned by
+// GetPayloadHash for the request body or UnsignedPayload for streamed bodies
+func SignRequest(req *http.Request, payloadHash string) {
+	if !httpConfig.Signature.IsEnabled() {
+		return
+	}
+	httpConfig.Signature.sign(req, payloadHash)
+}
+
+// SignRequestWithSecret adds the signature, timestamp and nonce headers to the
+// given request using the specified secret in place of the configured one.
+// The configured header names are used, if valid, otherwise the default ones
+func SignRequestWithSecret(req *http.Request, secret, payloadHash string) {
+	c := SignatureConfig{
+		Secret:          secret,
+		SignatureHeader: httpConfig.Signature.SignatureHeader,
+		TimestampHeader: httpConfig.Signature.TimestampHeader,
+		NonceHeader:     httpConfig.Signature.NonceHeader,
+	}
+	if err := c.validate(); err != nil {
+		c.SignatureHeader = defaultSignatureHeader
+		c.TimestampHeader = defaultTimestampHeader
+		c.NonceHeader = defaultNonceHeader
+	}
+	c.sign(req, payloadHash)
+}
+
+// readBody reads the whole body so it can be signed, hook payloads are small
+func readBody(body io.Reader) ([]byte, io.Reader, error) {
+	if body == nil {
+		return nil, nil, nil
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, bytes.NewReader(data), nil
+}
@@ -97,6 +97,7 @@ func disableAdmin2FA(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	admin.Filters.RecoveryCodes = nil
+	admin.Filters.WebAuthnCredentials = nil
 	admin.Filters.TOTPConfig = dataprovider.AdminTOTPConfig{
 		Enabled: false,
 	}
@@ -120,8 +121,10 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 	username = admin.Username
 	totpConfig := admin.Filters.TOTPConfig
 	recoveryCodes := admin.Filters.RecoveryCodes
+	webAuthnCredentials := admin.Filters.WebAuthnCredentials
 	admin.Filters.TOTPConfig = dataprovider.AdminTOTPConfig{}
 	admin.Filters.RecoveryCodes = nil
+	admin.Filters.WebAuthnCredentials = nil
 	err = render.DecodeJSON(r.Body, &admin)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
@@ -152,6 +155,7 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 	admin.Username = username
 	admin.Filters.TOTPConfig = totpConfig
 	admin.Filters.RecoveryCodes = recoveryCodes
+	admin.Filters.WebAuthnCredentials = webAuthnCredentials
 	if err := dataprovider.UpdateAdmin(&admin, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr)); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -197,6 +201,8 @@ func getAdminProfile(w http.ResponseWriter, r *http.Request) {
 			Email:           admin.Email,
 			Description:     admin.Description,
 			AllowAPIKeyAuth: admin.Filters.AllowAPIKeyAuth,
+			Theme:           admin.Filters.Preferences.Theme,
+			Language:        admin.Filters.Language,
 		},
 	}
 	render.JSON(w, r, resp)
@@ -223,6 +229,8 @@ func updateAdminProfile(w http.ResponseWriter, r *http.Request) {
 	admin.Email = req.Email
 	admin.Description = req.Description
 	admin.Filters.AllowAPIKeyAuth = req.AllowAPIKeyAuth
+	admin.Filters.Preferences.Theme = req.Theme
+	admin.Filters.Language = req.Language
 	if err := dataprovider.UpdateAdmin(&admin, dataprovider.ActionExecutorSelf, util.GetIPFromRemoteAddress(r.RemoteAddr)); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -0,0 +1,97 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/auditlog"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func getAuditFiltersFromRequest(r *http.Request) (dataprovider.AuditFilters, error) {
+	filters := dataprovider.AuditFilters{
+		ObjectType: r.URL.Query().Get("object_type"),
+		ObjectName: r.URL.Query().Get("object_name"),
+		Executor:   r.URL.Query().Get("executor"),
+		Action:     r.URL.Query().Get("action"),
+	}
+	if _, ok := r.URL.Query()["start_timestamp"]; ok {
+		ts, err := strconv.ParseInt(r.URL.Query().Get("start_timestamp"), 10, 64)
+		if err != nil {
+			return filters, util.NewValidationError(fmt.Sprintf("invalid start_timestamp: %v", err))
+		}
+		filters.StartTimestamp = ts
+	}
+	if _, ok := r.URL.Query()["end_timestamp"]; ok {
+		ts, err := strconv.ParseInt(r.URL.Query().Get("end_timestamp"), 10, 64)
+		if err != nil {
+			return filters, util.NewValidationError(fmt.Sprintf("invalid end_timestamp: %v", err))
+		}
+		filters.EndTimestamp = ts
+	}
+	return filters, nil
+}
+
+func getAuditEntries(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	limit, offset, order, err := getSearchFilters(w, r)
+	if err != nil {
+		return
+	}
+	filters, err := getAuditFiltersFromRequest(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	entries, err := dataprovider.GetAuditEntries(filters, limit, offset, order)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, entries)
+}
+
+func exportAuditLog(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if !auditlog.IsEnabled() {
+		sendAPIResponse(w, r, util.NewValidationError("the audit log is disabled"), "", http.StatusBadRequest)
+		return
+	}
+	filters, err := getAuditFiltersFromRequest(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if err := filters.Validate(); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q",
+		fmt.Sprintf("auditlog-%s.jsonl", time.Now().UTC().Format("20060102T150405Z"))))
+	if err := auditlog.Export(w, filters.StartTimestamp, filters.EndTimestamp); err != nil {
+		// the response status is already sent
+		logger.Warn(logSender, "", "unable to export the audit log: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func getBulkFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	return dataprovider.BulkFormatNDJSON
+}
+
+func bulkExport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	objectType := getURLParam(r, "objecttype")
+	format := getBulkFormat(r)
+	if err := dataprovider.ValidateBulkOptions(objectType, format); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if format == dataprovider.BulkFormatCSV {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q",
+		fmt.Sprintf("sftpgo-%s-%s.%s", objectType, time.Now().UTC().Format("20060102T150405Z"), format)))
+	if err := dataprovider.ExportBulk(w, objectType, format); err != nil {
+		// the response status is already sent
+		logger.Warn(logSender, "", "unable to export %s: %v", objectType, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func bulkImport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxRestoreSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	objectType := getURLParam(r, "objecttype")
+	format := getBulkFormat(r)
+	if err := dataprovider.ValidateBulkOptions(objectType, format); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	var dryRun bool
+	if _, ok := r.URL.Query()["dry_run"]; ok {
+		dryRun, err = strconv.ParseBool(r.URL.Query().Get("dry_run"))
+		if err != nil {
+			sendAPIResponse(w, r, err, "invalid dry_run parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if !dryRun && isApprovalRequired(ApprovalOpRestore) {
+		content, err := io.ReadAll(r.Body)
+		if err != nil || len(content) == 0 {
+			if len(content) == 0 {
+				err = util.NewValidationError("request body is required")
+			}
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		req, err := newBulkImportApprovalRequest(content, objectType, format, claims.Username)
+		if err != nil {
+			sendAPIResponse(w, r, err, "Unable to save the approval request", getRespStatus(err))
+			return
+		}
+		requestApproval(w, r, req)
+		return
+	}
+	result, err := dataprovider.ImportBulk(r.Body, objectType, format, dryRun, claims.Username,
+		util.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, result)
+}
+
+func executeBulkImport(content []byte, objectType, format, executor, ipAddress string) error {
+	result, err := dataprovider.ImportBulk(bytes.NewReader(content), objectType, format, false, executor, ipAddress)
+	if err != nil {
+		return err
+	}
+	if result.Failed > 0 {
+		return util.NewValidationError(fmt.Sprintf("unable to import %d of %d %s, first error: %s",
+			result.Failed, result.Total, objectType, result.Errors[0].Error))
+	}
+	return nil
+}
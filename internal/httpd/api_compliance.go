@@ -0,0 +1,120 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func getComplianceExports(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	render.JSON(w, r, complianceMgr.GetAll())
+}
+
+func getComplianceExportByID(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	export, err := complianceMgr.Get(getURLParam(r, "id"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, export)
+}
+
+func startComplianceExport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		StartTimestamp int64 `json:"start_timestamp"`
+		EndTimestamp   int64 `json:"end_timestamp"`
+	}
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	export := complianceExport{
+		StartTime:   req.StartTimestamp,
+		EndTime:     req.EndTimestamp,
+		RequestedBy: claims.Username,
+	}
+	if err := export.validate(); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if !complianceMgr.Add(&export) {
+		sendAPIResponse(w, r, nil, "Another compliance export is already in progress", http.StatusConflict)
+		return
+	}
+	go export.run()
+
+	w.Header().Add("Location", fmt.Sprintf("%s/%s", complianceExportsPath, export.ID))
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, map[string]string{"id": export.ID, "message": "Compliance export started"})
+}
+
+func downloadComplianceExport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	export, err := complianceMgr.Get(getURLParam(r, "id"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if export.Status != ComplianceExportCompleted {
+		err = util.NewValidationError(fmt.Sprintf("compliance export %q is not completed, status: %q", export.ID,
+			export.Status))
+		sendAPIResponse(w, r, err, "", http.StatusConflict)
+		return
+	}
+	f, err := os.Open(export.filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			sendAPIResponse(w, r, err, "", http.StatusNotFound)
+			return
+		}
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.getFileName()))
+	w.Header().Set("X-SFTPGo-Content-SHA256", export.SHA256)
+	http.ServeContent(w, r, export.getFileName(), info.ModTime(), f)
+}
+
+func deleteComplianceExport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if err := complianceMgr.Delete(getURLParam(r, "id")); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Compliance export deleted", http.StatusOK)
+}
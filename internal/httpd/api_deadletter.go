@@ -0,0 +1,119 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func getDeadLetterFiltersFromRequest(r *http.Request) (dataprovider.DeadLetterFilters, error) {
+	filters := dataprovider.DeadLetterFilters{
+		Source: r.URL.Query().Get("source"),
+		Name:   r.URL.Query().Get("name"),
+	}
+	if _, ok := r.URL.Query()["start_timestamp"]; ok {
+		ts, err := strconv.ParseInt(r.URL.Query().Get("start_timestamp"), 10, 64)
+		if err != nil {
+			return filters, util.NewValidationError(fmt.Sprintf("invalid start_timestamp: %v", err))
+		}
+		filters.StartTimestamp = ts
+	}
+	if _, ok := r.URL.Query()["end_timestamp"]; ok {
+		ts, err := strconv.ParseInt(r.URL.Query().Get("end_timestamp"), 10, 64)
+		if err != nil {
+			return filters, util.NewValidationError(fmt.Sprintf("invalid end_timestamp: %v", err))
+		}
+		filters.EndTimestamp = ts
+	}
+	return filters, nil
+}
+
+func getDeadLetterIDFromRequest(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(getURLParam(r, "id"), 10, 64)
+	if err != nil {
+		return 0, util.NewValidationError(fmt.Sprintf("invalid dead letter id: %v", err))
+	}
+	return id, nil
+}
+
+func getDeadLetters(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	limit, offset, order, err := getSearchFilters(w, r)
+	if err != nil {
+		return
+	}
+	filters, err := getDeadLetterFiltersFromRequest(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	entries, err := dataprovider.GetDeadLetters(filters, limit, offset, order)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, entries)
+}
+
+func getDeadLetterByID(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	id, err := getDeadLetterIDFromRequest(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	entry, err := dataprovider.GetDeadLetter(id)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, entry)
+}
+
+func deleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	id, err := getDeadLetterIDFromRequest(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if err := dataprovider.DeleteDeadLetter(id); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Dead letter deleted", http.StatusOK)
+}
+
+func replayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	id, err := getDeadLetterIDFromRequest(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if err := common.ReplayDeadLetter(id); err != nil {
+		sendAPIResponse(w, r, err, "Unable to replay the dead letter", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Dead letter replayed and deleted", http.StatusOK)
+}
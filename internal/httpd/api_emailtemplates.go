@@ -0,0 +1,115 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/smtp"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+type emailTemplateInfo struct {
+	Name     string `json:"name"`
+	Language string `json:"language,omitempty"`
+	Custom   bool   `json:"custom"`
+	Content  string `json:"content,omitempty"`
+}
+
+func getEmailTemplates(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if !smtp.IsEnabled() {
+		sendAPIResponse(w, r, nil, "Email capabilities are not configured", http.StatusBadRequest)
+		return
+	}
+	available := smtp.GetTemplates()
+	templates := make([]emailTemplateInfo, 0, len(available))
+	for _, tmpl := range available {
+		templates = append(templates, emailTemplateInfo{
+			Name:     tmpl.Name,
+			Language: tmpl.Language,
+			Custom:   tmpl.Custom,
+		})
+	}
+	render.JSON(w, r, templates)
+}
+
+func getEmailTemplateByName(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if !smtp.IsEnabled() {
+		sendAPIResponse(w, r, nil, "Email capabilities are not configured", http.StatusBadRequest)
+		return
+	}
+	name := getURLParam(r, "name")
+	lang := util.NormalizeLanguage(r.URL.Query().Get("language"))
+	content, err := smtp.GetCustomTemplate(name, lang)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, emailTemplateInfo{
+		Name:     name,
+		Language: lang,
+		Custom:   true,
+		Content:  string(content),
+	})
+}
+
+func saveEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, smtp.MaxTemplateSize*2)
+	if !smtp.IsEnabled() {
+		sendAPIResponse(w, r, nil, "Email capabilities are not configured", http.StatusBadRequest)
+		return
+	}
+	var tmpl emailTemplateInfo
+	if err := render.DecodeJSON(r.Body, &tmpl); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if err := smtp.SaveCustomTemplate(getURLParam(r, "name"), r.URL.Query().Get("language"),
+		[]byte(tmpl.Content)); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Template saved", http.StatusOK)
+}
+
+func deleteEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if !smtp.IsEnabled() {
+		sendAPIResponse(w, r, nil, "Email capabilities are not configured", http.StatusBadRequest)
+		return
+	}
+	if err := smtp.DeleteCustomTemplate(getURLParam(r, "name"), r.URL.Query().Get("language")); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Template deleted", http.StatusOK)
+}
+
+func reloadEmailTemplates(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if !smtp.IsEnabled() {
+		sendAPIResponse(w, r, nil, "Email capabilities are not configured", http.StatusBadRequest)
+		return
+	}
+	if err := smtp.ReloadTemplates(); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Templates reloaded", http.StatusOK)
+}
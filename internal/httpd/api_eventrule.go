@@ -16,10 +16,13 @@ package httpd
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net/http"
 
 	"github.com/go-chi/render"
 
+	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
@@ -99,6 +102,8 @@ func updateEventAction(w http.ResponseWriter, r *http.Request) {
 	actionID := action.ID
 	name = action.Name
 	currentHTTPPassword := action.Options.HTTPConfig.Password
+	currentHTTPSigningSecret := action.Options.HTTPConfig.SigningSecret
+	currentPublishPassword := action.Options.PublishConfig.Password
 	action.Options = dataprovider.BaseEventActionOptions{}
 
 	err = render.DecodeJSON(r.Body, &action)
@@ -114,6 +119,13 @@ func updateEventAction(w http.ResponseWriter, r *http.Request) {
 		if action.Options.HTTPConfig.Password.IsNotPlainAndNotEmpty() {
 			action.Options.HTTPConfig.Password = currentHTTPPassword
 		}
+		if action.Options.HTTPConfig.SigningSecret.IsNotPlainAndNotEmpty() {
+			action.Options.HTTPConfig.SigningSecret = currentHTTPSigningSecret
+		}
+	case dataprovider.ActionTypePublish:
+		if action.Options.PublishConfig.Password.IsNotPlainAndNotEmpty() {
+			action.Options.PublishConfig.Password = currentPublishPassword
+		}
 	}
 
 	err = dataprovider.UpdateEventAction(&action, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
@@ -176,6 +188,17 @@ func getEventRuleByName(w http.ResponseWriter, r *http.Request) {
 	renderEventRule(w, r, name, http.StatusOK)
 }
 
+func getEventRuleRolloutStatus(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	name := getURLParam(r, "name")
+	rule, err := dataprovider.EventRuleExists(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, rule.GetRolloutStatus())
+}
+
 func addEventRule(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 
@@ -247,3 +270,25 @@ func deleteEventRule(w http.ResponseWriter, r *http.Request) {
 	}
 	sendAPIResponse(w, r, err, "Event rule deleted", http.StatusOK)
 }
+
+func runOnDemandEventRule(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	var params common.OnDemandRuleParams
+	// the request body is optional
+	if err = render.DecodeJSON(r.Body, &params); err != nil && !errors.Is(err, io.EOF) {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	name := getURLParam(r, "name")
+	err = common.RunOnDemandRule(name, params, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Event rule started", http.StatusAccepted)
+}
@@ -32,7 +32,12 @@ func getFolders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	folders, err := dataprovider.GetFolders(limit, offset, order, false)
+	var folders []vfs.BaseVirtualFolder
+	if getBoolQueryParam(r, "deleted") {
+		folders, err = dataprovider.GetSoftDeletedFolders(limit, offset, order)
+	} else {
+		folders, err = dataprovider.GetFolders(limit, offset, order, false)
+	}
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
 		return
@@ -78,18 +83,39 @@ func updateFolder(w http.ResponseWriter, r *http.Request) {
 	}
 	users := folder.Users
 	groups := folder.Groups
+	err = decodeFolderForUpdate(&folder, func(v any) error {
+		return render.DecodeJSON(r.Body, v)
+	})
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.UpdateFolder(&folder, users, groups, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Folder updated", http.StatusOK)
+}
+
+// decodeFolderForUpdate decodes the fields to update into the specified existing
+// folder. The secrets not included in the decoded data are preserved
+func decodeFolderForUpdate(folder *vfs.BaseVirtualFolder, decode func(v any) error) error {
 	folderID := folder.ID
-	name = folder.Name
+	name := folder.Name
 	currentS3AccessSecret := folder.FsConfig.S3Config.AccessSecret
 	currentAzAccountKey := folder.FsConfig.AzBlobConfig.AccountKey
 	currentAzSASUrl := folder.FsConfig.AzBlobConfig.SASURL
 	currentGCSCredentials := folder.FsConfig.GCSConfig.Credentials
+	currentGCSEncryptionKey := folder.FsConfig.GCSConfig.EncryptionKey
 	currentCryptoPassphrase := folder.FsConfig.CryptConfig.Passphrase
 	currentSFTPPassword := folder.FsConfig.SFTPConfig.Password
 	currentSFTPKey := folder.FsConfig.SFTPConfig.PrivateKey
 	currentSFTPKeyPassphrase := folder.FsConfig.SFTPConfig.KeyPassphrase
 	currentHTTPPassword := folder.FsConfig.HTTPConfig.Password
 	currentHTTPAPIKey := folder.FsConfig.HTTPConfig.APIKey
+	currentSMBPassword := folder.FsConfig.SMBConfig.Password
+	currentWebDAVPassword := folder.FsConfig.WebDAVConfig.Password
 
 	folder.FsConfig.S3Config = vfs.S3FsConfig{}
 	folder.FsConfig.AzBlobConfig = vfs.AzBlobFsConfig{}
@@ -97,23 +123,19 @@ func updateFolder(w http.ResponseWriter, r *http.Request) {
 	folder.FsConfig.CryptConfig = vfs.CryptFsConfig{}
 	folder.FsConfig.SFTPConfig = vfs.SFTPFsConfig{}
 	folder.FsConfig.HTTPConfig = vfs.HTTPFsConfig{}
-	err = render.DecodeJSON(r.Body, &folder)
-	if err != nil {
-		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
-		return
+	folder.FsConfig.SMBConfig = vfs.SMBFsConfig{}
+	folder.FsConfig.WebDAVConfig = vfs.WebDAVFsConfig{}
+	folder.Metadata = nil
+	if err := decode(folder); err != nil {
+		return err
 	}
 	folder.ID = folderID
 	folder.Name = name
 	folder.FsConfig.SetEmptySecretsIfNil()
 	updateEncryptedSecrets(&folder.FsConfig, currentS3AccessSecret, currentAzAccountKey, currentAzSASUrl, currentGCSCredentials,
-		currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey, currentSFTPKeyPassphrase, currentHTTPPassword,
-		currentHTTPAPIKey)
-	err = dataprovider.UpdateFolder(&folder, users, groups, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
-	if err != nil {
-		sendAPIResponse(w, r, err, "", getRespStatus(err))
-		return
-	}
-	sendAPIResponse(w, r, nil, "Folder updated", http.StatusOK)
+		currentGCSEncryptionKey, currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey, currentSFTPKeyPassphrase, currentHTTPPassword,
+		currentHTTPAPIKey, currentSMBPassword, currentWebDAVPassword)
+	return nil
 }
 
 func renderFolder(w http.ResponseWriter, r *http.Request, name string, status int) {
@@ -145,10 +167,53 @@ func deleteFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	name := getURLParam(r, "name")
-	err = dataprovider.DeleteFolder(name, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	cryptoShred, err := getCryptoShredFromRequest(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if isApprovalRequired(ApprovalOpDeleteFolder) {
+		if _, err := dataprovider.GetFolderByName(name); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		req := newApprovalRequest(ApprovalOpDeleteFolder, name, claims.Username)
+		req.CryptoShred = cryptoShred
+		requestApproval(w, r, req)
+		return
+	}
+	err = doDeleteFolder(name, cryptoShred, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
 	sendAPIResponse(w, r, err, "Folder deleted", http.StatusOK)
 }
+
+// doDeleteFolder deletes the specified folder. If cryptoShred is true, the encrypted
+// files are made irrecoverable before deleting it, the folder is not deleted if
+// crypto-shredding fails
+func restoreFolder(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	name := getURLParam(r, "name")
+	err = dataprovider.RestoreFolder(name, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Folder restored", http.StatusOK)
+}
+
+func doDeleteFolder(name string, cryptoShred bool, executor, ipAddress string) error {
+	if cryptoShred {
+		if err := dataprovider.CryptoShredFolder(name, executor, ipAddress); err != nil {
+			return err
+		}
+	}
+	return dataprovider.DeleteFolder(name, executor, ipAddress)
+}
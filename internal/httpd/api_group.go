@@ -77,18 +77,39 @@ func updateGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	users := group.Users
+	err = decodeGroupForUpdate(&group, func(v any) error {
+		return render.DecodeJSON(r.Body, v)
+	})
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.UpdateGroup(&group, users, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Group updated", http.StatusOK)
+}
+
+// decodeGroupForUpdate decodes the fields to update into the specified existing
+// group. The secrets not included in the decoded data are preserved
+func decodeGroupForUpdate(group *dataprovider.Group, decode func(v any) error) error {
 	groupID := group.ID
-	name = group.Name
+	name := group.Name
 	currentS3AccessSecret := group.UserSettings.FsConfig.S3Config.AccessSecret
 	currentAzAccountKey := group.UserSettings.FsConfig.AzBlobConfig.AccountKey
 	currentAzSASUrl := group.UserSettings.FsConfig.AzBlobConfig.SASURL
 	currentGCSCredentials := group.UserSettings.FsConfig.GCSConfig.Credentials
+	currentGCSEncryptionKey := group.UserSettings.FsConfig.GCSConfig.EncryptionKey
 	currentCryptoPassphrase := group.UserSettings.FsConfig.CryptConfig.Passphrase
 	currentSFTPPassword := group.UserSettings.FsConfig.SFTPConfig.Password
 	currentSFTPKey := group.UserSettings.FsConfig.SFTPConfig.PrivateKey
 	currentSFTPKeyPassphrase := group.UserSettings.FsConfig.SFTPConfig.KeyPassphrase
 	currentHTTPPassword := group.UserSettings.FsConfig.HTTPConfig.Password
 	currentHTTPAPIKey := group.UserSettings.FsConfig.HTTPConfig.APIKey
+	currentSMBPassword := group.UserSettings.FsConfig.SMBConfig.Password
+	currentWebDAVPassword := group.UserSettings.FsConfig.WebDAVConfig.Password
 
 	group.UserSettings.FsConfig.S3Config = vfs.S3FsConfig{}
 	group.UserSettings.FsConfig.AzBlobConfig = vfs.AzBlobFsConfig{}
@@ -96,23 +117,20 @@ func updateGroup(w http.ResponseWriter, r *http.Request) {
 	group.UserSettings.FsConfig.CryptConfig = vfs.CryptFsConfig{}
 	group.UserSettings.FsConfig.SFTPConfig = vfs.SFTPFsConfig{}
 	group.UserSettings.FsConfig.HTTPConfig = vfs.HTTPFsConfig{}
-	err = render.DecodeJSON(r.Body, &group)
-	if err != nil {
-		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
-		return
+	group.UserSettings.FsConfig.SMBConfig = vfs.SMBFsConfig{}
+	group.UserSettings.FsConfig.WebDAVConfig = vfs.WebDAVFsConfig{}
+	group.UserSettings.TransferQuotaReset = nil
+	group.Metadata = nil
+	if err := decode(group); err != nil {
+		return err
 	}
 	group.ID = groupID
 	group.Name = name
 	group.UserSettings.FsConfig.SetEmptySecretsIfNil()
 	updateEncryptedSecrets(&group.UserSettings.FsConfig, currentS3AccessSecret, currentAzAccountKey, currentAzSASUrl,
-		currentGCSCredentials, currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey, currentSFTPKeyPassphrase,
-		currentHTTPPassword, currentHTTPAPIKey)
-	err = dataprovider.UpdateGroup(&group, users, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
-	if err != nil {
-		sendAPIResponse(w, r, err, "", getRespStatus(err))
-		return
-	}
-	sendAPIResponse(w, r, nil, "Group updated", http.StatusOK)
+		currentGCSCredentials, currentGCSEncryptionKey, currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey, currentSFTPKeyPassphrase,
+		currentHTTPPassword, currentHTTPAPIKey, currentSMBPassword, currentWebDAVPassword)
+	return nil
 }
 
 func renderGroup(w http.ResponseWriter, r *http.Request, name string, status int) {
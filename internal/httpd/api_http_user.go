@@ -16,6 +16,7 @@ package httpd
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -30,8 +31,10 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 func getUserConnection(w http.ResponseWriter, r *http.Request) (*Connection, error) {
@@ -57,6 +60,8 @@ func getUserConnection(w http.ResponseWriter, r *http.Request) (*Connection, err
 			r.RemoteAddr, user),
 		request: r,
 	}
+	connection.SetTraceContext(r.Context())
+	connection.setImpersonation(&claims)
 	if err = common.Connections.Add(connection); err != nil {
 		sendAPIResponse(w, r, err, "Unable to add connection", http.StatusTooManyRequests)
 		return connection, err
@@ -155,6 +160,7 @@ func getUserFile(w http.ResponseWriter, r *http.Request) {
 		resp := apiResponse{
 			Error:   err.Error(),
 			Message: http.StatusText(status),
+			Code:    getAPIErrorCode(err, status),
 		}
 		ctx := r.Context()
 		if status != 0 {
@@ -164,18 +170,54 @@ func getUserFile(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func getFileDirMetadata(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if !r.URL.Query().Has("path") {
+		sendAPIResponse(w, r, errors.New("please set a path"), "", http.StatusBadRequest)
+		return
+	}
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	info, err := connection.Stat(name, 0)
+	if err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to get metadata for path %q", name), getMappedStatusCode(err))
+		return
+	}
+	mTime := util.GetTimeAsMsSinceEpoch(info.ModTime())
+	metadata := fileDirMetadata{
+		ModificationTime: &mTime,
+	}
+	customMetadata, err := connection.GetCustomMetadata(name)
+	if err != nil && !errors.Is(err, common.ErrOpUnsupported) {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to get metadata for path %q", name), getMappedStatusCode(err))
+		return
+	}
+	metadata.CustomMetadata = customMetadata
+	render.JSON(w, r, metadata)
+}
+
 func setFileDirMetadata(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 
-	metadata := make(map[string]int64)
+	var metadata fileDirMetadata
 	err := render.DecodeJSON(r.Body, &metadata)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
-	mTime, ok := metadata["modification_time"]
-	if !ok || !r.URL.Query().Has("path") {
-		sendAPIResponse(w, r, errors.New("please set a modification_time and a path"), "", http.StatusBadRequest)
+	if (metadata.ModificationTime == nil && len(metadata.CustomMetadata) == 0) || !r.URL.Query().Has("path") {
+		sendAPIResponse(w, r, errors.New("please set a modification_time or custom_metadata and a path"), "",
+			http.StatusBadRequest)
+		return
+	}
+	customMetadata, err := vfs.ValidateCustomMetadata(metadata.CustomMetadata)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
 
@@ -186,10 +228,15 @@ func setFileDirMetadata(w http.ResponseWriter, r *http.Request) {
 	defer common.Connections.Remove(connection.GetID())
 
 	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
-	attrs := common.StatAttributes{
-		Flags: common.StatAttrTimes,
-		Atime: util.GetTimeFromMsecSinceEpoch(mTime),
-		Mtime: util.GetTimeFromMsecSinceEpoch(mTime),
+	attrs := common.StatAttributes{}
+	if metadata.ModificationTime != nil {
+		attrs.Flags |= common.StatAttrTimes
+		attrs.Atime = util.GetTimeFromMsecSinceEpoch(*metadata.ModificationTime)
+		attrs.Mtime = util.GetTimeFromMsecSinceEpoch(*metadata.ModificationTime)
+	}
+	if len(customMetadata) > 0 {
+		attrs.Flags |= common.StatAttrMetadata
+		attrs.Metadata = customMetadata
 	}
 	err = connection.SetStat(name, &attrs)
 	if err != nil {
@@ -226,13 +273,19 @@ func uploadUserFile(w http.ResponseWriter, r *http.Request) {
 }
 
 func doUploadFile(w http.ResponseWriter, r *http.Request, connection *Connection, filePath string) error {
+	buf, releaseBuf, err := getUploadBuffer(w, r)
+	if err != nil {
+		return err
+	}
+	defer releaseBuf()
+
 	connection.User.CheckFsRoot(connection.ID) //nolint:errcheck
-	writer, err := connection.getFileWriter(filePath)
+	writer, err := connection.getFileWriter(filePath, r.ContentLength)
 	if err != nil {
 		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to write file %#v", filePath), getMappedStatusCode(err))
 		return err
 	}
-	_, err = io.Copy(writer, r.Body)
+	_, err = io.CopyBuffer(writer, r.Body, buf)
 	if err != nil {
 		writer.Close() //nolint:errcheck
 		sendAPIResponse(w, r, err, fmt.Sprintf("Error saving file %#v", filePath), getMappedStatusCode(err))
@@ -267,38 +320,85 @@ func uploadUserFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	t := newThrottledReader(r.Body, connection.User.UploadBandwidth, connection)
-	r.Body = t
-	err = r.ParseMultipartForm(maxMultipartMem)
+	reader, err := r.MultipartReader()
 	if err != nil {
-		connection.RemoveTransfer(t)
 		sendAPIResponse(w, r, err, "Unable to parse multipart form", http.StatusBadRequest)
 		return
 	}
-	connection.RemoveTransfer(t)
-	defer r.MultipartForm.RemoveAll() //nolint:errcheck
-
 	parentDir := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
-	files := r.MultipartForm.File["filenames"]
-	if len(files) == 0 {
-		sendAPIResponse(w, r, nil, "No files uploaded!", http.StatusBadRequest)
-		return
-	}
 	if getBoolQueryParam(r, "mkdir_parents") {
 		if err = connection.CheckParentDirs(parentDir); err != nil {
 			sendAPIResponse(w, r, err, "Error checking parent directories", getMappedStatusCode(err))
 			return
 		}
 	}
-	doUploadFiles(w, r, connection, parentDir, files)
+	doStreamUploadFiles(w, r, connection, parentDir, reader)
+}
+
+// doStreamUploadFiles saves the files sent as "filenames" parts of a multipart
+// body while the body is read, so only the upload buffer is held in memory.
+// It returns the number of uploaded files
+func doStreamUploadFiles(w http.ResponseWriter, r *http.Request, connection *Connection, parentDir string,
+	reader *multipart.Reader,
+) int {
+	buf, releaseBuf, err := getUploadBuffer(w, r)
+	if err != nil {
+		return 0
+	}
+	defer releaseBuf()
+
+	connection.User.CheckFsRoot(connection.ID) //nolint:errcheck
+	uploaded := 0
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			sendAPIResponse(w, r, err, "Unable to parse multipart form", http.StatusBadRequest)
+			return uploaded
+		}
+		fileName := part.FileName()
+		if part.FormName() != "filenames" || fileName == "" {
+			part.Close()
+			continue
+		}
+		filePath := path.Join(parentDir, path.Base(util.CleanPath(fileName)))
+		writer, err := connection.getFileWriter(filePath, 0)
+		if err != nil {
+			part.Close()
+			sendAPIResponse(w, r, err, fmt.Sprintf("Unable to write file %#v", fileName), getMappedStatusCode(err))
+			return uploaded
+		}
+		_, err = io.CopyBuffer(writer, part, buf)
+		part.Close()
+		if err != nil {
+			writer.Close() //nolint:errcheck
+			sendAPIResponse(w, r, err, fmt.Sprintf("Error saving file %#v", fileName), getMappedStatusCode(err))
+			return uploaded
+		}
+		err = writer.Close()
+		if err != nil {
+			sendAPIResponse(w, r, err, fmt.Sprintf("Error closing file %#v", fileName), getMappedStatusCode(err))
+			return uploaded
+		}
+		uploaded++
+	}
+	if uploaded == 0 {
+		sendAPIResponse(w, r, nil, "No files uploaded!", http.StatusBadRequest)
+		return uploaded
+	}
+	sendAPIResponse(w, r, nil, "Upload completed", http.StatusCreated)
+	return uploaded
 }
 
 func doUploadFiles(w http.ResponseWriter, r *http.Request, connection *Connection, parentDir string,
-	files []*multipart.FileHeader,
+	files []*multipart.FileHeader, buf []byte,
 ) int {
 	connection.User.CheckFsRoot(connection.ID) //nolint:errcheck
 	uploaded := 0
 	connection.User.UploadBandwidth = 0
+	connection.User.Filters.BandwidthSchedules = nil
 	for _, f := range files {
 		file, err := f.Open()
 		if err != nil {
@@ -308,12 +408,12 @@ func doUploadFiles(w http.ResponseWriter, r *http.Request, connection *Connectio
 		defer file.Close()
 
 		filePath := path.Join(parentDir, path.Base(util.CleanPath(f.Filename)))
-		writer, err := connection.getFileWriter(filePath)
+		writer, err := connection.getFileWriter(filePath, f.Size)
 		if err != nil {
 			sendAPIResponse(w, r, err, fmt.Sprintf("Unable to write file %#v", f.Filename), getMappedStatusCode(err))
 			return uploaded
 		}
-		_, err = io.Copy(writer, file)
+		_, err = io.CopyBuffer(writer, file, buf)
 		if err != nil {
 			writer.Close() //nolint:errcheck
 			sendAPIResponse(w, r, err, fmt.Sprintf("Error saving file %#v", f.Filename), getMappedStatusCode(err))
@@ -335,6 +435,35 @@ func renameUserFile(w http.ResponseWriter, r *http.Request) {
 	renameItem(w, r)
 }
 
+func copyUserFile(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	source := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	target := connection.User.GetCleanedPath(r.URL.Query().Get("target"))
+	overwrite := false
+	if val := r.URL.Query().Get("overwrite"); val != "" {
+		overwrite, err = strconv.ParseBool(val)
+		if err != nil {
+			sendAPIResponse(w, r, err, "Invalid overwrite parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := connection.CopyFile(source, target, overwrite); err != nil {
+		statusCode := getMappedStatusCode(err)
+		if errors.Is(err, os.ErrExist) {
+			statusCode = http.StatusConflict
+		}
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to copy %q -> %q", source, target), statusCode)
+		return
+	}
+	sendAPIResponse(w, r, nil, fmt.Sprintf("%q copied to %q", source, target), http.StatusOK)
+}
+
 func deleteUserFile(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	connection, err := getUserConnection(w, r)
@@ -371,6 +500,139 @@ func deleteUserFile(w http.ResponseWriter, r *http.Request) {
 	sendAPIResponse(w, r, nil, fmt.Sprintf("File %#v deleted", name), http.StatusOK)
 }
 
+func getUserFileArchiveStatus(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	if name == "/" {
+		sendAPIResponse(w, r, nil, "Please set the path to a valid file", http.StatusBadRequest)
+		return
+	}
+	status, err := connection.GetArchiveStatus(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to get the archive status for file %q", name),
+			getMappedStatusCode(err))
+		return
+	}
+	render.JSON(w, r, status)
+}
+
+func restoreUserFile(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	if name == "/" {
+		sendAPIResponse(w, r, nil, "Please set the path to a valid file", http.StatusBadRequest)
+		return
+	}
+	var days int
+	if val := r.URL.Query().Get("days"); val != "" {
+		days, err = strconv.Atoi(val)
+		if err != nil {
+			sendAPIResponse(w, r, err, "Invalid days", http.StatusBadRequest)
+			return
+		}
+	}
+	status, err := connection.RestoreArchivedObject(name, days)
+	if err != nil {
+		statusCode := getMappedStatusCode(err)
+		if _, ok := err.(*util.ValidationError); ok {
+			statusCode = http.StatusBadRequest
+		}
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to restore file %q", name), statusCode)
+		return
+	}
+	if status.Restoring {
+		render.Status(r, http.StatusAccepted)
+	}
+	render.JSON(w, r, status)
+}
+
+func getUserFileVersions(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	if name == "/" {
+		sendAPIResponse(w, r, nil, "Please set the path to a valid file", http.StatusBadRequest)
+		return
+	}
+	versions, err := connection.ListFileVersions(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to get the versions for file %q", name),
+			getMappedStatusCode(err))
+		return
+	}
+	if versions == nil {
+		versions = []vfs.FileVersion{}
+	}
+	render.JSON(w, r, versions)
+}
+
+func getUserFileVersion(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	versionID := r.URL.Query().Get("version_id")
+	if name == "/" || versionID == "" {
+		sendAPIResponse(w, r, nil, "Please set the path to a valid file and the version_id", http.StatusBadRequest)
+		return
+	}
+	if status, err := downloadFileVersion(w, r, connection, name, versionID); err != nil {
+		resp := apiResponse{
+			Error:   err.Error(),
+			Message: http.StatusText(status),
+			Code:    getAPIErrorCode(err, status),
+		}
+		ctx := r.Context()
+		if status != 0 {
+			ctx = context.WithValue(ctx, render.StatusCtxKey, status)
+		}
+		render.JSON(w, r.WithContext(ctx), resp)
+	}
+}
+
+func restoreUserFileVersion(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	versionID := r.URL.Query().Get("version_id")
+	if name == "/" || versionID == "" {
+		sendAPIResponse(w, r, nil, "Please set the path to a valid file and the version_id", http.StatusBadRequest)
+		return
+	}
+	if err := connection.RestoreFileVersion(name, versionID); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to restore version %q for file %q", versionID, name),
+			getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Version restored", http.StatusOK)
+}
+
 func getUserFilesAsZipStream(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	connection, err := getUserConnection(w, r)
@@ -415,12 +677,34 @@ func getUserProfile(w http.ResponseWriter, r *http.Request) {
 			Email:           user.Email,
 			Description:     user.Description,
 			AllowAPIKeyAuth: user.Filters.AllowAPIKeyAuth,
+			Theme:           user.Filters.Theme,
+			Language:        user.Filters.Language,
 		},
 		PublicKeys: user.PublicKeys,
 	}
 	render.JSON(w, r, resp)
 }
 
+func getCurrentUserFoldersQuota(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	quota, err := dataprovider.GetUserFoldersQuota(&user)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, quota)
+}
+
 func updateUserProfile(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	claims, err := getTokenClaims(r)
@@ -439,7 +723,8 @@ func updateUserProfile(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
-	if !userMerged.CanManagePublicKeys() && !userMerged.CanChangeAPIKeyAuth() && !userMerged.CanChangeInfo() {
+	if !userMerged.CanManagePublicKeys() && !userMerged.CanChangeAPIKeyAuth() && !userMerged.CanChangeInfo() &&
+		req.Theme == user.Filters.Theme && req.Language == user.Filters.Language {
 		sendAPIResponse(w, r, nil, "You are not allowed to change anything", http.StatusForbidden)
 		return
 	}
@@ -453,6 +738,8 @@ func updateUserProfile(w http.ResponseWriter, r *http.Request) {
 		user.Email = req.Email
 		user.Description = req.Description
 	}
+	user.Filters.Theme = req.Theme
+	user.Filters.Language = req.Language
 	if err := dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSelf, util.GetIPFromRemoteAddress(r.RemoteAddr)); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -460,6 +747,54 @@ func updateUserProfile(w http.ResponseWriter, r *http.Request) {
 	sendAPIResponse(w, r, err, "Profile updated", http.StatusOK)
 }
 
+func generateUserS3Credentials(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	user, userMerged, err := dataprovider.GetUserVariants(claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if util.Contains(userMerged.Filters.DeniedProtocols, common.ProtocolS3) {
+		sendAPIResponse(w, r, nil, "The S3 protocol is not allowed for your account", http.StatusForbidden)
+		return
+	}
+	secret := base64.RawStdEncoding.EncodeToString(util.GenerateRandomBytes(30))
+	user.Filters.S3SecretAccessKey = kms.NewPlainSecret(secret)
+	if err := dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSelf, util.GetIPFromRemoteAddress(r.RemoteAddr)); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, s3Credentials{
+		AccessKeyID:     user.Username,
+		SecretAccessKey: secret,
+	})
+}
+
+func deleteUserS3Credentials(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.UserExists(claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	user.Filters.S3SecretAccessKey = nil
+	if err := dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSelf, util.GetIPFromRemoteAddress(r.RemoteAddr)); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "S3 credentials removed", http.StatusOK)
+}
+
 func changeUserPassword(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 
@@ -538,3 +873,40 @@ func renameItem(w http.ResponseWriter, r *http.Request) {
 	}
 	sendAPIResponse(w, r, nil, fmt.Sprintf("%#v renamed to %#v", oldName, newName), http.StatusOK)
 }
+
+func userSpeedTestDownload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	size, err := common.GetSpeedTestSize(r.URL.Query().Get("size"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	result, err := common.WriteSpeedTestData(w, size)
+	connection.Log(logger.LevelDebug, "download speed test completed, %s, error: %v", result.String(), err)
+}
+
+func userSpeedTestUpload(w http.ResponseWriter, r *http.Request) {
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	result, err := common.DiscardSpeedTestData(r.Body)
+	connection.Log(logger.LevelDebug, "upload speed test completed, %s, error: %v", result.String(), err)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to complete the speed test", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, result)
+}
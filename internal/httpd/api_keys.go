@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/render"
 
@@ -25,6 +26,81 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
+type apiKeyRoute struct {
+	path     string
+	resource string
+	// if set, the access level required regardless of the HTTP method
+	access string
+}
+
+// apiKeyRoutes maps the REST API routes, and their sub routes, to the resources
+// used to define granular API key scopes
+var apiKeyRoutes = []apiKeyRoute{
+	{path: versionPath, resource: dataprovider.APIKeyResourceStatus},
+	{path: serverStatusPath, resource: dataprovider.APIKeyResourceStatus},
+	{path: activeConnectionsPath, resource: dataprovider.APIKeyResourceConnections},
+	{path: clientVersionsPath, resource: dataprovider.APIKeyResourceConnections},
+	{path: quotasBasePath, resource: dataprovider.APIKeyResourceQuota},
+	{path: userPath, resource: dataprovider.APIKeyResourceUsers},
+	{path: folderPath, resource: dataprovider.APIKeyResourceFolders},
+	{path: groupPath, resource: dataprovider.APIKeyResourceGroups},
+	{path: adminPath, resource: dataprovider.APIKeyResourceAdmins},
+	{path: defenderHosts, resource: dataprovider.APIKeyResourceDefender},
+	{path: defenderFeeds, resource: dataprovider.APIKeyResourceDefender},
+	{path: retentionBasePath, resource: dataprovider.APIKeyResourceRetention},
+	{path: metadataBasePath, resource: dataprovider.APIKeyResourceMetadata},
+	{path: fsEventsPath, resource: dataprovider.APIKeyResourceEvents},
+	{path: providerEventsPath, resource: dataprovider.APIKeyResourceEvents},
+	{path: auditPath, resource: dataprovider.APIKeyResourceEvents},
+	{path: auditLogExportPath, resource: dataprovider.APIKeyResourceEvents},
+	{path: eventActionsPath, resource: dataprovider.APIKeyResourceEventRules},
+	{path: eventRulesPath, resource: dataprovider.APIKeyResourceEventRules},
+	{path: deadLettersPath, resource: dataprovider.APIKeyResourceEventRules},
+	{path: dumpDataPath, resource: dataprovider.APIKeyResourceSystem},
+	{path: loadDataPath, resource: dataprovider.APIKeyResourceSystem, access: dataprovider.APIKeyAccessWrite},
+	{path: dbBackupPath, resource: dataprovider.APIKeyResourceSystem},
+	{path: bulkPath, resource: dataprovider.APIKeyResourceSystem},
+	{path: emailTemplatesPath, resource: dataprovider.APIKeyResourceSystem},
+	{path: smtpTestPath, resource: dataprovider.APIKeyResourceSystem},
+	{path: jobsPath, resource: dataprovider.APIKeyResourceSystem},
+	{path: hostKeysPath, resource: dataprovider.APIKeyResourceSystem},
+	{path: complianceExportsPath, resource: dataprovider.APIKeyResourceSystem},
+	{path: userFoldersQuotaPath, resource: dataprovider.APIKeyResourceQuota},
+	{path: userSharesPath, resource: dataprovider.APIKeyResourceShares},
+	{path: userDirsPath, resource: dataprovider.APIKeyResourceFiles},
+	{path: userFilesPath, resource: dataprovider.APIKeyResourceFiles},
+	{path: userStreamZipPath, resource: dataprovider.APIKeyResourceFiles, access: dataprovider.APIKeyAccessRead},
+	{path: userSpeedTestPath, resource: dataprovider.APIKeyResourceFiles},
+	{path: userTUSPath, resource: dataprovider.APIKeyResourceFiles},
+}
+
+// isAPIKeyAllowed returns true if the granular scopes of the provided API key
+// allow the request. Routes not mapped to any resource are only allowed for
+// keys without granular scopes
+func isAPIKeyAllowed(k *dataprovider.APIKey, r *http.Request) bool {
+	if len(k.Scopes) == 0 {
+		return true
+	}
+	for _, route := range apiKeyRoutes {
+		if r.URL.Path != route.path && !strings.HasPrefix(r.URL.Path, route.path+"/") {
+			continue
+		}
+		switch route.access {
+		case dataprovider.APIKeyAccessRead:
+			return k.HasScopeAccess(route.resource, false)
+		case dataprovider.APIKeyAccessWrite:
+			return k.HasScopeAccess(route.resource, true)
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return k.HasScopeAccess(route.resource, false)
+		default:
+			return k.HasScopeAccess(route.resource, true)
+		}
+	}
+	return false
+}
+
 func getAPIKeys(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	limit, offset, order, err := getSearchFilters(w, r)
@@ -62,7 +62,12 @@ func dumpData(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if outputData != "1" {
-		var err error
+		// saving a backup on the server side is not a read-only operation
+		claims, err := getTokenClaims(r)
+		if err != nil || !claims.hasPerm(dataprovider.PermAdminManageSystem) {
+			sendAPIResponse(w, r, err, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
 		outputFile, err = validateBackupFile(outputFile)
 		if err != nil {
 			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
@@ -109,6 +114,27 @@ func dumpData(w http.ResponseWriter, r *http.Request) {
 	sendAPIResponse(w, r, err, "Data saved", http.StatusOK)
 }
 
+func backupDatabase(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	outputFile, err := validateBackupFile(strings.TrimSpace(r.URL.Query().Get("output-file")))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(outputFile), 0700)
+	if err != nil {
+		logger.Error(logSender, "", "database backup error: %v, output file: %q", err, outputFile)
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	logger.Debug(logSender, "", "saving database backup to: %q", outputFile)
+	if err := dataprovider.BackupDatabase(outputFile); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Database backup saved", http.StatusOK)
+}
+
 func loadDataFromRequest(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, MaxRestoreSize)
 	claims, err := getTokenClaims(r)
@@ -130,6 +156,15 @@ func loadDataFromRequest(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	if isApprovalRequired(ApprovalOpRestore) {
+		req, err := newRestoreApprovalRequest(content, "", scanQuota, mode, claims.Username)
+		if err != nil {
+			sendAPIResponse(w, r, err, "Unable to save the approval request", getRespStatus(err))
+			return
+		}
+		requestApproval(w, r, req)
+		return
+	}
 	if err := restoreBackup(content, "", scanQuota, mode, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr)); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 	}
@@ -168,6 +203,15 @@ func loadData(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	if isApprovalRequired(ApprovalOpRestore) {
+		req, err := newRestoreApprovalRequest(content, inputFile, scanQuota, mode, claims.Username)
+		if err != nil {
+			sendAPIResponse(w, r, err, "Unable to save the approval request", getRespStatus(err))
+			return
+		}
+		requestApproval(w, r, req)
+		return
+	}
 	if err := restoreBackup(content, inputFile, scanQuota, mode, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr)); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 	}
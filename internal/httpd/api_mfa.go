@@ -17,17 +17,24 @@ package httpd
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
+	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
+const numRecoveryCodes = 12
+
 var (
 	errRecoveryCodeForbidden = errors.New("recovery codes are not available with two-factor authentication disabled")
 )
@@ -54,6 +61,14 @@ type recoveryCode struct {
 	Used bool   `json:"used"`
 }
 
+type generateRecoveryCodesRequest struct {
+	// The current password or a TOTP passcode are required if the
+	// re-verification policy is enabled
+	Password  string `json:"password"`
+	Passcode  string `json:"passcode"`
+	SendEmail bool   `json:"send_email"`
+}
+
 func getTOTPConfigs(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	render.JSON(w, r, mfa.GetAvailableTOTPConfigs())
@@ -121,6 +136,13 @@ func saveTOTPConfig(w http.ResponseWriter, r *http.Request) {
 			sendAPIResponse(w, r, err, "", getRespStatus(err))
 			return
 		}
+		if claims.MustSetTwoFactorAuth {
+			// force logout
+			defer func() {
+				c := jwtTokenClaims{}
+				c.removeCookie(w, r, webBaseAdminPath)
+			}()
+		}
 	}
 
 	sendAPIResponse(w, r, nil, "TOTP configuration saved", http.StatusOK)
@@ -157,7 +179,7 @@ func getRecoveryCodes(w http.ResponseWriter, r *http.Request) {
 			sendAPIResponse(w, r, err, "", getRespStatus(err))
 			return
 		}
-		if !user.Filters.TOTPConfig.Enabled {
+		if !user.Filters.TOTPConfig.Enabled && len(user.Filters.WebAuthnCredentials) == 0 {
 			sendAPIResponse(w, r, errRecoveryCodeForbidden, "", http.StatusForbidden)
 			return
 		}
@@ -168,7 +190,7 @@ func getRecoveryCodes(w http.ResponseWriter, r *http.Request) {
 			sendAPIResponse(w, r, err, "", getRespStatus(err))
 			return
 		}
-		if !admin.Filters.TOTPConfig.Enabled {
+		if !admin.Filters.TOTPConfig.Enabled && len(admin.Filters.WebAuthnCredentials) == 0 {
 			sendAPIResponse(w, r, errRecoveryCodeForbidden, "", http.StatusForbidden)
 			return
 		}
@@ -195,25 +217,48 @@ func generateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
 		return
 	}
-	recoveryCodes := make([]string, 0, 12)
-	accountRecoveryCodes := make([]dataprovider.RecoveryCode, 0, 12)
-	for i := 0; i < 12; i++ {
-		code := getNewRecoveryCode()
-		recoveryCodes = append(recoveryCodes, code)
-		accountRecoveryCodes = append(accountRecoveryCodes, dataprovider.RecoveryCode{Secret: kms.NewPlainSecret(code)})
+	// the request body is optional
+	var req generateRecoveryCodesRequest
+	if r.ContentLength != 0 {
+		if err := render.DecodeJSON(r.Body, &req); err != nil && !errors.Is(err, io.EOF) {
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return
+		}
 	}
+	recoveryCodes, accountRecoveryCodes := getNewRecoveryCodes()
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	var email, lang string
 	if claims.hasUserAudience() {
 		user, err := dataprovider.UserExists(claims.Username)
 		if err != nil {
 			sendAPIResponse(w, r, err, "", getRespStatus(err))
 			return
 		}
-		if !user.Filters.TOTPConfig.Enabled {
+		if !user.Filters.TOTPConfig.Enabled && len(user.Filters.WebAuthnCredentials) == 0 {
 			sendAPIResponse(w, r, errRecoveryCodeForbidden, "", http.StatusForbidden)
 			return
 		}
+		if mfa.IsRecoveryCodesReverificationRequired() {
+			verified := false
+			if req.Password != "" {
+				_, err = dataprovider.CheckUserAndPass(user.Username, req.Password, ipAddr, getProtocolFromRequest(r))
+				verified = err == nil
+			} else {
+				verified = isRecoveryCodesPasscodeValid(user.Filters.TOTPConfig.Enabled,
+					user.Filters.TOTPConfig.ConfigName, user.Filters.TOTPConfig.Secret, req.Passcode)
+			}
+			if !verified {
+				sendAPIResponse(w, r, errRecoveryCodesReverification(), "", http.StatusForbidden)
+				return
+			}
+		}
+		email, lang = user.Email, user.Filters.Language
+		if err := checkRecoveryCodesEmail(req.SendEmail, email); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
 		user.Filters.RecoveryCodes = accountRecoveryCodes
-		if err := dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSelf, util.GetIPFromRemoteAddress(r.RemoteAddr)); err != nil {
+		if err := dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSelf, ipAddr); err != nil {
 			sendAPIResponse(w, r, err, "", getRespStatus(err))
 			return
 		}
@@ -223,20 +268,115 @@ func generateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
 			sendAPIResponse(w, r, err, "", getRespStatus(err))
 			return
 		}
-		if !admin.Filters.TOTPConfig.Enabled {
+		if !admin.Filters.TOTPConfig.Enabled && len(admin.Filters.WebAuthnCredentials) == 0 {
 			sendAPIResponse(w, r, errRecoveryCodeForbidden, "", http.StatusForbidden)
 			return
 		}
+		if mfa.IsRecoveryCodesReverificationRequired() {
+			verified := false
+			if req.Password != "" {
+				verified, _ = admin.CheckPassword(req.Password)
+			} else {
+				verified = isRecoveryCodesPasscodeValid(admin.Filters.TOTPConfig.Enabled,
+					admin.Filters.TOTPConfig.ConfigName, admin.Filters.TOTPConfig.Secret, req.Passcode)
+			}
+			if !verified {
+				sendAPIResponse(w, r, errRecoveryCodesReverification(), "", http.StatusForbidden)
+				return
+			}
+		}
+		email, lang = admin.Email, admin.Filters.Language
+		if err := checkRecoveryCodesEmail(req.SendEmail, email); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
 		admin.Filters.RecoveryCodes = accountRecoveryCodes
-		if err := dataprovider.UpdateAdmin(&admin, dataprovider.ActionExecutorSelf, util.GetIPFromRemoteAddress(r.RemoteAddr)); err != nil {
+		if err := dataprovider.UpdateAdmin(&admin, dataprovider.ActionExecutorSelf, ipAddr); err != nil {
 			sendAPIResponse(w, r, err, "", getRespStatus(err))
 			return
 		}
 	}
+	if req.SendEmail {
+		if err := sendRecoveryCodesEmail(r, claims.Username, email, lang, recoveryCodes); err != nil {
+			sendAPIResponse(w, r, err, "Recovery codes generated but not sent via email, please generate them again",
+				getRespStatus(err))
+			return
+		}
+	}
 
 	render.JSON(w, r, recoveryCodes)
 }
 
+func errRecoveryCodesReverification() error {
+	return util.NewValidationError("please confirm your identity providing your current password or a two-factor authentication passcode")
+}
+
+// isRecoveryCodesPasscodeValid returns true if the TOTP passcode is valid for
+// the given configuration
+func isRecoveryCodesPasscodeValid(totpEnabled bool, configName string, secret *kms.Secret, passcode string) bool {
+	if !totpEnabled || passcode == "" || secret == nil {
+		return false
+	}
+	if err := secret.Decrypt(); err != nil {
+		return false
+	}
+	match, err := mfa.ValidateTOTPPasscode(configName, passcode, secret.GetPayload())
+	return match && err == nil
+}
+
+// isRecoveryCodesEmailRecoveryEnabled returns true if the users who lost
+// their second factor can receive new recovery codes via email
+func isRecoveryCodesEmailRecoveryEnabled() bool {
+	return mfa.IsRecoveryCodesEmailRecoveryAllowed() && smtp.IsEnabled()
+}
+
+func checkRecoveryCodesEmail(sendEmail bool, email string) error {
+	if !sendEmail {
+		return nil
+	}
+	if !smtp.IsEnabled() {
+		return util.NewValidationError("unable to send the recovery codes via email, SMTP is not configured")
+	}
+	if email == "" {
+		return util.NewValidationError("unable to send the recovery codes via email, no email address is configured for your account")
+	}
+	return nil
+}
+
+// getNewRecoveryCodes returns the new recovery codes as plain text and as
+// secrets to store in the user or admin filters
+func getNewRecoveryCodes() ([]string, []dataprovider.RecoveryCode) {
+	recoveryCodes := make([]string, 0, numRecoveryCodes)
+	accountRecoveryCodes := make([]dataprovider.RecoveryCode, 0, numRecoveryCodes)
+	for i := 0; i < numRecoveryCodes; i++ {
+		code := getNewRecoveryCode()
+		recoveryCodes = append(recoveryCodes, code)
+		accountRecoveryCodes = append(accountRecoveryCodes, dataprovider.RecoveryCode{Secret: kms.NewPlainSecret(code)})
+	}
+	return recoveryCodes, accountRecoveryCodes
+}
+
+func sendRecoveryCodesEmail(r *http.Request, username, email, lang string, recoveryCodes []string) error {
+	data := make(map[string]any)
+	data["Username"] = username
+	data["RecoveryCodes"] = recoveryCodes
+	body, err := smtp.RenderRecoveryCodesBody(lang, data)
+	if err != nil {
+		logger.Warn(logSender, middleware.GetReqID(r.Context()), "unable to render recovery codes template: %v", err)
+		return util.NewGenericError("Unable to render recovery codes template")
+	}
+	startTime := time.Now()
+	subject := fmt.Sprintf("Recovery codes for %q", username)
+	if err := smtp.SendEmailBody(smtp.EmailAddresses{To: []string{email}}, subject, body); err != nil {
+		logger.Warn(logSender, middleware.GetReqID(r.Context()), "unable to send recovery codes via email: %v, elapsed: %v",
+			err, time.Since(startTime))
+		return util.NewGenericError("Unable to send the recovery codes via email, please try again later")
+	}
+	logger.Debug(logSender, middleware.GetReqID(r.Context()), "recovery codes sent via email to %q, email: %q, elapsed: %v",
+		username, email, time.Since(startTime))
+	return nil
+}
+
 func getNewRecoveryCode() string {
 	return fmt.Sprintf("RC-%v", strings.ToUpper(util.GenerateUniqueID()))
 }
@@ -268,7 +408,7 @@ func saveUserTOTPConfig(username string, r *http.Request, recoveryCodes []datapr
 		if user.CountUnusedRecoveryCodes() < 5 && user.Filters.TOTPConfig.Enabled {
 			user.Filters.RecoveryCodes = recoveryCodes
 		}
-	} else {
+	} else if len(user.Filters.WebAuthnCredentials) == 0 {
 		user.Filters.RecoveryCodes = nil
 	}
 	return dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSelf, util.GetIPFromRemoteAddress(r.RemoteAddr))
@@ -289,11 +429,15 @@ func saveAdminTOTPConfig(username string, r *http.Request, recoveryCodes []datap
 		if admin.CountUnusedRecoveryCodes() < 5 && admin.Filters.TOTPConfig.Enabled {
 			admin.Filters.RecoveryCodes = recoveryCodes
 		}
-	} else {
+	} else if len(admin.Filters.WebAuthnCredentials) == 0 {
 		admin.Filters.RecoveryCodes = nil
 	}
 	if admin.Filters.TOTPConfig.Secret == nil || !admin.Filters.TOTPConfig.Secret.IsPlain() {
 		admin.Filters.TOTPConfig.Secret = currentTOTPSecret
 	}
+	if !admin.Filters.TOTPConfig.Enabled && mfa.GetAdminPolicy() == mfa.AdminPolicyRequireTwoFactor &&
+		!admin.HasWebAuthnCredentials() {
+		return util.NewValidationError("two-factor authentication is required by the configured policy")
+	}
 	return dataprovider.UpdateAdmin(&admin, dataprovider.ActionExecutorSelf, util.GetIPFromRemoteAddress(r.RemoteAddr))
 }
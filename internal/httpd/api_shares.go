@@ -28,6 +28,7 @@ import (
 	"github.com/rs/xid"
 	"github.com/sftpgo/sdk"
 
+	"github.com/drakkan/sftpgo/v2/internal/auditlog"
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
@@ -246,6 +247,7 @@ func (s *httpdServer) downloadBrowsableSharedFile(w http.ResponseWriter, r *http
 		resp := apiResponse{
 			Error:   err.Error(),
 			Message: http.StatusText(status),
+			Code:    getAPIErrorCode(err, status),
 		}
 		ctx := r.Context()
 		if status != 0 {
@@ -306,6 +308,7 @@ func (s *httpdServer) downloadFromShare(w http.ResponseWriter, r *http.Request)
 		resp := apiResponse{
 			Error:   err.Error(),
 			Message: http.StatusText(status),
+			Code:    getAPIErrorCode(err, status),
 		}
 		ctx := r.Context()
 		if status != 0 {
@@ -370,9 +373,31 @@ func (s *httpdServer) uploadFilesToShare(w http.ResponseWriter, r *http.Request)
 	}
 	defer common.Connections.Remove(connection.GetID())
 
-	t := newThrottledReader(r.Body, connection.User.UploadBandwidth, connection)
+	if share.MaxTokens == 0 {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			sendAPIResponse(w, r, err, "Unable to parse multipart form", http.StatusBadRequest)
+			return
+		}
+		numUploads := doStreamUploadFiles(w, r, connection, share.Paths[0], reader)
+		if numUploads > 0 {
+			dataprovider.UpdateShareLastUse(&share, numUploads) //nolint:errcheck
+		}
+		return
+	}
+	// the number of files must be known before uploading to a share with
+	// limited usage, the form is parsed keeping in memory at most the
+	// upload buffer size, the files exceeding this size are stored on disk
+	buf, releaseBuf, err := getUploadBuffer(w, r)
+	if err != nil {
+		return
+	}
+	defer releaseBuf()
+
+	uploadBandwidth, _ := connection.GetBandwidth(time.Now())
+	t := newThrottledReader(r.Body, uploadBandwidth, connection)
 	r.Body = t
-	err = r.ParseMultipartForm(maxMultipartMem)
+	err = r.ParseMultipartForm(uploadsConf.getBufferSize())
 	if err != nil {
 		connection.RemoveTransfer(t)
 		sendAPIResponse(w, r, err, "Unable to parse multipart form", http.StatusBadRequest)
@@ -386,15 +411,13 @@ func (s *httpdServer) uploadFilesToShare(w http.ResponseWriter, r *http.Request)
 		sendAPIResponse(w, r, nil, "No files uploaded!", http.StatusBadRequest)
 		return
 	}
-	if share.MaxTokens > 0 {
-		if len(files) > (share.MaxTokens - share.UsedTokens) {
-			sendAPIResponse(w, r, nil, "Allowed usage exceeded", http.StatusBadRequest)
-			return
-		}
+	if len(files) > (share.MaxTokens - share.UsedTokens) {
+		sendAPIResponse(w, r, nil, "Allowed usage exceeded", http.StatusBadRequest)
+		return
 	}
 	dataprovider.UpdateShareLastUse(&share, len(files)) //nolint:errcheck
 
-	numUploads := doUploadFiles(w, r, connection, share.Paths[0], files)
+	numUploads := doUploadFiles(w, r, connection, share.Paths[0], files, buf)
 	if numUploads != len(files) {
 		dataprovider.UpdateShareLastUse(&share, numUploads-len(files)) //nolint:errcheck
 	}
@@ -402,6 +425,28 @@ func (s *httpdServer) uploadFilesToShare(w http.ResponseWriter, r *http.Request)
 
 func (s *httpdServer) checkPublicShare(w http.ResponseWriter, r *http.Request, validScopes []dataprovider.ShareScope,
 	isWebClient bool,
+) (dataprovider.Share, *Connection, error) {
+	share, connection, err := s.validatePublicShare(w, r, validScopes, isWebClient)
+	record := auditlog.Record{
+		Event:    auditlog.EventShareAccess,
+		Username: share.Username,
+		IP:       util.GetIPFromRemoteAddress(r.RemoteAddr),
+		Protocol: common.ProtocolHTTPShare,
+		Details: map[string]string{
+			"share_id": getURLParam(r, "id"),
+			"method":   r.Method,
+			"path":     r.URL.Path,
+		},
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	auditlog.Add(record)
+	return share, connection, err
+}
+
+func (s *httpdServer) validatePublicShare(w http.ResponseWriter, r *http.Request, validScopes []dataprovider.ShareScope,
+	isWebClient bool,
 ) (dataprovider.Share, *Connection, error) {
 	renderError := func(err error, message string, statusCode int) {
 		if isWebClient {
@@ -456,6 +501,7 @@ func (s *httpdServer) checkPublicShare(w http.ResponseWriter, r *http.Request, v
 			r.RemoteAddr, user),
 		request: r,
 	}
+	connection.SetTraceContext(r.Context())
 
 	return share, connection, nil
 }
@@ -465,7 +511,7 @@ func getUserForShare(share dataprovider.Share) (dataprovider.User, error) {
 	if err != nil {
 		return user, err
 	}
-	if !user.CanManageShares() {
+	if !user.CanManageShares() || user.IsSoftDeleted() {
 		return user, util.NewRecordNotFoundError("this share does not exist")
 	}
 	if share.Password == "" && util.Contains(user.Filters.WebClient, sdk.WebClientShareNoPasswordDisabled) {
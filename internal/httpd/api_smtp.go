@@ -0,0 +1,55 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/smtp"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+type smtpTestRequest struct {
+	Recipient string `json:"recipient"`
+}
+
+func testSMTPConfig(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if !smtp.IsEnabled() {
+		sendAPIResponse(w, r, nil, "Email capabilities are not configured", http.StatusBadRequest)
+		return
+	}
+	var req smtpTestRequest
+	err := render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if !util.IsEmailValid(req.Recipient) {
+		sendAPIResponse(w, r, util.NewValidationError(fmt.Sprintf("invalid recipient %q", req.Recipient)), "",
+			http.StatusBadRequest)
+		return
+	}
+	if err := smtp.SendTestEmail(req.Recipient); err != nil {
+		logger.Warn(logSender, "", "unable to send test email to %q: %v", req.Recipient, err)
+		sendAPIResponse(w, r, err, "Unable to send the test email", http.StatusInternalServerError)
+		return
+	}
+	sendAPIResponse(w, r, nil, "Test email sent", http.StatusOK)
+}
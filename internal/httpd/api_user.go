@@ -38,7 +38,12 @@ func getUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	users, err := dataprovider.GetUsers(limit, offset, order)
+	var users []dataprovider.User
+	if getBoolQueryParam(r, "deleted") {
+		users, err = dataprovider.GetSoftDeletedUsers(limit, offset, order)
+	} else {
+		users, err = dataprovider.GetUsers(limit, offset, order)
+	}
 	if err == nil {
 		render.JSON(w, r, users)
 	} else {
@@ -103,6 +108,7 @@ func disableUser2FA(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user.Filters.RecoveryCodes = nil
+	user.Filters.WebAuthnCredentials = nil
 	user.Filters.TOTPConfig = dataprovider.UserTOTPConfig{
 		Enabled: false,
 	}
@@ -136,21 +142,51 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	err = decodeUserForUpdate(&user, func(v any) error {
+		return render.DecodeJSON(r.Body, v)
+	})
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.UpdateUser(&user, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, err, "User updated", http.StatusOK)
+	if disconnect == 1 {
+		disconnectUser(user.Username)
+	}
+}
+
+// decodeUserForUpdate decodes the fields to update into the specified existing
+// user. Secrets, permissions and the second factor authentication settings not
+// included in the decoded data are preserved
+func decodeUserForUpdate(user *dataprovider.User, decode func(v any) error) error {
 	userID := user.ID
-	username = user.Username
+	username := user.Username
 	totpConfig := user.Filters.TOTPConfig
 	recoveryCodes := user.Filters.RecoveryCodes
+	webAuthnCredentials := user.Filters.WebAuthnCredentials
+	passwordChangedAt := user.Filters.PasswordChangedAt
+	passwordHistory := user.Filters.PasswordHistory
 	currentPermissions := user.Permissions
 	currentS3AccessSecret := user.FsConfig.S3Config.AccessSecret
 	currentAzAccountKey := user.FsConfig.AzBlobConfig.AccountKey
 	currentAzSASUrl := user.FsConfig.AzBlobConfig.SASURL
 	currentGCSCredentials := user.FsConfig.GCSConfig.Credentials
+	currentGCSEncryptionKey := user.FsConfig.GCSConfig.EncryptionKey
 	currentCryptoPassphrase := user.FsConfig.CryptConfig.Passphrase
 	currentSFTPPassword := user.FsConfig.SFTPConfig.Password
 	currentSFTPKey := user.FsConfig.SFTPConfig.PrivateKey
 	currentSFTPKeyPassphrase := user.FsConfig.SFTPConfig.KeyPassphrase
 	currentHTTPPassword := user.FsConfig.HTTPConfig.Password
 	currentHTTPAPIKey := user.FsConfig.HTTPConfig.APIKey
+	currentSMBPassword := user.FsConfig.SMBConfig.Password
+	currentWebDAVPassword := user.FsConfig.WebDAVConfig.Password
+	currentS3SecretAccessKey := user.Filters.S3SecretAccessKey
+	currentSFTPCredentials := user.Filters.SFTPCredentials
 
 	user.Permissions = make(map[string][]string)
 	user.FsConfig.S3Config = vfs.S3FsConfig{}
@@ -159,38 +195,77 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	user.FsConfig.CryptConfig = vfs.CryptFsConfig{}
 	user.FsConfig.SFTPConfig = vfs.SFTPFsConfig{}
 	user.FsConfig.HTTPConfig = vfs.HTTPFsConfig{}
+	user.FsConfig.SMBConfig = vfs.SMBFsConfig{}
+	user.FsConfig.WebDAVConfig = vfs.WebDAVFsConfig{}
 	user.Filters.TOTPConfig = dataprovider.UserTOTPConfig{}
 	user.Filters.RecoveryCodes = nil
+	user.Filters.WebAuthnCredentials = nil
+	user.Filters.S3SecretAccessKey = nil
+	user.Filters.SFTPCredentials = nil
+	user.Filters.ClientPolicy = nil
+	user.Filters.UploadSizeLimits = nil
+	user.Filters.MultipartUploads = nil
+	user.Filters.TransferQuotaReset = nil
 	user.VirtualFolders = nil
-	err = render.DecodeJSON(r.Body, &user)
-	if err != nil {
-		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
-		return
+	user.Metadata = nil
+	if err := decode(user); err != nil {
+		return err
 	}
 	user.ID = userID
 	user.Username = username
 	user.Filters.TOTPConfig = totpConfig
 	user.Filters.RecoveryCodes = recoveryCodes
+	user.Filters.WebAuthnCredentials = webAuthnCredentials
+	user.Filters.PasswordChangedAt = passwordChangedAt
+	user.Filters.PasswordHistory = passwordHistory
+	// we use the new S3 secret access key if plain or empty, otherwise the old value
+	if user.Filters.S3SecretAccessKey == nil || user.Filters.S3SecretAccessKey.IsNotPlainAndNotEmpty() {
+		user.Filters.S3SecretAccessKey = currentS3SecretAccessKey
+	}
+	updateSFTPRemoteCredentialsSecrets(user.Filters.SFTPCredentials, currentSFTPCredentials)
 	user.SetEmptySecretsIfNil()
 	// we use new Permissions if passed otherwise the old ones
 	if len(user.Permissions) == 0 {
 		user.Permissions = currentPermissions
 	}
 	updateEncryptedSecrets(&user.FsConfig, currentS3AccessSecret, currentAzAccountKey, currentAzSASUrl,
-		currentGCSCredentials, currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey, currentSFTPKeyPassphrase,
-		currentHTTPPassword, currentHTTPAPIKey)
-	err = dataprovider.UpdateUser(&user, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
+		currentGCSCredentials, currentGCSEncryptionKey, currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey,
+		currentSFTPKeyPassphrase, currentHTTPPassword, currentHTTPAPIKey, currentSMBPassword, currentWebDAVPassword)
+	return nil
+}
+
+func deleteUser(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	username := getURLParam(r, "username")
+	cryptoShred, err := getCryptoShredFromRequest(r)
 	if err != nil {
-		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
-	sendAPIResponse(w, r, err, "User updated", http.StatusOK)
-	if disconnect == 1 {
-		disconnectUser(user.Username)
+	if isApprovalRequired(ApprovalOpDeleteUser) {
+		if _, err := dataprovider.UserExists(username); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		req := newApprovalRequest(ApprovalOpDeleteUser, username, claims.Username)
+		req.CryptoShred = cryptoShred
+		requestApproval(w, r, req)
+		return
 	}
+	err = doDeleteUser(username, cryptoShred, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, err, "User deleted", http.StatusOK)
 }
 
-func deleteUser(w http.ResponseWriter, r *http.Request) {
+func restoreUser(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
@@ -198,13 +273,44 @@ func deleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	username := getURLParam(r, "username")
-	err = dataprovider.DeleteUser(username, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	err = dataprovider.RestoreUser(username, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
-	sendAPIResponse(w, r, err, "User deleted", http.StatusOK)
+	sendAPIResponse(w, r, nil, "User restored", http.StatusOK)
+}
+
+// doDeleteUser deletes the specified user and disconnects it. If cryptoShred is
+// true, the user is disconnected and its encrypted files are made irrecoverable
+// before deleting it, the user is not deleted if crypto-shredding fails
+func doDeleteUser(username string, cryptoShred bool, executor, ipAddress string) error {
+	if cryptoShred {
+		if _, err := dataprovider.UserExists(username); err != nil {
+			return err
+		}
+		disconnectUser(dataprovider.ConvertName(username))
+		if err := dataprovider.CryptoShredUser(username, executor, ipAddress); err != nil {
+			return err
+		}
+	}
+	if err := dataprovider.DeleteUser(username, executor, ipAddress); err != nil {
+		return err
+	}
 	disconnectUser(dataprovider.ConvertName(username))
+	return nil
+}
+
+func getCryptoShredFromRequest(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("crypto_shred")
+	if value == "" {
+		return false, nil
+	}
+	cryptoShred, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, util.NewValidationError(fmt.Sprintf("invalid crypto_shred parameter %q", value))
+	}
+	return cryptoShred, nil
 }
 
 func forgotUserPassword(w http.ResponseWriter, r *http.Request) {
@@ -250,8 +356,9 @@ func disconnectUser(username string) {
 }
 
 func updateEncryptedSecrets(fsConfig *vfs.Filesystem, currentS3AccessSecret, currentAzAccountKey, currentAzSASUrl,
-	currentGCSCredentials, currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey, currentSFTPKeyPassphrase,
-	currentHTTPPassword, currentHTTPAPIKey *kms.Secret) {
+	currentGCSCredentials, currentGCSEncryptionKey, currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey,
+	currentSFTPKeyPassphrase, currentHTTPPassword, currentHTTPAPIKey, currentSMBPassword, currentWebDAVPassword *kms.Secret,
+) {
 	// we use the new access secret if plain or empty, otherwise the old value
 	switch fsConfig.Provider {
 	case sdk.S3FilesystemProvider:
@@ -271,6 +378,9 @@ func updateEncryptedSecrets(fsConfig *vfs.Filesystem, currentS3AccessSecret, cur
 		if !fsConfig.GCSConfig.Credentials.IsPlain() {
 			fsConfig.GCSConfig.Credentials = currentGCSCredentials
 		}
+		if fsConfig.GCSConfig.EncryptionKey.IsNotPlainAndNotEmpty() {
+			fsConfig.GCSConfig.EncryptionKey = currentGCSEncryptionKey
+		}
 	case sdk.CryptedFilesystemProvider:
 		if fsConfig.CryptConfig.Passphrase.IsNotPlainAndNotEmpty() {
 			fsConfig.CryptConfig.Passphrase = currentCryptoPassphrase
@@ -279,6 +389,14 @@ func updateEncryptedSecrets(fsConfig *vfs.Filesystem, currentS3AccessSecret, cur
 		updateSFTPFsEncryptedSecrets(fsConfig, currentSFTPPassword, currentSFTPKey, currentSFTPKeyPassphrase)
 	case sdk.HTTPFilesystemProvider:
 		updateHTTPFsEncryptedSecrets(fsConfig, currentHTTPPassword, currentHTTPAPIKey)
+	case vfs.SMBFilesystemProvider:
+		if fsConfig.SMBConfig.Password.IsNotPlainAndNotEmpty() {
+			fsConfig.SMBConfig.Password = currentSMBPassword
+		}
+	case vfs.WebDAVFilesystemProvider:
+		if fsConfig.WebDAVConfig.Password.IsNotPlainAndNotEmpty() {
+			fsConfig.WebDAVConfig.Password = currentWebDAVPassword
+		}
 	}
 }
 
@@ -296,6 +414,30 @@ func updateSFTPFsEncryptedSecrets(fsConfig *vfs.Filesystem, currentSFTPPassword,
 	}
 }
 
+// updateSFTPRemoteCredentialsSecrets restores the current secrets for the
+// remote credentials not updated in plain text
+func updateSFTPRemoteCredentialsSecrets(credentials, currentCredentials []dataprovider.SFTPRemoteCredentials) {
+	getSecret := func(secret, current *kms.Secret) *kms.Secret {
+		if secret != nil && secret.IsNotPlainAndNotEmpty() {
+			return current
+		}
+		return secret
+	}
+	for idx := range credentials {
+		c := &credentials[idx]
+		var current dataprovider.SFTPRemoteCredentials
+		for _, cur := range currentCredentials {
+			if cur.FolderName == c.FolderName {
+				current = cur
+				break
+			}
+		}
+		c.Password = getSecret(c.Password, current.Password)
+		c.PrivateKey = getSecret(c.PrivateKey, current.PrivateKey)
+		c.KeyPassphrase = getSecret(c.KeyPassphrase, current.KeyPassphrase)
+	}
+}
+
 func updateHTTPFsEncryptedSecrets(fsConfig *vfs.Filesystem, currentHTTPPassword, currentHTTPAPIKey *kms.Secret) {
 	if fsConfig.HTTPConfig.Password.IsNotPlainAndNotEmpty() {
 		fsConfig.HTTPConfig.Password = currentHTTPPassword
@@ -15,13 +15,11 @@
 package httpd
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
-	"mime"
 	"net/http"
 	"net/url"
 	"os"
@@ -38,11 +36,13 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/i18n"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 type pwdChange struct {
@@ -59,6 +59,8 @@ type baseProfile struct {
 	Email           string `json:"email,omitempty"`
 	Description     string `json:"description,omitempty"`
 	AllowAPIKeyAuth bool   `json:"allow_api_key_auth"`
+	Theme           string `json:"theme,omitempty"`
+	Language        string `json:"language,omitempty"`
 }
 
 type adminProfile struct {
@@ -70,6 +72,107 @@ type userProfile struct {
 	PublicKeys []string `json:"public_keys,omitempty"`
 }
 
+type fileDirMetadata struct {
+	ModificationTime *int64            `json:"modification_time,omitempty"`
+	CustomMetadata   map[string]string `json:"custom_metadata,omitempty"`
+}
+
+type s3Credentials struct {
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+}
+
+// machine readable error codes returned within the API error responses,
+// they are part of the public API and must not be changed
+const (
+	apiErrorCodeValidation         = "validation_error"
+	apiErrorCodeNotFound           = "not_found"
+	apiErrorCodeMethodDisabled     = "method_disabled"
+	apiErrorCodePermissionDenied   = "permission_denied"
+	apiErrorCodeQuotaExceeded      = "quota_exceeded"
+	apiErrorCodeReadQuotaExceeded  = "read_quota_exceeded"
+	apiErrorCodeIPNotAllowed       = "ip_not_allowed"
+	apiErrorCodeInvalidCredentials = "invalid_credentials"
+	apiErrorCodeOpUnsupported      = "unsupported_operation"
+	apiErrorCodeNotImplemented     = "not_implemented"
+	apiErrorCodeRequestTooLarge    = "request_too_large"
+	apiErrorCodeBadRequest         = "bad_request"
+	apiErrorCodeUnauthorized       = "unauthorized"
+	apiErrorCodeForbidden          = "forbidden"
+	apiErrorCodeConflict           = "conflict"
+	apiErrorCodeObjectArchived     = "object_archived"
+	apiErrorCodeTooManyRequests    = "too_many_requests"
+	apiErrorCodeUnavailable        = "service_unavailable"
+	apiErrorCodeInternal           = "internal_error"
+)
+
+// getAPIErrorCode returns the error code for the specified error and HTTP status code.
+// The error is checked first, if it does not match a known error, the code is derived
+// from the HTTP status. An empty code is returned for non error responses
+func getAPIErrorCode(err error, statusCode int) string {
+	if statusCode > 0 && statusCode < http.StatusBadRequest {
+		return ""
+	}
+	switch err.(type) {
+	case *util.ValidationError:
+		return apiErrorCodeValidation
+	case *util.RecordNotFoundError:
+		return apiErrorCodeNotFound
+	case *util.MethodDisabledError:
+		return apiErrorCodeMethodDisabled
+	}
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrQuotaExceeded):
+		return apiErrorCodeQuotaExceeded
+	case errors.Is(err, common.ErrReadQuotaExceeded):
+		return apiErrorCodeReadQuotaExceeded
+	case errors.Is(err, dataprovider.ErrLoginNotAllowedFromIP):
+		return apiErrorCodeIPNotAllowed
+	case errors.Is(err, dataprovider.ErrInvalidCredentials):
+		return apiErrorCodeInvalidCredentials
+	case errors.Is(err, dataprovider.ErrProviderUnavailable):
+		return apiErrorCodeUnavailable
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, common.ErrPermissionDenied):
+		return apiErrorCodePermissionDenied
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, common.ErrNotExist):
+		return apiErrorCodeNotFound
+	case errors.Is(err, common.ErrOpUnsupported):
+		return apiErrorCodeOpUnsupported
+	case errors.Is(err, vfs.ErrObjectArchived):
+		return apiErrorCodeObjectArchived
+	case errors.Is(err, plugin.ErrNoSearcher), errors.Is(err, dataprovider.ErrNotImplemented):
+		return apiErrorCodeNotImplemented
+	case errors.As(err, &maxBytesErr):
+		return apiErrorCodeRequestTooLarge
+	}
+	switch statusCode {
+	case 0:
+		return ""
+	case http.StatusUnauthorized:
+		return apiErrorCodeUnauthorized
+	case http.StatusForbidden:
+		return apiErrorCodeForbidden
+	case http.StatusNotFound:
+		return apiErrorCodeNotFound
+	case http.StatusConflict:
+		return apiErrorCodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return apiErrorCodeRequestTooLarge
+	case http.StatusTooManyRequests:
+		return apiErrorCodeTooManyRequests
+	case http.StatusNotImplemented:
+		return apiErrorCodeNotImplemented
+	case http.StatusServiceUnavailable:
+		return apiErrorCodeUnavailable
+	}
+	if statusCode >= http.StatusInternalServerError {
+		return apiErrorCodeInternal
+	}
+	return apiErrorCodeBadRequest
+}
+
 func sendAPIResponse(w http.ResponseWriter, r *http.Request, err error, message string, code int) {
 	var errorString string
 	if _, ok := err.(*util.RecordNotFoundError); ok {
@@ -77,9 +180,11 @@ func sendAPIResponse(w http.ResponseWriter, r *http.Request, err error, message
 	} else if err != nil {
 		errorString = err.Error()
 	}
+	lang := getAPIRequestLanguage(r)
 	resp := apiResponse{
-		Error:   errorString,
-		Message: message,
+		Error:   i18n.T(lang, errorString),
+		Message: i18n.T(lang, message),
+		Code:    getAPIErrorCode(err, code),
 	}
 	ctx := context.WithValue(r.Context(), render.StatusCtxKey, code)
 	render.JSON(w, r.WithContext(ctx), resp)
@@ -104,6 +209,9 @@ func getRespStatus(err error) int {
 	if errors.Is(err, plugin.ErrNoSearcher) || errors.Is(err, dataprovider.ErrNotImplemented) {
 		return http.StatusNotImplemented
 	}
+	if errors.Is(err, dataprovider.ErrProviderUnavailable) {
+		return http.StatusServiceUnavailable
+	}
 	return http.StatusInternalServerError
 }
 
@@ -121,6 +229,10 @@ func getMappedStatusCode(err error) int {
 		statusCode = http.StatusRequestEntityTooLarge
 	case errors.Is(err, common.ErrOpUnsupported):
 		statusCode = http.StatusBadRequest
+	case errors.Is(err, vfs.ErrObjectArchived):
+		statusCode = http.StatusConflict
+	case errors.Is(err, common.ErrTooManyPendingRestores):
+		statusCode = http.StatusTooManyRequests
 	default:
 		statusCode = http.StatusInternalServerError
 	}
@@ -167,6 +279,11 @@ func getActiveConnections(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, stats)
 }
 
+func getClientVersions(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	render.JSON(w, r, common.GetClientVersions())
+}
+
 func handleCloseConnection(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	claims, err := getTokenClaims(r)
@@ -429,7 +546,7 @@ func downloadFile(w http.ResponseWriter, r *http.Request, connection *Connection
 	}
 	reader, err := connection.getFileReader(name, offset, r.Method)
 	if err != nil {
-		return getMappedStatusCode(err), fmt.Errorf("unable to read file %#v: %v", name, err)
+		return getMappedStatusCode(err), fmt.Errorf("unable to read file %#v: %w", name, err)
 	}
 	defer reader.Close()
 
@@ -437,22 +554,22 @@ func downloadFile(w http.ResponseWriter, r *http.Request, connection *Connection
 	if checkPreconditions(w, r, info.ModTime()) {
 		return 0, fmt.Errorf("%v", http.StatusText(http.StatusPreconditionFailed))
 	}
-	ctype := mime.TypeByExtension(path.Ext(name))
-	if ctype == "" {
-		ctype = "application/octet-stream"
-	}
+	ctype, inline := downloadsConf.getDownloadPolicy(name, inline)
 	if responseStatus == http.StatusPartialContent {
 		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+size-1, info.Size()))
 	}
 	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
 	w.Header().Set("Content-Type", ctype)
+	if downloadsConf.SafeMode {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
 	if !inline {
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%#v", path.Base(name)))
 	}
 	w.Header().Set("Accept-Ranges", "bytes")
 	w.WriteHeader(responseStatus)
 	if r.Method != http.MethodHead {
-		_, err = io.CopyN(w, reader, size)
+		err = copyFileToResponse(w, reader, size)
 		if err != nil {
 			if share != nil {
 				dataprovider.UpdateShareLastUse(share, -1) //nolint:errcheck
@@ -464,6 +581,50 @@ func downloadFile(w http.ResponseWriter, r *http.Request, connection *Connection
 	return http.StatusOK, nil
 }
 
+// copyFileToResponse sends size bytes from reader to w using the zero-copy
+// path if the download is eligible
+func copyFileToResponse(w io.Writer, reader io.Reader, size int64) error {
+	if f, ok := reader.(*httpdFile); ok {
+		n, err := f.ZeroCopyTo(w, size)
+		if !errors.Is(err, common.ErrOpUnsupported) {
+			if err == nil && n < size {
+				err = io.EOF
+			}
+			return err
+		}
+	}
+	_, err := io.CopyN(w, reader, size)
+	return err
+}
+
+func downloadFileVersion(w http.ResponseWriter, r *http.Request, connection *Connection, name, versionID string) (int, error) {
+	connection.User.CheckFsRoot(connection.ID) //nolint:errcheck
+	fs, fsPath, version, err := connection.GetFileVersion(name, versionID)
+	if err != nil {
+		return getMappedStatusCode(err), fmt.Errorf("unable to get version %q for file %q: %w", versionID, name, err)
+	}
+	reader, err := connection.getFileVersionReader(fs, fsPath, name, versionID)
+	if err != nil {
+		return getMappedStatusCode(err), fmt.Errorf("unable to read version %q for file %q: %w", versionID, name, err)
+	}
+	defer reader.Close()
+
+	ctype, _ := downloadsConf.getDownloadPolicy(name, false)
+	w.Header().Set("Last-Modified", util.GetTimeFromMsecSinceEpoch(version.ModTime).UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.FormatInt(version.Size, 10))
+	w.Header().Set("Content-Type", ctype)
+	if downloadsConf.SafeMode {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(name)))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.CopyN(w, reader, version.Size); err != nil {
+		connection.Log(logger.LevelDebug, "error reading file version to download: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+	return http.StatusOK, nil
+}
+
 func checkPreconditions(w http.ResponseWriter, r *http.Request, modtime time.Time) bool {
 	if checkIfUnmodifiedSince(r, modtime) == condFalse {
 		w.WriteHeader(http.StatusPreconditionFailed)
@@ -589,7 +750,6 @@ func parseRangeRequest(bytesRange string, size int64) (int64, int64, error) {
 }
 
 func updateLoginMetrics(user *dataprovider.User, loginMethod, ip string, err error) {
-	metric.AddLoginAttempt(loginMethod)
 	var protocol string
 	switch loginMethod {
 	case dataprovider.LoginMethodIDP:
@@ -597,13 +757,20 @@ func updateLoginMetrics(user *dataprovider.User, loginMethod, ip string, err err
 	default:
 		protocol = common.ProtocolHTTP
 	}
+	updateProtocolLoginMetrics(user, loginMethod, protocol, ip, err)
+}
+
+func updateProtocolLoginMetrics(user *dataprovider.User, loginMethod, protocol, ip string, err error) {
+	metric.AddLoginAttempt(loginMethod)
 	if err != nil && err != common.ErrInternalFailure && err != common.ErrNoCredentials {
-		logger.ConnectionFailedLog(user.Username, ip, loginMethod, protocol, err.Error())
+		logger.ConnectionFailedLog(user.Username, ip, common.GetCountryForIP(ip), loginMethod, protocol, err.Error())
 		event := common.HostEventLoginFailed
 		if _, ok := err.(*util.RecordNotFoundError); ok {
 			event = common.HostEventUserNotFound
 		}
-		common.AddDefenderEvent(ip, event)
+		if !errors.Is(err, dataprovider.ErrProviderUnavailable) {
+			common.AddDefenderEvent(ip, event)
+		}
 	}
 	metric.AddLoginResult(loginMethod, err)
 	dataprovider.ExecutePostLoginHook(user, loginMethod, ip, protocol, err)
@@ -614,6 +781,10 @@ func checkHTTPClientUser(user *dataprovider.User, r *http.Request, connectionID
 		logger.Info(logSender, connectionID, "cannot login user %#v, protocol HTTP is not allowed", user.Username)
 		return fmt.Errorf("protocol HTTP is not allowed for user %#v", user.Username)
 	}
+	// the login restrictions for the user do not apply to the admins impersonating it
+	if getImpersonatorFromToken(r) != "" {
+		return nil
+	}
 	if !isLoggedInWithOIDC(r) && !user.IsLoginMethodAllowed(dataprovider.LoginMethodPassword, common.ProtocolHTTP, nil) {
 		logger.Info(logSender, connectionID, "cannot login user %#v, password login method is not allowed", user.Username)
 		return fmt.Errorf("login method password is not allowed for user %#v", user.Username)
@@ -630,11 +801,16 @@ func checkHTTPClientUser(user *dataprovider.User, r *http.Request, connectionID
 		logger.Info(logSender, connectionID, "cannot login user %#v, remote address is not allowed: %v", user.Username, r.RemoteAddr)
 		return fmt.Errorf("login for user %#v is not allowed from this address: %v", user.Username, r.RemoteAddr)
 	}
+	if !user.IsClientAllowed(r.UserAgent()) {
+		common.AddDeniedClientVersion(common.ProtocolHTTP, r.UserAgent())
+		logger.Info(logSender, connectionID, "cannot login user %q, client %q is not allowed", user.Username, r.UserAgent())
+		return fmt.Errorf("client %q is not allowed for user %q", r.UserAgent(), user.Username)
+	}
 	return nil
 }
 
 func handleForgotPassword(r *http.Request, username string, isAdmin bool) error {
-	var email, subject string
+	var email, subject, lang string
 	var err error
 	var admin dataprovider.Admin
 	var user dataprovider.User
@@ -645,10 +821,12 @@ func handleForgotPassword(r *http.Request, username string, isAdmin bool) error
 	if isAdmin {
 		admin, err = dataprovider.AdminExists(username)
 		email = admin.Email
+		lang = admin.Filters.Language
 		subject = fmt.Sprintf("Email Verification Code for admin %#v", username)
 	} else {
 		user, err = dataprovider.GetUserWithGroupSettings(username)
 		email = user.Email
+		lang = user.Filters.Language
 		subject = fmt.Sprintf("Email Verification Code for user %#v", username)
 		if err == nil {
 			if !isUserAllowedToResetPassword(r, &user) {
@@ -668,15 +846,15 @@ func handleForgotPassword(r *http.Request, username string, isAdmin bool) error
 		return util.NewValidationError("Your account does not have an email address, it is not possible to reset your password by sending an email verification code")
 	}
 	c := newResetCode(username, isAdmin)
-	body := new(bytes.Buffer)
 	data := make(map[string]string)
 	data["Code"] = c.Code
-	if err := smtp.RenderPasswordResetTemplate(body, data); err != nil {
+	body, err := smtp.RenderPasswordResetBody(lang, data)
+	if err != nil {
 		logger.Warn(logSender, middleware.GetReqID(r.Context()), "unable to render password reset template: %v", err)
 		return util.NewGenericError("Unable to render password reset template")
 	}
 	startTime := time.Now()
-	if err := smtp.SendEmail([]string{email}, subject, body.String(), smtp.EmailContentTypeTextHTML); err != nil {
+	if err := smtp.SendEmailBody(smtp.EmailAddresses{To: []string{email}}, subject, body); err != nil {
 		logger.Warn(logSender, middleware.GetReqID(r.Context()), "unable to send password reset code via email: %v, elapsed: %v",
 			err, time.Since(startTime))
 		return util.NewGenericError(fmt.Sprintf("Unable to send confirmation code via email: %v", err))
@@ -703,7 +881,7 @@ func handleResetPassword(r *http.Request, code, newPassword string, isAdmin bool
 	if err != nil {
 		return &admin, &user, util.NewValidationError("confirmation code not found")
 	}
-	if resetCode.IsAdmin != isAdmin {
+	if resetCode.IsAdmin != isAdmin || resetCode.IsMagicLink {
 		return &admin, &user, util.NewValidationError("invalid confirmation code")
 	}
 	if isAdmin {
@@ -749,7 +927,7 @@ func isUserAllowedToResetPassword(r *http.Request, user *dataprovider.User) bool
 	if !user.IsLoginFromAddrAllowed(r.RemoteAddr) {
 		return false
 	}
-	return true
+	return user.IsClientAllowed(r.UserAgent())
 }
 
 func getProtocolFromRequest(r *http.Request) string {
@@ -0,0 +1,519 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported operations that can require a two-person approval
+const (
+	ApprovalOpDeleteUser   = "delete_user"
+	ApprovalOpDeleteFolder = "delete_folder"
+	ApprovalOpRestore      = "restore"
+)
+
+var (
+	supportedApprovalOps = []string{ApprovalOpDeleteUser, ApprovalOpDeleteFolder, ApprovalOpRestore}
+	approvalLifespan     = 30 * time.Minute
+	approvalRequiredOps  []string
+	approvalsMgr         approvalManager
+)
+
+// ApprovalConfig defines the destructive operations that must be confirmed
+// by a second admin before they are executed
+type ApprovalConfig struct {
+	// Operations requiring a second admin confirmation. Supported values:
+	// "delete_user", "delete_folder", "restore". Empty means disabled
+	Operations []string `json:"operations" mapstructure:"operations"`
+	// Time window, in minutes, for the second admin to confirm a pending
+	// operation. After this window the request expires
+	ValidityTime int `json:"validity_time" mapstructure:"validity_time"`
+}
+
+func (c *ApprovalConfig) validate() error {
+	for _, op := range c.Operations {
+		if !util.Contains(supportedApprovalOps, op) {
+			return fmt.Errorf("invalid approval operation %#v", op)
+		}
+	}
+	if len(c.Operations) > 0 && c.ValidityTime <= 0 {
+		return fmt.Errorf("invalid approval validity time: %d", c.ValidityTime)
+	}
+	return nil
+}
+
+func (c *ApprovalConfig) initialize() {
+	approvalRequiredOps = util.RemoveDuplicates(c.Operations, false)
+	if c.ValidityTime > 0 {
+		approvalLifespan = time.Duration(c.ValidityTime) * time.Minute
+	}
+}
+
+func isApprovalRequired(op string) bool {
+	return util.Contains(approvalRequiredOps, op)
+}
+
+type approvalManager interface {
+	Add(req *approvalRequest) error
+	Get(id string) (*approvalRequest, error)
+	List() ([]*approvalRequest, error)
+	Delete(id string) error
+	Cleanup()
+}
+
+func newApprovalManager(isShared int) approvalManager {
+	if isShared == 1 {
+		logger.Info(logSender, "", "using provider approval manager")
+		return &dbApprovalManager{}
+	}
+	logger.Info(logSender, "", "using memory approval manager")
+	return &memoryApprovalManager{}
+}
+
+// approvalRequest defines a pending destructive operation
+type approvalRequest struct {
+	ID           string    `json:"id"`
+	Operation    string    `json:"operation"`
+	Target       string    `json:"target,omitempty"`
+	RequestedBy  string    `json:"requested_by"`
+	ScanQuota    int       `json:"scan_quota,omitempty"`
+	RestoreMode  int       `json:"mode,omitempty"`
+	InputFile    string    `json:"input_file,omitempty"`
+	UploadPath   string    `json:"upload_path,omitempty"`
+	ContentSize  int64     `json:"content_size,omitempty"`
+	ContentHash  string    `json:"content_hash,omitempty"`
+	CryptoShred  bool      `json:"crypto_shred,omitempty"`
+	ImportObject string    `json:"import_object,omitempty"`
+	ImportFormat string    `json:"import_format,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+func newApprovalRequest(op, target, requestedBy string) *approvalRequest {
+	return &approvalRequest{
+		ID:          util.GenerateUniqueID(),
+		Operation:   op,
+		Target:      target,
+		RequestedBy: requestedBy,
+		ExpiresAt:   time.Now().Add(approvalLifespan).UTC(),
+	}
+}
+
+// newRestoreApprovalRequest creates a pending restore. The backup content is
+// not stored within the request: if it was read from an input file only its
+// size and hash are stored, otherwise the content is saved in the backups dir
+func newRestoreApprovalRequest(content []byte, inputFile string, scanQuota, mode int, requestedBy string) (*approvalRequest, error) {
+	req := newApprovalRequest(ApprovalOpRestore, inputFile, requestedBy)
+	req.InputFile = inputFile
+	req.ScanQuota = scanQuota
+	req.RestoreMode = mode
+	if err := req.setContent(content, inputFile == ""); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func newBulkImportApprovalRequest(content []byte, objectType, format, requestedBy string) (*approvalRequest, error) {
+	req := newApprovalRequest(ApprovalOpRestore, fmt.Sprintf("bulk import: %s", objectType), requestedBy)
+	req.ImportObject = objectType
+	req.ImportFormat = format
+	if err := req.setContent(content, true); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func getApprovalUploadsPath() string {
+	return filepath.Join(dataprovider.GetBackupsPath(), "approvals")
+}
+
+func (a *approvalRequest) setContent(content []byte, saveUpload bool) error {
+	if int64(len(content)) > MaxRestoreSize {
+		return util.NewValidationError(fmt.Sprintf("content size too big: %d/%d bytes", len(content), MaxRestoreSize))
+	}
+	hash := sha256.Sum256(content)
+	a.ContentSize = int64(len(content))
+	a.ContentHash = hex.EncodeToString(hash[:])
+	if !saveUpload {
+		return nil
+	}
+	uploadsPath := getApprovalUploadsPath()
+	if err := os.MkdirAll(uploadsPath, 0700); err != nil {
+		return err
+	}
+	a.UploadPath = filepath.Join(uploadsPath, a.ID)
+	return os.WriteFile(a.UploadPath, content, 0600)
+}
+
+// getContent reads the content to restore and checks that it was not modified
+// after the request
+func (a *approvalRequest) getContent() ([]byte, error) {
+	name := a.UploadPath
+	if name == "" {
+		name = a.InputFile
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() != a.ContentSize {
+		return nil, util.NewValidationError(fmt.Sprintf("the content to restore was modified, size %d, expected %d",
+			fi.Size(), a.ContentSize))
+	}
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(content)
+	if hex.EncodeToString(hash[:]) != a.ContentHash {
+		return nil, util.NewValidationError("the content to restore was modified after the approval request")
+	}
+	return content, nil
+}
+
+func (a *approvalRequest) removeUpload() {
+	if a.UploadPath == "" {
+		return
+	}
+	if err := os.Remove(a.UploadPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn(logSender, "", "unable to remove upload %q for approval %q: %v", a.UploadPath, a.ID, err)
+	}
+}
+
+func (a *approvalRequest) isExpired() bool {
+	return a.ExpiresAt.Before(time.Now().UTC())
+}
+
+// isVisibleTo returns true if the admin with the specified claims can view
+// the request: the requester and the admins allowed to confirm it
+func (a *approvalRequest) isVisibleTo(claims *jwtTokenClaims) bool {
+	return a.RequestedBy == claims.Username || claims.hasPerm(a.getRequiredPermission())
+}
+
+// getRequiredPermission returns the admin permission needed to request or
+// confirm the operation
+func (a *approvalRequest) getRequiredPermission() string {
+	switch a.Operation {
+	case ApprovalOpDeleteUser, ApprovalOpDeleteFolder:
+		return dataprovider.PermAdminDeleteUsers
+	default:
+		return dataprovider.PermAdminManageSystem
+	}
+}
+
+func (a *approvalRequest) getPublicInfo() map[string]any {
+	info := map[string]any{
+		"id":           a.ID,
+		"operation":    a.Operation,
+		"target":       a.Target,
+		"requested_by": a.RequestedBy,
+		"expires_at":   util.GetTimeAsMsSinceEpoch(a.ExpiresAt),
+	}
+	if a.CryptoShred {
+		info["crypto_shred"] = true
+	}
+	return info
+}
+
+func (a *approvalRequest) execute(executor, ipAddress string) error {
+	switch a.Operation {
+	case ApprovalOpDeleteUser:
+		return doDeleteUser(a.Target, a.CryptoShred, executor, ipAddress)
+	case ApprovalOpDeleteFolder:
+		return doDeleteFolder(a.Target, a.CryptoShred, executor, ipAddress)
+	case ApprovalOpRestore:
+		defer a.removeUpload()
+
+		content, err := a.getContent()
+		if err != nil {
+			return err
+		}
+		if a.ImportObject != "" {
+			return executeBulkImport(content, a.ImportObject, a.ImportFormat, executor, ipAddress)
+		}
+		return restoreBackup(content, a.InputFile, a.ScanQuota, a.RestoreMode, executor, ipAddress)
+	default:
+		return util.NewValidationError(fmt.Sprintf("unsupported operation %#v", a.Operation))
+	}
+}
+
+// requestApproval stores a pending operation and notifies the client that a
+// confirmation from a second admin is required
+func requestApproval(w http.ResponseWriter, r *http.Request, req *approvalRequest) {
+	if err := approvalsMgr.Add(req); err != nil {
+		req.removeUpload()
+		sendAPIResponse(w, r, err, "Unable to save the approval request", http.StatusInternalServerError)
+		return
+	}
+	logger.Info(logSender, "", "approval %#v required for operation %#v, target %#v, requested by %#v",
+		req.ID, req.Operation, req.Target, req.RequestedBy)
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, req.getPublicInfo())
+}
+
+func listApprovals(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	requests, err := approvalsMgr.List()
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	results := make([]map[string]any, 0, len(requests))
+	for _, req := range requests {
+		if req.isVisibleTo(&claims) {
+			results = append(results, req.getPublicInfo())
+		}
+	}
+	render.JSON(w, r, results)
+}
+
+func getApproval(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	req, err := approvalsMgr.Get(getURLParam(r, "id"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if !claims.hasPerm(req.getRequiredPermission()) {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	render.JSON(w, r, req.getPublicInfo())
+}
+
+func confirmApproval(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	id := getURLParam(r, "id")
+	req, err := approvalsMgr.Get(id)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if !claims.hasPerm(req.getRequiredPermission()) {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if req.RequestedBy == claims.Username {
+		sendAPIResponse(w, r, nil, "The operation must be confirmed by a different admin", http.StatusForbidden)
+		return
+	}
+	// delete before executing so the same request cannot be confirmed twice
+	if err := approvalsMgr.Delete(id); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	logger.Info(logSender, "", "approval %#v for operation %#v, target %#v, requested by %#v confirmed by %#v",
+		req.ID, req.Operation, req.Target, req.RequestedBy, claims.Username)
+	if err := req.execute(claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr)); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Operation approved and executed", http.StatusOK)
+}
+
+func rejectApproval(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	id := getURLParam(r, "id")
+	req, err := approvalsMgr.Get(id)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if req.RequestedBy != claims.Username && !claims.hasPerm(req.getRequiredPermission()) {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err := approvalsMgr.Delete(id); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	req.removeUpload()
+	logger.Info(logSender, "", "approval %#v for operation %#v rejected by %#v", req.ID, req.Operation, claims.Username)
+	sendAPIResponse(w, r, nil, "Approval request deleted", http.StatusOK)
+}
+
+type memoryApprovalManager struct {
+	requests sync.Map
+}
+
+func (m *memoryApprovalManager) Add(req *approvalRequest) error {
+	m.requests.Store(req.ID, req)
+	return nil
+}
+
+func (m *memoryApprovalManager) Get(id string) (*approvalRequest, error) {
+	val, ok := m.requests.Load(id)
+	if !ok {
+		return nil, util.NewRecordNotFoundError("approval request not found")
+	}
+	req := val.(*approvalRequest)
+	if req.isExpired() {
+		m.requests.Delete(id)
+		return nil, util.NewRecordNotFoundError("approval request expired")
+	}
+	return req, nil
+}
+
+func (m *memoryApprovalManager) List() ([]*approvalRequest, error) {
+	var requests []*approvalRequest
+	m.requests.Range(func(key, value any) bool {
+		req, ok := value.(*approvalRequest)
+		if ok && !req.isExpired() {
+			requests = append(requests, req)
+		}
+		return true
+	})
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].ExpiresAt.Before(requests[j].ExpiresAt)
+	})
+	return requests, nil
+}
+
+func (m *memoryApprovalManager) Delete(id string) error {
+	m.requests.Delete(id)
+	return nil
+}
+
+func (m *memoryApprovalManager) Cleanup() {
+	m.requests.Range(func(key, value any) bool {
+		req, ok := value.(*approvalRequest)
+		if !ok || req.isExpired() {
+			m.requests.Delete(key)
+		}
+		return true
+	})
+	cleanupApprovalUploads()
+}
+
+type dbApprovalManager struct{}
+
+func (m *dbApprovalManager) Add(req *approvalRequest) error {
+	session := dataprovider.Session{
+		Key:       req.ID,
+		Data:      req,
+		Type:      dataprovider.SessionTypeApproval,
+		Timestamp: util.GetTimeAsMsSinceEpoch(req.ExpiresAt),
+	}
+	return dataprovider.AddSharedSession(session)
+}
+
+func (m *dbApprovalManager) Get(id string) (*approvalRequest, error) {
+	session, err := dataprovider.GetSharedSession(id)
+	if err != nil {
+		return nil, err
+	}
+	if session.Type != dataprovider.SessionTypeApproval {
+		return nil, util.NewRecordNotFoundError("approval request not found")
+	}
+	if session.Timestamp < util.GetTimeAsMsSinceEpoch(time.Now()) {
+		// expired
+		return nil, util.NewRecordNotFoundError("approval request expired")
+	}
+	return m.decodeData(session.Data)
+}
+
+func (m *dbApprovalManager) List() ([]*approvalRequest, error) {
+	sessions, err := dataprovider.GetSharedSessions(dataprovider.SessionTypeApproval, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]*approvalRequest, 0, len(sessions))
+	for _, session := range sessions {
+		req, err := m.decodeData(session.Data)
+		if err != nil {
+			logger.Warn(logSender, "", "unable to decode approval request %q: %v", session.Key, err)
+			continue
+		}
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+func (m *dbApprovalManager) decodeData(data any) (*approvalRequest, error) {
+	if val, ok := data.([]byte); ok {
+		req := &approvalRequest{}
+		err := json.Unmarshal(val, req)
+		return req, err
+	}
+	logger.Error(logSender, "", "invalid approval request data type %T", data)
+	return nil, util.NewRecordNotFoundError("invalid approval request")
+}
+
+func (m *dbApprovalManager) Delete(id string) error {
+	return dataprovider.DeleteSharedSession(id)
+}
+
+func (m *dbApprovalManager) Cleanup() {
+	dataprovider.CleanupSharedSessions(dataprovider.SessionTypeApproval, time.Now()) //nolint:errcheck
+	cleanupApprovalUploads()
+}
+
+// cleanupApprovalUploads removes the saved uploads older than the approval
+// validity time, the related requests are expired
+func cleanupApprovalUploads() {
+	uploadsPath := getApprovalUploadsPath()
+	entries, err := os.ReadDir(uploadsPath)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if time.Since(info.ModTime()) > approvalLifespan {
+			name := filepath.Join(uploadsPath, entry.Name())
+			if err := os.Remove(name); err != nil {
+				logger.Warn(logSender, "", "unable to remove expired approval upload %q: %v", name, err)
+			}
+		}
+	}
+}
@@ -56,6 +56,10 @@ const (
 	claimMustSetSecondFactorKey     = "2fa_required"
 	claimRequiredTwoFactorProtocols = "2fa_protos"
 	claimHideUserPageSection        = "hus"
+	claimTheme                      = "theme"
+	claimLanguage                   = "lang"
+	claimImpersonatedBy             = "imp_by"
+	claimImpersonationReadOnly      = "imp_ro"
 	basicRealm                      = "Basic realm=\"SFTPGo\""
 	jwtCookieKey                    = "jwt"
 )
@@ -79,6 +83,11 @@ type jwtTokenClaims struct {
 	MustSetTwoFactorAuth       bool
 	RequiredTwoFactorProtocols []string
 	HideUserPageSections       int
+	Theme                      string
+	Language                   string
+	// admin impersonating the user, if any
+	ImpersonatedBy        string
+	ImpersonationReadOnly bool
 }
 
 func (c *jwtTokenClaims) hasUserAudience() bool {
@@ -112,6 +121,16 @@ func (c *jwtTokenClaims) asMap() map[string]any {
 	if c.HideUserPageSections > 0 {
 		claims[claimHideUserPageSection] = c.HideUserPageSections
 	}
+	if c.Theme != "" {
+		claims[claimTheme] = c.Theme
+	}
+	if c.Language != "" {
+		claims[claimLanguage] = c.Language
+	}
+	if c.ImpersonatedBy != "" {
+		claims[claimImpersonatedBy] = c.ImpersonatedBy
+		claims[claimImpersonationReadOnly] = c.ImpersonationReadOnly
+	}
 
 	return claims
 }
@@ -189,6 +208,34 @@ func (c *jwtTokenClaims) Decode(token map[string]any) {
 			c.HideUserPageSections = int(v)
 		}
 	}
+
+	if val, ok := token[claimTheme]; ok {
+		switch v := val.(type) {
+		case string:
+			c.Theme = v
+		}
+	}
+
+	if val, ok := token[claimLanguage]; ok {
+		switch v := val.(type) {
+		case string:
+			c.Language = v
+		}
+	}
+
+	if val, ok := token[claimImpersonatedBy]; ok {
+		switch v := val.(type) {
+		case string:
+			c.ImpersonatedBy = v
+		}
+	}
+
+	if val, ok := token[claimImpersonationReadOnly]; ok {
+		switch v := val.(type) {
+		case bool:
+			c.ImpersonationReadOnly = v
+		}
+	}
 }
 
 func (c *jwtTokenClaims) isCriticalPermRemoved(permissions []string) bool {
@@ -205,11 +252,7 @@ func (c *jwtTokenClaims) isCriticalPermRemoved(permissions []string) bool {
 }
 
 func (c *jwtTokenClaims) hasPerm(perm string) bool {
-	if util.Contains(c.Permissions, dataprovider.PermAdminAny) {
-		return true
-	}
-
-	return util.Contains(c.Permissions, perm)
+	return dataprovider.HasAdminPermission(c.Permissions, perm)
 }
 
 func (c *jwtTokenClaims) createToken(tokenAuth *jwtauth.JWTAuth, audience tokenAudience, ip string) (jwt.Token, string, error) {
@@ -336,6 +379,8 @@ func getUserFromToken(r *http.Request) *dataprovider.User {
 	tokenClaims.Decode(claims)
 	user.Username = tokenClaims.Username
 	user.Filters.WebClient = tokenClaims.Permissions
+	user.Filters.Theme = tokenClaims.Theme
+	user.Filters.Language = tokenClaims.Language
 	return user
 }
 
@@ -350,6 +395,8 @@ func getAdminFromToken(r *http.Request) *dataprovider.Admin {
 	admin.Username = tokenClaims.Username
 	admin.Permissions = tokenClaims.Permissions
 	admin.Filters.Preferences.HideUserPageSections = tokenClaims.HideUserPageSections
+	admin.Filters.Preferences.Theme = tokenClaims.Theme
+	admin.Filters.Language = tokenClaims.Language
 	return admin
 }
 
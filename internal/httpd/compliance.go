@@ -0,0 +1,626 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/sftpgo/sdk/plugin/eventsearcher"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+// Compliance export statuses
+const (
+	ComplianceExportRunning   = "running"
+	ComplianceExportCompleted = "completed"
+	ComplianceExportFailed    = "failed"
+)
+
+const (
+	complianceManifestName  = "manifest.json"
+	complianceSignatureName = "signature.json"
+	complianceEventsLimit   = 1000
+	// max number of audit events to include in a single bundle
+	complianceMaxEvents = 100000
+	// the number of steps needed to generate a bundle, used to compute the progress
+	complianceExportSteps = 5
+)
+
+var (
+	complianceMgr = newComplianceExportManager()
+)
+
+// ComplianceConfig defines the configuration for the compliance export bundles
+type ComplianceConfig struct {
+	// Path to the private key used to sign the bundles. RSA, ECDSA and Ed25519
+	// keys in PEM format are supported. If empty a random Ed25519 key is
+	// generated at startup, the bundles can still be verified using the
+	// included public key but it will change after each restart
+	SigningKey string `json:"signing_key" mapstructure:"signing_key"`
+	// Number of hours the generated bundles are kept on the server
+	Retention int `json:"retention" mapstructure:"retention"`
+}
+
+func (c *ComplianceConfig) validate() error {
+	if c.Retention < 0 {
+		return fmt.Errorf("invalid compliance export retention: %d", c.Retention)
+	}
+	return nil
+}
+
+func (c *ComplianceConfig) initialize(configDir string) error {
+	var signer ssh.Signer
+	signingKey := getConfigPath(c.SigningKey, configDir)
+	if signingKey != "" {
+		data, err := os.ReadFile(signingKey)
+		if err != nil {
+			return fmt.Errorf("unable to read compliance signing key %q: %w", signingKey, err)
+		}
+		signer, err = ssh.ParsePrivateKey(data)
+		if err != nil {
+			return fmt.Errorf("unable to parse compliance signing key %q: %w", signingKey, err)
+		}
+	} else {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return fmt.Errorf("unable to generate compliance signing key: %w", err)
+		}
+		signer, err = ssh.NewSignerFromKey(privateKey)
+		if err != nil {
+			return err
+		}
+		logger.Debug(logSender, "", "no compliance signing key configured, using a random key")
+	}
+	retention := 24 * time.Hour
+	if c.Retention > 0 {
+		retention = time.Duration(c.Retention) * time.Hour
+	}
+	complianceMgr.setSigner(signer, retention)
+	return nil
+}
+
+// complianceExport defines a compliance evidence bundle, generated in the background
+type complianceExport struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	StartTime   int64  `json:"start_timestamp"`
+	EndTime     int64  `json:"end_timestamp"`
+	RequestedBy string `json:"requested_by"`
+	CreatedAt   int64  `json:"created_at"`
+	CompletedAt int64  `json:"completed_at,omitempty"`
+	Progress    int    `json:"progress"`
+	Step        string `json:"step,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	Error       string `json:"error,omitempty"`
+	filePath    string
+}
+
+func (e *complianceExport) getFileName() string {
+	return fmt.Sprintf("compliance-%s.zip", e.ID)
+}
+
+func (e *complianceExport) validate() error {
+	if e.EndTime == 0 {
+		e.EndTime = util.GetTimeAsMsSinceEpoch(time.Now())
+	}
+	if e.StartTime <= 0 {
+		return util.NewValidationError("a valid start timestamp is required")
+	}
+	if e.EndTime <= e.StartTime {
+		return util.NewValidationError("the end timestamp must be greater than the start timestamp")
+	}
+	return nil
+}
+
+type complianceExportManager struct {
+	sync.RWMutex
+	exports   map[string]*complianceExport
+	signer    ssh.Signer
+	retention time.Duration
+}
+
+func newComplianceExportManager() *complianceExportManager {
+	return &complianceExportManager{
+		exports:   make(map[string]*complianceExport),
+		retention: 24 * time.Hour,
+	}
+}
+
+func (m *complianceExportManager) setSigner(signer ssh.Signer, retention time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.signer = signer
+	m.retention = retention
+}
+
+// Add adds a new export. Only one export at a time can be generated
+func (m *complianceExportManager) Add(export *complianceExport) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, e := range m.exports {
+		if e.Status == ComplianceExportRunning {
+			return false
+		}
+	}
+	export.ID = util.GenerateUniqueID()
+	export.Status = ComplianceExportRunning
+	export.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	export.filePath = filepath.Join(dataprovider.GetBackupsPath(), export.getFileName())
+	m.exports[export.ID] = export
+	return true
+}
+
+// Get returns a copy of the export with the specified ID
+func (m *complianceExportManager) Get(id string) (complianceExport, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if e, ok := m.exports[id]; ok {
+		return *e, nil
+	}
+	return complianceExport{}, util.NewRecordNotFoundError(fmt.Sprintf("compliance export %q does not exist", id))
+}
+
+// GetAll returns a copy of all the exports
+func (m *complianceExportManager) GetAll() []complianceExport {
+	m.RLock()
+	defer m.RUnlock()
+
+	exports := make([]complianceExport, 0, len(m.exports))
+	for _, e := range m.exports {
+		exports = append(exports, *e)
+	}
+	return exports
+}
+
+// Delete removes the export and the related bundle. Running exports cannot be removed
+func (m *complianceExportManager) Delete(id string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	e, ok := m.exports[id]
+	if !ok {
+		return util.NewRecordNotFoundError(fmt.Sprintf("compliance export %q does not exist", id))
+	}
+	if e.Status == ComplianceExportRunning {
+		return util.NewValidationError(fmt.Sprintf("compliance export %q is still running", id))
+	}
+	delete(m.exports, id)
+	if err := os.Remove(e.filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn(logSender, "", "unable to remove compliance bundle %q: %v", e.filePath, err)
+	}
+	return nil
+}
+
+func (m *complianceExportManager) updateProgress(id string, step int, stepName string) {
+	m.Lock()
+	defer m.Unlock()
+
+	if e, ok := m.exports[id]; ok {
+		e.Progress = (step - 1) * 100 / complianceExportSteps
+		e.Step = stepName
+	}
+}
+
+func (m *complianceExportManager) setCompleted(id string, size int64, checksum string, err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if e, ok := m.exports[id]; ok {
+		e.CompletedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		e.Step = ""
+		if err != nil {
+			e.Status = ComplianceExportFailed
+			e.Error = err.Error()
+			return
+		}
+		e.Status = ComplianceExportCompleted
+		e.Progress = 100
+		e.Size = size
+		e.SHA256 = checksum
+	}
+}
+
+func (m *complianceExportManager) getSigner() ssh.Signer {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.signer
+}
+
+// Cleanup removes the expired exports and the related bundles
+func (m *complianceExportManager) Cleanup() {
+	m.Lock()
+	defer m.Unlock()
+
+	for id, e := range m.exports {
+		if e.Status == ComplianceExportRunning {
+			continue
+		}
+		if util.GetTimeFromMsecSinceEpoch(e.CompletedAt).Add(m.retention).Before(time.Now()) {
+			delete(m.exports, id)
+			if err := os.Remove(e.filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				logger.Warn(logSender, "", "unable to remove expired compliance bundle %q: %v", e.filePath, err)
+			}
+		}
+	}
+}
+
+type complianceFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type complianceManifest struct {
+	ExportID       string           `json:"export_id"`
+	Version        string           `json:"version"`
+	GeneratedAt    int64            `json:"generated_at"`
+	GeneratedBy    string           `json:"generated_by"`
+	StartTimestamp int64            `json:"start_timestamp"`
+	EndTimestamp   int64            `json:"end_timestamp"`
+	Files          []complianceFile `json:"files"`
+	Notes          []string         `json:"notes,omitempty"`
+}
+
+type complianceSignature struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+type complianceUser struct {
+	Username           string              `json:"username"`
+	Status             int                 `json:"status"`
+	Email              string              `json:"email,omitempty"`
+	ExpirationDate     int64               `json:"expiration_date,omitempty"`
+	Permissions        map[string][]string `json:"permissions"`
+	Groups             []string            `json:"groups,omitempty"`
+	AllowedIP          []string            `json:"allowed_ip,omitempty"`
+	DeniedIP           []string            `json:"denied_ip,omitempty"`
+	DeniedLoginMethods []string            `json:"denied_login_methods,omitempty"`
+	DeniedProtocols    []string            `json:"denied_protocols,omitempty"`
+	TOTPEnabled        bool                `json:"totp_enabled"`
+	SecurityKeys       int                 `json:"security_keys,omitempty"`
+	CreatedAt          int64               `json:"created_at"`
+	UpdatedAt          int64               `json:"updated_at"`
+	LastLogin          int64               `json:"last_login,omitempty"`
+	SoftDeletedAt      int64               `json:"soft_deleted_at,omitempty"`
+}
+
+type complianceAdmin struct {
+	Username     string   `json:"username"`
+	Status       int      `json:"status"`
+	Email        string   `json:"email,omitempty"`
+	Permissions  []string `json:"permissions"`
+	AllowList    []string `json:"allow_list,omitempty"`
+	TOTPEnabled  bool     `json:"totp_enabled"`
+	SecurityKeys int      `json:"security_keys,omitempty"`
+	CreatedAt    int64    `json:"created_at"`
+	UpdatedAt    int64    `json:"updated_at"`
+	LastLogin    int64    `json:"last_login,omitempty"`
+}
+
+type complianceLogin struct {
+	Username  string `json:"username"`
+	Type      string `json:"type"`
+	LastLogin int64  `json:"last_login"`
+}
+
+// complianceBundleWriter writes the bundle files and keeps track of their checksums
+type complianceBundleWriter struct {
+	zw    *zip.Writer
+	files []complianceFile
+}
+
+func (w *complianceBundleWriter) addFile(name string, data []byte) error {
+	f, err := w.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	h := sha256.Sum256(data)
+	w.files = append(w.files, complianceFile{
+		Name:   name,
+		Size:   int64(len(data)),
+		SHA256: hex.EncodeToString(h[:]),
+	})
+	return nil
+}
+
+func (w *complianceBundleWriter) addJSONFile(name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return w.addFile(name, data)
+}
+
+func (e *complianceExport) run() {
+	logger.Info(logSender, "", "start generating compliance export %q, requested by %q", e.ID, e.RequestedBy)
+	size, checksum, err := e.generate()
+	if err != nil {
+		logger.Warn(logSender, "", "unable to generate compliance export %q: %v", e.ID, err)
+		if errRm := os.Remove(e.filePath); errRm != nil && !errors.Is(errRm, os.ErrNotExist) {
+			logger.Warn(logSender, "", "unable to remove compliance bundle %q: %v", e.filePath, errRm)
+		}
+	} else {
+		logger.Info(logSender, "", "compliance export %q generated, size: %d", e.ID, size)
+	}
+	complianceMgr.setCompleted(e.ID, size, checksum, err)
+}
+
+func (e *complianceExport) generate() (int64, string, error) {
+	if err := os.MkdirAll(filepath.Dir(e.filePath), 0700); err != nil {
+		return 0, "", err
+	}
+	f, err := os.OpenFile(e.filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	zw := zip.NewWriter(io.MultiWriter(f, h))
+	bundle := &complianceBundleWriter{zw: zw}
+	manifest := complianceManifest{
+		ExportID:       e.ID,
+		Version:        version.Get().Version,
+		GeneratedBy:    e.RequestedBy,
+		StartTimestamp: e.StartTime,
+		EndTimestamp:   e.EndTime,
+	}
+
+	complianceMgr.updateProgress(e.ID, 1, "users")
+	users, err := getComplianceUsers()
+	if err != nil {
+		return 0, "", fmt.Errorf("unable to get users: %w", err)
+	}
+	if err := bundle.addJSONFile("users.json", users); err != nil {
+		return 0, "", err
+	}
+	complianceMgr.updateProgress(e.ID, 2, "admins")
+	admins, err := getComplianceAdmins()
+	if err != nil {
+		return 0, "", fmt.Errorf("unable to get admins: %w", err)
+	}
+	if err := bundle.addJSONFile("admins.json", admins); err != nil {
+		return 0, "", err
+	}
+	complianceMgr.updateProgress(e.ID, 3, "logins")
+	if err := bundle.addJSONFile("logins.json", e.getLogins(users, admins)); err != nil {
+		return 0, "", err
+	}
+	complianceMgr.updateProgress(e.ID, 4, "admin_audit")
+	events, err := e.getAuditEvents()
+	if err != nil {
+		if !errors.Is(err, plugin.ErrNoSearcher) {
+			return 0, "", fmt.Errorf("unable to get admin audit events: %w", err)
+		}
+		manifest.Notes = append(manifest.Notes, "no event searcher plugin configured, admin audit trail not available")
+	} else {
+		if len(events) >= complianceMaxEvents {
+			manifest.Notes = append(manifest.Notes,
+				fmt.Sprintf("admin audit trail truncated to %d events", complianceMaxEvents))
+		}
+		if err := bundle.addJSONFile("admin_audit.json", events); err != nil {
+			return 0, "", err
+		}
+	}
+	complianceMgr.updateProgress(e.ID, 5, "signing")
+	manifest.GeneratedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	manifest.Files = bundle.files
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return 0, "", err
+	}
+	signature, err := signComplianceManifest(manifestData)
+	if err != nil {
+		return 0, "", fmt.Errorf("unable to sign the manifest: %w", err)
+	}
+	if err := bundle.addFile(complianceManifestName, manifestData); err != nil {
+		return 0, "", err
+	}
+	if err := bundle.addJSONFile(complianceSignatureName, signature); err != nil {
+		return 0, "", err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, "", err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, "", err
+	}
+	return info.Size(), hex.EncodeToString(h.Sum(nil)), f.Close()
+}
+
+// getLogins returns the users and admins whose last login is within the export time range
+func (e *complianceExport) getLogins(users []complianceUser, admins []complianceAdmin) []complianceLogin {
+	logins := make([]complianceLogin, 0)
+	for _, u := range users {
+		if u.LastLogin >= e.StartTime && u.LastLogin <= e.EndTime {
+			logins = append(logins, complianceLogin{Username: u.Username, Type: "user", LastLogin: u.LastLogin})
+		}
+	}
+	for _, a := range admins {
+		if a.LastLogin >= e.StartTime && a.LastLogin <= e.EndTime {
+			logins = append(logins, complianceLogin{Username: a.Username, Type: "admin", LastLogin: a.LastLogin})
+		}
+	}
+	return logins
+}
+
+// getAuditEvents returns the provider events executed by admins within the
+// export time range using the configured event searcher plugin
+func (e *complianceExport) getAuditEvents() ([]json.RawMessage, error) {
+	results := make([]json.RawMessage, 0)
+	filters := eventsearcher.ProviderEventSearch{
+		CommonSearchParams: eventsearcher.CommonSearchParams{
+			StartTimestamp: util.GetTimeFromMsecSinceEpoch(e.StartTime).UnixNano(),
+			EndTimestamp:   util.GetTimeFromMsecSinceEpoch(e.EndTime).UnixNano(),
+			Limit:          complianceEventsLimit,
+			Order:          1,
+		},
+	}
+	for len(results) < complianceMaxEvents {
+		data, _, sameTsAtEnd, err := plugin.Handler.SearchProviderEvents(&filters)
+		if err != nil {
+			return nil, err
+		}
+		var events []json.RawMessage
+		if err := json.Unmarshal(data, &events); err != nil {
+			return nil, err
+		}
+		results = append(results, events...)
+		if len(events) < complianceEventsLimit {
+			break
+		}
+		var last struct {
+			Timestamp int64 `json:"timestamp"`
+		}
+		if err := json.Unmarshal(events[len(events)-1], &last); err != nil {
+			return nil, err
+		}
+		filters.StartTimestamp = last.Timestamp
+		filters.ExcludeIDs = sameTsAtEnd
+	}
+	if len(results) > complianceMaxEvents {
+		results = results[:complianceMaxEvents]
+	}
+	return results, nil
+}
+
+func getComplianceUsers() ([]complianceUser, error) {
+	var results []complianceUser
+	offset := 0
+	for {
+		users, err := dataprovider.GetUsers(100, offset, dataprovider.OrderASC)
+		if err != nil {
+			return nil, err
+		}
+		for idx := range users {
+			u := &users[idx]
+			var groups []string
+			for _, g := range u.Groups {
+				groups = append(groups, g.Name)
+			}
+			results = append(results, complianceUser{
+				Username:           u.Username,
+				Status:             u.Status,
+				Email:              u.Email,
+				ExpirationDate:     u.ExpirationDate,
+				Permissions:        u.Permissions,
+				Groups:             groups,
+				AllowedIP:          u.Filters.AllowedIP,
+				DeniedIP:           u.Filters.DeniedIP,
+				DeniedLoginMethods: u.Filters.DeniedLoginMethods,
+				DeniedProtocols:    u.Filters.DeniedProtocols,
+				TOTPEnabled:        u.Filters.TOTPConfig.Enabled,
+				SecurityKeys:       len(u.Filters.WebAuthnCredentials),
+				CreatedAt:          u.CreatedAt,
+				UpdatedAt:          u.UpdatedAt,
+				LastLogin:          u.LastLogin,
+				SoftDeletedAt:      u.SoftDeletedAt,
+			})
+		}
+		if len(users) < 100 {
+			break
+		}
+		offset += len(users)
+	}
+	return results, nil
+}
+
+func getComplianceAdmins() ([]complianceAdmin, error) {
+	var results []complianceAdmin
+	offset := 0
+	for {
+		admins, err := dataprovider.GetAdmins(100, offset, dataprovider.OrderASC)
+		if err != nil {
+			return nil, err
+		}
+		for idx := range admins {
+			a := &admins[idx]
+			results = append(results, complianceAdmin{
+				Username:     a.Username,
+				Status:       a.Status,
+				Email:        a.Email,
+				Permissions:  a.Permissions,
+				AllowList:    a.Filters.AllowList,
+				TOTPEnabled:  a.Filters.TOTPConfig.Enabled,
+				SecurityKeys: len(a.Filters.WebAuthnCredentials),
+				CreatedAt:    a.CreatedAt,
+				UpdatedAt:    a.UpdatedAt,
+				LastLogin:    a.LastLogin,
+			})
+		}
+		if len(admins) < 100 {
+			break
+		}
+		offset += len(admins)
+	}
+	return results, nil
+}
+
+func signComplianceManifest(data []byte) (complianceSignature, error) {
+	signer := complianceMgr.getSigner()
+	if signer == nil {
+		return complianceSignature{}, errors.New("no signing key available")
+	}
+	var sig *ssh.Signature
+	var err error
+	if algoSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = algoSigner.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA256)
+	} else {
+		sig, err = signer.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return complianceSignature{}, err
+	}
+	return complianceSignature{
+		Algorithm: sig.Format,
+		PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))),
+		Signature: base64.StdEncoding.EncodeToString(sig.Blob),
+	}, nil
+}
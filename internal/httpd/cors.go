@@ -0,0 +1,173 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/rs/cors"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// CorsRule defines a CORS policy for a set of URL paths. The rules are evaluated in
+// the order they are defined and the first rule matching the request path is applied
+type CorsRule struct {
+	// URL path prefixes this rule applies to, for example "/api/v2/user".
+	// The web root, if configured, must be included
+	Paths []string `json:"paths" mapstructure:"paths"`
+	// Allowed origins, an origin may contain a wildcard to replace 0 or more
+	// characters, for example "https://*.example.com"
+	AllowedOrigins   []string `json:"allowed_origins" mapstructure:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods" mapstructure:"allowed_methods"`
+	AllowedHeaders   []string `json:"allowed_headers" mapstructure:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers" mapstructure:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials" mapstructure:"allow_credentials"`
+	MaxAge           int      `json:"max_age" mapstructure:"max_age"`
+}
+
+func (r *CorsRule) validate() error {
+	var paths []string
+	for _, p := range r.Paths {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !path.IsAbs(p) {
+			return fmt.Errorf("invalid CORS rule path %q, it must be an absolute URL path", p)
+		}
+		paths = append(paths, path.Clean(p))
+	}
+	if len(paths) == 0 {
+		return errors.New("CORS rules require at least a path")
+	}
+	r.Paths = util.RemoveDuplicates(paths, false)
+	if len(r.AllowedOrigins) == 0 {
+		return errors.New("CORS rules require at least an allowed origin")
+	}
+	if err := validateCorsOrigins(r.AllowedOrigins); err != nil {
+		return err
+	}
+	if r.AllowCredentials && util.Contains(r.AllowedOrigins, "*") {
+		return errors.New(`CORS rules allowing credentials cannot allow the "*" origin`)
+	}
+	return nil
+}
+
+func (r *CorsRule) matchPath(urlPath string) bool {
+	for _, p := range r.Paths {
+		if p == "/" || urlPath == p || strings.HasPrefix(urlPath, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func validateCorsOrigins(origins []string) error {
+	for _, origin := range origins {
+		if origin == "" || strings.Count(origin, "*") > 1 {
+			return fmt.Errorf("invalid CORS origin %q, a single wildcard is allowed", origin)
+		}
+	}
+	return nil
+}
+
+func (c *CorsConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if err := validateCorsOrigins(c.AllowedOrigins); err != nil {
+		return err
+	}
+	for idx := range c.Rules {
+		if err := c.Rules[idx].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CorsConfig) getOptions() cors.Options {
+	return cors.Options{
+		AllowedOrigins:       util.RemoveDuplicates(c.AllowedOrigins, true),
+		AllowedMethods:       util.RemoveDuplicates(c.AllowedMethods, true),
+		AllowedHeaders:       util.RemoveDuplicates(c.AllowedHeaders, true),
+		ExposedHeaders:       util.RemoveDuplicates(c.ExposedHeaders, true),
+		MaxAge:               c.MaxAge,
+		AllowCredentials:     c.AllowCredentials,
+		OptionsPassthrough:   c.OptionsPassthrough,
+		OptionsSuccessStatus: c.OptionsSuccessStatus,
+		AllowPrivateNetwork:  c.AllowPrivateNetwork,
+	}
+}
+
+func (c *CorsConfig) getRuleOptions(rule *CorsRule) cors.Options {
+	return cors.Options{
+		AllowedOrigins:       util.RemoveDuplicates(rule.AllowedOrigins, true),
+		AllowedMethods:       util.RemoveDuplicates(rule.AllowedMethods, true),
+		AllowedHeaders:       util.RemoveDuplicates(rule.AllowedHeaders, true),
+		ExposedHeaders:       util.RemoveDuplicates(rule.ExposedHeaders, true),
+		MaxAge:               rule.MaxAge,
+		AllowCredentials:     rule.AllowCredentials,
+		OptionsPassthrough:   c.OptionsPassthrough,
+		OptionsSuccessStatus: c.OptionsSuccessStatus,
+		AllowPrivateNetwork:  c.AllowPrivateNetwork,
+	}
+}
+
+// getHandler returns a middleware that applies the CORS policy of the first rule
+// matching the request path. Without rules the base configuration is applied to
+// any path, as before. If rules are defined, requests not matching any rule use
+// the base configuration only if it defines some allowed origins, an empty origins
+// list means allow all for the CORS handler
+func (c *CorsConfig) getHandler() func(http.Handler) http.Handler {
+	var base *cors.Cors
+	if len(c.Rules) == 0 || len(c.AllowedOrigins) > 0 {
+		base = cors.New(c.getOptions())
+	}
+	rules := make([]*cors.Cors, 0, len(c.Rules))
+	for idx := range c.Rules {
+		rules = append(rules, cors.New(c.getRuleOptions(&c.Rules[idx])))
+	}
+
+	return func(next http.Handler) http.Handler {
+		var baseHandler http.Handler
+		if base != nil {
+			baseHandler = base.Handler(next)
+		}
+		ruleHandlers := make([]http.Handler, 0, len(rules))
+		for _, rule := range rules {
+			ruleHandlers = append(ruleHandlers, rule.Handler(next))
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for idx := range c.Rules {
+				if c.Rules[idx].matchPath(r.URL.Path) {
+					ruleHandlers[idx].ServeHTTP(w, r)
+					return
+				}
+			}
+			if baseHandler != nil {
+				baseHandler.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const defaultDownloadMimeType = "application/octet-stream"
+
+var (
+	// content types that browsers render and that can execute scripts
+	unsafeInlineMimeTypes = []string{"text/html", "application/xhtml+xml", "image/svg+xml", "text/xml",
+		"application/xml", "text/javascript", "application/javascript", "application/x-javascript",
+		"application/ecmascript", "text/ecmascript"}
+	downloadsConf DownloadsConfig
+)
+
+// DownloadsConfig defines the MIME types and the Content-Disposition policies
+// for the files downloaded using the WebClient, the REST API and the shares
+type DownloadsConfig struct {
+	// MIME type overrides defined as "extension=MIME type", for example ".log=text/plain".
+	// The overrides take precedence over the system MIME types
+	MimeTypes []string `json:"mime_types" mapstructure:"mime_types"`
+	// File extensions that are always served as attachment, for example ".html"
+	AttachmentExtensions []string `json:"attachment_extensions" mapstructure:"attachment_extensions"`
+	// If not empty, only files with the listed extensions can be served inline,
+	// all other files are served as attachment
+	InlineExtensions []string `json:"inline_extensions" mapstructure:"inline_extensions"`
+	// If enabled, the content types that browsers can execute, such as HTML, SVG,
+	// XML and JavaScript, are always served as attachment and the
+	// "X-Content-Type-Options: nosniff" header is added to all downloads
+	SafeMode  bool `json:"safe_mode" mapstructure:"safe_mode"`
+	mimeTypes map[string]string
+}
+
+func normalizeExtension(ext string) string {
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+func normalizeExtensions(extensions []string) ([]string, error) {
+	result := make([]string, 0, len(extensions))
+	for _, ext := range extensions {
+		ext = normalizeExtension(ext)
+		if ext == "" || ext == "." {
+			return nil, fmt.Errorf("invalid download extension %#v", ext)
+		}
+		result = append(result, ext)
+	}
+	return util.RemoveDuplicates(result, false), nil
+}
+
+func (c *DownloadsConfig) validate() error {
+	c.mimeTypes = make(map[string]string)
+	for _, val := range c.MimeTypes {
+		ext, mimeType, ok := strings.Cut(val, "=")
+		ext = normalizeExtension(ext)
+		mimeType = strings.TrimSpace(mimeType)
+		if !ok || ext == "" || ext == "." {
+			return fmt.Errorf("invalid download MIME type mapping %#v", val)
+		}
+		if _, _, err := mime.ParseMediaType(mimeType); err != nil {
+			return fmt.Errorf("invalid MIME type for mapping %#v: %w", val, err)
+		}
+		c.mimeTypes[ext] = mimeType
+	}
+	var err error
+	c.AttachmentExtensions, err = normalizeExtensions(c.AttachmentExtensions)
+	if err != nil {
+		return err
+	}
+	c.InlineExtensions, err = normalizeExtensions(c.InlineExtensions)
+	return err
+}
+
+func (c *DownloadsConfig) getMimeType(name string) string {
+	ext := path.Ext(name)
+	if mimeType, ok := c.mimeTypes[strings.ToLower(ext)]; ok {
+		return mimeType
+	}
+	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+		return mimeType
+	}
+	return defaultDownloadMimeType
+}
+
+func (c *DownloadsConfig) isInlineAllowed(name, mimeType string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	if util.Contains(c.AttachmentExtensions, ext) {
+		return false
+	}
+	if len(c.InlineExtensions) > 0 && !util.Contains(c.InlineExtensions, ext) {
+		return false
+	}
+	if c.SafeMode {
+		mediaType, _, err := mime.ParseMediaType(mimeType)
+		if err != nil || util.Contains(unsafeInlineMimeTypes, mediaType) {
+			return false
+		}
+	}
+	return true
+}
+
+// getDownloadPolicy returns the content type for the specified file name and
+// if the file can be served inline
+func (c *DownloadsConfig) getDownloadPolicy(name string, inline bool) (string, bool) {
+	mimeType := c.getMimeType(name)
+	if inline {
+		inline = c.isInlineAllowed(name, mimeType)
+	}
+	return mimeType, inline
+}
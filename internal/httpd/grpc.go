@@ -0,0 +1,353 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	grpcLogSender = "grpc"
+	// metadata keys, gRPC metadata keys are always lowercase
+	grpcAPIKeyMetadata        = "x-sftpgo-api-key"
+	grpcAuthorizationMetadata = "authorization"
+)
+
+var (
+	grpcAdminKey = &contextKey{"gRPC admin"}
+	// grpcMethodPerms maps each RPC to the admin permission and to the API key
+	// scope required to call it
+	grpcMethodPerms = map[string]grpcMethodPerm{
+		"GetUsers":             {dataprovider.PermAdminViewUsers, dataprovider.APIKeyResourceUsers, false},
+		"GetUser":              {dataprovider.PermAdminViewUsers, dataprovider.APIKeyResourceUsers, false},
+		"AddUser":              {dataprovider.PermAdminAddUsers, dataprovider.APIKeyResourceUsers, true},
+		"UpdateUser":           {dataprovider.PermAdminChangeUsers, dataprovider.APIKeyResourceUsers, true},
+		"DeleteUser":           {dataprovider.PermAdminDeleteUsers, dataprovider.APIKeyResourceUsers, true},
+		"GetGroups":            {dataprovider.PermAdminManageGroups, dataprovider.APIKeyResourceGroups, false},
+		"GetGroup":             {dataprovider.PermAdminManageGroups, dataprovider.APIKeyResourceGroups, false},
+		"AddGroup":             {dataprovider.PermAdminManageGroups, dataprovider.APIKeyResourceGroups, true},
+		"UpdateGroup":          {dataprovider.PermAdminManageGroups, dataprovider.APIKeyResourceGroups, true},
+		"DeleteGroup":          {dataprovider.PermAdminManageGroups, dataprovider.APIKeyResourceGroups, true},
+		"GetFolders":           {dataprovider.PermAdminViewUsers, dataprovider.APIKeyResourceFolders, false},
+		"GetFolder":            {dataprovider.PermAdminViewUsers, dataprovider.APIKeyResourceFolders, false},
+		"AddFolder":            {dataprovider.PermAdminAddUsers, dataprovider.APIKeyResourceFolders, true},
+		"UpdateFolder":         {dataprovider.PermAdminChangeUsers, dataprovider.APIKeyResourceFolders, true},
+		"DeleteFolder":         {dataprovider.PermAdminDeleteUsers, dataprovider.APIKeyResourceFolders, true},
+		"GetUsersQuotaScans":   {dataprovider.PermAdminQuotaScans, dataprovider.APIKeyResourceQuota, false},
+		"StartUserQuotaScan":   {dataprovider.PermAdminQuotaScans, dataprovider.APIKeyResourceQuota, true},
+		"GetFoldersQuotaScans": {dataprovider.PermAdminQuotaScans, dataprovider.APIKeyResourceQuota, false},
+		"StartFolderQuotaScan": {dataprovider.PermAdminQuotaScans, dataprovider.APIKeyResourceQuota, true},
+		"StreamEvents":         {dataprovider.PermAdminViewEvents, dataprovider.APIKeyResourceEvents, false},
+	}
+)
+
+type grpcMethodPerm struct {
+	perm     string
+	resource string
+	write    bool
+}
+
+// GRPCConfig defines the configuration for the gRPC admin API.
+// The gRPC API is always served over TLS and clients must provide a
+// certificate signed by one of the configured CA certificates
+type GRPCConfig struct {
+	// The port used for serving the gRPC API, 0 means disabled
+	Port int `json:"port" mapstructure:"port"`
+	// The address to listen on. A blank value means listen on all available network interfaces.
+	Address string `json:"address" mapstructure:"address"`
+	// Certificate and matching private key for the gRPC server.
+	// If empty the global HTTP server certificate is used
+	CertificateFile    string `json:"certificate_file" mapstructure:"certificate_file"`
+	CertificateKeyFile string `json:"certificate_key_file" mapstructure:"certificate_key_file"`
+	// Defines the minimum TLS version. 13 means TLS 1.3, default is TLS 1.2
+	MinTLSVersion int `json:"min_tls_version" mapstructure:"min_tls_version"`
+	// Size of the per stream buffer for real-time events, the events that do
+	// not fit in the buffer of a slow client are dropped
+	EventsBufferSize int `json:"events_buffer_size" mapstructure:"events_buffer_size"`
+}
+
+func (c *GRPCConfig) isEnabled() bool {
+	return c.Port > 0
+}
+
+// GetAddress returns the address to listen on
+func (c *GRPCConfig) GetAddress() string {
+	return fmt.Sprintf("%s:%d", c.Address, c.Port)
+}
+
+func (c *GRPCConfig) hasCertificate() bool {
+	return getConfigPath(c.CertificateFile, "") != "" && getConfigPath(c.CertificateKeyFile, "") != ""
+}
+
+func (c *GRPCConfig) getCertID() string {
+	if c.hasCertificate() {
+		return c.GetAddress()
+	}
+	return common.DefaultTLSKeyPaidID
+}
+
+func (c *GRPCConfig) validate(hasDefaultCertificate bool, caCertificates []string) error {
+	if !c.isEnabled() {
+		return nil
+	}
+	if !c.hasCertificate() && !hasDefaultCertificate {
+		return errors.New("gRPC: a TLS certificate is required")
+	}
+	if len(caCertificates) == 0 {
+		return errors.New("gRPC: at least a CA certificate is required to verify client certificates")
+	}
+	if c.EventsBufferSize < 0 {
+		return fmt.Errorf("gRPC: invalid events buffer size %d", c.EventsBufferSize)
+	}
+	return nil
+}
+
+func (c *GRPCConfig) getTLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate:           certMgr.GetCertificateFunc(c.getCertID()),
+		MinVersion:               util.GetTLSVersion(c.MinTLSVersion),
+		NextProtos:               []string{"h2"},
+		ClientCAs:                certMgr.GetRootCAs(),
+		ClientAuth:               tls.RequireAndVerifyClientCert,
+		VerifyConnection:         verifyGRPCTLSConnection,
+		PreferServerCipherSuites: true,
+	}
+}
+
+func verifyGRPCTLSConnection(state tls.ConnectionState) error {
+	s := httpdServer{}
+	return s.verifyTLSConnection(state)
+}
+
+func newGRPCServer(creds credentials.TransportCredentials, eventsBufferSize int) *grpc.Server {
+	server := grpc.NewServer(
+		grpc.Creds(creds),
+		grpc.UnaryInterceptor(grpcUnaryAuthInterceptor),
+		grpc.StreamInterceptor(grpcStreamAuthInterceptor),
+	)
+	server.RegisterService(&grpcAdminServiceDesc, &grpcAdminServer{
+		eventsBufferSize: eventsBufferSize,
+	})
+	return server
+}
+
+func (c *GRPCConfig) listenAndServe() error {
+	listener, err := net.Listen("tcp", c.GetAddress())
+	if err != nil {
+		logger.Error(grpcLogSender, "", "error starting listener on address %q: %v", c.GetAddress(), err)
+		return err
+	}
+	server := newGRPCServer(credentials.NewTLS(c.getTLSConfig()), c.EventsBufferSize)
+	logger.Info(grpcLogSender, "", "gRPC server listening on %q", c.GetAddress())
+	return server.Serve(listener)
+}
+
+func getGRPCStatusError(err error) error {
+	var code codes.Code
+	switch getRespStatus(err) {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	default:
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
+
+func getGRPCRemoteIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	return util.GetIPFromRemoteAddress(p.Addr.String())
+}
+
+func getGRPCMetadata(md metadata.MD, key string) string {
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// getGRPCAdmin returns the admin authenticated for the specified context
+func getGRPCAdmin(ctx context.Context) (dataprovider.Admin, error) {
+	admin, ok := ctx.Value(grpcAdminKey).(dataprovider.Admin)
+	if !ok {
+		return admin, status.Error(codes.Unauthenticated, "no authenticated admin")
+	}
+	return admin, nil
+}
+
+func authenticateGRPCAPIKey(apiKey, ipAddr string, perm grpcMethodPerm) (dataprovider.Admin, error) {
+	keyParams := strings.SplitN(apiKey, ".", 3)
+	if len(keyParams) < 2 {
+		return dataprovider.Admin{}, status.Error(codes.Unauthenticated, "the provided api key is not valid")
+	}
+	keyID := keyParams[0]
+	apiUser := ""
+	if len(keyParams) > 2 {
+		apiUser = keyParams[2]
+	}
+	k, err := dataprovider.APIKeyExists(keyID)
+	if err != nil || k.Scope != dataprovider.APIKeyScopeAdmin {
+		logger.Debug(grpcLogSender, "", "invalid api key %q: %v", keyID, err)
+		return dataprovider.Admin{}, status.Error(codes.Unauthenticated, "the provided api key is not valid")
+	}
+	if err := k.Authenticate(keyParams[1]); err != nil {
+		logger.Debug(grpcLogSender, "", "unable to authenticate api key %q: %v", keyID, err)
+		return dataprovider.Admin{}, status.Error(codes.Unauthenticated, "the provided api key cannot be authenticated")
+	}
+	if !k.HasScopeAccess(perm.resource, perm.write) {
+		return dataprovider.Admin{}, status.Error(codes.PermissionDenied,
+			"the provided api key does not have the required scope")
+	}
+	if k.Admin != "" {
+		apiUser = k.Admin
+	}
+	if apiUser == "" {
+		return dataprovider.Admin{}, status.Error(codes.Unauthenticated,
+			"the provided key is not associated with any admin and no username was provided")
+	}
+	admin, err := dataprovider.AdminExists(apiUser)
+	if err != nil {
+		logger.Debug(grpcLogSender, "", "unable to get admin %q associated with api key %q: %v", apiUser, keyID, err)
+		return admin, status.Error(codes.Unauthenticated,
+			"the admin associated with the provided api key cannot be authenticated")
+	}
+	if !admin.Filters.AllowAPIKeyAuth {
+		return admin, status.Error(codes.Unauthenticated,
+			fmt.Sprintf("API key authentication disabled for admin %q", admin.Username))
+	}
+	if err := admin.CanLogin(ipAddr); err != nil {
+		return admin, status.Error(codes.Unauthenticated, err.Error())
+	}
+	dataprovider.UpdateAPIKeyLastUse(&k) //nolint:errcheck
+	return admin, nil
+}
+
+func authenticateGRPCBasicAuth(authorization, ipAddr string) (dataprovider.Admin, error) {
+	scheme, encoded, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "basic") {
+		return dataprovider.Admin{}, status.Error(codes.Unauthenticated, "unsupported authorization scheme")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return dataprovider.Admin{}, status.Error(codes.Unauthenticated, "invalid basic auth credentials")
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return dataprovider.Admin{}, status.Error(codes.Unauthenticated, "invalid basic auth credentials")
+	}
+	admin, err := dataprovider.CheckAdminAndPass(username, password, ipAddr)
+	if err != nil {
+		return admin, status.Error(codes.Unauthenticated, dataprovider.ErrInvalidCredentials.Error())
+	}
+	// there is no way to provide the second factor here, API keys must be used instead
+	if admin.Filters.TOTPConfig.Enabled || admin.IsPushEnabled() {
+		return admin, status.Error(codes.Unauthenticated,
+			"two-factor authentication is enabled, please use an API key")
+	}
+	return admin, nil
+}
+
+// authenticateGRPCRequest authenticates the admin using either an API key or
+// basic auth credentials and checks the permissions required for the method
+func authenticateGRPCRequest(ctx context.Context, fullMethod string) (context.Context, error) {
+	perm, ok := grpcMethodPerms[path.Base(fullMethod)]
+	if !ok {
+		return ctx, status.Errorf(codes.Unimplemented, "unknown method %q", fullMethod)
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx, status.Error(codes.Unauthenticated, "no credentials provided")
+	}
+	ipAddr := getGRPCRemoteIP(ctx)
+	var admin dataprovider.Admin
+	var err error
+	if apiKey := getGRPCMetadata(md, grpcAPIKeyMetadata); apiKey != "" {
+		admin, err = authenticateGRPCAPIKey(apiKey, ipAddr, perm)
+	} else if authorization := getGRPCMetadata(md, grpcAuthorizationMetadata); authorization != "" {
+		admin, err = authenticateGRPCBasicAuth(authorization, ipAddr)
+	} else {
+		err = status.Error(codes.Unauthenticated, "no credentials provided")
+	}
+	if err != nil {
+		logger.Debug(grpcLogSender, "", "unable to authenticate request for method %q from ip %q: %v",
+			fullMethod, ipAddr, err)
+		return ctx, err
+	}
+	if !admin.HasPermission(perm.perm) {
+		return ctx, status.Errorf(codes.PermissionDenied, "admin %q does not have the %q permission",
+			admin.Username, perm.perm)
+	}
+	return context.WithValue(ctx, grpcAdminKey, admin), nil
+}
+
+func grpcUnaryAuthInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	ctx, err := authenticateGRPCRequest(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+type grpcAuthenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcAuthenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func grpcStreamAuthInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	ctx, err := authenticateGRPCRequest(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &grpcAuthenticatedStream{ServerStream: ss, ctx: ctx})
+}
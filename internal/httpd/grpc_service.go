@@ -0,0 +1,562 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/eventstream"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// grpcAdminServiceName is the fully qualified name of the gRPC admin service,
+// see openapi/admin.proto for the service definition.
+// Users, groups and folders are exchanged as google.protobuf.Struct messages
+// having the same fields as the REST API JSON objects
+const grpcAdminServiceName = "sftpgo.admin.v1.AdminService"
+
+type grpcAdminServer struct {
+	eventsBufferSize int
+}
+
+type grpcUnaryMethod[T proto.Message] func(*grpcAdminServer, context.Context, T) (proto.Message, error)
+
+func grpcUnaryHandler[T proto.Message](name string, newRequest func() T, method grpcUnaryMethod[T]) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := newRequest()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req any) (any, error) {
+				return method(srv.(*grpcAdminServer), ctx, req.(T))
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: fmt.Sprintf("/%s/%s", grpcAdminServiceName, name),
+			}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+func newStringValue() *wrapperspb.StringValue {
+	return &wrapperspb.StringValue{}
+}
+
+func newStruct() *structpb.Struct {
+	return &structpb.Struct{}
+}
+
+func newEmpty() *emptypb.Empty {
+	return &emptypb.Empty{}
+}
+
+var grpcAdminServiceDesc = grpc.ServiceDesc{
+	ServiceName: grpcAdminServiceName,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		grpcUnaryHandler("GetUsers", newStruct, (*grpcAdminServer).getUsers),
+		grpcUnaryHandler("GetUser", newStringValue, (*grpcAdminServer).getUser),
+		grpcUnaryHandler("AddUser", newStruct, (*grpcAdminServer).addUser),
+		grpcUnaryHandler("UpdateUser", newStruct, (*grpcAdminServer).updateUser),
+		grpcUnaryHandler("DeleteUser", newStringValue, (*grpcAdminServer).deleteUser),
+		grpcUnaryHandler("GetGroups", newStruct, (*grpcAdminServer).getGroups),
+		grpcUnaryHandler("GetGroup", newStringValue, (*grpcAdminServer).getGroup),
+		grpcUnaryHandler("AddGroup", newStruct, (*grpcAdminServer).addGroup),
+		grpcUnaryHandler("UpdateGroup", newStruct, (*grpcAdminServer).updateGroup),
+		grpcUnaryHandler("DeleteGroup", newStringValue, (*grpcAdminServer).deleteGroup),
+		grpcUnaryHandler("GetFolders", newStruct, (*grpcAdminServer).getFolders),
+		grpcUnaryHandler("GetFolder", newStringValue, (*grpcAdminServer).getFolder),
+		grpcUnaryHandler("AddFolder", newStruct, (*grpcAdminServer).addFolder),
+		grpcUnaryHandler("UpdateFolder", newStruct, (*grpcAdminServer).updateFolder),
+		grpcUnaryHandler("DeleteFolder", newStringValue, (*grpcAdminServer).deleteFolder),
+		grpcUnaryHandler("GetUsersQuotaScans", newEmpty, (*grpcAdminServer).getUsersQuotaScans),
+		grpcUnaryHandler("StartUserQuotaScan", newStringValue, (*grpcAdminServer).startUserQuotaScan),
+		grpcUnaryHandler("GetFoldersQuotaScans", newEmpty, (*grpcAdminServer).getFoldersQuotaScans),
+		grpcUnaryHandler("StartFolderQuotaScan", newStringValue, (*grpcAdminServer).startFolderQuotaScan),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "StreamEvents",
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				req := &structpb.Struct{}
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(*grpcAdminServer).streamEvents(req, stream)
+			},
+			ServerStreams: true,
+		},
+	},
+	Metadata: "openapi/admin.proto",
+}
+
+// toGRPCStruct converts the specified object to a Struct using its JSON representation
+func toGRPCStruct(v any) (*structpb.Struct, error) {
+	var m map[string]any
+	if err := toGRPCValue(v, &m); err != nil {
+		return nil, err
+	}
+	s, err := structpb.NewStruct(m)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return s, nil
+}
+
+// toGRPCList converts the specified slice to a ListValue using its JSON representation
+func toGRPCList(v any) (*structpb.ListValue, error) {
+	var l []any
+	if err := toGRPCValue(v, &l); err != nil {
+		return nil, err
+	}
+	list, err := structpb.NewList(l)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return list, nil
+}
+
+func toGRPCValue(v, dst any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// fromGRPCStruct decodes the specified Struct into v using its JSON representation
+func fromGRPCStruct(s *structpb.Struct, v any) error {
+	data, err := json.Marshal(s.AsMap())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+func getGRPCExecutor(ctx context.Context) (string, string, error) {
+	admin, err := getGRPCAdmin(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	return admin.Username, getGRPCRemoteIP(ctx), nil
+}
+
+// getGRPCSearchFilters returns the limit, offset and order to use for listing
+// objects, the defaults and the limits are the same as the REST API
+func getGRPCSearchFilters(req *structpb.Struct) (int, int, string, error) {
+	filters := struct {
+		Limit  *int   `json:"limit"`
+		Offset int    `json:"offset"`
+		Order  string `json:"order"`
+	}{}
+	if err := fromGRPCStruct(req, &filters); err != nil {
+		return 0, 0, "", err
+	}
+	limit := 100
+	if filters.Limit != nil {
+		limit = *filters.Limit
+		if limit > 500 {
+			limit = 500
+		}
+	}
+	order := dataprovider.OrderASC
+	if filters.Order != "" {
+		order = filters.Order
+		if order != dataprovider.OrderASC && order != dataprovider.OrderDESC {
+			return 0, 0, "", status.Error(codes.InvalidArgument, "invalid order")
+		}
+	}
+	return limit, filters.Offset, order, nil
+}
+
+func checkGRPCApproval(op string) error {
+	if isApprovalRequired(op) {
+		return status.Error(codes.FailedPrecondition,
+			"this operation requires a two-person approval, please use the REST API")
+	}
+	return nil
+}
+
+func (s *grpcAdminServer) getUsers(_ context.Context, req *structpb.Struct) (proto.Message, error) {
+	limit, offset, order, err := getGRPCSearchFilters(req)
+	if err != nil {
+		return nil, err
+	}
+	users, err := dataprovider.GetUsers(limit, offset, order)
+	if err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	return toGRPCList(users)
+}
+
+func (s *grpcAdminServer) getUser(_ context.Context, req *wrapperspb.StringValue) (proto.Message, error) {
+	user, err := dataprovider.UserExists(req.GetValue())
+	if err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	user.PrepareForRendering()
+	return toGRPCStruct(user)
+}
+
+func (s *grpcAdminServer) addUser(ctx context.Context, req *structpb.Struct) (proto.Message, error) {
+	executor, ipAddr, err := getGRPCExecutor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var user dataprovider.User
+	if err := fromGRPCStruct(req, &user); err != nil {
+		return nil, err
+	}
+	if err := dataprovider.AddUser(&user, executor, ipAddr); err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	return s.getUser(ctx, wrapperspb.String(user.Username))
+}
+
+func (s *grpcAdminServer) updateUser(ctx context.Context, req *structpb.Struct) (proto.Message, error) {
+	executor, ipAddr, err := getGRPCExecutor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, err := dataprovider.UserExists(req.GetFields()["username"].GetStringValue())
+	if err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	err = decodeUserForUpdate(&user, func(v any) error {
+		return fromGRPCStruct(req, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := dataprovider.UpdateUser(&user, executor, ipAddr); err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcAdminServer) deleteUser(ctx context.Context, req *wrapperspb.StringValue) (proto.Message, error) {
+	executor, ipAddr, err := getGRPCExecutor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkGRPCApproval(ApprovalOpDeleteUser); err != nil {
+		return nil, err
+	}
+	if err := doDeleteUser(req.GetValue(), false, executor, ipAddr); err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcAdminServer) getGroups(_ context.Context, req *structpb.Struct) (proto.Message, error) {
+	limit, offset, order, err := getGRPCSearchFilters(req)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := dataprovider.GetGroups(limit, offset, order, false)
+	if err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	return toGRPCList(groups)
+}
+
+func (s *grpcAdminServer) getGroup(_ context.Context, req *wrapperspb.StringValue) (proto.Message, error) {
+	group, err := dataprovider.GroupExists(req.GetValue())
+	if err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	group.PrepareForRendering()
+	return toGRPCStruct(group)
+}
+
+func (s *grpcAdminServer) addGroup(ctx context.Context, req *structpb.Struct) (proto.Message, error) {
+	executor, ipAddr, err := getGRPCExecutor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var group dataprovider.Group
+	if err := fromGRPCStruct(req, &group); err != nil {
+		return nil, err
+	}
+	if err := dataprovider.AddGroup(&group, executor, ipAddr); err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	return s.getGroup(ctx, wrapperspb.String(group.Name))
+}
+
+func (s *grpcAdminServer) updateGroup(ctx context.Context, req *structpb.Struct) (proto.Message, error) {
+	executor, ipAddr, err := getGRPCExecutor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	group, err := dataprovider.GroupExists(req.GetFields()["name"].GetStringValue())
+	if err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	users := group.Users
+	err = decodeGroupForUpdate(&group, func(v any) error {
+		return fromGRPCStruct(req, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := dataprovider.UpdateGroup(&group, users, executor, ipAddr); err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcAdminServer) deleteGroup(ctx context.Context, req *wrapperspb.StringValue) (proto.Message, error) {
+	executor, ipAddr, err := getGRPCExecutor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := dataprovider.DeleteGroup(req.GetValue(), executor, ipAddr); err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcAdminServer) getFolders(_ context.Context, req *structpb.Struct) (proto.Message, error) {
+	limit, offset, order, err := getGRPCSearchFilters(req)
+	if err != nil {
+		return nil, err
+	}
+	folders, err := dataprovider.GetFolders(limit, offset, order, false)
+	if err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	return toGRPCList(folders)
+}
+
+func (s *grpcAdminServer) getFolder(_ context.Context, req *wrapperspb.StringValue) (proto.Message, error) {
+	folder, err := dataprovider.GetFolderByName(req.GetValue())
+	if err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	folder.PrepareForRendering()
+	return toGRPCStruct(folder)
+}
+
+func (s *grpcAdminServer) addFolder(ctx context.Context, req *structpb.Struct) (proto.Message, error) {
+	executor, ipAddr, err := getGRPCExecutor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var folder vfs.BaseVirtualFolder
+	if err := fromGRPCStruct(req, &folder); err != nil {
+		return nil, err
+	}
+	if err := dataprovider.AddFolder(&folder, executor, ipAddr); err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	return s.getFolder(ctx, wrapperspb.String(folder.Name))
+}
+
+func (s *grpcAdminServer) updateFolder(ctx context.Context, req *structpb.Struct) (proto.Message, error) {
+	executor, ipAddr, err := getGRPCExecutor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	folder, err := dataprovider.GetFolderByName(req.GetFields()["name"].GetStringValue())
+	if err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	users := folder.Users
+	groups := folder.Groups
+	err = decodeFolderForUpdate(&folder, func(v any) error {
+		return fromGRPCStruct(req, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := dataprovider.UpdateFolder(&folder, users, groups, executor, ipAddr); err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcAdminServer) deleteFolder(ctx context.Context, req *wrapperspb.StringValue) (proto.Message, error) {
+	executor, ipAddr, err := getGRPCExecutor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkGRPCApproval(ApprovalOpDeleteFolder); err != nil {
+		return nil, err
+	}
+	if err := doDeleteFolder(req.GetValue(), false, executor, ipAddr); err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcAdminServer) getUsersQuotaScans(_ context.Context, _ *emptypb.Empty) (proto.Message, error) {
+	return toGRPCList(common.QuotaScans.GetUsersQuotaScans())
+}
+
+func (s *grpcAdminServer) getFoldersQuotaScans(_ context.Context, _ *emptypb.Empty) (proto.Message, error) {
+	return toGRPCList(common.QuotaScans.GetVFoldersQuotaScans())
+}
+
+func (s *grpcAdminServer) startUserQuotaScan(_ context.Context, req *wrapperspb.StringValue) (proto.Message, error) {
+	if dataprovider.GetQuotaTracking() == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "quota tracking is disabled")
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(req.GetValue())
+	if err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	if !common.QuotaScans.AddUserQuotaScan(user.Username) {
+		return nil, status.Errorf(codes.AlreadyExists, "another scan is already in progress for user %q",
+			user.Username)
+	}
+	go doUserQuotaScan(user) //nolint:errcheck
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcAdminServer) startFolderQuotaScan(_ context.Context, req *wrapperspb.StringValue) (proto.Message, error) {
+	if dataprovider.GetQuotaTracking() == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "quota tracking is disabled")
+	}
+	folder, err := dataprovider.GetFolderByName(req.GetValue())
+	if err != nil {
+		return nil, getGRPCStatusError(err)
+	}
+	if !common.QuotaScans.AddVFolderQuotaScan(folder.Name) {
+		return nil, status.Errorf(codes.AlreadyExists, "another scan is already in progress for folder %q",
+			folder.Name)
+	}
+	go doFolderQuotaScan(folder) //nolint:errcheck
+	return &emptypb.Empty{}, nil
+}
+
+// grpcEventsFilter defines the events to stream, if both fs_events and
+// provider_events are false all the events are streamed
+type grpcEventsFilter struct {
+	FsEvents       bool     `json:"fs_events"`
+	ProviderEvents bool     `json:"provider_events"`
+	Actions        []string `json:"actions"`
+	Usernames      []string `json:"usernames"`
+}
+
+func (f *grpcEventsFilter) match(event eventstream.Event) bool {
+	var action, username string
+	if event.FsEvent != nil {
+		if f.ProviderEvents && !f.FsEvents {
+			return false
+		}
+		action = event.FsEvent.Action
+		username = event.FsEvent.Username
+	} else {
+		if f.FsEvents && !f.ProviderEvents {
+			return false
+		}
+		action = event.ProviderEvent.Action
+		username = event.ProviderEvent.Username
+	}
+	if len(f.Actions) > 0 && !util.Contains(f.Actions, action) {
+		return false
+	}
+	if len(f.Usernames) > 0 && !util.Contains(f.Usernames, username) {
+		return false
+	}
+	return true
+}
+
+func getGRPCEvent(event eventstream.Event) (*structpb.Struct, error) {
+	if event.FsEvent != nil {
+		return toGRPCStruct(map[string]any{
+			"type":  "fs",
+			"event": event.FsEvent,
+		})
+	}
+	return toGRPCStruct(map[string]any{
+		"type": "provider",
+		"event": map[string]any{
+			"action":      event.ProviderEvent.Action,
+			"username":    event.ProviderEvent.Username,
+			"object_type": event.ProviderEvent.ObjectType,
+			"object_name": event.ProviderEvent.ObjectName,
+			"ip":          event.ProviderEvent.IP,
+			"timestamp":   event.ProviderEvent.Timestamp,
+		},
+	})
+}
+
+func (s *grpcAdminServer) streamEvents(req *structpb.Struct, stream grpc.ServerStream) error {
+	var filter grpcEventsFilter
+	if err := fromGRPCStruct(req, &filter); err != nil {
+		return err
+	}
+	admin, err := getGRPCAdmin(stream.Context())
+	if err != nil {
+		return err
+	}
+	subscription := eventstream.Subscribe(s.eventsBufferSize)
+	defer subscription.Close()
+
+	logger.Debug(grpcLogSender, "", "admin %q subscribed to events, filter: %+v", admin.Username, filter)
+	defer func() {
+		logger.Debug(grpcLogSender, "", "events subscription closed for admin %q, dropped events: %d",
+			admin.Username, subscription.Dropped())
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-subscription.Events():
+			if !ok {
+				return nil
+			}
+			if !filter.match(event) {
+				continue
+			}
+			msg, err := getGRPCEvent(event)
+			if err != nil {
+				return err
+			}
+			if err := stream.SendMsg(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk/plugin/notifier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/eventstream"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func startTestGRPCServer(t *testing.T) *grpc.ClientConn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := newGRPCServer(insecure.NewCredentials(), 10)
+	go server.Serve(listener) //nolint:errcheck
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return conn
+}
+
+func invokeGRPC(ctx context.Context, conn *grpc.ClientConn, method string, req, resp proto.Message) error {
+	return conn.Invoke(ctx, fmt.Sprintf("/%s/%s", grpcAdminServiceName, method), req, resp)
+}
+
+func getGRPCBasicAuthContext(username, password string) context.Context {
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return metadata.AppendToOutgoingContext(context.Background(), grpcAuthorizationMetadata, "Basic "+auth)
+}
+
+func TestGRPCAdminAPI(t *testing.T) {
+	conn := startTestGRPCServer(t)
+	ctx := getGRPCBasicAuthContext(defaultAdminUsername, "password")
+
+	users := &structpb.ListValue{}
+	err := invokeGRPC(context.Background(), conn, "GetUsers", &structpb.Struct{}, users)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	err = invokeGRPC(getGRPCBasicAuthContext(defaultAdminUsername, "wrong"), conn, "GetUsers", &structpb.Struct{}, users)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	err = invokeGRPC(ctx, conn, "GetUsers", &structpb.Struct{}, users)
+	assert.NoError(t, err)
+	req, err := structpb.NewStruct(map[string]any{"order": "invalid"})
+	require.NoError(t, err)
+	err = invokeGRPC(ctx, conn, "GetUsers", req, users)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	username := "grpc_user"
+	req, err = structpb.NewStruct(map[string]any{
+		"username":    username,
+		"password":    "pwd",
+		"status":      1,
+		"home_dir":    filepath.Join(os.TempDir(), username),
+		"permissions": map[string]any{"/": []any{"*"}},
+		"quota_files": 100,
+	})
+	require.NoError(t, err)
+	user := &structpb.Struct{}
+	err = invokeGRPC(ctx, conn, "AddUser", req, user)
+	require.NoError(t, err)
+	assert.Equal(t, username, user.GetFields()["username"].GetStringValue())
+	assert.Equal(t, float64(100), user.GetFields()["quota_files"].GetNumberValue())
+	err = invokeGRPC(ctx, conn, "AddUser", req, user)
+	assert.Error(t, err)
+	// update the description only, the password and the permissions must be preserved
+	req, err = structpb.NewStruct(map[string]any{
+		"username":    username,
+		"status":      1,
+		"home_dir":    filepath.Join(os.TempDir(), username),
+		"description": "grpc desc",
+	})
+	require.NoError(t, err)
+	err = invokeGRPC(ctx, conn, "UpdateUser", req, &emptypb.Empty{})
+	assert.NoError(t, err)
+	err = invokeGRPC(ctx, conn, "GetUser", wrapperspb.String(username), user)
+	assert.NoError(t, err)
+	assert.Equal(t, "grpc desc", user.GetFields()["description"].GetStringValue())
+	_, err = dataprovider.CheckUserAndPass(username, "pwd", "", "HTTP")
+	assert.NoError(t, err)
+	u, err := dataprovider.UserExists(username)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dataprovider.PermAny}, u.Permissions["/"])
+	err = invokeGRPC(ctx, conn, "GetUser", wrapperspb.String("missing user"), user)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	err = invokeGRPC(ctx, conn, "StartUserQuotaScan", wrapperspb.String(username), &emptypb.Empty{})
+	assert.NoError(t, err)
+	scans := &structpb.ListValue{}
+	err = invokeGRPC(ctx, conn, "GetUsersQuotaScans", &emptypb.Empty{}, scans)
+	assert.NoError(t, err)
+
+	groupName := "grpc_group"
+	req, err = structpb.NewStruct(map[string]any{
+		"name":        groupName,
+		"description": "group",
+	})
+	require.NoError(t, err)
+	group := &structpb.Struct{}
+	err = invokeGRPC(ctx, conn, "AddGroup", req, group)
+	require.NoError(t, err)
+	assert.Equal(t, groupName, group.GetFields()["name"].GetStringValue())
+	req.Fields["description"] = structpb.NewStringValue("updated group")
+	err = invokeGRPC(ctx, conn, "UpdateGroup", req, &emptypb.Empty{})
+	assert.NoError(t, err)
+	err = invokeGRPC(ctx, conn, "GetGroup", wrapperspb.String(groupName), group)
+	assert.NoError(t, err)
+	assert.Equal(t, "updated group", group.GetFields()["description"].GetStringValue())
+	groups := &structpb.ListValue{}
+	err = invokeGRPC(ctx, conn, "GetGroups", &structpb.Struct{}, groups)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, len(groups.GetValues()), 1)
+	err = invokeGRPC(ctx, conn, "DeleteGroup", wrapperspb.String(groupName), &emptypb.Empty{})
+	assert.NoError(t, err)
+
+	folderName := "grpc_folder"
+	req, err = structpb.NewStruct(map[string]any{
+		"name":        folderName,
+		"mapped_path": filepath.Join(os.TempDir(), folderName),
+	})
+	require.NoError(t, err)
+	folder := &structpb.Struct{}
+	err = invokeGRPC(ctx, conn, "AddFolder", req, folder)
+	require.NoError(t, err)
+	req.Fields["description"] = structpb.NewStringValue("folder desc")
+	err = invokeGRPC(ctx, conn, "UpdateFolder", req, &emptypb.Empty{})
+	assert.NoError(t, err)
+	err = invokeGRPC(ctx, conn, "GetFolder", wrapperspb.String(folderName), folder)
+	assert.NoError(t, err)
+	assert.Equal(t, "folder desc", folder.GetFields()["description"].GetStringValue())
+	folders := &structpb.ListValue{}
+	req, err = structpb.NewStruct(map[string]any{"limit": 1000, "order": dataprovider.OrderDESC})
+	require.NoError(t, err)
+	err = invokeGRPC(ctx, conn, "GetFolders", req, folders)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, len(folders.GetValues()), 1)
+	err = invokeGRPC(ctx, conn, "StartFolderQuotaScan", wrapperspb.String(folderName), &emptypb.Empty{})
+	assert.NoError(t, err)
+	err = invokeGRPC(ctx, conn, "GetFoldersQuotaScans", &emptypb.Empty{}, scans)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		err = invokeGRPC(ctx, conn, "GetFoldersQuotaScans", &emptypb.Empty{}, scans)
+		return err == nil && len(scans.GetValues()) == 0
+	}, 2*time.Second, 100*time.Millisecond)
+	err = invokeGRPC(ctx, conn, "DeleteFolder", wrapperspb.String(folderName), &emptypb.Empty{})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		err = invokeGRPC(ctx, conn, "GetUsersQuotaScans", &emptypb.Empty{}, scans)
+		return err == nil && len(scans.GetValues()) == 0
+	}, 2*time.Second, 100*time.Millisecond)
+	err = invokeGRPC(ctx, conn, "DeleteUser", wrapperspb.String(username), &emptypb.Empty{})
+	assert.NoError(t, err)
+	err = invokeGRPC(ctx, conn, "DeleteUser", wrapperspb.String(username), &emptypb.Empty{})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	err = os.RemoveAll(filepath.Join(os.TempDir(), username))
+	assert.NoError(t, err)
+}
+
+func TestGRPCAPIKeyAuth(t *testing.T) {
+	conn := startTestGRPCServer(t)
+
+	admin := dataprovider.Admin{
+		Username:    "grpc_admin",
+		Password:    "password",
+		Status:      1,
+		Permissions: []string{dataprovider.PermAdminViewUsers},
+		Filters: dataprovider.AdminFilters{
+			AllowAPIKeyAuth: true,
+		},
+	}
+	err := dataprovider.AddAdmin(&admin, "", "")
+	require.NoError(t, err)
+	apiKey := dataprovider.APIKey{
+		Name:   "grpc key",
+		Scope:  dataprovider.APIKeyScopeAdmin,
+		Admin:  admin.Username,
+		Scopes: []string{dataprovider.APIKeyResourceFolders + ":" + dataprovider.APIKeyAccessRead},
+	}
+	err = dataprovider.AddAPIKey(&apiKey, "", "")
+	require.NoError(t, err)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), grpcAPIKeyMetadata, apiKey.DisplayKey())
+
+	list := &structpb.ListValue{}
+	err = invokeGRPC(ctx, conn, "GetFolders", &structpb.Struct{}, list)
+	assert.NoError(t, err)
+	// the key has no access to users
+	err = invokeGRPC(ctx, conn, "GetUsers", &structpb.Struct{}, list)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	// the admin has no permission to add folders
+	apiKey.Scopes = nil
+	err = dataprovider.UpdateAPIKey(&apiKey, "", "")
+	require.NoError(t, err)
+	err = invokeGRPC(ctx, conn, "GetUsers", &structpb.Struct{}, list)
+	assert.NoError(t, err)
+	req, err := structpb.NewStruct(map[string]any{"name": "f", "mapped_path": os.TempDir()})
+	require.NoError(t, err)
+	err = invokeGRPC(ctx, conn, "AddFolder", req, &structpb.Struct{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	// invalid keys
+	invalidCtx := metadata.AppendToOutgoingContext(context.Background(), grpcAPIKeyMetadata, "invalid")
+	err = invokeGRPC(invalidCtx, conn, "GetUsers", &structpb.Struct{}, list)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	invalidCtx = metadata.AppendToOutgoingContext(context.Background(), grpcAPIKeyMetadata, apiKey.KeyID+".wrong")
+	err = invokeGRPC(invalidCtx, conn, "GetUsers", &structpb.Struct{}, list)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	// API key authentication disabled for the admin
+	admin.Filters.AllowAPIKeyAuth = false
+	err = dataprovider.UpdateAdmin(&admin, "", "")
+	require.NoError(t, err)
+	err = invokeGRPC(ctx, conn, "GetUsers", &structpb.Struct{}, list)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	err = dataprovider.DeleteAPIKey(apiKey.KeyID, "", "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteAdmin(admin.Username, "", "")
+	assert.NoError(t, err)
+}
+
+func TestGRPCStreamEvents(t *testing.T) {
+	conn := startTestGRPCServer(t)
+	ctx, cancel := context.WithCancel(getGRPCBasicAuthContext(defaultAdminUsername, "password"))
+	defer cancel()
+
+	stream, err := conn.NewStream(ctx, &grpcAdminServiceDesc.Streams[0],
+		fmt.Sprintf("/%s/StreamEvents", grpcAdminServiceName))
+	require.NoError(t, err)
+	req, err := structpb.NewStruct(map[string]any{"provider_events": true, "actions": []any{"add"}})
+	require.NoError(t, err)
+	err = stream.SendMsg(req)
+	require.NoError(t, err)
+	err = stream.CloseSend()
+	require.NoError(t, err)
+	assert.Eventually(t, eventstream.HasSubscribers, 2*time.Second, 50*time.Millisecond)
+
+	group := dataprovider.Group{}
+	group.Name = "grpc_events_group"
+	err = dataprovider.AddGroup(&group, "", "")
+	require.NoError(t, err)
+	err = dataprovider.UpdateGroup(&group, nil, "", "")
+	require.NoError(t, err)
+	err = dataprovider.DeleteGroup(group.Name, "", "")
+	require.NoError(t, err)
+
+	event := &structpb.Struct{}
+	err = stream.RecvMsg(event)
+	require.NoError(t, err)
+	assert.Equal(t, "provider", event.GetFields()["type"].GetStringValue())
+	details := event.GetFields()["event"].GetStructValue().GetFields()
+	assert.Equal(t, "add", details["action"].GetStringValue())
+	assert.Equal(t, "group", details["object_type"].GetStringValue())
+	assert.Equal(t, group.Name, details["object_name"].GetStringValue())
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		return !eventstream.HasSubscribers()
+	}, 2*time.Second, 50*time.Millisecond)
+}
+
+func TestGRPCEventsFilter(t *testing.T) {
+	fsEvent := eventstream.Event{
+		FsEvent: &notifier.FsEvent{
+			Action:   "upload",
+			Username: "user",
+		},
+	}
+	providerEvent := eventstream.Event{
+		ProviderEvent: &notifier.ProviderEvent{
+			Action:     "add",
+			Username:   "admin",
+			ObjectType: "user",
+			ObjectName: "user",
+		},
+	}
+
+	filter := grpcEventsFilter{}
+	assert.True(t, filter.match(fsEvent))
+	assert.True(t, filter.match(providerEvent))
+	filter.FsEvents = true
+	assert.True(t, filter.match(fsEvent))
+	assert.False(t, filter.match(providerEvent))
+	filter.FsEvents = false
+	filter.ProviderEvents = true
+	assert.False(t, filter.match(fsEvent))
+	assert.True(t, filter.match(providerEvent))
+	filter.FsEvents = true
+	filter.Actions = []string{"upload"}
+	assert.True(t, filter.match(fsEvent))
+	assert.False(t, filter.match(providerEvent))
+	filter.Actions = nil
+	filter.Usernames = []string{"admin"}
+	assert.False(t, filter.match(fsEvent))
+	assert.True(t, filter.match(providerEvent))
+
+	msg, err := getGRPCEvent(fsEvent)
+	assert.NoError(t, err)
+	assert.Equal(t, "fs", msg.GetFields()["type"].GetStringValue())
+	assert.Equal(t, "upload", msg.GetFields()["event"].GetStructValue().GetFields()["action"].GetStringValue())
+}
+
+func TestGRPCConfig(t *testing.T) {
+	c := GRPCConfig{}
+	assert.NoError(t, c.validate(false, nil))
+	c.Port = 9443
+	assert.Equal(t, ":9443", c.GetAddress())
+	assert.Error(t, c.validate(false, nil))
+	assert.Error(t, c.validate(true, nil))
+	assert.NoError(t, c.validate(true, []string{"ca.crt"}))
+	assert.Equal(t, common.DefaultTLSKeyPaidID, c.getCertID())
+	c.CertificateFile = "grpc.crt"
+	c.CertificateKeyFile = "grpc.key"
+	assert.NoError(t, c.validate(false, []string{"ca.crt"}))
+	assert.Equal(t, c.GetAddress(), c.getCertID())
+	c.EventsBufferSize = -1
+	assert.Error(t, c.validate(false, []string{"ca.crt"}))
+
+	err := getGRPCStatusError(util.NewValidationError("invalid"))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	err = getGRPCStatusError(util.NewRecordNotFoundError("not found"))
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	err = getGRPCStatusError(util.NewMethodDisabledError("disabled"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	err = getGRPCStatusError(dataprovider.ErrNotImplemented)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+	err = getGRPCStatusError(dataprovider.ErrProviderUnavailable)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	err = getGRPCStatusError(os.ErrClosed)
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	_, err = getGRPCAdmin(context.Background())
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = authenticateGRPCRequest(context.Background(), "/unknown/Method")
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+	_, err = authenticateGRPCBasicAuth("Bearer token", "")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = authenticateGRPCBasicAuth("Basic invalid base64", "")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = authenticateGRPCBasicAuth("Basic "+base64.StdEncoding.EncodeToString([]byte("nocolon")), "")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -118,6 +118,12 @@ func (c *Connection) getFileReader(name string, offset int64, method string) (io
 	}
 
 	if method != http.MethodHead {
+		// the download of archived objects fails after the response headers are sent,
+		// so we check the archive status before starting the transfer
+		if err := c.CheckArchivedObject(fs, p); err != nil {
+			c.Log(logger.LevelDebug, "download for file %q denied, archive check: %v", name, err)
+			return nil, err
+		}
 		if err := common.ExecutePreAction(c.BaseConnection, common.OperationPreDownload, p, name, 0, 0); err != nil {
 			c.Log(logger.LevelDebug, "download for file %#v denied by pre action: %v", name, err)
 			return nil, c.GetPermissionDeniedError()
@@ -135,7 +141,34 @@ func (c *Connection) getFileReader(name string, offset int64, method string) (io
 	return newHTTPDFile(baseTransfer, nil, r), nil
 }
 
-func (c *Connection) getFileWriter(name string) (io.WriteCloser, error) {
+func (c *Connection) getFileVersionReader(fs vfs.FsVersioner, fsPath, name, versionID string) (io.ReadCloser, error) {
+	c.UpdateLastActivity()
+
+	transferQuota := c.GetTransferQuota()
+	if !transferQuota.HasDownloadSpace() {
+		c.Log(logger.LevelInfo, "denying file read due to quota limits")
+		return nil, c.GetReadQuotaExceededError()
+	}
+	if err := common.ExecutePreAction(c.BaseConnection, common.OperationPreDownload, fsPath, name, 0, 0); err != nil {
+		c.Log(logger.LevelDebug, "download for file %q, version %q denied by pre action: %v", name, versionID, err)
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	file, r, cancelFn, err := fs.OpenVersion(fsPath, versionID, 0)
+	if err != nil {
+		c.Log(logger.LevelError, "could not open file %q, version %q for reading: %+v", fsPath, versionID, err)
+		return nil, c.GetFsError(fs, err)
+	}
+
+	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, fsPath, fsPath, name, common.TransferDownload,
+		0, 0, 0, 0, false, fs, transferQuota)
+	return newHTTPDFile(baseTransfer, nil, r), nil
+}
+
+// getFileWriter returns a writer for the specified file. declaredSize is the
+// upload size declared by the client, if known, and it allows to reject uploads
+// exceeding the user's limits before writing any data. Use -1 if unknown
+func (c *Connection) getFileWriter(name string, declaredSize int64) (io.WriteCloser, error) {
 	c.UpdateLastActivity()
 
 	if ok, _ := c.User.IsFileAllowed(name); !ok {
@@ -157,7 +190,7 @@ func (c *Connection) getFileWriter(name string) (io.WriteCloser, error) {
 		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(name)) {
 			return nil, c.GetPermissionDeniedError()
 		}
-		return c.handleUploadFile(fs, p, filePath, name, true, 0)
+		return c.handleUploadFile(fs, p, filePath, name, true, 0, declaredSize)
 	}
 
 	if statErr != nil {
@@ -175,33 +208,37 @@ func (c *Connection) getFileWriter(name string) (io.WriteCloser, error) {
 		return nil, c.GetPermissionDeniedError()
 	}
 
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
-		err = fs.Rename(p, filePath)
-		if err != nil {
-			c.Log(logger.LevelError, "error renaming existing file for atomic upload, source: %#v, dest: %#v, err: %+v",
-				p, filePath, err)
-			return nil, c.GetFsError(fs, err)
-		}
-	}
-
-	return c.handleUploadFile(fs, p, filePath, name, false, stat.Size())
+	return c.handleUploadFile(fs, p, filePath, name, false, stat.Size(), declaredSize)
 }
 
-func (c *Connection) handleUploadFile(fs vfs.Fs, resolvedPath, filePath, requestPath string, isNewFile bool, fileSize int64) (io.WriteCloser, error) {
+func (c *Connection) handleUploadFile(fs vfs.Fs, resolvedPath, filePath, requestPath string, isNewFile bool,
+	fileSize, declaredSize int64,
+) (io.WriteCloser, error) {
 	diskQuota, transferQuota := c.HasSpace(isNewFile, false, requestPath)
 	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
 		c.Log(logger.LevelInfo, "denying file write due to quota limits")
 		return nil, common.ErrQuotaExceeded
 	}
+	maxWriteSize, _ := c.GetMaxWriteSize(requestPath, diskQuota, false, fileSize, fs.IsUploadResumeSupported())
+	if err := c.CheckDeclaredUploadSize(declaredSize, maxWriteSize, transferQuota); err != nil {
+		return nil, err
+	}
 	err := common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath, fileSize, os.O_TRUNC)
 	if err != nil {
 		c.Log(logger.LevelDebug, "upload for file %#v denied by pre action: %v", requestPath, err)
 		return nil, c.GetPermissionDeniedError()
 	}
 
-	maxWriteSize, _ := c.GetMaxWriteSize(diskQuota, false, fileSize, fs.IsUploadResumeSupported())
+	if !isNewFile && common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+		err = fs.Rename(resolvedPath, filePath)
+		if err != nil {
+			c.Log(logger.LevelError, "error renaming existing file for atomic upload, source: %#v, dest: %#v, err: %+v",
+				resolvedPath, filePath, err)
+			return nil, c.GetFsError(fs, err)
+		}
+	}
 
-	file, w, cancelFn, err := fs.Create(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	file, w, cancelFn, err := vfs.CreateWithSize(fs, filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, declaredSize)
 	if err != nil {
 		c.Log(logger.LevelError, "error opening existing file, source: %#v, err: %+v", filePath, err)
 		return nil, c.GetFsError(fs, err)
@@ -213,7 +250,7 @@ func (c *Connection) handleUploadFile(fs vfs.Fs, resolvedPath, filePath, request
 		if vfs.HasTruncateSupport(fs) {
 			vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
 			if err == nil {
-				dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
+				dataprovider.UpdateVirtualFolderQuotaForUser(&vfolder, c.User.Username, 0, -fileSize) //nolint:errcheck
 				if vfolder.IsIncludedInUserQuota() {
 					dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
 				}
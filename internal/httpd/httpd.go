@@ -39,215 +39,280 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/ftpd"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
+	"github.com/drakkan/sftpgo/v2/internal/s3d"
 	"github.com/drakkan/sftpgo/v2/internal/sftpd"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/webdavd"
 )
 
 const (
-	logSender                             = "httpd"
-	tokenPath                             = "/api/v2/token"
-	logoutPath                            = "/api/v2/logout"
-	userTokenPath                         = "/api/v2/user/token"
-	userLogoutPath                        = "/api/v2/user/logout"
-	activeConnectionsPath                 = "/api/v2/connections"
-	quotasBasePath                        = "/api/v2/quotas"
-	userPath                              = "/api/v2/users"
-	versionPath                           = "/api/v2/version"
-	folderPath                            = "/api/v2/folders"
-	groupPath                             = "/api/v2/groups"
-	serverStatusPath                      = "/api/v2/status"
-	dumpDataPath                          = "/api/v2/dumpdata"
-	loadDataPath                          = "/api/v2/loaddata"
-	defenderHosts                         = "/api/v2/defender/hosts"
-	adminPath                             = "/api/v2/admins"
-	adminPwdPath                          = "/api/v2/admin/changepwd"
-	adminProfilePath                      = "/api/v2/admin/profile"
-	userPwdPath                           = "/api/v2/user/changepwd"
-	userDirsPath                          = "/api/v2/user/dirs"
-	userFilesPath                         = "/api/v2/user/files"
-	userStreamZipPath                     = "/api/v2/user/streamzip"
-	userUploadFilePath                    = "/api/v2/user/files/upload"
-	userFilesDirsMetadataPath             = "/api/v2/user/files/metadata"
-	apiKeysPath                           = "/api/v2/apikeys"
-	adminTOTPConfigsPath                  = "/api/v2/admin/totp/configs"
-	adminTOTPGeneratePath                 = "/api/v2/admin/totp/generate"
-	adminTOTPValidatePath                 = "/api/v2/admin/totp/validate"
-	adminTOTPSavePath                     = "/api/v2/admin/totp/save"
-	admin2FARecoveryCodesPath             = "/api/v2/admin/2fa/recoverycodes"
-	userTOTPConfigsPath                   = "/api/v2/user/totp/configs"
-	userTOTPGeneratePath                  = "/api/v2/user/totp/generate"
-	userTOTPValidatePath                  = "/api/v2/user/totp/validate"
-	userTOTPSavePath                      = "/api/v2/user/totp/save"
-	user2FARecoveryCodesPath              = "/api/v2/user/2fa/recoverycodes"
-	userProfilePath                       = "/api/v2/user/profile"
-	userSharesPath                        = "/api/v2/user/shares"
-	retentionBasePath                     = "/api/v2/retention/users"
-	retentionChecksPath                   = "/api/v2/retention/users/checks"
-	metadataBasePath                      = "/api/v2/metadata/users"
-	metadataChecksPath                    = "/api/v2/metadata/users/checks"
-	fsEventsPath                          = "/api/v2/events/fs"
-	providerEventsPath                    = "/api/v2/events/provider"
-	sharesPath                            = "/api/v2/shares"
-	eventActionsPath                      = "/api/v2/eventactions"
-	eventRulesPath                        = "/api/v2/eventrules"
-	healthzPath                           = "/healthz"
-	robotsTxtPath                         = "/robots.txt"
-	webRootPathDefault                    = "/"
-	webBasePathDefault                    = "/web"
-	webBasePathAdminDefault               = "/web/admin"
-	webBasePathClientDefault              = "/web/client"
-	webAdminSetupPathDefault              = "/web/admin/setup"
-	webAdminLoginPathDefault              = "/web/admin/login"
-	webAdminOIDCLoginPathDefault          = "/web/admin/oidclogin"
-	webOIDCRedirectPathDefault            = "/web/oidc/redirect"
-	webAdminTwoFactorPathDefault          = "/web/admin/twofactor"
-	webAdminTwoFactorRecoveryPathDefault  = "/web/admin/twofactor-recovery"
-	webLogoutPathDefault                  = "/web/admin/logout"
-	webUsersPathDefault                   = "/web/admin/users"
-	webUserPathDefault                    = "/web/admin/user"
-	webConnectionsPathDefault             = "/web/admin/connections"
-	webFoldersPathDefault                 = "/web/admin/folders"
-	webFolderPathDefault                  = "/web/admin/folder"
-	webGroupsPathDefault                  = "/web/admin/groups"
-	webGroupPathDefault                   = "/web/admin/group"
-	webStatusPathDefault                  = "/web/admin/status"
-	webAdminsPathDefault                  = "/web/admin/managers"
-	webAdminPathDefault                   = "/web/admin/manager"
-	webMaintenancePathDefault             = "/web/admin/maintenance"
-	webBackupPathDefault                  = "/web/admin/backup"
-	webRestorePathDefault                 = "/web/admin/restore"
-	webScanVFolderPathDefault             = "/web/admin/quotas/scanfolder"
-	webQuotaScanPathDefault               = "/web/admin/quotas/scanuser"
-	webChangeAdminPwdPathDefault          = "/web/admin/changepwd"
-	webAdminForgotPwdPathDefault          = "/web/admin/forgot-password"
-	webAdminResetPwdPathDefault           = "/web/admin/reset-password"
-	webAdminProfilePathDefault            = "/web/admin/profile"
-	webAdminMFAPathDefault                = "/web/admin/mfa"
-	webAdminEventRulesPathDefault         = "/web/admin/eventrules"
-	webAdminEventRulePathDefault          = "/web/admin/eventrule"
-	webAdminEventActionsPathDefault       = "/web/admin/eventactions"
-	webAdminEventActionPathDefault        = "/web/admin/eventaction"
-	webAdminTOTPGeneratePathDefault       = "/web/admin/totp/generate"
-	webAdminTOTPValidatePathDefault       = "/web/admin/totp/validate"
-	webAdminTOTPSavePathDefault           = "/web/admin/totp/save"
-	webAdminRecoveryCodesPathDefault      = "/web/admin/recoverycodes"
-	webTemplateUserDefault                = "/web/admin/template/user"
-	webTemplateFolderDefault              = "/web/admin/template/folder"
-	webDefenderPathDefault                = "/web/admin/defender"
-	webDefenderHostsPathDefault           = "/web/admin/defender/hosts"
-	webClientLoginPathDefault             = "/web/client/login"
-	webClientOIDCLoginPathDefault         = "/web/client/oidclogin"
-	webClientTwoFactorPathDefault         = "/web/client/twofactor"
-	webClientTwoFactorRecoveryPathDefault = "/web/client/twofactor-recovery"
-	webClientFilesPathDefault             = "/web/client/files"
-	webClientFilePathDefault              = "/web/client/file"
-	webClientSharesPathDefault            = "/web/client/shares"
-	webClientSharePathDefault             = "/web/client/share"
-	webClientEditFilePathDefault          = "/web/client/editfile"
-	webClientDirsPathDefault              = "/web/client/dirs"
-	webClientDownloadZipPathDefault       = "/web/client/downloadzip"
-	webClientProfilePathDefault           = "/web/client/profile"
-	webClientMFAPathDefault               = "/web/client/mfa"
-	webClientTOTPGeneratePathDefault      = "/web/client/totp/generate"
-	webClientTOTPValidatePathDefault      = "/web/client/totp/validate"
-	webClientTOTPSavePathDefault          = "/web/client/totp/save"
-	webClientRecoveryCodesPathDefault     = "/web/client/recoverycodes"
-	webChangeClientPwdPathDefault         = "/web/client/changepwd"
-	webClientLogoutPathDefault            = "/web/client/logout"
-	webClientPubSharesPathDefault         = "/web/client/pubshares"
-	webClientForgotPwdPathDefault         = "/web/client/forgot-password"
-	webClientResetPwdPathDefault          = "/web/client/reset-password"
-	webClientViewPDFPathDefault           = "/web/client/viewpdf"
-	webClientGetPDFPathDefault            = "/web/client/getpdf"
-	webStaticFilesPathDefault             = "/static"
-	webOpenAPIPathDefault                 = "/openapi"
+	logSender                              = "httpd"
+	tokenPath                              = "/api/v2/token"
+	logoutPath                             = "/api/v2/logout"
+	userTokenPath                          = "/api/v2/user/token"
+	userLogoutPath                         = "/api/v2/user/logout"
+	activeConnectionsPath                  = "/api/v2/connections"
+	quotasBasePath                         = "/api/v2/quotas"
+	userPath                               = "/api/v2/users"
+	versionPath                            = "/api/v2/version"
+	folderPath                             = "/api/v2/folders"
+	groupPath                              = "/api/v2/groups"
+	serverStatusPath                       = "/api/v2/status"
+	dumpDataPath                           = "/api/v2/dumpdata"
+	loadDataPath                           = "/api/v2/loaddata"
+	dbBackupPath                           = "/api/v2/dbbackup"
+	bulkPath                               = "/api/v2/bulk"
+	defenderHosts                          = "/api/v2/defender/hosts"
+	defenderFeeds                          = "/api/v2/defender/feeds"
+	adminPath                              = "/api/v2/admins"
+	adminPwdPath                           = "/api/v2/admin/changepwd"
+	adminProfilePath                       = "/api/v2/admin/profile"
+	userPwdPath                            = "/api/v2/user/changepwd"
+	userDirsPath                           = "/api/v2/user/dirs"
+	userFilesPath                          = "/api/v2/user/files"
+	userStreamZipPath                      = "/api/v2/user/streamzip"
+	userUploadFilePath                     = "/api/v2/user/files/upload"
+	userFilesDirsMetadataPath              = "/api/v2/user/files/metadata"
+	userFilesRestorePath                   = "/api/v2/user/files/restore"
+	userFilesCopyPath                      = "/api/v2/user/files/copy"
+	userFileVersionsPath                   = "/api/v2/user/files/versions"
+	userFileVersionDownloadPath            = "/api/v2/user/files/versions/download"
+	userFileVersionRestorePath             = "/api/v2/user/files/versions/restore"
+	apiKeysPath                            = "/api/v2/apikeys"
+	adminTOTPConfigsPath                   = "/api/v2/admin/totp/configs"
+	adminTOTPGeneratePath                  = "/api/v2/admin/totp/generate"
+	adminTOTPValidatePath                  = "/api/v2/admin/totp/validate"
+	adminTOTPSavePath                      = "/api/v2/admin/totp/save"
+	admin2FARecoveryCodesPath              = "/api/v2/admin/2fa/recoverycodes"
+	userTOTPConfigsPath                    = "/api/v2/user/totp/configs"
+	userTOTPGeneratePath                   = "/api/v2/user/totp/generate"
+	userTOTPValidatePath                   = "/api/v2/user/totp/validate"
+	userTOTPSavePath                       = "/api/v2/user/totp/save"
+	user2FARecoveryCodesPath               = "/api/v2/user/2fa/recoverycodes"
+	userProfilePath                        = "/api/v2/user/profile"
+	userSpeedTestPath                      = "/api/v2/user/speedtest"
+	userTUSPath                            = "/api/v2/user/tus"
+	userS3CredentialsPath                  = "/api/v2/user/s3credentials"
+	userSSHCertificatePath                 = "/api/v2/user/sshcert"
+	userSharesPath                         = "/api/v2/user/shares"
+	userFoldersQuotaPath                   = "/api/v2/user/folders/quota"
+	retentionBasePath                      = "/api/v2/retention/users"
+	retentionChecksPath                    = "/api/v2/retention/users/checks"
+	metadataBasePath                       = "/api/v2/metadata/users"
+	metadataChecksPath                     = "/api/v2/metadata/users/checks"
+	fsEventsPath                           = "/api/v2/events/fs"
+	providerEventsPath                     = "/api/v2/events/provider"
+	sharesPath                             = "/api/v2/shares"
+	eventActionsPath                       = "/api/v2/eventactions"
+	eventRulesPath                         = "/api/v2/eventrules"
+	approvalsPath                          = "/api/v2/approvals"
+	emailTemplatesPath                     = "/api/v2/emailtemplates"
+	smtpTestPath                           = "/api/v2/smtp/test"
+	jobsPath                               = "/api/v2/jobs"
+	hostKeysPath                           = "/api/v2/hostkeys"
+	clientVersionsPath                     = "/api/v2/clientversions"
+	complianceExportsPath                  = "/api/v2/compliance/exports"
+	auditPath                              = "/api/v2/audit"
+	auditLogExportPath                     = "/api/v2/auditlog/export"
+	deadLettersPath                        = "/api/v2/deadletters"
+	healthzPath                            = "/healthz"
+	robotsTxtPath                          = "/robots.txt"
+	webRootPathDefault                     = "/"
+	webBasePathDefault                     = "/web"
+	webBasePathAdminDefault                = "/web/admin"
+	webBasePathClientDefault               = "/web/client"
+	webAdminSetupPathDefault               = "/web/admin/setup"
+	webAdminLoginPathDefault               = "/web/admin/login"
+	webAdminOIDCLoginPathDefault           = "/web/admin/oidclogin"
+	webOIDCRedirectPathDefault             = "/web/oidc/redirect"
+	webAdminSAMLLoginPathDefault           = "/web/admin/samllogin"
+	webSAMLMetadataPathDefault             = "/web/saml/metadata"
+	webSAMLACSPathDefault                  = "/web/saml/acs"
+	webAdminTwoFactorPathDefault           = "/web/admin/twofactor"
+	webAdminTwoFactorRecoveryPathDefault   = "/web/admin/twofactor-recovery"
+	webLogoutPathDefault                   = "/web/admin/logout"
+	webUsersPathDefault                    = "/web/admin/users"
+	webUserPathDefault                     = "/web/admin/user"
+	webConnectionsPathDefault              = "/web/admin/connections"
+	webFoldersPathDefault                  = "/web/admin/folders"
+	webFolderPathDefault                   = "/web/admin/folder"
+	webGroupsPathDefault                   = "/web/admin/groups"
+	webGroupPathDefault                    = "/web/admin/group"
+	webStatusPathDefault                   = "/web/admin/status"
+	webAdminsPathDefault                   = "/web/admin/managers"
+	webAdminPathDefault                    = "/web/admin/manager"
+	webAPIKeysPathDefault                  = "/web/admin/apikeys"
+	webMaintenancePathDefault              = "/web/admin/maintenance"
+	webBackupPathDefault                   = "/web/admin/backup"
+	webRestorePathDefault                  = "/web/admin/restore"
+	webScanVFolderPathDefault              = "/web/admin/quotas/scanfolder"
+	webQuotaScanPathDefault                = "/web/admin/quotas/scanuser"
+	webChangeAdminPwdPathDefault           = "/web/admin/changepwd"
+	webAdminForgotPwdPathDefault           = "/web/admin/forgot-password"
+	webAdminResetPwdPathDefault            = "/web/admin/reset-password"
+	webAdminProfilePathDefault             = "/web/admin/profile"
+	webAdminMFAPathDefault                 = "/web/admin/mfa"
+	webAdminEventRulesPathDefault          = "/web/admin/eventrules"
+	webAdminEventRulePathDefault           = "/web/admin/eventrule"
+	webAdminEventActionsPathDefault        = "/web/admin/eventactions"
+	webAdminEventActionPathDefault         = "/web/admin/eventaction"
+	webAdminTOTPGeneratePathDefault        = "/web/admin/totp/generate"
+	webAdminTOTPValidatePathDefault        = "/web/admin/totp/validate"
+	webAdminTOTPSavePathDefault            = "/web/admin/totp/save"
+	webAdminRecoveryCodesPathDefault       = "/web/admin/recoverycodes"
+	webAdminWebAuthnPathDefault            = "/web/admin/webauthn"
+	webAdminTwoFactorWebAuthnPathDefault   = "/web/admin/twofactor/webauthn"
+	webTemplateUserDefault                 = "/web/admin/template/user"
+	webTemplateFolderDefault               = "/web/admin/template/folder"
+	webDefenderPathDefault                 = "/web/admin/defender"
+	webDefenderHostsPathDefault            = "/web/admin/defender/hosts"
+	webAdminSMTPTestPathDefault            = "/web/admin/smtp/test"
+	webApprovalsPathDefault                = "/web/admin/approvals"
+	webApprovalsRequestsPathDefault        = "/web/admin/approvals/requests"
+	webClientLoginPathDefault              = "/web/client/login"
+	webClientOIDCLoginPathDefault          = "/web/client/oidclogin"
+	webClientSAMLLoginPathDefault          = "/web/client/samllogin"
+	webClientTwoFactorPathDefault          = "/web/client/twofactor"
+	webClientTwoFactorRecoveryPathDefault  = "/web/client/twofactor-recovery"
+	webClientRecoveryCodesEmailPathDefault = "/web/client/twofactor-recovery/email"
+	webClientFilesPathDefault              = "/web/client/files"
+	webClientFilePathDefault               = "/web/client/file"
+	webClientFileRestorePathDefault        = "/web/client/file/restore"
+	webClientFileCopyPathDefault           = "/web/client/file/copy"
+	webClientFileVersionsPathDefault       = "/web/client/file/versions"
+	webClientFileVersionDownloadDefault    = "/web/client/file/versions/download"
+	webClientFileVersionRestoreDefault     = "/web/client/file/versions/restore"
+	webClientTUSPathDefault                = "/web/client/tus"
+	webClientSharesPathDefault             = "/web/client/shares"
+	webClientSharePathDefault              = "/web/client/share"
+	webClientEditFilePathDefault           = "/web/client/editfile"
+	webClientDirsPathDefault               = "/web/client/dirs"
+	webClientDownloadZipPathDefault        = "/web/client/downloadzip"
+	webClientProfilePathDefault            = "/web/client/profile"
+	webClientMFAPathDefault                = "/web/client/mfa"
+	webClientTOTPGeneratePathDefault       = "/web/client/totp/generate"
+	webClientTOTPValidatePathDefault       = "/web/client/totp/validate"
+	webClientTOTPSavePathDefault           = "/web/client/totp/save"
+	webClientRecoveryCodesPathDefault      = "/web/client/recoverycodes"
+	webClientWebAuthnPathDefault           = "/web/client/webauthn"
+	webClientTwoFactorWebAuthnPathDefault  = "/web/client/twofactor/webauthn"
+	webChangeClientPwdPathDefault          = "/web/client/changepwd"
+	webClientLogoutPathDefault             = "/web/client/logout"
+	webClientPubSharesPathDefault          = "/web/client/pubshares"
+	webClientForgotPwdPathDefault          = "/web/client/forgot-password"
+	webClientResetPwdPathDefault           = "/web/client/reset-password"
+	webClientMagicLinkPathDefault          = "/web/client/magic-link"
+	webClientMagicLinkLoginPathDefault     = "/web/client/magic-link/login"
+	webClientViewPDFPathDefault            = "/web/client/viewpdf"
+	webClientGetPDFPathDefault             = "/web/client/getpdf"
+	webStaticFilesPathDefault              = "/static"
+	webOpenAPIPathDefault                  = "/openapi"
 	// MaxRestoreSize defines the max size for the loaddata input file
 	MaxRestoreSize       = 10485760 // 10 MB
 	maxRequestSize       = 1048576  // 1MB
 	maxLoginBodySize     = 262144   // 256 KB
 	httpdMaxEditFileSize = 1048576  // 1 MB
-	maxMultipartMem      = 10485760 // 10 MB
 	osWindows            = "windows"
 	otpHeaderCode        = "X-SFTPGO-OTP"
 	mTimeHeader          = "X-SFTPGO-MTIME"
 )
 
 var (
-	certMgr                        *common.CertManager
-	cleanupTicker                  *time.Ticker
-	cleanupDone                    chan bool
-	invalidatedJWTTokens           sync.Map
-	csrfTokenAuth                  *jwtauth.JWTAuth
-	webRootPath                    string
-	webBasePath                    string
-	webBaseAdminPath               string
-	webBaseClientPath              string
-	webOIDCRedirectPath            string
-	webAdminSetupPath              string
-	webAdminOIDCLoginPath          string
-	webAdminLoginPath              string
-	webAdminTwoFactorPath          string
-	webAdminTwoFactorRecoveryPath  string
-	webLogoutPath                  string
-	webUsersPath                   string
-	webUserPath                    string
-	webConnectionsPath             string
-	webFoldersPath                 string
-	webFolderPath                  string
-	webGroupsPath                  string
-	webGroupPath                   string
-	webStatusPath                  string
-	webAdminsPath                  string
-	webAdminPath                   string
-	webMaintenancePath             string
-	webBackupPath                  string
-	webRestorePath                 string
-	webScanVFolderPath             string
-	webQuotaScanPath               string
-	webAdminProfilePath            string
-	webAdminMFAPath                string
-	webAdminEventRulesPath         string
-	webAdminEventRulePath          string
-	webAdminEventActionsPath       string
-	webAdminEventActionPath        string
-	webAdminTOTPGeneratePath       string
-	webAdminTOTPValidatePath       string
-	webAdminTOTPSavePath           string
-	webAdminRecoveryCodesPath      string
-	webChangeAdminPwdPath          string
-	webAdminForgotPwdPath          string
-	webAdminResetPwdPath           string
-	webTemplateUser                string
-	webTemplateFolder              string
-	webDefenderPath                string
-	webDefenderHostsPath           string
-	webClientLoginPath             string
-	webClientOIDCLoginPath         string
-	webClientTwoFactorPath         string
-	webClientTwoFactorRecoveryPath string
-	webClientFilesPath             string
-	webClientFilePath              string
-	webClientSharesPath            string
-	webClientSharePath             string
-	webClientEditFilePath          string
-	webClientDirsPath              string
-	webClientDownloadZipPath       string
-	webClientProfilePath           string
-	webChangeClientPwdPath         string
-	webClientMFAPath               string
-	webClientTOTPGeneratePath      string
-	webClientTOTPValidatePath      string
-	webClientTOTPSavePath          string
-	webClientRecoveryCodesPath     string
-	webClientPubSharesPath         string
-	webClientLogoutPath            string
-	webClientForgotPwdPath         string
-	webClientResetPwdPath          string
-	webClientViewPDFPath           string
-	webClientGetPDFPath            string
-	webStaticFilesPath             string
-	webOpenAPIPath                 string
+	certMgr                         *common.CertManager
+	cleanupTicker                   *time.Ticker
+	cleanupDone                     chan bool
+	invalidatedJWTTokens            sync.Map
+	csrfTokenAuth                   *jwtauth.JWTAuth
+	webRootPath                     string
+	webBasePath                     string
+	webBaseAdminPath                string
+	webBaseClientPath               string
+	webOIDCRedirectPath             string
+	webSAMLMetadataPath             string
+	webSAMLACSPath                  string
+	webAdminSetupPath               string
+	webAdminOIDCLoginPath           string
+	webAdminSAMLLoginPath           string
+	webAdminLoginPath               string
+	webAdminTwoFactorPath           string
+	webAdminTwoFactorRecoveryPath   string
+	webLogoutPath                   string
+	webUsersPath                    string
+	webUserPath                     string
+	webConnectionsPath              string
+	webFoldersPath                  string
+	webFolderPath                   string
+	webGroupsPath                   string
+	webGroupPath                    string
+	webStatusPath                   string
+	webAdminsPath                   string
+	webAdminPath                    string
+	webAPIKeysPath                  string
+	webMaintenancePath              string
+	webBackupPath                   string
+	webRestorePath                  string
+	webScanVFolderPath              string
+	webQuotaScanPath                string
+	webAdminProfilePath             string
+	webAdminMFAPath                 string
+	webAdminEventRulesPath          string
+	webAdminEventRulePath           string
+	webAdminEventActionsPath        string
+	webAdminEventActionPath         string
+	webAdminTOTPGeneratePath        string
+	webAdminTOTPValidatePath        string
+	webAdminTOTPSavePath            string
+	webAdminRecoveryCodesPath       string
+	webAdminWebAuthnPath            string
+	webAdminTwoFactorWebAuthnPath   string
+	webChangeAdminPwdPath           string
+	webAdminForgotPwdPath           string
+	webAdminResetPwdPath            string
+	webTemplateUser                 string
+	webTemplateFolder               string
+	webDefenderPath                 string
+	webDefenderHostsPath            string
+	webAdminSMTPTestPath            string
+	webApprovalsPath                string
+	webApprovalsRequestsPath        string
+	webClientLoginPath              string
+	webClientOIDCLoginPath          string
+	webClientSAMLLoginPath          string
+	webClientTwoFactorPath          string
+	webClientTwoFactorRecoveryPath  string
+	webClientRecoveryCodesEmailPath string
+	webClientFilesPath              string
+	webClientFilePath               string
+	webClientFileRestorePath        string
+	webClientFileCopyPath           string
+	webClientFileVersionsPath       string
+	webClientFileVersionDownload    string
+	webClientFileVersionRestore     string
+	webClientTUSPath                string
+	webClientSharesPath             string
+	webClientSharePath              string
+	webClientEditFilePath           string
+	webClientDirsPath               string
+	webClientDownloadZipPath        string
+	webClientProfilePath            string
+	webChangeClientPwdPath          string
+	webClientMFAPath                string
+	webClientTOTPGeneratePath       string
+	webClientTOTPValidatePath       string
+	webClientTOTPSavePath           string
+	webClientRecoveryCodesPath      string
+	webClientWebAuthnPath           string
+	webClientTwoFactorWebAuthnPath  string
+	webClientPubSharesPath          string
+	webClientLogoutPath             string
+	webClientForgotPwdPath          string
+	webClientResetPwdPath           string
+	webClientMagicLinkPath          string
+	webClientMagicLinkLoginPath     string
+	webClientViewPDFPath            string
+	webClientGetPDFPath             string
+	webStaticFilesPath              string
+	webOpenAPIPath                  string
 	// max upload size for http clients, 1GB by default
 	maxUploadFileSize          = int64(1048576000)
 	hideSupportLink            bool
@@ -272,6 +337,9 @@ type HTTPSProxyHeader struct {
 	Value string
 }
 
+var validReferrerPolicies = []string{"no-referrer", "no-referrer-when-downgrade", "origin",
+	"origin-when-cross-origin", "same-origin", "strict-origin", "strict-origin-when-cross-origin", "unsafe-url"}
+
 // SecurityConf allows to add some security related headers to HTTP responses and to restrict allowed hosts
 type SecurityConf struct {
 	// Set to true to enable the security configurations
@@ -309,9 +377,81 @@ type SecurityConf struct {
 	CrossOriginOpenerPolicy string `json:"cross_origin_opener_policy" mapstructure:"cross_origin_opener_policy"`
 	// ExpectCTHeader allows to set the Expect-CT header value. Default is "".
 	ExpectCTHeader string `json:"expect_ct_header" mapstructure:"expect_ct_header"`
+	// FrameAncestors defines the sources allowed to embed the web UIs, for example
+	// "'self'" or "https://portal.example.com". They are added to the Content-Security-Policy
+	// header as "frame-ancestors" directive, unless the policy already defines it.
+	// Default is empty, which means "'self'". Set "*" to allow embedding from any source
+	FrameAncestors []string `json:"frame_ancestors" mapstructure:"frame_ancestors"`
+	// ReferrerPolicy allows to set the Referrer-Policy header value.
+	// Default is "", which means "strict-origin-when-cross-origin"
+	ReferrerPolicy string `json:"referrer_policy" mapstructure:"referrer_policy"`
 	proxyHeaders   []string
 }
 
+func (s *SecurityConf) validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	if s.STSSeconds < 0 {
+		return fmt.Errorf("invalid STS seconds: %d", s.STSSeconds)
+	}
+	for _, source := range s.FrameAncestors {
+		if source == "" || strings.ContainsAny(source, ";, ") {
+			return fmt.Errorf("invalid frame ancestor %q", source)
+		}
+	}
+	if s.ReferrerPolicy != "" && !util.Contains(validReferrerPolicies, s.ReferrerPolicy) {
+		return fmt.Errorf("invalid referrer policy %q", s.ReferrerPolicy)
+	}
+	return nil
+}
+
+func (s *SecurityConf) getFrameAncestors() []string {
+	if len(s.FrameAncestors) == 0 {
+		return []string{"'self'"}
+	}
+	return s.FrameAncestors
+}
+
+// getContentSecurityPolicy returns the configured Content-Security-Policy with
+// the frame-ancestors directive appended, if not already defined
+func (s *SecurityConf) getContentSecurityPolicy() string {
+	if strings.Contains(s.ContentSecurityPolicy, "frame-ancestors") {
+		return s.ContentSecurityPolicy
+	}
+	frameAncestors := "frame-ancestors " + strings.Join(s.getFrameAncestors(), " ")
+	policy := strings.TrimSuffix(strings.TrimSpace(s.ContentSecurityPolicy), ";")
+	if policy == "" {
+		return frameAncestors
+	}
+	return policy + "; " + frameAncestors
+}
+
+// getFrameOptions returns the X-Frame-Options header value equivalent to the
+// configured frame ancestors, for browsers without CSP support. Empty means that
+// the frame ancestors cannot be expressed using X-Frame-Options
+func (s *SecurityConf) getFrameOptions() string {
+	frameAncestors := s.getFrameAncestors()
+	if len(frameAncestors) != 1 {
+		return ""
+	}
+	switch frameAncestors[0] {
+	case "'none'":
+		return "DENY"
+	case "'self'":
+		return "SAMEORIGIN"
+	default:
+		return ""
+	}
+}
+
+func (s *SecurityConf) getReferrerPolicy() string {
+	if s.ReferrerPolicy == "" {
+		return "strict-origin-when-cross-origin"
+	}
+	return s.ReferrerPolicy
+}
+
 func (s *SecurityConf) updateProxyHeaders() {
 	if !s.Enabled {
 		s.proxyHeaders = nil
@@ -416,9 +556,9 @@ type Binding struct {
 	EnableRESTAPI bool `json:"enable_rest_api" mapstructure:"enable_rest_api"`
 	// Defines the login methods available for the WebAdmin and WebClient UIs:
 	//
-	// - 0 means any configured method: username/password login form and OIDC, if enabled
-	// - 1 means OIDC for the WebAdmin UI
-	// - 2 means OIDC for the WebClient UI
+	// - 0 means any configured method: username/password login form, OIDC and SAML, if enabled
+	// - 1 means OIDC and SAML for the WebAdmin UI
+	// - 2 means OIDC and SAML for the WebClient UI
 	// - 4 means login form for the WebAdmin UI
 	// - 8 means login form for the WebClient UI
 	//
@@ -436,6 +576,10 @@ type Binding struct {
 	// set to 1 to require client certificate authentication in addition to basic auth.
 	// You need to define at least a certificate authority for this to work
 	ClientAuthType int `json:"client_auth_type" mapstructure:"client_auth_type"`
+	// Enable HTTP/3 over QUIC, in addition to HTTP/1.1 and HTTP/2, on the UDP port with the same
+	// number. The HTTP/3 endpoint is advertised using the Alt-Svc header.
+	// HTTPS must be enabled, Unix-domain sockets are not supported
+	EnableHTTP3 bool `json:"enable_http3" mapstructure:"enable_http3"`
 	// TLSCipherSuites is a list of supported cipher suites for TLS version 1.2.
 	// If CipherSuites is nil/empty, a default list of secure cipher suites
 	// is used, with a preference order based on hardware performance.
@@ -471,11 +615,31 @@ type Binding struct {
 	WebClientIntegrations []WebClientIntegration `json:"web_client_integrations" mapstructure:"web_client_integrations"`
 	// Defining an OIDC configuration the web admin and web client UI will use OpenID to authenticate users.
 	OIDC OIDC `json:"oidc" mapstructure:"oidc"`
+	// Defining a SAML configuration the web admin and web client UI will allow to authenticate
+	// users using a SAML 2.0 identity provider
+	SAML SAML `json:"saml" mapstructure:"saml"`
+	// MagicLink allows WebClient users to login using a one-time link sent via email
+	MagicLink MagicLink `json:"magic_link" mapstructure:"magic_link"`
 	// Security defines security headers to add to HTTP responses and allows to restrict allowed hosts
 	Security SecurityConf `json:"security" mapstructure:"security"`
+	// CORS configuration for this binding. If not enabled the global CORS configuration
+	// is used, if any
+	Cors CorsConfig `json:"cors" mapstructure:"cors"`
 	// Branding defines customizations to suit your brand
-	Branding         Branding `json:"branding" mapstructure:"branding"`
-	allowHeadersFrom []func(net.IP) bool
+	Branding Branding `json:"branding" mapstructure:"branding"`
+	// Client software allowed or denied for this binding, based on the User-Agent.
+	// The User-Agent is checked for each request
+	ClientPolicy dataprovider.ClientPolicy `json:"client_policy" mapstructure:"client_policy"`
+	// Set to true to return the same error for all the failed user logins, regardless
+	// of the failure reason, and to check the password of unknown users against a dummy
+	// hash, so that the existing users cannot be enumerated
+	UniformAuthErrors bool `json:"uniform_auth_errors" mapstructure:"uniform_auth_errors"`
+	allowHeadersFrom  []func(net.IP) bool
+	// ISO 3166-1 alpha-2 country codes allowed to connect to this binding. If set,
+	// only clients geolocated in the listed countries are allowed
+	AllowedCountries []string `json:"allowed_countries" mapstructure:"allowed_countries"`
+	// ISO 3166-1 alpha-2 country codes denied to connect to this binding
+	DeniedCountries []string `json:"denied_countries" mapstructure:"denied_countries"`
 }
 
 func (b *Binding) checkWebClientIntegrations() {
@@ -578,20 +742,30 @@ func (b *Binding) isWebClientLoginFormDisabled() bool {
 	return false
 }
 
+func (b *Binding) checkCors(globalCors CorsConfig) error {
+	if !b.Cors.Enabled {
+		b.Cors = globalCors
+	}
+	if err := b.Cors.validate(); err != nil {
+		return fmt.Errorf("invalid CORS configuration for binding %q: %w", b.GetAddress(), err)
+	}
+	return nil
+}
+
 func (b *Binding) checkLoginMethods() error {
 	if b.isWebAdminLoginFormDisabled() && b.isWebAdminOIDCLoginDisabled() {
 		return errors.New("no login method available for WebAdmin UI")
 	}
 	if !b.isWebAdminOIDCLoginDisabled() {
-		if b.isWebAdminLoginFormDisabled() && !b.OIDC.hasRoles() {
+		if b.isWebAdminLoginFormDisabled() && !b.OIDC.hasRoles() && !b.SAML.hasRoles() {
 			return errors.New("no login method available for WebAdmin UI")
 		}
 	}
-	if b.isWebClientLoginFormDisabled() && b.isWebClientOIDCLoginDisabled() {
+	if b.isWebClientLoginFormDisabled() && b.isWebClientOIDCLoginDisabled() && !b.MagicLink.Enabled {
 		return errors.New("no login method available for WebClient UI")
 	}
 	if !b.isWebClientOIDCLoginDisabled() {
-		if b.isWebClientLoginFormDisabled() && !b.OIDC.isEnabled() {
+		if b.isWebClientLoginFormDisabled() && !b.OIDC.isEnabled() && !b.SAML.isEnabled() && !b.MagicLink.Enabled {
 			return errors.New("no login method available for WebClient UI")
 		}
 	}
@@ -619,7 +793,8 @@ func (b *Binding) showClientLoginURL() bool {
 }
 
 type defenderStatus struct {
-	IsActive bool `json:"is_active"`
+	IsActive bool                       `json:"is_active"`
+	Rollout  dataprovider.RolloutStatus `json:"rollout"`
 }
 
 // ServicesStatus keep the state of the running services
@@ -627,6 +802,7 @@ type ServicesStatus struct {
 	SSH          sftpd.ServiceStatus         `json:"ssh"`
 	FTP          ftpd.ServiceStatus          `json:"ftp"`
 	WebDAV       webdavd.ServiceStatus       `json:"webdav"`
+	S3           s3d.ServiceStatus           `json:"s3"`
 	DataProvider dataprovider.ProviderStatus `json:"data_provider"`
 	Defender     defenderStatus              `json:"defender"`
 	MFA          mfa.ServiceStatus           `json:"mfa"`
@@ -644,7 +820,8 @@ type SetupConfig struct {
 	InstallationCodeHint string `json:"installation_code_hint" mapstructure:"installation_code_hint"`
 }
 
-// CorsConfig defines the CORS configuration
+// CorsConfig defines the CORS configuration. The base fields apply to all the paths
+// not matching a more specific rule
 type CorsConfig struct {
 	AllowedOrigins       []string `json:"allowed_origins" mapstructure:"allowed_origins"`
 	AllowedMethods       []string `json:"allowed_methods" mapstructure:"allowed_methods"`
@@ -656,6 +833,8 @@ type CorsConfig struct {
 	OptionsPassthrough   bool     `json:"options_passthrough" mapstructure:"options_passthrough"`
 	OptionsSuccessStatus int      `json:"options_success_status" mapstructure:"options_success_status"`
 	AllowPrivateNetwork  bool     `json:"allow_private_network" mapstructure:"allow_private_network"`
+	// Rules allow to define different CORS policies for different URL paths
+	Rules []CorsRule `json:"rules" mapstructure:"rules"`
 }
 
 // Conf httpd daemon configuration
@@ -697,21 +876,41 @@ type Conf struct {
 	// MaxUploadFileSize Defines the maximum request body size, in bytes, for Web Client/API HTTP upload requests.
 	// 0 means no limit
 	MaxUploadFileSize int64 `json:"max_upload_file_size" mapstructure:"max_upload_file_size"`
-	// CORS configuration
+	// CORS configuration. Deprecated: use the per-binding CORS configuration.
+	// It applies to the bindings without an enabled CORS configuration
 	Cors CorsConfig `json:"cors" mapstructure:"cors"`
 	// Initial setup configuration
 	Setup SetupConfig `json:"setup" mapstructure:"setup"`
 	// If enabled, the link to the sponsors section will not appear on the setup screen page
 	HideSupportLink bool `json:"hide_support_link" mapstructure:"hide_support_link"`
+	// Two-person approval configuration for destructive operations
+	Approvals ApprovalConfig `json:"approvals" mapstructure:"approvals"`
+	// MIME types and Content-Disposition policies for downloads
+	Downloads DownloadsConfig `json:"downloads" mapstructure:"downloads"`
+	// tus resumable upload protocol configuration
+	TUS TUSConfig `json:"tus" mapstructure:"tus"`
+	// Memory limits for the uploads
+	Uploads UploadsConfig `json:"uploads" mapstructure:"uploads"`
+	// Localization settings for the web interfaces
+	I18n I18nConfig `json:"i18n" mapstructure:"i18n"`
+	// Signed evidence bundles for compliance audits
+	Compliance ComplianceConfig `json:"compliance" mapstructure:"compliance"`
+	// gRPC admin API configuration
+	GRPC GRPCConfig `json:"grpc" mapstructure:"grpc"`
 }
 
 type apiResponse struct {
 	Error   string `json:"error,omitempty"`
 	Message string `json:"message"`
+	// machine readable error code, it is set for error responses only
+	Code string `json:"code,omitempty"`
 }
 
-// ShouldBind returns true if there is at least a valid binding
+// ShouldBind returns true if there is at least a valid binding or the gRPC API is enabled
 func (c *Conf) ShouldBind() bool {
+	if c.GRPC.isEnabled() {
+		return true
+	}
 	for _, binding := range c.Bindings {
 		if binding.IsValid() {
 			return true
@@ -783,6 +982,13 @@ func (c *Conf) getKeyPairs(configDir string) []common.TLSKeyPair {
 			})
 		}
 	}
+	if c.GRPC.isEnabled() && c.GRPC.hasCertificate() {
+		keyPairs = append(keyPairs, common.TLSKeyPair{
+			Cert: getConfigPath(c.GRPC.CertificateFile, configDir),
+			Key:  getConfigPath(c.GRPC.CertificateKeyFile, configDir),
+			ID:   c.GRPC.GetAddress(),
+		})
+	}
 	certificateFile := getConfigPath(c.CertificateFile, configDir)
 	certificateKeyFile := getConfigPath(c.CertificateKeyFile, configDir)
 	if certificateFile != "" && certificateKeyFile != "" {
@@ -806,14 +1012,57 @@ func (c *Conf) setTokenValidationMode() {
 // Initialize configures and starts the HTTP server
 func (c *Conf) Initialize(configDir string, isShared int) error {
 	logger.Info(logSender, "", "initializing HTTP server with config %+v", c.getRedacted())
+	if err := c.Approvals.validate(); err != nil {
+		return err
+	}
+	if err := c.Downloads.validate(); err != nil {
+		return err
+	}
+	if err := c.TUS.validate(); err != nil {
+		return err
+	}
+	if err := c.Uploads.validate(); err != nil {
+		return err
+	}
+	if err := c.Compliance.validate(); err != nil {
+		return err
+	}
+	hasDefaultCert := getConfigPath(c.CertificateFile, configDir) != "" && getConfigPath(c.CertificateKeyFile, configDir) != ""
+	if err := c.GRPC.validate(hasDefaultCert, c.CACertificates); err != nil {
+		return err
+	}
+	downloadsConf = c.Downloads
+	tusConf = c.TUS
+	uploadsConf = c.Uploads
+	uploadsBudget.setLimit(uploadsConf.getMemoryBudget())
+	if tusConf.Enabled {
+		if isShared == 1 && tusConf.StagingPath == "" {
+			logger.Warn(logSender, "", "the data provider is shared but no tus staging path is configured, "+
+				"the incomplete uploads can be resumed only if the temporary directory is shared among the instances")
+		}
+		mgr, err := newTUSManager(getTUSStagingBaseDir(), isShared == 1)
+		if err != nil {
+			return err
+		}
+		tusMgr = mgr
+	}
 	resetCodesMgr = newResetCodeManager(isShared)
 	oidcMgr = newOIDCManager(isShared)
+	approvalsMgr = newApprovalManager(isShared)
+	webAuthnMgr = newWebAuthnSessionManager(isShared)
+	c.Approvals.initialize()
+	if err := c.Compliance.initialize(configDir); err != nil {
+		return err
+	}
 	staticFilesPath := util.FindSharedDataPath(c.StaticFilesPath, configDir)
 	templatesPath := util.FindSharedDataPath(c.TemplatesPath, configDir)
 	openAPIPath := util.FindSharedDataPath(c.OpenAPIPath, configDir)
 	if err := c.checkRequiredDirs(staticFilesPath, templatesPath); err != nil {
 		return err
 	}
+	if err := c.I18n.initialize(templatesPath, configDir); err != nil {
+		return err
+	}
 	if c.isWebAdminEnabled() {
 		updateWebAdminURLs(c.WebRoot)
 		loadAdminTemplates(templatesPath)
@@ -848,6 +1097,12 @@ func (c *Conf) Initialize(configDir string, isShared int) error {
 
 	exitChannel := make(chan error, 1)
 
+	if c.GRPC.isEnabled() {
+		go func(conf GRPCConfig) {
+			exitChannel <- conf.listenAndServe()
+		}(c.GRPC)
+	}
+
 	for _, binding := range c.Bindings {
 		if !binding.IsValid() {
 			continue
@@ -858,17 +1113,33 @@ func (c *Conf) Initialize(configDir string, isShared int) error {
 		binding.checkWebClientIntegrations()
 		binding.checkBranding()
 		binding.Security.updateProxyHeaders()
+		if err := binding.Security.validate(); err != nil {
+			return fmt.Errorf("invalid security configuration for binding %q: %w", binding.GetAddress(), err)
+		}
+		if err := binding.ClientPolicy.Validate(); err != nil {
+			return fmt.Errorf("invalid client policy for binding %q: %w", binding.GetAddress(), err)
+		}
+		if err := binding.checkCors(c.Cors); err != nil {
+			return err
+		}
+		if err := binding.MagicLink.validate(); err != nil {
+			return fmt.Errorf("invalid magic link configuration for binding %q: %w", binding.GetAddress(), err)
+		}
 
 		go func(b Binding) {
 			if err := b.OIDC.initialize(); err != nil {
 				exitChannel <- err
 				return
 			}
+			if err := b.SAML.initialize(configDir); err != nil {
+				exitChannel <- err
+				return
+			}
 			if err := b.checkLoginMethods(); err != nil {
 				exitChannel <- err
 				return
 			}
-			server := newHttpdServer(b, staticFilesPath, c.SigningPassphrase, c.Cors, openAPIPath)
+			server := newHttpdServer(b, staticFilesPath, c.SigningPassphrase, b.Cors, openAPIPath)
 			server.setShared(isShared)
 
 			exitChannel <- server.listenAndServe()
@@ -914,9 +1185,11 @@ func getServicesStatus() *ServicesStatus {
 		SSH:          sftpd.GetStatus(),
 		FTP:          ftpd.GetStatus(),
 		WebDAV:       webdavd.GetStatus(),
+		S3:           s3d.GetStatus(),
 		DataProvider: dataprovider.GetProviderStatus(),
 		Defender: defenderStatus{
 			IsActive: common.Config.DefenderConfig.Enabled,
+			Rollout:  common.GetDefenderRolloutStatus(),
 		},
 		MFA: mfa.GetStatus(),
 	}
@@ -946,12 +1219,22 @@ func updateWebClientURLs(baseURL string) {
 	webBasePath = path.Join(baseURL, webBasePathDefault)
 	webBaseClientPath = path.Join(baseURL, webBasePathClientDefault)
 	webOIDCRedirectPath = path.Join(baseURL, webOIDCRedirectPathDefault)
+	webSAMLMetadataPath = path.Join(baseURL, webSAMLMetadataPathDefault)
+	webSAMLACSPath = path.Join(baseURL, webSAMLACSPathDefault)
 	webClientLoginPath = path.Join(baseURL, webClientLoginPathDefault)
 	webClientOIDCLoginPath = path.Join(baseURL, webClientOIDCLoginPathDefault)
+	webClientSAMLLoginPath = path.Join(baseURL, webClientSAMLLoginPathDefault)
 	webClientTwoFactorPath = path.Join(baseURL, webClientTwoFactorPathDefault)
 	webClientTwoFactorRecoveryPath = path.Join(baseURL, webClientTwoFactorRecoveryPathDefault)
+	webClientRecoveryCodesEmailPath = path.Join(baseURL, webClientRecoveryCodesEmailPathDefault)
 	webClientFilesPath = path.Join(baseURL, webClientFilesPathDefault)
 	webClientFilePath = path.Join(baseURL, webClientFilePathDefault)
+	webClientFileRestorePath = path.Join(baseURL, webClientFileRestorePathDefault)
+	webClientFileCopyPath = path.Join(baseURL, webClientFileCopyPathDefault)
+	webClientFileVersionsPath = path.Join(baseURL, webClientFileVersionsPathDefault)
+	webClientFileVersionDownload = path.Join(baseURL, webClientFileVersionDownloadDefault)
+	webClientFileVersionRestore = path.Join(baseURL, webClientFileVersionRestoreDefault)
+	webClientTUSPath = path.Join(baseURL, webClientTUSPathDefault)
 	webClientSharesPath = path.Join(baseURL, webClientSharesPathDefault)
 	webClientPubSharesPath = path.Join(baseURL, webClientPubSharesPathDefault)
 	webClientSharePath = path.Join(baseURL, webClientSharePathDefault)
@@ -966,8 +1249,12 @@ func updateWebClientURLs(baseURL string) {
 	webClientTOTPValidatePath = path.Join(baseURL, webClientTOTPValidatePathDefault)
 	webClientTOTPSavePath = path.Join(baseURL, webClientTOTPSavePathDefault)
 	webClientRecoveryCodesPath = path.Join(baseURL, webClientRecoveryCodesPathDefault)
+	webClientWebAuthnPath = path.Join(baseURL, webClientWebAuthnPathDefault)
+	webClientTwoFactorWebAuthnPath = path.Join(baseURL, webClientTwoFactorWebAuthnPathDefault)
 	webClientForgotPwdPath = path.Join(baseURL, webClientForgotPwdPathDefault)
 	webClientResetPwdPath = path.Join(baseURL, webClientResetPwdPathDefault)
+	webClientMagicLinkPath = path.Join(baseURL, webClientMagicLinkPathDefault)
+	webClientMagicLinkLoginPath = path.Join(baseURL, webClientMagicLinkLoginPathDefault)
 	webClientViewPDFPath = path.Join(baseURL, webClientViewPDFPathDefault)
 	webClientGetPDFPath = path.Join(baseURL, webClientGetPDFPathDefault)
 }
@@ -980,9 +1267,12 @@ func updateWebAdminURLs(baseURL string) {
 	webBasePath = path.Join(baseURL, webBasePathDefault)
 	webBaseAdminPath = path.Join(baseURL, webBasePathAdminDefault)
 	webOIDCRedirectPath = path.Join(baseURL, webOIDCRedirectPathDefault)
+	webSAMLMetadataPath = path.Join(baseURL, webSAMLMetadataPathDefault)
+	webSAMLACSPath = path.Join(baseURL, webSAMLACSPathDefault)
 	webAdminSetupPath = path.Join(baseURL, webAdminSetupPathDefault)
 	webAdminLoginPath = path.Join(baseURL, webAdminLoginPathDefault)
 	webAdminOIDCLoginPath = path.Join(baseURL, webAdminOIDCLoginPathDefault)
+	webAdminSAMLLoginPath = path.Join(baseURL, webAdminSAMLLoginPathDefault)
 	webAdminTwoFactorPath = path.Join(baseURL, webAdminTwoFactorPathDefault)
 	webAdminTwoFactorRecoveryPath = path.Join(baseURL, webAdminTwoFactorRecoveryPathDefault)
 	webLogoutPath = path.Join(baseURL, webLogoutPathDefault)
@@ -996,6 +1286,7 @@ func updateWebAdminURLs(baseURL string) {
 	webStatusPath = path.Join(baseURL, webStatusPathDefault)
 	webAdminsPath = path.Join(baseURL, webAdminsPathDefault)
 	webAdminPath = path.Join(baseURL, webAdminPathDefault)
+	webAPIKeysPath = path.Join(baseURL, webAPIKeysPathDefault)
 	webMaintenancePath = path.Join(baseURL, webMaintenancePathDefault)
 	webBackupPath = path.Join(baseURL, webBackupPathDefault)
 	webRestorePath = path.Join(baseURL, webRestorePathDefault)
@@ -1014,10 +1305,15 @@ func updateWebAdminURLs(baseURL string) {
 	webAdminTOTPValidatePath = path.Join(baseURL, webAdminTOTPValidatePathDefault)
 	webAdminTOTPSavePath = path.Join(baseURL, webAdminTOTPSavePathDefault)
 	webAdminRecoveryCodesPath = path.Join(baseURL, webAdminRecoveryCodesPathDefault)
+	webAdminWebAuthnPath = path.Join(baseURL, webAdminWebAuthnPathDefault)
+	webAdminTwoFactorWebAuthnPath = path.Join(baseURL, webAdminTwoFactorWebAuthnPathDefault)
 	webTemplateUser = path.Join(baseURL, webTemplateUserDefault)
 	webTemplateFolder = path.Join(baseURL, webTemplateFolderDefault)
 	webDefenderHostsPath = path.Join(baseURL, webDefenderHostsPathDefault)
 	webDefenderPath = path.Join(baseURL, webDefenderPathDefault)
+	webAdminSMTPTestPath = path.Join(baseURL, webAdminSMTPTestPathDefault)
+	webApprovalsPath = path.Join(baseURL, webApprovalsPathDefault)
+	webApprovalsRequestsPath = path.Join(baseURL, webApprovalsRequestsPathDefault)
 	webStaticFilesPath = path.Join(baseURL, webStaticFilesPathDefault)
 	webOpenAPIPath = path.Join(baseURL, webOpenAPIPathDefault)
 }
@@ -1045,6 +1341,12 @@ func startCleanupTicker(duration time.Duration) {
 				counter++
 				cleanupExpiredJWTTokens()
 				resetCodesMgr.Cleanup()
+				approvalsMgr.Cleanup()
+				webAuthnMgr.Cleanup()
+				complianceMgr.Cleanup()
+				if tusMgr != nil {
+					tusMgr.cleanup()
+				}
 				if counter%2 == 0 {
 					oidcMgr.cleanup()
 				}
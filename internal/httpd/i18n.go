@@ -0,0 +1,94 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package httpd
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+
+	"github.com/go-chi/jwtauth/v5"
+
+	"github.com/drakkan/sftpgo/v2/internal/i18n"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const templateI18nDir = "i18n"
+
+// I18nConfig defines the localization settings for the web interfaces
+type I18nConfig struct {
+	// Language to use if neither the user preference nor the languages
+	// accepted by the browser are available
+	DefaultLanguage string `json:"default_language" mapstructure:"default_language"`
+	// Path to a directory with additional message catalogs, they override the
+	// built-in ones for the same language. This can be an absolute path or a
+	// path relative to the config dir
+	CatalogsPath string `json:"catalogs_path" mapstructure:"catalogs_path"`
+}
+
+func (c *I18nConfig) initialize(templatesPath, configDir string) error {
+	var catalogsPath string
+	if c.CatalogsPath != "" {
+		if !util.IsFileInputValid(c.CatalogsPath) {
+			return fmt.Errorf("invalid i18n catalogs path %q", c.CatalogsPath)
+		}
+		catalogsPath = c.CatalogsPath
+		if !filepath.IsAbs(catalogsPath) {
+			catalogsPath = filepath.Join(configDir, catalogsPath)
+		}
+	}
+	return i18n.Initialize(filepath.Join(templatesPath, templateI18nDir), catalogsPath, c.DefaultLanguage)
+}
+
+// pageLanguage is embedded in the data of the web pages
+type pageLanguage struct {
+	Lang    string
+	LangDir string
+}
+
+func getPageLanguage(r *http.Request) pageLanguage {
+	lang := getRequestLanguage(r)
+	return pageLanguage{
+		Lang:    lang,
+		LangDir: i18n.GetDirection(lang),
+	}
+}
+
+// getRequestLanguage returns the language for the web pages: the preferred
+// language of the logged in user or admin, if available, otherwise the best
+// match for the languages accepted by the browser or the default language
+func getRequestLanguage(r *http.Request) string {
+	var preferred string
+	if _, claims, err := jwtauth.FromContext(r.Context()); err == nil {
+		tokenClaims := jwtTokenClaims{}
+		tokenClaims.Decode(claims)
+		preferred = tokenClaims.Language
+	}
+	return i18n.Negotiate(preferred, r.Header.Get("Accept-Language"))
+}
+
+// getAPIRequestLanguage returns the language for the REST API responses.
+// The API messages are translated only if requested using the Accept-Language
+// header, so existing clients keep receiving the messages in English
+func getAPIRequestLanguage(r *http.Request) string {
+	return i18n.Match(i18n.ParseAcceptLanguage(r.Header.Get("Accept-Language"))...)
+}
+
+func getI18nTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"T":  i18n.T,
+		"Tf": i18n.Tf,
+	}
+}
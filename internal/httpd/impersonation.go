@@ -0,0 +1,139 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/auditlog"
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	// the impersonating admin cannot change the user's credentials and
+	// settings and cannot create shares, they would outlive the session
+	impersonationDeniedWebClientPerms = []string{sdk.WebClientPasswordChangeDisabled, sdk.WebClientMFADisabled,
+		sdk.WebClientPubKeyChangeDisabled, sdk.WebClientAPIKeyAuthChangeDisabled, sdk.WebClientInfoChangeDisabled,
+		sdk.WebClientSharesDisabled}
+	impersonationReadOnlyPerms = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+)
+
+func getImpersonationWebClientPerms(userPerms []string, readOnly bool) []string {
+	perms := make([]string, 0, len(userPerms)+len(impersonationDeniedWebClientPerms)+1)
+	perms = append(perms, userPerms...)
+	perms = append(perms, impersonationDeniedWebClientPerms...)
+	if readOnly {
+		perms = append(perms, sdk.WebClientWriteDisabled)
+	}
+	return util.RemoveDuplicates(perms, false)
+}
+
+// getImpersonatorFromToken returns the admin impersonating the user for the
+// token in the request context, if any
+func getImpersonatorFromToken(r *http.Request) string {
+	claims, err := getTokenClaims(r)
+	if err != nil {
+		return ""
+	}
+	return claims.ImpersonatedBy
+}
+
+// setImpersonation tags the connection with the admin impersonating the user,
+// if any. Read-only impersonated sessions can only list and download files
+func (c *Connection) setImpersonation(claims *jwtTokenClaims) {
+	if claims.ImpersonatedBy == "" {
+		return
+	}
+	c.SetImpersonator(claims.ImpersonatedBy)
+	if !claims.ImpersonationReadOnly {
+		return
+	}
+	permissions := make(map[string][]string)
+	for dir, perms := range c.User.Permissions {
+		allowed := make([]string, 0, len(impersonationReadOnlyPerms))
+		for _, perm := range impersonationReadOnlyPerms {
+			if util.Contains(perms, dataprovider.PermAny) || util.Contains(perms, perm) {
+				allowed = append(allowed, perm)
+			}
+		}
+		permissions[dir] = allowed
+	}
+	c.User.Permissions = permissions
+}
+
+func (s *httpdServer) impersonateUser(w http.ResponseWriter, r *http.Request, username, admin string, readOnly bool) error {
+	user, err := dataprovider.GetUserWithGroupSettings(username)
+	if err != nil {
+		return err
+	}
+	if err := user.CheckLoginConditions(); err != nil {
+		return util.NewValidationError(err.Error())
+	}
+	if util.Contains(user.Filters.DeniedProtocols, common.ProtocolHTTP) {
+		return util.NewValidationError(fmt.Sprintf("protocol HTTP is not allowed for user %q", user.Username))
+	}
+	c := jwtTokenClaims{
+		Username:              user.Username,
+		Permissions:           getImpersonationWebClientPerms(user.Filters.WebClient, readOnly),
+		Signature:             user.GetSignature(),
+		Theme:                 user.Filters.Theme,
+		Language:              user.Filters.Language,
+		ImpersonatedBy:        admin,
+		ImpersonationReadOnly: readOnly,
+	}
+	return c.createAndSetCookie(w, r, s.tokenAuth, tokenAudienceWebClient, util.GetIPFromRemoteAddress(r.RemoteAddr))
+}
+
+func (s *httpdServer) handleWebImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	username := getURLParam(r, "username")
+	readOnly := getBoolQueryParam(r, "read_only")
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+
+	err = s.impersonateUser(w, r, username, claims.Username, readOnly)
+	record := auditlog.Record{
+		Event:    auditlog.EventImpersonation,
+		Username: claims.Username,
+		IP:       ipAddr,
+		Protocol: common.ProtocolHTTP,
+		Details: map[string]string{
+			"user":      username,
+			"read_only": strconv.FormatBool(readOnly),
+		},
+	}
+	if err != nil {
+		record.Error = err.Error()
+		auditlog.Add(record)
+		logger.Warn(logSender, "", "admin %q cannot impersonate user %q: %v", claims.Username, username, err)
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	auditlog.Add(record)
+	logger.Info(logSender, "", "admin %q started a WebClient session impersonating user %q, read only: %t, ip: %q",
+		claims.Username, username, readOnly, ipAddr)
+	sendAPIResponse(w, r, nil, "Impersonation session started", http.StatusOK)
+}
@@ -32,6 +32,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -46,6 +47,7 @@ import (
 	"github.com/sftpgo/sdk"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
@@ -393,6 +395,38 @@ func TestMappedStatusCode(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, code)
 }
 
+func TestGetAPIErrorCode(t *testing.T) {
+	assert.Empty(t, getAPIErrorCode(nil, http.StatusOK))
+	assert.Empty(t, getAPIErrorCode(errors.New("error"), http.StatusOK))
+	assert.Empty(t, getAPIErrorCode(nil, 0))
+	assert.Equal(t, apiErrorCodeValidation, getAPIErrorCode(util.NewValidationError("invalid"), http.StatusBadRequest))
+	assert.Equal(t, apiErrorCodeNotFound, getAPIErrorCode(util.NewRecordNotFoundError("missing"), http.StatusNotFound))
+	assert.Equal(t, apiErrorCodeMethodDisabled, getAPIErrorCode(util.NewMethodDisabledError("disabled"), http.StatusForbidden))
+	assert.Equal(t, apiErrorCodeQuotaExceeded, getAPIErrorCode(fmt.Errorf("upload error: %w", common.ErrQuotaExceeded),
+		http.StatusRequestEntityTooLarge))
+	assert.Equal(t, apiErrorCodeReadQuotaExceeded, getAPIErrorCode(common.ErrReadQuotaExceeded, http.StatusForbidden))
+	assert.Equal(t, apiErrorCodeIPNotAllowed, getAPIErrorCode(dataprovider.ErrLoginNotAllowedFromIP, http.StatusForbidden))
+	assert.Equal(t, apiErrorCodeInvalidCredentials, getAPIErrorCode(dataprovider.ErrInvalidCredentials, http.StatusUnauthorized))
+	assert.Equal(t, apiErrorCodePermissionDenied, getAPIErrorCode(os.ErrPermission, http.StatusForbidden))
+	assert.Equal(t, apiErrorCodePermissionDenied, getAPIErrorCode(common.ErrPermissionDenied, http.StatusForbidden))
+	assert.Equal(t, apiErrorCodeNotFound, getAPIErrorCode(os.ErrNotExist, http.StatusBadRequest))
+	assert.Equal(t, apiErrorCodeOpUnsupported, getAPIErrorCode(common.ErrOpUnsupported, http.StatusBadRequest))
+	assert.Equal(t, apiErrorCodeNotImplemented, getAPIErrorCode(plugin.ErrNoSearcher, http.StatusNotImplemented))
+	assert.Equal(t, apiErrorCodeRequestTooLarge, getAPIErrorCode(&http.MaxBytesError{Limit: 10}, http.StatusBadRequest))
+	assert.Equal(t, apiErrorCodeBadRequest, getAPIErrorCode(errors.New("error"), http.StatusBadRequest))
+	assert.Equal(t, apiErrorCodeBadRequest, getAPIErrorCode(nil, http.StatusRequestedRangeNotSatisfiable))
+	assert.Equal(t, apiErrorCodeUnauthorized, getAPIErrorCode(nil, http.StatusUnauthorized))
+	assert.Equal(t, apiErrorCodeForbidden, getAPIErrorCode(nil, http.StatusForbidden))
+	assert.Equal(t, apiErrorCodeNotFound, getAPIErrorCode(nil, http.StatusNotFound))
+	assert.Equal(t, apiErrorCodeConflict, getAPIErrorCode(nil, http.StatusConflict))
+	assert.Equal(t, apiErrorCodeRequestTooLarge, getAPIErrorCode(nil, http.StatusRequestEntityTooLarge))
+	assert.Equal(t, apiErrorCodeTooManyRequests, getAPIErrorCode(nil, http.StatusTooManyRequests))
+	assert.Equal(t, apiErrorCodeNotImplemented, getAPIErrorCode(nil, http.StatusNotImplemented))
+	assert.Equal(t, apiErrorCodeUnavailable, getAPIErrorCode(nil, http.StatusServiceUnavailable))
+	assert.Equal(t, apiErrorCodeInternal, getAPIErrorCode(errors.New("error"), http.StatusInternalServerError))
+	assert.Equal(t, apiErrorCodeInternal, getAPIErrorCode(nil, http.StatusBadGateway))
+}
+
 func TestGCSWebInvalidFormFile(t *testing.T) {
 	form := make(url.Values)
 	form.Set("username", "test_username")
@@ -2072,7 +2106,7 @@ func TestGetFileWriterErrors(t *testing.T) {
 		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolHTTP, "", "", user),
 		request:        nil,
 	}
-	_, err := connection.getFileWriter("name")
+	_, err := connection.getFileWriter("name", -1)
 	assert.Error(t, err)
 
 	user.FsConfig.Provider = sdk.S3FilesystemProvider
@@ -2088,7 +2122,7 @@ func TestGetFileWriterErrors(t *testing.T) {
 		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolHTTP, "", "", user),
 		request:        nil,
 	}
-	_, err = connection.getFileWriter("/path")
+	_, err = connection.getFileWriter("/path", -1)
 	assert.Error(t, err)
 }
 
@@ -2399,6 +2433,333 @@ func TestResetCodesCleanup(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestAuditorPermissions(t *testing.T) {
+	claims := jwtTokenClaims{
+		Permissions: []string{dataprovider.PermAdminAuditor},
+	}
+	assert.True(t, claims.hasPerm(dataprovider.PermAdminViewUsers))
+	assert.True(t, claims.hasPerm(dataprovider.PermAdminViewConnections))
+	assert.True(t, claims.hasPerm(dataprovider.PermAdminViewEvents))
+	assert.True(t, claims.hasPerm(dataprovider.PermAdminComplianceExport))
+	assert.False(t, claims.hasPerm(dataprovider.PermAdminExportData))
+	assert.False(t, claims.hasPerm(dataprovider.PermAdminAddUsers))
+	assert.False(t, claims.hasPerm(dataprovider.PermAdminCloseConnections))
+	assert.False(t, claims.hasPerm(dataprovider.PermAdminManageSystem))
+	claims.Permissions = []string{dataprovider.PermAdminManageSystem}
+	assert.True(t, claims.hasPerm(dataprovider.PermAdminExportData))
+	assert.True(t, claims.hasPerm(dataprovider.PermAdminComplianceExport))
+	claims.Permissions = []string{dataprovider.PermAdminViewUsers}
+	assert.False(t, claims.hasPerm(dataprovider.PermAdminExportData))
+	assert.False(t, claims.hasPerm(dataprovider.PermAdminComplianceExport))
+
+	admin := dataprovider.Admin{
+		Permissions: []string{dataprovider.PermAdminAuditor},
+	}
+	assert.True(t, admin.HasPermission(dataprovider.PermAdminViewServerStatus))
+	assert.False(t, admin.HasPermission(dataprovider.PermAdminManageAdmins))
+	// saving a backup on the server is not allowed for auditors
+	server := httpdServer{
+		tokenAuth: jwtauth.New(jwa.HS256.String(), util.GenerateRandomBytes(32), nil),
+	}
+	token, _, err := server.tokenAuth.Encode(map[string]any{
+		claimUsernameKey:    "auditor",
+		claimPermissionsKey: []any{dataprovider.PermAdminAuditor},
+	})
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodGet, dumpDataPath+"?output-file=backup.json", nil)
+	req = req.WithContext(jwtauth.NewContext(req.Context(), token, nil))
+	rr := httptest.NewRecorder()
+	dumpData(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestApprovalConfig(t *testing.T) {
+	c := ApprovalConfig{
+		Operations:   []string{ApprovalOpDeleteUser, "unknown"},
+		ValidityTime: 10,
+	}
+	assert.Error(t, c.validate())
+	c.Operations = []string{ApprovalOpDeleteUser}
+	c.ValidityTime = 0
+	assert.Error(t, c.validate())
+	c.ValidityTime = 10
+	assert.NoError(t, c.validate())
+	c.initialize()
+	assert.True(t, isApprovalRequired(ApprovalOpDeleteUser))
+	assert.False(t, isApprovalRequired(ApprovalOpRestore))
+	assert.Equal(t, 10*time.Minute, approvalLifespan)
+
+	c = ApprovalConfig{ValidityTime: 30}
+	c.initialize()
+	assert.False(t, isApprovalRequired(ApprovalOpDeleteUser))
+}
+
+func TestComplianceConfig(t *testing.T) {
+	c := ComplianceConfig{Retention: -1}
+	assert.Error(t, c.validate())
+	c.Retention = 48
+	assert.NoError(t, c.validate())
+	configDir := t.TempDir()
+	c.SigningKey = "missing_key"
+	assert.Error(t, c.initialize(configDir))
+	err := os.WriteFile(filepath.Join(configDir, "invalid_key"), []byte("invalid"), 0600)
+	assert.NoError(t, err)
+	c.SigningKey = "invalid_key"
+	assert.Error(t, c.initialize(configDir))
+	err = util.GenerateRSAKeys(filepath.Join(configDir, "id_rsa"))
+	assert.NoError(t, err)
+	c.SigningKey = "id_rsa"
+	assert.NoError(t, c.initialize(configDir))
+	assert.Equal(t, 48*time.Hour, complianceMgr.retention)
+	signature, err := signComplianceManifest([]byte("manifest"))
+	assert.NoError(t, err)
+	assert.Equal(t, ssh.KeyAlgoRSASHA256, signature.Algorithm)
+	assert.True(t, strings.HasPrefix(signature.PublicKey, ssh.KeyAlgoRSA))
+
+	c = ComplianceConfig{}
+	assert.NoError(t, c.initialize(configDir))
+	assert.Equal(t, 24*time.Hour, complianceMgr.retention)
+	signature, err = signComplianceManifest([]byte("manifest"))
+	assert.NoError(t, err)
+	assert.Equal(t, ssh.KeyAlgoED25519, signature.Algorithm)
+}
+
+func TestComplianceExportsCleanup(t *testing.T) {
+	running := &complianceExport{StartTime: 1, EndTime: 2}
+	assert.True(t, complianceMgr.Add(running))
+	assert.False(t, complianceMgr.Add(&complianceExport{StartTime: 1, EndTime: 2}))
+	err := complianceMgr.Delete(running.ID)
+	assert.Error(t, err)
+	complianceMgr.Cleanup()
+	_, err = complianceMgr.Get(running.ID)
+	assert.NoError(t, err)
+	complianceMgr.setCompleted(running.ID, 0, "", errors.New("generation error"))
+	export, err := complianceMgr.Get(running.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, ComplianceExportFailed, export.Status)
+	assert.Equal(t, "generation error", export.Error)
+
+	complianceMgr.Lock()
+	complianceMgr.exports[running.ID].CompletedAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(-25 * time.Hour))
+	complianceMgr.Unlock()
+	complianceMgr.Cleanup()
+	_, err = complianceMgr.Get(running.ID)
+	assert.Error(t, err)
+}
+
+func TestApprovalsCleanup(t *testing.T) {
+	req := newApprovalRequest(ApprovalOpDeleteFolder, util.GenerateUniqueID(), "admin")
+	req.ExpiresAt = time.Now().Add(-1 * time.Minute).UTC()
+	err := approvalsMgr.Add(req)
+	assert.NoError(t, err)
+	approvalsMgr.Cleanup()
+	_, err = approvalsMgr.Get(req.ID)
+	assert.Error(t, err)
+}
+
+func TestListApprovals(t *testing.T) {
+	mgr := &memoryApprovalManager{}
+	oldMgr := approvalsMgr
+	approvalsMgr = mgr
+	defer func() {
+		approvalsMgr = oldMgr
+	}()
+
+	tokenAuth := jwtauth.New(jwa.HS256.String(), util.GenerateRandomBytes(32), nil)
+	getRequest := func(username string, permissions []string) *http.Request {
+		claims := make(map[string]any)
+		claims[claimUsernameKey] = username
+		var perms []any
+		for _, perm := range permissions {
+			perms = append(perms, perm)
+		}
+		claims[claimPermissionsKey] = perms
+		token, _, err := tokenAuth.Encode(claims)
+		assert.NoError(t, err)
+		req, _ := http.NewRequest(http.MethodGet, approvalsPath, nil)
+		return req.WithContext(jwtauth.NewContext(req.Context(), token, nil))
+	}
+	getIDs := func(rr *httptest.ResponseRecorder) []string {
+		var results []map[string]any
+		err := json.Unmarshal(rr.Body.Bytes(), &results)
+		assert.NoError(t, err)
+		var ids []string
+		for _, res := range results {
+			ids = append(ids, res["id"].(string))
+		}
+		return ids
+	}
+
+	deleteReq := newApprovalRequest(ApprovalOpDeleteUser, "user1", "admin1")
+	deleteReq.ExpiresAt = time.Now().Add(10 * time.Minute).UTC()
+	restoreReq, err := newRestoreApprovalRequest([]byte("{}"), "backup.json", 0, 0, "admin1")
+	assert.NoError(t, err)
+	restoreReq.ExpiresAt = time.Now().Add(5 * time.Minute).UTC()
+	expiredReq := newApprovalRequest(ApprovalOpDeleteFolder, "folder1", "admin1")
+	expiredReq.ExpiresAt = time.Now().Add(-1 * time.Minute).UTC()
+	for _, req := range []*approvalRequest{deleteReq, restoreReq, expiredReq} {
+		err := mgr.Add(req)
+		assert.NoError(t, err)
+	}
+	_, err = mgr.Get(expiredReq.ID)
+	assert.Error(t, err)
+	// the requester can see its own requests
+	rr := httptest.NewRecorder()
+	listApprovals(rr, getRequest("admin1", []string{dataprovider.PermAdminViewUsers}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []string{restoreReq.ID, deleteReq.ID}, getIDs(rr))
+	// the other admins can see the requests they are allowed to confirm
+	rr = httptest.NewRecorder()
+	listApprovals(rr, getRequest("admin2", []string{dataprovider.PermAdminDeleteUsers}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []string{deleteReq.ID}, getIDs(rr))
+	rr = httptest.NewRecorder()
+	listApprovals(rr, getRequest("admin2", []string{dataprovider.PermAdminViewUsers}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, getIDs(rr), 0)
+	rr = httptest.NewRecorder()
+	listApprovals(rr, getRequest("admin2", []string{dataprovider.PermAdminAny}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []string{restoreReq.ID, deleteReq.ID}, getIDs(rr))
+
+	req, _ := http.NewRequest(http.MethodGet, approvalsPath, nil)
+	rr = httptest.NewRecorder()
+	listApprovals(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestRestoreApprovalContent(t *testing.T) {
+	content := []byte(`{"users":[]}`)
+	req, err := newRestoreApprovalRequest(content, "", 1, 0, "admin1")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dataprovider.GetBackupsPath(), "approvals", req.ID), req.UploadPath)
+	assert.Equal(t, int64(len(content)), req.ContentSize)
+	assert.NotEmpty(t, req.ContentHash)
+	// the content is not stored within the request
+	asJSON, err := json.Marshal(req)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(asJSON), `"content"`)
+	data, err := req.getContent()
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+	err = os.WriteFile(req.UploadPath, []byte(`{"users":{}}`), 0600)
+	assert.NoError(t, err)
+	_, err = req.getContent()
+	assert.ErrorContains(t, err, "modified after the approval request")
+	err = os.WriteFile(req.UploadPath, []byte("{}"), 0600)
+	assert.NoError(t, err)
+	err = req.execute("admin2", "127.0.0.1")
+	assert.ErrorContains(t, err, "modified")
+	// the upload is removed after the execution
+	assert.NoFileExists(t, req.UploadPath)
+	_, err = req.getContent()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	// a backup loaded from an input file is not copied
+	inputFile := filepath.Join(os.TempDir(), "approval_backup.json")
+	err = os.WriteFile(inputFile, content, 0600)
+	assert.NoError(t, err)
+	defer os.Remove(inputFile)
+	req, err = newRestoreApprovalRequest(content, inputFile, 0, 0, "admin1")
+	require.NoError(t, err)
+	assert.Empty(t, req.UploadPath)
+	data, err = req.getContent()
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+	req.removeUpload()
+	assert.FileExists(t, inputFile)
+	err = os.WriteFile(inputFile, append(content, '\n'), 0600)
+	assert.NoError(t, err)
+	_, err = req.getContent()
+	assert.ErrorContains(t, err, "modified")
+
+	_, err = newBulkImportApprovalRequest(make([]byte, MaxRestoreSize+1), dataprovider.BulkObjectUsers,
+		dataprovider.BulkFormatCSV, "admin1")
+	assert.ErrorContains(t, err, "too big")
+	req, err = newBulkImportApprovalRequest(content, dataprovider.BulkObjectUsers, dataprovider.BulkFormatCSV, "admin1")
+	require.NoError(t, err)
+	assert.FileExists(t, req.UploadPath)
+	// expired uploads are removed
+	expiredTime := time.Now().Add(-approvalLifespan - time.Minute)
+	err = os.Chtimes(req.UploadPath, expiredTime, expiredTime)
+	assert.NoError(t, err)
+	approvalsMgr.Cleanup()
+	assert.NoFileExists(t, req.UploadPath)
+}
+
+func TestConfirmApproval(t *testing.T) {
+	server := httpdServer{
+		tokenAuth: jwtauth.New(jwa.HS256.String(), util.GenerateRandomBytes(32), nil),
+	}
+	folderName := util.GenerateUniqueID()
+	approval := newApprovalRequest(ApprovalOpDeleteFolder, folderName, "admin1")
+	assert.Equal(t, dataprovider.PermAdminDeleteUsers, approval.getRequiredPermission())
+	assert.Equal(t, dataprovider.PermAdminManageSystem, newApprovalRequest(ApprovalOpRestore, "", "admin1").getRequiredPermission())
+	err := approvalsMgr.Add(approval)
+	assert.NoError(t, err)
+
+	getRequest := func(username string, permissions []string) *http.Request {
+		claims := make(map[string]any)
+		claims[claimUsernameKey] = username
+		var perms []any
+		for _, perm := range permissions {
+			perms = append(perms, perm)
+		}
+		claims[claimPermissionsKey] = perms
+		token, _, err := server.tokenAuth.Encode(claims)
+		assert.NoError(t, err)
+		req, _ := http.NewRequest(http.MethodPost, path.Join(approvalsPath, approval.ID, "approve"), nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", approval.ID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		return req.WithContext(jwtauth.NewContext(req.Context(), token, nil))
+	}
+	// the requester cannot confirm its own request
+	rr := httptest.NewRecorder()
+	confirmApproval(rr, getRequest("admin1", []string{dataprovider.PermAdminAny}))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "different admin")
+	// the required permission is missing
+	rr = httptest.NewRecorder()
+	getApproval(rr, getRequest("admin2", []string{dataprovider.PermAdminViewUsers}))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = httptest.NewRecorder()
+	confirmApproval(rr, getRequest("admin2", []string{dataprovider.PermAdminViewUsers}))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = httptest.NewRecorder()
+	getApproval(rr, getRequest("admin2", []string{dataprovider.PermAdminDeleteUsers}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), folderName)
+	// the folder does not exist
+	rr = httptest.NewRecorder()
+	confirmApproval(rr, getRequest("admin2", []string{dataprovider.PermAdminDeleteUsers}))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	// the request was consumed
+	rr = httptest.NewRecorder()
+	confirmApproval(rr, getRequest("admin2", []string{dataprovider.PermAdminDeleteUsers}))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	approval.ExpiresAt = time.Now().Add(-1 * time.Minute).UTC()
+	err = approvalsMgr.Add(approval)
+	assert.NoError(t, err)
+	rr = httptest.NewRecorder()
+	confirmApproval(rr, getRequest("admin2", []string{dataprovider.PermAdminDeleteUsers}))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	_, err = approvalsMgr.Get(approval.ID)
+	assert.Error(t, err)
+
+	approval = newApprovalRequest(ApprovalOpDeleteUser, util.GenerateUniqueID(), "admin1")
+	err = approvalsMgr.Add(approval)
+	assert.NoError(t, err)
+	rr = httptest.NewRecorder()
+	rejectApproval(rr, getRequest("admin3", []string{dataprovider.PermAdminViewUsers}))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = httptest.NewRecorder()
+	rejectApproval(rr, getRequest("admin1", []string{dataprovider.PermAdminViewUsers}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	_, err = approvalsMgr.Get(approval.ID)
+	assert.Error(t, err)
+}
+
 func TestUserCanResetPassword(t *testing.T) {
 	req, err := http.NewRequest(http.MethodGet, webClientLoginPath, nil)
 	assert.NoError(t, err)
@@ -2580,12 +2941,73 @@ func TestSecureMiddlewareIntegration(t *testing.T) {
 	assert.NotEmpty(t, r.Header.Get(forwardedHostHeader))
 	assert.Equal(t, "max-age=31536000; includeSubDomains; preload", rr.Header().Get("Strict-Transport-Security"))
 	assert.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "frame-ancestors 'self'", rr.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "SAMEORIGIN", rr.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "strict-origin-when-cross-origin", rr.Header().Get("Referrer-Policy"))
 
 	server.binding.Security.Enabled = false
 	server.binding.Security.updateProxyHeaders()
 	assert.Len(t, server.binding.Security.proxyHeaders, 0)
 }
 
+func TestSecurityHeadersConf(t *testing.T) {
+	s := SecurityConf{
+		Enabled:        true,
+		FrameAncestors: []string{"https://portal.example.com; script-src *"},
+	}
+	err := s.validate()
+	assert.ErrorContains(t, err, "invalid frame ancestor")
+	s.FrameAncestors = []string{""}
+	err = s.validate()
+	assert.ErrorContains(t, err, "invalid frame ancestor")
+	s.FrameAncestors = []string{"'self'", "https://portal.example.com"}
+	s.ReferrerPolicy = "invalid"
+	err = s.validate()
+	assert.ErrorContains(t, err, "invalid referrer policy")
+	s.ReferrerPolicy = "no-referrer"
+	s.STSSeconds = -1
+	err = s.validate()
+	assert.ErrorContains(t, err, "invalid STS seconds")
+	s.STSSeconds = 0
+	err = s.validate()
+	assert.NoError(t, err)
+	s.Enabled = false
+	s.ReferrerPolicy = "invalid"
+	err = s.validate()
+	assert.NoError(t, err)
+	s.ReferrerPolicy = "no-referrer"
+
+	assert.Equal(t, "frame-ancestors 'self' https://portal.example.com", s.getContentSecurityPolicy())
+	assert.Empty(t, s.getFrameOptions())
+	assert.Equal(t, "no-referrer", s.getReferrerPolicy())
+	s.ContentSecurityPolicy = "default-src 'self'; "
+	assert.Equal(t, "default-src 'self'; frame-ancestors 'self' https://portal.example.com", s.getContentSecurityPolicy())
+	s.ContentSecurityPolicy = "default-src 'self'; frame-ancestors 'none'"
+	assert.Equal(t, s.ContentSecurityPolicy, s.getContentSecurityPolicy())
+	s.FrameAncestors = []string{"'none'"}
+	assert.Equal(t, "DENY", s.getFrameOptions())
+	s.FrameAncestors = []string{"*"}
+	assert.Empty(t, s.getFrameOptions())
+
+	server := httpdServer{
+		binding: Binding{
+			Security: SecurityConf{
+				Enabled:        true,
+				FrameAncestors: []string{"*"},
+			},
+		},
+		enableWebClient: true,
+	}
+	server.initializeRouter()
+	rr := httptest.NewRecorder()
+	r, err := http.NewRequest(http.MethodGet, webClientLoginPath, nil)
+	assert.NoError(t, err)
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "frame-ancestors *", rr.Header().Get("Content-Security-Policy"))
+	assert.Empty(t, rr.Header().Get("X-Frame-Options"))
+}
+
 func TestGetCompressedFileName(t *testing.T) {
 	username := "test"
 	res := getCompressedFileName(username, []string{"single dir"})
@@ -2796,6 +3218,52 @@ func TestDbResetCodeManager(t *testing.T) {
 	}
 }
 
+func TestDbApprovalManager(t *testing.T) {
+	if !isSharedProviderSupported() {
+		t.Skip("this test it is not available with this provider")
+	}
+	mgr := newApprovalManager(1)
+	req, err := newRestoreApprovalRequest([]byte("{}"), "", 1, 0, "admin")
+	require.NoError(t, err)
+	err = mgr.Add(req)
+	assert.NoError(t, err)
+	reqGet, err := mgr.Get(req.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, req, reqGet)
+	requests, err := mgr.List()
+	assert.NoError(t, err)
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, req, requests[0])
+	}
+	err = mgr.Delete(req.ID)
+	assert.NoError(t, err)
+	req.removeUpload()
+	assert.NoFileExists(t, req.UploadPath)
+	_, err = mgr.Get(req.ID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	// add an expired request
+	req = newApprovalRequest(ApprovalOpDeleteUser, "user", "admin")
+	req.ExpiresAt = time.Now().Add(-24 * time.Hour)
+	err = mgr.Add(req)
+	assert.NoError(t, err)
+	_, err = mgr.Get(req.ID)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "approval request expired")
+	}
+	requests, err = mgr.List()
+	assert.NoError(t, err)
+	assert.Len(t, requests, 0)
+	mgr.Cleanup()
+	_, err = mgr.Get(req.ID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	dbMgr, ok := mgr.(*dbApprovalManager)
+	if assert.True(t, ok) {
+		_, err = dbMgr.decodeData("astring")
+		assert.Error(t, err)
+	}
+}
+
 func isSharedProviderSupported() bool {
 	// SQLite shares the implementation with other SQL-based provider but it makes no sense
 	// to use it outside test cases
@@ -2807,3 +3275,415 @@ func isSharedProviderSupported() bool {
 		return false
 	}
 }
+
+func TestDownloadsConfig(t *testing.T) {
+	c := DownloadsConfig{
+		MimeTypes: []string{"log"},
+	}
+	assert.Error(t, c.validate())
+	c.MimeTypes = []string{"=text/plain"}
+	assert.Error(t, c.validate())
+	c.MimeTypes = []string{".log=text/plain;;"}
+	assert.Error(t, c.validate())
+	c.MimeTypes = []string{"LOG = text/plain; charset=utf-8", ".html=text/html"}
+	c.AttachmentExtensions = []string{" "}
+	assert.Error(t, c.validate())
+	c.AttachmentExtensions = []string{".PDF", "pdf"}
+	c.InlineExtensions = []string{"."}
+	assert.Error(t, c.validate())
+	c.InlineExtensions = nil
+	require.NoError(t, c.validate())
+	assert.Equal(t, []string{".pdf"}, c.AttachmentExtensions)
+
+	mimeType, inline := c.getDownloadPolicy("/dir/file.log", true)
+	assert.Equal(t, "text/plain; charset=utf-8", mimeType)
+	assert.True(t, inline)
+	mimeType, inline = c.getDownloadPolicy("/dir/file.unknownext", true)
+	assert.Equal(t, defaultDownloadMimeType, mimeType)
+	assert.True(t, inline)
+	_, inline = c.getDownloadPolicy("file.Pdf", true)
+	assert.False(t, inline)
+	_, inline = c.getDownloadPolicy("file.html", true)
+	assert.True(t, inline)
+	_, inline = c.getDownloadPolicy("file.log", false)
+	assert.False(t, inline)
+
+	c.SafeMode = true
+	_, inline = c.getDownloadPolicy("file.html", true)
+	assert.False(t, inline)
+	mimeType, inline = c.getDownloadPolicy("image.svg", true)
+	assert.Equal(t, "image/svg+xml", mimeType)
+	assert.False(t, inline)
+	_, inline = c.getDownloadPolicy("file.log", true)
+	assert.True(t, inline)
+
+	c.InlineExtensions = []string{"png"}
+	require.NoError(t, c.validate())
+	_, inline = c.getDownloadPolicy("file.log", true)
+	assert.False(t, inline)
+	_, inline = c.getDownloadPolicy("image.png", true)
+	assert.True(t, inline)
+}
+
+func TestUploadsConfig(t *testing.T) {
+	c := UploadsConfig{}
+	assert.Error(t, c.validate())
+	c.BufferSize = 256
+	c.MemoryBudget = -1
+	assert.Error(t, c.validate())
+	c.BufferSize = 2048
+	c.MemoryBudget = 1
+	assert.Error(t, c.validate())
+	c.BufferSize = 1024
+	assert.NoError(t, c.validate())
+	assert.Equal(t, int64(1048576), c.getBufferSize())
+	assert.Equal(t, int64(1048576), c.getMemoryBudget())
+	c.MemoryBudget = 0
+	assert.NoError(t, c.validate())
+}
+
+func TestUploadsMemoryBudget(t *testing.T) {
+	oldConf := uploadsConf
+	defer func() {
+		uploadsConf = oldConf
+		uploadsBudget.setLimit(oldConf.getMemoryBudget())
+	}()
+
+	uploadsConf = UploadsConfig{
+		BufferSize:   512,
+		MemoryBudget: 1,
+	}
+	uploadsBudget.setLimit(uploadsConf.getMemoryBudget())
+	used := uploadsBudget.getUsed()
+
+	req, err := http.NewRequest(http.MethodPost, userUploadFilePath, nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	buf1, release1, err := getUploadBuffer(rr, req)
+	require.NoError(t, err)
+	assert.Len(t, buf1, 524288)
+	rr = httptest.NewRecorder()
+	buf2, release2, err := getUploadBuffer(rr, req)
+	require.NoError(t, err)
+	assert.Len(t, buf2, 524288)
+	assert.Equal(t, used+1048576, uploadsBudget.getUsed())
+	// the budget is exhausted
+	rr = httptest.NewRecorder()
+	_, _, err = getUploadBuffer(rr, req)
+	assert.ErrorIs(t, err, errUploadsMemoryBudget)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, strconv.Itoa(uploadsRetryAfter), rr.Header().Get("Retry-After"))
+
+	release1()
+	rr = httptest.NewRecorder()
+	_, release3, err := getUploadBuffer(rr, req)
+	assert.NoError(t, err)
+	release3()
+	release2()
+	assert.Equal(t, used, uploadsBudget.getUsed())
+
+	uploadsBudget.release(1048576)
+	assert.Equal(t, int64(0), uploadsBudget.getUsed())
+	uploadsBudget.setLimit(0)
+	assert.True(t, uploadsBudget.reserve(1048576*1024))
+	uploadsBudget.release(1048576 * 1024)
+}
+
+func TestCorsRules(t *testing.T) {
+	c := CorsConfig{
+		Enabled:        true,
+		AllowedOrigins: []string{"https://*.*.example.com"},
+	}
+	err := c.validate()
+	assert.Error(t, err)
+	c.AllowedOrigins = nil
+	c.Rules = []CorsRule{
+		{
+			Paths: []string{"", " "},
+		},
+	}
+	err = c.validate()
+	assert.Error(t, err)
+	c.Rules[0].Paths = []string{"api/v2/user"}
+	err = c.validate()
+	assert.Error(t, err)
+	c.Rules[0].Paths = []string{"/api/v2/user/"}
+	err = c.validate()
+	assert.Error(t, err)
+	c.Rules[0].AllowedOrigins = []string{"*"}
+	c.Rules[0].AllowCredentials = true
+	err = c.validate()
+	assert.Error(t, err)
+	c.Rules[0].AllowedOrigins = []string{"https://*.example.com"}
+	err = c.validate()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/api/v2/user"}, c.Rules[0].Paths)
+	c.Rules = append(c.Rules, CorsRule{
+		Paths:          []string{"/api/v2"},
+		AllowedOrigins: []string{"https://admin.example.net"},
+		AllowedMethods: []string{http.MethodGet, http.MethodDelete},
+	})
+	err = c.validate()
+	assert.NoError(t, err)
+
+	b := Binding{
+		Address:        "",
+		Port:           8080,
+		EnableWebAdmin: true,
+		EnableRESTAPI:  true,
+		Cors: CorsConfig{
+			Enabled: false,
+			Rules: []CorsRule{
+				{
+					Paths: []string{"/api/v2"},
+				},
+			},
+		},
+	}
+	err = b.checkCors(c)
+	assert.NoError(t, err)
+	assert.Len(t, b.Cors.Rules, 2)
+	b.Cors = CorsConfig{
+		Enabled: true,
+		Rules: []CorsRule{
+			{
+				Paths: []string{"/api/v2"},
+			},
+		},
+	}
+	err = b.checkCors(c)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid CORS configuration for binding")
+	}
+	b.Cors = c
+
+	server := newHttpdServer(b, "", "", b.Cors, "")
+	server.initializeRouter()
+	testServer := httptest.NewServer(server.router)
+	defer testServer.Close()
+
+	// the first matching rule allows credentials and any method for the user APIs
+	req, err := http.NewRequest(http.MethodOptions, userFilesPath, nil)
+	assert.NoError(t, err)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	rr := httptest.NewRecorder()
+	testServer.Config.Handler.ServeHTTP(rr, req)
+	assert.Equal(t, "https://app.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"))
+	// the admin origin is not allowed for the user APIs
+	req.Header.Set("Origin", "https://admin.example.net")
+	rr = httptest.NewRecorder()
+	testServer.Config.Handler.ServeHTTP(rr, req)
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+	// the second rule applies to the other API paths
+	req, err = http.NewRequest(http.MethodOptions, userPath, nil)
+	assert.NoError(t, err)
+	req.Header.Set("Origin", "https://admin.example.net")
+	req.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+	rr = httptest.NewRecorder()
+	testServer.Config.Handler.ServeHTTP(rr, req)
+	assert.Equal(t, "https://admin.example.net", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Credentials"))
+	req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	rr = httptest.NewRecorder()
+	testServer.Config.Handler.ServeHTTP(rr, req)
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+	// no rule matches and the base configuration does not allow any origin
+	req, err = http.NewRequest(http.MethodGet, webClientLoginPath, nil)
+	assert.NoError(t, err)
+	req.Header.Set("Origin", "https://app.example.com")
+	rr = httptest.NewRecorder()
+	testServer.Config.Handler.ServeHTTP(rr, req)
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+	// now allow the base configuration
+	b.Cors.AllowedOrigins = []string{"https://*.example.com"}
+	server = newHttpdServer(b, "", "", b.Cors, "")
+	server.initializeRouter()
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	assert.Equal(t, "https://app.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestTUSManager(t *testing.T) {
+	metadata, err := parseTUSMetadata("")
+	assert.NoError(t, err)
+	assert.Len(t, metadata, 0)
+	metadata, err = parseTUSMetadata("filename dGVzdC50eHQ=, empty")
+	assert.NoError(t, err)
+	assert.Equal(t, "test.txt", metadata["filename"])
+	value, ok := metadata["empty"]
+	assert.True(t, ok)
+	assert.Empty(t, value)
+	_, err = parseTUSMetadata(" ,filename dGVzdC50eHQ=")
+	assert.Error(t, err)
+	_, err = parseTUSMetadata("filename invalid")
+	assert.Error(t, err)
+
+	mgr, err := newTUSManager(t.TempDir(), false)
+	require.NoError(t, err)
+	upload := &tusUpload{
+		ID:        "id1",
+		Username:  "user",
+		Path:      "/file.txt",
+		Size:      10,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	err = mgr.add(upload)
+	require.NoError(t, err)
+	assert.FileExists(t, upload.stagingPath)
+	_, err = mgr.get(upload.ID, "user")
+	assert.NoError(t, err)
+	_, err = mgr.get(upload.ID, "otheruser")
+	assert.Error(t, err)
+	err = mgr.add(&tusUpload{ID: upload.ID})
+	assert.Error(t, err)
+	mgr.cleanup()
+	assert.Equal(t, 1, mgr.count())
+	upload.ExpiresAt = time.Now().Add(-time.Second)
+	_, err = mgr.get(upload.ID, "user")
+	assert.Error(t, err)
+	mgr.cleanup()
+	assert.Equal(t, 0, mgr.count())
+	assert.NoFileExists(t, upload.stagingPath)
+
+	c := TUSConfig{
+		Enabled: true,
+	}
+	assert.Error(t, c.validate())
+	c.ExpirationTime = 10
+	assert.NoError(t, c.validate())
+	assert.Equal(t, 10*time.Minute, c.getExpiration())
+}
+
+func TestSharedTUSManager(t *testing.T) {
+	if dataprovider.GetProviderConfig().Driver == dataprovider.BoltDataProviderName {
+		t.Skip("this test is not supported with the bolt provider")
+	}
+	stagingDir := t.TempDir()
+	mgr, err := newTUSManager(stagingDir, true)
+	require.NoError(t, err)
+	upload := &tusUpload{
+		ID:        util.GenerateUniqueID(),
+		Username:  "user",
+		Path:      "/file.txt",
+		Size:      10,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	err = mgr.add(upload)
+	require.NoError(t, err)
+	assert.FileExists(t, upload.stagingPath)
+	// another instance using the same shared staging path
+	otherMgr, err := newTUSManager(stagingDir, true)
+	require.NoError(t, err)
+	assert.FileExists(t, upload.stagingPath)
+	otherUpload, err := otherMgr.get(upload.ID, "user")
+	require.NoError(t, err)
+	assert.Equal(t, upload.Path, otherUpload.Path)
+	assert.Equal(t, upload.stagingPath, otherUpload.stagingPath)
+	_, err = otherMgr.get(upload.ID, "otheruser")
+	assert.Error(t, err)
+	otherUpload.Lock()
+	otherUpload.Offset = 5
+	err = otherMgr.update(otherUpload)
+	otherUpload.Unlock()
+	assert.NoError(t, err)
+	// the committed offset is refreshed from the data provider
+	res, err := mgr.get(upload.ID, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), res.Offset)
+	assert.Same(t, upload, res)
+	// the staging file is not available on an instance using another staging path
+	localMgr, err := newTUSManager(t.TempDir(), true)
+	require.NoError(t, err)
+	_, err = localMgr.get(upload.ID, "user")
+	assert.Error(t, err)
+	// not expired uploads are preserved
+	modTime := time.Now().Add(-tusConf.getExpiration() - time.Hour)
+	err = os.Chtimes(upload.stagingPath, modTime, modTime)
+	assert.NoError(t, err)
+	otherMgr.cleanup()
+	assert.FileExists(t, upload.stagingPath)
+	assert.Equal(t, 1, otherMgr.count())
+	otherUpload.ExpiresAt = time.Now().Add(-time.Second)
+	err = otherMgr.update(otherUpload)
+	assert.NoError(t, err)
+	_, err = mgr.get(upload.ID, "user")
+	assert.Error(t, err)
+	// the expired upload is removed by any instance
+	otherMgr.cleanup()
+	assert.Equal(t, 0, otherMgr.count())
+	assert.NoFileExists(t, upload.stagingPath)
+	_, err = dataprovider.GetSharedSession(mgr.getSessionKey(upload.ID))
+	assert.Error(t, err)
+	mgr.cleanup()
+	assert.Equal(t, 0, mgr.count())
+
+	upload.ID = util.GenerateUniqueID()
+	upload.ExpiresAt = time.Now().Add(time.Hour)
+	err = mgr.add(upload)
+	require.NoError(t, err)
+	_, err = otherMgr.get(upload.ID, "user")
+	assert.NoError(t, err)
+	otherMgr.remove(upload.ID)
+	assert.NoFileExists(t, upload.stagingPath)
+	_, err = mgr.get(upload.ID, "user")
+	assert.Error(t, err)
+	mgr.remove(upload.ID)
+
+	c := TUSConfig{
+		Enabled:        true,
+		ExpirationTime: 10,
+		StagingPath:    "relative",
+	}
+	assert.Error(t, c.validate())
+	c.StagingPath = stagingDir
+	assert.NoError(t, c.validate())
+}
+
+func TestImpersonation(t *testing.T) {
+	perms := getImpersonationWebClientPerms([]string{sdk.WebClientSharesDisabled}, false)
+	assert.Contains(t, perms, sdk.WebClientPasswordChangeDisabled)
+	assert.Contains(t, perms, sdk.WebClientMFADisabled)
+	assert.NotContains(t, perms, sdk.WebClientWriteDisabled)
+	assert.Len(t, perms, len(impersonationDeniedWebClientPerms))
+	perms = getImpersonationWebClientPerms(nil, true)
+	assert.Contains(t, perms, sdk.WebClientWriteDisabled)
+
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "impersonated_user",
+			HomeDir:  filepath.Clean(os.TempDir()),
+			Permissions: map[string][]string{
+				"/":     {dataprovider.PermAny},
+				"/sub1": {dataprovider.PermListItems, dataprovider.PermUpload},
+				"/sub2": {dataprovider.PermUpload},
+			},
+		},
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolHTTP, "", "", user),
+	}
+	connection.setImpersonation(&jwtTokenClaims{})
+	assert.Empty(t, connection.GetImpersonator())
+	assert.Equal(t, user.Permissions, connection.User.Permissions)
+	connection.setImpersonation(&jwtTokenClaims{ImpersonatedBy: "admin", ImpersonationReadOnly: true})
+	assert.Equal(t, "admin", connection.GetImpersonator())
+	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermDownload}, connection.User.Permissions["/"])
+	assert.Equal(t, []string{dataprovider.PermListItems}, connection.User.Permissions["/sub1"])
+	assert.Len(t, connection.User.Permissions["/sub2"], 0)
+	// the permissions of the original user are not modified
+	assert.Equal(t, []string{dataprovider.PermAny}, user.Permissions["/"])
+	err := connection.CreateDir("/adir", true)
+	assert.ErrorIs(t, err, os.ErrPermission)
+
+	claims := jwtTokenClaims{
+		Username:              "user",
+		ImpersonatedBy:        "admin",
+		ImpersonationReadOnly: true,
+	}
+	decoded := jwtTokenClaims{}
+	decoded.Decode(claims.asMap())
+	assert.Equal(t, "admin", decoded.ImpersonatedBy)
+	assert.True(t, decoded.ImpersonationReadOnly)
+}
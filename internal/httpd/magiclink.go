@@ -0,0 +1,327 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/xid"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/smtp"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	defaultMagicLinkLifespan = 15
+	maxMagicLinkLifespan     = 1440
+)
+
+var errInvalidMagicLink = errors.New("the login link is invalid or expired, please request a new one")
+
+// MagicLink defines the configuration for the password-less WebClient login.
+// Users enter their username and receive, via email, a one-time link to login.
+// The SMTP configuration is required
+type MagicLink struct {
+	// Set to true to allow WebClient users to login using a link sent via email
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Base URL used to generate the login links, for example "https://sftpgo.example.com".
+	// The links are never generated from the request host to prevent host header injection
+	BaseURL string `json:"base_url" mapstructure:"base_url"`
+	// Validity of the login links, in minutes
+	Lifespan int `json:"lifespan" mapstructure:"lifespan"`
+}
+
+func (m *MagicLink) validate() error {
+	if !m.Enabled {
+		return nil
+	}
+	m.BaseURL = strings.TrimSuffix(strings.TrimSpace(m.BaseURL), "/")
+	u, err := url.Parse(m.BaseURL)
+	if err != nil {
+		return fmt.Errorf("invalid base URL %q: %w", m.BaseURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid base URL %q, an absolute http or https URL is required", m.BaseURL)
+	}
+	if m.Lifespan == 0 {
+		m.Lifespan = defaultMagicLinkLifespan
+	}
+	if m.Lifespan < 1 || m.Lifespan > maxMagicLinkLifespan {
+		return fmt.Errorf("invalid lifespan %d, it must be between 1 and %d minutes", m.Lifespan, maxMagicLinkLifespan)
+	}
+	return nil
+}
+
+func (m *MagicLink) isEnabled() bool {
+	return m.Enabled && smtp.IsEnabled()
+}
+
+func (m *MagicLink) getLifespan() time.Duration {
+	if m.Lifespan <= 0 {
+		return defaultMagicLinkLifespan * time.Minute
+	}
+	return time.Duration(m.Lifespan) * time.Minute
+}
+
+func (m *MagicLink) getLoginURL(code string) string {
+	return fmt.Sprintf("%s%s?code=%s", m.BaseURL, webClientMagicLinkLoginPath, url.QueryEscape(code))
+}
+
+type magicLinkPage struct {
+	pageLanguage
+	CurrentURL string
+	Version    string
+	Error      string
+	Info       string
+	CSRFToken  string
+	StaticURL  string
+	Code       string
+	Branding   UIBranding
+}
+
+func isUserAllowedToUseMagicLink(r *http.Request, user *dataprovider.User) bool {
+	if user.Email == "" {
+		return false
+	}
+	if util.Contains(user.Filters.DeniedProtocols, common.ProtocolHTTP) {
+		return false
+	}
+	if !user.IsLoginMethodAllowed(dataprovider.LoginMethodPassword, common.ProtocolHTTP, nil) {
+		return false
+	}
+	return user.IsLoginFromAddrAllowed(r.RemoteAddr) && user.IsClientAllowed(r.UserAgent())
+}
+
+func (s *httpdServer) sendMagicLink(r *http.Request, username string) error {
+	user, err := dataprovider.GetUserWithGroupSettings(username)
+	if err != nil {
+		if _, ok := err.(*util.RecordNotFoundError); ok {
+			logger.Debug(logSender, middleware.GetReqID(r.Context()), "username %q does not exist, magic link request silently ignored",
+				username)
+			return nil
+		}
+		return util.NewGenericError("Error retrieving your account, please try again later")
+	}
+	if err := user.CheckLoginConditions(); err != nil || !isUserAllowedToUseMagicLink(r, &user) {
+		// we don't reveal if the user exists
+		logger.Debug(logSender, middleware.GetReqID(r.Context()), "user %q is not allowed to login using a magic link, request silently ignored",
+			username)
+		return nil
+	}
+	c := newMagicLinkCode(user.Username, s.binding.MagicLink.getLifespan())
+	data := make(map[string]any)
+	data["Username"] = user.Username
+	data["LoginURL"] = s.binding.MagicLink.getLoginURL(c.Code)
+	data["Lifespan"] = int(s.binding.MagicLink.getLifespan().Minutes())
+	body, err := smtp.RenderMagicLinkBody(user.Filters.Language, data)
+	if err != nil {
+		logger.Warn(logSender, middleware.GetReqID(r.Context()), "unable to render magic link template: %v", err)
+		return util.NewGenericError("Unable to render magic link template")
+	}
+	startTime := time.Now()
+	subject := fmt.Sprintf("Login link for user %q", user.Username)
+	if err := smtp.SendEmailBody(smtp.EmailAddresses{To: []string{user.Email}}, subject, body); err != nil {
+		logger.Warn(logSender, middleware.GetReqID(r.Context()), "unable to send magic link via email: %v, elapsed: %v",
+			err, time.Since(startTime))
+		return util.NewGenericError("Unable to send the login link via email, please try again later")
+	}
+	logger.Debug(logSender, middleware.GetReqID(r.Context()), "magic link sent via email to %q, email: %q, elapsed: %v",
+		user.Username, user.Email, time.Since(startTime))
+	return resetCodesMgr.Add(c)
+}
+
+// getUserFromMagicLink validates and consumes the specified magic link code
+// and returns the associated user
+func getUserFromMagicLink(r *http.Request, code string) (dataprovider.User, error) {
+	if code == "" {
+		return dataprovider.User{}, errInvalidMagicLink
+	}
+	c, err := resetCodesMgr.Get(code)
+	if err != nil {
+		return dataprovider.User{}, errInvalidMagicLink
+	}
+	if !c.IsMagicLink || c.IsAdmin {
+		return dataprovider.User{}, errInvalidMagicLink
+	}
+	// the link can be used only once
+	if err := resetCodesMgr.Delete(code); err != nil {
+		logger.Warn(logSender, middleware.GetReqID(r.Context()), "unable to delete magic link code: %v", err)
+		return dataprovider.User{}, util.NewGenericError("Unable to validate the login link, please try again later")
+	}
+	if c.isExpired() {
+		return dataprovider.User{BaseUser: sdk.BaseUser{Username: c.Username}}, errInvalidMagicLink
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(c.Username)
+	if err != nil {
+		return dataprovider.User{BaseUser: sdk.BaseUser{Username: c.Username}}, errInvalidMagicLink
+	}
+	if err := user.CheckLoginConditions(); err != nil {
+		return user, err
+	}
+	if !isUserAllowedToUseMagicLink(r, &user) {
+		return user, errInvalidMagicLink
+	}
+	return user, nil
+}
+
+func (s *httpdServer) renderClientMagicLinkPage(w http.ResponseWriter, r *http.Request, error, info, ip string) {
+	data := magicLinkPage{
+		pageLanguage: getPageLanguage(r),
+		CurrentURL:   webClientMagicLinkPath,
+		Version:      version.Get().Version,
+		Error:        error,
+		Info:         info,
+		CSRFToken:    createCSRFToken(ip),
+		StaticURL:    webStaticFilesPath,
+		Branding:     s.binding.Branding.WebClient,
+	}
+	renderClientTemplate(w, templateClientMagicLink, data)
+}
+
+func (s *httpdServer) renderClientMagicLinkLoginPage(w http.ResponseWriter, r *http.Request, code, ip string) {
+	data := magicLinkPage{
+		pageLanguage: getPageLanguage(r),
+		CurrentURL:   webClientMagicLinkLoginPath,
+		Version:      version.Get().Version,
+		CSRFToken:    createCSRFToken(ip),
+		StaticURL:    webStaticFilesPath,
+		Code:         code,
+		Branding:     s.binding.Branding.WebClient,
+	}
+	renderClientTemplate(w, templateClientMagicLink, data)
+}
+
+func (s *httpdServer) handleWebClientMagicLink(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if !s.binding.MagicLink.isEnabled() {
+		s.renderClientNotFoundPage(w, r, errors.New("this page does not exist"))
+		return
+	}
+	s.renderClientMagicLinkPage(w, r, "", "", util.GetIPFromRemoteAddress(r.RemoteAddr))
+}
+
+func (s *httpdServer) handleWebClientMagicLinkPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if !s.binding.MagicLink.isEnabled() {
+		s.renderClientNotFoundPage(w, r, errors.New("this page does not exist"))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.renderClientMagicLinkPage(w, r, err.Error(), "", ipAddr)
+		return
+	}
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
+		s.renderClientForbiddenPage(w, r, err.Error())
+		return
+	}
+	username := strings.TrimSpace(r.Form.Get("username"))
+	if username == "" {
+		s.renderClientMagicLinkPage(w, r, "username is mandatory", "", ipAddr)
+		return
+	}
+	if err := common.ThrottleByLatency(common.ProtocolHTTP, ipAddr); err != nil {
+		s.renderClientMagicLinkPage(w, r, err.Error(), "", ipAddr)
+		return
+	}
+	if err := s.sendMagicLink(r, username); err != nil {
+		s.renderClientMagicLinkPage(w, r, err.Error(), "", ipAddr)
+		return
+	}
+	s.renderClientMagicLinkPage(w, r, "", fmt.Sprintf("If your account allows it, a login link has been sent to your "+
+		"email address. The link is valid for %d minutes", int(s.binding.MagicLink.getLifespan().Minutes())), ipAddr)
+}
+
+// handleWebClientMagicLinkLogin renders a confirmation page, the link is consumed
+// only after an explicit POST so that email link scanners cannot invalidate it
+func (s *httpdServer) handleWebClientMagicLinkLogin(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if !s.binding.MagicLink.isEnabled() {
+		s.renderClientNotFoundPage(w, r, errors.New("this page does not exist"))
+		return
+	}
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		s.renderClientMagicLinkPage(w, r, errInvalidMagicLink.Error(), "", ipAddr)
+		return
+	}
+	s.renderClientMagicLinkLoginPage(w, r, code, ipAddr)
+}
+
+func (s *httpdServer) handleWebClientMagicLinkLoginPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if !s.binding.MagicLink.isEnabled() {
+		s.renderClientNotFoundPage(w, r, errors.New("this page does not exist"))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.renderClientLoginPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
+		s.renderClientForbiddenPage(w, r, err.Error())
+		return
+	}
+	protocol := common.ProtocolHTTP
+	if err := common.ThrottleByLatency(protocol, ipAddr); err != nil {
+		s.renderClientLoginPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	if err := common.Config.ExecutePostConnectHook(ipAddr, protocol); err != nil {
+		s.renderClientLoginPage(w, r, fmt.Sprintf("access denied by post connect hook: %v", err), ipAddr)
+		return
+	}
+	user, err := getUserFromMagicLink(r, r.Form.Get("code"))
+	if err != nil {
+		updateLoginMetrics(&user, dataprovider.LoginMethodMagicLink, ipAddr, dataprovider.ErrInvalidCredentials)
+		if e, ok := err.(*util.GenericError); ok {
+			s.renderClientLoginPage(w, r, e.Error(), ipAddr)
+			return
+		}
+		s.renderClientLoginPage(w, r, errInvalidMagicLink.Error(), ipAddr)
+		return
+	}
+	connectionID := fmt.Sprintf("%v_%v", protocol, xid.New().String())
+	if err := checkHTTPClientUser(&user, r, connectionID, true); err != nil {
+		updateLoginMetrics(&user, dataprovider.LoginMethodMagicLink, ipAddr, err)
+		s.renderClientLoginPage(w, r, err.Error(), ipAddr)
+		return
+	}
+
+	defer user.CloseFs() //nolint:errcheck
+	err = user.CheckFsRoot(connectionID)
+	if err != nil {
+		logger.Warn(logSender, connectionID, "unable to check fs root: %v", err)
+		updateLoginMetrics(&user, dataprovider.LoginMethodMagicLink, ipAddr, common.ErrInternalFailure)
+		s.renderClientLoginPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	s.loginUser(w, r, &user, connectionID, ipAddr, false, s.renderClientLoginPage)
+}
@@ -269,6 +269,15 @@ func (s *httpdServer) checkPerm(perm string) func(next http.Handler) http.Handle
 			tokenClaims := jwtTokenClaims{}
 			tokenClaims.Decode(claims)
 
+			if tokenClaims.MustSetTwoFactorAuth {
+				message := "Two-factor authentication requirements not met, please configure a second factor for your account"
+				if isWebRequest(r) {
+					s.renderForbiddenPage(w, r, message)
+				} else {
+					sendAPIResponse(w, r, nil, message, http.StatusForbidden)
+				}
+				return
+			}
 			if !tokenClaims.hasPerm(perm) {
 				if isWebRequest(r) {
 					s.renderForbiddenPage(w, r, "You don't have permission for this action")
@@ -375,6 +384,12 @@ func checkAPIKeyAuth(tokenAuth *jwtauth.JWTAuth, scope dataprovider.APIKeyScope)
 				sendAPIResponse(w, r, fmt.Errorf("the provided api key cannot be authenticated"), "", http.StatusUnauthorized)
 				return
 			}
+			if !isAPIKeyAllowed(&k, r) {
+				logger.Debug(logSender, "", "api key %q is not allowed to access %s %q", keyID, r.Method, r.URL.Path)
+				sendAPIResponse(w, r, errors.New("the provided api key does not have the required scope"), "",
+					http.StatusForbidden)
+				return
+			}
 			if scope == dataprovider.APIKeyScopeAdmin {
 				if k.Admin != "" {
 					apiUser = k.Admin
@@ -205,6 +205,8 @@ type oidcToken struct {
 	Username             string          `json:"username"`
 	Permissions          []string        `json:"permissions"`
 	HideUserPageSections int             `json:"hide_user_page_sections,omitempty"`
+	Theme                string          `json:"theme,omitempty"`
+	Language             string          `json:"language,omitempty"`
 	Role                 any             `json:"role"`
 	CustomFields         *map[string]any `json:"custom_fields,omitempty"`
 	Cookie               string          `json:"cookie"`
@@ -390,6 +392,8 @@ func (t *oidcToken) refreshUser(r *http.Request) error {
 		}
 		t.Permissions = admin.Permissions
 		t.HideUserPageSections = admin.Filters.Preferences.HideUserPageSections
+		t.Theme = admin.Filters.Preferences.Theme
+		t.Language = admin.Filters.Language
 		return nil
 	}
 	user, err := dataprovider.GetUserWithGroupSettings(t.Username)
@@ -403,6 +407,8 @@ func (t *oidcToken) refreshUser(r *http.Request) error {
 		return err
 	}
 	t.Permissions = user.Filters.WebClient
+	t.Theme = user.Filters.Theme
+	t.Language = user.Filters.Language
 	return nil
 }
 
@@ -417,6 +423,8 @@ func (t *oidcToken) getUser(r *http.Request) error {
 		}
 		t.Permissions = admin.Permissions
 		t.HideUserPageSections = admin.Filters.Preferences.HideUserPageSections
+		t.Theme = admin.Filters.Preferences.Theme
+		t.Language = admin.Filters.Language
 		dataprovider.UpdateAdminLastLogin(&admin)
 		return nil
 	}
@@ -448,6 +456,8 @@ func (t *oidcToken) getUser(r *http.Request) error {
 	updateLoginMetrics(&user, dataprovider.LoginMethodIDP, ipAddr, nil)
 	dataprovider.UpdateLastLogin(&user)
 	t.Permissions = user.Filters.WebClient
+	t.Theme = user.Filters.Theme
+	t.Language = user.Filters.Language
 	return nil
 }
 
@@ -516,6 +526,8 @@ func (s *httpdServer) oidcTokenAuthenticator(audience tokenAudience) func(next h
 				Username:             token.Username,
 				Permissions:          token.Permissions,
 				HideUserPageSections: token.HideUserPageSections,
+				Theme:                token.Theme,
+				Language:             token.Language,
 			}
 			_, tokenString, err := jwtTokenClaims.createToken(s.tokenAuth, audience, util.GetIPFromRemoteAddress(r.RemoteAddr))
 			if err != nil {
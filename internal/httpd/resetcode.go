@@ -50,6 +50,9 @@ type resetCode struct {
 	Username  string    `json:"username"`
 	IsAdmin   bool      `json:"is_admin"`
 	ExpiresAt time.Time `json:"expires_at"`
+	// magic link codes are one-time login codes, they cannot be used to
+	// reset the password
+	IsMagicLink bool `json:"is_magic_link,omitempty"`
 }
 
 func newResetCode(username string, isAdmin bool) *resetCode {
@@ -61,6 +64,15 @@ func newResetCode(username string, isAdmin bool) *resetCode {
 	}
 }
 
+func newMagicLinkCode(username string, lifespan time.Duration) *resetCode {
+	return &resetCode{
+		Code:        util.GenerateUniqueID(),
+		Username:    username,
+		ExpiresAt:   time.Now().Add(lifespan).UTC(),
+		IsMagicLink: true,
+	}
+}
+
 func (c *resetCode) isExpired() bool {
 	return c.ExpiresAt.Before(time.Now().UTC())
 }
@@ -0,0 +1,637 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/crewjam/saml"
+	"github.com/rs/xid"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	maxSAMLMetadataSize = 10 * 1048576
+)
+
+// the JWT cookie is strict so it is not sent if the login is completed using a
+// redirect after the cross site POST from the identity provider
+var samlLoginCompletedTemplate = template.Must(template.New("saml").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="refresh" content="0;url={{.}}">
+</head>
+<body>
+<a href="{{.}}">Continue</a>
+</body>
+</html>`))
+
+// SAMLGroupMapping maps a value of the SAML group attribute to an SFTPGo group
+type SAMLGroupMapping struct {
+	// Value of the SAML group attribute
+	Value string `json:"value" mapstructure:"value"`
+	// Name of the SFTPGo group
+	Group string `json:"group" mapstructure:"group"`
+	// Group type: 1 primary, 2 secondary, 3 membership only
+	Type int `json:"type" mapstructure:"type"`
+}
+
+// SAML defines the configuration for the SAML 2.0 single sign-on, SFTPGo acts
+// as service provider
+type SAML struct {
+	// URL of the identity provider metadata. SFTPGo will try to retrieve the metadata
+	// on startup and then will refuse to start if it fails to connect to the specified URL
+	IDPMetadataURL string `json:"idp_metadata_url" mapstructure:"idp_metadata_url"`
+	// Path to the identity provider metadata, alternative to IDPMetadataURL
+	IDPMetadataFile string `json:"idp_metadata_file" mapstructure:"idp_metadata_file"`
+	// BaseURL is the external URL of SFTPGo, for example "https://sftpgo.example.com".
+	// The service provider metadata is available at "/web/saml/metadata" and the
+	// assertion consumer service at "/web/saml/acs", the "web_root", if any, is
+	// added to these paths
+	BaseURL string `json:"base_url" mapstructure:"base_url"`
+	// Service provider entity ID. If empty the metadata URL is used
+	EntityID string `json:"entity_id" mapstructure:"entity_id"`
+	// Optional certificate and matching RSA private key. If set the authentication requests
+	// are signed and encrypted assertions are supported
+	CertificateFile    string `json:"certificate_file" mapstructure:"certificate_file"`
+	CertificateKeyFile string `json:"certificate_key_file" mapstructure:"certificate_key_file"`
+	// Assertion attribute to map to the SFTPGo username. If empty the NameID is used
+	UsernameAttribute string `json:"username_attribute" mapstructure:"username_attribute"`
+	// Optional assertion attribute to map to a SFTPGo role.
+	// If the attribute contains the value "admin" the authenticated user
+	// is mapped to an SFTPGo admin
+	RoleAttribute string `json:"role_attribute" mapstructure:"role_attribute"`
+	// If set, the `RoleAttribute` is ignored and the SFTPGo role is assumed based on
+	// the login link used
+	ImplicitRoles bool `json:"implicit_roles" mapstructure:"implicit_roles"`
+	// Assertion attribute containing the groups of the authenticated user
+	GroupAttribute string `json:"group_attribute" mapstructure:"group_attribute"`
+	// Mappings between the values of the group attribute and the SFTPGo groups.
+	// The groups of the WebClient users are updated after each login
+	GroupMappings []SAMLGroupMapping `json:"group_mappings" mapstructure:"group_mappings"`
+	// Assertion attributes to pass to the pre-login hook
+	CustomAttributes []string `json:"custom_attributes" mapstructure:"custom_attributes"`
+	// Set to true to automatically create the WebClient users that do not exist
+	AutoProvisioning bool `json:"auto_provisioning" mapstructure:"auto_provisioning"`
+	// Home directory for the automatically created users. "%username%" is replaced
+	// with the username. If empty the "users_base_dir" data provider setting is used
+	HomeDirTemplate string `json:"home_dir_template" mapstructure:"home_dir_template"`
+	// Permissions granted on the root directory to the automatically created users
+	Permissions []string `json:"permissions" mapstructure:"permissions"`
+	// Debug enables the SAML debug mode. In debug mode, the received assertion attributes
+	// will be logged at the debug level
+	Debug bool `json:"debug" mapstructure:"debug"`
+	sp    *saml.ServiceProvider
+}
+
+func (s *SAML) isEnabled() bool {
+	return s.sp != nil
+}
+
+func (s *SAML) hasRoles() bool {
+	return s.isEnabled() && (s.RoleAttribute != "" || s.ImplicitRoles)
+}
+
+func (s *SAML) getForcedRole(audience tokenAudience) string {
+	if !s.ImplicitRoles {
+		return ""
+	}
+	if audience == tokenAudienceWebAdmin {
+		return adminRoleFieldValue
+	}
+	return ""
+}
+
+func (s *SAML) initialize(configDir string) error {
+	if s.IDPMetadataURL == "" && s.IDPMetadataFile == "" {
+		return nil
+	}
+	if s.BaseURL == "" {
+		return errors.New("saml: base URL cannot be empty")
+	}
+	s.BaseURL = strings.TrimSuffix(s.BaseURL, "/")
+	metadataURL, err := url.Parse(s.BaseURL + webSAMLMetadataPath)
+	if err != nil {
+		return fmt.Errorf("saml: invalid base URL %q: %w", s.BaseURL, err)
+	}
+	if (metadataURL.Scheme != "http" && metadataURL.Scheme != "https") || metadataURL.Host == "" {
+		return fmt.Errorf("saml: invalid base URL %q, an absolute http or https URL is required", s.BaseURL)
+	}
+	acsURL, err := url.Parse(s.BaseURL + webSAMLACSPath)
+	if err != nil {
+		return fmt.Errorf("saml: invalid base URL %q: %w", s.BaseURL, err)
+	}
+	for _, m := range s.GroupMappings {
+		if m.Value == "" || m.Group == "" {
+			return errors.New("saml: group mappings require a value and a group")
+		}
+		if m.Type < sdk.GroupTypePrimary || m.Type > sdk.GroupTypeMembership {
+			return fmt.Errorf("saml: invalid type %d for the mapped group %q", m.Type, m.Group)
+		}
+	}
+	if len(s.GroupMappings) > 0 && s.GroupAttribute == "" {
+		return errors.New("saml: the group attribute is required to map the groups")
+	}
+	if len(s.Permissions) == 0 {
+		s.Permissions = []string{dataprovider.PermAny}
+	}
+	idpMetadata, err := s.getIDPMetadata(configDir)
+	if err != nil {
+		return fmt.Errorf("saml: unable to load the identity provider metadata: %w", err)
+	}
+	sp := &saml.ServiceProvider{
+		EntityID:          s.EntityID,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       idpMetadata,
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+		HTTPClient:        httpclient.GetHTTPClient(),
+	}
+	if s.CertificateFile != "" || s.CertificateKeyFile != "" {
+		key, cert, err := loadSAMLKeyPair(getConfigPath(s.CertificateFile, configDir),
+			getConfigPath(s.CertificateKeyFile, configDir))
+		if err != nil {
+			return fmt.Errorf("saml: %w", err)
+		}
+		sp.Key = key
+		sp.Certificate = cert
+		sp.SignatureMethod = dsig.RSASHA256SignatureMethod
+	}
+	if sp.GetSSOBindingLocation(saml.HTTPRedirectBinding) == "" && sp.GetSSOBindingLocation(saml.HTTPPostBinding) == "" {
+		return errors.New("saml: the identity provider metadata does not define a supported single sign-on service")
+	}
+	s.sp = sp
+	logger.Debug(logSender, "", "saml initialized, entity ID %q, identity provider %q", sp.Metadata().EntityID,
+		idpMetadata.EntityID)
+	return nil
+}
+
+func (s *SAML) getIDPMetadata(configDir string) (*saml.EntityDescriptor, error) {
+	var data []byte
+	var err error
+
+	if s.IDPMetadataURL != "" {
+		data, err = fetchSAMLMetadata(s.IDPMetadataURL)
+	} else {
+		data, err = os.ReadFile(getConfigPath(s.IDPMetadataFile, configDir))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseSAMLMetadata(data)
+}
+
+func fetchSAMLMetadata(metadataURL string) ([]byte, error) {
+	resp, err := httpclient.RetryableGet(metadataURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from %q", resp.StatusCode, metadataURL)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxSAMLMetadataSize))
+}
+
+// parseSAMLMetadata parses an entity descriptor or the first identity provider
+// within an entities descriptor
+func parseSAMLMetadata(data []byte) (*saml.EntityDescriptor, error) {
+	entity := &saml.EntityDescriptor{}
+	if err := xml.Unmarshal(data, entity); err == nil {
+		return entity, nil
+	}
+	entities := &saml.EntitiesDescriptor{}
+	if err := xml.Unmarshal(data, entities); err != nil {
+		return nil, err
+	}
+	for idx := range entities.EntityDescriptors {
+		if len(entities.EntityDescriptors[idx].IDPSSODescriptors) > 0 {
+			return &entities.EntityDescriptors[idx], nil
+		}
+	}
+	return nil, errors.New("no identity provider found")
+}
+
+func loadSAMLKeyPair(certificateFile, keyFile string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	keyPair, err := tls.LoadX509KeyPair(certificateFile, keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load the key pair: %w", err)
+	}
+	key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("only RSA private keys are supported")
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse the certificate: %w", err)
+	}
+	return key, cert, nil
+}
+
+func (s *SAML) getHomeDir(username string) string {
+	if s.HomeDirTemplate == "" {
+		return ""
+	}
+	return strings.ReplaceAll(s.HomeDirTemplate, "%username%", username)
+}
+
+func (s *SAML) getGroups(values []string) []sdk.GroupMapping {
+	var groups []sdk.GroupMapping
+	var hasPrimaryGroup bool
+
+	for _, m := range s.GroupMappings {
+		if !util.Contains(values, m.Value) {
+			continue
+		}
+		groupType := m.Type
+		if groupType == sdk.GroupTypePrimary {
+			if hasPrimaryGroup {
+				// only one primary group is allowed
+				groupType = sdk.GroupTypeSecondary
+			}
+			hasPrimaryGroup = true
+		}
+		groups = append(groups, sdk.GroupMapping{
+			Name: m.Group,
+			Type: groupType,
+		})
+	}
+	return groups
+}
+
+func isSameSAMLGroups(groups1, groups2 []sdk.GroupMapping) bool {
+	if len(groups1) != len(groups2) {
+		return false
+	}
+	for idx := range groups1 {
+		if groups1[idx].Name != groups2[idx].Name || groups1[idx].Type != groups2[idx].Type {
+			return false
+		}
+	}
+	return true
+}
+
+// samlAssertion contains the user information extracted from a validated assertion
+type samlAssertion struct {
+	Username     string
+	Roles        []string
+	Groups       []string
+	CustomFields *map[string]any
+}
+
+func (a *samlAssertion) isAdmin() bool {
+	return util.Contains(a.Roles, adminRoleFieldValue)
+}
+
+// getSAMLAttributeValues returns the values of the attribute with the specified name
+// or friendly name
+func getSAMLAttributeValues(assertion *saml.Assertion, name string) []string {
+	var values []string
+
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			if attr.Name != name && attr.FriendlyName != name {
+				continue
+			}
+			for _, v := range attr.Values {
+				if v.Value != "" {
+					values = append(values, v.Value)
+				}
+			}
+		}
+	}
+	return values
+}
+
+func (s *SAML) parseAssertion(assertion *saml.Assertion, audience tokenAudience) (samlAssertion, error) {
+	var result samlAssertion
+
+	if s.Debug {
+		for _, statement := range assertion.AttributeStatements {
+			for _, attr := range statement.Attributes {
+				values := make([]string, 0, len(attr.Values))
+				for _, v := range attr.Values {
+					values = append(values, v.Value)
+				}
+				logger.Debug(logSender, "", "saml attribute %q, friendly name %q, values %+v", attr.Name,
+					attr.FriendlyName, values)
+			}
+		}
+	}
+	if s.UsernameAttribute != "" {
+		if values := getSAMLAttributeValues(assertion, s.UsernameAttribute); len(values) > 0 {
+			result.Username = values[0]
+		}
+	} else if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		result.Username = assertion.Subject.NameID.Value
+	}
+	if result.Username == "" {
+		logger.Warn(logSender, "", "saml username attribute %q not found", s.UsernameAttribute)
+		return result, errors.New("no username attribute")
+	}
+	if forcedRole := s.getForcedRole(audience); forcedRole != "" {
+		result.Roles = []string{forcedRole}
+	} else if s.RoleAttribute != "" {
+		result.Roles = getSAMLAttributeValues(assertion, s.RoleAttribute)
+	}
+	if s.GroupAttribute != "" {
+		result.Groups = getSAMLAttributeValues(assertion, s.GroupAttribute)
+	}
+	for _, field := range s.CustomAttributes {
+		values := getSAMLAttributeValues(assertion, field)
+		if len(values) == 0 {
+			logger.Info(logSender, "", "custom attribute %q not found in saml assertion", field)
+			continue
+		}
+		if result.CustomFields == nil {
+			customFields := make(map[string]any)
+			result.CustomFields = &customFields
+		}
+		if len(values) == 1 {
+			(*result.CustomFields)[field] = values[0]
+		} else {
+			(*result.CustomFields)[field] = values
+		}
+	}
+	return result, nil
+}
+
+// provisionUser creates the user, if it does not exist and auto provisioning
+// is enabled, or updates its groups, if group mappings are defined
+func (s *SAML) provisionUser(username string, groupValues []string, ipAddr string) error {
+	if !s.AutoProvisioning {
+		return nil
+	}
+	var groups []sdk.GroupMapping
+	if len(s.GroupMappings) > 0 {
+		groups = s.getGroups(groupValues)
+	}
+	user, err := dataprovider.UserExists(username)
+	if err != nil {
+		if _, ok := err.(*util.RecordNotFoundError); !ok {
+			return err
+		}
+		user = dataprovider.User{
+			BaseUser: sdk.BaseUser{
+				Username: username,
+				Status:   1,
+				HomeDir:  s.getHomeDir(username),
+				Permissions: map[string][]string{
+					"/": s.Permissions,
+				},
+			},
+			Groups: groups,
+		}
+		logger.Info(logSender, "", "saml: adding user %q, groups: %+v", username, groups)
+		return dataprovider.AddUser(&user, dataprovider.ActionExecutorSystem, ipAddr)
+	}
+	if len(s.GroupMappings) == 0 || isSameSAMLGroups(groups, user.Groups) {
+		return nil
+	}
+	logger.Info(logSender, "", "saml: updating groups for user %q, groups: %+v", username, groups)
+	user.Groups = groups
+	return dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSystem, ipAddr)
+}
+
+func (s *httpdServer) handleWebAdminSAMLLogin(w http.ResponseWriter, r *http.Request) {
+	s.samlLoginRedirect(w, r, tokenAudienceWebAdmin)
+}
+
+func (s *httpdServer) handleWebClientSAMLLogin(w http.ResponseWriter, r *http.Request) {
+	s.samlLoginRedirect(w, r, tokenAudienceWebClient)
+}
+
+func (s *httpdServer) samlLoginRedirect(w http.ResponseWriter, r *http.Request, audience tokenAudience) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	sp := s.binding.SAML.sp
+	binding := saml.HTTPRedirectBinding
+	idpURL := sp.GetSSOBindingLocation(binding)
+	if idpURL == "" {
+		binding = saml.HTTPPostBinding
+		idpURL = sp.GetSSOBindingLocation(binding)
+	}
+	req, err := sp.MakeAuthenticationRequest(idpURL, binding, saml.HTTPPostBinding)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to create saml authentication request: %v", err)
+		s.renderSAMLLoginError(w, r, audience, "Unable to create the SAML authentication request")
+		return
+	}
+	// the relay state is the key to retrieve the pending authentication
+	pendingAuth := newOIDCPendingAuth(audience)
+	pendingAuth.Nonce = req.ID
+	oidcMgr.addPendingAuth(pendingAuth)
+
+	if binding == saml.HTTPPostBinding {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(req.Post(pendingAuth.State)) //nolint:errcheck
+		return
+	}
+	redirectURL, err := req.Redirect(pendingAuth.State, sp)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to create saml redirect URL: %v", err)
+		s.renderSAMLLoginError(w, r, audience, "Unable to create the SAML authentication request")
+		return
+	}
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+func (s *httpdServer) renderSAMLLoginError(w http.ResponseWriter, r *http.Request, audience tokenAudience, message string) {
+	setFlashMessage(w, r, message)
+	if audience == tokenAudienceWebAdmin {
+		http.Redirect(w, r, webAdminLoginPath, http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, webClientLoginPath, http.StatusFound)
+}
+
+func (s *httpdServer) handleSAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	data, err := xml.MarshalIndent(s.binding.SAML.sp.Metadata(), "", "  ")
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(data) //nolint:errcheck
+}
+
+func (s *httpdServer) handleSAMLACS(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+
+	if err := r.ParseForm(); err != nil {
+		s.renderClientMessagePage(w, r, "Invalid authentication response", "Unable to parse the SAML response",
+			http.StatusBadRequest, nil, "")
+		return
+	}
+	state := r.PostForm.Get("RelayState")
+	authReq, err := oidcMgr.getPendingAuth(state)
+	if err != nil {
+		logger.Debug(logSender, "", "saml relay state did not match")
+		s.renderClientMessagePage(w, r, "Invalid authentication response", "Authentication state did not match",
+			http.StatusBadRequest, nil, "")
+		return
+	}
+	oidcMgr.removePendingAuth(state)
+
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	assertion, err := s.binding.SAML.sp.ParseResponse(r, []string{authReq.Nonce})
+	if err != nil {
+		if e, ok := err.(*saml.InvalidResponseError); ok {
+			err = e.PrivateErr
+		}
+		logger.Debug(logSender, "", "unable to validate saml response: %v", err)
+		s.renderSAMLLoginError(w, r, authReq.Audience, "Unable to validate the SAML response")
+		return
+	}
+	result, err := s.binding.SAML.parseAssertion(assertion, authReq.Audience)
+	if err != nil {
+		s.renderSAMLLoginError(w, r, authReq.Audience, fmt.Sprintf("Unable to parse the SAML assertion: %v", err))
+		return
+	}
+	switch authReq.Audience {
+	case tokenAudienceWebAdmin:
+		if !result.isAdmin() {
+			logger.Debug(logSender, "", "wrong saml role, the mapped user is not an SFTPGo admin")
+			s.renderSAMLLoginError(w, r, authReq.Audience, "Wrong SAML role, the logged in user is not an SFTPGo admin")
+			return
+		}
+		s.loginSAMLAdmin(w, r, &result, ipAddr)
+	default:
+		if result.isAdmin() {
+			logger.Debug(logSender, "", "wrong saml role, the mapped user is an SFTPGo admin")
+			s.renderSAMLLoginError(w, r, authReq.Audience, "Wrong SAML role, the logged in user is an SFTPGo admin")
+			return
+		}
+		s.loginSAMLUser(w, r, &result, ipAddr)
+	}
+}
+
+func (s *httpdServer) loginSAMLAdmin(w http.ResponseWriter, r *http.Request, result *samlAssertion, ipAddr string) {
+	admin, err := dataprovider.AdminExists(result.Username)
+	if err == nil {
+		err = admin.CanLogin(ipAddr)
+	}
+	if err != nil {
+		logger.Debug(logSender, "", "unable to get the sftpgo admin associated with the saml assertion: %v", err)
+		s.renderSAMLLoginError(w, r, tokenAudienceWebAdmin, "Unable to get the admin associated with the SAML assertion")
+		return
+	}
+	c := jwtTokenClaims{
+		Username:             admin.Username,
+		Permissions:          admin.Permissions,
+		Signature:            admin.GetSignature(),
+		HideUserPageSections: admin.Filters.Preferences.HideUserPageSections,
+		Theme:                admin.Filters.Preferences.Theme,
+		Language:             admin.Filters.Language,
+	}
+	if err := c.createAndSetCookie(w, r, s.tokenAuth, tokenAudienceWebAdmin, ipAddr); err != nil {
+		logger.Warn(logSender, "", "unable to set admin login cookie %v", err)
+		s.renderSAMLLoginError(w, r, tokenAudienceWebAdmin, "Unable to create cookie")
+		return
+	}
+	dataprovider.UpdateAdminLastLogin(&admin)
+	renderSAMLLoginCompleted(w, webUsersPath)
+}
+
+func (s *httpdServer) loginSAMLUser(w http.ResponseWriter, r *http.Request, result *samlAssertion, ipAddr string) {
+	var user dataprovider.User
+	var err error
+
+	user.Username = result.Username
+	loginError := func(err error) {
+		logger.Debug(logSender, "", "unable to get the sftpgo user associated with the saml assertion: %v", err)
+		s.renderSAMLLoginError(w, r, tokenAudienceWebClient, "Unable to get the user associated with the SAML assertion")
+	}
+
+	if err = s.binding.SAML.provisionUser(result.Username, result.Groups, ipAddr); err != nil {
+		updateProtocolLoginMetrics(&user, dataprovider.LoginMethodIDP, common.ProtocolSAML, ipAddr, common.ErrInternalFailure)
+		loginError(err)
+		return
+	}
+	user, err = dataprovider.GetUserAfterIDPAuth(result.Username, ipAddr, common.ProtocolSAML, result.CustomFields)
+	if err != nil {
+		updateProtocolLoginMetrics(&user, dataprovider.LoginMethodIDP, common.ProtocolSAML, ipAddr, err)
+		loginError(err)
+		return
+	}
+	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolSAML); err != nil {
+		updateProtocolLoginMetrics(&user, dataprovider.LoginMethodIDP, common.ProtocolSAML, ipAddr, err)
+		loginError(fmt.Errorf("access denied by post connect hook: %w", err))
+		return
+	}
+	if err := user.CheckLoginConditions(); err != nil {
+		updateProtocolLoginMetrics(&user, dataprovider.LoginMethodIDP, common.ProtocolSAML, ipAddr, err)
+		loginError(err)
+		return
+	}
+	connectionID := fmt.Sprintf("%v_%v", common.ProtocolSAML, xid.New().String())
+	if err := checkHTTPClientUser(&user, r, connectionID, true); err != nil {
+		updateProtocolLoginMetrics(&user, dataprovider.LoginMethodIDP, common.ProtocolSAML, ipAddr, err)
+		loginError(err)
+		return
+	}
+	defer user.CloseFs() //nolint:errcheck
+	if err := user.CheckFsRoot(connectionID); err != nil {
+		logger.Warn(logSender, connectionID, "unable to check fs root: %v", err)
+		updateProtocolLoginMetrics(&user, dataprovider.LoginMethodIDP, common.ProtocolSAML, ipAddr, common.ErrInternalFailure)
+		loginError(err)
+		return
+	}
+	c := jwtTokenClaims{
+		Username:    user.Username,
+		Permissions: user.Filters.WebClient,
+		Signature:   user.GetSignature(),
+		Theme:       user.Filters.Theme,
+		Language:    user.Filters.Language,
+	}
+	if err := c.createAndSetCookie(w, r, s.tokenAuth, tokenAudienceWebClient, ipAddr); err != nil {
+		logger.Warn(logSender, connectionID, "unable to set user login cookie %v", err)
+		updateProtocolLoginMetrics(&user, dataprovider.LoginMethodIDP, common.ProtocolSAML, ipAddr, common.ErrInternalFailure)
+		s.renderSAMLLoginError(w, r, tokenAudienceWebClient, "Unable to create cookie")
+		return
+	}
+	updateProtocolLoginMetrics(&user, dataprovider.LoginMethodIDP, common.ProtocolSAML, ipAddr, nil)
+	dataprovider.UpdateLastLogin(&user)
+	renderSAMLLoginCompleted(w, webClientFilesPath)
+}
+
+func renderSAMLLoginCompleted(w http.ResponseWriter, location string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, max-age=0, must-revalidate")
+	if err := samlLoginCompletedTemplate.Execute(w, location); err != nil {
+		logger.Warn(logSender, "", "unable to render saml login page: %v", err)
+	}
+}
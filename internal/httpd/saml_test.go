@@ -0,0 +1,522 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"encoding/xml"
+	"html"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	samllogger "github.com/crewjam/saml/logger"
+	"github.com/rs/xid"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+)
+
+var (
+	samlFormValueRegex = regexp.MustCompile(`name="(SAMLResponse|RelayState)" value="([^"]*)"`)
+)
+
+type mockSAMLSessionProvider struct {
+	session *saml.Session
+}
+
+func (p *mockSAMLSessionProvider) GetSession(w http.ResponseWriter, r *http.Request, req *saml.IdpAuthnRequest) *saml.Session {
+	return p.session
+}
+
+type mockSAMLServiceProviderProvider struct {
+	metadata *saml.EntityDescriptor
+}
+
+func (p *mockSAMLServiceProviderProvider) GetServiceProvider(r *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
+	if p.metadata == nil || p.metadata.EntityID != serviceProviderID {
+		return nil, os.ErrNotExist
+	}
+	return p.metadata, nil
+}
+
+func generateSAMLKeyPair(t *testing.T) (*rsa.PrivateKey, *x509.Certificate, []byte, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "SFTPGo SAML test",
+		},
+		NotBefore: time.Now().Add(-1 * time.Hour),
+		NotAfter:  time.Now().Add(24 * time.Hour),
+		KeyUsage:  x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return key, cert, certPEM, keyPEM
+}
+
+func getTestSAMLIDP(t *testing.T, sessionProvider saml.SessionProvider) *saml.IdentityProvider {
+	key, cert, _, _ := generateSAMLKeyPair(t)
+	metadataURL, err := url.Parse("http://idp.example.com/saml/metadata")
+	require.NoError(t, err)
+	ssoURL, err := url.Parse("http://idp.example.com/saml/sso")
+	require.NoError(t, err)
+	return &saml.IdentityProvider{
+		Key:                     key,
+		Certificate:             cert,
+		Logger:                  samllogger.DefaultLogger,
+		MetadataURL:             *metadataURL,
+		SSOURL:                  *ssoURL,
+		SessionProvider:         sessionProvider,
+		ServiceProviderProvider: &mockSAMLServiceProviderProvider{},
+	}
+}
+
+func writeSAMLIDPMetadata(t *testing.T, idp *saml.IdentityProvider) string {
+	data, err := xml.Marshal(idp.Metadata())
+	require.NoError(t, err)
+	metadataFile := filepath.Join(os.TempDir(), "saml_idp_metadata.xml")
+	err = os.WriteFile(metadataFile, data, 0600)
+	require.NoError(t, err)
+	return metadataFile
+}
+
+func getTestSAMLServer(metadataFile string) *httpdServer {
+	return &httpdServer{
+		binding: Binding{
+			SAML: SAML{
+				IDPMetadataFile:   metadataFile,
+				BaseURL:           "http://127.0.0.1:8081/",
+				UsernameAttribute: "uid",
+				RoleAttribute:     "sftpgo_role",
+				Debug:             true,
+			},
+		},
+		enableWebAdmin:  true,
+		enableWebClient: true,
+	}
+}
+
+func getTestSAMLSession(username, role string, groups []string) *saml.Session {
+	session := &saml.Session{
+		ID:         xid.New().String(),
+		CreateTime: time.Now(),
+		ExpireTime: time.Now().Add(5 * time.Minute),
+		Index:      xid.New().String(),
+		NameID:     username,
+		UserName:   username,
+		Groups:     groups,
+	}
+	if role != "" {
+		session.CustomAttributes = []saml.Attribute{
+			{
+				Name:       "sftpgo_role",
+				NameFormat: "urn:oasis:names:tc:SAML:2.0:attrname-format:basic",
+				Values: []saml.AttributeValue{
+					{
+						Type:  "xs:string",
+						Value: role,
+					},
+				},
+			},
+		}
+	}
+	return session
+}
+
+// samlLogin starts the SAML login using the specified path, sends the authentication
+// request to the identity provider and posts the generated response to the ACS endpoint
+func samlLogin(t *testing.T, server *httpdServer, idp *saml.IdentityProvider, loginPath string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	r, err := http.NewRequest(http.MethodGet, loginPath, nil)
+	require.NoError(t, err)
+	server.router.ServeHTTP(rr, r)
+	require.Equal(t, http.StatusFound, rr.Code)
+	location := rr.Header().Get("Location")
+	require.True(t, strings.HasPrefix(location, idp.SSOURL.String()), location)
+
+	rr = httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodGet, location, nil)
+	require.NoError(t, err)
+	idp.ServeSSO(rr, r)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	form := make(url.Values)
+	for _, match := range samlFormValueRegex.FindAllStringSubmatch(rr.Body.String(), -1) {
+		form.Set(match[1], html.UnescapeString(match[2]))
+	}
+	require.NotEmpty(t, form.Get("SAMLResponse"))
+	require.NotEmpty(t, form.Get("RelayState"))
+
+	rr = httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodPost, webSAMLACSPath, bytes.NewBuffer([]byte(form.Encode())))
+	require.NoError(t, err)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "127.0.1.1:4567"
+	server.router.ServeHTTP(rr, r)
+	return rr
+}
+
+func TestSAMLInitialization(t *testing.T) {
+	config := SAML{}
+	err := config.initialize(configDir)
+	assert.NoError(t, err)
+	assert.False(t, config.isEnabled())
+	config.IDPMetadataFile = "missing.xml"
+	err = config.initialize(configDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "base URL cannot be empty")
+	}
+	config.BaseURL = "relative/path"
+	err = config.initialize(configDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "an absolute http or https URL is required")
+	}
+	config.BaseURL = "http://127.0.0.1:8081"
+	config.GroupMappings = []SAMLGroupMapping{
+		{
+			Value: "value",
+			Type:  sdk.GroupTypePrimary,
+		},
+	}
+	err = config.initialize(configDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "group mappings require a value and a group")
+	}
+	config.GroupMappings[0].Group = "group"
+	config.GroupMappings[0].Type = 0
+	err = config.initialize(configDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid type 0")
+	}
+	config.GroupMappings[0].Type = sdk.GroupTypeSecondary
+	err = config.initialize(configDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "the group attribute is required")
+	}
+	config.GroupAttribute = "groups"
+	err = config.initialize(configDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to load the identity provider metadata")
+	}
+	assert.Equal(t, []string{dataprovider.PermAny}, config.Permissions)
+
+	invalidMetadataFile := filepath.Join(os.TempDir(), "invalid_saml_metadata.xml")
+	err = os.WriteFile(invalidMetadataFile, []byte("invalid metadata"), 0600)
+	assert.NoError(t, err)
+	config.IDPMetadataFile = invalidMetadataFile
+	err = config.initialize(configDir)
+	assert.Error(t, err)
+	err = os.Remove(invalidMetadataFile)
+	assert.NoError(t, err)
+
+	idp := getTestSAMLIDP(t, &mockSAMLSessionProvider{})
+	config.IDPMetadataFile = writeSAMLIDPMetadata(t, idp)
+	config.CertificateFile = "missing.crt"
+	config.CertificateKeyFile = "missing.key"
+	err = config.initialize(configDir)
+	assert.Error(t, err)
+
+	_, _, certPEM, keyPEM := generateSAMLKeyPair(t)
+	certFile := filepath.Join(os.TempDir(), "saml_sp.crt")
+	keyFile := filepath.Join(os.TempDir(), "saml_sp.key")
+	err = os.WriteFile(certFile, certPEM, 0600)
+	assert.NoError(t, err)
+	err = os.WriteFile(keyFile, keyPEM, 0600)
+	assert.NoError(t, err)
+	config.CertificateFile = certFile
+	config.CertificateKeyFile = keyFile
+	err = config.initialize(configDir)
+	assert.NoError(t, err)
+	assert.True(t, config.isEnabled())
+	assert.False(t, config.hasRoles())
+	assert.NotNil(t, config.sp.Key)
+	assert.Equal(t, "http://127.0.0.1:8081"+webSAMLMetadataPath, config.sp.Metadata().EntityID)
+
+	config.EntityID = "sftpgo"
+	config.ImplicitRoles = true
+	err = config.initialize(configDir)
+	assert.NoError(t, err)
+	assert.True(t, config.hasRoles())
+	assert.Equal(t, "sftpgo", config.sp.Metadata().EntityID)
+	assert.Equal(t, adminRoleFieldValue, config.getForcedRole(tokenAudienceWebAdmin))
+	assert.Empty(t, config.getForcedRole(tokenAudienceWebClient))
+
+	err = os.Remove(certFile)
+	assert.NoError(t, err)
+	err = os.Remove(keyFile)
+	assert.NoError(t, err)
+	err = os.Remove(config.IDPMetadataFile)
+	assert.NoError(t, err)
+}
+
+func TestSAMLLoginLogout(t *testing.T) {
+	oidcMgr, ok := oidcMgr.(*memoryOIDCManager)
+	require.True(t, ok)
+	sessionProvider := &mockSAMLSessionProvider{}
+	idp := getTestSAMLIDP(t, sessionProvider)
+	metadataFile := writeSAMLIDPMetadata(t, idp)
+	defer os.Remove(metadataFile)
+
+	server := getTestSAMLServer(metadataFile)
+	err := server.binding.SAML.initialize(configDir)
+	require.NoError(t, err)
+	server.initializeRouter()
+	idp.ServiceProviderProvider = &mockSAMLServiceProviderProvider{
+		metadata: server.binding.SAML.sp.Metadata(),
+	}
+
+	rr := httptest.NewRecorder()
+	r, err := http.NewRequest(http.MethodGet, webSAMLMetadataPath, nil)
+	assert.NoError(t, err)
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/samlmetadata+xml", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), "http://127.0.0.1:8081"+webSAMLACSPath)
+
+	rr = httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodGet, webClientLoginPath, nil)
+	assert.NoError(t, err)
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), webClientSAMLLoginPath)
+
+	rr = httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodPost, webSAMLACSPath, nil)
+	assert.NoError(t, err)
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Authentication state did not match")
+
+	authReq := newOIDCPendingAuth(tokenAudienceWebClient)
+	oidcMgr.addPendingAuth(authReq)
+	form := make(url.Values)
+	form.Set("RelayState", authReq.State)
+	form.Set("SAMLResponse", "invalid")
+	rr = httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodPost, webSAMLACSPath, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webClientLoginPath, rr.Header().Get("Location"))
+	require.Len(t, oidcMgr.pendingAuths, 0)
+	// the user does not exist and auto provisioning is disabled
+	sessionProvider.session = getTestSAMLSession("saml_user", "", nil)
+	rr = samlLogin(t, server, idp, webClientSAMLLoginPath)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webClientLoginPath, rr.Header().Get("Location"))
+	require.Len(t, oidcMgr.pendingAuths, 0)
+	// the role attribute is not set, the webadmin login is not allowed
+	sessionProvider.session = getTestSAMLSession(defaultAdminUsername, "", nil)
+	rr = samlLogin(t, server, idp, webAdminSAMLLoginPath)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webAdminLoginPath, rr.Header().Get("Location"))
+	// an admin cannot login to the WebClient
+	sessionProvider.session = getTestSAMLSession(defaultAdminUsername, adminRoleFieldValue, nil)
+	rr = samlLogin(t, server, idp, webClientSAMLLoginPath)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webClientLoginPath, rr.Header().Get("Location"))
+
+	rr = samlLogin(t, server, idp, webAdminSAMLLoginPath)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), webUsersPath)
+	assert.Contains(t, rr.Header().Get("Set-Cookie"), jwtCookieKey)
+
+	sessionProvider.session = getTestSAMLSession("missing_admin", adminRoleFieldValue, nil)
+	rr = samlLogin(t, server, idp, webAdminSAMLLoginPath)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webAdminLoginPath, rr.Header().Get("Location"))
+	require.Len(t, oidcMgr.pendingAuths, 0)
+}
+
+func TestSAMLAutoProvisioning(t *testing.T) {
+	sessionProvider := &mockSAMLSessionProvider{}
+	idp := getTestSAMLIDP(t, sessionProvider)
+	metadataFile := writeSAMLIDPMetadata(t, idp)
+	defer os.Remove(metadataFile)
+
+	group1 := dataprovider.Group{
+		BaseGroup: sdk.BaseGroup{
+			Name: "saml_group1",
+		},
+	}
+	group2 := dataprovider.Group{
+		BaseGroup: sdk.BaseGroup{
+			Name: "saml_group2",
+		},
+	}
+	err := dataprovider.AddGroup(&group1, "", "")
+	require.NoError(t, err)
+	err = dataprovider.AddGroup(&group2, "", "")
+	require.NoError(t, err)
+
+	server := getTestSAMLServer(metadataFile)
+	server.binding.SAML.AutoProvisioning = true
+	server.binding.SAML.GroupAttribute = "eduPersonAffiliation"
+	server.binding.SAML.HomeDirTemplate = filepath.Join(os.TempDir(), "saml", "%username%")
+	server.binding.SAML.GroupMappings = []SAMLGroupMapping{
+		{
+			Value: "staff",
+			Group: group1.Name,
+			Type:  sdk.GroupTypePrimary,
+		},
+		{
+			Value: "members",
+			Group: group2.Name,
+			Type:  sdk.GroupTypeSecondary,
+		},
+	}
+	err = server.binding.SAML.initialize(configDir)
+	require.NoError(t, err)
+	server.initializeRouter()
+	idp.ServiceProviderProvider = &mockSAMLServiceProviderProvider{
+		metadata: server.binding.SAML.sp.Metadata(),
+	}
+
+	username := "saml_provisioned_user"
+	sessionProvider.session = getTestSAMLSession(username, "", []string{"staff", "unmapped"})
+	rr := samlLogin(t, server, idp, webClientSAMLLoginPath)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), webClientFilesPath)
+	assert.Contains(t, rr.Header().Get("Set-Cookie"), jwtCookieKey)
+
+	user, err := dataprovider.UserExists(username)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(os.TempDir(), "saml", username), user.HomeDir)
+	assert.Equal(t, []string{dataprovider.PermAny}, user.Permissions["/"])
+	if assert.Len(t, user.Groups, 1) {
+		assert.Equal(t, group1.Name, user.Groups[0].Name)
+		assert.Equal(t, sdk.GroupTypePrimary, user.Groups[0].Type)
+	}
+	assert.Greater(t, user.LastLogin, int64(0))
+	// the groups are updated on the next login
+	sessionProvider.session = getTestSAMLSession(username, "", []string{"members"})
+	rr = samlLogin(t, server, idp, webClientSAMLLoginPath)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	user, err = dataprovider.UserExists(username)
+	require.NoError(t, err)
+	if assert.Len(t, user.Groups, 1) {
+		assert.Equal(t, group2.Name, user.Groups[0].Name)
+		assert.Equal(t, sdk.GroupTypeSecondary, user.Groups[0].Type)
+	}
+	// disabled users cannot login
+	user.Status = 0
+	err = dataprovider.UpdateUser(&user, "", "")
+	assert.NoError(t, err)
+	rr = samlLogin(t, server, idp, webClientSAMLLoginPath)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webClientLoginPath, rr.Header().Get("Location"))
+
+	err = dataprovider.DeleteUser(username, "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(filepath.Join(os.TempDir(), "saml"))
+	assert.NoError(t, err)
+	err = dataprovider.DeleteGroup(group1.Name, "", "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteGroup(group2.Name, "", "")
+	assert.NoError(t, err)
+}
+
+func TestSAMLAssertionAttributes(t *testing.T) {
+	config := SAML{
+		RoleAttribute:    "role",
+		GroupAttribute:   "groups",
+		CustomAttributes: []string{"department", "projects", "missing"},
+	}
+	assertion := &saml.Assertion{
+		AttributeStatements: []saml.AttributeStatement{
+			{
+				Attributes: []saml.Attribute{
+					{
+						Name:   "role",
+						Values: []saml.AttributeValue{{Value: adminRoleFieldValue}},
+					},
+					{
+						Name:         "urn:oid:1",
+						FriendlyName: "groups",
+						Values:       []saml.AttributeValue{{Value: "g1"}, {Value: ""}, {Value: "g2"}},
+					},
+					{
+						Name:   "department",
+						Values: []saml.AttributeValue{{Value: "sales"}},
+					},
+					{
+						Name:   "projects",
+						Values: []saml.AttributeValue{{Value: "p1"}, {Value: "p2"}},
+					},
+				},
+			},
+		},
+	}
+	_, err := config.parseAssertion(assertion, tokenAudienceWebAdmin)
+	assert.Error(t, err)
+	assertion.Subject = &saml.Subject{
+		NameID: &saml.NameID{
+			Value: "name_id",
+		},
+	}
+	result, err := config.parseAssertion(assertion, tokenAudienceWebAdmin)
+	assert.NoError(t, err)
+	assert.Equal(t, "name_id", result.Username)
+	assert.True(t, result.isAdmin())
+	assert.Equal(t, []string{"g1", "g2"}, result.Groups)
+	if assert.NotNil(t, result.CustomFields) {
+		assert.Equal(t, "sales", (*result.CustomFields)["department"])
+		assert.Equal(t, []string{"p1", "p2"}, (*result.CustomFields)["projects"])
+		assert.NotContains(t, *result.CustomFields, "missing")
+	}
+	config.UsernameAttribute = "uid"
+	_, err = config.parseAssertion(assertion, tokenAudienceWebAdmin)
+	assert.Error(t, err)
+	config.UsernameAttribute = "department"
+	config.RoleAttribute = ""
+	config.ImplicitRoles = true
+	result, err = config.parseAssertion(assertion, tokenAudienceWebClient)
+	assert.NoError(t, err)
+	assert.Equal(t, "sales", result.Username)
+	assert.False(t, result.isAdmin())
+	result, err = config.parseAssertion(assertion, tokenAudienceWebAdmin)
+	assert.NoError(t, err)
+	assert.True(t, result.isAdmin())
+
+	assert.True(t, isSameSAMLGroups(nil, nil))
+	assert.False(t, isSameSAMLGroups([]sdk.GroupMapping{{Name: "a", Type: 1}}, nil))
+	assert.True(t, isSameSAMLGroups([]sdk.GroupMapping{{Name: "a", Type: 1}, {Name: "b", Type: 2}},
+		[]sdk.GroupMapping{{Name: "a", Type: 1}, {Name: "b", Type: 2}}))
+	assert.False(t, isSameSAMLGroups([]sdk.GroupMapping{{Name: "a", Type: 1}},
+		[]sdk.GroupMapping{{Name: "a", Type: 2}}))
+}
@@ -32,7 +32,6 @@ import (
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/go-chi/render"
 	"github.com/lestrrat-go/jwx/jwa"
-	"github.com/rs/cors"
 	"github.com/rs/xid"
 	"github.com/sftpgo/sdk"
 	"github.com/unrolled/secure"
@@ -42,6 +41,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/version"
 )
@@ -120,6 +120,9 @@ func (s *httpdServer) listenAndServe() error {
 			httpServer.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
 			httpServer.TLSConfig.VerifyConnection = s.verifyTLSConnection
 		}
+		if s.binding.EnableHTTP3 {
+			return util.HTTP3ListenAndServe(httpServer, s.binding.Address, s.binding.Port, logSender)
+		}
 		return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, true, logSender)
 	}
 	return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, false, logSender)
@@ -159,8 +162,9 @@ func (s *httpdServer) refreshCookie(next http.Handler) http.Handler {
 	})
 }
 
-func (s *httpdServer) renderClientLoginPage(w http.ResponseWriter, error, ip string) {
+func (s *httpdServer) renderClientLoginPage(w http.ResponseWriter, r *http.Request, error, ip string) {
 	data := loginPage{
+		pageLanguage: getPageLanguage(r),
 		CurrentURL:   webClientLoginPath,
 		Version:      version.Get().Version,
 		Error:        error,
@@ -179,6 +183,12 @@ func (s *httpdServer) renderClientLoginPage(w http.ResponseWriter, error, ip str
 	if s.binding.OIDC.isEnabled() && !s.binding.isWebClientOIDCLoginDisabled() {
 		data.OpenIDLoginURL = webClientOIDCLoginPath
 	}
+	if s.binding.SAML.isEnabled() && !s.binding.isWebClientOIDCLoginDisabled() {
+		data.SAMLLoginURL = webClientSAMLLoginPath
+	}
+	if s.binding.MagicLink.isEnabled() {
+		data.MagicLinkURL = webClientMagicLinkPath
+	}
 	renderClientTemplate(w, templateClientLogin, data)
 }
 
@@ -217,7 +227,7 @@ func (s *httpdServer) handleClientWebLogin(w http.ResponseWriter, r *http.Reques
 		http.Redirect(w, r, webAdminSetupPath, http.StatusFound)
 		return
 	}
-	s.renderClientLoginPage(w, getFlashMessage(w, r), util.GetIPFromRemoteAddress(r.RemoteAddr))
+	s.renderClientLoginPage(w, r, getFlashMessage(w, r), util.GetIPFromRemoteAddress(r.RemoteAddr))
 }
 
 func (s *httpdServer) handleWebClientLoginPost(w http.ResponseWriter, r *http.Request) {
@@ -225,7 +235,7 @@ func (s *httpdServer) handleWebClientLoginPost(w http.ResponseWriter, r *http.Re
 
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	if err := r.ParseForm(); err != nil {
-		s.renderClientLoginPage(w, err.Error(), ipAddr)
+		s.renderClientLoginPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	protocol := common.ProtocolHTTP
@@ -234,31 +244,47 @@ func (s *httpdServer) handleWebClientLoginPost(w http.ResponseWriter, r *http.Re
 	if username == "" || password == "" {
 		updateLoginMetrics(&dataprovider.User{BaseUser: sdk.BaseUser{Username: username}},
 			dataprovider.LoginMethodPassword, ipAddr, common.ErrNoCredentials)
-		s.renderClientLoginPage(w, "Invalid credentials", ipAddr)
+		s.renderClientLoginPage(w, r, "Invalid credentials", ipAddr)
 		return
 	}
 	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
 		updateLoginMetrics(&dataprovider.User{BaseUser: sdk.BaseUser{Username: username}},
 			dataprovider.LoginMethodPassword, ipAddr, err)
-		s.renderClientLoginPage(w, err.Error(), ipAddr)
+		s.renderClientLoginPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	if err := common.ThrottleByLatency(protocol, ipAddr); err != nil {
+		s.renderClientLoginPage(w, r, err.Error(), ipAddr)
 		return
 	}
 
 	if err := common.Config.ExecutePostConnectHook(ipAddr, protocol); err != nil {
-		s.renderClientLoginPage(w, fmt.Sprintf("access denied by post connect hook: %v", err), ipAddr)
+		s.renderClientLoginPage(w, r, fmt.Sprintf("access denied by post connect hook: %v", err), ipAddr)
 		return
 	}
 
 	user, err := dataprovider.CheckUserAndPass(username, password, ipAddr, protocol)
 	if err != nil {
 		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, err)
-		s.renderClientLoginPage(w, dataprovider.ErrInvalidCredentials.Error(), ipAddr)
+		if errors.Is(err, dataprovider.ErrProviderUnavailable) {
+			s.renderClientLoginPage(w, r, dataprovider.ErrProviderUnavailable.Error(), ipAddr)
+			return
+		}
+		if errors.Is(err, dataprovider.ErrPasswordExpired) {
+			s.renderClientLoginPage(w, r, "Your password is expired, please reset it", ipAddr)
+			return
+		}
+		s.checkUnknownUserPassword(err, password)
+		s.renderClientLoginPage(w, r, dataprovider.ErrInvalidCredentials.Error(), ipAddr)
 		return
 	}
 	connectionID := fmt.Sprintf("%v_%v", protocol, xid.New().String())
 	if err := checkHTTPClientUser(&user, r, connectionID, true); err != nil {
 		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, err)
-		s.renderClientLoginPage(w, err.Error(), ipAddr)
+		if s.binding.UniformAuthErrors {
+			err = dataprovider.ErrInvalidCredentials
+		}
+		s.renderClientLoginPage(w, r, err.Error(), ipAddr)
 		return
 	}
 
@@ -267,19 +293,31 @@ func (s *httpdServer) handleWebClientLoginPost(w http.ResponseWriter, r *http.Re
 	if err != nil {
 		logger.Warn(logSender, connectionID, "unable to check fs root: %v", err)
 		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, common.ErrInternalFailure)
-		s.renderClientLoginPage(w, err.Error(), ipAddr)
+		s.renderClientLoginPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	s.loginUser(w, r, &user, connectionID, ipAddr, false, s.renderClientLoginPage)
 }
 
+// checkUnknownUserPassword checks the password of an unknown user against a dummy
+// hash if uniform authentication errors are enabled for the binding, this way the
+// time spent is about the same as for an existing user with a wrong password
+func (s *httpdServer) checkUnknownUserPassword(err error, password string) {
+	if !s.binding.UniformAuthErrors {
+		return
+	}
+	if _, ok := err.(*util.RecordNotFoundError); ok {
+		dataprovider.SimulatePasswordCheck(password)
+	}
+}
+
 func (s *httpdServer) handleWebClientPasswordResetPost(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
 
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	err := r.ParseForm()
 	if err != nil {
-		s.renderClientResetPwdPage(w, err.Error(), ipAddr)
+		s.renderClientResetPwdPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
@@ -288,12 +326,12 @@ func (s *httpdServer) handleWebClientPasswordResetPost(w http.ResponseWriter, r
 	}
 	_, user, err := handleResetPassword(r, r.Form.Get("code"), r.Form.Get("password"), false)
 	if err != nil {
-		s.renderClientResetPwdPage(w, err.Error(), ipAddr)
+		s.renderClientResetPwdPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	connectionID := fmt.Sprintf("%v_%v", getProtocolFromRequest(r), xid.New().String())
 	if err := checkHTTPClientUser(user, r, connectionID, true); err != nil {
-		s.renderClientResetPwdPage(w, fmt.Sprintf("Password reset successfully but unable to login: %v", err.Error()), ipAddr)
+		s.renderClientResetPwdPage(w, r, fmt.Sprintf("Password reset successfully but unable to login: %v", err.Error()), ipAddr)
 		return
 	}
 
@@ -301,7 +339,7 @@ func (s *httpdServer) handleWebClientPasswordResetPost(w http.ResponseWriter, r
 	err = user.CheckFsRoot(connectionID)
 	if err != nil {
 		logger.Warn(logSender, connectionID, "unable to check fs root: %v", err)
-		s.renderClientResetPwdPage(w, fmt.Sprintf("Password reset successfully but unable to login: %v", err.Error()), ipAddr)
+		s.renderClientResetPwdPage(w, r, fmt.Sprintf("Password reset successfully but unable to login: %v", err.Error()), ipAddr)
 		return
 	}
 	s.loginUser(w, r, user, connectionID, ipAddr, false, s.renderClientResetPwdPage)
@@ -316,26 +354,26 @@ func (s *httpdServer) handleWebClientTwoFactorRecoveryPost(w http.ResponseWriter
 	}
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	if err := r.ParseForm(); err != nil {
-		s.renderClientTwoFactorRecoveryPage(w, err.Error(), ipAddr)
+		s.renderClientTwoFactorRecoveryPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	username := claims.Username
 	recoveryCode := r.Form.Get("recovery_code")
 	if username == "" || recoveryCode == "" {
-		s.renderClientTwoFactorRecoveryPage(w, "Invalid credentials", ipAddr)
+		s.renderClientTwoFactorRecoveryPage(w, r, "Invalid credentials", ipAddr)
 		return
 	}
 	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
-		s.renderClientTwoFactorRecoveryPage(w, err.Error(), ipAddr)
+		s.renderClientTwoFactorRecoveryPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	user, userMerged, err := dataprovider.GetUserVariants(username)
 	if err != nil {
-		s.renderClientTwoFactorRecoveryPage(w, "Invalid credentials", ipAddr)
+		s.renderClientTwoFactorRecoveryPage(w, r, "Invalid credentials", ipAddr)
 		return
 	}
-	if !userMerged.Filters.TOTPConfig.Enabled || !util.Contains(userMerged.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) {
-		s.renderClientTwoFactorPage(w, "Two factory authentication is not enabled", ipAddr)
+	if !isUserWebClientSecondFactorEnabled(&userMerged) {
+		s.renderClientTwoFactorPage(w, r, "Two factory authentication is not enabled", ipAddr)
 		return
 	}
 	for idx, code := range user.Filters.RecoveryCodes {
@@ -345,7 +383,7 @@ func (s *httpdServer) handleWebClientTwoFactorRecoveryPost(w http.ResponseWriter
 		}
 		if code.Secret.GetPayload() == recoveryCode {
 			if code.Used {
-				s.renderClientTwoFactorRecoveryPage(w, "This recovery code was already used", ipAddr)
+				s.renderClientTwoFactorRecoveryPage(w, r, "This recovery code was already used", ipAddr)
 				return
 			}
 			user.Filters.RecoveryCodes[idx].Used = true
@@ -361,7 +399,66 @@ func (s *httpdServer) handleWebClientTwoFactorRecoveryPost(w http.ResponseWriter
 			return
 		}
 	}
-	s.renderClientTwoFactorRecoveryPage(w, "Invalid recovery code", ipAddr)
+	s.renderClientTwoFactorRecoveryPage(w, r, "Invalid recovery code", ipAddr)
+}
+
+// handleWebClientRecoveryCodesEmailPost regenerates the recovery codes for
+// users who lost both their second factor and their recovery codes and sends
+// them via email. The password is verified again
+func (s *httpdServer) handleWebClientRecoveryCodesEmailPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	claims, err := getTokenClaims(r)
+	if err != nil || !isRecoveryCodesEmailRecoveryEnabled() {
+		s.renderNotFoundPage(w, r, nil)
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if err := r.ParseForm(); err != nil {
+		s.renderClientTwoFactorRecoveryPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	username := claims.Username
+	password := r.Form.Get("password")
+	if username == "" || password == "" {
+		s.renderClientTwoFactorRecoveryPage(w, r, "Invalid credentials", ipAddr)
+		return
+	}
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
+		s.renderClientTwoFactorRecoveryPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	user, userMerged, err := dataprovider.GetUserVariants(username)
+	if err != nil {
+		s.renderClientTwoFactorRecoveryPage(w, r, "Invalid credentials", ipAddr)
+		return
+	}
+	if !isUserWebClientSecondFactorEnabled(&userMerged) {
+		s.renderClientTwoFactorPage(w, r, "Two factory authentication is not enabled", ipAddr)
+		return
+	}
+	if _, err := dataprovider.CheckUserAndPass(username, password, ipAddr, getProtocolFromRequest(r)); err != nil {
+		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, err)
+		s.renderClientTwoFactorRecoveryPage(w, r, "Invalid credentials", ipAddr)
+		return
+	}
+	if user.Email == "" {
+		s.renderClientTwoFactorRecoveryPage(w, r,
+			"No email address is configured for your account, please contact your administrator", ipAddr)
+		return
+	}
+	recoveryCodes, accountRecoveryCodes := getNewRecoveryCodes()
+	user.Filters.RecoveryCodes = accountRecoveryCodes
+	if err := dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSelf, ipAddr); err != nil {
+		logger.Warn(logSender, "", "unable to save the new recovery codes for user %q: %v", username, err)
+		s.renderClientInternalServerErrorPage(w, r, errors.New("unable to save the new recovery codes"))
+		return
+	}
+	if err := sendRecoveryCodesEmail(r, username, user.Email, userMerged.Filters.Language, recoveryCodes); err != nil {
+		s.renderClientTwoFactorRecoveryPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	s.renderClientTwoFactorRecoveryPageWithInfo(w, r, "",
+		"New recovery codes were sent to your email address, the previous ones can no longer be used", ipAddr)
 }
 
 func (s *httpdServer) handleWebClientTwoFactorPost(w http.ResponseWriter, r *http.Request) {
@@ -373,26 +470,36 @@ func (s *httpdServer) handleWebClientTwoFactorPost(w http.ResponseWriter, r *htt
 	}
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	if err := r.ParseForm(); err != nil {
-		s.renderClientTwoFactorPage(w, err.Error(), ipAddr)
+		s.renderClientTwoFactorPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	username := claims.Username
 	passcode := r.Form.Get("passcode")
-	if username == "" || passcode == "" {
-		s.renderClientTwoFactorPage(w, "Invalid credentials", ipAddr)
+	webAuthnResponse := r.Form.Get("webauthn_response")
+	push := r.Form.Get("push")
+	if username == "" || (passcode == "" && webAuthnResponse == "" && push == "") {
+		s.renderClientTwoFactorPage(w, r, "Invalid credentials", ipAddr)
 		return
 	}
 	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
-		s.renderClientTwoFactorPage(w, err.Error(), ipAddr)
+		s.renderClientTwoFactorPage(w, r, err.Error(), ipAddr)
+		return
+	}
+	if webAuthnResponse != "" {
+		s.handleWebClientTwoFactorWebAuthnPost(w, r, username, webAuthnResponse, ipAddr)
+		return
+	}
+	if passcode == "" {
+		s.handleWebClientTwoFactorPushPost(w, r, username, ipAddr)
 		return
 	}
 	user, err := dataprovider.GetUserWithGroupSettings(username)
 	if err != nil {
-		s.renderClientTwoFactorPage(w, "Invalid credentials", ipAddr)
+		s.renderClientTwoFactorPage(w, r, "Invalid credentials", ipAddr)
 		return
 	}
 	if !user.Filters.TOTPConfig.Enabled || !util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) {
-		s.renderClientTwoFactorPage(w, "Two factory authentication is not enabled", ipAddr)
+		s.renderClientTwoFactorPage(w, r, "Two factory authentication is not enabled", ipAddr)
 		return
 	}
 	err = user.Filters.TOTPConfig.Secret.Decrypt()
@@ -403,7 +510,25 @@ func (s *httpdServer) handleWebClientTwoFactorPost(w http.ResponseWriter, r *htt
 	match, err := mfa.ValidateTOTPPasscode(user.Filters.TOTPConfig.ConfigName, passcode,
 		user.Filters.TOTPConfig.Secret.GetPayload())
 	if !match || err != nil {
-		s.renderClientTwoFactorPage(w, "Invalid authentication code", ipAddr)
+		s.renderClientTwoFactorPage(w, r, "Invalid authentication code", ipAddr)
+		return
+	}
+	connectionID := fmt.Sprintf("%v_%v", getProtocolFromRequest(r), xid.New().String())
+	s.loginUser(w, r, &user, connectionID, ipAddr, true, s.renderClientTwoFactorPage)
+}
+
+func (s *httpdServer) handleWebClientTwoFactorPushPost(w http.ResponseWriter, r *http.Request, username, ipAddr string) {
+	user, err := dataprovider.GetUserWithGroupSettings(username)
+	if err != nil {
+		s.renderClientTwoFactorPage(w, r, "Invalid credentials", ipAddr)
+		return
+	}
+	if !user.IsPushEnabledForProtocol(common.ProtocolHTTP) {
+		s.renderClientTwoFactorPage(w, r, "Two factory authentication is not enabled", ipAddr)
+		return
+	}
+	if err := dataprovider.RequestUserPushApproval(&user, ipAddr, common.ProtocolHTTP); err != nil {
+		s.renderClientTwoFactorPage(w, r, "The login request was not approved", ipAddr)
 		return
 	}
 	connectionID := fmt.Sprintf("%v_%v", getProtocolFromRequest(r), xid.New().String())
@@ -420,26 +545,26 @@ func (s *httpdServer) handleWebAdminTwoFactorRecoveryPost(w http.ResponseWriter,
 	}
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	if err := r.ParseForm(); err != nil {
-		s.renderTwoFactorRecoveryPage(w, err.Error(), ipAddr)
+		s.renderTwoFactorRecoveryPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	username := claims.Username
 	recoveryCode := r.Form.Get("recovery_code")
 	if username == "" || recoveryCode == "" {
-		s.renderTwoFactorRecoveryPage(w, "Invalid credentials", ipAddr)
+		s.renderTwoFactorRecoveryPage(w, r, "Invalid credentials", ipAddr)
 		return
 	}
 	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
-		s.renderTwoFactorRecoveryPage(w, err.Error(), ipAddr)
+		s.renderTwoFactorRecoveryPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	admin, err := dataprovider.AdminExists(username)
 	if err != nil {
-		s.renderTwoFactorRecoveryPage(w, "Invalid credentials", ipAddr)
+		s.renderTwoFactorRecoveryPage(w, r, "Invalid credentials", ipAddr)
 		return
 	}
-	if !admin.Filters.TOTPConfig.Enabled {
-		s.renderTwoFactorRecoveryPage(w, "Two factory authentication is not enabled", ipAddr)
+	if !admin.Filters.TOTPConfig.Enabled && !admin.HasWebAuthnCredentials() {
+		s.renderTwoFactorRecoveryPage(w, r, "Two factory authentication is not enabled", ipAddr)
 		return
 	}
 	for idx, code := range admin.Filters.RecoveryCodes {
@@ -449,7 +574,7 @@ func (s *httpdServer) handleWebAdminTwoFactorRecoveryPost(w http.ResponseWriter,
 		}
 		if code.Secret.GetPayload() == recoveryCode {
 			if code.Used {
-				s.renderTwoFactorRecoveryPage(w, "This recovery code was already used", ipAddr)
+				s.renderTwoFactorRecoveryPage(w, r, "This recovery code was already used", ipAddr)
 				return
 			}
 			admin.Filters.RecoveryCodes[idx].Used = true
@@ -463,7 +588,7 @@ func (s *httpdServer) handleWebAdminTwoFactorRecoveryPost(w http.ResponseWriter,
 			return
 		}
 	}
-	s.renderTwoFactorRecoveryPage(w, "Invalid recovery code", ipAddr)
+	s.renderTwoFactorRecoveryPage(w, r, "Invalid recovery code", ipAddr)
 }
 
 func (s *httpdServer) handleWebAdminTwoFactorPost(w http.ResponseWriter, r *http.Request) {
@@ -475,26 +600,49 @@ func (s *httpdServer) handleWebAdminTwoFactorPost(w http.ResponseWriter, r *http
 	}
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	if err := r.ParseForm(); err != nil {
-		s.renderTwoFactorPage(w, err.Error(), ipAddr)
+		s.renderTwoFactorPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	username := claims.Username
 	passcode := r.Form.Get("passcode")
-	if username == "" || passcode == "" {
-		s.renderTwoFactorPage(w, "Invalid credentials", ipAddr)
+	webAuthnResponse := r.Form.Get("webauthn_response")
+	push := r.Form.Get("push")
+	if username == "" || (passcode == "" && webAuthnResponse == "" && push == "") {
+		s.renderTwoFactorPage(w, r, "Invalid credentials", ipAddr)
 		return
 	}
 	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
-		s.renderTwoFactorPage(w, err.Error(), ipAddr)
+		s.renderTwoFactorPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	admin, err := dataprovider.AdminExists(username)
 	if err != nil {
-		s.renderTwoFactorPage(w, "Invalid credentials", ipAddr)
+		s.renderTwoFactorPage(w, r, "Invalid credentials", ipAddr)
+		return
+	}
+	if webAuthnResponse != "" {
+		if err := checkAdminWebAuthnLogin(&admin, r.Form.Get("webauthn_session"), webAuthnResponse, ipAddr); err != nil {
+			logger.Debug(logSender, "", "WebAuthn login failed for admin %q: %v", admin.Username, err)
+			s.renderTwoFactorPage(w, r, "Security key verification failed", ipAddr)
+			return
+		}
+		s.loginAdmin(w, r, &admin, true, s.renderTwoFactorPage, ipAddr)
+		return
+	}
+	if passcode == "" {
+		if !admin.IsPushEnabled() {
+			s.renderTwoFactorPage(w, r, "Two factory authentication is not enabled", ipAddr)
+			return
+		}
+		if err := dataprovider.RequestAdminPushApproval(&admin, ipAddr); err != nil {
+			s.renderTwoFactorPage(w, r, "The login request was not approved", ipAddr)
+			return
+		}
+		s.loginAdmin(w, r, &admin, true, s.renderTwoFactorPage, ipAddr)
 		return
 	}
 	if !admin.Filters.TOTPConfig.Enabled {
-		s.renderTwoFactorPage(w, "Two factory authentication is not enabled", ipAddr)
+		s.renderTwoFactorPage(w, r, "Two factory authentication is not enabled", ipAddr)
 		return
 	}
 	err = admin.Filters.TOTPConfig.Secret.Decrypt()
@@ -505,7 +653,7 @@ func (s *httpdServer) handleWebAdminTwoFactorPost(w http.ResponseWriter, r *http
 	match, err := mfa.ValidateTOTPPasscode(admin.Filters.TOTPConfig.ConfigName, passcode,
 		admin.Filters.TOTPConfig.Secret.GetPayload())
 	if !match || err != nil {
-		s.renderTwoFactorPage(w, "Invalid authentication code", ipAddr)
+		s.renderTwoFactorPage(w, r, "Invalid authentication code", ipAddr)
 		return
 	}
 	s.loginAdmin(w, r, &admin, true, s.renderTwoFactorPage, ipAddr)
@@ -516,29 +664,30 @@ func (s *httpdServer) handleWebAdminLoginPost(w http.ResponseWriter, r *http.Req
 
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	if err := r.ParseForm(); err != nil {
-		s.renderAdminLoginPage(w, err.Error(), ipAddr)
+		s.renderAdminLoginPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	username := r.Form.Get("username")
 	password := r.Form.Get("password")
 	if username == "" || password == "" {
-		s.renderAdminLoginPage(w, "Invalid credentials", ipAddr)
+		s.renderAdminLoginPage(w, r, "Invalid credentials", ipAddr)
 		return
 	}
 	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
-		s.renderAdminLoginPage(w, err.Error(), ipAddr)
+		s.renderAdminLoginPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	admin, err := dataprovider.CheckAdminAndPass(username, password, ipAddr)
 	if err != nil {
-		s.renderAdminLoginPage(w, err.Error(), ipAddr)
+		s.renderAdminLoginPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	s.loginAdmin(w, r, &admin, false, s.renderAdminLoginPage, ipAddr)
 }
 
-func (s *httpdServer) renderAdminLoginPage(w http.ResponseWriter, error, ip string) {
+func (s *httpdServer) renderAdminLoginPage(w http.ResponseWriter, r *http.Request, error, ip string) {
 	data := loginPage{
+		pageLanguage: getPageLanguage(r),
 		CurrentURL:   webAdminLoginPath,
 		Version:      version.Get().Version,
 		Error:        error,
@@ -557,6 +706,9 @@ func (s *httpdServer) renderAdminLoginPage(w http.ResponseWriter, error, ip stri
 	if s.binding.OIDC.hasRoles() && !s.binding.isWebAdminOIDCLoginDisabled() {
 		data.OpenIDLoginURL = webAdminOIDCLoginPath
 	}
+	if s.binding.SAML.hasRoles() && !s.binding.isWebAdminOIDCLoginDisabled() {
+		data.SAMLLoginURL = webAdminSAMLLoginPath
+	}
 	renderAdminTemplate(w, templateLogin, data)
 }
 
@@ -566,7 +718,7 @@ func (s *httpdServer) handleWebAdminLogin(w http.ResponseWriter, r *http.Request
 		http.Redirect(w, r, webAdminSetupPath, http.StatusFound)
 		return
 	}
-	s.renderAdminLoginPage(w, getFlashMessage(w, r), util.GetIPFromRemoteAddress(r.RemoteAddr))
+	s.renderAdminLoginPage(w, r, getFlashMessage(w, r), util.GetIPFromRemoteAddress(r.RemoteAddr))
 }
 
 func (s *httpdServer) handleWebAdminLogout(w http.ResponseWriter, r *http.Request) {
@@ -604,7 +756,7 @@ func (s *httpdServer) handleWebAdminPasswordResetPost(w http.ResponseWriter, r *
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	err := r.ParseForm()
 	if err != nil {
-		s.renderResetPwdPage(w, err.Error(), ipAddr)
+		s.renderResetPwdPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
@@ -614,10 +766,10 @@ func (s *httpdServer) handleWebAdminPasswordResetPost(w http.ResponseWriter, r *
 	admin, _, err := handleResetPassword(r, r.Form.Get("code"), r.Form.Get("password"), true)
 	if err != nil {
 		if e, ok := err.(*util.ValidationError); ok {
-			s.renderResetPwdPage(w, e.GetErrorString(), ipAddr)
+			s.renderResetPwdPage(w, r, e.GetErrorString(), ipAddr)
 			return
 		}
-		s.renderResetPwdPage(w, err.Error(), ipAddr)
+		s.renderResetPwdPage(w, r, err.Error(), ipAddr)
 		return
 	}
 
@@ -676,7 +828,7 @@ func (s *httpdServer) handleWebAdminSetupPost(w http.ResponseWriter, r *http.Req
 
 func (s *httpdServer) loginUser(
 	w http.ResponseWriter, r *http.Request, user *dataprovider.User, connectionID, ipAddr string,
-	isSecondFactorAuth bool, errorFunc func(w http.ResponseWriter, error, ip string),
+	isSecondFactorAuth bool, errorFunc func(w http.ResponseWriter, r *http.Request, error, ip string),
 ) {
 	c := jwtTokenClaims{
 		Username:                   user.Username,
@@ -684,11 +836,13 @@ func (s *httpdServer) loginUser(
 		Signature:                  user.GetSignature(),
 		MustSetTwoFactorAuth:       user.MustSetSecondFactor(),
 		RequiredTwoFactorProtocols: user.Filters.TwoFactorAuthProtocols,
+		Theme:                      user.Filters.Theme,
+		Language:                   user.Filters.Language,
 	}
 
 	audience := tokenAudienceWebClient
-	if user.Filters.TOTPConfig.Enabled && util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) &&
-		user.CanManageMFA() && !isSecondFactorAuth {
+	if ((isUserWebClientSecondFactorEnabled(user) && user.CanManageMFA()) ||
+		user.IsPushEnabledForProtocol(common.ProtocolHTTP)) && !isSecondFactorAuth {
 		audience = tokenAudienceWebClientPartial
 	}
 
@@ -696,7 +850,7 @@ func (s *httpdServer) loginUser(
 	if err != nil {
 		logger.Warn(logSender, connectionID, "unable to set user login cookie %v", err)
 		updateLoginMetrics(user, dataprovider.LoginMethodPassword, ipAddr, common.ErrInternalFailure)
-		errorFunc(w, err.Error(), ipAddr)
+		errorFunc(w, r, err.Error(), ipAddr)
 		return
 	}
 	if isSecondFactorAuth {
@@ -713,7 +867,7 @@ func (s *httpdServer) loginUser(
 
 func (s *httpdServer) loginAdmin(
 	w http.ResponseWriter, r *http.Request, admin *dataprovider.Admin,
-	isSecondFactorAuth bool, errorFunc func(w http.ResponseWriter, error, ip string),
+	isSecondFactorAuth bool, errorFunc func(w http.ResponseWriter, r *http.Request, error, ip string),
 	ipAddr string,
 ) {
 	c := jwtTokenClaims{
@@ -721,12 +875,18 @@ func (s *httpdServer) loginAdmin(
 		Permissions:          admin.Permissions,
 		Signature:            admin.GetSignature(),
 		HideUserPageSections: admin.Filters.Preferences.HideUserPageSections,
+		Theme:                admin.Filters.Preferences.Theme,
+		Language:             admin.Filters.Language,
 	}
 
 	audience := tokenAudienceWebAdmin
-	if admin.Filters.TOTPConfig.Enabled && admin.CanManageMFA() && !isSecondFactorAuth {
+	if (((admin.Filters.TOTPConfig.Enabled || admin.HasWebAuthnCredentials()) && admin.CanManageMFA()) ||
+		admin.IsPushEnabled()) && !isSecondFactorAuth {
 		audience = tokenAudienceWebAdminPartial
 	}
+	if audience == tokenAudienceWebAdmin {
+		c.MustSetTwoFactorAuth = admin.MustSetSecondFactor()
+	}
 
 	err := c.createAndSetCookie(w, r, s.tokenAuth, audience, ipAddr)
 	if err != nil {
@@ -735,7 +895,7 @@ func (s *httpdServer) loginAdmin(
 			s.renderAdminSetupPage(w, r, admin.Username, err.Error())
 			return
 		}
-		errorFunc(w, err.Error(), ipAddr)
+		errorFunc(w, r, err.Error(), ipAddr)
 		return
 	}
 	if isSecondFactorAuth {
@@ -746,6 +906,10 @@ func (s *httpdServer) loginAdmin(
 		return
 	}
 	dataprovider.UpdateAdminLastLogin(admin)
+	if c.MustSetTwoFactorAuth {
+		http.Redirect(w, r, webAdminMFAPath, http.StatusFound)
+		return
+	}
 	http.Redirect(w, r, webUsersPath, http.StatusFound)
 }
 
@@ -774,14 +938,28 @@ func (s *httpdServer) getUserToken(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
+	if err := common.ThrottleByLatency(protocol, ipAddr); err != nil {
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
 	if err := common.Config.ExecutePostConnectHook(ipAddr, protocol); err != nil {
 		sendAPIResponse(w, r, err, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 		return
 	}
 	user, err := dataprovider.CheckUserAndPass(username, password, ipAddr, protocol)
 	if err != nil {
-		w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
 		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, err)
+		if errors.Is(err, dataprovider.ErrProviderUnavailable) {
+			sendAPIResponse(w, r, dataprovider.ErrProviderUnavailable, http.StatusText(http.StatusServiceUnavailable),
+				http.StatusServiceUnavailable)
+			return
+		}
+		if errors.Is(err, dataprovider.ErrPasswordExpired) {
+			sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		s.checkUnknownUserPassword(err, password)
+		w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
 		sendAPIResponse(w, r, dataprovider.ErrInvalidCredentials, http.StatusText(http.StatusUnauthorized),
 			http.StatusUnauthorized)
 		return
@@ -789,12 +967,26 @@ func (s *httpdServer) getUserToken(w http.ResponseWriter, r *http.Request) {
 	connectionID := fmt.Sprintf("%v_%v", protocol, xid.New().String())
 	if err := checkHTTPClientUser(&user, r, connectionID, true); err != nil {
 		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, err)
+		if s.binding.UniformAuthErrors {
+			w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
+			sendAPIResponse(w, r, dataprovider.ErrInvalidCredentials, http.StatusText(http.StatusUnauthorized),
+				http.StatusUnauthorized)
+			return
+		}
 		sendAPIResponse(w, r, err, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 		return
 	}
 
-	if user.Filters.TOTPConfig.Enabled && util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) {
-		passcode := r.Header.Get(otpHeaderCode)
+	passcode := r.Header.Get(otpHeaderCode)
+	if passcode == "" && user.IsPushEnabledForProtocol(common.ProtocolHTTP) {
+		if err := dataprovider.RequestUserPushApproval(&user, ipAddr, protocol); err != nil {
+			w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
+			updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, err)
+			sendAPIResponse(w, r, dataprovider.ErrInvalidCredentials, http.StatusText(http.StatusUnauthorized),
+				http.StatusUnauthorized)
+			return
+		}
+	} else if user.Filters.TOTPConfig.Enabled && util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) {
 		if passcode == "" {
 			logger.Debug(logSender, "", "TOTP enabled for user %#v and not passcode provided, authentication refused", user.Username)
 			w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
@@ -869,8 +1061,15 @@ func (s *httpdServer) getToken(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
-	if admin.Filters.TOTPConfig.Enabled {
-		passcode := r.Header.Get(otpHeaderCode)
+	passcode := r.Header.Get(otpHeaderCode)
+	if passcode == "" && admin.IsPushEnabled() {
+		if err := dataprovider.RequestAdminPushApproval(&admin, ipAddr); err != nil {
+			w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
+			sendAPIResponse(w, r, dataprovider.ErrInvalidCredentials, http.StatusText(http.StatusUnauthorized),
+				http.StatusUnauthorized)
+			return
+		}
+	} else if admin.Filters.TOTPConfig.Enabled {
 		if passcode == "" {
 			logger.Debug(logSender, "", "TOTP enabled for admin %#v and not passcode provided, authentication refused", admin.Username)
 			w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
@@ -925,7 +1124,8 @@ func (s *httpdServer) checkCookieExpiration(w http.ResponseWriter, r *http.Reque
 	}
 	tokenClaims := jwtTokenClaims{}
 	tokenClaims.Decode(claims)
-	if tokenClaims.Username == "" || tokenClaims.Signature == "" {
+	// impersonated sessions are time-limited and never refreshed
+	if tokenClaims.Username == "" || tokenClaims.Signature == "" || tokenClaims.ImpersonatedBy != "" {
 		return
 	}
 	if time.Until(token.Expiration()) > tokenRefreshThreshold {
@@ -953,6 +1153,8 @@ func (s *httpdServer) refreshClientToken(w http.ResponseWriter, r *http.Request,
 	}
 
 	tokenClaims.Permissions = user.Filters.WebClient
+	tokenClaims.Theme = user.Filters.Theme
+	tokenClaims.Language = user.Filters.Language
 	logger.Debug(logSender, "", "cookie refreshed for user %#v", user.Username)
 	tokenClaims.createAndSetCookie(w, r, s.tokenAuth, tokenAudienceWebClient, util.GetIPFromRemoteAddress(r.RemoteAddr)) //nolint:errcheck
 }
@@ -977,10 +1179,48 @@ func (s *httpdServer) refreshAdminToken(w http.ResponseWriter, r *http.Request,
 	}
 	tokenClaims.Permissions = admin.Permissions
 	tokenClaims.HideUserPageSections = admin.Filters.Preferences.HideUserPageSections
+	tokenClaims.Theme = admin.Filters.Preferences.Theme
+	tokenClaims.Language = admin.Filters.Language
 	logger.Debug(logSender, "", "cookie refreshed for admin %#v", admin.Username)
 	tokenClaims.createAndSetCookie(w, r, s.tokenAuth, tokenAudienceWebAdmin, ipAddr) //nolint:errcheck
 }
 
+// updateSessionPreferences applies a changed theme or language to the current web session,
+// without waiting for the next token refresh, and returns a request with updated claims
+func (s *httpdServer) updateSessionPreferences(w http.ResponseWriter, r *http.Request, theme, lang string,
+	audience tokenAudience,
+) *http.Request {
+	_, claims, err := jwtauth.FromContext(r.Context())
+	if err != nil {
+		return r
+	}
+	tokenClaims := jwtTokenClaims{}
+	tokenClaims.Decode(claims)
+	if tokenClaims.Theme == theme && tokenClaims.Language == lang {
+		return r
+	}
+	tokenClaims.Theme = theme
+	tokenClaims.Language = lang
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if cookie, ok := r.Context().Value(oidcTokenKey).(string); ok {
+		token, err := oidcMgr.getToken(cookie)
+		if err != nil {
+			return r
+		}
+		token.Theme = theme
+		token.Language = lang
+		oidcMgr.addToken(token)
+	} else if err := tokenClaims.createAndSetCookie(w, r, s.tokenAuth, audience, ipAddr); err != nil {
+		logger.Debug(logSender, "", "unable to update the preferences for the session of %q: %v", tokenClaims.Username, err)
+		return r
+	}
+	token, _, err := tokenClaims.createToken(s.tokenAuth, audience, ipAddr)
+	if err != nil {
+		return r
+	}
+	return r.WithContext(jwtauth.NewContext(r.Context(), token, nil))
+}
+
 func (s *httpdServer) updateContextFromCookie(r *http.Request) *http.Request {
 	token, _, err := jwtauth.FromContext(r.Context())
 	if token == nil || err != nil {
@@ -1039,6 +1279,11 @@ func (s *httpdServer) checkConnection(next http.Handler) http.Handler {
 			s.sendForbiddenResponse(w, r, "your IP address is banned")
 			return
 		}
+		if !common.IsCountryAllowed(ipAddr, s.binding.AllowedCountries, s.binding.DeniedCountries) {
+			logger.Log(logger.LevelDebug, common.ProtocolHTTP, "", "connection refused, country not allowed for ip %q", ipAddr)
+			s.sendForbiddenResponse(w, r, "connection not allowed from your country")
+			return
+		}
 		if delay, err := common.LimitRate(common.ProtocolHTTP, ipAddr); err != nil {
 			delay += 499999999 * time.Nanosecond
 			w.Header().Set("Retry-After", fmt.Sprintf("%.0f", delay.Seconds()))
@@ -1046,11 +1291,29 @@ func (s *httpdServer) checkConnection(next http.Handler) http.Handler {
 			s.sendTooManyRequestResponse(w, r, err)
 			return
 		}
+		if !s.isClientAllowed(r) {
+			s.sendForbiddenResponse(w, r, common.ErrClientNotAllowed.Error())
+			return
+		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+func (s *httpdServer) isClientAllowed(r *http.Request) bool {
+	userAgent := r.UserAgent()
+	if !s.binding.ClientPolicy.IsClientAllowed(userAgent) {
+		common.AddDeniedClientVersion(common.ProtocolHTTP, userAgent)
+		logger.Debug(logSender, "", "client %q not allowed for binding %q, remote address: %v",
+			userAgent, s.binding.GetAddress(), r.RemoteAddr)
+		return false
+	}
+	if !strings.HasPrefix(r.URL.Path, webStaticFilesPath) {
+		common.AddClientVersion(common.ProtocolHTTP, userAgent)
+	}
+	return true
+}
+
 func (s *httpdServer) sendTooManyRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
 	if (s.enableWebAdmin || s.enableWebClient) && isWebRequest(r) {
 		r = s.updateContextFromCookie(r)
@@ -1111,11 +1374,19 @@ func (s *httpdServer) isStaticFileURL(r *http.Request) bool {
 	return !strings.HasPrefix(urlPath, webOpenAPIPath) && !strings.HasPrefix(urlPath, webStaticFilesPath)
 }
 
+func getRoutePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		return rctx.RoutePattern()
+	}
+	return ""
+}
+
 func (s *httpdServer) initializeRouter() {
 	s.tokenAuth = jwtauth.New(jwa.HS256.String(), getSigningKey(s.signingPassphrase), nil)
 	s.router = chi.NewRouter()
 
 	s.router.Use(middleware.RequestID)
+	s.router.Use(tracing.HTTPMiddleware(common.ProtocolHTTP, getRoutePattern))
 	s.router.Use(s.checkConnection)
 	s.router.Use(logger.NewStructuredLogger(logger.GetLogger()))
 	s.router.Use(middleware.Recoverer)
@@ -1132,7 +1403,9 @@ func (s *httpdServer) initializeRouter() {
 			STSIncludeSubdomains:    s.binding.Security.STSIncludeSubdomains,
 			STSPreload:              s.binding.Security.STSPreload,
 			ContentTypeNosniff:      s.binding.Security.ContentTypeNosniff,
-			ContentSecurityPolicy:   s.binding.Security.ContentSecurityPolicy,
+			ContentSecurityPolicy:   s.binding.Security.getContentSecurityPolicy(),
+			CustomFrameOptionsValue: s.binding.Security.getFrameOptions(),
+			ReferrerPolicy:          s.binding.Security.getReferrerPolicy(),
 			PermissionsPolicy:       s.binding.Security.PermissionsPolicy,
 			CrossOriginOpenerPolicy: s.binding.Security.CrossOriginOpenerPolicy,
 			ExpectCTHeader:          s.binding.Security.ExpectCTHeader,
@@ -1141,18 +1414,7 @@ func (s *httpdServer) initializeRouter() {
 		s.router.Use(secureMiddleware.Handler)
 	}
 	if s.cors.Enabled {
-		c := cors.New(cors.Options{
-			AllowedOrigins:       util.RemoveDuplicates(s.cors.AllowedOrigins, true),
-			AllowedMethods:       util.RemoveDuplicates(s.cors.AllowedMethods, true),
-			AllowedHeaders:       util.RemoveDuplicates(s.cors.AllowedHeaders, true),
-			ExposedHeaders:       util.RemoveDuplicates(s.cors.ExposedHeaders, true),
-			MaxAge:               s.cors.MaxAge,
-			AllowCredentials:     s.cors.AllowCredentials,
-			OptionsPassthrough:   s.cors.OptionsPassthrough,
-			OptionsSuccessStatus: s.cors.OptionsSuccessStatus,
-			AllowPrivateNetwork:  s.cors.AllowPrivateNetwork,
-		})
-		s.router.Use(c.Handler)
+		s.router.Use(s.cors.getHandler())
 	}
 	s.router.Use(middleware.GetHead)
 	// StripSlashes causes infinite redirects at the root path if used with http.FileServer
@@ -1235,29 +1497,40 @@ func (s *httpdServer) initializeRouter() {
 			router.With(s.checkPerm(dataprovider.PermAdminViewUsers)).Get(userPath+"/{username}", getUserByUsername)
 			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(userPath+"/{username}", updateUser)
 			router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers)).Delete(userPath+"/{username}", deleteUser)
+			router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers)).Post(userPath+"/{username}/restore", restoreUser)
 			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(userPath+"/{username}/2fa/disable", disableUser2FA)
+			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Post(userPath+"/{username}/sshcert", issueSSHCertificateForUser)
 			router.With(s.checkPerm(dataprovider.PermAdminViewUsers)).Get(folderPath, getFolders)
 			router.With(s.checkPerm(dataprovider.PermAdminViewUsers)).Get(folderPath+"/{name}", getFolderByName)
 			router.With(s.checkPerm(dataprovider.PermAdminAddUsers)).Post(folderPath, addFolder)
 			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(folderPath+"/{name}", updateFolder)
 			router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers)).Delete(folderPath+"/{name}", deleteFolder)
+			router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers)).Post(folderPath+"/{name}/restore", restoreFolder)
 			router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Get(groupPath, getGroups)
 			router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Get(groupPath+"/{name}", getGroupByName)
 			router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Post(groupPath, addGroup)
 			router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Put(groupPath+"/{name}", updateGroup)
 			router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Delete(groupPath+"/{name}", deleteGroup)
-			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(dumpDataPath, dumpData)
+			router.With(s.checkPerm(dataprovider.PermAdminExportData)).Get(dumpDataPath, dumpData)
 			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(loadDataPath, loadData)
 			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(loadDataPath, loadDataFromRequest)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(dbBackupPath, backupDatabase)
+			router.With(s.checkPerm(dataprovider.PermAdminExportData)).Get(bulkPath+"/{objecttype}/export", bulkExport)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(bulkPath+"/{objecttype}/import", bulkImport)
 			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(quotasBasePath+"/users/{username}/usage",
 				updateUserQuotaUsage)
 			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(quotasBasePath+"/users/{username}/transfer-usage",
 				updateUserTransferQuotaUsage)
 			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(quotasBasePath+"/folders/{name}/usage",
 				updateFolderQuotaUsage)
+			router.With(s.checkPerm(dataprovider.PermAdminViewUsers)).Get(quotasBasePath+"/users/{username}/folders",
+				getUserFoldersQuota)
+			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).
+				Put(quotasBasePath+"/users/{username}/folders/{name}/usage", updateUserFolderQuotaUsage)
 			router.With(s.checkPerm(dataprovider.PermAdminViewDefender)).Get(defenderHosts, getDefenderHosts)
 			router.With(s.checkPerm(dataprovider.PermAdminViewDefender)).Get(defenderHosts+"/{id}", getDefenderHostByID)
 			router.With(s.checkPerm(dataprovider.PermAdminManageDefender)).Delete(defenderHosts+"/{id}", deleteDefenderHostByID)
+			router.With(s.checkPerm(dataprovider.PermAdminViewDefender)).Get(defenderFeeds, getDefenderFeeds)
 			router.With(s.checkPerm(dataprovider.PermAdminManageAdmins)).Get(adminPath, getAdmins)
 			router.With(s.checkPerm(dataprovider.PermAdminManageAdmins)).Post(adminPath, addAdmin)
 			router.With(s.checkPerm(dataprovider.PermAdminManageAdmins)).Get(adminPath+"/{username}", getAdminByUsername)
@@ -1274,6 +1547,16 @@ func (s *httpdServer) initializeRouter() {
 				Get(fsEventsPath, searchFsEvents)
 			router.With(s.checkPerm(dataprovider.PermAdminViewEvents), compressor.Handler).
 				Get(providerEventsPath, searchProviderEvents)
+			router.With(s.checkPerm(dataprovider.PermAdminViewEvents), compressor.Handler).
+				Get(auditPath, getAuditEntries)
+			router.With(s.checkPerm(dataprovider.PermAdminViewEvents), compressor.Handler).
+				Get(auditLogExportPath, exportAuditLog)
+			router.With(s.checkPerm(dataprovider.PermAdminViewEvents), compressor.Handler).
+				Get(deadLettersPath, getDeadLetters)
+			router.With(s.checkPerm(dataprovider.PermAdminViewEvents)).Get(deadLettersPath+"/{id}", getDeadLetterByID)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Delete(deadLettersPath+"/{id}", deleteDeadLetter)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(deadLettersPath+"/{id}/replay",
+				replayDeadLetter)
 			router.With(forbidAPIKeyAuthentication, s.checkPerm(dataprovider.PermAdminManageAPIKeys)).
 				Get(apiKeysPath, getAPIKeys)
 			router.With(forbidAPIKeyAuthentication, s.checkPerm(dataprovider.PermAdminManageAPIKeys)).
@@ -1291,9 +1574,37 @@ func (s *httpdServer) initializeRouter() {
 			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Delete(eventActionsPath+"/{name}", deleteEventAction)
 			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Get(eventRulesPath, getEventRules)
 			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Get(eventRulesPath+"/{name}", getEventRuleByName)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Get(eventRulesPath+"/{name}/rollout", getEventRuleRolloutStatus)
 			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(eventRulesPath, addEventRule)
 			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Put(eventRulesPath+"/{name}", updateEventRule)
 			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Delete(eventRulesPath+"/{name}", deleteEventRule)
+			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(eventRulesPath+"/{name}/run", runOnDemandEventRule)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(emailTemplatesPath, getEmailTemplates)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(emailTemplatesPath+"/reload", reloadEmailTemplates)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(emailTemplatesPath+"/{name}", getEmailTemplateByName)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Put(emailTemplatesPath+"/{name}", saveEmailTemplate)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Delete(emailTemplatesPath+"/{name}", deleteEmailTemplate)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(smtpTestPath, testSMTPConfig)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(jobsPath, getJobs)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(jobsPath+"/{name}", getJobByName)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(jobsPath+"/{name}/run", runJob)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(jobsPath+"/{name}/pause", pauseJob)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(jobsPath+"/{name}/resume", resumeJob)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(hostKeysPath, getHostKeys)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(hostKeysPath, addHostKey)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(hostKeysPath+"/{id}/retire", retireHostKey)
+			router.With(s.checkPerm(dataprovider.PermAdminViewConnections)).Get(clientVersionsPath, getClientVersions)
+			router.With(s.checkPerm(dataprovider.PermAdminComplianceExport)).Get(complianceExportsPath, getComplianceExports)
+			router.With(s.checkPerm(dataprovider.PermAdminComplianceExport)).Post(complianceExportsPath, startComplianceExport)
+			router.With(s.checkPerm(dataprovider.PermAdminComplianceExport)).Get(complianceExportsPath+"/{id}", getComplianceExportByID)
+			router.With(s.checkPerm(dataprovider.PermAdminComplianceExport)).
+				Get(complianceExportsPath+"/{id}/download", downloadComplianceExport)
+			router.With(s.checkPerm(dataprovider.PermAdminComplianceExport)).
+				Delete(complianceExportsPath+"/{id}", deleteComplianceExport)
+			router.With(forbidAPIKeyAuthentication).Get(approvalsPath, listApprovals)
+			router.With(forbidAPIKeyAuthentication).Get(approvalsPath+"/{id}", getApproval)
+			router.With(forbidAPIKeyAuthentication).Post(approvalsPath+"/{id}/approve", confirmApproval)
+			router.With(forbidAPIKeyAuthentication).Delete(approvalsPath+"/{id}", rejectApproval)
 		})
 
 		s.router.Get(userTokenPath, s.getUserToken)
@@ -1307,7 +1618,14 @@ func (s *httpdServer) initializeRouter() {
 			router.With(forbidAPIKeyAuthentication, s.checkSecondFactorRequirement,
 				s.checkHTTPUserPerm(sdk.WebClientPasswordChangeDisabled)).Put(userPwdPath, changeUserPassword)
 			router.With(forbidAPIKeyAuthentication).Get(userProfilePath, getUserProfile)
+			router.Get(userFoldersQuotaPath, getCurrentUserFoldersQuota)
 			router.With(forbidAPIKeyAuthentication, s.checkSecondFactorRequirement).Put(userProfilePath, updateUserProfile)
+			router.With(forbidAPIKeyAuthentication, s.checkSecondFactorRequirement).
+				Post(userS3CredentialsPath, generateUserS3Credentials)
+			router.With(forbidAPIKeyAuthentication, s.checkSecondFactorRequirement).
+				Delete(userS3CredentialsPath, deleteUserS3Credentials)
+			router.With(forbidAPIKeyAuthentication, s.checkSecondFactorRequirement).
+				Post(userSSHCertificatePath, issueUserSSHCertificate)
 			// user TOTP APIs
 			router.With(forbidAPIKeyAuthentication, s.checkHTTPUserPerm(sdk.WebClientMFADisabled)).
 				Get(userTOTPConfigsPath, getTOTPConfigs)
@@ -1336,6 +1654,14 @@ func (s *httpdServer) initializeRouter() {
 				Patch(userFilesPath, renameUserFile)
 			router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
 				Delete(userFilesPath, deleteUserFile)
+			router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+				Post(userFilesCopyPath, copyUserFile)
+			router.With(s.checkSecondFactorRequirement).Get(userFilesRestorePath, getUserFileArchiveStatus)
+			router.With(s.checkSecondFactorRequirement).Post(userFilesRestorePath, restoreUserFile)
+			router.With(s.checkSecondFactorRequirement).Get(userFileVersionsPath, getUserFileVersions)
+			router.With(s.checkSecondFactorRequirement).Get(userFileVersionDownloadPath, getUserFileVersion)
+			router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+				Post(userFileVersionRestorePath, restoreUserFileVersion)
 			router.With(s.checkSecondFactorRequirement).Post(userStreamZipPath, getUserFilesAsZipStream)
 			router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled)).
 				Get(userSharesPath, getShares)
@@ -1351,6 +1677,21 @@ func (s *httpdServer) initializeRouter() {
 				Post(userUploadFilePath, uploadUserFile)
 			router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
 				Patch(userFilesDirsMetadataPath, setFileDirMetadata)
+			router.With(s.checkSecondFactorRequirement).Get(userFilesDirsMetadataPath, getFileDirMetadata)
+			router.With(s.checkSecondFactorRequirement).Get(userSpeedTestPath+"/download", userSpeedTestDownload)
+			router.With(s.checkSecondFactorRequirement).Post(userSpeedTestPath+"/upload", userSpeedTestUpload)
+			if tusConf.Enabled {
+				router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+					Options(userTUSPath, getTUSOptions)
+				router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+					Post(userTUSPath, createTUSUpload)
+				router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+					Head(userTUSPath+"/{id}", getTUSUploadOffset)
+				router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+					Patch(userTUSPath+"/{id}", patchTUSUpload)
+				router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+					Delete(userTUSPath+"/{id}", deleteTUSUpload)
+			}
 		})
 
 		if s.renderOpenAPI {
@@ -1369,6 +1710,10 @@ func (s *httpdServer) initializeRouter() {
 		if s.binding.OIDC.isEnabled() {
 			s.router.Get(webOIDCRedirectPath, s.handleOIDCRedirect)
 		}
+		if s.binding.SAML.isEnabled() {
+			s.router.Get(webSAMLMetadataPath, s.handleSAMLMetadata)
+			s.router.Post(webSAMLACSPath, s.handleSAMLACS)
+		}
 		if s.enableWebClient {
 			s.router.Get(webRootPath, func(w http.ResponseWriter, r *http.Request) {
 				r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
@@ -1404,24 +1749,41 @@ func (s *httpdServer) setupWebClientRoutes() {
 		if s.binding.OIDC.isEnabled() && !s.binding.isWebClientOIDCLoginDisabled() {
 			s.router.Get(webClientOIDCLoginPath, s.handleWebClientOIDCLogin)
 		}
+		if s.binding.SAML.isEnabled() && !s.binding.isWebClientOIDCLoginDisabled() {
+			s.router.Get(webClientSAMLLoginPath, s.handleWebClientSAMLLogin)
+		}
 		if !s.binding.isWebClientLoginFormDisabled() {
 			s.router.Post(webClientLoginPath, s.handleWebClientLoginPost)
 			s.router.Get(webClientForgotPwdPath, s.handleWebClientForgotPwd)
 			s.router.Post(webClientForgotPwdPath, s.handleWebClientForgotPwdPost)
 			s.router.Get(webClientResetPwdPath, s.handleWebClientPasswordReset)
 			s.router.Post(webClientResetPwdPath, s.handleWebClientPasswordResetPost)
+		}
+		if s.binding.MagicLink.Enabled {
+			s.router.Get(webClientMagicLinkPath, s.handleWebClientMagicLink)
+			s.router.Post(webClientMagicLinkPath, s.handleWebClientMagicLinkPost)
+			s.router.Get(webClientMagicLinkLoginPath, s.handleWebClientMagicLinkLogin)
+			s.router.Post(webClientMagicLinkLoginPath, s.handleWebClientMagicLinkLoginPost)
+		}
+		if !s.binding.isWebClientLoginFormDisabled() || s.binding.MagicLink.Enabled {
 			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
 				s.jwtAuthenticatorPartial(tokenAudienceWebClientPartial)).
 				Get(webClientTwoFactorPath, s.handleWebClientTwoFactor)
 			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
 				s.jwtAuthenticatorPartial(tokenAudienceWebClientPartial)).
 				Post(webClientTwoFactorPath, s.handleWebClientTwoFactorPost)
+			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
+				s.jwtAuthenticatorPartial(tokenAudienceWebClientPartial), verifyCSRFHeader).
+				Post(webClientTwoFactorWebAuthnPath, s.handleWebClientTwoFactorWebAuthn)
 			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
 				s.jwtAuthenticatorPartial(tokenAudienceWebClientPartial)).
 				Get(webClientTwoFactorRecoveryPath, s.handleWebClientTwoFactorRecovery)
 			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
 				s.jwtAuthenticatorPartial(tokenAudienceWebClientPartial)).
 				Post(webClientTwoFactorRecoveryPath, s.handleWebClientTwoFactorRecoveryPost)
+			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
+				s.jwtAuthenticatorPartial(tokenAudienceWebClientPartial)).
+				Post(webClientRecoveryCodesEmailPath, s.handleWebClientRecoveryCodesEmailPost)
 		}
 		// share routes exposed to external users
 		s.router.Get(webClientPubSharesPath+"/{id}", s.downloadFromShare)
@@ -1444,11 +1806,29 @@ func (s *httpdServer) setupWebClientRoutes() {
 			router.With(s.checkSecondFactorRequirement, s.refreshCookie).Get(webClientViewPDFPath, s.handleClientViewPDF)
 			router.With(s.checkSecondFactorRequirement, s.refreshCookie).Get(webClientGetPDFPath, s.handleClientGetPDF)
 			router.With(s.checkSecondFactorRequirement, s.refreshCookie, verifyCSRFHeader).Get(webClientFilePath, getUserFile)
+			router.With(s.checkSecondFactorRequirement, verifyCSRFHeader).Post(webClientFileRestorePath, restoreUserFile)
+			router.With(s.checkSecondFactorRequirement, verifyCSRFHeader).Get(webClientFileVersionsPath, getUserFileVersions)
+			router.With(s.checkSecondFactorRequirement, s.refreshCookie).
+				Get(webClientFileVersionDownload, getUserFileVersion)
+			router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), verifyCSRFHeader).
+				Post(webClientFileVersionRestore, restoreUserFileVersion)
 			router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), verifyCSRFHeader).
 				Post(webClientFilePath, uploadUserFile)
 			router.With(s.checkSecondFactorRequirement, s.refreshCookie).Get(webClientEditFilePath, s.handleClientEditFile)
+			if tusConf.Enabled {
+				router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), verifyCSRFHeader).
+					Post(webClientTUSPath, createTUSUpload)
+				router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), verifyCSRFHeader).
+					Head(webClientTUSPath+"/{id}", getTUSUploadOffset)
+				router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), verifyCSRFHeader).
+					Patch(webClientTUSPath+"/{id}", patchTUSUpload)
+				router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), verifyCSRFHeader).
+					Delete(webClientTUSPath+"/{id}", deleteTUSUpload)
+			}
 			router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), verifyCSRFHeader).
 				Patch(webClientFilesPath, renameUserFile)
+			router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), verifyCSRFHeader).
+				Post(webClientFileCopyPath, copyUserFile)
 			router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), verifyCSRFHeader).
 				Delete(webClientFilesPath, deleteUserFile)
 			router.With(s.checkSecondFactorRequirement, compressor.Handler, s.refreshCookie).
@@ -1480,6 +1860,12 @@ func (s *httpdServer) setupWebClientRoutes() {
 				Get(webClientRecoveryCodesPath, getRecoveryCodes)
 			router.With(s.checkHTTPUserPerm(sdk.WebClientMFADisabled), verifyCSRFHeader).
 				Post(webClientRecoveryCodesPath, generateRecoveryCodes)
+			router.With(s.checkHTTPUserPerm(sdk.WebClientMFADisabled), verifyCSRFHeader).
+				Post(webClientWebAuthnPath+"/register", beginWebAuthnRegistration)
+			router.With(s.checkHTTPUserPerm(sdk.WebClientMFADisabled), verifyCSRFHeader).
+				Post(webClientWebAuthnPath+"/register/finish", finishWebAuthnRegistration)
+			router.With(s.checkHTTPUserPerm(sdk.WebClientMFADisabled), verifyCSRFHeader).
+				Delete(webClientWebAuthnPath+"/{id}", deleteWebAuthnCredential)
 			router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled), s.refreshCookie).
 				Get(webClientSharesPath, s.handleClientGetShares)
 			router.With(s.checkSecondFactorRequirement, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled), s.refreshCookie).
@@ -1506,6 +1892,9 @@ func (s *httpdServer) setupWebAdminRoutes() {
 		if s.binding.OIDC.hasRoles() && !s.binding.isWebAdminOIDCLoginDisabled() {
 			s.router.Get(webAdminOIDCLoginPath, s.handleWebAdminOIDCLogin)
 		}
+		if s.binding.SAML.hasRoles() && !s.binding.isWebAdminOIDCLoginDisabled() {
+			s.router.Get(webAdminSAMLLoginPath, s.handleWebAdminSAMLLogin)
+		}
 		s.router.Get(webAdminSetupPath, s.handleWebAdminSetupGet)
 		s.router.Post(webAdminSetupPath, s.handleWebAdminSetupPost)
 		if !s.binding.isWebAdminLoginFormDisabled() {
@@ -1516,6 +1905,9 @@ func (s *httpdServer) setupWebAdminRoutes() {
 			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
 				s.jwtAuthenticatorPartial(tokenAudienceWebAdminPartial)).
 				Post(webAdminTwoFactorPath, s.handleWebAdminTwoFactorPost)
+			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
+				s.jwtAuthenticatorPartial(tokenAudienceWebAdminPartial), verifyCSRFHeader).
+				Post(webAdminTwoFactorWebAuthnPath, s.handleWebAdminTwoFactorWebAuthn)
 			s.router.With(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie),
 				s.jwtAuthenticatorPartial(tokenAudienceWebAdminPartial)).
 				Get(webAdminTwoFactorRecoveryPath, s.handleWebAdminTwoFactorRecovery)
@@ -1548,6 +1940,12 @@ func (s *httpdServer) setupWebAdminRoutes() {
 			router.With(verifyCSRFHeader, s.requireBuiltinLogin, s.refreshCookie).Get(webAdminRecoveryCodesPath,
 				getRecoveryCodes)
 			router.With(verifyCSRFHeader, s.requireBuiltinLogin).Post(webAdminRecoveryCodesPath, generateRecoveryCodes)
+			router.With(verifyCSRFHeader, s.requireBuiltinLogin).Post(webAdminWebAuthnPath+"/register",
+				beginWebAuthnRegistration)
+			router.With(verifyCSRFHeader, s.requireBuiltinLogin).Post(webAdminWebAuthnPath+"/register/finish",
+				finishWebAuthnRegistration)
+			router.With(verifyCSRFHeader, s.requireBuiltinLogin).Delete(webAdminWebAuthnPath+"/{id}",
+				deleteWebAuthnCredential)
 
 			router.With(s.checkPerm(dataprovider.PermAdminViewUsers), s.refreshCookie).
 				Get(webUsersPath, s.handleGetWebUsers)
@@ -1585,6 +1983,8 @@ func (s *httpdServer) setupWebAdminRoutes() {
 			router.With(s.checkPerm(dataprovider.PermAdminManageAdmins), s.refreshCookie).
 				Get(webAdminPath+"/{username}", s.handleWebUpdateAdminGet)
 			router.With(s.checkPerm(dataprovider.PermAdminManageAdmins)).Post(webAdminPath, s.handleWebAddAdminPost)
+			router.With(s.checkPerm(dataprovider.PermAdminManageAPIKeys), s.refreshCookie).
+				Get(webAPIKeysPath, s.handleGetWebAPIKeys)
 			router.With(s.checkPerm(dataprovider.PermAdminManageAdmins)).Post(webAdminPath+"/{username}",
 				s.handleWebUpdateAdminPost)
 			router.With(s.checkPerm(dataprovider.PermAdminManageAdmins), verifyCSRFHeader).
@@ -1597,15 +1997,25 @@ func (s *httpdServer) setupWebAdminRoutes() {
 				s.handleWebUpdateFolderPost)
 			router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers), verifyCSRFHeader).
 				Delete(webFolderPath+"/{name}", deleteFolder)
+			router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers), verifyCSRFHeader).
+				Post(webFolderPath+"/{name}/restore", restoreFolder)
 			router.With(s.checkPerm(dataprovider.PermAdminQuotaScans), verifyCSRFHeader).
 				Post(webScanVFolderPath+"/{name}", startFolderQuotaScan)
 			router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers), verifyCSRFHeader).
 				Delete(webUserPath+"/{username}", deleteUser)
+			router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers), verifyCSRFHeader).
+				Post(webUserPath+"/{username}/restore", restoreUser)
+			if s.enableWebClient {
+				router.With(s.checkPerm(dataprovider.PermAdminImpersonateUsers), verifyCSRFHeader).
+					Post(webUserPath+"/{username}/impersonate", s.handleWebImpersonateUser)
+			}
 			router.With(s.checkPerm(dataprovider.PermAdminQuotaScans), verifyCSRFHeader).
 				Post(webQuotaScanPath+"/{username}", startUserQuotaScan)
 			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(webMaintenancePath, s.handleWebMaintenance)
-			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(webBackupPath, dumpData)
+			router.With(s.checkPerm(dataprovider.PermAdminExportData)).Get(webBackupPath, dumpData)
 			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(webRestorePath, s.handleWebRestore)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem), verifyCSRFHeader).
+				Post(webAdminSMTPTestPath, testSMTPConfig)
 			router.With(s.checkPerm(dataprovider.PermAdminManageSystem), s.refreshCookie).
 				Get(webTemplateUser, s.handleWebTemplateUserGet)
 			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(webTemplateUser, s.handleWebTemplateUserPost)
@@ -1616,6 +2026,10 @@ func (s *httpdServer) setupWebAdminRoutes() {
 			router.With(s.checkPerm(dataprovider.PermAdminViewDefender)).Get(webDefenderHostsPath, getDefenderHosts)
 			router.With(s.checkPerm(dataprovider.PermAdminManageDefender)).Delete(webDefenderHostsPath+"/{id}",
 				deleteDefenderHostByID)
+			router.With(s.refreshCookie).Get(webApprovalsPath, s.handleWebApprovalsPage)
+			router.Get(webApprovalsRequestsPath, listApprovals)
+			router.With(verifyCSRFHeader).Post(webApprovalsRequestsPath+"/{id}/approve", confirmApproval)
+			router.With(verifyCSRFHeader).Delete(webApprovalsRequestsPath+"/{id}", rejectApproval)
 			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.refreshCookie).
 				Get(webAdminEventActionsPath, s.handleWebGetEventActions)
 			router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.refreshCookie).
@@ -0,0 +1,694 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	tusVersion             = "1.0.0"
+	tusExtensions          = "creation,creation-with-upload,termination,expiration"
+	tusContentType         = "application/offset+octet-stream"
+	tusResumableHeader     = "Tus-Resumable"
+	tusVersionHeader       = "Tus-Version"
+	tusExtensionHeader     = "Tus-Extension"
+	tusMaxSizeHeader       = "Tus-Max-Size"
+	tusUploadOffsetHeader  = "Upload-Offset"
+	tusUploadLengthHeader  = "Upload-Length"
+	tusUploadMetaHeader    = "Upload-Metadata"
+	tusUploadExpiresHeader = "Upload-Expires"
+	tusStagingDirName      = "tus"
+	tusSessionKeyPrefix    = "tus_upload_"
+)
+
+var (
+	tusConf TUSConfig
+	tusMgr  *tusManager
+)
+
+// TUSConfig defines the configuration for the tus resumable upload protocol,
+// see https://tus.io/protocols/resumable-upload.html
+type TUSConfig struct {
+	// Set to true to enable the tus endpoints for the REST API and the WebClient
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Time, in minutes, after which an incomplete upload expires and its data is removed.
+	// The expiration is refreshed each time new data is received
+	ExpirationTime int `json:"expiration_time" mapstructure:"expiration_time"`
+	// Absolute path to the directory where the received data is staged, inside a "tus"
+	// subdirectory. If empty the configured temp path, or the system temporary directory,
+	// is used. If the data provider is shared, this directory must be on a storage shared
+	// among all the SFTPGo instances so an upload can be resumed on any of them
+	StagingPath string `json:"staging_path" mapstructure:"staging_path"`
+}
+
+func (c *TUSConfig) validate() error {
+	if c.Enabled && c.ExpirationTime <= 0 {
+		return fmt.Errorf("invalid tus expiration time: %d", c.ExpirationTime)
+	}
+	if c.StagingPath != "" && !filepath.IsAbs(c.StagingPath) {
+		return fmt.Errorf("invalid tus staging path %q, it must be an absolute path", c.StagingPath)
+	}
+	return nil
+}
+
+func (c *TUSConfig) getExpiration() time.Duration {
+	return time.Duration(c.ExpirationTime) * time.Minute
+}
+
+// tusUpload defines an incomplete upload. The received data is staged in a
+// file and it is written to the user's filesystem, as a normal upload,
+// when the upload is complete, so quota, permissions and event hooks apply
+type tusUpload struct {
+	sync.Mutex
+	ID           string    `json:"id"`
+	Username     string    `json:"username"`
+	Path         string    `json:"path"`
+	Size         int64     `json:"size"`
+	Offset       int64     `json:"offset"`
+	Metadata     string    `json:"metadata,omitempty"`
+	MkdirParents bool      `json:"mkdir_parents,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	stagingPath  string
+}
+
+func (u *tusUpload) isExpired() bool {
+	return time.Now().After(u.ExpiresAt)
+}
+
+func (u *tusUpload) getExpiresHeader() string {
+	return u.ExpiresAt.UTC().Format(http.TimeFormat)
+}
+
+// tusManager stores the incomplete uploads. If the data provider is not shared
+// they are kept in memory so they are bound to the SFTPGo instance that received
+// them, otherwise the upload state is stored within the data provider, as a
+// shared session, and the in memory uploads are only used to serialize the
+// requests for the same upload received by this instance
+type tusManager struct {
+	mu         sync.RWMutex
+	uploads    map[string]*tusUpload
+	stagingDir string
+	isShared   bool
+}
+
+func newTUSManager(baseDir string, isShared bool) (*tusManager, error) {
+	stagingDir := filepath.Join(baseDir, tusStagingDirName)
+	if !isShared {
+		// the uploads are not persisted, remove any leftover from a previous run
+		if err := os.RemoveAll(stagingDir); err != nil {
+			return nil, fmt.Errorf("unable to remove the tus staging directory: %w", err)
+		}
+	}
+	if err := os.MkdirAll(stagingDir, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create the tus staging directory: %w", err)
+	}
+	return &tusManager{
+		uploads:    make(map[string]*tusUpload),
+		stagingDir: stagingDir,
+		isShared:   isShared,
+	}, nil
+}
+
+func (m *tusManager) getSessionKey(id string) string {
+	return tusSessionKeyPrefix + id
+}
+
+func (m *tusManager) add(upload *tusUpload) error {
+	upload.stagingPath = filepath.Join(m.stagingDir, upload.ID)
+	f, err := os.OpenFile(upload.stagingPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(upload.stagingPath) //nolint:errcheck
+		return err
+	}
+	if err := m.update(upload); err != nil {
+		os.Remove(upload.stagingPath) //nolint:errcheck
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.uploads[upload.ID] = upload
+	return nil
+}
+
+// update persists the upload state, it is a no-op if the data provider is not shared.
+// It must be called with the upload locked
+func (m *tusManager) update(upload *tusUpload) error {
+	if !m.isShared {
+		return nil
+	}
+	return dataprovider.AddSharedSession(dataprovider.Session{
+		Key:       m.getSessionKey(upload.ID),
+		Data:      upload,
+		Type:      dataprovider.SessionTypeTUSUpload,
+		Timestamp: util.GetTimeAsMsSinceEpoch(upload.ExpiresAt),
+	})
+}
+
+// load returns the upload state stored within the data provider
+func (m *tusManager) load(id string) (*tusUpload, error) {
+	session, err := dataprovider.GetSharedSession(m.getSessionKey(id))
+	if err != nil {
+		return nil, err
+	}
+	if session.Type != dataprovider.SessionTypeTUSUpload {
+		return nil, fmt.Errorf("unexpected session type for tus upload: %v", session.Type)
+	}
+	data, ok := session.Data.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("invalid tus upload data type %T", session.Data)
+	}
+	upload := &tusUpload{}
+	if err := json.Unmarshal(data, upload); err != nil {
+		return nil, err
+	}
+	upload.stagingPath = filepath.Join(m.stagingDir, upload.ID)
+	return upload, nil
+}
+
+// get returns the upload with the specified ID if it belongs to the specified user
+func (m *tusManager) get(id, username string) (*tusUpload, error) {
+	if m.isShared {
+		return m.getShared(id, username)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	upload, ok := m.uploads[id]
+	if !ok || upload.Username != username || upload.isExpired() {
+		return nil, util.NewRecordNotFoundError(fmt.Sprintf("upload %q not found", id))
+	}
+	return upload, nil
+}
+
+// getShared returns the upload with the specified ID loading its state from the data
+// provider, the upload could be created or updated by another SFTPGo instance
+func (m *tusManager) getShared(id, username string) (*tusUpload, error) {
+	state, err := m.load(id)
+	if err != nil || state.Username != username || state.isExpired() {
+		if err != nil && !isSharedSessionNotFound(err) {
+			logger.Warn(logSender, "", "unable to load tus upload %q: %v", id, err)
+		} else if err != nil || state.isExpired() {
+			// the upload was completed, terminated or it is expired
+			m.mu.Lock()
+			delete(m.uploads, id)
+			m.mu.Unlock()
+		}
+		return nil, util.NewRecordNotFoundError(fmt.Sprintf("upload %q not found", id))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, ok := m.uploads[id]
+	if !ok {
+		if _, err := os.Stat(state.stagingPath); err != nil {
+			logger.Warn(logSender, "", "unable to stat the staging file for tus upload %q, is the staging "+
+				"path shared among all the instances? %v", id, err)
+			return nil, util.NewRecordNotFoundError(fmt.Sprintf("upload %q not found", id))
+		}
+		m.uploads[id] = state
+		return state, nil
+	}
+	// if the upload is locked a request for it is in progress on this instance and
+	// the in memory state is the most recent one
+	if upload.TryLock() {
+		upload.Offset = state.Offset
+		upload.ExpiresAt = state.ExpiresAt
+		upload.Unlock()
+	}
+	return upload, nil
+}
+
+func (m *tusManager) remove(id string) {
+	m.mu.Lock()
+	upload, ok := m.uploads[id]
+	delete(m.uploads, id)
+	m.mu.Unlock()
+
+	if m.isShared {
+		err := dataprovider.DeleteSharedSession(m.getSessionKey(id))
+		if err != nil && !isSharedSessionNotFound(err) {
+			logger.Warn(logSender, "", "unable to remove the state for tus upload %q: %v", id, err)
+		}
+		if !ok {
+			upload = &tusUpload{stagingPath: filepath.Join(m.stagingDir, id)}
+			ok = true
+		}
+	}
+	if ok {
+		if err := os.Remove(upload.stagingPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Warn(logSender, "", "unable to remove staging file for tus upload %q: %v", id, err)
+		}
+	}
+}
+
+func (m *tusManager) cleanup() {
+	var expired []string
+
+	m.mu.RLock()
+	for id, upload := range m.uploads {
+		if upload.isExpired() {
+			expired = append(expired, id)
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, id := range expired {
+		if m.isShared {
+			// the upload could be resumed on another instance, just forget about it
+			// and let the staging directory scan check the shared state
+			m.mu.Lock()
+			delete(m.uploads, id)
+			m.mu.Unlock()
+			continue
+		}
+		logger.Debug(logSender, "", "removing expired tus upload %q", id)
+		m.remove(id)
+	}
+	if m.isShared {
+		m.cleanupShared()
+	}
+}
+
+// cleanupShared removes the staging files for the expired uploads, whatever SFTPGo instance
+// received them, and then the expired states, so no staging file is left behind
+func (m *tusManager) cleanupShared() {
+	entries, err := os.ReadDir(m.stagingDir)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to read the tus staging directory %q: %v", m.stagingDir, err)
+		return
+	}
+	// the expiration is refreshed each time new data is received, so files modified after
+	// this time cannot be expired
+	limit := time.Now().Add(-tusConf.getExpiration())
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(limit) {
+			continue
+		}
+		id := entry.Name()
+		state, err := m.load(id)
+		if err == nil && !state.isExpired() {
+			continue
+		}
+		if err != nil && !isSharedSessionNotFound(err) {
+			logger.Warn(logSender, "", "unable to load tus upload %q: %v", id, err)
+			continue
+		}
+		logger.Debug(logSender, "", "removing expired tus upload %q", id)
+		m.remove(id)
+	}
+	dataprovider.CleanupSharedSessions(dataprovider.SessionTypeTUSUpload, time.Now()) //nolint:errcheck
+}
+
+func (m *tusManager) count() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.uploads)
+}
+
+func getTUSStagingBaseDir() string {
+	if tusConf.StagingPath != "" {
+		return tusConf.StagingPath
+	}
+	if tempPath := vfs.GetTempPath(); tempPath != "" {
+		return tempPath
+	}
+	return os.TempDir()
+}
+
+func isSharedSessionNotFound(err error) bool {
+	if errors.Is(err, sql.ErrNoRows) {
+		return true
+	}
+	_, ok := err.(*util.RecordNotFoundError)
+	return ok
+}
+
+// parseTUSMetadata parses the Upload-Metadata header, it is a comma separated list
+// of key and base64 encoded value pairs, the value is optional
+func parseTUSMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("invalid upload metadata: empty key")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload metadata for key %q: %w", key, err)
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
+
+func setTUSResponseHeaders(w http.ResponseWriter) {
+	w.Header().Set(tusResumableHeader, tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func checkTUSResumableHeader(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get(tusResumableHeader) != tusVersion {
+		w.Header().Set(tusVersionHeader, tusVersion)
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Unsupported %s version", tusResumableHeader), http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func getTUSUploadURL(r *http.Request, id string) string {
+	if strings.HasPrefix(r.URL.Path, webClientTUSPath) {
+		return path.Join(webClientTUSPath, id)
+	}
+	return path.Join(userTUSPath, id)
+}
+
+func getWebClientTUSURL() string {
+	if tusConf.Enabled {
+		return webClientTUSPath
+	}
+	return ""
+}
+
+func getTUSOptions(w http.ResponseWriter, r *http.Request) {
+	setTUSResponseHeaders(w)
+	w.Header().Set(tusVersionHeader, tusVersion)
+	w.Header().Set(tusExtensionHeader, tusExtensions)
+	if maxUploadFileSize > 0 {
+		w.Header().Set(tusMaxSizeHeader, strconv.FormatInt(maxUploadFileSize, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkTUSUploadAllowed performs the checks that can be done before the upload starts,
+// they are repeated when the file is written to the user's filesystem
+func checkTUSUploadAllowed(connection *Connection, filePath string, size int64) (int, error) {
+	if ok, _ := connection.User.IsFileAllowed(filePath); !ok {
+		return http.StatusForbidden, connection.GetPermissionDeniedError()
+	}
+	if !connection.User.HasAnyPerm([]string{dataprovider.PermUpload, dataprovider.PermOverwrite}, path.Dir(filePath)) {
+		return http.StatusForbidden, connection.GetPermissionDeniedError()
+	}
+	if maxUploadFileSize > 0 && size > maxUploadFileSize {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("the upload size exceeds the maximum allowed size: %d",
+			maxUploadFileSize)
+	}
+	if maxSize := connection.User.GetMaxUploadFileSize(filePath); maxSize > 0 && size > maxSize {
+		return http.StatusRequestEntityTooLarge, common.ErrQuotaExceeded
+	}
+	diskQuota, transferQuota := connection.HasSpace(true, false, filePath)
+	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
+		return http.StatusRequestEntityTooLarge, common.ErrQuotaExceeded
+	}
+	if diskQuota.QuotaSize > 0 && size > diskQuota.GetRemainingSize() {
+		return http.StatusRequestEntityTooLarge, common.ErrQuotaExceeded
+	}
+	return 0, nil
+}
+
+func createTUSUpload(w http.ResponseWriter, r *http.Request) {
+	setTUSResponseHeaders(w)
+	if !checkTUSResumableHeader(w, r) {
+		return
+	}
+	size, err := strconv.ParseInt(r.Header.Get(tusUploadLengthHeader), 10, 64)
+	if err != nil || size < 0 {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Invalid or missing %s header", tusUploadLengthHeader),
+			http.StatusBadRequest)
+		return
+	}
+	metadata, err := parseTUSMetadata(r.Header.Get(tusUploadMetaHeader))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	filePath := r.URL.Query().Get("path")
+	if filePath == "" {
+		filePath = metadata["path"]
+	}
+	if filePath == "" {
+		sendAPIResponse(w, r, errors.New("please set a file path"), "", http.StatusBadRequest)
+		return
+	}
+
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	filePath = connection.User.GetCleanedPath(filePath)
+	if filePath == "/" {
+		sendAPIResponse(w, r, nil, "Please set the path to a valid file", http.StatusBadRequest)
+		return
+	}
+	if status, err := checkTUSUploadAllowed(connection, filePath, size); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to upload file %q", filePath), status)
+		return
+	}
+	upload := &tusUpload{
+		ID:           util.GenerateUniqueID(),
+		Username:     connection.User.Username,
+		Path:         filePath,
+		Size:         size,
+		Metadata:     r.Header.Get(tusUploadMetaHeader),
+		MkdirParents: getBoolQueryParam(r, "mkdir_parents") || metadata["mkdir_parents"] == "true",
+		ExpiresAt:    time.Now().Add(tusConf.getExpiration()),
+	}
+	if err := tusMgr.add(upload); err != nil {
+		connection.Log(logger.LevelError, "unable to create tus upload for file %q: %v", filePath, err)
+		sendAPIResponse(w, r, err, "Unable to create the upload", http.StatusInternalServerError)
+		return
+	}
+	connection.Log(logger.LevelDebug, "tus upload %q created for file %q, size: %d", upload.ID, filePath, size)
+
+	w.Header().Set("Location", getTUSUploadURL(r, upload.ID))
+	w.Header().Set(tusUploadExpiresHeader, upload.getExpiresHeader())
+	if r.Header.Get("Content-Type") == tusContentType || size == 0 {
+		// creation-with-upload, the request body may contain the first chunk
+		upload.Lock()
+		defer upload.Unlock()
+
+		if status, err := appendTUSUploadData(w, r, connection, upload); err != nil {
+			sendAPIResponse(w, r, err, "Unable to save the upload data", status)
+			return
+		}
+		w.Header().Set(tusUploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func getTUSUploadConnection(w http.ResponseWriter, r *http.Request) (*Connection, *tusUpload, error) {
+	setTUSResponseHeaders(w)
+	if !checkTUSResumableHeader(w, r) {
+		return nil, nil, errors.New("unsupported tus version")
+	}
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	upload, err := tusMgr.get(getURLParam(r, "id"), connection.User.Username)
+	if err != nil {
+		common.Connections.Remove(connection.GetID())
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return nil, nil, err
+	}
+	return connection, upload, nil
+}
+
+func getTUSUploadOffset(w http.ResponseWriter, r *http.Request) {
+	connection, upload, err := getTUSUploadConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	upload.Lock()
+	defer upload.Unlock()
+
+	w.Header().Set(tusUploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set(tusUploadLengthHeader, strconv.FormatInt(upload.Size, 10))
+	w.Header().Set(tusUploadExpiresHeader, upload.getExpiresHeader())
+	if upload.Metadata != "" {
+		w.Header().Set(tusUploadMetaHeader, upload.Metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func patchTUSUpload(w http.ResponseWriter, r *http.Request) {
+	connection, upload, err := getTUSUploadConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	if r.Header.Get("Content-Type") != tusContentType {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Content-Type must be %q", tusContentType),
+			http.StatusUnsupportedMediaType)
+		return
+	}
+	if !upload.TryLock() {
+		sendAPIResponse(w, r, nil, "The upload is in progress in another request", http.StatusLocked)
+		return
+	}
+	defer upload.Unlock()
+
+	offset, err := strconv.ParseInt(r.Header.Get(tusUploadOffsetHeader), 10, 64)
+	if err != nil || offset != upload.Offset {
+		w.Header().Set(tusUploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+		sendAPIResponse(w, r, err, fmt.Sprintf("The %s header does not match the current offset %d",
+			tusUploadOffsetHeader, upload.Offset), http.StatusConflict)
+		return
+	}
+	status, err := appendTUSUploadData(w, r, connection, upload)
+	w.Header().Set(tusUploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set(tusUploadExpiresHeader, upload.getExpiresHeader())
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to save the upload data", status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deleteTUSUpload(w http.ResponseWriter, r *http.Request) {
+	connection, upload, err := getTUSUploadConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	if !upload.TryLock() {
+		sendAPIResponse(w, r, nil, "The upload is in progress in another request", http.StatusLocked)
+		return
+	}
+	defer upload.Unlock()
+
+	tusMgr.remove(upload.ID)
+	connection.Log(logger.LevelDebug, "tus upload %q for file %q terminated", upload.ID, upload.Path)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// appendTUSUploadData appends the request body to the staged data and writes the file
+// to the user's filesystem if the upload is complete. It must be called with the
+// upload locked
+func appendTUSUploadData(w http.ResponseWriter, r *http.Request, connection *Connection, upload *tusUpload) (int, error) {
+	connection.UpdateLastActivity()
+
+	f, err := os.OpenFile(upload.stagingPath, os.O_WRONLY, 0600)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	// the data is written at the committed offset and not appended: any data after the
+	// offset, for example a chunk interrupted while another SFTPGo instance was writing
+	// it, is overwritten by the new chunk
+	if _, err := f.Seek(upload.Offset, io.SeekStart); err != nil {
+		f.Close() //nolint:errcheck
+		return http.StatusInternalServerError, err
+	}
+	// the client can send at most the remaining bytes
+	n, err := io.Copy(f, http.MaxBytesReader(w, r.Body, upload.Size-upload.Offset))
+	upload.ExpiresAt = time.Now().Add(tusConf.getExpiration())
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		// discard the whole chunk
+		f.Close() //nolint:errcheck
+		if errUpdate := tusMgr.update(upload); errUpdate != nil {
+			return http.StatusInternalServerError, errUpdate
+		}
+		return http.StatusRequestEntityTooLarge, errors.New("the request body exceeds the upload size")
+	}
+	upload.Offset += n
+	errClose := f.Close()
+	if errClose == nil {
+		errClose = tusMgr.update(upload)
+	}
+	if err != nil {
+		// the received bytes are saved, the client can resume from the new offset
+		connection.Log(logger.LevelDebug, "tus upload %q interrupted at offset %d: %v", upload.ID, upload.Offset, err)
+		return http.StatusBadRequest, err
+	}
+	if errClose != nil {
+		return http.StatusInternalServerError, errClose
+	}
+	if upload.Offset < upload.Size {
+		return 0, nil
+	}
+	defer tusMgr.remove(upload.ID)
+
+	return completeTUSUpload(r, connection, upload)
+}
+
+func completeTUSUpload(r *http.Request, connection *Connection, upload *tusUpload) (int, error) {
+	if upload.MkdirParents {
+		if err := connection.CheckParentDirs(path.Dir(upload.Path)); err != nil {
+			return getMappedStatusCode(err), err
+		}
+	}
+	f, err := os.Open(upload.stagingPath)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer f.Close()
+
+	connection.User.CheckFsRoot(connection.ID) //nolint:errcheck
+	writer, err := connection.getFileWriter(upload.Path, upload.Size)
+	if err != nil {
+		return getMappedStatusCode(err), fmt.Errorf("unable to write file %q: %w", upload.Path, err)
+	}
+	if _, err := io.Copy(writer, f); err != nil {
+		writer.Close() //nolint:errcheck
+		return getMappedStatusCode(err), fmt.Errorf("error saving file %q: %w", upload.Path, err)
+	}
+	if err := writer.Close(); err != nil {
+		return getMappedStatusCode(err), fmt.Errorf("error closing file %q: %w", upload.Path, err)
+	}
+	setModificationTimeFromHeader(r, connection, upload.Path)
+	connection.Log(logger.LevelDebug, "tus upload %q completed for file %q", upload.ID, upload.Path)
+	return 0, nil
+}
@@ -0,0 +1,138 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+// seconds to wait, as suggested to the clients, before retrying an upload
+// rejected because the uploads memory budget is exhausted
+const uploadsRetryAfter = 5
+
+var (
+	uploadsConf   UploadsConfig
+	uploadsBudget uploadsMemoryBudget
+
+	errUploadsMemoryBudget  = errors.New("the memory budget for uploads is exhausted, please retry later")
+	errUploadsResourceLimit = errors.New("the server resource limits are exceeded, please retry later")
+)
+
+// UploadsConfig defines the memory limits for the uploads via the REST API,
+// the WebClient and the public shares. The received data are copied to the
+// storage backend using a buffer of limited size, so a slow backend cannot
+// cause the whole request body to be held in memory
+type UploadsConfig struct {
+	// Size, as KB, of the buffer used by each connection to copy the received
+	// data to the storage backend
+	BufferSize int64 `json:"buffer_size" mapstructure:"buffer_size"`
+	// Max memory, as MB, that can be used for the upload buffers of all the
+	// connections. New uploads are rejected with a 429 status code while the
+	// budget is exhausted. 0 means no limit
+	MemoryBudget int64 `json:"memory_budget" mapstructure:"memory_budget"`
+}
+
+func (c *UploadsConfig) validate() error {
+	if c.BufferSize <= 0 {
+		return fmt.Errorf("invalid uploads buffer size: %d", c.BufferSize)
+	}
+	if c.MemoryBudget < 0 {
+		return fmt.Errorf("invalid uploads memory budget: %d", c.MemoryBudget)
+	}
+	if c.MemoryBudget > 0 && c.getMemoryBudget() < c.getBufferSize() {
+		return fmt.Errorf("the uploads memory budget %d MB is smaller than the buffer size %d KB",
+			c.MemoryBudget, c.BufferSize)
+	}
+	return nil
+}
+
+func (c *UploadsConfig) getBufferSize() int64 {
+	return c.BufferSize * 1024
+}
+
+func (c *UploadsConfig) getMemoryBudget() int64 {
+	return c.MemoryBudget * 1024 * 1024
+}
+
+// uploadsMemoryBudget tracks the memory used for the upload buffers
+type uploadsMemoryBudget struct {
+	mu    sync.Mutex
+	limit int64
+	used  int64
+}
+
+func (b *uploadsMemoryBudget) setLimit(limit int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.limit = limit
+}
+
+func (b *uploadsMemoryBudget) reserve(size int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.limit > 0 && b.used+size > b.limit {
+		return false
+	}
+	b.used += size
+	return true
+}
+
+func (b *uploadsMemoryBudget) release(size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.used -= size
+	if b.used < 0 {
+		b.used = 0
+	}
+}
+
+func (b *uploadsMemoryBudget) getUsed() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.used
+}
+
+// getUploadBuffer reserves the memory for an upload buffer and returns the
+// buffer and a function to call to release it once the upload is complete.
+// If the memory cannot be reserved an error response is sent and an error
+// is returned
+func getUploadBuffer(w http.ResponseWriter, r *http.Request) ([]byte, func(), error) {
+	if common.AreResourceLimitsExceeded() {
+		logger.Debug(logSender, "", "upload from %q rejected, resource limits exceeded", r.RemoteAddr)
+		sendAPIResponse(w, r, errUploadsResourceLimit, "", http.StatusInsufficientStorage)
+		return nil, nil, errUploadsResourceLimit
+	}
+	size := uploadsConf.getBufferSize()
+	if !uploadsBudget.reserve(size) {
+		logger.Debug(logSender, "", "upload from %q rejected, memory budget exhausted", r.RemoteAddr)
+		w.Header().Set("Retry-After", strconv.Itoa(uploadsRetryAfter))
+		sendAPIResponse(w, r, errUploadsMemoryBudget, "", http.StatusTooManyRequests)
+		return nil, nil, errUploadsMemoryBudget
+	}
+	return make([]byte, size), func() {
+		uploadsBudget.release(size)
+	}, nil
+}
@@ -39,6 +39,7 @@ const (
 )
 
 type loginPage struct {
+	pageLanguage
 	CurrentURL     string
 	Version        string
 	Error          string
@@ -48,21 +49,31 @@ type loginPage struct {
 	AltLoginName   string
 	ForgotPwdURL   string
 	OpenIDLoginURL string
+	SAMLLoginURL   string
+	MagicLinkURL   string
 	Branding       UIBranding
 	FormDisabled   bool
 }
 
 type twoFactorPage struct {
+	pageLanguage
 	CurrentURL  string
 	Version     string
 	Error       string
+	Info        string
 	CSRFToken   string
 	StaticURL   string
 	RecoveryURL string
 	Branding    UIBranding
+	TOTPEnabled bool
+	WebAuthnURL string
+	PushEnabled bool
+	// URL to request new recovery codes via email, empty if not allowed
+	RecoveryEmailURL string
 }
 
 type forgotPwdPage struct {
+	pageLanguage
 	CurrentURL string
 	Error      string
 	CSRFToken  string
@@ -72,6 +83,7 @@ type forgotPwdPage struct {
 }
 
 type resetPwdPage struct {
+	pageLanguage
 	CurrentURL string
 	Error      string
 	CSRFToken  string
@@ -34,8 +34,10 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/i18n"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
+	"github.com/drakkan/sftpgo/v2/internal/mq"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/version"
@@ -75,6 +77,7 @@ const (
 	templateUser             = "user.html"
 	templateAdmins           = "admins.html"
 	templateAdmin            = "admin.html"
+	templateAPIKeys          = "apikeys.html"
 	templateConnections      = "connections.html"
 	templateGroups           = "groups.html"
 	templateGroup            = "group.html"
@@ -88,6 +91,7 @@ const (
 	templateStatus           = "status.html"
 	templateLogin            = "login.html"
 	templateDefender         = "defender.html"
+	templateApprovals        = "approvals.html"
 	templateProfile          = "profile.html"
 	templateChangePwd        = "changepassword.html"
 	templateMaintenance      = "maintenance.html"
@@ -95,6 +99,7 @@ const (
 	templateSetup            = "adminsetup.html"
 	pageUsersTitle           = "Users"
 	pageAdminsTitle          = "Admins"
+	pageAPIKeysTitle         = "API keys"
 	pageConnectionsTitle     = "Connections"
 	pageStatusTitle          = "Status"
 	pageFoldersTitle         = "Folders"
@@ -105,6 +110,7 @@ const (
 	pageChangePwdTitle       = "Change password"
 	pageMaintenanceTitle     = "Maintenance"
 	pageDefenderTitle        = "Defender"
+	pageApprovalsTitle       = "Approvals"
 	pageForgotPwdTitle       = "SFTPGo Admin - Forgot password"
 	pageResetPwdTitle        = "SFTPGo Admin - Reset password"
 	pageSetupTitle           = "Create first admin user"
@@ -117,6 +123,7 @@ var (
 )
 
 type basePage struct {
+	pageLanguage
 	Title              string
 	CurrentURL         string
 	UsersURL           string
@@ -124,6 +131,7 @@ type basePage struct {
 	UserTemplateURL    string
 	AdminsURL          string
 	AdminURL           string
+	APIKeysURL         string
 	QuotaScanURL       string
 	ConnectionsURL     string
 	GroupsURL          string
@@ -132,6 +140,7 @@ type basePage struct {
 	FolderURL          string
 	FolderTemplateURL  string
 	DefenderURL        string
+	ApprovalsURL       string
 	LogoutURL          string
 	ProfileURL         string
 	ChangePwdURL       string
@@ -146,6 +155,7 @@ type basePage struct {
 	StaticURL          string
 	UsersTitle         string
 	AdminsTitle        string
+	APIKeysTitle       string
 	ConnectionsTitle   string
 	FoldersTitle       string
 	GroupsTitle        string
@@ -154,10 +164,12 @@ type basePage struct {
 	StatusTitle        string
 	MaintenanceTitle   string
 	DefenderTitle      string
+	ApprovalsTitle     string
 	Version            string
 	CSRFToken          string
 	IsEventManagerPage bool
 	HasDefender        bool
+	HasApprovals       bool
 	HasExternalLogin   bool
 	LoggedAdmin        *dataprovider.Admin
 	Branding           UIBranding
@@ -165,7 +177,12 @@ type basePage struct {
 
 type usersPage struct {
 	basePage
-	Users []dataprovider.User
+	Users             []dataprovider.User
+	DeletedUsers      bool
+	SoftDeleteEnabled bool
+	// WebClient URL to open after starting an impersonation session, empty if
+	// the WebClient is disabled
+	ClientFilesURL string
 }
 
 type adminsPage struct {
@@ -173,9 +190,16 @@ type adminsPage struct {
 	Admins []dataprovider.Admin
 }
 
+type apiKeysPage struct {
+	basePage
+	APIKeys []dataprovider.APIKey
+}
+
 type foldersPage struct {
 	basePage
-	Folders []vfs.BaseVirtualFolder
+	Folders           []vfs.BaseVirtualFolder
+	DeletedFolders    bool
+	SoftDeleteEnabled bool
 }
 
 type groupsPage struct {
@@ -221,21 +245,24 @@ type userPage struct {
 	ValidLoginMethods  []string
 	ValidProtocols     []string
 	TwoFactorProtocols []string
+	PushProtocols      []string
 	WebClientOptions   []string
 	RootDirPerms       []string
 	Mode               userPageMode
 	VirtualFolders     []vfs.BaseVirtualFolder
 	Groups             []dataprovider.Group
+	PasswordPolicies   []string
 	CanImpersonate     bool
 	FsWrapper          fsWrapper
 }
 
 type adminPage struct {
 	basePage
-	Admin  *dataprovider.Admin
-	Groups []dataprovider.Group
-	Error  string
-	IsAdd  bool
+	Admin       *dataprovider.Admin
+	Groups      []dataprovider.Group
+	Error       string
+	IsAdd       bool
+	PushEnabled bool
 }
 
 type profilePage struct {
@@ -244,6 +271,9 @@ type profilePage struct {
 	AllowAPIKeyAuth bool
 	Email           string
 	Description     string
+	Theme           string
+	Language        string
+	Languages       []i18n.LanguageInfo
 }
 
 type changePasswordPage struct {
@@ -259,13 +289,22 @@ type mfaPage struct {
 	ValidateTOTPURL string
 	SaveTOTPURL     string
 	RecCodesURL     string
+	WebAuthnEnabled bool
+	WebAuthnURL     string
+	SecurityKeys    []webAuthnCredentialView
+	RequiredPolicy  int
+	// true if the password or a passcode is required to generate new recovery codes
+	RecCodesReverification bool
+	RecCodesEmailEnabled   bool
 }
 
 type maintenancePage struct {
 	basePage
-	BackupPath  string
-	RestorePath string
-	Error       string
+	BackupPath   string
+	RestorePath  string
+	SMTPTestPath string
+	SMTPEnabled  bool
+	Error        string
 }
 
 type defenderHostsPage struct {
@@ -273,6 +312,11 @@ type defenderHostsPage struct {
 	DefenderHostsURL string
 }
 
+type approvalsPage struct {
+	basePage
+	ApprovalsRequestsURL string
+}
+
 type setupPage struct {
 	basePage
 	Username             string
@@ -301,18 +345,24 @@ type groupPage struct {
 	TwoFactorProtocols []string
 	WebClientOptions   []string
 	VirtualFolders     []vfs.BaseVirtualFolder
+	PasswordPolicies   []string
 	FsWrapper          fsWrapper
 }
 
 type eventActionPage struct {
 	basePage
-	Action         dataprovider.BaseEventAction
-	ActionTypes    []dataprovider.EnumMapping
-	FsActions      []dataprovider.EnumMapping
-	HTTPMethods    []string
-	RedactedSecret string
-	Error          string
-	Mode           genericPageMode
+	Action               dataprovider.BaseEventAction
+	ActionTypes          []dataprovider.EnumMapping
+	FsActions            []dataprovider.EnumMapping
+	HTTPMethods          []string
+	HTTPTransformFormats []string
+	S3StorageClasses     []string
+	GCSStorageClasses    []string
+	AzureAccessTiers     []string
+	PublishBrokers       []string
+	RedactedSecret       string
+	Error                string
+	Mode                 genericPageMode
 }
 
 type eventRulePage struct {
@@ -359,6 +409,11 @@ func loadAdminTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
 		filepath.Join(templatesPath, templateAdminDir, templateAdmins),
 	}
+	apiKeysPaths := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
+		filepath.Join(templatesPath, templateAdminDir, templateBase),
+		filepath.Join(templatesPath, templateAdminDir, templateAPIKeys),
+	}
 	adminPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
@@ -393,6 +448,7 @@ func loadAdminTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
 		filepath.Join(templatesPath, templateAdminDir, templateFsConfig),
+		filepath.Join(templatesPath, templateAdminDir, templateSharedComponents),
 		filepath.Join(templatesPath, templateAdminDir, templateFolder),
 	}
 	groupsPaths := []string{
@@ -447,6 +503,11 @@ func loadAdminTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
 		filepath.Join(templatesPath, templateAdminDir, templateDefender),
 	}
+	approvalsPaths := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
+		filepath.Join(templatesPath, templateAdminDir, templateBase),
+		filepath.Join(templatesPath, templateAdminDir, templateApprovals),
+	}
 	mfaPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
@@ -476,46 +537,53 @@ func loadAdminTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateCommonDir, templateResetPassword),
 	}
 
-	fsBaseTpl := template.New("fsBaseTemplate").Funcs(template.FuncMap{
+	baseTpl := template.New("baseTemplate").Funcs(getI18nTemplateFuncs())
+	fsBaseTpl := template.New("fsBaseTemplate").Funcs(getI18nTemplateFuncs()).Funcs(template.FuncMap{
 		"ListFSProviders": func() []sdk.FilesystemProvider {
 			return []sdk.FilesystemProvider{sdk.LocalFilesystemProvider, sdk.CryptedFilesystemProvider,
 				sdk.S3FilesystemProvider, sdk.GCSFilesystemProvider, sdk.AzureBlobFilesystemProvider,
-				sdk.SFTPFilesystemProvider, sdk.HTTPFilesystemProvider,
+				sdk.SFTPFilesystemProvider, sdk.HTTPFilesystemProvider, vfs.SMBFilesystemProvider,
+				vfs.WebDAVFilesystemProvider, vfs.CompressedFilesystemProvider, vfs.DedupFilesystemProvider,
 			}
 		},
-		"HumanizeBytes": util.ByteCountSI,
+		"FSProviderName":      vfs.GetProviderName,
+		"FSProviderShortInfo": vfs.GetProviderShortInfo,
+		"HumanizeBytes":       util.ByteCountSI,
 	})
-	usersTmpl := util.LoadTemplate(nil, usersPaths...)
+	usersTmpl := util.LoadTemplate(baseTpl, usersPaths...)
 	userTmpl := util.LoadTemplate(fsBaseTpl, userPaths...)
-	adminsTmpl := util.LoadTemplate(nil, adminsPaths...)
-	adminTmpl := util.LoadTemplate(nil, adminPaths...)
-	connectionsTmpl := util.LoadTemplate(nil, connectionsPaths...)
-	messageTmpl := util.LoadTemplate(nil, messagePaths...)
-	groupsTmpl := util.LoadTemplate(nil, groupsPaths...)
+	adminsTmpl := util.LoadTemplate(baseTpl, adminsPaths...)
+	adminTmpl := util.LoadTemplate(baseTpl, adminPaths...)
+	apiKeysTmpl := util.LoadTemplate(baseTpl, apiKeysPaths...)
+	connectionsTmpl := util.LoadTemplate(baseTpl, connectionsPaths...)
+	messageTmpl := util.LoadTemplate(baseTpl, messagePaths...)
+	groupsTmpl := util.LoadTemplate(baseTpl, groupsPaths...)
 	groupTmpl := util.LoadTemplate(fsBaseTpl, groupPaths...)
-	foldersTmpl := util.LoadTemplate(nil, foldersPaths...)
+	foldersTmpl := util.LoadTemplate(baseTpl, foldersPaths...)
 	folderTmpl := util.LoadTemplate(fsBaseTpl, folderPaths...)
-	eventRulesTmpl := util.LoadTemplate(nil, eventRulesPaths...)
+	eventRulesTmpl := util.LoadTemplate(baseTpl, eventRulesPaths...)
 	eventRuleTmpl := util.LoadTemplate(fsBaseTpl, eventRulePaths...)
-	eventActionsTmpl := util.LoadTemplate(nil, eventActionsPaths...)
-	eventActionTmpl := util.LoadTemplate(nil, eventActionPaths...)
-	statusTmpl := util.LoadTemplate(nil, statusPaths...)
-	loginTmpl := util.LoadTemplate(nil, loginPaths...)
-	profileTmpl := util.LoadTemplate(nil, profilePaths...)
-	changePwdTmpl := util.LoadTemplate(nil, changePwdPaths...)
-	maintenanceTmpl := util.LoadTemplate(nil, maintenancePaths...)
-	defenderTmpl := util.LoadTemplate(nil, defenderPaths...)
-	mfaTmpl := util.LoadTemplate(nil, mfaPaths...)
-	twoFactorTmpl := util.LoadTemplate(nil, twoFactorPaths...)
-	twoFactorRecoveryTmpl := util.LoadTemplate(nil, twoFactorRecoveryPaths...)
-	setupTmpl := util.LoadTemplate(nil, setupPaths...)
-	forgotPwdTmpl := util.LoadTemplate(nil, forgotPwdPaths...)
-	resetPwdTmpl := util.LoadTemplate(nil, resetPwdPaths...)
+	eventActionsTmpl := util.LoadTemplate(baseTpl, eventActionsPaths...)
+	eventActionTmpl := util.LoadTemplate(baseTpl, eventActionPaths...)
+	statusTmpl := util.LoadTemplate(baseTpl, statusPaths...)
+	loginTmpl := util.LoadTemplate(baseTpl, loginPaths...)
+	profileTmpl := util.LoadTemplate(baseTpl, profilePaths...)
+	changePwdTmpl := util.LoadTemplate(baseTpl, changePwdPaths...)
+	maintenanceTmpl := util.LoadTemplate(baseTpl, maintenancePaths...)
+	defenderTmpl := util.LoadTemplate(baseTpl, defenderPaths...)
+	approvalsTmpl := util.LoadTemplate(baseTpl, approvalsPaths...)
+	mfaTmpl := util.LoadTemplate(baseTpl, mfaPaths...)
+	twoFactorTmpl := util.LoadTemplate(baseTpl, twoFactorPaths...)
+	twoFactorRecoveryTmpl := util.LoadTemplate(baseTpl, twoFactorRecoveryPaths...)
+	setupTmpl := util.LoadTemplate(baseTpl, setupPaths...)
+	forgotPwdTmpl := util.LoadTemplate(baseTpl, forgotPwdPaths...)
+	resetPwdTmpl := util.LoadTemplate(baseTpl, resetPwdPaths...)
 
 	adminTemplates[templateUsers] = usersTmpl
 	adminTemplates[templateUser] = userTmpl
 	adminTemplates[templateAdmins] = adminsTmpl
 	adminTemplates[templateAdmin] = adminTmpl
+	adminTemplates[templateAPIKeys] = apiKeysTmpl
 	adminTemplates[templateConnections] = connectionsTmpl
 	adminTemplates[templateMessage] = messageTmpl
 	adminTemplates[templateGroups] = groupsTmpl
@@ -532,6 +600,7 @@ func loadAdminTemplates(templatesPath string) {
 	adminTemplates[templateChangePwd] = changePwdTmpl
 	adminTemplates[templateMaintenance] = maintenanceTmpl
 	adminTemplates[templateDefender] = defenderTmpl
+	adminTemplates[templateApprovals] = approvalsTmpl
 	adminTemplates[templateMFA] = mfaTmpl
 	adminTemplates[templateTwoFactor] = twoFactorTmpl
 	adminTemplates[templateTwoFactorRecovery] = twoFactorRecoveryTmpl
@@ -561,20 +630,24 @@ func (s *httpdServer) getBasePageData(title, currentURL string, r *http.Request)
 	if currentURL != "" {
 		csrfToken = createCSRFToken(util.GetIPFromRemoteAddress(r.RemoteAddr))
 	}
+	lang := getPageLanguage(r)
 	return basePage{
-		Title:              title,
+		pageLanguage:       lang,
+		Title:              i18n.T(lang.Lang, title),
 		CurrentURL:         currentURL,
 		UsersURL:           webUsersPath,
 		UserURL:            webUserPath,
 		UserTemplateURL:    webTemplateUser,
 		AdminsURL:          webAdminsPath,
 		AdminURL:           webAdminPath,
+		APIKeysURL:         webAPIKeysPath,
 		GroupsURL:          webGroupsPath,
 		GroupURL:           webGroupPath,
 		FoldersURL:         webFoldersPath,
 		FolderURL:          webFolderPath,
 		FolderTemplateURL:  webTemplateFolder,
 		DefenderURL:        webDefenderPath,
+		ApprovalsURL:       webApprovalsPath,
 		LogoutURL:          webLogoutPath,
 		ProfileURL:         webAdminProfilePath,
 		ChangePwdURL:       webChangeAdminPwdPath,
@@ -589,20 +662,23 @@ func (s *httpdServer) getBasePageData(title, currentURL string, r *http.Request)
 		FolderQuotaScanURL: webScanVFolderPath,
 		MaintenanceURL:     webMaintenancePath,
 		StaticURL:          webStaticFilesPath,
-		UsersTitle:         pageUsersTitle,
-		AdminsTitle:        pageAdminsTitle,
-		ConnectionsTitle:   pageConnectionsTitle,
-		FoldersTitle:       pageFoldersTitle,
-		GroupsTitle:        pageGroupsTitle,
-		EventRulesTitle:    pageEventRulesTitle,
-		EventActionsTitle:  pageEventActionsTitle,
-		StatusTitle:        pageStatusTitle,
-		MaintenanceTitle:   pageMaintenanceTitle,
-		DefenderTitle:      pageDefenderTitle,
+		UsersTitle:         i18n.T(lang.Lang, pageUsersTitle),
+		AdminsTitle:        i18n.T(lang.Lang, pageAdminsTitle),
+		APIKeysTitle:       i18n.T(lang.Lang, pageAPIKeysTitle),
+		ConnectionsTitle:   i18n.T(lang.Lang, pageConnectionsTitle),
+		FoldersTitle:       i18n.T(lang.Lang, pageFoldersTitle),
+		GroupsTitle:        i18n.T(lang.Lang, pageGroupsTitle),
+		EventRulesTitle:    i18n.T(lang.Lang, pageEventRulesTitle),
+		EventActionsTitle:  i18n.T(lang.Lang, pageEventActionsTitle),
+		StatusTitle:        i18n.T(lang.Lang, pageStatusTitle),
+		MaintenanceTitle:   i18n.T(lang.Lang, pageMaintenanceTitle),
+		DefenderTitle:      i18n.T(lang.Lang, pageDefenderTitle),
+		ApprovalsTitle:     i18n.T(lang.Lang, pageApprovalsTitle),
 		Version:            version.GetAsString(),
 		LoggedAdmin:        getAdminFromToken(r),
 		IsEventManagerPage: isEventManagerResource(currentURL),
 		HasDefender:        common.Config.DefenderConfig.Enabled,
+		HasApprovals:       len(approvalRequiredOps) > 0,
 		HasExternalLogin:   isLoggedInWithOIDC(r),
 		CSRFToken:          csrfToken,
 		Branding:           s.binding.Branding.WebAdmin,
@@ -619,17 +695,18 @@ func renderAdminTemplate(w http.ResponseWriter, tmplName string, data any) {
 func (s *httpdServer) renderMessagePage(w http.ResponseWriter, r *http.Request, title, body string, statusCode int,
 	err error, message string,
 ) {
+	page := s.getBasePageData(title, "", r)
 	var errorString string
 	if body != "" {
-		errorString = body + " "
+		errorString = i18n.T(page.Lang, body) + " "
 	}
 	if err != nil {
-		errorString += err.Error()
+		errorString += i18n.T(page.Lang, err.Error())
 	}
 	data := messagePage{
-		basePage: s.getBasePageData(title, "", r),
+		basePage: page,
 		Error:    errorString,
-		Success:  message,
+		Success:  i18n.T(page.Lang, message),
 	}
 	w.WriteHeader(statusCode)
 	renderAdminTemplate(w, templateMessage, data)
@@ -651,51 +728,65 @@ func (s *httpdServer) renderNotFoundPage(w http.ResponseWriter, r *http.Request,
 	s.renderMessagePage(w, r, page404Title, page404Body, http.StatusNotFound, err, "")
 }
 
-func (s *httpdServer) renderForgotPwdPage(w http.ResponseWriter, error, ip string) {
+func (s *httpdServer) renderForgotPwdPage(w http.ResponseWriter, r *http.Request, error, ip string) {
 	data := forgotPwdPage{
-		CurrentURL: webAdminForgotPwdPath,
-		Error:      error,
-		CSRFToken:  createCSRFToken(ip),
-		StaticURL:  webStaticFilesPath,
-		Title:      pageForgotPwdTitle,
-		Branding:   s.binding.Branding.WebAdmin,
+		pageLanguage: getPageLanguage(r),
+		CurrentURL:   webAdminForgotPwdPath,
+		Error:        error,
+		CSRFToken:    createCSRFToken(ip),
+		StaticURL:    webStaticFilesPath,
+		Title:        pageForgotPwdTitle,
+		Branding:     s.binding.Branding.WebAdmin,
 	}
 	renderAdminTemplate(w, templateForgotPassword, data)
 }
 
-func (s *httpdServer) renderResetPwdPage(w http.ResponseWriter, error, ip string) {
+func (s *httpdServer) renderResetPwdPage(w http.ResponseWriter, r *http.Request, error, ip string) {
 	data := resetPwdPage{
-		CurrentURL: webAdminResetPwdPath,
-		Error:      error,
-		CSRFToken:  createCSRFToken(ip),
-		StaticURL:  webStaticFilesPath,
-		Title:      pageResetPwdTitle,
-		Branding:   s.binding.Branding.WebAdmin,
+		pageLanguage: getPageLanguage(r),
+		CurrentURL:   webAdminResetPwdPath,
+		Error:        error,
+		CSRFToken:    createCSRFToken(ip),
+		StaticURL:    webStaticFilesPath,
+		Title:        pageResetPwdTitle,
+		Branding:     s.binding.Branding.WebAdmin,
 	}
 	renderAdminTemplate(w, templateResetPassword, data)
 }
 
-func (s *httpdServer) renderTwoFactorPage(w http.ResponseWriter, error, ip string) {
+func (s *httpdServer) renderTwoFactorPage(w http.ResponseWriter, r *http.Request, error, ip string) {
 	data := twoFactorPage{
-		CurrentURL:  webAdminTwoFactorPath,
-		Version:     version.Get().Version,
-		Error:       error,
-		CSRFToken:   createCSRFToken(ip),
-		StaticURL:   webStaticFilesPath,
-		RecoveryURL: webAdminTwoFactorRecoveryPath,
-		Branding:    s.binding.Branding.WebAdmin,
+		pageLanguage: getPageLanguage(r),
+		CurrentURL:   webAdminTwoFactorPath,
+		Version:      version.Get().Version,
+		Error:        error,
+		CSRFToken:    createCSRFToken(ip),
+		StaticURL:    webStaticFilesPath,
+		RecoveryURL:  webAdminTwoFactorRecoveryPath,
+		Branding:     s.binding.Branding.WebAdmin,
+		TOTPEnabled:  true,
+	}
+	if claims, err := getTokenClaims(r); err == nil {
+		if admin, err := dataprovider.AdminExists(claims.Username); err == nil {
+			data.TOTPEnabled = admin.Filters.TOTPConfig.Enabled
+			if admin.HasWebAuthnCredentials() {
+				data.WebAuthnURL = webAdminTwoFactorWebAuthnPath
+			}
+			data.PushEnabled = admin.IsPushEnabled()
+		}
 	}
 	renderAdminTemplate(w, templateTwoFactor, data)
 }
 
-func (s *httpdServer) renderTwoFactorRecoveryPage(w http.ResponseWriter, error, ip string) {
+func (s *httpdServer) renderTwoFactorRecoveryPage(w http.ResponseWriter, r *http.Request, error, ip string) {
 	data := twoFactorPage{
-		CurrentURL: webAdminTwoFactorRecoveryPath,
-		Version:    version.Get().Version,
-		Error:      error,
-		CSRFToken:  createCSRFToken(ip),
-		StaticURL:  webStaticFilesPath,
-		Branding:   s.binding.Branding.WebAdmin,
+		pageLanguage: getPageLanguage(r),
+		CurrentURL:   webAdminTwoFactorRecoveryPath,
+		Version:      version.Get().Version,
+		Error:        error,
+		CSRFToken:    createCSRFToken(ip),
+		StaticURL:    webStaticFilesPath,
+		Branding:     s.binding.Branding.WebAdmin,
 	}
 	renderAdminTemplate(w, templateTwoFactorRecovery, data)
 }
@@ -708,6 +799,11 @@ func (s *httpdServer) renderMFAPage(w http.ResponseWriter, r *http.Request) {
 		ValidateTOTPURL: webAdminTOTPValidatePath,
 		SaveTOTPURL:     webAdminTOTPSavePath,
 		RecCodesURL:     webAdminRecoveryCodesPath,
+		WebAuthnEnabled: mfa.IsWebAuthnEnabled(),
+		WebAuthnURL:     webAdminWebAuthnPath,
+
+		RecCodesReverification: mfa.IsRecoveryCodesReverificationRequired(),
+		RecCodesEmailEnabled:   smtp.IsEnabled(),
 	}
 	admin, err := dataprovider.AdminExists(data.LoggedAdmin.Username)
 	if err != nil {
@@ -715,6 +811,10 @@ func (s *httpdServer) renderMFAPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	data.TOTPConfig = admin.Filters.TOTPConfig
+	data.SecurityKeys = getWebAuthnCredentialViews(admin.Filters.WebAuthnCredentials)
+	if admin.MustSetSecondFactor() {
+		data.RequiredPolicy = mfa.GetAdminPolicy()
+	}
 	renderAdminTemplate(w, templateMFA, data)
 }
 
@@ -731,6 +831,9 @@ func (s *httpdServer) renderProfilePage(w http.ResponseWriter, r *http.Request,
 	data.AllowAPIKeyAuth = admin.Filters.AllowAPIKeyAuth
 	data.Email = admin.Email
 	data.Description = admin.Description
+	data.Theme = admin.Filters.Preferences.Theme
+	data.Language = admin.Filters.Language
+	data.Languages = i18n.GetLanguages()
 
 	renderAdminTemplate(w, templateProfile, data)
 }
@@ -746,10 +849,12 @@ func (s *httpdServer) renderChangePasswordPage(w http.ResponseWriter, r *http.Re
 
 func (s *httpdServer) renderMaintenancePage(w http.ResponseWriter, r *http.Request, error string) {
 	data := maintenancePage{
-		basePage:    s.getBasePageData(pageMaintenanceTitle, webMaintenancePath, r),
-		BackupPath:  webBackupPath,
-		RestorePath: webRestorePath,
-		Error:       error,
+		basePage:     s.getBasePageData(pageMaintenanceTitle, webMaintenancePath, r),
+		BackupPath:   webBackupPath,
+		RestorePath:  webRestorePath,
+		SMTPTestPath: webAdminSMTPTestPath,
+		SMTPEnabled:  smtp.IsEnabled(),
+		Error:        error,
 	}
 
 	renderAdminTemplate(w, templateMaintenance, data)
@@ -781,11 +886,12 @@ func (s *httpdServer) renderAddUpdateAdminPage(w http.ResponseWriter, r *http.Re
 		title = "Update admin"
 	}
 	data := adminPage{
-		basePage: s.getBasePageData(title, currentURL, r),
-		Admin:    admin,
-		Groups:   groups,
-		Error:    error,
-		IsAdd:    isAdd,
+		basePage:    s.getBasePageData(title, currentURL, r),
+		Admin:       admin,
+		Groups:      groups,
+		Error:       error,
+		IsAdd:       isAdd,
+		PushEnabled: mfa.IsPushEnabled(),
 	}
 
 	renderAdminTemplate(w, templateAdmin, data)
@@ -851,6 +957,7 @@ func (s *httpdServer) renderUserPage(w http.ResponseWriter, r *http.Request, use
 		RootDirPerms:       user.GetPermissionsForPath("/"),
 		VirtualFolders:     folders,
 		Groups:             groups,
+		PasswordPolicies:   dataprovider.GetPasswordPolicyNames(),
 		CanImpersonate:     os.Getuid() == 0,
 		FsWrapper: fsWrapper{
 			Filesystem:      user.FsConfig,
@@ -861,6 +968,9 @@ func (s *httpdServer) renderUserPage(w http.ResponseWriter, r *http.Request, use
 			DirPath:         user.HomeDir,
 		},
 	}
+	if mfa.IsPushEnabled() {
+		data.PushProtocols = dataprovider.PushMFAProtocols
+	}
 	renderAdminTemplate(w, templateUser, data)
 }
 
@@ -896,6 +1006,7 @@ func (s *httpdServer) renderGroupPage(w http.ResponseWriter, r *http.Request, gr
 		TwoFactorProtocols: dataprovider.MFAProtocols,
 		WebClientOptions:   sdk.WebClientOptions,
 		VirtualFolders:     folders,
+		PasswordPolicies:   dataprovider.GetPasswordPolicyNames(),
 		FsWrapper: fsWrapper{
 			Filesystem:      group.UserSettings.FsConfig,
 			IsUserPage:      false,
@@ -926,16 +1037,30 @@ func (s *httpdServer) renderEventActionPage(w http.ResponseWriter, r *http.Reque
 	if action.Options.CmdConfig.Timeout == 0 {
 		action.Options.CmdConfig.Timeout = 20
 	}
+	if action.Options.PublishConfig.Timeout == 0 {
+		action.Options.PublishConfig.Timeout = 20
+	}
+	if action.Options.SummaryReportConfig.Period == 0 {
+		action.Options.SummaryReportConfig.Period = 24
+	}
+	if action.Options.SummaryReportConfig.TopCount == 0 {
+		action.Options.SummaryReportConfig.TopCount = 10
+	}
 
 	data := eventActionPage{
-		basePage:       s.getBasePageData(title, currentURL, r),
-		Action:         action,
-		ActionTypes:    dataprovider.EventActionTypes,
-		FsActions:      dataprovider.FsActionTypes,
-		HTTPMethods:    dataprovider.SupportedHTTPActionMethods,
-		RedactedSecret: redactedSecret,
-		Error:          error,
-		Mode:           mode,
+		basePage:             s.getBasePageData(title, currentURL, r),
+		Action:               action,
+		ActionTypes:          dataprovider.EventActionTypes,
+		FsActions:            dataprovider.FsActionTypes,
+		HTTPMethods:          dataprovider.SupportedHTTPActionMethods,
+		HTTPTransformFormats: dataprovider.SupportedHTTPTransformFormats,
+		S3StorageClasses:     dataprovider.SupportedS3TransitionStorageClasses,
+		GCSStorageClasses:    dataprovider.SupportedGCSTransitionStorageClasses,
+		AzureAccessTiers:     dataprovider.SupportedAzureTransitionAccessTiers,
+		PublishBrokers:       mq.SupportedBrokers,
+		RedactedSecret:       redactedSecret,
+		Error:                error,
+		Mode:                 mode,
 	}
 	renderAdminTemplate(w, templateEventAction, data)
 }
@@ -1066,6 +1191,8 @@ func getVirtualFoldersFromPostFields(r *http.Request) []vfs.VirtualFolder {
 	folderNames := r.Form["vfolder_name"]
 	folderQuotaSizes := r.Form["vfolder_quota_size"]
 	folderQuotaFiles := r.Form["vfolder_quota_files"]
+	folderUserQuotaSizes := r.Form["vfolder_user_quota_size"]
+	folderUserQuotaFiles := r.Form["vfolder_user_quota_files"]
 	for idx, p := range folderPaths {
 		p = strings.TrimSpace(p)
 		name := ""
@@ -1093,6 +1220,18 @@ func getVirtualFoldersFromPostFields(r *http.Request) []vfs.VirtualFolder {
 					vfolder.QuotaFiles = quotaFiles
 				}
 			}
+			if len(folderUserQuotaSizes) > idx {
+				quotaSize, err := util.ParseBytes(folderUserQuotaSizes[idx])
+				if err == nil {
+					vfolder.UserQuotaSize = quotaSize
+				}
+			}
+			if len(folderUserQuotaFiles) > idx {
+				quotaFiles, err := strconv.Atoi(strings.TrimSpace(folderUserQuotaFiles[idx]))
+				if err == nil {
+					vfolder.UserQuotaFiles = quotaFiles
+				}
+			}
 			virtualFolders = append(virtualFolders, vfolder)
 		}
 	}
@@ -1202,6 +1341,64 @@ func getBandwidthLimitsFromPostFields(r *http.Request) ([]sdk.BandwidthLimit, er
 	return result, nil
 }
 
+func getBandwidthSchedulesFromPostFields(r *http.Request) ([]dataprovider.BandwidthSchedule, error) {
+	var result []dataprovider.BandwidthSchedule
+
+	for k := range r.Form {
+		if strings.HasPrefix(k, "bw_schedule_hour") {
+			idx := strings.TrimPrefix(k, "bw_schedule_hour")
+			schedule := dataprovider.BandwidthSchedule{
+				Hours:      strings.TrimSpace(r.Form.Get(k)),
+				DayOfWeek:  strings.TrimSpace(r.Form.Get(fmt.Sprintf("bw_schedule_day_of_week%v", idx))),
+				DayOfMonth: strings.TrimSpace(r.Form.Get(fmt.Sprintf("bw_schedule_day_of_month%v", idx))),
+				Month:      strings.TrimSpace(r.Form.Get(fmt.Sprintf("bw_schedule_month%v", idx))),
+			}
+			ul := r.Form.Get(fmt.Sprintf("bw_schedule_upload%v", idx))
+			dl := r.Form.Get(fmt.Sprintf("bw_schedule_download%v", idx))
+			if schedule.Hours == "" && schedule.DayOfWeek == "" && schedule.DayOfMonth == "" && schedule.Month == "" &&
+				ul == "" && dl == "" {
+				continue
+			}
+			if ul != "" {
+				bandwidthUL, err := strconv.ParseInt(ul, 10, 64)
+				if err != nil {
+					return result, fmt.Errorf("invalid bw_schedule_upload%v %q: %w", idx, ul, err)
+				}
+				schedule.UploadBandwidth = bandwidthUL
+			}
+			if dl != "" {
+				bandwidthDL, err := strconv.ParseInt(dl, 10, 64)
+				if err != nil {
+					return result, fmt.Errorf("invalid bw_schedule_download%v %q: %w", idx, dl, err)
+				}
+				schedule.DownloadBandwidth = bandwidthDL
+			}
+			result = append(result, schedule)
+		}
+	}
+
+	return result, nil
+}
+
+func getTransferQuotaResetFromPostFields(r *http.Request) (*dataprovider.TransferQuotaReset, error) {
+	period := strings.TrimSpace(r.Form.Get("transfer_quota_reset_period"))
+	if period == "" {
+		return nil, nil
+	}
+	reset := &dataprovider.TransferQuotaReset{
+		Period: period,
+	}
+	anchor := strings.TrimSpace(r.Form.Get("transfer_quota_reset_anchor"))
+	if anchor != "" {
+		anchorDate, err := time.Parse(webDateTimeFormat, anchor)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transfer quota reset anchor date %q: %w", anchor, err)
+		}
+		reset.AnchorDate = util.GetTimeAsMsSinceEpoch(anchorDate)
+	}
+	return reset, nil
+}
+
 func getPatterDenyPolicyFromString(policy string) int {
 	denyPolicy := sdk.DenyPolicyDefault
 	if policy == "1" {
@@ -1298,6 +1495,98 @@ func getGroupsFromUserPostFields(r *http.Request) []sdk.GroupMapping {
 	return groups
 }
 
+func getPortForwardingFromPostFields(r *http.Request) (*dataprovider.SSHPortForwarding, error) {
+	allowLocal := r.Form.Get("port_forwarding_local") != ""
+	allowRemote := r.Form.Get("port_forwarding_remote") != ""
+	if !allowLocal && !allowRemote {
+		return nil, nil
+	}
+	bandwidth, err := strconv.ParseInt(r.Form.Get("port_forwarding_bandwidth"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port forwarding bandwidth: %w", err)
+	}
+	return &dataprovider.SSHPortForwarding{
+		AllowLocal:   allowLocal,
+		AllowRemote:  allowRemote,
+		PermitOpen:   getSliceFromDelimitedValues(r.Form.Get("port_forwarding_permit_open"), ","),
+		PermitListen: getSliceFromDelimitedValues(r.Form.Get("port_forwarding_permit_listen"), ","),
+		Bandwidth:    bandwidth,
+	}, nil
+}
+
+func getUploadSizeLimitsFromPostFields(r *http.Request) ([]dataprovider.UploadSizeLimit, error) {
+	var result []dataprovider.UploadSizeLimit
+
+	for k := range r.Form {
+		if strings.HasPrefix(k, "upload_size_limit_path") {
+			p := strings.TrimSpace(r.Form.Get(k))
+			if p == "" {
+				continue
+			}
+			idx := strings.TrimPrefix(k, "upload_size_limit_path")
+			maxSize, err := util.ParseBytes(r.Form.Get(fmt.Sprintf("upload_size_limit_size%v", idx)))
+			if err != nil {
+				return result, fmt.Errorf("invalid upload_size_limit_size%v: %w", idx, err)
+			}
+			result = append(result, dataprovider.UploadSizeLimit{
+				Path:       p,
+				Extensions: getSliceFromDelimitedValues(r.Form.Get(fmt.Sprintf("upload_size_limit_extensions%v", idx)), ","),
+				MaxSize:    maxSize,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+func getMultipartUploadsFromPostFields(r *http.Request) ([]dataprovider.MultipartUploadSettings, error) {
+	var result []dataprovider.MultipartUploadSettings
+
+	for k := range r.Form {
+		if strings.HasPrefix(k, "multipart_upload_path") {
+			p := strings.TrimSpace(r.Form.Get(k))
+			if p == "" {
+				continue
+			}
+			idx := strings.TrimPrefix(k, "multipart_upload_path")
+			partSize, err := strconv.ParseInt(r.Form.Get(fmt.Sprintf("multipart_upload_part_size%v", idx)), 10, 64)
+			if err != nil {
+				return result, fmt.Errorf("invalid multipart_upload_part_size%v: %w", idx, err)
+			}
+			concurrency, err := strconv.Atoi(r.Form.Get(fmt.Sprintf("multipart_upload_concurrency%v", idx)))
+			if err != nil {
+				return result, fmt.Errorf("invalid multipart_upload_concurrency%v: %w", idx, err)
+			}
+			result = append(result, dataprovider.MultipartUploadSettings{
+				Path:        p,
+				PartSize:    partSize,
+				Concurrency: concurrency,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+func getUserPushConfigFromPostFields(r *http.Request) dataprovider.UserPushConfig {
+	protocols := r.Form["push_protocols"]
+	return dataprovider.UserPushConfig{
+		Enabled:   len(protocols) > 0,
+		Protocols: protocols,
+	}
+}
+
+func getClientPolicyFromPostFields(r *http.Request) *dataprovider.ClientPolicy {
+	policy := &dataprovider.ClientPolicy{
+		Allowed: getSliceFromDelimitedValues(r.Form.Get("client_policy_allowed"), ","),
+		Denied:  getSliceFromDelimitedValues(r.Form.Get("client_policy_denied"), ","),
+	}
+	if !policy.IsEnabled() {
+		return nil
+	}
+	return policy
+}
+
 func getFiltersFromUserPostFields(r *http.Request) (sdk.BaseUserFilters, error) {
 	var filters sdk.BaseUserFilters
 	bwLimits, err := getBandwidthLimitsFromPostFields(r)
@@ -1392,6 +1681,7 @@ func getS3Config(r *http.Request) (vfs.S3FsConfig, error) {
 		return config, fmt.Errorf("invalid s3 download concurrency: %w", err)
 	}
 	config.ForcePathStyle = r.Form.Get("s3_force_path_style") != ""
+	config.EmulateSymlinks = r.Form.Get("s3_emulate_symlinks") != ""
 	config.DownloadPartMaxTime, err = strconv.Atoi(r.Form.Get("s3_download_part_max_time"))
 	if err != nil {
 		return config, fmt.Errorf("invalid s3 download part max time: %w", err)
@@ -1411,6 +1701,9 @@ func getGCSConfig(r *http.Request) (vfs.GCSFsConfig, error) {
 	config.StorageClass = r.Form.Get("gcs_storage_class")
 	config.ACL = r.Form.Get("gcs_acl")
 	config.KeyPrefix = r.Form.Get("gcs_key_prefix")
+	config.EncryptionKey = getSecretFromFormField(r, "gcs_encryption_key")
+	config.KMSKeyName = r.Form.Get("gcs_kms_key_name")
+	config.EmulateSymlinks = r.Form.Get("gcs_emulate_symlinks") != ""
 	autoCredentials := r.Form.Get("gcs_auto_credentials")
 	if autoCredentials != "" {
 		config.AutomaticCredentials = 1
@@ -1449,6 +1742,7 @@ func getSFTPConfig(r *http.Request) (vfs.SFTPFsConfig, error) {
 	config.Fingerprints = getSliceFromDelimitedValues(fingerprintsFormValue, "\n")
 	config.Prefix = r.Form.Get("sftp_prefix")
 	config.DisableCouncurrentReads = r.Form.Get("sftp_disable_concurrent_reads") != ""
+	config.UserCredentials = r.Form.Get("sftp_user_credentials") != ""
 	config.BufferSize, err = strconv.ParseInt(r.Form.Get("sftp_buffer_size"), 10, 64)
 	if r.Form.Get("sftp_equality_check_mode") != "" {
 		config.EqualityCheckMode = 1
@@ -1476,6 +1770,66 @@ func getHTTPFsConfig(r *http.Request) vfs.HTTPFsConfig {
 	return config
 }
 
+func getSMBFsConfig(r *http.Request) vfs.SMBFsConfig {
+	config := vfs.SMBFsConfig{}
+	config.Endpoint = r.Form.Get("smb_endpoint")
+	config.Share = r.Form.Get("smb_share")
+	config.Domain = r.Form.Get("smb_domain")
+	config.Username = r.Form.Get("smb_username")
+	config.Password = getSecretFromFormField(r, "smb_password")
+	config.Prefix = r.Form.Get("smb_prefix")
+	if r.Form.Get("smb_equality_check_mode") != "" {
+		config.EqualityCheckMode = 1
+	} else {
+		config.EqualityCheckMode = 0
+	}
+	return config
+}
+
+func getWebDAVFsConfig(r *http.Request) (vfs.WebDAVFsConfig, error) {
+	var err error
+	config := vfs.WebDAVFsConfig{}
+	config.Endpoint = r.Form.Get("webdav_endpoint")
+	config.Username = r.Form.Get("webdav_username")
+	config.Password = getSecretFromFormField(r, "webdav_password")
+	config.Prefix = r.Form.Get("webdav_prefix")
+	config.SkipTLSVerify = r.Form.Get("webdav_skip_tls_verify") != ""
+	config.CACertificates = strings.TrimSpace(r.Form.Get("webdav_ca_certificates"))
+	config.UploadsEndpoint = r.Form.Get("webdav_uploads_endpoint")
+	if r.Form.Get("webdav_equality_check_mode") != "" {
+		config.EqualityCheckMode = 1
+	} else {
+		config.EqualityCheckMode = 0
+	}
+	config.UploadChunkSize, err = strconv.Atoi(r.Form.Get("webdav_upload_chunk_size"))
+	if err != nil {
+		return config, fmt.Errorf("invalid WebDAV upload chunk size: %w", err)
+	}
+	return config, nil
+}
+
+func getCompressedFsConfig(r *http.Request) (vfs.CompressedFsConfig, error) {
+	var err error
+	config := vfs.CompressedFsConfig{}
+	config.Algorithm = r.Form.Get("compress_algorithm")
+	config.Level, err = strconv.Atoi(r.Form.Get("compress_level"))
+	if err != nil {
+		return config, fmt.Errorf("invalid compression level: %w", err)
+	}
+	return config, nil
+}
+
+func getDedupFsConfig(r *http.Request) (vfs.DedupFsConfig, error) {
+	var err error
+	config := vfs.DedupFsConfig{}
+	config.StorePath = strings.TrimSpace(r.Form.Get("dedup_store_path"))
+	config.QuotaMode, err = strconv.Atoi(r.Form.Get("dedup_quota_mode"))
+	if err != nil {
+		return config, fmt.Errorf("invalid dedup quota mode: %w", err)
+	}
+	return config, nil
+}
+
 func getAzureConfig(r *http.Request) (vfs.AzBlobFsConfig, error) {
 	var err error
 	config := vfs.AzBlobFsConfig{}
@@ -1487,6 +1841,7 @@ func getAzureConfig(r *http.Request) (vfs.AzBlobFsConfig, error) {
 	config.KeyPrefix = r.Form.Get("az_key_prefix")
 	config.AccessTier = r.Form.Get("az_access_tier")
 	config.UseEmulator = r.Form.Get("az_use_emulator") != ""
+	config.EmulateSymlinks = r.Form.Get("az_emulate_symlinks") != ""
 	config.UploadPartSize, err = strconv.ParseInt(r.Form.Get("az_upload_part_size"), 10, 64)
 	if err != nil {
 		return config, fmt.Errorf("invalid azure upload part size: %w", err)
@@ -1503,12 +1858,38 @@ func getAzureConfig(r *http.Request) (vfs.AzBlobFsConfig, error) {
 	if err != nil {
 		return config, fmt.Errorf("invalid azure download concurrency: %w", err)
 	}
-	return config, nil
+	config.TieringRules, err = getAzureTieringRules(r)
+	return config, err
+}
+
+// getAzureTieringRules parses the tiering rules, one per line, in the format
+// "access tier,path pattern,min size"
+func getAzureTieringRules(r *http.Request) ([]vfs.AzBlobTieringRule, error) {
+	var rules []vfs.AzBlobTieringRule
+	for _, line := range getSliceFromDelimitedValues(r.Form.Get("az_tiering_rules"), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid azure tiering rule %q, expected format: access tier,path pattern,min size", line)
+		}
+		rule := vfs.AzBlobTieringRule{
+			AccessTier:  strings.TrimSpace(fields[0]),
+			PathPattern: strings.TrimSpace(fields[1]),
+		}
+		if minSize := strings.TrimSpace(fields[2]); minSize != "" {
+			size, err := strconv.ParseInt(minSize, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid azure tiering rule min size %q: %w", minSize, err)
+			}
+			rule.MinSize = size
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
 }
 
 func getFsConfigFromPostFields(r *http.Request) (vfs.Filesystem, error) {
 	var fs vfs.Filesystem
-	fs.Provider = sdk.GetProviderByName(r.Form.Get("fs_provider"))
+	fs.Provider = vfs.GetProviderByName(r.Form.Get("fs_provider"))
 	switch fs.Provider {
 	case sdk.S3FilesystemProvider:
 		config, err := getS3Config(r)
@@ -1538,6 +1919,26 @@ func getFsConfigFromPostFields(r *http.Request) (vfs.Filesystem, error) {
 		fs.SFTPConfig = config
 	case sdk.HTTPFilesystemProvider:
 		fs.HTTPConfig = getHTTPFsConfig(r)
+	case vfs.SMBFilesystemProvider:
+		fs.SMBConfig = getSMBFsConfig(r)
+	case vfs.WebDAVFilesystemProvider:
+		config, err := getWebDAVFsConfig(r)
+		if err != nil {
+			return fs, err
+		}
+		fs.WebDAVConfig = config
+	case vfs.CompressedFilesystemProvider:
+		config, err := getCompressedFsConfig(r)
+		if err != nil {
+			return fs, err
+		}
+		fs.CompressConfig = config
+	case vfs.DedupFilesystemProvider:
+		config, err := getDedupFsConfig(r)
+		if err != nil {
+			return fs, err
+		}
+		fs.DedupConfig = config
 	}
 	return fs, nil
 }
@@ -1582,8 +1983,10 @@ func getAdminFromPostFields(r *http.Request) (dataprovider.Admin, error) {
 	admin.Permissions = r.Form["permissions"]
 	admin.Email = r.Form.Get("email")
 	admin.Status = status
+	admin.Filters.Language = strings.TrimSpace(r.Form.Get("language"))
 	admin.Filters.AllowList = getSliceFromDelimitedValues(r.Form.Get("allowed_ip"), ",")
 	admin.Filters.AllowAPIKeyAuth = r.Form.Get("allow_api_key_auth") != ""
+	admin.Filters.PushConfig.Enabled = r.Form.Get("push_enabled") != ""
 	admin.AdditionalInfo = r.Form.Get("additional_info")
 	admin.Description = r.Form.Get("description")
 	admin.Filters.Preferences.HideUserPageSections = getAdminHiddenUserPageSections(r)
@@ -1638,6 +2041,12 @@ func getFolderFromTemplate(folder vfs.BaseVirtualFolder, name string) vfs.BaseVi
 		folder.FsConfig.SFTPConfig = getSFTPFsFromTemplate(folder.FsConfig.SFTPConfig, replacements)
 	case sdk.HTTPFilesystemProvider:
 		folder.FsConfig.HTTPConfig = getHTTPFsFromTemplate(folder.FsConfig.HTTPConfig, replacements)
+	case vfs.SMBFilesystemProvider:
+		folder.FsConfig.SMBConfig = getSMBFsFromTemplate(folder.FsConfig.SMBConfig, replacements)
+	case vfs.WebDAVFilesystemProvider:
+		folder.FsConfig.WebDAVConfig = getWebDAVFsFromTemplate(folder.FsConfig.WebDAVConfig, replacements)
+	case vfs.DedupFilesystemProvider:
+		folder.FsConfig.DedupConfig.StorePath = replacePlaceholders(folder.FsConfig.DedupConfig.StorePath, replacements)
 	}
 
 	return folder
@@ -1693,6 +2102,28 @@ func getHTTPFsFromTemplate(fsConfig vfs.HTTPFsConfig, replacements map[string]st
 	return fsConfig
 }
 
+func getSMBFsFromTemplate(fsConfig vfs.SMBFsConfig, replacements map[string]string) vfs.SMBFsConfig {
+	fsConfig.Prefix = replacePlaceholders(fsConfig.Prefix, replacements)
+	fsConfig.Username = replacePlaceholders(fsConfig.Username, replacements)
+	if fsConfig.Password != nil && fsConfig.Password.IsPlain() {
+		payload := replacePlaceholders(fsConfig.Password.GetPayload(), replacements)
+		fsConfig.Password = kms.NewPlainSecret(payload)
+	}
+	return fsConfig
+}
+
+func getWebDAVFsFromTemplate(fsConfig vfs.WebDAVFsConfig, replacements map[string]string) vfs.WebDAVFsConfig {
+	fsConfig.Endpoint = replacePlaceholders(fsConfig.Endpoint, replacements)
+	fsConfig.UploadsEndpoint = replacePlaceholders(fsConfig.UploadsEndpoint, replacements)
+	fsConfig.Prefix = replacePlaceholders(fsConfig.Prefix, replacements)
+	fsConfig.Username = replacePlaceholders(fsConfig.Username, replacements)
+	if fsConfig.Password != nil && fsConfig.Password.IsPlain() {
+		payload := replacePlaceholders(fsConfig.Password.GetPayload(), replacements)
+		fsConfig.Password = kms.NewPlainSecret(payload)
+	}
+	return fsConfig
+}
+
 func getUserFromTemplate(user dataprovider.User, template userTemplateFields) dataprovider.User {
 	user.Username = template.Username
 	user.Password = template.Password
@@ -1729,6 +2160,12 @@ func getUserFromTemplate(user dataprovider.User, template userTemplateFields) da
 		user.FsConfig.SFTPConfig = getSFTPFsFromTemplate(user.FsConfig.SFTPConfig, replacements)
 	case sdk.HTTPFilesystemProvider:
 		user.FsConfig.HTTPConfig = getHTTPFsFromTemplate(user.FsConfig.HTTPConfig, replacements)
+	case vfs.SMBFilesystemProvider:
+		user.FsConfig.SMBConfig = getSMBFsFromTemplate(user.FsConfig.SMBConfig, replacements)
+	case vfs.WebDAVFilesystemProvider:
+		user.FsConfig.WebDAVConfig = getWebDAVFsFromTemplate(user.FsConfig.WebDAVConfig, replacements)
+	case vfs.DedupFilesystemProvider:
+		user.FsConfig.DedupConfig.StorePath = replacePlaceholders(user.FsConfig.DedupConfig.StorePath, replacements)
 	}
 
 	return user
@@ -1818,6 +2255,26 @@ func getUserFromPostFields(r *http.Request) (dataprovider.User, error) {
 	if err != nil {
 		return user, err
 	}
+	portForwarding, err := getPortForwardingFromPostFields(r)
+	if err != nil {
+		return user, err
+	}
+	uploadSizeLimits, err := getUploadSizeLimitsFromPostFields(r)
+	if err != nil {
+		return user, err
+	}
+	multipartUploads, err := getMultipartUploadsFromPostFields(r)
+	if err != nil {
+		return user, err
+	}
+	bwSchedules, err := getBandwidthSchedulesFromPostFields(r)
+	if err != nil {
+		return user, err
+	}
+	transferQuotaReset, err := getTransferQuotaResetFromPostFields(r)
+	if err != nil {
+		return user, err
+	}
 	user = dataprovider.User{
 		BaseUser: sdk.BaseUser{
 			Username:             r.Form.Get("username"),
@@ -1842,11 +2299,25 @@ func getUserFromPostFields(r *http.Request) (dataprovider.User, error) {
 			Description:          r.Form.Get("description"),
 		},
 		Filters: dataprovider.UserFilters{
-			BaseUserFilters: filters,
+			BaseUserFilters:    filters,
+			Language:           strings.TrimSpace(r.Form.Get("language")),
+			FTPPassiveHost:     strings.TrimSpace(r.Form.Get("ftp_passive_host")),
+			FTPModeZ:           r.Form.Get("ftp_mode_z") != "",
+			PasswordPolicy:     strings.TrimSpace(r.Form.Get("password_policy")),
+			AllowedCountries:   getSliceFromDelimitedValues(r.Form.Get("allowed_countries"), ","),
+			DeniedCountries:    getSliceFromDelimitedValues(r.Form.Get("denied_countries"), ","),
+			PortForwarding:     portForwarding,
+			ClientPolicy:       getClientPolicyFromPostFields(r),
+			UploadSizeLimits:   uploadSizeLimits,
+			MultipartUploads:   multipartUploads,
+			BandwidthSchedules: bwSchedules,
+			TransferQuotaReset: transferQuotaReset,
+			PushConfig:         getUserPushConfigFromPostFields(r),
 		},
 		VirtualFolders: getVirtualFoldersFromPostFields(r),
 		FsConfig:       fsConfig,
 		Groups:         getGroupsFromUserPostFields(r),
+		Metadata:       getMetadataFromPostFields(r),
 	}
 	return user, nil
 }
@@ -1887,6 +2358,14 @@ func getGroupFromPostFields(r *http.Request) (dataprovider.Group, error) {
 	if err != nil {
 		return group, err
 	}
+	bwSchedules, err := getBandwidthSchedulesFromPostFields(r)
+	if err != nil {
+		return group, err
+	}
+	transferQuotaReset, err := getTransferQuotaResetFromPostFields(r)
+	if err != nil {
+		return group, err
+	}
 	group = dataprovider.Group{
 		BaseGroup: sdk.BaseGroup{
 			Name:        r.Form.Get("name"),
@@ -1906,9 +2385,17 @@ func getGroupFromPostFields(r *http.Request) (dataprovider.Group, error) {
 				TotalDataTransfer:    dataTransferTotal,
 				Filters:              filters,
 			},
-			FsConfig: fsConfig,
+			FsConfig:           fsConfig,
+			FTPPassiveHost:     strings.TrimSpace(r.Form.Get("ftp_passive_host")),
+			FTPModeZ:           r.Form.Get("ftp_mode_z") != "",
+			PasswordPolicy:     strings.TrimSpace(r.Form.Get("password_policy")),
+			AllowedCountries:   getSliceFromDelimitedValues(r.Form.Get("allowed_countries"), ","),
+			DeniedCountries:    getSliceFromDelimitedValues(r.Form.Get("denied_countries"), ","),
+			BandwidthSchedules: bwSchedules,
+			TransferQuotaReset: transferQuotaReset,
 		},
 		VirtualFolders: getVirtualFoldersFromPostFields(r),
+		Metadata:       getMetadataFromPostFields(r),
 	}
 	return group, nil
 }
@@ -1931,6 +2418,27 @@ func getKeyValsFromPostFields(r *http.Request, key, val string) []dataprovider.K
 	return res
 }
 
+func getMetadataFromPostFields(r *http.Request) util.Metadata {
+	res := make(util.Metadata)
+	for k := range r.Form {
+		if strings.HasPrefix(k, "metadata_key") {
+			key := strings.TrimSpace(r.Form.Get(k))
+			if key == "" {
+				continue
+			}
+			idx := strings.TrimPrefix(k, "metadata_key")
+			res[key] = util.MetadataValue{
+				Type:  r.Form.Get(fmt.Sprintf("metadata_type%s", idx)),
+				Value: strings.TrimSpace(r.Form.Get(fmt.Sprintf("metadata_value%s", idx))),
+			}
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
+
 func getFoldersRetentionFromPostFields(r *http.Request) ([]dataprovider.FolderRetention, error) {
 	var res []dataprovider.FolderRetention
 	for k := range r.Form {
@@ -1955,6 +2463,30 @@ func getFoldersRetentionFromPostFields(r *http.Request) ([]dataprovider.FolderRe
 	return res, nil
 }
 
+func getFoldersStorageTransitionFromPostFields(r *http.Request) ([]dataprovider.FolderStorageTransition, error) {
+	var res []dataprovider.FolderStorageTransition
+	for k := range r.Form {
+		if strings.HasPrefix(k, "folder_transition_path") {
+			folderPath := r.Form.Get(k)
+			if folderPath != "" {
+				idx := strings.TrimPrefix(k, "folder_transition_path")
+				age, err := strconv.Atoi(r.Form.Get(fmt.Sprintf("folder_transition_age%s", idx)))
+				if err != nil {
+					return nil, fmt.Errorf("invalid storage transition age for path %q: %w", folderPath, err)
+				}
+				res = append(res, dataprovider.FolderStorageTransition{
+					Path:            folderPath,
+					Age:             age,
+					S3StorageClass:  r.Form.Get(fmt.Sprintf("folder_transition_s3%s", idx)),
+					GCSStorageClass: r.Form.Get(fmt.Sprintf("folder_transition_gcs%s", idx)),
+					AzureAccessTier: r.Form.Get(fmt.Sprintf("folder_transition_azure%s", idx)),
+				})
+			}
+		}
+	}
+	return res, nil
+}
+
 func getHTTPPartsFromPostFields(r *http.Request) []dataprovider.HTTPPart {
 	var result []dataprovider.HTTPPart
 	for k := range r.Form {
@@ -2008,10 +2540,36 @@ func getEventActionOptionsFromPostFields(r *http.Request) (dataprovider.BaseEven
 	if err != nil {
 		return dataprovider.BaseEventActionOptions{}, err
 	}
+	foldersTransition, err := getFoldersStorageTransitionFromPostFields(r)
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, err
+	}
 	fsActionType, err := strconv.Atoi(r.Form.Get("fs_action_type"))
 	if err != nil {
 		return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid fs action type: %w", err)
 	}
+	var httpRetries int
+	if val := r.Form.Get("http_retries"); val != "" {
+		httpRetries, err = strconv.Atoi(val)
+		if err != nil {
+			return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid http retries: %w", err)
+		}
+	}
+	var samplePercentage int
+	if val := r.Form.Get("integrity_sample_percentage"); val != "" {
+		samplePercentage, err = strconv.Atoi(val)
+		if err != nil {
+			return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid sample percentage: %w", err)
+		}
+	}
+	publishOptions, err := getEventActionPublishOptionsFromPostFields(r)
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, err
+	}
+	summaryReportOptions, err := getEventActionSummaryReportOptionsFromPostFields(r)
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, err
+	}
 	var emailAttachments []string
 	if r.Form.Get("email_attachments") != "" {
 		emailAttachments = strings.Split(strings.ReplaceAll(r.Form.Get("email_attachments"), " ", ""), ",")
@@ -2028,10 +2586,17 @@ func getEventActionOptionsFromPostFields(r *http.Request) (dataprovider.BaseEven
 			Headers:         getKeyValsFromPostFields(r, "http_header_key", "http_header_val"),
 			Timeout:         httpTimeout,
 			SkipTLSVerify:   r.Form.Get("http_skip_tls_verify") != "",
+			SigningSecret:   getSecretFromFormField(r, "http_signing_secret"),
+			Retries:         httpRetries,
 			Method:          r.Form.Get("http_method"),
 			QueryParameters: getKeyValsFromPostFields(r, "http_query_key", "http_query_val"),
 			Body:            r.Form.Get("http_body"),
 			Parts:           getHTTPPartsFromPostFields(r),
+			Transform: dataprovider.HTTPTransform{
+				Filepath: strings.TrimSpace(r.Form.Get("http_transform_path")),
+				Format:   r.Form.Get("http_transform_format"),
+				Template: r.Form.Get("http_transform_template"),
+			},
 		},
 		CmdConfig: dataprovider.EventActionCommandConfig{
 			Cmd:     r.Form.Get("cmd_path"),
@@ -2041,6 +2606,11 @@ func getEventActionOptionsFromPostFields(r *http.Request) (dataprovider.BaseEven
 		},
 		EmailConfig: dataprovider.EventActionEmailConfig{
 			Recipients:  strings.Split(strings.ReplaceAll(r.Form.Get("email_recipients"), " ", ""), ","),
+			Cc:          getSliceFromDelimitedValues(r.Form.Get("email_cc"), ","),
+			Bcc:         getSliceFromDelimitedValues(r.Form.Get("email_bcc"), ","),
+			ReplyTo:     strings.TrimSpace(r.Form.Get("email_reply_to")),
+			Template:    strings.TrimSpace(r.Form.Get("email_template")),
+			Language:    strings.TrimSpace(r.Form.Get("email_language")),
 			Subject:     r.Form.Get("email_subject"),
 			Body:        r.Form.Get("email_body"),
 			Attachments: emailAttachments,
@@ -2059,10 +2629,85 @@ func getEventActionOptionsFromPostFields(r *http.Request) (dataprovider.BaseEven
 				Paths: strings.Split(strings.ReplaceAll(r.Form.Get("fs_compress_paths"), " ", ""), ","),
 			},
 		},
+		TransitionConfig: dataprovider.EventActionStorageTransitionConfig{
+			Folders: foldersTransition,
+			DryRun:  r.Form.Get("transition_dry_run") != "",
+		},
+		IntegrityConfig: dataprovider.EventActionIntegrityCheckConfig{
+			Paths:            getSliceFromDelimitedValues(r.Form.Get("integrity_paths"), ","),
+			SamplePercentage: samplePercentage,
+			ManifestsPath:    strings.TrimSpace(r.Form.Get("integrity_manifests_path")),
+		},
+		PublishConfig:       publishOptions,
+		SummaryReportConfig: summaryReportOptions,
 	}
 	return options, nil
 }
 
+func getEventActionSummaryReportOptionsFromPostFields(r *http.Request) (dataprovider.EventActionSummaryReportConfig, error) {
+	var period, topCount int
+	var err error
+	if val := r.Form.Get("summary_report_period"); val != "" {
+		period, err = strconv.Atoi(val)
+		if err != nil {
+			return dataprovider.EventActionSummaryReportConfig{}, fmt.Errorf("invalid summary report period: %w", err)
+		}
+	}
+	if val := r.Form.Get("summary_report_top_count"); val != "" {
+		topCount, err = strconv.Atoi(val)
+		if err != nil {
+			return dataprovider.EventActionSummaryReportConfig{}, fmt.Errorf("invalid summary report top count: %w", err)
+		}
+	}
+	return dataprovider.EventActionSummaryReportConfig{
+		Recipients: getSliceFromDelimitedValues(r.Form.Get("summary_report_recipients"), ","),
+		Admins:     getSliceFromDelimitedValues(r.Form.Get("summary_report_admins"), ","),
+		Subject:    strings.TrimSpace(r.Form.Get("summary_report_subject")),
+		Language:   strings.TrimSpace(r.Form.Get("summary_report_language")),
+		Period:     period,
+		TopCount:   topCount,
+	}, nil
+}
+
+func getEventActionPublishOptionsFromPostFields(r *http.Request) (dataprovider.EventActionPublishConfig, error) {
+	var timeout, poolSize, delivery int
+	var err error
+	if val := r.Form.Get("publish_timeout"); val != "" {
+		timeout, err = strconv.Atoi(val)
+		if err != nil {
+			return dataprovider.EventActionPublishConfig{}, fmt.Errorf("invalid publish timeout: %w", err)
+		}
+	}
+	if val := r.Form.Get("publish_pool_size"); val != "" {
+		poolSize, err = strconv.Atoi(val)
+		if err != nil {
+			return dataprovider.EventActionPublishConfig{}, fmt.Errorf("invalid publish pool size: %w", err)
+		}
+	}
+	if val := r.Form.Get("publish_delivery"); val != "" {
+		delivery, err = strconv.Atoi(val)
+		if err != nil {
+			return dataprovider.EventActionPublishConfig{}, fmt.Errorf("invalid publish delivery: %w", err)
+		}
+	}
+	return dataprovider.EventActionPublishConfig{
+		Broker:        r.Form.Get("publish_broker"),
+		Endpoint:      strings.TrimSpace(r.Form.Get("publish_endpoint")),
+		Username:      r.Form.Get("publish_username"),
+		Password:      getSecretFromFormField(r, "publish_password"),
+		TLS:           r.Form.Get("publish_tls") != "",
+		SkipTLSVerify: r.Form.Get("publish_skip_tls_verify") != "",
+		Topic:         strings.TrimSpace(r.Form.Get("publish_topic")),
+		Exchange:      strings.TrimSpace(r.Form.Get("publish_exchange")),
+		Key:           r.Form.Get("publish_key"),
+		Headers:       getKeyValsFromPostFields(r, "publish_header_key", "publish_header_val"),
+		Body:          r.Form.Get("publish_body"),
+		Delivery:      delivery,
+		PoolSize:      poolSize,
+		Timeout:       timeout,
+	}, nil
+}
+
 func getEventActionFromPostFields(r *http.Request) (dataprovider.BaseEventAction, error) {
 	err := r.ParseForm()
 	if err != nil {
@@ -2146,6 +2791,13 @@ func getEventRuleConditionsFromPostFields(r *http.Request) (dataprovider.EventCo
 	if err != nil {
 		return dataprovider.EventConditions{}, fmt.Errorf("invalid max file size: %w", err)
 	}
+	var rolloutPercentage int
+	if val := r.Form.Get("rollout_percentage"); val != "" {
+		rolloutPercentage, err = strconv.Atoi(val)
+		if err != nil {
+			return dataprovider.EventConditions{}, fmt.Errorf("invalid rollout percentage: %w", err)
+		}
+	}
 	conditions := dataprovider.EventConditions{
 		FsEvents:       r.Form["fs_events"],
 		ProviderEvents: r.Form["provider_events"],
@@ -2158,7 +2810,12 @@ func getEventRuleConditionsFromPostFields(r *http.Request) (dataprovider.EventCo
 			ProviderObjects:     r.Form["provider_objects"],
 			MinFileSize:         minFileSize,
 			MaxFileSize:         maxFileSize,
+			Countries:           getSliceFromDelimitedValues(r.Form.Get("countries"), ","),
 			ConcurrentExecution: r.Form.Get("concurrent_execution") != "",
+			Rollout: dataprovider.Rollout{
+				Nodes:      getSliceFromDelimitedValues(r.Form.Get("rollout_nodes"), ","),
+				Percentage: rolloutPercentage,
+			},
 		},
 	}
 	return conditions, nil
@@ -2176,6 +2833,17 @@ func getEventRuleActionsFromPostFields(r *http.Request) ([]dataprovider.EventAct
 					return actions, fmt.Errorf("invalid order: %w", err)
 				}
 				options := r.Form[fmt.Sprintf("action_options%s", idx)]
+				var conditions []dataprovider.ActionCondition
+				for _, val := range strings.Split(r.Form.Get(fmt.Sprintf("action_conditions%s", idx)), ";") {
+					if strings.TrimSpace(val) == "" {
+						continue
+					}
+					condition, err := dataprovider.ParseActionCondition(val)
+					if err != nil {
+						return actions, err
+					}
+					conditions = append(conditions, condition)
+				}
 				actions = append(actions, dataprovider.EventAction{
 					BaseEventAction: dataprovider.BaseEventAction{
 						Name: name,
@@ -2185,6 +2853,7 @@ func getEventRuleActionsFromPostFields(r *http.Request) ([]dataprovider.EventAct
 						IsFailureAction: util.Contains(options, "1"),
 						StopOnFailure:   util.Contains(options, "2"),
 						ExecuteSync:     util.Contains(options, "3"),
+						Conditions:      conditions,
 					},
 				})
 			}
@@ -2226,7 +2895,7 @@ func (s *httpdServer) handleWebAdminForgotPwd(w http.ResponseWriter, r *http.Req
 		s.renderNotFoundPage(w, r, errors.New("this page does not exist"))
 		return
 	}
-	s.renderForgotPwdPage(w, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
+	s.renderForgotPwdPage(w, r, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
 }
 
 func (s *httpdServer) handleWebAdminForgotPwdPost(w http.ResponseWriter, r *http.Request) {
@@ -2235,7 +2904,7 @@ func (s *httpdServer) handleWebAdminForgotPwdPost(w http.ResponseWriter, r *http
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	err := r.ParseForm()
 	if err != nil {
-		s.renderForgotPwdPage(w, err.Error(), ipAddr)
+		s.renderForgotPwdPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
@@ -2245,10 +2914,10 @@ func (s *httpdServer) handleWebAdminForgotPwdPost(w http.ResponseWriter, r *http
 	err = handleForgotPassword(r, r.Form.Get("username"), true)
 	if err != nil {
 		if e, ok := err.(*util.ValidationError); ok {
-			s.renderForgotPwdPage(w, e.GetErrorString(), ipAddr)
+			s.renderForgotPwdPage(w, r, e.GetErrorString(), ipAddr)
 			return
 		}
-		s.renderForgotPwdPage(w, err.Error(), ipAddr)
+		s.renderForgotPwdPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	http.Redirect(w, r, webAdminResetPwdPath, http.StatusFound)
@@ -2260,17 +2929,17 @@ func (s *httpdServer) handleWebAdminPasswordReset(w http.ResponseWriter, r *http
 		s.renderNotFoundPage(w, r, errors.New("this page does not exist"))
 		return
 	}
-	s.renderResetPwdPage(w, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
+	s.renderResetPwdPage(w, r, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
 }
 
 func (s *httpdServer) handleWebAdminTwoFactor(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
-	s.renderTwoFactorPage(w, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
+	s.renderTwoFactorPage(w, r, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
 }
 
 func (s *httpdServer) handleWebAdminTwoFactorRecovery(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
-	s.renderTwoFactorRecoveryPage(w, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
+	s.renderTwoFactorRecoveryPage(w, r, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
 }
 
 func (s *httpdServer) handleWebAdminMFA(w http.ResponseWriter, r *http.Request) {
@@ -2313,11 +2982,14 @@ func (s *httpdServer) handleWebAdminProfilePost(w http.ResponseWriter, r *http.R
 	admin.Filters.AllowAPIKeyAuth = r.Form.Get("allow_api_key_auth") != ""
 	admin.Email = r.Form.Get("email")
 	admin.Description = r.Form.Get("description")
+	admin.Filters.Preferences.Theme = r.Form.Get("theme")
+	admin.Filters.Language = r.Form.Get("language")
 	err = dataprovider.UpdateAdmin(&admin, dataprovider.ActionExecutorSelf, ipAddr)
 	if err != nil {
 		s.renderProfilePage(w, r, err.Error())
 		return
 	}
+	r = s.updateSessionPreferences(w, r, admin.Filters.Preferences.Theme, admin.Filters.Language, tokenAudienceWebAdmin)
 	s.renderMessagePage(w, r, "Profile updated", "", http.StatusOK, nil,
 		"Your profile has been successfully updated")
 }
@@ -2372,6 +3044,22 @@ func (s *httpdServer) handleWebRestore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if isApprovalRequired(ApprovalOpRestore) {
+		req, err := newRestoreApprovalRequest(backupContent, "", scanQuota, restoreMode, claims.Username)
+		if err != nil {
+			s.renderMaintenancePage(w, r, err.Error())
+			return
+		}
+		if err := approvalsMgr.Add(req); err != nil {
+			req.removeUpload()
+			s.renderMaintenancePage(w, r, err.Error())
+			return
+		}
+		s.renderMessagePage(w, r, "Approval required", "", http.StatusAccepted, nil,
+			fmt.Sprintf("The restore must be confirmed by a second admin, approval ID: %v", req.ID))
+		return
+	}
+
 	if err := restoreBackup(backupContent, "", scanQuota, restoreMode, claims.Username, ipAddr); err != nil {
 		s.renderMaintenancePage(w, r, err.Error())
 		return
@@ -2409,6 +3097,35 @@ func (s *httpdServer) handleGetWebAdmins(w http.ResponseWriter, r *http.Request)
 	renderAdminTemplate(w, templateAdmins, data)
 }
 
+func (s *httpdServer) handleGetWebAPIKeys(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	limit := defaultQueryLimit
+	if _, ok := r.URL.Query()["qlimit"]; ok {
+		var err error
+		limit, err = strconv.Atoi(r.URL.Query().Get("qlimit"))
+		if err != nil {
+			limit = defaultQueryLimit
+		}
+	}
+	apiKeys := make([]dataprovider.APIKey, 0, limit)
+	for {
+		keys, err := dataprovider.GetAPIKeys(limit, len(apiKeys), dataprovider.OrderASC)
+		if err != nil {
+			s.renderInternalServerErrorPage(w, r, err)
+			return
+		}
+		apiKeys = append(apiKeys, keys...)
+		if len(keys) < limit {
+			break
+		}
+	}
+	data := apiKeysPage{
+		basePage: s.getBasePageData(pageAPIKeysTitle, webAPIKeysPath, r),
+		APIKeys:  apiKeys,
+	}
+	renderAdminTemplate(w, templateAPIKeys, data)
+}
+
 func (s *httpdServer) handleWebAdminSetupGet(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
 	if dataprovider.HasAdmin() {
@@ -2498,6 +3215,8 @@ func (s *httpdServer) handleWebUpdateAdminPost(w http.ResponseWriter, r *http.Re
 	}
 	updatedAdmin.Filters.TOTPConfig = admin.Filters.TOTPConfig
 	updatedAdmin.Filters.RecoveryCodes = admin.Filters.RecoveryCodes
+	updatedAdmin.Filters.WebAuthnCredentials = admin.Filters.WebAuthnCredentials
+	updatedAdmin.Filters.Preferences.Theme = admin.Filters.Preferences.Theme
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
 		s.renderAddUpdateAdminPage(w, r, &updatedAdmin, "Invalid token claims", false)
@@ -2531,6 +3250,16 @@ func (s *httpdServer) handleWebDefenderPage(w http.ResponseWriter, r *http.Reque
 	renderAdminTemplate(w, templateDefender, data)
 }
 
+func (s *httpdServer) handleWebApprovalsPage(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	data := approvalsPage{
+		basePage:             s.getBasePageData(pageApprovalsTitle, webApprovalsPath, r),
+		ApprovalsRequestsURL: webApprovalsRequestsPath,
+	}
+
+	renderAdminTemplate(w, templateApprovals, data)
+}
+
 func (s *httpdServer) handleGetWebUsers(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	var limit int
@@ -2543,9 +3272,16 @@ func (s *httpdServer) handleGetWebUsers(w http.ResponseWriter, r *http.Request)
 	} else {
 		limit = defaultQueryLimit
 	}
+	deleted := getBoolQueryParam(r, "deleted")
 	users := make([]dataprovider.User, 0, limit)
 	for {
-		u, err := dataprovider.GetUsers(limit, len(users), dataprovider.OrderASC)
+		var u []dataprovider.User
+		var err error
+		if deleted {
+			u, err = dataprovider.GetSoftDeletedUsers(limit, len(users), dataprovider.OrderASC)
+		} else {
+			u, err = dataprovider.GetUsers(limit, len(users), dataprovider.OrderASC)
+		}
 		if err != nil {
 			s.renderInternalServerErrorPage(w, r, err)
 			return
@@ -2556,8 +3292,13 @@ func (s *httpdServer) handleGetWebUsers(w http.ResponseWriter, r *http.Request)
 		}
 	}
 	data := usersPage{
-		basePage: s.getBasePageData(pageUsersTitle, webUsersPath, r),
-		Users:    users,
+		basePage:          s.getBasePageData(pageUsersTitle, webUsersPath, r),
+		Users:             users,
+		DeletedUsers:      deleted,
+		SoftDeleteEnabled: dataprovider.IsSoftDeleteEnabled(),
+	}
+	if s.enableWebClient {
+		data.ClientFilesURL = webClientFilesPath
 	}
 	renderAdminTemplate(w, templateUsers, data)
 }
@@ -2604,6 +3345,7 @@ func (s *httpdServer) handleWebTemplateFolderPost(w http.ResponseWriter, r *http
 
 	templateFolder.MappedPath = r.Form.Get("mapped_path")
 	templateFolder.Description = r.Form.Get("description")
+	templateFolder.Metadata = getMetadataFromPostFields(r)
 	fsConfig, err := getFsConfigFromPostFields(r)
 	if err != nil {
 		s.renderMessagePage(w, r, "Error parsing folders fields", "", http.StatusBadRequest, err, "")
@@ -2810,15 +3552,24 @@ func (s *httpdServer) handleWebUpdateUserPost(w http.ResponseWriter, r *http.Req
 	updatedUser.ID = user.ID
 	updatedUser.Username = user.Username
 	updatedUser.Filters.RecoveryCodes = user.Filters.RecoveryCodes
+	updatedUser.Filters.WebAuthnCredentials = user.Filters.WebAuthnCredentials
 	updatedUser.Filters.TOTPConfig = user.Filters.TOTPConfig
+	updatedUser.Filters.PasswordChangedAt = user.Filters.PasswordChangedAt
+	updatedUser.Filters.PasswordHistory = user.Filters.PasswordHistory
+	updatedUser.Filters.S3SecretAccessKey = user.Filters.S3SecretAccessKey
+	updatedUser.Filters.Theme = user.Filters.Theme
+	// the SFTP remote credentials can be managed using the REST API only
+	updatedUser.Filters.SFTPCredentials = user.Filters.SFTPCredentials
 	updatedUser.SetEmptySecretsIfNil()
 	if updatedUser.Password == redactedSecret {
 		updatedUser.Password = user.Password
 	}
 	updateEncryptedSecrets(&updatedUser.FsConfig, user.FsConfig.S3Config.AccessSecret, user.FsConfig.AzBlobConfig.AccountKey,
-		user.FsConfig.AzBlobConfig.SASURL, user.FsConfig.GCSConfig.Credentials, user.FsConfig.CryptConfig.Passphrase,
+		user.FsConfig.AzBlobConfig.SASURL, user.FsConfig.GCSConfig.Credentials, user.FsConfig.GCSConfig.EncryptionKey,
+		user.FsConfig.CryptConfig.Passphrase,
 		user.FsConfig.SFTPConfig.Password, user.FsConfig.SFTPConfig.PrivateKey, user.FsConfig.SFTPConfig.KeyPassphrase,
-		user.FsConfig.HTTPConfig.Password, user.FsConfig.HTTPConfig.APIKey)
+		user.FsConfig.HTTPConfig.Password, user.FsConfig.HTTPConfig.APIKey, user.FsConfig.SMBConfig.Password,
+		user.FsConfig.WebDAVConfig.Password)
 
 	updatedUser = getUserFromTemplate(updatedUser, userTemplateFields{
 		Username:   updatedUser.Username,
@@ -2890,6 +3641,7 @@ func (s *httpdServer) handleWebAddFolderPost(w http.ResponseWriter, r *http.Requ
 	folder.MappedPath = r.Form.Get("mapped_path")
 	folder.Name = r.Form.Get("name")
 	folder.Description = r.Form.Get("description")
+	folder.Metadata = getMetadataFromPostFields(r)
 	fsConfig, err := getFsConfigFromPostFields(r)
 	if err != nil {
 		s.renderFolderPage(w, r, folder, folderPageModeAdd, err.Error())
@@ -2956,15 +3708,18 @@ func (s *httpdServer) handleWebUpdateFolderPost(w http.ResponseWriter, r *http.R
 	updatedFolder := vfs.BaseVirtualFolder{
 		MappedPath:  r.Form.Get("mapped_path"),
 		Description: r.Form.Get("description"),
+		Metadata:    getMetadataFromPostFields(r),
 	}
 	updatedFolder.ID = folder.ID
 	updatedFolder.Name = folder.Name
 	updatedFolder.FsConfig = fsConfig
 	updatedFolder.FsConfig.SetEmptySecretsIfNil()
 	updateEncryptedSecrets(&updatedFolder.FsConfig, folder.FsConfig.S3Config.AccessSecret, folder.FsConfig.AzBlobConfig.AccountKey,
-		folder.FsConfig.AzBlobConfig.SASURL, folder.FsConfig.GCSConfig.Credentials, folder.FsConfig.CryptConfig.Passphrase,
+		folder.FsConfig.AzBlobConfig.SASURL, folder.FsConfig.GCSConfig.Credentials, folder.FsConfig.GCSConfig.EncryptionKey,
+		folder.FsConfig.CryptConfig.Passphrase,
 		folder.FsConfig.SFTPConfig.Password, folder.FsConfig.SFTPConfig.PrivateKey, folder.FsConfig.SFTPConfig.KeyPassphrase,
-		folder.FsConfig.HTTPConfig.Password, folder.FsConfig.HTTPConfig.APIKey)
+		folder.FsConfig.HTTPConfig.Password, folder.FsConfig.HTTPConfig.APIKey, folder.FsConfig.SMBConfig.Password,
+		folder.FsConfig.WebDAVConfig.Password)
 
 	updatedFolder = getFolderFromTemplate(updatedFolder, updatedFolder.Name)
 
@@ -2992,6 +3747,22 @@ func (s *httpdServer) getWebVirtualFolders(w http.ResponseWriter, r *http.Reques
 	return folders, nil
 }
 
+func (s *httpdServer) getWebSoftDeletedFolders(w http.ResponseWriter, r *http.Request, limit int) ([]vfs.BaseVirtualFolder, error) {
+	folders := make([]vfs.BaseVirtualFolder, 0, limit)
+	for {
+		f, err := dataprovider.GetSoftDeletedFolders(limit, len(folders), dataprovider.OrderASC)
+		if err != nil {
+			s.renderInternalServerErrorPage(w, r, err)
+			return folders, err
+		}
+		folders = append(folders, f...)
+		if len(f) < limit {
+			break
+		}
+	}
+	return folders, nil
+}
+
 func (s *httpdServer) handleWebGetFolders(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	limit := defaultQueryLimit
@@ -3002,14 +3773,23 @@ func (s *httpdServer) handleWebGetFolders(w http.ResponseWriter, r *http.Request
 			limit = defaultQueryLimit
 		}
 	}
-	folders, err := s.getWebVirtualFolders(w, r, limit, false)
+	deleted := getBoolQueryParam(r, "deleted")
+	var folders []vfs.BaseVirtualFolder
+	var err error
+	if deleted {
+		folders, err = s.getWebSoftDeletedFolders(w, r, limit)
+	} else {
+		folders, err = s.getWebVirtualFolders(w, r, limit, false)
+	}
 	if err != nil {
 		return
 	}
 
 	data := foldersPage{
-		basePage: s.getBasePageData(pageFoldersTitle, webFoldersPath, r),
-		Folders:  folders,
+		basePage:          s.getBasePageData(pageFoldersTitle, webFoldersPath, r),
+		Folders:           folders,
+		DeletedFolders:    deleted,
+		SoftDeleteEnabled: dataprovider.IsSoftDeleteEnabled(),
 	}
 	renderAdminTemplate(w, templateFolders, data)
 }
@@ -3127,10 +3907,12 @@ func (s *httpdServer) handleWebUpdateGroupPost(w http.ResponseWriter, r *http.Re
 
 	updateEncryptedSecrets(&updatedGroup.UserSettings.FsConfig, group.UserSettings.FsConfig.S3Config.AccessSecret,
 		group.UserSettings.FsConfig.AzBlobConfig.AccountKey, group.UserSettings.FsConfig.AzBlobConfig.SASURL,
-		group.UserSettings.FsConfig.GCSConfig.Credentials, group.UserSettings.FsConfig.CryptConfig.Passphrase,
+		group.UserSettings.FsConfig.GCSConfig.Credentials, group.UserSettings.FsConfig.GCSConfig.EncryptionKey,
+		group.UserSettings.FsConfig.CryptConfig.Passphrase,
 		group.UserSettings.FsConfig.SFTPConfig.Password, group.UserSettings.FsConfig.SFTPConfig.PrivateKey,
 		group.UserSettings.FsConfig.SFTPConfig.KeyPassphrase, group.UserSettings.FsConfig.HTTPConfig.Password,
-		group.UserSettings.FsConfig.HTTPConfig.APIKey)
+		group.UserSettings.FsConfig.HTTPConfig.APIKey, group.UserSettings.FsConfig.SMBConfig.Password,
+		group.UserSettings.FsConfig.WebDAVConfig.Password)
 
 	err = dataprovider.UpdateGroup(&updatedGroup, group.Users, claims.Username, ipAddr)
 	if err != nil {
@@ -3258,6 +4040,13 @@ func (s *httpdServer) handleWebUpdateEventActionPost(w http.ResponseWriter, r *h
 		if updatedAction.Options.HTTPConfig.Password.IsNotPlainAndNotEmpty() {
 			updatedAction.Options.HTTPConfig.Password = action.Options.HTTPConfig.Password
 		}
+		if updatedAction.Options.HTTPConfig.SigningSecret.IsNotPlainAndNotEmpty() {
+			updatedAction.Options.HTTPConfig.SigningSecret = action.Options.HTTPConfig.SigningSecret
+		}
+	case dataprovider.ActionTypePublish:
+		if updatedAction.Options.PublishConfig.Password.IsNotPlainAndNotEmpty() {
+			updatedAction.Options.PublishConfig.Password = action.Options.PublishConfig.Password
+		}
 	}
 	err = dataprovider.UpdateEventAction(&updatedAction, claims.Username, ipAddr)
 	if err != nil {
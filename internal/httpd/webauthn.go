@@ -0,0 +1,524 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/mfa"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	webAuthnMgr webAuthnSessionManager
+)
+
+type webAuthnSessionManager interface {
+	Add(session *webAuthnSession) error
+	Get(id string) (*webAuthnSession, error)
+	Delete(id string) error
+	Cleanup()
+}
+
+func newWebAuthnSessionManager(isShared int) webAuthnSessionManager {
+	if isShared == 1 {
+		logger.Info(logSender, "", "using provider WebAuthn session manager")
+		return &dbWebAuthnSessionManager{}
+	}
+	logger.Info(logSender, "", "using memory WebAuthn session manager")
+	return &memoryWebAuthnSessionManager{}
+}
+
+// webAuthnSession stores, server side, the challenge for an in progress
+// WebAuthn registration or login
+type webAuthnSession struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	IsAdmin   bool      `json:"is_admin"`
+	IsLogin   bool      `json:"is_login"`
+	Data      []byte    `json:"data"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func newWebAuthnSession(username string, isAdmin, isLogin bool, data []byte) *webAuthnSession {
+	return &webAuthnSession{
+		ID:        util.GenerateUniqueID(),
+		Username:  username,
+		IsAdmin:   isAdmin,
+		IsLogin:   isLogin,
+		Data:      data,
+		ExpiresAt: time.Now().Add(mfa.GetWebAuthnTimeout() + 30*time.Second).UTC(),
+	}
+}
+
+func (s *webAuthnSession) isExpired() bool {
+	return s.ExpiresAt.Before(time.Now().UTC())
+}
+
+type memoryWebAuthnSessionManager struct {
+	sessions sync.Map
+}
+
+func (m *memoryWebAuthnSessionManager) Add(session *webAuthnSession) error {
+	m.sessions.Store(session.ID, session)
+	return nil
+}
+
+func (m *memoryWebAuthnSessionManager) Get(id string) (*webAuthnSession, error) {
+	s, ok := m.sessions.Load(id)
+	if !ok {
+		return nil, util.NewRecordNotFoundError("WebAuthn session not found")
+	}
+	session := s.(*webAuthnSession)
+	if session.isExpired() {
+		return nil, util.NewRecordNotFoundError("WebAuthn session expired")
+	}
+	return session, nil
+}
+
+func (m *memoryWebAuthnSessionManager) Delete(id string) error {
+	m.sessions.Delete(id)
+	return nil
+}
+
+func (m *memoryWebAuthnSessionManager) Cleanup() {
+	m.sessions.Range(func(key, value any) bool {
+		s, ok := value.(*webAuthnSession)
+		if !ok || s.isExpired() {
+			m.sessions.Delete(key)
+		}
+		return true
+	})
+}
+
+type dbWebAuthnSessionManager struct{}
+
+func (m *dbWebAuthnSessionManager) Add(session *webAuthnSession) error {
+	s := dataprovider.Session{
+		Key:       session.ID,
+		Data:      session,
+		Type:      dataprovider.SessionTypeWebAuthn,
+		Timestamp: util.GetTimeAsMsSinceEpoch(session.ExpiresAt),
+	}
+	return dataprovider.AddSharedSession(s)
+}
+
+func (m *dbWebAuthnSessionManager) Get(id string) (*webAuthnSession, error) {
+	s, err := dataprovider.GetSharedSession(id)
+	if err != nil {
+		return nil, err
+	}
+	if s.Timestamp < util.GetTimeAsMsSinceEpoch(time.Now()) {
+		// expired
+		return nil, util.NewRecordNotFoundError("WebAuthn session expired")
+	}
+	if val, ok := s.Data.([]byte); ok {
+		session := &webAuthnSession{}
+		err := json.Unmarshal(val, session)
+		return session, err
+	}
+	logger.Error(logSender, "", "invalid WebAuthn session data type %T", s.Data)
+	return nil, util.NewRecordNotFoundError("invalid WebAuthn session")
+}
+
+func (m *dbWebAuthnSessionManager) Delete(id string) error {
+	return dataprovider.DeleteSharedSession(id)
+}
+
+func (m *dbWebAuthnSessionManager) Cleanup() {
+	dataprovider.CleanupSharedSessions(dataprovider.SessionTypeWebAuthn, time.Now()) //nolint:errcheck
+}
+
+// getWebAuthnSessionData returns the data for the specified session if it matches
+// the account and the ceremony type. Sessions can only be used once
+func getWebAuthnSessionData(id, username string, isAdmin, isLogin bool) ([]byte, error) {
+	if id == "" {
+		return nil, util.NewValidationError("WebAuthn session ID is required")
+	}
+	session, err := webAuthnMgr.Get(id)
+	if err != nil {
+		return nil, util.NewValidationError("invalid WebAuthn session")
+	}
+	if err := webAuthnMgr.Delete(id); err != nil {
+		logger.Warn(logSender, "", "unable to delete WebAuthn session %q: %v", id, err)
+	}
+	if session.Username != username || session.IsAdmin != isAdmin || session.IsLogin != isLogin {
+		return nil, util.NewValidationError("invalid WebAuthn session")
+	}
+	return session.Data, nil
+}
+
+// webAuthnCredentialView is a registered credential as displayed in the web pages
+type webAuthnCredentialView struct {
+	ID        string
+	Name      string
+	CreatedAt string
+	LastUseAt string
+}
+
+func getWebAuthnCredentialViews(credentials []mfa.WebAuthnCredential) []webAuthnCredentialView {
+	result := make([]webAuthnCredentialView, 0, len(credentials))
+	for _, c := range credentials {
+		view := webAuthnCredentialView{
+			ID:        base64.RawURLEncoding.EncodeToString(c.ID),
+			Name:      c.Name,
+			CreatedAt: util.GetTimeFromMsecSinceEpoch(c.CreatedAt).Format("2006-01-02 15:04"),
+		}
+		if c.LastUseAt > 0 {
+			view.LastUseAt = util.GetTimeFromMsecSinceEpoch(c.LastUseAt).Format("2006-01-02 15:04")
+		}
+		result = append(result, view)
+	}
+	return result
+}
+
+type webAuthnBeginResponse struct {
+	SessionID string          `json:"session_id"`
+	Options   json.RawMessage `json:"options"`
+}
+
+type webAuthnFinishRegistrationRequest struct {
+	SessionID string          `json:"session_id"`
+	Name      string          `json:"name"`
+	Response  json.RawMessage `json:"response"`
+}
+
+func beginWebAuthnCeremony(w http.ResponseWriter, r *http.Request, username string, isAdmin, isLogin bool,
+	beginFunc func(*mfa.WebAuthnUser) ([]byte, []byte, error), webAuthnUser *mfa.WebAuthnUser,
+) {
+	options, data, err := beginFunc(webAuthnUser)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	session := newWebAuthnSession(username, isAdmin, isLogin, data)
+	if err := webAuthnMgr.Add(session); err != nil {
+		sendAPIResponse(w, r, err, "Unable to save the WebAuthn session", http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, webAuthnBeginResponse{
+		SessionID: session.ID,
+		Options:   options,
+	})
+}
+
+func beginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	if claims.hasUserAudience() {
+		user, err := dataprovider.UserExists(claims.Username)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		beginWebAuthnCeremony(w, r, user.Username, false, false, mfa.BeginWebAuthnRegistration, user.GetWebAuthnUser())
+		return
+	}
+	admin, err := dataprovider.AdminExists(claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	beginWebAuthnCeremony(w, r, admin.Username, true, false, mfa.BeginWebAuthnRegistration, admin.GetWebAuthnUser())
+}
+
+func finishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	var req webAuthnFinishRegistrationRequest
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		sendAPIResponse(w, r, nil, "A name for the security key is required", http.StatusBadRequest)
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if claims.hasUserAudience() {
+		if err := saveUserWebAuthnCredential(claims.Username, &req, ipAddr); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		if claims.MustSetTwoFactorAuth {
+			// force logout
+			defer func() {
+				c := jwtTokenClaims{}
+				c.removeCookie(w, r, webBaseClientPath)
+			}()
+		}
+	} else {
+		if err := saveAdminWebAuthnCredential(claims.Username, &req, ipAddr); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		if claims.MustSetTwoFactorAuth {
+			// force logout
+			defer func() {
+				c := jwtTokenClaims{}
+				c.removeCookie(w, r, webBaseAdminPath)
+			}()
+		}
+	}
+
+	sendAPIResponse(w, r, nil, "Security key registered", http.StatusCreated)
+}
+
+func deleteWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	credentialID, err := base64.RawURLEncoding.DecodeString(getURLParam(r, "id"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "Invalid credential ID", http.StatusBadRequest)
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if claims.hasUserAudience() {
+		err = deleteUserWebAuthnCredential(claims.Username, credentialID, ipAddr)
+	} else {
+		err = deleteAdminWebAuthnCredential(claims.Username, credentialID, ipAddr)
+	}
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Security key deleted", http.StatusOK)
+}
+
+func getNewRecoveryCodesIfNeeded(unused int) []dataprovider.RecoveryCode {
+	if unused >= 5 {
+		return nil
+	}
+	recoveryCodes := make([]dataprovider.RecoveryCode, 0, 12)
+	for i := 0; i < 12; i++ {
+		recoveryCodes = append(recoveryCodes, dataprovider.RecoveryCode{Secret: kms.NewPlainSecret(getNewRecoveryCode())})
+	}
+	return recoveryCodes
+}
+
+func saveUserWebAuthnCredential(username string, req *webAuthnFinishRegistrationRequest, ipAddr string) error {
+	user, err := dataprovider.UserExists(username)
+	if err != nil {
+		return err
+	}
+	data, err := getWebAuthnSessionData(req.SessionID, user.Username, false, false)
+	if err != nil {
+		return err
+	}
+	credential, err := mfa.FinishWebAuthnRegistration(user.GetWebAuthnUser(), data, req.Response)
+	if err != nil {
+		return util.NewValidationError(err.Error())
+	}
+	credential.Name = req.Name
+	user.Filters.WebAuthnCredentials = append(user.Filters.WebAuthnCredentials, credential)
+	if codes := getNewRecoveryCodesIfNeeded(user.CountUnusedRecoveryCodes()); codes != nil {
+		user.Filters.RecoveryCodes = codes
+	}
+	return dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSelf, ipAddr)
+}
+
+func saveAdminWebAuthnCredential(username string, req *webAuthnFinishRegistrationRequest, ipAddr string) error {
+	admin, err := dataprovider.AdminExists(username)
+	if err != nil {
+		return err
+	}
+	data, err := getWebAuthnSessionData(req.SessionID, admin.Username, true, false)
+	if err != nil {
+		return err
+	}
+	credential, err := mfa.FinishWebAuthnRegistration(admin.GetWebAuthnUser(), data, req.Response)
+	if err != nil {
+		return util.NewValidationError(err.Error())
+	}
+	credential.Name = req.Name
+	admin.Filters.WebAuthnCredentials = append(admin.Filters.WebAuthnCredentials, credential)
+	if codes := getNewRecoveryCodesIfNeeded(admin.CountUnusedRecoveryCodes()); codes != nil {
+		admin.Filters.RecoveryCodes = codes
+	}
+	return dataprovider.UpdateAdmin(&admin, dataprovider.ActionExecutorSelf, ipAddr)
+}
+
+func removeWebAuthnCredential(credentials []mfa.WebAuthnCredential, id []byte) ([]mfa.WebAuthnCredential, error) {
+	for idx := range credentials {
+		if bytes.Equal(credentials[idx].ID, id) {
+			return append(credentials[:idx], credentials[idx+1:]...), nil
+		}
+	}
+	return credentials, util.NewRecordNotFoundError("security key not found")
+}
+
+func deleteUserWebAuthnCredential(username string, id []byte, ipAddr string) error {
+	user, err := dataprovider.UserExists(username)
+	if err != nil {
+		return err
+	}
+	user.Filters.WebAuthnCredentials, err = removeWebAuthnCredential(user.Filters.WebAuthnCredentials, id)
+	if err != nil {
+		return err
+	}
+	if len(user.Filters.WebAuthnCredentials) == 0 && !user.Filters.TOTPConfig.Enabled {
+		user.Filters.RecoveryCodes = nil
+	}
+	return dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSelf, ipAddr)
+}
+
+func deleteAdminWebAuthnCredential(username string, id []byte, ipAddr string) error {
+	admin, err := dataprovider.AdminExists(username)
+	if err != nil {
+		return err
+	}
+	admin.Filters.WebAuthnCredentials, err = removeWebAuthnCredential(admin.Filters.WebAuthnCredentials, id)
+	if err != nil {
+		return err
+	}
+	if admin.MustSetSecondFactor() {
+		return util.NewValidationError("the two-factor authentication policy does not allow to delete this security key")
+	}
+	if len(admin.Filters.WebAuthnCredentials) == 0 && !admin.Filters.TOTPConfig.Enabled {
+		admin.Filters.RecoveryCodes = nil
+	}
+	return dataprovider.UpdateAdmin(&admin, dataprovider.ActionExecutorSelf, ipAddr)
+}
+
+func (s *httpdServer) handleWebAdminTwoFactorWebAuthn(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	admin, err := dataprovider.AdminExists(claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	beginWebAuthnCeremony(w, r, admin.Username, true, true, mfa.BeginWebAuthnLogin, admin.GetWebAuthnUser())
+}
+
+func (s *httpdServer) handleWebClientTwoFactorWebAuthn(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.UserExists(claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	beginWebAuthnCeremony(w, r, user.Username, false, true, mfa.BeginWebAuthnLogin, user.GetWebAuthnUser())
+}
+
+// checkAdminWebAuthnLogin validates a WebAuthn login response and updates the
+// signature counter for the used credential
+func checkAdminWebAuthnLogin(admin *dataprovider.Admin, sessionID, response, ipAddr string) error {
+	if !admin.HasWebAuthnCredentials() {
+		return errors.New("no security key registered")
+	}
+	data, err := getWebAuthnSessionData(sessionID, admin.Username, true, true)
+	if err != nil {
+		return err
+	}
+	idx, credential, err := mfa.FinishWebAuthnLogin(admin.GetWebAuthnUser(), data, []byte(response))
+	if err != nil {
+		return err
+	}
+	admin.Filters.WebAuthnCredentials[idx] = credential
+	if err := dataprovider.UpdateAdmin(admin, dataprovider.ActionExecutorSelf, ipAddr); err != nil {
+		logger.Warn(logSender, "", "unable to update the WebAuthn credential %q for admin %q: %v",
+			credential.Name, admin.Username, err)
+		return fmt.Errorf("unable to update the security key: %w", err)
+	}
+	return nil
+}
+
+// checkUserWebAuthnLogin validates a WebAuthn login response and updates the
+// signature counter for the used credential
+func checkUserWebAuthnLogin(user *dataprovider.User, sessionID, response, ipAddr string) error {
+	if !user.HasWebAuthnCredentials() {
+		return errors.New("no security key registered")
+	}
+	data, err := getWebAuthnSessionData(sessionID, user.Username, false, true)
+	if err != nil {
+		return err
+	}
+	idx, credential, err := mfa.FinishWebAuthnLogin(user.GetWebAuthnUser(), data, []byte(response))
+	if err != nil {
+		return err
+	}
+	user.Filters.WebAuthnCredentials[idx] = credential
+	if err := dataprovider.UpdateUser(user, dataprovider.ActionExecutorSelf, ipAddr); err != nil {
+		logger.Warn(logSender, "", "unable to update the WebAuthn credential %q for user %q: %v",
+			credential.Name, user.Username, err)
+		return fmt.Errorf("unable to update the security key: %w", err)
+	}
+	return nil
+}
+
+func (s *httpdServer) handleWebClientTwoFactorWebAuthnPost(w http.ResponseWriter, r *http.Request, username,
+	response, ipAddr string,
+) {
+	user, userMerged, err := dataprovider.GetUserVariants(username)
+	if err != nil {
+		s.renderClientTwoFactorPage(w, r, "Invalid credentials", ipAddr)
+		return
+	}
+	if err := checkUserWebAuthnLogin(&user, r.Form.Get("webauthn_session"), response, ipAddr); err != nil {
+		logger.Debug(logSender, "", "WebAuthn login failed for user %q: %v", user.Username, err)
+		s.renderClientTwoFactorPage(w, r, "Security key verification failed", ipAddr)
+		return
+	}
+	userMerged.Filters.WebAuthnCredentials = user.Filters.WebAuthnCredentials
+	connectionID := fmt.Sprintf("%v_%v", getProtocolFromRequest(r), xid.New().String())
+	s.loginUser(w, r, &userMerged, connectionID, ipAddr, true, s.renderClientTwoFactorPage)
+}
+
+// isUserWebClientSecondFactorEnabled returns true if the user must provide a
+// second factor to login to the WebClient
+func isUserWebClientSecondFactorEnabled(user *dataprovider.User) bool {
+	if user.Filters.TOTPConfig.Enabled && util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) {
+		return true
+	}
+	return user.HasWebAuthnCredentials()
+}
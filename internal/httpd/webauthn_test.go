@@ -0,0 +1,245 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/mfa"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func getTestWebAuthnCredential(name string) mfa.WebAuthnCredential {
+	return mfa.WebAuthnCredential{
+		ID:              util.GenerateRandomBytes(16),
+		Name:            name,
+		PublicKey:       util.GenerateRandomBytes(64),
+		AttestationType: "none",
+		CreatedAt:       util.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+}
+
+func TestWebAuthnSessions(t *testing.T) {
+	mgr := newWebAuthnSessionManager(0)
+	_, ok := mgr.(*memoryWebAuthnSessionManager)
+	require.True(t, ok)
+	_, ok = newWebAuthnSessionManager(1).(*dbWebAuthnSessionManager)
+	require.True(t, ok)
+
+	session := newWebAuthnSession("user", false, true, []byte("data"))
+	err := mgr.Add(session)
+	assert.NoError(t, err)
+	s, err := mgr.Get(session.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), s.Data)
+	_, err = mgr.Get(util.GenerateUniqueID())
+	assert.IsType(t, &util.RecordNotFoundError{}, err)
+
+	expired := newWebAuthnSession("user", false, true, nil)
+	expired.ExpiresAt = time.Now().Add(-1 * time.Minute).UTC()
+	err = mgr.Add(expired)
+	assert.NoError(t, err)
+	_, err = mgr.Get(expired.ID)
+	assert.IsType(t, &util.RecordNotFoundError{}, err)
+	mgr.Cleanup()
+	_, ok = mgr.(*memoryWebAuthnSessionManager).sessions.Load(expired.ID)
+	assert.False(t, ok)
+	_, ok = mgr.(*memoryWebAuthnSessionManager).sessions.Load(session.ID)
+	assert.True(t, ok)
+	err = mgr.Delete(session.ID)
+	assert.NoError(t, err)
+	_, err = mgr.Get(session.ID)
+	assert.Error(t, err)
+}
+
+func TestWebAuthnSessionData(t *testing.T) {
+	_, err := getWebAuthnSessionData("", "user", false, false)
+	assert.IsType(t, &util.ValidationError{}, err)
+	_, err = getWebAuthnSessionData(util.GenerateUniqueID(), "user", false, false)
+	assert.IsType(t, &util.ValidationError{}, err)
+
+	session := newWebAuthnSession("user", false, false, []byte("registration"))
+	err = webAuthnMgr.Add(session)
+	assert.NoError(t, err)
+	data, err := getWebAuthnSessionData(session.ID, "user", false, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("registration"), data)
+	// sessions can only be used once
+	_, err = getWebAuthnSessionData(session.ID, "user", false, false)
+	assert.IsType(t, &util.ValidationError{}, err)
+	// a session cannot be used for a different account or ceremony
+	for _, test := range []struct {
+		username string
+		isAdmin  bool
+		isLogin  bool
+	}{
+		{username: "user1", isAdmin: false, isLogin: false},
+		{username: "user", isAdmin: true, isLogin: false},
+		{username: "user", isAdmin: false, isLogin: true},
+	} {
+		session = newWebAuthnSession("user", false, false, []byte("registration"))
+		err = webAuthnMgr.Add(session)
+		assert.NoError(t, err)
+		_, err = getWebAuthnSessionData(session.ID, test.username, test.isAdmin, test.isLogin)
+		assert.IsType(t, &util.ValidationError{}, err)
+		_, err = webAuthnMgr.Get(session.ID)
+		assert.Error(t, err)
+	}
+}
+
+func TestWebAuthnCredentialHelpers(t *testing.T) {
+	credentials := []mfa.WebAuthnCredential{
+		getTestWebAuthnCredential("key1"),
+		getTestWebAuthnCredential("key2"),
+	}
+	credentials[1].LastUseAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	views := getWebAuthnCredentialViews(credentials)
+	if assert.Len(t, views, 2) {
+		assert.Equal(t, base64.RawURLEncoding.EncodeToString(credentials[0].ID), views[0].ID)
+		assert.Equal(t, "key1", views[0].Name)
+		assert.NotEmpty(t, views[0].CreatedAt)
+		assert.Empty(t, views[0].LastUseAt)
+		assert.NotEmpty(t, views[1].LastUseAt)
+	}
+
+	_, err := removeWebAuthnCredential(credentials, []byte("missing"))
+	assert.IsType(t, &util.RecordNotFoundError{}, err)
+	credentials, err = removeWebAuthnCredential(credentials, credentials[0].ID)
+	assert.NoError(t, err)
+	if assert.Len(t, credentials, 1) {
+		assert.Equal(t, "key2", credentials[0].Name)
+	}
+
+	assert.Len(t, getNewRecoveryCodesIfNeeded(0), 12)
+	assert.Len(t, getNewRecoveryCodesIfNeeded(4), 12)
+	assert.Nil(t, getNewRecoveryCodesIfNeeded(5))
+
+	user := dataprovider.User{}
+	assert.False(t, isUserWebClientSecondFactorEnabled(&user))
+	user.Filters.TOTPConfig.Enabled = true
+	user.Filters.TOTPConfig.Protocols = []string{common.ProtocolSSH}
+	assert.False(t, isUserWebClientSecondFactorEnabled(&user))
+	user.Filters.TOTPConfig.Protocols = []string{common.ProtocolSSH, common.ProtocolHTTP}
+	assert.True(t, isUserWebClientSecondFactorEnabled(&user))
+	user.Filters.TOTPConfig.Enabled = false
+	user.Filters.WebAuthnCredentials = credentials
+	// registered security keys are ignored if WebAuthn is disabled
+	assert.False(t, isUserWebClientSecondFactorEnabled(&user))
+}
+
+func TestWebAuthnRegistrationDisabled(t *testing.T) {
+	require.False(t, mfa.IsWebAuthnEnabled())
+	tokenAuth := jwtauth.New(jwa.HS256.String(), util.GenerateRandomBytes(32), nil)
+	claims := make(map[string]any)
+	claims[claimUsernameKey] = defaultAdminUsername
+	claims[jwt.ExpirationKey] = time.Now().Add(1 * time.Minute)
+	claims[jwt.AudienceKey] = []string{tokenAudienceWebAdmin}
+	token, _, err := tokenAuth.Encode(claims)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, webAdminWebAuthnPath+"/register", nil)
+	require.NoError(t, err)
+	beginWebAuthnRegistration(rr, req.WithContext(jwtauth.NewContext(req.Context(), token, nil)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodDelete, webAdminWebAuthnPath+"/invalid%20id", nil)
+	require.NoError(t, err)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "invalid id")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	deleteWebAuthnCredential(rr, req.WithContext(jwtauth.NewContext(req.Context(), token, nil)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Invalid credential ID")
+}
+
+func TestDeleteWebAuthnCredentials(t *testing.T) {
+	admin := dataprovider.Admin{
+		Username:    "webauthn_admin",
+		Password:    "password",
+		Permissions: []string{dataprovider.PermAdminAny},
+		Status:      1,
+	}
+	err := dataprovider.AddAdmin(&admin, "", "")
+	require.NoError(t, err)
+	admin, err = dataprovider.AdminExists(admin.Username)
+	require.NoError(t, err)
+	key1 := getTestWebAuthnCredential("key1")
+	key2 := getTestWebAuthnCredential("key2")
+	admin.Filters.WebAuthnCredentials = []mfa.WebAuthnCredential{key1, key2}
+	admin.Filters.RecoveryCodes = []dataprovider.RecoveryCode{{Secret: kms.NewPlainSecret(getNewRecoveryCode())}}
+	err = dataprovider.UpdateAdmin(&admin, "", "")
+	require.NoError(t, err)
+
+	err = deleteAdminWebAuthnCredential(admin.Username, []byte("missing"), "")
+	assert.IsType(t, &util.RecordNotFoundError{}, err)
+	err = deleteAdminWebAuthnCredential(admin.Username, key1.ID, "")
+	assert.NoError(t, err)
+	admin, err = dataprovider.AdminExists(admin.Username)
+	require.NoError(t, err)
+	assert.Len(t, admin.Filters.WebAuthnCredentials, 1)
+	assert.Len(t, admin.Filters.RecoveryCodes, 1)
+	err = deleteAdminWebAuthnCredential(admin.Username, key2.ID, "")
+	assert.NoError(t, err)
+	admin, err = dataprovider.AdminExists(admin.Username)
+	require.NoError(t, err)
+	assert.Len(t, admin.Filters.WebAuthnCredentials, 0)
+	assert.Len(t, admin.Filters.RecoveryCodes, 0)
+	err = dataprovider.DeleteAdmin(admin.Username, "", "")
+	assert.NoError(t, err)
+
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:    "webauthn_user",
+			Password:    "password",
+			HomeDir:     t.TempDir(),
+			Status:      1,
+			Permissions: map[string][]string{"/": {dataprovider.PermAny}},
+		},
+	}
+	err = dataprovider.AddUser(&user, "", "")
+	require.NoError(t, err)
+	user, err = dataprovider.UserExists(user.Username)
+	require.NoError(t, err)
+	user.Filters.WebAuthnCredentials = []mfa.WebAuthnCredential{key1}
+	err = dataprovider.UpdateUser(&user, "", "")
+	require.NoError(t, err)
+	err = deleteUserWebAuthnCredential(user.Username, key2.ID, "")
+	assert.IsType(t, &util.RecordNotFoundError{}, err)
+	err = deleteUserWebAuthnCredential(user.Username, key1.ID, "")
+	assert.NoError(t, err)
+	user, err = dataprovider.UserExists(user.Username)
+	require.NoError(t, err)
+	assert.Len(t, user.Filters.WebAuthnCredentials, 0)
+	err = dataprovider.DeleteUser(user.Username, "", "")
+	assert.NoError(t, err)
+}
@@ -36,6 +36,7 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/i18n"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
@@ -55,6 +56,7 @@ const (
 	templateClientChangePwd         = "changepassword.html"
 	templateClientTwoFactor         = "twofactor.html"
 	templateClientTwoFactorRecovery = "twofactor-recovery.html"
+	templateClientMagicLink         = "magic-link.html"
 	templateClientMFA               = "mfa.html"
 	templateClientEditFile          = "editfile.html"
 	templateClientShare             = "share.html"
@@ -95,6 +97,7 @@ func isZeroTime(t time.Time) bool {
 }
 
 type baseClientPage struct {
+	pageLanguage
 	Title        string
 	CurrentURL   string
 	FilesURL     string
@@ -113,6 +116,9 @@ type baseClientPage struct {
 	CSRFToken    string
 	LoggedUser   *dataprovider.User
 	Branding     UIBranding
+	// admin impersonating the logged user, if any
+	ImpersonatedBy        string
+	ImpersonationReadOnly bool
 }
 
 type dirMapping struct {
@@ -121,6 +127,7 @@ type dirMapping struct {
 }
 
 type viewPDFPage struct {
+	pageLanguage
 	Title     string
 	URL       string
 	StaticURL string
@@ -139,20 +146,30 @@ type editFilePage struct {
 
 type filesPage struct {
 	baseClientPage
-	CurrentDir      string
-	DirsURL         string
-	DownloadURL     string
-	ViewPDFURL      string
-	FileURL         string
-	CanAddFiles     bool
-	CanCreateDirs   bool
-	CanRename       bool
-	CanDelete       bool
-	CanDownload     bool
-	CanShare        bool
-	Error           string
-	Paths           []dirMapping
-	HasIntegrations bool
+	CurrentDir            string
+	DirsURL               string
+	DownloadURL           string
+	ViewPDFURL            string
+	FileURL               string
+	FileRestoreURL        string
+	FileCopyURL           string
+	FileVersionsURL       string
+	FileVersionURL        string
+	FileVersionRestoreURL string
+	TUSURL                string
+	CanAddFiles           bool
+	CanCreateDirs         bool
+	CanRename             bool
+	CanCopy               bool
+	CanDelete             bool
+	CanDownload           bool
+	CanShare              bool
+	CanRestore            bool
+	CanListVersions       bool
+	CanRestoreVersions    bool
+	Error                 string
+	Paths                 []dirMapping
+	HasIntegrations       bool
 }
 
 type shareFilesPage struct {
@@ -182,13 +199,24 @@ type clientMessagePage struct {
 type clientProfilePage struct {
 	baseClientPage
 	PublicKeys      []string
-	CanSubmit       bool
 	AllowAPIKeyAuth bool
 	Email           string
 	Description     string
+	Theme           string
+	Language        string
+	Languages       []i18n.LanguageInfo
+	FoldersQuota    []clientFolderQuota
 	Error           string
 }
 
+// clientFolderQuota defines the per-user quota usage for a virtual folder
+// formatted for display
+type clientFolderQuota struct {
+	VirtualPath string
+	Size        string
+	Files       string
+}
+
 type changeClientPasswordPage struct {
 	baseClientPage
 	Error string
@@ -203,6 +231,12 @@ type clientMFAPage struct {
 	SaveTOTPURL     string
 	RecCodesURL     string
 	Protocols       []string
+	WebAuthnEnabled bool
+	WebAuthnURL     string
+	SecurityKeys    []webAuthnCredentialView
+	// true if the password or a passcode is required to generate new recovery codes
+	RecCodesReverification bool
+	RecCodesEmailEnabled   bool
 }
 
 type clientSharesPage struct {
@@ -285,6 +319,11 @@ func loadClientTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateClientDir, templateClientBaseLogin),
 		filepath.Join(templatesPath, templateClientDir, templateClientTwoFactorRecovery),
 	}
+	magicLinkPath := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
+		filepath.Join(templatesPath, templateClientDir, templateClientBaseLogin),
+		filepath.Join(templatesPath, templateClientDir, templateClientMagicLink),
+	}
 	forgotPwdPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateCommonDir, templateForgotPassword),
@@ -308,22 +347,24 @@ func loadClientTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateClientDir, templateUploadToShare),
 	}
 
-	filesTmpl := util.LoadTemplate(nil, filesPaths...)
-	profileTmpl := util.LoadTemplate(nil, profilePaths...)
-	changePwdTmpl := util.LoadTemplate(nil, changePwdPaths...)
-	loginTmpl := util.LoadTemplate(nil, loginPath...)
-	messageTmpl := util.LoadTemplate(nil, messagePath...)
-	mfaTmpl := util.LoadTemplate(nil, mfaPath...)
-	twoFactorTmpl := util.LoadTemplate(nil, twoFactorPath...)
-	twoFactorRecoveryTmpl := util.LoadTemplate(nil, twoFactorRecoveryPath...)
-	editFileTmpl := util.LoadTemplate(nil, editFilePath...)
-	sharesTmpl := util.LoadTemplate(nil, sharesPaths...)
-	shareTmpl := util.LoadTemplate(nil, sharePaths...)
-	forgotPwdTmpl := util.LoadTemplate(nil, forgotPwdPaths...)
-	resetPwdTmpl := util.LoadTemplate(nil, resetPwdPaths...)
-	viewPDFTmpl := util.LoadTemplate(nil, viewPDFPaths...)
-	shareFilesTmpl := util.LoadTemplate(nil, shareFilesPath...)
-	shareUploadTmpl := util.LoadTemplate(nil, shareUploadPath...)
+	baseTpl := template.New("baseTemplate").Funcs(getI18nTemplateFuncs())
+	filesTmpl := util.LoadTemplate(baseTpl, filesPaths...)
+	profileTmpl := util.LoadTemplate(baseTpl, profilePaths...)
+	changePwdTmpl := util.LoadTemplate(baseTpl, changePwdPaths...)
+	loginTmpl := util.LoadTemplate(baseTpl, loginPath...)
+	messageTmpl := util.LoadTemplate(baseTpl, messagePath...)
+	mfaTmpl := util.LoadTemplate(baseTpl, mfaPath...)
+	twoFactorTmpl := util.LoadTemplate(baseTpl, twoFactorPath...)
+	twoFactorRecoveryTmpl := util.LoadTemplate(baseTpl, twoFactorRecoveryPath...)
+	magicLinkTmpl := util.LoadTemplate(baseTpl, magicLinkPath...)
+	editFileTmpl := util.LoadTemplate(baseTpl, editFilePath...)
+	sharesTmpl := util.LoadTemplate(baseTpl, sharesPaths...)
+	shareTmpl := util.LoadTemplate(baseTpl, sharePaths...)
+	forgotPwdTmpl := util.LoadTemplate(baseTpl, forgotPwdPaths...)
+	resetPwdTmpl := util.LoadTemplate(baseTpl, resetPwdPaths...)
+	viewPDFTmpl := util.LoadTemplate(baseTpl, viewPDFPaths...)
+	shareFilesTmpl := util.LoadTemplate(baseTpl, shareFilesPath...)
+	shareUploadTmpl := util.LoadTemplate(baseTpl, shareUploadPath...)
 
 	clientTemplates[templateClientFiles] = filesTmpl
 	clientTemplates[templateClientProfile] = profileTmpl
@@ -333,6 +374,7 @@ func loadClientTemplates(templatesPath string) {
 	clientTemplates[templateClientMFA] = mfaTmpl
 	clientTemplates[templateClientTwoFactor] = twoFactorTmpl
 	clientTemplates[templateClientTwoFactorRecovery] = twoFactorRecoveryTmpl
+	clientTemplates[templateClientMagicLink] = magicLinkTmpl
 	clientTemplates[templateClientEditFile] = editFileTmpl
 	clientTemplates[templateClientShares] = sharesTmpl
 	clientTemplates[templateClientShare] = shareTmpl
@@ -349,9 +391,11 @@ func (s *httpdServer) getBaseClientPageData(title, currentURL string, r *http.Re
 		csrfToken = createCSRFToken(util.GetIPFromRemoteAddress(r.RemoteAddr))
 	}
 	v := version.Get()
+	lang := getPageLanguage(r)
 
-	return baseClientPage{
-		Title:        title,
+	data := baseClientPage{
+		pageLanguage: lang,
+		Title:        i18n.T(lang.Lang, title),
 		CurrentURL:   currentURL,
 		FilesURL:     webClientFilesPath,
 		SharesURL:    webClientSharesPath,
@@ -361,37 +405,44 @@ func (s *httpdServer) getBaseClientPageData(title, currentURL string, r *http.Re
 		StaticURL:    webStaticFilesPath,
 		LogoutURL:    webClientLogoutPath,
 		MFAURL:       webClientMFAPath,
-		MFATitle:     pageClient2FATitle,
-		FilesTitle:   pageClientFilesTitle,
-		SharesTitle:  pageClientSharesTitle,
-		ProfileTitle: pageClientProfileTitle,
+		MFATitle:     i18n.T(lang.Lang, pageClient2FATitle),
+		FilesTitle:   i18n.T(lang.Lang, pageClientFilesTitle),
+		SharesTitle:  i18n.T(lang.Lang, pageClientSharesTitle),
+		ProfileTitle: i18n.T(lang.Lang, pageClientProfileTitle),
 		Version:      fmt.Sprintf("%v-%v", v.Version, v.CommitHash),
 		CSRFToken:    csrfToken,
 		LoggedUser:   getUserFromToken(r),
 		Branding:     s.binding.Branding.WebClient,
 	}
+	if claims, err := getTokenClaims(r); err == nil {
+		data.ImpersonatedBy = claims.ImpersonatedBy
+		data.ImpersonationReadOnly = claims.ImpersonationReadOnly
+	}
+	return data
 }
 
-func (s *httpdServer) renderClientForgotPwdPage(w http.ResponseWriter, error, ip string) {
+func (s *httpdServer) renderClientForgotPwdPage(w http.ResponseWriter, r *http.Request, error, ip string) {
 	data := forgotPwdPage{
-		CurrentURL: webClientForgotPwdPath,
-		Error:      error,
-		CSRFToken:  createCSRFToken(ip),
-		StaticURL:  webStaticFilesPath,
-		Title:      pageClientForgotPwdTitle,
-		Branding:   s.binding.Branding.WebClient,
+		pageLanguage: getPageLanguage(r),
+		CurrentURL:   webClientForgotPwdPath,
+		Error:        error,
+		CSRFToken:    createCSRFToken(ip),
+		StaticURL:    webStaticFilesPath,
+		Title:        pageClientForgotPwdTitle,
+		Branding:     s.binding.Branding.WebClient,
 	}
 	renderClientTemplate(w, templateForgotPassword, data)
 }
 
-func (s *httpdServer) renderClientResetPwdPage(w http.ResponseWriter, error, ip string) {
+func (s *httpdServer) renderClientResetPwdPage(w http.ResponseWriter, r *http.Request, error, ip string) {
 	data := resetPwdPage{
-		CurrentURL: webClientResetPwdPath,
-		Error:      error,
-		CSRFToken:  createCSRFToken(ip),
-		StaticURL:  webStaticFilesPath,
-		Title:      pageClientResetPwdTitle,
-		Branding:   s.binding.Branding.WebClient,
+		pageLanguage: getPageLanguage(r),
+		CurrentURL:   webClientResetPwdPath,
+		Error:        error,
+		CSRFToken:    createCSRFToken(ip),
+		StaticURL:    webStaticFilesPath,
+		Title:        pageClientResetPwdTitle,
+		Branding:     s.binding.Branding.WebClient,
 	}
 	renderClientTemplate(w, templateResetPassword, data)
 }
@@ -404,18 +455,19 @@ func renderClientTemplate(w http.ResponseWriter, tmplName string, data any) {
 }
 
 func (s *httpdServer) renderClientMessagePage(w http.ResponseWriter, r *http.Request, title, body string, statusCode int, err error, message string) {
+	page := s.getBaseClientPageData(title, "", r)
 	var errorString strings.Builder
 	if body != "" {
-		errorString.WriteString(body)
+		errorString.WriteString(i18n.T(page.Lang, body))
 		errorString.WriteString(" ")
 	}
 	if err != nil {
-		errorString.WriteString(err.Error())
+		errorString.WriteString(i18n.T(page.Lang, err.Error()))
 	}
 	data := clientMessagePage{
-		baseClientPage: s.getBaseClientPageData(title, "", r),
+		baseClientPage: page,
 		Error:          errorString.String(),
-		Success:        message,
+		Success:        i18n.T(page.Lang, message),
 	}
 	w.WriteHeader(statusCode)
 	renderClientTemplate(w, templateClientMessage, data)
@@ -437,27 +489,50 @@ func (s *httpdServer) renderClientNotFoundPage(w http.ResponseWriter, r *http.Re
 	s.renderClientMessagePage(w, r, page404Title, page404Body, http.StatusNotFound, err, "")
 }
 
-func (s *httpdServer) renderClientTwoFactorPage(w http.ResponseWriter, error, ip string) {
+func (s *httpdServer) renderClientTwoFactorPage(w http.ResponseWriter, r *http.Request, error, ip string) {
 	data := twoFactorPage{
-		CurrentURL:  webClientTwoFactorPath,
-		Version:     version.Get().Version,
-		Error:       error,
-		CSRFToken:   createCSRFToken(ip),
-		StaticURL:   webStaticFilesPath,
-		RecoveryURL: webClientTwoFactorRecoveryPath,
-		Branding:    s.binding.Branding.WebClient,
+		pageLanguage: getPageLanguage(r),
+		CurrentURL:   webClientTwoFactorPath,
+		Version:      version.Get().Version,
+		Error:        error,
+		CSRFToken:    createCSRFToken(ip),
+		StaticURL:    webStaticFilesPath,
+		RecoveryURL:  webClientTwoFactorRecoveryPath,
+		Branding:     s.binding.Branding.WebClient,
+		TOTPEnabled:  true,
+	}
+	if claims, err := getTokenClaims(r); err == nil {
+		if user, err := dataprovider.GetUserWithGroupSettings(claims.Username); err == nil {
+			data.TOTPEnabled = user.Filters.TOTPConfig.Enabled &&
+				util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP)
+			if user.HasWebAuthnCredentials() {
+				data.WebAuthnURL = webClientTwoFactorWebAuthnPath
+			}
+			data.PushEnabled = user.IsPushEnabledForProtocol(common.ProtocolHTTP)
+		}
 	}
 	renderClientTemplate(w, templateTwoFactor, data)
 }
 
-func (s *httpdServer) renderClientTwoFactorRecoveryPage(w http.ResponseWriter, error, ip string) {
+func (s *httpdServer) renderClientTwoFactorRecoveryPage(w http.ResponseWriter, r *http.Request, error, ip string) {
+	s.renderClientTwoFactorRecoveryPageWithInfo(w, r, error, "", ip)
+}
+
+func (s *httpdServer) renderClientTwoFactorRecoveryPageWithInfo(w http.ResponseWriter, r *http.Request, error, info,
+	ip string,
+) {
 	data := twoFactorPage{
-		CurrentURL: webClientTwoFactorRecoveryPath,
-		Version:    version.Get().Version,
-		Error:      error,
-		CSRFToken:  createCSRFToken(ip),
-		StaticURL:  webStaticFilesPath,
-		Branding:   s.binding.Branding.WebClient,
+		pageLanguage: getPageLanguage(r),
+		CurrentURL:   webClientTwoFactorRecoveryPath,
+		Version:      version.Get().Version,
+		Error:        error,
+		Info:         info,
+		CSRFToken:    createCSRFToken(ip),
+		StaticURL:    webStaticFilesPath,
+		Branding:     s.binding.Branding.WebClient,
+	}
+	if isRecoveryCodesEmailRecoveryEnabled() {
+		data.RecoveryEmailURL = webClientRecoveryCodesEmailPath
 	}
 	renderClientTemplate(w, templateTwoFactorRecovery, data)
 }
@@ -471,6 +546,11 @@ func (s *httpdServer) renderClientMFAPage(w http.ResponseWriter, r *http.Request
 		SaveTOTPURL:     webClientTOTPSavePath,
 		RecCodesURL:     webClientRecoveryCodesPath,
 		Protocols:       dataprovider.MFAProtocols,
+		WebAuthnEnabled: mfa.IsWebAuthnEnabled(),
+		WebAuthnURL:     webClientWebAuthnPath,
+
+		RecCodesReverification: mfa.IsRecoveryCodesReverificationRequired(),
+		RecCodesEmailEnabled:   smtp.IsEnabled(),
 	}
 	user, err := dataprovider.UserExists(data.LoggedUser.Username)
 	if err != nil {
@@ -478,6 +558,7 @@ func (s *httpdServer) renderClientMFAPage(w http.ResponseWriter, r *http.Request
 		return
 	}
 	data.TOTPConfig = user.Filters.TOTPConfig
+	data.SecurityKeys = getWebAuthnCredentialViews(user.Filters.WebAuthnCredentials)
 	renderClientTemplate(w, templateClientMFA, data)
 }
 
@@ -566,21 +647,31 @@ func (s *httpdServer) renderFilesPage(w http.ResponseWriter, r *http.Request, di
 	hasIntegrations bool,
 ) {
 	data := filesPage{
-		baseClientPage:  s.getBaseClientPageData(pageClientFilesTitle, webClientFilesPath, r),
-		Error:           error,
-		CurrentDir:      url.QueryEscape(dirName),
-		DownloadURL:     webClientDownloadZipPath,
-		ViewPDFURL:      webClientViewPDFPath,
-		DirsURL:         webClientDirsPath,
-		FileURL:         webClientFilePath,
-		CanAddFiles:     user.CanAddFilesFromWeb(dirName),
-		CanCreateDirs:   user.CanAddDirsFromWeb(dirName),
-		CanRename:       user.CanRenameFromWeb(dirName, dirName),
-		CanDelete:       user.CanDeleteFromWeb(dirName),
-		CanDownload:     user.HasPerm(dataprovider.PermDownload, dirName),
-		CanShare:        user.CanManageShares(),
-		HasIntegrations: hasIntegrations,
-		Paths:           getDirMapping(dirName, webClientFilesPath),
+		baseClientPage:        s.getBaseClientPageData(pageClientFilesTitle, webClientFilesPath, r),
+		Error:                 error,
+		CurrentDir:            url.QueryEscape(dirName),
+		DownloadURL:           webClientDownloadZipPath,
+		ViewPDFURL:            webClientViewPDFPath,
+		DirsURL:               webClientDirsPath,
+		FileURL:               webClientFilePath,
+		FileRestoreURL:        webClientFileRestorePath,
+		FileCopyURL:           webClientFileCopyPath,
+		FileVersionsURL:       webClientFileVersionsPath,
+		FileVersionURL:        webClientFileVersionDownload,
+		FileVersionRestoreURL: webClientFileVersionRestore,
+		TUSURL:                getWebClientTUSURL(),
+		CanAddFiles:           user.CanAddFilesFromWeb(dirName),
+		CanCreateDirs:         user.CanAddDirsFromWeb(dirName),
+		CanRename:             user.CanRenameFromWeb(dirName, dirName),
+		CanCopy:               user.CanCopyFromWeb(dirName, dirName),
+		CanDelete:             user.CanDeleteFromWeb(dirName),
+		CanDownload:           user.HasPerm(dataprovider.PermDownload, dirName),
+		CanShare:              user.CanManageShares(),
+		CanRestore:            user.CanRestoreArchivedFromWeb(dirName),
+		CanListVersions:       user.CanManageVersionsFromWeb(dirName),
+		CanRestoreVersions:    user.CanManageVersionsFromWeb(dirName) && user.CanAddFilesFromWeb(dirName),
+		HasIntegrations:       hasIntegrations,
+		Paths:                 getDirMapping(dirName, webClientFilesPath),
 	}
 	renderClientTemplate(w, templateClientFiles, data)
 }
@@ -590,7 +681,7 @@ func (s *httpdServer) renderClientProfilePage(w http.ResponseWriter, r *http.Req
 		baseClientPage: s.getBaseClientPageData(pageClientProfileTitle, webClientProfilePath, r),
 		Error:          error,
 	}
-	user, userMerged, err := dataprovider.GetUserVariants(data.LoggedUser.Username)
+	user, err := dataprovider.UserExists(data.LoggedUser.Username)
 	if err != nil {
 		s.renderClientInternalServerErrorPage(w, r, err)
 		return
@@ -599,10 +690,45 @@ func (s *httpdServer) renderClientProfilePage(w http.ResponseWriter, r *http.Req
 	data.AllowAPIKeyAuth = user.Filters.AllowAPIKeyAuth
 	data.Email = user.Email
 	data.Description = user.Description
-	data.CanSubmit = userMerged.CanChangeAPIKeyAuth() || userMerged.CanManagePublicKeys() || userMerged.CanChangeInfo()
+	data.Theme = user.Filters.Theme
+	data.Language = user.Filters.Language
+	data.Languages = i18n.GetLanguages()
+	data.FoldersQuota = getClientFoldersQuota(user.Username)
 	renderClientTemplate(w, templateClientProfile, data)
 }
 
+func getClientFoldersQuota(username string) []clientFolderQuota {
+	var result []clientFolderQuota
+	if dataprovider.GetQuotaTracking() == 0 {
+		return result
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(username)
+	if err != nil {
+		return result
+	}
+	quota, err := dataprovider.GetUserFoldersQuota(&user)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to get folders quota for user %q: %v", username, err)
+		return result
+	}
+	for _, q := range quota {
+		size := util.ByteCountSI(q.UsedQuotaSize)
+		if q.QuotaSize > 0 {
+			size = fmt.Sprintf("%s/%s", size, util.ByteCountSI(q.QuotaSize))
+		}
+		files := strconv.Itoa(q.UsedQuotaFiles)
+		if q.QuotaFiles > 0 {
+			files = fmt.Sprintf("%d/%d", q.UsedQuotaFiles, q.QuotaFiles)
+		}
+		result = append(result, clientFolderQuota{
+			VirtualPath: q.VirtualPath,
+			Size:        size,
+			Files:       files,
+		})
+	}
+	return result
+}
+
 func (s *httpdServer) renderClientChangePasswordPage(w http.ResponseWriter, r *http.Request, error string) {
 	data := changeClientPasswordPage{
 		baseClientPage: s.getBaseClientPageData(pageClientChangePwdTitle, webChangeClientPwdPath, r),
@@ -638,6 +764,8 @@ func (s *httpdServer) handleWebClientDownloadZip(w http.ResponseWriter, r *http.
 			r.RemoteAddr, user),
 		request: r,
 	}
+	connection.SetTraceContext(r.Context())
+	connection.setImpersonation(&claims)
 	if err = common.Connections.Add(connection); err != nil {
 		s.renderClientMessagePage(w, r, "Unable to add connection", "", http.StatusTooManyRequests, err, "")
 		return
@@ -838,6 +966,8 @@ func (s *httpdServer) handleClientGetDirContents(w http.ResponseWriter, r *http.
 			r.RemoteAddr, user),
 		request: r,
 	}
+	connection.SetTraceContext(r.Context())
+	connection.setImpersonation(&claims)
 	if err = common.Connections.Add(connection); err != nil {
 		s.renderClientMessagePage(w, r, "Unable to add connection", "", http.StatusTooManyRequests, err, "")
 		return
@@ -915,6 +1045,8 @@ func (s *httpdServer) handleClientGetFiles(w http.ResponseWriter, r *http.Reques
 			r.RemoteAddr, user),
 		request: r,
 	}
+	connection.SetTraceContext(r.Context())
+	connection.setImpersonation(&claims)
 	if err = common.Connections.Add(connection); err != nil {
 		s.renderClientMessagePage(w, r, "Unable to add connection", "", http.StatusTooManyRequests, err, "")
 		return
@@ -974,6 +1106,8 @@ func (s *httpdServer) handleClientEditFile(w http.ResponseWriter, r *http.Reques
 			r.RemoteAddr, user),
 		request: r,
 	}
+	connection.SetTraceContext(r.Context())
+	connection.setImpersonation(&claims)
 	if err = common.Connections.Add(connection); err != nil {
 		s.renderClientMessagePage(w, r, "Unable to add connection", "", http.StatusTooManyRequests, err, "")
 		return
@@ -1218,12 +1352,19 @@ func (s *httpdServer) handleWebClientProfilePost(w http.ResponseWriter, r *http.
 		s.renderClientForbiddenPage(w, r, "Invalid token claims")
 		return
 	}
+	if claims.ImpersonatedBy != "" {
+		s.renderClientForbiddenPage(w, r, "The profile cannot be changed in impersonated sessions")
+		return
+	}
 	user, userMerged, err := dataprovider.GetUserVariants(claims.Username)
 	if err != nil {
 		s.renderClientProfilePage(w, r, err.Error())
 		return
 	}
-	if !userMerged.CanManagePublicKeys() && !userMerged.CanChangeAPIKeyAuth() && !userMerged.CanChangeInfo() {
+	theme := r.Form.Get("theme")
+	lang := r.Form.Get("language")
+	if !userMerged.CanManagePublicKeys() && !userMerged.CanChangeAPIKeyAuth() && !userMerged.CanChangeInfo() &&
+		theme == user.Filters.Theme && lang == user.Filters.Language {
 		s.renderClientForbiddenPage(w, r, "You are not allowed to change anything")
 		return
 	}
@@ -1237,11 +1378,14 @@ func (s *httpdServer) handleWebClientProfilePost(w http.ResponseWriter, r *http.
 		user.Email = r.Form.Get("email")
 		user.Description = r.Form.Get("description")
 	}
+	user.Filters.Theme = theme
+	user.Filters.Language = lang
 	err = dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSelf, ipAddr)
 	if err != nil {
 		s.renderClientProfilePage(w, r, err.Error())
 		return
 	}
+	r = s.updateSessionPreferences(w, r, user.Filters.Theme, user.Filters.Language, tokenAudienceWebClient)
 	s.renderClientMessagePage(w, r, "Profile updated", "", http.StatusOK, nil,
 		"Your profile has been successfully updated")
 }
@@ -1253,12 +1397,12 @@ func (s *httpdServer) handleWebClientMFA(w http.ResponseWriter, r *http.Request)
 
 func (s *httpdServer) handleWebClientTwoFactor(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
-	s.renderClientTwoFactorPage(w, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
+	s.renderClientTwoFactorPage(w, r, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
 }
 
 func (s *httpdServer) handleWebClientTwoFactorRecovery(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
-	s.renderClientTwoFactorRecoveryPage(w, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
+	s.renderClientTwoFactorRecoveryPage(w, r, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
 }
 
 func getShareFromPostFields(r *http.Request) (*dataprovider.Share, error) {
@@ -1300,7 +1444,7 @@ func (s *httpdServer) handleWebClientForgotPwd(w http.ResponseWriter, r *http.Re
 		s.renderClientNotFoundPage(w, r, errors.New("this page does not exist"))
 		return
 	}
-	s.renderClientForgotPwdPage(w, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
+	s.renderClientForgotPwdPage(w, r, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
 }
 
 func (s *httpdServer) handleWebClientForgotPwdPost(w http.ResponseWriter, r *http.Request) {
@@ -1309,7 +1453,7 @@ func (s *httpdServer) handleWebClientForgotPwdPost(w http.ResponseWriter, r *htt
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	err := r.ParseForm()
 	if err != nil {
-		s.renderClientForgotPwdPage(w, err.Error(), ipAddr)
+		s.renderClientForgotPwdPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
@@ -1320,10 +1464,10 @@ func (s *httpdServer) handleWebClientForgotPwdPost(w http.ResponseWriter, r *htt
 	err = handleForgotPassword(r, username, false)
 	if err != nil {
 		if e, ok := err.(*util.ValidationError); ok {
-			s.renderClientForgotPwdPage(w, e.GetErrorString(), ipAddr)
+			s.renderClientForgotPwdPage(w, r, e.GetErrorString(), ipAddr)
 			return
 		}
-		s.renderClientForgotPwdPage(w, err.Error(), ipAddr)
+		s.renderClientForgotPwdPage(w, r, err.Error(), ipAddr)
 		return
 	}
 	http.Redirect(w, r, webClientResetPwdPath, http.StatusFound)
@@ -1335,7 +1479,7 @@ func (s *httpdServer) handleWebClientPasswordReset(w http.ResponseWriter, r *htt
 		s.renderClientNotFoundPage(w, r, errors.New("this page does not exist"))
 		return
 	}
-	s.renderClientResetPwdPage(w, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
+	s.renderClientResetPwdPage(w, r, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
 }
 
 func (s *httpdServer) handleClientViewPDF(w http.ResponseWriter, r *http.Request) {
@@ -1347,10 +1491,11 @@ func (s *httpdServer) handleClientViewPDF(w http.ResponseWriter, r *http.Request
 	}
 	name = util.CleanPath(name)
 	data := viewPDFPage{
-		Title:     path.Base(name),
-		URL:       fmt.Sprintf("%s?path=%s&_=%d", webClientGetPDFPath, url.QueryEscape(name), time.Now().UTC().Unix()),
-		StaticURL: webStaticFilesPath,
-		Branding:  s.binding.Branding.WebClient,
+		pageLanguage: getPageLanguage(r),
+		Title:        path.Base(name),
+		URL:          fmt.Sprintf("%s?path=%s&_=%d", webClientGetPDFPath, url.QueryEscape(name), time.Now().UTC().Unix()),
+		StaticURL:    webStaticFilesPath,
+		Branding:     s.binding.Branding.WebClient,
 	}
 	renderClientTemplate(w, templateClientViewPDF, data)
 }
@@ -1386,6 +1531,8 @@ func (s *httpdServer) handleClientGetPDF(w http.ResponseWriter, r *http.Request)
 			r.RemoteAddr, user),
 		request: r,
 	}
+	connection.SetTraceContext(r.Context())
+	connection.setImpersonation(&claims)
 	if err = common.Connections.Add(connection); err != nil {
 		s.renderClientMessagePage(w, r, "Unable to add connection", "", http.StatusTooManyRequests, err, "")
 		return
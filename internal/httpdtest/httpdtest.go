@@ -25,6 +25,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -44,6 +45,7 @@ import (
 const (
 	tokenPath             = "/api/v2/token"
 	activeConnectionsPath = "/api/v2/connections"
+	clientVersionsPath    = "/api/v2/clientversions"
 	quotasBasePath        = "/api/v2/quotas"
 	quotaScanPath         = "/api/v2/quotas/users/scans"
 	quotaScanVFolderPath  = "/api/v2/quotas/folders/scans"
@@ -54,6 +56,7 @@ const (
 	serverStatusPath      = "/api/v2/status"
 	dumpDataPath          = "/api/v2/dumpdata"
 	loadDataPath          = "/api/v2/loaddata"
+	dbBackupPath          = "/api/v2/dbbackup"
 	defenderHosts         = "/api/v2/defender/hosts"
 	adminPath             = "/api/v2/admins"
 	adminPwdPath          = "/api/v2/admin/changepwd"
@@ -62,6 +65,8 @@ const (
 	retentionChecksPath   = "/api/v2/retention/users/checks"
 	eventActionsPath      = "/api/v2/eventactions"
 	eventRulesPath        = "/api/v2/eventrules"
+	auditPath             = "/api/v2/audit"
+	deadLettersPath       = "/api/v2/deadletters"
 )
 
 const (
@@ -808,6 +813,26 @@ func GetEventRuleByName(name string, expectedStatusCode int) (dataprovider.Event
 	return rule, body, err
 }
 
+// GetEventRuleRolloutStatus returns the rollout status for the event rule with the given name
+// if it exists
+func GetEventRuleRolloutStatus(name string, expectedStatusCode int) (dataprovider.RolloutStatus, []byte, error) {
+	var status dataprovider.RolloutStatus
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(eventRulesPath, url.PathEscape(name), "rollout"),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return status, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &status)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return status, body, err
+}
+
 // GetEventRules returns a list of event rules and checks the received HTTP Status code against expectedStatusCode.
 // The number of results can be limited specifying a limit.
 // Some results can be skipped specifying an offset.
@@ -832,6 +857,124 @@ func GetEventRules(limit, offset int64, expectedStatusCode int) ([]dataprovider.
 	return rules, body, err
 }
 
+// GetAuditEntries returns the audit entries for the specified object type and name,
+// in descending order, and checks the received HTTP Status code against expectedStatusCode.
+// Empty object type and name mean all the objects.
+// The number of results can be limited specifying a limit.
+// Some results can be skipped specifying an offset.
+func GetAuditEntries(objectType, objectName string, limit, offset int64, expectedStatusCode int) ([]dataprovider.AuditEntry,
+	[]byte, error,
+) {
+	var entries []dataprovider.AuditEntry
+	var body []byte
+	url, err := addLimitAndOffsetQueryParams(buildURLRelativeToBase(auditPath), limit, offset)
+	if err != nil {
+		return entries, body, err
+	}
+	q := url.Query()
+	if objectType != "" {
+		q.Add("object_type", objectType)
+	}
+	if objectName != "" {
+		q.Add("object_name", objectName)
+	}
+	q.Add("order", dataprovider.OrderDESC)
+	url.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(http.MethodGet, url.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return entries, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &entries)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return entries, body, err
+}
+
+// GetDeadLetters returns the dead letters matching the specified source and name
+// and checks the received HTTP Status code against expectedStatusCode.
+func GetDeadLetters(source, name string, limit, offset int64, expectedStatusCode int) ([]dataprovider.DeadLetter,
+	[]byte, error,
+) {
+	var entries []dataprovider.DeadLetter
+	var body []byte
+	url, err := addLimitAndOffsetQueryParams(buildURLRelativeToBase(deadLettersPath), limit, offset)
+	if err != nil {
+		return entries, body, err
+	}
+	q := url.Query()
+	if source != "" {
+		q.Add("source", source)
+	}
+	if name != "" {
+		q.Add("name", name)
+	}
+	q.Add("order", dataprovider.OrderDESC)
+	url.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(http.MethodGet, url.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return entries, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &entries)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return entries, body, err
+}
+
+// GetDeadLetterByID gets a dead letter by ID and checks the received HTTP Status code against expectedStatusCode.
+func GetDeadLetterByID(id int64, expectedStatusCode int) (dataprovider.DeadLetter, []byte, error) {
+	var entry dataprovider.DeadLetter
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(deadLettersPath, strconv.FormatInt(id, 10)),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return entry, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &entry)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return entry, body, err
+}
+
+// RemoveDeadLetter removes the dead letter with the specified ID and checks the received HTTP Status code
+// against expectedStatusCode.
+func RemoveDeadLetter(id int64, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(deadLettersPath, strconv.FormatInt(id, 10)),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// ReplayDeadLetter replays the dead letter with the specified ID and checks the received HTTP Status code
+// against expectedStatusCode.
+func ReplayDeadLetter(id int64, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodPost,
+		buildURLRelativeToBase(deadLettersPath, strconv.FormatInt(id, 10), "replay"), nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
 // GetQuotaScans gets active quota scans for users and checks the received HTTP Status code against expectedStatusCode.
 func GetQuotaScans(expectedStatusCode int) ([]common.ActiveQuotaScan, []byte, error) {
 	var quotaScans []common.ActiveQuotaScan
@@ -951,6 +1094,24 @@ func GetConnections(expectedStatusCode int) ([]common.ConnectionStatus, []byte,
 	return connections, body, err
 }
 
+// GetClientVersions returns the client identification strings observed by the server
+func GetClientVersions(expectedStatusCode int) ([]common.ClientVersionStats, []byte, error) {
+	var versions []common.ClientVersionStats
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(clientVersionsPath), nil, "", getDefaultToken())
+	if err != nil {
+		return versions, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &versions)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return versions, body, err
+}
+
 // CloseConnection closes an active  connection identified by connectionID
 func CloseConnection(connectionID string, expectedStatusCode int) ([]byte, error) {
 	var body []byte
@@ -1124,6 +1285,48 @@ func UpdateFolderQuotaUsage(folder vfs.BaseVirtualFolder, mode string, expectedS
 	return body, checkResponse(resp.StatusCode, expectedStatusCode)
 }
 
+// GetUserFoldersQuota returns the per-user folders quota for the specified user
+func GetUserFoldersQuota(username string, expectedStatusCode int) ([]dataprovider.UserFolderQuota, []byte, error) {
+	var quota []dataprovider.UserFolderQuota
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(quotasBasePath, "users", username, "folders"),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return quota, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &quota)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return quota, body, err
+}
+
+// UpdateUserFolderQuotaUsage updates the quota used by the specified user within the given folder
+func UpdateUserFolderQuotaUsage(username, folderName string, usedQuotaFiles int, usedQuotaSize int64, mode string,
+	expectedStatusCode int,
+) ([]byte, error) {
+	var body []byte
+	usageAsJSON, _ := json.Marshal(map[string]any{
+		"used_quota_files": usedQuotaFiles,
+		"used_quota_size":  usedQuotaSize,
+	})
+	url, err := addModeQueryParam(buildURLRelativeToBase(quotasBasePath, "users", username, "folders", folderName,
+		"usage"), mode)
+	if err != nil {
+		return body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPut, url.String(), bytes.NewBuffer(usageAsJSON), "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
 // GetVersion returns version details
 func GetVersion(expectedStatusCode int) (version.Info, []byte, error) {
 	var appVersion version.Info
@@ -1249,6 +1452,28 @@ func Dumpdata(outputFile, outputData, indent string, expectedStatusCode int) (ma
 	return response, body, err
 }
 
+// BackupDatabase saves an online copy of the SQLite database to outputFile.
+// outputFile is relative to the configured backups_path
+func BackupDatabase(outputFile string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(dbBackupPath))
+	if err != nil {
+		return body, err
+	}
+	q := url.Query()
+	if outputFile != "" {
+		q.Add("output-file", outputFile)
+	}
+	url.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(http.MethodPost, url.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
 // Loaddata restores a backup.
 func Loaddata(inputFile, scanQuota, mode string, expectedStatusCode int) (map[string]any, []byte, error) {
 	var response map[string]any
@@ -1352,6 +1577,19 @@ func checkEventAction(expected, actual dataprovider.BaseEventAction) error {
 	if err := compareEventActionFsConfigFields(expected.Options.FsConfig, actual.Options.FsConfig); err != nil {
 		return err
 	}
+	if err := compareEventActionStorageTransitionFields(expected.Options.TransitionConfig, actual.Options.TransitionConfig); err != nil {
+		return err
+	}
+	if err := compareEventActionIntegrityCheckFields(expected.Options.IntegrityConfig, actual.Options.IntegrityConfig); err != nil {
+		return err
+	}
+	if err := compareEventActionPublishConfigFields(expected.Options.PublishConfig, actual.Options.PublishConfig); err != nil {
+		return err
+	}
+	if err := compareEventActionSummaryReportFields(expected.Options.SummaryReportConfig,
+		actual.Options.SummaryReportConfig); err != nil {
+		return err
+	}
 	return compareEventActionHTTPConfigFields(expected.Options.HTTPConfig, actual.Options.HTTPConfig)
 }
 
@@ -1425,6 +1663,20 @@ func checkEventConditionOptions(expected, actual dataprovider.ConditionOptions)
 	if expected.MaxFileSize != actual.MaxFileSize {
 		return errors.New("condition max file size mismatch")
 	}
+	if err := compareCountries(expected.Countries, actual.Countries); err != nil {
+		return fmt.Errorf("condition %w", err)
+	}
+	if expected.Rollout.Percentage != actual.Rollout.Percentage {
+		return errors.New("condition rollout percentage mismatch")
+	}
+	if len(expected.Rollout.Nodes) != len(actual.Rollout.Nodes) {
+		return errors.New("condition rollout nodes mismatch")
+	}
+	for _, v := range expected.Rollout.Nodes {
+		if !util.Contains(actual.Rollout.Nodes, v) {
+			return errors.New("condition rollout nodes content mismatch")
+		}
+	}
 	return nil
 }
 
@@ -1461,6 +1713,9 @@ func checkEventRuleActions(expected, actual []dataprovider.EventAction) error {
 		for _, ac := range actual {
 			if ex.Name == ac.Name && ex.Order == ac.Order && ex.Options.ExecuteSync == ac.Options.ExecuteSync &&
 				ex.Options.IsFailureAction == ac.Options.IsFailureAction && ex.Options.StopOnFailure == ac.Options.StopOnFailure {
+				if err := checkActionConditions(ex.Options.Conditions, ac.Options.Conditions); err != nil {
+					return err
+				}
 				found = true
 				break
 			}
@@ -1472,6 +1727,24 @@ func checkEventRuleActions(expected, actual []dataprovider.EventAction) error {
 	return nil
 }
 
+func checkActionConditions(expected, actual []dataprovider.ActionCondition) error {
+	if len(expected) != len(actual) {
+		return errors.New("action conditions mismatch")
+	}
+	for idx := range expected {
+		if strings.TrimSpace(expected[idx].Value) != actual[idx].Value {
+			return errors.New("action condition value mismatch")
+		}
+		if expected[idx].Operator != actual[idx].Operator {
+			return errors.New("action condition operator mismatch")
+		}
+		if expected[idx].Operand != actual[idx].Operand {
+			return errors.New("action condition operand mismatch")
+		}
+	}
+	return nil
+}
+
 func checkEventRule(expected, actual dataprovider.EventRule) error {
 	if expected.ID <= 0 {
 		if actual.ID <= 0 {
@@ -1519,6 +1792,9 @@ func checkGroup(expected, actual dataprovider.Group) error {
 	if expected.Description != actual.Description {
 		return errors.New("description mismatch")
 	}
+	if err := compareMetadata(expected.Metadata, actual.Metadata); err != nil {
+		return err
+	}
 	if actual.CreatedAt == 0 {
 		return errors.New("created_at unset")
 	}
@@ -1535,9 +1811,46 @@ func checkGroup(expected, actual dataprovider.Group) error {
 	if err := compareUserFilters(expected.UserSettings.Filters, actual.UserSettings.Filters); err != nil {
 		return err
 	}
+	if strings.TrimSpace(expected.UserSettings.FTPPassiveHost) != actual.UserSettings.FTPPassiveHost {
+		return errors.New("FTP passive host mismatch")
+	}
+	if expected.UserSettings.FTPModeZ != actual.UserSettings.FTPModeZ {
+		return errors.New("FTP MODE Z mismatch")
+	}
+	if strings.TrimSpace(expected.UserSettings.PasswordPolicy) != actual.UserSettings.PasswordPolicy {
+		return errors.New("password policy mismatch")
+	}
+	if err := compareCountries(expected.UserSettings.AllowedCountries, actual.UserSettings.AllowedCountries); err != nil {
+		return fmt.Errorf("allowed %w", err)
+	}
+	if err := compareCountries(expected.UserSettings.DeniedCountries, actual.UserSettings.DeniedCountries); err != nil {
+		return fmt.Errorf("denied %w", err)
+	}
+	if err := compareBandwidthSchedules(expected.UserSettings.BandwidthSchedules, actual.UserSettings.BandwidthSchedules); err != nil {
+		return err
+	}
+	if err := compareTransferQuotaReset(expected.UserSettings.TransferQuotaReset, actual.UserSettings.TransferQuotaReset); err != nil {
+		return err
+	}
 	return compareFsConfig(&expected.UserSettings.FsConfig, &actual.UserSettings.FsConfig)
 }
 
+func compareCountries(expected, actual []string) error {
+	normalized, err := util.NormalizeCountryCodes(expected)
+	if err != nil {
+		return err
+	}
+	if len(normalized) != len(actual) {
+		return errors.New("countries mismatch")
+	}
+	for _, v := range normalized {
+		if !util.Contains(actual, v) {
+			return errors.New("countries content mismatch")
+		}
+	}
+	return nil
+}
+
 func checkFolder(expected *vfs.BaseVirtualFolder, actual *vfs.BaseVirtualFolder) error {
 	if expected.ID <= 0 {
 		if actual.ID <= 0 {
@@ -1557,9 +1870,33 @@ func checkFolder(expected *vfs.BaseVirtualFolder, actual *vfs.BaseVirtualFolder)
 	if expected.Description != actual.Description {
 		return errors.New("description mismatch")
 	}
+	if err := compareMetadata(expected.Metadata, actual.Metadata); err != nil {
+		return err
+	}
 	return compareFsConfig(&expected.FsConfig, &actual.FsConfig)
 }
 
+func compareMetadata(expected, actual util.Metadata) error {
+	if len(expected) != len(actual) {
+		return errors.New("metadata mismatch")
+	}
+	// metadata are normalized when saved
+	expected = expected.GetACopy()
+	if err := expected.Validate(); err != nil {
+		return err
+	}
+	for k, v := range expected {
+		val, ok := actual[k]
+		if !ok {
+			return fmt.Errorf("metadata %q not found", k)
+		}
+		if v.Type != val.Type || v.Value != val.Value {
+			return fmt.Errorf("metadata %q mismatch", k)
+		}
+	}
+	return nil
+}
+
 func checkAPIKey(expected, actual *dataprovider.APIKey) error {
 	if actual.Key != "" {
 		return errors.New("key must not be visible")
@@ -1591,6 +1928,14 @@ func checkAPIKey(expected, actual *dataprovider.APIKey) error {
 	if expected.Admin != actual.Admin {
 		return errors.New("admin mismatch")
 	}
+	if len(expected.Scopes) != len(actual.Scopes) {
+		return errors.New("scopes mismatch")
+	}
+	for _, scope := range expected.Scopes {
+		if !util.Contains(actual.Scopes, scope) {
+			return fmt.Errorf("scope %q not found", scope)
+		}
+	}
 
 	return nil
 }
@@ -1645,6 +1990,15 @@ func compareAdminFilters(expected, actual dataprovider.AdminFilters) error {
 	if expected.Preferences.HideUserPageSections != actual.Preferences.HideUserPageSections {
 		return errors.New("hide user page sections mismatch")
 	}
+	if !strings.EqualFold(strings.TrimSpace(expected.Preferences.Theme), actual.Preferences.Theme) {
+		return errors.New("theme mismatch")
+	}
+	if util.NormalizeLanguage(expected.Language) != actual.Language {
+		return errors.New("language mismatch")
+	}
+	if expected.PushConfig.Enabled != actual.PushConfig.Enabled {
+		return errors.New("push config mismatch")
+	}
 	return nil
 }
 
@@ -1667,6 +2021,137 @@ func compareAdminEqualFields(expected *dataprovider.Admin, actual *dataprovider.
 	return nil
 }
 
+func comparePortForwarding(expected, actual *dataprovider.SSHPortForwarding) error {
+	if !expected.IsEnabled() {
+		if actual != nil {
+			return errors.New("port forwarding must be disabled")
+		}
+		return nil
+	}
+	if actual == nil {
+		return errors.New("port forwarding must be enabled")
+	}
+	if expected.AllowLocal != actual.AllowLocal || expected.AllowRemote != actual.AllowRemote {
+		return errors.New("port forwarding allowed types mismatch")
+	}
+	if expected.Bandwidth != actual.Bandwidth {
+		return errors.New("port forwarding bandwidth mismatch")
+	}
+	if len(expected.PermitOpen) != len(actual.PermitOpen) {
+		return errors.New("port forwarding permit open mismatch")
+	}
+	for _, v := range expected.PermitOpen {
+		if !util.Contains(actual.PermitOpen, strings.TrimSpace(v)) {
+			return errors.New("port forwarding permit open content mismatch")
+		}
+	}
+	if len(expected.PermitListen) != len(actual.PermitListen) {
+		return errors.New("port forwarding permit listen mismatch")
+	}
+	for _, v := range expected.PermitListen {
+		if !util.Contains(actual.PermitListen, strings.TrimSpace(v)) {
+			return errors.New("port forwarding permit listen content mismatch")
+		}
+	}
+	return nil
+}
+
+func compareBandwidthSchedules(expected, actual []dataprovider.BandwidthSchedule) error {
+	if len(expected) != len(actual) {
+		return errors.New("bandwidth schedules mismatch")
+	}
+	for idx, s := range expected {
+		if s != actual[idx] {
+			return errors.New("bandwidth schedules content mismatch")
+		}
+	}
+	return nil
+}
+
+func compareTransferQuotaReset(expected, actual *dataprovider.TransferQuotaReset) error {
+	if expected.IsEnabled() != actual.IsEnabled() {
+		return errors.New("transfer quota reset mismatch")
+	}
+	if expected.IsEnabled() && *expected != *actual {
+		return errors.New("transfer quota reset content mismatch")
+	}
+	return nil
+}
+
+func compareMultipartUploads(expected, actual []dataprovider.MultipartUploadSettings) error {
+	if len(expected) != len(actual) {
+		return errors.New("multipart uploads mismatch")
+	}
+	for idx, s := range expected {
+		if util.CleanPath(s.Path) != actual[idx].Path {
+			return errors.New("multipart uploads path mismatch")
+		}
+		if s.PartSize != actual[idx].PartSize {
+			return errors.New("multipart uploads part size mismatch")
+		}
+		if s.Concurrency != actual[idx].Concurrency {
+			return errors.New("multipart uploads concurrency mismatch")
+		}
+	}
+	return nil
+}
+
+func compareUploadSizeLimits(expected, actual []dataprovider.UploadSizeLimit) error {
+	if len(expected) != len(actual) {
+		return errors.New("upload size limits mismatch")
+	}
+	for idx, l := range expected {
+		if util.CleanPath(l.Path) != actual[idx].Path {
+			return errors.New("upload size limit path mismatch")
+		}
+		if l.MaxSize != actual[idx].MaxSize {
+			return errors.New("upload size limit max size mismatch")
+		}
+		if len(l.Extensions) != len(actual[idx].Extensions) {
+			return errors.New("upload size limit extensions mismatch")
+		}
+		for _, ext := range l.Extensions {
+			ext = strings.ToLower(strings.TrimSpace(ext))
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			if !util.Contains(actual[idx].Extensions, ext) {
+				return errors.New("upload size limit extensions content mismatch")
+			}
+		}
+	}
+	return nil
+}
+
+func compareClientPolicy(expected, actual *dataprovider.ClientPolicy) error {
+	if !expected.IsEnabled() {
+		if actual != nil {
+			return errors.New("client policy must be empty")
+		}
+		return nil
+	}
+	if actual == nil {
+		return errors.New("client policy must not be empty")
+	}
+	if len(expected.Allowed) != len(actual.Allowed) {
+		return errors.New("allowed clients mismatch")
+	}
+	for _, v := range expected.Allowed {
+		if !util.Contains(actual.Allowed, strings.TrimSpace(v)) {
+			return errors.New("allowed clients content mismatch")
+		}
+	}
+	if len(expected.Denied) != len(actual.Denied) {
+		return errors.New("denied clients mismatch")
+	}
+	for _, v := range expected.Denied {
+		if !util.Contains(actual.Denied, strings.TrimSpace(v)) {
+			return errors.New("denied clients content mismatch")
+		}
+	}
+	return nil
+}
+
 func checkUser(expected *dataprovider.User, actual *dataprovider.User) error {
 	if actual.Password != "" {
 		return errors.New("user password must not be visible")
@@ -1695,6 +2180,51 @@ func checkUser(expected *dataprovider.User, actual *dataprovider.User) error {
 	if err := compareUserFilters(expected.Filters.BaseUserFilters, actual.Filters.BaseUserFilters); err != nil {
 		return err
 	}
+	if util.NormalizeLanguage(expected.Filters.Language) != actual.Filters.Language {
+		return errors.New("language mismatch")
+	}
+	if !strings.EqualFold(strings.TrimSpace(expected.Filters.Theme), actual.Filters.Theme) {
+		return errors.New("theme mismatch")
+	}
+	if strings.TrimSpace(expected.Filters.FTPPassiveHost) != actual.Filters.FTPPassiveHost {
+		return errors.New("FTP passive host mismatch")
+	}
+	if expected.Filters.FTPModeZ != actual.Filters.FTPModeZ {
+		return errors.New("FTP MODE Z mismatch")
+	}
+	if err := compareUserPushConfig(expected.Filters.PushConfig, actual.Filters.PushConfig); err != nil {
+		return err
+	}
+	if strings.TrimSpace(expected.Filters.PasswordPolicy) != actual.Filters.PasswordPolicy {
+		return errors.New("password policy mismatch")
+	}
+	if err := compareCountries(expected.Filters.AllowedCountries, actual.Filters.AllowedCountries); err != nil {
+		return fmt.Errorf("allowed %w", err)
+	}
+	if err := compareCountries(expected.Filters.DeniedCountries, actual.Filters.DeniedCountries); err != nil {
+		return fmt.Errorf("denied %w", err)
+	}
+	if len(actual.Filters.PasswordHistory) > 0 {
+		return errors.New("password history must not be exposed")
+	}
+	if err := comparePortForwarding(expected.Filters.PortForwarding, actual.Filters.PortForwarding); err != nil {
+		return err
+	}
+	if err := compareClientPolicy(expected.Filters.ClientPolicy, actual.Filters.ClientPolicy); err != nil {
+		return err
+	}
+	if err := compareUploadSizeLimits(expected.Filters.UploadSizeLimits, actual.Filters.UploadSizeLimits); err != nil {
+		return err
+	}
+	if err := compareMultipartUploads(expected.Filters.MultipartUploads, actual.Filters.MultipartUploads); err != nil {
+		return err
+	}
+	if err := compareBandwidthSchedules(expected.Filters.BandwidthSchedules, actual.Filters.BandwidthSchedules); err != nil {
+		return err
+	}
+	if err := compareTransferQuotaReset(expected.Filters.TransferQuotaReset, actual.Filters.TransferQuotaReset); err != nil {
+		return err
+	}
 	if err := compareFsConfig(&expected.FsConfig, &actual.FsConfig); err != nil {
 		return err
 	}
@@ -1784,6 +2314,12 @@ func compareVirtualFolders(expected []vfs.VirtualFolder, actual []vfs.VirtualFol
 				if (v.QuotaFiles) != (v1.QuotaFiles) {
 					return errors.New("vfolder quota files mismatch")
 				}
+				if v.UserQuotaSize != v1.UserQuotaSize {
+					return errors.New("vfolder user quota size mismatch")
+				}
+				if v.UserQuotaFiles != v1.UserQuotaFiles {
+					return errors.New("vfolder user quota files mismatch")
+				}
 				found = true
 				break
 			}
@@ -1814,7 +2350,19 @@ func compareFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if err := compareSFTPFsConfig(expected, actual); err != nil {
 		return err
 	}
-	return compareHTTPFsConfig(expected, actual)
+	if err := compareHTTPFsConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareSMBFsConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareWebDAVFsConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareCompressedFsConfig(expected, actual); err != nil {
+		return err
+	}
+	return compareDedupFsConfig(expected, actual)
 }
 
 func compareS3Config(expected *vfs.Filesystem, actual *vfs.Filesystem) error { //nolint:gocyclo
@@ -1857,6 +2405,9 @@ func compareS3Config(expected *vfs.Filesystem, actual *vfs.Filesystem) error { /
 	if expected.S3Config.ForcePathStyle != actual.S3Config.ForcePathStyle {
 		return errors.New("fs S3 force path style mismatch")
 	}
+	if expected.S3Config.EmulateSymlinks != actual.S3Config.EmulateSymlinks {
+		return errors.New("fs S3 emulate symlinks mismatch")
+	}
 	if expected.S3Config.DownloadPartMaxTime != actual.S3Config.DownloadPartMaxTime {
 		return errors.New("fs S3 download part max time mismatch")
 	}
@@ -1887,6 +2438,110 @@ func compareGCSConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if expected.GCSConfig.AutomaticCredentials != actual.GCSConfig.AutomaticCredentials {
 		return errors.New("GCS automatic credentials mismatch")
 	}
+	if expected.GCSConfig.KMSKeyName != actual.GCSConfig.KMSKeyName {
+		return errors.New("GCS KMS key name mismatch")
+	}
+	if expected.GCSConfig.EmulateSymlinks != actual.GCSConfig.EmulateSymlinks {
+		return errors.New("GCS emulate symlinks mismatch")
+	}
+	if err := checkEncryptedSecret(expected.GCSConfig.EncryptionKey, actual.GCSConfig.EncryptionKey); err != nil {
+		return fmt.Errorf("GCS encryption key mismatch: %w", err)
+	}
+	return nil
+}
+
+func compareSMBFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if expected.Provider != vfs.SMBFilesystemProvider {
+		// the SMB config is cleared for the other providers
+		return nil
+	}
+	if expected.SMBConfig.Endpoint != actual.SMBConfig.Endpoint {
+		return errors.New("SMBFs endpoint mismatch")
+	}
+	if strings.Trim(expected.SMBConfig.Share, `/\`) != actual.SMBConfig.Share {
+		return errors.New("SMBFs share mismatch")
+	}
+	if expected.SMBConfig.Domain != actual.SMBConfig.Domain {
+		return errors.New("SMBFs domain mismatch")
+	}
+	if expected.SMBConfig.Username != actual.SMBConfig.Username {
+		return errors.New("SMBFs username mismatch")
+	}
+	if expected.SMBConfig.EqualityCheckMode != actual.SMBConfig.EqualityCheckMode {
+		return errors.New("SMBFs equality_check_mode mismatch")
+	}
+	if err := checkEncryptedSecret(expected.SMBConfig.Password, actual.SMBConfig.Password); err != nil {
+		return fmt.Errorf("SMBFs password mismatch: %v", err)
+	}
+	if util.CleanPath(strings.ReplaceAll(expected.SMBConfig.Prefix, "\\", "/")) != actual.SMBConfig.Prefix {
+		return errors.New("SMBFs prefix mismatch")
+	}
+	return nil
+}
+
+func compareWebDAVFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if expected.Provider != vfs.WebDAVFilesystemProvider {
+		// the WebDAV config is cleared for the other providers
+		return nil
+	}
+	if strings.TrimRight(expected.WebDAVConfig.Endpoint, "/") != actual.WebDAVConfig.Endpoint {
+		return errors.New("WebDAVFs endpoint mismatch")
+	}
+	if expected.WebDAVConfig.Username != actual.WebDAVConfig.Username {
+		return errors.New("WebDAVFs username mismatch")
+	}
+	if expected.WebDAVConfig.SkipTLSVerify != actual.WebDAVConfig.SkipTLSVerify {
+		return errors.New("WebDAVFs skip_tls_verify mismatch")
+	}
+	if expected.WebDAVConfig.CACertificates != actual.WebDAVConfig.CACertificates {
+		return errors.New("WebDAVFs ca_certificates mismatch")
+	}
+	if expected.WebDAVConfig.UploadChunkSize != actual.WebDAVConfig.UploadChunkSize {
+		return errors.New("WebDAVFs upload_chunk_size mismatch")
+	}
+	if expected.WebDAVConfig.UploadChunkSize > 0 &&
+		strings.TrimRight(expected.WebDAVConfig.UploadsEndpoint, "/") != actual.WebDAVConfig.UploadsEndpoint {
+		return errors.New("WebDAVFs uploads_endpoint mismatch")
+	}
+	if expected.WebDAVConfig.EqualityCheckMode != actual.WebDAVConfig.EqualityCheckMode {
+		return errors.New("WebDAVFs equality_check_mode mismatch")
+	}
+	if err := checkEncryptedSecret(expected.WebDAVConfig.Password, actual.WebDAVConfig.Password); err != nil {
+		return fmt.Errorf("WebDAVFs password mismatch: %v", err)
+	}
+	if util.CleanPath(expected.WebDAVConfig.Prefix) != actual.WebDAVConfig.Prefix {
+		return errors.New("WebDAVFs prefix mismatch")
+	}
+	return nil
+}
+
+func compareCompressedFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if expected.Provider != vfs.CompressedFilesystemProvider {
+		return nil
+	}
+	algorithm := expected.CompressConfig.Algorithm
+	if algorithm == "" {
+		algorithm = vfs.CompressionAlgoZstd
+	}
+	if algorithm != actual.CompressConfig.Algorithm {
+		return errors.New("compressed fs algorithm mismatch")
+	}
+	if expected.CompressConfig.Level != actual.CompressConfig.Level {
+		return errors.New("compressed fs level mismatch")
+	}
+	return nil
+}
+
+func compareDedupFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if expected.Provider != vfs.DedupFilesystemProvider {
+		return nil
+	}
+	if filepath.Clean(expected.DedupConfig.StorePath) != actual.DedupConfig.StorePath {
+		return errors.New("dedup fs store path mismatch")
+	}
+	if expected.DedupConfig.QuotaMode != actual.DedupConfig.QuotaMode {
+		return errors.New("dedup fs quota mode mismatch")
+	}
 	return nil
 }
 
@@ -1928,6 +2583,9 @@ func compareSFTPFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error
 	if expected.SFTPConfig.EqualityCheckMode != actual.SFTPConfig.EqualityCheckMode {
 		return errors.New("SFTPFs equality_check_mode mismatch")
 	}
+	if expected.SFTPConfig.UserCredentials != actual.SFTPConfig.UserCredentials {
+		return errors.New("SFTPFs user_credentials mismatch")
+	}
 	if err := checkEncryptedSecret(expected.SFTPConfig.Password, actual.SFTPConfig.Password); err != nil {
 		return fmt.Errorf("SFTPFs password mismatch: %v", err)
 	}
@@ -1988,9 +2646,20 @@ func compareAzBlobConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error
 	if expected.AzBlobConfig.UseEmulator != actual.AzBlobConfig.UseEmulator {
 		return errors.New("azure Blob use emulator mismatch")
 	}
+	if expected.AzBlobConfig.EmulateSymlinks != actual.AzBlobConfig.EmulateSymlinks {
+		return errors.New("azure Blob emulate symlinks mismatch")
+	}
 	if expected.AzBlobConfig.AccessTier != actual.AzBlobConfig.AccessTier {
 		return errors.New("azure Blob access tier mismatch")
 	}
+	if len(expected.AzBlobConfig.TieringRules) != len(actual.AzBlobConfig.TieringRules) {
+		return errors.New("azure Blob tiering rules mismatch")
+	}
+	for idx := range expected.AzBlobConfig.TieringRules {
+		if expected.AzBlobConfig.TieringRules[idx] != actual.AzBlobConfig.TieringRules[idx] {
+			return fmt.Errorf("azure Blob tiering rule %d mismatch", idx)
+		}
+	}
 	return nil
 }
 
@@ -2272,6 +2941,12 @@ func compareEventActionHTTPConfigFields(expected, actual dataprovider.EventActio
 	if err := checkEncryptedSecret(expected.Password, actual.Password); err != nil {
 		return err
 	}
+	if err := checkEncryptedSecret(expected.SigningSecret, actual.SigningSecret); err != nil {
+		return fmt.Errorf("http signing secret mismatch: %v", err)
+	}
+	if expected.Retries != actual.Retries {
+		return errors.New("http retries mismatch")
+	}
 	if err := compareKeyValues(expected.Headers, actual.Headers); err != nil {
 		return errors.New("http headers mismatch")
 	}
@@ -2290,6 +2965,9 @@ func compareEventActionHTTPConfigFields(expected, actual dataprovider.EventActio
 	if expected.Body != actual.Body {
 		return errors.New("http body mismatch")
 	}
+	if expected.Transform != actual.Transform {
+		return errors.New("http transform mismatch")
+	}
 	if len(expected.Parts) != len(actual.Parts) {
 		return errors.New("http parts mismatch")
 	}
@@ -2305,6 +2983,31 @@ func compareEventActionEmailConfigFields(expected, actual dataprovider.EventActi
 			return errors.New("email recipients content mismatch")
 		}
 	}
+	if len(expected.Cc) != len(actual.Cc) {
+		return errors.New("email cc mismatch")
+	}
+	for _, v := range expected.Cc {
+		if !util.Contains(actual.Cc, v) {
+			return errors.New("email cc content mismatch")
+		}
+	}
+	if len(expected.Bcc) != len(actual.Bcc) {
+		return errors.New("email bcc mismatch")
+	}
+	for _, v := range expected.Bcc {
+		if !util.Contains(actual.Bcc, v) {
+			return errors.New("email bcc content mismatch")
+		}
+	}
+	if expected.ReplyTo != actual.ReplyTo {
+		return errors.New("email reply to mismatch")
+	}
+	if expected.Template != actual.Template {
+		return errors.New("email template mismatch")
+	}
+	if util.NormalizeLanguage(expected.Language) != actual.Language {
+		return errors.New("email language mismatch")
+	}
 	if expected.Subject != actual.Subject {
 		return errors.New("email subject mismatch")
 	}
@@ -2420,6 +3123,136 @@ func compareEventActionDataRetentionFields(expected, actual dataprovider.EventAc
 	return nil
 }
 
+func compareEventActionStorageTransitionFields(expected, actual dataprovider.EventActionStorageTransitionConfig) error {
+	if expected.DryRun != actual.DryRun {
+		return errors.New("storage transition dry run mismatch")
+	}
+	if len(expected.Folders) != len(actual.Folders) {
+		return errors.New("storage transition folders mismatch")
+	}
+	for _, f1 := range expected.Folders {
+		found := false
+		for _, f2 := range actual.Folders {
+			if f1.Path == f2.Path {
+				found = true
+				if f1.Age != f2.Age {
+					return fmt.Errorf("storage transition age mismatch for folder %s", f1.Path)
+				}
+				if f1.S3StorageClass != f2.S3StorageClass {
+					return fmt.Errorf("s3_storage_class mismatch for folder %s", f1.Path)
+				}
+				if f1.GCSStorageClass != f2.GCSStorageClass {
+					return fmt.Errorf("gcs_storage_class mismatch for folder %s", f1.Path)
+				}
+				if f1.AzureAccessTier != f2.AzureAccessTier {
+					return fmt.Errorf("azure_access_tier mismatch for folder %s", f1.Path)
+				}
+				break
+			}
+		}
+		if !found {
+			return errors.New("storage transition folders mismatch")
+		}
+	}
+	return nil
+}
+
+func compareEventActionIntegrityCheckFields(expected, actual dataprovider.EventActionIntegrityCheckConfig) error {
+	if expected.SamplePercentage != actual.SamplePercentage {
+		return errors.New("integrity check sample percentage mismatch")
+	}
+	if expected.ManifestsPath != actual.ManifestsPath {
+		return errors.New("integrity check manifests path mismatch")
+	}
+	if len(expected.Paths) != len(actual.Paths) {
+		return errors.New("integrity check paths mismatch")
+	}
+	for _, p := range expected.Paths {
+		if !util.Contains(actual.Paths, p) {
+			return errors.New("integrity check paths content mismatch")
+		}
+	}
+	return nil
+}
+
+func compareEventActionPublishConfigFields(expected, actual dataprovider.EventActionPublishConfig) error {
+	if expected.Broker != actual.Broker {
+		return errors.New("publish broker mismatch")
+	}
+	if expected.Endpoint != actual.Endpoint {
+		return errors.New("publish endpoint mismatch")
+	}
+	if expected.Username != actual.Username {
+		return errors.New("publish username mismatch")
+	}
+	if err := checkEncryptedSecret(expected.Password, actual.Password); err != nil {
+		return err
+	}
+	if expected.TLS != actual.TLS {
+		return errors.New("publish TLS mismatch")
+	}
+	if expected.SkipTLSVerify != actual.SkipTLSVerify {
+		return errors.New("publish skip TLS verify mismatch")
+	}
+	if expected.Topic != actual.Topic {
+		return errors.New("publish topic mismatch")
+	}
+	if expected.Exchange != actual.Exchange {
+		return errors.New("publish exchange mismatch")
+	}
+	if expected.Key != actual.Key {
+		return errors.New("publish key mismatch")
+	}
+	if err := compareKeyValues(expected.Headers, actual.Headers); err != nil {
+		return errors.New("publish headers mismatch")
+	}
+	if expected.Body != actual.Body {
+		return errors.New("publish body mismatch")
+	}
+	if expected.Delivery != actual.Delivery {
+		return errors.New("publish delivery mismatch")
+	}
+	if expected.PoolSize != actual.PoolSize {
+		return errors.New("publish pool size mismatch")
+	}
+	if expected.Timeout != actual.Timeout {
+		return errors.New("publish timeout mismatch")
+	}
+	return nil
+}
+
+func compareEventActionSummaryReportFields(expected, actual dataprovider.EventActionSummaryReportConfig) error {
+	if len(expected.Recipients) != len(actual.Recipients) {
+		return errors.New("summary report recipients mismatch")
+	}
+	for _, v := range expected.Recipients {
+		if !util.Contains(actual.Recipients, v) {
+			return errors.New("summary report recipients content mismatch")
+		}
+	}
+	if len(expected.Admins) != len(actual.Admins) {
+		return errors.New("summary report admins mismatch")
+	}
+	for _, v := range expected.Admins {
+		if !util.Contains(actual.Admins, v) {
+			return errors.New("summary report admins content mismatch")
+		}
+	}
+	if expected.Subject != actual.Subject {
+		return errors.New("summary report subject mismatch")
+	}
+	if expected.Language != actual.Language {
+		return errors.New("summary report language mismatch")
+	}
+	if expected.Period != actual.Period {
+		return errors.New("summary report period mismatch")
+	}
+	if expected.TopCount != actual.TopCount {
+		return errors.New("summary report top count mismatch")
+	}
+	return nil
+}
+
 func compareEqualGroupSettingsFields(expected sdk.BaseGroupUserSettings, actual sdk.BaseGroupUserSettings) error {
 	if expected.HomeDir != actual.HomeDir {
 		return errors.New("home dir mismatch")
@@ -2488,6 +3321,9 @@ func compareEqualsUserFields(expected *dataprovider.User, actual *dataprovider.U
 	if expected.Description != actual.Description {
 		return errors.New("description mismatch")
 	}
+	if err := compareMetadata(expected.Metadata, actual.Metadata); err != nil {
+		return err
+	}
 	return compareQuotaUserFields(expected, actual)
 }
 
@@ -2551,3 +3387,22 @@ func addDisconnectQueryParam(rawurl, disconnect string) (*url.URL, error) {
 	url.RawQuery = q.Encode()
 	return url, err
 }
+
+func compareUserPushConfig(expected, actual dataprovider.UserPushConfig) error {
+	if expected.Enabled != actual.Enabled {
+		return errors.New("push config enabled mismatch")
+	}
+	if !expected.Enabled {
+		return nil
+	}
+	expectedProtocols := util.RemoveDuplicates(append([]string(nil), expected.Protocols...), false)
+	if len(expectedProtocols) != len(actual.Protocols) {
+		return errors.New("push config protocols mismatch")
+	}
+	for _, protocol := range expectedProtocols {
+		if !util.Contains(actual.Protocols, protocol) {
+			return errors.New("push config protocols content mismatch")
+		}
+	}
+	return nil
+}
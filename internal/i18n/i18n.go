@@ -0,0 +1,327 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+// Package i18n provides the message catalogs used to localize the web
+// interfaces and the user facing messages returned by the HTTP server
+package i18n
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	logSender = "i18n"
+	// DefaultLanguage is the language of the messages embedded in the source
+	// code and in the templates, it does not require a catalog
+	DefaultLanguage = "en"
+	catalogExt      = ".json"
+	// MaxCatalogSize defines the maximum size for a message catalog
+	MaxCatalogSize = 2097152
+)
+
+var (
+	rtlLanguages = []string{"ar", "dv", "fa", "he", "ku", "ps", "sd", "ug", "ur", "yi"}
+	catalogs     = catalogsRegistry{
+		defaultLang: DefaultLanguage,
+		catalogs:    make(map[string]*catalog),
+	}
+)
+
+// LanguageInfo describes an available language
+type LanguageInfo struct {
+	// Normalized language tag, for example "pt-br"
+	Tag string
+	// Name of the language in the language itself, for example "Português"
+	Name string
+	// True for right-to-left scripts
+	RTL bool
+}
+
+// catalog defines the format of a message catalog file. The messages are keyed by
+// the English text used in the source code and in the templates
+type catalog struct {
+	Name     string            `json:"name"`
+	Messages map[string]string `json:"messages"`
+}
+
+type catalogsRegistry struct {
+	sync.RWMutex
+	defaultLang string
+	catalogs    map[string]*catalog
+}
+
+// Initialize loads the built-in message catalogs from builtinPath and the custom
+// ones from customPath, if not empty. Each catalog is a JSON file named as the
+// language, for example "de.json". A custom catalog for a language that has a
+// built-in one overrides the built-in messages with the same key
+func Initialize(builtinPath, customPath, defaultLang string) error {
+	loaded := make(map[string]*catalog)
+	if err := loadCatalogs(builtinPath, loaded, false); err != nil {
+		return err
+	}
+	if customPath != "" {
+		if err := loadCatalogs(customPath, loaded, true); err != nil {
+			return err
+		}
+	}
+	defaultLang = util.NormalizeLanguage(defaultLang)
+	if defaultLang == "" {
+		defaultLang = DefaultLanguage
+	}
+	if defaultLang != DefaultLanguage {
+		if _, ok := loaded[defaultLang]; !ok {
+			return fmt.Errorf("i18n: no message catalog for the default language %q", defaultLang)
+		}
+	}
+
+	catalogs.Lock()
+	defer catalogs.Unlock()
+
+	catalogs.defaultLang = defaultLang
+	catalogs.catalogs = loaded
+	logger.Debug(logSender, "", "message catalogs loaded, languages: %v, default: %q", getTags(loaded), defaultLang)
+	return nil
+}
+
+func loadCatalogs(dirPath string, loaded map[string]*catalog, isCustom bool) error {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !isCustom {
+			logger.Warn(logSender, "", "built-in message catalogs dir %q not found, only %q will be available",
+				dirPath, DefaultLanguage)
+			return nil
+		}
+		return fmt.Errorf("i18n: unable to read catalogs dir %q: %w", dirPath, err)
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || filepath.Ext(entry.Name()) != catalogExt {
+			continue
+		}
+		lang := util.NormalizeLanguage(strings.TrimSuffix(entry.Name(), catalogExt))
+		if !util.IsLanguageValid(lang) || lang == DefaultLanguage {
+			logger.Warn(logSender, "", "catalog %q ignored, invalid language %q", entry.Name(), lang)
+			continue
+		}
+		c, err := readCatalog(filepath.Join(dirPath, entry.Name()))
+		if err != nil {
+			return err
+		}
+		if existing, ok := loaded[lang]; ok {
+			if c.Name != "" {
+				existing.Name = c.Name
+			}
+			for k, v := range c.Messages {
+				existing.Messages[k] = v
+			}
+			continue
+		}
+		if c.Name == "" {
+			c.Name = lang
+		}
+		loaded[lang] = c
+	}
+	return nil
+}
+
+func readCatalog(name string) (*catalog, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, fmt.Errorf("i18n: unable to stat catalog %q: %w", name, err)
+	}
+	if info.Size() > MaxCatalogSize {
+		return nil, fmt.Errorf("i18n: catalog %q is too big: %d bytes", name, info.Size())
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("i18n: unable to read catalog %q: %w", name, err)
+	}
+	var c catalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("i18n: unable to parse catalog %q: %w", name, err)
+	}
+	if c.Messages == nil {
+		c.Messages = make(map[string]string)
+	}
+	return &c, nil
+}
+
+func getTags(loaded map[string]*catalog) []string {
+	tags := []string{DefaultLanguage}
+	for tag := range loaded {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// GetDefaultLanguage returns the configured default language
+func GetDefaultLanguage() string {
+	catalogs.RLock()
+	defer catalogs.RUnlock()
+
+	return catalogs.defaultLang
+}
+
+// GetLanguages returns the available languages sorted by tag
+func GetLanguages() []LanguageInfo {
+	catalogs.RLock()
+	defer catalogs.RUnlock()
+
+	result := []LanguageInfo{{Tag: DefaultLanguage, Name: "English"}}
+	for tag, c := range catalogs.catalogs {
+		result = append(result, LanguageInfo{
+			Tag:  tag,
+			Name: c.Name,
+			RTL:  IsRTL(tag),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Tag < result[j].Tag
+	})
+	return result
+}
+
+// IsAvailable returns true if the specified, normalized, language can be used
+func IsAvailable(lang string) bool {
+	if lang == DefaultLanguage {
+		return true
+	}
+	catalogs.RLock()
+	defer catalogs.RUnlock()
+
+	_, ok := catalogs.catalogs[lang]
+	return ok
+}
+
+// IsRTL returns true if the specified language uses a right-to-left script
+func IsRTL(lang string) bool {
+	base, _, _ := strings.Cut(util.NormalizeLanguage(lang), "-")
+	return util.Contains(rtlLanguages, base)
+}
+
+// GetDirection returns the text direction, "rtl" or "ltr", for the specified language
+func GetDirection(lang string) string {
+	if IsRTL(lang) {
+		return "rtl"
+	}
+	return "ltr"
+}
+
+// T returns the translation of msg for the specified language. The message
+// itself is returned if the language or the translation is not available
+func T(lang, msg string) string {
+	if lang == "" || lang == DefaultLanguage || msg == "" {
+		return msg
+	}
+	catalogs.RLock()
+	defer catalogs.RUnlock()
+
+	if c, ok := catalogs.catalogs[lang]; ok {
+		if translated, ok := c.Messages[msg]; ok && translated != "" {
+			return translated
+		}
+	}
+	if base, _, ok := strings.Cut(lang, "-"); ok {
+		if c, ok := catalogs.catalogs[base]; ok {
+			if translated, ok := c.Messages[msg]; ok && translated != "" {
+				return translated
+			}
+		}
+	}
+	return msg
+}
+
+// Tf translates the specified format string and then formats it
+func Tf(lang, format string, a ...any) string {
+	return fmt.Sprintf(T(lang, format), a...)
+}
+
+// Match returns the first of the specified languages, in order of preference, that
+// is available. Each language is normalized and, if there is no catalog for it, the
+// base language, for example "pt" for "pt-br", is checked. An empty string is
+// returned if no language is available
+func Match(langs ...string) string {
+	for _, lang := range langs {
+		lang = util.NormalizeLanguage(lang)
+		if lang == "" {
+			continue
+		}
+		if IsAvailable(lang) {
+			return lang
+		}
+		if base, _, ok := strings.Cut(lang, "-"); ok && IsAvailable(base) {
+			return base
+		}
+	}
+	return ""
+}
+
+// Negotiate returns the language to use given the preferred language, if any,
+// and the value of the Accept-Language header. The default language is returned
+// if no other language is available
+func Negotiate(preferred, acceptLanguage string) string {
+	langs := append([]string{preferred}, ParseAcceptLanguage(acceptLanguage)...)
+	if lang := Match(langs...); lang != "" {
+		return lang
+	}
+	return GetDefaultLanguage()
+}
+
+// ParseAcceptLanguage returns the languages in the specified Accept-Language
+// header value sorted by quality, highest first
+func ParseAcceptLanguage(header string) []string {
+	type weightedLang struct {
+		lang    string
+		quality float64
+	}
+	var langs []weightedLang
+
+	for _, part := range strings.Split(header, ",") {
+		lang, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang = strings.TrimSpace(lang)
+		if lang == "" || lang == "*" {
+			continue
+		}
+		quality := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			val, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			if err != nil {
+				continue
+			}
+			quality = val
+		}
+		if quality <= 0 {
+			continue
+		}
+		langs = append(langs, weightedLang{lang: lang, quality: quality})
+	}
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].quality > langs[j].quality
+	})
+	result := make([]string, 0, len(langs))
+	for _, l := range langs {
+		result = append(result, l.lang)
+	}
+	return result
+}
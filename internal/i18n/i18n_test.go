@@ -0,0 +1,123 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeCatalog(t *testing.T, dir, name, content string) {
+	t.Helper()
+	err := os.WriteFile(filepath.Join(dir, name), []byte(content), os.ModePerm)
+	require.NoError(t, err)
+}
+
+func TestCatalogs(t *testing.T) {
+	builtinDir := t.TempDir()
+	customDir := t.TempDir()
+	writeCatalog(t, builtinDir, "it.json", `{"name":"Italiano","messages":{"Login":"Accedi","Logout":"Esci","Users: %d":"Utenti: %d"}}`)
+	writeCatalog(t, builtinDir, "pt_BR.json", `{"name":"Português","messages":{"Login":"Entrar"}}`)
+	writeCatalog(t, builtinDir, "ar.json", `{"messages":{"Login":"تسجيل الدخول"}}`)
+	writeCatalog(t, builtinDir, "en.json", `{"messages":{"Login":"Sign in"}}`)
+	writeCatalog(t, builtinDir, "invalid lang.json", `{}`)
+	writeCatalog(t, builtinDir, "readme.txt", `not a catalog`)
+	writeCatalog(t, customDir, "it.json", `{"messages":{"Logout":"Disconnetti"}}`)
+
+	err := Initialize(builtinDir, customDir, "")
+	require.NoError(t, err)
+	defer func() {
+		err := Initialize(filepath.Join(builtinDir, "missing"), "", "")
+		assert.NoError(t, err)
+	}()
+
+	assert.Equal(t, DefaultLanguage, GetDefaultLanguage())
+	languages := GetLanguages()
+	if assert.Len(t, languages, 4) {
+		assert.Equal(t, "ar", languages[0].Tag)
+		assert.Equal(t, "ar", languages[0].Name)
+		assert.True(t, languages[0].RTL)
+		assert.Equal(t, DefaultLanguage, languages[1].Tag)
+		assert.Equal(t, "it", languages[2].Tag)
+		assert.Equal(t, "Italiano", languages[2].Name)
+		assert.False(t, languages[2].RTL)
+		assert.Equal(t, "pt-br", languages[3].Tag)
+	}
+	assert.True(t, IsAvailable("it"))
+	assert.True(t, IsAvailable(DefaultLanguage))
+	assert.False(t, IsAvailable("de"))
+
+	assert.Equal(t, "Accedi", T("it", "Login"))
+	assert.Equal(t, "Disconnetti", T("it", "Logout"))
+	assert.Equal(t, "Accedi", T("it-ch", "Login"))
+	assert.Equal(t, "Entrar", T("pt-br", "Login"))
+	assert.Equal(t, "Login", T("en", "Login"))
+	assert.Equal(t, "Login", T("", "Login"))
+	assert.Equal(t, "Login", T("de", "Login"))
+	assert.Equal(t, "Not translated", T("it", "Not translated"))
+	assert.Equal(t, "Utenti: 3", Tf("it", "Users: %d", 3))
+	assert.Equal(t, "Users: 3", Tf("de", "Users: %d", 3))
+
+	assert.Equal(t, "it", Match("de", "it-IT"))
+	assert.Equal(t, "pt-br", Match("pt_BR"))
+	assert.Empty(t, Match("de", "fr"))
+	assert.Empty(t, Match())
+
+	assert.Equal(t, "it", Negotiate("it", "pt-BR,pt;q=0.9"))
+	assert.Equal(t, "pt-br", Negotiate("", "pt-BR,pt;q=0.9"))
+	assert.Equal(t, "pt-br", Negotiate("de", "fr;q=0.9, pt-BR;q=0.8"))
+	assert.Equal(t, DefaultLanguage, Negotiate("de", "fr"))
+
+	err = Initialize(builtinDir, customDir, "it")
+	require.NoError(t, err)
+	assert.Equal(t, "it", GetDefaultLanguage())
+	assert.Equal(t, "it", Negotiate("", "de, fr;q=0.5"))
+	assert.Equal(t, DefaultLanguage, Negotiate("", "en-US"))
+
+	err = Initialize(builtinDir, customDir, "de")
+	assert.Error(t, err)
+	// the previous catalogs are still in use
+	assert.Equal(t, "it", GetDefaultLanguage())
+
+	err = Initialize(builtinDir, filepath.Join(customDir, "missing"), "")
+	assert.Error(t, err)
+
+	writeCatalog(t, customDir, "fr.json", `{"messages":`)
+	err = Initialize(builtinDir, customDir, "")
+	assert.Error(t, err)
+}
+
+func TestDirection(t *testing.T) {
+	assert.True(t, IsRTL("ar"))
+	assert.True(t, IsRTL("he-IL"))
+	assert.True(t, IsRTL("fa_IR"))
+	assert.False(t, IsRTL("en"))
+	assert.False(t, IsRTL(""))
+	assert.Equal(t, "rtl", GetDirection("ur"))
+	assert.Equal(t, "ltr", GetDirection("it"))
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	assert.Empty(t, ParseAcceptLanguage(""))
+	assert.Empty(t, ParseAcceptLanguage("*"))
+	assert.Equal(t, []string{"fr-CH", "fr", "en", "de"},
+		ParseAcceptLanguage("fr-CH, fr;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5"))
+	assert.Equal(t, []string{"de", "it", "en"}, ParseAcceptLanguage("it;q=0.5, de, en;q=0.1"))
+	assert.Equal(t, []string{"it"}, ParseAcceptLanguage("it, de;q=0, fr;q=invalid"))
+}
@@ -0,0 +1,288 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package icap provides a minimal ICAP (RFC 3507) client to scan files
+// using antivirus gateways such as ClamAV/c-icap, Symantec or McAfee
+package icap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPort    = "1344"
+	defaultTLSPort = "11344"
+	chunkSize      = 32768
+)
+
+// Supported ICAP methods
+const (
+	MethodRESPMOD = "RESPMOD"
+	MethodREQMOD  = "REQMOD"
+)
+
+var (
+	// infectionHeaders are the response headers used by the most common ICAP
+	// servers to report a detection
+	infectionHeaders = []string{"X-Infection-Found", "X-Virus-Id", "X-Violations-Found", "X-Virus-Name"}
+	errInvalidStatus = errors.New("invalid ICAP status line")
+)
+
+// Client defines an ICAP client configuration
+type Client struct {
+	// ICAP service URL, for example "icap://127.0.0.1:1344/avscan".
+	// Use the "icaps" scheme for TLS connections
+	URL string
+	// ICAP method, RESPMOD or REQMOD. Empty means RESPMOD
+	Method        string
+	SkipTLSVerify bool
+	// Timeout for the whole scan, including the file upload to the ICAP server
+	Timeout time.Duration
+	parsed  *url.URL
+}
+
+// Validate returns an error if the configuration is not valid
+func (c *Client) Validate() error {
+	u, err := url.Parse(strings.TrimSpace(c.URL))
+	if err != nil {
+		return fmt.Errorf("invalid ICAP URL %q: %w", c.URL, err)
+	}
+	if u.Scheme != "icap" && u.Scheme != "icaps" {
+		return fmt.Errorf("invalid ICAP URL %q, supported schemes: icap, icaps", c.URL)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("invalid ICAP URL %q, host is required", c.URL)
+	}
+	switch strings.ToUpper(c.Method) {
+	case "", MethodRESPMOD:
+		c.Method = MethodRESPMOD
+	case MethodREQMOD:
+		c.Method = MethodREQMOD
+	default:
+		return fmt.Errorf("unsupported ICAP method %q", c.Method)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("invalid ICAP timeout %s", c.Timeout)
+	}
+	c.parsed = u
+	return nil
+}
+
+// Result defines the scan result
+type Result struct {
+	// Infected is true if the ICAP server reported a threat
+	Infected bool
+	// Threat name or description, as reported by the ICAP server, if any
+	Threat string
+	// ICAP status code
+	StatusCode int
+}
+
+func (c *Client) getAddress() string {
+	port := c.parsed.Port()
+	if port == "" {
+		port = defaultPort
+		if c.parsed.Scheme == "icaps" {
+			port = defaultTLSPort
+		}
+	}
+	return net.JoinHostPort(c.parsed.Hostname(), port)
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{}
+	if c.parsed.Scheme == "icaps" {
+		tlsDialer := &tls.Dialer{
+			NetDialer: dialer,
+			Config: &tls.Config{
+				ServerName:         c.parsed.Hostname(),
+				InsecureSkipVerify: c.SkipTLSVerify,
+				MinVersion:         tls.VersionTLS12,
+			},
+		}
+		return tlsDialer.DialContext(ctx, "tcp", c.getAddress())
+	}
+	return dialer.DialContext(ctx, "tcp", c.getAddress())
+}
+
+// getEncapsulatedHeaders returns the encapsulated HTTP headers and the
+// Encapsulated ICAP header value
+func (c *Client) getEncapsulatedHeaders(name string, size int64) (string, string) {
+	reqPath := (&url.URL{Path: "/" + strings.TrimPrefix(name, "/")}).EscapedPath()
+	if c.Method == MethodREQMOD {
+		reqHdr := fmt.Sprintf("PUT %s HTTP/1.1\r\nHost: sftpgo\r\nContent-Length: %d\r\n\r\n", reqPath, size)
+		return reqHdr, fmt.Sprintf("req-hdr=0, req-body=%d", len(reqHdr))
+	}
+	reqHdr := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: sftpgo\r\n\r\n", reqPath)
+	resHdr := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: %d\r\n\r\n", size)
+	return reqHdr + resHdr, fmt.Sprintf("req-hdr=0, res-hdr=%d, res-body=%d", len(reqHdr), len(reqHdr)+len(resHdr))
+}
+
+func (c *Client) writeRequest(w *bufio.Writer, reader io.Reader, name string, size int64) error {
+	httpHeaders, encapsulated := c.getEncapsulatedHeaders(name, size)
+	fmt.Fprintf(w, "%s %s ICAP/1.0\r\n", c.Method, c.parsed.String())
+	fmt.Fprintf(w, "Host: %s\r\n", c.parsed.Host)
+	fmt.Fprintf(w, "User-Agent: SFTPGo\r\n")
+	fmt.Fprintf(w, "Allow: 204\r\n")
+	fmt.Fprintf(w, "Encapsulated: %s\r\n\r\n", encapsulated)
+	if _, err := w.WriteString(httpHeaders); err != nil {
+		return err
+	}
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			if _, errWrite := w.Write(buf[:n]); errWrite != nil {
+				return errWrite
+			}
+			if _, errWrite := w.WriteString("\r\n"); errWrite != nil {
+				return errWrite
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read the file to scan: %w", err)
+		}
+	}
+	if _, err := w.WriteString("0\r\n\r\n"); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Scan sends the content read from reader to the ICAP server and returns the
+// scan result. name is the file name and size the file size, they are sent
+// within the encapsulated HTTP headers
+func (c *Client) Scan(ctx context.Context, reader io.Reader, name string, size int64) (Result, error) {
+	if c.parsed == nil {
+		if err := c.Validate(); err != nil {
+			return Result{}, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return Result{}, fmt.Errorf("unable to connect to the ICAP server: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline) //nolint:errcheck
+	}
+	if err := c.writeRequest(bufio.NewWriter(conn), reader, name, size); err != nil {
+		return Result{}, fmt.Errorf("unable to send the ICAP request: %w", err)
+	}
+	return readResponse(bufio.NewReader(conn))
+}
+
+func parseStatusLine(line string) (int, error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "ICAP/") {
+		return 0, fmt.Errorf("%w: %q", errInvalidStatus, line)
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", errInvalidStatus, line)
+	}
+	return code, nil
+}
+
+func getThreat(header textproto.MIMEHeader) string {
+	for _, name := range infectionHeaders {
+		if val := header.Get(name); val != "" {
+			return val
+		}
+	}
+	return ""
+}
+
+func readResponse(r *bufio.Reader) (Result, error) {
+	tp := textproto.NewReader(r)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return Result{}, fmt.Errorf("unable to read the ICAP response: %w", err)
+	}
+	code, err := parseStatusLine(line)
+	if err != nil {
+		return Result{}, err
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return Result{}, fmt.Errorf("unable to read the ICAP response headers: %w", err)
+	}
+	result := Result{
+		StatusCode: code,
+		Threat:     getThreat(header),
+	}
+	switch code {
+	case http.StatusNoContent:
+		// unmodified content, no threat found
+	case http.StatusOK:
+		if result.Threat != "" {
+			result.Infected = true
+			return result, nil
+		}
+		// the server replaced the content, this means a block page if the
+		// encapsulated HTTP status code is not successful
+		httpCode, err := readEncapsulatedStatus(r, header.Get("Encapsulated"))
+		if err != nil {
+			return result, err
+		}
+		if httpCode < http.StatusOK || httpCode > 299 {
+			result.Infected = true
+			result.Threat = fmt.Sprintf("blocked by the ICAP server, HTTP status code %d", httpCode)
+		}
+	default:
+		return result, fmt.Errorf("unexpected ICAP status code %d", code)
+	}
+	return result, nil
+}
+
+// readEncapsulatedStatus returns the status code of the encapsulated HTTP
+// response, if any
+func readEncapsulatedStatus(r *bufio.Reader, encapsulated string) (int, error) {
+	if !strings.Contains(encapsulated, "res-hdr") {
+		return http.StatusOK, nil
+	}
+	line, err := textproto.NewReader(r).ReadLine()
+	if err != nil {
+		return 0, fmt.Errorf("unable to read the encapsulated HTTP response: %w", err)
+	}
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "HTTP/") {
+		return 0, fmt.Errorf("invalid encapsulated HTTP status line: %q", line)
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid encapsulated HTTP status line: %q", line)
+	}
+	return code, nil
+}
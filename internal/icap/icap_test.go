@@ -0,0 +1,305 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package icap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http/httputil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testICAPRequest struct {
+	requestLine string
+	headers     map[string]string
+	httpHeaders string
+	body        []byte
+}
+
+type testICAPServer struct {
+	listener net.Listener
+	response string
+	requests chan testICAPRequest
+}
+
+func newTestICAPServer(t *testing.T, response string) *testICAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &testICAPServer{
+		listener: listener,
+		response: response,
+		requests: make(chan testICAPRequest, 10),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	return s
+}
+
+func (s *testICAPServer) getURL(path string) string {
+	return "icap://" + s.listener.Addr().String() + path
+}
+
+func (s *testICAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	req, err := readTestRequest(r)
+	if err != nil {
+		return
+	}
+	s.requests <- req
+	conn.Write([]byte(s.response)) //nolint:errcheck
+}
+
+func readTestRequest(r *bufio.Reader) (testICAPRequest, error) {
+	req := testICAPRequest{
+		headers: make(map[string]string),
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return req, err
+	}
+	req.requestLine = strings.TrimSpace(line)
+	for {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return req, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		key, val, _ := strings.Cut(line, ":")
+		req.headers[key] = strings.TrimSpace(val)
+	}
+	// the encapsulated HTTP headers end with an empty line, a RESPMOD
+	// request includes both request and response headers
+	numHeaders := 1
+	if strings.HasPrefix(req.requestLine, MethodRESPMOD) {
+		numHeaders = 2
+	}
+	var httpHeaders strings.Builder
+	for numHeaders > 0 {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return req, err
+		}
+		httpHeaders.WriteString(line)
+		if line == "\r\n" {
+			numHeaders--
+		}
+	}
+	req.httpHeaders = httpHeaders.String()
+	req.body, err = io.ReadAll(httputil.NewChunkedReader(r))
+	if err != nil {
+		return req, err
+	}
+	// read the final CRLF after the last chunk
+	_, err = r.ReadString('\n')
+	return req, err
+}
+
+type errReader struct{}
+
+func (r *errReader) Read(_ []byte) (int, error) {
+	return 0, errors.New("read error")
+}
+
+func TestValidate(t *testing.T) {
+	c := Client{
+		URL:     "http://127.0.0.1:1344/avscan",
+		Timeout: time.Second,
+	}
+	err := c.Validate()
+	assert.ErrorContains(t, err, "supported schemes")
+	c.URL = "icap:///avscan"
+	err = c.Validate()
+	assert.ErrorContains(t, err, "host is required")
+	c.URL = "icap://127.0.0.1\x7f/avscan"
+	err = c.Validate()
+	assert.Error(t, err)
+	c.URL = "icap://127.0.0.1/avscan"
+	c.Method = "OPTIONS"
+	err = c.Validate()
+	assert.ErrorContains(t, err, "unsupported ICAP method")
+	c.Method = "reqmod"
+	c.Timeout = 0
+	err = c.Validate()
+	assert.ErrorContains(t, err, "invalid ICAP timeout")
+	c.Timeout = time.Second
+	err = c.Validate()
+	assert.NoError(t, err)
+	assert.Equal(t, MethodREQMOD, c.Method)
+	assert.Equal(t, "127.0.0.1:1344", c.getAddress())
+	c.URL = "icaps://127.0.0.1/avscan"
+	c.Method = ""
+	err = c.Validate()
+	assert.NoError(t, err)
+	assert.Equal(t, MethodRESPMOD, c.Method)
+	assert.Equal(t, "127.0.0.1:11344", c.getAddress())
+}
+
+func TestScanClean(t *testing.T) {
+	server := newTestICAPServer(t, "ICAP/1.0 204 No Content\r\nISTag: \"test\"\r\nEncapsulated: null-body=0\r\n\r\n")
+	content := bytes.Repeat([]byte("clean content "), 5000)
+	c := Client{
+		URL:     server.getURL("/avscan"),
+		Timeout: 5 * time.Second,
+	}
+	result, err := c.Scan(context.Background(), bytes.NewReader(content), "/dir/file name.txt", int64(len(content)))
+	require.NoError(t, err)
+	assert.False(t, result.Infected)
+	assert.Empty(t, result.Threat)
+	assert.Equal(t, 204, result.StatusCode)
+
+	req := <-server.requests
+	assert.True(t, strings.HasPrefix(req.requestLine, "RESPMOD icap://"))
+	assert.Equal(t, "204", req.headers["Allow"])
+	assert.Contains(t, req.headers["Encapsulated"], "res-body=")
+	assert.Contains(t, req.httpHeaders, "GET /dir/file%20name.txt HTTP/1.1")
+	assert.Contains(t, req.httpHeaders, "Content-Length: 70000")
+	assert.Equal(t, content, req.body)
+
+	c.Method = MethodREQMOD
+	err = c.Validate()
+	require.NoError(t, err)
+	result, err = c.Scan(context.Background(), strings.NewReader("data"), "file.txt", 4)
+	require.NoError(t, err)
+	assert.False(t, result.Infected)
+	req = <-server.requests
+	assert.True(t, strings.HasPrefix(req.requestLine, "REQMOD icap://"))
+	assert.Contains(t, req.headers["Encapsulated"], "req-body=")
+	assert.Contains(t, req.httpHeaders, "PUT /file.txt HTTP/1.1")
+	assert.Equal(t, []byte("data"), req.body)
+}
+
+func TestScanInfected(t *testing.T) {
+	server := newTestICAPServer(t, "ICAP/1.0 200 OK\r\nX-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Signature;\r\n"+
+		"Encapsulated: res-hdr=0, res-body=50\r\n\r\nHTTP/1.1 403 Forbidden\r\n\r\n0\r\n\r\n")
+	c := Client{
+		URL:     server.getURL("/avscan"),
+		Timeout: 5 * time.Second,
+	}
+	result, err := c.Scan(context.Background(), strings.NewReader("eicar"), "eicar.com", 5)
+	require.NoError(t, err)
+	assert.True(t, result.Infected)
+	assert.Contains(t, result.Threat, "Eicar-Signature")
+	assert.Equal(t, 200, result.StatusCode)
+	// block page without infection headers
+	server = newTestICAPServer(t, "ICAP/1.0 200 OK\r\nEncapsulated: res-hdr=0, res-body=30\r\n\r\n"+
+		"HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n0\r\n\r\n")
+	c.URL = server.getURL("/avscan")
+	err = c.Validate()
+	require.NoError(t, err)
+	result, err = c.Scan(context.Background(), strings.NewReader("eicar"), "eicar.com", 5)
+	require.NoError(t, err)
+	assert.True(t, result.Infected)
+	assert.Contains(t, result.Threat, "403")
+	// modified content with a successful HTTP status
+	server = newTestICAPServer(t, "ICAP/1.0 200 OK\r\nEncapsulated: res-hdr=0, res-body=30\r\n\r\n"+
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n0\r\n\r\n")
+	c.URL = server.getURL("/avscan")
+	err = c.Validate()
+	require.NoError(t, err)
+	result, err = c.Scan(context.Background(), strings.NewReader("data"), "file", 4)
+	require.NoError(t, err)
+	assert.False(t, result.Infected)
+}
+
+func TestScanErrors(t *testing.T) {
+	c := Client{
+		URL:     "icap://127.0.0.1:1344\x7f/avscan",
+		Timeout: time.Second,
+	}
+	_, err := c.Scan(context.Background(), strings.NewReader("data"), "file", 4)
+	assert.Error(t, err)
+
+	server := newTestICAPServer(t, "ICAP/1.0 500 Server Error\r\n\r\n")
+	c.URL = server.getURL("/avscan")
+	err = c.Validate()
+	require.NoError(t, err)
+	result, err := c.Scan(context.Background(), strings.NewReader("data"), "file", 4)
+	assert.ErrorContains(t, err, "unexpected ICAP status code 500")
+	assert.Equal(t, 500, result.StatusCode)
+
+	server = newTestICAPServer(t, "HTTP/1.1 200 OK\r\n\r\n")
+	c.URL = server.getURL("/avscan")
+	err = c.Validate()
+	require.NoError(t, err)
+	_, err = c.Scan(context.Background(), strings.NewReader("data"), "file", 4)
+	assert.ErrorIs(t, err, errInvalidStatus)
+
+	server = newTestICAPServer(t, "ICAP/1.0 200 OK\r\nEncapsulated: res-hdr=0, res-body=10\r\n\r\ninvalid\r\n")
+	c.URL = server.getURL("/avscan")
+	err = c.Validate()
+	require.NoError(t, err)
+	_, err = c.Scan(context.Background(), strings.NewReader("data"), "file", 4)
+	assert.ErrorContains(t, err, "invalid encapsulated HTTP status line")
+
+	server = newTestICAPServer(t, "")
+	c.URL = server.getURL("/avscan")
+	err = c.Validate()
+	require.NoError(t, err)
+	_, err = c.Scan(context.Background(), strings.NewReader("data"), "file", 4)
+	assert.ErrorContains(t, err, "unable to read the ICAP response")
+
+	_, err = c.Scan(context.Background(), &errReader{}, "file", 4)
+	assert.ErrorContains(t, err, "unable to read the file to scan")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	c.URL = "icap://" + listener.Addr().String() + "/avscan"
+	listener.Close()
+	err = c.Validate()
+	require.NoError(t, err)
+	_, err = c.Scan(context.Background(), strings.NewReader("data"), "file", 4)
+	assert.ErrorContains(t, err, "unable to connect to the ICAP server")
+}
+
+func TestParseStatusLine(t *testing.T) {
+	code, err := parseStatusLine("ICAP/1.0 204 No Content")
+	assert.NoError(t, err)
+	assert.Equal(t, 204, code)
+	_, err = parseStatusLine("ICAP/1.0")
+	assert.ErrorIs(t, err, errInvalidStatus)
+	_, err = parseStatusLine("ICAP/1.0 abc OK")
+	assert.ErrorIs(t, err, errInvalidStatus)
+	code, err = readEncapsulatedStatus(bufio.NewReader(strings.NewReader("")), "null-body=0")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, code)
+	_, err = readEncapsulatedStatus(bufio.NewReader(strings.NewReader("HTTP/1.1 abc\r\n")), "res-hdr=0")
+	assert.ErrorContains(t, err, "invalid encapsulated HTTP status line")
+	_, err = readEncapsulatedStatus(bufio.NewReader(strings.NewReader("")), "res-hdr=0")
+	assert.ErrorContains(t, err, "unable to read the encapsulated HTTP response")
+}
@@ -0,0 +1,300 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package jobs keeps track of the internal scheduled jobs, it allows to
+// inspect their status and to trigger or pause them
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	logSender = "jobs"
+)
+
+// Supported job statuses
+const (
+	StatusScheduled = "scheduled"
+	StatusRunning   = "running"
+	StatusPaused    = "paused"
+)
+
+// Supported results for the last job execution
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+var (
+	// ErrJobRunning is returned if a job is triggered while it is already running
+	ErrJobRunning = errors.New("the job is already running")
+	registry      = jobsRegistry{
+		jobs:   make(map[string]*job),
+		paused: make(map[string]bool),
+	}
+)
+
+// JobStatus defines the status of a scheduled job
+type JobStatus struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	Status   string `json:"status"`
+	// last execution start time as unix timestamp in milliseconds
+	LastRun int64 `json:"last_run,omitempty"`
+	// last execution duration in milliseconds
+	LastDuration int64  `json:"last_duration,omitempty"`
+	LastResult   string `json:"last_result,omitempty"`
+	LastError    string `json:"last_error,omitempty"`
+	// next scheduled execution as unix timestamp in milliseconds
+	NextRun  int64 `json:"next_run,omitempty"`
+	Runs     int64 `json:"runs"`
+	Failures int64 `json:"failures"`
+}
+
+type job struct {
+	name      string
+	spec      string
+	fn        func() error
+	scheduler *cron.Cron
+	entryID   cron.EntryID
+
+	mu           sync.Mutex
+	running      bool
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastError    error
+	runs         int64
+	failures     int64
+}
+
+func (j *job) run(isManual bool) {
+	if !isManual && registry.isPaused(j.name) {
+		logger.Debug(logSender, "", "job %q is paused, skip execution", j.name)
+		return
+	}
+	j.mu.Lock()
+	if j.running {
+		j.mu.Unlock()
+		logger.Debug(logSender, "", "job %q is already running, skip execution", j.name)
+		return
+	}
+	j.running = true
+	j.mu.Unlock()
+
+	startTime := time.Now()
+	err := j.fn()
+	elapsed := time.Since(startTime)
+	logger.Debug(logSender, "", "job %q executed, manual: %t, elapsed: %s, error: %v", j.name, isManual, elapsed, err)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.running = false
+	j.lastRun = startTime
+	j.lastDuration = elapsed
+	j.lastError = err
+	j.runs++
+	if err != nil {
+		j.failures++
+	}
+}
+
+func (j *job) getStatus(isPaused bool) JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := JobStatus{
+		Name:     j.name,
+		Schedule: j.spec,
+		Runs:     j.runs,
+		Failures: j.failures,
+	}
+	switch {
+	case j.running:
+		status.Status = StatusRunning
+	case isPaused:
+		status.Status = StatusPaused
+	default:
+		status.Status = StatusScheduled
+	}
+	if !j.lastRun.IsZero() {
+		status.LastRun = util.GetTimeAsMsSinceEpoch(j.lastRun)
+		status.LastDuration = j.lastDuration.Milliseconds()
+		if j.lastError != nil {
+			status.LastResult = ResultFailure
+			status.LastError = j.lastError.Error()
+		} else {
+			status.LastResult = ResultSuccess
+		}
+	}
+	if !isPaused {
+		if next := j.scheduler.Entry(j.entryID).Next; !next.IsZero() {
+			status.NextRun = util.GetTimeAsMsSinceEpoch(next)
+		}
+	}
+	return status
+}
+
+type jobsRegistry struct {
+	sync.RWMutex
+	jobs map[string]*job
+	// the paused state is preserved if a job is registered again,
+	// for example after a scheduler restart
+	paused map[string]bool
+}
+
+func (r *jobsRegistry) isPaused(name string) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.paused[name]
+}
+
+func (r *jobsRegistry) get(name string) (*job, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	j, ok := r.jobs[name]
+	if !ok {
+		return nil, util.NewRecordNotFoundError(fmt.Sprintf("job %q does not exist", name))
+	}
+	return j, nil
+}
+
+// Add schedules the specified function on the given scheduler and registers
+// it as job with the specified name. A job with the same name, if any, is
+// replaced, the execution statistics and the paused state are preserved
+func Add(scheduler *cron.Cron, name, spec string, fn func() error) error {
+	j := &job{
+		name:      name,
+		spec:      spec,
+		fn:        fn,
+		scheduler: scheduler,
+	}
+	entryID, err := scheduler.AddFunc(spec, func() {
+		j.run(false)
+	})
+	if err != nil {
+		return err
+	}
+	j.entryID = entryID
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if old, ok := registry.jobs[name]; ok {
+		old.mu.Lock()
+		j.lastRun = old.lastRun
+		j.lastDuration = old.lastDuration
+		j.lastError = old.lastError
+		j.runs = old.runs
+		j.failures = old.failures
+		old.mu.Unlock()
+	}
+	registry.jobs[name] = j
+	logger.Debug(logSender, "", "job %q registered, schedule %q", name, spec)
+	return nil
+}
+
+// RemoveScheduler unregisters all the jobs scheduled on the specified
+// scheduler, it must be called when a scheduler is stopped
+func RemoveScheduler(scheduler *cron.Cron) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for name, j := range registry.jobs {
+		if j.scheduler == scheduler {
+			delete(registry.jobs, name)
+		}
+	}
+}
+
+// GetJobs returns the status of the registered jobs ordered by name
+func GetJobs() []JobStatus {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	result := make([]JobStatus, 0, len(registry.jobs))
+	for name, j := range registry.jobs {
+		result = append(result, j.getStatus(registry.paused[name]))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// GetJob returns the status of the job with the specified name
+func GetJob(name string) (JobStatus, error) {
+	j, err := registry.get(name)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	return j.getStatus(registry.isPaused(name)), nil
+}
+
+// Run triggers the execution of the job with the specified name in a new
+// goroutine. Paused jobs can be triggered too
+func Run(name string) error {
+	j, err := registry.get(name)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	isRunning := j.running
+	j.mu.Unlock()
+	if isRunning {
+		return ErrJobRunning
+	}
+	logger.Info(logSender, "", "manual execution requested for job %q", name)
+	go j.run(true)
+	return nil
+}
+
+// Pause pauses the job with the specified name, scheduled executions are
+// skipped until the job is resumed
+func Pause(name string) error {
+	return setPaused(name, true)
+}
+
+// Resume resumes the job with the specified name
+func Resume(name string) error {
+	return setPaused(name, false)
+}
+
+func setPaused(name string, paused bool) error {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, ok := registry.jobs[name]; !ok {
+		return util.NewRecordNotFoundError(fmt.Sprintf("job %q does not exist", name))
+	}
+	if paused {
+		registry.paused[name] = true
+	} else {
+		delete(registry.paused, name)
+	}
+	logger.Info(logSender, "", "job %q paused: %t", name, paused)
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func TestJobs(t *testing.T) {
+	scheduler := cron.New(cron.WithLocation(time.UTC))
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	err := Add(scheduler, "invalid", "invalid spec", func() error { return nil })
+	assert.Error(t, err)
+
+	var runs atomic.Int32
+	release := make(chan bool)
+	jobErr := errors.New("job error")
+	err = Add(scheduler, "job1", "@every 1h", func() error {
+		runs.Add(1)
+		<-release
+		return jobErr
+	})
+	require.NoError(t, err)
+	err = Add(scheduler, "job2", "@every 2h", func() error { return nil })
+	require.NoError(t, err)
+
+	statuses := GetJobs()
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "job1", statuses[0].Name)
+	assert.Equal(t, "job2", statuses[1].Name)
+	assert.Equal(t, StatusScheduled, statuses[0].Status)
+	assert.Equal(t, "@every 1h", statuses[0].Schedule)
+	assert.Greater(t, statuses[0].NextRun, util.GetTimeAsMsSinceEpoch(time.Now()))
+	assert.Zero(t, statuses[0].LastRun)
+
+	_, err = GetJob("missing")
+	assert.ErrorAs(t, err, new(*util.RecordNotFoundError))
+	err = Run("missing")
+	assert.ErrorAs(t, err, new(*util.RecordNotFoundError))
+	err = Pause("missing")
+	assert.ErrorAs(t, err, new(*util.RecordNotFoundError))
+	err = Resume("missing")
+	assert.ErrorAs(t, err, new(*util.RecordNotFoundError))
+
+	err = Run("job1")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		status, err := GetJob("job1")
+		return err == nil && status.Status == StatusRunning
+	}, 1*time.Second, 50*time.Millisecond)
+	err = Run("job1")
+	assert.ErrorIs(t, err, ErrJobRunning)
+	release <- true
+	assert.Eventually(t, func() bool {
+		status, err := GetJob("job1")
+		return err == nil && status.Status == StatusScheduled
+	}, 1*time.Second, 50*time.Millisecond)
+	status, err := GetJob("job1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), status.Runs)
+	assert.Equal(t, int64(1), status.Failures)
+	assert.Equal(t, ResultFailure, status.LastResult)
+	assert.Equal(t, jobErr.Error(), status.LastError)
+	assert.Greater(t, status.LastRun, int64(0))
+
+	err = Pause("job1")
+	assert.NoError(t, err)
+	status, err = GetJob("job1")
+	require.NoError(t, err)
+	assert.Equal(t, StatusPaused, status.Status)
+	assert.Zero(t, status.NextRun)
+	// scheduled executions are skipped for paused jobs
+	j, err := registry.get("job1")
+	require.NoError(t, err)
+	j.run(false)
+	assert.Equal(t, int32(1), runs.Load())
+	// the stats and the paused state are preserved if the job is registered again
+	err = Add(scheduler, "job1", "@every 1h", func() error { return nil })
+	require.NoError(t, err)
+	status, err = GetJob("job1")
+	require.NoError(t, err)
+	assert.Equal(t, StatusPaused, status.Status)
+	assert.Equal(t, int64(1), status.Runs)
+	// manual executions are allowed for paused jobs
+	err = Run("job1")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		status, err := GetJob("job1")
+		return err == nil && status.Runs == 2
+	}, 1*time.Second, 50*time.Millisecond)
+	status, err = GetJob("job1")
+	require.NoError(t, err)
+	assert.Equal(t, ResultSuccess, status.LastResult)
+	assert.Empty(t, status.LastError)
+	assert.Equal(t, int64(1), status.Failures)
+	err = Resume("job1")
+	assert.NoError(t, err)
+	status, err = GetJob("job1")
+	require.NoError(t, err)
+	assert.Equal(t, StatusScheduled, status.Status)
+	assert.Greater(t, status.NextRun, int64(0))
+
+	RemoveScheduler(scheduler)
+	assert.Len(t, GetJobs(), 0)
+}
@@ -0,0 +1,92 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kms
+
+import (
+	"fmt"
+
+	sdkkms "github.com/sftpgo/sdk/kms"
+)
+
+// KeyRotator re-encrypts the secrets encrypted using a previous KMS
+// configuration, for example a previous master key, using the current one
+type KeyRotator struct {
+	old Configuration
+}
+
+// NewKeyRotator returns a KeyRotator for the secrets encrypted using the
+// specified configuration. The current configuration is not modified,
+// Initialize must be called before using the returned KeyRotator
+func NewKeyRotator(old Configuration) (*KeyRotator, error) {
+	if err := old.loadMasterKey(); err != nil {
+		return nil, fmt.Errorf("unable to load the previous master key: %w", err)
+	}
+	return &KeyRotator{
+		old: old,
+	}, nil
+}
+
+// Rotate decrypts the given secret using the previous configuration and
+// encrypts it again using the current one. It returns true if the secret was
+// re-encrypted. Empty and not encrypted secrets are not modified, secrets
+// that can be already decrypted using the current configuration are
+// considered as rotated and are not modified too
+func (r *KeyRotator) Rotate(s *Secret) (bool, error) {
+	if s == nil || s.IsEmpty() || !s.IsEncrypted() {
+		return false, nil
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	base := BaseSecret{
+		Status:         s.provider.GetStatus(),
+		Payload:        s.provider.GetPayload(),
+		Key:            s.provider.GetKey(),
+		AdditionalData: s.provider.GetAdditionalData(),
+		Mode:           s.provider.GetMode(),
+	}
+	plain, err := r.old.decrypt(base)
+	if err != nil {
+		if _, errCurrent := config.decrypt(base); errCurrent == nil {
+			return false, nil
+		}
+		return false, err
+	}
+	provider := config.getSecretProvider(BaseSecret{
+		Status:         sdkkms.SecretStatusPlain,
+		Payload:        plain,
+		AdditionalData: base.AdditionalData,
+	})
+	if err := provider.Encrypt(); err != nil {
+		return false, fmt.Errorf("unable to encrypt the secret: %w", err)
+	}
+	s.provider = provider
+	return true, nil
+}
+
+// decrypt returns the plain text payload for the given encrypted secret
+// using a provider for the configuration
+func (c *Configuration) decrypt(base BaseSecret) (string, error) {
+	for _, v := range secretProviders {
+		if v.encryptedStatus == base.Status {
+			provider := v.newFn(base, c.Secrets.URL, c.Secrets.masterKey)
+			if err := provider.Decrypt(); err != nil {
+				return "", err
+			}
+			return provider.GetPayload(), nil
+		}
+	}
+	return "", ErrInvalidSecret
+}
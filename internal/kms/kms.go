@@ -105,6 +105,18 @@ func NewPlainSecret(payload string) *Secret {
 
 // Initialize configures the KMS support
 func (c *Configuration) Initialize() error {
+	if err := c.loadMasterKey(); err != nil {
+		return err
+	}
+	config = *c
+	for k, v := range secretProviders {
+		logger.Info(logSender, "", "secret provider registered for scheme: %#v, encrypted status: %#v",
+			k, v.encryptedStatus)
+	}
+	return nil
+}
+
+func (c *Configuration) loadMasterKey() error {
 	if c.Secrets.MasterKeyString != "" {
 		c.Secrets.masterKey = c.Secrets.MasterKeyString
 	}
@@ -115,13 +127,8 @@ func (c *Configuration) Initialize() error {
 		}
 		c.Secrets.masterKey = strings.TrimSpace(string(mKey))
 	}
-	config = *c
-	if config.Secrets.URL == "" {
-		config.Secrets.URL = sdkkms.SchemeLocal + "://"
-	}
-	for k, v := range secretProviders {
-		logger.Info(logSender, "", "secret provider registered for scheme: %#v, encrypted status: %#v",
-			k, v.encryptedStatus)
+	if c.Secrets.URL == "" {
+		c.Secrets.URL = sdkkms.SchemeLocal + "://"
 	}
 	return nil
 }
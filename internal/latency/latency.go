@@ -0,0 +1,126 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package latency records the latency of backend operations and computes
+// percentiles over a sliding time window
+package latency
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// BackendS3Put identifies the S3 PUT requests, for example object and multipart uploads
+	BackendS3Put = "s3_put"
+	// BackendDataProvider identifies the data provider queries
+	BackendDataProvider = "data_provider"
+	maxSamples          = 1024
+)
+
+var (
+	// SupportedBackends defines the monitored backends
+	SupportedBackends = []string{BackendS3Put, BackendDataProvider}
+	trackers          = map[string]*tracker{
+		BackendS3Put:        {},
+		BackendDataProvider: {},
+	}
+)
+
+type sample struct {
+	recordedAt int64
+	value      time.Duration
+}
+
+// tracker keeps the most recent samples in a fixed size ring buffer
+type tracker struct {
+	mu      sync.Mutex
+	samples [maxSamples]sample
+	next    int
+	count   int
+}
+
+func (t *tracker) add(value time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.samples[t.next] = sample{
+		recordedAt: time.Now().UnixNano(),
+		value:      value,
+	}
+	t.next = (t.next + 1) % maxSamples
+	if t.count < maxSamples {
+		t.count++
+	}
+}
+
+func (t *tracker) getPercentile(percentile float64, window time.Duration) (time.Duration, int) {
+	limit := time.Now().Add(-window).UnixNano()
+
+	t.mu.Lock()
+	values := make([]time.Duration, 0, t.count)
+	for idx := 0; idx < t.count; idx++ {
+		if t.samples[idx].recordedAt >= limit {
+			values = append(values, t.samples[idx].value)
+		}
+	}
+	t.mu.Unlock()
+
+	if len(values) == 0 {
+		return 0, 0
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i] < values[j]
+	})
+	idx := int(math.Ceil(percentile/100*float64(len(values)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return values[idx], len(values)
+}
+
+func (t *tracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.next = 0
+	t.count = 0
+}
+
+// Record adds a latency sample for the specified backend.
+// Unsupported backends are silently ignored
+func Record(backend string, value time.Duration) {
+	if t, ok := trackers[backend]; ok {
+		t.add(value)
+	}
+}
+
+// GetP95 returns the 95th percentile latency for the specified backend
+// considering the samples recorded within the given window and the number
+// of samples used to compute it
+func GetP95(backend string, window time.Duration) (time.Duration, int) {
+	if t, ok := trackers[backend]; ok {
+		return t.getPercentile(95, window)
+	}
+	return 0, 0
+}
+
+// Reset removes the recorded samples for all the backends
+func Reset() {
+	for _, t := range trackers {
+		t.reset()
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package latency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPercentile(t *testing.T) {
+	Reset()
+
+	p95, samples := GetP95(BackendS3Put, time.Minute)
+	assert.Equal(t, time.Duration(0), p95)
+	assert.Equal(t, 0, samples)
+	for i := 1; i <= 100; i++ {
+		Record(BackendS3Put, time.Duration(i)*time.Millisecond)
+	}
+	Record("unsupported", time.Second)
+	p95, samples = GetP95(BackendS3Put, time.Minute)
+	assert.Equal(t, 95*time.Millisecond, p95)
+	assert.Equal(t, 100, samples)
+	p95, samples = GetP95(BackendDataProvider, time.Minute)
+	assert.Equal(t, time.Duration(0), p95)
+	assert.Equal(t, 0, samples)
+	p95, samples = GetP95("unsupported", time.Minute)
+	assert.Equal(t, time.Duration(0), p95)
+	assert.Equal(t, 0, samples)
+	// samples outside the window are ignored
+	time.Sleep(20 * time.Millisecond)
+	Record(BackendS3Put, time.Second)
+	p95, samples = GetP95(BackendS3Put, 10*time.Millisecond)
+	assert.Equal(t, time.Second, p95)
+	assert.Equal(t, 1, samples)
+
+	Reset()
+	_, samples = GetP95(BackendS3Put, time.Minute)
+	assert.Equal(t, 0, samples)
+}
+
+func TestRingBuffer(t *testing.T) {
+	Reset()
+
+	for i := 0; i < maxSamples; i++ {
+		Record(BackendDataProvider, time.Second)
+	}
+	p95, samples := GetP95(BackendDataProvider, time.Minute)
+	assert.Equal(t, time.Second, p95)
+	assert.Equal(t, maxSamples, samples)
+	// the oldest samples are overwritten
+	for i := 0; i < maxSamples; i++ {
+		Record(BackendDataProvider, time.Millisecond)
+	}
+	p95, samples = GetP95(BackendDataProvider, time.Minute)
+	assert.Equal(t, time.Millisecond, p95)
+	assert.Equal(t, maxSamples, samples)
+
+	Reset()
+}
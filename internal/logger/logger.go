@@ -28,6 +28,8 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	ftpserverlog "github.com/fclairamb/go-log"
@@ -202,7 +204,7 @@ func ErrorToConsole(format string, v ...any) {
 
 // TransferLog logs uploads or downloads
 func TransferLog(operation, path string, elapsed int64, size int64, user, connectionID, protocol, localAddr,
-	remoteAddr, ftpMode string,
+	remoteAddr, ftpMode, impersonator string,
 ) {
 	ev := logger.Info().
 		Timestamp().
@@ -218,13 +220,26 @@ func TransferLog(operation, path string, elapsed int64, size int64, user, connec
 	if ftpMode != "" {
 		ev.Str("ftp_mode", ftpMode)
 	}
+	if impersonator != "" {
+		ev.Str("impersonated_by", impersonator)
+	}
 	ev.Send()
+	sendSyslogEvent(strings.ToLower(operation),
+		syslogField{name: syslogFieldIP, value: getIPFromAddress(remoteAddr)},
+		syslogField{name: syslogFieldUsername, value: user},
+		syslogField{name: syslogFieldProtocol, value: protocol},
+		syslogField{name: syslogFieldConnectionID, value: connectionID},
+		syslogField{name: syslogFieldFilePath, value: path},
+		syslogField{name: syslogFieldSize, value: strconv.FormatInt(size, 10)},
+		syslogField{name: syslogFieldElapsed, value: strconv.FormatInt(elapsed, 10)},
+		syslogField{name: syslogFieldImpersonator, value: impersonator},
+	)
 }
 
 // CommandLog logs an SFTP/SCP/SSH command
 func CommandLog(command, path, target, user, fileMode, connectionID, protocol string, uid, gid int, atime, mtime,
-	sshCommand string, size int64, localAddr, remoteAddr string) {
-	logger.Info().
+	sshCommand string, size int64, localAddr, remoteAddr, impersonator string) {
+	ev := logger.Info().
 		Timestamp().
 		Str("sender", command).
 		Str("local_addr", localAddr).
@@ -240,24 +255,39 @@ func CommandLog(command, path, target, user, fileMode, connectionID, protocol st
 		Int64("size", size).
 		Str("ssh_command", sshCommand).
 		Str("connection_id", connectionID).
-		Str("protocol", protocol).
-		Send()
+		Str("protocol", protocol)
+	if impersonator != "" {
+		ev.Str("impersonated_by", impersonator)
+	}
+	ev.Send()
 }
 
 // ConnectionFailedLog logs failed attempts to initialize a connection.
 // A connection can fail for an authentication error or other errors such as
 // a client abort or a time out if the login does not happen in two minutes.
 // These logs are useful for better integration with Fail2ban and similar tools.
-func ConnectionFailedLog(user, ip, loginType, protocol, errorString string) {
-	logger.Debug().
+// The country is the ISO code resolved using the GeoIP database, if any.
+func ConnectionFailedLog(user, ip, country, loginType, protocol, errorString string) {
+	ev := logger.Debug().
 		Timestamp().
 		Str("sender", "connection_failed").
-		Str("client_ip", ip).
-		Str("username", user).
+		Str("client_ip", ip)
+	if country != "" {
+		ev.Str("country", country)
+	}
+	ev.Str("username", user).
 		Str("login_type", loginType).
 		Str("protocol", protocol).
 		Str("error", errorString).
 		Send()
+	sendSyslogEvent("connection_failed",
+		syslogField{name: syslogFieldIP, value: ip},
+		syslogField{name: syslogFieldCountry, value: country},
+		syslogField{name: syslogFieldUsername, value: user},
+		syslogField{name: syslogFieldProtocol, value: protocol},
+		syslogField{name: syslogFieldLoginMethod, value: loginType},
+		syslogField{name: syslogFieldError, value: errorString},
+	)
 }
 
 func isLogFilePathValid(logFilePath string) bool {
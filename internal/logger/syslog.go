@@ -0,0 +1,567 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package logger
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	syslogSender          = "syslog"
+	syslogNetworkUDP      = "udp"
+	syslogNetworkTCP      = "tcp"
+	syslogNetworkTLS      = "tls"
+	syslogFormatCEF       = "cef"
+	syslogFormatLEEF      = "leef"
+	defaultSyslogFacility = "local0"
+	syslogAppName         = "sftpgo"
+	syslogVendor          = "SFTPGo"
+	syslogQueueSize       = 4096
+	syslogTimeout         = 10 * time.Second
+	// syslog severities
+	syslogSeverityWarning = 4
+	syslogSeverityInfo    = 6
+)
+
+// Syslog event categories
+const (
+	SyslogEventConnection     = "connection"
+	SyslogEventAuthentication = "authentication"
+	SyslogEventDefender       = "defender"
+	SyslogEventTransfer       = "transfer"
+)
+
+// event fields, the keys used in the CEF and LEEF messages can be customized
+const (
+	syslogFieldCategory      = "category"
+	syslogFieldTimestamp     = "timestamp"
+	syslogFieldIP            = "ip"
+	syslogFieldCountry       = "country"
+	syslogFieldUsername      = "username"
+	syslogFieldProtocol      = "protocol"
+	syslogFieldConnectionID  = "connection_id"
+	syslogFieldLoginMethod   = "login_method"
+	syslogFieldError         = "error"
+	syslogFieldFilePath      = "file_path"
+	syslogFieldSize          = "size"
+	syslogFieldElapsed       = "elapsed_ms"
+	syslogFieldDefenderEvent = "defender_event"
+	syslogFieldBanTime       = "ban_time"
+	syslogFieldImpersonator  = "impersonator"
+)
+
+var (
+	syslogSink       atomic.Pointer[syslogWriter]
+	syslogCategories = []string{SyslogEventConnection, SyslogEventAuthentication, SyslogEventDefender,
+		SyslogEventTransfer}
+	syslogFacilities = map[string]int{
+		"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+		"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "local0": 16, "local1": 17, "local2": 18,
+		"local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+	}
+	syslogKeyRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+	// default keys for the CEF format. The csN and cnN custom fields are
+	// automatically labeled with the field name
+	cefKeys = map[string]string{
+		syslogFieldCategory:      "cat",
+		syslogFieldTimestamp:     "rt",
+		syslogFieldIP:            "src",
+		syslogFieldCountry:       "cs2",
+		syslogFieldUsername:      "suser",
+		syslogFieldProtocol:      "app",
+		syslogFieldConnectionID:  "externalId",
+		syslogFieldLoginMethod:   "cs1",
+		syslogFieldError:         "reason",
+		syslogFieldFilePath:      "filePath",
+		syslogFieldSize:          "fsize",
+		syslogFieldElapsed:       "cn1",
+		syslogFieldDefenderEvent: "cs3",
+		syslogFieldBanTime:       "end",
+		syslogFieldImpersonator:  "cs4",
+	}
+	// default keys for the LEEF format
+	leefKeys = map[string]string{
+		syslogFieldCategory:      "cat",
+		syslogFieldTimestamp:     "devTime",
+		syslogFieldIP:            "src",
+		syslogFieldCountry:       "srcCountry",
+		syslogFieldUsername:      "usrName",
+		syslogFieldProtocol:      "protocol",
+		syslogFieldConnectionID:  "sessionId",
+		syslogFieldLoginMethod:   "loginMethod",
+		syslogFieldError:         "reason",
+		syslogFieldFilePath:      "filePath",
+		syslogFieldSize:          "fileSize",
+		syslogFieldElapsed:       "elapsedMs",
+		syslogFieldDefenderEvent: "defenderEvent",
+		syslogFieldBanTime:       "banTime",
+		syslogFieldImpersonator:  "impersonator",
+	}
+)
+
+type syslogEventType struct {
+	category string
+	name     string
+	// severity from 0 to 10, as defined for CEF
+	severity int
+}
+
+var syslogEventTypes = map[string]syslogEventType{
+	"connection_open":    {category: SyslogEventConnection, name: "Connection opened", severity: 3},
+	"connection_close":   {category: SyslogEventConnection, name: "Connection closed", severity: 3},
+	"connection_failed":  {category: SyslogEventConnection, name: "Connection failed", severity: 6},
+	"login_ok":           {category: SyslogEventAuthentication, name: "Login succeeded", severity: 3},
+	"login_failed":       {category: SyslogEventAuthentication, name: "Login failed", severity: 6},
+	"admin_login_ok":     {category: SyslogEventAuthentication, name: "Admin login succeeded", severity: 3},
+	"admin_login_failed": {category: SyslogEventAuthentication, name: "Admin login failed", severity: 7},
+	"defender_event":     {category: SyslogEventDefender, name: "Defender event", severity: 5},
+	"host_banned":        {category: SyslogEventDefender, name: "Host banned", severity: 8},
+	"upload":             {category: SyslogEventTransfer, name: "Upload", severity: 2},
+	"download":           {category: SyslogEventTransfer, name: "Download", severity: 2},
+}
+
+// SyslogConfig defines the configuration to send connection, authentication,
+// defender and transfer events to a syslog server using the CEF or LEEF format.
+// These events are sent in addition to the standard logs
+type SyslogConfig struct {
+	// Syslog server address as "host:port". Leave empty to disable
+	Address string `json:"address" mapstructure:"address"`
+	// Network to use: "udp", "tcp" or "tls". Default: "udp"
+	Network string `json:"network" mapstructure:"network"`
+	// Message format: "cef" or "leef". Default: "cef"
+	Format string `json:"format" mapstructure:"format"`
+	// Syslog facility, for example "auth" or "local0". Default: "local0"
+	Facility string `json:"facility" mapstructure:"facility"`
+	// Event categories to send: "connection", "authentication", "defender",
+	// "transfer". Empty means all
+	Events []string `json:"events" mapstructure:"events"`
+	// Custom keys for the event fields, each mapping must be in the form
+	// "field=key". An empty key omits the field
+	FieldMapping []string `json:"field_mapping" mapstructure:"field_mapping"`
+	// Set to true to skip the TLS certificate validation for the "tls" network
+	SkipTLSVerify bool `json:"skip_tls_verify" mapstructure:"skip_tls_verify"`
+	facility      int
+	keys          map[string]string
+}
+
+// IsEnabled returns true if the syslog output is enabled
+func (c *SyslogConfig) IsEnabled() bool {
+	return c.Address != ""
+}
+
+func (c *SyslogConfig) validate() error {
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf("invalid syslog address %q: %w", c.Address, err)
+	}
+	if c.Network == "" {
+		c.Network = syslogNetworkUDP
+	}
+	if c.Network != syslogNetworkUDP && c.Network != syslogNetworkTCP && c.Network != syslogNetworkTLS {
+		return fmt.Errorf("invalid syslog network %q, supported values: udp, tcp, tls", c.Network)
+	}
+	if c.Format == "" {
+		c.Format = syslogFormatCEF
+	}
+	if c.Facility == "" {
+		c.Facility = defaultSyslogFacility
+	}
+	facility, ok := syslogFacilities[c.Facility]
+	if !ok {
+		return fmt.Errorf("invalid syslog facility %q", c.Facility)
+	}
+	c.facility = facility
+	for _, event := range c.Events {
+		if !contains(syslogCategories, event) {
+			return fmt.Errorf("invalid syslog event %q, supported events: %s", event,
+				strings.Join(syslogCategories, ","))
+		}
+	}
+	return c.parseFieldMapping()
+}
+
+func (c *SyslogConfig) parseFieldMapping() error {
+	var defaultKeys map[string]string
+	switch c.Format {
+	case syslogFormatCEF:
+		defaultKeys = cefKeys
+	case syslogFormatLEEF:
+		defaultKeys = leefKeys
+	default:
+		return fmt.Errorf("invalid syslog format %q, supported values: cef, leef", c.Format)
+	}
+	c.keys = make(map[string]string)
+	for field, key := range defaultKeys {
+		c.keys[field] = key
+	}
+	for _, mapping := range c.FieldMapping {
+		field, key, ok := strings.Cut(mapping, "=")
+		field = strings.TrimSpace(field)
+		key = strings.TrimSpace(key)
+		if !ok {
+			return fmt.Errorf("invalid syslog field mapping %q, the expected format is \"field=key\"", mapping)
+		}
+		if _, ok := defaultKeys[field]; !ok {
+			return fmt.Errorf("invalid syslog field mapping %q, unknown field %q", mapping, field)
+		}
+		if key != "" && !syslogKeyRegex.MatchString(key) {
+			return fmt.Errorf("invalid syslog field mapping %q, invalid key %q", mapping, key)
+		}
+		c.keys[field] = key
+	}
+	return nil
+}
+
+// Initialize validates the configuration and, if enabled, starts sending
+// the events to the syslog server. Any previous syslog output is stopped
+func (c *SyslogConfig) Initialize() error {
+	if !c.IsEnabled() {
+		CloseSyslog()
+		return nil
+	}
+	conf := *c
+	if err := conf.validate(); err != nil {
+		return err
+	}
+	w := newSyslogWriter(conf)
+	if prev := syslogSink.Swap(w); prev != nil {
+		prev.stop()
+	}
+	Info(syslogSender, "", "syslog output enabled, address %q, network %q, format %q, facility %q, events %v",
+		conf.Address, conf.Network, conf.Format, conf.Facility, conf.Events)
+	return nil
+}
+
+// CloseSyslog sends the pending events and stops the syslog output
+func CloseSyslog() {
+	if w := syslogSink.Swap(nil); w != nil {
+		w.stop()
+	}
+}
+
+type syslogField struct {
+	name  string
+	value string
+}
+
+type syslogWriter struct {
+	config   SyslogConfig
+	hostname string
+	queue    chan []byte
+	dropped  atomic.Int64
+	done     chan bool
+	wg       sync.WaitGroup
+	conn     net.Conn
+	failing  bool
+}
+
+func newSyslogWriter(config SyslogConfig) *syslogWriter {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	w := &syslogWriter{
+		config:   config,
+		hostname: hostname,
+		queue:    make(chan []byte, syslogQueueSize),
+		done:     make(chan bool),
+	}
+	w.wg.Add(1)
+	go w.loop()
+	return w
+}
+
+func (w *syslogWriter) isEventEnabled(category string) bool {
+	return len(w.config.Events) == 0 || contains(w.config.Events, category)
+}
+
+func (w *syslogWriter) add(msg []byte) {
+	select {
+	case w.queue <- msg:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+func (w *syslogWriter) loop() {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case <-w.done:
+			for {
+				select {
+				case msg := <-w.queue:
+					w.write(msg)
+				default:
+					w.closeConn()
+					return
+				}
+			}
+		case msg := <-w.queue:
+			w.write(msg)
+		}
+	}
+}
+
+func (w *syslogWriter) stop() {
+	close(w.done)
+	w.wg.Wait()
+}
+
+func (w *syslogWriter) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+	if w.config.Network == syslogNetworkTLS {
+		host, _, _ := net.SplitHostPort(w.config.Address)
+		return tls.DialWithDialer(dialer, "tcp", w.config.Address, &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: w.config.SkipTLSVerify, //nolint:gosec
+			MinVersion:         tls.VersionTLS12,
+		})
+	}
+	return dialer.Dial(w.config.Network, w.config.Address)
+}
+
+func (w *syslogWriter) closeConn() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+// write sends a message, stream connections are reopened once on error
+func (w *syslogWriter) write(msg []byte) {
+	if dropped := w.dropped.Swap(0); dropped > 0 {
+		Warn(syslogSender, "", "%d syslog events dropped, the queue is full", dropped)
+	}
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			w.conn, err = w.dial()
+			if err != nil {
+				w.conn = nil
+				continue
+			}
+		}
+		w.conn.SetWriteDeadline(time.Now().Add(syslogTimeout)) //nolint:errcheck
+		if _, err = w.conn.Write(msg); err == nil {
+			if w.failing {
+				Info(syslogSender, "", "syslog output to %q restored", w.config.Address)
+				w.failing = false
+			}
+			return
+		}
+		w.closeConn()
+	}
+	// log only the first error to avoid flooding the logs if the server is down
+	if !w.failing {
+		Warn(syslogSender, "", "unable to send events to the syslog server %q: %v", w.config.Address, err)
+		w.failing = true
+	}
+}
+
+// format returns the syslog message, RFC 5424, for the specified event.
+// Messages sent over stream connections are terminated by a new line
+func (w *syslogWriter) format(eventID string, event syslogEventType, now time.Time, fields []syslogField) []byte {
+	severity := syslogSeverityInfo
+	if event.severity >= 6 {
+		severity = syslogSeverityWarning
+	}
+	fields = append([]syslogField{
+		{name: syslogFieldCategory, value: event.category},
+		{name: syslogFieldTimestamp, value: strconv.FormatInt(now.UnixMilli(), 10)},
+	}, fields...)
+	var payload string
+	if w.config.Format == syslogFormatLEEF {
+		payload = w.formatLEEF(eventID, event, fields)
+	} else {
+		payload = w.formatCEF(eventID, event, fields)
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s %s - %s - %s", w.config.facility*8+severity,
+		now.Format("2006-01-02T15:04:05.000Z07:00"), w.hostname, syslogAppName, eventID, payload)
+	if w.config.Network != syslogNetworkUDP {
+		msg += "\n"
+	}
+	return []byte(msg)
+}
+
+func (w *syslogWriter) formatCEF(eventID string, event syslogEventType, fields []syslogField) string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|", syslogVendor, syslogVendor,
+		escapeCEFHeader(version.Get().Version), escapeCEFHeader(eventID), escapeCEFHeader(event.name), event.severity))
+	first := true
+	for _, field := range fields {
+		key := w.config.keys[field.name]
+		if key == "" || field.value == "" {
+			continue
+		}
+		if !first {
+			sb.WriteString(" ")
+		}
+		first = false
+		sb.WriteString(key)
+		sb.WriteString("=")
+		sb.WriteString(escapeCEFValue(field.value))
+		if isCEFCustomKey(key) {
+			sb.WriteString(" ")
+			sb.WriteString(key)
+			sb.WriteString("Label=")
+			sb.WriteString(escapeCEFValue(field.name))
+		}
+	}
+	return sb.String()
+}
+
+func (w *syslogWriter) formatLEEF(eventID string, event syslogEventType, fields []syslogField) string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("LEEF:1.0|%s|%s|%s|%s|sev=%d", syslogVendor, syslogVendor,
+		escapeLEEFHeader(version.Get().Version), escapeLEEFHeader(eventID), event.severity))
+	for _, field := range fields {
+		key := w.config.keys[field.name]
+		if key == "" || field.value == "" {
+			continue
+		}
+		sb.WriteString("\t")
+		sb.WriteString(key)
+		sb.WriteString("=")
+		sb.WriteString(escapeLEEFValue(field.value))
+	}
+	return sb.String()
+}
+
+func isCEFCustomKey(key string) bool {
+	if len(key) != 3 || (!strings.HasPrefix(key, "cs") && !strings.HasPrefix(key, "cn")) {
+		return false
+	}
+	return key[2] >= '1' && key[2] <= '6'
+}
+
+var (
+	cefHeaderReplacer  = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefValueReplacer   = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+	leefHeaderReplacer = strings.NewReplacer(`|`, ` `, "\t", " ", "\n", " ", "\r", " ")
+	leefValueReplacer  = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+)
+
+func escapeCEFHeader(value string) string {
+	return cefHeaderReplacer.Replace(value)
+}
+
+func escapeCEFValue(value string) string {
+	return cefValueReplacer.Replace(value)
+}
+
+func escapeLEEFHeader(value string) string {
+	return leefHeaderReplacer.Replace(value)
+}
+
+func escapeLEEFValue(value string) string {
+	return leefValueReplacer.Replace(value)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sendSyslogEvent(eventID string, fields ...syslogField) {
+	w := syslogSink.Load()
+	if w == nil {
+		return
+	}
+	event, ok := syslogEventTypes[eventID]
+	if !ok || !w.isEventEnabled(event.category) {
+		return
+	}
+	w.add(w.format(eventID, event, time.Now(), fields))
+}
+
+func getIPFromAddress(address string) string {
+	ip, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return ip
+}
+
+// SyslogConnectionEvent sends a connection opened or closed event to the
+// syslog server, if enabled
+func SyslogConnectionEvent(opened bool, connectionID, username, remoteAddr, protocol string) {
+	eventID := "connection_close"
+	if opened {
+		eventID = "connection_open"
+	}
+	sendSyslogEvent(eventID,
+		syslogField{name: syslogFieldIP, value: getIPFromAddress(remoteAddr)},
+		syslogField{name: syslogFieldUsername, value: username},
+		syslogField{name: syslogFieldProtocol, value: protocol},
+		syslogField{name: syslogFieldConnectionID, value: connectionID},
+	)
+}
+
+// SyslogLoginEvent sends a login event to the syslog server, if enabled.
+// A nil error means a successful login
+func SyslogLoginEvent(isAdmin bool, username, ip, protocol, loginMethod string, err error) {
+	eventID := "login_"
+	if isAdmin {
+		eventID = "admin_login_"
+	}
+	var errorString string
+	if err == nil {
+		eventID += "ok"
+	} else {
+		eventID += "failed"
+		errorString = err.Error()
+	}
+	sendSyslogEvent(eventID,
+		syslogField{name: syslogFieldIP, value: ip},
+		syslogField{name: syslogFieldUsername, value: username},
+		syslogField{name: syslogFieldProtocol, value: protocol},
+		syslogField{name: syslogFieldLoginMethod, value: loginMethod},
+		syslogField{name: syslogFieldError, value: errorString},
+	)
+}
+
+// SyslogDefenderEvent sends a defender event to the syslog server, if enabled.
+// A zero banTime means the host is not banned
+func SyslogDefenderEvent(ip, event string, banTime time.Time) {
+	eventID := "defender_event"
+	var banTimeValue string
+	if !banTime.IsZero() {
+		eventID = "host_banned"
+		banTimeValue = strconv.FormatInt(banTime.UnixMilli(), 10)
+	}
+	sendSyslogEvent(eventID,
+		syslogField{name: syslogFieldIP, value: ip},
+		syslogField{name: syslogFieldDefenderEvent, value: event},
+		syslogField{name: syslogFieldBanTime, value: banTimeValue},
+	)
+}
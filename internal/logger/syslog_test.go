@@ -0,0 +1,229 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package logger
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyslogConfigValidation(t *testing.T) {
+	c := SyslogConfig{}
+	assert.False(t, c.IsEnabled())
+	require.NoError(t, c.Initialize())
+	assert.Nil(t, syslogSink.Load())
+
+	c.Address = "127.0.0.1"
+	assert.Error(t, c.Initialize())
+	c.Address = "127.0.0.1:514"
+	c.Network = "unix"
+	assert.Error(t, c.Initialize())
+	c.Network = ""
+	c.Format = "json"
+	assert.Error(t, c.Initialize())
+	c.Format = ""
+	c.Facility = "local8"
+	assert.Error(t, c.Initialize())
+	c.Facility = ""
+	c.Events = []string{SyslogEventTransfer, "invalid"}
+	assert.Error(t, c.Initialize())
+	c.Events = nil
+	c.FieldMapping = []string{"username"}
+	assert.Error(t, c.Initialize())
+	c.FieldMapping = []string{"unknown=key"}
+	assert.Error(t, c.Initialize())
+	c.FieldMapping = []string{"username=invalid key"}
+	assert.Error(t, c.Initialize())
+	assert.Nil(t, syslogSink.Load())
+	c.FieldMapping = []string{" username = duser ", "country="}
+	err := c.validate()
+	require.NoError(t, err)
+	assert.Equal(t, syslogNetworkUDP, c.Network)
+	assert.Equal(t, syslogFormatCEF, c.Format)
+	assert.Equal(t, 16, c.facility)
+	assert.Equal(t, "duser", c.keys[syslogFieldUsername])
+	assert.Empty(t, c.keys[syslogFieldCountry])
+	assert.Equal(t, "src", c.keys[syslogFieldIP])
+}
+
+func TestSyslogFormat(t *testing.T) {
+	c := SyslogConfig{
+		Address:      "127.0.0.1:514",
+		Facility:     "auth",
+		FieldMapping: []string{"country="},
+	}
+	require.NoError(t, c.validate())
+	w := &syslogWriter{
+		config:   c,
+		hostname: "host",
+	}
+	now := time.Date(2022, 10, 15, 10, 20, 30, 0, time.UTC)
+	msg := string(w.format("login_failed", syslogEventTypes["login_failed"], now, []syslogField{
+		{name: syslogFieldIP, value: "192.168.1.1"},
+		{name: syslogFieldCountry, value: "IT"},
+		{name: syslogFieldUsername, value: "user=a\\b"},
+		{name: syslogFieldLoginMethod, value: "password"},
+		{name: syslogFieldError, value: "invalid\ncredentials"},
+		{name: syslogFieldFilePath, value: ""},
+	}))
+	// auth facility, warning severity
+	assert.True(t, strings.HasPrefix(msg, "<36>1 2022-10-15T10:20:30.000Z host sftpgo - login_failed - CEF:0|SFTPGo|SFTPGo|"), msg)
+	assert.Contains(t, msg, "|login_failed|Login failed|6|cat=authentication rt=1665829230000 src=192.168.1.1 ")
+	assert.Contains(t, msg, ` suser=user\=a\\b cs1=password cs1Label=login_method reason=invalid\ncredentials`)
+	assert.NotContains(t, msg, "IT")
+	assert.NotContains(t, msg, "filePath")
+	assert.False(t, strings.HasSuffix(msg, "\n"))
+
+	c.Format = syslogFormatLEEF
+	c.Network = syslogNetworkTCP
+	c.FieldMapping = nil
+	require.NoError(t, c.validate())
+	w.config = c
+	msg = string(w.format("upload", syslogEventTypes["upload"], now, []syslogField{
+		{name: syslogFieldUsername, value: "user"},
+		{name: syslogFieldFilePath, value: "/tmp/a\tb"},
+		{name: syslogFieldSize, value: "100"},
+	}))
+	// auth facility, info severity
+	assert.True(t, strings.HasPrefix(msg, "<38>1 2022-10-15T10:20:30.000Z host sftpgo - upload - LEEF:1.0|SFTPGo|SFTPGo|"), msg)
+	assert.Contains(t, msg, "|upload|sev=2\tcat=transfer\tdevTime=1665829230000\tusrName=user\tfilePath=/tmp/a b\tfileSize=100\n")
+
+	assert.True(t, isCEFCustomKey("cs1"))
+	assert.True(t, isCEFCustomKey("cn3"))
+	assert.False(t, isCEFCustomKey("cs7"))
+	assert.False(t, isCEFCustomKey("src"))
+	assert.Equal(t, `a\|b\\c`, escapeCEFHeader(`a|b\c`))
+	assert.Equal(t, "a b", escapeLEEFHeader("a|b"))
+}
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	c := SyslogConfig{
+		Address: conn.LocalAddr().String(),
+		Events:  []string{SyslogEventAuthentication, SyslogEventDefender},
+	}
+	require.NoError(t, c.Initialize())
+	// the transfer events are not enabled
+	TransferLog("Upload", "/tmp/file", 10, 100, "user", "conn_id", "SFTP", "127.0.0.1:2022", "127.0.0.1:12345", "", "")
+	SyslogLoginEvent(false, "user", "127.0.0.1", "SSH", "password", errors.New("invalid credentials"))
+	SyslogDefenderEvent("127.0.0.1", "login_failed", time.Now().Add(time.Hour))
+	CloseSyslog()
+
+	var messages []string
+	buf := make([]byte, 4096)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		messages = append(messages, string(buf[:n]))
+	}
+	assert.Contains(t, messages[0], "|login_failed|Login failed|6|")
+	assert.Contains(t, messages[0], "reason=invalid credentials")
+	assert.Contains(t, messages[1], "|host_banned|Host banned|8|")
+	assert.Contains(t, messages[1], "cs3=login_failed cs3Label=defender_event end=")
+}
+
+func TestSyslogTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	c := SyslogConfig{
+		Address: listener.Addr().String(),
+		Network: syslogNetworkTCP,
+		Format:  syslogFormatLEEF,
+	}
+	require.NoError(t, c.Initialize())
+	SyslogConnectionEvent(true, "conn_id", "", "127.0.0.1:12345", "FTP")
+	ConnectionFailedLog("user", "127.0.0.1", "", "password", "FTP", "authentication failed")
+	TransferLog("Download", "/tmp/file", 10, 100, "user", "conn_id", "FTP", "127.0.0.1:21", "127.0.0.1:12345", "passive", "")
+	SyslogConnectionEvent(false, "conn_id", "user", "127.0.0.1:12345", "FTP")
+	CloseSyslog()
+
+	var received []string
+	for i := 0; i < 4; i++ {
+		select {
+		case line := <-lines:
+			received = append(received, line)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "syslog message not received")
+		}
+	}
+	assert.Contains(t, received[0], "|connection_open|sev=3\tcat=connection\t")
+	assert.NotContains(t, received[0], "usrName")
+	assert.Contains(t, received[1], "|connection_failed|sev=6\t")
+	assert.Contains(t, received[1], "\treason=authentication failed")
+	assert.Contains(t, received[2], "|download|sev=2\t")
+	assert.Contains(t, received[2], "\tsrc=127.0.0.1\tusrName=user\tprotocol=FTP\tsessionId=conn_id\tfilePath=/tmp/file\tfileSize=100\telapsedMs=10")
+	assert.Contains(t, received[3], "|connection_close|sev=3\t")
+	assert.Contains(t, received[3], "\tusrName=user")
+}
+
+func TestSyslogServerDown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	c := SyslogConfig{
+		Address: address,
+		Network: syslogNetworkTCP,
+	}
+	require.NoError(t, c.validate())
+	// the writer loop is not started
+	w := &syslogWriter{
+		config: c,
+		queue:  make(chan []byte, syslogQueueSize),
+	}
+	w.write([]byte("test\n"))
+	assert.True(t, w.failing)
+	assert.Nil(t, w.conn)
+	for i := 0; i < syslogQueueSize+10; i++ {
+		w.add([]byte("msg\n"))
+	}
+	assert.Equal(t, int64(10), w.dropped.Load())
+	w.write(<-w.queue)
+	assert.Equal(t, int64(0), w.dropped.Load())
+
+	require.NoError(t, c.Initialize())
+	require.NotNil(t, syslogSink.Load())
+	SyslogLoginEvent(true, "admin", "127.0.0.1", "HTTP", "password", nil)
+	CloseSyslog()
+	assert.Nil(t, syslogSink.Load())
+}
@@ -19,10 +19,14 @@
 package metric
 
 import (
+	"net/http"
+	"time"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
 
 	"github.com/drakkan/sftpgo/v2/internal/version"
 )
@@ -35,6 +39,7 @@ const (
 	loginMethodTLSCertificate       = "TLSCertificate"
 	loginMethodTLSCertificateAndPwd = "TLSCertificate+password"
 	loginMethodIDP                  = "IDP"
+	loginMethodGSSAPI               = "gssapi-with-mic"
 )
 
 func init() {
@@ -295,6 +300,27 @@ var (
 		Help: "The total number of failed logins using  Identity Providers",
 	})
 
+	// totalGSSAPILoginAttempts is the metric that reports the total number of
+	// login attempts using Kerberos
+	totalGSSAPILoginAttempts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_gssapi_login_attempts_total",
+		Help: "The total number of login attempts using Kerberos",
+	})
+
+	// totalGSSAPILoginOK is the metric that reports the total number of
+	// successful logins using Kerberos
+	totalGSSAPILoginOK = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_gssapi_login_ok_total",
+		Help: "The total number of successful logins using Kerberos",
+	})
+
+	// totalGSSAPILoginFailed is the metric that reports the total number of
+	// failed logins using Kerberos
+	totalGSSAPILoginFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_gssapi_login_ko_total",
+		Help: "The total number of failed logins using Kerberos",
+	})
+
 	totalHTTPRequests = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "sftpgo_http_req_total",
 		Help: "The total number of HTTP requests served",
@@ -638,6 +664,66 @@ var (
 		Name: "sftpgo_httpfs_download_size",
 		Help: "The total HTTPFs download size as bytes, partial downloads are included",
 	})
+
+	// backendLatencyP95 is the metric that reports the 95th percentile latency, as seconds, for the
+	// monitored backend operations
+	backendLatencyP95 = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sftpgo_backend_latency_p95_seconds",
+		Help: "The 95th percentile latency, as seconds, for the monitored backend operations",
+	}, []string{"backend"})
+
+	// latencyThrottlingActive is the metric that reports if the latency based throttling is active
+	latencyThrottlingActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sftpgo_latency_throttling_active",
+		Help: "Set to 1 if new connections are throttled because of the backend latency, 0 otherwise",
+	})
+
+	// resourceLimitsExceeded is the metric that reports if the process resource limits are exceeded
+	resourceLimitsExceeded = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sftpgo_resource_limits_exceeded",
+		Help: "Set to 1 if new connections are rejected because the process resource limits are exceeded, 0 otherwise",
+	})
+
+	// totalLatencyThrottledConnections is the metric that reports the total number of new connections
+	// delayed or rejected because of the backend latency
+	totalLatencyThrottledConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_latency_throttled_connections_total",
+		Help: "The total number of new connections delayed or rejected because of the backend latency",
+	}, []string{"action"})
+
+	// totalTempFilesRemoved is the metric that reports the total number of stale temporary
+	// files removed, or incomplete uploads aborted, by the periodic cleanup
+	totalTempFilesRemoved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_temp_files_removed_total",
+		Help: "The total number of stale temporary files removed by the periodic cleanup",
+	}, []string{"backend"})
+
+	// totalTempFilesRemovedSize is the metric that reports the total size, as bytes, of
+	// the stale temporary files removed by the periodic cleanup
+	totalTempFilesRemovedSize = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_temp_files_removed_size",
+		Help: "The total size, as bytes, of the stale temporary files removed by the periodic cleanup",
+	}, []string{"backend"})
+
+	// totalTempFilesCleanupErrors is the metric that reports the total number of errors
+	// for the periodic cleanup of the stale temporary files
+	totalTempFilesCleanupErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_temp_files_cleanup_errors_total",
+		Help: "The total number of errors for the periodic cleanup of the stale temporary files",
+	}, []string{"backend"})
+
+	// totalReadCacheRequests is the metric that reports the total number of downloads
+	// from cloud backends served from the local read cache (hit) or not (miss)
+	totalReadCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_read_cache_requests_total",
+		Help: "The total number of downloads from cloud backends looked up in the local read cache",
+	}, []string{"result"})
+
+	// readCacheSize is the metric that reports the size of the objects in the local read cache
+	readCacheSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sftpgo_read_cache_size",
+		Help: "The size, as bytes, of the objects stored in the local read cache",
+	})
 )
 
 // AddMetricsEndpoint exposes metrics to the specified endpoint
@@ -645,6 +731,19 @@ func AddMetricsEndpoint(metricsPath string, handler chi.Router) {
 	handler.Handle(metricsPath, promhttp.Handler())
 }
 
+// PushToGateway pushes all the collected metrics to the Pushgateway at the specified URL.
+// The existing metrics with the same job and grouping labels will be replaced
+func PushToGateway(url, job string, grouping map[string]string, username, password string, client *http.Client) error {
+	pusher := push.New(url, job).Gatherer(prometheus.DefaultGatherer).Client(client)
+	for name, value := range grouping {
+		pusher.Grouping(name, value)
+	}
+	if username != "" {
+		pusher.BasicAuth(username, password)
+	}
+	return pusher.Push()
+}
+
 // TransferCompleted updates metrics after an upload or a download
 func TransferCompleted(bytesSent, bytesReceived int64, transferKind int, err error, isSFTPFs bool) {
 	if transferKind == 0 {
@@ -926,6 +1025,8 @@ func AddLoginAttempt(authMethod string) {
 		totalTLSCertAndPwdLoginAttempts.Inc()
 	case loginMethodIDP:
 		totalIDPLoginAttempts.Inc()
+	case loginMethodGSSAPI:
+		totalGSSAPILoginAttempts.Inc()
 	default:
 		totalPasswordLoginAttempts.Inc()
 	}
@@ -948,6 +1049,8 @@ func incLoginOK(authMethod string) {
 		totalTLSCertAndPwdLoginOK.Inc()
 	case loginMethodIDP:
 		totalIDPLoginOK.Inc()
+	case loginMethodGSSAPI:
+		totalGSSAPILoginOK.Inc()
 	default:
 		totalPasswordLoginOK.Inc()
 	}
@@ -970,6 +1073,8 @@ func incLoginFailed(authMethod string) {
 		totalTLSCertAndPwdLoginFailed.Inc()
 	case loginMethodIDP:
 		totalIDPLoginFailed.Inc()
+	case loginMethodGSSAPI:
+		totalGSSAPILoginFailed.Inc()
 	default:
 		totalPasswordLoginFailed.Inc()
 	}
@@ -1006,3 +1111,62 @@ func HTTPRequestServed(status int) {
 func UpdateActiveConnectionsSize(size int) {
 	activeConnections.Set(float64(size))
 }
+
+// UpdateBackendLatency sets the metric for the 95th percentile latency of the specified backend
+func UpdateBackendLatency(backend string, p95 time.Duration) {
+	backendLatencyP95.WithLabelValues(backend).Set(p95.Seconds())
+}
+
+// UpdateLatencyThrottling sets the metric for the latency based throttling status
+func UpdateLatencyThrottling(active bool) {
+	if active {
+		latencyThrottlingActive.Set(1)
+	} else {
+		latencyThrottlingActive.Set(0)
+	}
+}
+
+// UpdateResourceLimitsExceeded sets the metric for the resource limits status
+func UpdateResourceLimitsExceeded(exceeded bool) {
+	if exceeded {
+		resourceLimitsExceeded.Set(1)
+	} else {
+		resourceLimitsExceeded.Set(0)
+	}
+}
+
+// AddLatencyThrottledConnection increments the metric for connections delayed or
+// rejected because of the backend latency
+func AddLatencyThrottledConnection(rejected bool) {
+	if rejected {
+		totalLatencyThrottledConnections.WithLabelValues("rejected").Inc()
+	} else {
+		totalLatencyThrottledConnections.WithLabelValues("delayed").Inc()
+	}
+}
+
+// AddTempFilesCleanup increments the metrics for the stale temporary files removed
+// from the specified backend
+func AddTempFilesCleanup(backend string, files int, size int64) {
+	totalTempFilesRemoved.WithLabelValues(backend).Add(float64(files))
+	totalTempFilesRemovedSize.WithLabelValues(backend).Add(float64(size))
+}
+
+// AddTempFilesCleanupError increments the metric for the temporary files cleanup errors
+func AddTempFilesCleanupError(backend string) {
+	totalTempFilesCleanupErrors.WithLabelValues(backend).Inc()
+}
+
+// AddReadCacheRequest increments the metric for the local read cache lookups
+func AddReadCacheRequest(hit bool) {
+	if hit {
+		totalReadCacheRequests.WithLabelValues("hit").Inc()
+	} else {
+		totalReadCacheRequests.WithLabelValues("miss").Inc()
+	}
+}
+
+// UpdateReadCacheSize sets the metric for the local read cache size
+func UpdateReadCacheSize(size int64) {
+	readCacheSize.Set(float64(size))
+}
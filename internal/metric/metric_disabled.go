@@ -4,6 +4,9 @@
 package metric
 
 import (
+	"net/http"
+	"time"
+
 	"github.com/go-chi/chi/v5"
 
 	"github.com/drakkan/sftpgo/v2/internal/version"
@@ -16,6 +19,11 @@ func init() {
 // AddMetricsEndpoint exposes metrics to the specified endpoint
 func AddMetricsEndpoint(_ string, _ chi.Router) {}
 
+// PushToGateway pushes all the collected metrics to the Pushgateway at the specified URL
+func PushToGateway(_, _ string, _ map[string]string, _, _ string, _ *http.Client) error {
+	return nil
+}
+
 // TransferCompleted updates metrics after an upload or a download
 func TransferCompleted(_, _ int64, _ int, _ error, _ bool) {}
 
@@ -73,3 +81,57 @@ func HTTPRequestServed(_ int) {}
 
 // UpdateActiveConnectionsSize sets the metric for active connections
 func UpdateActiveConnectionsSize(_ int) {}
+
+// UpdateBackendLatency sets the metric for the 95th percentile latency of the specified backend
+func UpdateBackendLatency(_ string, _ time.Duration) {}
+
+// UpdateLatencyThrottling sets the metric for the latency based throttling status
+func UpdateLatencyThrottling(_ bool) {}
+
+// UpdateResourceLimitsExceeded sets the metric for the resource limits status
+func UpdateResourceLimitsExceeded(_ bool) {}
+
+// AddLatencyThrottledConnection increments the metric for connections delayed or
+// rejected because of the backend latency
+func AddLatencyThrottledConnection(_ bool) {}
+
+// AddTempFilesCleanup increments the metrics for the stale temporary files removed
+// from the specified backend
+func AddTempFilesCleanup(_ string, _ int, _ int64) {}
+
+// AddTempFilesCleanupError increments the metric for the temporary files cleanup errors
+func AddTempFilesCleanupError(_ string) {}
+
+// AddReadCacheRequest increments the metric for the local read cache lookups
+func AddReadCacheRequest(_ bool) {}
+
+// UpdateReadCacheSize sets the metric for the local read cache size
+func UpdateReadCacheSize(_ int64) {}
+
+// SetUserMetrics enables or disables the metrics labeled by username, protocol
+// and virtual folder
+func SetUserMetrics(_ bool, _, _ int) {}
+
+// IsUserMetricsEnabled returns true if the metrics labeled by username,
+// protocol and virtual folder are enabled
+func IsUserMetricsEnabled() bool {
+	return false
+}
+
+// AddUserConnection increments the active connections metric for the specified user
+func AddUserConnection(_, _ string) {}
+
+// RemoveUserConnection decrements the active connections metric for the specified user
+func RemoveUserConnection(_, _ string) {}
+
+// UserTransferCompleted updates the user metrics after an upload or a download
+func UserTransferCompleted(_, _, _ string, _, _ int64, _ int, _ error) {}
+
+// AddUserOperation updates the user metrics after a filesystem operation
+func AddUserOperation(_, _, _, _ string, _ error) {}
+
+// AddLoginDuration records the time spent authenticating a user
+func AddLoginDuration(_, _ string, _ time.Duration, _ error) {}
+
+// AddTransferThroughput records the throughput for a successfully completed transfer
+func AddTransferThroughput(_ string, _ int, _ int64, _ time.Duration) {}
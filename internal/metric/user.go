@@ -0,0 +1,230 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nometrics
+// +build !nometrics
+
+package metric
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// otherLabelValue replaces the usernames and folder names exceeding the
+	// configured cardinality limits
+	otherLabelValue = "__other__"
+	loginResultOK   = "ok"
+	loginResultKO   = "ko"
+)
+
+var (
+	userMetricsEnabled atomic.Bool
+	usernameLimiter    = newLabelLimiter(0)
+	folderLimiter      = newLabelLimiter(0)
+
+	// userActiveConnections is the metric that reports the active connections by username and protocol
+	userActiveConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sftpgo_user_active_connections",
+		Help: "Active connections by username and protocol",
+	}, []string{"username", "protocol"})
+
+	// userUploadSize is the metric that reports the uploaded bytes by username, protocol and virtual folder
+	userUploadSize = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_upload_size",
+		Help: "The total upload size as bytes by username, protocol and virtual folder",
+	}, []string{"username", "protocol", "folder"})
+
+	// userDownloadSize is the metric that reports the downloaded bytes by username, protocol and virtual folder
+	userDownloadSize = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_download_size",
+		Help: "The total download size as bytes by username, protocol and virtual folder",
+	}, []string{"username", "protocol", "folder"})
+
+	// userOperations is the metric that reports the filesystem operations by username, protocol,
+	// virtual folder and operation
+	userOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_operations_total",
+		Help: "The total number of operations by username, protocol, virtual folder and operation",
+	}, []string{"username", "protocol", "folder", "operation"})
+
+	// userOperationErrors is the metric that reports the failed filesystem operations by username,
+	// protocol, virtual folder and operation
+	userOperationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_operation_errors_total",
+		Help: "The total number of failed operations by username, protocol, virtual folder and operation",
+	}, []string{"username", "protocol", "folder", "operation"})
+
+	// loginDuration is the metric that reports the time needed to authenticate the users
+	loginDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sftpgo_login_duration_seconds",
+		Help:    "Time spent authenticating the users by protocol, login method and result",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"protocol", "method", "result"})
+
+	// transferThroughput is the metric that reports the throughput of the completed transfers
+	transferThroughput = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "sftpgo_transfer_throughput_bytes_per_second",
+		Help: "Throughput of the successfully completed transfers by protocol and operation",
+		// from 1 KB/s to 1 GB/s
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 11),
+	}, []string{"protocol", "operation"})
+)
+
+// labelLimiter limits the number of distinct values for a label, the values
+// exceeding the limit are reported as otherLabelValue. Once accepted, a value
+// is always reported as is, so increments and decrements are consistent
+type labelLimiter struct {
+	mu     sync.RWMutex
+	limit  int
+	values map[string]bool
+}
+
+func newLabelLimiter(limit int) *labelLimiter {
+	return &labelLimiter{
+		limit:  limit,
+		values: make(map[string]bool),
+	}
+}
+
+func (l *labelLimiter) get(value string) string {
+	if value == "" {
+		return value
+	}
+	l.mu.RLock()
+	ok := l.values[value]
+	l.mu.RUnlock()
+	if ok {
+		return value
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.values[value] {
+		return value
+	}
+	if len(l.values) >= l.limit {
+		return otherLabelValue
+	}
+	l.values[value] = true
+	return value
+}
+
+func (l *labelLimiter) reset(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = limit
+	l.values = make(map[string]bool)
+}
+
+// SetUserMetrics enables or disables the metrics labeled by username, protocol
+// and virtual folder. maxUsers and maxFolders limit the number of distinct
+// usernames and folder names, the others are reported as "__other__".
+// The existing labeled metrics are reset
+func SetUserMetrics(enabled bool, maxUsers, maxFolders int) {
+	userMetricsEnabled.Store(false)
+	usernameLimiter.reset(maxUsers)
+	folderLimiter.reset(maxFolders)
+	userActiveConnections.Reset()
+	userUploadSize.Reset()
+	userDownloadSize.Reset()
+	userOperations.Reset()
+	userOperationErrors.Reset()
+	userMetricsEnabled.Store(enabled)
+}
+
+// IsUserMetricsEnabled returns true if the metrics labeled by username,
+// protocol and virtual folder are enabled
+func IsUserMetricsEnabled() bool {
+	return userMetricsEnabled.Load()
+}
+
+// AddUserConnection increments the active connections metric for the specified user
+func AddUserConnection(username, protocol string) {
+	if username == "" || !userMetricsEnabled.Load() {
+		return
+	}
+	userActiveConnections.WithLabelValues(usernameLimiter.get(username), protocol).Inc()
+}
+
+// RemoveUserConnection decrements the active connections metric for the specified user
+func RemoveUserConnection(username, protocol string) {
+	if username == "" || !userMetricsEnabled.Load() {
+		return
+	}
+	userActiveConnections.WithLabelValues(usernameLimiter.get(username), protocol).Dec()
+}
+
+// UserTransferCompleted updates the user metrics after an upload or a download
+func UserTransferCompleted(username, protocol, folder string, bytesSent, bytesReceived int64, transferKind int, err error) {
+	if !userMetricsEnabled.Load() {
+		return
+	}
+	username = usernameLimiter.get(username)
+	folder = folderLimiter.get(folder)
+	if bytesReceived > 0 {
+		userUploadSize.WithLabelValues(username, protocol, folder).Add(float64(bytesReceived))
+	}
+	if bytesSent > 0 {
+		userDownloadSize.WithLabelValues(username, protocol, folder).Add(float64(bytesSent))
+	}
+	operation := "upload"
+	if transferKind != 0 {
+		operation = "download"
+	}
+	addUserOperation(username, protocol, folder, operation, err)
+}
+
+// AddUserOperation updates the user metrics after a filesystem operation
+func AddUserOperation(username, protocol, folder, operation string, err error) {
+	if !userMetricsEnabled.Load() {
+		return
+	}
+	addUserOperation(usernameLimiter.get(username), protocol, folderLimiter.get(folder), operation, err)
+}
+
+func addUserOperation(username, protocol, folder, operation string, err error) {
+	userOperations.WithLabelValues(username, protocol, folder, operation).Inc()
+	if err != nil {
+		userOperationErrors.WithLabelValues(username, protocol, folder, operation).Inc()
+	}
+}
+
+// AddLoginDuration records the time spent authenticating a user
+func AddLoginDuration(protocol, method string, elapsed time.Duration, err error) {
+	result := loginResultOK
+	if err != nil {
+		result = loginResultKO
+	}
+	loginDuration.WithLabelValues(protocol, method, result).Observe(elapsed.Seconds())
+}
+
+// AddTransferThroughput records the throughput for a successfully completed transfer
+func AddTransferThroughput(protocol string, transferKind int, bytes int64, elapsed time.Duration) {
+	if bytes <= 0 || elapsed <= 0 {
+		return
+	}
+	operation := "upload"
+	if transferKind != 0 {
+		operation = "download"
+	}
+	transferThroughput.WithLabelValues(protocol, operation).Observe(float64(bytes) / elapsed.Seconds())
+}
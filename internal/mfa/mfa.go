@@ -16,12 +16,26 @@
 package mfa
 
 import (
+	"errors"
 	"fmt"
 	"time"
 )
 
+// Supported policies for admins
+const (
+	// AdminPolicyNone means that two-factor authentication is optional
+	AdminPolicyNone = iota
+	// AdminPolicyRequireTwoFactor means that admins must configure TOTP, WebAuthn
+	// or push authentication
+	AdminPolicyRequireTwoFactor
+	// AdminPolicyRequireWebAuthn means that admins must register a WebAuthn credential
+	AdminPolicyRequireWebAuthn
+)
+
 var (
 	totpConfigs   []*TOTPConfig
+	adminPolicy   int
+	recoveryCodes RecoveryCodesConfig
 	serviceStatus ServiceStatus
 )
 
@@ -29,6 +43,12 @@ var (
 type ServiceStatus struct {
 	IsActive    bool         `json:"is_active"`
 	TOTPConfigs []TOTPConfig `json:"totp_configs"`
+	WebAuthn    bool         `json:"webauthn"`
+	AdminPolicy int          `json:"admin_policy"`
+	// Recovery codes regeneration settings
+	RecoveryCodes RecoveryCodesConfig `json:"recovery_codes"`
+	// Configured push provider, empty if push authentication is disabled
+	Push string `json:"push"`
 }
 
 // GetStatus returns the service status
@@ -40,13 +60,39 @@ func GetStatus() ServiceStatus {
 type Config struct {
 	// Time-based one time passwords configurations
 	TOTP []TOTPConfig `json:"totp" mapstructure:"totp"`
+	// WebAuthn/FIDO2 authenticators configuration
+	WebAuthn WebAuthnConfig `json:"webauthn" mapstructure:"webauthn"`
+	// Two-factor authentication policy for admins logging in to the WebAdmin:
+	// 0 optional, 1 TOTP or WebAuthn required, 2 WebAuthn required
+	AdminPolicy int `json:"admin_policy" mapstructure:"admin_policy"`
+	// Recovery codes regeneration settings
+	RecoveryCodes RecoveryCodesConfig `json:"recovery_codes" mapstructure:"recovery_codes"`
+	// Push-based second factor configuration
+	Push PushConfig `json:"push" mapstructure:"push"`
+}
+
+// RecoveryCodesConfig defines the policy for regenerating the recovery codes
+type RecoveryCodesConfig struct {
+	// If true, users and admins must confirm their identity, providing their
+	// current password or a TOTP passcode, to regenerate the recovery codes
+	RequireReverification bool `json:"require_reverification" mapstructure:"require_reverification"`
+	// If true, WebClient users who lost both their second factor and their
+	// recovery codes can receive new recovery codes via email after the
+	// password login step. The SMTP configuration is required
+	AllowEmailRecovery bool `json:"allow_email_recovery" mapstructure:"allow_email_recovery"`
 }
 
 // Initialize configures the MFA support
 func (c *Config) Initialize() error {
 	totpConfigs = nil
+	adminPolicy = AdminPolicyNone
 	serviceStatus.IsActive = false
 	serviceStatus.TOTPConfigs = nil
+	serviceStatus.WebAuthn = false
+	serviceStatus.AdminPolicy = AdminPolicyNone
+	recoveryCodes = RecoveryCodesConfig{}
+	serviceStatus.RecoveryCodes = RecoveryCodesConfig{}
+	serviceStatus.Push = ""
 	totp := make(map[string]bool)
 	for _, totpConfig := range c.TOTP {
 		totpConfig := totpConfig //pin
@@ -63,10 +109,68 @@ func (c *Config) Initialize() error {
 		serviceStatus.IsActive = true
 		serviceStatus.TOTPConfigs = append(serviceStatus.TOTPConfigs, totpConfig)
 	}
+	if err := c.WebAuthn.initialize(); err != nil {
+		totpConfigs = nil
+		return err
+	}
+	if c.WebAuthn.isEnabled() {
+		serviceStatus.IsActive = true
+		serviceStatus.WebAuthn = true
+	}
+	if err := c.Push.initialize(); err != nil {
+		totpConfigs = nil
+		webAuthn = nil
+		return err
+	}
+	if c.Push.isEnabled() {
+		serviceStatus.IsActive = true
+		serviceStatus.Push = c.Push.Provider
+	}
+	switch c.AdminPolicy {
+	case AdminPolicyNone:
+	case AdminPolicyRequireTwoFactor:
+		if !serviceStatus.IsActive {
+			totpConfigs = nil
+			webAuthn = nil
+			return errors.New("the admin two-factor policy requires TOTP, WebAuthn or push authentication")
+		}
+	case AdminPolicyRequireWebAuthn:
+		if !c.WebAuthn.isEnabled() {
+			totpConfigs = nil
+			pushProvider = nil
+			return errors.New("the admin WebAuthn policy requires WebAuthn")
+		}
+	default:
+		totpConfigs = nil
+		webAuthn = nil
+		pushProvider = nil
+		return fmt.Errorf("invalid admin two-factor policy: %d", c.AdminPolicy)
+	}
+	adminPolicy = c.AdminPolicy
+	serviceStatus.AdminPolicy = c.AdminPolicy
+	recoveryCodes = c.RecoveryCodes
+	serviceStatus.RecoveryCodes = c.RecoveryCodes
 	startCleanupTicker(2 * time.Minute)
 	return nil
 }
 
+// GetAdminPolicy returns the two-factor authentication policy for admins
+func GetAdminPolicy() int {
+	return adminPolicy
+}
+
+// IsRecoveryCodesReverificationRequired returns true if the password or a TOTP
+// passcode is required to regenerate the recovery codes
+func IsRecoveryCodesReverificationRequired() bool {
+	return recoveryCodes.RequireReverification
+}
+
+// IsRecoveryCodesEmailRecoveryAllowed returns true if users who lost their
+// second factor can receive new recovery codes via email
+func IsRecoveryCodesEmailRecoveryAllowed() bool {
+	return recoveryCodes.AllowEmailRecovery
+}
+
 // GetAvailableTOTPConfigs returns the available TOTP configs
 func GetAvailableTOTPConfigs() []*TOTPConfig {
 	return totpConfigs
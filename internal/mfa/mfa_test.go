@@ -15,12 +15,27 @@
 package mfa
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 )
 
 func TestMFAConfig(t *testing.T) {
@@ -108,6 +123,206 @@ func TestMFAConfig(t *testing.T) {
 	stopCleanupTicker()
 }
 
+func TestRecoveryCodesPolicy(t *testing.T) {
+	config := Config{
+		RecoveryCodes: RecoveryCodesConfig{
+			RequireReverification: true,
+			AllowEmailRecovery:    true,
+		},
+	}
+	err := config.Initialize()
+	assert.NoError(t, err)
+	assert.True(t, IsRecoveryCodesReverificationRequired())
+	assert.True(t, IsRecoveryCodesEmailRecoveryAllowed())
+	assert.True(t, GetStatus().RecoveryCodes.RequireReverification)
+	assert.True(t, GetStatus().RecoveryCodes.AllowEmailRecovery)
+
+	config.RecoveryCodes = RecoveryCodesConfig{}
+	err = config.Initialize()
+	assert.NoError(t, err)
+	assert.False(t, IsRecoveryCodesReverificationRequired())
+	assert.False(t, IsRecoveryCodesEmailRecoveryAllowed())
+	assert.False(t, GetStatus().RecoveryCodes.RequireReverification)
+
+	stopCleanupTicker()
+}
+
+func TestPushConfig(t *testing.T) {
+	config := Config{
+		Push: PushConfig{
+			Provider: "unknown",
+		},
+	}
+	err := config.Initialize()
+	assert.Error(t, err)
+	assert.False(t, IsPushEnabled())
+	config.Push.Provider = PushProviderWebhook
+	err = config.Initialize()
+	assert.Error(t, err)
+	config.Push.Webhook.URL = "ftp://127.0.0.1"
+	err = config.Initialize()
+	assert.Error(t, err)
+	config.Push.Webhook.URL = "http://127.0.0.1:8080/approve"
+	config.Push.Timeout = 120
+	err = config.Initialize()
+	assert.Error(t, err)
+	config.Push.Timeout = 0
+	err = config.Initialize()
+	assert.NoError(t, err)
+	assert.True(t, IsPushEnabled())
+	assert.True(t, GetStatus().IsActive)
+	assert.Equal(t, PushProviderWebhook, GetStatus().Push)
+	assert.Equal(t, defaultPushTimeout*time.Second, pushTimeout)
+	config.Push.Provider = PushProviderDuo
+	err = config.Initialize()
+	assert.Error(t, err)
+	config.Push.Duo = DuoConfig{
+		APIHostname:    "api-test.duosecurity.com",
+		IntegrationKey: "ikey",
+		SecretKey:      "skey",
+	}
+	err = config.Initialize()
+	assert.NoError(t, err)
+	assert.Equal(t, PushProviderDuo, GetStatus().Push)
+	assert.Equal(t, "https://api-test.duosecurity.com", config.Push.Duo.getBaseURL())
+	config.Push = PushConfig{}
+	err = config.Initialize()
+	assert.NoError(t, err)
+	assert.False(t, IsPushEnabled())
+	assert.Empty(t, GetStatus().Push)
+	err = RequestPushApproval(PushRequest{Username: "user"})
+	assert.ErrorIs(t, err, errPushDisabled)
+
+	stopCleanupTicker()
+}
+
+func TestPushWebhook(t *testing.T) {
+	httpConfig := httpclient.Config{
+		Timeout: 5,
+	}
+	err := httpConfig.Initialize("")
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req PushRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch req.Username {
+		case "approve":
+			w.Write([]byte(`{"result": "approve"}`)) //nolint:errcheck
+		case "deny":
+			w.Write([]byte(`{"result": "deny"}`)) //nolint:errcheck
+		case "invalid":
+			w.Write([]byte(`{"result": "unknown"}`)) //nolint:errcheck
+		case "slow":
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	config := Config{
+		Push: PushConfig{
+			Provider: PushProviderWebhook,
+			Timeout:  1,
+			Webhook: PushWebhookConfig{
+				URL: server.URL,
+			},
+		},
+	}
+	err = config.Initialize()
+	require.NoError(t, err)
+	err = RequestPushApproval(PushRequest{Username: "approve", Role: "user", IP: "127.0.0.1", Protocol: "FTP"})
+	assert.NoError(t, err)
+	err = RequestPushApproval(PushRequest{Username: "deny"})
+	assert.ErrorIs(t, err, ErrPushDenied)
+	err = RequestPushApproval(PushRequest{Username: "invalid"})
+	assert.Error(t, err)
+	err = RequestPushApproval(PushRequest{Username: "error"})
+	assert.Error(t, err)
+	err = RequestPushApproval(PushRequest{Username: "slow"})
+	assert.ErrorIs(t, err, ErrPushTimeout)
+
+	config.Push = PushConfig{}
+	err = config.Initialize()
+	assert.NoError(t, err)
+	stopCleanupTicker()
+}
+
+func TestPushDuo(t *testing.T) {
+	httpConfig := httpclient.Config{
+		Timeout: 5,
+	}
+	err := httpConfig.Initialize("")
+	require.NoError(t, err)
+
+	duoConfig := DuoConfig{
+		IntegrationKey: "DIXXXXXXXXXXXXXXXXXX",
+		SecretKey:      "secret",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != duoAuthPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		p := &duoPushProvider{config: duoConfig}
+		expected := p.getAuthorization(r.Header.Get("Date"), r.Method, r.Host, r.URL.Path, duoCanonicalParams(r.PostForm))
+		if r.Header.Get("Authorization") != expected || r.PostForm.Get("factor") != "push" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.PostForm.Get("username") {
+		case "allow":
+			w.Write([]byte(`{"stat": "OK", "response": {"result": "allow", "status": "allow"}}`)) //nolint:errcheck
+		case "deny":
+			w.Write([]byte(`{"stat": "OK", "response": {"result": "deny", "status": "deny"}}`)) //nolint:errcheck
+		default:
+			w.Write([]byte(`{"stat": "FAIL", "code": 40002, "message": "Invalid request parameters"}`)) //nolint:errcheck
+		}
+	}))
+	defer server.Close()
+
+	duoConfig.APIHostname = server.URL
+	config := Config{
+		Push: PushConfig{
+			Provider: PushProviderDuo,
+			Duo:      duoConfig,
+		},
+	}
+	err = config.Initialize()
+	require.NoError(t, err)
+	err = RequestPushApproval(PushRequest{Username: "allow", IP: "127.0.0.1", Protocol: "HTTP"})
+	assert.NoError(t, err)
+	err = RequestPushApproval(PushRequest{Username: "deny", IP: "127.0.0.1", Protocol: "HTTP"})
+	assert.ErrorIs(t, err, ErrPushDenied)
+	err = RequestPushApproval(PushRequest{Username: "missing"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Invalid request parameters")
+	}
+
+	config.Push = PushConfig{}
+	err = config.Initialize()
+	assert.NoError(t, err)
+	stopCleanupTicker()
+}
+
+func TestDuoCanonicalParams(t *testing.T) {
+	params := url.Values{}
+	params.Set("username", "user name")
+	params.Set("factor", "push")
+	params.Set("pushinfo", "protocol=SSH")
+	canon := duoCanonicalParams(params)
+	assert.Equal(t, "factor=push&pushinfo=protocol%3DSSH&username=user%20name", canon)
+	assert.False(t, strings.Contains(canon, "+"))
+}
+
 func TestCleanupPasscodes(t *testing.T) {
 	usedPasscodes.Store("key", time.Now().Add(-24*time.Hour).UTC())
 	startCleanupTicker(30 * time.Millisecond)
@@ -141,3 +356,246 @@ func generatePasscode(secret string, algo otp.Algorithm) (string, error) {
 		Algorithm: algo,
 	})
 }
+
+func TestWebAuthnConfig(t *testing.T) {
+	config := Config{
+		WebAuthn: WebAuthnConfig{
+			RPID:        "example.com",
+			ResidentKey: "invalid",
+		},
+	}
+	err := config.Initialize()
+	assert.Error(t, err)
+	assert.False(t, IsWebAuthnEnabled())
+	config.WebAuthn.ResidentKey = "required"
+	config.WebAuthn.UserVerification = "invalid"
+	err = config.Initialize()
+	assert.Error(t, err)
+	config.WebAuthn.UserVerification = ""
+	config.AdminPolicy = 3
+	err = config.Initialize()
+	assert.Error(t, err)
+	config.AdminPolicy = AdminPolicyRequireWebAuthn
+	err = config.Initialize()
+	assert.NoError(t, err)
+	assert.True(t, IsWebAuthnEnabled())
+	assert.Equal(t, AdminPolicyRequireWebAuthn, GetAdminPolicy())
+	assert.Equal(t, 60*time.Second, GetWebAuthnTimeout())
+	status := GetStatus()
+	assert.True(t, status.IsActive)
+	assert.True(t, status.WebAuthn)
+	assert.Equal(t, AdminPolicyRequireWebAuthn, status.AdminPolicy)
+
+	config.WebAuthn.RPID = ""
+	err = config.Initialize()
+	assert.Error(t, err)
+	config.AdminPolicy = AdminPolicyRequireTwoFactor
+	err = config.Initialize()
+	assert.Error(t, err)
+	config.AdminPolicy = AdminPolicyNone
+	err = config.Initialize()
+	assert.NoError(t, err)
+	assert.False(t, IsWebAuthnEnabled())
+	assert.False(t, GetStatus().IsActive)
+	_, _, err = BeginWebAuthnRegistration(&WebAuthnUser{Name: "user"})
+	assert.ErrorIs(t, err, errWebAuthnDisabled)
+	_, _, err = BeginWebAuthnLogin(&WebAuthnUser{Name: "user"})
+	assert.ErrorIs(t, err, errWebAuthnDisabled)
+
+	stopCleanupTicker()
+}
+
+func TestWebAuthnCeremonies(t *testing.T) {
+	config := Config{
+		WebAuthn: WebAuthnConfig{
+			RPID:     "localhost",
+			RPOrigin: "http://localhost:8080",
+			Timeout:  30,
+		},
+	}
+	err := config.Initialize()
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, GetWebAuthnTimeout())
+
+	user := &WebAuthnUser{
+		Name: "user",
+	}
+	admin := &WebAuthnUser{
+		Name:    "user",
+		IsAdmin: true,
+	}
+	assert.NotEqual(t, user.WebAuthnID(), admin.WebAuthnID())
+	_, _, err = BeginWebAuthnLogin(user)
+	assert.Error(t, err)
+
+	authenticator := newTestAuthenticator(t, "localhost", "http://localhost:8080")
+	options, session, err := BeginWebAuthnRegistration(user)
+	require.NoError(t, err)
+	// the registration session cannot be used for another account
+	_, err = FinishWebAuthnRegistration(admin, session, authenticator.register(t, options))
+	assert.Error(t, err)
+	_, err = FinishWebAuthnRegistration(user, []byte("{"), authenticator.register(t, options))
+	assert.Error(t, err)
+	_, err = FinishWebAuthnRegistration(user, session, []byte("{}"))
+	assert.Error(t, err)
+	credential, err := FinishWebAuthnRegistration(user, session, authenticator.register(t, options))
+	require.NoError(t, err)
+	assert.Equal(t, authenticator.credentialID, credential.ID)
+	assert.Equal(t, "none", credential.AttestationType)
+	assert.Greater(t, credential.CreatedAt, int64(0))
+	credential.Name = "key1"
+	user.Credentials = append(user.Credentials, credential)
+	// the same credential cannot be registered twice
+	options, session, err = BeginWebAuthnRegistration(user)
+	require.NoError(t, err)
+	var creation map[string]map[string]any
+	err = json.Unmarshal(options, &creation)
+	require.NoError(t, err)
+	assert.Len(t, creation["publicKey"]["excludeCredentials"], 1)
+	_, err = FinishWebAuthnRegistration(user, session, authenticator.register(t, options))
+	assert.Error(t, err)
+
+	options, session, err = BeginWebAuthnLogin(user)
+	require.NoError(t, err)
+	_, _, err = FinishWebAuthnLogin(user, []byte("invalid"), authenticator.login(t, options))
+	assert.Error(t, err)
+	_, _, err = FinishWebAuthnLogin(admin, session, authenticator.login(t, options))
+	assert.Error(t, err)
+	idx, loginCredential, err := FinishWebAuthnLogin(user, session, authenticator.login(t, options))
+	require.NoError(t, err)
+	assert.Equal(t, 0, idx)
+	assert.Equal(t, authenticator.counter, loginCredential.SignCount)
+	assert.Greater(t, loginCredential.LastUseAt, int64(0))
+	user.Credentials[0] = loginCredential
+	// a login response cannot be reused for a different challenge
+	response := authenticator.login(t, options)
+	options, session, err = BeginWebAuthnLogin(user)
+	require.NoError(t, err)
+	_, _, err = FinishWebAuthnLogin(user, session, response)
+	assert.Error(t, err)
+	// the signature counter cannot go backwards
+	authenticator.counter = 1
+	_, _, err = FinishWebAuthnLogin(user, session, authenticator.login(t, options))
+	assert.ErrorIs(t, err, errWebAuthnCloneDetected)
+
+	config = Config{}
+	err = config.Initialize()
+	assert.NoError(t, err)
+	_, err = FinishWebAuthnRegistration(user, session, response)
+	assert.ErrorIs(t, err, errWebAuthnDisabled)
+	_, _, err = FinishWebAuthnLogin(user, session, response)
+	assert.ErrorIs(t, err, errWebAuthnDisabled)
+
+	stopCleanupTicker()
+}
+
+// testAuthenticator is a software WebAuthn authenticator using the "none" attestation
+type testAuthenticator struct {
+	rpID         string
+	origin       string
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	counter      uint32
+}
+
+func newTestAuthenticator(t *testing.T, rpID, origin string) *testAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	credentialID := make([]byte, 32)
+	_, err = rand.Read(credentialID)
+	require.NoError(t, err)
+	return &testAuthenticator{
+		rpID:         rpID,
+		origin:       origin,
+		key:          key,
+		credentialID: credentialID,
+	}
+}
+
+func (a *testAuthenticator) getChallenge(t *testing.T, options []byte) string {
+	var data map[string]map[string]any
+	err := json.Unmarshal(options, &data)
+	require.NoError(t, err)
+	challenge, ok := data["publicKey"]["challenge"].(string)
+	require.True(t, ok)
+	// the challenge is serialized using the standard encoding, browsers use base64url
+	// inside the client data
+	decoded, err := base64.StdEncoding.DecodeString(challenge)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(decoded)
+}
+
+func (a *testAuthenticator) getAuthData(flags byte, attestedData []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	authData := append([]byte{}, rpIDHash[:]...)
+	authData = append(authData, flags)
+	authData = binary.BigEndian.AppendUint32(authData, a.counter)
+	return append(authData, attestedData...)
+}
+
+func (a *testAuthenticator) getClientData(t *testing.T, ceremonyType, challenge string) []byte {
+	clientData, err := json.Marshal(map[string]string{
+		"type":      ceremonyType,
+		"challenge": challenge,
+		"origin":    a.origin,
+	})
+	require.NoError(t, err)
+	return clientData
+}
+
+func (a *testAuthenticator) register(t *testing.T, options []byte) []byte {
+	publicKey, err := webauthncbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	require.NoError(t, err)
+	attestedData := make([]byte, 16) // AAGUID
+	attestedData = binary.BigEndian.AppendUint16(attestedData, uint16(len(a.credentialID)))
+	attestedData = append(attestedData, a.credentialID...)
+	attestedData = append(attestedData, publicKey...)
+	// user present, user verified, attested credential data included
+	authData := a.getAuthData(0x45, attestedData)
+	attestationObject, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	require.NoError(t, err)
+	clientData := a.getClientData(t, "webauthn.create", a.getChallenge(t, options))
+	response, err := json.Marshal(map[string]any{
+		"id":    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestationObject),
+		},
+	})
+	require.NoError(t, err)
+	return response
+}
+
+func (a *testAuthenticator) login(t *testing.T, options []byte) []byte {
+	a.counter++
+	authData := a.getAuthData(0x05, nil)
+	clientData := a.getClientData(t, "webauthn.get", a.getChallenge(t, options))
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	require.NoError(t, err)
+	response, err := json.Marshal(map[string]any{
+		"id":    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+		},
+	})
+	require.NoError(t, err)
+	return response
+}
@@ -0,0 +1,335 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mfa
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported push providers
+const (
+	PushProviderDuo     = "duo"
+	PushProviderWebhook = "webhook"
+)
+
+const (
+	defaultPushTimeout = 45
+	// the web interfaces must reply before the HTTP write timeout expires
+	maxPushTimeout      = 55
+	duoAuthPath         = "/auth/v2/auth"
+	pushResultApprove   = "approve"
+	pushResultDeny      = "deny"
+	duoResultAllow      = "allow"
+	duoResultDeny       = "deny"
+	duoStatOK           = "OK"
+	maxPushResponseSize = 65535
+)
+
+var (
+	pushProvider PushProvider
+	pushTimeout  = defaultPushTimeout * time.Second
+	// ErrPushDenied defines the error returned if the login request was denied
+	ErrPushDenied = errors.New("push: login request denied")
+	// ErrPushTimeout defines the error returned if the login request was not
+	// approved or denied before the timeout expired
+	ErrPushTimeout  = errors.New("push: login request not approved in time")
+	errPushDisabled = errors.New("push: not enabled")
+)
+
+// PushRequest defines a login request to approve
+type PushRequest struct {
+	Username string `json:"username"`
+	// "user" or "admin"
+	Role     string `json:"role"`
+	IP       string `json:"ip"`
+	Protocol string `json:"protocol"`
+}
+
+// PushProvider defines the interface for the push-based second factor
+// providers. Approve must block until the login request is approved, denied
+// or the context is done. A nil error means that the request was approved
+type PushProvider interface {
+	Approve(ctx context.Context, req PushRequest) error
+}
+
+// PushConfig defines the configuration for push-based second factor
+// authentication. The login is approved or denied by the account owner
+// using an external service, for example the Duo mobile app
+type PushConfig struct {
+	// Provider to use: "duo" or "webhook". Empty means disabled
+	Provider string `json:"provider" mapstructure:"provider"`
+	// Maximum time to wait for the approval, in seconds. 0 means 45 seconds,
+	// the maximum allowed value is 55 seconds
+	Timeout int `json:"timeout" mapstructure:"timeout"`
+	// Duo Auth API configuration
+	Duo DuoConfig `json:"duo" mapstructure:"duo"`
+	// Generic webhook configuration
+	Webhook PushWebhookConfig `json:"webhook" mapstructure:"webhook"`
+}
+
+// DuoConfig defines the configuration for the Duo Auth API. The usernames
+// must match the ones enrolled in Duo
+type DuoConfig struct {
+	// API hostname, for example "api-XXXXXXXX.duosecurity.com"
+	APIHostname    string `json:"api_hostname" mapstructure:"api_hostname"`
+	IntegrationKey string `json:"integration_key" mapstructure:"integration_key"`
+	SecretKey      string `json:"secret_key" mapstructure:"secret_key"`
+}
+
+// PushWebhookConfig defines the configuration for a generic approval
+// webhook. SFTPGo sends a POST request with a JSON serialized PushRequest
+// and the webhook must reply, before the timeout, with a 200 status code
+// and a JSON object like {"result": "approve"} or {"result": "deny"}
+type PushWebhookConfig struct {
+	URL string `json:"url" mapstructure:"url"`
+}
+
+func (c *PushConfig) isEnabled() bool {
+	return c.Provider != ""
+}
+
+func (c *PushConfig) getTimeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultPushTimeout * time.Second
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+func (c *PushConfig) initialize() error {
+	pushProvider = nil
+	pushTimeout = defaultPushTimeout * time.Second
+	if !c.isEnabled() {
+		return nil
+	}
+	if c.Timeout > maxPushTimeout {
+		return fmt.Errorf("push: invalid timeout %d, the maximum allowed value is %d", c.Timeout, maxPushTimeout)
+	}
+	switch c.Provider {
+	case PushProviderDuo:
+		if err := c.Duo.validate(); err != nil {
+			return err
+		}
+		pushProvider = &duoPushProvider{config: c.Duo}
+	case PushProviderWebhook:
+		if err := c.Webhook.validate(); err != nil {
+			return err
+		}
+		pushProvider = &webhookPushProvider{config: c.Webhook}
+	default:
+		return fmt.Errorf("push: unsupported provider %q", c.Provider)
+	}
+	pushTimeout = c.getTimeout()
+	return nil
+}
+
+// IsPushEnabled returns true if a push provider is configured
+func IsPushEnabled() bool {
+	return pushProvider != nil
+}
+
+// RequestPushApproval sends a login request to the configured push provider
+// and waits for the approval. A nil error means that the request was approved
+func RequestPushApproval(req PushRequest) error {
+	if pushProvider == nil {
+		return errPushDisabled
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+	defer cancel()
+
+	err := pushProvider.Approve(ctx, req)
+	if err != nil && ctx.Err() != nil {
+		return ErrPushTimeout
+	}
+	return err
+}
+
+func doPushHTTPRequest(req *http.Request, result any) error {
+	client := httpclient.GetHTTPClient()
+	// the request is bounded by the context
+	client.Timeout = 0
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("push: unexpected status code %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxPushResponseSize)).Decode(result)
+}
+
+type webhookPushProvider struct {
+	config PushWebhookConfig
+}
+
+func (c *PushWebhookConfig) validate() error {
+	if c.URL == "" {
+		return errors.New("push: the webhook URL is mandatory")
+	}
+	if !util.IsStringPrefixInSlice(c.URL, []string{"http://", "https://"}) {
+		return fmt.Errorf("push: invalid webhook URL %q", c.URL)
+	}
+	if _, err := url.Parse(c.URL); err != nil {
+		return fmt.Errorf("push: invalid webhook URL %q: %w", c.URL, err)
+	}
+	return nil
+}
+
+func (p *webhookPushProvider) Approve(ctx context.Context, req PushRequest) error {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.URL, bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpclient.SignRequest(httpReq, httpclient.GetPayloadHash(payload))
+
+	var resp struct {
+		Result string `json:"result"`
+	}
+	if err := doPushHTTPRequest(httpReq, &resp); err != nil {
+		return err
+	}
+	switch resp.Result {
+	case pushResultApprove:
+		return nil
+	case pushResultDeny:
+		return ErrPushDenied
+	default:
+		return fmt.Errorf("push: unexpected webhook result %q", resp.Result)
+	}
+}
+
+type duoPushProvider struct {
+	config DuoConfig
+}
+
+func (c *DuoConfig) validate() error {
+	if c.APIHostname == "" || c.IntegrationKey == "" || c.SecretKey == "" {
+		return errors.New("push: the Duo API hostname, integration key and secret key are mandatory")
+	}
+	return nil
+}
+
+// getBaseURL returns the base URL for the Duo API. A full URL is accepted
+// too, for example to use a proxy
+func (c *DuoConfig) getBaseURL() string {
+	if strings.HasPrefix(c.APIHostname, "http://") || strings.HasPrefix(c.APIHostname, "https://") {
+		return strings.TrimSuffix(c.APIHostname, "/")
+	}
+	return "https://" + c.APIHostname
+}
+
+func (p *duoPushProvider) Approve(ctx context.Context, req PushRequest) error {
+	baseURL, err := url.Parse(p.config.getBaseURL())
+	if err != nil {
+		return fmt.Errorf("push: invalid Duo API hostname: %w", err)
+	}
+	params := url.Values{}
+	params.Set("username", req.Username)
+	params.Set("factor", "push")
+	params.Set("device", "auto")
+	params.Set("type", "SFTPGo login")
+	params.Set("pushinfo", url.Values{"protocol": []string{req.Protocol}}.Encode())
+	if req.IP != "" {
+		params.Set("ipaddr", req.IP)
+	}
+	body := duoCanonicalParams(params)
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL.String()+duoAuthPath, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	date := time.Now().UTC().Format(time.RFC1123Z)
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Date", date)
+	httpReq.Header.Set("Authorization", p.getAuthorization(date, http.MethodPost, baseURL.Host, duoAuthPath, body))
+
+	var resp struct {
+		Stat     string `json:"stat"`
+		Message  string `json:"message"`
+		Response struct {
+			Result    string `json:"result"`
+			StatusMsg string `json:"status_msg"`
+		} `json:"response"`
+	}
+	if err := doPushHTTPRequest(httpReq, &resp); err != nil {
+		return err
+	}
+	if resp.Stat != duoStatOK {
+		return fmt.Errorf("push: Duo API error: %s", resp.Message)
+	}
+	switch resp.Response.Result {
+	case duoResultAllow:
+		return nil
+	case duoResultDeny:
+		return ErrPushDenied
+	default:
+		return fmt.Errorf("push: unexpected Duo result %q: %s", resp.Response.Result, resp.Response.StatusMsg)
+	}
+}
+
+// getAuthorization returns the value for the Authorization header as
+// described in the Duo Auth API documentation
+func (p *duoPushProvider) getAuthorization(date, method, host, path, params string) string {
+	canon := strings.Join([]string{date, strings.ToUpper(method), strings.ToLower(host), path, params}, "\n")
+	mac := hmac.New(sha512.New, []byte(p.config.SecretKey))
+	mac.Write([]byte(canon))
+	sig := hex.EncodeToString(mac.Sum(nil))
+	auth := base64.StdEncoding.EncodeToString([]byte(p.config.IntegrationKey + ":" + sig))
+	return "Basic " + auth
+}
+
+// duoCanonicalParams returns the parameters sorted by key and URL encoded
+// as required by the Duo request signature
+func duoCanonicalParams(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, duoEscape(k)+"="+duoEscape(params.Get(k)))
+	}
+	return strings.Join(parts, "&")
+}
+
+func duoEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
@@ -0,0 +1,340 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mfa
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	defaultWebAuthnTimeout = 60
+)
+
+var (
+	webAuthn                 *webauthn.WebAuthn
+	webAuthnResidentKey      protocol.ResidentKeyRequirement
+	errWebAuthnDisabled      = errors.New("webauthn: not enabled")
+	errWebAuthnCloneDetected = errors.New("webauthn: the signature counter is not valid, the authenticator may be cloned")
+)
+
+// WebAuthnConfig defines the configuration for WebAuthn/FIDO2 authenticators,
+// for example hardware security keys
+type WebAuthnConfig struct {
+	// Relying party ID, it must be the domain, or a registrable suffix of the
+	// domain, used to access the web interfaces, for example "sftp.example.com".
+	// Empty means disabled
+	RPID string `json:"rp_id" mapstructure:"rp_id"`
+	// Relying party name displayed by the browsers
+	RPDisplayName string `json:"rp_display_name" mapstructure:"rp_display_name"`
+	// Origin used to access the web interfaces, for example "https://sftp.example.com:8443".
+	// If empty "https://" followed by the relying party ID is used
+	RPOrigin string `json:"rp_origin" mapstructure:"rp_origin"`
+	// Resident key requirement for new credentials: "discouraged", "preferred" or
+	// "required". Empty means "discouraged"
+	ResidentKey string `json:"resident_key" mapstructure:"resident_key"`
+	// User verification requirement, for example a PIN or a fingerprint: "discouraged",
+	// "preferred" or "required". Empty means "preferred"
+	UserVerification string `json:"user_verification" mapstructure:"user_verification"`
+	// Timeout for the registration and login ceremonies, in seconds. 0 means 60 seconds
+	Timeout int `json:"timeout" mapstructure:"timeout"`
+}
+
+func (c *WebAuthnConfig) isEnabled() bool {
+	return c.RPID != ""
+}
+
+func (c *WebAuthnConfig) getTimeout() int {
+	if c.Timeout <= 0 {
+		return defaultWebAuthnTimeout
+	}
+	return c.Timeout
+}
+
+func (c *WebAuthnConfig) initialize() error {
+	webAuthn = nil
+	if !c.isEnabled() {
+		return nil
+	}
+	residentKey := protocol.ResidentKeyRequirement(c.ResidentKey)
+	switch residentKey {
+	case "":
+		residentKey = protocol.ResidentKeyRequirementDiscouraged
+	case protocol.ResidentKeyRequirementDiscouraged, protocol.ResidentKeyRequirementPreferred,
+		protocol.ResidentKeyRequirementRequired:
+	default:
+		return fmt.Errorf("webauthn: unsupported resident key requirement %q", c.ResidentKey)
+	}
+	userVerification := protocol.UserVerificationRequirement(c.UserVerification)
+	switch userVerification {
+	case "":
+		userVerification = protocol.VerificationPreferred
+	case protocol.VerificationDiscouraged, protocol.VerificationPreferred, protocol.VerificationRequired:
+	default:
+		return fmt.Errorf("webauthn: unsupported user verification requirement %q", c.UserVerification)
+	}
+	displayName := c.RPDisplayName
+	if displayName == "" {
+		displayName = "SFTPGo"
+	}
+	origin := c.RPOrigin
+	if origin == "" {
+		origin = "https://" + c.RPID
+	}
+	w, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: displayName,
+		RPID:          c.RPID,
+		RPOrigin:      origin,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			UserVerification: userVerification,
+		},
+		Timeout: c.getTimeout() * 1000,
+	})
+	if err != nil {
+		return fmt.Errorf("webauthn: %w", err)
+	}
+	webAuthn = w
+	webAuthnResidentKey = residentKey
+	return nil
+}
+
+// WebAuthnCredential defines a registered WebAuthn credential
+type WebAuthnCredential struct {
+	ID              []byte   `json:"id"`
+	Name            string   `json:"name"`
+	PublicKey       []byte   `json:"public_key"`
+	AttestationType string   `json:"attestation_type,omitempty"`
+	Transports      []string `json:"transports,omitempty"`
+	AAGUID          []byte   `json:"aaguid,omitempty"`
+	SignCount       uint32   `json:"sign_count,omitempty"`
+	CreatedAt       int64    `json:"created_at"`
+	LastUseAt       int64    `json:"last_use_at,omitempty"`
+}
+
+func (c *WebAuthnCredential) toWebAuthn() webauthn.Credential {
+	transports := make([]protocol.AuthenticatorTransport, 0, len(c.Transports))
+	for _, t := range c.Transports {
+		transports = append(transports, protocol.AuthenticatorTransport(t))
+	}
+	return webauthn.Credential{
+		ID:              c.ID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transport:       transports,
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    c.AAGUID,
+			SignCount: c.SignCount,
+		},
+	}
+}
+
+// WebAuthnUser defines an SFTPGo account that uses WebAuthn credentials
+type WebAuthnUser struct {
+	Name        string
+	DisplayName string
+	IsAdmin     bool
+	Credentials []WebAuthnCredential
+}
+
+// WebAuthnID implements webauthn.User, the returned ID does not contain
+// personally identifying information
+func (u *WebAuthnUser) WebAuthnID() []byte {
+	prefix := "user:"
+	if u.IsAdmin {
+		prefix = "admin:"
+	}
+	h := sha256.Sum256([]byte(prefix + u.Name))
+	return h[:]
+}
+
+// WebAuthnName implements webauthn.User
+func (u *WebAuthnUser) WebAuthnName() string {
+	return u.Name
+}
+
+// WebAuthnDisplayName implements webauthn.User
+func (u *WebAuthnUser) WebAuthnDisplayName() string {
+	if u.DisplayName == "" {
+		return u.Name
+	}
+	return u.DisplayName
+}
+
+// WebAuthnIcon implements webauthn.User
+func (u *WebAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+// WebAuthnCredentials implements webauthn.User
+func (u *WebAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	result := make([]webauthn.Credential, 0, len(u.Credentials))
+	for idx := range u.Credentials {
+		result = append(result, u.Credentials[idx].toWebAuthn())
+	}
+	return result
+}
+
+func (u *WebAuthnUser) getCredentialDescriptors() []protocol.CredentialDescriptor {
+	result := make([]protocol.CredentialDescriptor, 0, len(u.Credentials))
+	for idx := range u.Credentials {
+		result = append(result, u.Credentials[idx].toWebAuthn().Descriptor())
+	}
+	return result
+}
+
+// IsWebAuthnEnabled returns true if WebAuthn authenticators are supported
+func IsWebAuthnEnabled() bool {
+	return webAuthn != nil
+}
+
+// GetWebAuthnTimeout returns the maximum allowed duration for WebAuthn ceremonies
+func GetWebAuthnTimeout() time.Duration {
+	if webAuthn == nil {
+		return defaultWebAuthnTimeout * time.Second
+	}
+	return time.Duration(webAuthn.Config.Timeout) * time.Millisecond
+}
+
+// BeginWebAuthnRegistration starts the registration of a new credential for the
+// specified user. It returns the JSON serialized options to pass to the browser
+// and the session data to store, server side, until the registration ends
+func BeginWebAuthnRegistration(user *WebAuthnUser) ([]byte, []byte, error) {
+	if webAuthn == nil {
+		return nil, nil, errWebAuthnDisabled
+	}
+	options, session, err := webAuthn.BeginRegistration(user, webauthn.WithExclusions(user.getCredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(webAuthnResidentKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	return marshalWebAuthnCeremony(options, session)
+}
+
+// FinishWebAuthnRegistration validates the browser response for a registration
+// started using BeginWebAuthnRegistration and returns the new credential
+func FinishWebAuthnRegistration(user *WebAuthnUser, sessionData, response []byte) (WebAuthnCredential, error) {
+	if webAuthn == nil {
+		return WebAuthnCredential{}, errWebAuthnDisabled
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal(sessionData, &session); err != nil {
+		return WebAuthnCredential{}, fmt.Errorf("webauthn: invalid session: %w", err)
+	}
+	parsedResponse, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return WebAuthnCredential{}, getWebAuthnError(err)
+	}
+	credential, err := webAuthn.CreateCredential(user, session, parsedResponse)
+	if err != nil {
+		return WebAuthnCredential{}, getWebAuthnError(err)
+	}
+	for idx := range user.Credentials {
+		if bytes.Equal(user.Credentials[idx].ID, credential.ID) {
+			return WebAuthnCredential{}, errors.New("webauthn: credential already registered")
+		}
+	}
+	transports := make([]string, 0, len(credential.Transport))
+	for _, t := range credential.Transport {
+		transports = append(transports, string(t))
+	}
+	return WebAuthnCredential{
+		ID:              credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		CreatedAt:       util.GetTimeAsMsSinceEpoch(time.Now()),
+	}, nil
+}
+
+// BeginWebAuthnLogin starts a login using one of the credentials registered for
+// the specified user. It returns the JSON serialized options to pass to the
+// browser and the session data to store, server side, until the login ends
+func BeginWebAuthnLogin(user *WebAuthnUser) ([]byte, []byte, error) {
+	if webAuthn == nil {
+		return nil, nil, errWebAuthnDisabled
+	}
+	if len(user.Credentials) == 0 {
+		return nil, nil, errors.New("webauthn: no credential registered")
+	}
+	options, session, err := webAuthn.BeginLogin(user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return marshalWebAuthnCeremony(options, session)
+}
+
+// FinishWebAuthnLogin validates the browser response for a login started using
+// BeginWebAuthnLogin. It returns the index of the used credential and the
+// credential itself with the updated signature counter and last use time
+func FinishWebAuthnLogin(user *WebAuthnUser, sessionData, response []byte) (int, WebAuthnCredential, error) {
+	if webAuthn == nil {
+		return -1, WebAuthnCredential{}, errWebAuthnDisabled
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal(sessionData, &session); err != nil {
+		return -1, WebAuthnCredential{}, fmt.Errorf("webauthn: invalid session: %w", err)
+	}
+	parsedResponse, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return -1, WebAuthnCredential{}, getWebAuthnError(err)
+	}
+	credential, err := webAuthn.ValidateLogin(user, session, parsedResponse)
+	if err != nil {
+		return -1, WebAuthnCredential{}, getWebAuthnError(err)
+	}
+	if credential.Authenticator.CloneWarning {
+		return -1, WebAuthnCredential{}, errWebAuthnCloneDetected
+	}
+	for idx := range user.Credentials {
+		if bytes.Equal(user.Credentials[idx].ID, credential.ID) {
+			result := user.Credentials[idx]
+			result.SignCount = credential.Authenticator.SignCount
+			result.LastUseAt = util.GetTimeAsMsSinceEpoch(time.Now())
+			return idx, result, nil
+		}
+	}
+	return -1, WebAuthnCredential{}, errors.New("webauthn: credential not found")
+}
+
+func marshalWebAuthnCeremony(options any, session *webauthn.SessionData) ([]byte, []byte, error) {
+	optionsData, err := json.Marshal(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return nil, nil, err
+	}
+	return optionsData, sessionData, nil
+}
+
+func getWebAuthnError(err error) error {
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) && protocolErr.DevInfo != "" {
+		return fmt.Errorf("webauthn: %s: %s", protocolErr.Details, protocolErr.DevInfo)
+	}
+	return fmt.Errorf("webauthn: %w", err)
+}
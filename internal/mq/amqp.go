@@ -0,0 +1,183 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+type amqpEndpoint struct {
+	address  string
+	vhost    string
+	username string
+	password string
+	useTLS   bool
+}
+
+func parseAMQPEndpoint(config *Config) (amqpEndpoint, error) {
+	u, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return amqpEndpoint{}, err
+	}
+	result := amqpEndpoint{
+		vhost:    "/",
+		username: "guest",
+		password: "guest",
+		useTLS:   u.Scheme == "amqps" || config.TLS,
+	}
+	port := u.Port()
+	if port == "" {
+		port = "5672"
+		if result.useTLS {
+			port = "5671"
+		}
+	}
+	result.address = net.JoinHostPort(u.Hostname(), port)
+	if len(u.Path) > 1 {
+		result.vhost, err = url.PathUnescape(strings.TrimPrefix(u.EscapedPath(), "/"))
+		if err != nil {
+			return amqpEndpoint{}, err
+		}
+	}
+	if u.User != nil {
+		result.username = u.User.Username()
+		result.password, _ = u.User.Password()
+	}
+	if config.Username != "" {
+		result.username = config.Username
+		result.password = config.Password
+	}
+	return result, nil
+}
+
+type amqpPublisher struct {
+	conn     *amqp.Connection
+	ch       *amqp.Channel
+	closed   chan *amqp.Error
+	confirms bool
+}
+
+func newAMQPPublisher(ctx context.Context, config *Config) (*amqpPublisher, error) {
+	endpoint, err := parseAMQPEndpoint(config)
+	if err != nil {
+		return nil, err
+	}
+	properties := amqp.NewConnectionProperties()
+	properties["product"] = "SFTPGo"
+	properties["version"] = version.Get().Version
+	// the TLS handshake, if required, is done in the dial function, so the
+	// URL scheme is always amqp
+	conn, err := amqp.DialConfig("amqp://"+endpoint.address+"/", amqp.Config{
+		SASL: []amqp.Authentication{
+			&amqp.PlainAuth{
+				Username: endpoint.username,
+				Password: endpoint.password,
+			},
+		},
+		Vhost:      endpoint.vhost,
+		Properties: properties,
+		Dial: func(network, addr string) (net.Conn, error) {
+			return config.dial(ctx, addr, endpoint.useTLS)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	p := &amqpPublisher{
+		conn:     conn,
+		ch:       ch,
+		closed:   ch.NotifyClose(make(chan *amqp.Error, 1)),
+		confirms: config.Delivery == DeliveryAtLeastOnce,
+	}
+	if p.confirms {
+		if err := ch.Confirm(false); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// getCloseError returns the error that caused the channel closure, if any
+func (p *amqpPublisher) getCloseError() error {
+	select {
+	case err, ok := <-p.closed:
+		if ok && err != nil {
+			return err
+		}
+		return amqp.ErrClosed
+	default:
+		return nil
+	}
+}
+
+func (p *amqpPublisher) publish(ctx context.Context, msg *Message) error {
+	publishing := amqp.Publishing{
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Transient,
+		Timestamp:    time.Now(),
+		Body:         msg.Body,
+	}
+	if len(msg.Headers) > 0 {
+		publishing.Headers = make(amqp.Table)
+		for _, h := range msg.Headers {
+			publishing.Headers[h.Key] = h.Value
+		}
+	}
+	if !p.confirms {
+		return p.ch.PublishWithContext(ctx, msg.Exchange, msg.Topic, false, false, publishing)
+	}
+	publishing.DeliveryMode = amqp.Persistent
+	confirmation, err := p.ch.PublishWithDeferredConfirmWithContext(ctx, msg.Exchange, msg.Topic, false, false,
+		publishing)
+	if err != nil {
+		return err
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("timeout waiting for the AMQP publish confirmation: %w", err)
+	}
+	if !acked {
+		// the pending confirmations are released without an ack if the
+		// channel is closed by the server, for example for a missing exchange
+		if err := p.getCloseError(); err != nil {
+			return err
+		}
+		return errors.New("message rejected by the AMQP server")
+	}
+	return nil
+}
+
+func (p *amqpPublisher) close() error {
+	if p.conn.IsClosed() {
+		return nil
+	}
+	return p.conn.CloseDeadline(time.Now().Add(2 * time.Second))
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mq
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+type kafkaPublisher struct {
+	client *kgo.Client
+}
+
+func newKafkaPublisher(ctx context.Context, config *Config) (*kafkaPublisher, error) {
+	options := []kgo.Opt{
+		kgo.SeedBrokers(config.getKafkaBrokers()...),
+		kgo.ClientID("sftpgo"),
+		kgo.SoftwareNameAndVersion("SFTPGo", version.Get().Version),
+		kgo.Dialer(func(ctx context.Context, network, host string) (net.Conn, error) {
+			return config.dial(ctx, host, config.TLS)
+		}),
+		kgo.ProducerLinger(0),
+	}
+	// the default partitioner hashes the record keys as the Java client does
+	if config.Delivery == DeliveryAtLeastOnce {
+		options = append(options, kgo.RequiredAcks(kgo.AllISRAcks()))
+	} else {
+		options = append(options, kgo.RequiredAcks(kgo.NoAck()), kgo.DisableIdempotentWrite())
+	}
+	if config.Username != "" {
+		options = append(options, kgo.SASL(plain.Auth{
+			User: config.Username,
+			Pass: config.Password,
+		}.AsMechanism()))
+	}
+	client, err := kgo.NewClient(options...)
+	if err != nil {
+		return nil, err
+	}
+	// the client connects lazily, ping a broker to report connection and
+	// authentication errors now
+	if err := client.Ping(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &kafkaPublisher{
+		client: client,
+	}, nil
+}
+
+func (p *kafkaPublisher) publish(ctx context.Context, msg *Message) error {
+	record := &kgo.Record{
+		Topic: msg.Topic,
+		Key:   msg.Key,
+		Value: msg.Body,
+	}
+	for _, h := range msg.Headers {
+		record.Headers = append(record.Headers, kgo.RecordHeader{
+			Key:   h.Key,
+			Value: []byte(h.Value),
+		})
+	}
+	if err := p.client.ProduceSync(ctx, record).FirstErr(); err != nil {
+		return fmt.Errorf("unable to publish to topic %q: %w", msg.Topic, err)
+	}
+	return nil
+}
+
+func (p *kafkaPublisher) close() error {
+	p.client.Close()
+	return nil
+}
@@ -0,0 +1,393 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package mq provides support for publishing messages to Kafka, NATS and AMQP brokers
+package mq
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/resolver"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	logSender = "mq"
+)
+
+// Supported brokers
+const (
+	BrokerKafka = "kafka"
+	BrokerNATS  = "nats"
+	BrokerAMQP  = "amqp"
+)
+
+// Supported delivery guarantees
+const (
+	// the message is sent without waiting for a broker acknowledgement
+	DeliveryAtMostOnce = iota
+	// the publish succeeds only after the broker acknowledged the message.
+	// Kafka waits for all the in-sync replicas, NATS requires a JetStream
+	// stream for the subject and AMQP uses publisher confirms and
+	// persistent messages
+	DeliveryAtLeastOnce
+)
+
+const (
+	// MaxPoolSize defines the maximum number of idle connections to keep for
+	// each broker configuration
+	MaxPoolSize    = 32
+	idleTimeout    = 5 * time.Minute
+	defaultTimeout = 30 * time.Second
+)
+
+var (
+	// SupportedBrokers defines the supported brokers
+	SupportedBrokers = []string{BrokerKafka, BrokerNATS, BrokerAMQP}
+	pool             = newConnectionPool()
+)
+
+// Config defines the broker connection settings
+type Config struct {
+	// Broker type, see SupportedBrokers
+	Broker string
+	// Kafka: comma separated list of bootstrap brokers, for example "kafka1:9092,kafka2:9092".
+	// NATS: comma separated list of server URLs, for example "nats://127.0.0.1:4222".
+	// AMQP: server URL including the optional virtual host, for example "amqp://127.0.0.1:5672/vhost"
+	Endpoint string
+	// Username and password are sent using SASL PLAIN for Kafka and AMQP and as
+	// user info for NATS
+	Username string
+	Password string
+	// TLS enables encrypted connections. It is implied by the "tls://" NATS
+	// URL scheme and by the "amqps://" AMQP URL scheme
+	TLS           bool
+	SkipTLSVerify bool
+	// Delivery guarantee, see the above enum
+	Delivery int
+	// Number of idle connections to keep and reuse, 0 means a new connection
+	// for each message
+	PoolSize int
+}
+
+// Validate returns an error if the configuration is not valid
+func (c *Config) Validate() error {
+	if !util.Contains(SupportedBrokers, c.Broker) {
+		return fmt.Errorf("unsupported broker %q", c.Broker)
+	}
+	c.Endpoint = strings.TrimSpace(c.Endpoint)
+	if c.Endpoint == "" {
+		return errors.New("the endpoint is required")
+	}
+	if err := c.validateEndpoint(); err != nil {
+		return err
+	}
+	if c.Delivery != DeliveryAtMostOnce && c.Delivery != DeliveryAtLeastOnce {
+		return fmt.Errorf("invalid delivery guarantee %d", c.Delivery)
+	}
+	if c.PoolSize < 0 || c.PoolSize > MaxPoolSize {
+		return fmt.Errorf("invalid pool size %d, allowed range 0-%d", c.PoolSize, MaxPoolSize)
+	}
+	return nil
+}
+
+func (c *Config) validateEndpoint() error {
+	switch c.Broker {
+	case BrokerKafka:
+		for _, addr := range c.getKafkaBrokers() {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil || host == "" {
+				return fmt.Errorf("invalid Kafka broker %q, host:port is required", addr)
+			}
+			if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+				return fmt.Errorf("invalid Kafka broker %q: invalid port", addr)
+			}
+		}
+	case BrokerNATS:
+		for _, u := range strings.Split(c.Endpoint, ",") {
+			u = strings.TrimSpace(u)
+			if !util.IsStringPrefixInSlice(u, []string{"nats://", "tls://"}) {
+				return fmt.Errorf("invalid NATS URL %q, supported schemes: nats, tls", u)
+			}
+		}
+	default:
+		u, err := url.Parse(c.Endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid AMQP URL %q: %w", c.Endpoint, err)
+		}
+		if u.Scheme != "amqp" && u.Scheme != "amqps" {
+			return fmt.Errorf("invalid AMQP URL %q, supported schemes: amqp, amqps", c.Endpoint)
+		}
+		if u.Hostname() == "" {
+			return fmt.Errorf("invalid AMQP URL %q, the host is required", c.Endpoint)
+		}
+	}
+	return nil
+}
+
+func (c *Config) getKafkaBrokers() []string {
+	var result []string
+	for _, addr := range strings.Split(c.Endpoint, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr != "" {
+			result = append(result, addr)
+		}
+	}
+	return result
+}
+
+func (c *Config) getTLSConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: c.SkipTLSVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+}
+
+// dial opens a TCP connection, using the configured resolver, and wraps it
+// with TLS if required
+func (c *Config) dial(ctx context.Context, address string, useTLS bool) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: defaultTimeout}
+	conn, err := resolver.GetDialContext(dialer)(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if !useTLS {
+		return conn, nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, c.getTLSConfig(host))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	return tlsConn, nil
+}
+
+// key returns a string that uniquely identifies the connection settings
+func (c *Config) key() string {
+	h := sha256.New()
+	for _, val := range []string{c.Broker, c.Endpoint, c.Username, c.Password, strconv.FormatBool(c.TLS),
+		strconv.FormatBool(c.SkipTLSVerify), strconv.Itoa(c.Delivery)} {
+		h.Write([]byte(val))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Header defines a message header
+type Header struct {
+	Key   string
+	Value string
+}
+
+// Message defines a message to publish
+type Message struct {
+	// Kafka topic, NATS subject or AMQP routing key
+	Topic string
+	// AMQP exchange, empty means the default exchange. Ignored for the other brokers
+	Exchange string
+	// Kafka message key, used to select the partition. Ignored for the other brokers
+	Key     []byte
+	Headers []Header
+	// Content type, used for AMQP messages only
+	ContentType string
+	Body        []byte
+}
+
+type publisher interface {
+	publish(ctx context.Context, msg *Message) error
+	close() error
+}
+
+func newPublisher(ctx context.Context, config *Config) (publisher, error) {
+	switch config.Broker {
+	case BrokerKafka:
+		return newKafkaPublisher(ctx, config)
+	case BrokerNATS:
+		return newNATSPublisher(ctx, config)
+	case BrokerAMQP:
+		return newAMQPPublisher(ctx, config)
+	default:
+		return nil, fmt.Errorf("unsupported broker %q", config.Broker)
+	}
+}
+
+type idleConnection struct {
+	publisher publisher
+	lastUsed  time.Time
+}
+
+type connectionPool struct {
+	mu    sync.Mutex
+	conns map[string][]idleConnection
+}
+
+func newConnectionPool() *connectionPool {
+	return &connectionPool{
+		conns: make(map[string][]idleConnection),
+	}
+}
+
+// get returns an idle connection for the specified key, if any.
+// Expired connections are closed
+func (p *connectionPool) get(key string) publisher {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := p.conns[key]
+	for len(conns) > 0 {
+		c := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if time.Since(c.lastUsed) < idleTimeout {
+			p.conns[key] = conns
+			return c.publisher
+		}
+		go c.publisher.close() //nolint:errcheck
+	}
+	delete(p.conns, key)
+	return nil
+}
+
+// put adds the connection to the idle ones or closes it if the pool is full
+func (p *connectionPool) put(key string, size int, conn publisher) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.conns[key]) >= size {
+		go conn.close() //nolint:errcheck
+		return
+	}
+	p.conns[key] = append(p.conns[key], idleConnection{
+		publisher: conn,
+		lastUsed:  time.Now(),
+	})
+}
+
+func (p *connectionPool) closeIdle(expiredOnly bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, conns := range p.conns {
+		var active []idleConnection
+		for _, c := range conns {
+			if expiredOnly && time.Since(c.lastUsed) < idleTimeout {
+				active = append(active, c)
+				continue
+			}
+			go c.publisher.close() //nolint:errcheck
+		}
+		if len(active) == 0 {
+			delete(p.conns, key)
+		} else {
+			p.conns[key] = active
+		}
+	}
+}
+
+func (p *connectionPool) countIdle() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	count := 0
+	for _, conns := range p.conns {
+		count += len(conns)
+	}
+	return count
+}
+
+// isConnectionError returns true if the error means that the connection is
+// broken, for example because it was closed by the server while idle
+func isConnectionError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, nats.ErrConnectionClosed) ||
+		errors.Is(err, amqp.ErrClosed) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// Publish sends a message using the specified configuration. If a pool size
+// is configured the connections are reused and a publish failure caused by a
+// broken pooled connection is retried once using a new connection
+func Publish(ctx context.Context, config Config, msg *Message) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if msg.Topic == "" {
+		return errors.New("the topic is required")
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+	key := config.key()
+	if config.PoolSize > 0 {
+		pool.closeIdle(true)
+		if conn := pool.get(key); conn != nil {
+			err := conn.publish(ctx, msg)
+			if err == nil {
+				pool.put(key, config.PoolSize, conn)
+				return nil
+			}
+			conn.close() //nolint:errcheck
+			if !isConnectionError(err) {
+				return err
+			}
+			logger.Debug(logSender, "", "unable to publish using a pooled %s connection, retrying with a new one: %v",
+				config.Broker, err)
+		}
+	}
+	conn, err := newPublisher(ctx, &config)
+	if err != nil {
+		return fmt.Errorf("unable to connect to %s broker: %w", config.Broker, err)
+	}
+	if err := conn.publish(ctx, msg); err != nil {
+		conn.close() //nolint:errcheck
+		return err
+	}
+	if config.PoolSize > 0 {
+		pool.put(key, config.PoolSize, conn)
+		return nil
+	}
+	return conn.close()
+}
+
+// CloseIdleConnections closes all the pooled connections
+func CloseIdleConnections() {
+	pool.closeIdle(false)
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mq
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/drakkan/sftpgo/v2/internal/resolver"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+// natsDialer allows to use the configured resolver for NATS connections
+type natsDialer struct {
+	timeout time.Duration
+}
+
+func (d *natsDialer) Dial(network, address string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	return resolver.GetDialContext(&net.Dialer{Timeout: d.timeout})(ctx, network, address)
+}
+
+type natsPublisher struct {
+	conn *nats.Conn
+	js   nats.JetStreamContext
+}
+
+func newNATSPublisher(ctx context.Context, config *Config) (*natsPublisher, error) {
+	timeout := defaultTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	options := []nats.Option{
+		nats.Name("SFTPGo " + version.Get().Version),
+		nats.Timeout(timeout),
+		nats.NoReconnect(),
+		nats.SetCustomDialer(&natsDialer{timeout: timeout}),
+	}
+	if config.Username != "" {
+		options = append(options, nats.UserInfo(config.Username, config.Password))
+	}
+	if config.TLS || config.SkipTLSVerify {
+		options = append(options, nats.Secure(config.getTLSConfig("")))
+	}
+	conn, err := nats.Connect(config.Endpoint, options...)
+	if err != nil {
+		return nil, err
+	}
+	p := &natsPublisher{
+		conn: conn,
+	}
+	if config.Delivery == DeliveryAtLeastOnce {
+		p.js, err = conn.JetStream()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *natsPublisher) publish(ctx context.Context, msg *Message) error {
+	if !p.conn.IsConnected() {
+		return nats.ErrConnectionClosed
+	}
+	m := nats.NewMsg(msg.Topic)
+	m.Data = msg.Body
+	for _, h := range msg.Headers {
+		m.Header.Add(h.Key, h.Value)
+	}
+	if p.js != nil {
+		_, err := p.js.PublishMsg(m, nats.Context(ctx))
+		return err
+	}
+	if err := p.conn.PublishMsg(m); err != nil {
+		return err
+	}
+	// a flush only ensures that the server received the message
+	return p.conn.FlushWithContext(ctx)
+}
+
+func (p *natsPublisher) close() error {
+	p.conn.Close()
+	return nil
+}
@@ -0,0 +1,178 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	dohContentType    = "application/dns-message"
+	maxDNSMessageSize = 65535
+)
+
+var errDoHConnClosed = errors.New("DNS over HTTPS connection closed")
+
+type dohAddr struct {
+	url string
+}
+
+func (a dohAddr) Network() string {
+	return serverTypeHTTPS
+}
+
+func (a dohAddr) String() string {
+	return a.url
+}
+
+// dohConn implements a stream based net.Conn for the Go resolver.
+// Each DNS message written, prefixed with its length as for DNS over TCP,
+// is sent as an HTTP POST request as defined in RFC 8484, the response is
+// available for reading using the same framing
+type dohConn struct {
+	ctx      context.Context
+	url      string
+	client   *http.Client
+	deadline time.Time
+	request  bytes.Buffer
+	response bytes.Buffer
+	closed   bool
+}
+
+func newDoHConn(ctx context.Context, url string, timeout time.Duration, dial func(context.Context, string, DialContextFunc) (net.Conn, error)) *dohConn {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, address string) (net.Conn, error) {
+			return dial(ctx, address, defaultDialer.DialContext)
+		},
+		TLSClientConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: timeout,
+		DisableKeepAlives:   true,
+	}
+	return &dohConn{
+		ctx: ctx,
+		url: url,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		},
+	}
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	if c.closed {
+		return 0, errDoHConnClosed
+	}
+	c.request.Write(b)
+	for c.request.Len() >= 2 {
+		size := int(binary.BigEndian.Uint16(c.request.Bytes()[:2]))
+		if c.request.Len() < size+2 {
+			break
+		}
+		msg := make([]byte, size+2)
+		if _, err := io.ReadFull(&c.request, msg); err != nil {
+			return 0, err
+		}
+		if err := c.exchange(msg[2:]); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (c *dohConn) exchange(msg []byte) error {
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code from DNS over HTTPS server %q: %d", c.url, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDNSMessageSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 || len(data) > maxDNSMessageSize {
+		return fmt.Errorf("invalid response size from DNS over HTTPS server %q: %d", c.url, len(data))
+	}
+	var size [2]byte
+	binary.BigEndian.PutUint16(size[:], uint16(len(data)))
+	c.response.Write(size[:])
+	c.response.Write(data)
+	return nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.closed {
+		return 0, errDoHConnClosed
+	}
+	if c.response.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.response.Read(b)
+}
+
+func (c *dohConn) Close() error {
+	c.closed = true
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+func (c *dohConn) LocalAddr() net.Addr {
+	return dohAddr{}
+}
+
+func (c *dohConn) RemoteAddr() net.Addr {
+	return dohAddr{url: c.url}
+}
+
+func (c *dohConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *dohConn) SetReadDeadline(_ time.Time) error {
+	return nil
+}
+
+func (c *dohConn) SetWriteDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
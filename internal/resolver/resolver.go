@@ -0,0 +1,454 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package resolver provides configurable DNS resolution for outbound connections
+// such as the ones to storage backends and hook endpoints
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	logSender       = "resolver"
+	maxCacheEntries = 5000
+)
+
+// Supported DNS server types
+const (
+	serverTypeUDP   = "udp"
+	serverTypeTCP   = "tcp"
+	serverTypeTLS   = "tls"
+	serverTypeHTTPS = "https"
+)
+
+var (
+	dnsConfig *Config
+	// dialer used to connect to the DNS servers and to the resolved addresses
+	defaultDialer = &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+)
+
+// DialContextFunc defines the signature of the functions used to open network connections
+type DialContextFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// StaticHost defines a host name pinned to the specified addresses.
+// Static hosts are never resolved using DNS
+type StaticHost struct {
+	Host      string   `json:"host" mapstructure:"host"`
+	Addresses []string `json:"addresses" mapstructure:"addresses"`
+}
+
+// Config defines the DNS resolution settings for outbound connections.
+// If no setting is changed from the defaults, the system resolver is used
+// as before
+type Config struct {
+	// Servers defines the DNS servers to use instead of the system ones.
+	// Supported formats:
+	// - "ip[:port]" or "udp://ip[:port]", plain DNS over UDP, with fallback to
+	//   TCP for truncated responses. The default port is 53
+	// - "tcp://ip[:port]", plain DNS over TCP. The default port is 53
+	// - "tls://host[:port]", DNS over TLS. The default port is 853
+	// - "https://host[:port]/path", DNS over HTTPS
+	// The servers are queried in round robin order
+	Servers []string `json:"servers" mapstructure:"servers"`
+	// Timeout defines the time limit, in seconds, to connect to a DNS server
+	// and to get a response
+	Timeout int `json:"timeout" mapstructure:"timeout"`
+	// CacheTTL defines, in seconds, for how long the resolved addresses are
+	// cached. 0 means no cache. The same addresses are used for all the
+	// connections to a host until the cache entry expires, this prevents
+	// DNS rebinding attacks between a validation and the actual request
+	CacheTTL int `json:"cache_ttl" mapstructure:"cache_ttl"`
+	// NegativeCacheTTL defines, in seconds, for how long the non-existent
+	// host names are cached. 0 means no negative cache
+	NegativeCacheTTL int `json:"negative_cache_ttl" mapstructure:"negative_cache_ttl"`
+	// StaticHosts defines host names pinned to static addresses
+	StaticHosts []StaticHost `json:"static_hosts" mapstructure:"static_hosts"`
+	servers     []dnsServer
+	staticHosts map[string][]string
+	resolver    *net.Resolver
+	cache       *dnsCache
+	next        uint32
+	enabled     bool
+}
+
+// Initialize validates and applies the DNS configuration
+func (c *Config) Initialize() error {
+	if c.Timeout <= 0 {
+		return fmt.Errorf("invalid DNS timeout: %v", c.Timeout)
+	}
+	if c.CacheTTL < 0 {
+		return fmt.Errorf("invalid DNS cache TTL: %v", c.CacheTTL)
+	}
+	if c.NegativeCacheTTL < 0 {
+		return fmt.Errorf("invalid DNS negative cache TTL: %v", c.NegativeCacheTTL)
+	}
+	conf := &Config{
+		Servers:          c.Servers,
+		Timeout:          c.Timeout,
+		CacheTTL:         c.CacheTTL,
+		NegativeCacheTTL: c.NegativeCacheTTL,
+		StaticHosts:      c.StaticHosts,
+		staticHosts:      make(map[string][]string),
+		resolver:         net.DefaultResolver,
+		cache:            newDNSCache(time.Duration(c.CacheTTL)*time.Second, time.Duration(c.NegativeCacheTTL)*time.Second),
+	}
+	for _, s := range c.Servers {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		server, err := parseServer(s)
+		if err != nil {
+			return err
+		}
+		conf.servers = append(conf.servers, server)
+	}
+	for _, h := range c.StaticHosts {
+		host := normalizeHost(h.Host)
+		if host == "" {
+			return errors.New("invalid DNS static host: the host name is mandatory")
+		}
+		var addresses []string
+		for _, addr := range h.Addresses {
+			ip := net.ParseIP(strings.TrimSpace(addr))
+			if ip == nil {
+				return fmt.Errorf("invalid address %q for DNS static host %q", addr, h.Host)
+			}
+			addresses = append(addresses, ip.String())
+		}
+		if len(addresses) == 0 {
+			return fmt.Errorf("invalid DNS static host %q: at least an address is required", h.Host)
+		}
+		conf.staticHosts[host] = append(conf.staticHosts[host], addresses...)
+	}
+	if len(conf.servers) > 0 {
+		conf.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial:     conf.dialServer,
+		}
+	}
+	conf.enabled = len(conf.servers) > 0 || len(conf.staticHosts) > 0 || c.CacheTTL > 0 || c.NegativeCacheTTL > 0
+	logger.Debug(logSender, "", "DNS configuration initialized, custom servers: %d, static hosts: %d, cache TTL: %d, "+
+		"negative cache TTL: %d", len(conf.servers), len(conf.staticHosts), c.CacheTTL, c.NegativeCacheTTL)
+	dnsConfig = conf
+	return nil
+}
+
+// dialServer opens a connection to one of the configured DNS servers.
+// The Go resolver uses the packet based protocol if the returned connection
+// is a net.PacketConn and the stream based one otherwise
+func (c *Config) dialServer(ctx context.Context, network, _ string) (net.Conn, error) {
+	idx := atomic.AddUint32(&c.next, 1)
+	server := c.servers[int(idx)%len(c.servers)]
+	if server.serverType == serverTypeHTTPS {
+		return newDoHConn(ctx, server.url, time.Duration(c.Timeout)*time.Second, c.dialBootstrap), nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.Timeout)*time.Second)
+	defer cancel()
+
+	switch server.serverType {
+	case serverTypeUDP:
+		if network == "tcp" {
+			// fallback for truncated responses
+			return defaultDialer.DialContext(ctx, "tcp", server.address)
+		}
+		return defaultDialer.DialContext(ctx, "udp", server.address)
+	case serverTypeTLS:
+		d := tls.Dialer{
+			NetDialer: &net.Dialer{
+				Timeout: time.Duration(c.Timeout) * time.Second,
+			},
+			Config: &tls.Config{
+				ServerName: server.serverName,
+				MinVersion: tls.VersionTLS12,
+			},
+		}
+		return c.dialBootstrap(ctx, server.address, d.DialContext)
+	default:
+		return defaultDialer.DialContext(ctx, "tcp", server.address)
+	}
+}
+
+// dialBootstrap connects to a DNS server. Host names used for DNS servers
+// cannot be resolved using the same servers, they are resolved using the
+// static hosts, if any, or the system resolver
+func (c *Config) dialBootstrap(ctx context.Context, address string, dial DialContextFunc) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if addrs, ok := c.staticHosts[normalizeHost(host)]; ok {
+		return dialAddresses(ctx, "tcp", host, port, addrs, dial)
+	}
+	return dial(ctx, "tcp", address)
+}
+
+func (c *Config) lookupHost(ctx context.Context, host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, nil
+	}
+	name := normalizeHost(host)
+	if addrs, ok := c.staticHosts[name]; ok {
+		return addrs, nil
+	}
+	if entry, ok := c.cache.get(name); ok {
+		return entry.addrs, entry.err
+	}
+	addrs, err := c.resolver.LookupHost(ctx, host)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			c.cache.addNotFound(name, err)
+		}
+		return nil, err
+	}
+	c.cache.add(name, addrs)
+	return addrs, nil
+}
+
+// IsEnabled returns true if custom DNS settings are configured
+func IsEnabled() bool {
+	return dnsConfig.enabled
+}
+
+// LookupHost resolves the specified host using the configured DNS settings
+func LookupHost(ctx context.Context, host string) ([]string, error) {
+	if !dnsConfig.enabled {
+		return net.DefaultResolver.LookupHost(ctx, host)
+	}
+	return dnsConfig.lookupHost(ctx, host)
+}
+
+// WrapDialContext returns a dial function that resolves the host names using
+// the configured DNS settings and then connects to the resolved addresses
+// using the provided dial function. The provided dial function is used as is
+// if no custom DNS setting is configured
+func WrapDialContext(dial DialContextFunc) DialContextFunc {
+	if dial == nil {
+		dial = defaultDialer.DialContext
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conf := dnsConfig
+		if !conf.enabled {
+			return dial(ctx, network, address)
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return dial(ctx, network, address)
+		}
+		addrs, err := conf.lookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		return dialAddresses(ctx, network, host, port, addrs, dial)
+	}
+}
+
+// GetDialContext returns a dial function that uses the configured DNS settings.
+// It uses the default dialer if the provided one is nil
+func GetDialContext(dialer *net.Dialer) DialContextFunc {
+	if dialer == nil {
+		dialer = defaultDialer
+	}
+	return WrapDialContext(dialer.DialContext)
+}
+
+// dialAddresses tries the specified addresses in order and returns the first
+// established connection. The addresses are used as is, so the host is never
+// resolved again
+func dialAddresses(ctx context.Context, network, host, port string, addrs []string, dial DialContextFunc) (net.Conn, error) {
+	var firstErr error
+	for _, addr := range addrs {
+		if !isAddressAllowedForNetwork(network, addr) {
+			continue
+		}
+		conn, err := dial(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if firstErr == nil {
+		firstErr = &net.DNSError{Err: "no suitable address found", Name: host}
+	}
+	return nil, firstErr
+}
+
+func isAddressAllowedForNetwork(network, addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	switch network {
+	case "tcp4", "udp4", "ip4":
+		return ip.To4() != nil
+	case "tcp6", "udp6", "ip6":
+		return ip.To4() == nil
+	default:
+		return true
+	}
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+type dnsServer struct {
+	serverType string
+	address    string
+	serverName string
+	url        string
+}
+
+func parseServer(s string) (dnsServer, error) {
+	if !strings.Contains(s, "://") {
+		s = fmt.Sprintf("%s://%s", serverTypeUDP, s)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return dnsServer{}, fmt.Errorf("invalid DNS server %q: %w", s, err)
+	}
+	if u.Hostname() == "" {
+		return dnsServer{}, fmt.Errorf("invalid DNS server %q: the host is mandatory", s)
+	}
+	server := dnsServer{
+		serverType: u.Scheme,
+		serverName: u.Hostname(),
+	}
+	defaultPort := "53"
+	switch u.Scheme {
+	case serverTypeUDP, serverTypeTCP:
+		if net.ParseIP(u.Hostname()) == nil {
+			return dnsServer{}, fmt.Errorf("invalid DNS server %q: plain DNS servers must be specified as IP addresses", s)
+		}
+	case serverTypeTLS:
+		defaultPort = "853"
+	case serverTypeHTTPS:
+		server.url = u.String()
+		return server, nil
+	default:
+		return dnsServer{}, fmt.Errorf("invalid DNS server %q: unsupported type %q, supported types: %s", s, u.Scheme,
+			strings.Join([]string{serverTypeUDP, serverTypeTCP, serverTypeTLS, serverTypeHTTPS}, ", "))
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	server.address = net.JoinHostPort(u.Hostname(), port)
+	return server, nil
+}
+
+type dnsCacheEntry struct {
+	addrs     []string
+	err       error
+	expiresAt time.Time
+}
+
+type dnsCache struct {
+	sync.RWMutex
+	ttl         time.Duration
+	negativeTTL time.Duration
+	entries     map[string]dnsCacheEntry
+}
+
+func newDNSCache(ttl, negativeTTL time.Duration) *dnsCache {
+	return &dnsCache{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     make(map[string]dnsCacheEntry),
+	}
+}
+
+func (c *dnsCache) get(host string) (dnsCacheEntry, bool) {
+	if c.ttl <= 0 && c.negativeTTL <= 0 {
+		return dnsCacheEntry{}, false
+	}
+	c.RLock()
+	defer c.RUnlock()
+
+	entry, ok := c.entries[host]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return dnsCacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *dnsCache) add(host string, addrs []string) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.set(host, dnsCacheEntry{
+		addrs:     addrs,
+		expiresAt: time.Now().Add(c.ttl),
+	})
+}
+
+func (c *dnsCache) addNotFound(host string, err error) {
+	if c.negativeTTL <= 0 {
+		return
+	}
+	c.set(host, dnsCacheEntry{
+		err:       err,
+		expiresAt: time.Now().Add(c.negativeTTL),
+	})
+}
+
+func (c *dnsCache) set(host string, entry dnsCacheEntry) {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.entries) >= maxCacheEntries {
+		now := time.Now()
+		for k, v := range c.entries {
+			if now.After(v.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			logger.Debug(logSender, "", "DNS cache is full, removing all the entries")
+			c.entries = make(map[string]dnsCacheEntry)
+		}
+	}
+	c.entries[host] = entry
+}
+
+func init() {
+	// the system resolver is used until the configuration is initialized
+	dnsConfig = &Config{
+		Timeout:     5,
+		resolver:    net.DefaultResolver,
+		cache:       newDNSCache(0, 0),
+		staticHosts: make(map[string][]string),
+	}
+}
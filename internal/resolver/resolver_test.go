@@ -0,0 +1,353 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const testHost = "files.sftpgo.test."
+
+var testAddr = [4]byte{10, 1, 2, 3}
+
+type testDNSServer struct {
+	queries int32
+}
+
+func (s *testDNSServer) handle(b []byte) []byte {
+	var p dnsmessage.Parser
+	header, err := p.Start(b)
+	if err != nil {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+	atomic.AddInt32(&s.queries, 1)
+	header.Response = true
+	header.Authoritative = true
+	found := strings.EqualFold(q.Name.String(), testHost)
+	if !found {
+		header.RCode = dnsmessage.RCodeNameError
+	}
+	builder := dnsmessage.NewBuilder(nil, header)
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil
+	}
+	if err := builder.Question(q); err != nil {
+		return nil
+	}
+	if err := builder.StartAnswers(); err != nil {
+		return nil
+	}
+	if found && q.Type == dnsmessage.TypeA {
+		err = builder.AResource(dnsmessage.ResourceHeader{
+			Name:  q.Name,
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+			TTL:   60,
+		}, dnsmessage.AResource{A: testAddr})
+		if err != nil {
+			return nil
+		}
+	}
+	resp, err := builder.Finish()
+	if err != nil {
+		return nil
+	}
+	return resp
+}
+
+func (s *testDNSServer) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := s.handle(buf[:n]); resp != nil {
+			conn.WriteTo(resp, addr) //nolint:errcheck
+		}
+	}
+}
+
+func (s *testDNSServer) serveTCP(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(c net.Conn) {
+			defer c.Close()
+
+			for {
+				var size [2]byte
+				if _, err := io.ReadFull(c, size[:]); err != nil {
+					return
+				}
+				msg := make([]byte, binary.BigEndian.Uint16(size[:]))
+				if _, err := io.ReadFull(c, msg); err != nil {
+					return
+				}
+				resp := s.handle(msg)
+				if resp == nil {
+					return
+				}
+				binary.BigEndian.PutUint16(size[:], uint16(len(resp)))
+				if _, err := c.Write(append(size[:], resp...)); err != nil {
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
+func (s *testDNSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dohContentType {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	msg, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	resp := s.handle(msg)
+	if resp == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", dohContentType)
+	w.Write(resp) //nolint:errcheck
+}
+
+func resetConfig(t *testing.T) {
+	c := Config{
+		Timeout: 5,
+	}
+	require.NoError(t, c.Initialize())
+	assert.False(t, IsEnabled())
+}
+
+func TestInitialize(t *testing.T) {
+	c := Config{}
+	assert.Error(t, c.Initialize())
+	c.Timeout = 5
+	c.CacheTTL = -1
+	assert.Error(t, c.Initialize())
+	c.CacheTTL = 0
+	c.NegativeCacheTTL = -1
+	assert.Error(t, c.Initialize())
+	c.NegativeCacheTTL = 0
+	for _, s := range []string{"dns.google", "tcp://dns.google:53", "quic://1.1.1.1", "tls://", "udp://[::1"} {
+		c.Servers = []string{s}
+		assert.Error(t, c.Initialize(), s)
+	}
+	c.Servers = nil
+	c.StaticHosts = []StaticHost{{Host: " ", Addresses: []string{"127.0.0.1"}}}
+	assert.Error(t, c.Initialize())
+	c.StaticHosts = []StaticHost{{Host: "example.com", Addresses: []string{"invalid"}}}
+	assert.Error(t, c.Initialize())
+	c.StaticHosts = []StaticHost{{Host: "example.com"}}
+	assert.Error(t, c.Initialize())
+	c.StaticHosts = nil
+	c.Servers = []string{"", "1.1.1.1", "udp://[2606:4700:4700::1111]", "tcp://8.8.8.8:5353", "tls://dns.google",
+		"https://cloudflare-dns.com/dns-query"}
+	require.NoError(t, c.Initialize())
+	assert.True(t, IsEnabled())
+	if assert.Len(t, dnsConfig.servers, 5) {
+		assert.Equal(t, dnsServer{serverType: serverTypeUDP, address: "1.1.1.1:53", serverName: "1.1.1.1"}, dnsConfig.servers[0])
+		assert.Equal(t, "[2606:4700:4700::1111]:53", dnsConfig.servers[1].address)
+		assert.Equal(t, dnsServer{serverType: serverTypeTCP, address: "8.8.8.8:5353", serverName: "8.8.8.8"}, dnsConfig.servers[2])
+		assert.Equal(t, dnsServer{serverType: serverTypeTLS, address: "dns.google:853", serverName: "dns.google"}, dnsConfig.servers[3])
+		assert.Equal(t, serverTypeHTTPS, dnsConfig.servers[4].serverType)
+		assert.Equal(t, "https://cloudflare-dns.com/dns-query", dnsConfig.servers[4].url)
+	}
+
+	resetConfig(t)
+}
+
+func TestStaticHosts(t *testing.T) {
+	c := Config{
+		Timeout: 5,
+		StaticHosts: []StaticHost{
+			{
+				Host:      "S3.Example.com.",
+				Addresses: []string{"192.168.1.10", " ::1 "},
+			},
+		},
+	}
+	require.NoError(t, c.Initialize())
+	assert.True(t, IsEnabled())
+	addrs, err := LookupHost(context.Background(), "s3.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"192.168.1.10", "::1"}, addrs)
+	addrs, err = LookupHost(context.Background(), "10.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, addrs)
+
+	var dialed []string
+	dial := WrapDialContext(func(_ context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, network+" "+address)
+		return nil, net.UnknownNetworkError(network)
+	})
+	_, err = dial(context.Background(), "tcp", "S3.example.com:443")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"tcp 192.168.1.10:443", "tcp [::1]:443"}, dialed)
+	dialed = nil
+	_, err = dial(context.Background(), "tcp6", "s3.example.com:443")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"tcp6 [::1]:443"}, dialed)
+	dialed = nil
+	_, err = dial(context.Background(), "tcp", "invalid address")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"tcp invalid address"}, dialed)
+	// connect to a real listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	c.StaticHosts = []StaticHost{{Host: "pinned.example.com", Addresses: []string{"127.0.0.1"}}}
+	require.NoError(t, c.Initialize())
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+	conn, err := GetDialContext(nil)(context.Background(), "tcp", net.JoinHostPort("pinned.example.com", port))
+	if assert.NoError(t, err) {
+		assert.Equal(t, l.Addr().String(), conn.RemoteAddr().String())
+		assert.NoError(t, conn.Close())
+	}
+	_, err = GetDialContext(nil)(context.Background(), "tcp6", net.JoinHostPort("pinned.example.com", port))
+	var dnsErr *net.DNSError
+	assert.ErrorAs(t, err, &dnsErr)
+
+	resetConfig(t)
+}
+
+func TestCustomServers(t *testing.T) {
+	server := &testDNSServer{}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	go server.serveUDP(pc)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go server.serveTCP(l)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, s := range []string{pc.LocalAddr().String(), "tcp://" + l.Addr().String()} {
+		c := Config{
+			Timeout: 5,
+			Servers: []string{s},
+		}
+		require.NoError(t, c.Initialize())
+		addrs, err := LookupHost(ctx, testHost)
+		if assert.NoError(t, err, s) {
+			assert.Equal(t, []string{"10.1.2.3"}, addrs)
+		}
+		_, err = LookupHost(ctx, "missing.sftpgo.test.")
+		assert.Error(t, err)
+	}
+	// the cache avoids new queries
+	c := Config{
+		Timeout:          5,
+		Servers:          []string{pc.LocalAddr().String()},
+		CacheTTL:         60,
+		NegativeCacheTTL: 60,
+	}
+	require.NoError(t, c.Initialize())
+	_, err = LookupHost(ctx, testHost)
+	assert.NoError(t, err)
+	_, err = LookupHost(ctx, "missing.sftpgo.test.")
+	assert.Error(t, err)
+	queries := atomic.LoadInt32(&server.queries)
+	addrs, err := LookupHost(ctx, strings.ToUpper(testHost))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.1.2.3"}, addrs)
+	_, err = LookupHost(ctx, "missing.sftpgo.test.")
+	assert.Error(t, err)
+	assert.Equal(t, queries, atomic.LoadInt32(&server.queries))
+	// expired entries are ignored
+	entry, ok := dnsConfig.cache.get(normalizeHost(testHost))
+	require.True(t, ok)
+	entry.expiresAt = time.Now().Add(-time.Second)
+	dnsConfig.cache.set(normalizeHost(testHost), entry)
+	_, err = LookupHost(ctx, testHost)
+	assert.NoError(t, err)
+	assert.Greater(t, atomic.LoadInt32(&server.queries), queries)
+
+	resetConfig(t)
+}
+
+func TestDoH(t *testing.T) {
+	server := &testDNSServer{}
+	ts := httptest.NewTLSServer(server)
+	defer ts.Close()
+
+	dohURL := ts.URL + "/dns-query"
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			conn := newDoHConn(ctx, dohURL, 5*time.Second, dnsConfig.dialBootstrap)
+			conn.client = ts.Client()
+			return conn, nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addrs, err := r.LookupHost(ctx, testHost)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"10.1.2.3"}, addrs)
+	}
+	_, err = r.LookupHost(ctx, "missing.sftpgo.test.")
+	assert.Error(t, err)
+
+	conn := newDoHConn(ctx, ts.URL, 5*time.Second, dnsConfig.dialBootstrap)
+	conn.client = ts.Client()
+	n, err := conn.Write([]byte{0, 3, 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	_, err = conn.Write([]byte{2, 3})
+	// the server rejects invalid messages
+	assert.Error(t, err)
+	_, err = conn.Read(make([]byte, 2))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+	assert.Equal(t, serverTypeHTTPS, conn.RemoteAddr().Network())
+	assert.Equal(t, ts.URL, conn.RemoteAddr().String())
+	assert.NoError(t, conn.Close())
+	_, err = conn.Write([]byte{0, 1, 1})
+	assert.ErrorIs(t, err, errDoHConnClosed)
+	_, err = conn.Read(make([]byte, 2))
+	assert.ErrorIs(t, err, errDoHConnClosed)
+}
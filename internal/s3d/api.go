@@ -0,0 +1,180 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s3d
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// subresources we don't support, requests including them are rejected
+// instead of being handled as plain object or bucket requests
+var unsupportedSubresources = []string{"accelerate", "acl", "analytics", "attributes", "cors", "encryption",
+	"intelligent-tiering", "inventory", "legal-hold", "lifecycle", "logging", "metrics", "notification",
+	"object-lock", "ownershipControls", "policy", "policyStatus", "publicAccessBlock", "replication",
+	"requestPayment", "restore", "retention", "select", "tagging", "torrent", "versioning", "versions", "website"}
+
+type requestHandler struct {
+	config     *Configuration
+	connection *Connection
+	info       *signatureInfo
+	signingKey []byte
+}
+
+func (h *requestHandler) serve(w http.ResponseWriter, r *http.Request) {
+	bucketName, key := getBucketAndKey(r.URL.Path)
+	if bucketName == "" {
+		if r.Method == http.MethodGet {
+			h.listBuckets(w, r)
+			return
+		}
+		writeErrorResponse(w, r, errMethodNotAllowed)
+		return
+	}
+	if bucketName != h.connection.User.Username {
+		if r.Method == http.MethodPut && key == "" {
+			writeErrorResponse(w, r, newS3Error(errAccessDenied, "Bucket creation is not allowed"))
+			return
+		}
+		writeErrorResponse(w, r, errNoSuchBucket)
+		return
+	}
+	query := r.URL.Query()
+	for _, subresource := range unsupportedSubresources {
+		if query.Has(subresource) {
+			writeErrorResponse(w, r, errNotImplemented)
+			return
+		}
+	}
+	if key == "" {
+		h.serveBucket(w, r, query)
+		return
+	}
+	h.serveObject(w, r, key, query)
+}
+
+func (h *requestHandler) serveBucket(w http.ResponseWriter, r *http.Request, query url.Values) {
+	switch r.Method {
+	case http.MethodGet:
+		switch {
+		case query.Has("location"):
+			h.getBucketLocation(w, r)
+		case query.Has("uploads"):
+			writeErrorResponse(w, r, errNotImplemented)
+		case query.Get("list-type") == "2":
+			h.listObjectsV2(w, r, query)
+		default:
+			h.listObjectsV1(w, r, query)
+		}
+	case http.MethodHead:
+		writeEmptyResponse(w, r, http.StatusOK)
+	case http.MethodPut:
+		writeErrorResponse(w, r, errBucketAlreadyOwnedByYou)
+	case http.MethodPost:
+		if query.Has("delete") {
+			h.deleteObjects(w, r)
+			return
+		}
+		writeErrorResponse(w, r, errMethodNotAllowed)
+	default:
+		writeErrorResponse(w, r, errMethodNotAllowed)
+	}
+}
+
+func (h *requestHandler) serveObject(w http.ResponseWriter, r *http.Request, key string, query url.Values) {
+	switch r.Method {
+	case http.MethodGet:
+		if query.Has("uploadId") {
+			// ListParts
+			writeErrorResponse(w, r, errNotImplemented)
+			return
+		}
+		h.getObject(w, r, key, query)
+	case http.MethodHead:
+		h.getObject(w, r, key, query)
+	case http.MethodPut:
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			writeErrorResponse(w, r, errNotImplemented)
+			return
+		}
+		if query.Has("uploadId") {
+			h.uploadPart(w, r, key, query)
+			return
+		}
+		h.putObject(w, r, key)
+	case http.MethodPost:
+		switch {
+		case query.Has("uploads"):
+			h.createMultipartUpload(w, r, key)
+		case query.Has("uploadId"):
+			h.completeMultipartUpload(w, r, key, query.Get("uploadId"))
+		default:
+			writeErrorResponse(w, r, errMethodNotAllowed)
+		}
+	case http.MethodDelete:
+		if query.Has("uploadId") {
+			h.abortMultipartUpload(w, r, key, query.Get("uploadId"))
+			return
+		}
+		h.deleteObject(w, r, key)
+	default:
+		writeErrorResponse(w, r, errMethodNotAllowed)
+	}
+}
+
+func (h *requestHandler) listBuckets(w http.ResponseWriter, r *http.Request) {
+	user := &h.connection.User
+	resp := listAllMyBucketsResult{
+		Xmlns: s3Namespace,
+		Owner: owner{
+			ID:          user.Username,
+			DisplayName: user.Username,
+		},
+		Buckets: []bucket{
+			{
+				Name:         user.Username,
+				CreationDate: formatS3Time(util.GetTimeFromMsecSinceEpoch(user.CreatedAt)),
+			},
+		},
+	}
+	writeXMLResponse(w, r, http.StatusOK, resp)
+}
+
+func (h *requestHandler) getBucketLocation(w http.ResponseWriter, r *http.Request) {
+	resp := locationConstraint{
+		Xmlns:  s3Namespace,
+		Region: h.config.getRegion(),
+	}
+	writeXMLResponse(w, r, http.StatusOK, resp)
+}
+
+// getBucketAndKey returns the bucket and the object key from a path style request
+func getBucketAndKey(p string) (string, string) {
+	bucketName, key, _ := strings.Cut(strings.TrimPrefix(p, "/"), "/")
+	return bucketName, key
+}
+
+// getVirtualPath returns the SFTPGo virtual path for the specified key,
+// a trailing slash identifies a directory
+func getVirtualPath(key string) (string, error) {
+	p := "/" + strings.TrimSuffix(key, "/")
+	if p == "/" || util.CleanPath(p) != p {
+		return "", newS3Error(errInvalidArgument, "Invalid object key")
+	}
+	return p, nil
+}
@@ -0,0 +1,273 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s3d
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	defaultMaxKeys = 1000
+	listDelimiter  = "/"
+	storageClass   = "STANDARD"
+)
+
+var errListLimitReached = errors.New("list limit reached")
+
+type listEntry struct {
+	key      string
+	info     os.FileInfo
+	isPrefix bool
+}
+
+// objectLister lists the objects in lexical key order, as S3 does, walking the
+// user directories sorted by name, a trailing slash is added to directory names.
+// Directories are listed as common prefixes if the "/" delimiter is requested,
+// empty directories are not listed otherwise
+type objectLister struct {
+	connection *Connection
+	prefix     string
+	delimiter  string
+	marker     string
+	maxKeys    int
+	entries    []listEntry
+}
+
+func (l *objectLister) list() ([]listEntry, bool, error) {
+	if l.maxKeys == 0 {
+		return nil, false, nil
+	}
+	err := l.walk("/", "")
+	if err == errListLimitReached {
+		return l.entries[:l.maxKeys], true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return l.entries, false, nil
+}
+
+func (l *objectLister) add(entry listEntry) error {
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > l.maxKeys {
+		return errListLimitReached
+	}
+	return nil
+}
+
+func (l *objectLister) walk(dirPath, dirKey string) error {
+	files, err := l.connection.ListDir(dirPath)
+	if err != nil {
+		if dirPath == "/" {
+			return err
+		}
+		if l.connection.IsNotExistError(err) || errors.Is(err, os.ErrPermission) {
+			l.connection.Log(logger.LevelDebug, "skipping directory %#v while listing objects: %v", dirPath, err)
+			return nil
+		}
+		return err
+	}
+	entries := make([]listEntry, 0, len(files))
+	for _, info := range files {
+		key := dirKey + info.Name()
+		if info.IsDir() {
+			key += listDelimiter
+		}
+		entries = append(entries, listEntry{
+			key:  key,
+			info: info,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	for _, entry := range entries {
+		if entry.info.IsDir() {
+			if err := l.handleDir(entry); err != nil {
+				return err
+			}
+			continue
+		}
+		if entry.key > l.marker && strings.HasPrefix(entry.key, l.prefix) {
+			if err := l.add(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (l *objectLister) handleDir(entry listEntry) error {
+	if !strings.HasPrefix(entry.key, l.prefix) && !strings.HasPrefix(l.prefix, entry.key) {
+		return nil
+	}
+	// all the keys inside this directory sort before the marker
+	if entry.key <= l.marker && !strings.HasPrefix(l.marker, entry.key) {
+		return nil
+	}
+	if l.delimiter != "" && strings.HasPrefix(entry.key, l.prefix) && entry.key != l.prefix {
+		if strings.HasPrefix(l.marker, entry.key) {
+			return nil
+		}
+		entry.isPrefix = true
+		return l.add(entry)
+	}
+	return l.walk("/"+strings.TrimSuffix(entry.key, listDelimiter), entry.key)
+}
+
+type listObjectsParams struct {
+	prefix       string
+	delimiter    string
+	maxKeys      int
+	encodingType string
+}
+
+func parseListObjectsParams(query url.Values) (listObjectsParams, error) {
+	params := listObjectsParams{
+		prefix:       query.Get("prefix"),
+		delimiter:    query.Get("delimiter"),
+		maxKeys:      defaultMaxKeys,
+		encodingType: query.Get("encoding-type"),
+	}
+	if params.delimiter != "" && params.delimiter != listDelimiter {
+		return params, newS3Error(errNotImplemented, "Only the \"/\" delimiter is supported")
+	}
+	if params.encodingType != "" && params.encodingType != "url" {
+		return params, newS3Error(errInvalidArgument, "Invalid Encoding Method specified in Request")
+	}
+	if value := query.Get("max-keys"); value != "" {
+		maxKeys, err := strconv.Atoi(value)
+		if err != nil || maxKeys < 0 {
+			return params, newS3Error(errInvalidArgument, "Invalid max-keys")
+		}
+		if maxKeys < defaultMaxKeys {
+			params.maxKeys = maxKeys
+		}
+	}
+	return params, nil
+}
+
+func (p *listObjectsParams) encode(value string) string {
+	if p.encodingType == "url" {
+		return url.QueryEscape(value)
+	}
+	return value
+}
+
+func (p *listObjectsParams) fillResult(result *listBucketResult, entries []listEntry) {
+	result.Xmlns = s3Namespace
+	result.Prefix = p.encode(p.prefix)
+	result.Delimiter = p.encode(p.delimiter)
+	result.MaxKeys = p.maxKeys
+	result.EncodingType = p.encodingType
+	for _, entry := range entries {
+		if entry.isPrefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: p.encode(entry.key)})
+			continue
+		}
+		result.Contents = append(result.Contents, object{
+			Key:          p.encode(entry.key),
+			LastModified: formatS3Time(entry.info.ModTime()),
+			ETag:         getETag(getPseudoETag(entry.info)),
+			Size:         entry.info.Size(),
+			StorageClass: storageClass,
+		})
+	}
+}
+
+func (h *requestHandler) listObjectsV1(w http.ResponseWriter, r *http.Request, query url.Values) {
+	params, err := parseListObjectsParams(query)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	marker := query.Get("marker")
+	lister := objectLister{
+		connection: h.connection,
+		prefix:     params.prefix,
+		delimiter:  params.delimiter,
+		marker:     marker,
+		maxKeys:    params.maxKeys,
+	}
+	entries, isTruncated, err := lister.list()
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	encodedMarker := params.encode(marker)
+	result := listBucketResult{
+		Name:        h.connection.User.Username,
+		Marker:      &encodedMarker,
+		IsTruncated: isTruncated,
+	}
+	params.fillResult(&result, entries)
+	if isTruncated {
+		result.NextMarker = params.encode(entries[len(entries)-1].key)
+	}
+	writeXMLResponse(w, r, http.StatusOK, result)
+}
+
+func (h *requestHandler) listObjectsV2(w http.ResponseWriter, r *http.Request, query url.Values) {
+	params, err := parseListObjectsParams(query)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	continuationToken := query.Get("continuation-token")
+	startAfter := query.Get("start-after")
+	marker := startAfter
+	if query.Has("continuation-token") {
+		token, err := base64.RawURLEncoding.DecodeString(continuationToken)
+		if err != nil || len(token) == 0 {
+			writeErrorResponse(w, r, newS3Error(errInvalidArgument, "The continuation token provided is incorrect"))
+			return
+		}
+		marker = string(token)
+	}
+	lister := objectLister{
+		connection: h.connection,
+		prefix:     params.prefix,
+		delimiter:  params.delimiter,
+		marker:     marker,
+		maxKeys:    params.maxKeys,
+	}
+	entries, isTruncated, err := lister.list()
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	keyCount := len(entries)
+	result := listBucketResult{
+		Name:              h.connection.User.Username,
+		ContinuationToken: continuationToken,
+		StartAfter:        params.encode(startAfter),
+		KeyCount:          &keyCount,
+		IsTruncated:       isTruncated,
+	}
+	params.fillResult(&result, entries)
+	if isTruncated {
+		result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(entries[len(entries)-1].key))
+	}
+	writeXMLResponse(w, r, http.StatusOK, result)
+}
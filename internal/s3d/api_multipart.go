@@ -0,0 +1,211 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s3d
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+func (h *requestHandler) getMultipartUpload(key, uploadID string) (*multipartUpload, error) {
+	if multipartMgr == nil {
+		return nil, errNoSuchUpload
+	}
+	return multipartMgr.get(uploadID, h.connection.User.Username, key)
+}
+
+func (h *requestHandler) createMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	vpath, err := getVirtualPath(key)
+	if err != nil || strings.HasSuffix(key, "/") {
+		writeErrorResponse(w, r, newS3Error(errInvalidArgument, "Invalid object key"))
+		return
+	}
+	// early permissions check, they will be checked again when the upload is completed
+	if !h.connection.User.HasAnyPerm([]string{dataprovider.PermUpload, dataprovider.PermOverwrite}, path.Dir(vpath)) {
+		writeErrorResponse(w, r, h.connection.GetPermissionDeniedError())
+		return
+	}
+	if ok, _ := h.connection.User.IsFileAllowed(vpath); !ok {
+		writeErrorResponse(w, r, h.connection.GetPermissionDeniedError())
+		return
+	}
+	upload, err := multipartMgr.create(h.connection.User.Username, key)
+	if err != nil {
+		h.connection.Log(logger.LevelError, "unable to create multipart upload for key %#v: %v", key, err)
+		writeErrorResponse(w, r, errInternalError)
+		return
+	}
+	h.connection.Log(logger.LevelDebug, "multipart upload %#v created for key %#v", upload.id, key)
+	writeXMLResponse(w, r, http.StatusOK, initiateMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Bucket:   h.connection.User.Username,
+		Key:      key,
+		UploadID: upload.id,
+	})
+}
+
+func (h *requestHandler) uploadPart(w http.ResponseWriter, r *http.Request, key string, query url.Values) {
+	upload, err := h.getMultipartUpload(key, query.Get("uploadId"))
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		writeErrorResponse(w, r, newS3Error(errInvalidArgument,
+			"Part number must be an integer between 1 and 10000, inclusive"))
+		return
+	}
+	payload, err := newPayloadReader(r, h.info, h.signingKey)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	vpath, err := getVirtualPath(key)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	var maxSize int64
+	if maxUploadSize := h.connection.User.GetMaxUploadFileSize(vpath); maxUploadSize > 0 {
+		maxSize = maxUploadSize - upload.getSizeExcludingPart(partNumber)
+		if maxSize <= 0 || payload.getSize() > maxSize {
+			writeErrorResponse(w, r, errEntityTooLarge)
+			return
+		}
+	}
+	h.connection.UpdateLastActivity()
+	etag, err := upload.writePart(partNumber, payload, maxSize)
+	if err != nil {
+		h.connection.Log(logger.LevelDebug, "unable to write part %d for multipart upload %#v: %v",
+			partNumber, upload.id, err)
+		if _, ok := err.(*s3Error); !ok {
+			err = errInternalError
+		}
+		writeErrorResponse(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", getETag(etag))
+	writeEmptyResponse(w, r, http.StatusOK)
+}
+
+func (h *requestHandler) completeMultipartUpload(w http.ResponseWriter, r *http.Request, key, uploadID string) {
+	upload, err := h.getMultipartUpload(key, uploadID)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	var req completeMultipartUpload
+	if err := readXMLRequest(r, h.info, h.signingKey, &req); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	parts, err := upload.getCompletedParts(req.Parts)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	vpath, err := getVirtualPath(key)
+	if err != nil {
+		upload.resetCompleting()
+		writeErrorResponse(w, r, err)
+		return
+	}
+	reader := newPartsReader(upload, parts)
+	_, err = h.writeObject(vpath, reader)
+	reader.Close() //nolint:errcheck
+	if err != nil {
+		upload.resetCompleting()
+		writeErrorResponse(w, r, err)
+		return
+	}
+	multipartMgr.remove(upload.id)
+	etag := getMultipartETag(parts)
+	w.Header().Set("ETag", getETag(etag))
+	writeXMLResponse(w, r, http.StatusOK, completeMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Location: path.Join("/", h.connection.User.Username, key),
+		Bucket:   h.connection.User.Username,
+		Key:      key,
+		ETag:     getETag(etag),
+	})
+}
+
+func (h *requestHandler) abortMultipartUpload(w http.ResponseWriter, r *http.Request, key, uploadID string) {
+	upload, err := h.getMultipartUpload(key, uploadID)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	multipartMgr.remove(upload.id)
+	writeEmptyResponse(w, r, http.StatusNoContent)
+}
+
+// partsReader reads the staged parts sequentially
+type partsReader struct {
+	upload  *multipartUpload
+	parts   []uploadedPart
+	current *os.File
+}
+
+func newPartsReader(upload *multipartUpload, parts []uploadedPart) *partsReader {
+	return &partsReader{
+		upload: upload,
+		parts:  parts,
+	}
+}
+
+func (p *partsReader) Read(buf []byte) (int, error) {
+	for {
+		if p.current == nil {
+			if len(p.parts) == 0 {
+				return 0, io.EOF
+			}
+			f, err := os.Open(p.upload.getPartPath(p.parts[0].number))
+			if err != nil {
+				return 0, err
+			}
+			p.current = f
+			p.parts = p.parts[1:]
+		}
+		n, err := p.current.Read(buf)
+		if err == io.EOF {
+			p.current.Close()
+			p.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (p *partsReader) Close() error {
+	if p.current != nil {
+		err := p.current.Close()
+		p.current = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s3d
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	maxDeleteObjects    = 1000
+	maxXMLRequestSize   = 2 * 1024 * 1024
+	emptyMD5            = "d41d8cd98f00b204e9800998ecf8427e"
+	defaultContentType  = "application/octet-stream"
+	rangeRequestHeader  = "Range"
+	rangeResponseHeader = "Content-Range"
+)
+
+// response headers that can be overridden using query parameters, for example in presigned URLs
+var responseOverrides = map[string]string{
+	"response-cache-control":       "Cache-Control",
+	"response-content-disposition": "Content-Disposition",
+	"response-content-encoding":    "Content-Encoding",
+	"response-content-language":    "Content-Language",
+	"response-content-type":        "Content-Type",
+	"response-expires":             "Expires",
+}
+
+// getPseudoETag returns an ETag based on the modification time and the size.
+// The "-" suffix, as for multipart uploads, tells the clients that this is not an MD5
+func getPseudoETag(info os.FileInfo) string {
+	h := md5.Sum([]byte(fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())))
+	return hex.EncodeToString(h[:]) + "-1"
+}
+
+func getContentType(name string) string {
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		return defaultContentType
+	}
+	return ctype
+}
+
+// parseRange parses a single byte range. Invalid or multiple ranges are ignored,
+// as S3 does, and the whole object is returned
+func parseRange(value string, size int64) (int64, int64, bool, error) {
+	if !strings.HasPrefix(value, "bytes=") || strings.Contains(value, ",") {
+		return 0, size, false, nil
+	}
+	startValue, endValue, ok := strings.Cut(strings.TrimSpace(value[6:]), "-")
+	if !ok {
+		return 0, size, false, nil
+	}
+	if startValue == "" {
+		suffixLength, err := strconv.ParseInt(endValue, 10, 64)
+		if err != nil || suffixLength < 0 {
+			return 0, size, false, nil
+		}
+		if suffixLength == 0 || size == 0 {
+			return 0, 0, false, errInvalidRange
+		}
+		if suffixLength > size {
+			suffixLength = size
+		}
+		return size - suffixLength, suffixLength, true, nil
+	}
+	start, err := strconv.ParseInt(startValue, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, false, nil
+	}
+	end := size - 1
+	if endValue != "" {
+		end, err = strconv.ParseInt(endValue, 10, 64)
+		if err != nil || end < start {
+			return 0, size, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, false, errInvalidRange
+	}
+	return start, end - start + 1, true, nil
+}
+
+// checkPreconditions evaluates the conditional request headers and returns true
+// if the response was already sent
+func checkPreconditions(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) bool {
+	modTime = modTime.Truncate(time.Second)
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if trimETag(ifMatch) != etag && ifMatch != "*" {
+			writeErrorResponse(w, r, errPreconditionFailed)
+			return true
+		}
+	} else if ifUnmodifiedSince := r.Header.Get("If-Unmodified-Since"); ifUnmodifiedSince != "" {
+		if t, err := http.ParseTime(ifUnmodifiedSince); err == nil && modTime.After(t) {
+			writeErrorResponse(w, r, errPreconditionFailed)
+			return true
+		}
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if trimETag(ifNoneMatch) == etag || ifNoneMatch == "*" {
+			w.Header().Set("ETag", getETag(etag))
+			writeEmptyResponse(w, r, http.StatusNotModified)
+			return true
+		}
+	} else if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		if t, err := http.ParseTime(ifModifiedSince); err == nil && !modTime.After(t) {
+			w.Header().Set("ETag", getETag(etag))
+			writeEmptyResponse(w, r, http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+func (h *requestHandler) getObject(w http.ResponseWriter, r *http.Request, key string, query url.Values) {
+	vpath, err := getVirtualPath(key)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	info, err := h.connection.Stat(vpath)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	isDirMarker := strings.HasSuffix(key, "/")
+	if info.IsDir() != isDirMarker {
+		writeErrorResponse(w, r, errNoSuchKey)
+		return
+	}
+	etag := getPseudoETag(info)
+	if checkPreconditions(w, r, etag, info.ModTime()) {
+		return
+	}
+	size := info.Size()
+	if isDirMarker {
+		size = 0
+	}
+	offset, length, isPartial, err := parseRange(r.Header.Get(rangeRequestHeader), size)
+	if err != nil {
+		w.Header().Set(rangeResponseHeader, fmt.Sprintf("bytes */%d", size))
+		writeErrorResponse(w, r, err)
+		return
+	}
+	var reader *s3dFile
+	if r.Method == http.MethodGet && !isDirMarker {
+		reader, err = h.connection.getFileReader(vpath, offset, r.Method)
+		if err != nil {
+			writeErrorResponse(w, r, err)
+			return
+		}
+		defer reader.Close()
+	}
+
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	w.Header().Set("ETag", getETag(etag))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Type", getContentType(vpath))
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	if r.Method == http.MethodGet {
+		for param, header := range responseOverrides {
+			if value := query.Get(param); value != "" {
+				w.Header().Set(header, value)
+			}
+		}
+	}
+	status := http.StatusOK
+	if isPartial {
+		status = http.StatusPartialContent
+		w.Header().Set(rangeResponseHeader, fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+	}
+	w.WriteHeader(status)
+	if reader != nil {
+		if _, err := io.CopyN(w, reader, length); err != nil {
+			h.connection.Log(logger.LevelDebug, "unable to send object %#v: %v", key, err)
+			writeLog(r, status, err)
+			return
+		}
+	}
+	writeLog(r, status, nil)
+}
+
+func (h *requestHandler) putObject(w http.ResponseWriter, r *http.Request, key string) {
+	vpath, err := getVirtualPath(key)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	payload, err := newPayloadReader(r, h.info, h.signingKey)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if strings.HasSuffix(key, "/") {
+		h.createDirMarker(w, r, vpath, payload)
+		return
+	}
+	if maxSize := h.connection.User.GetMaxUploadFileSize(vpath); maxSize > 0 && payload.getSize() > maxSize {
+		writeErrorResponse(w, r, errEntityTooLarge)
+		return
+	}
+	etag, err := h.writeObject(vpath, payload)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", getETag(etag))
+	writeEmptyResponse(w, r, http.StatusOK)
+}
+
+func (h *requestHandler) createDirMarker(w http.ResponseWriter, r *http.Request, vpath string, payload *payloadReader) {
+	if payload.getSize() > 0 {
+		writeErrorResponse(w, r, newS3Error(errInvalidArgument, "Directory objects cannot have contents"))
+		return
+	}
+	if _, err := io.Copy(io.Discard, payload); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if err := h.connection.CheckParentDirs(vpath); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", getETag(emptyMD5))
+	writeEmptyResponse(w, r, http.StatusOK)
+}
+
+// writeObject writes the contents read from reader to the specified virtual path and
+// returns the MD5 of the written contents as hex string
+func (h *requestHandler) writeObject(vpath string, reader io.Reader) (string, error) {
+	if parent := path.Dir(vpath); parent != "/" {
+		if err := h.connection.CheckParentDirs(parent); err != nil {
+			return "", err
+		}
+	}
+	writer, err := h.connection.getFileWriter(vpath)
+	if err != nil {
+		return "", err
+	}
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(writer, hash), reader)
+	if err != nil {
+		writer.TransferError(err)
+		writer.Close() //nolint:errcheck
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// removeObject removes the object with the specified key, removing missing objects
+// is not an error
+func (h *requestHandler) removeObject(key string) error {
+	vpath, err := getVirtualPath(key)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(key, "/") {
+		// removing a directory marker has no effect on the objects inside the directory
+		contents, err := h.connection.ListDir(vpath)
+		if err != nil {
+			if h.connection.IsNotExistError(err) {
+				return nil
+			}
+			return err
+		}
+		if len(contents) > 0 {
+			return nil
+		}
+		return h.connection.RemoveDir(vpath)
+	}
+	fs, fsPath, err := h.connection.GetFsAndResolvedPath(vpath)
+	if err != nil {
+		return err
+	}
+	info, err := fs.Lstat(fsPath)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return nil
+		}
+		return h.connection.GetFsError(fs, err)
+	}
+	if info.IsDir() {
+		return nil
+	}
+	return h.connection.RemoveFile(fs, fsPath, vpath, info)
+}
+
+func (h *requestHandler) deleteObject(w http.ResponseWriter, r *http.Request, key string) {
+	if err := h.removeObject(key); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	writeEmptyResponse(w, r, http.StatusNoContent)
+}
+
+func readXMLRequest(r *http.Request, info *signatureInfo, signingKey []byte, v any) error {
+	payload, err := newPayloadReader(r, info, signingKey)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(payload, maxXMLRequestSize))
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return errMalformedXML
+	}
+	return nil
+}
+
+func (h *requestHandler) deleteObjects(w http.ResponseWriter, r *http.Request) {
+	var req deleteRequest
+	if err := readXMLRequest(r, h.info, h.signingKey, &req); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if len(req.Objects) == 0 || len(req.Objects) > maxDeleteObjects {
+		writeErrorResponse(w, r, errMalformedXML)
+		return
+	}
+	resp := deleteResult{
+		Xmlns: s3Namespace,
+	}
+	for _, obj := range req.Objects {
+		if err := h.removeObject(obj.Key); err != nil {
+			s3Err := getS3Error(err)
+			resp.Errors = append(resp.Errors, deleteError{
+				Key:     obj.Key,
+				Code:    s3Err.code,
+				Message: s3Err.message,
+			})
+			continue
+		}
+		if !req.Quiet {
+			resp.Deleted = append(resp.Deleted, deletedObject{Key: obj.Key})
+		}
+	}
+	writeXMLResponse(w, r, http.StatusOK, resp)
+}
@@ -0,0 +1,323 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s3d
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	multipartCleanupInterval = 10 * time.Minute
+	maxPartNumber            = 10000
+)
+
+var (
+	cleanupTicker *time.Ticker
+	cleanupDone   chan bool
+)
+
+type uploadedPart struct {
+	number int
+	etag   string
+	size   int64
+}
+
+// multipartUpload defines an in progress multipart upload.
+// Uploaded parts are staged in a local directory and are written
+// to the user filesystem when the upload is completed
+type multipartUpload struct {
+	sync.Mutex
+	id           string
+	username     string
+	key          string
+	dir          string
+	createdAt    time.Time
+	parts        map[int]uploadedPart
+	isCompleting bool
+}
+
+func (u *multipartUpload) getPartPath(number int) string {
+	return filepath.Join(u.dir, strconv.Itoa(number))
+}
+
+// getSizeExcludingPart returns the size of the uploaded parts, excluding the specified one
+func (u *multipartUpload) getSizeExcludingPart(number int) int64 {
+	u.Lock()
+	defer u.Unlock()
+
+	var size int64
+	for n, part := range u.parts {
+		if n != number {
+			size += part.size
+		}
+	}
+	return size
+}
+
+// writePart stores the specified part and returns its MD5 as hex string
+func (u *multipartUpload) writePart(number int, reader io.Reader, maxSize int64) (string, error) {
+	tmpPath := filepath.Join(u.dir, fmt.Sprintf("%d.%s", number, xid.New().String()))
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	h := md5.New()
+	if maxSize > 0 {
+		// read one more byte to detect that the limit was exceeded
+		reader = io.LimitReader(reader, maxSize+1)
+	}
+	written, err := io.Copy(io.MultiWriter(f, h), reader)
+	errClose := f.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err == nil && maxSize > 0 && written > maxSize {
+		err = errEntityTooLarge
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	u.Lock()
+	defer u.Unlock()
+
+	if u.isCompleting {
+		os.Remove(tmpPath)
+		return "", errNoSuchUpload
+	}
+	if err := os.Rename(tmpPath, u.getPartPath(number)); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	etag := hex.EncodeToString(h.Sum(nil))
+	u.parts[number] = uploadedPart{
+		number: number,
+		etag:   etag,
+		size:   written,
+	}
+	return etag, nil
+}
+
+// getCompletedParts validates the parts requested to complete the upload
+// and marks the upload as completing
+func (u *multipartUpload) getCompletedParts(requested []completedPart) ([]uploadedPart, error) {
+	u.Lock()
+	defer u.Unlock()
+
+	if u.isCompleting {
+		return nil, errNoSuchUpload
+	}
+	if len(requested) == 0 {
+		return nil, errMalformedXML
+	}
+	parts := make([]uploadedPart, 0, len(requested))
+	for idx, p := range requested {
+		if idx > 0 && p.PartNumber <= requested[idx-1].PartNumber {
+			return nil, errInvalidPartOrder
+		}
+		part, ok := u.parts[p.PartNumber]
+		if !ok || part.etag != trimETag(p.ETag) {
+			return nil, errInvalidPart
+		}
+		parts = append(parts, part)
+	}
+	u.isCompleting = true
+	return parts, nil
+}
+
+// resetCompleting allows to retry a failed completion
+func (u *multipartUpload) resetCompleting() {
+	u.Lock()
+	defer u.Unlock()
+
+	u.isCompleting = false
+}
+
+func trimETag(etag string) string {
+	if len(etag) >= 2 && etag[0] == '"' && etag[len(etag)-1] == '"' {
+		return etag[1 : len(etag)-1]
+	}
+	return etag
+}
+
+// getMultipartETag returns the ETag for a completed multipart upload, it is the MD5
+// of the concatenated binary MD5 of the parts followed by the number of parts
+func getMultipartETag(parts []uploadedPart) string {
+	h := md5.New()
+	for _, p := range parts {
+		b, err := hex.DecodeString(p.etag)
+		if err == nil {
+			h.Write(b)
+		}
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(h.Sum(nil)), len(parts))
+}
+
+type multipartManager struct {
+	sync.RWMutex
+	baseDir    string
+	expiration time.Duration
+	uploads    map[string]*multipartUpload
+}
+
+func newMultipartManager(expiration int) (*multipartManager, error) {
+	tempDir := vfs.GetTempPath()
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	baseDir := filepath.Join(tempDir, logSender)
+	if err := os.MkdirAll(baseDir, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create the multipart uploads dir %#v: %w", baseDir, err)
+	}
+	return &multipartManager{
+		baseDir:    baseDir,
+		expiration: time.Duration(expiration) * time.Minute,
+		uploads:    make(map[string]*multipartUpload),
+	}, nil
+}
+
+func (m *multipartManager) create(username, key string) (*multipartUpload, error) {
+	id := xid.New().String()
+	upload := &multipartUpload{
+		id:        id,
+		username:  username,
+		key:       key,
+		dir:       filepath.Join(m.baseDir, id),
+		createdAt: time.Now(),
+		parts:     make(map[int]uploadedPart),
+	}
+	if err := os.Mkdir(upload.dir, 0700); err != nil {
+		return nil, err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.uploads[id] = upload
+	return upload, nil
+}
+
+func (m *multipartManager) get(id, username, key string) (*multipartUpload, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	upload, ok := m.uploads[id]
+	if !ok || upload.username != username || upload.key != key {
+		return nil, errNoSuchUpload
+	}
+	return upload, nil
+}
+
+func (m *multipartManager) remove(id string) {
+	m.Lock()
+	upload, ok := m.uploads[id]
+	delete(m.uploads, id)
+	m.Unlock()
+
+	if ok {
+		if err := os.RemoveAll(upload.dir); err != nil {
+			logger.Warn(logSender, "", "unable to remove multipart upload dir %#v: %v", upload.dir, err)
+		}
+	}
+}
+
+func (m *multipartManager) count() int {
+	m.RLock()
+	defer m.RUnlock()
+
+	return len(m.uploads)
+}
+
+// cleanup removes the expired uploads and any leftover staging directory,
+// for example the ones created before a restart
+func (m *multipartManager) cleanup() {
+	var expired []string
+
+	m.RLock()
+	for id, upload := range m.uploads {
+		if time.Since(upload.createdAt) > m.expiration {
+			expired = append(expired, id)
+		}
+	}
+	m.RUnlock()
+
+	sort.Strings(expired)
+	for _, id := range expired {
+		logger.Debug(logSender, "", "removing expired multipart upload %#v", id)
+		m.remove(id)
+	}
+
+	entries, err := os.ReadDir(m.baseDir)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to read multipart uploads dir %#v: %v", m.baseDir, err)
+		return
+	}
+	for _, entry := range entries {
+		m.RLock()
+		_, ok := m.uploads[entry.Name()]
+		m.RUnlock()
+		if ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < m.expiration {
+			continue
+		}
+		p := filepath.Join(m.baseDir, entry.Name())
+		logger.Debug(logSender, "", "removing leftover multipart upload dir %#v", p)
+		os.RemoveAll(p) //nolint:errcheck
+	}
+}
+
+// the ticker cannot be started/stopped from multiple goroutines
+func startCleanupTicker(duration time.Duration) {
+	stopCleanupTicker()
+	cleanupTicker = time.NewTicker(duration)
+	cleanupDone = make(chan bool)
+
+	go func() {
+		logger.Debug(logSender, "", "start multipart uploads cleanup ticker")
+		for {
+			select {
+			case <-cleanupDone:
+				return
+			case <-cleanupTicker.C:
+				multipartMgr.cleanup()
+			}
+		}
+	}()
+}
+
+func stopCleanupTicker() {
+	if cleanupTicker != nil {
+		cleanupTicker.Stop()
+		cleanupDone <- true
+		cleanupTicker = nil
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s3d
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxChunkSize    = 16 * 1024 * 1024
+	chunkSignature  = "chunk-signature="
+	maxChunkLineLen = 4096
+)
+
+var errMalformedChunk = newS3Error(errIncompleteBody, "malformed chunked encoding")
+
+// chunkedReader decodes aws-chunked encoded payloads, for signed payloads
+// the signature of each chunk is verified
+type chunkedReader struct {
+	reader        *bufio.Reader
+	info          *signatureInfo
+	signingKey    []byte
+	prevSignature string
+	buffer        []byte
+	offset        int
+	done          bool
+	err           error
+}
+
+func newChunkedReader(r io.Reader, info *signatureInfo, signingKey []byte) *chunkedReader {
+	return &chunkedReader{
+		reader:        bufio.NewReaderSize(r, maxChunkLineLen),
+		info:          info,
+		signingKey:    signingKey,
+		prevSignature: info.signature,
+	}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.offset >= len(c.buffer) {
+		if c.err != nil {
+			return 0, c.err
+		}
+		if c.done {
+			return 0, io.EOF
+		}
+		c.err = c.readChunk()
+	}
+	n := copy(p, c.buffer[c.offset:])
+	c.offset += n
+	return n, nil
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.reader.ReadSlice('\n')
+	if err != nil {
+		if err == io.EOF || errors.Is(err, bufio.ErrBufferFull) {
+			return "", errMalformedChunk
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+func (c *chunkedReader) readChunk() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	sizeHex, params, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return errMalformedChunk
+	}
+	if int64(cap(c.buffer)) < size {
+		c.buffer = make([]byte, size)
+	}
+	c.buffer = c.buffer[:size]
+	c.offset = 0
+	if _, err := io.ReadFull(c.reader, c.buffer); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errMalformedChunk
+		}
+		return err
+	}
+	if c.signingKey != nil {
+		if !strings.HasPrefix(params, chunkSignature) {
+			return errMalformedChunk
+		}
+		if err := c.verifyChunk(strings.TrimPrefix(params, chunkSignature)); err != nil {
+			return err
+		}
+	}
+	if size == 0 {
+		c.done = true
+		return c.readTrailer()
+	}
+	line, err = c.readLine()
+	if err != nil {
+		return err
+	}
+	if line != "" {
+		return errMalformedChunk
+	}
+	return nil
+}
+
+// readTrailer consumes the trailing headers, if any, and the final CRLF.
+// Trailing checksums are not verified
+func (c *chunkedReader) readTrailer() error {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			if c.signingKey == nil && err == errMalformedChunk {
+				// some clients omit the final CRLF for unsigned payloads
+				return nil
+			}
+			return err
+		}
+		if line == "" {
+			return nil
+		}
+	}
+}
+
+func (c *chunkedReader) verifyChunk(signature string) error {
+	stringToSign := strings.Join([]string{
+		signV4ChunkAlgorithm,
+		c.info.rawDate,
+		c.info.scope.String(),
+		c.prevSignature,
+		emptySHA256,
+		getSHA256Hex(c.buffer),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(c.signingKey, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errSignatureDoesNotMatch
+	}
+	c.prevSignature = signature
+	return nil
+}
+
+// payloadReader reads the request payload, computes its MD5 and verifies
+// the content SHA-256 and Content-MD5 provided by the client, if any
+type payloadReader struct {
+	reader         io.Reader
+	md5Hash        hash.Hash
+	sha256Hash     hash.Hash
+	expectedMD5    []byte
+	expectedSHA256 []byte
+	expectedSize   int64
+	size           int64
+}
+
+func newPayloadReader(r *http.Request, info *signatureInfo, signingKey []byte) (*payloadReader, error) {
+	reader := &payloadReader{
+		reader:       r.Body,
+		md5Hash:      md5.New(),
+		expectedSize: r.ContentLength,
+	}
+	if contentMD5 := r.Header.Get("Content-Md5"); contentMD5 != "" {
+		expected, err := base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(expected) != md5.Size {
+			return nil, errInvalidDigest
+		}
+		reader.expectedMD5 = expected
+	}
+	switch info.payloadHash {
+	case unsignedPayload:
+	case streamingPayload, streamingUnsignedTrailer:
+		decodedLength, err := strconv.ParseInt(r.Header.Get(amzDecodedLengthHeader), 10, 64)
+		if err != nil || decodedLength < 0 {
+			return nil, newS3Error(errInvalidArgument, "invalid or missing x-amz-decoded-content-length")
+		}
+		reader.expectedSize = decodedLength
+		if info.payloadHash == streamingPayload {
+			reader.reader = newChunkedReader(r.Body, info, signingKey)
+		} else {
+			reader.reader = newChunkedReader(r.Body, info, nil)
+		}
+	default:
+		expected, err := hex.DecodeString(info.payloadHash)
+		if err != nil || len(expected) != sha256.Size {
+			return nil, newS3Error(errInvalidArgument, "invalid x-amz-content-sha256")
+		}
+		reader.sha256Hash = sha256.New()
+		reader.expectedSHA256 = expected
+	}
+	return reader, nil
+}
+
+func (r *payloadReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.md5Hash.Write(p[:n])
+		if r.sha256Hash != nil {
+			r.sha256Hash.Write(p[:n])
+		}
+		r.size += int64(n)
+	}
+	if err == io.EOF {
+		if errVerify := r.verify(); errVerify != nil {
+			return n, errVerify
+		}
+	}
+	return n, err
+}
+
+func (r *payloadReader) verify() error {
+	if r.expectedSize >= 0 && r.size != r.expectedSize {
+		return errIncompleteBody
+	}
+	if r.sha256Hash != nil && !bytes.Equal(r.sha256Hash.Sum(nil), r.expectedSHA256) {
+		return errContentSHA256Mismatch
+	}
+	if r.expectedMD5 != nil && !bytes.Equal(r.md5Hash.Sum(nil), r.expectedMD5) {
+		return errBadDigest
+	}
+	return nil
+}
+
+// getSize returns the expected payload size or -1 if unknown
+func (r *payloadReader) getSize() int64 {
+	return r.expectedSize
+}
+
+func (r *payloadReader) getMD5() []byte {
+	return r.md5Hash.Sum(nil)
+}
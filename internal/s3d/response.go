@@ -0,0 +1,307 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s3d
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	s3Namespace    = "http://s3.amazonaws.com/doc/2006-03-01/"
+	s3TimeFormat   = "2006-01-02T15:04:05.000Z"
+	xmlContentType = "application/xml"
+)
+
+// s3Error defines an S3 API error
+type s3Error struct {
+	code       string
+	message    string
+	statusCode int
+}
+
+func (e *s3Error) Error() string {
+	return e.message
+}
+
+func newS3Error(base *s3Error, message string) *s3Error {
+	return &s3Error{
+		code:       base.code,
+		message:    message,
+		statusCode: base.statusCode,
+	}
+}
+
+var (
+	errAccessDenied         = &s3Error{"AccessDenied", "Access Denied", http.StatusForbidden}
+	errMissingAuth          = &s3Error{"AccessDenied", "Anonymous access is not allowed", http.StatusForbidden}
+	errQuotaExceed          = &s3Error{"AccessDenied", "Denying write due to space limit", http.StatusForbidden}
+	errUnsupportedSignature = &s3Error{"InvalidRequest",
+		"The authorization mechanism you have provided is not supported. Please use AWS4-HMAC-SHA256.",
+		http.StatusBadRequest}
+	errAuthorizationMalformed = &s3Error{"AuthorizationHeaderMalformed", "The authorization header is malformed",
+		http.StatusBadRequest}
+	errAuthQueryParams = &s3Error{"AuthorizationQueryParametersError", "Invalid query parameters",
+		http.StatusBadRequest}
+	errInvalidAccessKeyID = &s3Error{"InvalidAccessKeyId",
+		"The AWS access key Id you provided does not exist in our records.", http.StatusForbidden}
+	errSignatureDoesNotMatch = &s3Error{"SignatureDoesNotMatch",
+		"The request signature we calculated does not match the signature you provided.", http.StatusForbidden}
+	errRequestTimeTooSkewed = &s3Error{"RequestTimeTooSkewed",
+		"The difference between the request time and the server's time is too large.", http.StatusForbidden}
+	errContentSHA256Mismatch = &s3Error{"XAmzContentSHA256Mismatch",
+		"The provided 'x-amz-content-sha256' header does not match what was computed.", http.StatusBadRequest}
+	errBadDigest = &s3Error{"BadDigest", "The Content-MD5 you specified did not match what we received.",
+		http.StatusBadRequest}
+	errInvalidDigest = &s3Error{"InvalidDigest", "The Content-MD5 you specified is not valid.",
+		http.StatusBadRequest}
+	errIncompleteBody = &s3Error{"IncompleteBody",
+		"You did not provide the number of bytes specified by the Content-Length HTTP header.", http.StatusBadRequest}
+	errInvalidRequest  = &s3Error{"InvalidRequest", "Invalid request", http.StatusBadRequest}
+	errInvalidArgument = &s3Error{"InvalidArgument", "Invalid argument", http.StatusBadRequest}
+	errMalformedXML    = &s3Error{"MalformedXML",
+		"The XML you provided was not well-formed or did not validate against our published schema.",
+		http.StatusBadRequest}
+	errEntityTooLarge = &s3Error{"EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size.",
+		http.StatusBadRequest}
+	errInvalidPart = &s3Error{"InvalidPart",
+		"One or more of the specified parts could not be found or the specified entity tag might not have matched the part's entity tag.",
+		http.StatusBadRequest}
+	errInvalidPartOrder = &s3Error{"InvalidPartOrder",
+		"The list of parts was not in ascending order. Parts must be ordered by part number.", http.StatusBadRequest}
+	errInvalidRange = &s3Error{"InvalidRange", "The requested range is not satisfiable",
+		http.StatusRequestedRangeNotSatisfiable}
+	errPreconditionFailed = &s3Error{"PreconditionFailed",
+		"At least one of the preconditions you specified did not hold.", http.StatusPreconditionFailed}
+	errNoSuchBucket = &s3Error{"NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound}
+	errNoSuchKey    = &s3Error{"NoSuchKey", "The specified key does not exist.", http.StatusNotFound}
+	errNoSuchUpload = &s3Error{"NoSuchUpload",
+		"The specified multipart upload does not exist. The upload ID may be invalid, or the upload may have been aborted or completed.",
+		http.StatusNotFound}
+	errBucketAlreadyOwnedByYou = &s3Error{"BucketAlreadyOwnedByYou",
+		"Your previous request to create the named bucket succeeded and you already own it.", http.StatusConflict}
+	errMethodNotAllowed = &s3Error{"MethodNotAllowed", "The specified method is not allowed against this resource.",
+		http.StatusMethodNotAllowed}
+	errNotImplemented = &s3Error{"NotImplemented",
+		"A header or query you provided implies functionality that is not implemented.", http.StatusNotImplemented}
+	errSlowDown      = &s3Error{"SlowDown", "Please reduce your request rate.", http.StatusServiceUnavailable}
+	errInternalError = &s3Error{"InternalError", "We encountered an internal error. Please try again.",
+		http.StatusInternalServerError}
+)
+
+// getS3Error converts the specified error to an S3 API error
+func getS3Error(err error) *s3Error {
+	var s3Err *s3Error
+	if errors.As(err, &s3Err) {
+		return s3Err
+	}
+	switch {
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, common.ErrNotExist):
+		return errNoSuchKey
+	case errors.Is(err, os.ErrPermission), errors.Is(err, common.ErrPermissionDenied):
+		return errAccessDenied
+	case errors.Is(err, common.ErrQuotaExceeded):
+		return errQuotaExceed
+	case errors.Is(err, common.ErrReadQuotaExceeded):
+		return newS3Error(errAccessDenied, err.Error())
+	case errors.Is(err, common.ErrOpUnsupported):
+		return errNotImplemented
+	}
+	return errInternalError
+}
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestID string   `xml:"RequestId"`
+}
+
+type bucket struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Owner   owner    `xml:"Owner"`
+	Buckets []bucket `xml:"Buckets>Bucket"`
+}
+
+type locationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Region  string   `xml:",chardata"`
+}
+
+type object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Marker                *string        `xml:"Marker,omitempty"`
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	KeyCount              *int           `xml:"KeyCount,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []object       `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type objectIdentifier struct {
+	Key string `xml:"Key"`
+}
+
+type deleteRequest struct {
+	XMLName xml.Name           `xml:"Delete"`
+	Quiet   bool               `xml:"Quiet"`
+	Objects []objectIdentifier `xml:"Object"`
+}
+
+type deletedObject struct {
+	Key string `xml:"Key"`
+}
+
+type deleteError struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Xmlns   string          `xml:"xmlns,attr"`
+	Deleted []deletedObject `xml:"Deleted"`
+	Errors  []deleteError   `xml:"Error"`
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+func formatS3Time(t time.Time) string {
+	return t.UTC().Format(s3TimeFormat)
+}
+
+func getRequestID(r *http.Request) string {
+	if reqID, ok := r.Context().Value(requestIDKey).(string); ok {
+		return reqID
+	}
+	return ""
+}
+
+func writeXMLResponse(w http.ResponseWriter, r *http.Request, statusCode int, v any) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		logger.Warn(logSender, getRequestID(r), "unable to marshal XML response: %v", err)
+		writeErrorResponse(w, r, errInternalError)
+		return
+	}
+	w.Header().Set("Content-Type", xmlContentType)
+	w.WriteHeader(statusCode)
+	w.Write([]byte(xml.Header)) //nolint:errcheck
+	w.Write(data)               //nolint:errcheck
+	writeLog(r, statusCode, nil)
+}
+
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	s3Err := getS3Error(err)
+	requestID := getRequestID(r)
+	w.Header().Set("x-amz-request-id", requestID)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(s3Err.statusCode)
+		writeLog(r, s3Err.statusCode, err)
+		return
+	}
+	resp := errorResponse{
+		Code:      s3Err.code,
+		Message:   s3Err.message,
+		Resource:  r.URL.Path,
+		RequestID: requestID,
+	}
+	data, errMarshal := xml.Marshal(resp)
+	if errMarshal != nil {
+		http.Error(w, s3Err.message, s3Err.statusCode)
+		writeLog(r, s3Err.statusCode, err)
+		return
+	}
+	w.Header().Set("Content-Type", xmlContentType)
+	w.WriteHeader(s3Err.statusCode)
+	w.Write([]byte(xml.Header)) //nolint:errcheck
+	w.Write(data)               //nolint:errcheck
+	writeLog(r, s3Err.statusCode, err)
+}
+
+func writeEmptyResponse(w http.ResponseWriter, r *http.Request, statusCode int) {
+	w.WriteHeader(statusCode)
+	writeLog(r, statusCode, nil)
+}
+
+func getETag(value string) string {
+	return "\"" + value + "\""
+}
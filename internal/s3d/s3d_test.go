@@ -0,0 +1,571 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s3d_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/rs/zerolog"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/httpdtest"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/s3d"
+)
+
+const (
+	s3ServerAddr    = "127.0.0.1:9101"
+	s3ServerPort    = 9101
+	defaultUsername = "test_user_s3"
+	defaultPassword = "test_password"
+	defaultSecret   = "test_secret_access_key"
+	testFileName    = "test_file_s3.dat"
+)
+
+var (
+	configDir    = filepath.Join(".", "..", "..")
+	allPerms     = []string{dataprovider.PermAny}
+	homeBasePath string
+	logFilePath  string
+)
+
+func TestMain(m *testing.M) {
+	logFilePath = filepath.Join(configDir, "sftpgo_s3d_test.log")
+	logger.InitLogger(logFilePath, 5, 1, 28, false, false, zerolog.DebugLevel)
+	os.Setenv("SFTPGO_DATA_PROVIDER__CREATE_DEFAULT_ADMIN", "1")
+	os.Setenv("SFTPGO_DEFAULT_ADMIN_USERNAME", "admin")
+	os.Setenv("SFTPGO_DEFAULT_ADMIN_PASSWORD", "password")
+	err := config.LoadConfig(configDir, "")
+	if err != nil {
+		logger.ErrorToConsole("error loading configuration: %v", err)
+		os.Exit(1)
+	}
+	providerConf := config.GetProviderConf()
+	logger.InfoToConsole("Starting S3D tests, provider: %v", providerConf.Driver)
+	homeBasePath = os.TempDir()
+
+	err = common.Initialize(config.GetCommonConfig(), 0)
+	if err != nil {
+		logger.WarnToConsole("error initializing common: %v", err)
+		os.Exit(1)
+	}
+
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	if err != nil {
+		logger.ErrorToConsole("error initializing data provider: %v", err)
+		os.Exit(1)
+	}
+
+	httpConfig := config.GetHTTPConfig()
+	httpConfig.Initialize(configDir) //nolint:errcheck
+	kmsConfig := config.GetKMSConfig()
+	err = kmsConfig.Initialize()
+	if err != nil {
+		logger.ErrorToConsole("error initializing kms: %v", err)
+		os.Exit(1)
+	}
+
+	httpdConf := config.GetHTTPDConfig()
+	httpdConf.Bindings[0].Port = 8077
+	httpdtest.SetBaseURL("http://127.0.0.1:8077")
+
+	s3dConf := config.GetS3DConfig()
+	s3dConf.Bindings = []s3d.Binding{
+		{
+			Port: s3ServerPort,
+		},
+	}
+
+	status := s3d.GetStatus()
+	if status.IsActive {
+		logger.ErrorToConsole("S3 gateway is already active")
+		os.Exit(1)
+	}
+
+	go func() {
+		logger.Debug("s3dTesting", "", "initializing S3 gateway with config %+v", s3dConf)
+		if err := s3dConf.Initialize(configDir); err != nil {
+			logger.ErrorToConsole("could not start S3 gateway: %v", err)
+			os.Exit(1)
+		}
+	}()
+
+	go func() {
+		if err := httpdConf.Initialize(configDir, 0); err != nil {
+			logger.ErrorToConsole("could not start HTTP server: %v", err)
+			os.Exit(1)
+		}
+	}()
+
+	waitTCPListening(s3ServerAddr)
+	waitTCPListening(httpdConf.Bindings[0].GetAddress())
+
+	exitCode := m.Run()
+	os.Remove(logFilePath)
+	os.Exit(exitCode)
+}
+
+func TestInitialization(t *testing.T) {
+	cfg := s3d.Configuration{
+		Bindings: []s3d.Binding{
+			{
+				Port:        1234,
+				EnableHTTPS: true,
+			},
+		},
+		CertificateFile:    "missing path",
+		CertificateKeyFile: "bad path",
+	}
+	err := cfg.Initialize(configDir)
+	assert.Error(t, err)
+
+	cfg.CertificateFile = ""
+	cfg.CertificateKeyFile = ""
+	cfg.Bindings = []s3d.Binding{
+		{
+			Port: 0,
+		},
+	}
+	err = cfg.Initialize(configDir)
+	assert.EqualError(t, err, common.ErrNoBinding.Error())
+
+	cfg.Bindings = []s3d.Binding{
+		{
+			Port: s3ServerPort,
+		},
+	}
+	err = cfg.Initialize(configDir)
+	assert.Error(t, err)
+
+	status := s3d.GetStatus()
+	assert.True(t, status.IsActive)
+	assert.Len(t, status.Bindings, 1)
+}
+
+func TestBasicHandling(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	client := getS3Client(user.Username, defaultSecret)
+	ctx := context.Background()
+
+	buckets, err := client.ListBuckets(ctx, &s3.ListBucketsInput{})
+	if assert.NoError(t, err) {
+		if assert.Len(t, buckets.Buckets, 1) {
+			assert.Equal(t, user.Username, aws.ToString(buckets.Buckets[0].Name))
+		}
+	}
+	_, err = client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(user.Username)})
+	assert.NoError(t, err)
+	_, err = client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String("missing")})
+	assert.Error(t, err)
+	_, err = client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String("newbucket")})
+	assert.Error(t, err)
+	location, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{Bucket: aws.String(user.Username)})
+	if assert.NoError(t, err) {
+		assert.Equal(t, types.BucketLocationConstraint("us-east-1"), location.LocationConstraint)
+	}
+
+	content := []byte("S3 gateway test content")
+	contentMD5 := md5.Sum(content)
+	key := "dir/sub dir/" + testFileName
+	putResp, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(content),
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, fmt.Sprintf("%q", hex.EncodeToString(contentMD5[:])), aws.ToString(putResp.ETag))
+	}
+	data, err := os.ReadFile(filepath.Join(user.GetHomeDir(), "dir", "sub dir", testFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	headResp, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String(key),
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(len(content)), headResp.ContentLength)
+	}
+	getResp, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String(key),
+	})
+	if assert.NoError(t, err) {
+		data, err = io.ReadAll(getResp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, content, data)
+		getResp.Body.Close()
+	}
+	getResp, err = client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String(key),
+		Range:  aws.String("bytes=3-9"),
+	})
+	if assert.NoError(t, err) {
+		data, err = io.ReadAll(getResp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, content[3:10], data)
+		getResp.Body.Close()
+	}
+	_, err = client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String("missing"),
+	})
+	var noSuchKey *types.NoSuchKey
+	assert.True(t, errors.As(err, &noSuchKey), "unexpected error: %v", err)
+	// create an empty directory using a directory marker
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String("emptydir/"),
+		Body:   bytes.NewReader(nil),
+	})
+	assert.NoError(t, err)
+	assert.DirExists(t, filepath.Join(user.GetHomeDir(), "emptydir"))
+
+	listResp, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(user.Username),
+		Delimiter: aws.String("/"),
+	})
+	if assert.NoError(t, err) {
+		assert.Len(t, listResp.Contents, 0)
+		if assert.Len(t, listResp.CommonPrefixes, 2) {
+			assert.Equal(t, "dir/", aws.ToString(listResp.CommonPrefixes[0].Prefix))
+			assert.Equal(t, "emptydir/", aws.ToString(listResp.CommonPrefixes[1].Prefix))
+		}
+	}
+	listResp, err = client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(user.Username),
+	})
+	if assert.NoError(t, err) {
+		assert.Len(t, listResp.CommonPrefixes, 0)
+		if assert.Len(t, listResp.Contents, 1) {
+			assert.Equal(t, key, aws.ToString(listResp.Contents[0].Key))
+			assert.Equal(t, int64(len(content)), listResp.Contents[0].Size)
+		}
+	}
+	listV1Resp, err := client.ListObjects(ctx, &s3.ListObjectsInput{
+		Bucket:    aws.String(user.Username),
+		Prefix:    aws.String("dir/"),
+		Delimiter: aws.String("/"),
+	})
+	if assert.NoError(t, err) {
+		assert.Len(t, listV1Resp.Contents, 0)
+		if assert.Len(t, listV1Resp.CommonPrefixes, 1) {
+			assert.Equal(t, "dir/sub dir/", aws.ToString(listV1Resp.CommonPrefixes[0].Prefix))
+		}
+	}
+
+	_, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String(key),
+	})
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), "dir", "sub dir", testFileName))
+	// deleting a missing object must succeed
+	_, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String(key),
+	})
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	assert.Len(t, common.Connections.GetStats(), 0)
+}
+
+func TestDeleteObjects(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	client := getS3Client(user.Username, defaultSecret)
+	ctx := context.Background()
+
+	var objects []types.ObjectIdentifier
+	for i := 0; i < 3; i++ {
+		key := fmt.Sprintf("dir/file%d", i)
+		_, err = client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(user.Username),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader([]byte(key)),
+		})
+		assert.NoError(t, err)
+		objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+	}
+	resp, err := client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(user.Username),
+		Delete: &types.Delete{
+			Objects: objects,
+		},
+	})
+	if assert.NoError(t, err) {
+		assert.Len(t, resp.Deleted, 3)
+		assert.Len(t, resp.Errors, 0)
+	}
+	for i := 0; i < 3; i++ {
+		assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), "dir", fmt.Sprintf("file%d", i)))
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestMultipartUpload(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	client := getS3Client(user.Username, defaultSecret)
+	ctx := context.Background()
+
+	content := make([]byte, 6*1024*1024+100)
+	_, err = rand.Read(content)
+	require.NoError(t, err)
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		u.PartSize = manager.MinUploadPartSize
+		u.Concurrency = 2
+	})
+	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String(testFileName),
+		Body:   bytes.NewReader(content),
+	})
+	assert.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(user.GetHomeDir(), testFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	createResp, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String("aborted"),
+	})
+	require.NoError(t, err)
+	_, err = client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(user.Username),
+		Key:        aws.String("aborted"),
+		UploadId:   createResp.UploadId,
+		PartNumber: 1,
+		Body:       bytes.NewReader([]byte("part")),
+	})
+	assert.NoError(t, err)
+	_, err = client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(user.Username),
+		Key:      aws.String("aborted"),
+		UploadId: createResp.UploadId,
+	})
+	assert.NoError(t, err)
+	_, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(user.Username),
+		Key:      aws.String("aborted"),
+		UploadId: createResp.UploadId,
+	})
+	var apiErr interface{ ErrorCode() string }
+	if assert.True(t, errors.As(err, &apiErr), "unexpected error: %v", err) {
+		assert.Equal(t, "NoSuchUpload", apiErr.ErrorCode())
+	}
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), "aborted"))
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestPresignedURL(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	content := []byte("presigned content")
+	err = os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), testFileName), content, os.ModePerm)
+	assert.NoError(t, err)
+
+	presignClient := s3.NewPresignClient(getS3Client(user.Username, defaultSecret))
+	req, err := presignClient.PresignGetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String(testFileName),
+	}, s3.WithPresignExpires(10*time.Minute))
+	require.NoError(t, err)
+	resp, err := http.Get(req.URL)
+	if assert.NoError(t, err) {
+		data, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, content, data)
+		resp.Body.Close()
+	}
+	resp, err = http.Get(req.URL + "&extra=param")
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		resp.Body.Close()
+	}
+	resp, err = http.Get(fmt.Sprintf("http://%s/%s/%s", s3ServerAddr, user.Username, testFileName))
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		resp.Body.Close()
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestLoginErrors(t *testing.T) {
+	u := getTestUser()
+	u.Filters.DeniedProtocols = []string{common.ProtocolS3}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = getS3Client(user.Username, defaultSecret).ListBuckets(ctx, &s3.ListBucketsInput{})
+	assert.Error(t, err)
+	user.Filters.DeniedProtocols = []string{common.ProtocolFTP}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, err = getS3Client(user.Username, defaultSecret).ListBuckets(ctx, &s3.ListBucketsInput{})
+	assert.NoError(t, err)
+	_, err = getS3Client(user.Username, defaultSecret+"_wrong").ListBuckets(ctx, &s3.ListBucketsInput{})
+	assert.Error(t, err)
+	_, err = getS3Client(user.Username+"_missing", defaultSecret).ListBuckets(ctx, &s3.ListBucketsInput{})
+	assert.Error(t, err)
+	// the secret access key is preserved if not provided
+	user.Filters.S3SecretAccessKey = nil
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, err = getS3Client(user.Username, defaultSecret).ListBuckets(ctx, &s3.ListBucketsInput{})
+	assert.NoError(t, err)
+	// an empty secret removes the secret access key
+	user.Filters.S3SecretAccessKey = kms.NewEmptySecret()
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, err = getS3Client(user.Username, defaultSecret).ListBuckets(ctx, &s3.ListBucketsInput{})
+	assert.Error(t, err)
+	user.Filters.S3SecretAccessKey = kms.NewPlainSecret("short")
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	assert.Len(t, common.Connections.GetStats(), 0)
+}
+
+func TestPermissions(t *testing.T) {
+	u := getTestUser()
+	u.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	client := getS3Client(user.Username, defaultSecret)
+	ctx := context.Background()
+
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String(testFileName),
+		Body:   bytes.NewReader([]byte("content")),
+	})
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), testFileName))
+	_, err = client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String(testFileName),
+	})
+	assert.Error(t, err)
+	_, err = client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(user.Username),
+	})
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func getS3Client(accessKey, secret string) *s3.Client {
+	endpointResolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...any) (aws.Endpoint, error) {
+		return aws.Endpoint{
+			URL:               "http://" + s3ServerAddr,
+			HostnameImmutable: true,
+			Source:            aws.EndpointSourceCustom,
+		}, nil
+	})
+	cfg := aws.Config{
+		Region:                      "us-east-1",
+		Credentials:                 credentials.NewStaticCredentialsProvider(accessKey, secret, ""),
+		EndpointResolverWithOptions: endpointResolver,
+		Retryer: func() aws.Retryer {
+			return aws.NopRetryer{}
+		},
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = true
+	})
+}
+
+func waitTCPListening(address string) {
+	for {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			logger.WarnToConsole("tcp server %v not listening: %v", address, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		logger.InfoToConsole("tcp server %v now listening", address)
+		conn.Close()
+		break
+	}
+}
+
+func getTestUser() dataprovider.User {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: defaultUsername,
+			Password: defaultPassword,
+			HomeDir:  filepath.Join(homeBasePath, defaultUsername),
+			Status:   1,
+		},
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = allPerms
+	user.Filters.S3SecretAccessKey = kms.NewPlainSecret(defaultSecret)
+	return user
+}
@@ -0,0 +1,304 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s3d
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+type s3Server struct {
+	config  *Configuration
+	binding Binding
+}
+
+func (s *s3Server) listenAndServe() error {
+	httpServer := &http.Server{
+		Handler:           tracing.HTTPMiddleware(common.ProtocolS3, nil)(s),
+		ReadHeaderTimeout: 30 * time.Second,
+		ReadTimeout:       60 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		MaxHeaderBytes:    1 << 16, // 64KB
+		ErrorLog:          log.New(&logger.StdLoggerWrapper{Sender: logSender}, "", 0),
+	}
+	if certMgr != nil && s.binding.EnableHTTPS {
+		serviceStatus.Bindings = append(serviceStatus.Bindings, s.binding)
+		certID := common.DefaultTLSKeyPaidID
+		if getConfigPath(s.binding.CertificateFile, "") != "" && getConfigPath(s.binding.CertificateKeyFile, "") != "" {
+			certID = s.binding.GetAddress()
+		}
+		httpServer.TLSConfig = &tls.Config{
+			GetCertificate:           certMgr.GetCertificateFunc(certID),
+			MinVersion:               util.GetTLSVersion(s.binding.MinTLSVersion),
+			NextProtos:               []string{"http/1.1", "h2"},
+			CipherSuites:             util.GetTLSCiphersFromNames(s.binding.TLSCipherSuites),
+			PreferServerCipherSuites: true,
+		}
+		logger.Debug(logSender, "", "configured TLS cipher suites for binding %#v: %v, certID: %v",
+			s.binding.GetAddress(), httpServer.TLSConfig.CipherSuites, certID)
+		return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, true, logSender)
+	}
+	s.binding.EnableHTTPS = false
+	serviceStatus.Bindings = append(serviceStatus.Bindings, s.binding)
+	return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, false, logSender)
+}
+
+// ServeHTTP implements the http.Handler interface
+func (s *s3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(logSender, "", "panic in ServeHTTP: %#v stack trace: %v", r, string(debug.Stack()))
+			http.Error(w, common.ErrGenericFailure.Error(), http.StatusInternalServerError)
+		}
+	}()
+
+	connID := xid.New().String()
+	connectionID := fmt.Sprintf("%v_%v", common.ProtocolS3, connID)
+	ctx := context.WithValue(r.Context(), requestIDKey, connectionID)
+	ctx = context.WithValue(ctx, requestStartKey, time.Now())
+	r = r.WithContext(ctx)
+	w.Header().Set("x-amz-request-id", connectionID)
+
+	ipAddr := s.checkRemoteAddress(r)
+
+	common.Connections.AddClientConnection(ipAddr)
+	defer common.Connections.RemoveClientConnection(ipAddr)
+
+	if !common.Connections.IsNewConnectionAllowed(ipAddr) {
+		logger.Log(logger.LevelDebug, common.ProtocolS3, "", fmt.Sprintf("connection not allowed from ip %#v", ipAddr))
+		writeErrorResponse(w, r, newS3Error(errSlowDown, common.ErrConnectionDenied.Error()))
+		return
+	}
+	if common.IsBanned(ipAddr) {
+		writeErrorResponse(w, r, newS3Error(errAccessDenied, common.ErrConnectionDenied.Error()))
+		return
+	}
+	if !common.IsCountryAllowed(ipAddr, s.binding.AllowedCountries, s.binding.DeniedCountries) {
+		logger.Log(logger.LevelDebug, common.ProtocolS3, "", "connection refused, country not allowed for ip %q", ipAddr)
+		writeErrorResponse(w, r, newS3Error(errAccessDenied, common.ErrConnectionDenied.Error()))
+		return
+	}
+	delay, err := common.LimitRate(common.ProtocolS3, ipAddr)
+	if err != nil {
+		delay += 499999999 * time.Nanosecond
+		w.Header().Set("Retry-After", fmt.Sprintf("%.0f", delay.Seconds()))
+		w.Header().Set("X-Retry-In", delay.String())
+		writeErrorResponse(w, r, newS3Error(errSlowDown, err.Error()))
+		return
+	}
+	if err := common.ThrottleByLatency(common.ProtocolS3, ipAddr); err != nil {
+		writeErrorResponse(w, r, newS3Error(errSlowDown, err.Error()))
+		return
+	}
+	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolS3); err != nil {
+		writeErrorResponse(w, r, newS3Error(errAccessDenied, common.ErrConnectionDenied.Error()))
+		return
+	}
+	info, err := getSignatureInfo(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+	user, signingKey, err := s.authenticate(r, &info, ipAddr)
+	if err != nil {
+		updateLoginMetrics(&user, ipAddr, err)
+		writeErrorResponse(w, r, err)
+		return
+	}
+	if err := s.validateUser(&user, r, connectionID); err != nil {
+		updateLoginMetrics(&user, ipAddr, err)
+		writeErrorResponse(w, r, newS3Error(errAccessDenied, err.Error()))
+		return
+	}
+	if err := user.CheckFsRoot(connectionID); err != nil {
+		errClose := user.CloseFs()
+		logger.Warn(logSender, connectionID, "unable to check fs root: %v close fs error: %v", err, errClose)
+		updateLoginMetrics(&user, ipAddr, common.ErrInternalFailure)
+		writeErrorResponse(w, r, errInternalError)
+		return
+	}
+
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connID, common.ProtocolS3, util.GetHTTPLocalAddress(r),
+			r.RemoteAddr, user),
+		request: r,
+	}
+	connection.SetTraceContext(r.Context())
+	if err = common.Connections.Add(connection); err != nil {
+		errClose := user.CloseFs()
+		logger.Warn(logSender, connectionID, "unable add connection: %v close fs error: %v", err, errClose)
+		updateLoginMetrics(&user, ipAddr, err)
+		writeErrorResponse(w, r, newS3Error(errSlowDown, err.Error()))
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	updateLoginMetrics(&user, ipAddr, nil)
+	dataprovider.UpdateLastLogin(&user)
+
+	handler := &requestHandler{
+		config:     s.config,
+		connection: connection,
+		info:       &info,
+		signingKey: signingKey,
+	}
+	handler.serve(w, r)
+}
+
+// authenticate verifies the request signature, the access key ID is the username
+func (s *s3Server) authenticate(r *http.Request, info *signatureInfo, ip string) (dataprovider.User, []byte, error) {
+	if err := info.checkDate(time.Now()); err != nil {
+		var user dataprovider.User
+		user.Username = info.scope.accessKey
+		return user, nil, err
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(info.scope.accessKey)
+	if err != nil {
+		user.Username = info.scope.accessKey
+		if _, ok := err.(*util.RecordNotFoundError); ok {
+			logger.Debug(logSender, "", "user %#v not found, ip: %v", user.Username, ip)
+			return user, nil, errInvalidAccessKeyID
+		}
+		logger.Warn(logSender, "", "unable to get user %#v: %v", user.Username, err)
+		return user, nil, common.ErrInternalFailure
+	}
+	secret := user.Filters.S3SecretAccessKey
+	if secret == nil || secret.IsEmpty() {
+		logger.Debug(logSender, "", "user %#v has no S3 secret access key", user.Username)
+		return user, nil, errInvalidAccessKeyID
+	}
+	if err := secret.TryDecrypt(); err != nil {
+		logger.Warn(logSender, "", "unable to decrypt the S3 secret access key for user %#v: %v", user.Username, err)
+		return user, nil, common.ErrInternalFailure
+	}
+	signingKey, err := info.verify(r, secret.GetPayload())
+	if err != nil {
+		return user, nil, err
+	}
+	return user, signingKey, nil
+}
+
+func (s *s3Server) validateUser(user *dataprovider.User, r *http.Request, connectionID string) error {
+	if err := user.CheckLoginConditions(); err != nil {
+		logger.Info(logSender, connectionID, "cannot login user %#v: %v", user.Username, err)
+		return err
+	}
+	if !filepath.IsAbs(user.HomeDir) {
+		logger.Warn(logSender, connectionID, "user %#v has an invalid home dir: %#v. Home dir must be an absolute path, login not allowed",
+			user.Username, user.HomeDir)
+		return fmt.Errorf("cannot login user with invalid home dir: %#v", user.HomeDir)
+	}
+	if util.Contains(user.Filters.DeniedProtocols, common.ProtocolS3) {
+		logger.Info(logSender, connectionID, "cannot login user %#v, protocol S3 is not allowed", user.Username)
+		return fmt.Errorf("protocol S3 is not allowed for user %#v", user.Username)
+	}
+	if !user.IsLoginFromAddrAllowed(r.RemoteAddr) {
+		logger.Info(logSender, connectionID, "cannot login user %#v, remote address is not allowed: %v",
+			user.Username, r.RemoteAddr)
+		return fmt.Errorf("login for user %#v is not allowed from this address: %v", user.Username, r.RemoteAddr)
+	}
+	if !user.IsClientAllowed(r.UserAgent()) {
+		common.AddDeniedClientVersion(common.ProtocolS3, r.UserAgent())
+		logger.Info(logSender, connectionID, "cannot login user %q, client %q is not allowed", user.Username, r.UserAgent())
+		return fmt.Errorf("client %q is not allowed for user %q", r.UserAgent(), user.Username)
+	}
+	return nil
+}
+
+func (s *s3Server) checkRemoteAddress(r *http.Request) string {
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	var ip net.IP
+	isUnixSocket := filepath.IsAbs(s.binding.Address)
+	if !isUnixSocket {
+		ip = net.ParseIP(ipAddr)
+	}
+	if isUnixSocket || ip != nil {
+		for _, allow := range s.binding.allowHeadersFrom {
+			if allow(ip) {
+				parsedIP := util.GetRealIP(r, s.binding.ClientIPProxyHeader, s.binding.ClientIPHeaderDepth)
+				if parsedIP != "" {
+					ipAddr = parsedIP
+					r.RemoteAddr = ipAddr
+				}
+				break
+			}
+		}
+	}
+	return ipAddr
+}
+
+func writeLog(r *http.Request, status int, err error) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	fields := map[string]any{
+		"remote_addr": r.RemoteAddr,
+		"proto":       r.Proto,
+		"method":      r.Method,
+		"user_agent":  r.UserAgent(),
+		"uri":         fmt.Sprintf("%s://%s%s", scheme, r.Host, strings.SplitN(r.RequestURI, "?", 2)[0])}
+	if reqID, ok := r.Context().Value(requestIDKey).(string); ok {
+		fields["request_id"] = reqID
+	}
+	if reqStart, ok := r.Context().Value(requestStartKey).(time.Time); ok {
+		fields["elapsed_ms"] = time.Since(reqStart).Nanoseconds() / 1000000
+	}
+	if contentLength := r.Header.Get("Content-Length"); contentLength != "" {
+		fields["content_length"] = contentLength
+	}
+	if status != 0 {
+		fields["resp_status"] = status
+	}
+	logger.GetLogger().Info().
+		Timestamp().
+		Str("sender", logSender).
+		Fields(fields).
+		Err(err).
+		Send()
+}
+
+func updateLoginMetrics(user *dataprovider.User, ip string, err error) {
+	loginMethod := dataprovider.LoginMethodS3Signature
+	metric.AddLoginAttempt(loginMethod)
+	if err != nil && err != common.ErrInternalFailure {
+		logger.ConnectionFailedLog(user.Username, ip, common.GetCountryForIP(ip), loginMethod, common.ProtocolS3, err.Error())
+		event := common.HostEventLoginFailed
+		if err == errInvalidAccessKeyID {
+			event = common.HostEventUserNotFound
+		}
+		common.AddDefenderEvent(ip, event)
+	}
+	metric.AddLoginResult(loginMethod, err)
+	dataprovider.ExecutePostLoginHook(user, loginMethod, ip, common.ProtocolS3, err)
+}
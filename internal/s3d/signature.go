@@ -0,0 +1,354 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s3d
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signV4Algorithm          = "AWS4-HMAC-SHA256"
+	signV4ChunkAlgorithm     = "AWS4-HMAC-SHA256-PAYLOAD"
+	iso8601Format            = "20060102T150405Z"
+	yyyymmdd                 = "20060102"
+	unsignedPayload          = "UNSIGNED-PAYLOAD"
+	streamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	emptySHA256              = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	serviceName              = "s3"
+	scopeTerminator          = "aws4_request"
+	defaultRegion            = "us-east-1"
+	maxClockSkew             = 15 * time.Minute
+	maxPresignedExpires      = 7 * 24 * time.Hour
+	amzDateHeader            = "X-Amz-Date"
+	amzContentSHA256Header   = "X-Amz-Content-Sha256"
+	amzDecodedLengthHeader   = "X-Amz-Decoded-Content-Length"
+	amzSignatureParam        = "X-Amz-Signature"
+)
+
+// credentialScope defines the scope of an AWS Signature Version 4
+type credentialScope struct {
+	accessKey string
+	date      string
+	region    string
+	service   string
+}
+
+func (c *credentialScope) String() string {
+	return strings.Join([]string{c.date, c.region, c.service, scopeTerminator}, "/")
+}
+
+func parseCredential(value string) (credentialScope, error) {
+	// the access key ID cannot contain "/" so we can safely split here
+	parts := strings.Split(value, "/")
+	if len(parts) != 5 {
+		return credentialScope{}, newS3Error(errAuthorizationMalformed, "invalid credential scope")
+	}
+	if parts[0] == "" {
+		return credentialScope{}, newS3Error(errAuthorizationMalformed, "missing access key ID")
+	}
+	if _, err := time.Parse(yyyymmdd, parts[1]); err != nil {
+		return credentialScope{}, newS3Error(errAuthorizationMalformed, "invalid credential date")
+	}
+	if parts[3] != serviceName || parts[4] != scopeTerminator {
+		return credentialScope{}, newS3Error(errAuthorizationMalformed, "invalid credential scope")
+	}
+	return credentialScope{
+		accessKey: parts[0],
+		date:      parts[1],
+		region:    parts[2],
+		service:   parts[3],
+	}, nil
+}
+
+// signatureInfo defines the AWS Signature Version 4 parameters received from the client,
+// the signature can be sent using the Authorization header or the query string
+type signatureInfo struct {
+	scope         credentialScope
+	signedHeaders []string
+	signature     string
+	rawDate       string
+	date          time.Time
+	expires       time.Duration
+	payloadHash   string
+	isPresigned   bool
+}
+
+func (s *signatureInfo) isStreaming() bool {
+	return s.payloadHash == streamingPayload || s.payloadHash == streamingUnsignedTrailer
+}
+
+func (s *signatureInfo) parseDate(value string) error {
+	date, err := time.Parse(iso8601Format, value)
+	if err != nil {
+		return newS3Error(errAccessDenied, "unable to parse the request date")
+	}
+	if date.Format(yyyymmdd) != s.scope.date {
+		return newS3Error(errAuthorizationMalformed, "the credential date does not match the request date")
+	}
+	s.rawDate = value
+	s.date = date
+	return nil
+}
+
+func (s *signatureInfo) checkDate(now time.Time) error {
+	if s.isPresigned {
+		if now.Before(s.date.Add(-maxClockSkew)) {
+			return newS3Error(errAccessDenied, "request is not valid yet")
+		}
+		if now.After(s.date.Add(s.expires)) {
+			return newS3Error(errAccessDenied, "request has expired")
+		}
+		return nil
+	}
+	diff := now.Sub(s.date)
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > maxClockSkew {
+		return errRequestTimeTooSkewed
+	}
+	return nil
+}
+
+func parseSignedHeaders(value string) ([]string, error) {
+	if value == "" {
+		return nil, newS3Error(errAuthorizationMalformed, "missing signed headers")
+	}
+	headers := strings.Split(value, ";")
+	hasHost := false
+	for _, h := range headers {
+		if h == "" || h != strings.ToLower(h) {
+			return nil, newS3Error(errAuthorizationMalformed, "invalid signed headers")
+		}
+		if h == "host" {
+			hasHost = true
+		}
+	}
+	if !hasHost {
+		return nil, newS3Error(errAuthorizationMalformed, "the host header must be signed")
+	}
+	return headers, nil
+}
+
+func parseAuthorizationHeader(r *http.Request, value string) (signatureInfo, error) {
+	var info signatureInfo
+
+	if !strings.HasPrefix(value, signV4Algorithm+" ") {
+		return info, errUnsupportedSignature
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(value, signV4Algorithm), ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return info, newS3Error(errAuthorizationMalformed, "invalid authorization header")
+		}
+		fields[k] = v
+	}
+	scope, err := parseCredential(fields["Credential"])
+	if err != nil {
+		return info, err
+	}
+	info.scope = scope
+	info.signedHeaders, err = parseSignedHeaders(fields["SignedHeaders"])
+	if err != nil {
+		return info, err
+	}
+	info.signature = fields["Signature"]
+	if info.signature == "" {
+		return info, newS3Error(errAuthorizationMalformed, "missing signature")
+	}
+	date := r.Header.Get(amzDateHeader)
+	if date == "" {
+		date = r.Header.Get("Date")
+	}
+	if err := info.parseDate(date); err != nil {
+		return info, err
+	}
+	info.payloadHash = r.Header.Get(amzContentSHA256Header)
+	if info.payloadHash == "" {
+		return info, newS3Error(errInvalidRequest, "missing required header for this request: x-amz-content-sha256")
+	}
+	return info, nil
+}
+
+func parsePresignedQuery(query url.Values) (signatureInfo, error) {
+	var info signatureInfo
+
+	if query.Get("X-Amz-Algorithm") != signV4Algorithm {
+		return info, errUnsupportedSignature
+	}
+	scope, err := parseCredential(query.Get("X-Amz-Credential"))
+	if err != nil {
+		return info, newS3Error(errAuthQueryParams, err.Error())
+	}
+	info.scope = scope
+	info.signedHeaders, err = parseSignedHeaders(query.Get("X-Amz-SignedHeaders"))
+	if err != nil {
+		return info, newS3Error(errAuthQueryParams, err.Error())
+	}
+	info.signature = query.Get(amzSignatureParam)
+	if info.signature == "" {
+		return info, newS3Error(errAuthQueryParams, "missing signature")
+	}
+	if err := info.parseDate(query.Get(amzDateHeader)); err != nil {
+		return info, err
+	}
+	expires, err := strconv.ParseInt(query.Get("X-Amz-Expires"), 10, 64)
+	if err != nil || expires <= 0 {
+		return info, newS3Error(errAuthQueryParams, "invalid X-Amz-Expires")
+	}
+	info.expires = time.Duration(expires) * time.Second
+	if info.expires > maxPresignedExpires {
+		return info, newS3Error(errAuthQueryParams, "X-Amz-Expires must be less than a week")
+	}
+	info.payloadHash = unsignedPayload
+	info.isPresigned = true
+	return info, nil
+}
+
+// getSignatureInfo returns the signature parameters sent by the client
+func getSignatureInfo(r *http.Request) (signatureInfo, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		return parseAuthorizationHeader(r, auth)
+	}
+	query := r.URL.Query()
+	if query.Has("X-Amz-Algorithm") {
+		return parsePresignedQuery(query)
+	}
+	if query.Has("AWSAccessKeyId") {
+		return signatureInfo{}, errUnsupportedSignature
+	}
+	return signatureInfo{}, errMissingAuth
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func getSHA256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func getSigningKey(secret string, scope *credentialScope) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), scope.date)
+	key = hmacSHA256(key, scope.region)
+	key = hmacSHA256(key, scope.service)
+	return hmacSHA256(key, scopeTerminator)
+}
+
+// awsURIEncode encodes the specified string as required by AWS Signature Version 4:
+// all the characters except the unreserved ones are percent encoded
+func awsURIEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			sb.WriteByte(c)
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("%%%02X", c))
+	}
+	return sb.String()
+}
+
+func getCanonicalQueryString(query url.Values, isPresigned bool) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		if isPresigned && k == amzSignatureParam {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		values := make([]string, len(query[k]))
+		copy(values, query[k])
+		sort.Strings(values)
+		for _, v := range values {
+			params = append(params, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func getCanonicalHeaderValue(r *http.Request, name string) string {
+	switch name {
+	case "host":
+		return r.Host
+	case "content-length":
+		// the content length is removed from the request headers
+		if r.ContentLength >= 0 {
+			return strconv.FormatInt(r.ContentLength, 10)
+		}
+	}
+	values := r.Header.Values(name)
+	for idx := range values {
+		values[idx] = strings.Join(strings.Fields(values[idx]), " ")
+	}
+	return strings.Join(values, ",")
+}
+
+func getCanonicalRequest(r *http.Request, info *signatureInfo) string {
+	var headers strings.Builder
+	for _, name := range info.signedHeaders {
+		headers.WriteString(name)
+		headers.WriteString(":")
+		headers.WriteString(getCanonicalHeaderValue(r, name))
+		headers.WriteString("\n")
+	}
+	return strings.Join([]string{
+		r.Method,
+		awsURIEncode(r.URL.Path, false),
+		getCanonicalQueryString(r.URL.Query(), info.isPresigned),
+		headers.String(),
+		strings.Join(info.signedHeaders, ";"),
+		info.payloadHash,
+	}, "\n")
+}
+
+func (s *signatureInfo) getStringToSign(canonicalRequest string) string {
+	return strings.Join([]string{
+		signV4Algorithm,
+		s.rawDate,
+		s.scope.String(),
+		getSHA256Hex([]byte(canonicalRequest)),
+	}, "\n")
+}
+
+// verify checks the request signature using the specified secret and returns the signing key
+func (s *signatureInfo) verify(r *http.Request, secret string) ([]byte, error) {
+	signingKey := getSigningKey(secret, &s.scope)
+	stringToSign := s.getStringToSign(getCanonicalRequest(r, s))
+	expected := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(s.signature)) {
+		return nil, errSignatureDoesNotMatch
+	}
+	return signingKey, nil
+}
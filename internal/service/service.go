@@ -181,6 +181,12 @@ func (s *Service) initializeServices(disableAWSInstallationCode bool) error {
 		return err
 	}
 
+	dnsConfig := config.GetDNSConfig()
+	if err := dnsConfig.Initialize(); err != nil {
+		logger.Error(logSender, "", "error initializing DNS configuration: %v", err)
+		logger.ErrorToConsole("error initializing DNS configuration: %v", err)
+		return err
+	}
 	httpConfig := config.GetHTTPConfig()
 	err = httpConfig.Initialize(s.ConfigDir)
 	if err != nil {
@@ -194,6 +200,35 @@ func (s *Service) initializeServices(disableAWSInstallationCode bool) error {
 		logger.ErrorToConsole("error initializing commands configuration: %v", err)
 		return err
 	}
+	tracingConfig := config.GetTracingConfig()
+	if err := tracingConfig.Initialize(); err != nil {
+		logger.Error(logSender, "", "error initializing tracing: %v", err)
+		logger.ErrorToConsole("error initializing tracing: %v", err)
+		return err
+	}
+	auditLogConfig := config.GetAuditLogConfig()
+	if err := auditLogConfig.Initialize(s.ConfigDir); err != nil {
+		logger.Error(logSender, "", "error initializing audit log: %v", err)
+		logger.ErrorToConsole("error initializing audit log: %v", err)
+		return err
+	}
+	syslogConfig := config.GetSyslogConfig()
+	if err := syslogConfig.Initialize(); err != nil {
+		logger.Error(logSender, "", "error initializing syslog output: %v", err)
+		logger.ErrorToConsole("error initializing syslog output: %v", err)
+		return err
+	}
+	telemetryConf := config.GetTelemetryConfig()
+	if err := telemetryConf.StartMetricsPush(); err != nil {
+		logger.Error(logSender, "", "error initializing metrics push: %v", err)
+		logger.ErrorToConsole("error initializing metrics push: %v", err)
+		return err
+	}
+	if err := telemetryConf.InitializeUserMetrics(); err != nil {
+		logger.Error(logSender, "", "error initializing user metrics: %v", err)
+		logger.ErrorToConsole("error initializing user metrics: %v", err)
+		return err
+	}
 
 	return nil
 }
@@ -209,6 +244,7 @@ func (s *Service) startServices() {
 	ftpdConf := config.GetFTPDConfig()
 	httpdConf := config.GetHTTPDConfig()
 	webDavDConf := config.GetWebDAVDConfig()
+	s3dConf := config.GetS3DConfig()
 	telemetryConf := config.GetTelemetryConfig()
 
 	if sftpdConf.ShouldBind() {
@@ -267,6 +303,18 @@ func (s *Service) startServices() {
 	} else {
 		logger.Info(logSender, "", "WebDAV server not started, disabled in config file")
 	}
+	if s3dConf.ShouldBind() {
+		go func() {
+			if err := s3dConf.Initialize(s.ConfigDir); err != nil {
+				logger.Error(logSender, "", "could not start S3 gateway: %v", err)
+				logger.ErrorToConsole("could not start S3 gateway: %v", err)
+				s.Error = err
+			}
+			s.Shutdown <- true
+		}()
+	} else {
+		logger.Info(logSender, "", "S3 gateway not started, disabled in config file")
+	}
 	if telemetryConf.ShouldBind() {
 		go func() {
 			if err := telemetryConf.Initialize(s.ConfigDir); err != nil {
@@ -289,6 +289,8 @@ func (s *Service) configurePortableSecrets() {
 	case sdk.GCSFilesystemProvider:
 		payload := s.PortableUser.FsConfig.GCSConfig.Credentials.GetPayload()
 		s.PortableUser.FsConfig.GCSConfig.Credentials = getSecretFromString(payload)
+		payload = s.PortableUser.FsConfig.GCSConfig.EncryptionKey.GetPayload()
+		s.PortableUser.FsConfig.GCSConfig.EncryptionKey = getSecretFromString(payload)
 	case sdk.AzureBlobFilesystemProvider:
 		payload := s.PortableUser.FsConfig.AzBlobConfig.AccountKey.GetPayload()
 		s.PortableUser.FsConfig.AzBlobConfig.AccountKey = getSecretFromString(payload)
@@ -25,14 +25,18 @@ import (
 	"golang.org/x/sys/windows/svc/eventlog"
 	"golang.org/x/sys/windows/svc/mgr"
 
+	"github.com/drakkan/sftpgo/v2/internal/auditlog"
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/ftpd"
 	"github.com/drakkan/sftpgo/v2/internal/httpd"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/s3d"
 	"github.com/drakkan/sftpgo/v2/internal/sftpd"
+	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/telemetry"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
 	"github.com/drakkan/sftpgo/v2/internal/webdavd"
 )
 
@@ -129,6 +133,9 @@ loop:
 			wasStopped <- true
 			s.Service.Stop()
 			plugin.Handler.Cleanup()
+			tracing.Shutdown()
+			auditlog.Close() //nolint:errcheck
+			logger.CloseSyslog()
 			break loop
 		case svc.ParamChange:
 			logger.Debug(logSender, "", "Received reload request")
@@ -148,6 +155,10 @@ loop:
 			if err != nil {
 				logger.Warn(logSender, "", "error reloading WebDAV cert manager: %v", err)
 			}
+			err = s3d.ReloadCertificateMgr()
+			if err != nil {
+				logger.Warn(logSender, "", "error reloading S3 gateway cert manager: %v", err)
+			}
 			err = telemetry.ReloadCertificateMgr()
 			if err != nil {
 				logger.Warn(logSender, "", "error reloading telemetry cert manager: %v", err)
@@ -160,6 +171,10 @@ loop:
 			if err != nil {
 				logger.Warn(logSender, "", "error reloading sftpd revoked certificates: %v", err)
 			}
+			err = smtp.ReloadTemplates()
+			if err != nil {
+				logger.Warn(logSender, "", "error reloading email templates: %v", err)
+			}
 		case rotateLogCmd:
 			logger.Debug(logSender, "", "Received log file rotation request")
 			err := logger.RotateLogFile()
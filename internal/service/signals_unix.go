@@ -22,14 +22,18 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/drakkan/sftpgo/v2/internal/auditlog"
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/ftpd"
 	"github.com/drakkan/sftpgo/v2/internal/httpd"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/s3d"
 	"github.com/drakkan/sftpgo/v2/internal/sftpd"
+	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/telemetry"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
 	"github.com/drakkan/sftpgo/v2/internal/webdavd"
 )
 
@@ -68,6 +72,10 @@ func handleSIGHUP() {
 	if err != nil {
 		logger.Warn(logSender, "", "error reloading WebDAV cert manager: %v", err)
 	}
+	err = s3d.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading S3 gateway cert manager: %v", err)
+	}
 	err = telemetry.ReloadCertificateMgr()
 	if err != nil {
 		logger.Warn(logSender, "", "error reloading telemetry cert manager: %v", err)
@@ -80,6 +88,10 @@ func handleSIGHUP() {
 	if err != nil {
 		logger.Warn(logSender, "", "error reloading sftpd revoked certificates: %v", err)
 	}
+	err = smtp.ReloadTemplates()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading email templates: %v", err)
+	}
 }
 
 func handleSIGUSR1() {
@@ -93,5 +105,8 @@ func handleSIGUSR1() {
 func handleInterrupt() {
 	logger.Debug(logSender, "", "Received interrupt request")
 	plugin.Handler.Cleanup()
+	tracing.Shutdown()
+	auditlog.Close() //nolint:errcheck
+	logger.CloseSyslog()
 	os.Exit(0)
 }
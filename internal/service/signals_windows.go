@@ -18,8 +18,10 @@ import (
 	"os"
 	"os/signal"
 
+	"github.com/drakkan/sftpgo/v2/internal/auditlog"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
 )
 
 func registerSignals() {
@@ -29,6 +31,9 @@ func registerSignals() {
 		for range c {
 			logger.Debug(logSender, "", "Received interrupt request")
 			plugin.Handler.Cleanup()
+			tracing.Shutdown()
+			auditlog.Close() //nolint:errcheck
+			logger.CloseSyslog()
 			os.Exit(0)
 		}
 	}()
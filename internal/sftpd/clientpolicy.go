@@ -0,0 +1,101 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package sftpd
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+// clientPolicyChecker records the client version for a connection and enforces
+// the binding client policy before any authentication attempt
+type clientPolicyChecker struct {
+	policy  *dataprovider.ClientPolicy
+	once    sync.Once
+	allowed bool
+}
+
+func newClientPolicyChecker(binding *Binding) *clientPolicyChecker {
+	return &clientPolicyChecker{
+		policy: &binding.ClientPolicy,
+	}
+}
+
+func (c *clientPolicyChecker) check(conn ssh.ConnMetadata) error {
+	c.once.Do(func() {
+		clientVersion := string(conn.ClientVersion())
+		c.allowed = c.policy.IsClientAllowed(clientVersion)
+		if c.allowed {
+			common.AddClientVersion(common.ProtocolSSH, clientVersion)
+			return
+		}
+		common.AddDeniedClientVersion(common.ProtocolSSH, clientVersion)
+		logger.Info(logSender, "", "client version %q not allowed for binding on %q, remote address: %v",
+			clientVersion, conn.LocalAddr(), conn.RemoteAddr())
+	})
+	if !c.allowed {
+		return &authenticationError{err: common.ErrClientNotAllowed.Error()}
+	}
+	return nil
+}
+
+// getConnectionConfig returns a copy of the specified config with the authentication
+// callbacks wrapped to check the client version first
+func (c *clientPolicyChecker) getConnectionConfig(config *ssh.ServerConfig) *ssh.ServerConfig {
+	connConfig := *config
+	if config.PublicKeyCallback != nil {
+		connConfig.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if err := c.check(conn); err != nil {
+				return nil, err
+			}
+			return config.PublicKeyCallback(conn, key)
+		}
+	}
+	if config.PasswordCallback != nil {
+		connConfig.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if err := c.check(conn); err != nil {
+				return nil, err
+			}
+			return config.PasswordCallback(conn, password)
+		}
+	}
+	if config.KeyboardInteractiveCallback != nil {
+		connConfig.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata,
+			client ssh.KeyboardInteractiveChallenge,
+		) (*ssh.Permissions, error) {
+			if err := c.check(conn); err != nil {
+				return nil, err
+			}
+			return config.KeyboardInteractiveCallback(conn, client)
+		}
+	}
+	return &connConfig
+}
+
+func checkUserClientVersion(user *dataprovider.User, conn ssh.ConnMetadata, connectionID string) error {
+	clientVersion := string(conn.ClientVersion())
+	if !user.IsClientAllowed(clientVersion) {
+		common.AddDeniedClientVersion(common.ProtocolSSH, clientVersion)
+		logger.Info(logSender, connectionID, "cannot login user %q, client version %q is not allowed",
+			user.Username, clientVersion)
+		return fmt.Errorf("client version %q is not allowed for user %q", clientVersion, user.Username)
+	}
+	return nil
+}
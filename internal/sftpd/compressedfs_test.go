@@ -0,0 +1,237 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package sftpd_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/httpdtest"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func TestBasicSFTPCompressedHandling(t *testing.T) {
+	for _, algo := range []string{vfs.CompressionAlgoZstd, vfs.CompressionAlgoGzip} {
+		usePubKey := false
+		u := getTestUserWithCompressedFs(usePubKey)
+		u.FsConfig.CompressConfig.Algorithm = algo
+		u.QuotaSize = 6553600
+		user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+		assert.NoError(t, err)
+		conn, client, err := getSftpClient(user, usePubKey)
+		if assert.NoError(t, err) {
+			testFilePath := filepath.Join(homeBasePath, testFileName)
+			testFileSize, err := createCompressibleTestFile(testFilePath)
+			assert.NoError(t, err)
+			expectedQuotaSize := user.UsedQuotaSize + testFileSize
+			expectedQuotaFiles := user.UsedQuotaFiles + 1
+			err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+			assert.NoError(t, err)
+			localDownloadPath := filepath.Join(homeBasePath, testDLFileName)
+			err = sftpDownloadFile(testFileName, localDownloadPath, testFileSize, client)
+			assert.NoError(t, err)
+			initialHash, err := computeHashForFile(sha256.New(), testFilePath)
+			assert.NoError(t, err)
+			downloadedFileHash, err := computeHashForFile(sha256.New(), localDownloadPath)
+			assert.NoError(t, err)
+			assert.Equal(t, initialHash, downloadedFileHash)
+			info, err := os.Stat(filepath.Join(user.HomeDir, testFileName))
+			if assert.NoError(t, err) {
+				assert.Less(t, info.Size(), testFileSize/10)
+			}
+			user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+			assert.NoError(t, err)
+			assert.Equal(t, expectedQuotaFiles, user.UsedQuotaFiles)
+			assert.Equal(t, expectedQuotaSize, user.UsedQuotaSize)
+			result, err := client.ReadDir(".")
+			assert.NoError(t, err)
+			if assert.Len(t, result, 1) {
+				assert.Equal(t, testFileSize, result[0].Size())
+			}
+			info, err = client.Stat(testFileName)
+			if assert.NoError(t, err) {
+				assert.Equal(t, testFileSize, info.Size())
+			}
+			// read from an offset
+			f, err := client.Open(testFileName)
+			if assert.NoError(t, err) {
+				offset := testFileSize - 100
+				_, err = f.Seek(offset, io.SeekStart)
+				assert.NoError(t, err)
+				data, err := io.ReadAll(f)
+				assert.NoError(t, err)
+				content, err := os.ReadFile(testFilePath)
+				assert.NoError(t, err)
+				assert.Equal(t, content[offset:], data)
+				err = f.Close()
+				assert.NoError(t, err)
+			}
+			err = client.Remove(testFileName)
+			assert.NoError(t, err)
+			user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+			assert.NoError(t, err)
+			assert.Equal(t, expectedQuotaFiles-1, user.UsedQuotaFiles)
+			assert.Equal(t, expectedQuotaSize-testFileSize, user.UsedQuotaSize)
+			err = os.Remove(testFilePath)
+			assert.NoError(t, err)
+			err = os.Remove(localDownloadPath)
+			assert.NoError(t, err)
+			client.Close()
+			conn.Close()
+		}
+		_, err = httpdtest.RemoveUser(user, http.StatusOK)
+		assert.NoError(t, err)
+		err = os.RemoveAll(user.GetHomeDir())
+		assert.NoError(t, err)
+	}
+}
+
+func TestCompressedFsUncompressedFiles(t *testing.T) {
+	usePubKey := true
+	user, _, err := httpdtest.AddUser(getTestUserWithCompressedFs(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	// files stored before enabling compression must be readable
+	testData := []byte("uncompressed file contents")
+	err = os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), testFileName), testData, os.ModePerm)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		info, err := client.Stat(testFileName)
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(len(testData)), info.Size())
+		}
+		f, err := client.Open(testFileName)
+		if assert.NoError(t, err) {
+			_, err = f.Seek(5, io.SeekStart)
+			assert.NoError(t, err)
+			data, err := io.ReadAll(f)
+			assert.NoError(t, err)
+			assert.Equal(t, testData[5:], data)
+			err = f.Close()
+			assert.NoError(t, err)
+		}
+		// truncate is not supported
+		err = client.Truncate(testFileName, 0)
+		assert.Error(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestQuotaScanCompressedFs(t *testing.T) {
+	usePubKey := false
+	user, _, err := httpdtest.AddUser(getTestUserWithCompressedFs(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	testFileSize, err := createCompressibleTestFile(testFilePath)
+	assert.NoError(t, err)
+	expectedQuotaSize := user.UsedQuotaSize + testFileSize
+	expectedQuotaFiles := user.UsedQuotaFiles + 1
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+	}
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+	// reset the quota and scan the user home dir
+	user.UsedQuotaFiles = 0
+	user.UsedQuotaSize = 0
+	_, err = httpdtest.UpdateQuotaUsage(user, "reset", http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.StartQuotaScan(user, http.StatusAccepted)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		scans, _, err := httpdtest.GetQuotaScans(http.StatusOK)
+		if err == nil {
+			return len(scans) == 0
+		}
+		return false
+	}, 1*time.Second, 50*time.Millisecond)
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedQuotaFiles, user.UsedQuotaFiles)
+	assert.Equal(t, expectedQuotaSize, user.UsedQuotaSize)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestGetMimeTypeCompressedFs(t *testing.T) {
+	usePubKey := true
+	user, _, err := httpdtest.AddUser(getTestUserWithCompressedFs(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		sftpFile, err := client.OpenFile(testFileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if assert.NoError(t, err) {
+			testData := []byte("some UTF-8 text so we should get a text/plain mime type")
+			n, err := sftpFile.Write(testData)
+			assert.NoError(t, err)
+			assert.Equal(t, len(testData), n)
+			err = sftpFile.Close()
+			assert.NoError(t, err)
+		}
+	}
+
+	fs, err := user.GetFilesystem("connID")
+	if assert.NoError(t, err) {
+		assert.True(t, vfs.IsCompressedOsFs(fs))
+		mime, err := fs.GetMimeType(filepath.Join(user.GetHomeDir(), testFileName))
+		assert.NoError(t, err)
+		assert.Equal(t, "text/plain; charset=utf-8", mime)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func createCompressibleTestFile(path string) (int64, error) {
+	line := []byte("2022-10-14T10:00:00Z INFO connection accepted from 192.168.1.100, protocol SFTP\n")
+	content := bytes.Repeat(line, 2000)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return 0, err
+	}
+	return int64(len(content)), os.WriteFile(path, content, os.ModePerm)
+}
+
+func getTestUserWithCompressedFs(usePubKey bool) dataprovider.User {
+	u := getTestUser(usePubKey)
+	u.FsConfig.Provider = vfs.CompressedFilesystemProvider
+	return u
+}
@@ -0,0 +1,251 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package sftpd_test
+
+import (
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/httpdtest"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func TestBasicSFTPDedupHandling(t *testing.T) {
+	usePubKey := false
+	u := getTestUserWithDedupFs(usePubKey)
+	u.QuotaSize = 6553600
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	storePath := user.FsConfig.DedupConfig.StorePath
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65535)
+		err = createTestFile(testFilePath, testFileSize)
+		assert.NoError(t, err)
+		testFileName1 := testFileName + "1"
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, testFileName1, testFileSize, client)
+		assert.NoError(t, err)
+		// the contents are stored once
+		assert.Equal(t, 1, countDedupBlobs(t, storePath))
+		// the files inside the home dir are small references
+		info, err := os.Stat(filepath.Join(user.GetHomeDir(), testFileName))
+		if assert.NoError(t, err) {
+			assert.Less(t, info.Size(), int64(100))
+		}
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, user.UsedQuotaFiles)
+		assert.Equal(t, 2*testFileSize, user.UsedQuotaSize)
+		result, err := client.ReadDir(".")
+		assert.NoError(t, err)
+		if assert.Len(t, result, 2) {
+			assert.Equal(t, testFileSize, result[0].Size())
+			assert.Equal(t, testFileSize, result[1].Size())
+		}
+		localDownloadPath := filepath.Join(homeBasePath, testDLFileName)
+		err = sftpDownloadFile(testFileName1, localDownloadPath, testFileSize, client)
+		assert.NoError(t, err)
+		initialHash, err := computeHashForFile(sha256.New(), testFilePath)
+		assert.NoError(t, err)
+		downloadedFileHash, err := computeHashForFile(sha256.New(), localDownloadPath)
+		assert.NoError(t, err)
+		assert.Equal(t, initialHash, downloadedFileHash)
+		f, err := client.Open(testFileName)
+		if assert.NoError(t, err) {
+			offset := int64(65000)
+			_, err = f.Seek(offset, io.SeekStart)
+			assert.NoError(t, err)
+			data, err := io.ReadAll(f)
+			assert.NoError(t, err)
+			content, err := os.ReadFile(testFilePath)
+			assert.NoError(t, err)
+			assert.Equal(t, content[offset:], data)
+			err = f.Close()
+			assert.NoError(t, err)
+		}
+		// overwrite a file with different contents
+		err = createTestFile(testFilePath, 32768)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, testFileName1, 32768, client)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, countDedupBlobs(t, storePath))
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, user.UsedQuotaFiles)
+		assert.Equal(t, testFileSize+32768, user.UsedQuotaSize)
+		// rename over an existing file, the replaced blob is no longer referenced
+		err = client.PosixRename(testFileName1, testFileName)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, countDedupBlobs(t, storePath))
+		err = client.Remove(testFileName)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, countDedupBlobs(t, storePath))
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, user.UsedQuotaFiles)
+		assert.Equal(t, int64(0), user.UsedQuotaSize)
+		// truncate is not supported
+		err = client.Truncate(testFileName1, 0)
+		assert.Error(t, err)
+		err = os.Remove(testFilePath)
+		assert.NoError(t, err)
+		err = os.Remove(localDownloadPath)
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(storePath)
+	assert.NoError(t, err)
+}
+
+func TestDedupFsPhysicalQuota(t *testing.T) {
+	usePubKey := true
+	u := getTestUserWithDedupFs(usePubKey)
+	u.FsConfig.DedupConfig.QuotaMode = vfs.DedupQuotaModePhysical
+	u.QuotaSize = 6553600
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	// a second user sharing the same store
+	u = getTestSFTPUser(usePubKey)
+	u.HomeDir = filepath.Join(homeBasePath, u.Username)
+	u.FsConfig = user.FsConfig
+	u.QuotaSize = 6553600
+	sftpUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	storePath := user.FsConfig.DedupConfig.StorePath
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	testFileSize := int64(65535)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, testFileName+"1", testFileSize, client)
+		assert.NoError(t, err)
+		result, err := client.ReadDir(".")
+		assert.NoError(t, err)
+		if assert.Len(t, result, 2) {
+			assert.Equal(t, testFileSize, result[0].Size())
+			assert.Equal(t, testFileSize, result[1].Size())
+		}
+		info, err := client.Stat(testFileName + "1")
+		if assert.NoError(t, err) {
+			assert.Equal(t, testFileSize, info.Size())
+		}
+	}
+	conn1, client1, err := getSftpClient(sftpUser, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn1.Close()
+		defer client1.Close()
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client1)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, countDedupBlobs(t, storePath))
+	// only the first upload stored the blob
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, user.UsedQuotaFiles)
+	assert.Equal(t, testFileSize, user.UsedQuotaSize)
+	sftpUser, _, err = httpdtest.GetUserByUsername(sftpUser.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sftpUser.UsedQuotaFiles)
+	assert.Equal(t, int64(0), sftpUser.UsedQuotaSize)
+	// a quota scan must give the same results
+	_, err = httpdtest.UpdateQuotaUsage(user, "reset", http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.StartQuotaScan(user, http.StatusAccepted)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		scans, _, err := httpdtest.GetQuotaScans(http.StatusOK)
+		if err == nil {
+			return len(scans) == 0
+		}
+		return false
+	}, 1*time.Second, 50*time.Millisecond)
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, user.UsedQuotaFiles)
+	assert.Equal(t, testFileSize, user.UsedQuotaSize)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(sftpUser, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(sftpUser.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(storePath)
+	assert.NoError(t, err)
+}
+
+func TestDedupFsStorePathValidation(t *testing.T) {
+	u := getTestUserWithDedupFs(true)
+	u.FsConfig.DedupConfig.StorePath = filepath.Join(u.HomeDir, "store")
+	_, resp, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "cannot overlap the root directory")
+	u.FsConfig.DedupConfig.StorePath = "relative"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "must be an absolute path")
+	u.FsConfig.DedupConfig.StorePath = ""
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "store path is required")
+}
+
+func countDedupBlobs(t *testing.T, storePath string) int {
+	blobs := 0
+	err := filepath.Walk(filepath.Join(storePath, "blobs"), func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			blobs++
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		assert.NoError(t, err)
+	}
+	return blobs
+}
+
+func getTestUserWithDedupFs(usePubKey bool) dataprovider.User {
+	u := getTestUser(usePubKey)
+	u.FsConfig.Provider = vfs.DedupFilesystemProvider
+	u.FsConfig.DedupConfig.StorePath = filepath.Join(homeBasePath, "dedup_store")
+	return u
+}
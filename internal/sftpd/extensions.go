@@ -0,0 +1,651 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package sftpd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime/debug"
+	"sort"
+	"sync"
+
+	"github.com/pkg/sftp"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	sshFxpVersion  = 2
+	sshFxpRead     = 5
+	sshFxpWrite    = 6
+	sshFxpLstat    = 7
+	sshFxpStat     = 17
+	sshFxpStatus   = 101
+	sshFxpData     = 103
+	sshFxpAttrs    = 105
+	sshFxpExtended = 200
+)
+
+const sshFileXferAttrExtended = 0x80000000
+
+const (
+	sshFxOk               = 0
+	sshFxEOF              = 1
+	sshFxNoSuchFile       = 2
+	sshFxPermissionDenied = 3
+	sshFxFailure          = 4
+	sshFxBadMessage       = 5
+	sshFxOpUnsupported    = 8
+)
+
+const (
+	extCopyData = "copy-data"
+	extCopyFile = "copy-file"
+	// max allowed length for an SFTP packet, this is the same limit enforced by pkg/sftp
+	maxSFTPPacketLength = 256 * 1024
+	// size of the read requests sent to the request server to execute copy-data
+	copyDataChunkSize = 32768
+)
+
+var (
+	errShortPacket      = errors.New("packet too short")
+	errLongPacket       = errors.New("packet too long")
+	errChannelClosed    = errors.New("channel closed")
+	errCopyDataOverlap  = errors.New("copy-data: the read and write ranges overlap")
+	supportedExtensions = []string{extCopyData, extCopyFile}
+)
+
+// extensionsHandler defines the interface to execute the operations required by
+// the extensions using virtual paths
+type extensionsHandler interface {
+	CopyFile(source, target string, overwrite bool) error
+	GetCustomMetadata(virtualPath string) (map[string]string, error)
+}
+
+type sftpStatusError struct {
+	code uint32
+	msg  string
+}
+
+func (e *sftpStatusError) Error() string {
+	return fmt.Sprintf("sftp status %d: %s", e.code, e.msg)
+}
+
+// extensionsChannel wraps the channel used for an SFTP session and implements the
+// copy-data and copy-file extensions, not supported by pkg/sftp.
+// If enabled, the file metadata are added, as extended attributes, to the stat and
+// lstat responses, pkg/sftp never returns extended attributes.
+// All the other packets are forwarded unchanged to the SFTP request server.
+//
+// copy-file is executed using the storage backend copy capabilities, if any.
+// copy-data refers to handles opened inside the request server, so it is
+// executed sending read and write requests to the request server: the data
+// are not sent to the client and the quota and permissions checks are the same
+// as for uploads
+type extensionsChannel struct {
+	channel        io.ReadWriteCloser
+	connection     *Connection
+	handler        extensionsHandler
+	startDirectory string
+	copyExtensions bool
+	customMetadata bool
+	pipeReader     *io.PipeReader
+	pipeWriter     *io.PipeWriter
+	done           chan struct{}
+	closeOnce      sync.Once
+	// buffer for the packets partially written by the request server
+	outBuf  []byte
+	outMu   sync.Mutex
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	cond    *sync.Cond
+	closed  bool
+	// number of forwarded requests waiting for a response
+	pending int
+	// if not nil, the responses are sent to this channel and not to the client
+	responses chan []byte
+	// virtual paths for the pending stat requests, keyed by request id
+	statRequests map[uint32]string
+}
+
+func newExtensionsChannel(channel io.ReadWriteCloser, connection *Connection, handler extensionsHandler,
+	startDirectory string, copyExtensions, customMetadata bool,
+) *extensionsChannel {
+	pipeReader, pipeWriter := io.Pipe()
+	c := &extensionsChannel{
+		channel:        channel,
+		connection:     connection,
+		handler:        handler,
+		startDirectory: util.CleanPath(startDirectory),
+		copyExtensions: copyExtensions,
+		customMetadata: customMetadata,
+		pipeReader:     pipeReader,
+		pipeWriter:     pipeWriter,
+		done:           make(chan struct{}),
+		statRequests:   make(map[uint32]string),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	go c.readLoop()
+	return c
+}
+
+// Read returns the data to the request server
+func (c *extensionsChannel) Read(p []byte) (int, error) {
+	return c.pipeReader.Read(p)
+}
+
+// Write receives the responses from the request server
+func (c *extensionsChannel) Write(p []byte) (int, error) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+
+	c.outBuf = append(c.outBuf, p...)
+	for len(c.outBuf) >= 4 {
+		length := int(binary.BigEndian.Uint32(c.outBuf))
+		if len(c.outBuf)-4 < length {
+			break
+		}
+		if err := c.handleResponse(c.outBuf[:4+length]); err != nil {
+			return 0, err
+		}
+		c.outBuf = append(c.outBuf[:0], c.outBuf[4+length:]...)
+	}
+	return len(p), nil
+}
+
+// Close closes the underlying channel
+func (c *extensionsChannel) Close() error {
+	c.setClosed()
+	c.pipeReader.Close()
+	return c.channel.Close()
+}
+
+func (c *extensionsChannel) setClosed() {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		c.cond.Broadcast()
+		c.mu.Unlock()
+		close(c.done)
+	})
+}
+
+func (c *extensionsChannel) handleResponse(packet []byte) error {
+	c.mu.Lock()
+	if c.pending > 0 {
+		c.pending--
+		if c.pending == 0 {
+			c.cond.Broadcast()
+		}
+	}
+	responses := c.responses
+	var statPath string
+	if len(packet) >= 9 && len(c.statRequests) > 0 {
+		id := binary.BigEndian.Uint32(packet[5:9])
+		statPath = c.statRequests[id]
+		delete(c.statRequests, id)
+	}
+	c.mu.Unlock()
+
+	if len(packet) > 4 {
+		switch packet[4] {
+		case sshFxpVersion:
+			if c.copyExtensions {
+				packet = addExtensionsToVersionPacket(packet)
+			}
+		case sshFxpAttrs:
+			if statPath != "" {
+				packet = c.addMetadataToAttrsPacket(packet, statPath)
+			}
+		}
+	}
+	if responses != nil {
+		responses <- append([]byte(nil), packet[4:]...)
+		return nil
+	}
+	return c.writePacket(packet)
+}
+
+func (c *extensionsChannel) writePacket(packet []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_, err := c.channel.Write(packet)
+	return err
+}
+
+func (c *extensionsChannel) readLoop() {
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(logSender, "", "panic in extensions channel: %#v stack trace: %v", r, string(debug.Stack()))
+			err = errChannelClosed
+		}
+		c.setClosed()
+		c.pipeWriter.CloseWithError(err)
+	}()
+
+	err = c.serve()
+}
+
+func (c *extensionsChannel) serve() error {
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(c.channel, header); err != nil {
+			return err
+		}
+		length := binary.BigEndian.Uint32(header)
+		if length > maxSFTPPacketLength {
+			return errLongPacket
+		}
+		if length == 0 {
+			return errShortPacket
+		}
+		packet := make([]byte, 4+length)
+		copy(packet, header)
+		if _, err := io.ReadFull(c.channel, packet[4:]); err != nil {
+			return err
+		}
+		switch packet[4] {
+		case sshFxpExtended:
+			if c.copyExtensions {
+				handled, err := c.handleExtendedRequest(packet[5:])
+				if err != nil {
+					return err
+				}
+				if handled {
+					continue
+				}
+			}
+		case sshFxpStat, sshFxpLstat:
+			if c.customMetadata {
+				c.addStatRequest(packet[5:])
+			}
+		}
+		if err := c.forward(packet); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *extensionsChannel) forward(packet []byte) error {
+	c.mu.Lock()
+	c.pending++
+	c.mu.Unlock()
+
+	_, err := c.pipeWriter.Write(packet)
+	return err
+}
+
+// waitForPendingRequests waits until the request server replies to all the
+// forwarded requests, this way the extensions are executed after any previous
+// requests, for example a write to the file to copy
+func (c *extensionsChannel) waitForPendingRequests() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.pending > 0 && !c.closed {
+		c.cond.Wait()
+	}
+	if c.closed {
+		return errChannelClosed
+	}
+	return nil
+}
+
+// addStatRequest saves the path for a stat request, the metadata for this path
+// will be added to the response
+func (c *extensionsChannel) addStatRequest(data []byte) {
+	id, data, err := unmarshalUint32(data)
+	if err != nil {
+		return
+	}
+	p, _, err := unmarshalString(data)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.statRequests[id] = c.getVirtualPath(p)
+}
+
+func (c *extensionsChannel) addMetadataToAttrsPacket(packet []byte, virtualPath string) []byte {
+	if len(packet) < 13 {
+		return packet
+	}
+	if binary.BigEndian.Uint32(packet[9:13])&sshFileXferAttrExtended != 0 {
+		return packet
+	}
+	metadata, err := c.handler.GetCustomMetadata(virtualPath)
+	if err != nil {
+		c.connection.Log(logger.LevelDebug, "unable to get metadata for stat response, path %q: %v",
+			virtualPath, err)
+		return packet
+	}
+	return addExtendedAttributesToAttrsPacket(packet, metadata)
+}
+
+func (c *extensionsChannel) setResponsesChannel(responses chan []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.responses = responses
+}
+
+func (c *extensionsChannel) handleExtendedRequest(data []byte) (bool, error) {
+	id, data, err := unmarshalUint32(data)
+	if err != nil {
+		return false, nil
+	}
+	name, data, err := unmarshalString(data)
+	if err != nil || !util.Contains(supportedExtensions, name) {
+		return false, nil
+	}
+	if err := c.waitForPendingRequests(); err != nil {
+		return true, err
+	}
+	c.connection.UpdateLastActivity()
+
+	switch name {
+	case extCopyData:
+		err = c.copyData(id, data)
+	default:
+		err = c.copyFile(data)
+	}
+	if errors.Is(err, errChannelClosed) {
+		return true, err
+	}
+	return true, c.writeStatus(id, err)
+}
+
+func (c *extensionsChannel) getVirtualPath(p string) string {
+	if !path.IsAbs(p) {
+		p = path.Join(c.startDirectory, p)
+	}
+	return util.CleanPath(p)
+}
+
+func (c *extensionsChannel) copyFile(data []byte) error {
+	source, data, err := unmarshalString(data)
+	if err != nil {
+		return &sftpStatusError{code: sshFxBadMessage, msg: err.Error()}
+	}
+	target, data, err := unmarshalString(data)
+	if err != nil {
+		return &sftpStatusError{code: sshFxBadMessage, msg: err.Error()}
+	}
+	if len(data) < 1 {
+		return &sftpStatusError{code: sshFxBadMessage, msg: errShortPacket.Error()}
+	}
+	overwrite := data[0] != 0
+	source = c.getVirtualPath(source)
+	target = c.getVirtualPath(target)
+
+	c.connection.Log(logger.LevelDebug, "copy-file requested %q -> %q, overwrite: %t", source, target, overwrite)
+	return c.handler.CopyFile(source, target, overwrite)
+}
+
+func (c *extensionsChannel) copyData(id uint32, data []byte) error {
+	readHandle, data, err := unmarshalString(data)
+	if err != nil {
+		return &sftpStatusError{code: sshFxBadMessage, msg: err.Error()}
+	}
+	readOffset, data, err := unmarshalUint64(data)
+	if err != nil {
+		return &sftpStatusError{code: sshFxBadMessage, msg: err.Error()}
+	}
+	readLength, data, err := unmarshalUint64(data)
+	if err != nil {
+		return &sftpStatusError{code: sshFxBadMessage, msg: err.Error()}
+	}
+	writeHandle, data, err := unmarshalString(data)
+	if err != nil {
+		return &sftpStatusError{code: sshFxBadMessage, msg: err.Error()}
+	}
+	writeOffset, _, err := unmarshalUint64(data)
+	if err != nil {
+		return &sftpStatusError{code: sshFxBadMessage, msg: err.Error()}
+	}
+	if readHandle == writeHandle {
+		if readLength == 0 || (writeOffset < readOffset+readLength && readOffset < writeOffset+readLength) {
+			return &sftpStatusError{code: sshFxFailure, msg: errCopyDataOverlap.Error()}
+		}
+	}
+	c.connection.Log(logger.LevelDebug, "copy-data requested, read offset: %d, length: %d, write offset: %d",
+		readOffset, readLength, writeOffset)
+
+	c.setResponsesChannel(make(chan []byte, 1))
+	defer c.setResponsesChannel(nil)
+
+	var copied uint64
+	for readLength == 0 || copied < readLength {
+		chunkSize := uint64(copyDataChunkSize)
+		if readLength > 0 && readLength-copied < chunkSize {
+			chunkSize = readLength - copied
+		}
+		resp, err := c.sendRequest(marshalReadPacket(id, readHandle, readOffset+copied, uint32(chunkSize)))
+		if err != nil {
+			return err
+		}
+		chunk, err := getDataFromResponse(resp)
+		if err != nil {
+			var statusErr *sftpStatusError
+			if errors.As(err, &statusErr) && statusErr.code == sshFxEOF {
+				break
+			}
+			return err
+		}
+		if len(chunk) == 0 {
+			break
+		}
+		resp, err = c.sendRequest(marshalWritePacket(id, writeHandle, writeOffset+copied, chunk))
+		if err != nil {
+			return err
+		}
+		if err := getErrorFromStatusResponse(resp); err != nil {
+			return err
+		}
+		copied += uint64(len(chunk))
+	}
+	c.connection.Log(logger.LevelDebug, "copy-data completed, copied bytes: %d", copied)
+	return nil
+}
+
+// sendRequest sends the specified packet to the request server and waits for the response
+func (c *extensionsChannel) sendRequest(packet []byte) ([]byte, error) {
+	if err := c.forward(packet); err != nil {
+		return nil, errChannelClosed
+	}
+	select {
+	case resp := <-c.responses:
+		return resp, nil
+	case <-c.done:
+		return nil, errChannelClosed
+	}
+}
+
+func (c *extensionsChannel) writeStatus(id uint32, err error) error {
+	code, msg := getStatusFromError(err)
+	if code != sshFxOk {
+		c.connection.Log(logger.LevelDebug, "extended request failed, status: %d, error: %v", code, err)
+	}
+	packet := make([]byte, 4, 64)
+	packet = append(packet, sshFxpStatus)
+	packet = appendUint32(packet, id)
+	packet = appendUint32(packet, code)
+	packet = appendString(packet, msg)
+	packet = appendString(packet, "")
+	binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+
+	return c.writePacket(packet)
+}
+
+func getStatusFromError(err error) (uint32, string) {
+	var statusErr *sftpStatusError
+
+	switch {
+	case err == nil:
+		return sshFxOk, ""
+	case errors.As(err, &statusErr):
+		return statusErr.code, statusErr.msg
+	case errors.Is(err, sftp.ErrSSHFxNoSuchFile), errors.Is(err, os.ErrNotExist):
+		return sshFxNoSuchFile, err.Error()
+	case errors.Is(err, sftp.ErrSSHFxPermissionDenied), errors.Is(err, os.ErrPermission):
+		return sshFxPermissionDenied, err.Error()
+	case errors.Is(err, sftp.ErrSSHFxOpUnsupported):
+		return sshFxOpUnsupported, err.Error()
+	default:
+		return sshFxFailure, err.Error()
+	}
+}
+
+// getDataFromResponse returns the data from an SSH_FXP_DATA response or the
+// error for an SSH_FXP_STATUS response
+func getDataFromResponse(resp []byte) ([]byte, error) {
+	if len(resp) < 5 {
+		return nil, &sftpStatusError{code: sshFxFailure, msg: errShortPacket.Error()}
+	}
+	switch resp[0] {
+	case sshFxpData:
+		data, _, err := unmarshalString(resp[5:])
+		if err != nil {
+			return nil, &sftpStatusError{code: sshFxFailure, msg: err.Error()}
+		}
+		return []byte(data), nil
+	case sshFxpStatus:
+		return nil, getErrorFromStatusResponse(resp)
+	default:
+		return nil, &sftpStatusError{code: sshFxFailure, msg: fmt.Sprintf("unexpected response type %d", resp[0])}
+	}
+}
+
+func getErrorFromStatusResponse(resp []byte) error {
+	if len(resp) < 5 || resp[0] != sshFxpStatus {
+		return &sftpStatusError{code: sshFxFailure, msg: "unexpected response"}
+	}
+	code, data, err := unmarshalUint32(resp[5:])
+	if err != nil {
+		return &sftpStatusError{code: sshFxFailure, msg: err.Error()}
+	}
+	if code == sshFxOk {
+		return nil
+	}
+	msg, _, _ := unmarshalString(data)
+	return &sftpStatusError{code: code, msg: msg}
+}
+
+func addExtensionsToVersionPacket(packet []byte) []byte {
+	result := make([]byte, len(packet), len(packet)+64)
+	copy(result, packet)
+	for _, ext := range supportedExtensions {
+		result = appendString(result, ext)
+		result = appendString(result, "1")
+	}
+	binary.BigEndian.PutUint32(result, uint32(len(result)-4))
+	return result
+}
+
+// addExtendedAttributesToAttrsPacket adds the specified metadata as extended
+// attributes to an SSH_FXP_ATTRS packet without extended attributes. The
+// extended attributes are the last field in the protocol version 3 encoding
+func addExtendedAttributesToAttrsPacket(packet []byte, metadata map[string]string) []byte {
+	if len(metadata) == 0 {
+		return packet
+	}
+	keys := make([]string, 0, len(metadata))
+	size := 4
+	for k, v := range metadata {
+		keys = append(keys, k)
+		size += 8 + len(k) + len(v)
+	}
+	sort.Strings(keys)
+
+	result := make([]byte, len(packet), len(packet)+size)
+	copy(result, packet)
+	binary.BigEndian.PutUint32(result[9:], binary.BigEndian.Uint32(packet[9:13])|sshFileXferAttrExtended)
+	result = appendUint32(result, uint32(len(keys)))
+	for _, k := range keys {
+		result = appendString(result, k)
+		result = appendString(result, metadata[k])
+	}
+	binary.BigEndian.PutUint32(result, uint32(len(result)-4))
+	return result
+}
+
+func marshalReadPacket(id uint32, handle string, offset uint64, length uint32) []byte {
+	packet := make([]byte, 4, 4+1+4+4+len(handle)+8+4)
+	packet = append(packet, sshFxpRead)
+	packet = appendUint32(packet, id)
+	packet = appendString(packet, handle)
+	packet = appendUint64(packet, offset)
+	packet = appendUint32(packet, length)
+	binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+	return packet
+}
+
+func marshalWritePacket(id uint32, handle string, offset uint64, data []byte) []byte {
+	packet := make([]byte, 4, 4+1+4+4+len(handle)+8+4+len(data))
+	packet = append(packet, sshFxpWrite)
+	packet = appendUint32(packet, id)
+	packet = appendString(packet, handle)
+	packet = appendUint64(packet, offset)
+	packet = appendUint32(packet, uint32(len(data)))
+	packet = append(packet, data...)
+	binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+	return packet
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return binary.BigEndian.AppendUint32(b, v)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return binary.BigEndian.AppendUint64(b, v)
+}
+
+func appendString(b []byte, v string) []byte {
+	b = appendUint32(b, uint32(len(v)))
+	return append(b, v...)
+}
+
+func unmarshalUint32(b []byte) (uint32, []byte, error) {
+	if len(b) < 4 {
+		return 0, nil, errShortPacket
+	}
+	return binary.BigEndian.Uint32(b), b[4:], nil
+}
+
+func unmarshalUint64(b []byte) (uint64, []byte, error) {
+	if len(b) < 8 {
+		return 0, nil, errShortPacket
+	}
+	return binary.BigEndian.Uint64(b), b[8:], nil
+}
+
+func unmarshalString(b []byte) (string, []byte, error) {
+	length, b, err := unmarshalUint32(b)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint32(len(b)) < length {
+		return "", nil, errShortPacket
+	}
+	return string(b[:length]), b[length:], nil
+}
@@ -0,0 +1,789 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	// hostKeysRequest is the OpenSSH extension used to inform the clients about all the host keys
+	// accepted by the server, see PROTOCOL in the OpenSSH sources
+	hostKeysRequest = "hostkeys-00@openssh.com"
+	// hostKeysProveRequest is sent by the clients to ask the server to prove the ownership of
+	// the private keys for the host keys they don't know
+	hostKeysProveRequest  = "hostkeys-prove-00@openssh.com"
+	hostKeysSessionKey    = "sftpd_host_keys"
+	hostKeysReloadTimeout = 30 * time.Second
+	maxHostKeyGracePeriod = 60 * 24 * 30
+)
+
+// Supported sources for host keys
+const (
+	HostKeySourceConfig  = "config"
+	HostKeySourceRuntime = "runtime"
+)
+
+// Supported host key statuses
+const (
+	// HostKeyStatusActive means that the key is used in the SSH handshake
+	HostKeyStatusActive = "active"
+	// HostKeyStatusPending means that the key is advertised to the clients but it
+	// will be used in the SSH handshake only after the grace period
+	HostKeyStatusPending = "pending"
+	// HostKeyStatusRetiring means that the key is used in the SSH handshake until
+	// its retirement time
+	HostKeyStatusRetiring = "retiring"
+	// HostKeyStatusSuperseded means that the key is defined in the configuration file
+	// but a runtime key of the same type replaces it
+	HostKeyStatusSuperseded = "superseded"
+)
+
+var (
+	hostKeys                = newHostKeysManager()
+	supportedGeneratedTypes = []string{"rsa", "ecdsa", "ed25519"}
+	errHostKeysNotAvailable = util.NewValidationError("host keys can be managed only if the SFTP service is active")
+)
+
+// HostKeyInfo defines the details for a configured or runtime host key
+type HostKeyInfo struct {
+	ID          string `json:"id"`
+	Source      string `json:"source"`
+	Status      string `json:"status"`
+	Path        string `json:"path,omitempty"`
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"public_key"`
+	CreatedAt   int64  `json:"created_at,omitempty"`
+	ActivateAt  int64  `json:"activate_at,omitempty"`
+	RetireAt    int64  `json:"retire_at,omitempty"`
+}
+
+type configHostKey struct {
+	path   string
+	signer ssh.Signer
+	// certificates signed for this host key
+	certSigners []ssh.Signer
+}
+
+func (k *configHostKey) getInfo(status string) HostKeyInfo {
+	return HostKeyInfo{
+		ID:          k.path,
+		Source:      HostKeySourceConfig,
+		Status:      status,
+		Path:        k.path,
+		Type:        k.signer.PublicKey().Type(),
+		Fingerprint: ssh.FingerprintSHA256(k.signer.PublicKey()),
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k.signer.PublicKey()))),
+	}
+}
+
+// runtimeHostKey defines an host key added using the REST API,
+// the private key is stored encrypted in the data provider
+type runtimeHostKey struct {
+	ID         string      `json:"id"`
+	PrivateKey *kms.Secret `json:"private_key"`
+	CreatedAt  int64       `json:"created_at"`
+	ActivateAt int64       `json:"activate_at"`
+	RetireAt   int64       `json:"retire_at,omitempty"`
+	signer     ssh.Signer
+}
+
+func (k *runtimeHostKey) getType() string {
+	return k.signer.PublicKey().Type()
+}
+
+func (k *runtimeHostKey) isRetired(now int64) bool {
+	return k.RetireAt > 0 && k.RetireAt <= now
+}
+
+func (k *runtimeHostKey) isActive(now int64) bool {
+	return k.ActivateAt <= now && !k.isRetired(now)
+}
+
+func (k *runtimeHostKey) getInfo(status string) HostKeyInfo {
+	return HostKeyInfo{
+		ID:          k.ID,
+		Source:      HostKeySourceRuntime,
+		Status:      status,
+		Type:        k.getType(),
+		Fingerprint: ssh.FingerprintSHA256(k.signer.PublicKey()),
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k.signer.PublicKey()))),
+		CreatedAt:   k.CreatedAt,
+		ActivateAt:  k.ActivateAt,
+		RetireAt:    k.RetireAt,
+	}
+}
+
+func (k *runtimeHostKey) loadSigner() error {
+	// decrypt a copy, the encrypted key will be saved again as is
+	secret := k.PrivateKey.Clone()
+	if err := secret.TryDecrypt(); err != nil {
+		return fmt.Errorf("unable to decrypt host key %q: %w", k.ID, err)
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(secret.GetPayload()))
+	if err != nil {
+		return fmt.Errorf("unable to parse host key %q: %w", k.ID, err)
+	}
+	k.signer = signer
+	return nil
+}
+
+// hostKeySet defines the host keys to use for a given point in time
+type hostKeySet struct {
+	// signers to add to the server configuration
+	handshake []ssh.Signer
+	// all the keys to advertise to the clients using the hostkeys extension
+	advertised []ssh.Signer
+	// true if there are pending or retiring keys
+	isRotating bool
+}
+
+// hostKeysServer defines the host keys configuration for an SFTP server
+type hostKeysServer struct {
+	// same configuration used for the server but without host keys
+	template   ssh.ServerConfig
+	configKeys []configHostKey
+	isServing  bool
+}
+
+// hostKeysManager allows to add and retire host keys at runtime. The runtime keys
+// are persisted in the data provider as a shared session so all the nodes of a
+// multi-node setup will use the same keys
+type hostKeysManager struct {
+	sync.RWMutex
+	servers     map[*ssh.ServerConfig]*hostKeysServer
+	runtimeKeys []*runtimeHostKey
+	loadedAt    time.Time
+	isReloading atomic.Bool
+}
+
+func newHostKeysManager() *hostKeysManager {
+	return &hostKeysManager{
+		servers: make(map[*ssh.ServerConfig]*hostKeysServer),
+	}
+}
+
+func (m *hostKeysManager) setConfigKeys(serverConfig *ssh.ServerConfig, configKeys []configHostKey) {
+	m.Lock()
+	defer m.Unlock()
+
+	server, ok := m.servers[serverConfig]
+	if !ok {
+		server = &hostKeysServer{}
+		m.servers[serverConfig] = server
+	}
+	server.configKeys = configKeys
+}
+
+// addServer adds the host keys defined in the configuration file to serverConfig
+// and enables the runtime host keys for the connections using it.
+// serverConfig must be fully configured
+func (m *hostKeysManager) addServer(serverConfig *ssh.ServerConfig) {
+	m.Lock()
+	server, ok := m.servers[serverConfig]
+	if !ok {
+		server = &hostKeysServer{}
+		m.servers[serverConfig] = server
+	}
+	// the configuration without host keys is the template for the connections
+	// using the runtime host keys
+	server.template = *serverConfig
+	for idx := range server.configKeys {
+		k := &server.configKeys[idx]
+		serverConfig.AddHostKey(k.signer)
+		for _, signer := range k.certSigners {
+			serverConfig.AddHostKey(signer)
+		}
+	}
+	server.isServing = true
+	m.Unlock()
+
+	if err := m.reload(); err != nil {
+		logger.Warn(logSender, "", "unable to load runtime host keys: %v", err)
+	}
+}
+
+func (m *hostKeysManager) isInitialized() bool {
+	for _, server := range m.servers {
+		if server.isServing {
+			return true
+		}
+	}
+	return false
+}
+
+// getConfigKeys returns the host keys defined in the configuration file for all
+// the serving SFTP servers
+func (m *hostKeysManager) getConfigKeys() []*configHostKey {
+	var result []*configHostKey
+	for _, server := range m.servers {
+		if !server.isServing {
+			continue
+		}
+		for idx := range server.configKeys {
+			k := &server.configKeys[idx]
+			isDuplicated := false
+			for _, added := range result {
+				if added.path == k.path {
+					isDuplicated = true
+					break
+				}
+			}
+			if !isDuplicated {
+				result = append(result, k)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].path < result[j].path
+	})
+	return result
+}
+
+func (m *hostKeysManager) loadFromProvider() ([]*runtimeHostKey, error) {
+	session, err := dataprovider.GetSharedSession(hostKeysSessionKey)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if _, ok := err.(*util.RecordNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if session.Type != dataprovider.SessionTypeHostKeys {
+		return nil, fmt.Errorf("unexpected session type for runtime host keys: %v", session.Type)
+	}
+	data, ok := session.Data.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("invalid runtime host keys data type %T", session.Data)
+	}
+	var keys []*runtimeHostKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	result := make([]*runtimeHostKey, 0, len(keys))
+	for _, k := range keys {
+		if k.PrivateKey == nil {
+			logger.Warn(logSender, "", "skipping runtime host key %q without private key", k.ID)
+			continue
+		}
+		if err := k.loadSigner(); err != nil {
+			logger.Warn(logSender, "", "skipping runtime host key: %v", err)
+			continue
+		}
+		result = append(result, k)
+	}
+	return result, nil
+}
+
+// reload loads the runtime host keys from the data provider. The data providers
+// not supporting shared sessions keep the runtime host keys in memory only
+func (m *hostKeysManager) reload() error {
+	m.RLock()
+	loadedAt := m.loadedAt
+	m.RUnlock()
+
+	keys, err := m.loadFromProvider()
+	if err != nil && !errors.Is(err, dataprovider.ErrNotImplemented) {
+		return err
+	}
+
+	m.Lock()
+	// the keys could be updated while we were loading them
+	if m.loadedAt.Equal(loadedAt) {
+		if err == nil {
+			m.runtimeKeys = keys
+		}
+		m.loadedAt = time.Now()
+	}
+	m.Unlock()
+
+	m.updateFingerprints()
+	return nil
+}
+
+// checkReload reloads the runtime host keys in background if they are not
+// recently loaded, this way changes made on other nodes are applied
+func (m *hostKeysManager) checkReload() {
+	m.RLock()
+	isStale := time.Since(m.loadedAt) > hostKeysReloadTimeout
+	m.RUnlock()
+
+	if !isStale || !m.isReloading.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer m.isReloading.Store(false)
+
+		if err := m.reload(); err != nil {
+			logger.Warn(logSender, "", "unable to reload runtime host keys: %v", err)
+		}
+	}()
+}
+
+func (m *hostKeysManager) save(keys []*runtimeHostKey) error {
+	err := dataprovider.AddSharedSession(dataprovider.Session{
+		Key:       hostKeysSessionKey,
+		Data:      keys,
+		Type:      dataprovider.SessionTypeHostKeys,
+		Timestamp: util.GetTimeAsMsSinceEpoch(time.Now()),
+	})
+	if errors.Is(err, dataprovider.ErrNotImplemented) {
+		logger.Debug(logSender, "", "shared sessions not supported, runtime host keys will be kept in memory only")
+		return nil
+	}
+	return err
+}
+
+// update applies the specified change to the most recent runtime host keys,
+// removes the retired ones and saves the result
+func (m *hostKeysManager) update(fn func(keys []*runtimeHostKey, now int64) ([]*runtimeHostKey, error)) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if !m.isInitialized() {
+		return errHostKeysNotAvailable
+	}
+	keys, err := m.loadFromProvider()
+	if err != nil {
+		if !errors.Is(err, dataprovider.ErrNotImplemented) {
+			return err
+		}
+		keys = m.runtimeKeys
+	}
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	keys, err = fn(keys, now)
+	if err != nil {
+		return err
+	}
+	result := make([]*runtimeHostKey, 0, len(keys))
+	for _, k := range keys {
+		if !k.isRetired(now) {
+			result = append(result, k)
+		}
+	}
+	if err := m.save(result); err != nil {
+		return err
+	}
+	m.runtimeKeys = result
+	m.loadedAt = time.Now()
+	return nil
+}
+
+// getActiveRuntimeKeys returns the active runtime key, if any, for each key type,
+// the most recently activated key wins
+func (m *hostKeysManager) getActiveRuntimeKeys(now int64) map[string]*runtimeHostKey {
+	active := make(map[string]*runtimeHostKey)
+	for _, k := range m.runtimeKeys {
+		if !k.isActive(now) {
+			continue
+		}
+		if current, ok := active[k.getType()]; !ok || k.ActivateAt > current.ActivateAt {
+			active[k.getType()] = k
+		}
+	}
+	return active
+}
+
+func (m *hostKeysManager) getKeySet(server *hostKeysServer, now int64) hostKeySet {
+	var set hostKeySet
+
+	active := m.getActiveRuntimeKeys(now)
+	for idx := range server.configKeys {
+		k := &server.configKeys[idx]
+		if _, ok := active[k.signer.PublicKey().Type()]; ok {
+			continue
+		}
+		set.handshake = append(set.handshake, k.signer)
+		set.handshake = append(set.handshake, k.certSigners...)
+		set.advertised = append(set.advertised, k.signer)
+	}
+	for _, k := range m.runtimeKeys {
+		if k.isRetired(now) {
+			continue
+		}
+		if active[k.getType()] == k {
+			set.handshake = append(set.handshake, k.signer)
+		}
+		if k.ActivateAt > now || k.RetireAt > 0 {
+			set.isRotating = true
+		}
+		set.advertised = append(set.advertised, k.signer)
+	}
+	return set
+}
+
+// getConnectionConfig returns the server configuration to use for a new connection
+// and the host keys to advertise to the client, if a rotation is in progress
+func (m *hostKeysManager) getConnectionConfig(config *ssh.ServerConfig) (*ssh.ServerConfig, []ssh.Signer) {
+	m.checkReload()
+
+	m.RLock()
+	defer m.RUnlock()
+
+	server, ok := m.servers[config]
+	if !ok || !server.isServing || len(m.runtimeKeys) == 0 {
+		return config, nil
+	}
+	set := m.getKeySet(server, util.GetTimeAsMsSinceEpoch(time.Now()))
+	connConfig := server.template
+	for _, signer := range set.handshake {
+		connConfig.AddHostKey(signer)
+	}
+	if !set.isRotating {
+		return &connConfig, nil
+	}
+	return &connConfig, set.advertised
+}
+
+func (m *hostKeysManager) getHostKeys() ([]HostKeyInfo, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if !m.isInitialized() {
+		return nil, errHostKeysNotAvailable
+	}
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	active := m.getActiveRuntimeKeys(now)
+	configKeys := m.getConfigKeys()
+	result := make([]HostKeyInfo, 0, len(configKeys)+len(m.runtimeKeys))
+	for _, k := range configKeys {
+		status := HostKeyStatusActive
+		if _, ok := active[k.signer.PublicKey().Type()]; ok {
+			status = HostKeyStatusSuperseded
+		}
+		result = append(result, k.getInfo(status))
+	}
+	for _, k := range m.runtimeKeys {
+		if k.isRetired(now) {
+			continue
+		}
+		status := HostKeyStatusActive
+		if k.ActivateAt > now {
+			status = HostKeyStatusPending
+		} else if k.RetireAt > 0 {
+			status = HostKeyStatusRetiring
+		}
+		result = append(result, k.getInfo(status))
+	}
+	return result, nil
+}
+
+// getUsedHostKeys returns the host keys currently used in the SSH handshake
+func (m *hostKeysManager) getUsedHostKeys() []HostKey {
+	m.RLock()
+	defer m.RUnlock()
+
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	active := m.getActiveRuntimeKeys(now)
+	var result []HostKey
+	for _, k := range m.getConfigKeys() {
+		if _, ok := active[k.signer.PublicKey().Type()]; ok {
+			continue
+		}
+		result = append(result, HostKey{
+			Path:        k.path,
+			Fingerprint: ssh.FingerprintSHA256(k.signer.PublicKey()),
+		})
+	}
+	for _, k := range m.runtimeKeys {
+		if active[k.getType()] == k {
+			result = append(result, HostKey{
+				Fingerprint: ssh.FingerprintSHA256(k.signer.PublicKey()),
+			})
+		}
+	}
+	return result
+}
+
+// updateFingerprints sets the fingerprints of all the known host keys, they are
+// used to detect SFTP self connections
+func (m *hostKeysManager) updateFingerprints() {
+	m.RLock()
+	defer m.RUnlock()
+
+	configKeys := m.getConfigKeys()
+	fp := make([]string, 0, len(configKeys)+len(m.runtimeKeys))
+	for _, k := range configKeys {
+		fp = append(fp, ssh.FingerprintSHA256(k.signer.PublicKey()))
+	}
+	for _, k := range m.runtimeKeys {
+		fp = append(fp, ssh.FingerprintSHA256(k.signer.PublicKey()))
+	}
+	vfs.SetSFTPFingerprints(fp)
+}
+
+func (m *hostKeysManager) addKey(signer ssh.Signer, privateKey []byte, gracePeriod int) (HostKeyInfo, error) {
+	if err := validateHostKeyGracePeriod(gracePeriod); err != nil {
+		return HostKeyInfo{}, err
+	}
+	secret := kms.NewPlainSecret(string(privateKey))
+	if err := secret.Encrypt(); err != nil {
+		return HostKeyInfo{}, fmt.Errorf("unable to encrypt the host key: %w", err)
+	}
+	key := &runtimeHostKey{
+		ID:         util.GenerateUniqueID(),
+		PrivateKey: secret,
+		signer:     signer,
+	}
+	fp := ssh.FingerprintSHA256(signer.PublicKey())
+
+	err := m.update(func(keys []*runtimeHostKey, now int64) ([]*runtimeHostKey, error) {
+		for _, k := range m.getConfigKeys() {
+			if ssh.FingerprintSHA256(k.signer.PublicKey()) == fp {
+				return nil, util.NewValidationError(fmt.Sprintf("host key %q already exists", fp))
+			}
+		}
+		key.CreatedAt = now
+		key.ActivateAt = now + int64(gracePeriod)*60*1000
+		for _, k := range keys {
+			if ssh.FingerprintSHA256(k.signer.PublicKey()) == fp && !k.isRetired(now) {
+				return nil, util.NewValidationError(fmt.Sprintf("host key %q already exists", fp))
+			}
+		}
+		// the existing keys of the same type are replaced by the new one
+		// when it becomes active
+		for _, k := range keys {
+			if k.getType() == key.getType() && (k.RetireAt == 0 || k.RetireAt > key.ActivateAt) {
+				k.RetireAt = key.ActivateAt
+			}
+		}
+		return append(keys, key), nil
+	})
+	if err != nil {
+		return HostKeyInfo{}, err
+	}
+	m.updateFingerprints()
+	logger.Info(logSender, "", "runtime host key %q added, type %q, fingerprint %q, activation time: %v",
+		key.ID, key.getType(), fp, util.GetTimeFromMsecSinceEpoch(key.ActivateAt))
+	status := HostKeyStatusActive
+	if gracePeriod > 0 {
+		status = HostKeyStatusPending
+	}
+	return key.getInfo(status), nil
+}
+
+func (m *hostKeysManager) retireKey(id string, gracePeriod int) error {
+	if err := validateHostKeyGracePeriod(gracePeriod); err != nil {
+		return err
+	}
+	err := m.update(func(keys []*runtimeHostKey, now int64) ([]*runtimeHostKey, error) {
+		for _, k := range m.getConfigKeys() {
+			if k.path == id {
+				return nil, util.NewValidationError("host keys defined in the configuration file cannot be retired")
+			}
+		}
+		for _, k := range keys {
+			if k.ID == id && !k.isRetired(now) {
+				retireAt := now + int64(gracePeriod)*60*1000
+				if k.RetireAt == 0 || k.RetireAt > retireAt {
+					k.RetireAt = retireAt
+				}
+				return keys, nil
+			}
+		}
+		return nil, util.NewRecordNotFoundError(fmt.Sprintf("host key %q not found", id))
+	})
+	if err != nil {
+		return err
+	}
+	m.updateFingerprints()
+	logger.Info(logSender, "", "runtime host key %q retired, grace period: %d minutes", id, gracePeriod)
+	return nil
+}
+
+func validateHostKeyGracePeriod(gracePeriod int) error {
+	if gracePeriod < 0 || gracePeriod > maxHostKeyGracePeriod {
+		return util.NewValidationError(fmt.Sprintf("invalid grace period %d, it must be between 0 and %d minutes",
+			gracePeriod, maxHostKeyGracePeriod))
+	}
+	return nil
+}
+
+func generatePrivateKey(keyType string) ([]byte, error) {
+	var block *pem.Block
+
+	switch keyType {
+	case "rsa":
+		priv, err := rsa.GenerateKey(rand.Reader, 4096)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}
+	case "ecdsa":
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		privBytes, err := x509.MarshalECPrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: privBytes}
+	case "ed25519":
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}
+	default:
+		return nil, util.NewValidationError(fmt.Sprintf("unsupported host key type %q, supported types: %s",
+			keyType, strings.Join(supportedGeneratedTypes, ", ")))
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// getHostKeysPayload returns the payload for the hostkeys-00@openssh.com request
+func getHostKeysPayload(signers []ssh.Signer) []byte {
+	var payload []byte
+	for _, signer := range signers {
+		payload = appendSSHString(payload, signer.PublicKey().Marshal())
+	}
+	return payload
+}
+
+func appendSSHString(buf, s []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+func parseSSHStrings(payload []byte) ([][]byte, error) {
+	var result [][]byte
+	for len(payload) > 0 {
+		if len(payload) < 4 {
+			return nil, errors.New("invalid payload")
+		}
+		length := binary.BigEndian.Uint32(payload)
+		payload = payload[4:]
+		if uint32(len(payload)) < length {
+			return nil, errors.New("invalid payload")
+		}
+		result = append(result, payload[:length])
+		payload = payload[length:]
+	}
+	return result, nil
+}
+
+// hostKeysProver replies to hostkeys-prove-00@openssh.com requests
+type hostKeysProver struct {
+	sessionID []byte
+	signers   []ssh.Signer
+}
+
+// prove returns the signatures, in the requested order, proving the ownership
+// of the requested host keys
+func (p *hostKeysProver) prove(payload []byte) ([]byte, error) {
+	blobs, err := parseSSHStrings(payload)
+	if err != nil {
+		return nil, err
+	}
+	var response []byte
+	for _, blob := range blobs {
+		idx := -1
+		for i, signer := range p.signers {
+			if string(signer.PublicKey().Marshal()) == string(blob) {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return nil, errors.New("unknown host key")
+		}
+		var data []byte
+		data = appendSSHString(data, []byte(hostKeysProveRequest))
+		data = appendSSHString(data, p.sessionID)
+		data = appendSSHString(data, blob)
+
+		var sig *ssh.Signature
+		signer := p.signers[idx]
+		if algSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+			sig, err = algSigner.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+		} else {
+			sig, err = signer.Sign(rand.Reader, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		response = appendSSHString(response, ssh.Marshal(sig))
+	}
+	return response, nil
+}
+
+// GetHostKeys returns the host keys defined in the configuration file and the
+// ones added at runtime
+func GetHostKeys() ([]HostKeyInfo, error) {
+	return hostKeys.getHostKeys()
+}
+
+// GenerateHostKey generates a new host key of the specified type. The new key is
+// advertised to the clients immediately and replaces the existing keys of the same
+// type after the specified grace period, in minutes
+func GenerateHostKey(keyType string, gracePeriod int) (HostKeyInfo, error) {
+	privateKey, err := generatePrivateKey(keyType)
+	if err != nil {
+		return HostKeyInfo{}, err
+	}
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return HostKeyInfo{}, err
+	}
+	return hostKeys.addKey(signer, privateKey, gracePeriod)
+}
+
+// AddHostKey adds the specified PEM encoded private key as host key, the grace
+// period works as for GenerateHostKey
+func AddHostKey(privateKey string, gracePeriod int) (HostKeyInfo, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+	if err != nil {
+		return HostKeyInfo{}, util.NewValidationError(fmt.Sprintf("unable to parse the private key: %v", err))
+	}
+	return hostKeys.addKey(signer, []byte(privateKey), gracePeriod)
+}
+
+// RetireHostKey retires the runtime host key with the specified id after the
+// specified grace period, in minutes
+func RetireHostKey(id string, gracePeriod int) error {
+	return hostKeys.retireKey(id, gracePeriod)
+}
@@ -16,6 +16,7 @@ package sftpd
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -23,10 +24,22 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/eikenb/pipeat"
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	krbcrypto "github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	krbtypes "github.com/jcmturner/gokrb5/v8/types"
 	"github.com/pkg/sftp"
 	"github.com/rs/xid"
 	"github.com/sftpgo/sdk"
@@ -1472,7 +1485,12 @@ func TestSCPErrorsMockFs(t *testing.T) {
 
 	fs := newMockOsFs(errFake, nil, true, "123", os.TempDir())
 	err = scpCommand.handleUploadFile(fs, testfile, testfile, 0, false, 4, "/testfile")
-	assert.NoError(t, err)
+	if common.Config.IsAtomicUploadEnabled() {
+		// the existing file is renamed for atomic uploads after the quota checks
+		assert.ErrorIs(t, err, errFake)
+	} else {
+		assert.NoError(t, err)
+	}
 	err = os.Remove(testfile)
 	assert.NoError(t, err)
 }
@@ -1973,6 +1991,55 @@ func TestLoadHostKeys(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestHostKeysManagerErrors(t *testing.T) {
+	m := newHostKeysManager()
+	_, err := m.getHostKeys()
+	assert.ErrorIs(t, err, errHostKeysNotAvailable)
+	err = m.retireKey("id", 0)
+	assert.ErrorIs(t, err, errHostKeysNotAvailable)
+	err = m.retireKey("id", -1)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errHostKeysNotAvailable)
+	serverConfig := &ssh.ServerConfig{}
+	config, advertised := m.getConnectionConfig(serverConfig)
+	assert.Equal(t, serverConfig, config)
+	assert.Len(t, advertised, 0)
+	for _, keyType := range supportedGeneratedTypes {
+		if keyType == "rsa" {
+			// generating an RSA key is slow
+			continue
+		}
+		privateKey, err := generatePrivateKey(keyType)
+		assert.NoError(t, err)
+		signer, err := ssh.ParsePrivateKey(privateKey)
+		assert.NoError(t, err)
+		_, err = m.addKey(signer, privateKey, 0)
+		assert.ErrorIs(t, err, errHostKeysNotAvailable)
+	}
+	_, err = generatePrivateKey("unsupported")
+	assert.Error(t, err)
+
+	privateKey, err := generatePrivateKey("ed25519")
+	assert.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	assert.NoError(t, err)
+	prover := &hostKeysProver{
+		sessionID: []byte("session id"),
+		signers:   []ssh.Signer{signer},
+	}
+	_, err = prover.prove([]byte{0, 0})
+	assert.Error(t, err)
+	_, err = prover.prove([]byte{0, 0, 0, 10, 1})
+	assert.Error(t, err)
+	_, err = prover.prove(appendSSHString(nil, []byte("unknown key")))
+	assert.Error(t, err)
+	response, err := prover.prove(getHostKeysPayload([]ssh.Signer{signer}))
+	assert.NoError(t, err)
+	signatures, err := parseSSHStrings(response)
+	assert.NoError(t, err)
+	assert.Len(t, signatures, 1)
+}
+
 func TestCertCheckerInitErrors(t *testing.T) {
 	c := Configuration{}
 	c.TrustedUserCAKeys = []string{".", "missing file"}
@@ -2033,7 +2100,7 @@ func TestSFTPSubSystem(t *testing.T) {
 		},
 	}
 	user.FsConfig.Provider = sdk.AzureBlobFilesystemProvider
-	err := ServeSubSystemConnection(user, "connID", nil, nil)
+	err := ServeSubSystemConnection(user, "connID", nil, nil, &Configuration{})
 	assert.Error(t, err)
 	user.FsConfig.Provider = sdk.LocalFilesystemProvider
 
@@ -2046,7 +2113,9 @@ func TestSFTPSubSystem(t *testing.T) {
 	// this is 327680 and it will result in packet too long error
 	_, err = mockSSHChannel.Write([]byte{0x00, 0x05, 0x00, 0x00, 0x00, 0x00})
 	assert.NoError(t, err)
-	err = ServeSubSystemConnection(user, "id", mockSSHChannel, mockSSHChannel)
+	err = ServeSubSystemConnection(user, "id", mockSSHChannel, mockSSHChannel, &Configuration{
+		CopyExtensions: true,
+	})
 	assert.EqualError(t, err, "packet too long")
 
 	subsystemChannel := newSubsystemChannel(mockSSHChannel, mockSSHChannel)
@@ -2057,6 +2126,100 @@ func TestSFTPSubSystem(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestExtensionsChannelHelpers(t *testing.T) {
+	code, msg := getStatusFromError(nil)
+	assert.Equal(t, uint32(sshFxOk), code)
+	assert.Empty(t, msg)
+	code, _ = getStatusFromError(os.ErrNotExist)
+	assert.Equal(t, uint32(sshFxNoSuchFile), code)
+	code, _ = getStatusFromError(sftp.ErrSSHFxPermissionDenied)
+	assert.Equal(t, uint32(sshFxPermissionDenied), code)
+	code, _ = getStatusFromError(sftp.ErrSSHFxOpUnsupported)
+	assert.Equal(t, uint32(sshFxOpUnsupported), code)
+	code, msg = getStatusFromError(&sftpStatusError{code: sshFxBadMessage, msg: "bad message"})
+	assert.Equal(t, uint32(sshFxBadMessage), code)
+	assert.Equal(t, "bad message", msg)
+	code, msg = getStatusFromError(errors.New("generic error"))
+	assert.Equal(t, uint32(sshFxFailure), code)
+	assert.Equal(t, "generic error", msg)
+
+	_, _, err := unmarshalString([]byte{0, 0, 0, 10, 1})
+	assert.ErrorIs(t, err, errShortPacket)
+	_, _, err = unmarshalUint64([]byte{0, 0, 0, 10})
+	assert.ErrorIs(t, err, errShortPacket)
+	_, err = getDataFromResponse([]byte{sshFxpData})
+	assert.Error(t, err)
+	_, err = getDataFromResponse([]byte{sshFxpData, 0, 0, 0, 1, 0, 0, 0, 5})
+	assert.Error(t, err)
+	_, err = getDataFromResponse([]byte{sshFxpWrite, 0, 0, 0, 1})
+	assert.Error(t, err)
+	data, err := getDataFromResponse(appendString([]byte{sshFxpData, 0, 0, 0, 1}, "data"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+	err = getErrorFromStatusResponse([]byte{sshFxpData, 0, 0, 0, 1})
+	assert.Error(t, err)
+	err = getErrorFromStatusResponse([]byte{sshFxpStatus, 0, 0, 0, 1})
+	assert.Error(t, err)
+	resp := appendUint32([]byte{sshFxpStatus, 0, 0, 0, 1}, sshFxEOF)
+	err = getErrorFromStatusResponse(appendString(resp, "EOF"))
+	var statusErr *sftpStatusError
+	if assert.ErrorAs(t, err, &statusErr) {
+		assert.Equal(t, uint32(sshFxEOF), statusErr.code)
+		assert.Equal(t, "EOF", statusErr.msg)
+	}
+
+	packet := appendUint32([]byte{0, 0, 0, 5, sshFxpVersion}, 3)
+	packet = addExtensionsToVersionPacket(packet)
+	assert.Equal(t, uint32(len(packet)-4), binary.BigEndian.Uint32(packet))
+	name, data2, err := unmarshalString(packet[9:])
+	assert.NoError(t, err)
+	assert.Equal(t, extCopyData, name)
+	value, _, err := unmarshalString(data2)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", value)
+
+	packet = appendUint32([]byte{0, 0, 0, 13, sshFxpAttrs, 0, 0, 0, 1}, 0x1)
+	packet = appendUint64(packet, 100)
+	assert.Equal(t, packet, addExtendedAttributesToAttrsPacket(packet, nil))
+	packet = addExtendedAttributesToAttrsPacket(packet, map[string]string{"b": "2", "a": "1"})
+	assert.Equal(t, uint32(len(packet)-4), binary.BigEndian.Uint32(packet))
+	assert.Equal(t, uint32(0x80000001), binary.BigEndian.Uint32(packet[9:]))
+	assert.Equal(t, uint32(2), binary.BigEndian.Uint32(packet[21:]))
+	name, data2, err = unmarshalString(packet[25:])
+	assert.NoError(t, err)
+	assert.Equal(t, "a", name)
+	value, _, err = unmarshalString(data2)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", value)
+
+	c := &extensionsChannel{startDirectory: "/start"}
+	// attributes with extended data or truncated are not changed
+	assert.Equal(t, packet, c.addMetadataToAttrsPacket(packet, "/file"))
+	assert.Equal(t, packet[:10], c.addMetadataToAttrsPacket(packet[:10], "/file"))
+	assert.Equal(t, "/start/file", c.getVirtualPath("file"))
+	assert.Equal(t, "/file", c.getVirtualPath("/../file"))
+	err = c.copyFile([]byte{0, 0, 0, 1})
+	assert.ErrorAs(t, err, &statusErr)
+	err = c.copyFile(appendString(nil, "src"))
+	assert.ErrorAs(t, err, &statusErr)
+	err = c.copyFile(appendString(appendString(nil, "src"), "dst"))
+	if assert.ErrorAs(t, err, &statusErr) {
+		assert.Equal(t, uint32(sshFxBadMessage), statusErr.code)
+	}
+	for _, data := range [][]byte{
+		nil,
+		appendString(nil, "h1"),
+		appendUint64(appendString(nil, "h1"), 0),
+		appendUint64(appendUint64(appendString(nil, "h1"), 0), 0),
+		appendString(appendUint64(appendUint64(appendString(nil, "h1"), 0), 0), "h2"),
+	} {
+		err = c.copyData(1, data)
+		if assert.ErrorAs(t, err, &statusErr) {
+			assert.Equal(t, uint32(sshFxBadMessage), statusErr.code)
+		}
+	}
+}
+
 func TestRecoverer(t *testing.T) {
 	c := Configuration{}
 	c.AcceptInboundConnection(nil, nil)
@@ -2086,14 +2249,14 @@ func TestListernerAcceptErrors(t *testing.T) {
 	errFake := errors.New("a fake error")
 	listener := newFakeListener(errFake)
 	c := Configuration{}
-	err := c.serve(listener, nil)
+	err := c.serve(listener, nil, Binding{})
 	require.EqualError(t, err, errFake.Error())
 	err = listener.Close()
 	require.NoError(t, err)
 
 	errNetFake := &fakeNetError{error: errFake}
 	listener = newFakeListener(errNetFake)
-	err = c.serve(listener, nil)
+	err = c.serve(listener, nil, Binding{})
 	require.EqualError(t, err, errFake.Error())
 	err = listener.Close()
 	require.NoError(t, err)
@@ -2227,7 +2390,7 @@ func TestMaxUserSessions(t *testing.T) {
 	if assert.Error(t, err) {
 		assert.Contains(t, err.Error(), "too many open sessions")
 	}
-	err = ServeSubSystemConnection(&connection.User, connection.ID, nil, nil)
+	err = ServeSubSystemConnection(&connection.User, connection.ID, nil, nil, &Configuration{})
 	if assert.Error(t, err) {
 		assert.Contains(t, err.Error(), "too many open sessions")
 	}
@@ -2265,3 +2428,816 @@ func TestCanReadSymlink(t *testing.T) {
 	err = connection.canReadLink("/denied/file.txt")
 	assert.ErrorIs(t, err, sftp.ErrSSHFxNoSuchFile)
 }
+
+func TestPublicKeyOptions(t *testing.T) {
+	options, err := dataprovider.ParsePublicKeyOptions([]string{`from="!192.168.1.1,192.168.1.?,10.8.0.0/16,fd00::/8"`,
+		"no-X11-forwarding", `expiry-time="202001011200"`})
+	require.NoError(t, err)
+	assert.Len(t, options.From, 4)
+	assert.False(t, options.NoPTY)
+	assert.False(t, options.IsSFTPOnly())
+	assert.True(t, options.IsExpired())
+	assert.Error(t, options.CheckLoginConditions("192.168.1.2"))
+	options.ExpiryTime = time.Now().Add(time.Hour)
+	assert.False(t, options.IsExpired())
+	assert.NoError(t, options.CheckLoginConditions("192.168.1.2"))
+	assert.NoError(t, options.CheckLoginConditions("10.8.1.1"))
+	assert.NoError(t, options.CheckLoginConditions("fd00::1"))
+	assert.Error(t, options.CheckLoginConditions("192.168.1.1"))
+	assert.Error(t, options.CheckLoginConditions("192.168.1.22"))
+	assert.Error(t, options.CheckLoginConditions("10.9.0.1"))
+
+	options, err = dataprovider.ParsePublicKeyOptions([]string{"restrict", `command="internal-sftp"`})
+	require.NoError(t, err)
+	assert.True(t, options.NoPTY)
+	assert.True(t, options.IsSFTPOnly())
+	assert.True(t, options.IsSourceAllowed("127.0.0.1"))
+	remoteAddr := "127.0.0.1:12345"
+	assert.False(t, keyRestrictions.isSFTPOnly(remoteAddr))
+	keyRestrictions.add(remoteAddr, &dataprovider.PublicKeyOptions{})
+	assert.False(t, keyRestrictions.isSFTPOnly(remoteAddr))
+	keyRestrictions.add(remoteAddr, &options)
+	assert.True(t, keyRestrictions.isSFTPOnly(remoteAddr))
+	assert.True(t, options.NoPortForwarding)
+	assert.True(t, keyRestrictions.isPortForwardingDenied(remoteAddr))
+	keyRestrictions.remove(remoteAddr)
+	assert.False(t, keyRestrictions.isSFTPOnly(remoteAddr))
+	assert.False(t, keyRestrictions.isPortForwardingDenied(remoteAddr))
+	options, err = dataprovider.ParsePublicKeyOptions([]string{"no-port-forwarding"})
+	require.NoError(t, err)
+	assert.False(t, options.NoPTY)
+	assert.True(t, options.NoPortForwarding)
+	keyRestrictions.add(remoteAddr, &options)
+	assert.False(t, keyRestrictions.isSFTPOnly(remoteAddr))
+	assert.True(t, keyRestrictions.isPortForwardingDenied(remoteAddr))
+	keyRestrictions.remove(remoteAddr)
+
+	options, err = dataprovider.ParsePublicKeyOptions([]string{`expiry-time="20221231Z"`})
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 12, 31, 0, 0, 0, 0, time.UTC), options.ExpiryTime)
+
+	for _, invalid := range [][]string{
+		{"cert-authority"},
+		{`from=10.0.0.1`},
+		{`from="10.0.0.1,"`},
+		{`from="10.0.0.1/33"`},
+		{`from="10.0.0.1"`, `from="10.0.0.2"`},
+		{`expiry-time="20221301"`},
+		{`expiry-time="2022123"`},
+		{`command="/usr/lib/openssh/sftp-server"`},
+		{`environment="A=B"`},
+	} {
+		_, err = dataprovider.ParsePublicKeyOptions(invalid)
+		assert.Error(t, err, "options %v must be invalid", invalid)
+	}
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			PublicKeys: []string{"invalid"},
+		},
+	}
+	_, err = user.GetPublicKeyOptions([]byte("key"))
+	assert.Error(t, err)
+	user.PublicKeys = nil
+	_, err = user.GetPublicKeyOptions([]byte("key"))
+	assert.ErrorIs(t, err, dataprovider.ErrInvalidCredentials)
+}
+
+func TestPortForwardingSettings(t *testing.T) {
+	var settings *dataprovider.SSHPortForwarding
+	assert.False(t, settings.IsEnabled())
+	assert.False(t, settings.IsDestinationAllowed("127.0.0.1", 22))
+	assert.False(t, settings.IsListenAllowed("127.0.0.1", 22))
+	settings = &dataprovider.SSHPortForwarding{
+		AllowLocal: true,
+	}
+	assert.True(t, settings.IsEnabled())
+	assert.True(t, settings.IsDestinationAllowed("example.com", 443))
+	assert.False(t, settings.IsListenAllowed("127.0.0.1", 22))
+	settings.AllowRemote = true
+	settings.PermitOpen = []string{"*.example.com:443", "10.8.0.0/16:*", "[fd00::1]:22", "db.local:5432"}
+	settings.PermitListen = []string{"localhost:*", "127.0.0.1:8080"}
+	assert.True(t, settings.IsDestinationAllowed("www.Example.com", 443))
+	assert.False(t, settings.IsDestinationAllowed("www.example.com", 80))
+	assert.False(t, settings.IsDestinationAllowed("example.com", 443))
+	assert.True(t, settings.IsDestinationAllowed("10.8.1.2", 22))
+	assert.False(t, settings.IsDestinationAllowed("10.9.1.2", 22))
+	assert.True(t, settings.IsDestinationAllowed("fd00:0::1", 22))
+	assert.False(t, settings.IsDestinationAllowed("fd00::2", 22))
+	assert.True(t, settings.IsDestinationAllowed("db.local", 5432))
+	assert.True(t, settings.IsListenAllowed("localhost", 0))
+	assert.True(t, settings.IsListenAllowed("127.0.0.1", 8080))
+	assert.False(t, settings.IsListenAllowed("127.0.0.1", 0))
+	assert.False(t, settings.IsListenAllowed("", 8080))
+	assert.Equal(t, "localhost:*,127.0.0.1:8080", settings.GetPermitListenAsString())
+
+	assert.Nil(t, newPortForwarder(nil, nil, dataprovider.User{}, "id", "", ""))
+	user := dataprovider.User{}
+	user.Filters.PortForwarding = settings
+	f := newPortForwarder(nil, nil, user, "id", "127.0.0.1:2022", "127.0.0.1:12345")
+	require.NotNil(t, f)
+	addr, err := f.getDialAddress("10.8.0.1", 80)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.8.0.1:80", addr)
+	_, err = f.getDialAddress("192.168.1.1", 80)
+	assert.ErrorIs(t, err, common.ErrPermissionDenied)
+	f.settings.PermitOpen = []string{"127.0.0.0/8:22"}
+	addr, err = f.getDialAddress("localhost", 22)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "127.0.0.1:22", addr)
+	}
+	_, err = f.getDialAddress("localhost", 23)
+	assert.ErrorIs(t, err, common.ErrPermissionDenied)
+
+	assert.NoError(t, getForwardingError(io.EOF))
+	assert.NoError(t, getForwardingError(net.ErrClosed))
+	assert.Error(t, getForwardingError(io.ErrUnexpectedEOF))
+
+	f.close()
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	assert.False(t, f.addConn(c1))
+	ok, _ := f.startRemoteForward("127.0.0.1", 8080)
+	assert.False(t, ok)
+	assert.False(t, f.cancelRemoteForward("127.0.0.1", 8080))
+}
+
+func TestRsyncArgs(t *testing.T) {
+	assert.NoError(t, validateRsyncArgs([]string{"--server", "-vlogDtprze.iLsfxC", ".", "/"}))
+	assert.NoError(t, validateRsyncArgs([]string{"--server", "--sender", "-vlogDtprze.iLsfxC", ".", "/"}))
+	for _, args := range [][]string{
+		nil,
+		{"-vlogDtprze.iLsfxC", ".", "/"},
+		{"--server", "--rsh=sh", ".", "/"},
+		{"--server", "-e", "sh", ".", "/"},
+		{"--server", "--temp-dir", "/tmp", ".", "/"},
+		{"--server", "--log-file=/tmp/log", ".", "/"},
+		{"--server", "--link-dest=/etc", ".", "/"},
+		{"--server", "--files-from=/etc/passwd", ".", "/"},
+	} {
+		err := validateRsyncArgs(args)
+		assert.ErrorIs(t, err, errUnsupportedConfig, "args %v must be rejected", args)
+	}
+	assert.True(t, isRsyncSender([]string{"--server", "--sender", ".", "/"}))
+	assert.False(t, isRsyncSender([]string{"--server", ".", "/"}))
+
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermListItems, dataprovider.PermDownload},
+			},
+			HomeDir: filepath.Join(os.TempDir(), "rsync_test"),
+		},
+	}
+	conn := &Connection{
+		BaseConnection: common.NewBaseConnection("", common.ProtocolSSH, "", "", user),
+	}
+	sshCmd := sshCommand{
+		command:    "rsync",
+		connection: conn,
+		args:       []string{"--server", "--sender", "-vlogDtprze.iLsfxC", ".", "/"},
+	}
+	assert.False(t, sshCmd.isRsyncReceiver())
+	assert.True(t, user.HasPerms(sshCmd.getSystemCommandPerms(), "/"))
+	sshCmd.args = []string{"--server", "-vlogDtprze.iLsfxC", ".", "/"}
+	assert.True(t, sshCmd.isRsyncReceiver())
+	assert.False(t, user.HasPerms(sshCmd.getSystemCommandPerms(), "/"))
+	fs := vfs.NewOsFs("", user.GetHomeDir(), "")
+	snapshot := sshCmd.getRsyncSnapshot(fs, user.GetHomeDir())
+	assert.Len(t, snapshot, 0)
+	err := os.MkdirAll(filepath.Join(user.GetHomeDir(), "dir"), os.ModePerm)
+	assert.NoError(t, err)
+	sshCmd.args = []string{"--server", "--daemon", ".", "/"}
+	_, err = sshCmd.getSystemCommand()
+	assert.ErrorIs(t, err, errUnsupportedConfig)
+	sshCmd.args = []string{"--server", "-vlogDtprze.iLsfxC", ".", "/"}
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "dir", "file1"), []byte("content"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file2"), []byte("content"), os.ModePerm)
+	assert.NoError(t, err)
+	snapshot = sshCmd.getRsyncSnapshot(fs, user.GetHomeDir())
+	if assert.Len(t, snapshot, 2) {
+		assert.Equal(t, int64(7), snapshot[filepath.Join(user.GetHomeDir(), "file2")].size)
+	}
+	sshCmd.notifyRsyncChanges(fs, user.GetHomeDir(), map[string]rsyncFileState{
+		filepath.Join(user.GetHomeDir(), "deleted"): {size: 10},
+	})
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestBindingKeepalive(t *testing.T) {
+	b := Binding{}
+	assert.Equal(t, time.Duration(0), b.getKeepaliveInterval())
+	assert.Equal(t, defaultKeepaliveMaxCount, b.getKeepaliveMaxCount())
+	b.KeepaliveInterval = 15
+	b.KeepaliveMaxCount = 5
+	assert.Equal(t, 15*time.Second, b.getKeepaliveInterval())
+	assert.Equal(t, 5, b.getKeepaliveMaxCount())
+	b.KeepaliveInterval = -1
+	b.KeepaliveMaxCount = -1
+	assert.Equal(t, time.Duration(0), b.getKeepaliveInterval())
+	assert.Equal(t, defaultKeepaliveMaxCount, b.getKeepaliveMaxCount())
+}
+
+func TestBindingClientPolicy(t *testing.T) {
+	privateKey, err := generatePrivateKey("ed25519")
+	require.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	require.NoError(t, err)
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return &ssh.Permissions{}, nil
+		},
+	}
+	serverConfig.AddHostKey(signer)
+	binding := Binding{
+		Port: 2022,
+		ClientPolicy: dataprovider.ClientPolicy{
+			Allowed: []string{"SSH-2.0-Allowed*"},
+			Denied:  []string{"ssh-2.0-allowedbutbroken*"},
+		},
+	}
+	require.NoError(t, binding.ClientPolicy.Validate())
+
+	testCases := []struct {
+		version string
+		allowed bool
+	}{
+		{version: "SSH-2.0-Allowed_1.0", allowed: true},
+		{version: "SSH-2.0-AllowedButBroken_0.1", allowed: false},
+		{version: "SSH-2.0-Other_2.0", allowed: false},
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	for _, tc := range testCases {
+		connConfig := newClientPolicyChecker(&binding).getConnectionConfig(serverConfig)
+		assert.Nil(t, connConfig.PublicKeyCallback)
+		assert.Nil(t, connConfig.KeyboardInteractiveCallback)
+		go func() {
+			serverConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			sconn, _, _, err := ssh.NewServerConn(serverConn, connConfig)
+			if err == nil {
+				sconn.Close()
+			}
+			serverConn.Close()
+		}()
+
+		clientConn, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		c, _, _, err := ssh.NewClientConn(clientConn, "", &ssh.ClientConfig{
+			User:            "user",
+			Auth:            []ssh.AuthMethod{ssh.Password("password")},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(), //nolint:gosec
+			ClientVersion:   tc.version,
+		})
+		if tc.allowed {
+			assert.NoError(t, err, tc.version)
+		} else {
+			assert.Error(t, err, tc.version)
+		}
+		if c != nil {
+			c.Close()
+		}
+		clientConn.Close()
+	}
+
+	found := 0
+	for _, v := range common.GetClientVersions() {
+		if v.Protocol != common.ProtocolSSH {
+			continue
+		}
+		switch v.Version {
+		case "SSH-2.0-Allowed_1.0":
+			assert.Equal(t, int64(1), v.Count)
+			assert.Equal(t, int64(0), v.Denied)
+			found++
+		case "SSH-2.0-AllowedButBroken_0.1", "SSH-2.0-Other_2.0":
+			assert.Equal(t, int64(0), v.Count)
+			assert.Equal(t, int64(1), v.Denied)
+			found++
+		}
+	}
+	assert.Equal(t, 3, found)
+
+	binding.ClientPolicy.Denied = []string{strings.Repeat("a", dataprovider.MaxClientVersionLength+1)}
+	assert.Error(t, binding.ClientPolicy.Validate())
+}
+
+func TestUniformAuthConfig(t *testing.T) {
+	privateKey, err := generatePrivateKey("ed25519")
+	require.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	require.NoError(t, err)
+	c := Configuration{
+		PasswordAuthentication: true,
+	}
+	serverConfig := c.getServerConfig()
+	serverConfig.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		return c.validateKeyboardInteractiveCredentials(conn, client)
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	for _, uniformAuthErrors := range []bool{false, true} {
+		connConfig := serverConfig
+		if uniformAuthErrors {
+			connConfig = c.getUniformAuthConfig(serverConfig)
+		}
+		go func() {
+			serverConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			sconn, _, _, err := ssh.NewServerConn(serverConn, connConfig)
+			if err == nil {
+				sconn.Close()
+			}
+			serverConn.Close()
+		}()
+
+		var questions []string
+		clientConn, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		_, _, _, err = ssh.NewClientConn(clientConn, "", &ssh.ClientConfig{
+			User: "unknown user",
+			Auth: []ssh.AuthMethod{
+				ssh.Password("password"),
+				ssh.KeyboardInteractive(func(user, instruction string, q []string, echos []bool) ([]string, error) {
+					questions = append(questions, q...)
+					answers := make([]string, len(q))
+					for idx := range answers {
+						answers[idx] = "password"
+					}
+					return answers, nil
+				}),
+			},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(), //nolint:gosec
+		})
+		assert.Error(t, err)
+		if uniformAuthErrors {
+			assert.Equal(t, []string{"Password: "}, questions)
+		} else {
+			assert.Len(t, questions, 0)
+		}
+		clientConn.Close()
+	}
+}
+
+type testGSSAPIClient struct {
+	token      []byte
+	sessionKey krbtypes.EncryptionKey
+	subKey     krbtypes.EncryptionKey
+	ctime      time.Time
+	cusec      int
+}
+
+func (c *testGSSAPIClient) InitSecContext(target string, token []byte, isGSSDelegCreds bool) ([]byte, bool, error) {
+	if token == nil {
+		return c.token, true, nil
+	}
+	var oid asn1.ObjectIdentifier
+	rest, err := asn1.UnmarshalWithParams(token, &oid, "application,explicit,tag:0")
+	if err != nil {
+		return nil, false, err
+	}
+	if !oid.Equal(gssapi.OIDKRB5.OID()) || len(rest) < 2 || binary.BigEndian.Uint16(rest[:2]) != gssapiTokenIDAPRep {
+		return nil, false, errors.New("unexpected token")
+	}
+	var apRep messages.APRep
+	if err := apRep.Unmarshal(rest[2:]); err != nil {
+		return nil, false, err
+	}
+	data, err := krbcrypto.DecryptEncPart(apRep.EncPart, c.sessionKey, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return nil, false, err
+	}
+	var encPart messages.EncAPRepPart
+	if err := encPart.Unmarshal(data); err != nil {
+		return nil, false, err
+	}
+	if !encPart.CTime.Equal(c.ctime.Truncate(time.Second)) || encPart.Cusec != c.cusec {
+		return nil, false, errors.New("AP-REP ctime mismatch")
+	}
+	return nil, false, nil
+}
+
+func (c *testGSSAPIClient) GetMIC(micField []byte) ([]byte, error) {
+	token, err := gssapi.NewInitiatorMICToken(micField, c.subKey)
+	if err != nil {
+		return nil, err
+	}
+	return token.Marshal()
+}
+
+func (c *testGSSAPIClient) DeleteSecContext() error {
+	return nil
+}
+
+func getKerberosTestKeytab(t *testing.T) *keytab.Keytab {
+	kt := keytab.New()
+	err := kt.AddEntry("host/localhost", "EXAMPLE.COM", "keytab password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+	require.NoError(t, err)
+	return kt
+}
+
+func getKerberosTestClient(t *testing.T, kt *keytab.Keytab, clientName string, mutual bool) *testGSSAPIClient {
+	realm := "EXAMPLE.COM"
+	cname := krbtypes.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, clientName)
+	now := time.Now().UTC()
+	tkt, sessionKey, err := messages.NewTicket(cname, realm, krbtypes.NewPrincipalName(nametype.KRB_NT_SRV_HST, "host/localhost"),
+		realm, krbtypes.NewKrbFlags(), kt, etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, now.Add(time.Hour), now.Add(time.Hour))
+	require.NoError(t, err)
+	auth, err := krbtypes.NewAuthenticator(realm, cname)
+	require.NoError(t, err)
+	err = auth.GenerateSeqNumberAndSubKey(etypeID.AES256_CTS_HMAC_SHA1_96, 32)
+	require.NoError(t, err)
+	checksum := make([]byte, 24)
+	binary.LittleEndian.PutUint32(checksum[:4], 16)
+	contextFlags := uint32(gssapi.ContextFlagInteg)
+	if mutual {
+		contextFlags |= uint32(gssapi.ContextFlagMutual)
+	}
+	binary.LittleEndian.PutUint32(checksum[20:24], contextFlags)
+	auth.Cksum = krbtypes.Checksum{
+		CksumType: chksumtype.GSSAPI,
+		Checksum:  checksum,
+	}
+	apReq, err := messages.NewAPReq(tkt, sessionKey, auth)
+	require.NoError(t, err)
+	apReqBytes, err := apReq.Marshal()
+	require.NoError(t, err)
+	token, err := asn1.Marshal(gssapi.OIDKRB5.OID())
+	require.NoError(t, err)
+	token = append(token, 0x01, 0x00)
+	token = append(token, apReqBytes...)
+	return &testGSSAPIClient{
+		token:      asn1tools.AddASNAppTag(token, 0),
+		sessionKey: sessionKey,
+		subKey:     auth.SubKey,
+		ctime:      auth.CTime,
+		cusec:      auth.Cusec,
+	}
+}
+
+func TestKerberosConfig(t *testing.T) {
+	c := KerberosConfig{}
+	err := c.initialize(configDir)
+	assert.NoError(t, err)
+	assert.False(t, c.isEnabled())
+	assert.Equal(t, defaultKerberosMaxClockSkew*time.Second, c.getMaxClockSkew())
+	c.MaxClockSkew = 60
+	assert.Equal(t, time.Minute, c.getMaxClockSkew())
+
+	c.Keytab = "missing.keytab"
+	err = c.initialize(configDir)
+	assert.Error(t, err)
+	keytabPath := filepath.Join(os.TempDir(), "sftpgo_test.keytab")
+	err = os.WriteFile(keytabPath, []byte("invalid keytab"), os.ModePerm)
+	assert.NoError(t, err)
+	c.Keytab = keytabPath
+	err = c.initialize(configDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to parse keytab")
+	}
+	data, err := getKerberosTestKeytab(t).Marshal()
+	require.NoError(t, err)
+	err = os.WriteFile(keytabPath, data, os.ModePerm)
+	assert.NoError(t, err)
+	c.ServicePrincipal = "host/sftp.example.com"
+	err = c.initialize(configDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "not found in keytab")
+	}
+	c.ServicePrincipal = "host/localhost@EXAMPLE.COM"
+	c.PrincipalMappings = []KerberosPrincipalMapping{
+		{
+			Pattern:  "^(",
+			Username: "$1",
+		},
+	}
+	err = c.initialize(configDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid principal mapping pattern")
+	}
+	c.PrincipalMappings[0].Pattern = ""
+	err = c.initialize(configDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "require a pattern and a username")
+	}
+	c.PrincipalMappings = []KerberosPrincipalMapping{
+		{
+			Pattern:  `^([^@/]+)@EXAMPLE\.COM$`,
+			Username: "$1",
+		},
+		{
+			Pattern:  `^([^@/]+)/admin@EXAMPLE\.COM$`,
+			Username: "${1}_admin",
+		},
+		{
+			Pattern:  `^nobody@`,
+			Username: "$2",
+		},
+	}
+	err = c.initialize(configDir)
+	assert.NoError(t, err)
+	assert.True(t, c.isEnabled())
+
+	username, err := c.getUsername("alice@EXAMPLE.COM")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", username)
+	username, err = c.getUsername("alice/admin@EXAMPLE.COM")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice_admin", username)
+	_, err = c.getUsername("alice@OTHER.COM")
+	assert.Error(t, err)
+	_, err = c.getUsername("nobody@OTHER.COM")
+	assert.Error(t, err)
+
+	c.PrincipalMappings = nil
+	username, err = c.getUsername("bob@OTHER.COM")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", username)
+	_, err = c.getUsername("bob/admin@OTHER.COM")
+	assert.Error(t, err)
+	_, err = c.getUsername("bob")
+	assert.Error(t, err)
+
+	err = os.Remove(keytabPath)
+	assert.NoError(t, err)
+}
+
+func TestGSSAPIServer(t *testing.T) {
+	kt := getKerberosTestKeytab(t)
+	c := KerberosConfig{
+		keytab: kt,
+	}
+	var lastErr error
+	server := &gssapiServer{
+		settings: c.getServiceSettings("127.0.0.1"),
+		onError: func(err error) {
+			lastErr = err
+		},
+	}
+	err := server.VerifyMIC([]byte("mic"), []byte("token"))
+	assert.ErrorIs(t, err, errKerberosNoContext)
+	assert.ErrorIs(t, lastErr, errKerberosNoContext)
+	_, _, _, err = server.AcceptSecContext([]byte("invalid token"))
+	assert.Error(t, err)
+
+	client := getKerberosTestClient(t, kt, "alice", false)
+	outToken, srcName, needContinue, err := server.AcceptSecContext(client.token)
+	assert.NoError(t, err)
+	assert.Empty(t, outToken)
+	assert.Equal(t, "alice@EXAMPLE.COM", srcName)
+	assert.False(t, needContinue)
+	mic, err := client.GetMIC([]byte("mic field"))
+	assert.NoError(t, err)
+	err = server.VerifyMIC([]byte("mic field"), mic)
+	assert.NoError(t, err)
+	err = server.VerifyMIC([]byte("other mic field"), mic)
+	assert.Error(t, err)
+	err = server.VerifyMIC([]byte("mic field"), []byte("invalid mic"))
+	assert.Error(t, err)
+	// the same authenticator cannot be used again
+	_, _, _, err = server.AcceptSecContext(client.token)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "replay")
+	}
+	err = server.DeleteSecContext()
+	assert.NoError(t, err)
+	err = server.VerifyMIC([]byte("mic field"), mic)
+	assert.ErrorIs(t, err, errKerberosNoContext)
+
+	client = getKerberosTestClient(t, kt, "alice", true)
+	outToken, _, _, err = server.AcceptSecContext(client.token)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, outToken)
+	_, needContinue, err = client.InitSecContext("", outToken, false)
+	assert.NoError(t, err)
+	assert.False(t, needContinue)
+	// a ticket for a different service principal
+	c.ServicePrincipal = "host/sftp.example.com"
+	server.settings = c.getServiceSettings("127.0.0.1")
+	client = getKerberosTestClient(t, kt, "alice", true)
+	_, _, _, err = server.AcceptSecContext(client.token)
+	assert.Error(t, err)
+}
+
+func TestKerberosAuthentication(t *testing.T) {
+	privateKey, err := generatePrivateKey("ed25519")
+	require.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	require.NoError(t, err)
+	kt := getKerberosTestKeytab(t)
+	c := Configuration{
+		Kerberos: KerberosConfig{
+			keytab: kt,
+		},
+	}
+	serverConfig := c.getServerConfig()
+	serverConfig.AddHostKey(signer)
+
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "krbuser",
+			Status:   1,
+			HomeDir:  filepath.Join(os.TempDir(), "krbuser"),
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	err = dataprovider.AddUser(&user, "", "")
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	login := func(principal, username string) error {
+		connConfig := c.getGSSAPIConfig(serverConfig, "127.0.0.1")
+		go func() {
+			serverConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			sconn, _, _, err := ssh.NewServerConn(serverConn, connConfig)
+			if err == nil {
+				assert.Equal(t, dataprovider.SSHLoginMethodGSSAPI+": krbuser@EXAMPLE.COM",
+					sconn.Permissions.Extensions["sftpgo_login_method"])
+				sconn.Close()
+			}
+			serverConn.Close()
+		}()
+
+		clientConn, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		defer clientConn.Close()
+
+		client, _, _, err := ssh.NewClientConn(clientConn, "", &ssh.ClientConfig{
+			User: username,
+			Auth: []ssh.AuthMethod{
+				ssh.GSSAPIWithMICAuthMethod(getKerberosTestClient(t, kt, principal, true), "localhost"),
+			},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(), //nolint:gosec
+		})
+		if client != nil {
+			client.Close()
+		}
+		return err
+	}
+
+	testCases := []struct {
+		principal string
+		username  string
+		allowed   bool
+	}{
+		{principal: "krbuser", username: "krbuser", allowed: true},
+		{principal: "krbuser", username: "otheruser", allowed: false},
+		{principal: "otheruser", username: "otheruser", allowed: false},
+	}
+	for _, tc := range testCases {
+		err = login(tc.principal, tc.username)
+		if tc.allowed {
+			assert.NoError(t, err, tc.principal)
+		} else {
+			assert.Error(t, err, tc.principal)
+		}
+	}
+	user, err = dataprovider.UserExists(user.Username)
+	assert.NoError(t, err)
+	user.Filters.DeniedLoginMethods = []string{dataprovider.SSHLoginMethodGSSAPI}
+	err = dataprovider.UpdateUser(&user, "", "")
+	assert.NoError(t, err)
+	err = login("krbuser", "krbuser")
+	assert.Error(t, err)
+
+	err = dataprovider.DeleteUser(user.Username, "", "")
+	assert.NoError(t, err)
+}
+
+func TestUserCAConfig(t *testing.T) {
+	c := UserCAConfig{}
+	err := c.initialize(configDir)
+	assert.NoError(t, err)
+	assert.False(t, IsUserCAEnabled())
+	_, err = IssueUserCertificate("user", "", 0, "", "")
+	assert.ErrorIs(t, err, errUserCANotAvailable)
+
+	keyPath := filepath.Join(os.TempDir(), "sftpgo_test_user_ca")
+	defer func() {
+		userCA.set(nil, nil)
+		os.Remove(keyPath)
+		os.Remove(keyPath + ".pub")
+	}()
+
+	c.PrivateKey = keyPath
+	c.DefaultTTL = -1
+	err = c.initialize(configDir)
+	assert.Error(t, err)
+	c.DefaultTTL = 120
+	c.MaxTTL = 60
+	err = c.initialize(configDir)
+	assert.Error(t, err)
+	c.MaxTTL = 0
+	c.PrincipalMappings = []UserCAPrincipalMapping{
+		{
+			Group:      " ",
+			Principals: []string{"p1"},
+		},
+	}
+	err = c.initialize(configDir)
+	assert.Error(t, err)
+	c.PrincipalMappings[0].Group = "g1"
+	c.PrincipalMappings[0].Principals = []string{" "}
+	err = c.initialize(configDir)
+	assert.Error(t, err)
+	c.PrincipalMappings[0].Principals = []string{"p1,p2"}
+	err = c.initialize(configDir)
+	assert.Error(t, err)
+	c.PrincipalMappings[0].Principals = []string{"p1 ", "p2", "p1"}
+	c.PrincipalMappings = append(c.PrincipalMappings, UserCAPrincipalMapping{
+		Group:      "g2",
+		Principals: []string{"p2", "p3"},
+	})
+	err = os.WriteFile(keyPath, []byte("invalid key"), 0600)
+	assert.NoError(t, err)
+	err = c.initialize(configDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to parse the private key")
+	}
+	assert.False(t, IsUserCAEnabled())
+	err = os.Remove(keyPath)
+	assert.NoError(t, err)
+	// a new key is generated
+	err = c.initialize(configDir)
+	assert.NoError(t, err)
+	assert.True(t, IsUserCAEnabled())
+	assert.Equal(t, 1440, c.MaxTTL)
+	assert.Equal(t, []string{"p1", "p2"}, c.PrincipalMappings[0].Principals)
+	assert.FileExists(t, keyPath+".pub")
+	pubKeyBytes, err := os.ReadFile(keyPath + ".pub")
+	require.NoError(t, err)
+	caKey, _, _, _, err := ssh.ParseAuthorizedKey(pubKeyBytes) //nolint:dogsled
+	require.NoError(t, err)
+	assert.True(t, userCA.isAuthority(caKey))
+	privateKey, err := generatePrivateKey("ecdsa")
+	require.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	require.NoError(t, err)
+	assert.False(t, userCA.isAuthority(signer.PublicKey()))
+
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "causer",
+		},
+		Groups: []sdk.GroupMapping{
+			{
+				Name: "g2",
+				Type: sdk.GroupTypeSecondary,
+			},
+			{
+				Name: "g1",
+				Type: sdk.GroupTypePrimary,
+			},
+		},
+	}
+	assert.Equal(t, []string{"causer", "p1", "p2", "p3"}, userCA.getPrincipals(&user))
+	user.Groups = nil
+	assert.Equal(t, []string{"causer"}, userCA.getPrincipals(&user))
+
+	_, err = userCA.issue(&user, signer.PublicKey(), 1441)
+	assert.Error(t, err)
+	_, err = userCA.issue(&user, signer.PublicKey(), -1)
+	assert.Error(t, err)
+	cert, err := userCA.issue(&user, signer.PublicKey(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"causer"}, cert.Principals)
+	assert.Equal(t, ssh.FingerprintSHA256(caKey), cert.CAFingerprint)
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(cert.Certificate)) //nolint:dogsled
+	require.NoError(t, err)
+	sshCert, ok := parsed.(*ssh.Certificate)
+	require.True(t, ok)
+	checker := ssh.CertChecker{
+		IsUserAuthority: userCA.isAuthority,
+	}
+	assert.NoError(t, checker.CheckCert("causer", sshCert))
+	assert.Error(t, checker.CheckCert("otheruser", sshCert))
+	// the configured default validity is 120 minutes
+	assert.Equal(t, uint64((120*time.Minute+userCertClockSkew)/time.Second), sshCert.ValidBefore-sshCert.ValidAfter)
+
+	_, err = IssueUserCertificate("causer", string(ssh.MarshalAuthorizedKey(sshCert)), 0, "", "")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "certificates are not supported")
+	}
+	_, err = IssueUserCertificate("missing_user", string(ssh.MarshalAuthorizedKey(signer.PublicKey())), 0, "", "")
+	assert.IsType(t, &util.RecordNotFoundError{}, err)
+}
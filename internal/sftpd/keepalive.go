@@ -0,0 +1,143 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	// keepaliveRequest is the global request sent by the server to check if the peer is alive,
+	// it is the same request used by OpenSSH for ClientAliveInterval
+	keepaliveRequest = "keepalive@openssh.com"
+	// pingRequest is a global request that clients can send to check that the connection is healthy
+	pingRequest              = "ping@sftpgo.com"
+	defaultKeepaliveMaxCount = 3
+)
+
+// handleGlobalRequests replies to the supported global requests and rejects the other ones.
+// Keepalive and ping requests don't update the last activity, so they don't prevent the
+// idle timeout from disconnecting inactive clients
+func handleGlobalRequests(reqs <-chan *ssh.Request, connectionID string, prover *hostKeysProver,
+	forwarder *portForwarder,
+) {
+	for req := range reqs {
+		ok := false
+		var payload []byte
+		switch req.Type {
+		case keepaliveRequest, pingRequest:
+			ok = true
+		case hostKeysProveRequest:
+			if prover == nil {
+				logger.Debug(logSender, connectionID, "host keys prove request received but no rotation is in progress")
+				break
+			}
+			var err error
+			payload, err = prover.prove(req.Payload)
+			if err != nil {
+				logger.Debug(logSender, connectionID, "unable to prove host keys: %v", err)
+				payload = nil
+				break
+			}
+			ok = true
+		case tcpipForwardRequest, cancelTCPIPForwardRequest:
+			if forwarder == nil || !forwarder.settings.AllowRemote {
+				logger.Debug(logSender, connectionID, "%s request received but remote port forwarding is not allowed",
+					req.Type)
+				break
+			}
+			ok, payload = forwarder.handleGlobalRequest(req)
+		default:
+			logger.Debug(logSender, connectionID, "unsupported global request %q", req.Type)
+		}
+		if req.WantReply {
+			req.Reply(ok, payload) //nolint:errcheck
+		}
+	}
+}
+
+type keepaliveChecker struct {
+	conn        ssh.Conn
+	sshConn     *common.SSHConnection
+	interval    time.Duration
+	maxCount    int
+	done        chan struct{}
+	missedCount int
+}
+
+func newKeepaliveChecker(conn ssh.Conn, sshConn *common.SSHConnection, interval time.Duration,
+	maxCount int,
+) *keepaliveChecker {
+	return &keepaliveChecker{
+		conn:     conn,
+		sshConn:  sshConn,
+		interval: interval,
+		maxCount: maxCount,
+		done:     make(chan struct{}),
+	}
+}
+
+// stop stops the checker, it must be called once when the connection ends
+func (k *keepaliveChecker) stop() {
+	close(k.done)
+}
+
+// run sends a keepalive request each interval and closes the connection if the peer
+// does not reply to maxCount consecutive requests. Any reply, including a failure one,
+// proves that the peer is alive
+func (k *keepaliveChecker) run() {
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.done:
+			return
+		case <-ticker.C:
+		}
+
+		replied := make(chan error, 1)
+		go func() {
+			_, _, err := k.conn.SendRequest(keepaliveRequest, true, nil)
+			replied <- err
+		}()
+
+		select {
+		case <-k.done:
+			return
+		case err := <-replied:
+			if err != nil {
+				logger.Debug(logSender, k.sshConn.GetID(), "unable to send keepalive request: %v", err)
+				return
+			}
+			k.missedCount = 0
+		case <-time.After(k.interval):
+			k.missedCount++
+			logger.Debug(logSender, k.sshConn.GetID(), "keepalive request not answered, missed count: %d/%d",
+				k.missedCount, k.maxCount)
+			if k.missedCount >= k.maxCount {
+				logger.Info(logSender, k.sshConn.GetID(), "no reply to %d keepalive requests, closing dead connection",
+					k.missedCount)
+				k.sshConn.Close() //nolint:errcheck
+				return
+			}
+		}
+	}
+}
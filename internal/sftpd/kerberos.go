@@ -0,0 +1,357 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/service"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	defaultKerberosMaxClockSkew = 300
+	// GSS-API token ID for a KRB_AP_REP, RFC 4121 section 4.1
+	gssapiTokenIDAPRep = 0x0200
+	// length of the RFC 4121 authenticator checksum without delegation
+	gssapiChecksumMinLength = 24
+)
+
+var (
+	errKerberosNoContext = errors.New("kerberos: security context not established")
+)
+
+// KerberosPrincipalMapping maps the Kerberos principals matching a regular
+// expression to an SFTPGo username
+type KerberosPrincipalMapping struct {
+	// Regular expression matched against the authenticated principal,
+	// for example "^([^@/]+)@EXAMPLE\\.COM$"
+	Pattern string `json:"pattern" mapstructure:"pattern"`
+	// Username template, the groups captured by the regular expression can be
+	// referenced as $1, $2 and so on, for example "$1"
+	Username string `json:"username" mapstructure:"username"`
+	regex    *regexp.Regexp
+}
+
+// KerberosConfig defines the configuration for the Kerberos "gssapi-with-mic"
+// user authentication, RFC 4462
+type KerberosConfig struct {
+	// Path to the keytab with the keys for the service principal.
+	// The path can be absolute or relative to the configuration directory.
+	// Empty means disabled
+	Keytab string `json:"keytab" mapstructure:"keytab"`
+	// Service principal to use, for example "host/sftp.example.com".
+	// If empty, the service principal requested by the client is used, it must be
+	// in the keytab
+	ServicePrincipal string `json:"service_principal" mapstructure:"service_principal"`
+	// Maximum allowed clock skew, in seconds, between the clients and SFTPGo.
+	// 0 means the default (300 seconds)
+	MaxClockSkew int `json:"max_clock_skew" mapstructure:"max_clock_skew"`
+	// Rules to map the authenticated principals to SFTPGo usernames, the first matching
+	// rule is used. If empty, principals such as "user@REALM" are mapped to "user".
+	// The mapped username must match the one requested by the client
+	PrincipalMappings []KerberosPrincipalMapping `json:"principal_mappings" mapstructure:"principal_mappings"`
+	keytab            *keytab.Keytab
+}
+
+func (k *KerberosConfig) isEnabled() bool {
+	return k.keytab != nil
+}
+
+func (k *KerberosConfig) getMaxClockSkew() time.Duration {
+	if k.MaxClockSkew <= 0 {
+		return defaultKerberosMaxClockSkew * time.Second
+	}
+	return time.Duration(k.MaxClockSkew) * time.Second
+}
+
+func (k *KerberosConfig) initialize(configDir string) error {
+	k.keytab = nil
+	if k.Keytab == "" {
+		return nil
+	}
+	if !util.IsFileInputValid(k.Keytab) {
+		return fmt.Errorf("kerberos: invalid keytab %q", k.Keytab)
+	}
+	keytabPath := k.Keytab
+	if !filepath.IsAbs(keytabPath) {
+		keytabPath = filepath.Join(configDir, keytabPath)
+	}
+	data, err := os.ReadFile(keytabPath)
+	if err != nil {
+		return fmt.Errorf("kerberos: unable to read keytab %q: %w", keytabPath, err)
+	}
+	kt := keytab.New()
+	if err := kt.Unmarshal(data); err != nil {
+		return fmt.Errorf("kerberos: unable to parse keytab %q: %w", keytabPath, err)
+	}
+	if len(kt.Entries) == 0 {
+		return fmt.Errorf("kerberos: keytab %q has no entries", keytabPath)
+	}
+	if k.ServicePrincipal != "" {
+		spn, _ := types.ParseSPNString(k.ServicePrincipal)
+		found := false
+		for _, entry := range kt.Entries {
+			if strings.Join(entry.Principal.Components, "/") == spn.PrincipalNameString() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("kerberos: service principal %q not found in keytab %q", k.ServicePrincipal, keytabPath)
+		}
+	}
+	for idx := range k.PrincipalMappings {
+		mapping := &k.PrincipalMappings[idx]
+		if mapping.Pattern == "" || mapping.Username == "" {
+			return errors.New("kerberos: principal mappings require a pattern and a username")
+		}
+		regex, err := regexp.Compile(mapping.Pattern)
+		if err != nil {
+			return fmt.Errorf("kerberos: invalid principal mapping pattern %q: %w", mapping.Pattern, err)
+		}
+		mapping.regex = regex
+	}
+	k.keytab = kt
+	logger.Debug(logSender, "", "kerberos authentication enabled, keytab %q, entries: %d, principal mappings: %d",
+		keytabPath, len(kt.Entries), len(k.PrincipalMappings))
+	return nil
+}
+
+// getUsername returns the SFTPGo username for the specified principal
+func (k *KerberosConfig) getUsername(principal string) (string, error) {
+	if len(k.PrincipalMappings) == 0 {
+		name, _, found := strings.Cut(principal, "@")
+		if !found || name == "" || strings.Contains(name, "/") {
+			return "", fmt.Errorf("kerberos: unable to map principal %q to a username", principal)
+		}
+		return name, nil
+	}
+	for _, mapping := range k.PrincipalMappings {
+		match := mapping.regex.FindStringSubmatchIndex(principal)
+		if match == nil {
+			continue
+		}
+		username := string(mapping.regex.ExpandString(nil, mapping.Username, principal, match))
+		if username == "" {
+			break
+		}
+		return username, nil
+	}
+	return "", fmt.Errorf("kerberos: no mapping rule matches the principal %q", principal)
+}
+
+func (k *KerberosConfig) getServiceSettings(ipAddr string) *service.Settings {
+	settings := []func(*service.Settings){
+		service.MaxClockSkew(k.getMaxClockSkew()),
+		service.DecodePAC(false),
+	}
+	if k.ServicePrincipal != "" {
+		settings = append(settings, service.KeytabPrincipal(k.ServicePrincipal))
+	}
+	if ip := net.ParseIP(ipAddr); ip != nil {
+		settings = append(settings, service.ClientAddress(types.HostAddressFromNetIP(ip)))
+	}
+	return service.NewSettings(k.keytab, settings...)
+}
+
+// gssapiServer implements the acceptor side of the Kerberos V5 GSS-API mechanism,
+// RFC 4121. A new instance is required for each connection
+type gssapiServer struct {
+	settings *service.Settings
+	// key to use to verify the MIC tokens sent from the initiator
+	key         types.EncryptionKey
+	established bool
+	onError     func(err error)
+}
+
+func (s *gssapiServer) AcceptSecContext(token []byte) ([]byte, string, bool, error) {
+	outputToken, srcName, err := s.acceptSecContext(token)
+	if err != nil {
+		s.onError(err)
+		return nil, "", false, err
+	}
+	return outputToken, srcName, false, nil
+}
+
+func (s *gssapiServer) acceptSecContext(token []byte) ([]byte, string, error) {
+	s.established = false
+
+	var krb5Token spnego.KRB5Token
+	if err := krb5Token.Unmarshal(token); err != nil {
+		return nil, "", fmt.Errorf("kerberos: invalid token: %w", err)
+	}
+	if !krb5Token.IsAPReq() {
+		return nil, "", errors.New("kerberos: the token is not an AP-REQ")
+	}
+	apReq := &krb5Token.APReq
+	ok, creds, err := service.VerifyAPREQ(apReq, s.settings)
+	if err != nil {
+		return nil, "", fmt.Errorf("kerberos: unable to verify AP-REQ: %w", err)
+	}
+	if !ok {
+		return nil, "", errors.New("kerberos: invalid AP-REQ")
+	}
+	checksum := apReq.Authenticator.Cksum
+	if checksum.CksumType != chksumtype.GSSAPI || len(checksum.Checksum) < gssapiChecksumMinLength {
+		return nil, "", errors.New("kerberos: invalid authenticator checksum")
+	}
+	s.key = apReq.Ticket.DecryptedEncPart.Key
+	if len(apReq.Authenticator.SubKey.KeyValue) > 0 {
+		s.key = apReq.Authenticator.SubKey
+	}
+	s.established = true
+	srcName := fmt.Sprintf("%s@%s", creds.CName().PrincipalNameString(), creds.Realm())
+
+	contextFlags := binary.LittleEndian.Uint32(checksum.Checksum[20:24])
+	if contextFlags&uint32(gssapi.ContextFlagMutual) == 0 && !types.IsFlagSet(&apReq.APOptions, flags.APOptionMutualRequired) {
+		return nil, srcName, nil
+	}
+	outputToken, err := getAPRepToken(apReq)
+	if err != nil {
+		s.established = false
+		return nil, "", err
+	}
+	return outputToken, srcName, nil
+}
+
+func (s *gssapiServer) VerifyMIC(micField []byte, micToken []byte) error {
+	err := s.verifyMIC(micField, micToken)
+	if err != nil {
+		s.onError(err)
+	}
+	return err
+}
+
+func (s *gssapiServer) verifyMIC(micField []byte, micToken []byte) error {
+	if !s.established {
+		return errKerberosNoContext
+	}
+	var token gssapi.MICToken
+	if err := token.Unmarshal(micToken, false); err != nil {
+		return fmt.Errorf("kerberos: invalid MIC token: %w", err)
+	}
+	token.Payload = micField
+	ok, err := token.Verify(s.key, keyusage.GSSAPI_INITIATOR_SIGN)
+	if err != nil {
+		return fmt.Errorf("kerberos: unable to verify MIC: %w", err)
+	}
+	if !ok {
+		return errors.New("kerberos: invalid MIC")
+	}
+	return nil
+}
+
+func (s *gssapiServer) DeleteSecContext() error {
+	s.established = false
+	s.key = types.EncryptionKey{}
+	return nil
+}
+
+// getAPRepToken returns the GSS-API token with the KRB_AP_REP used for
+// mutual authentication, RFC 4120 section 3.2.4
+func getAPRepToken(apReq *messages.APReq) ([]byte, error) {
+	seqNum, err := rand.Int(rand.Reader, big.NewInt(math.MaxUint32))
+	if err != nil {
+		return nil, err
+	}
+	encPart := messages.EncAPRepPart{
+		CTime:          apReq.Authenticator.CTime,
+		Cusec:          apReq.Authenticator.Cusec,
+		SequenceNumber: seqNum.Int64() & 0x3fffffff,
+	}
+	encPartBytes, err := asn1.Marshal(encPart)
+	if err != nil {
+		return nil, fmt.Errorf("kerberos: unable to marshal AP-REP encrypted part: %w", err)
+	}
+	encPartBytes = asn1tools.AddASNAppTag(encPartBytes, asnAppTag.EncAPRepPart)
+	encryptedData, err := crypto.GetEncryptedData(encPartBytes, apReq.Ticket.DecryptedEncPart.Key,
+		keyusage.AP_REP_ENCPART, 0)
+	if err != nil {
+		return nil, fmt.Errorf("kerberos: unable to encrypt AP-REP: %w", err)
+	}
+	apRep := messages.APRep{
+		PVNO:    5,
+		MsgType: msgtype.KRB_AP_REP,
+		EncPart: encryptedData,
+	}
+	apRepBytes, err := asn1.Marshal(apRep)
+	if err != nil {
+		return nil, fmt.Errorf("kerberos: unable to marshal AP-REP: %w", err)
+	}
+	apRepBytes = asn1tools.AddASNAppTag(apRepBytes, asnAppTag.APREP)
+
+	oid, err := asn1.Marshal(gssapi.OIDKRB5.OID())
+	if err != nil {
+		return nil, err
+	}
+	token := make([]byte, 0, len(oid)+2+len(apRepBytes))
+	token = append(token, oid...)
+	token = binary.BigEndian.AppendUint16(token, gssapiTokenIDAPRep)
+	token = append(token, apRepBytes...)
+	return asn1tools.AddASNAppTag(token, 0), nil
+}
+
+// getGSSAPIConfig returns a copy of the specified config with a new GSS-API acceptor,
+// the GSS-API security context is per connection
+func (c *Configuration) getGSSAPIConfig(config *ssh.ServerConfig, ipAddr string) *ssh.ServerConfig {
+	connConfig := *config
+	connConfig.GSSAPIWithMICConfig = &ssh.GSSAPIWithMICConfig{
+		AllowLogin: func(conn ssh.ConnMetadata, srcName string) (*ssh.Permissions, error) {
+			sp, err := c.validateGSSAPICredentials(conn, srcName)
+			if err != nil {
+				return nil, &authenticationError{err: fmt.Sprintf("could not validate gssapi-with-mic credentials: %v", err)}
+			}
+
+			return sp, nil
+		},
+		Server: &gssapiServer{
+			settings: c.Kerberos.getServiceSettings(ipAddr),
+			onError: func(err error) {
+				logger.Debug(logSender, "", "kerberos authentication failed, ip %q: %v", ipAddr, err)
+				updateLoginMetrics(&dataprovider.User{}, ipAddr, dataprovider.SSHLoginMethodGSSAPI, err)
+			},
+		},
+	}
+	return &connConfig
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"encoding/hex"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+// keyRestrictions stores the SFTP only and port forwarding restrictions derived from the
+// authorized_keys options for connections still in the authentication phase.
+// The SSH library discards the permissions returned for partial successes, so
+// restrictions are tracked for each client connection, keyed by remote address,
+// and they are applied if any successfully checked key has them: a client cannot
+// remove a restriction by querying multiple keys
+var keyRestrictions = keyRestrictionsStore{
+	sftpOnly:         make(map[string]bool),
+	noPortForwarding: make(map[string]bool),
+}
+
+type keyRestrictionsStore struct {
+	sync.RWMutex
+	sftpOnly         map[string]bool
+	noPortForwarding map[string]bool
+}
+
+func (s *keyRestrictionsStore) add(remoteAddr string, options *dataprovider.PublicKeyOptions) {
+	if !options.IsSFTPOnly() && !options.NoPortForwarding {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if options.IsSFTPOnly() {
+		s.sftpOnly[remoteAddr] = true
+	}
+	if options.NoPortForwarding {
+		s.noPortForwarding[remoteAddr] = true
+	}
+}
+
+func (s *keyRestrictionsStore) isSFTPOnly(remoteAddr string) bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.sftpOnly[remoteAddr]
+}
+
+func (s *keyRestrictionsStore) isPortForwardingDenied(remoteAddr string) bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.noPortForwarding[remoteAddr]
+}
+
+func (s *keyRestrictionsStore) remove(remoteAddr string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.sftpOnly, remoteAddr)
+	delete(s.noPortForwarding, remoteAddr)
+}
+
+// checkPublicKeyOptions enforces the authorized_keys options for the stored
+// key matching the one used for authentication
+func checkPublicKeyOptions(user *dataprovider.User, pubKey ssh.PublicKey, conn ssh.ConnMetadata, ipAddr string) error {
+	connectionID := hex.EncodeToString(conn.SessionID())
+	options, err := user.GetPublicKeyOptions(pubKey.Marshal())
+	if err != nil {
+		logger.Warn(logSender, connectionID, "unable to get public key options for user %q: %v", user.Username, err)
+		return err
+	}
+	if err := options.CheckLoginConditions(ipAddr); err != nil {
+		logger.Info(logSender, connectionID, "cannot login user %q: %v", user.Username, err)
+		return err
+	}
+	keyRestrictions.add(conn.RemoteAddr().String(), &options)
+	return nil
+}
@@ -35,6 +35,7 @@ type Middleware interface {
 	sftp.StatVFSFileCmder
 	sftp.FileLister
 	sftp.LstatFileLister
+	extensionsHandler
 }
 
 type prefixMatch uint8
@@ -157,6 +158,24 @@ func (p *prefixMiddleware) Filecmd(request *sftp.Request) error {
 	}
 }
 
+func (p *prefixMiddleware) CopyFile(source, target string, overwrite bool) error {
+	if getPrefixHierarchy(p.prefix, source) == pathContainsPrefix &&
+		getPrefixHierarchy(p.prefix, target) == pathContainsPrefix {
+		source, _ = p.removeFolderPrefix(source)
+		target, _ = p.removeFolderPrefix(target)
+		return p.next.CopyFile(source, target, overwrite)
+	}
+	return sftp.ErrSSHFxPermissionDenied
+}
+
+func (p *prefixMiddleware) GetCustomMetadata(virtualPath string) (map[string]string, error) {
+	if getPrefixHierarchy(p.prefix, virtualPath) == pathContainsPrefix {
+		virtualPath, _ = p.removeFolderPrefix(virtualPath)
+		return p.next.GetCustomMetadata(virtualPath)
+	}
+	return nil, sftp.ErrSSHFxPermissionDenied
+}
+
 func (p *prefixMiddleware) StatVFS(request *sftp.Request) (*sftp.StatVFS, error) {
 	switch getPrefixHierarchy(p.prefix, request.Filepath) {
 	case pathContainsPrefix:
@@ -62,6 +62,39 @@ func (Suite *PrefixMiddlewareSuite) TestFileWriter() {
 	Suite.Nil(WriterAt)
 }
 
+func (Suite *PrefixMiddlewareSuite) TestCopyFile() {
+	middleware := prefixMiddleware{prefix: `/files`}
+
+	err := middleware.CopyFile(`/files/a`, `/b`, false)
+	Suite.Equal(sftp.ErrSSHFxPermissionDenied, err)
+	err = middleware.CopyFile(`/`, `/files/b`, false)
+	Suite.Equal(sftp.ErrSSHFxPermissionDenied, err)
+
+	mockedCopier := mocks.NewMockMiddleware(Suite.MockCtl)
+	mockedCopier.EXPECT().
+		CopyFile(`/a`, `/sub/b`, true).
+		Return(nil)
+	middleware.next = mockedCopier
+	err = middleware.CopyFile(`/files/a`, `/files/sub/b`, true)
+	Suite.Nil(err)
+}
+
+func (Suite *PrefixMiddlewareSuite) TestGetCustomMetadata() {
+	middleware := prefixMiddleware{prefix: `/files`}
+
+	_, err := middleware.GetCustomMetadata(`/a`)
+	Suite.Equal(sftp.ErrSSHFxPermissionDenied, err)
+
+	mockedHandler := mocks.NewMockMiddleware(Suite.MockCtl)
+	mockedHandler.EXPECT().
+		GetCustomMetadata(`/sub/a`).
+		Return(map[string]string{"label": "value"}, nil)
+	middleware.next = mockedHandler
+	metadata, err := middleware.GetCustomMetadata(`/files/sub/a`)
+	Suite.Nil(err)
+	Suite.Equal(map[string]string{"label": "value"}, metadata)
+}
+
 func (Suite *PrefixMiddlewareSuite) TestFileReader() {
 	middleware := prefixMiddleware{prefix: `/files`}
 
@@ -35,6 +35,20 @@ func (m *MockMiddleware) EXPECT() *MockMiddlewareMockRecorder {
 	return m.recorder
 }
 
+// CopyFile mocks base method.
+func (m *MockMiddleware) CopyFile(arg0, arg1 string, arg2 bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CopyFile", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CopyFile indicates an expected call of CopyFile.
+func (mr *MockMiddlewareMockRecorder) CopyFile(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CopyFile", reflect.TypeOf((*MockMiddleware)(nil).CopyFile), arg0, arg1, arg2)
+}
+
 // Filecmd mocks base method.
 func (m *MockMiddleware) Filecmd(arg0 *sftp.Request) error {
 	m.ctrl.T.Helper()
@@ -94,6 +108,21 @@ func (mr *MockMiddlewareMockRecorder) Filewrite(arg0 any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Filewrite", reflect.TypeOf((*MockMiddleware)(nil).Filewrite), arg0)
 }
 
+// GetCustomMetadata mocks base method.
+func (m *MockMiddleware) GetCustomMetadata(arg0 string) (map[string]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCustomMetadata", arg0)
+	ret0, _ := ret[0].(map[string]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCustomMetadata indicates an expected call of GetCustomMetadata.
+func (mr *MockMiddlewareMockRecorder) GetCustomMetadata(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCustomMetadata", reflect.TypeOf((*MockMiddleware)(nil).GetCustomMetadata), arg0)
+}
+
 // Lstat mocks base method.
 func (m *MockMiddleware) Lstat(arg0 *sftp.Request) (sftp.ListerAt, error) {
 	m.ctrl.T.Helper()
@@ -0,0 +1,409 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package sftpd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	directTCPIPChannel        = "direct-tcpip"
+	forwardedTCPIPChannel     = "forwarded-tcpip"
+	tcpipForwardRequest       = "tcpip-forward"
+	cancelTCPIPForwardRequest = "cancel-tcpip-forward"
+	portForwardDialTimeout    = 10 * time.Second
+)
+
+// RFC 4254 section 7.2
+type directTCPIPPayload struct {
+	Host       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// RFC 4254 section 7.1
+type tcpipForwardPayload struct {
+	BindAddr string
+	BindPort uint32
+}
+
+type tcpipForwardReply struct {
+	BindPort uint32
+}
+
+// RFC 4254 section 7.2
+type forwardedTCPIPPayload struct {
+	ConnectedAddr string
+	ConnectedPort uint32
+	OriginAddr    string
+	OriginPort    uint32
+}
+
+// portForwarder handles the local and remote TCP port forwarding for an SSH connection
+type portForwarder struct {
+	sconn      ssh.Conn
+	sshConn    *common.SSHConnection
+	connection *common.BaseConnection
+	settings   dataprovider.SSHPortForwarding
+	mu         sync.Mutex
+	closed     bool
+	listeners  map[string]net.Listener
+	conns      map[net.Conn]bool
+}
+
+// newPortForwarder returns nil if port forwarding is not enabled for the specified user
+func newPortForwarder(sconn ssh.Conn, sshConn *common.SSHConnection, user dataprovider.User, connectionID,
+	localAddr, remoteAddr string,
+) *portForwarder {
+	if !user.Filters.PortForwarding.IsEnabled() {
+		return nil
+	}
+	return &portForwarder{
+		sconn:      sconn,
+		sshConn:    sshConn,
+		connection: common.NewBaseConnection(connectionID, common.ProtocolSSH, localAddr, remoteAddr, user),
+		settings:   *user.Filters.PortForwarding,
+		listeners:  make(map[string]net.Listener),
+		conns:      make(map[net.Conn]bool),
+	}
+}
+
+func (f *portForwarder) canForwardLocal() bool {
+	return f != nil && f.settings.AllowLocal
+}
+
+// handleDirectTCPIP handles a local port forwarding request
+func (f *portForwarder) handleDirectTCPIP(newChannel ssh.NewChannel) {
+	var payload directTCPIPPayload
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		f.connection.Log(logger.LevelDebug, "invalid direct-tcpip payload: %v", err)
+		newChannel.Reject(ssh.ConnectionFailed, "invalid payload") //nolint:errcheck
+		return
+	}
+	destination := net.JoinHostPort(payload.Host, strconv.FormatUint(uint64(payload.Port), 10))
+	origin := net.JoinHostPort(payload.OriginAddr, strconv.FormatUint(uint64(payload.OriginPort), 10))
+
+	dialAddress, err := f.getDialAddress(payload.Host, int(payload.Port))
+	if err != nil {
+		f.connection.Log(logger.LevelInfo, "local port forwarding to %q denied: %v", destination, err)
+		newChannel.Reject(ssh.Prohibited, "destination not allowed") //nolint:errcheck
+		f.executeNotification(directTCPIPChannel, destination, origin, 0, err)
+		return
+	}
+	conn, err := net.DialTimeout("tcp", dialAddress, portForwardDialTimeout)
+	if err != nil {
+		f.connection.Log(logger.LevelInfo, "unable to connect to %q: %v", destination, err)
+		newChannel.Reject(ssh.ConnectionFailed, "unable to connect") //nolint:errcheck
+		f.executeNotification(directTCPIPChannel, destination, origin, 0, err)
+		return
+	}
+	if !f.addConn(conn) {
+		conn.Close()
+		newChannel.Reject(ssh.ConnectionFailed, "connection closed") //nolint:errcheck
+		return
+	}
+	defer f.removeConn(conn)
+
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		f.connection.Log(logger.LevelWarn, "could not accept a direct-tcpip channel: %v", err)
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	f.connection.Log(logger.LevelDebug, "local port forwarding started, destination: %q, origin: %q",
+		destination, origin)
+	size, err := f.pipe(channel, conn)
+	f.connection.Log(logger.LevelDebug, "local port forwarding to %q ended, transferred bytes: %d, err: %v",
+		destination, size, err)
+	f.executeNotification(directTCPIPChannel, destination, origin, size, err)
+}
+
+// getDialAddress returns the address to connect to for the specified destination.
+// If the destination is allowed by IP address and not by name, the allowed
+// resolved IP is returned so the name cannot resolve to a different address later
+func (f *portForwarder) getDialAddress(host string, port int) (string, error) {
+	if f.settings.IsDestinationAllowed(host, port) {
+		return net.JoinHostPort(host, strconv.Itoa(port)), nil
+	}
+	if net.ParseIP(host) == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), portForwardDialTimeout)
+		defer cancel()
+
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err == nil {
+			for _, addr := range addrs {
+				if f.settings.IsDestinationAllowed(addr.IP.String(), port) {
+					return net.JoinHostPort(addr.IP.String(), strconv.Itoa(port)), nil
+				}
+			}
+		}
+	}
+	return "", common.ErrPermissionDenied
+}
+
+// handleGlobalRequest handles the remote port forwarding requests
+func (f *portForwarder) handleGlobalRequest(req *ssh.Request) (bool, []byte) {
+	var payload tcpipForwardPayload
+	if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+		f.connection.Log(logger.LevelDebug, "invalid %s payload: %v", req.Type, err)
+		return false, nil
+	}
+	if payload.BindPort > 65535 {
+		f.connection.Log(logger.LevelDebug, "invalid %s port: %d", req.Type, payload.BindPort)
+		return false, nil
+	}
+	if req.Type == cancelTCPIPForwardRequest {
+		return f.cancelRemoteForward(payload.BindAddr, int(payload.BindPort)), nil
+	}
+	return f.startRemoteForward(payload.BindAddr, int(payload.BindPort))
+}
+
+func (f *portForwarder) startRemoteForward(bindAddr string, bindPort int) (bool, []byte) {
+	address := net.JoinHostPort(bindAddr, strconv.Itoa(bindPort))
+	if !f.settings.IsListenAllowed(bindAddr, bindPort) {
+		f.connection.Log(logger.LevelInfo, "remote port forwarding from %q denied", address)
+		f.executeNotification(tcpipForwardRequest, address, "", 0, common.ErrPermissionDenied)
+		return false, nil
+	}
+	listenHost := bindAddr
+	if listenHost == "*" {
+		listenHost = ""
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(listenHost, strconv.Itoa(bindPort)))
+	if err != nil {
+		f.connection.Log(logger.LevelInfo, "unable to listen on %q: %v", address, err)
+		f.executeNotification(tcpipForwardRequest, address, "", 0, err)
+		return false, nil
+	}
+	var reply []byte
+	if bindPort == 0 {
+		bindPort = listener.Addr().(*net.TCPAddr).Port
+		reply = ssh.Marshal(&tcpipForwardReply{BindPort: uint32(bindPort)})
+	}
+	key := net.JoinHostPort(bindAddr, strconv.Itoa(bindPort))
+
+	f.mu.Lock()
+	_, exists := f.listeners[key]
+	if f.closed || exists {
+		f.mu.Unlock()
+		listener.Close()
+		f.connection.Log(logger.LevelInfo, "unable to start remote port forwarding from %q, closed: %t, exists: %t",
+			key, f.closed, exists)
+		return false, nil
+	}
+	f.listeners[key] = listener
+	f.mu.Unlock()
+
+	f.connection.Log(logger.LevelDebug, "remote port forwarding started, listening on %q", listener.Addr())
+	go f.acceptForwardedConnections(listener, bindAddr, bindPort)
+	return true, reply
+}
+
+func (f *portForwarder) cancelRemoteForward(bindAddr string, bindPort int) bool {
+	key := net.JoinHostPort(bindAddr, strconv.Itoa(bindPort))
+
+	f.mu.Lock()
+	listener, ok := f.listeners[key]
+	delete(f.listeners, key)
+	f.mu.Unlock()
+
+	if !ok {
+		f.connection.Log(logger.LevelDebug, "no remote port forwarding to cancel for %q", key)
+		return false
+	}
+	err := listener.Close()
+	f.connection.Log(logger.LevelDebug, "remote port forwarding from %q canceled, close err: %v", key, err)
+	return true
+}
+
+func (f *portForwarder) acceptForwardedConnections(listener net.Listener, bindAddr string, bindPort int) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				f.connection.Log(logger.LevelWarn, "unable to accept forwarded connections on %q: %v",
+					listener.Addr(), err)
+			}
+			return
+		}
+		go f.handleForwardedConnection(conn, bindAddr, bindPort)
+	}
+}
+
+func (f *portForwarder) handleForwardedConnection(conn net.Conn, bindAddr string, bindPort int) {
+	address := net.JoinHostPort(bindAddr, strconv.Itoa(bindPort))
+	origin := conn.RemoteAddr().String()
+	if !f.addConn(conn) {
+		conn.Close()
+		return
+	}
+	defer f.removeConn(conn)
+
+	payload := forwardedTCPIPPayload{
+		ConnectedAddr: bindAddr,
+		ConnectedPort: uint32(bindPort),
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		payload.OriginAddr = addr.IP.String()
+		payload.OriginPort = uint32(addr.Port)
+	}
+	channel, reqs, err := f.sconn.OpenChannel(forwardedTCPIPChannel, ssh.Marshal(&payload))
+	if err != nil {
+		conn.Close()
+		f.connection.Log(logger.LevelInfo, "unable to open a forwarded-tcpip channel for %q: %v", address, err)
+		f.executeNotification(forwardedTCPIPChannel, address, origin, 0, err)
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	f.connection.Log(logger.LevelDebug, "remote port forwarding connection started, address: %q, origin: %q",
+		address, origin)
+	size, err := f.pipe(channel, conn)
+	f.connection.Log(logger.LevelDebug, "remote port forwarding connection from %q ended, transferred bytes: %d, err: %v",
+		origin, size, err)
+	f.executeNotification(forwardedTCPIPChannel, address, origin, size, err)
+}
+
+// pipe copies the data between the SSH channel and the TCP connection until
+// both directions are done and returns the total transferred bytes
+func (f *portForwarder) pipe(channel ssh.Channel, conn net.Conn) (int64, error) {
+	var wg sync.WaitGroup
+	var received int64
+	var errReceived error
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		received, errReceived = f.copy(conn, channel)
+		if c, ok := conn.(interface{ CloseWrite() error }); ok && errReceived == nil {
+			c.CloseWrite() //nolint:errcheck
+		} else {
+			conn.Close()
+		}
+	}()
+
+	sent, errSent := f.copy(channel, conn)
+	if errSent == nil {
+		channel.CloseWrite() //nolint:errcheck
+	} else {
+		channel.Close()
+	}
+	wg.Wait()
+	channel.Close()
+	conn.Close()
+
+	if errSent != nil {
+		return sent + received, errSent
+	}
+	return sent + received, errReceived
+}
+
+func (f *portForwarder) copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := make([]byte, 32768)
+	start := time.Now()
+	var written int64
+
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			f.sshConn.UpdateLastActivity()
+			nw, errWrite := dst.Write(buf[:n])
+			written += int64(nw)
+			if errWrite != nil {
+				return written, getForwardingError(errWrite)
+			}
+			f.throttle(start, written)
+		}
+		if err != nil {
+			return written, getForwardingError(err)
+		}
+	}
+}
+
+func (f *portForwarder) throttle(start time.Time, transferredBytes int64) {
+	if f.settings.Bandwidth <= 0 {
+		return
+	}
+	// real and wanted elapsed as milliseconds, bytes as kilobytes
+	realElapsed := time.Since(start).Nanoseconds() / 1000000
+	wantedElapsed := 1000 * (transferredBytes / 1024) / f.settings.Bandwidth
+	if wantedElapsed > realElapsed {
+		toSleep := time.Duration(wantedElapsed - realElapsed)
+		time.Sleep(toSleep * time.Millisecond)
+	}
+}
+
+func (f *portForwarder) addConn(conn net.Conn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return false
+	}
+	f.conns[conn] = true
+	return true
+}
+
+func (f *portForwarder) removeConn(conn net.Conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.conns, conn)
+}
+
+// close stops the remote forwarding listeners and closes the forwarded connections
+func (f *portForwarder) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	for key, listener := range f.listeners {
+		err := listener.Close()
+		f.connection.Log(logger.LevelDebug, "remote port forwarding from %q stopped, close err: %v", key, err)
+		delete(f.listeners, key)
+	}
+	for conn := range f.conns {
+		conn.Close()
+		delete(f.conns, conn)
+	}
+}
+
+func (f *portForwarder) executeNotification(sshRequest, address, origin string, size int64, err error) {
+	common.ExecuteActionNotification(f.connection, common.OperationPortForward, "", address, "", origin, //nolint:errcheck
+		sshRequest, size, err)
+}
+
+func getForwardingError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return fmt.Errorf("port forwarding error: %w", err)
+}
@@ -0,0 +1,129 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+var (
+	// rsync options that allow to execute other commands or to read and write
+	// paths outside the command path, they are not allowed for the server side rsync
+	rsyncDeniedOptions = []string{"--rsh", "--rsync-path", "--daemon", "--config", "--write-batch",
+		"--only-write-batch", "--read-batch", "--log-file", "--temp-dir", "--partial-dir", "--backup-dir",
+		"--compare-dest", "--copy-dest", "--link-dest", "--files-from", "--exclude-from", "--include-from",
+		"--filter"}
+	rsyncDeniedShortOptions = []string{"-e", "-T", "-f"}
+)
+
+// rsyncFileState defines the state of a file, it is used to detect the files
+// changed by rsync
+type rsyncFileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// validateRsyncArgs checks that rsync is executed in server mode, as done by
+// rsync clients over SSH, and without options that could escape the command path
+func validateRsyncArgs(args []string) error {
+	if len(args) == 0 || args[0] != "--server" {
+		return fmt.Errorf("%w: rsync must be executed in server mode", errUnsupportedConfig)
+	}
+	for _, arg := range args {
+		if util.Contains(rsyncDeniedShortOptions, arg) {
+			return fmt.Errorf("%w: rsync option %q is not allowed", errUnsupportedConfig, arg)
+		}
+		if !strings.HasPrefix(arg, "--") {
+			continue
+		}
+		name, _, _ := strings.Cut(arg, "=")
+		if util.Contains(rsyncDeniedOptions, name) {
+			return fmt.Errorf("%w: rsync option %q is not allowed", errUnsupportedConfig, name)
+		}
+	}
+	return nil
+}
+
+// isRsyncSender returns true if the server side rsync sends files to the client,
+// in this mode rsync only reads from the command path
+func isRsyncSender(args []string) bool {
+	return util.Contains(args, "--sender")
+}
+
+func (c *sshCommand) isRsyncReceiver() bool {
+	return c.command == "rsync" && !isRsyncSender(c.args)
+}
+
+// getSystemCommandPerms returns the permissions required to execute the command
+func (c *sshCommand) getSystemCommandPerms() []string {
+	if c.command == "rsync" && isRsyncSender(c.args) {
+		return []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	}
+	return []string{dataprovider.PermDownload, dataprovider.PermUpload, dataprovider.PermCreateDirs,
+		dataprovider.PermListItems, dataprovider.PermOverwrite, dataprovider.PermDelete}
+}
+
+// getRsyncSnapshot returns the state of the regular files inside the specified path
+func (c *sshCommand) getRsyncSnapshot(fs vfs.Fs, fsPath string) map[string]rsyncFileState {
+	snapshot := make(map[string]rsyncFileState)
+	err := fs.Walk(fsPath, func(walkedPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if fs.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			snapshot[walkedPath] = rsyncFileState{
+				size:    info.Size(),
+				modTime: info.ModTime(),
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.connection.Log(logger.LevelDebug, "unable to get the files state for path %q: %v", fsPath, err)
+	}
+	return snapshot
+}
+
+// notifyRsyncChanges executes the upload and delete actions for the files
+// added, changed or removed by rsync
+func (c *sshCommand) notifyRsyncChanges(fs vfs.Fs, fsPath string, before map[string]rsyncFileState) {
+	after := c.getRsyncSnapshot(fs, fsPath)
+	for p, state := range after {
+		if old, ok := before[p]; ok && old == state {
+			continue
+		}
+		common.ExecuteActionNotification(c.connection.BaseConnection, common.OperationUpload, p, //nolint:errcheck
+			fs.GetRelativePath(p), "", "", "", state.size, nil)
+	}
+	for p, state := range before {
+		if _, ok := after[p]; ok {
+			continue
+		}
+		common.ExecuteActionNotification(c.connection.BaseConnection, common.OperationDelete, p, //nolint:errcheck
+			fs.GetRelativePath(p), "", "", "", state.size, nil)
+	}
+}
@@ -236,6 +236,12 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 		c.sendErrorMessage(nil, err)
 		return err
 	}
+	maxWriteSize, _ := c.connection.GetMaxWriteSize(requestPath, diskQuota, false, fileSize, fs.IsUploadResumeSupported())
+	if err := c.connection.CheckDeclaredUploadSize(sizeToRead, maxWriteSize, transferQuota); err != nil {
+		c.connection.Log(logger.LevelError, "error uploading file: %#v, err: %v", filePath, err)
+		c.sendErrorMessage(nil, err)
+		return err
+	}
 	err := common.ExecutePreAction(c.connection.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath,
 		fileSize, os.O_TRUNC)
 	if err != nil {
@@ -245,9 +251,17 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 		return err
 	}
 
-	maxWriteSize, _ := c.connection.GetMaxWriteSize(diskQuota, false, fileSize, fs.IsUploadResumeSupported())
+	if !isNewFile && common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+		err = fs.Rename(resolvedPath, filePath)
+		if err != nil {
+			c.connection.Log(logger.LevelError, "error renaming existing file for atomic upload, source: %#v, dest: %#v, err: %v",
+				resolvedPath, filePath, err)
+			c.sendErrorMessage(fs, err)
+			return err
+		}
+	}
 
-	file, w, cancelFn, err := fs.Create(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	file, w, cancelFn, err := vfs.CreateWithSize(fs, filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, sizeToRead)
 	if err != nil {
 		c.connection.Log(logger.LevelError, "error creating file %#v: %v", resolvedPath, err)
 		c.sendErrorMessage(fs, err)
@@ -260,7 +274,7 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 		if vfs.HasTruncateSupport(fs) {
 			vfolder, err := c.connection.User.GetVirtualFolderForPath(path.Dir(requestPath))
 			if err == nil {
-				dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
+				dataprovider.UpdateVirtualFolderQuotaForUser(&vfolder, c.connection.User.Username, 0, -fileSize) //nolint:errcheck
 				if vfolder.IsIncludedInUserQuota() {
 					dataprovider.UpdateUserQuota(&c.connection.User, 0, -fileSize, false) //nolint:errcheck
 				}
@@ -334,16 +348,6 @@ func (c *scpCommand) handleUpload(uploadFilePath string, sizeToRead int64) error
 		return common.ErrPermissionDenied
 	}
 
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
-		err = fs.Rename(p, filePath)
-		if err != nil {
-			c.connection.Log(logger.LevelError, "error renaming existing file for atomic upload, source: %#v, dest: %#v, err: %v",
-				p, filePath, err)
-			c.sendErrorMessage(fs, err)
-			return err
-		}
-	}
-
 	return c.handleUploadFile(fs, p, filePath, sizeToRead, false, stat.Size(), uploadFilePath)
 }
 
@@ -443,9 +447,7 @@ func (c *scpCommand) sendDownloadFileData(fs vfs.Fs, filePath string, stat os.Fi
 			return err
 		}
 	}
-	if vfs.IsCryptOsFs(fs) {
-		stat = fs.(*vfs.CryptFs).ConvertFileInfo(stat)
-	}
+	stat = vfs.ConvertFileInfo(fs, stat)
 
 	fileSize := stat.Size()
 	readed := int64(0)
@@ -16,6 +16,7 @@ package sftpd
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -38,6 +39,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
@@ -102,6 +104,26 @@ type Binding struct {
 	Port int `json:"port" mapstructure:"port"`
 	// Apply the proxy configuration, if any, for this binding
 	ApplyProxyConfig bool `json:"apply_proxy_config" mapstructure:"apply_proxy_config"`
+	// Interval, in seconds, between server initiated keepalive requests.
+	// Keepalive requests are sent through the encrypted channel and the client must reply,
+	// so dead peers, for example behind an expired NAT mapping, are detected and disconnected.
+	// 0 means disabled
+	KeepaliveInterval int `json:"keepalive_interval" mapstructure:"keepalive_interval"`
+	// Number of consecutive unanswered keepalive requests after which the connection
+	// is closed. 0 means the default (3)
+	KeepaliveMaxCount int `json:"keepalive_max_count" mapstructure:"keepalive_max_count"`
+	// Client versions allowed or denied for this binding. The client version is checked
+	// before any authentication attempt
+	ClientPolicy dataprovider.ClientPolicy `json:"client_policy" mapstructure:"client_policy"`
+	// Set to true to make the authentication failures for unknown users indistinguishable
+	// from the failures for existing users: a password is always requested and checked
+	// against a dummy hash, so the time spent is about the same
+	UniformAuthErrors bool `json:"uniform_auth_errors" mapstructure:"uniform_auth_errors"`
+	// ISO 3166-1 alpha-2 country codes allowed to connect to this binding. If set,
+	// only clients geolocated in the listed countries are allowed
+	AllowedCountries []string `json:"allowed_countries" mapstructure:"allowed_countries"`
+	// ISO 3166-1 alpha-2 country codes denied to connect to this binding
+	DeniedCountries []string `json:"denied_countries" mapstructure:"denied_countries"`
 }
 
 // GetAddress returns the binding address
@@ -119,6 +141,20 @@ func (b *Binding) HasProxy() bool {
 	return b.ApplyProxyConfig && common.Config.ProxyProtocol > 0
 }
 
+func (b *Binding) getKeepaliveInterval() time.Duration {
+	if b.KeepaliveInterval <= 0 {
+		return 0
+	}
+	return time.Duration(b.KeepaliveInterval) * time.Second
+}
+
+func (b *Binding) getKeepaliveMaxCount() int {
+	if b.KeepaliveMaxCount <= 0 {
+		return defaultKeepaliveMaxCount
+	}
+	return b.KeepaliveMaxCount
+}
+
 // Configuration for the SFTP server
 type Configuration struct {
 	// Identification string used by the server
@@ -158,6 +194,9 @@ type Configuration struct {
 	// Example content:
 	// ["SHA256:bsBRHC/xgiqBJdSuvSTNpJNLTISP/G356jNMCRYC5Es","SHA256:119+8cL/HH+NLMawRsJx6CzPF1I3xC+jpM60bQHXGE8"]
 	RevokedUserCertsFile string `json:"revoked_user_certs_file" mapstructure:"revoked_user_certs_file"`
+	// UserCA defines the built-in certificate authority that can issue short-lived
+	// user certificates. The certificates issued by this authority are always trusted
+	UserCA UserCAConfig `json:"user_ca" mapstructure:"user_ca"`
 	// LoginBannerFile the contents of the specified file, if any, are sent to
 	// the remote user before authentication is allowed.
 	LoginBannerFile string `json:"login_banner_file" mapstructure:"login_banner_file"`
@@ -196,7 +235,17 @@ type Configuration struct {
 	// The prefix is only applied to SFTP requests, SCP and other SSH commands will be automatically disabled if
 	// you configure a prefix.
 	// This setting can help some migrations from OpenSSH. It is not recommended for general usage.
-	FolderPrefix     string `json:"folder_prefix" mapstructure:"folder_prefix"`
+	FolderPrefix string `json:"folder_prefix" mapstructure:"folder_prefix"`
+	// CopyExtensions enables the "copy-data" and "copy-file" SFTP extensions.
+	// The SFTP packets are inspected by SFTPGo before forwarding them to the SFTP server
+	// so this setting adds some overhead to every SFTP session and it is disabled by default.
+	CopyExtensions bool `json:"copy_extensions" mapstructure:"copy_extensions"`
+	// CustomMetadata enables returning the file metadata as extended attributes in the
+	// SFTP stat responses. For cloud storage backends an additional request is required
+	// for each stat request, so this setting is disabled by default.
+	CustomMetadata bool `json:"custom_metadata" mapstructure:"custom_metadata"`
+	// Kerberos defines the configuration for the "gssapi-with-mic" authentication
+	Kerberos         KerberosConfig `json:"kerberos" mapstructure:"kerberos"`
 	certChecker      *ssh.CertChecker
 	parsedUserCAKeys []ssh.PublicKey
 }
@@ -300,9 +349,20 @@ func (c *Configuration) Initialize(configDir string) error {
 		return err
 	}
 	c.configureKeyboardInteractiveAuth(serverConfig)
+	if err := c.configureKerberosAuth(configDir); err != nil {
+		return err
+	}
 	c.configureLoginBanner(serverConfig, configDir)
 	c.checkSSHCommands()
 	c.checkFolderPrefix()
+	hostKeys.addServer(serverConfig)
+
+	for idx := range c.Bindings {
+		if err := c.Bindings[idx].ClientPolicy.Validate(); err != nil {
+			logger.Error(logSender, "", "invalid client policy for binding %q: %v", c.Bindings[idx].GetAddress(), err)
+			return err
+		}
+	}
 
 	exitChannel := make(chan error, 1)
 	serviceStatus.Bindings = nil
@@ -333,7 +393,7 @@ func (c *Configuration) Initialize(configDir string) error {
 				listener = proxyListener
 			}
 
-			exitChannel <- c.serve(listener, serverConfig)
+			exitChannel <- c.serve(listener, serverConfig, binding)
 		}(binding)
 	}
 
@@ -344,7 +404,7 @@ func (c *Configuration) Initialize(configDir string) error {
 	return <-exitChannel
 }
 
-func (c *Configuration) serve(listener net.Listener, serverConfig *ssh.ServerConfig) error {
+func (c *Configuration) serve(listener net.Listener, serverConfig *ssh.ServerConfig, binding Binding) error {
 	logger.Info(logSender, "", "server listener registered, address: %v", listener.Addr().String())
 	var tempDelay time.Duration // how long to sleep on accept failure
 
@@ -370,7 +430,7 @@ func (c *Configuration) serve(listener net.Listener, serverConfig *ssh.ServerCon
 		}
 		tempDelay = 0
 
-		go c.AcceptInboundConnection(conn, serverConfig)
+		go c.acceptInboundConnection(conn, serverConfig, binding)
 	}
 }
 
@@ -469,6 +529,18 @@ func (c *Configuration) configureKeyboardInteractiveAuth(serverConfig *ssh.Serve
 	serviceStatus.Authentications = append(serviceStatus.Authentications, dataprovider.SSHLoginMethodKeyboardInteractive)
 }
 
+func (c *Configuration) configureKerberosAuth(configDir string) error {
+	if err := c.Kerberos.initialize(configDir); err != nil {
+		logger.WarnToConsole("unable to configure Kerberos authentication: %v", err)
+		logger.Warn(logSender, "", "unable to configure Kerberos authentication: %v", err)
+		return err
+	}
+	if c.Kerberos.isEnabled() {
+		serviceStatus.Authentications = append(serviceStatus.Authentications, dataprovider.SSHLoginMethodGSSAPI)
+	}
+	return nil
+}
+
 func canAcceptConnection(ip string) bool {
 	if common.IsBanned(ip) {
 		logger.Log(logger.LevelDebug, common.ProtocolSSH, "", "connection refused, ip %#v is banned", ip)
@@ -482,6 +554,9 @@ func canAcceptConnection(ip string) bool {
 	if err != nil {
 		return false
 	}
+	if err := common.ThrottleByLatency(common.ProtocolSSH, ip); err != nil {
+		return false
+	}
 	if err := common.Config.ExecutePostConnectHook(ip, common.ProtocolSSH); err != nil {
 		return false
 	}
@@ -489,7 +564,12 @@ func canAcceptConnection(ip string) bool {
 }
 
 // AcceptInboundConnection handles an inbound connection to the server instance and determines if the request should be served or not.
+// Server initiated keepalives are disabled for connections accepted this way
 func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.ServerConfig) {
+	c.acceptInboundConnection(conn, config, Binding{})
+}
+
+func (c *Configuration) acceptInboundConnection(conn net.Conn, config *ssh.ServerConfig, binding Binding) {
 	defer func() {
 		if r := recover(); r != nil {
 			logger.Error(logSender, "", "panic in AcceptInboundConnection: %#v stack trace: %v", r, string(debug.Stack()))
@@ -500,6 +580,11 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	common.Connections.AddClientConnection(ipAddr)
 	defer common.Connections.RemoveClientConnection(ipAddr)
 
+	if !common.IsCountryAllowed(ipAddr, binding.AllowedCountries, binding.DeniedCountries) {
+		logger.Debug(logSender, "", "connection refused, country not allowed for ip %q", ipAddr)
+		conn.Close()
+		return
+	}
 	if !canAcceptConnection(ipAddr) {
 		conn.Close()
 		return
@@ -508,10 +593,28 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	// we'll set a Deadline for handshake to complete, the default is 2 minutes as OpenSSH
 	conn.SetDeadline(time.Now().Add(handshakeTimeout)) //nolint:errcheck
 
-	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	remoteAddr := conn.RemoteAddr().String()
+	defer keyRestrictions.remove(remoteAddr)
+
+	connConfig, advertisedKeys := hostKeys.getConnectionConfig(config)
+	if binding.UniformAuthErrors {
+		connConfig = c.getUniformAuthConfig(connConfig)
+	}
+	if c.Kerberos.isEnabled() {
+		connConfig = c.getGSSAPIConfig(connConfig, ipAddr)
+	}
+	connConfig = newClientPolicyChecker(&binding).getConnectionConfig(connConfig)
+	traceCtx, span := tracing.Start(context.Background(), "SSH connection", tracing.SpanKindServer)
+	defer span.End()
+
+	span.SetAttribute("net.peer.ip", ipAddr)
+	_, loginSpan := tracing.Start(traceCtx, "SSH login", tracing.SpanKindInternal)
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, connConfig)
+	loginSpan.EndWithError(err)
 	if err != nil {
 		logger.Debug(logSender, "", "failed to accept an incoming connection: %v", err)
 		checkAuthError(ipAddr, err)
+		span.SetError(err)
 		return
 	}
 	// handshake completed so remove the deadline, we'll use IdleTimeout configuration from now on
@@ -526,6 +629,9 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 
 	loginType := sconn.Permissions.Extensions["sftpgo_login_method"]
 	connectionID := hex.EncodeToString(sconn.SessionID())
+	span.SetAttribute("enduser.id", user.Username)
+	span.SetAttribute("sftpgo.login_method", loginType)
+	sftpOnly := keyRestrictions.isSFTPOnly(remoteAddr)
 
 	if err = user.CheckFsRoot(connectionID); err != nil {
 		errClose := user.CloseFs()
@@ -545,10 +651,39 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 
 	defer common.Connections.RemoveSSHConnection(connectionID)
 
-	go ssh.DiscardRequests(reqs)
+	var prover *hostKeysProver
+	if len(advertisedKeys) > 0 {
+		// a host key rotation is in progress, inform the client about the accepted keys
+		prover = &hostKeysProver{sessionID: sconn.SessionID(), signers: advertisedKeys}
+		sconn.SendRequest(hostKeysRequest, false, getHostKeysPayload(advertisedKeys)) //nolint:errcheck
+	}
+	var forwarder *portForwarder
+	if !sftpOnly && !keyRestrictions.isPortForwardingDenied(remoteAddr) {
+		forwarder = newPortForwarder(sconn, sshConnection, user, connectionID, conn.LocalAddr().String(), remoteAddr)
+	}
+	if forwarder != nil {
+		defer forwarder.close()
+	}
+	go handleGlobalRequests(reqs, connectionID, prover, forwarder)
+	if interval := binding.getKeepaliveInterval(); interval > 0 {
+		checker := newKeepaliveChecker(sconn, sshConnection, interval, binding.getKeepaliveMaxCount())
+		defer checker.stop()
+
+		go checker.run()
+	}
 
 	channelCounter := int64(0)
 	for newChannel := range chans {
+		if newChannel.ChannelType() == directTCPIPChannel {
+			if !forwarder.canForwardLocal() {
+				logger.Log(logger.LevelDebug, common.ProtocolSSH, connectionID, "local port forwarding is not allowed")
+				newChannel.Reject(ssh.Prohibited, "port forwarding is not allowed") //nolint:errcheck
+				continue
+			}
+			sshConnection.UpdateLastActivity()
+			go forwarder.handleDirectTCPIP(newChannel)
+			continue
+		}
 		// If its not a session channel we just move on because its not something we
 		// know how to handle at this point.
 		if newChannel.ChannelType() != "session" {
@@ -586,9 +721,14 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 							channel:       channel,
 							folderPrefix:  c.FolderPrefix,
 						}
+						connection.SetTraceContext(traceCtx)
 						go c.handleSftpConnection(channel, connection)
 					}
 				case "exec":
+					if sftpOnly {
+						logger.Info(logSender, connID, "SSH commands are not allowed, the public key is restricted to SFTP")
+						break
+					}
 					// protocol will be set later inside processSSHCommand it could be SSH or SCP
 					connection := Connection{
 						BaseConnection: common.NewBaseConnection(connID, "sshd_exec", conn.LocalAddr().String(),
@@ -599,6 +739,7 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 						channel:       channel,
 						folderPrefix:  c.FolderPrefix,
 					}
+					connection.SetTraceContext(traceCtx)
 					ok = processSSHCommand(req.Payload, &connection, c.EnabledSSHCommands)
 				}
 				if req.WantReply {
@@ -623,7 +764,9 @@ func (c *Configuration) handleSftpConnection(channel ssh.Channel, connection *Co
 	defer common.Connections.Remove(connection.GetID())
 
 	// Create the server instance for the channel using the handler we created above.
-	server := sftp.NewRequestServer(channel, c.createHandlers(connection), sftp.WithRSAllocator(),
+	handlers, extHandler := c.createHandlers(connection)
+	serverChannel := c.getServerChannel(channel, connection, extHandler, connection.User.Filters.StartDirectory)
+	server := sftp.NewRequestServer(serverChannel, handlers, sftp.WithRSAllocator(),
 		sftp.WithStartDirectory(connection.User.Filters.StartDirectory))
 
 	defer server.Close()
@@ -636,7 +779,18 @@ func (c *Configuration) handleSftpConnection(channel ssh.Channel, connection *Co
 	}
 }
 
-func (c *Configuration) createHandlers(connection *Connection) sftp.Handlers {
+// getServerChannel returns the channel to use for the SFTP request server, the
+// SFTP packets are inspected only if some extension is enabled
+func (c *Configuration) getServerChannel(channel io.ReadWriteCloser, connection *Connection,
+	handler extensionsHandler, startDirectory string,
+) io.ReadWriteCloser {
+	if !c.CopyExtensions && !c.CustomMetadata {
+		return channel
+	}
+	return newExtensionsChannel(channel, connection, handler, startDirectory, c.CopyExtensions, c.CustomMetadata)
+}
+
+func (c *Configuration) createHandlers(connection *Connection) (sftp.Handlers, extensionsHandler) {
 	if c.FolderPrefix != "" {
 		prefixMiddleware := newPrefixMiddleware(c.FolderPrefix, connection)
 
@@ -645,7 +799,7 @@ func (c *Configuration) createHandlers(connection *Connection) sftp.Handlers {
 			FilePut:  prefixMiddleware,
 			FileCmd:  prefixMiddleware,
 			FileList: prefixMiddleware,
-		}
+		}, prefixMiddleware
 	}
 
 	return sftp.Handlers{
@@ -653,7 +807,7 @@ func (c *Configuration) createHandlers(connection *Connection) sftp.Handlers {
 		FilePut:  connection,
 		FileCmd:  connection,
 		FileList: connection,
-	}
+	}, connection
 }
 
 func checkAuthError(ip string, err error) {
@@ -662,7 +816,8 @@ func checkAuthError(ip string, err error) {
 		for _, err := range authErrors.Errors {
 			if err != nil {
 				// these checks should be improved, we should check for error type and not error strings
-				if strings.Contains(err.Error(), "public key credentials") {
+				if strings.Contains(err.Error(), "public key credentials") &&
+					!strings.Contains(err.Error(), dataprovider.ErrProviderUnavailable.Error()) {
 					event := common.HostEventLoginFailed
 					if strings.Contains(err.Error(), "not found") {
 						event = common.HostEventUserNotFound
@@ -673,7 +828,7 @@ func checkAuthError(ip string, err error) {
 			}
 		}
 	} else {
-		logger.ConnectionFailedLog("", ip, dataprovider.LoginMethodNoAuthTryed, common.ProtocolSSH, err.Error())
+		logger.ConnectionFailedLog("", ip, common.GetCountryForIP(ip), dataprovider.LoginMethodNoAuthTryed, common.ProtocolSSH, err.Error())
 		metric.AddNoAuthTryed()
 		common.AddDefenderEvent(ip, common.HostEventNoLoginTried)
 		dataprovider.ExecutePostLoginHook(&dataprovider.User{}, dataprovider.LoginMethodNoAuthTryed, ip, common.ProtocolSSH, err)
@@ -718,6 +873,9 @@ func loginUser(user *dataprovider.User, loginMethod, publicKey string, conn ssh.
 			user.Username, remoteAddr)
 		return nil, fmt.Errorf("login for user %#v is not allowed from this address: %v", user.Username, remoteAddr)
 	}
+	if err := checkUserClientVersion(user, conn, connectionID); err != nil {
+		return nil, err
+	}
 
 	json, err := json.Marshal(user)
 	if err != nil {
@@ -852,6 +1010,7 @@ func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh
 		return err
 	}
 	serviceStatus.HostKeys = nil
+	var configKeys []configHostKey
 	for _, hostKey := range c.HostKeys {
 		hostKey = strings.TrimSpace(hostKey)
 		if !util.IsFileInputValid(hostKey) {
@@ -881,17 +1040,22 @@ func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh
 		logger.Info(logSender, "", "Host key %#v loaded, type %#v, fingerprint %#v", hostKey,
 			private.PublicKey().Type(), k.Fingerprint)
 
-		// Add private key to the server configuration.
-		serverConfig.AddHostKey(private)
+		// The private key will be added to the server configuration when the server starts.
+		configKey := configHostKey{
+			path:   hostKey,
+			signer: private,
+		}
 		for _, cert := range hostCertificates {
 			signer, err := ssh.NewCertSigner(cert, private)
 			if err == nil {
-				serverConfig.AddHostKey(signer)
+				configKey.certSigners = append(configKey.certSigners, signer)
 				logger.Info(logSender, "", "Host certificate loaded for host key %#v, fingerprint %#v",
 					hostKey, ssh.FingerprintSHA256(signer.PublicKey()))
 			}
 		}
+		configKeys = append(configKeys, configKey)
 	}
+	hostKeys.setConfigKeys(serverConfig, configKeys)
 	var fp []string
 	for idx := range serviceStatus.HostKeys {
 		h := &serviceStatus.HostKeys[idx]
@@ -934,6 +1098,11 @@ func (c *Configuration) loadHostCertificates(configDir string) ([]*ssh.Certifica
 }
 
 func (c *Configuration) initializeCertChecker(configDir string) error {
+	if err := c.UserCA.initialize(configDir); err != nil {
+		logger.Warn(logSender, "", "unable to initialize the user CA: %v", err)
+		logger.WarnToConsole("unable to initialize the user CA: %v", err)
+		return err
+	}
 	for _, keyPath := range c.TrustedUserCAKeys {
 		keyPath = strings.TrimSpace(keyPath)
 		if !util.IsFileInputValid(keyPath) {
@@ -963,6 +1132,9 @@ func (c *Configuration) initializeCertChecker(configDir string) error {
 			sourceAddressCriticalOption,
 		},
 		IsUserAuthority: func(k ssh.PublicKey) bool {
+			if userCA.isAuthority(k) {
+				return true
+			}
 			for _, key := range c.parsedUserCAKeys {
 				if bytes.Equal(k.Marshal(), key.Marshal()) {
 					return true
@@ -1032,6 +1204,10 @@ func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubK
 		if ok {
 			keyID = fmt.Sprintf("%s: ID: %s, serial: %v, CA %s %s", certFingerprint,
 				cert.KeyId, cert.Serial, cert.Type(), ssh.FingerprintSHA256(cert.SignatureKey))
+		} else if err = checkPublicKeyOptions(&user, pubKey, conn, ipAddr); err != nil {
+			user.Username = conn.User()
+			updateLoginMetrics(&user, ipAddr, method, err)
+			return nil, err
 		}
 		if user.IsPartialAuth(method) {
 			logger.Debug(logSender, connectionID, "user %#v authenticated with partial success", conn.User())
@@ -1091,11 +1267,31 @@ func (c *Configuration) validateKeyboardInteractiveCredentials(conn ssh.ConnMeta
 	return sshPerm, err
 }
 
+func (c *Configuration) validateGSSAPICredentials(conn ssh.ConnMetadata, srcName string) (*ssh.Permissions, error) {
+	var user dataprovider.User
+	var sshPerm *ssh.Permissions
+
+	method := dataprovider.SSHLoginMethodGSSAPI
+	ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	username, err := c.Kerberos.getUsername(srcName)
+	if err == nil && username != conn.User() {
+		err = fmt.Errorf("kerberos principal %q cannot login as user %q", srcName, conn.User())
+	}
+	if err == nil {
+		if user, err = dataprovider.CheckUserAfterGSSAPIAuth(username, ipAddr, common.ProtocolSSH); err == nil {
+			sshPerm, err = loginUser(&user, method, srcName, conn)
+		}
+	}
+	user.Username = conn.User()
+	updateLoginMetrics(&user, ipAddr, method, err)
+	return sshPerm, err
+}
+
 func updateLoginMetrics(user *dataprovider.User, ip, method string, err error) {
 	metric.AddLoginAttempt(method)
 	if err != nil {
-		logger.ConnectionFailedLog(user.Username, ip, method, common.ProtocolSSH, err.Error())
-		if method != dataprovider.SSHLoginMethodPublicKey {
+		logger.ConnectionFailedLog(user.Username, ip, common.GetCountryForIP(ip), method, common.ProtocolSSH, err.Error())
+		if method != dataprovider.SSHLoginMethodPublicKey && !errors.Is(err, dataprovider.ErrProviderUnavailable) {
 			// some clients try all available public keys for a user, we
 			// record failed login key auth only once for session if the
 			// authentication fails in checkAuthError
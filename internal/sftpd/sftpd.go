@@ -29,7 +29,8 @@ const (
 
 var (
 	supportedSSHCommands = []string{"scp", "md5sum", "sha1sum", "sha256sum", "sha384sum", "sha512sum", "cd", "pwd",
-		"git-receive-pack", "git-upload-pack", "git-upload-archive", "rsync", "sftpgo-copy", "sftpgo-remove"}
+		"git-receive-pack", "git-upload-pack", "git-upload-archive", "rsync", "sftpgo-copy", "sftpgo-remove",
+		"sftpgo-speedtest"}
 	defaultSSHCommands = []string{"md5sum", "sha1sum", "sha256sum", "cd", "pwd", "scp"}
 	sshHashCommands    = []string{"md5sum", "sha1sum", "sha256sum", "sha384sum", "sha512sum"}
 	systemCommands     = []string{"git-receive-pack", "git-upload-pack", "git-upload-archive", "rsync"}
@@ -71,7 +72,11 @@ func (s *ServiceStatus) GetSupportedAuthsAsString() string {
 
 // GetStatus returns the server status
 func GetStatus() ServiceStatus {
-	return serviceStatus
+	status := serviceStatus
+	if status.IsActive {
+		status.HostKeys = hostKeys.getUsedHostKeys()
+	}
+	return status
 }
 
 // GetDefaultSSHCommands returns the SSH commands enabled as default
@@ -256,6 +256,8 @@ func TestMain(m *testing.M) {
 	sftpdConf.LoginBannerFile = loginBannerFileName
 	// we need to test all supported ssh commands
 	sftpdConf.EnabledSSHCommands = []string{"*"}
+	sftpdConf.CopyExtensions = true
+	sftpdConf.CustomMetadata = true
 
 	keyIntAuthPath = filepath.Join(homeBasePath, "keyintauth.sh")
 	err = os.WriteFile(keyIntAuthPath, getKeyboardInteractiveScriptContent([]string{"1", "2"}, 0, false, 1), os.ModePerm)
@@ -310,8 +312,9 @@ func TestMain(m *testing.M) {
 	prefixedConf := sftpdConf
 	prefixedConf.Bindings = []sftpd.Binding{
 		{
-			Port:             2226,
-			ApplyProxyConfig: false,
+			Port:              2226,
+			ApplyProxyConfig:  false,
+			KeepaliveInterval: 1,
 		},
 	}
 	prefixedConf.PasswordAuthentication = true
@@ -2589,6 +2592,370 @@ func TestLoginWithIPFilters(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestLoginWithPublicKeyOptions(t *testing.T) {
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.PublicKeys = []string{`from="10.0.0.0/8,192.168.1.*" ` + testPubKey}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if !assert.Error(t, err, "login from an address not allowed by the key options must fail") {
+		client.Close()
+		conn.Close()
+	}
+	user.PublicKeys = []string{`from="!127.0.0.1,*" ` + testPubKey}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if !assert.Error(t, err, "login from a negated address must fail") {
+		client.Close()
+		conn.Close()
+	}
+	user.PublicKeys = []string{`expiry-time="20200101" ` + testPubKey}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if !assert.Error(t, err, "login with an expired key must fail") {
+		client.Close()
+		conn.Close()
+	}
+	user.PublicKeys = []string{`from="127.0.0.*",expiry-time="29991231235959Z",no-pty ` + testPubKey}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	_, err = runSSHCommand("md5sum", user, usePubKey)
+	assert.NoError(t, err)
+	// restrict the key to SFTP
+	user.PublicKeys = []string{`restrict,command="internal-sftp" ` + testPubKey}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	_, err = runSSHCommand("md5sum", user, usePubKey)
+	assert.Error(t, err, "SSH commands must fail for keys restricted to SFTP")
+	// the restriction must be applied for multi-step authentication too
+	user.Password = defaultPassword
+	user.Filters.DeniedLoginMethods = []string{
+		dataprovider.SSHLoginMethodKeyAndKeyboardInt,
+		dataprovider.SSHLoginMethodPublicKey,
+		dataprovider.LoginMethodPassword,
+		dataprovider.SSHLoginMethodKeyboardInteractive,
+	}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey([]byte(testPrivateKey))
+	assert.NoError(t, err)
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{
+		ssh.PublicKeys(signer),
+		ssh.Password(defaultPassword),
+	}, "")
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		session, err := conn.NewSession()
+		if assert.NoError(t, err) {
+			err = session.Run("md5sum")
+			assert.Error(t, err)
+			session.Close()
+		}
+		client.Close()
+		conn.Close()
+	}
+	// unsupported options are rejected
+	user.PublicKeys = []string{`command="/bin/sh" ` + testPubKey}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+	user.PublicKeys = []string{`permitopen="localhost:80" ` + testPubKey}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+	user.PublicKeys = []string{`expiry-time="2020" ` + testPubKey}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestSSHGlobalRequests(t *testing.T) {
+	usePubKey := false
+	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		ok, _, err := conn.SendRequest("ping@sftpgo.com", true, nil)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		ok, _, err = conn.SendRequest("keepalive@openssh.com", true, nil)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		ok, _, err = conn.SendRequest("unsupported@sftpgo.com", true, nil)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestServerKeepalive(t *testing.T) {
+	usePubKey := false
+	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	config := &ssh.ClientConfig{
+		User: user.Username,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return nil
+		},
+		Auth: []ssh.AuthMethod{ssh.Password(defaultPassword)},
+	}
+	// the binding on port 2226 sends a keepalive request each second
+	netConn, err := net.Dial("tcp", "127.0.0.1:2226")
+	assert.NoError(t, err)
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, "127.0.0.1:2226", config)
+	if assert.NoError(t, err) {
+		go func() {
+			for newChannel := range chans {
+				newChannel.Reject(ssh.Prohibited, "") //nolint:errcheck
+			}
+		}()
+		// reply to the first keepalive requests
+		for i := 0; i < 3; i++ {
+			select {
+			case req := <-reqs:
+				assert.Equal(t, "keepalive@openssh.com", req.Type)
+				assert.True(t, req.WantReply)
+				err = req.Reply(false, nil)
+				assert.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				assert.Fail(t, "keepalive request not received")
+			}
+		}
+		assert.Equal(t, int32(1), common.Connections.GetClientConnections())
+		// stop replying, the server must close the connection
+		go func() {
+			for range reqs { //nolint:revive
+			}
+		}()
+		done := make(chan error, 1)
+		go func() {
+			done <- sshConn.Wait()
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			assert.Fail(t, "dead connection not closed")
+			sshConn.Close()
+		}
+		assert.Eventually(t, func() bool {
+			return common.Connections.GetClientConnections() == 0
+		}, 1*time.Second, 50*time.Millisecond)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestHostKeysRotation(t *testing.T) {
+	usePubKey := false
+	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+
+	keys, err := sftpd.GetHostKeys()
+	assert.NoError(t, err)
+	var configED25519Key sftpd.HostKeyInfo
+	for _, k := range keys {
+		assert.Equal(t, sftpd.HostKeySourceConfig, k.Source)
+		assert.Equal(t, sftpd.HostKeyStatusActive, k.Status)
+		if k.Type == ssh.KeyAlgoED25519 {
+			configED25519Key = k
+		}
+	}
+	assert.NotEmpty(t, configED25519Key.Fingerprint)
+	err = sftpd.RetireHostKey(configED25519Key.ID, 0)
+	assert.Error(t, err)
+	err = sftpd.RetireHostKey("missing", 0)
+	assert.Error(t, err)
+	_, err = sftpd.GenerateHostKey("dsa", 0)
+	assert.Error(t, err)
+	_, err = sftpd.GenerateHostKey("ed25519", -1)
+	assert.Error(t, err)
+	_, err = sftpd.AddHostKey("invalid key", 0)
+	assert.Error(t, err)
+
+	newKey, err := sftpd.GenerateHostKey("ed25519", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, sftpd.HostKeySourceRuntime, newKey.Source)
+	assert.Equal(t, sftpd.HostKeyStatusActive, newKey.Status)
+	fp, _, err := getHostKeyAdvertisement(user)
+	assert.NoError(t, err)
+	assert.Equal(t, newKey.Fingerprint, fp)
+	// add a pending key, the active key must be still used in the handshake
+	pendingKey, err := sftpd.GenerateHostKey("ed25519", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, sftpd.HostKeyStatusPending, pendingKey.Status)
+	fp, advertised, err := getHostKeyAdvertisement(user)
+	assert.NoError(t, err)
+	assert.Equal(t, newKey.Fingerprint, fp)
+	assert.Contains(t, advertised, newKey.Fingerprint)
+	assert.Contains(t, advertised, pendingKey.Fingerprint)
+	assert.NotContains(t, advertised, configED25519Key.Fingerprint)
+
+	keys, err = sftpd.GetHostKeys()
+	assert.NoError(t, err)
+	for _, k := range keys {
+		switch k.ID {
+		case configED25519Key.ID:
+			assert.Equal(t, sftpd.HostKeyStatusSuperseded, k.Status)
+		case newKey.ID:
+			assert.Equal(t, sftpd.HostKeyStatusRetiring, k.Status)
+			assert.Equal(t, pendingKey.ActivateAt, k.RetireAt)
+		case pendingKey.ID:
+			assert.Equal(t, sftpd.HostKeyStatusPending, k.Status)
+		}
+	}
+	status := sftpd.GetStatus()
+	for _, k := range status.HostKeys {
+		assert.NotEqual(t, configED25519Key.Fingerprint, k.Fingerprint)
+		assert.NotEqual(t, pendingKey.Fingerprint, k.Fingerprint)
+	}
+	// add a private key
+	importedKey, err := sftpd.AddHostKey(testPrivateKey, 10)
+	if assert.NoError(t, err) {
+		_, err = sftpd.AddHostKey(testPrivateKey, 0)
+		assert.Error(t, err)
+		err = sftpd.RetireHostKey(importedKey.ID, 0)
+		assert.NoError(t, err)
+	}
+
+	err = sftpd.RetireHostKey(pendingKey.ID, 0)
+	assert.NoError(t, err)
+	err = sftpd.RetireHostKey(newKey.ID, 0)
+	assert.NoError(t, err)
+	err = sftpd.RetireHostKey(newKey.ID, 0)
+	assert.Error(t, err)
+	fp, advertised, err = getHostKeyAdvertisement(user)
+	assert.NoError(t, err)
+	assert.Equal(t, configED25519Key.Fingerprint, fp)
+	assert.Len(t, advertised, 0)
+	keys, err = sftpd.GetHostKeys()
+	assert.NoError(t, err)
+	for _, k := range keys {
+		assert.Equal(t, sftpd.HostKeySourceConfig, k.Source)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+// getHostKeyAdvertisement returns the fingerprint of the ed25519 host key used in the
+// handshake and the fingerprints of the keys advertised using the hostkeys extension.
+// The advertised keys are verified using the hostkeys-prove extension
+func getHostKeyAdvertisement(user dataprovider.User) (string, []string, error) {
+	var handshakeFp string
+	config := &ssh.ClientConfig{
+		User: user.Username,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			handshakeFp = ssh.FingerprintSHA256(key)
+			return nil
+		},
+		HostKeyAlgorithms: []string{ssh.KeyAlgoED25519},
+		Auth:              []ssh.AuthMethod{ssh.Password(defaultPassword)},
+	}
+	netConn, err := net.Dial("tcp", sftpServerAddr)
+	if err != nil {
+		return "", nil, err
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, sftpServerAddr, config)
+	if err != nil {
+		return "", nil, err
+	}
+	defer sshConn.Close()
+
+	go func() {
+		for newChannel := range chans {
+			newChannel.Reject(ssh.Prohibited, "") //nolint:errcheck
+		}
+	}()
+	var payload []byte
+	select {
+	case req := <-reqs:
+		if req.Type != "hostkeys-00@openssh.com" || req.WantReply {
+			return "", nil, fmt.Errorf("unexpected request %q", req.Type)
+		}
+		payload = req.Payload
+	case <-time.After(500 * time.Millisecond):
+		return handshakeFp, nil, nil
+	}
+	var keys []ssh.PublicKey
+	var advertised []string
+	var provePayload []byte
+	for len(payload) > 0 {
+		var blob struct {
+			Key  []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(payload, &blob); err != nil {
+			return "", nil, err
+		}
+		key, err := ssh.ParsePublicKey(blob.Key)
+		if err != nil {
+			return "", nil, err
+		}
+		keys = append(keys, key)
+		advertised = append(advertised, ssh.FingerprintSHA256(key))
+		provePayload = append(provePayload, ssh.Marshal(struct{ Key []byte }{blob.Key})...)
+		payload = blob.Rest
+	}
+	ok, response, err := sshConn.SendRequest("hostkeys-prove-00@openssh.com", true, provePayload)
+	if err != nil {
+		return "", nil, err
+	}
+	if !ok {
+		return "", nil, errors.New("unable to prove the advertised host keys")
+	}
+	for _, key := range keys {
+		var sigBlob struct {
+			Sig  []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(response, &sigBlob); err != nil {
+			return "", nil, err
+		}
+		var sig ssh.Signature
+		if err := ssh.Unmarshal(sigBlob.Sig, &sig); err != nil {
+			return "", nil, err
+		}
+		data := ssh.Marshal(struct {
+			Name      string
+			SessionID []byte
+			Key       []byte
+		}{"hostkeys-prove-00@openssh.com", sshConn.SessionID(), key.Marshal()})
+		if err := key.Verify(data, &sig); err != nil {
+			return "", nil, fmt.Errorf("invalid signature for host key %q: %w", ssh.FingerprintSHA256(key), err)
+		}
+		response = sigBlob.Rest
+	}
+	return handshakeFp, advertised, nil
+}
+
 func TestLoginEmptyPassword(t *testing.T) {
 	usePubKey := false
 	u := getTestUser(usePubKey)
@@ -2616,11 +2983,11 @@ func TestLoginAnonymousUser(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, user.Filters.IsAnonymous)
 	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermDownload}, user.Permissions["/"])
-	assert.Equal(t, []string{common.ProtocolSSH, common.ProtocolHTTP}, user.Filters.DeniedProtocols)
+	assert.Equal(t, []string{common.ProtocolSSH, common.ProtocolHTTP, common.ProtocolS3}, user.Filters.DeniedProtocols)
 	assert.Equal(t, []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
 		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndPassword,
 		dataprovider.SSHLoginMethodKeyAndKeyboardInt, dataprovider.LoginMethodTLSCertificate,
-		dataprovider.LoginMethodTLSCertificateAndPwd}, user.Filters.DeniedLoginMethods)
+		dataprovider.LoginMethodTLSCertificateAndPwd, dataprovider.SSHLoginMethodGSSAPI}, user.Filters.DeniedLoginMethods)
 	_, _, err = getSftpClient(user, usePubKey)
 	assert.Error(t, err)
 
@@ -4087,11 +4454,11 @@ func TestExternalAuthReturningAnonymousUser(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, user.Filters.IsAnonymous)
 	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermDownload}, user.Permissions["/"])
-	assert.Equal(t, []string{common.ProtocolSSH, common.ProtocolHTTP}, user.Filters.DeniedProtocols)
+	assert.Equal(t, []string{common.ProtocolSSH, common.ProtocolHTTP, common.ProtocolS3}, user.Filters.DeniedProtocols)
 	assert.Equal(t, []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
 		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndPassword,
 		dataprovider.SSHLoginMethodKeyAndKeyboardInt, dataprovider.LoginMethodTLSCertificate,
-		dataprovider.LoginMethodTLSCertificateAndPwd}, user.Filters.DeniedLoginMethods)
+		dataprovider.LoginMethodTLSCertificateAndPwd, dataprovider.SSHLoginMethodGSSAPI}, user.Filters.DeniedLoginMethods)
 
 	// test again, the user now exists
 	_, _, err = getSftpClient(u, usePubKey)
@@ -4825,6 +5192,48 @@ func TestUploadMaxSize(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestUploadSizeLimits(t *testing.T) {
+	testFileSize := int64(65535)
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Filters.MaxUploadFileSize = testFileSize - 1
+	u.Filters.UploadSizeLimits = []dataprovider.UploadSizeLimit{
+		{
+			Path:       "/",
+			Extensions: []string{".mp4"},
+			MaxSize:    testFileSize,
+		},
+		{
+			Path:    "/sub",
+			MaxSize: 0,
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.Error(t, err)
+		err = sftpUploadFile(testFilePath, "file.MP4", testFileSize, client)
+		assert.NoError(t, err)
+		err = client.Mkdir("sub")
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, path.Join("sub", testFileName), testFileSize, client)
+		assert.NoError(t, err)
+	}
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestBandwidthAndConnections(t *testing.T) {
 	usePubKey := false
 	testFileSize := int64(524288)
@@ -5337,40 +5746,196 @@ func TestSFTPLoopVirtualFolders(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestNestedVirtualFolders(t *testing.T) {
-	usePubKey := true
-	baseUser, resp, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
-	assert.NoError(t, err, string(resp))
-	u := getTestSFTPUser(usePubKey)
-	u.QuotaFiles = 1000
-	mappedPathCrypt := filepath.Join(os.TempDir(), "crypt")
-	folderNameCrypt := filepath.Base(mappedPathCrypt)
-	vdirCryptPath := "/vdir/crypt"
-	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
-		BaseVirtualFolder: vfs.BaseVirtualFolder{
-			Name: folderNameCrypt,
-			FsConfig: vfs.Filesystem{
-				Provider: sdk.CryptedFilesystemProvider,
-				CryptConfig: vfs.CryptFsConfig{
-					Passphrase: kms.NewPlainSecret(defaultPassword),
+func TestSFTPUserCredentialsVirtualFolder(t *testing.T) {
+	usePubKey := false
+	folderName := "sftpusercreds"
+	remoteUser1 := getTestUser(usePubKey)
+	remoteUser1.Username += "_remote1"
+	remoteUser1.HomeDir += "_remote1"
+	remoteUser2 := getTestUser(usePubKey)
+	remoteUser2.Username += "_remote2"
+	remoteUser2.HomeDir += "_remote2"
+	remoteUser1, _, err := httpdtest.AddUser(remoteUser1, http.StatusCreated)
+	assert.NoError(t, err)
+	remoteUser2, _, err = httpdtest.AddUser(remoteUser2, http.StatusCreated)
+	assert.NoError(t, err)
+
+	folder := vfs.BaseVirtualFolder{
+		Name: folderName,
+		FsConfig: vfs.Filesystem{
+			Provider: sdk.SFTPFilesystemProvider,
+			SFTPConfig: vfs.SFTPFsConfig{
+				BaseSFTPFsConfig: sdk.BaseSFTPFsConfig{
+					Endpoint: sftpServerAddr,
 				},
+				UserCredentials: true,
 			},
-			MappedPath: mappedPathCrypt,
 		},
-		VirtualPath: vdirCryptPath,
-		QuotaFiles:  100,
+	}
+	_, _, err = httpdtest.AddFolder(folder, http.StatusCreated)
+	assert.NoError(t, err)
+
+	u1 := getTestUser(usePubKey)
+	u1.Username += "1"
+	u1.HomeDir += "1"
+	u1.VirtualFolders = append(u1.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: folder,
+		VirtualPath:       "/vdir",
 	})
-	mappedPath := filepath.Join(os.TempDir(), "local")
-	folderName := filepath.Base(mappedPath)
-	vdirPath := "/vdir/local"
-	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
-		BaseVirtualFolder: vfs.BaseVirtualFolder{
-			Name:       folderName,
-			MappedPath: mappedPath,
+	u2 := u1
+	u2.Username = defaultUsername + "2"
+	u2.HomeDir = filepath.Join(homeBasePath, u2.Username)
+	u3 := u1
+	u3.Username = defaultUsername + "3"
+	u3.HomeDir = filepath.Join(homeBasePath, u3.Username)
+	u1.Filters.SFTPCredentials = []dataprovider.SFTPRemoteCredentials{
+		{
+			FolderName: folderName,
+			Username:   remoteUser1.Username,
+			Password:   kms.NewPlainSecret(defaultPassword),
 		},
-		VirtualPath: vdirPath,
-		QuotaFiles:  -1,
-		QuotaSize:   -1,
+	}
+	u2.Filters.SFTPCredentials = []dataprovider.SFTPRemoteCredentials{
+		{
+			FolderName: folderName,
+			Username:   remoteUser2.Username,
+			Password:   kms.NewPlainSecret(defaultPassword),
+		},
+	}
+	user1, resp, err := httpdtest.AddUser(u1, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	user2, resp, err := httpdtest.AddUser(u2, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	user3, resp, err := httpdtest.AddUser(u3, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	if assert.Len(t, user1.Filters.SFTPCredentials, 1) {
+		assert.Equal(t, sdkkms.SecretStatusSecretBox, user1.Filters.SFTPCredentials[0].Password.GetStatus())
+		assert.NotEmpty(t, user1.Filters.SFTPCredentials[0].Password.GetPayload())
+	}
+
+	testFileSize := int64(65535)
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+	for _, user := range []dataprovider.User{user1, user2} {
+		conn, client, err := getSftpClient(user, usePubKey)
+		if assert.NoError(t, err) {
+			err = sftpUploadFile(testFilePath, path.Join("/vdir", user.Username), testFileSize, client)
+			assert.NoError(t, err)
+			client.Close()
+			conn.Close()
+		}
+	}
+	assert.FileExists(t, filepath.Join(remoteUser1.GetHomeDir(), user1.Username))
+	assert.NoFileExists(t, filepath.Join(remoteUser1.GetHomeDir(), user2.Username))
+	assert.FileExists(t, filepath.Join(remoteUser2.GetHomeDir(), user2.Username))
+	assert.NoFileExists(t, filepath.Join(remoteUser2.GetHomeDir(), user1.Username))
+	// user3 has no credentials for the folder, login works but /vdir is not accessible
+	conn, client, err := getSftpClient(user3, usePubKey)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		_, err = client.ReadDir("/vdir")
+		assert.Error(t, err)
+		client.Close()
+		conn.Close()
+	}
+	// the password is preserved if a redacted secret is sent back
+	user1.Filters.SFTPCredentials[0].Password.Hide()
+	user1, _, err = httpdtest.UpdateUser(user1, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user1, usePubKey)
+	if assert.NoError(t, err) {
+		_, err = client.Stat(path.Join("/vdir", user1.Username))
+		assert.NoError(t, err)
+		client.Close()
+		conn.Close()
+	}
+	// invalid credentials
+	u3.Filters.SFTPCredentials = []dataprovider.SFTPRemoteCredentials{
+		{
+			Username: remoteUser1.Username,
+			Password: kms.NewPlainSecret(defaultPassword),
+		},
+	}
+	_, _, err = httpdtest.UpdateUser(u3, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+	u3.Filters.SFTPCredentials = []dataprovider.SFTPRemoteCredentials{
+		{
+			FolderName: folderName,
+			Username:   remoteUser1.Username,
+		},
+	}
+	_, _, err = httpdtest.UpdateUser(u3, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+	u3.Filters.SFTPCredentials = []dataprovider.SFTPRemoteCredentials{
+		{
+			FolderName: folderName,
+			Password:   kms.NewPlainSecret(defaultPassword),
+		},
+		{
+			FolderName: folderName,
+			Password:   kms.NewPlainSecret(defaultPassword),
+		},
+	}
+	_, _, err = httpdtest.UpdateUser(u3, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+	u3.Filters.SFTPCredentials = nil
+	u3.FsConfig.Provider = sdk.SFTPFilesystemProvider
+	u3.FsConfig.SFTPConfig = vfs.SFTPFsConfig{
+		BaseSFTPFsConfig: sdk.BaseSFTPFsConfig{
+			Endpoint: sftpServerAddr,
+		},
+		UserCredentials: true,
+	}
+	_, _, err = httpdtest.UpdateUser(u3, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+	for _, user := range []dataprovider.User{user1, user2, user3, remoteUser1, remoteUser2} {
+		_, err = httpdtest.RemoveUser(user, http.StatusOK)
+		assert.NoError(t, err)
+		err = os.RemoveAll(user.GetHomeDir())
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName}, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestNestedVirtualFolders(t *testing.T) {
+	usePubKey := true
+	baseUser, resp, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	u := getTestSFTPUser(usePubKey)
+	u.QuotaFiles = 1000
+	mappedPathCrypt := filepath.Join(os.TempDir(), "crypt")
+	folderNameCrypt := filepath.Base(mappedPathCrypt)
+	vdirCryptPath := "/vdir/crypt"
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name: folderNameCrypt,
+			FsConfig: vfs.Filesystem{
+				Provider: sdk.CryptedFilesystemProvider,
+				CryptConfig: vfs.CryptFsConfig{
+					Passphrase: kms.NewPlainSecret(defaultPassword),
+				},
+			},
+			MappedPath: mappedPathCrypt,
+		},
+		VirtualPath: vdirCryptPath,
+		QuotaFiles:  100,
+	})
+	mappedPath := filepath.Join(os.TempDir(), "local")
+	folderName := filepath.Base(mappedPath)
+	vdirPath := "/vdir/local"
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name:       folderName,
+			MappedPath: mappedPath,
+		},
+		VirtualPath: vdirPath,
+		QuotaFiles:  -1,
+		QuotaSize:   -1,
 	})
 	mappedPathNested := filepath.Join(os.TempDir(), "nested")
 	folderNameNested := filepath.Base(mappedPathNested)
@@ -8239,10 +8804,11 @@ func TestUserAllowedLoginMethods(t *testing.T) {
 		dataprovider.SSHLoginMethodKeyboardInteractive,
 	}
 	allowedMethods = user.GetAllowedLoginMethods()
-	assert.Equal(t, 4, len(allowedMethods))
+	assert.Equal(t, 5, len(allowedMethods))
 
 	assert.True(t, util.Contains(allowedMethods, dataprovider.SSHLoginMethodKeyAndKeyboardInt))
 	assert.True(t, util.Contains(allowedMethods, dataprovider.SSHLoginMethodKeyAndPassword))
+	assert.True(t, util.Contains(allowedMethods, dataprovider.SSHLoginMethodGSSAPI))
 }
 
 func TestUserPartialAuth(t *testing.T) {
@@ -8438,6 +9004,379 @@ func TestGetVirtualFolderForPath(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSFTPCopyExtensions(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.QuotaFiles = 100
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	testFileSize := int64(131072)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = writeSFTPFile(testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		rawClient, err := newRawSFTPClient(conn)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer rawClient.close()
+
+		assert.Equal(t, "1", rawClient.extensions["copy-data"])
+		assert.Equal(t, "1", rawClient.extensions["copy-file"])
+		// copy-file
+		code, _, err := rawClient.extended("copy-file", testFileName, "copy1", false)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), code)
+		info, err := client.Stat("copy1")
+		if assert.NoError(t, err) {
+			assert.Equal(t, testFileSize, info.Size())
+		}
+		code, msg, err := rawClient.extended("copy-file", testFileName, "/copy1", false)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(4), code)
+		assert.Contains(t, msg, "exists")
+		code, _, err = rawClient.extended("copy-file", testFileName, "/copy1", true)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), code)
+		code, _, err = rawClient.extended("copy-file", "missing", "copy2", true)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), code)
+		code, _, err = rawClient.extended("copy-file", testFileName, testFileName, true)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(8), code)
+		code, _, err = rawClient.extended("copy-file", "/", "copy2", true)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(8), code)
+		// copy-data
+		readHandle, err := rawClient.open(testFileName, 0x01)
+		assert.NoError(t, err)
+		writeHandle, err := rawClient.open("copy2", 0x02|0x08|0x10)
+		assert.NoError(t, err)
+		code, _, err = rawClient.extended("copy-data", readHandle, uint64(0), uint64(0), writeHandle, uint64(0))
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), code)
+		code, msg, err = rawClient.extended("copy-data", readHandle, uint64(0), uint64(0), readHandle, uint64(0))
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(4), code)
+		assert.Contains(t, msg, "overlap")
+		code, _, err = rawClient.extended("copy-data", "invalid handle", uint64(0), uint64(0), writeHandle, uint64(0))
+		assert.NoError(t, err)
+		assert.NotEqual(t, uint32(0), code)
+		assert.NoError(t, rawClient.closeHandle(writeHandle))
+		writeHandle, err = rawClient.open("copy3", 0x02|0x08|0x10)
+		assert.NoError(t, err)
+		code, _, err = rawClient.extended("copy-data", readHandle, uint64(100), uint64(40000), writeHandle, uint64(0))
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), code)
+		assert.NoError(t, rawClient.closeHandle(writeHandle))
+		assert.NoError(t, rawClient.closeHandle(readHandle))
+		// the regular SFTP requests still work after the copies
+		info, err = client.Stat("copy2")
+		if assert.NoError(t, err) {
+			assert.Equal(t, testFileSize, info.Size())
+		}
+		info, err = client.Stat("copy3")
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(40000), info.Size())
+		}
+		srcContent, err := os.ReadFile(filepath.Join(user.GetHomeDir(), testFileName))
+		assert.NoError(t, err)
+		dstContent, err := os.ReadFile(filepath.Join(user.GetHomeDir(), "copy2"))
+		assert.NoError(t, err)
+		assert.Equal(t, srcContent, dstContent)
+		dstContent, err = os.ReadFile(filepath.Join(user.GetHomeDir(), "copy3"))
+		assert.NoError(t, err)
+		assert.Equal(t, srcContent[100:40100], dstContent)
+
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, user.UsedQuotaFiles)
+		assert.Equal(t, 3*testFileSize+40000, user.UsedQuotaSize)
+		// quota must be enforced on the destination
+		user.QuotaSize = user.UsedQuotaSize + 1
+		user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+		assert.NoError(t, err)
+	}
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		rawClient, err := newRawSFTPClient(conn)
+		if assert.NoError(t, err) {
+			defer rawClient.close()
+
+			code, msg, err := rawClient.extended("copy-file", testFileName, "copy4", false)
+			assert.NoError(t, err)
+			assert.Equal(t, uint32(4), code)
+			assert.Contains(t, msg, common.ErrQuotaExceeded.Error())
+			_, err = client.Stat("copy4")
+			assert.ErrorIs(t, err, fs.ErrNotExist)
+			// overwriting a file with the same size is allowed
+			code, _, err = rawClient.extended("copy-file", testFileName, "copy2", true)
+			assert.NoError(t, err)
+			assert.Equal(t, uint32(0), code)
+		}
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestSFTPExtendedAttributes(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("this test requires extended attributes support")
+	}
+	usePubKey := false
+	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = writeSFTPFile(testFileName, 100, client)
+		assert.NoError(t, err)
+		rawClient, err := newRawSFTPClient(conn)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer rawClient.close()
+		// the copy extensions are advertised, the stat responses are not changed
+		// if no metadata are set
+		assert.Equal(t, "1", rawClient.extensions["copy-file"])
+		attrs, err := rawClient.stat(17, testFileName)
+		assert.NoError(t, err)
+		assert.Empty(t, attrs)
+
+		err = rawClient.sendPacket(9, rawClient.getID(), testFileName, uint32(0x80000000), uint32(2),
+			"Label", "value1", "doc-id", "value2")
+		assert.NoError(t, err)
+		code, _, err := rawClient.recvStatus()
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), code)
+
+		expected := map[string]string{"label": "value1", "doc-id": "value2"}
+		attrs, err = rawClient.stat(17, testFileName)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, attrs)
+		attrs, err = rawClient.stat(7, "/"+testFileName)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, attrs)
+		// other stat responses are not changed
+		info, err := client.Stat(testFileName)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(100), info.Size())
+		_, err = rawClient.stat(17, "missing")
+		assert.Error(t, err)
+		// empty values remove the metadata
+		err = rawClient.sendPacket(9, rawClient.getID(), testFileName, uint32(0x80000000), uint32(1),
+			"label", "")
+		assert.NoError(t, err)
+		code, _, err = rawClient.recvStatus()
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), code)
+		attrs, err = rawClient.stat(17, testFileName)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"doc-id": "value2"}, attrs)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestSSHPortForwarding(t *testing.T) {
+	echoListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer echoListener.Close()
+
+	go func() {
+		for {
+			c, err := echoListener.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				io.Copy(c, c) //nolint:errcheck
+			}(c)
+		}
+	}()
+
+	echoAddr := echoListener.Addr().String()
+	checkEcho := func(c net.Conn) {
+		defer c.Close()
+
+		data := []byte("port forwarding test")
+		_, err := c.Write(data)
+		assert.NoError(t, err)
+		buf := make([]byte, len(data))
+		_, err = io.ReadFull(c, buf)
+		assert.NoError(t, err)
+		assert.Equal(t, data, buf)
+	}
+
+	usePubKey := true
+	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		_, err = conn.Dial("tcp", echoAddr)
+		assert.Error(t, err)
+		_, err = conn.Listen("tcp", "127.0.0.1:0")
+		assert.Error(t, err)
+		client.Close()
+		conn.Close()
+	}
+
+	user.Filters.PortForwarding = &dataprovider.SSHPortForwarding{
+		AllowLocal:   true,
+		AllowRemote:  true,
+		PermitOpen:   []string{echoAddr, "127.0.0.1:1"},
+		PermitListen: []string{"127.0.0.1:*"},
+		Bandwidth:    1024,
+	}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	if assert.NotNil(t, user.Filters.PortForwarding) {
+		assert.Len(t, user.Filters.PortForwarding.PermitOpen, 2)
+	}
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		c, err := conn.Dial("tcp", echoAddr)
+		if assert.NoError(t, err) {
+			checkEcho(c)
+		}
+		_, err = conn.Dial("tcp", net.JoinHostPort("127.0.0.1", "2"))
+		assert.Error(t, err)
+		// the destination is allowed but nothing is listening
+		_, err = conn.Dial("tcp", net.JoinHostPort("127.0.0.1", "1"))
+		assert.Error(t, err)
+
+		_, err = conn.Listen("tcp", "0.0.0.0:0")
+		assert.Error(t, err)
+		listener, err := conn.Listen("tcp", "127.0.0.1:0")
+		if assert.NoError(t, err) {
+			go func() {
+				for {
+					c, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					go func(c net.Conn) {
+						defer c.Close()
+						io.Copy(c, c) //nolint:errcheck
+					}(c)
+				}
+			}()
+
+			listenAddr := listener.Addr().String()
+			c, err := net.Dial("tcp", listenAddr)
+			if assert.NoError(t, err) {
+				checkEcho(c)
+			}
+			err = listener.Close()
+			assert.NoError(t, err)
+			assert.Eventually(t, func() bool {
+				c, err := net.Dial("tcp", listenAddr)
+				if err == nil {
+					c.Close()
+				}
+				return err != nil
+			}, 2*time.Second, 100*time.Millisecond)
+		}
+	}
+	// port forwarding is not allowed for keys restricted to SFTP
+	user.PublicKeys = []string{"restrict " + testPubKey}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	restrictedConn, restrictedClient, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer restrictedConn.Close()
+		defer restrictedClient.Close()
+
+		_, err = restrictedConn.Dial("tcp", echoAddr)
+		assert.Error(t, err)
+		_, err = restrictedConn.Listen("tcp", "127.0.0.1:0")
+		assert.Error(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestUserClientPolicy(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Filters.ClientPolicy = &dataprovider.ClientPolicy{
+		Allowed: []string{" SSH-2.0-Go*", "SSH-2.0-Allowed?Client*"},
+		Denied:  []string{"*Go"},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	if assert.NotNil(t, user.Filters.ClientPolicy) {
+		assert.Len(t, user.Filters.ClientPolicy.Allowed, 2)
+		assert.Len(t, user.Filters.ClientPolicy.Denied, 1)
+	}
+	// the default Go client version is "SSH-2.0-Go"
+	_, _, err = getSftpClient(user, usePubKey)
+	assert.Error(t, err)
+
+	config := &ssh.ClientConfig{
+		User: user.Username,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return nil
+		},
+		Auth:          []ssh.AuthMethod{ssh.Password(defaultPassword)},
+		ClientVersion: "SSH-2.0-Allowed Client_1.2",
+	}
+	conn, err := ssh.Dial("tcp", sftpServerAddr, config)
+	if assert.NoError(t, err) {
+		client, err := sftp.NewClient(conn)
+		if assert.NoError(t, err) {
+			assert.NoError(t, checkBasicSFTP(client))
+			client.Close()
+		}
+		conn.Close()
+	}
+	config.ClientVersion = "SSH-2.0-OtherClient_1.2"
+	_, err = ssh.Dial("tcp", sftpServerAddr, config)
+	assert.Error(t, err)
+
+	deniedFound := false
+	for _, v := range common.GetClientVersions() {
+		if v.Protocol == common.ProtocolSSH && v.Version == "SSH-2.0-OtherClient_1.2" {
+			assert.GreaterOrEqual(t, v.Count, int64(1))
+			assert.GreaterOrEqual(t, v.Denied, int64(1))
+			deniedFound = true
+		}
+	}
+	assert.True(t, deniedFound)
+
+	user.Filters.ClientPolicy.Denied = []string{strings.Repeat("a", dataprovider.MaxClientVersionLength+1)}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestStatVFS(t *testing.T) {
 	usePubKey := false
 	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
@@ -8579,6 +9518,55 @@ func TestStatVFSCloudBackend(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSSHSpeedTest(t *testing.T) {
+	usePubKey := true
+	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	out, err := runSSHCommand("sftpgo-speedtest download 131073", user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.Len(t, out, 131073)
+	}
+	out, err = runSSHCommand("sftpgo-speedtest download", user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.Len(t, out, int(common.DefaultSpeedTestSize))
+	}
+	_, err = runSSHCommand("sftpgo-speedtest download a", user, usePubKey)
+	assert.Error(t, err)
+	_, err = runSSHCommand(fmt.Sprintf("sftpgo-speedtest download %d", common.MaxSpeedTestSize+1), user, usePubKey)
+	assert.Error(t, err)
+	_, err = runSSHCommand("sftpgo-speedtest", user, usePubKey)
+	assert.Error(t, err)
+	_, err = runSSHCommand("sftpgo-speedtest invalid", user, usePubKey)
+	assert.Error(t, err)
+	_, err = runSSHCommand("sftpgo-speedtest upload 100", user, usePubKey)
+	assert.Error(t, err)
+
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		session, err := conn.NewSession()
+		if assert.NoError(t, err) {
+			var stdout bytes.Buffer
+			session.Stdin = bytes.NewReader(make([]byte, 65537))
+			session.Stdout = &stdout
+			err = session.Run("sftpgo-speedtest upload")
+			assert.NoError(t, err)
+			assert.Contains(t, stdout.String(), "64.0 KiB transferred")
+		}
+	}
+	// no file is created
+	files, err := os.ReadDir(user.GetHomeDir())
+	assert.NoError(t, err)
+	assert.Len(t, files, 0)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestSSHCommands(t *testing.T) {
 	usePubKey := false
 	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
@@ -9873,17 +10861,14 @@ func TestSCPTransferQuotaLimits(t *testing.T) {
 	// error before starting the download
 	err = scpDownload(localDownloadPath, remoteDownPath, false, false)
 	assert.Error(t, err)
-	// error while upload is active
-	err = scpUpload(testFilePath, remoteUpPath, false, false)
-	assert.Error(t, err)
-	// error before starting the upload
+	// the declared size exceeds the remaining transfer quota, the upload is denied before transferring any data
 	err = scpUpload(testFilePath, remoteUpPath, false, false)
 	assert.Error(t, err)
 
 	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
 	assert.NoError(t, err)
 	assert.Greater(t, user.UsedDownloadDataTransfer, int64(1024*1024))
-	assert.Greater(t, user.UsedUploadDataTransfer, int64(1024*1024))
+	assert.Equal(t, testFileSize, user.UsedUploadDataTransfer)
 
 	err = os.Remove(testFilePath)
 	assert.NoError(t, err)
@@ -10744,6 +11729,214 @@ func getSftpClientWithAddr(user dataprovider.User, usePubKey bool, addr string)
 	return conn, sftpClient, err
 }
 
+// rawSFTPClient allows to send SFTP requests not supported by pkg/sftp
+type rawSFTPClient struct {
+	session    *ssh.Session
+	stdin      io.WriteCloser
+	stdout     io.Reader
+	nextID     uint32
+	extensions map[string]string
+}
+
+func newRawSFTPClient(conn *ssh.Client) (*rawSFTPClient, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		session.Close()
+		return nil, err
+	}
+	c := &rawSFTPClient{
+		session:    session,
+		stdin:      stdin,
+		stdout:     stdout,
+		extensions: make(map[string]string),
+	}
+	if err := c.sendPacket(1, uint32(3)); err != nil {
+		c.close()
+		return nil, err
+	}
+	pktType, data, err := c.recvPacket()
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	if pktType != 2 || len(data) < 4 {
+		c.close()
+		return nil, fmt.Errorf("unexpected version packet type %d", pktType)
+	}
+	data = data[4:]
+	for len(data) > 0 {
+		var name, value string
+		name, data = unmarshalRawSFTPString(data)
+		value, data = unmarshalRawSFTPString(data)
+		c.extensions[name] = value
+	}
+	return c, nil
+}
+
+func (c *rawSFTPClient) close() {
+	c.stdin.Close()
+	c.session.Close()
+}
+
+func (c *rawSFTPClient) sendPacket(pktType byte, fields ...any) error {
+	packet := []byte{0, 0, 0, 0, pktType}
+	for _, field := range fields {
+		switch v := field.(type) {
+		case uint32:
+			packet = binary.BigEndian.AppendUint32(packet, v)
+		case uint64:
+			packet = binary.BigEndian.AppendUint64(packet, v)
+		case string:
+			packet = binary.BigEndian.AppendUint32(packet, uint32(len(v)))
+			packet = append(packet, v...)
+		case bool:
+			if v {
+				packet = append(packet, 1)
+			} else {
+				packet = append(packet, 0)
+			}
+		default:
+			return fmt.Errorf("unsupported field type %T", field)
+		}
+	}
+	binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+	_, err := c.stdin.Write(packet)
+	return err
+}
+
+func (c *rawSFTPClient) recvPacket() (byte, []byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c.stdout, header); err != nil {
+		return 0, nil, err
+	}
+	packet := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := io.ReadFull(c.stdout, packet); err != nil {
+		return 0, nil, err
+	}
+	if len(packet) == 0 {
+		return 0, nil, errors.New("empty packet")
+	}
+	return packet[0], packet[1:], nil
+}
+
+func (c *rawSFTPClient) getID() uint32 {
+	c.nextID++
+	return c.nextID
+}
+
+// recvStatus returns the code and the message for an SSH_FXP_STATUS response
+func (c *rawSFTPClient) recvStatus() (uint32, string, error) {
+	pktType, data, err := c.recvPacket()
+	if err != nil {
+		return 0, "", err
+	}
+	if pktType != 101 || len(data) < 8 {
+		return 0, "", fmt.Errorf("unexpected packet type %d, status expected", pktType)
+	}
+	msg, _ := unmarshalRawSFTPString(data[8:])
+	return binary.BigEndian.Uint32(data[4:]), msg, nil
+}
+
+func (c *rawSFTPClient) extended(name string, fields ...any) (uint32, string, error) {
+	if err := c.sendPacket(200, append([]any{c.getID(), name}, fields...)...); err != nil {
+		return 0, "", err
+	}
+	return c.recvStatus()
+}
+
+func (c *rawSFTPClient) open(name string, pflags uint32) (string, error) {
+	if err := c.sendPacket(3, c.getID(), name, pflags, uint32(0)); err != nil {
+		return "", err
+	}
+	pktType, data, err := c.recvPacket()
+	if err != nil {
+		return "", err
+	}
+	if pktType != 102 || len(data) < 4 {
+		return "", fmt.Errorf("unexpected packet type %d, handle expected", pktType)
+	}
+	handle, _ := unmarshalRawSFTPString(data[4:])
+	return handle, nil
+}
+
+func (c *rawSFTPClient) closeHandle(handle string) error {
+	if err := c.sendPacket(4, c.getID(), handle); err != nil {
+		return err
+	}
+	code, msg, err := c.recvStatus()
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("close failed, status %d: %s", code, msg)
+	}
+	return nil
+}
+
+// stat sends a stat or lstat request and returns the extended attributes from
+// the SSH_FXP_ATTRS response
+func (c *rawSFTPClient) stat(pktType byte, name string) (map[string]string, error) {
+	if err := c.sendPacket(pktType, c.getID(), name); err != nil {
+		return nil, err
+	}
+	respType, data, err := c.recvPacket()
+	if err != nil {
+		return nil, err
+	}
+	if respType != 105 || len(data) < 8 {
+		return nil, fmt.Errorf("unexpected packet type %d, attrs expected", respType)
+	}
+	flags := binary.BigEndian.Uint32(data[4:])
+	data = data[8:]
+	// size, uid/gid, permissions, atime/mtime
+	for flag, size := range map[uint32]int{0x1: 8, 0x2: 8, 0x4: 4, 0x8: 8} {
+		if flags&flag != 0 {
+			if len(data) < size {
+				return nil, errors.New("short attrs packet")
+			}
+			data = data[size:]
+		}
+	}
+	result := make(map[string]string)
+	if flags&0x80000000 == 0 || len(data) < 4 {
+		return result, nil
+	}
+	count := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	for i := uint32(0); i < count; i++ {
+		var name, value string
+		name, data = unmarshalRawSFTPString(data)
+		value, data = unmarshalRawSFTPString(data)
+		result[name] = value
+	}
+	return result, nil
+}
+
+func unmarshalRawSFTPString(data []byte) (string, []byte) {
+	if len(data) < 4 {
+		return "", nil
+	}
+	length := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if uint32(len(data)) < length {
+		return "", nil
+	}
+	return string(data[:length]), data[length:]
+}
+
 func getSftpClient(user dataprovider.User, usePubKey bool) (*ssh.Client, *sftp.Client, error) {
 	return getSftpClientWithAddr(user, usePubKey, sftpServerAddr)
 }
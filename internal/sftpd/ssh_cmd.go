@@ -154,10 +154,58 @@ func (c *sshCommand) handle() (err error) {
 		return c.handleSFTPGoCopy()
 	} else if c.command == "sftpgo-remove" {
 		return c.handleSFTPGoRemove()
+	} else if c.command == "sftpgo-speedtest" {
+		return c.handleSFTPGoSpeedTest()
 	}
 	return
 }
 
+// handleSFTPGoSpeedTest streams generated data to the client or reads and discards
+// the data sent by the client, the storage backend is never used, so the client can
+// measure the network throughput
+func (c *sshCommand) handleSFTPGoSpeedTest() error {
+	if len(c.args) == 0 || len(c.args) > 2 {
+		return c.sendErrorResponse(errors.New("usage sftpgo-speedtest download [size] | upload"))
+	}
+	var result common.SpeedTestResult
+	var err error
+	switch c.args[0] {
+	case "download":
+		var size int64
+		if len(c.args) == 2 {
+			size, err = common.GetSpeedTestSize(c.args[1])
+		} else {
+			size, err = common.GetSpeedTestSize("")
+		}
+		if err != nil {
+			return c.sendErrorResponse(err)
+		}
+		result, err = common.WriteSpeedTestData(c.connection.channel, size)
+		if err != nil {
+			c.connection.Log(logger.LevelDebug, "download speed test failed, %s, error: %v", result.String(), err)
+			c.sendExitStatus(err)
+			return err
+		}
+		// the data are sent to stdout so the result is sent to stderr
+		c.connection.channel.(ssh.Channel).Stderr().Write([]byte(result.String() + "\n")) //nolint:errcheck
+	case "upload":
+		if len(c.args) != 1 {
+			return c.sendErrorResponse(errors.New("usage sftpgo-speedtest upload"))
+		}
+		result, err = common.DiscardSpeedTestData(c.connection.channel)
+		if err != nil {
+			return c.sendErrorResponse(err)
+		}
+		c.connection.channel.Write([]byte(result.String() + "\n")) //nolint:errcheck
+	default:
+		return c.sendErrorResponse(fmt.Errorf("unsupported speed test %q, usage sftpgo-speedtest download [size] | upload",
+			c.args[0]))
+	}
+	c.connection.Log(logger.LevelDebug, "%s speed test completed, %s", c.args[0], result.String())
+	c.sendExitStatus(nil)
+	return nil
+}
+
 func (c *sshCommand) handleSFTPGoCopy() error {
 	fsSrc, fsDst, sshSourcePath, sshDestPath, fsSourcePath, fsDestPath, err := c.getFsAndCopyPaths()
 	if err != nil {
@@ -284,7 +332,7 @@ func (c *sshCommand) handleSFTPGoRemove() error {
 func (c *sshCommand) updateQuota(sshDestPath string, filesNum int, filesSize int64) {
 	vfolder, err := c.connection.User.GetVirtualFolderForPath(sshDestPath)
 	if err == nil {
-		dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, filesNum, filesSize, false) //nolint:errcheck
+		dataprovider.UpdateVirtualFolderQuotaForUser(&vfolder, c.connection.User.Username, filesNum, filesSize) //nolint:errcheck
 		if vfolder.IsIncludedInUserQuota() {
 			dataprovider.UpdateUserQuota(&c.connection.User, filesNum, filesSize, false) //nolint:errcheck
 		}
@@ -346,12 +394,15 @@ func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 		return c.sendErrorResponse(errUnsupportedConfig)
 	}
 	diskQuota, transferQuota := c.connection.HasSpace(true, false, command.quotaCheckPath)
-	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() || !transferQuota.HasDownloadSpace() {
+	if c.command == "rsync" && isRsyncSender(c.args) {
+		// rsync only reads files in sender mode
+		if !transferQuota.HasDownloadSpace() {
+			return c.sendErrorResponse(common.ErrQuotaExceeded)
+		}
+	} else if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() || !transferQuota.HasDownloadSpace() {
 		return c.sendErrorResponse(common.ErrQuotaExceeded)
 	}
-	perms := []string{dataprovider.PermDownload, dataprovider.PermUpload, dataprovider.PermCreateDirs, dataprovider.PermListItems,
-		dataprovider.PermOverwrite, dataprovider.PermDelete}
-	if !c.connection.User.HasPerms(perms, sshDestPath) {
+	if !c.connection.User.HasPerms(c.getSystemCommandPerms(), sshDestPath) {
 		return c.sendErrorResponse(c.connection.GetPermissionDeniedError())
 	}
 
@@ -359,6 +410,10 @@ func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 	if err != nil {
 		return c.sendErrorResponse(err)
 	}
+	var initialState map[string]rsyncFileState
+	if c.isRsyncReceiver() {
+		initialState = c.getRsyncSnapshot(command.fs, command.fsPath)
+	}
 
 	stdin, stdout, stderr, err := command.GetSTDs()
 	if err != nil {
@@ -434,6 +489,9 @@ func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 	c.connection.Log(logger.LevelDebug, "command %#v finished for path %#v, initial files %v initial size %v "+
 		"current files %v current size %v size err: %v", c.connection.command, command.fsPath, initialFiles, initialSize,
 		numFiles, dirSize, errSize)
+	if initialState != nil {
+		c.notifyRsyncChanges(command.fs, command.fsPath, initialState)
+	}
 	return c.connection.GetFsError(command.fs, err)
 }
 
@@ -514,6 +572,10 @@ func (c *sshCommand) getSystemCommand() (systemCommand, error) {
 		return command, errUnsupportedConfig
 	}
 	if c.command == "rsync" {
+		if err := validateRsyncArgs(c.args); err != nil {
+			c.connection.Log(logger.LevelInfo, "rsync command not allowed: %v", err)
+			return command, errUnsupportedConfig
+		}
 		// we cannot avoid that rsync creates symlinks so if the user has the permission
 		// to create symlinks we add the option --safe-links to the received rsync command if
 		// it is not already set. This should prevent to create symlinks that point outside
@@ -755,6 +817,10 @@ func (c *sshCommand) sendExitStatus(err error) {
 		vTargetPath = vCmdPath
 		vCmdPath = c.getSourcePath()
 	}
+	if c.command == "sftpgo-speedtest" {
+		// speed tests have no path arguments
+		vCmdPath = ""
+	}
 	if err != nil {
 		status = uint32(1)
 		c.connection.Log(logger.LevelError, "command failed: %#v args: %v user: %v err: %v",
@@ -786,7 +852,7 @@ func (c *sshCommand) sendExitStatus(err error) {
 		if err == nil {
 			logger.CommandLog(sshCommandLogSender, cmdPath, targetPath, c.connection.User.Username, "", c.connection.ID,
 				common.ProtocolSSH, -1, -1, "", "", c.connection.command, -1, c.connection.GetLocalAddress(),
-				c.connection.GetRemoteAddress())
+				c.connection.GetRemoteAddress(), c.connection.GetImpersonator())
 		}
 	}
 }
@@ -50,7 +50,9 @@ func newSubsystemChannel(reader io.Reader, writer io.Writer) *subsystemChannel {
 }
 
 // ServeSubSystemConnection handles a connection as SSH subsystem
-func ServeSubSystemConnection(user *dataprovider.User, connectionID string, reader io.Reader, writer io.Writer) error {
+func ServeSubSystemConnection(user *dataprovider.User, connectionID string, reader io.Reader, writer io.Writer,
+	conf *Configuration,
+) error {
 	err := user.CheckFsRoot(connectionID)
 	if err != nil {
 		errClose := user.CloseFs()
@@ -75,7 +77,8 @@ func ServeSubSystemConnection(user *dataprovider.User, connectionID string, read
 
 	dataprovider.UpdateLastLogin(user)
 	sftp.SetSFTPExtensions(sftpExtensions...) //nolint:errcheck
-	server := sftp.NewRequestServer(connection.channel, sftp.Handlers{
+	serverChannel := conf.getServerChannel(connection.channel, connection, connection, "/")
+	server := sftp.NewRequestServer(serverChannel, sftp.Handlers{
 		FileGet:  connection,
 		FilePut:  connection,
 		FileCmd:  connection,
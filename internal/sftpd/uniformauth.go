@@ -0,0 +1,65 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// getUniformAuthConfig returns a copy of the specified config with the password and
+// keyboard interactive callbacks replaced so that an unknown user cannot be told
+// apart from an existing user with wrong credentials by looking at the time spent
+// or at the authentication prompts
+func (c *Configuration) getUniformAuthConfig(config *ssh.ServerConfig) *ssh.ServerConfig {
+	connConfig := *config
+	if config.PasswordCallback != nil {
+		connConfig.PasswordCallback = func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			sp, err := c.validatePasswordCredentials(conn, pass)
+			if err != nil {
+				if _, ok := err.(*util.RecordNotFoundError); ok {
+					dataprovider.SimulatePasswordCheck(string(pass))
+				}
+				return nil, &authenticationError{err: fmt.Sprintf("could not validate password credentials: %v", err)}
+			}
+
+			return sp, nil
+		}
+	}
+	if config.KeyboardInteractiveCallback != nil {
+		connConfig.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata,
+			client ssh.KeyboardInteractiveChallenge,
+		) (*ssh.Permissions, error) {
+			sp, err := c.validateKeyboardInteractiveCredentials(conn, client)
+			if err != nil {
+				if _, ok := err.(*util.RecordNotFoundError); ok {
+					// existing users are asked for a password, do the same for unknown users
+					answers, errChallenge := client("", "", []string{"Password: "}, []bool{false})
+					if errChallenge == nil && len(answers) == 1 {
+						dataprovider.SimulatePasswordCheck(answers[0])
+					}
+				}
+				return nil, &authenticationError{err: fmt.Sprintf("could not validate keyboard interactive credentials: %v", err)}
+			}
+
+			return sp, nil
+		}
+	}
+	return &connConfig
+}
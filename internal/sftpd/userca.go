@@ -0,0 +1,318 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	defaultUserCertTTL    = 60
+	defaultUserCertMaxTTL = 60 * 24
+	// issued certificates are valid starting from a few minutes in the past to
+	// tolerate small clock differences between SFTPGo and the SSH servers
+	userCertClockSkew = 5 * time.Minute
+)
+
+var (
+	userCA                = &userCertificateAuthority{}
+	errUserCANotAvailable = util.NewValidationError("the SSH certificate authority is not enabled")
+)
+
+// UserCAPrincipalMapping adds the specified principals to the certificates
+// issued for the members of a group
+type UserCAPrincipalMapping struct {
+	// Group name
+	Group string `json:"group" mapstructure:"group"`
+	// Principals to add to the certificates issued for the group members
+	Principals []string `json:"principals" mapstructure:"principals"`
+}
+
+// UserCAConfig defines the configuration for the built-in certificate authority
+// used to issue short-lived SSH user certificates
+type UserCAConfig struct {
+	// Path to the private key of the certificate authority, absolute or relative to
+	// the configuration directory. If the file does not exist an Ed25519 key is
+	// generated and its public key is saved in the same directory adding the ".pub"
+	// suffix. Empty means disabled
+	PrivateKey string `json:"private_key" mapstructure:"private_key"`
+	// Default validity, in minutes, for the issued certificates. 0 means 60 minutes
+	DefaultTTL int `json:"default_ttl" mapstructure:"default_ttl"`
+	// Maximum validity, in minutes, that can be requested. 0 means 1440 minutes
+	MaxTTL int `json:"max_ttl" mapstructure:"max_ttl"`
+	// Additional principals for the members of the defined groups. The username
+	// is always included in the principals
+	PrincipalMappings []UserCAPrincipalMapping `json:"principal_mappings" mapstructure:"principal_mappings"`
+}
+
+func (c *UserCAConfig) validate() error {
+	if c.DefaultTTL < 0 || c.MaxTTL < 0 {
+		return fmt.Errorf("user CA: invalid validity, default: %d, max: %d", c.DefaultTTL, c.MaxTTL)
+	}
+	if c.DefaultTTL == 0 {
+		c.DefaultTTL = defaultUserCertTTL
+	}
+	if c.MaxTTL == 0 {
+		c.MaxTTL = defaultUserCertMaxTTL
+	}
+	if c.DefaultTTL > c.MaxTTL {
+		return fmt.Errorf("user CA: the default validity %d cannot be greater than the max validity %d",
+			c.DefaultTTL, c.MaxTTL)
+	}
+	for idx := range c.PrincipalMappings {
+		mapping := &c.PrincipalMappings[idx]
+		mapping.Group = strings.TrimSpace(mapping.Group)
+		if mapping.Group == "" {
+			return errors.New("user CA: the group is required for principal mappings")
+		}
+		var principals []string
+		for _, p := range mapping.Principals {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			if strings.ContainsAny(p, ", ") {
+				return fmt.Errorf("user CA: invalid principal %q for group %q", p, mapping.Group)
+			}
+			principals = append(principals, p)
+		}
+		if len(principals) == 0 {
+			return fmt.Errorf("user CA: no principals defined for group %q", mapping.Group)
+		}
+		mapping.Principals = util.RemoveDuplicates(principals, false)
+	}
+	return nil
+}
+
+func (c *UserCAConfig) initialize(configDir string) error {
+	userCA.set(nil, nil)
+	if c.PrivateKey == "" {
+		return nil
+	}
+	if !util.IsFileInputValid(c.PrivateKey) {
+		return fmt.Errorf("user CA: invalid private key %q", c.PrivateKey)
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	keyPath := c.PrivateKey
+	if !filepath.IsAbs(keyPath) {
+		keyPath = filepath.Join(configDir, keyPath)
+	}
+	if _, err := os.Stat(keyPath); errors.Is(err, fs.ErrNotExist) {
+		logger.Info(logSender, "", "user CA private key %q does not exist, generating a new Ed25519 key", keyPath)
+		if err := util.GenerateEd25519Keys(keyPath); err != nil {
+			return fmt.Errorf("user CA: unable to generate the private key %q: %w", keyPath, err)
+		}
+	}
+	privateBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("user CA: unable to read the private key %q: %w", keyPath, err)
+	}
+	signer, err := ssh.ParsePrivateKey(privateBytes)
+	if err != nil {
+		return fmt.Errorf("user CA: unable to parse the private key %q: %w", keyPath, err)
+	}
+	logger.Info(logSender, "", "user CA enabled, key %q, fingerprint %s, default validity: %d minutes, max validity: %d minutes",
+		keyPath, ssh.FingerprintSHA256(signer.PublicKey()), c.DefaultTTL, c.MaxTTL)
+	userCA.set(signer, c)
+	return nil
+}
+
+// UserCertificate defines an SSH user certificate issued by the built-in
+// certificate authority
+type UserCertificate struct {
+	// Certificate in authorized keys format, it can be saved in a file with the
+	// "-cert.pub" suffix next to the private key
+	Certificate string   `json:"certificate"`
+	Serial      uint64   `json:"serial"`
+	KeyID       string   `json:"key_id"`
+	Principals  []string `json:"principals"`
+	// Unix timestamps in milliseconds
+	ValidAfter  int64 `json:"valid_after"`
+	ValidBefore int64 `json:"valid_before"`
+	// Fingerprint of the certificate authority
+	CAFingerprint string `json:"ca_fingerprint"`
+}
+
+type userCertificateAuthority struct {
+	sync.RWMutex
+	signer     ssh.Signer
+	defaultTTL int
+	maxTTL     int
+	mappings   []UserCAPrincipalMapping
+}
+
+func (a *userCertificateAuthority) set(signer ssh.Signer, c *UserCAConfig) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.signer = signer
+	if c != nil {
+		a.defaultTTL = c.DefaultTTL
+		a.maxTTL = c.MaxTTL
+		a.mappings = c.PrincipalMappings
+	} else {
+		a.defaultTTL = 0
+		a.maxTTL = 0
+		a.mappings = nil
+	}
+}
+
+func (a *userCertificateAuthority) isEnabled() bool {
+	a.RLock()
+	defer a.RUnlock()
+
+	return a.signer != nil
+}
+
+// isAuthority returns true if the specified key is the public key of the built-in
+// certificate authority
+func (a *userCertificateAuthority) isAuthority(key ssh.PublicKey) bool {
+	a.RLock()
+	defer a.RUnlock()
+
+	if a.signer == nil {
+		return false
+	}
+	return bytes.Equal(a.signer.PublicKey().Marshal(), key.Marshal())
+}
+
+func (a *userCertificateAuthority) getPrincipals(user *dataprovider.User) []string {
+	principals := []string{user.Username}
+	for _, mapping := range a.mappings {
+		for _, g := range user.Groups {
+			if g.Name == mapping.Group {
+				principals = append(principals, mapping.Principals...)
+				break
+			}
+		}
+	}
+	return util.RemoveDuplicates(principals, false)
+}
+
+func (a *userCertificateAuthority) issue(user *dataprovider.User, pubKey ssh.PublicKey, ttl int) (UserCertificate, error) {
+	a.RLock()
+	defer a.RUnlock()
+
+	if a.signer == nil {
+		return UserCertificate{}, errUserCANotAvailable
+	}
+	if ttl == 0 {
+		ttl = a.defaultTTL
+	}
+	if ttl < 0 || ttl > a.maxTTL {
+		return UserCertificate{}, util.NewValidationError(fmt.Sprintf("invalid validity %d, it must be between 1 and %d minutes",
+			ttl, a.maxTTL))
+	}
+	var serialBytes [8]byte
+	if _, err := rand.Read(serialBytes[:]); err != nil {
+		return UserCertificate{}, err
+	}
+	serial := binary.BigEndian.Uint64(serialBytes[:])
+	now := time.Now()
+	validAfter := now.Add(-userCertClockSkew)
+	validBefore := now.Add(time.Duration(ttl) * time.Minute)
+	cert := &ssh.Certificate{
+		Key:             pubKey,
+		Serial:          serial,
+		CertType:        ssh.UserCert,
+		KeyId:           fmt.Sprintf("sftpgo:%s:%d", user.Username, serial),
+		ValidPrincipals: a.getPrincipals(user),
+		ValidAfter:      uint64(validAfter.Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, a.signer); err != nil {
+		return UserCertificate{}, fmt.Errorf("unable to sign the certificate: %w", err)
+	}
+	return UserCertificate{
+		Certificate:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))),
+		Serial:        cert.Serial,
+		KeyID:         cert.KeyId,
+		Principals:    cert.ValidPrincipals,
+		ValidAfter:    util.GetTimeAsMsSinceEpoch(validAfter),
+		ValidBefore:   util.GetTimeAsMsSinceEpoch(validBefore),
+		CAFingerprint: ssh.FingerprintSHA256(a.signer.PublicKey()),
+	}, nil
+}
+
+// IsUserCAEnabled returns true if the built-in certificate authority is enabled
+func IsUserCAEnabled() bool {
+	return userCA.isEnabled()
+}
+
+// IssueUserCertificate signs the specified public key, in authorized keys format,
+// and returns a certificate valid for the specified minutes, 0 means the default
+// validity. The user must be allowed to login using public key authentication via SSH.
+// The issued certificate is logged and recorded in the audit trail
+func IssueUserCertificate(username, publicKey string, ttl int, executor, ipAddress string) (UserCertificate, error) {
+	if !userCA.isEnabled() {
+		return UserCertificate{}, errUserCANotAvailable
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return UserCertificate{}, util.NewValidationError(fmt.Sprintf("unable to parse the public key: %v", err))
+	}
+	if _, ok := pubKey.(*ssh.Certificate); ok {
+		return UserCertificate{}, util.NewValidationError("a public key is required, certificates are not supported")
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(username)
+	if err != nil {
+		return UserCertificate{}, err
+	}
+	if err := user.CheckLoginConditions(); err != nil {
+		return UserCertificate{}, util.NewValidationError(err.Error())
+	}
+	if util.Contains(user.Filters.DeniedProtocols, common.ProtocolSSH) ||
+		!user.IsLoginMethodAllowed(dataprovider.SSHLoginMethodPublicKey, common.ProtocolSSH, nil) {
+		return UserCertificate{}, util.NewValidationError(fmt.Sprintf("user %q is not allowed to login using SSH public keys",
+			user.Username))
+	}
+	cert, err := userCA.issue(&user, pubKey, ttl)
+	if err != nil {
+		return cert, err
+	}
+	logger.Info(logSender, "", "SSH certificate issued for user %q, serial: %d, key id: %q, principals: %v, "+
+		"key: %s, valid before: %s, executor: %q, ip: %q", user.Username, cert.Serial, cert.KeyID, cert.Principals,
+		ssh.FingerprintSHA256(pubKey), util.GetTimeFromMsecSinceEpoch(cert.ValidBefore).UTC().Format(time.RFC3339),
+		executor, ipAddress)
+	dataprovider.AddSSHCertificateAuditEntry(user.Username, executor, ipAddress, map[string]any{
+		"serial":         fmt.Sprintf("%d", cert.Serial),
+		"key_id":         cert.KeyID,
+		"principals":     cert.Principals,
+		"key":            ssh.FingerprintSHA256(pubKey),
+		"valid_after":    cert.ValidAfter,
+		"valid_before":   cert.ValidBefore,
+		"ca_fingerprint": cert.CAFingerprint,
+	})
+	return cert, nil
+}
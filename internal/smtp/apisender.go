@@ -0,0 +1,392 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	mail "github.com/xhit/go-simple-mail/v2"
+
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	apiSendTimeout     = 120 * time.Second
+	sendGridDefaultURL = "https://api.sendgrid.com"
+	mailgunDefaultURL  = "https://api.mailgun.net"
+	maxAPIErrorSize    = 4096
+)
+
+func doAPIRequest(req *http.Request) error {
+	client := httpclient.GetHTTPClient()
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("smtp: unable to send email: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxAPIErrorSize))
+		return fmt.Errorf("smtp: unexpected status code %d, response: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+	return nil
+}
+
+func getAttachmentContent(attachment *mail.File) ([]byte, error) {
+	if attachment.Data != nil {
+		return attachment.Data, nil
+	}
+	if attachment.B64Data != "" {
+		return base64.StdEncoding.DecodeString(attachment.B64Data)
+	}
+	if attachment.FilePath != "" {
+		return os.ReadFile(attachment.FilePath)
+	}
+	return nil, errors.New("smtp: attachment without content")
+}
+
+func getAttachmentName(attachment *mail.File) string {
+	if attachment.Name != "" {
+		return attachment.Name
+	}
+	return filepath.Base(attachment.FilePath)
+}
+
+func getAttachmentMimeType(attachment *mail.File) string {
+	if attachment.MimeType != "" {
+		return attachment.MimeType
+	}
+	if mimeType := mime.TypeByExtension(filepath.Ext(getAttachmentName(attachment))); mimeType != "" {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
+
+type sesSender struct {
+	endpoint string
+	region   string
+	creds    aws.CredentialsProvider
+	signer   *v4.Signer
+}
+
+func newSESSender(config APIConfig) (*sesSender, error) {
+	if config.Region == "" {
+		return nil, errors.New("smtp: region is required for the SES provider")
+	}
+	s := &sesSender{
+		endpoint: config.Endpoint,
+		region:   config.Region,
+		signer:   v4.NewSigner(),
+	}
+	if s.endpoint == "" {
+		s.endpoint = fmt.Sprintf("https://email.%s.amazonaws.com", config.Region)
+	}
+	if config.Key != "" {
+		s.creds = aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(config.Key, config.Secret, ""))
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		awsConfig, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(config.Region))
+		if err != nil {
+			return nil, fmt.Errorf("smtp: unable to get AWS config: %w", err)
+		}
+		s.creds = awsConfig.Credentials
+	}
+	logger.Debug(logSender, "", "SES provider initialized, region: %#v, endpoint: %#v", s.region, s.endpoint)
+	return s, nil
+}
+
+func (s *sesSender) send(msg *emailMessage) error {
+	email, err := msg.toMail("")
+	if err != nil {
+		return err
+	}
+	destination := map[string]any{}
+	if len(msg.to) > 0 {
+		destination["ToAddresses"] = msg.to
+	}
+	if len(msg.cc) > 0 {
+		destination["CcAddresses"] = msg.cc
+	}
+	if len(msg.bcc) > 0 {
+		destination["BccAddresses"] = msg.bcc
+	}
+	payload := map[string]any{
+		"Destination": destination,
+		"Content": map[string]any{
+			"Raw": map[string]any{
+				"Data": []byte(email.GetMessage()),
+			},
+		},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiSendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(s.endpoint, "/")+"/v2/email/outbound-emails", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	creds, err := s.creds.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("smtp: unable to get AWS credentials: %w", err)
+	}
+	payloadHash := sha256.Sum256(body)
+	err = s.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(payloadHash[:]), "ses", s.region, time.Now())
+	if err != nil {
+		return fmt.Errorf("smtp: unable to sign SES request: %w", err)
+	}
+	return doAPIRequest(req)
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridAttachment struct {
+	Content  string `json:"content"`
+	Filename string `json:"filename"`
+	Type     string `json:"type,omitempty"`
+}
+
+type sendGridPersonalization struct {
+	To  []sendGridAddress `json:"to"`
+	Cc  []sendGridAddress `json:"cc,omitempty"`
+	Bcc []sendGridAddress `json:"bcc,omitempty"`
+}
+
+type sendGridMessage struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	ReplyTo          *sendGridAddress          `json:"reply_to,omitempty"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+}
+
+func getSendGridAddress(address string) (sendGridAddress, error) {
+	addr, err := netmail.ParseAddress(address)
+	if err != nil {
+		return sendGridAddress{}, fmt.Errorf("smtp: invalid address %#v: %w", address, err)
+	}
+	return sendGridAddress{Email: addr.Address, Name: addr.Name}, nil
+}
+
+func getSendGridAddresses(addresses []string) ([]sendGridAddress, error) {
+	result := make([]sendGridAddress, 0, len(addresses))
+	for _, address := range addresses {
+		addr, err := getSendGridAddress(address)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, addr)
+	}
+	return result, nil
+}
+
+type sendGridSender struct {
+	endpoint string
+	apiKey   string
+}
+
+func newSendGridSender(config APIConfig) (*sendGridSender, error) {
+	if config.Key == "" {
+		return nil, errors.New("smtp: API key is required for the SendGrid provider")
+	}
+	s := &sendGridSender{
+		endpoint: config.Endpoint,
+		apiKey:   config.Key,
+	}
+	if s.endpoint == "" {
+		s.endpoint = sendGridDefaultURL
+	}
+	logger.Debug(logSender, "", "SendGrid provider initialized, endpoint: %#v", s.endpoint)
+	return s, nil
+}
+
+func (s *sendGridSender) getMessage(msg *emailMessage) (*sendGridMessage, error) {
+	fromAddress, err := getSendGridAddress(msg.from)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg.to) == 0 {
+		return nil, errors.New("smtp: at least a To recipient is required for the SendGrid provider")
+	}
+	personalization := sendGridPersonalization{}
+	if personalization.To, err = getSendGridAddresses(msg.to); err != nil {
+		return nil, err
+	}
+	if personalization.Cc, err = getSendGridAddresses(msg.cc); err != nil {
+		return nil, err
+	}
+	if personalization.Bcc, err = getSendGridAddresses(msg.bcc); err != nil {
+		return nil, err
+	}
+	result := &sendGridMessage{
+		Personalizations: []sendGridPersonalization{personalization},
+		From:             fromAddress,
+		Subject:          msg.subject,
+	}
+	if msg.hasAlternative() {
+		// SendGrid requires text/plain to be the first content
+		result.Content = append(result.Content, sendGridContent{
+			Type:  "text/plain",
+			Value: msg.textBody,
+		})
+	}
+	result.Content = append(result.Content, sendGridContent{
+		Type:  msg.getMIMEContentType(),
+		Value: msg.body,
+	})
+	if msg.replyTo != "" {
+		replyTo, err := getSendGridAddress(msg.replyTo)
+		if err != nil {
+			return nil, err
+		}
+		result.ReplyTo = &replyTo
+	}
+	for idx := range msg.attachments {
+		attachment := &msg.attachments[idx]
+		data, err := getAttachmentContent(attachment)
+		if err != nil {
+			return nil, err
+		}
+		result.Attachments = append(result.Attachments, sendGridAttachment{
+			Content:  base64.StdEncoding.EncodeToString(data),
+			Filename: getAttachmentName(attachment),
+			Type:     getAttachmentMimeType(attachment),
+		})
+	}
+	return result, nil
+}
+
+func (s *sendGridSender) send(msg *emailMessage) error {
+	message, err := s.getMessage(msg)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiSendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.endpoint, "/")+"/v3/mail/send",
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	return doAPIRequest(req)
+}
+
+type mailgunSender struct {
+	endpoint string
+	domain   string
+	apiKey   string
+}
+
+func newMailgunSender(config APIConfig) (*mailgunSender, error) {
+	if config.Key == "" {
+		return nil, errors.New("smtp: API key is required for the Mailgun provider")
+	}
+	if config.Domain == "" {
+		return nil, errors.New("smtp: domain is required for the Mailgun provider")
+	}
+	s := &mailgunSender{
+		endpoint: config.Endpoint,
+		domain:   config.Domain,
+		apiKey:   config.Key,
+	}
+	if s.endpoint == "" {
+		s.endpoint = mailgunDefaultURL
+	}
+	logger.Debug(logSender, "", "Mailgun provider initialized, endpoint: %#v, domain: %#v", s.endpoint, s.domain)
+	return s, nil
+}
+
+func (s *mailgunSender) send(msg *emailMessage) error {
+	email, err := msg.toMail("")
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	// the message headers are sent as is, Bcc recipients are not included in
+	// the MIME message so all the recipients must be listed here
+	for _, to := range msg.getAllRecipients() {
+		if err := writer.WriteField("to", to); err != nil {
+			return err
+		}
+	}
+	part, err := writer.CreateFormFile("message", "message.mime")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(part, email.GetMessage()); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiSendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/v3/%s/messages.mime", strings.TrimSuffix(s.endpoint, "/"), s.domain), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.SetBasicAuth("api", s.apiKey)
+	return doAPIRequest(req)
+}
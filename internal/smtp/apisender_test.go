@@ -0,0 +1,336 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mail "github.com/xhit/go-simple-mail/v2"
+
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+)
+
+const (
+	testAPIKey    = "test_key"
+	testAPISecret = "test_secret"
+)
+
+type capturedRequest struct {
+	method string
+	path   string
+	header http.Header
+	host   string
+	body   []byte
+}
+
+// getTestAPIServer returns a test server that captures the last request and
+// replies with the specified status code and body
+func getTestAPIServer(t *testing.T, statusCode int, respBody string) (*httptest.Server, *capturedRequest) {
+	httpConfig := httpclient.Config{
+		Timeout: 5,
+	}
+	err := httpConfig.Initialize(".")
+	require.NoError(t, err)
+
+	captured := &capturedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		captured.method = r.Method
+		captured.path = r.URL.Path
+		captured.header = r.Header.Clone()
+		captured.host = r.Host
+		captured.body = body
+		w.WriteHeader(statusCode)
+		_, err = w.Write([]byte(respBody))
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+	return server, captured
+}
+
+func getTestEmailMessage() *emailMessage {
+	return &emailMessage{
+		from:        "SFTPGo <from@example.com>",
+		to:          []string{"to@example.com"},
+		cc:          []string{"Cc User <cc@example.com>"},
+		bcc:         []string{"bcc@example.com"},
+		replyTo:     "reply@example.com",
+		subject:     "test subject",
+		body:        "<p>test body</p>",
+		contentType: EmailContentTypeTextHTML,
+		textBody:    "test body",
+		attachments: []mail.File{
+			{
+				Name: "file.txt",
+				Data: []byte("attachment content"),
+			},
+		},
+	}
+}
+
+func TestAPIProvidersConfig(t *testing.T) {
+	_, err := newSESSender(APIConfig{})
+	assert.ErrorContains(t, err, "region is required")
+	ses, err := newSESSender(APIConfig{Key: testAPIKey, Secret: testAPISecret, Region: "eu-west-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://email.eu-west-1.amazonaws.com", ses.endpoint)
+
+	_, err = newSendGridSender(APIConfig{})
+	assert.ErrorContains(t, err, "API key is required")
+	sendGrid, err := newSendGridSender(APIConfig{Key: testAPIKey})
+	require.NoError(t, err)
+	assert.Equal(t, sendGridDefaultURL, sendGrid.endpoint)
+
+	_, err = newMailgunSender(APIConfig{Domain: "example.com"})
+	assert.ErrorContains(t, err, "API key is required")
+	_, err = newMailgunSender(APIConfig{Key: testAPIKey})
+	assert.ErrorContains(t, err, "domain is required")
+	mailgun, err := newMailgunSender(APIConfig{Key: testAPIKey, Domain: "example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, mailgunDefaultURL, mailgun.endpoint)
+}
+
+func TestSESSender(t *testing.T) {
+	server, captured := getTestAPIServer(t, http.StatusOK, `{"MessageId":"id"}`)
+	s, err := newSESSender(APIConfig{
+		Key:      testAPIKey,
+		Secret:   testAPISecret,
+		Region:   "us-east-1",
+		Endpoint: server.URL + "/",
+	})
+	require.NoError(t, err)
+	msg := getTestEmailMessage()
+	err = s.send(msg)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.MethodPost, captured.method)
+	assert.Equal(t, "/v2/email/outbound-emails", captured.path)
+	assert.Equal(t, "application/json", captured.header.Get("Content-Type"))
+	authorization := captured.header.Get("Authorization")
+	assert.True(t, strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential="+testAPIKey+"/"), authorization)
+	assert.Contains(t, authorization, "/us-east-1/ses/aws4_request")
+	// sign the received request again and check that the signature matches
+	signingTime, err := time.Parse("20060102T150405Z", captured.header.Get("X-Amz-Date"))
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, "http://"+captured.host+captured.path, bytes.NewReader(captured.body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", captured.header.Get("Content-Type"))
+	payloadHash := sha256.Sum256(captured.body)
+	err = v4.NewSigner().SignHTTP(context.Background(), aws.Credentials{
+		AccessKeyID:     testAPIKey,
+		SecretAccessKey: testAPISecret,
+	}, req, hex.EncodeToString(payloadHash[:]), "ses", "us-east-1", signingTime)
+	require.NoError(t, err)
+	assert.Equal(t, req.Header.Get("Authorization"), authorization)
+
+	var payload struct {
+		Destination struct {
+			ToAddresses  []string
+			CcAddresses  []string
+			BccAddresses []string
+		}
+		Content struct {
+			Raw struct {
+				Data []byte
+			}
+		}
+	}
+	err = json.Unmarshal(captured.body, &payload)
+	require.NoError(t, err)
+	assert.Equal(t, msg.to, payload.Destination.ToAddresses)
+	assert.Equal(t, msg.cc, payload.Destination.CcAddresses)
+	assert.Equal(t, msg.bcc, payload.Destination.BccAddresses)
+	rawMessage := string(payload.Content.Raw.Data)
+	assert.Contains(t, rawMessage, "Subject: test subject")
+	assert.Contains(t, rawMessage, "multipart/alternative")
+	assert.Contains(t, rawMessage, "file.txt")
+	// the signature does not match if the body is modified
+	req, err = http.NewRequest(http.MethodPost, "http://"+captured.host+captured.path, bytes.NewReader([]byte("{}")))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", captured.header.Get("Content-Type"))
+	payloadHash = sha256.Sum256([]byte("{}"))
+	err = v4.NewSigner().SignHTTP(context.Background(), aws.Credentials{
+		AccessKeyID:     testAPIKey,
+		SecretAccessKey: testAPISecret,
+	}, req, hex.EncodeToString(payloadHash[:]), "ses", "us-east-1", signingTime)
+	require.NoError(t, err)
+	assert.NotEqual(t, req.Header.Get("Authorization"), authorization)
+
+	msg.contentType = EmailContentType(100)
+	err = s.send(msg)
+	assert.ErrorContains(t, err, "unsupported body content type")
+}
+
+func TestSendGridSender(t *testing.T) {
+	server, captured := getTestAPIServer(t, http.StatusAccepted, "")
+	s, err := newSendGridSender(APIConfig{
+		Key:      testAPIKey,
+		Endpoint: server.URL,
+	})
+	require.NoError(t, err)
+	msg := getTestEmailMessage()
+	err = s.send(msg)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.MethodPost, captured.method)
+	assert.Equal(t, "/v3/mail/send", captured.path)
+	assert.Equal(t, "application/json", captured.header.Get("Content-Type"))
+	assert.Equal(t, "Bearer "+testAPIKey, captured.header.Get("Authorization"))
+	var message sendGridMessage
+	err = json.Unmarshal(captured.body, &message)
+	require.NoError(t, err)
+	assert.Equal(t, sendGridAddress{Email: "from@example.com", Name: "SFTPGo"}, message.From)
+	assert.Equal(t, "test subject", message.Subject)
+	if assert.Len(t, message.Personalizations, 1) {
+		p := message.Personalizations[0]
+		assert.Equal(t, []sendGridAddress{{Email: "to@example.com"}}, p.To)
+		assert.Equal(t, []sendGridAddress{{Email: "cc@example.com", Name: "Cc User"}}, p.Cc)
+		assert.Equal(t, []sendGridAddress{{Email: "bcc@example.com"}}, p.Bcc)
+	}
+	if assert.NotNil(t, message.ReplyTo) {
+		assert.Equal(t, "reply@example.com", message.ReplyTo.Email)
+	}
+	// text/plain must be the first content
+	assert.Equal(t, []sendGridContent{
+		{Type: "text/plain", Value: "test body"},
+		{Type: "text/html", Value: "<p>test body</p>"},
+	}, message.Content)
+	if assert.Len(t, message.Attachments, 1) {
+		assert.Equal(t, "file.txt", message.Attachments[0].Filename)
+		assert.Equal(t, "text/plain; charset=utf-8", message.Attachments[0].Type)
+		data, err := base64.StdEncoding.DecodeString(message.Attachments[0].Content)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("attachment content"), data)
+	}
+
+	msg.to = nil
+	err = s.send(msg)
+	assert.ErrorContains(t, err, "at least a To recipient is required")
+	msg.to = []string{"invalid address"}
+	err = s.send(msg)
+	assert.ErrorContains(t, err, "invalid address")
+	msg.to = []string{"to@example.com"}
+	msg.attachments = []mail.File{{Name: "empty.txt"}}
+	err = s.send(msg)
+	assert.ErrorContains(t, err, "attachment without content")
+}
+
+func TestMailgunSender(t *testing.T) {
+	server, captured := getTestAPIServer(t, http.StatusOK, `{"message":"Queued"}`)
+	s, err := newMailgunSender(APIConfig{
+		Key:      testAPIKey,
+		Domain:   "mg.example.com",
+		Endpoint: server.URL,
+	})
+	require.NoError(t, err)
+	msg := getTestEmailMessage()
+	err = s.send(msg)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.MethodPost, captured.method)
+	assert.Equal(t, "/v3/mg.example.com/messages.mime", captured.path)
+	assert.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("api:"+testAPIKey)),
+		captured.header.Get("Authorization"))
+	mediaType, params, err := mime.ParseMediaType(captured.header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/form-data", mediaType)
+	form, err := multipart.NewReader(bytes.NewReader(captured.body), params["boundary"]).ReadForm(1024 * 1024)
+	require.NoError(t, err)
+	// all the recipients, including Bcc, are sent as form fields
+	assert.Equal(t, []string{"to@example.com", "Cc User <cc@example.com>", "bcc@example.com"}, form.Value["to"])
+	if assert.Len(t, form.File["message"], 1) {
+		f, err := form.File["message"][0].Open()
+		require.NoError(t, err)
+		rawMessage, err := io.ReadAll(f)
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+		assert.Contains(t, string(rawMessage), "Subject: test subject")
+		assert.Contains(t, string(rawMessage), "file.txt")
+		assert.NotContains(t, string(rawMessage), "bcc@example.com")
+	}
+}
+
+func TestAPISenderErrors(t *testing.T) {
+	longResponse := strings.Repeat("a", maxAPIErrorSize*2)
+	server, _ := getTestAPIServer(t, http.StatusBadRequest, longResponse)
+	ses, err := newSESSender(APIConfig{Key: testAPIKey, Secret: testAPISecret, Region: "us-east-1", Endpoint: server.URL})
+	require.NoError(t, err)
+	sendGrid, err := newSendGridSender(APIConfig{Key: testAPIKey, Endpoint: server.URL})
+	require.NoError(t, err)
+	mailgun, err := newMailgunSender(APIConfig{Key: testAPIKey, Domain: "example.com", Endpoint: server.URL})
+	require.NoError(t, err)
+
+	for _, s := range []sender{ses, sendGrid, mailgun} {
+		err = s.send(getTestEmailMessage())
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "unexpected status code 400")
+			// the error response is truncated
+			assert.Less(t, len(err.Error()), maxAPIErrorSize+100)
+		}
+	}
+
+	server, _ = getTestAPIServer(t, http.StatusUnauthorized, " invalid credentials\n")
+	mailgun.endpoint = server.URL
+	err = mailgun.send(getTestEmailMessage())
+	assert.EqualError(t, err, "smtp: unexpected status code 401, response: invalid credentials")
+	// the server is not reachable
+	server.Close()
+	sendGrid.endpoint = server.URL
+	err = sendGrid.send(getTestEmailMessage())
+	assert.ErrorContains(t, err, "unable to send email")
+}
+
+func TestAttachmentHelpers(t *testing.T) {
+	attachmentPath := filepath.Join(t.TempDir(), "report.csv")
+	err := os.WriteFile(attachmentPath, []byte("a,b"), 0600)
+	require.NoError(t, err)
+
+	data, err := getAttachmentContent(&mail.File{Data: []byte("data")})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+	data, err = getAttachmentContent(&mail.File{B64Data: base64.StdEncoding.EncodeToString([]byte("b64"))})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("b64"), data)
+	data, err = getAttachmentContent(&mail.File{FilePath: attachmentPath})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a,b"), data)
+	_, err = getAttachmentContent(&mail.File{})
+	assert.Error(t, err)
+
+	assert.Equal(t, "report.csv", getAttachmentName(&mail.File{FilePath: attachmentPath}))
+	assert.Equal(t, "custom.txt", getAttachmentName(&mail.File{Name: "custom.txt", FilePath: attachmentPath}))
+	assert.Equal(t, "image/png", getAttachmentMimeType(&mail.File{Name: "a.csv", MimeType: "image/png"}))
+	assert.Equal(t, "application/octet-stream", getAttachmentMimeType(&mail.File{Name: "file.unknownext"}))
+}
@@ -0,0 +1,154 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	defaultMaxAttachmentsSize = 10 * 1024 * 1024
+)
+
+var (
+	// ErrAttachmentsTooLarge is returned if the email attachments exceed the configured max size
+	ErrAttachmentsTooLarge = errors.New("smtp: attachments size too large")
+	maxAttachmentsSize     = int64(defaultMaxAttachmentsSize)
+)
+
+// GetMaxAttachmentsSize returns the maximum allowed size, as bytes, for the
+// attachments of a single email
+func GetMaxAttachmentsSize() int64 {
+	return maxAttachmentsSize
+}
+
+// FileAttachment defines an email attachment whose content is read from a
+// virtual filesystem while sending the email. The SMTP provider streams the
+// content without buffering it in memory, the API providers read it in memory
+// up to the configured max attachments size
+type FileAttachment struct {
+	// Name is the attachment name as seen by the recipients
+	Name string
+	// Size is the expected size, it is used to reject early attachments exceeding
+	// the limits. The limits are also enforced while reading the content
+	Size   int64
+	fs     vfs.Fs
+	fsPath string
+}
+
+// NewFileAttachment returns an attachment for the specified filesystem path
+func NewFileAttachment(fs vfs.Fs, fsPath, name string, size int64) FileAttachment {
+	return FileAttachment{
+		Name:   name,
+		Size:   size,
+		fs:     fs,
+		fsPath: fsPath,
+	}
+}
+
+// open returns a reader for the attachment content, the returned function
+// must be called after closing the reader
+func (a *FileAttachment) open() (io.ReadCloser, func(), error) {
+	if a.fs == nil {
+		return nil, nil, fmt.Errorf("smtp: no filesystem for attachment %q", a.Name)
+	}
+	f, r, cancelFn, err := a.fs.Open(a.fsPath, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("smtp: unable to open attachment %q: %w", a.Name, err)
+	}
+	if cancelFn == nil {
+		cancelFn = func() {}
+	}
+	if f != nil {
+		return f, cancelFn, nil
+	}
+	return r, cancelFn, nil
+}
+
+// copyTo copies the attachment content to w, the content size is checked
+// against the limiter while reading
+func (a *FileAttachment) copyTo(w io.Writer, limiter *attachmentsLimiter) error {
+	reader, cancelFn, err := a.open()
+	if err != nil {
+		return err
+	}
+	defer cancelFn()
+	defer reader.Close()
+
+	_, err = io.Copy(w, limiter.wrap(reader))
+	if err != nil {
+		return fmt.Errorf("smtp: unable to read attachment %q: %w", a.Name, err)
+	}
+	return nil
+}
+
+// load reads the attachment content in memory
+func (a *FileAttachment) load(limiter *attachmentsLimiter) (mail.File, error) {
+	var buf bytes.Buffer
+	if err := a.copyTo(&buf, limiter); err != nil {
+		return mail.File{}, err
+	}
+	return mail.File{
+		Name: a.Name,
+		Data: buf.Bytes(),
+	}, nil
+}
+
+// attachmentsLimiter enforces the max size for all the attachments of an email
+type attachmentsLimiter struct {
+	remaining int64
+}
+
+func newAttachmentsLimiter(attachments []mail.File, files []FileAttachment) (*attachmentsLimiter, error) {
+	l := &attachmentsLimiter{
+		remaining: GetMaxAttachmentsSize(),
+	}
+	for idx := range attachments {
+		l.remaining -= int64(len(attachments[idx].Data))
+	}
+	expected := l.remaining
+	for idx := range files {
+		expected -= files[idx].Size
+	}
+	if l.remaining < 0 || expected < 0 {
+		return nil, ErrAttachmentsTooLarge
+	}
+	return l, nil
+}
+
+func (l *attachmentsLimiter) wrap(r io.Reader) io.Reader {
+	return &limitedReader{r: r, limiter: l}
+}
+
+type limitedReader struct {
+	r       io.Reader
+	limiter *attachmentsLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.limiter.remaining -= int64(n)
+	if r.limiter.remaining < 0 {
+		return n, ErrAttachmentsTooLarge
+	}
+	return n, err
+}
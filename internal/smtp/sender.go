@@ -0,0 +1,138 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"errors"
+	"fmt"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// sender defines the interface for email delivery providers
+type sender interface {
+	send(msg *emailMessage) error
+}
+
+// emailMessage defines a provider independent email message
+type emailMessage struct {
+	from        string
+	to          []string
+	cc          []string
+	bcc         []string
+	replyTo     string
+	subject     string
+	body        string
+	contentType EmailContentType
+	// plain text alternative for HTML bodies
+	textBody    string
+	attachments []mail.File
+}
+
+func (m *emailMessage) validate() error {
+	if len(m.to) == 0 && len(m.bcc) == 0 {
+		return errors.New("smtp: at least a recipient is required")
+	}
+	switch m.contentType {
+	case EmailContentTypeTextPlain, EmailContentTypeTextHTML:
+		return nil
+	default:
+		return fmt.Errorf("smtp: unsupported body content type %v", m.contentType)
+	}
+}
+
+// getAllRecipients returns the envelope recipients, To, Cc and Bcc
+func (m *emailMessage) getAllRecipients() []string {
+	recipients := make([]string, 0, len(m.to)+len(m.cc)+len(m.bcc))
+	recipients = append(recipients, m.to...)
+	recipients = append(recipients, m.cc...)
+	return append(recipients, m.bcc...)
+}
+
+// hasAlternative returns true if the message must be sent as multipart/alternative
+func (m *emailMessage) hasAlternative() bool {
+	return m.contentType == EmailContentTypeTextHTML && m.textBody != ""
+}
+
+func (m *emailMessage) getMIMEContentType() string {
+	if m.contentType == EmailContentTypeTextHTML {
+		return "text/html"
+	}
+	return "text/plain"
+}
+
+// toMail converts the message to a go-simple-mail message, it is used by the
+// SMTP sender and by the API providers that accept raw MIME messages
+func (m *emailMessage) toMail(fallbackFrom string) (*mail.Email, error) {
+	email := mail.NewMSG()
+	if m.from != "" {
+		email.SetFrom(m.from)
+	} else {
+		email.SetFrom(fallbackFrom)
+	}
+	if len(m.to) > 0 {
+		email.AddTo(m.to...)
+	}
+	if len(m.cc) > 0 {
+		email.AddCc(m.cc...)
+	}
+	if len(m.bcc) > 0 {
+		email.AddBcc(m.bcc...)
+	}
+	if m.replyTo != "" {
+		email.SetReplyTo(m.replyTo)
+	}
+	email.SetSubject(m.subject)
+	switch m.contentType {
+	case EmailContentTypeTextPlain:
+		email.SetBody(mail.TextPlain, m.body)
+	case EmailContentTypeTextHTML:
+		if m.hasAlternative() {
+			// the preferred format must be the last one
+			email.SetBody(mail.TextPlain, m.textBody)
+			email.AddAlternative(mail.TextHTML, m.body)
+		} else {
+			email.SetBody(mail.TextHTML, m.body)
+		}
+	default:
+		return nil, fmt.Errorf("smtp: unsupported body content type %v", m.contentType)
+	}
+	for idx := range m.attachments {
+		email.Attach(&m.attachments[idx])
+	}
+	if email.Error != nil {
+		return nil, fmt.Errorf("smtp: email error: %w", email.Error)
+	}
+	return email, nil
+}
+
+type smtpSender struct {
+	server *mail.SMTPServer
+}
+
+func (s *smtpSender) send(msg *emailMessage) error {
+	email, err := msg.toMail(s.server.Username)
+	if err != nil {
+		return err
+	}
+	smtpClient, err := s.server.Connect()
+	if err != nil {
+		return fmt.Errorf("smtp: unable to connect to %s:%d: %w", s.server.Host, s.server.Port, err)
+	}
+	if err := email.Send(smtpClient); err != nil {
+		return fmt.Errorf("smtp: unable to send email: %w", err)
+	}
+	return nil
+}
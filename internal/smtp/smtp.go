@@ -19,7 +19,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"html/template"
+	"os"
 	"path/filepath"
 	"time"
 
@@ -43,19 +43,51 @@ const (
 )
 
 const (
-	templateEmailDir      = "email"
-	templatePasswordReset = "reset-password.html"
+	templateEmailDir            = "email"
+	templatePasswordReset       = "reset-password.html"
+	templatePasswordResetText   = "reset-password.txt"
+	templateArchiveRestored     = "archive-restored.html"
+	templateArchiveRestoredText = "archive-restored.txt"
+	templateMagicLink           = "magic-link.html"
+	templateMagicLinkText       = "magic-link.txt"
+	templateSummaryReport       = "summary-report.html"
+	templateSummaryReportText   = "summary-report.txt"
+	templateRecoveryCodes       = "recovery-codes.html"
+	templateRecoveryCodesText   = "recovery-codes.txt"
+)
+
+// Supported email delivery providers
+const (
+	ProviderSMTP     = "smtp"
+	ProviderSES      = "ses"
+	ProviderSendGrid = "sendgrid"
+	ProviderMailgun  = "mailgun"
 )
 
 var (
-	smtpServer     *mail.SMTPServer
-	from           string
-	emailTemplates = make(map[string]*template.Template)
+	emailSender sender
+	from        string
 )
 
-// IsEnabled returns true if an SMTP server is configured
+// IsEnabled returns true if an email delivery provider is configured
 func IsEnabled() bool {
-	return smtpServer != nil
+	return emailSender != nil
+}
+
+// APIConfig defines the configuration for HTTP API based email providers
+type APIConfig struct {
+	// API key. For SES this is the access key ID, if empty the default AWS
+	// credentials chain will be used
+	Key string `json:"key" mapstructure:"key"`
+	// Access secret, used for SES only
+	Secret string `json:"secret" mapstructure:"secret"`
+	// AWS region, used for SES only
+	Region string `json:"region" mapstructure:"region"`
+	// Sending domain, used for Mailgun only
+	Domain string `json:"domain" mapstructure:"domain"`
+	// Optional base URL to use instead of the provider default one,
+	// for example "https://api.eu.mailgun.net" for Mailgun EU region
+	Endpoint string `json:"endpoint" mapstructure:"endpoint"`
 }
 
 // Config defines the SMTP configuration to use to send emails
@@ -86,31 +118,123 @@ type Config struct {
 	// Path to the email templates. This can be an absolute path or a path relative to the config dir.
 	// Templates are searched within a subdirectory named "email" in the specified path
 	TemplatesPath string `json:"templates_path" mapstructure:"templates_path"`
+	// Path to a writable directory for custom templates. This can be an absolute path or a path
+	// relative to the config dir. Custom templates can be managed using the REST API and they are
+	// reloaded on SIGHUP. A custom template overrides a built-in one with the same name.
+	// Leave empty to disable custom templates
+	CustomTemplatesPath string `json:"custom_templates_path" mapstructure:"custom_templates_path"`
+	// Email delivery provider. Empty or "smtp" means SMTP, "ses", "sendgrid" and "mailgun"
+	// allow to send emails using the provider HTTP APIs, without SMTP connectivity
+	Provider string `json:"provider" mapstructure:"provider"`
+	// Configuration for the HTTP API based providers
+	API APIConfig `json:"api" mapstructure:"api"`
+	// Maximum number of emails to send per minute, additional emails will be
+	// rejected. 0 means no limit
+	MaxEmailsPerMinute int `json:"max_emails_per_minute" mapstructure:"max_emails_per_minute"`
+	// Interval, in seconds, for coalescing notifications. If greater than 0,
+	// similar notifications, with the same recipients and subject, sent within
+	// this interval are grouped into a single summary email. The first notification
+	// is always sent immediately. 0 means disabled
+	DigestInterval int `json:"digest_interval" mapstructure:"digest_interval"`
+	// Maximum total size, as MB, for the attachments of a single email.
+	// Files attached to event notifications exceeding this limit are not sent.
+	// 0 means the default limit: 10 MB
+	MaxAttachmentsSize int `json:"max_attachments_size" mapstructure:"max_attachments_size"`
+}
+
+func (c *Config) isAPIProvider() bool {
+	return c.Provider != "" && c.Provider != ProviderSMTP
 }
 
 // Initialize initialized and validates the SMTP configuration
 func (c *Config) Initialize(configDir string) error {
-	smtpServer = nil
-	if c.Host == "" {
+	emailSender = nil
+	setThrottling(0, 0)
+	maxAttachmentsSize = defaultMaxAttachmentsSize
+	if !c.isAPIProvider() && c.Host == "" {
 		logger.Debug(logSender, "", "configuration disabled, email capabilities will not be available")
 		return nil
 	}
-	if c.Port <= 0 || c.Port > 65535 {
-		return fmt.Errorf("smtp: invalid port %v", c.Port)
-	}
-	if c.AuthType < 0 || c.AuthType > 2 {
-		return fmt.Errorf("smtp: invalid auth type %v", c.AuthType)
-	}
-	if c.Encryption < 0 || c.Encryption > 2 {
-		return fmt.Errorf("smtp: invalid encryption %v", c.Encryption)
+	if c.isAPIProvider() && c.From == "" {
+		return fmt.Errorf("smtp: from address is required for provider %#v", c.Provider)
 	}
 	templatesPath := util.FindSharedDataPath(c.TemplatesPath, configDir)
 	if templatesPath == "" {
 		return fmt.Errorf("smtp: invalid templates path %#v", templatesPath)
 	}
-	loadTemplates(filepath.Join(templatesPath, templateEmailDir))
+	if c.MaxEmailsPerMinute < 0 {
+		return fmt.Errorf("smtp: invalid max emails per minute %v", c.MaxEmailsPerMinute)
+	}
+	if c.DigestInterval < 0 {
+		return fmt.Errorf("smtp: invalid digest interval %v", c.DigestInterval)
+	}
+	if c.MaxAttachmentsSize < 0 {
+		return fmt.Errorf("smtp: invalid max attachments size %v", c.MaxAttachmentsSize)
+	}
+	customTemplatesPath, err := c.getCustomTemplatesPath(configDir)
+	if err != nil {
+		return err
+	}
+	s, err := c.getSender()
+	if err != nil {
+		return err
+	}
+	loadTemplates(filepath.Join(templatesPath, templateEmailDir), customTemplatesPath)
+	if err := templates.reload(); err != nil {
+		return err
+	}
 	from = c.From
-	smtpServer = mail.NewSMTPClient()
+	setThrottling(c.MaxEmailsPerMinute, c.DigestInterval)
+	if c.MaxAttachmentsSize > 0 {
+		maxAttachmentsSize = int64(c.MaxAttachmentsSize) * 1024 * 1024
+	}
+	emailSender = s
+	return nil
+}
+
+func (c *Config) getCustomTemplatesPath(configDir string) (string, error) {
+	if c.CustomTemplatesPath == "" {
+		return "", nil
+	}
+	if !util.IsFileInputValid(c.CustomTemplatesPath) {
+		return "", fmt.Errorf("smtp: invalid custom templates path %#v", c.CustomTemplatesPath)
+	}
+	customTemplatesPath := c.CustomTemplatesPath
+	if !filepath.IsAbs(customTemplatesPath) {
+		customTemplatesPath = filepath.Join(configDir, customTemplatesPath)
+	}
+	if err := os.MkdirAll(customTemplatesPath, 0700); err != nil {
+		return "", fmt.Errorf("smtp: unable to create custom templates path %#v: %w", customTemplatesPath, err)
+	}
+	return customTemplatesPath, nil
+}
+
+func (c *Config) getSender() (sender, error) {
+	switch c.Provider {
+	case "", ProviderSMTP:
+		return c.getSMTPSender()
+	case ProviderSES:
+		return newSESSender(c.API)
+	case ProviderSendGrid:
+		return newSendGridSender(c.API)
+	case ProviderMailgun:
+		return newMailgunSender(c.API)
+	default:
+		return nil, fmt.Errorf("smtp: unsupported provider %#v", c.Provider)
+	}
+}
+
+func (c *Config) getSMTPSender() (sender, error) {
+	if c.Port <= 0 || c.Port > 65535 {
+		return nil, fmt.Errorf("smtp: invalid port %v", c.Port)
+	}
+	if c.AuthType < 0 || c.AuthType > 2 {
+		return nil, fmt.Errorf("smtp: invalid auth type %v", c.AuthType)
+	}
+	if c.Encryption < 0 || c.Encryption > 2 {
+		return nil, fmt.Errorf("smtp: invalid encryption %v", c.Encryption)
+	}
+	smtpServer := mail.NewSMTPClient()
 	smtpServer.Host = c.Host
 	smtpServer.Port = c.Port
 	smtpServer.Username = c.User
@@ -125,7 +249,7 @@ func (c *Config) Initialize(configDir string) error {
 	}
 	logger.Debug(logSender, "", "configuration successfully initialized, host: %#v, port: %v, username: %#v, auth: %v, encryption: %v, helo: %#v",
 		smtpServer.Host, smtpServer.Port, smtpServer.Username, smtpServer.Authentication, smtpServer.Encryption, smtpServer.Helo)
-	return nil
+	return &smtpSender{server: smtpServer}, nil
 }
 
 func (c *Config) getEncryption() mail.Encryption {
@@ -153,53 +277,203 @@ func (c *Config) getAuthType() mail.AuthType {
 	}
 }
 
-func loadTemplates(templatesPath string) {
-	logger.Debug(logSender, "", "loading templates from %#v", templatesPath)
+func loadTemplates(templatesPath, customTemplatesPath string) {
+	logger.Debug(logSender, "", "loading templates from %#v, custom templates path: %#v", templatesPath,
+		customTemplatesPath)
 
 	passwordResetPath := filepath.Join(templatesPath, templatePasswordReset)
 	pwdResetTmpl := util.LoadTemplate(nil, passwordResetPath)
 
-	emailTemplates[templatePasswordReset] = pwdResetTmpl
+	templates.Lock()
+	templates.builtin = make(map[string]emailTemplate)
+	templates.customPath = customTemplatesPath
+	templates.Unlock()
+	templates.setBuiltin(templatePasswordReset, pwdResetTmpl)
+	// the plain text alternative is optional, custom templates paths may not have it
+	if pwdResetTextTmpl, err := loadBuiltinTemplate(filepath.Join(templatesPath, templatePasswordResetText)); err == nil {
+		templates.setBuiltin(templatePasswordResetText, pwdResetTextTmpl)
+	} else {
+		logger.Debug(logSender, "", "plain text password reset template not loaded: %v", err)
+	}
+	// the archive restored, magic link, summary report and recovery codes templates are optional
+	// too, the related features cannot send emails if they are missing
+	for _, name := range []string{templateArchiveRestored, templateArchiveRestoredText, templateMagicLink,
+		templateMagicLinkText, templateSummaryReport, templateSummaryReportText, templateRecoveryCodes,
+		templateRecoveryCodesText} {
+		templatePath := filepath.Join(templatesPath, name)
+		if _, err := os.Stat(templatePath); err != nil {
+			logger.Debug(logSender, "", "template %q not loaded: %v", name, err)
+			continue
+		}
+		if tmpl, err := loadBuiltinTemplate(templatePath); err == nil {
+			templates.setBuiltin(name, tmpl)
+		} else {
+			logger.Debug(logSender, "", "template %q not loaded: %v", name, err)
+		}
+	}
+	loadLocalizedBuiltinTemplates(templatesPath, templatePasswordReset, templatePasswordResetText,
+		templateArchiveRestored, templateArchiveRestoredText, templateMagicLink, templateMagicLinkText,
+		templateSummaryReport, templateSummaryReportText, templateRecoveryCodes, templateRecoveryCodesText)
 }
 
-// RenderPasswordResetTemplate executes the password reset template
-func RenderPasswordResetTemplate(buf *bytes.Buffer, data any) error {
-	if smtpServer == nil {
-		return errors.New("smtp: not configured")
-	}
-	return emailTemplates[templatePasswordReset].Execute(buf, data)
+// RenderPasswordResetTemplate executes the password reset template for the
+// specified language
+func RenderPasswordResetTemplate(buf *bytes.Buffer, lang string, data any) error {
+	return RenderLocalizedTemplate(buf, templatePasswordReset, lang, data)
+}
+
+// RenderPasswordResetBody executes the password reset template for the specified
+// language and returns the email body, the plain text alternative is included, if available
+func RenderPasswordResetBody(lang string, data any) (EmailBody, error) {
+	return RenderLocalizedTemplateBody(templatePasswordReset, lang, data)
+}
+
+// RenderArchiveRestoredBody executes the archive restored template for the specified
+// language and returns the email body, the plain text alternative is included, if available
+func RenderArchiveRestoredBody(lang string, data any) (EmailBody, error) {
+	return RenderLocalizedTemplateBody(templateArchiveRestored, lang, data)
+}
+
+// RenderMagicLinkBody executes the magic link template for the specified language
+// and returns the email body, the plain text alternative is included, if available
+func RenderMagicLinkBody(lang string, data any) (EmailBody, error) {
+	return RenderLocalizedTemplateBody(templateMagicLink, lang, data)
+}
+
+// RenderRecoveryCodesBody executes the recovery codes template for the specified language
+// and returns the email body, the plain text alternative is included, if available
+func RenderRecoveryCodesBody(lang string, data any) (EmailBody, error) {
+	return RenderLocalizedTemplateBody(templateRecoveryCodes, lang, data)
+}
+
+// RenderSummaryReportBody executes the summary report template for the specified
+// language and returns the email body, the plain text alternative is included, if available
+func RenderSummaryReportBody(lang string, data any) (EmailBody, error) {
+	return RenderLocalizedTemplateBody(templateSummaryReport, lang, data)
+}
+
+// EmailAddresses defines the recipients and the reply address for an email
+type EmailAddresses struct {
+	To      []string
+	Cc      []string
+	Bcc     []string
+	ReplyTo string
+}
+
+// EmailBody defines an email body. For HTML bodies a plain text alternative can
+// be optionally set, in this case a multipart/alternative message is sent, so
+// text based clients can display the plain text version
+type EmailBody struct {
+	Content     string
+	ContentType EmailContentType
+	// Text is the plain text alternative, it is ignored for plain text bodies
+	Text string
 }
 
 // SendEmail tries to send an email using the specified parameters.
 func SendEmail(to []string, subject, body string, contentType EmailContentType, attachments ...mail.File) error {
-	if smtpServer == nil {
+	return SendEmailTo(EmailAddresses{To: to}, subject, body, contentType, attachments...)
+}
+
+// SendTestEmail sends a test email to the specified recipient using the current
+// configuration. Rate limiting and digest mode do not apply to test emails
+func SendTestEmail(recipient string) error {
+	if emailSender == nil {
 		return errors.New("smtp: not configured")
 	}
-	smtpClient, err := smtpServer.Connect()
-	if err != nil {
-		return fmt.Errorf("smtp: unable to connect: %w", err)
+	msg := &emailMessage{
+		from:        from,
+		to:          []string{recipient},
+		subject:     "SFTPGo - Testing Email Settings",
+		body:        "It appears your SFTPGo email is setup correctly!",
+		contentType: EmailContentTypeTextPlain,
+	}
+	if err := msg.validate(); err != nil {
+		return err
 	}
+	return emailSender.send(msg)
+}
 
-	email := mail.NewMSG()
-	if from != "" {
-		email.SetFrom(from)
-	} else {
-		email.SetFrom(smtpServer.Username)
-	}
-	email.AddTo(to...).SetSubject(subject)
-	switch contentType {
-	case EmailContentTypeTextPlain:
-		email.SetBody(mail.TextPlain, body)
-	case EmailContentTypeTextHTML:
-		email.SetBody(mail.TextHTML, body)
-	default:
-		return fmt.Errorf("smtp: unsupported body content type %v", contentType)
+// SendEmailTo tries to send an email to the specified addresses.
+// At least a To or Bcc recipient is required.
+// ErrRateLimited is returned if the configured rate limit is exceeded
+func SendEmailTo(addresses EmailAddresses, subject, body string, contentType EmailContentType,
+	attachments ...mail.File,
+) error {
+	return SendEmailBody(addresses, subject, EmailBody{Content: body, ContentType: contentType}, attachments...)
+}
+
+// SendEmailBody tries to send an email with the specified body to the specified
+// addresses. HTML bodies with a plain text alternative are sent as multipart/alternative.
+// ErrRateLimited is returned if the configured rate limit is exceeded
+func SendEmailBody(addresses EmailAddresses, subject string, body EmailBody, attachments ...mail.File) error {
+	if emailSender == nil {
+		return errors.New("smtp: not configured")
+	}
+	msg := &emailMessage{
+		from:        from,
+		to:          addresses.To,
+		cc:          addresses.Cc,
+		bcc:         addresses.Bcc,
+		replyTo:     addresses.ReplyTo,
+		subject:     subject,
+		body:        body.Content,
+		contentType: body.ContentType,
+		textBody:    body.Text,
+		attachments: attachments,
+	}
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	if !allowSending() {
+		return ErrRateLimited
+	}
+	return emailSender.send(msg)
+}
+
+// SendEmailWithFiles tries to send an email with the specified file attachments.
+// The SMTP provider streams the files content while sending the email, the API
+// providers read it in memory. ErrAttachmentsTooLarge is returned if the attachments
+// exceed the configured max size, the size is enforced while reading the files too.
+// ErrRateLimited is returned if the configured rate limit is exceeded
+func SendEmailWithFiles(addresses EmailAddresses, subject string, body EmailBody, files []FileAttachment,
+	attachments ...mail.File,
+) error {
+	if emailSender == nil {
+		return errors.New("smtp: not configured")
+	}
+	msg := &emailMessage{
+		from:        from,
+		to:          addresses.To,
+		cc:          addresses.Cc,
+		bcc:         addresses.Bcc,
+		replyTo:     addresses.ReplyTo,
+		subject:     subject,
+		body:        body.Content,
+		contentType: body.ContentType,
+		textBody:    body.Text,
+		attachments: attachments,
+	}
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	limiter, err := newAttachmentsLimiter(attachments, files)
+	if err != nil {
+		return err
+	}
+	if !allowSending() {
+		return ErrRateLimited
 	}
-	for _, attachment := range attachments {
-		email.Attach(&attachment)
+	if s, ok := emailSender.(streamingSender); ok {
+		return s.sendWithFiles(msg, files, limiter)
 	}
-	if email.Error != nil {
-		return fmt.Errorf("smtp: email error: %w", email.Error)
+	msg.attachments = append(make([]mail.File, 0, len(attachments)+len(files)), attachments...)
+	for idx := range files {
+		f, err := files[idx].load(limiter)
+		if err != nil {
+			return err
+		}
+		msg.attachments = append(msg.attachments, f)
 	}
-	return email.Send(smtpClient)
+	return emailSender.send(msg)
 }
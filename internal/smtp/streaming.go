@@ -0,0 +1,444 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// maximum line length for base64 encoded attachments as defined in RFC 2045
+	base64LineLength = 76
+)
+
+// streamingSender is implemented by the senders able to send file attachments
+// without buffering them in memory
+type streamingSender interface {
+	sendWithFiles(msg *emailMessage, files []FileAttachment, limiter *attachmentsLimiter) error
+}
+
+// sendWithFiles sends the message using net/smtp directly, go-simple-mail builds
+// the whole message in memory. The SMTP transaction is aborted, by closing the
+// connection before the end of the data, if reading an attachment fails
+func (s *smtpSender) sendWithFiles(msg *emailMessage, files []FileAttachment, limiter *attachmentsLimiter) error {
+	fromAddress := msg.from
+	if fromAddress == "" {
+		fromAddress = s.server.Username
+	}
+	sender, err := netmail.ParseAddress(fromAddress)
+	if err != nil {
+		return fmt.Errorf("smtp: invalid from address %q: %w", fromAddress, err)
+	}
+	recipients, err := parseAddresses(msg.getAllRecipients())
+	if err != nil {
+		return err
+	}
+	client, err := s.dial()
+	if err != nil {
+		return fmt.Errorf("smtp: unable to connect to %s:%d: %w", s.server.Host, s.server.Port, err)
+	}
+	defer client.Close()
+
+	if err := client.Mail(sender.Address); err != nil {
+		return fmt.Errorf("smtp: unable to set the sender %q: %w", sender.Address, err)
+	}
+	for _, rcpt := range recipients {
+		if err := client.Rcpt(rcpt.Address); err != nil {
+			return fmt.Errorf("smtp: unable to add the recipient %q: %w", rcpt.Address, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp: unable to send email: %w", err)
+	}
+	if err := writeMessage(w, msg, sender, files, limiter); err != nil {
+		// the data writer is not closed so the message is not delivered
+		return fmt.Errorf("smtp: unable to send email: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp: unable to send email: %w", err)
+	}
+	if err := client.Quit(); err != nil {
+		logger.Debug(logSender, "", "unable to close the SMTP session after sending the email: %v", err)
+	}
+	return nil
+}
+
+func (s *smtpSender) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(s.server.Host, strconv.Itoa(s.server.Port))
+	dialer := &net.Dialer{Timeout: s.server.ConnectTimeout}
+	tlsConfig := s.server.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: s.server.Host}
+	}
+	var conn net.Conn
+	var err error
+	if s.server.Encryption == mail.EncryptionSSLTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	client, err := smtp.NewClient(&idleTimeoutConn{Conn: conn, timeout: s.server.SendTimeout}, s.server.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	helo := s.server.Helo
+	if helo == "" {
+		helo = "localhost"
+	}
+	if err := client.Hello(helo); err != nil {
+		client.Close()
+		return nil, err
+	}
+	if s.server.Encryption == mail.EncryptionSTARTTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				client.Close()
+				return nil, err
+			}
+		}
+	}
+	if auth := s.getAuth(); auth != nil {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(auth); err != nil {
+				client.Close()
+				return nil, err
+			}
+		}
+	}
+	return client, nil
+}
+
+func (s *smtpSender) getAuth() smtp.Auth {
+	if s.server.Username == "" && s.server.Password == "" {
+		return nil
+	}
+	switch s.server.Authentication {
+	case mail.AuthPlain:
+		return &plainAuth{username: s.server.Username, password: s.server.Password}
+	case mail.AuthLogin:
+		return &loginAuth{username: s.server.Username, password: s.server.Password}
+	case mail.AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(s.server.Username, s.server.Password)
+	default:
+		return nil
+	}
+}
+
+// plainAuth implements the PLAIN authentication. Unlike net/smtp it allows
+// unencrypted connections, as go-simple-mail does
+type plainAuth struct {
+	username, password string
+}
+
+func (a *plainAuth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	return "PLAIN", []byte("\x00" + a.username + "\x00" + a.password), nil
+}
+
+func (a *plainAuth) Next(_ []byte, more bool) ([]byte, error) {
+	if more {
+		return nil, errors.New("unexpected server challenge")
+	}
+	return nil, nil
+}
+
+// loginAuth implements the LOGIN authentication
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", []byte(a.username), nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	challenge := strings.ToLower(string(fromServer))
+	switch {
+	case strings.Contains(challenge, "username"):
+		return []byte(a.username), nil
+	case strings.Contains(challenge, "password"):
+		return []byte(a.password), nil
+	default:
+		return nil, errors.New("unexpected server challenge")
+	}
+}
+
+// idleTimeoutConn extends the deadline before each read and write, so large
+// attachments can be sent while still detecting stalled connections
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout)) //nolint:errcheck
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	if c.timeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)) //nolint:errcheck
+	}
+	return c.Conn.Write(b)
+}
+
+func parseAddresses(addresses []string) ([]*netmail.Address, error) {
+	result := make([]*netmail.Address, 0, len(addresses))
+	for _, address := range addresses {
+		addr, err := netmail.ParseAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("smtp: invalid address %q: %w", address, err)
+		}
+		result = append(result, addr)
+	}
+	return result, nil
+}
+
+func formatAddresses(addresses []string) (string, error) {
+	parsed, err := parseAddresses(addresses)
+	if err != nil {
+		return "", err
+	}
+	result := make([]string, 0, len(parsed))
+	for _, addr := range parsed {
+		result = append(result, addr.String())
+	}
+	return strings.Join(result, ", "), nil
+}
+
+// writeMessage writes a multipart/mixed MIME message to w, the file attachments
+// are base64 encoded on the fly
+func writeMessage(w io.Writer, msg *emailMessage, sender *netmail.Address, files []FileAttachment,
+	limiter *attachmentsLimiter,
+) error {
+	mw := multipart.NewWriter(w)
+	if err := writeMessageHeaders(w, msg, sender, mw.Boundary()); err != nil {
+		return err
+	}
+	if err := writeMessageBody(mw, msg); err != nil {
+		return err
+	}
+	for idx := range msg.attachments {
+		attachment := &msg.attachments[idx]
+		data, err := getAttachmentContent(attachment)
+		if err != nil {
+			return err
+		}
+		part, err := createAttachmentPart(mw, getAttachmentName(attachment), getAttachmentMimeType(attachment))
+		if err != nil {
+			return err
+		}
+		if err := writeBase64(part, func(enc io.Writer) error {
+			_, err := enc.Write(data)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	for idx := range files {
+		file := &files[idx]
+		mimeType := mime.TypeByExtension(filepath.Ext(file.Name))
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		part, err := createAttachmentPart(mw, file.Name, mimeType)
+		if err != nil {
+			return err
+		}
+		if err := writeBase64(part, func(enc io.Writer) error {
+			return file.copyTo(enc, limiter)
+		}); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func writeMessageHeaders(w io.Writer, msg *emailMessage, sender *netmail.Address, boundary string) error {
+	var sb strings.Builder
+	writeHeader := func(key, value string) {
+		sb.WriteString(key)
+		sb.WriteString(": ")
+		sb.WriteString(value)
+		sb.WriteString("\r\n")
+	}
+	writeHeader("MIME-Version", "1.0")
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	domain := sender.Address[strings.LastIndex(sender.Address, "@")+1:]
+	writeHeader("Message-ID", fmt.Sprintf("<%s@%s>", util.GenerateUniqueID(), domain))
+	writeHeader("From", sender.String())
+	for _, h := range []struct {
+		key       string
+		addresses []string
+	}{
+		{"To", msg.to},
+		{"Cc", msg.cc},
+	} {
+		if len(h.addresses) == 0 {
+			continue
+		}
+		value, err := formatAddresses(h.addresses)
+		if err != nil {
+			return err
+		}
+		writeHeader(h.key, value)
+	}
+	if msg.replyTo != "" {
+		value, err := formatAddresses([]string{msg.replyTo})
+		if err != nil {
+			return err
+		}
+		writeHeader("Reply-To", value)
+	}
+	writeHeader("Subject", mime.QEncoding.Encode("UTF-8", msg.subject))
+	writeHeader("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": boundary}))
+	sb.WriteString("\r\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func writeMessageBody(mw *multipart.Writer, msg *emailMessage) error {
+	if !msg.hasAlternative() {
+		return writeTextPart(mw, msg.getMIMEContentType(), msg.body)
+	}
+	boundary := "alt-" + util.GenerateUniqueID()
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": boundary}))
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	alternative := multipart.NewWriter(part)
+	if err := alternative.SetBoundary(boundary); err != nil {
+		return err
+	}
+	// the preferred format must be the last one
+	if err := writeTextPart(alternative, "text/plain", msg.textBody); err != nil {
+		return err
+	}
+	if err := writeTextPart(alternative, "text/html", msg.body); err != nil {
+		return err
+	}
+	return alternative.Close()
+}
+
+func writeTextPart(mw *multipart.Writer, contentType, content string) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(contentType, map[string]string{"charset": "UTF-8"}))
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := io.WriteString(qp, content); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func createAttachmentPart(mw *multipart.Writer, name, mimeType string) (io.Writer, error) {
+	mediaType, params, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		mediaType = "application/octet-stream"
+		params = make(map[string]string)
+	}
+	params["name"] = name
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	header.Set("Content-Transfer-Encoding", "base64")
+	return mw.CreatePart(header)
+}
+
+// writeBase64 base64 encodes, to w, the content written by the write function
+func writeBase64(w io.Writer, write func(enc io.Writer) error) error {
+	lw := &lineBreaker{w: w}
+	enc := base64.NewEncoder(base64.StdEncoding, lw)
+	if err := write(enc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return lw.close()
+}
+
+// lineBreaker splits the written data in lines of base64LineLength bytes
+type lineBreaker struct {
+	w       io.Writer
+	lineLen int
+}
+
+func (l *lineBreaker) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := base64LineLength - l.lineLen
+		if n > len(b) {
+			n = len(b)
+		}
+		if _, err := l.w.Write(b[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		l.lineLen += n
+		b = b[n:]
+		if l.lineLen == base64LineLength {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return written, err
+			}
+			l.lineLen = 0
+		}
+	}
+	return written, nil
+}
+
+func (l *lineBreaker) close() error {
+	if l.lineLen > 0 {
+		_, err := io.WriteString(l.w, "\r\n")
+		l.lineLen = 0
+		return err
+	}
+	return nil
+}
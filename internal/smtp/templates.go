@@ -0,0 +1,487 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	templateExtHTML = ".html"
+	templateExtText = ".txt"
+	// MaxTemplateSize defines the maximum size for a custom email template
+	MaxTemplateSize = 1048576
+)
+
+var (
+	errCustomTemplatesDisabled = util.NewMethodDisabledError("custom email templates are disabled, configure a custom templates path")
+	templates                  = templatesRegistry{
+		builtin: make(map[string]emailTemplate),
+		custom:  make(map[string]emailTemplate),
+	}
+)
+
+// TemplateInfo describes an available email template
+type TemplateInfo struct {
+	Name string
+	// Language is empty for the default templates
+	Language string
+	Custom   bool
+}
+
+// emailTemplate is implemented by both html and text templates
+type emailTemplate interface {
+	Execute(wr io.Writer, data any) error
+}
+
+// templatesRegistry holds the built-in templates, loaded once from the templates path,
+// and the custom templates, loaded from the custom templates path, that can be added,
+// updated and reloaded at runtime. A custom template overrides a built-in one with the
+// same name. Localized templates are stored in a subdirectory named as the language,
+// for example "de/reset-password.html", and they are keyed the same way
+type templatesRegistry struct {
+	sync.RWMutex
+	customPath string
+	builtin    map[string]emailTemplate
+	custom     map[string]emailTemplate
+}
+
+func (r *templatesRegistry) setBuiltin(key string, tmpl emailTemplate) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.builtin[key] = tmpl
+}
+
+// get returns the template for the specified language, if not found the
+// base language and then the default template are searched.
+// For example for "pt-br" we search "pt-br", "pt" and finally the default
+func (r *templatesRegistry) get(name, lang string) (emailTemplate, bool) {
+	tmpl, _, _, ok := r.getWithSource(name, lang)
+	return tmpl, ok
+}
+
+// getWithSource is like get but it also returns the language of the found template
+// and if it is a custom one
+func (r *templatesRegistry) getWithSource(name, lang string) (emailTemplate, string, bool, bool) {
+	r.RLock()
+	defer r.RUnlock()
+
+	for _, l := range getLanguageFallbacks(lang) {
+		key := getTemplateKey(name, l)
+		if tmpl, ok := r.custom[key]; ok {
+			return tmpl, l, true, true
+		}
+		if tmpl, ok := r.builtin[key]; ok {
+			return tmpl, l, false, true
+		}
+	}
+	return nil, "", false, false
+}
+
+// getTextAlternative returns the plain text alternative for the specified HTML template,
+// it has the same name with the ".txt" extension, the same language and it must come
+// from the same source, so a custom HTML template is never paired with a built-in text one
+func (r *templatesRegistry) getTextAlternative(name, lang string, custom bool) (emailTemplate, bool) {
+	if !IsHTMLTemplate(name) {
+		return nil, false
+	}
+	r.RLock()
+	defer r.RUnlock()
+
+	key := getTemplateKey(strings.TrimSuffix(name, templateExtHTML)+templateExtText, lang)
+	var tmpl emailTemplate
+	var ok bool
+	if custom {
+		tmpl, ok = r.custom[key]
+	} else {
+		tmpl, ok = r.builtin[key]
+	}
+	return tmpl, ok
+}
+
+func (r *templatesRegistry) getTemplates() []TemplateInfo {
+	r.RLock()
+	defer r.RUnlock()
+
+	result := make([]TemplateInfo, 0, len(r.builtin)+len(r.custom))
+	for key := range r.builtin {
+		if _, ok := r.custom[key]; !ok {
+			result = append(result, newTemplateInfo(key, false))
+		}
+	}
+	for key := range r.custom {
+		result = append(result, newTemplateInfo(key, true))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Language == result[j].Language {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Language < result[j].Language
+	})
+	return result
+}
+
+func (r *templatesRegistry) getCustomPath() string {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.customPath
+}
+
+func (r *templatesRegistry) reload() error {
+	r.RLock()
+	customPath := r.customPath
+	r.RUnlock()
+
+	custom := make(map[string]emailTemplate)
+	if customPath != "" {
+		if err := loadCustomTemplates(customPath, "", custom); err != nil {
+			return err
+		}
+	}
+	r.Lock()
+	r.custom = custom
+	r.Unlock()
+
+	logger.Debug(logSender, "", "custom templates loaded from %#v: %d", customPath, len(custom))
+	return nil
+}
+
+func (r *templatesRegistry) save(name, lang string, content []byte) error {
+	customPath := r.getCustomPath()
+	if customPath == "" {
+		return errCustomTemplatesDisabled
+	}
+	lang, err := validateTemplateNameAndLanguage(name, lang)
+	if err != nil {
+		return err
+	}
+	if len(content) > MaxTemplateSize {
+		return util.NewValidationError(fmt.Sprintf("template size exceeds the maximum allowed size: %d", MaxTemplateSize))
+	}
+	tmpl, err := parseTemplate(name, content)
+	if err != nil {
+		return util.NewValidationError(err.Error())
+	}
+	dirPath := filepath.Join(customPath, lang)
+	if err := os.MkdirAll(dirPath, 0700); err != nil {
+		return fmt.Errorf("smtp: unable to create templates dir %#v: %w", dirPath, err)
+	}
+	tempFile, err := os.CreateTemp(dirPath, ".tmp-"+name)
+	if err != nil {
+		return fmt.Errorf("smtp: unable to create template file: %w", err)
+	}
+	tempName := tempFile.Name()
+	_, err = tempFile.Write(content)
+	if errClose := tempFile.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(tempName, filepath.Join(dirPath, name))
+	}
+	if err != nil {
+		os.Remove(tempName)
+		return fmt.Errorf("smtp: unable to save template %#v: %w", name, err)
+	}
+
+	r.Lock()
+	r.custom[getTemplateKey(name, lang)] = tmpl
+	r.Unlock()
+	return nil
+}
+
+func (r *templatesRegistry) delete(name, lang string) error {
+	customPath := r.getCustomPath()
+	if customPath == "" {
+		return errCustomTemplatesDisabled
+	}
+	lang, err := validateTemplateNameAndLanguage(name, lang)
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	key := getTemplateKey(name, lang)
+	if _, ok := r.custom[key]; !ok {
+		return util.NewRecordNotFoundError(fmt.Sprintf("custom template %#v does not exist", key))
+	}
+	if err := os.Remove(filepath.Join(customPath, lang, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("smtp: unable to delete template %#v: %w", key, err)
+	}
+	delete(r.custom, key)
+	return nil
+}
+
+// loadCustomTemplates loads the templates within dirPath, the subdirectories
+// named as a valid language are searched for localized templates
+func loadCustomTemplates(dirPath, lang string, result map[string]emailTemplate) error {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return fmt.Errorf("smtp: unable to read custom templates from %#v: %w", dirPath, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if lang == "" && util.IsLanguageValid(entry.Name()) {
+				if err := loadCustomTemplates(filepath.Join(dirPath, entry.Name()), entry.Name(), result); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !entry.Type().IsRegular() || validateTemplateName(entry.Name()) != nil {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dirPath, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("smtp: unable to read template %#v: %w", entry.Name(), err)
+		}
+		tmpl, err := parseTemplate(entry.Name(), content)
+		if err != nil {
+			return err
+		}
+		result[getTemplateKey(entry.Name(), lang)] = tmpl
+	}
+	return nil
+}
+
+// loadLocalizedBuiltinTemplates loads the localized versions of the specified
+// built-in templates from the language subdirectories of templatesPath
+func loadLocalizedBuiltinTemplates(templatesPath string, names ...string) {
+	entries, err := util.ReadTemplatesDir(templatesPath)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to read templates dir %#v: %v", templatesPath, err)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !util.IsLanguageValid(entry.Name()) {
+			continue
+		}
+		langPath := filepath.Join(templatesPath, entry.Name())
+		langEntries, err := util.ReadTemplatesDir(langPath)
+		if err != nil {
+			logger.Warn(logSender, "", "unable to read templates dir %#v: %v", langPath, err)
+			continue
+		}
+		for _, langEntry := range langEntries {
+			if langEntry.IsDir() || !util.Contains(names, langEntry.Name()) {
+				continue
+			}
+			tmpl, err := loadBuiltinTemplate(filepath.Join(langPath, langEntry.Name()))
+			if err != nil {
+				logger.Warn(logSender, "", "unable to load localized template %#v: %v", langEntry.Name(), err)
+				continue
+			}
+			templates.setBuiltin(getTemplateKey(langEntry.Name(), entry.Name()), tmpl)
+			logger.Debug(logSender, "", "localized template %#v loaded for language %#v", langEntry.Name(), entry.Name())
+		}
+	}
+}
+
+// loadBuiltinTemplate loads the built-in template at the specified path, HTML templates
+// are required, text templates are optional and an error is returned if they cannot be loaded
+func loadBuiltinTemplate(templatePath string) (emailTemplate, error) {
+	if IsHTMLTemplate(templatePath) {
+		return util.LoadTemplate(nil, templatePath), nil
+	}
+	content, err := util.ReadTemplateFile(templatePath)
+	if err != nil {
+		return nil, err
+	}
+	return parseTemplate(filepath.Base(templatePath), content)
+}
+
+func getTemplateKey(name, lang string) string {
+	if lang == "" {
+		return name
+	}
+	return path.Join(lang, name)
+}
+
+func newTemplateInfo(key string, custom bool) TemplateInfo {
+	lang, name := path.Split(key)
+	return TemplateInfo{
+		Name:     name,
+		Language: strings.TrimSuffix(lang, "/"),
+		Custom:   custom,
+	}
+}
+
+func getLanguageFallbacks(lang string) []string {
+	lang = util.NormalizeLanguage(lang)
+	if lang == "" {
+		return []string{""}
+	}
+	result := []string{lang}
+	if idx := strings.Index(lang, "-"); idx > 0 {
+		result = append(result, lang[:idx])
+	}
+	return append(result, "")
+}
+
+func validateTemplateNameAndLanguage(name, lang string) (string, error) {
+	if err := validateTemplateName(name); err != nil {
+		return "", err
+	}
+	lang = util.NormalizeLanguage(lang)
+	if lang != "" && !util.IsLanguageValid(lang) {
+		return "", util.NewValidationError(fmt.Sprintf("invalid template language %#v", lang))
+	}
+	return lang, nil
+}
+
+func validateTemplateName(name string) error {
+	ext := filepath.Ext(name)
+	if name == "" || strings.HasPrefix(name, ".") || filepath.Base(name) != name || strings.ContainsAny(name, `/\`) {
+		return util.NewValidationError(fmt.Sprintf("invalid template name %#v", name))
+	}
+	if ext != templateExtHTML && ext != templateExtText {
+		return util.NewValidationError(fmt.Sprintf("invalid template name %#v, supported extensions: %s, %s",
+			name, templateExtHTML, templateExtText))
+	}
+	return nil
+}
+
+// parseTemplate parses templates with the html extension as HTML templates
+// and all the other templates as text templates
+func parseTemplate(name string, content []byte) (emailTemplate, error) {
+	if IsHTMLTemplate(name) {
+		tmpl, err := htmltemplate.New(name).Parse(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("smtp: unable to parse template %#v: %w", name, err)
+		}
+		return tmpl, nil
+	}
+	tmpl, err := texttemplate.New(name).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("smtp: unable to parse template %#v: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// IsHTMLTemplate returns true if the template with the specified name
+// renders an HTML body
+func IsHTMLTemplate(name string) bool {
+	return filepath.Ext(name) == templateExtHTML
+}
+
+// RenderTemplate executes the default template with the specified name
+func RenderTemplate(buf *bytes.Buffer, name string, data any) error {
+	return RenderLocalizedTemplate(buf, name, "", data)
+}
+
+// RenderLocalizedTemplate executes the template with the specified name for the
+// specified language, the default template is used if there is no localized version
+func RenderLocalizedTemplate(buf *bytes.Buffer, name, lang string, data any) error {
+	if emailSender == nil {
+		return errors.New("smtp: not configured")
+	}
+	tmpl, ok := templates.get(name, lang)
+	if !ok {
+		return fmt.Errorf("smtp: template %#v not found", name)
+	}
+	return tmpl.Execute(buf, data)
+}
+
+// RenderLocalizedTemplateBody executes the template with the specified name for the
+// specified language and returns the resulting email body. For HTML templates, if a
+// plain text template with the same name, the ".txt" extension and the same language
+// exists, it is executed too and used as plain text alternative
+func RenderLocalizedTemplateBody(name, lang string, data any) (EmailBody, error) {
+	if emailSender == nil {
+		return EmailBody{}, errors.New("smtp: not configured")
+	}
+	tmpl, foundLang, isCustom, ok := templates.getWithSource(name, lang)
+	if !ok {
+		return EmailBody{}, fmt.Errorf("smtp: template %#v not found", name)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return EmailBody{}, err
+	}
+	if !IsHTMLTemplate(name) {
+		return EmailBody{Content: buf.String(), ContentType: EmailContentTypeTextPlain}, nil
+	}
+	body := EmailBody{Content: buf.String(), ContentType: EmailContentTypeTextHTML}
+	if textTmpl, ok := templates.getTextAlternative(name, foundLang, isCustom); ok {
+		buf.Reset()
+		if err := textTmpl.Execute(&buf, data); err != nil {
+			return EmailBody{}, err
+		}
+		body.Text = buf.String()
+	}
+	return body, nil
+}
+
+// GetTemplates returns all the available templates
+func GetTemplates() []TemplateInfo {
+	return templates.getTemplates()
+}
+
+// GetCustomTemplate returns the content of the custom template with the specified
+// name and language. An empty language means the default template
+func GetCustomTemplate(name, lang string) ([]byte, error) {
+	customPath := templates.getCustomPath()
+	if customPath == "" {
+		return nil, errCustomTemplatesDisabled
+	}
+	lang, err := validateTemplateNameAndLanguage(name, lang)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(filepath.Join(customPath, lang, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, util.NewRecordNotFoundError(fmt.Sprintf("custom template %#v does not exist",
+				getTemplateKey(name, lang)))
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+// SaveCustomTemplate validates and saves a custom template, the new template
+// is immediately available
+func SaveCustomTemplate(name, lang string, content []byte) error {
+	return templates.save(name, lang, content)
+}
+
+// DeleteCustomTemplate deletes the custom template with the specified name and language
+func DeleteCustomTemplate(name, lang string) error {
+	return templates.delete(name, lang)
+}
+
+// ReloadTemplates reloads the custom templates from disk
+func ReloadTemplates() error {
+	if emailSender == nil {
+		return nil
+	}
+	return templates.reload()
+}
@@ -0,0 +1,255 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+	"golang.org/x/time/rate"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	// maximum number of notification bodies included in a digest email,
+	// additional notifications are only counted
+	maxDigestBodies = 100
+)
+
+var (
+	// ErrRateLimited is returned if an email is not sent because the configured
+	// rate limit was exceeded
+	ErrRateLimited = errors.New("smtp: rate limit exceeded, email not sent")
+	rateLimiter    *rate.Limiter
+	digests        = newDigestManager(0)
+)
+
+func setThrottling(maxEmailsPerMinute, digestInterval int) {
+	if maxEmailsPerMinute > 0 {
+		rateLimiter = rate.NewLimiter(rate.Limit(float64(maxEmailsPerMinute)/60), maxEmailsPerMinute)
+	} else {
+		rateLimiter = nil
+	}
+	digests.stop()
+	digests = newDigestManager(time.Duration(digestInterval) * time.Second)
+}
+
+func allowSending() bool {
+	if rateLimiter == nil {
+		return true
+	}
+	return rateLimiter.Allow()
+}
+
+type pendingDigest struct {
+	addresses   EmailAddresses
+	subject     string
+	contentType EmailContentType
+	bodies      []string
+	textBodies  []string
+	count       int
+	timer       *time.Timer
+}
+
+func (d *pendingDigest) add(body EmailBody) {
+	d.count++
+	if len(d.bodies) < maxDigestBodies {
+		d.bodies = append(d.bodies, body.Content)
+		if body.Text != "" {
+			d.textBodies = append(d.textBodies, body.Text)
+		}
+	}
+}
+
+func (d *pendingDigest) getSubject() string {
+	return fmt.Sprintf("%s (%d notifications)", d.subject, d.count)
+}
+
+func (d *pendingDigest) getBody() EmailBody {
+	body := EmailBody{
+		ContentType: d.contentType,
+		Text:        d.joinTextBodies(d.textBodies),
+	}
+	if d.contentType == EmailContentTypeTextHTML {
+		var omitted string
+		if d.count > len(d.bodies) {
+			omitted = fmt.Sprintf("<p>%d more notifications were omitted</p>", d.count-len(d.bodies))
+		}
+		body.Content = strings.Join(d.bodies, "<hr>") + omitted
+	} else {
+		body.Content = d.joinTextBodies(d.bodies)
+	}
+	return body
+}
+
+func (d *pendingDigest) joinTextBodies(bodies []string) string {
+	if len(bodies) == 0 {
+		return ""
+	}
+	separator := "\n\n----------\n\n"
+	var omitted string
+	if d.count > len(bodies) {
+		omitted = fmt.Sprintf("%s%d more notifications were omitted", separator, d.count-len(bodies))
+	}
+	return strings.Join(bodies, separator) + omitted
+}
+
+func (d *pendingDigest) reset() {
+	d.bodies = nil
+	d.textBodies = nil
+	d.count = 0
+}
+
+// digestManager coalesces similar notifications, with the same recipients and
+// subject, sent within the configured interval into a single summary email
+type digestManager struct {
+	interval time.Duration
+	mu       sync.Mutex
+	pending  map[string]*pendingDigest
+}
+
+func newDigestManager(interval time.Duration) *digestManager {
+	return &digestManager{
+		interval: interval,
+		pending:  make(map[string]*pendingDigest),
+	}
+}
+
+func (m *digestManager) isEnabled() bool {
+	return m.interval > 0
+}
+
+func (m *digestManager) getKey(addresses EmailAddresses, subject string, body EmailBody) string {
+	var sb strings.Builder
+	for _, recipients := range [][]string{addresses.To, addresses.Cc, addresses.Bcc} {
+		sorted := make([]string, len(recipients))
+		copy(sorted, recipients)
+		sort.Strings(sorted)
+		sb.WriteString(strings.Join(sorted, ","))
+		sb.WriteString("|")
+	}
+	sb.WriteString(addresses.ReplyTo)
+	sb.WriteString("|")
+	sb.WriteString(subject)
+	sb.WriteString(fmt.Sprintf("|%d|%t", body.ContentType, body.Text != ""))
+	return sb.String()
+}
+
+func (m *digestManager) send(addresses EmailAddresses, subject string, body EmailBody) error {
+	key := m.getKey(addresses, subject, body)
+
+	m.mu.Lock()
+	if d, ok := m.pending[key]; ok {
+		d.add(body)
+		m.mu.Unlock()
+		return nil
+	}
+	d := &pendingDigest{
+		addresses:   addresses,
+		subject:     subject,
+		contentType: body.ContentType,
+	}
+	d.timer = time.AfterFunc(m.interval, func() {
+		m.flush(key)
+	})
+	m.pending[key] = d
+	m.mu.Unlock()
+
+	// the first notification is sent immediately, similar ones sent within the
+	// interval will be coalesced
+	err := SendEmailBody(addresses, subject, body)
+	if errors.Is(err, ErrRateLimited) {
+		m.mu.Lock()
+		d.add(body)
+		m.mu.Unlock()
+		return nil
+	}
+	return err
+}
+
+func (m *digestManager) flush(key string) {
+	m.mu.Lock()
+	d, ok := m.pending[key]
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
+	if d.count == 0 {
+		delete(m.pending, key)
+		m.mu.Unlock()
+		return
+	}
+	subject := d.getSubject()
+	body := d.getBody()
+	m.mu.Unlock()
+
+	err := SendEmailBody(d.addresses, subject, body)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pending[key]; !ok {
+		// the manager was stopped
+		return
+	}
+	if err == nil {
+		d.reset()
+	} else if !errors.Is(err, ErrRateLimited) {
+		logger.Warn(logSender, "", "unable to send digest email, subject %q: %v", subject, err)
+		d.reset()
+	}
+	d.timer.Reset(m.interval)
+}
+
+func (m *digestManager) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, d := range m.pending {
+		d.timer.Stop()
+		if d.count > 0 {
+			logger.Info(logSender, "", "discarding %d pending notifications for subject %q", d.count, d.subject)
+		}
+		delete(m.pending, key)
+	}
+}
+
+// SendNotification sends a notification email. If the digest mode is enabled,
+// similar notifications sent within the configured interval are coalesced into
+// a single summary email. Notifications with attachments are never coalesced
+func SendNotification(addresses EmailAddresses, subject string, body EmailBody, attachments ...mail.File) error {
+	if emailSender == nil {
+		return errors.New("smtp: not configured")
+	}
+	if !digests.isEnabled() || len(attachments) > 0 {
+		return SendEmailBody(addresses, subject, body, attachments...)
+	}
+	msg := &emailMessage{
+		to:          addresses.To,
+		cc:          addresses.Cc,
+		bcc:         addresses.Bcc,
+		contentType: body.ContentType,
+	}
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	return digests.send(addresses, subject, body)
+}
@@ -0,0 +1,169 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	defaultPushJob      = "sftpgo"
+	defaultPushInterval = 60
+	minPushInterval     = 5
+	instanceLabel       = "instance"
+)
+
+var (
+	pushTicker     *time.Ticker
+	pushDone       chan bool
+	labelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// PushConfig defines the configuration to periodically push the metrics to a
+// Prometheus Pushgateway. This is useful for nodes that cannot be scraped, for
+// example because they are behind a firewall
+type PushConfig struct {
+	// Pushgateway URL, for example "http://pushgateway:9091".
+	// Leave empty to disable metrics push
+	URL string `json:"url" mapstructure:"url"`
+	// The job name to use. Default: "sftpgo"
+	Job string `json:"job" mapstructure:"job"`
+	// Interval between pushes, in seconds. Default: 60
+	Interval int `json:"interval" mapstructure:"interval"`
+	// Grouping labels to add to the pushed metrics, each label must be in the form "name=value".
+	// If no "instance" label is defined the hostname is used
+	Labels []string `json:"labels" mapstructure:"labels"`
+	// Optional credentials for HTTP basic authentication
+	Username string `json:"username" mapstructure:"username"`
+	Password string `json:"password" mapstructure:"password"`
+	grouping map[string]string
+}
+
+// IsEnabled returns true if pushing metrics is enabled
+func (c *PushConfig) IsEnabled() bool {
+	return c.URL != ""
+}
+
+func (c *PushConfig) validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid metrics push URL %q: %w", c.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid metrics push URL %q: only http and https are supported", c.URL)
+	}
+	if c.Job == "" {
+		c.Job = defaultPushJob
+	}
+	if c.Interval <= 0 {
+		c.Interval = defaultPushInterval
+	}
+	if c.Interval < minPushInterval {
+		return fmt.Errorf("invalid metrics push interval %d, the minimum allowed is %d seconds", c.Interval, minPushInterval)
+	}
+	if c.Username == "" && c.Password != "" {
+		return errors.New("metrics push password set without a username")
+	}
+	return c.parseLabels()
+}
+
+func (c *PushConfig) parseLabels() error {
+	c.grouping = make(map[string]string)
+	for _, label := range c.Labels {
+		name, value, ok := strings.Cut(label, "=")
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		if !ok || value == "" {
+			return fmt.Errorf("invalid metrics push label %q, the expected format is \"name=value\"", label)
+		}
+		if !labelNameRegex.MatchString(name) || strings.HasPrefix(name, "__") || name == "job" {
+			return fmt.Errorf("invalid metrics push label name %q", name)
+		}
+		if _, ok := c.grouping[name]; ok {
+			return fmt.Errorf("duplicated metrics push label %q", name)
+		}
+		c.grouping[name] = value
+	}
+	if _, ok := c.grouping[instanceLabel]; !ok {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("unable to get the hostname for the %q label: %w", instanceLabel, err)
+		}
+		c.grouping[instanceLabel] = hostname
+	}
+	return nil
+}
+
+func (c *PushConfig) push() {
+	err := metric.PushToGateway(c.URL, c.Job, c.grouping, c.Username, c.Password, httpclient.GetHTTPClient())
+	if err != nil {
+		logger.Warn(logSender, "", "unable to push metrics to %q: %v", util.GetRedactedURL(c.URL), err)
+		return
+	}
+	logger.Debug(logSender, "", "metrics pushed to %q", util.GetRedactedURL(c.URL))
+}
+
+// StartMetricsPush validates the push configuration and, if enabled, starts
+// pushing the metrics periodically. Any previously started push is stopped
+func (c Conf) StartMetricsPush() error {
+	stopMetricsPush()
+	if !c.Push.IsEnabled() {
+		return nil
+	}
+	pushConf := c.Push
+	if err := pushConf.validate(); err != nil {
+		return err
+	}
+	logger.Info(logSender, "", "pushing metrics to %q every %d seconds, job %q, grouping labels %v",
+		util.GetRedactedURL(pushConf.URL), pushConf.Interval, pushConf.Job, pushConf.grouping)
+	startPushTicker(&pushConf)
+	return nil
+}
+
+// the ticker cannot be started/stopped from multiple goroutines
+func startPushTicker(c *PushConfig) {
+	pushTicker = time.NewTicker(time.Duration(c.Interval) * time.Second)
+	pushDone = make(chan bool)
+
+	go func() {
+		for {
+			select {
+			case <-pushDone:
+				return
+			case <-pushTicker.C:
+				c.push()
+			}
+		}
+	}()
+}
+
+func stopMetricsPush() {
+	if pushTicker != nil {
+		pushTicker.Stop()
+		pushDone <- true
+		pushTicker = nil
+	}
+}
@@ -79,6 +79,10 @@ type Conf struct {
 	TLSCipherSuites []string `json:"tls_cipher_suites" mapstructure:"tls_cipher_suites"`
 	// Defines the minimum TLS version. 13 means TLS 1.3, default is TLS 1.2
 	MinTLSVersion int `json:"min_tls_version" mapstructure:"min_tls_version"`
+	// Configuration to periodically push the metrics to a Prometheus Pushgateway
+	Push PushConfig `json:"push" mapstructure:"push"`
+	// Configuration for the metrics labeled by username, protocol and virtual folder
+	UserMetrics UserMetricsConfig `json:"user_metrics" mapstructure:"user_metrics"`
 }
 
 // ShouldBind returns true if there service must be started
@@ -95,7 +99,7 @@ func (c Conf) ShouldBind() bool {
 // Initialize configures and starts the telemetry server.
 func (c Conf) Initialize(configDir string) error {
 	var err error
-	logger.Info(logSender, "", "initializing telemetry server with config %+v", c)
+	logger.Info(logSender, "", "initializing telemetry server with config %+v", c.getRedacted())
 	authUserFile := getConfigPath(c.AuthUserFile, configDir)
 	httpAuth, err = common.NewBasicAuthProvider(authUserFile)
 	if err != nil {
@@ -139,6 +143,15 @@ func (c Conf) Initialize(configDir string) error {
 	return util.HTTPListenAndServe(httpServer, c.BindAddress, c.BindPort, false, logSender)
 }
 
+func (c Conf) getRedacted() Conf {
+	conf := c
+	conf.Push.URL = util.GetRedactedURL(c.Push.URL)
+	if conf.Push.Password != "" {
+		conf.Push.Password = "[redacted]"
+	}
+	return conf
+}
+
 // ReloadCertificateMgr reloads the certificate manager
 func ReloadCertificateMgr() error {
 	if certMgr != nil {
@@ -21,10 +21,15 @@ import (
 	"path/filepath"
 	"runtime"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
 )
 
 const (
@@ -168,3 +173,151 @@ func TestRouter(t *testing.T) {
 	err = os.Remove(authUserFile)
 	require.NoError(t, err)
 }
+
+func TestMetricsPush(t *testing.T) {
+	httpConfig := httpclient.Config{
+		Timeout: 5,
+	}
+	err := httpConfig.Initialize(".")
+	require.NoError(t, err)
+
+	var pushedPath string
+	var authUser, authPassword string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushedPath = r.URL.Path
+		authUser, authPassword, _ = r.BasicAuth()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := Conf{}
+	err = c.StartMetricsPush()
+	require.NoError(t, err)
+	require.Nil(t, pushTicker)
+
+	c.Push.URL = "ftp://127.0.0.1"
+	err = c.StartMetricsPush()
+	require.Error(t, err)
+	c.Push.URL = server.URL
+	c.Push.Interval = 1
+	err = c.StartMetricsPush()
+	require.Error(t, err)
+	c.Push.Interval = 0
+	c.Push.Password = "pwd"
+	err = c.StartMetricsPush()
+	require.Error(t, err)
+	c.Push.Username = "user"
+	for _, labels := range [][]string{{"invalid"}, {"a=b", "a=c"}, {"job=a"}, {"1a=b"}, {"__a=b"}, {"a="}} {
+		c.Push.Labels = labels
+		err = c.StartMetricsPush()
+		require.Error(t, err, "labels %v should be invalid", labels)
+	}
+	c.Push.Labels = []string{" region = eu "}
+	err = c.StartMetricsPush()
+	require.NoError(t, err)
+	require.NotNil(t, pushTicker)
+	// restarting stops the previous ticker
+	err = c.StartMetricsPush()
+	require.NoError(t, err)
+	stopMetricsPush()
+	require.Nil(t, pushTicker)
+
+	pushConf := c.Push
+	err = pushConf.validate()
+	require.NoError(t, err)
+	require.Equal(t, defaultPushJob, pushConf.Job)
+	require.Equal(t, defaultPushInterval, pushConf.Interval)
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"region": "eu", instanceLabel: hostname}, pushConf.grouping)
+	pushConf.push()
+	require.Contains(t, pushedPath, "/metrics/job/sftpgo/")
+	require.Contains(t, pushedPath, "/region/eu")
+	require.Equal(t, "user", authUser)
+	require.Equal(t, "pwd", authPassword)
+
+	pushConf.Labels = []string{"instance=node1"}
+	err = pushConf.validate()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{instanceLabel: "node1"}, pushConf.grouping)
+	pushConf.push()
+	require.Equal(t, "/metrics/job/sftpgo/instance/node1", pushedPath)
+	// push errors are only logged
+	pushConf.URL = "http://127.0.0.1:1"
+	pushConf.push()
+
+	redacted := c.getRedacted()
+	require.Equal(t, "[redacted]", redacted.Push.Password)
+	require.Equal(t, "pwd", c.Push.Password)
+}
+
+func getMetricLabels(t *testing.T, name string) []map[string]string {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	var result []map[string]string
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			result = append(result, labels)
+		}
+	}
+	return result
+}
+
+func TestUserMetrics(t *testing.T) {
+	c := Conf{}
+	err := c.InitializeUserMetrics()
+	require.NoError(t, err)
+	assert.False(t, metric.IsUserMetricsEnabled())
+
+	c.UserMetrics.Enabled = true
+	c.UserMetrics.MaxUsers = -1
+	err = c.InitializeUserMetrics()
+	require.Error(t, err)
+	c.UserMetrics.MaxUsers = 1
+	c.UserMetrics.MaxFolders = -1
+	err = c.InitializeUserMetrics()
+	require.Error(t, err)
+	c.UserMetrics.MaxFolders = 0
+	err = c.InitializeUserMetrics()
+	require.NoError(t, err)
+	assert.True(t, metric.IsUserMetricsEnabled())
+
+	metric.AddUserConnection("user1", common.ProtocolSFTP)
+	metric.AddUserConnection("user2", common.ProtocolSFTP)
+	metric.RemoveUserConnection("user2", common.ProtocolSFTP)
+	metric.UserTransferCompleted("user1", common.ProtocolSFTP, "folder1", 0, 100, 0, nil)
+	metric.AddUserOperation("user3", common.ProtocolFTP, "", "mkdir", os.ErrPermission)
+	metric.AddLoginDuration(common.ProtocolSSH, "password", 10*time.Millisecond, nil)
+	metric.AddTransferThroughput(common.ProtocolSFTP, 1, 1024*1024, time.Second)
+
+	labels := getMetricLabels(t, "sftpgo_user_active_connections")
+	require.Len(t, labels, 2)
+	usernames := []string{labels[0]["username"], labels[1]["username"]}
+	assert.Contains(t, usernames, "user1")
+	assert.Contains(t, usernames, "__other__")
+	labels = getMetricLabels(t, "sftpgo_user_upload_size")
+	require.Len(t, labels, 1)
+	assert.Equal(t, "user1", labels[0]["username"])
+	assert.Equal(t, "folder1", labels[0]["folder"])
+	labels = getMetricLabels(t, "sftpgo_user_operation_errors_total")
+	require.Len(t, labels, 1)
+	assert.Equal(t, "__other__", labels[0]["username"])
+	assert.Equal(t, "mkdir", labels[0]["operation"])
+	assert.NotEmpty(t, getMetricLabels(t, "sftpgo_login_duration_seconds"))
+	assert.NotEmpty(t, getMetricLabels(t, "sftpgo_transfer_throughput_bytes_per_second"))
+
+	c.UserMetrics.Enabled = false
+	err = c.InitializeUserMetrics()
+	require.NoError(t, err)
+	assert.False(t, metric.IsUserMetricsEnabled())
+	assert.Empty(t, getMetricLabels(t, "sftpgo_user_active_connections"))
+	metric.AddUserConnection("user1", common.ProtocolSFTP)
+	assert.Empty(t, getMetricLabels(t, "sftpgo_user_active_connections"))
+}
@@ -0,0 +1,72 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package telemetry
+
+import (
+	"fmt"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+)
+
+const (
+	defaultMaxUsers   = 100
+	defaultMaxFolders = 100
+)
+
+// UserMetricsConfig defines the configuration for the metrics labeled by
+// username, protocol and virtual folder. Each distinct label value creates
+// new time series, so the number of distinct usernames and folder names is
+// limited, the ones exceeding the limits are reported as "__other__"
+type UserMetricsConfig struct {
+	// Set to true to enable the labeled metrics
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Maximum number of distinct usernames. Default: 100
+	MaxUsers int `json:"max_users" mapstructure:"max_users"`
+	// Maximum number of distinct virtual folder names. Default: 100
+	MaxFolders int `json:"max_folders" mapstructure:"max_folders"`
+}
+
+func (c *UserMetricsConfig) validate() error {
+	if c.MaxUsers == 0 {
+		c.MaxUsers = defaultMaxUsers
+	}
+	if c.MaxFolders == 0 {
+		c.MaxFolders = defaultMaxFolders
+	}
+	if c.MaxUsers < 0 {
+		return fmt.Errorf("invalid max users for user metrics: %d", c.MaxUsers)
+	}
+	if c.MaxFolders < 0 {
+		return fmt.Errorf("invalid max folders for user metrics: %d", c.MaxFolders)
+	}
+	return nil
+}
+
+// InitializeUserMetrics validates the configuration and enables or disables
+// the metrics labeled by username, protocol and virtual folder
+func (c Conf) InitializeUserMetrics() error {
+	if !c.UserMetrics.Enabled {
+		metric.SetUserMetrics(false, 0, 0)
+		return nil
+	}
+	conf := c.UserMetrics
+	if err := conf.validate(); err != nil {
+		return err
+	}
+	metric.SetUserMetrics(true, conf.MaxUsers, conf.MaxFolders)
+	logger.Info(logSender, "", "user metrics enabled, max users: %d, max folders: %d", conf.MaxUsers, conf.MaxFolders)
+	return nil
+}
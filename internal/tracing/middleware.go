@@ -0,0 +1,61 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// HTTPMiddleware returns a middleware that creates a server span for each
+// request. If getRoute is not nil it is called after the request is served
+// to get a low cardinality route to use in the span name
+func HTTPMiddleware(protocol string, getRoute func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !IsEnabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, span := Start(Extract(r.Context(), r.Header), fmt.Sprintf("%s %s", protocol, r.Method), SpanKindServer)
+			defer span.End()
+
+			span.SetAttribute("http.method", r.Method)
+			span.SetAttribute("http.target", r.URL.Path)
+			span.SetAttribute("net.peer.addr", r.RemoteAddr)
+			span.SetAttribute("sftpgo.protocol", protocol)
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			r = r.WithContext(ctx)
+
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.SetAttribute("http.status_code", status)
+			if status >= http.StatusInternalServerError {
+				span.SetError(fmt.Errorf("%d %s", status, http.StatusText(status)))
+			}
+			if getRoute != nil {
+				if route := getRoute(r); route != "" {
+					span.SetName(fmt.Sprintf("%s %s", r.Method, route))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package tracing provides distributed tracing using OpenTelemetry. The spans
+// are exported to an OpenTelemetry collector using the OTLP/HTTP protocol and
+// the trace context is propagated using the W3C Trace Context and Baggage
+// headers
+package tracing
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	logSender             = "tracing"
+	instrumentationName   = "github.com/drakkan/sftpgo/v2"
+	tracesPath            = "/v1/traces"
+	defaultServiceName    = "sftpgo"
+	defaultExportInterval = 5
+	defaultTimeout        = 10
+	maxQueueSize          = 4096
+	maxBatchSize          = 512
+	// TraceParentHeader is the W3C header used to propagate the trace context
+	TraceParentHeader = "traceparent"
+	// TraceParentEnvVar is the environment variable used to propagate the
+	// trace context to the external commands
+	TraceParentEnvVar = "TRACEPARENT"
+)
+
+// Span kinds, as defined in the OpenTelemetry specification
+const (
+	SpanKindInternal = int(trace.SpanKindInternal)
+	SpanKindServer   = int(trace.SpanKindServer)
+	SpanKindClient   = int(trace.SpanKindClient)
+)
+
+var (
+	activeProvider atomic.Pointer[tracerProvider]
+	propagator     = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+)
+
+func init() {
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn(logSender, "", "%v", err)
+	}))
+}
+
+type tracerProvider struct {
+	*sdktrace.TracerProvider
+	tracer  trace.Tracer
+	timeout time.Duration
+}
+
+// Config defines the tracing configuration
+type Config struct {
+	// OTLP/HTTP collector endpoint, for example "http://127.0.0.1:4318".
+	// The spans are sent to the "/v1/traces" path. Leave empty to disable tracing
+	Endpoint string `json:"endpoint" mapstructure:"endpoint"`
+	// Service name to add to the exported spans. Default: "sftpgo"
+	ServiceName string `json:"service_name" mapstructure:"service_name"`
+	// Ratio of the new traces to sample, from 0 to 1. Traces started by a
+	// client sending the "traceparent" header follow the client decision.
+	// Default: 1, all the traces are sampled
+	SampleRatio float64 `json:"sample_ratio" mapstructure:"sample_ratio"`
+	// Additional headers to send to the collector, for example for
+	// authentication. Each header must be in the form "name: value"
+	Headers []string `json:"headers" mapstructure:"headers"`
+	// Interval between exports, as seconds. Default: 5
+	ExportInterval int `json:"export_interval" mapstructure:"export_interval"`
+	// Timeout for the export requests, as seconds. Default: 10
+	Timeout int `json:"timeout" mapstructure:"timeout"`
+	// Set to true to skip the TLS certificate validation for the collector
+	SkipTLSVerify bool `json:"skip_tls_verify" mapstructure:"skip_tls_verify"`
+	headers       map[string]string
+}
+
+// IsEnabled returns true if tracing is enabled
+func (c *Config) IsEnabled() bool {
+	return c.Endpoint != ""
+}
+
+func (c *Config) validate() error {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid tracing endpoint %q: %w", c.Endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid tracing endpoint %q: only http and https are supported", c.Endpoint)
+	}
+	if c.ServiceName == "" {
+		c.ServiceName = defaultServiceName
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("invalid tracing sample ratio %v, it must be between 0 and 1", c.SampleRatio)
+	}
+	if c.ExportInterval <= 0 {
+		c.ExportInterval = defaultExportInterval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	c.headers = make(map[string]string)
+	for _, header := range c.Headers {
+		name, value, ok := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		if !ok || name == "" || value == "" {
+			return fmt.Errorf("invalid tracing header %q, the expected format is \"name: value\"", header)
+		}
+		c.headers[http.CanonicalHeaderKey(name)] = value
+	}
+	return nil
+}
+
+func (c *Config) getExporterOptions() []otlptracehttp.Option {
+	u, _ := url.Parse(c.Endpoint)
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + tracesPath),
+		otlptracehttp.WithTimeout(time.Duration(c.Timeout) * time.Second),
+	}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	} else {
+		options = append(options, otlptracehttp.WithTLSClientConfig(&tls.Config{
+			InsecureSkipVerify: c.SkipTLSVerify, //nolint:gosec
+			MinVersion:         tls.VersionTLS12,
+		}))
+	}
+	if len(c.headers) > 0 {
+		options = append(options, otlptracehttp.WithHeaders(c.headers))
+	}
+	return options
+}
+
+// Initialize validates the configuration and, if tracing is enabled, starts
+// exporting the spans. Any previously started exporter is stopped
+func (c *Config) Initialize() error {
+	if !c.IsEnabled() {
+		Shutdown()
+		return nil
+	}
+	conf := *c
+	if err := conf.validate(); err != nil {
+		return err
+	}
+	exporter, err := otlptracehttp.New(context.Background(), conf.getExporterOptions()...)
+	if err != nil {
+		return fmt.Errorf("unable to create the tracing exporter: %w", err)
+	}
+	res, err := resource.New(context.Background(),
+		resource.WithHost(),
+		resource.WithAttributes(
+			semconv.ServiceName(conf.ServiceName),
+			semconv.ServiceVersion(version.Get().Version),
+		),
+	)
+	if err != nil {
+		return fmt.Errorf("unable to create the tracing resource: %w", err)
+	}
+	timeout := time.Duration(conf.Timeout) * time.Second
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter,
+			sdktrace.WithBatchTimeout(time.Duration(conf.ExportInterval)*time.Second),
+			sdktrace.WithExportTimeout(timeout),
+			sdktrace.WithMaxQueueSize(maxQueueSize),
+			sdktrace.WithMaxExportBatchSize(maxBatchSize),
+		),
+		sdktrace.WithResource(res),
+		// traces started by a client sending the trace context follow the client decision
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.SampleRatio))),
+	)
+	p := &tracerProvider{
+		TracerProvider: provider,
+		tracer:         provider.Tracer(instrumentationName, trace.WithInstrumentationVersion(version.Get().Version)),
+		timeout:        timeout,
+	}
+	if prev := activeProvider.Swap(p); prev != nil {
+		prev.stop()
+	}
+	logger.Info(logSender, "", "tracing enabled, endpoint %q, service name %q, sample ratio %v",
+		conf.Endpoint, conf.ServiceName, conf.SampleRatio)
+	return nil
+}
+
+// stop exports the pending spans and releases the provider resources
+func (p *tracerProvider) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	if err := p.Shutdown(ctx); err != nil {
+		logger.Warn(logSender, "", "unable to stop the tracer provider: %v", err)
+	}
+}
+
+// Shutdown exports the pending spans and stops tracing
+func Shutdown() {
+	if p := activeProvider.Swap(nil); p != nil {
+		p.stop()
+	}
+}
+
+// IsEnabled returns true if the spans are exported
+func IsEnabled() bool {
+	return activeProvider.Load() != nil
+}
+
+// Span represents an operation within a trace. A nil Span is valid and
+// all its methods are no-op, so callers do not need to check if tracing
+// is enabled
+type Span struct {
+	span trace.Span
+}
+
+// SetName updates the span name
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.span.SetName(name)
+}
+
+// SetAttribute adds an attribute to the span. Supported values are
+// strings, booleans, integers and floats, other types are converted to
+// strings
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(getAttribute(key, value))
+}
+
+// SetError marks the span as failed. Nil errors are ignored
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End completes the span and queues it for export.
+// Calling End more than once has no effect
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
+
+// EndWithError marks the span as failed, if err is not nil, and ends it
+func (s *Span) EndWithError(err error) {
+	s.SetError(err)
+	s.End()
+}
+
+// TraceParent returns the W3C traceparent value for the span
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return GetTraceParent(trace.ContextWithSpan(context.Background(), s.span))
+}
+
+func getAttribute(key string, value any) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int32:
+		return attribute.Int64(key, int64(v))
+	case int64:
+		return attribute.Int64(key, v)
+	case uint32:
+		return attribute.Int64(key, int64(v))
+	case uint64:
+		return attribute.Int64(key, int64(v))
+	case float32:
+		return attribute.Float64(key, float64(v))
+	case float64:
+		return attribute.Float64(key, v)
+	default:
+		return attribute.String(key, fmt.Sprintf("%v", v))
+	}
+}
+
+// Start creates a new span as child of the span within the given context, if
+// any. The returned context contains the new span. If tracing is disabled the
+// returned span is nil and the given context is returned unchanged
+func Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	p := activeProvider.Load()
+	if p == nil {
+		return ctx, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := p.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKind(kind)))
+	return ctx, &Span{span: span}
+}
+
+// Extract returns a context containing the trace context and the baggage sent
+// by the client, if any
+func Extract(ctx context.Context, header http.Header) context.Context {
+	if !IsEnabled() {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Inject adds the trace context and baggage headers for the span within the
+// given context, if any
+func Inject(ctx context.Context, header http.Header) {
+	if ctx == nil {
+		return
+	}
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// GetTraceParent returns the W3C traceparent value for the span within the
+// given context or an empty string if the context has no span
+func GetTraceParent(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get(TraceParentHeader)
+}
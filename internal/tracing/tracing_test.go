@@ -0,0 +1,323 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/baggage"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+type testCollector struct {
+	mu      sync.Mutex
+	spans   []*tracepb.Span
+	headers http.Header
+	server  *httptest.Server
+}
+
+func newTestCollector() *testCollector {
+	c := &testCollector{}
+	c.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != tracesPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var req collectortrace.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.headers = r.Header.Clone()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+	}))
+	return c
+}
+
+func (c *testCollector) getSpans() []*tracepb.Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.spans
+}
+
+func getSpanAttribute(span *tracepb.Span, key string) *commonpb.AnyValue {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return nil
+}
+
+func TestConfigValidation(t *testing.T) {
+	c := Config{}
+	assert.False(t, c.IsEnabled())
+	require.NoError(t, c.Initialize())
+	assert.False(t, IsEnabled())
+
+	c.Endpoint = "ftp://127.0.0.1"
+	assert.Error(t, c.Initialize())
+	c.Endpoint = "http://127.0.0.1:4318"
+	c.SampleRatio = 1.5
+	assert.Error(t, c.Initialize())
+	c.SampleRatio = -0.1
+	assert.Error(t, c.Initialize())
+	c.SampleRatio = 0.5
+	c.Headers = []string{"Authorization"}
+	assert.Error(t, c.Initialize())
+	c.Headers = []string{"authorization: Bearer token"}
+	err := c.validate()
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer token", c.headers["Authorization"])
+	assert.Equal(t, defaultServiceName, c.ServiceName)
+	assert.Equal(t, defaultExportInterval, c.ExportInterval)
+	assert.Equal(t, defaultTimeout, c.Timeout)
+	assert.False(t, IsEnabled())
+}
+
+func TestDisabled(t *testing.T) {
+	Shutdown()
+	ctx, span := Start(context.Background(), "test", SpanKindInternal)
+	assert.Nil(t, span)
+	assert.Equal(t, context.Background(), ctx)
+	// the methods of a nil span must not panic
+	span.SetName("name")
+	span.SetAttribute("key", "value")
+	span.EndWithError(errors.New("test error"))
+	assert.Empty(t, span.TraceParent())
+	assert.Empty(t, GetTraceParent(ctx))
+	header := http.Header{}
+	header.Set(TraceParentHeader, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	assert.Equal(t, ctx, Extract(ctx, header))
+}
+
+func TestTraceParent(t *testing.T) {
+	collector := newTestCollector()
+	defer collector.server.Close()
+
+	c := Config{
+		Endpoint:    collector.server.URL,
+		SampleRatio: 1,
+	}
+	err := c.Initialize()
+	require.NoError(t, err)
+	defer Shutdown()
+
+	value := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	header := http.Header{}
+	header.Set(TraceParentHeader, value)
+	assert.Equal(t, value, GetTraceParent(Extract(context.Background(), header)))
+	value = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00"
+	header.Set(TraceParentHeader, value)
+	assert.Equal(t, value, GetTraceParent(Extract(context.Background(), header)))
+	// future versions can have additional fields
+	header.Set(TraceParentHeader, "01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra")
+	assert.NotEmpty(t, GetTraceParent(Extract(context.Background(), header)))
+
+	for _, invalid := range []string{
+		"",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c8031-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319z-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b716920333z-01",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-zz",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01",
+	} {
+		header.Set(TraceParentHeader, invalid)
+		assert.Empty(t, GetTraceParent(Extract(context.Background(), header)), invalid)
+	}
+}
+
+func TestExport(t *testing.T) {
+	collector := newTestCollector()
+	defer collector.server.Close()
+
+	c := Config{
+		Endpoint:    collector.server.URL,
+		SampleRatio: 1,
+		Headers:     []string{"X-Api-Key: secret"},
+	}
+	err := c.Initialize()
+	require.NoError(t, err)
+	require.True(t, IsEnabled())
+
+	header := http.Header{}
+	header.Set(TraceParentHeader, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	header.Set("baggage", "tenant=acme")
+	ctx, parent := Start(Extract(context.Background(), header), "parent", SpanKindServer)
+	require.NotNil(t, parent)
+	parent.SetAttribute("str", "value")
+	parent.SetAttribute("bool", true)
+	parent.SetAttribute("int", 10)
+	parent.SetAttribute("int64", int64(20))
+	parent.SetAttribute("float", 1.5)
+	parent.SetAttribute("other", []string{"a"})
+	parent.SetAttribute("str", "updated")
+	assert.Equal(t, parent.TraceParent(), GetTraceParent(ctx))
+
+	_, child := Start(ctx, "child", SpanKindClient)
+	require.NotNil(t, child)
+	child.EndWithError(errors.New("child error"))
+	child.End()
+	parent.End()
+
+	outHeader := http.Header{}
+	Inject(ctx, outHeader)
+	assert.Equal(t, parent.TraceParent(), outHeader.Get(TraceParentHeader))
+	assert.Equal(t, "tenant=acme", outHeader.Get("baggage"))
+	assert.Equal(t, "acme", baggage.FromContext(ctx).Member("tenant").Value())
+
+	Shutdown()
+	assert.False(t, IsEnabled())
+
+	spans := collector.getSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "secret", collector.headers.Get("X-Api-Key"))
+	childSpan := spans[0]
+	parentSpan := spans[1]
+	assert.Equal(t, "child", childSpan.Name)
+	assert.Equal(t, SpanKindClient, int(childSpan.Kind))
+	assert.Equal(t, parentSpan.SpanId, childSpan.ParentSpanId)
+	assert.Equal(t, parentSpan.TraceId, childSpan.TraceId)
+	require.NotNil(t, childSpan.Status)
+	assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, childSpan.Status.Code)
+	assert.Equal(t, "child error", childSpan.Status.Message)
+
+	assert.Equal(t, "parent", parentSpan.Name)
+	assert.Equal(t, SpanKindServer, int(parentSpan.Kind))
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", hex.EncodeToString(parentSpan.TraceId))
+	assert.Equal(t, "b7ad6b7169203331", hex.EncodeToString(parentSpan.ParentSpanId))
+	assert.Equal(t, tracepb.Status_STATUS_CODE_UNSET, parentSpan.GetStatus().GetCode())
+	require.Len(t, parentSpan.Attributes, 6)
+	assert.Equal(t, "updated", getSpanAttribute(parentSpan, "str").GetStringValue())
+	assert.True(t, getSpanAttribute(parentSpan, "bool").GetBoolValue())
+	assert.Equal(t, int64(10), getSpanAttribute(parentSpan, "int").GetIntValue())
+	assert.Equal(t, int64(20), getSpanAttribute(parentSpan, "int64").GetIntValue())
+	assert.Equal(t, 1.5, getSpanAttribute(parentSpan, "float").GetDoubleValue())
+	assert.Equal(t, "[a]", getSpanAttribute(parentSpan, "other").GetStringValue())
+}
+
+func TestSampling(t *testing.T) {
+	collector := newTestCollector()
+	defer collector.server.Close()
+
+	c := Config{
+		Endpoint:    collector.server.URL,
+		SampleRatio: 0,
+	}
+	err := c.Initialize()
+	require.NoError(t, err)
+
+	ctx, span := Start(context.Background(), "not sampled", SpanKindInternal)
+	require.NotNil(t, span)
+	assert.Contains(t, span.TraceParent(), "-00")
+	_, child := Start(ctx, "not sampled child", SpanKindInternal)
+	child.End()
+	span.End()
+	// the client decision is honored
+	header := http.Header{}
+	header.Set(TraceParentHeader, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	_, span = Start(Extract(context.Background(), header), "sampled", SpanKindServer)
+	span.End()
+
+	Shutdown()
+	spans := collector.getSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "sampled", spans[0].Name)
+}
+
+func TestExportErrors(t *testing.T) {
+	collector := newTestCollector()
+	collector.server.Close()
+
+	c := Config{
+		Endpoint:    collector.server.URL,
+		SampleRatio: 1,
+		Timeout:     1,
+	}
+	err := c.Initialize()
+	require.NoError(t, err)
+	for i := 0; i < maxQueueSize+10; i++ {
+		_, span := Start(context.Background(), "span", SpanKindInternal)
+		span.End()
+	}
+	// the export errors must not block the shutdown beyond the timeout
+	start := time.Now()
+	Shutdown()
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.False(t, IsEnabled())
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	collector := newTestCollector()
+	defer collector.server.Close()
+
+	var traceParent string
+	handler := HTTPMiddleware("HTTP", func(r *http.Request) string {
+		return "/api/{id}"
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParent = GetTraceParent(r.Context())
+		if r.URL.Path == "/api/error" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	// tracing disabled
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/1", nil)
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, traceParent)
+
+	c := Config{
+		Endpoint:    collector.server.URL,
+		SampleRatio: 1,
+	}
+	err := c.Initialize()
+	require.NoError(t, err)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEmpty(t, traceParent)
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/error", nil)
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+
+	Shutdown()
+	spans := collector.getSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "GET /api/{id}", spans[0].Name)
+	assert.Equal(t, SpanKindServer, int(spans[0].Kind))
+	assert.Equal(t, tracepb.Status_STATUS_CODE_UNSET, spans[0].GetStatus().GetCode())
+	assert.Equal(t, int64(http.StatusOK), getSpanAttribute(spans[0], "http.status_code").GetIntValue())
+	assert.Equal(t, "POST /api/{id}", spans[1].Name)
+	assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, spans[1].GetStatus().GetCode())
+}
@@ -0,0 +1,116 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Supported metadata value types
+const (
+	MetadataTypeString  = "string"
+	MetadataTypeNumber  = "number"
+	MetadataTypeBoolean = "boolean"
+)
+
+const (
+	// MaxMetadataEntries defines the maximum number of metadata entries for an object
+	MaxMetadataEntries   = 50
+	maxMetadataKeyLength = 64
+	maxMetadataValueSize = 1024
+)
+
+var (
+	// MetadataTypes defines the supported metadata value types
+	MetadataTypes    = []string{MetadataTypeString, MetadataTypeNumber, MetadataTypeBoolean}
+	metadataKeyRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+)
+
+// MetadataValue defines a custom metadata value. The value is always stored
+// as string, the type is an hint for the external systems and it is used to
+// validate the value
+type MetadataValue struct {
+	// Value type: "string", "number" or "boolean". Empty means "string"
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value"`
+}
+
+// Metadata defines free-form key/value metadata to attach to an object,
+// for example to store external system IDs
+type Metadata map[string]MetadataValue
+
+// Validate validates and normalizes the metadata entries
+func (m Metadata) Validate() error {
+	if len(m) > MaxMetadataEntries {
+		return NewValidationError(fmt.Sprintf("too many metadata entries: %d, max allowed: %d", len(m), MaxMetadataEntries))
+	}
+	for k, v := range m {
+		if len(k) > maxMetadataKeyLength || !metadataKeyRegex.MatchString(k) {
+			return NewValidationError(fmt.Sprintf("invalid metadata key %q, it must start with a letter and contain "+
+				"only letters, numbers and underscores, max length: %d", k, maxMetadataKeyLength))
+		}
+		v.Type = strings.ToLower(strings.TrimSpace(v.Type))
+		if v.Type == "" {
+			v.Type = MetadataTypeString
+		}
+		if len(v.Value) > maxMetadataValueSize {
+			return NewValidationError(fmt.Sprintf("metadata %q: value too long, max allowed: %d", k, maxMetadataValueSize))
+		}
+		switch v.Type {
+		case MetadataTypeString:
+		case MetadataTypeNumber:
+			v.Value = strings.TrimSpace(v.Value)
+			if _, err := strconv.ParseFloat(v.Value, 64); err != nil {
+				return NewValidationError(fmt.Sprintf("metadata %q: invalid number %q", k, v.Value))
+			}
+		case MetadataTypeBoolean:
+			val, err := strconv.ParseBool(strings.TrimSpace(v.Value))
+			if err != nil {
+				return NewValidationError(fmt.Sprintf("metadata %q: invalid boolean %q", k, v.Value))
+			}
+			v.Value = strconv.FormatBool(val)
+		default:
+			return NewValidationError(fmt.Sprintf("metadata %q: invalid type %q", k, v.Type))
+		}
+		m[k] = v
+	}
+	return nil
+}
+
+// GetKeys returns the metadata keys sorted alphabetically
+func (m Metadata) GetKeys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetACopy returns a copy
+func (m Metadata) GetACopy() Metadata {
+	if len(m) == 0 {
+		return nil
+	}
+	result := make(Metadata, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
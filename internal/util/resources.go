@@ -19,6 +19,7 @@ package util
 
 import (
 	"html/template"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -80,3 +81,13 @@ func LoadTemplate(base *template.Template, paths ...string) *template.Template {
 	}
 	return t
 }
+
+// ReadTemplatesDir returns the entries of the specified templates directory
+func ReadTemplatesDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// ReadTemplateFile returns the content of the specified template file
+func ReadTemplateFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
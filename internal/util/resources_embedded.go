@@ -19,6 +19,7 @@ package util
 
 import (
 	"html/template"
+	"io/fs"
 	"os"
 
 	"github.com/drakkan/sftpgo/v2/internal/bundle"
@@ -57,3 +58,13 @@ func LoadTemplate(base *template.Template, paths ...string) *template.Template {
 	}
 	return t
 }
+
+// ReadTemplatesDir returns the entries of the specified templates directory
+func ReadTemplatesDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(bundle.GetTemplatesFs(), name)
+}
+
+// ReadTemplateFile returns the content of the specified template file
+func ReadTemplateFile(name string) ([]byte, error) {
+	return fs.ReadFile(bundle.GetTemplatesFs(), name)
+}
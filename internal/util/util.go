@@ -24,12 +24,14 @@ import (
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"math"
+	"math/big"
 	"net"
 	"net/http"
 	"net/url"
@@ -45,6 +47,8 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/lithammer/shortuuid/v3"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 	"github.com/rs/xid"
 	"golang.org/x/crypto/ssh"
 
@@ -60,6 +64,8 @@ var (
 	emailRegex = regexp.MustCompile("^(?:(?:(?:(?:[a-zA-Z]|\\d|[!#\\$%&'\\*\\+\\-\\/=\\?\\^_`{\\|}~]|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])+(?:\\.([a-zA-Z]|\\d|[!#\\$%&'\\*\\+\\-\\/=\\?\\^_`{\\|}~]|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])+)*)|(?:(?:\\x22)(?:(?:(?:(?:\\x20|\\x09)*(?:\\x0d\\x0a))?(?:\\x20|\\x09)+)?(?:(?:[\\x01-\\x08\\x0b\\x0c\\x0e-\\x1f\\x7f]|\\x21|[\\x23-\\x5b]|[\\x5d-\\x7e]|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])|(?:(?:[\\x01-\\x09\\x0b\\x0c\\x0d-\\x7f]|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}]))))*(?:(?:(?:\\x20|\\x09)*(?:\\x0d\\x0a))?(\\x20|\\x09)+)?(?:\\x22))))@(?:(?:(?:[a-zA-Z]|\\d|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])|(?:(?:[a-zA-Z]|\\d|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])(?:[a-zA-Z]|\\d|-|\\.|~|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])*(?:[a-zA-Z]|\\d|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])))\\.)+(?:(?:[a-zA-Z]|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])|(?:(?:[a-zA-Z]|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])(?:[a-zA-Z]|\\d|-|\\.|~|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])*(?:[a-zA-Z]|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])))\\.?$")
 	// this can be set at build time
 	additionalSharedDataSearchPath = ""
+
+	languageRegex = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
 )
 
 // IEC Sizes.
@@ -410,6 +416,59 @@ func GenerateEd25519Keys(file string) error {
 	return os.WriteFile(file+".pub", ssh.MarshalAuthorizedKey(pub), 0600)
 }
 
+// GenerateSelfSignedCertificate generates an ecdsa private key and a self-signed
+// certificate, valid for the specified hostnames and IP addresses, and writes
+// them to the specified files
+func GenerateSelfSignedCertificate(certFile, keyFile string, hosts []string, validity time.Duration) error {
+	if err := createDirPathIfMissing(certFile, 0700); err != nil {
+		return err
+	}
+	if err := createDirPathIfMissing(keyFile, 0700); err != nil {
+		return err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{Organization: []string{"SFTPGo"}},
+		NotBefore:             now.Add(-1 * time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	if len(template.DNSNames) > 0 {
+		template.Subject.CommonName = template.DNSNames[0]
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}), 0644)
+}
+
 // GetDirsForVirtualPath returns all the directory for the given path in reverse order
 // for example if the path is: /1/2/3/4 it returns:
 // [ "/1/2/3/4", "/1/2/3", "/1/2", "/1", "/" ]
@@ -545,6 +604,52 @@ func HTTPListenAndServe(srv *http.Server, address string, port int, isTLS bool,
 	return srv.Serve(listener)
 }
 
+// HTTP3ListenAndServe serves HTTP/1.1 and HTTP/2 over TLS, as HTTPListenAndServe, and
+// HTTP/3 over QUIC on the UDP port with the same number. The HTTP/3 endpoint is advertised
+// using the Alt-Svc header. The TLS configuration must be set in the specified server.
+// The first error returned by one of the two servers is returned
+func HTTP3ListenAndServe(srv *http.Server, address string, port int, logSender string) error {
+	if srv.TLSConfig == nil {
+		return errors.New("HTTP/3 requires a TLS configuration")
+	}
+	if filepath.IsAbs(address) && runtime.GOOS != osWindows {
+		return fmt.Errorf("HTTP/3 is not supported for Unix-domain socket address %#v", address)
+	}
+	conn, err := net.ListenPacket("udp", fmt.Sprintf("%s:%d", address, port))
+	if err != nil {
+		return err
+	}
+	h3Server := &http3.Server{
+		Port:           port,
+		TLSConfig:      srv.TLSConfig.Clone(),
+		Handler:        srv.Handler,
+		MaxHeaderBytes: srv.MaxHeaderBytes,
+		QuicConfig: &quic.Config{
+			MaxIdleTimeout: srv.IdleTimeout,
+		},
+	}
+	handler := srv.Handler
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h3Server.SetQuicHeaders(w.Header()) //nolint:errcheck
+		handler.ServeHTTP(w, r)
+	})
+
+	logger.Info(logSender, "", "HTTP/3 server listener registered, address: %v", conn.LocalAddr().String())
+
+	errCh := make(chan error, 2)
+	go func() {
+		defer conn.Close()
+
+		errCh <- h3Server.Serve(conn)
+	}()
+	go func() {
+		errCh <- HTTPListenAndServe(srv, address, port, true, logSender)
+	}()
+	err = <-errCh
+	h3Server.Close() //nolint:errcheck
+	return err
+}
+
 // GetTLSCiphersFromNames returns the TLS ciphers from the specified names
 func GetTLSCiphersFromNames(cipherNames []string) []uint16 {
 	var ciphers []uint16
@@ -682,6 +787,51 @@ func ParseAllowedIPAndRanges(allowed []string) ([]func(net.IP) bool, error) {
 	return res, nil
 }
 
+// IsCountryAllowed returns true if the specified ISO 3166-1 alpha-2 country code
+// is allowed. Denied countries have precedence. If allowed countries are
+// defined, only the listed ones are allowed and an unknown country is denied
+func IsCountryAllowed(country string, allowed, denied []string) bool {
+	if len(allowed) == 0 && len(denied) == 0 {
+		return true
+	}
+	if country == "" {
+		return len(allowed) == 0
+	}
+	for _, c := range denied {
+		if strings.EqualFold(c, country) {
+			return false
+		}
+	}
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, c := range allowed {
+		if strings.EqualFold(c, country) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeCountryCodes validates the specified ISO 3166-1 alpha-2 country codes
+// and returns them uppercase without duplicates
+func NormalizeCountryCodes(countries []string) ([]string, error) {
+	var result []string
+	for _, c := range countries {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+		if len(c) != 2 || c[0] < 'A' || c[0] > 'Z' || c[1] < 'A' || c[1] > 'Z' {
+			return nil, fmt.Errorf("invalid country code %q", c)
+		}
+		if !Contains(result, c) {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
 // GetRedactedURL returns the url redacting the password if any
 func GetRedactedURL(rawurl string) string {
 	if !strings.HasPrefix(rawurl, "http") {
@@ -721,6 +871,17 @@ func IsEmailValid(email string) bool {
 	return emailRegex.MatchString(email)
 }
 
+// NormalizeLanguage converts a language tag, for example "pt_BR", to the
+// lowercase form used for localized resources, for example "pt-br"
+func NormalizeLanguage(lang string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+}
+
+// IsLanguageValid returns true if the specified, normalized, language tag is valid
+func IsLanguageValid(lang string) bool {
+	return languageRegex.MatchString(lang)
+}
+
 // PanicOnError calls panic if err is not nil
 func PanicOnError(err error) {
 	if err != nil {
@@ -21,6 +21,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -44,10 +45,12 @@ import (
 	"github.com/eikenb/pipeat"
 	"github.com/google/uuid"
 	"github.com/pkg/sftp"
+	"github.com/sftpgo/sdk"
 
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/resolver"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/version"
 )
@@ -55,6 +58,8 @@ import (
 const (
 	azureDefaultEndpoint = "blob.core.windows.net"
 	azBlobFsName         = "AzureBlobFs"
+	// max block size allowed by Azure Blob Storage
+	azMaxUploadPartSize = 4000 * 1024 * 1024
 )
 
 // AzureBlobFs is a Fs implementation for Azure Blob storage.
@@ -171,6 +176,15 @@ func (fs *AzureBlobFs) ConnectionID() string {
 
 // Stat returns a FileInfo describing the named file
 func (fs *AzureBlobFs) Stat(name string) (os.FileInfo, error) {
+	if fs.config.EmulateSymlinks {
+		return statEmulatedSymlinks(fs, name, fs.config.KeyPrefix)
+	}
+	return fs.Lstat(name)
+}
+
+// Lstat returns a FileInfo describing the named file.
+// Emulated symlinks, if enabled, are not followed
+func (fs *AzureBlobFs) Lstat(name string) (os.FileInfo, error) {
 	if name == "" || name == "/" || name == "." {
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
 	}
@@ -183,6 +197,9 @@ func (fs *AzureBlobFs) Stat(name string) (os.FileInfo, error) {
 		contentType := util.GetStringFromPointer(attrs.ContentType)
 		isDir := contentType == dirMimeType
 		metric.AZListObjectsCompleted(nil)
+		if target, ok := fs.getSymlinkTarget(util.GetIntFromPointer(attrs.ContentLength), attrs.Metadata); ok {
+			return newSymlinkFileInfo(name, target, util.GetTimeFromPointer(attrs.LastModified)), nil
+		}
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, isDir,
 			util.GetIntFromPointer(attrs.ContentLength),
 			util.GetTimeFromPointer(attrs.LastModified), false))
@@ -201,24 +218,36 @@ func (fs *AzureBlobFs) Stat(name string) (os.FileInfo, error) {
 	return nil, os.ErrNotExist
 }
 
-// Lstat returns a FileInfo describing the named file
-func (fs *AzureBlobFs) Lstat(name string) (os.FileInfo, error) {
-	return fs.Stat(name)
-}
-
 // Open opens the named file for reading
 func (fs *AzureBlobFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	if fs.config.EmulateSymlinks {
+		resolved, _, err := resolveEmulatedSymlinks(fs, name, fs.config.KeyPrefix)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		name = resolved
+	}
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	cacheKey := fs.getReadCacheKey(name)
+	cachedReader, cachedCancelFn, cw := readCache.open(cacheKey, offset, fs.localTempDir, func() (os.FileInfo, error) {
+		return fs.Stat(name)
+	}, w)
+	if cachedReader != nil {
+		r.Close()
+		w.Close()
+		return nil, cachedReader, cachedCancelFn, nil
+	}
 	ctx, cancelFn := context.WithCancel(context.Background())
 
 	go func() {
 		defer cancelFn()
 
 		blockBlob := fs.containerClient.NewBlockBlobClient(name)
-		err := fs.handleMultipartDownload(ctx, blockBlob, offset, w)
+		err := fs.handleMultipartDownload(ctx, blockBlob, offset, cw)
+		cw.Done(err)
 		w.CloseWithError(err) //nolint:errcheck
 		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %+v", name, w.GetWrittenBytes(), err)
 		metric.AZTransferCompleted(w.GetWrittenBytes(), 1, err)
@@ -229,6 +258,12 @@ func (fs *AzureBlobFs) Open(name string, offset int64) (File, *pipeat.PipeReader
 
 // Create creates or opens the named file for writing
 func (fs *AzureBlobFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	return fs.CreateWithSize(name, flag, 0)
+}
+
+// CreateWithSize creates or opens the named file for writing, the expected
+// size, if greater than 0, is used to tune the multipart upload part size
+func (fs *AzureBlobFs) CreateWithSize(name string, flag int, size int64) (File, *PipeWriter, func(), error) {
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
@@ -236,6 +271,8 @@ func (fs *AzureBlobFs) Create(name string, flag int) (File, *PipeWriter, func(),
 	ctx, cancelFn := context.WithCancel(context.Background())
 
 	p := NewPipeWriter(w)
+	cacheKey := fs.getReadCacheKey(name)
+	readCache.invalidate(cacheKey)
 	headers := blob.HTTPHeaders{}
 	var contentType string
 	if flag == -1 {
@@ -251,7 +288,8 @@ func (fs *AzureBlobFs) Create(name string, flag int) (File, *PipeWriter, func(),
 		defer cancelFn()
 
 		blockBlob := fs.containerClient.NewBlockBlobClient(name)
-		err := fs.handleMultipartUpload(ctx, r, blockBlob, &headers)
+		err := fs.handleMultipartUpload(ctx, r, name, size, blockBlob, &headers)
+		readCache.invalidate(cacheKey)
 		r.CloseWithError(err) //nolint:errcheck
 		p.Done(err)
 		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, readed bytes: %v, err: %+v", name, r.GetReadedBytes(), err)
@@ -270,7 +308,7 @@ func (fs *AzureBlobFs) Rename(source, target string) error {
 	if source == target {
 		return nil
 	}
-	fi, err := fs.Stat(source)
+	fi, err := fs.Lstat(source)
 	if err != nil {
 		return err
 	}
@@ -283,14 +321,51 @@ func (fs *AzureBlobFs) Rename(source, target string) error {
 			return fmt.Errorf("cannot rename non empty directory: %#v", source)
 		}
 	}
+	if err := fs.copyFileInternal(source, target, fi); err != nil {
+		return err
+	}
+	return fs.Remove(source, fi.IsDir())
+}
+
+// CopyFile copies source to target using a server side copy, only files are supported
+func (fs *AzureBlobFs) CopyFile(source, target string) error {
+	if fs.config.EmulateSymlinks {
+		resolved, _, err := resolveEmulatedSymlinks(fs, source, fs.config.KeyPrefix)
+		if err != nil {
+			return err
+		}
+		source = resolved
+	}
+	fi, err := fs.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("cannot copy %q: %w", source, ErrVfsUnsupported)
+	}
+	return fs.copyFileInternal(source, target, fi)
+}
+
+func (fs *AzureBlobFs) copyFileInternal(source, target string, fi os.FileInfo) error {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
 	defer cancelFn()
 
+	readCache.invalidate(fs.getReadCacheKey(target))
 	srcBlob := fs.containerClient.NewBlockBlobClient(url.PathEscape(source))
 	dstBlob := fs.containerClient.NewBlockBlobClient(target)
-	resp, err := dstBlob.StartCopyFromURL(ctx, srcBlob.URL(), fs.getCopyOptions())
+	err := fs.copyFromURL(ctx, dstBlob, srcBlob.URL())
+	metric.AZCopyObjectCompleted(err)
+	if err != nil {
+		return err
+	}
+	fs.preserveModificationTime(source, target, fi)
+	return nil
+}
+
+// copyFromURL starts a copy to dstBlob from the specified URL and waits for its completion
+func (fs *AzureBlobFs) copyFromURL(ctx context.Context, dstBlob *blockblob.Client, sourceURL string) error {
+	resp, err := dstBlob.StartCopyFromURL(ctx, sourceURL, fs.getCopyOptions())
 	if err != nil {
-		metric.AZCopyObjectCompleted(err)
 		return err
 	}
 	copyStatus := blob.CopyStatusType(util.GetStringFromPointer((*string)(resp.CopyStatus)))
@@ -304,7 +379,6 @@ func (fs *AzureBlobFs) Rename(source, target string) error {
 			// of them before giving up.
 			nErrors++
 			if ctx.Err() != nil || nErrors == 3 {
-				metric.AZCopyObjectCompleted(err)
 				return err
 			}
 		} else {
@@ -312,14 +386,9 @@ func (fs *AzureBlobFs) Rename(source, target string) error {
 		}
 	}
 	if copyStatus != blob.CopyStatusTypeSuccess {
-		err := fmt.Errorf("copy failed with status: %s", copyStatus)
-		metric.AZCopyObjectCompleted(err)
-		return err
+		return fmt.Errorf("copy failed with status: %s", copyStatus)
 	}
-
-	metric.AZCopyObjectCompleted(nil)
-	fs.preserveModificationTime(source, target, fi)
-	return fs.Remove(source, fi.IsDir())
+	return nil
 }
 
 // Remove removes the named file or (empty) directory.
@@ -343,6 +412,7 @@ func (fs *AzureBlobFs) Remove(name string, isDir bool) error {
 		DeleteSnapshots: &deletSnapshots,
 	})
 	metric.AZDeleteObjectCompleted(err)
+	readCache.invalidate(fs.getReadCacheKey(name))
 	if plugin.Handler.HasMetadater() && err == nil && !isDir {
 		if errMetadata := plugin.Handler.RemoveMetadata(fs.getStorageID(), ensureAbsPath(name)); errMetadata != nil {
 			fsLog(fs, logger.LevelWarn, "unable to remove metadata for path %#v: %+v", name, errMetadata)
@@ -365,13 +435,46 @@ func (fs *AzureBlobFs) Mkdir(name string) error {
 }
 
 // Symlink creates source as a symbolic link to target.
-func (*AzureBlobFs) Symlink(source, target string) error {
-	return ErrVfsUnsupported
+// Symlinks are emulated using zero bytes marker blobs, if enabled
+func (fs *AzureBlobFs) Symlink(source, target string) error {
+	if !fs.config.EmulateSymlinks {
+		return ErrVfsUnsupported
+	}
+	if _, err := fs.Lstat(target); !fs.IsNotExist(err) {
+		if err == nil {
+			return fmt.Errorf("cannot create symlink %q: %w", target, os.ErrExist)
+		}
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	options := &blockblob.UploadOptions{
+		Metadata: map[string]string{
+			symlinkTargetMetadataKey: getSymlinkMarkerTarget(source, fs.config.KeyPrefix),
+		},
+	}
+	if accessTier := fs.getUploadAccessTier(target, 0); accessTier != "" {
+		options.Tier = (*blob.AccessTier)(&accessTier)
+	}
+	_, err := fs.containerClient.NewBlockBlobClient(target).Upload(ctx, &bytesReaderWrapper{
+		Reader: bytes.NewReader(nil),
+	}, options)
+	readCache.invalidate(fs.getReadCacheKey(target))
+	metric.AZTransferCompleted(0, 0, err)
+	return err
 }
 
 // Readlink returns the destination of the named symbolic link
-func (*AzureBlobFs) Readlink(name string) (string, error) {
-	return "", ErrVfsUnsupported
+func (fs *AzureBlobFs) Readlink(name string) (string, error) {
+	if !fs.config.EmulateSymlinks {
+		return "", ErrVfsUnsupported
+	}
+	info, err := fs.Lstat(name)
+	if err != nil {
+		return "", err
+	}
+	return readEmulatedSymlink(name, info, fs.mountPath)
 }
 
 // Chown changes the numeric uid and gid of the named file.
@@ -415,6 +518,10 @@ func (*AzureBlobFs) Truncate(name string, size int64) error {
 func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	var result []os.FileInfo
 	// dirname must be already cleaned
+	dirname, err := getDirToList(fs, dirname, fs.config.KeyPrefix, fs.config.EmulateSymlinks)
+	if err != nil {
+		return result, err
+	}
 	prefix := fs.getPrefix(dirname)
 
 	modTimes, err := getFolderModTimes(fs.getStorageID(), dirname)
@@ -424,7 +531,7 @@ func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	prefixes := make(map[string]bool)
 
 	pager := fs.containerClient.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{
-		Include: container.ListBlobsInclude{},
+		Include: container.ListBlobsInclude{Metadata: fs.config.EmulateSymlinks},
 		Prefix:  &prefix,
 	})
 
@@ -474,6 +581,10 @@ func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 			if t, ok := modTimes[name]; ok {
 				modTime = util.GetTimeFromMsecSinceEpoch(t)
 			}
+			if target, ok := fs.getListedSymlinkTarget(blobItem, isDir, size); ok {
+				result = append(result, newSymlinkFileInfo(name, target, modTime))
+				continue
+			}
 			result = append(result, NewFileInfo(name, isDir, size, modTime, false))
 		}
 	}
@@ -715,6 +826,27 @@ func (fs *AzureBlobFs) ResolvePath(virtualPath string) (string, error) {
 	return fs.Join(fs.config.KeyPrefix, strings.TrimPrefix(virtualPath, "/")), nil
 }
 
+// getSymlinkTarget returns the target of a blob with the specified size and metadata
+// if it is a symlink marker
+func (fs *AzureBlobFs) getSymlinkTarget(size int64, metadata map[string]string) (string, bool) {
+	if !fs.config.EmulateSymlinks || size != 0 {
+		return "", false
+	}
+	return getSymlinkTargetFromMetadata(metadata)
+}
+
+// getListedSymlinkTarget returns the target of a listed blob if it is a symlink marker
+func (fs *AzureBlobFs) getListedSymlinkTarget(blobItem *container.BlobItem, isDir bool, size int64) (string, bool) {
+	if isDir || len(blobItem.Metadata) == 0 {
+		return "", false
+	}
+	metadata := make(map[string]string)
+	for k, v := range blobItem.Metadata {
+		metadata[k] = util.GetStringFromPointer(v)
+	}
+	return fs.getSymlinkTarget(size, metadata)
+}
+
 func (fs *AzureBlobFs) headObject(name string) (blob.GetPropertiesResponse, error) {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
@@ -725,6 +857,192 @@ func (fs *AzureBlobFs) headObject(name string) (blob.GetPropertiesResponse, erro
 	return resp, err
 }
 
+// GetArchiveStatus returns the archive status for the specified blob
+func (fs *AzureBlobFs) GetArchiveStatus(name string) (ArchiveStatus, error) {
+	props, err := fs.headObject(name)
+	if err != nil {
+		return ArchiveStatus{}, err
+	}
+	status := ArchiveStatus{
+		StorageClass: util.GetStringFromPointer(props.AccessTier),
+		Available:    true,
+	}
+	if status.StorageClass != string(blob.AccessTierArchive) {
+		return status, nil
+	}
+	status.Archived = true
+	status.Available = false
+	status.Restoring = strings.HasPrefix(util.GetStringFromPointer(props.ArchiveStatus), "rehydrate-pending-")
+	return status, nil
+}
+
+// RestoreArchivedObject rehydrates the specified blob from the archive tier.
+// The blob is moved to the configured access tier, or to the hot tier if the
+// configured one is the archive tier. Azure does not support temporary copies,
+// so the days parameter is ignored
+func (fs *AzureBlobFs) RestoreArchivedObject(name string, days int) error {
+	status, err := fs.GetArchiveStatus(name)
+	if err != nil {
+		return err
+	}
+	if !status.Archived || status.Restoring {
+		return nil
+	}
+	tier := blob.AccessTierHot
+	if fs.config.AccessTier == string(blob.AccessTierCool) {
+		tier = blob.AccessTierCool
+	}
+	priority := blob.RehydratePriorityStandard
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	_, err = fs.containerClient.NewBlockBlobClient(name).SetTier(ctx, tier, &blob.SetTierOptions{
+		RehydratePriority: &priority,
+	})
+	fsLog(fs, logger.LevelDebug, "rehydration requested for archived blob %q, tier: %v, err: %v", name, tier, err)
+	return err
+}
+
+// GetStorageProvider returns the filesystem provider
+func (*AzureBlobFs) GetStorageProvider() sdk.FilesystemProvider {
+	return sdk.AzureBlobFilesystemProvider
+}
+
+// GetStorageClass returns the access tier for the specified blob
+func (fs *AzureBlobFs) GetStorageClass(name string) (string, error) {
+	props, err := fs.headObject(name)
+	if err != nil {
+		return "", err
+	}
+	return util.GetStringFromPointer(props.AccessTier), nil
+}
+
+// SetStorageClass moves the specified blob to the specified access tier.
+// Archived blobs must be rehydrated before they can be moved to an online tier
+func (fs *AzureBlobFs) SetStorageClass(name, storageClass string) error {
+	currentTier, err := fs.GetStorageClass(name)
+	if err != nil {
+		return err
+	}
+	if currentTier == storageClass {
+		return nil
+	}
+	if currentTier == string(blob.AccessTierArchive) {
+		return ErrObjectArchived
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	_, err = fs.containerClient.NewBlockBlobClient(name).SetTier(ctx, blob.AccessTier(storageClass), &blob.SetTierOptions{})
+	fsLog(fs, logger.LevelDebug, "access tier change for blob %q, from %q to %q, err: %v",
+		name, currentTier, storageClass, err)
+	return err
+}
+
+// GetRecordedChecksum returns the Content-MD5 property for the specified blob,
+// if set. Blobs in the archive tier cannot be read
+func (fs *AzureBlobFs) GetRecordedChecksum(name string) (Checksum, error) {
+	props, err := fs.headObject(name)
+	if err != nil {
+		return Checksum{}, err
+	}
+	if util.GetStringFromPointer(props.AccessTier) == string(blob.AccessTierArchive) {
+		return Checksum{}, ErrObjectArchived
+	}
+	if len(props.ContentMD5) == 0 {
+		return Checksum{}, nil
+	}
+	return Checksum{
+		Algorithm: ChecksumAlgoMD5,
+		Value:     hex.EncodeToString(props.ContentMD5),
+	}, nil
+}
+
+// ListVersions returns the versions for the specified blob, newest first
+func (fs *AzureBlobFs) ListVersions(name string) ([]FileVersion, error) {
+	var versions []FileVersion
+
+	pager := fs.containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Include: container.ListBlobsInclude{Versions: true},
+		Prefix:  &name,
+	})
+
+	for pager.More() {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		resp, err := pager.NextPage(ctx)
+		cancelFn()
+		if err != nil {
+			metric.AZListObjectsCompleted(err)
+			return nil, err
+		}
+		for _, blobItem := range resp.ListBlobsFlatSegmentResponse.Segment.BlobItems {
+			if util.GetStringFromPointer(blobItem.Name) != name || blobItem.VersionID == nil {
+				continue
+			}
+			version := FileVersion{
+				ID:       util.GetStringFromPointer(blobItem.VersionID),
+				IsLatest: blobItem.IsCurrentVersion != nil && *blobItem.IsCurrentVersion,
+			}
+			if blobItem.Properties != nil {
+				version.Size = util.GetIntFromPointer(blobItem.Properties.ContentLength)
+				if blobItem.Properties.LastModified != nil {
+					version.ModTime = util.GetTimeAsMsSinceEpoch(*blobItem.Properties.LastModified)
+				}
+			}
+			versions = append(versions, version)
+		}
+	}
+	metric.AZListObjectsCompleted(nil)
+	sortFileVersions(versions)
+	return versions, nil
+}
+
+// OpenVersion opens the specified blob version for reading.
+// The read cache is not used for blob versions
+func (fs *AzureBlobFs) OpenVersion(name, versionID string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	blockBlob, err := fs.containerClient.NewBlockBlobClient(name).WithVersionID(versionID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		err := fs.handleMultipartDownload(ctx, blockBlob, offset, w)
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path: %q version: %q size: %v, err: %+v",
+			name, versionID, w.GetWrittenBytes(), err)
+		metric.AZTransferCompleted(w.GetWrittenBytes(), 1, err)
+	}()
+
+	return nil, r, cancelFn, nil
+}
+
+// RestoreVersion makes the specified version the current one by copying it in place
+func (fs *AzureBlobFs) RestoreVersion(name, versionID string) error {
+	srcBlob, err := fs.containerClient.NewBlockBlobClient(url.PathEscape(name)).WithVersionID(versionID)
+	if err != nil {
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+
+	readCache.invalidate(fs.getReadCacheKey(name))
+	err = fs.copyFromURL(ctx, fs.containerClient.NewBlockBlobClient(name), srcBlob.URL())
+	metric.AZCopyObjectCompleted(err)
+	fsLog(fs, logger.LevelDebug, "version %q restored for blob %q, err: %v", versionID, name, err)
+	if err != nil {
+		return err
+	}
+	updateRestoredVersionModTime(fs, fs.getStorageID(), name)
+	return nil
+}
+
 // GetMimeType returns the content type
 func (fs *AzureBlobFs) GetMimeType(name string) (string, error) {
 	response, err := fs.headObject(name)
@@ -854,6 +1172,10 @@ func (fs *AzureBlobFs) handleMultipartDownload(ctx context.Context, blockBlob *b
 		fsLog(fs, logger.LevelError, "unable to get blob properties, download aborted: %+v", err)
 		return err
 	}
+	if util.GetStringFromPointer(props.AccessTier) == string(blob.AccessTierArchive) {
+		fsLog(fs, logger.LevelDebug, "the blob is in the archive tier, download aborted")
+		return ErrObjectArchived
+	}
 	contentLength := util.GetIntFromPointer(props.ContentLength)
 	sizeToDownload := contentLength - offset
 	if sizeToDownload < 0 {
@@ -927,18 +1249,19 @@ func (fs *AzureBlobFs) handleMultipartDownload(ctx context.Context, blockBlob *b
 	return poolError
 }
 
-func (fs *AzureBlobFs) handleMultipartUpload(ctx context.Context, reader io.Reader,
+func (fs *AzureBlobFs) handleMultipartUpload(ctx context.Context, reader io.Reader, name string, fileSize int64,
 	blockBlob *blockblob.Client, httpHeaders *blob.HTTPHeaders,
 ) error {
-	partSize := fs.config.UploadPartSize
-	guard := make(chan struct{}, fs.config.UploadConcurrency)
-	blockCtxTimeout := time.Duration(fs.config.UploadPartSize/(1024*1024)) * time.Minute
+	partSize := GetUploadPartSize(fs.config.UploadPartSize, fileSize, azMaxUploadPartSize)
+	guard := make(chan struct{}, GetUploadConcurrency(fs.config.UploadConcurrency))
+	blockCtxTimeout := time.Duration(partSize/(1024*1024)) * time.Minute
 
 	// sync.Pool seems to use a lot of memory so prefer our own, very simple, allocator
 	// we only need to recycle few byte slices
 	pool := newBufferAllocator(int(partSize))
 	finished := false
 	var blocks []string
+	var size int64
 	var wg sync.WaitGroup
 	var errOnce sync.Once
 	var hasError atomic.Bool
@@ -974,6 +1297,7 @@ func (fs *AzureBlobFs) handleMultipartUpload(ctx context.Context, reader io.Read
 		}
 		blockID := base64.StdEncoding.EncodeToString([]byte(generatedUUID.String()))
 		blocks = append(blocks, blockID)
+		size += int64(n)
 
 		guard <- struct{}{}
 		if hasError.Load() {
@@ -1019,8 +1343,8 @@ func (fs *AzureBlobFs) handleMultipartUpload(ctx context.Context, reader io.Read
 	commitOptions := blockblob.CommitBlockListOptions{
 		HTTPHeaders: httpHeaders,
 	}
-	if fs.config.AccessTier != "" {
-		commitOptions.Tier = (*blob.AccessTier)(&fs.config.AccessTier)
+	if accessTier := fs.getUploadAccessTier(name, size); accessTier != "" {
+		commitOptions.Tier = (*blob.AccessTier)(&accessTier)
 	}
 
 	_, err := blockBlob.CommitBlockList(ctx, blocks, &commitOptions)
@@ -1055,13 +1379,29 @@ func (fs *AzureBlobFs) preserveModificationTime(source, target string, fi os.Fil
 			err := plugin.Handler.SetModificationTime(fs.getStorageID(), ensureAbsPath(target),
 				util.GetTimeAsMsSinceEpoch(fi.ModTime()))
 			if err != nil {
-				fsLog(fs, logger.LevelWarn, "unable to preserve modification time after renaming %#v -> %#v: %+v",
+				fsLog(fs, logger.LevelWarn, "unable to preserve modification time after copying %#v -> %#v: %+v",
 					source, target, err)
 			}
 		}
 	}
 }
 
+// getUploadAccessTier returns the access tier for an uploaded blob based on the
+// tiering rules, if no rule matches the configured access tier is returned
+func (fs *AzureBlobFs) getUploadAccessTier(name string, size int64) string {
+	if len(fs.config.TieringRules) > 0 {
+		relPath := "/" + strings.TrimPrefix(name, fs.config.KeyPrefix)
+		for idx := range fs.config.TieringRules {
+			if fs.config.TieringRules[idx].matches(relPath, size) {
+				fsLog(fs, logger.LevelDebug, "tiering rule %d matches for %q, size %d, access tier: %q",
+					idx, name, size, fs.config.TieringRules[idx].AccessTier)
+				return fs.config.TieringRules[idx].AccessTier
+			}
+		}
+	}
+	return fs.config.AccessTier
+}
+
 func (fs *AzureBlobFs) getCopyOptions() *blob.StartCopyFromURLOptions {
 	copyOptions := &blob.StartCopyFromURLOptions{}
 	if fs.config.AccessTier != "" {
@@ -1080,15 +1420,29 @@ func (fs *AzureBlobFs) getStorageID() string {
 	return fmt.Sprintf("azblob://%v", fs.config.Container)
 }
 
+// getReadCacheKey uses the container URL, without the query string that may
+// contain a SAS token, since the storage ID does not include the account name
+func (fs *AzureBlobFs) getReadCacheKey(name string) string {
+	containerURL := fs.containerClient.URL()
+	if idx := strings.Index(containerURL, "?"); idx >= 0 {
+		containerURL = containerURL[:idx]
+	}
+	return newReadCacheKey(containerURL, name)
+}
+
 func getAzContainerClientOptions() *container.ClientOptions {
 	version := version.Get()
-	return &container.ClientOptions{
+	options := &container.ClientOptions{
 		ClientOptions: azcore.ClientOptions{
 			Telemetry: policy.TelemetryOptions{
 				ApplicationID: fmt.Sprintf("SFTPGo-%v_%v", version.Version, version.CommitHash),
 			},
 		},
 	}
+	if resolver.IsEnabled() {
+		options.Transport = &http.Client{Transport: getResolverTransport()}
+	}
+	return options
 }
 
 type bytesReaderWrapper struct {
@@ -0,0 +1,480 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package vfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/eikenb/pipeat"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// compressedFsName is the name for the local Fs implementation with compression support
+	compressedFsName = "compressedfs"
+	// CompressionAlgoZstd defines the zstd compression algorithm
+	CompressionAlgoZstd = "zstd"
+	// CompressionAlgoGzip defines the gzip compression algorithm
+	CompressionAlgoGzip = "gzip"
+)
+
+const (
+	compressedV1               byte  = 0x01
+	compressedAlgoZstdID       byte  = 0x01
+	compressedAlgoGzipID       byte  = 0x02
+	compressedHeaderSize       int64 = 14 // 4 (magic) + 1 (version) + 1 (algorithm) + 8 (original size)
+	compressedHeaderSizeOffset int64 = 6
+)
+
+var (
+	compressedMagic          = []byte("SFGZ")
+	supportedCompressionAlgo = []string{CompressionAlgoZstd, CompressionAlgoGzip}
+)
+
+// CompressedFsConfig defines the configuration to store local files as compressed
+type CompressedFsConfig struct {
+	// Algorithm to use for new files: zstd or gzip. Existing files are
+	// decompressed using the algorithm stored in their header
+	Algorithm string `json:"algorithm,omitempty"`
+	// Compression level, 0 means the algorithm default.
+	// The allowed levels are 1-9 for gzip and 1-22 for zstd
+	Level int `json:"level,omitempty"`
+}
+
+func (c *CompressedFsConfig) isEqual(other CompressedFsConfig) bool {
+	return c.Algorithm == other.Algorithm && c.Level == other.Level
+}
+
+func (c *CompressedFsConfig) isSameResource(_ CompressedFsConfig) bool {
+	return true
+}
+
+// validate returns an error if the configuration is not valid
+func (c *CompressedFsConfig) validate() error {
+	if c.Algorithm == "" {
+		c.Algorithm = CompressionAlgoZstd
+	}
+	if !util.Contains(supportedCompressionAlgo, c.Algorithm) {
+		return fmt.Errorf("invalid compression algorithm %q, supported values: %v", c.Algorithm, supportedCompressionAlgo)
+	}
+	maxLevel := 22
+	if c.Algorithm == CompressionAlgoGzip {
+		maxLevel = gzip.BestCompression
+	}
+	if c.Level < 0 || c.Level > maxLevel {
+		return fmt.Errorf("invalid %s compression level %d, allowed range 0-%d", c.Algorithm, c.Level, maxLevel)
+	}
+	return nil
+}
+
+// Validate validates the configuration
+func (c *CompressedFsConfig) Validate() error {
+	if err := c.validate(); err != nil {
+		return util.NewValidationError(fmt.Sprintf("could not validate compressed fs config: %v", err))
+	}
+	return nil
+}
+
+// CompressedFs is a Fs implementation that compresses/decompresses local files.
+// The original size is stored in the file header so it is reported in directory
+// listings and used for quota tracking
+type CompressedFs struct {
+	*OsFs
+	localTempDir string
+	config       CompressedFsConfig
+}
+
+// NewCompressedFs returns a CompressedFs object
+func NewCompressedFs(connectionID, rootDir, mountPath string, config CompressedFsConfig) (Fs, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	fs := &CompressedFs{
+		OsFs: &OsFs{
+			name:         compressedFsName,
+			connectionID: connectionID,
+			rootDir:      rootDir,
+			mountPath:    getMountPath(mountPath),
+		},
+		config: config,
+	}
+	if tempPath == "" {
+		fs.localTempDir = rootDir
+	} else {
+		fs.localTempDir = tempPath
+	}
+	return fs, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs *CompressedFs) Name() string {
+	return fs.name
+}
+
+// Stat returns a FileInfo describing the named file.
+// The reported size is the original, uncompressed, one
+func (fs *CompressedFs) Stat(name string) (os.FileInfo, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return info, err
+	}
+	return fs.convertFileInfo(name, info), nil
+}
+
+// Lstat returns a FileInfo describing the named file.
+// The reported size is the original, uncompressed, one
+func (fs *CompressedFs) Lstat(name string) (os.FileInfo, error) {
+	info, err := os.Lstat(name)
+	if err != nil {
+		return info, err
+	}
+	return fs.convertFileInfo(name, info), nil
+}
+
+// Open opens the named file for reading
+func (fs *CompressedFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	header := compressedFileHeader{}
+	isCompressed, err := header.Load(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	if !isCompressed {
+		// files stored before enabling compression are served as is
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, nil, nil, err
+		}
+		return f, nil, nil, nil
+	}
+	dec, err := header.getDecompressor(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		dec.Close()
+		f.Close()
+		return nil, nil, nil, err
+	}
+
+	go func() {
+		var n int64
+		var err error
+
+		if offset > 0 {
+			_, err = io.CopyN(io.Discard, dec, offset)
+		}
+		if err == nil {
+			n, err = io.Copy(w, dec)
+		}
+		w.CloseWithError(err) //nolint:errcheck
+		dec.Close()
+		f.Close()
+		fsLog(fs, logger.LevelDebug, "download completed, path: %q size: %v, err: %v", name, n, err)
+	}()
+
+	return nil, r, nil, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *CompressedFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	var err error
+	var f *os.File
+	if flag == 0 {
+		f, err = os.Create(name)
+	} else {
+		f, err = os.OpenFile(name, flag, 0666)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	header := compressedFileHeader{
+		version:   compressedV1,
+		algorithm: compressedAlgoZstdID,
+	}
+	if fs.config.Algorithm == CompressionAlgoGzip {
+		header.algorithm = compressedAlgoGzipID
+	}
+	if err = header.Store(f); err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	enc, err := fs.getCompressor(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		enc.Close()
+		f.Close()
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+
+	go func() {
+		n, err := io.Copy(enc, r)
+		errClose := enc.Close()
+		if err == nil && errClose != nil {
+			err = errClose
+		}
+		if err == nil {
+			err = header.storeSize(f, n)
+		}
+		errClose = f.Close()
+		if err == nil && errClose != nil {
+			err = errClose
+		}
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, readed bytes: %v, err: %v", name, n, err)
+	}()
+
+	return nil, p, nil, nil
+}
+
+// Truncate changes the size of the named file
+func (*CompressedFs) Truncate(name string, size int64) error {
+	return ErrVfsUnsupported
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs *CompressedFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	f, err := os.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	list, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]os.FileInfo, 0, len(list))
+	for _, info := range list {
+		result = append(result, fs.convertFileInfo(filepath.Join(dirname, info.Name()), info))
+	}
+	return result, nil
+}
+
+// IsUploadResumeSupported returns false, compressed streams does not support random access writes
+func (*CompressedFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// ScanRootDirContents returns the number of files contained in the root
+// directory and their uncompressed size
+func (fs *CompressedFs) ScanRootDirContents() (int, int64, error) {
+	return fs.GetDirSize(fs.rootDir)
+}
+
+// GetDirSize returns the number of files and the uncompressed size for a folder
+// including any subfolders
+func (fs *CompressedFs) GetDirSize(dirname string) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	isDir, err := isDirectory(fs, dirname)
+	if err == nil && isDir {
+		err = fs.Walk(dirname, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info != nil && info.Mode().IsRegular() {
+				size += info.Size()
+				numFiles++
+			}
+			return err
+		})
+	}
+	return numFiles, size, err
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root. The reported sizes are the uncompressed ones
+func (fs *CompressedFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	return filepath.Walk(root, func(walkedPath string, info os.FileInfo, err error) error {
+		if err == nil && info != nil {
+			info = fs.convertFileInfo(walkedPath, info)
+		}
+		return walkFn(walkedPath, info, err)
+	})
+}
+
+// GetMimeType returns the content type
+func (fs *CompressedFs) GetMimeType(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := compressedFileHeader{}
+	isCompressed, err := header.Load(f)
+	if err != nil {
+		return "", err
+	}
+	if !isCompressed {
+		return fs.OsFs.GetMimeType(name)
+	}
+	dec, err := header.getDecompressor(f)
+	if err != nil {
+		return "", err
+	}
+	defer dec.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(dec, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+func (fs *CompressedFs) getCompressor(w io.Writer) (io.WriteCloser, error) {
+	if fs.config.Algorithm == CompressionAlgoGzip {
+		level := gzip.DefaultCompression
+		if fs.config.Level > 0 {
+			level = fs.config.Level
+		}
+		return gzip.NewWriterLevel(w, level)
+	}
+	level := zstd.SpeedDefault
+	if fs.config.Level > 0 {
+		level = zstd.EncoderLevelFromZstd(fs.config.Level)
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+}
+
+// convertFileInfo returns a FileInfo with the uncompressed size, files
+// without a valid header are reported as is
+func (fs *CompressedFs) convertFileInfo(name string, info os.FileInfo) os.FileInfo {
+	if !info.Mode().IsRegular() || info.Size() < compressedHeaderSize {
+		return info
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return info
+	}
+	defer f.Close()
+
+	header := compressedFileHeader{}
+	isCompressed, err := header.Load(f)
+	if err != nil || !isCompressed {
+		return info
+	}
+	return &compressedFileInfo{
+		FileInfo: info,
+		size:     header.size,
+	}
+}
+
+type compressedFileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+// Size returns the uncompressed size
+func (fi *compressedFileInfo) Size() int64 {
+	return fi.size
+}
+
+type readCloser struct {
+	io.Reader
+	closeFn func()
+}
+
+func (r *readCloser) Close() {
+	r.closeFn()
+}
+
+type compressedFileHeader struct {
+	version   byte
+	algorithm byte
+	size      int64
+}
+
+func (h *compressedFileHeader) Store(f *os.File) error {
+	buf := make([]byte, 0, compressedHeaderSize)
+	buf = append(buf, compressedMagic...)
+	buf = append(buf, h.version, h.algorithm)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(h.size))
+	_, err := f.Write(buf)
+	return err
+}
+
+func (h *compressedFileHeader) storeSize(f *os.File, size int64) error {
+	h.size = size
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(size))
+	_, err := f.WriteAt(buf, compressedHeaderSizeOffset)
+	return err
+}
+
+// Load reads the header from f, false is returned if f is not a compressed
+// file. If the header is not valid the file position is restored
+func (h *compressedFileHeader) Load(f *os.File) (bool, error) {
+	buf := make([]byte, compressedHeaderSize)
+	_, err := io.ReadFull(f, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		_, err = f.Seek(0, io.SeekStart)
+		return false, err
+	}
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(buf[:len(compressedMagic)], compressedMagic) || buf[4] != compressedV1 {
+		_, err = f.Seek(0, io.SeekStart)
+		return false, err
+	}
+	h.version = buf[4]
+	h.algorithm = buf[5]
+	h.size = int64(binary.BigEndian.Uint64(buf[compressedHeaderSizeOffset:]))
+	return true, nil
+}
+
+func (h *compressedFileHeader) getDecompressor(r io.Reader) (*readCloser, error) {
+	switch h.algorithm {
+	case compressedAlgoZstdID:
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &readCloser{Reader: dec, closeFn: dec.Close}, nil
+	case compressedAlgoGzipID:
+		dec, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &readCloser{Reader: dec, closeFn: func() { dec.Close() }}, nil
+	default:
+		return nil, errors.New("unsupported compression algorithm")
+	}
+}
@@ -18,10 +18,12 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 
 	"github.com/eikenb/pipeat"
 	"github.com/minio/sio"
@@ -331,6 +333,90 @@ func isZeroBytesDownload(f *os.File, offset int64) (bool, error) {
 	return false, nil
 }
 
+// ShredCryptFsKeys makes the files encrypted within rootDir irrecoverable by
+// overwriting, with random data, the nonce stored in each file header. The
+// per-file encryption keys are derived from the passphrase and this nonce, so
+// the contents cannot be decrypted anymore, even using the passphrase or a
+// backup of the data provider, and they can be removed later. The directories
+// in excludedDirs, for example the mapped paths of nested virtual folders, are
+// skipped. The number of shredded files is returned, shredding continues on
+// errors and the first error is returned
+func ShredCryptFsKeys(rootDir string, excludedDirs []string) (int, error) {
+	rootDir = filepath.Clean(rootDir)
+	excluded := make(map[string]bool)
+	for _, dir := range excludedDirs {
+		excluded[filepath.Clean(dir)] = true
+	}
+	var shredded int
+	var firstErr error
+
+	err := filepath.Walk(rootDir, func(walkedPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if walkedPath == rootDir && errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if walkedPath != rootDir && excluded[walkedPath] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || info.Size() < headerV10Size {
+			return nil
+		}
+		ok, err := shredEncryptedFileNonce(walkedPath)
+		if err != nil {
+			logger.Warn(cryptFsName, "", "unable to shred the encryption key for %q: %v", walkedPath, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			return nil
+		}
+		if ok {
+			shredded++
+		}
+		return nil
+	})
+	if err != nil {
+		return shredded, err
+	}
+	return shredded, firstErr
+}
+
+// shredEncryptedFileNonce overwrites the nonce of an encrypted file, false is
+// returned if the file is not encrypted
+func shredEncryptedFileNonce(name string) (bool, error) {
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	version := make([]byte, 1)
+	if _, err := io.ReadFull(f, version); err != nil {
+		return false, err
+	}
+	if version[0] != version10 {
+		return false, nil
+	}
+	nonce := make([]byte, nonceV10Size)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return false, err
+	}
+	if _, err := f.WriteAt(nonce, 1); err != nil {
+		return false, err
+	}
+	if err := f.Sync(); err != nil {
+		return false, err
+	}
+	return true, f.Close()
+}
+
 type encryptedFileHeader struct {
 	version byte
 	nonce   []byte
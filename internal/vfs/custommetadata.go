@@ -0,0 +1,122 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// the custom metadata keys with this prefix are reserved for internal usage
+	reservedMetadataKeyPrefix = "sftpgo"
+	maxCustomMetadataKeyLen   = 128
+	// S3 limits the user defined metadata to 2 KB, we apply the same limit
+	// to all the backends so the metadata can be moved between them
+	maxCustomMetadataSize = 2048
+)
+
+var customMetadataKeyRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// FsCustomMetadataHandler is a Fs that allows to store custom metadata, as
+// key/value pairs, for files. The metadata are stored as user extended
+// attributes for the local filesystem and as user defined metadata for
+// object storages
+type FsCustomMetadataHandler interface {
+	Fs
+	// GetCustomMetadata returns the custom metadata for the specified file
+	GetCustomMetadata(name string) (map[string]string, error)
+	// SetCustomMetadata updates the custom metadata for the specified file.
+	// Keys with an empty value are removed, keys not included are preserved
+	SetCustomMetadata(name string, metadata map[string]string) error
+}
+
+// ValidateCustomMetadata validates the specified custom metadata and returns
+// them with normalized keys. Keys are case insensitive and they are converted
+// to lower case, empty values are allowed and mean that the key must be removed
+func ValidateCustomMetadata(metadata map[string]string) (map[string]string, error) {
+	result := make(map[string]string)
+	for k, v := range metadata {
+		key := strings.ToLower(strings.TrimSpace(k))
+		if !isCustomMetadataKeyValid(key) {
+			return nil, util.NewValidationError(fmt.Sprintf("invalid metadata key %q", k))
+		}
+		if !utf8.ValidString(v) {
+			return nil, util.NewValidationError(fmt.Sprintf("invalid value for metadata key %q", k))
+		}
+		if _, ok := result[key]; ok {
+			return nil, util.NewValidationError(fmt.Sprintf("duplicated metadata key %q", key))
+		}
+		result[key] = v
+	}
+	if getCustomMetadataSize(result) > maxCustomMetadataSize {
+		return nil, util.NewValidationError(fmt.Sprintf("metadata size exceeds the limit of %d bytes",
+			maxCustomMetadataSize))
+	}
+	return result, nil
+}
+
+func isCustomMetadataKeyValid(key string) bool {
+	if len(key) > maxCustomMetadataKeyLen || !customMetadataKeyRegex.MatchString(key) {
+		return false
+	}
+	return !strings.HasPrefix(key, reservedMetadataKeyPrefix)
+}
+
+func getCustomMetadataSize(metadata map[string]string) int {
+	size := 0
+	for k, v := range metadata {
+		size += len(k) + len(v)
+	}
+	return size
+}
+
+// filterCustomMetadata returns the custom metadata included in the metadata
+// stored by a backend, internal and unsupported keys are excluded
+func filterCustomMetadata(metadata map[string]string) map[string]string {
+	result := make(map[string]string)
+	for k, v := range metadata {
+		key := strings.ToLower(k)
+		if isCustomMetadataKeyValid(key) {
+			result[key] = v
+		}
+	}
+	return result
+}
+
+// mergeCustomMetadata applies the specified changes to the metadata stored by
+// a backend. Internal keys are preserved and they are not counted for the size limit
+func mergeCustomMetadata(stored, changes map[string]string) (map[string]string, error) {
+	result := make(map[string]string)
+	for k, v := range stored {
+		result[strings.ToLower(k)] = v
+	}
+	for k, v := range changes {
+		if v == "" {
+			delete(result, k)
+		} else {
+			result[k] = v
+		}
+	}
+	if getCustomMetadataSize(filterCustomMetadata(result)) > maxCustomMetadataSize {
+		return nil, util.NewValidationError(fmt.Sprintf("metadata size exceeds the limit of %d bytes",
+			maxCustomMetadataSize))
+	}
+	return result, nil
+}
@@ -0,0 +1,640 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package vfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/eikenb/pipeat"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// dedupFsName is the name for the local Fs implementation with deduplication support
+	dedupFsName = "dedupfs"
+)
+
+// Quota accounting modes for the deduplicated filesystem
+const (
+	// DedupQuotaModeLogical accounts the full size for each file
+	DedupQuotaModeLogical = iota
+	// DedupQuotaModePhysical accounts only the stored blobs, duplicates are free
+	DedupQuotaModePhysical
+)
+
+const (
+	dedupRefV1             byte  = 0x01
+	dedupRefFlagOwner      byte  = 0x01
+	dedupRefSize           int64 = 46 // 4 (magic) + 1 (version) + 1 (flags) + 8 (size) + 32 (SHA-256)
+	dedupBlobsDirName            = "blobs"
+	dedupRefsDirName             = "refs"
+	dedupUploadsDirName          = "uploads"
+	dedupUploadsTempPrefix       = "upload-"
+)
+
+var (
+	dedupRefMagic = []byte("SFDD")
+	// dedupStoreMu serializes the reference counts updates and the blobs
+	// creation/removal for all the stores
+	dedupStoreMu sync.Mutex
+)
+
+// DedupFsConfig defines the configuration to store local files deduplicated
+type DedupFsConfig struct {
+	// StorePath is the absolute path to the directory where the unique blobs are stored.
+	// Users and folders with the same store path share the stored blobs
+	StorePath string `json:"store_path,omitempty"`
+	// QuotaMode defines how the used quota is accounted:
+	//  - 0 logical, each file counts its full size
+	//  - 1 physical, a file counts its size only if its upload stored a new blob
+	QuotaMode int `json:"quota_mode,omitempty"`
+}
+
+func (c *DedupFsConfig) isEqual(other DedupFsConfig) bool {
+	return c.StorePath == other.StorePath && c.QuotaMode == other.QuotaMode
+}
+
+func (c *DedupFsConfig) isSameResource(other DedupFsConfig) bool {
+	return c.StorePath == other.StorePath
+}
+
+// validate returns an error if the configuration is not valid
+func (c *DedupFsConfig) validate() error {
+	if c.StorePath == "" {
+		return errors.New("store path is required")
+	}
+	if !filepath.IsAbs(c.StorePath) {
+		return fmt.Errorf("store path %q must be an absolute path", c.StorePath)
+	}
+	c.StorePath = filepath.Clean(c.StorePath)
+	if c.QuotaMode != DedupQuotaModeLogical && c.QuotaMode != DedupQuotaModePhysical {
+		return fmt.Errorf("invalid quota mode %d", c.QuotaMode)
+	}
+	return nil
+}
+
+// Validate validates the configuration
+func (c *DedupFsConfig) Validate() error {
+	if err := c.validate(); err != nil {
+		return util.NewValidationError(fmt.Sprintf("could not validate dedup fs config: %v", err))
+	}
+	return nil
+}
+
+// ValidateRootDir returns a validation error if the store path overlaps the
+// specified root directory, the blobs must not be visible to the users
+func (c *DedupFsConfig) ValidateRootDir(rootDir string) error {
+	if err := c.checkRootDir(rootDir); err != nil {
+		return util.NewValidationError(fmt.Sprintf("could not validate dedup fs config: %v", err))
+	}
+	return nil
+}
+
+func (c *DedupFsConfig) checkRootDir(rootDir string) error {
+	root := filepath.Clean(rootDir)
+	if isLocalPathOverlapped(root, filepath.Clean(c.StorePath)) {
+		return fmt.Errorf("store path %q cannot overlap the root directory %q", c.StorePath, root)
+	}
+	return nil
+}
+
+// DedupFs is a Fs implementation that stores the contents of the uploaded
+// files once in a content-addressed store. The files inside the root
+// directory are references, holding the SHA-256 and the size of their contents,
+// to the stored blobs and each blob has a reference count
+type DedupFs struct {
+	*OsFs
+	localTempDir string
+	config       DedupFsConfig
+}
+
+// NewDedupFs returns a DedupFs object
+func NewDedupFs(connectionID, rootDir, mountPath string, config DedupFsConfig) (Fs, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.checkRootDir(rootDir); err != nil {
+		return nil, err
+	}
+	fs := &DedupFs{
+		OsFs: &OsFs{
+			name:         dedupFsName,
+			connectionID: connectionID,
+			rootDir:      rootDir,
+			mountPath:    getMountPath(mountPath),
+		},
+		config: config,
+	}
+	if tempPath == "" {
+		fs.localTempDir = rootDir
+	} else {
+		fs.localTempDir = tempPath
+	}
+	return fs, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs *DedupFs) Name() string {
+	return fs.name
+}
+
+// Stat returns a FileInfo describing the named file.
+// The reported size is the one to use for quota tracking
+func (fs *DedupFs) Stat(name string) (os.FileInfo, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return info, err
+	}
+	return fs.getFileInfo(name, info), nil
+}
+
+// Lstat returns a FileInfo describing the named file.
+// The reported size is the one to use for quota tracking
+func (fs *DedupFs) Lstat(name string) (os.FileInfo, error) {
+	info, err := os.Lstat(name)
+	if err != nil {
+		return info, err
+	}
+	return fs.getFileInfo(name, info), nil
+}
+
+// Open opens the named file for reading
+func (fs *DedupFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	ref, isRef, err := loadDedupRef(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if isRef {
+		name = fs.getBlobPath(ref.hash)
+	}
+	return fs.OsFs.Open(name, offset)
+}
+
+// Create creates or opens the named file for writing.
+// The contents are hashed while they are received and the blob is
+// added to the store, if not already present, once the upload completes
+func (fs *DedupFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	if err := fs.mkdirStore(dedupUploadsDirName); err != nil {
+		return nil, nil, nil, err
+	}
+	// the existing reference must be read before truncating the file
+	oldRef, hasOldRef, err := loadDedupRef(name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, nil, err
+	}
+	var f *os.File
+	if flag == 0 {
+		f, err = os.Create(name)
+	} else {
+		f, err = os.OpenFile(name, flag, 0666)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Join(fs.config.StorePath, dedupUploadsDirName), dedupUploadsTempPrefix)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		f.Close()
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+
+	go func() {
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(tmp, h), r)
+		errClose := tmp.Close()
+		if err == nil && errClose != nil {
+			err = errClose
+		}
+		if err == nil {
+			err = fs.storeUpload(f, tmp.Name(), h.Sum(nil), n)
+		} else {
+			os.Remove(tmp.Name())
+		}
+		errClose = f.Close()
+		if err == nil && errClose != nil {
+			err = errClose
+		}
+		if hasOldRef {
+			fs.releaseReplacedRef(name, oldRef, err)
+		}
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, readed bytes: %v, err: %v", name, n, err)
+	}()
+
+	return nil, p, nil, nil
+}
+
+// Rename renames (moves) source to target.
+// A replaced target releases its blob reference
+func (fs *DedupFs) Rename(source, target string) error {
+	if source == target {
+		return nil
+	}
+	oldRef, hasOldRef, _ := loadDedupRef(target)
+	if err := fs.OsFs.Rename(source, target); err != nil {
+		return err
+	}
+	if hasOldRef {
+		fs.releaseRef(oldRef.hash)
+	}
+	return nil
+}
+
+// Remove removes the named file or (empty) directory.
+// The blob is removed from the store when its last reference is removed
+func (fs *DedupFs) Remove(name string, isDir bool) error {
+	if isDir {
+		return fs.OsFs.Remove(name, isDir)
+	}
+	ref, isRef, _ := loadDedupRef(name)
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	if isRef {
+		fs.releaseRef(ref.hash)
+	}
+	return nil
+}
+
+// CopyFile copies the reference to the source blob, the contents are not duplicated
+func (fs *DedupFs) CopyFile(source, target string) error {
+	ref, isRef, err := loadDedupRef(source)
+	if err != nil {
+		return err
+	}
+	if !isRef {
+		return fs.OsFs.CopyFile(source, target)
+	}
+	oldRef, hasOldRef, _ := loadDedupRef(target)
+	if err := fs.addRef(ref.hash); err != nil {
+		return err
+	}
+	ref.flags &^= dedupRefFlagOwner
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err == nil {
+		err = ref.store(dst)
+		errClose := dst.Close()
+		if err == nil {
+			err = errClose
+		}
+	}
+	if err != nil {
+		fs.releaseRef(ref.hash)
+		return err
+	}
+	if hasOldRef {
+		fs.releaseRef(oldRef.hash)
+	}
+	return nil
+}
+
+// Truncate changes the size of the named file
+func (*DedupFs) Truncate(name string, size int64) error {
+	return ErrVfsUnsupported
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs *DedupFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	f, err := os.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	list, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]os.FileInfo, 0, len(list))
+	for _, info := range list {
+		result = append(result, fs.ConvertFileInfo(fs.getFileInfo(filepath.Join(dirname, info.Name()), info)))
+	}
+	return result, nil
+}
+
+// IsUploadResumeSupported returns false, the blobs are immutable
+func (*DedupFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// ScanRootDirContents returns the number of files contained in the root
+// directory and their size according to the configured quota mode
+func (fs *DedupFs) ScanRootDirContents() (int, int64, error) {
+	return fs.GetDirSize(fs.rootDir)
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders, according to the configured quota mode
+func (fs *DedupFs) GetDirSize(dirname string) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	isDir, err := isDirectory(fs, dirname)
+	if err == nil && isDir {
+		err = fs.Walk(dirname, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info != nil && info.Mode().IsRegular() {
+				size += info.Size()
+				numFiles++
+			}
+			return err
+		})
+	}
+	return numFiles, size, err
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root. The reported sizes are the ones
+// to use for quota tracking
+func (fs *DedupFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	return filepath.Walk(root, func(walkedPath string, info os.FileInfo, err error) error {
+		if err == nil && info != nil {
+			info = fs.getFileInfo(walkedPath, info)
+		}
+		return walkFn(walkedPath, info, err)
+	})
+}
+
+// GetMimeType returns the content type
+func (fs *DedupFs) GetMimeType(name string) (string, error) {
+	ref, isRef, err := loadDedupRef(name)
+	if err != nil {
+		return "", err
+	}
+	if isRef {
+		name = fs.getBlobPath(ref.hash)
+	}
+	return fs.OsFs.GetMimeType(name)
+}
+
+// ConvertFileInfo returns a FileInfo with the size of the file contents
+func (fs *DedupFs) ConvertFileInfo(info os.FileInfo) os.FileInfo {
+	if fi, ok := info.(*dedupFileInfo); ok {
+		return &dedupFileInfo{
+			FileInfo:    fi.FileInfo,
+			size:        fi.contentSize,
+			contentSize: fi.contentSize,
+		}
+	}
+	return info
+}
+
+// getFileInfo returns a FileInfo with the size to use for quota tracking,
+// files that are not blob references are reported as is
+func (fs *DedupFs) getFileInfo(name string, info os.FileInfo) os.FileInfo {
+	if !info.Mode().IsRegular() || info.Size() != dedupRefSize {
+		return info
+	}
+	ref, isRef, err := loadDedupRef(name)
+	if err != nil || !isRef {
+		return info
+	}
+	size := ref.size
+	if fs.config.QuotaMode == DedupQuotaModePhysical && ref.flags&dedupRefFlagOwner == 0 {
+		size = 0
+	}
+	return &dedupFileInfo{
+		FileInfo:    info,
+		size:        size,
+		contentSize: ref.size,
+	}
+}
+
+// storeUpload moves the uploaded file to the store, if a blob with the same
+// hash does not exist, and writes the blob reference to f
+func (fs *DedupFs) storeUpload(f *os.File, uploadPath string, hash []byte, size int64) error {
+	ref := dedupRef{
+		version: dedupRefV1,
+		size:    size,
+	}
+	copy(ref.hash[:], hash)
+
+	isNewBlob, err := fs.addBlob(uploadPath, ref.hash)
+	if err != nil {
+		os.Remove(uploadPath)
+		return err
+	}
+	if isNewBlob {
+		ref.flags |= dedupRefFlagOwner
+	}
+	if _, err = f.Seek(0, io.SeekStart); err == nil {
+		if err = ref.store(f); err == nil {
+			err = f.Truncate(dedupRefSize)
+		}
+	}
+	if err != nil {
+		fs.releaseRef(ref.hash)
+		return err
+	}
+	fsLog(fs, logger.LevelDebug, "blob %x stored for file %q, new blob: %t", ref.hash, f.Name(), isNewBlob)
+	return nil
+}
+
+// releaseReplacedRef releases the reference replaced by an upload to name.
+// If the upload failed, the reference is released only if it was overwritten,
+// for example because the file was truncated when opened
+func (fs *DedupFs) releaseReplacedRef(name string, oldRef dedupRef, uploadErr error) {
+	if uploadErr != nil {
+		ref, isRef, _ := loadDedupRef(name)
+		if isRef && ref == oldRef {
+			return
+		}
+	}
+	fs.releaseRef(oldRef.hash)
+}
+
+func (fs *DedupFs) mkdirStore(dirs ...string) error {
+	return os.MkdirAll(filepath.Join(append([]string{fs.config.StorePath}, dirs...)...), os.ModePerm)
+}
+
+func (fs *DedupFs) getBlobPath(hash [sha256.Size]byte) string {
+	h := hex.EncodeToString(hash[:])
+	return filepath.Join(fs.config.StorePath, dedupBlobsDirName, h[:2], h)
+}
+
+func (fs *DedupFs) getRefsPath(hash [sha256.Size]byte) string {
+	h := hex.EncodeToString(hash[:])
+	return filepath.Join(fs.config.StorePath, dedupRefsDirName, h[:2], h)
+}
+
+// addBlob adds a reference to the blob with the specified hash, the
+// uploaded file is moved to the store if the blob does not exist or
+// removed otherwise. It returns true if a new blob was stored
+func (fs *DedupFs) addBlob(uploadPath string, hash [sha256.Size]byte) (bool, error) {
+	dedupStoreMu.Lock()
+	defer dedupStoreMu.Unlock()
+
+	blobPath := fs.getBlobPath(hash)
+	isNewBlob := false
+	if _, err := os.Stat(blobPath); err != nil {
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+		if err := os.MkdirAll(filepath.Dir(blobPath), os.ModePerm); err != nil {
+			return false, err
+		}
+		if err := os.Rename(uploadPath, blobPath); err != nil {
+			return false, err
+		}
+		isNewBlob = true
+	} else {
+		os.Remove(uploadPath)
+	}
+	if err := fs.updateRefsLocked(hash, 1); err != nil {
+		if isNewBlob {
+			os.Remove(blobPath)
+		}
+		return false, err
+	}
+	return isNewBlob, nil
+}
+
+func (fs *DedupFs) addRef(hash [sha256.Size]byte) error {
+	dedupStoreMu.Lock()
+	defer dedupStoreMu.Unlock()
+
+	if _, err := os.Stat(fs.getBlobPath(hash)); err != nil {
+		return err
+	}
+	return fs.updateRefsLocked(hash, 1)
+}
+
+// releaseRef decrements the reference count for the blob with the specified
+// hash and removes the blob if it is no longer referenced
+func (fs *DedupFs) releaseRef(hash [sha256.Size]byte) {
+	dedupStoreMu.Lock()
+	defer dedupStoreMu.Unlock()
+
+	if err := fs.updateRefsLocked(hash, -1); err != nil {
+		fsLog(fs, logger.LevelError, "unable to release reference for blob %x: %v", hash, err)
+	}
+}
+
+func (fs *DedupFs) updateRefsLocked(hash [sha256.Size]byte, delta int64) error {
+	refsPath := fs.getRefsPath(hash)
+	var count int64
+	data, err := os.ReadFile(refsPath)
+	if err == nil {
+		count, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid reference count for blob %x: %w", hash, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	count += delta
+	if count <= 0 {
+		if err := os.Remove(fs.getBlobPath(hash)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		fsLog(fs, logger.LevelDebug, "blob %x removed, no more references", hash)
+		if err := os.Remove(refsPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(refsPath), os.ModePerm); err != nil {
+		return err
+	}
+	tmpPath := refsPath + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(strconv.FormatInt(count, 10)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, refsPath)
+}
+
+// isLocalPathOverlapped returns true if the cleaned paths are equal or one
+// of them is inside the other one
+func isLocalPathOverlapped(p1, p2 string) bool {
+	if p1 == p2 {
+		return true
+	}
+	sep := string(os.PathSeparator)
+	return strings.HasPrefix(p1, strings.TrimSuffix(p2, sep)+sep) || strings.HasPrefix(p2, strings.TrimSuffix(p1, sep)+sep)
+}
+
+type dedupFileInfo struct {
+	os.FileInfo
+	size        int64
+	contentSize int64
+}
+
+// Size returns the file size
+func (fi *dedupFileInfo) Size() int64 {
+	return fi.size
+}
+
+type dedupRef struct {
+	version byte
+	flags   byte
+	size    int64
+	hash    [sha256.Size]byte
+}
+
+func (r *dedupRef) store(f *os.File) error {
+	buf := make([]byte, 0, dedupRefSize)
+	buf = append(buf, dedupRefMagic...)
+	buf = append(buf, r.version, r.flags)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(r.size))
+	buf = append(buf, r.hash[:]...)
+	_, err := f.Write(buf)
+	return err
+}
+
+// loadDedupRef reads the blob reference stored in the named file, false is
+// returned if the file is not a blob reference
+func loadDedupRef(name string) (dedupRef, bool, error) {
+	var ref dedupRef
+	f, err := os.Open(name)
+	if err != nil {
+		return ref, false, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, dedupRefSize+1)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return ref, false, err
+	}
+	if int64(n) != dedupRefSize || !bytes.Equal(buf[:len(dedupRefMagic)], dedupRefMagic) || buf[4] != dedupRefV1 {
+		return ref, false, nil
+	}
+	ref.version = buf[4]
+	ref.flags = buf[5]
+	ref.size = int64(binary.BigEndian.Uint64(buf[6:14]))
+	copy(ref.hash[:], buf[14:dedupRefSize])
+	return ref, true, nil
+}
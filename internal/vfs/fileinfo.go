@@ -26,6 +26,8 @@ type FileInfo struct {
 	sizeInBytes int64
 	modTime     time.Time
 	mode        os.FileMode
+	// target for emulated symlinks
+	symlinkTarget string
 }
 
 // NewFileInfo creates file info.
@@ -20,6 +20,69 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 )
 
+// SMBFilesystemProvider defines the provider for SMB/CIFS shares.
+// It is not yet defined in the SDK so it extends the SDK providers
+const SMBFilesystemProvider sdk.FilesystemProvider = sdk.HTTPFilesystemProvider + 1
+
+// WebDAVFilesystemProvider defines the provider for remote WebDAV servers.
+// It is not yet defined in the SDK so it extends the SDK providers
+const WebDAVFilesystemProvider sdk.FilesystemProvider = SMBFilesystemProvider + 1
+
+// CompressedFilesystemProvider defines the provider for local files stored compressed.
+// It is not yet defined in the SDK so it extends the SDK providers
+const CompressedFilesystemProvider sdk.FilesystemProvider = WebDAVFilesystemProvider + 1
+
+// DedupFilesystemProvider defines the provider for local files stored deduplicated.
+// It is not yet defined in the SDK so it extends the SDK providers
+const DedupFilesystemProvider sdk.FilesystemProvider = CompressedFilesystemProvider + 1
+
+// GetProviderByName returns the FilesystemProvider matching a given name.
+// Numeric strings are accepted as well
+func GetProviderByName(name string) sdk.FilesystemProvider {
+	switch name {
+	case "7", smbFsName:
+		return SMBFilesystemProvider
+	case "8", webDAVFsName:
+		return WebDAVFilesystemProvider
+	case "9", compressedFsName:
+		return CompressedFilesystemProvider
+	case "10", dedupFsName:
+		return DedupFilesystemProvider
+	}
+	return sdk.GetProviderByName(name)
+}
+
+// GetProviderName returns the unique name for the specified provider
+func GetProviderName(p sdk.FilesystemProvider) string {
+	switch p {
+	case SMBFilesystemProvider:
+		return smbFsName
+	case WebDAVFilesystemProvider:
+		return webDAVFsName
+	case CompressedFilesystemProvider:
+		return compressedFsName
+	case DedupFilesystemProvider:
+		return dedupFsName
+	}
+	return p.Name()
+}
+
+// GetProviderShortInfo returns a human readable, short description for
+// the specified provider
+func GetProviderShortInfo(p sdk.FilesystemProvider) string {
+	switch p {
+	case SMBFilesystemProvider:
+		return "SMB/CIFS"
+	case WebDAVFilesystemProvider:
+		return "WebDAV"
+	case CompressedFilesystemProvider:
+		return "Local compressed"
+	case DedupFilesystemProvider:
+		return "Local deduplicated"
+	}
+	return p.ShortInfo()
+}
+
 // Filesystem defines filesystem details
 type Filesystem struct {
 	RedactedSecret string                 `json:"-"`
@@ -30,12 +93,17 @@ type Filesystem struct {
 	CryptConfig    CryptFsConfig          `json:"cryptconfig,omitempty"`
 	SFTPConfig     SFTPFsConfig           `json:"sftpconfig,omitempty"`
 	HTTPConfig     HTTPFsConfig           `json:"httpconfig,omitempty"`
+	SMBConfig      SMBFsConfig            `json:"smbconfig,omitempty"`
+	WebDAVConfig   WebDAVFsConfig         `json:"webdavconfig,omitempty"`
+	CompressConfig CompressedFsConfig     `json:"compressconfig,omitempty"`
+	DedupConfig    DedupFsConfig          `json:"dedupconfig,omitempty"`
 }
 
 // SetEmptySecrets sets the secrets to empty
 func (f *Filesystem) SetEmptySecrets() {
 	f.S3Config.AccessSecret = kms.NewEmptySecret()
 	f.GCSConfig.Credentials = kms.NewEmptySecret()
+	f.GCSConfig.EncryptionKey = kms.NewEmptySecret()
 	f.AzBlobConfig.AccountKey = kms.NewEmptySecret()
 	f.AzBlobConfig.SASURL = kms.NewEmptySecret()
 	f.CryptConfig.Passphrase = kms.NewEmptySecret()
@@ -44,6 +112,8 @@ func (f *Filesystem) SetEmptySecrets() {
 	f.SFTPConfig.KeyPassphrase = kms.NewEmptySecret()
 	f.HTTPConfig.Password = kms.NewEmptySecret()
 	f.HTTPConfig.APIKey = kms.NewEmptySecret()
+	f.SMBConfig.Password = kms.NewEmptySecret()
+	f.WebDAVConfig.Password = kms.NewEmptySecret()
 }
 
 // SetEmptySecretsIfNil sets the secrets to empty if nil
@@ -54,6 +124,9 @@ func (f *Filesystem) SetEmptySecretsIfNil() {
 	if f.GCSConfig.Credentials == nil {
 		f.GCSConfig.Credentials = kms.NewEmptySecret()
 	}
+	if f.GCSConfig.EncryptionKey == nil {
+		f.GCSConfig.EncryptionKey = kms.NewEmptySecret()
+	}
 	if f.AzBlobConfig.AccountKey == nil {
 		f.AzBlobConfig.AccountKey = kms.NewEmptySecret()
 	}
@@ -78,6 +151,12 @@ func (f *Filesystem) SetEmptySecretsIfNil() {
 	if f.HTTPConfig.APIKey == nil {
 		f.HTTPConfig.APIKey = kms.NewEmptySecret()
 	}
+	if f.SMBConfig.Password == nil {
+		f.SMBConfig.Password = kms.NewEmptySecret()
+	}
+	if f.WebDAVConfig.Password == nil {
+		f.WebDAVConfig.Password = kms.NewEmptySecret()
+	}
 }
 
 // SetNilSecretsIfEmpty set the secrets to nil if empty.
@@ -90,6 +169,9 @@ func (f *Filesystem) SetNilSecretsIfEmpty() {
 	if f.GCSConfig.Credentials != nil && f.GCSConfig.Credentials.IsEmpty() {
 		f.GCSConfig.Credentials = nil
 	}
+	if f.GCSConfig.EncryptionKey != nil && f.GCSConfig.EncryptionKey.IsEmpty() {
+		f.GCSConfig.EncryptionKey = nil
+	}
 	if f.AzBlobConfig.AccountKey != nil && f.AzBlobConfig.AccountKey.IsEmpty() {
 		f.AzBlobConfig.AccountKey = nil
 	}
@@ -101,6 +183,28 @@ func (f *Filesystem) SetNilSecretsIfEmpty() {
 	}
 	f.SFTPConfig.setNilSecretsIfEmpty()
 	f.HTTPConfig.setNilSecretsIfEmpty()
+	f.SMBConfig.setNilSecretsIfEmpty()
+	f.WebDAVConfig.setNilSecretsIfEmpty()
+}
+
+// GetSecrets returns all the secrets for the filesystem configuration,
+// including the ones not used by the configured provider. Secrets can be nil
+func (f *Filesystem) GetSecrets() []*kms.Secret {
+	return []*kms.Secret{
+		f.S3Config.AccessSecret,
+		f.GCSConfig.Credentials,
+		f.GCSConfig.EncryptionKey,
+		f.AzBlobConfig.AccountKey,
+		f.AzBlobConfig.SASURL,
+		f.CryptConfig.Passphrase,
+		f.SFTPConfig.Password,
+		f.SFTPConfig.PrivateKey,
+		f.SFTPConfig.KeyPassphrase,
+		f.HTTPConfig.Password,
+		f.HTTPConfig.APIKey,
+		f.SMBConfig.Password,
+		f.WebDAVConfig.Password,
+	}
 }
 
 // IsEqual returns true if the fs is equal to other
@@ -121,6 +225,14 @@ func (f *Filesystem) IsEqual(other Filesystem) bool {
 		return f.SFTPConfig.isEqual(other.SFTPConfig)
 	case sdk.HTTPFilesystemProvider:
 		return f.HTTPConfig.isEqual(other.HTTPConfig)
+	case SMBFilesystemProvider:
+		return f.SMBConfig.isEqual(other.SMBConfig)
+	case WebDAVFilesystemProvider:
+		return f.WebDAVConfig.isEqual(other.WebDAVConfig)
+	case CompressedFilesystemProvider:
+		return f.CompressConfig.isEqual(other.CompressConfig)
+	case DedupFilesystemProvider:
+		return f.DedupConfig.isEqual(other.DedupConfig)
 	default:
 		return true
 	}
@@ -144,6 +256,14 @@ func (f *Filesystem) IsSameResource(other Filesystem) bool {
 		return f.SFTPConfig.isSameResource(other.SFTPConfig)
 	case sdk.HTTPFilesystemProvider:
 		return f.HTTPConfig.isSameResource(other.HTTPConfig)
+	case SMBFilesystemProvider:
+		return f.SMBConfig.isSameResource(other.SMBConfig)
+	case WebDAVFilesystemProvider:
+		return f.WebDAVConfig.isSameResource(other.WebDAVConfig)
+	case CompressedFilesystemProvider:
+		return f.CompressConfig.isSameResource(other.CompressConfig)
+	case DedupFilesystemProvider:
+		return f.DedupConfig.isSameResource(other.DedupConfig)
 	default:
 		return true
 	}
@@ -162,6 +282,10 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.CryptConfig = CryptFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.CompressConfig = CompressedFsConfig{}
+		f.DedupConfig = DedupFsConfig{}
 		return nil
 	case sdk.GCSFilesystemProvider:
 		if err := f.GCSConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
@@ -172,6 +296,10 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.CryptConfig = CryptFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.CompressConfig = CompressedFsConfig{}
+		f.DedupConfig = DedupFsConfig{}
 		return nil
 	case sdk.AzureBlobFilesystemProvider:
 		if err := f.AzBlobConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
@@ -182,6 +310,10 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.CryptConfig = CryptFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.CompressConfig = CompressedFsConfig{}
+		f.DedupConfig = DedupFsConfig{}
 		return nil
 	case sdk.CryptedFilesystemProvider:
 		if err := f.CryptConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
@@ -192,6 +324,10 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.AzBlobConfig = AzBlobFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.CompressConfig = CompressedFsConfig{}
+		f.DedupConfig = DedupFsConfig{}
 		return nil
 	case sdk.SFTPFilesystemProvider:
 		if err := f.SFTPConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
@@ -202,6 +338,10 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.AzBlobConfig = AzBlobFsConfig{}
 		f.CryptConfig = CryptFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.CompressConfig = CompressedFsConfig{}
+		f.DedupConfig = DedupFsConfig{}
 		return nil
 	case sdk.HTTPFilesystemProvider:
 		if err := f.HTTPConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
@@ -212,6 +352,66 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.AzBlobConfig = AzBlobFsConfig{}
 		f.CryptConfig = CryptFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.CompressConfig = CompressedFsConfig{}
+		f.DedupConfig = DedupFsConfig{}
+		return nil
+	case SMBFilesystemProvider:
+		if err := f.SMBConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
+			return err
+		}
+		f.S3Config = S3FsConfig{}
+		f.GCSConfig = GCSFsConfig{}
+		f.AzBlobConfig = AzBlobFsConfig{}
+		f.CryptConfig = CryptFsConfig{}
+		f.SFTPConfig = SFTPFsConfig{}
+		f.HTTPConfig = HTTPFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.CompressConfig = CompressedFsConfig{}
+		f.DedupConfig = DedupFsConfig{}
+		return nil
+	case WebDAVFilesystemProvider:
+		if err := f.WebDAVConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
+			return err
+		}
+		f.S3Config = S3FsConfig{}
+		f.GCSConfig = GCSFsConfig{}
+		f.AzBlobConfig = AzBlobFsConfig{}
+		f.CryptConfig = CryptFsConfig{}
+		f.SFTPConfig = SFTPFsConfig{}
+		f.HTTPConfig = HTTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.CompressConfig = CompressedFsConfig{}
+		f.DedupConfig = DedupFsConfig{}
+		return nil
+	case CompressedFilesystemProvider:
+		if err := f.CompressConfig.Validate(); err != nil {
+			return err
+		}
+		f.S3Config = S3FsConfig{}
+		f.GCSConfig = GCSFsConfig{}
+		f.AzBlobConfig = AzBlobFsConfig{}
+		f.CryptConfig = CryptFsConfig{}
+		f.SFTPConfig = SFTPFsConfig{}
+		f.HTTPConfig = HTTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.DedupConfig = DedupFsConfig{}
+		return nil
+	case DedupFilesystemProvider:
+		if err := f.DedupConfig.Validate(); err != nil {
+			return err
+		}
+		f.S3Config = S3FsConfig{}
+		f.GCSConfig = GCSFsConfig{}
+		f.AzBlobConfig = AzBlobFsConfig{}
+		f.CryptConfig = CryptFsConfig{}
+		f.SFTPConfig = SFTPFsConfig{}
+		f.HTTPConfig = HTTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.CompressConfig = CompressedFsConfig{}
 		return nil
 	default:
 		f.Provider = sdk.LocalFilesystemProvider
@@ -221,6 +421,10 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.CryptConfig = CryptFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.CompressConfig = CompressedFsConfig{}
+		f.DedupConfig = DedupFsConfig{}
 		return nil
 	}
 }
@@ -232,7 +436,10 @@ func (f *Filesystem) HasRedactedSecret() bool {
 	case sdk.S3FilesystemProvider:
 		return f.S3Config.AccessSecret.IsRedacted()
 	case sdk.GCSFilesystemProvider:
-		return f.GCSConfig.Credentials.IsRedacted()
+		if f.GCSConfig.Credentials.IsRedacted() {
+			return true
+		}
+		return f.GCSConfig.EncryptionKey.IsRedacted()
 	case sdk.AzureBlobFilesystemProvider:
 		if f.AzBlobConfig.AccountKey.IsRedacted() {
 			return true
@@ -253,6 +460,10 @@ func (f *Filesystem) HasRedactedSecret() bool {
 			return true
 		}
 		return f.HTTPConfig.APIKey.IsRedacted()
+	case SMBFilesystemProvider:
+		return f.SMBConfig.Password.IsRedacted()
+	case WebDAVFilesystemProvider:
+		return f.WebDAVConfig.Password.IsRedacted()
 	}
 
 	return false
@@ -273,6 +484,10 @@ func (f *Filesystem) HideConfidentialData() {
 		f.SFTPConfig.HideConfidentialData()
 	case sdk.HTTPFilesystemProvider:
 		f.HTTPConfig.HideConfidentialData()
+	case SMBFilesystemProvider:
+		f.SMBConfig.HideConfidentialData()
+	case WebDAVFilesystemProvider:
+		f.WebDAVConfig.HideConfidentialData()
 	}
 }
 
@@ -299,7 +514,8 @@ func (f *Filesystem) GetACopy() Filesystem {
 				UploadPartMaxTime:   f.S3Config.UploadPartMaxTime,
 				ForcePathStyle:      f.S3Config.ForcePathStyle,
 			},
-			AccessSecret: f.S3Config.AccessSecret.Clone(),
+			AccessSecret:    f.S3Config.AccessSecret.Clone(),
+			EmulateSymlinks: f.S3Config.EmulateSymlinks,
 		},
 		GCSConfig: GCSFsConfig{
 			BaseGCSFsConfig: sdk.BaseGCSFsConfig{
@@ -309,7 +525,10 @@ func (f *Filesystem) GetACopy() Filesystem {
 				ACL:                  f.GCSConfig.ACL,
 				KeyPrefix:            f.GCSConfig.KeyPrefix,
 			},
-			Credentials: f.GCSConfig.Credentials.Clone(),
+			Credentials:     f.GCSConfig.Credentials.Clone(),
+			EncryptionKey:   f.GCSConfig.EncryptionKey.Clone(),
+			KMSKeyName:      f.GCSConfig.KMSKeyName,
+			EmulateSymlinks: f.GCSConfig.EmulateSymlinks,
 		},
 		AzBlobConfig: AzBlobFsConfig{
 			BaseAzBlobFsConfig: sdk.BaseAzBlobFsConfig{
@@ -324,8 +543,9 @@ func (f *Filesystem) GetACopy() Filesystem {
 				UseEmulator:         f.AzBlobConfig.UseEmulator,
 				AccessTier:          f.AzBlobConfig.AccessTier,
 			},
-			AccountKey: f.AzBlobConfig.AccountKey.Clone(),
-			SASURL:     f.AzBlobConfig.SASURL.Clone(),
+			AccountKey:      f.AzBlobConfig.AccountKey.Clone(),
+			SASURL:          f.AzBlobConfig.SASURL.Clone(),
+			EmulateSymlinks: f.AzBlobConfig.EmulateSymlinks,
 		},
 		CryptConfig: CryptFsConfig{
 			Passphrase: f.CryptConfig.Passphrase.Clone(),
@@ -339,9 +559,10 @@ func (f *Filesystem) GetACopy() Filesystem {
 				BufferSize:              f.SFTPConfig.BufferSize,
 				EqualityCheckMode:       f.SFTPConfig.EqualityCheckMode,
 			},
-			Password:      f.SFTPConfig.Password.Clone(),
-			PrivateKey:    f.SFTPConfig.PrivateKey.Clone(),
-			KeyPassphrase: f.SFTPConfig.KeyPassphrase.Clone(),
+			Password:        f.SFTPConfig.Password.Clone(),
+			PrivateKey:      f.SFTPConfig.PrivateKey.Clone(),
+			KeyPassphrase:   f.SFTPConfig.KeyPassphrase.Clone(),
+			UserCredentials: f.SFTPConfig.UserCredentials,
 		},
 		HTTPConfig: HTTPFsConfig{
 			BaseHTTPFsConfig: sdk.BaseHTTPFsConfig{
@@ -353,10 +574,42 @@ func (f *Filesystem) GetACopy() Filesystem {
 			Password: f.HTTPConfig.Password.Clone(),
 			APIKey:   f.HTTPConfig.APIKey.Clone(),
 		},
+		SMBConfig: SMBFsConfig{
+			Endpoint:          f.SMBConfig.Endpoint,
+			Share:             f.SMBConfig.Share,
+			Domain:            f.SMBConfig.Domain,
+			Username:          f.SMBConfig.Username,
+			Password:          f.SMBConfig.Password.Clone(),
+			Prefix:            f.SMBConfig.Prefix,
+			EqualityCheckMode: f.SMBConfig.EqualityCheckMode,
+		},
+		WebDAVConfig: WebDAVFsConfig{
+			Endpoint:          f.WebDAVConfig.Endpoint,
+			Username:          f.WebDAVConfig.Username,
+			Password:          f.WebDAVConfig.Password.Clone(),
+			Prefix:            f.WebDAVConfig.Prefix,
+			SkipTLSVerify:     f.WebDAVConfig.SkipTLSVerify,
+			CACertificates:    f.WebDAVConfig.CACertificates,
+			UploadChunkSize:   f.WebDAVConfig.UploadChunkSize,
+			UploadsEndpoint:   f.WebDAVConfig.UploadsEndpoint,
+			EqualityCheckMode: f.WebDAVConfig.EqualityCheckMode,
+		},
+		CompressConfig: CompressedFsConfig{
+			Algorithm: f.CompressConfig.Algorithm,
+			Level:     f.CompressConfig.Level,
+		},
+		DedupConfig: DedupFsConfig{
+			StorePath: f.DedupConfig.StorePath,
+			QuotaMode: f.DedupConfig.QuotaMode,
+		},
 	}
 	if len(f.SFTPConfig.Fingerprints) > 0 {
 		fs.SFTPConfig.Fingerprints = make([]string, len(f.SFTPConfig.Fingerprints))
 		copy(fs.SFTPConfig.Fingerprints, f.SFTPConfig.Fingerprints)
 	}
+	if len(f.AzBlobConfig.TieringRules) > 0 {
+		fs.AzBlobConfig.TieringRules = make([]AzBlobTieringRule, len(f.AzBlobConfig.TieringRules))
+		copy(fs.AzBlobConfig.TieringRules, f.AzBlobConfig.TieringRules)
+	}
 	return fs
 }
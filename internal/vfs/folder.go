@@ -44,6 +44,18 @@ type BaseVirtualFolder struct {
 	Groups []string `json:"groups,omitempty"`
 	// Filesystem configuration details
 	FsConfig Filesystem `json:"filesystem"`
+	// Custom key/value metadata, for example external system IDs or billing codes
+	Metadata util.Metadata `json:"metadata,omitempty"`
+	// Timestamp, as unix milliseconds, of the soft deletion. A soft deleted folder
+	// is not available to the mapped users and groups and it is permanently
+	// removed after the retention period
+	SoftDeletedAt int64 `json:"soft_deleted_at,omitempty"`
+}
+
+// IsSoftDeleted returns true if the folder is soft deleted and waiting for
+// permanent removal
+func (v *BaseVirtualFolder) IsSoftDeleted() bool {
+	return v.SoftDeletedAt > 0
 }
 
 // GetEncryptionAdditionalData returns the additional data to use for AEAD
@@ -68,6 +80,8 @@ func (v *BaseVirtualFolder) GetACopy() BaseVirtualFolder {
 		Users:           users,
 		Groups:          v.Groups,
 		FsConfig:        v.FsConfig.GetACopy(),
+		Metadata:        v.Metadata.GetACopy(),
+		SoftDeletedAt:   v.SoftDeletedAt,
 	}
 }
 
@@ -89,6 +103,14 @@ func (v *BaseVirtualFolder) GetLastQuotaUpdateAsString() string {
 	return ""
 }
 
+// GetSoftDeletedAtAsString returns the soft deletion time as string
+func (v *BaseVirtualFolder) GetSoftDeletedAtAsString() string {
+	if v.SoftDeletedAt > 0 {
+		return util.GetTimeFromMsecSinceEpoch(v.SoftDeletedAt).UTC().Format("2006-01-02 15:04:05Z")
+	}
+	return ""
+}
+
 // GetQuotaSummary returns used quota and last update as string
 func (v *BaseVirtualFolder) GetQuotaSummary() string {
 	var result string
@@ -116,14 +138,29 @@ func (v *BaseVirtualFolder) GetStorageDescrition() string {
 		return fmt.Sprintf("SFTP: %s", v.FsConfig.SFTPConfig.Endpoint)
 	case sdk.HTTPFilesystemProvider:
 		return fmt.Sprintf("HTTP: %s", v.FsConfig.HTTPConfig.Endpoint)
+	case SMBFilesystemProvider:
+		return fmt.Sprintf("SMB: %s/%s", v.FsConfig.SMBConfig.Endpoint, v.FsConfig.SMBConfig.Share)
+	case WebDAVFilesystemProvider:
+		return fmt.Sprintf("WebDAV: %s", v.FsConfig.WebDAVConfig.Endpoint)
+	case CompressedFilesystemProvider:
+		return fmt.Sprintf("Compressed: %s", v.MappedPath)
+	case DedupFilesystemProvider:
+		return fmt.Sprintf("Deduplicated: %s", v.MappedPath)
 	default:
 		return ""
 	}
 }
 
-// IsLocalOrLocalCrypted returns true if the folder provider is local or local encrypted
+// IsLocalOrLocalCrypted returns true if the folder provider is local, local encrypted,
+// local compressed or local deduplicated
 func (v *BaseVirtualFolder) IsLocalOrLocalCrypted() bool {
-	return v.FsConfig.Provider == sdk.LocalFilesystemProvider || v.FsConfig.Provider == sdk.CryptedFilesystemProvider
+	switch v.FsConfig.Provider {
+	case sdk.LocalFilesystemProvider, sdk.CryptedFilesystemProvider, CompressedFilesystemProvider,
+		DedupFilesystemProvider:
+		return true
+	default:
+		return false
+	}
 }
 
 // hideConfidentialData hides folder confidential data
@@ -141,6 +178,10 @@ func (v *BaseVirtualFolder) hideConfidentialData() {
 		v.FsConfig.SFTPConfig.HideConfidentialData()
 	case sdk.HTTPFilesystemProvider:
 		v.FsConfig.HTTPConfig.HideConfidentialData()
+	case SMBFilesystemProvider:
+		v.FsConfig.SMBConfig.HideConfidentialData()
+	case WebDAVFilesystemProvider:
+		v.FsConfig.WebDAVConfig.HideConfidentialData()
 	}
 }
 
@@ -157,6 +198,12 @@ func (v *BaseVirtualFolder) HasRedactedSecret() bool {
 	return v.FsConfig.HasRedactedSecret()
 }
 
+// HasUserCredentials returns true if the folder is an SFTP folder and the
+// remote credentials must be resolved for the authenticated user
+func (v *BaseVirtualFolder) HasUserCredentials() bool {
+	return v.FsConfig.Provider == sdk.SFTPFilesystemProvider && v.FsConfig.SFTPConfig.UserCredentials
+}
+
 // hasPathPlaceholder returns true if the folder has a path placeholder
 func (v *BaseVirtualFolder) hasPathPlaceholder() bool {
 	placeholder := "%username%"
@@ -169,8 +216,14 @@ func (v *BaseVirtualFolder) hasPathPlaceholder() bool {
 		return strings.Contains(v.FsConfig.AzBlobConfig.KeyPrefix, placeholder)
 	case sdk.SFTPFilesystemProvider:
 		return strings.Contains(v.FsConfig.SFTPConfig.Prefix, placeholder)
-	case sdk.LocalFilesystemProvider, sdk.CryptedFilesystemProvider:
+	case SMBFilesystemProvider:
+		return strings.Contains(v.FsConfig.SMBConfig.Prefix, placeholder)
+	case WebDAVFilesystemProvider:
+		return strings.Contains(v.FsConfig.WebDAVConfig.Prefix, placeholder)
+	case sdk.LocalFilesystemProvider, sdk.CryptedFilesystemProvider, CompressedFilesystemProvider:
 		return strings.Contains(v.MappedPath, placeholder)
+	case DedupFilesystemProvider:
+		return strings.Contains(v.MappedPath, placeholder) || strings.Contains(v.FsConfig.DedupConfig.StorePath, placeholder)
 	}
 	return false
 }
@@ -188,6 +241,13 @@ type VirtualFolder struct {
 	QuotaSize int64 `json:"quota_size"`
 	// Maximum number of files allowed. 0 means unlimited, -1 included in user quota
 	QuotaFiles int `json:"quota_files"`
+	// Maximum size, as bytes, that each user can upload inside the folder.
+	// This limit is independent from the quota shared among all the users
+	// mapped to the folder. 0 means unlimited
+	UserQuotaSize int64 `json:"user_quota_size,omitempty"`
+	// Maximum number of files that each user can upload inside the folder.
+	// 0 means unlimited
+	UserQuotaFiles int `json:"user_quota_files,omitempty"`
 }
 
 // GetFilesystem returns the filesystem for this folder
@@ -205,6 +265,14 @@ func (v *VirtualFolder) GetFilesystem(connectionID string, forbiddenSelfUsers []
 		return NewSFTPFs(connectionID, v.VirtualPath, v.MappedPath, forbiddenSelfUsers, v.FsConfig.SFTPConfig)
 	case sdk.HTTPFilesystemProvider:
 		return NewHTTPFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.HTTPConfig)
+	case SMBFilesystemProvider:
+		return NewSMBFs(connectionID, v.VirtualPath, v.MappedPath, v.FsConfig.SMBConfig)
+	case WebDAVFilesystemProvider:
+		return NewWebDAVFs(connectionID, v.VirtualPath, v.MappedPath, v.FsConfig.WebDAVConfig)
+	case CompressedFilesystemProvider:
+		return NewCompressedFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.CompressConfig)
+	case DedupFilesystemProvider:
+		return NewDedupFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.DedupConfig)
 	default:
 		return NewOsFs(connectionID, v.MappedPath, v.VirtualPath), nil
 	}
@@ -227,6 +295,9 @@ func (v *VirtualFolder) ScanQuota() (int, int64, error) {
 	if v.hasPathPlaceholder() {
 		return 0, 0, errors.New("cannot scan quota: this folder has a path placeholder")
 	}
+	if v.HasUserCredentials() {
+		return 0, 0, errors.New("cannot scan quota: this folder uses per-user remote credentials")
+	}
 	fs, err := v.GetFilesystem("", nil)
 	if err != nil {
 		return 0, 0, err
@@ -249,6 +320,12 @@ func (v *VirtualFolder) HasNoQuotaRestrictions(checkFiles bool) bool {
 	return false
 }
 
+// HasUserQuotaRestrictions returns true if per-user quota limits are defined
+// for this folder mapping
+func (v *VirtualFolder) HasUserQuotaRestrictions() bool {
+	return v.UserQuotaSize > 0 || v.UserQuotaFiles > 0
+}
+
 // GetACopy returns a copy
 func (v *VirtualFolder) GetACopy() VirtualFolder {
 	return VirtualFolder{
@@ -256,5 +333,7 @@ func (v *VirtualFolder) GetACopy() VirtualFolder {
 		VirtualPath:       v.VirtualPath,
 		QuotaSize:         v.QuotaSize,
 		QuotaFiles:        v.QuotaFiles,
+		UserQuotaSize:     v.UserQuotaSize,
+		UserQuotaFiles:    v.UserQuotaFiles,
 	}
 }
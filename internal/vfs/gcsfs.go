@@ -19,6 +19,8 @@ package vfs
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"mime"
@@ -26,19 +28,23 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/eikenb/pipeat"
 	"github.com/pkg/sftp"
+	"github.com/sftpgo/sdk"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/resolver"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/version"
 )
@@ -62,6 +68,8 @@ type GCSFs struct {
 	svc            *storage.Client
 	ctxTimeout     time.Duration
 	ctxLongTimeout time.Duration
+	// decoded customer-supplied encryption key, if any
+	encryptionKey []byte
 }
 
 func init() {
@@ -91,18 +99,45 @@ func NewGCSFs(connectionID, localTempDir, mountPath string, config GCSFsConfig)
 		return fs, err
 	}
 	ctx := context.Background()
-	if fs.config.AutomaticCredentials > 0 {
-		fs.svc, err = storage.NewClient(ctx)
-	} else {
+	var opts []option.ClientOption
+	if fs.config.AutomaticCredentials <= 0 {
 		err = fs.config.Credentials.TryDecrypt()
 		if err != nil {
 			return fs, err
 		}
-		fs.svc, err = storage.NewClient(ctx, option.WithCredentialsJSON([]byte(fs.config.Credentials.GetPayload())))
+		opts = append(opts, option.WithCredentialsJSON([]byte(fs.config.Credentials.GetPayload())))
+	}
+	if !fs.config.EncryptionKey.IsEmpty() {
+		if err = fs.config.EncryptionKey.TryDecrypt(); err != nil {
+			return fs, err
+		}
+		fs.encryptionKey, err = base64.StdEncoding.DecodeString(fs.config.EncryptionKey.GetPayload())
+		if err != nil {
+			return fs, fmt.Errorf("unable to decode the GCS encryption key: %w", err)
+		}
 	}
+	if resolver.IsEnabled() {
+		opts, err = getGCSResolverClientOptions(ctx, opts)
+		if err != nil {
+			return fs, err
+		}
+	}
+	fs.svc, err = storage.NewClient(ctx, opts...)
 	return fs, err
 }
 
+// getGCSResolverClientOptions returns the client options to use an HTTP client
+// that resolves the host names using the configured DNS settings. The provided
+// options are used to authenticate the requests
+func getGCSResolverClientOptions(ctx context.Context, opts []option.ClientOption) ([]option.ClientOption, error) {
+	opts = append([]option.ClientOption{option.WithScopes(storage.ScopeFullControl)}, opts...)
+	transport, err := htransport.NewTransport(ctx, getResolverTransport(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create GCS transport: %w", err)
+	}
+	return []option.ClientOption{option.WithHTTPClient(&http.Client{Transport: transport})}, nil
+}
+
 // Name returns the name for the Fs implementation
 func (fs *GCSFs) Name() string {
 	return fmt.Sprintf("%s bucket %q", gcsfsName, fs.config.Bucket)
@@ -115,6 +150,15 @@ func (fs *GCSFs) ConnectionID() string {
 
 // Stat returns a FileInfo describing the named file
 func (fs *GCSFs) Stat(name string) (os.FileInfo, error) {
+	if fs.config.EmulateSymlinks {
+		return statEmulatedSymlinks(fs, name, fs.config.KeyPrefix)
+	}
+	return fs.Lstat(name)
+}
+
+// Lstat returns a FileInfo describing the named file.
+// Emulated symlinks, if enabled, are not followed
+func (fs *GCSFs) Lstat(name string) (os.FileInfo, error) {
 	if name == "" || name == "/" || name == "." {
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
 	}
@@ -125,19 +169,29 @@ func (fs *GCSFs) Stat(name string) (os.FileInfo, error) {
 	return info, err
 }
 
-// Lstat returns a FileInfo describing the named file
-func (fs *GCSFs) Lstat(name string) (os.FileInfo, error) {
-	return fs.Stat(name)
-}
-
 // Open opens the named file for reading
 func (fs *GCSFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	if fs.config.EmulateSymlinks {
+		resolved, _, err := resolveEmulatedSymlinks(fs, name, fs.config.KeyPrefix)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		name = resolved
+	}
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	bkt := fs.svc.Bucket(fs.config.Bucket)
-	obj := bkt.Object(name)
+	cacheKey := fs.getReadCacheKey(name)
+	cachedReader, cachedCancelFn, cw := readCache.open(cacheKey, offset, fs.localTempDir, func() (os.FileInfo, error) {
+		return fs.Stat(name)
+	}, w)
+	if cachedReader != nil {
+		r.Close()
+		w.Close()
+		return nil, cachedReader, cachedCancelFn, nil
+	}
+	obj := fs.getObjectHandle(name)
 	ctx, cancelFn := context.WithCancel(context.Background())
 	objectReader, err := obj.NewRangeReader(ctx, offset, -1)
 	if err == nil && offset > 0 && objectReader.Attrs.ContentEncoding == "gzip" {
@@ -145,6 +199,7 @@ func (fs *GCSFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, fu
 		objectReader.Close()
 	}
 	if err != nil {
+		cw.Done(err)
 		r.Close()
 		w.Close()
 		cancelFn()
@@ -154,7 +209,8 @@ func (fs *GCSFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, fu
 		defer cancelFn()
 		defer objectReader.Close()
 
-		n, err := io.Copy(w, objectReader)
+		n, err := io.Copy(cw, objectReader)
+		cw.Done(err)
 		w.CloseWithError(err) //nolint:errcheck
 		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %+v", name, n, err)
 		metric.GCSTransferCompleted(n, 1, err)
@@ -169,8 +225,9 @@ func (fs *GCSFs) Create(name string, flag int) (File, *PipeWriter, func(), error
 		return nil, nil, nil, err
 	}
 	p := NewPipeWriter(w)
-	bkt := fs.svc.Bucket(fs.config.Bucket)
-	obj := bkt.Object(name)
+	cacheKey := fs.getReadCacheKey(name)
+	readCache.invalidate(cacheKey)
+	obj := fs.getObjectHandle(name)
 	ctx, cancelFn := context.WithCancel(context.Background())
 	objectWriter := obj.NewWriter(ctx)
 	var contentType string
@@ -188,6 +245,9 @@ func (fs *GCSFs) Create(name string, flag int) (File, *PipeWriter, func(), error
 	if fs.config.ACL != "" {
 		objectWriter.PredefinedACL = fs.config.ACL
 	}
+	if fs.config.KMSKeyName != "" {
+		objectWriter.KMSKeyName = fs.config.KMSKeyName
+	}
 	go func() {
 		defer cancelFn()
 
@@ -196,6 +256,7 @@ func (fs *GCSFs) Create(name string, flag int) (File, *PipeWriter, func(), error
 		if err == nil {
 			err = closeErr
 		}
+		readCache.invalidate(cacheKey)
 		r.CloseWithError(err) //nolint:errcheck
 		p.Done(err)
 		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, acl: %#v, readed bytes: %v, err: %+v",
@@ -226,12 +287,41 @@ func (fs *GCSFs) Rename(source, target string) error {
 		if hasContents {
 			return fmt.Errorf("cannot rename non empty directory: %#v", source)
 		}
+	}
+	if err := fs.copyFileInternal(realSourceName, target, fi); err != nil {
+		return err
+	}
+	return fs.Remove(source, fi.IsDir())
+}
+
+// CopyFile copies source to target using a server side copy, only files are supported
+func (fs *GCSFs) CopyFile(source, target string) error {
+	if fs.config.EmulateSymlinks {
+		resolved, _, err := resolveEmulatedSymlinks(fs, source, fs.config.KeyPrefix)
+		if err != nil {
+			return err
+		}
+		source = resolved
+	}
+	realSourceName, fi, err := fs.getObjectStat(source)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("cannot copy %q: %w", source, ErrVfsUnsupported)
+	}
+	return fs.copyFileInternal(realSourceName, target, fi)
+}
+
+func (fs *GCSFs) copyFileInternal(realSourceName, target string, fi os.FileInfo) error {
+	if fi.IsDir() {
 		if !strings.HasSuffix(target, "/") {
 			target += "/"
 		}
 	}
-	src := fs.svc.Bucket(fs.config.Bucket).Object(realSourceName)
-	dst := fs.svc.Bucket(fs.config.Bucket).Object(target)
+	readCache.invalidate(fs.getReadCacheKey(target))
+	src := fs.getObjectHandle(realSourceName)
+	dst := fs.getObjectHandle(target)
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
@@ -242,16 +332,27 @@ func (fs *GCSFs) Rename(source, target string) error {
 	if fs.config.ACL != "" {
 		copier.PredefinedACL = fs.config.ACL
 	}
+	if fs.config.KMSKeyName != "" {
+		copier.DestinationKMSKeyName = fs.config.KMSKeyName
+	}
 	var contentType string
 	if fi.IsDir() {
 		contentType = dirMimeType
 	} else {
-		contentType = mime.TypeByExtension(path.Ext(source))
+		contentType = mime.TypeByExtension(path.Ext(realSourceName))
 	}
 	if contentType != "" {
 		copier.ContentType = contentType
 	}
-	_, err = copier.Run(ctx)
+	if !fi.IsDir() {
+		// setting the destination attributes replaces the source metadata
+		attrs, err := fs.headObject(realSourceName)
+		if err != nil {
+			return err
+		}
+		copier.Metadata = attrs.Metadata
+	}
+	_, err := copier.Run(ctx)
 	metric.GCSCopyObjectCompleted(err)
 	if err != nil {
 		return err
@@ -261,12 +362,12 @@ func (fs *GCSFs) Rename(source, target string) error {
 			err = plugin.Handler.SetModificationTime(fs.getStorageID(), ensureAbsPath(target),
 				util.GetTimeAsMsSinceEpoch(fi.ModTime()))
 			if err != nil {
-				fsLog(fs, logger.LevelWarn, "unable to preserve modification time after renaming %#v -> %#v: %+v",
-					source, target, err)
+				fsLog(fs, logger.LevelWarn, "unable to preserve modification time after copying %#v -> %#v: %+v",
+					realSourceName, target, err)
 			}
 		}
 	}
-	return fs.Remove(source, fi.IsDir())
+	return nil
 }
 
 // Remove removes the named file or (empty) directory.
@@ -292,6 +393,7 @@ func (fs *GCSFs) Remove(name string, isDir bool) error {
 		err = fs.svc.Bucket(fs.config.Bucket).Object(strings.TrimSuffix(name, "/")).Delete(ctx)
 	}
 	metric.GCSDeleteObjectCompleted(err)
+	readCache.invalidate(fs.getReadCacheKey(name))
 	if plugin.Handler.HasMetadater() && err == nil && !isDir {
 		if errMetadata := plugin.Handler.RemoveMetadata(fs.getStorageID(), ensureAbsPath(name)); errMetadata != nil {
 			fsLog(fs, logger.LevelWarn, "unable to remove metadata for path %#v: %+v", name, errMetadata)
@@ -317,13 +419,49 @@ func (fs *GCSFs) Mkdir(name string) error {
 }
 
 // Symlink creates source as a symbolic link to target.
-func (*GCSFs) Symlink(source, target string) error {
-	return ErrVfsUnsupported
+// Symlinks are emulated using zero bytes marker objects, if enabled
+func (fs *GCSFs) Symlink(source, target string) error {
+	if !fs.config.EmulateSymlinks {
+		return ErrVfsUnsupported
+	}
+	if _, err := fs.Lstat(target); !fs.IsNotExist(err) {
+		if err == nil {
+			return fmt.Errorf("cannot create symlink %q: %w", target, os.ErrExist)
+		}
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	objectWriter := fs.getObjectHandle(target).NewWriter(ctx)
+	objectWriter.ObjectAttrs.Metadata = map[string]string{
+		symlinkTargetMetadataKey: getSymlinkMarkerTarget(source, fs.config.KeyPrefix),
+	}
+	if fs.config.StorageClass != "" {
+		objectWriter.ObjectAttrs.StorageClass = fs.config.StorageClass
+	}
+	if fs.config.ACL != "" {
+		objectWriter.PredefinedACL = fs.config.ACL
+	}
+	if fs.config.KMSKeyName != "" {
+		objectWriter.KMSKeyName = fs.config.KMSKeyName
+	}
+	err := objectWriter.Close()
+	readCache.invalidate(fs.getReadCacheKey(target))
+	metric.GCSTransferCompleted(0, 0, err)
+	return err
 }
 
 // Readlink returns the destination of the named symbolic link
-func (*GCSFs) Readlink(name string) (string, error) {
-	return "", ErrVfsUnsupported
+func (fs *GCSFs) Readlink(name string) (string, error) {
+	if !fs.config.EmulateSymlinks {
+		return "", ErrVfsUnsupported
+	}
+	info, err := fs.Lstat(name)
+	if err != nil {
+		return "", err
+	}
+	return readEmulatedSymlink(name, info, fs.mountPath)
 }
 
 // Chown changes the numeric uid and gid of the named file.
@@ -367,10 +505,14 @@ func (*GCSFs) Truncate(name string, size int64) error {
 func (fs *GCSFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	var result []os.FileInfo
 	// dirname must be already cleaned
+	dirname, err := getDirToList(fs, dirname, fs.config.KeyPrefix, fs.config.EmulateSymlinks)
+	if err != nil {
+		return result, err
+	}
 	prefix := fs.getPrefix(dirname)
 
 	query := &storage.Query{Prefix: prefix, Delimiter: "/"}
-	err := query.SetAttrSelection(gcsDefaultFieldsSelection)
+	err = query.SetAttrSelection(fs.getListFieldsSelection())
 	if err != nil {
 		return nil, err
 	}
@@ -422,11 +564,7 @@ func (fs *GCSFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 					}
 					prefixes[name] = true
 				}
-				modTime := attrs.Updated
-				if t, ok := modTimes[name]; ok {
-					modTime = util.GetTimeFromMsecSinceEpoch(t)
-				}
-				result = append(result, NewFileInfo(name, isDir, attrs.Size, modTime, false))
+				result = append(result, fs.getListedFileInfo(attrs, name, isDir, modTimes))
 			}
 		}
 
@@ -732,6 +870,9 @@ func (fs *GCSFs) getObjectStat(name string) (string, os.FileInfo, error) {
 	attrs, err := fs.headObject(name)
 	var info os.FileInfo
 	if err == nil {
+		if target, ok := fs.getSymlinkTarget(attrs); ok {
+			return name, newSymlinkFileInfo(name, target, attrs.Updated), nil
+		}
 		objSize := attrs.Size
 		objectModTime := attrs.Updated
 		isDir := attrs.ContentType == dirMimeType || strings.HasSuffix(attrs.Name, "/")
@@ -807,17 +948,155 @@ func (fs *GCSFs) getPrefix(name string) string {
 	return prefix
 }
 
+// getListedFileInfo returns the file info for a listed object
+func (fs *GCSFs) getListedFileInfo(attrs *storage.ObjectAttrs, name string, isDir bool, modTimes map[string]int64) os.FileInfo {
+	modTime := attrs.Updated
+	if t, ok := modTimes[name]; ok {
+		modTime = util.GetTimeFromMsecSinceEpoch(t)
+	}
+	if target, ok := fs.getSymlinkTarget(attrs); ok {
+		return newSymlinkFileInfo(name, target, modTime)
+	}
+	return NewFileInfo(name, isDir, attrs.Size, modTime, false)
+}
+
+// getListFieldsSelection returns the object attributes to request while listing
+// directories, metadata are required to detect the emulated symlinks
+func (fs *GCSFs) getListFieldsSelection() []string {
+	if fs.config.EmulateSymlinks {
+		return append([]string{"Metadata"}, gcsDefaultFieldsSelection...)
+	}
+	return gcsDefaultFieldsSelection
+}
+
+// getSymlinkTarget returns the target of the specified object if it is a symlink marker
+func (fs *GCSFs) getSymlinkTarget(attrs *storage.ObjectAttrs) (string, bool) {
+	if !fs.config.EmulateSymlinks || attrs.Size != 0 {
+		return "", false
+	}
+	return getSymlinkTargetFromMetadata(attrs.Metadata)
+}
+
 func (fs *GCSFs) headObject(name string) (*storage.ObjectAttrs, error) {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
-	bkt := fs.svc.Bucket(fs.config.Bucket)
-	obj := bkt.Object(name)
+	obj := fs.getObjectHandle(name)
 	attrs, err := obj.Attrs(ctx)
 	metric.GCSHeadObjectCompleted(err)
 	return attrs, err
 }
 
+// GetStorageProvider returns the filesystem provider
+func (*GCSFs) GetStorageProvider() sdk.FilesystemProvider {
+	return sdk.GCSFilesystemProvider
+}
+
+// GetStorageClass returns the storage class for the specified object
+func (fs *GCSFs) GetStorageClass(name string) (string, error) {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return "", err
+	}
+	return attrs.StorageClass, nil
+}
+
+// SetStorageClass moves the specified object to the specified storage class
+// by rewriting it in place
+func (fs *GCSFs) SetStorageClass(name, storageClass string) error {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	if attrs.StorageClass == storageClass {
+		return nil
+	}
+	obj := fs.getObjectHandle(name)
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	copier := obj.CopierFrom(obj)
+	copier.StorageClass = storageClass
+	copier.ContentType = attrs.ContentType
+	copier.Metadata = attrs.Metadata
+	if fs.config.ACL != "" {
+		copier.PredefinedACL = fs.config.ACL
+	}
+	if fs.config.KMSKeyName != "" {
+		copier.DestinationKMSKeyName = fs.config.KMSKeyName
+	}
+	_, err = copier.Run(ctx)
+	metric.GCSCopyObjectCompleted(err)
+	fsLog(fs, logger.LevelDebug, "storage class change for object %q, from %q to %q, err: %v",
+		name, attrs.StorageClass, storageClass, err)
+	if err != nil {
+		return err
+	}
+	if err := preserveModificationTime(fs.getStorageID(), name, attrs.Updated); err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to preserve modification time after storage class change for %q: %+v",
+			name, err)
+	}
+	return nil
+}
+
+// GetCustomMetadata returns the user defined metadata for the specified object
+func (fs *GCSFs) GetCustomMetadata(name string) (map[string]string, error) {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return nil, err
+	}
+	return filterCustomMetadata(attrs.Metadata), nil
+}
+
+// SetCustomMetadata updates the user defined metadata for the specified object
+func (fs *GCSFs) SetCustomMetadata(name string, metadata map[string]string) error {
+	if len(metadata) == 0 {
+		// an empty map removes all the metadata, including the internal ones
+		return nil
+	}
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	if _, err := mergeCustomMetadata(attrs.Metadata, metadata); err != nil {
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	// GCS patches the metadata: keys with an empty value are removed,
+	// missing keys are preserved
+	_, err = fs.getObjectHandle(name).Update(ctx, storage.ObjectAttrsToUpdate{Metadata: metadata})
+	fsLog(fs, logger.LevelDebug, "metadata updated for object %q, err: %v", name, err)
+	if err != nil {
+		return err
+	}
+	if err := preserveModificationTime(fs.getStorageID(), name, attrs.Updated); err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to preserve modification time after metadata update for %q: %+v",
+			name, err)
+	}
+	return nil
+}
+
+// GetRecordedChecksum returns the MD5 checksum for the specified object, the
+// CRC32C checksum is returned for composite objects
+func (fs *GCSFs) GetRecordedChecksum(name string) (Checksum, error) {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return Checksum{}, err
+	}
+	if len(attrs.MD5) > 0 {
+		return Checksum{
+			Algorithm: ChecksumAlgoMD5,
+			Value:     hex.EncodeToString(attrs.MD5),
+		}, nil
+	}
+	return Checksum{
+		Algorithm: ChecksumAlgoCRC32C,
+		Value:     fmt.Sprintf("%08x", attrs.CRC32C),
+	}, nil
+}
+
 // GetMimeType returns the content type
 func (fs *GCSFs) GetMimeType(name string) (string, error) {
 	attrs, err := fs.headObject(name)
@@ -827,6 +1106,119 @@ func (fs *GCSFs) GetMimeType(name string) (string, error) {
 	return attrs.ContentType, nil
 }
 
+// ListVersions returns the versions for the specified object, newest first.
+// The object generation is used as version identifier
+func (fs *GCSFs) ListVersions(name string) ([]FileVersion, error) {
+	var versions []FileVersion
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+
+	query := &storage.Query{Prefix: name, Versions: true}
+	err := query.SetAttrSelection([]string{"Name", "Generation", "Size", "Updated", "Deleted"})
+	if err != nil {
+		return nil, err
+	}
+	it := fs.svc.Bucket(fs.config.Bucket).Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			metric.GCSListObjectsCompleted(err)
+			return nil, err
+		}
+		if attrs.Name != name {
+			continue
+		}
+		versions = append(versions, FileVersion{
+			ID:       strconv.FormatInt(attrs.Generation, 10),
+			Size:     attrs.Size,
+			ModTime:  util.GetTimeAsMsSinceEpoch(attrs.Updated),
+			IsLatest: attrs.Deleted.IsZero(),
+		})
+	}
+	metric.GCSListObjectsCompleted(nil)
+	sortFileVersions(versions)
+	return versions, nil
+}
+
+func (fs *GCSFs) getObjectVersion(name, versionID string) (*storage.ObjectHandle, error) {
+	generation, err := strconv.ParseInt(versionID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version %q: %w", versionID, err)
+	}
+	return fs.getObjectHandle(name).Generation(generation), nil
+}
+
+// OpenVersion opens the specified object version for reading.
+// The read cache is not used for object versions
+func (fs *GCSFs) OpenVersion(name, versionID string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	obj, err := fs.getObjectVersion(name, versionID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	objectReader, err := obj.NewRangeReader(ctx, offset, -1)
+	if err == nil && offset > 0 && objectReader.Attrs.ContentEncoding == "gzip" {
+		err = fmt.Errorf("range request is not possible for gzip content encoding, requested offset %v", offset)
+		objectReader.Close()
+	}
+	if err != nil {
+		r.Close()
+		w.Close()
+		cancelFn()
+		return nil, nil, nil, err
+	}
+	go func() {
+		defer cancelFn()
+		defer objectReader.Close()
+
+		n, err := io.Copy(w, objectReader)
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path: %q version: %q size: %v, err: %+v",
+			name, versionID, n, err)
+		metric.GCSTransferCompleted(n, 1, err)
+	}()
+	return nil, r, cancelFn, nil
+}
+
+// RestoreVersion makes the specified version the current one by copying it in place
+func (fs *GCSFs) RestoreVersion(name, versionID string) error {
+	src, err := fs.getObjectVersion(name, versionID)
+	if err != nil {
+		return err
+	}
+	readCache.invalidate(fs.getReadCacheKey(name))
+	dst := fs.getObjectHandle(name)
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	copier := dst.CopierFrom(src)
+	if fs.config.StorageClass != "" {
+		copier.StorageClass = fs.config.StorageClass
+	}
+	if fs.config.ACL != "" {
+		copier.PredefinedACL = fs.config.ACL
+	}
+	if fs.config.KMSKeyName != "" {
+		copier.DestinationKMSKeyName = fs.config.KMSKeyName
+	}
+	_, err = copier.Run(ctx)
+	metric.GCSCopyObjectCompleted(err)
+	fsLog(fs, logger.LevelDebug, "version %q restored for object %q, err: %v", versionID, name, err)
+	if err != nil {
+		return err
+	}
+	updateRestoredVersionModTime(fs, fs.getStorageID(), name)
+	return nil
+}
+
 // Close closes the fs
 func (fs *GCSFs) Close() error {
 	return nil
@@ -837,6 +1229,20 @@ func (*GCSFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
 	return nil, ErrStorageSizeUnavailable
 }
 
+// getObjectHandle returns an handle for the specified object name, the
+// customer-supplied encryption key, if any, is used for all the operations
+func (fs *GCSFs) getObjectHandle(name string) *storage.ObjectHandle {
+	obj := fs.svc.Bucket(fs.config.Bucket).Object(name)
+	if len(fs.encryptionKey) > 0 {
+		return obj.Key(fs.encryptionKey)
+	}
+	return obj
+}
+
 func (fs *GCSFs) getStorageID() string {
 	return fmt.Sprintf("gs://%v", fs.config.Bucket)
 }
+
+func (fs *GCSFs) getReadCacheKey(name string) string {
+	return newReadCacheKey(fs.getStorageID(), name)
+}
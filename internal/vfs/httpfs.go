@@ -39,6 +39,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/resolver"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
@@ -217,6 +218,7 @@ func NewHTTPFs(connectionID, localTempDir, mountPath string, config HTTPFsConfig
 	transport.MaxResponseHeaderBytes = 1 << 16
 	transport.WriteBufferSize = 1 << 16
 	transport.ReadBufferSize = 1 << 16
+	transport.DialContext = resolver.WrapDialContext(transport.DialContext)
 	if fs.config.isUnixDomainSocket() {
 		endpointURL, err := url.Parse(fs.config.Endpoint)
 		if err != nil {
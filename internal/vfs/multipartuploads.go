@@ -0,0 +1,109 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"fmt"
+)
+
+const (
+	// if auto-tuning is enabled, the part size is increased so that files
+	// with a known size are uploaded using at most this number of parts
+	multipartAutoTuneParts = 1000
+	// min part size, as MB, allowed by S3. Smaller caps are not allowed
+	minMultipartPartSize = 5
+	megabyte             = 1024 * 1024
+)
+
+var multipartUploads MultipartUploadsConfig
+
+// MultipartUploadsConfig defines the server side limits for the multipart
+// uploads to S3 and Azure Blob Storage. The limits are applied to the part
+// size and concurrency defined in the filesystem configs and to the per-user
+// overrides. Each in-flight part is buffered in memory, so an upload can use
+// up to part size * concurrency bytes
+type MultipartUploadsConfig struct {
+	// Max part size as MB. 0 means no limit other than the one imposed by the
+	// storage backend
+	MaxPartSize int64 `json:"max_part_size" mapstructure:"max_part_size"`
+	// Max number of parts uploaded in parallel for a single file. 0 means no limit
+	MaxConcurrency int `json:"max_concurrency" mapstructure:"max_concurrency"`
+	// If enabled, the part size is increased for big files if the client
+	// declares the file size before the upload, for example using the FTP ALLO
+	// command, the SCP file header or the HTTP Content-Length header.
+	// The auto-tuned part size is limited by max_part_size
+	AutoTune bool `json:"auto_tune" mapstructure:"auto_tune"`
+}
+
+func (c *MultipartUploadsConfig) validate() error {
+	if c.MaxPartSize != 0 && c.MaxPartSize < minMultipartPartSize {
+		return fmt.Errorf("invalid multipart uploads max part size: %d, it must be 0 or at least %d MB",
+			c.MaxPartSize, minMultipartPartSize)
+	}
+	if c.MaxConcurrency < 0 {
+		return fmt.Errorf("invalid multipart uploads max concurrency: %d", c.MaxConcurrency)
+	}
+	return nil
+}
+
+// InitializeMultipartUploads sets the server side limits for the multipart uploads
+func InitializeMultipartUploads(c MultipartUploadsConfig) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	multipartUploads = c
+	return nil
+}
+
+// GetUploadPartSize returns the part size, as bytes, to use for uploading a
+// file with the specified size. partSize is the configured part size as bytes,
+// fileSize is the size declared by the client, 0 means unknown.
+// maxPartSize is the max part size, as bytes, supported by the storage backend
+func GetUploadPartSize(partSize, fileSize, maxPartSize int64) int64 {
+	if multipartUploads.AutoTune && fileSize > 0 {
+		tuned := (fileSize + multipartAutoTuneParts - 1) / multipartAutoTuneParts
+		// round up to the next MB
+		tuned = (tuned + megabyte - 1) / megabyte * megabyte
+		if tuned > partSize {
+			partSize = tuned
+		}
+	}
+	if multipartUploads.MaxPartSize > 0 && partSize > multipartUploads.MaxPartSize*megabyte {
+		partSize = multipartUploads.MaxPartSize * megabyte
+	}
+	if maxPartSize > 0 && partSize > maxPartSize {
+		partSize = maxPartSize
+	}
+	return partSize
+}
+
+// GetUploadConcurrency returns the number of parts to upload in parallel
+// limited by the configured max concurrency
+func GetUploadConcurrency(concurrency int) int {
+	if multipartUploads.MaxConcurrency > 0 && concurrency > multipartUploads.MaxConcurrency {
+		return multipartUploads.MaxConcurrency
+	}
+	return concurrency
+}
+
+// CreateWithSize creates or opens the named file for writing. If the
+// filesystem implements FsSizedCreator, the size declared by the client, if
+// greater than 0, is used to tune the upload
+func CreateWithSize(fs Fs, name string, flag int, size int64) (File, *PipeWriter, func(), error) {
+	if creator, ok := fs.(FsSizedCreator); ok && size > 0 {
+		return creator.CreateWithSize(name, flag, size)
+	}
+	return fs.Create(name, flag)
+}
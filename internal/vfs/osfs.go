@@ -114,6 +114,46 @@ func (*OsFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
 	return f, nil, nil, err
 }
 
+// CopyFile copies source to target, only files are supported.
+// On Linux the data are copied in kernel space using copy_file_range,
+// this allows in-filesystem copy acceleration, such as reflinks, if supported.
+// For CryptFs the encrypted contents are copied as is, the encryption key
+// does not depend on the file path
+func (fs *OsFs) CopyFile(source, target string) error {
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("cannot copy %q: %w", source, ErrVfsUnsupported)
+	}
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	errClose := dst.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return err
+	}
+	// the custom metadata are copied if the filesystem supports extended attributes
+	if xattrs, err := getUserXattrs(source); err == nil && len(xattrs) > 0 {
+		if err := setUserXattrs(target, xattrs); err != nil {
+			fsLog(fs, logger.LevelWarn, "unable to copy extended attributes from %q to %q: %v", source, target, err)
+		}
+	}
+	return nil
+}
+
 // Rename renames (moves) source to target
 func (fs *OsFs) Rename(source, target string) error {
 	if source == target {
@@ -492,6 +532,29 @@ func (fs *OsFs) GetMimeType(name string) (string, error) {
 	return ctype, err
 }
 
+// GetCustomMetadata returns the custom metadata, stored as user extended
+// attributes, for the specified file
+func (*OsFs) GetCustomMetadata(name string) (map[string]string, error) {
+	xattrs, err := getUserXattrs(name)
+	if err != nil {
+		return nil, err
+	}
+	return filterCustomMetadata(xattrs), nil
+}
+
+// SetCustomMetadata updates the custom metadata, stored as user extended
+// attributes, for the specified file
+func (*OsFs) SetCustomMetadata(name string, metadata map[string]string) error {
+	xattrs, err := getUserXattrs(name)
+	if err != nil {
+		return err
+	}
+	if _, err := mergeCustomMetadata(xattrs, metadata); err != nil {
+		return err
+	}
+	return setUserXattrs(name, metadata)
+}
+
 // Close closes the fs
 func (*OsFs) Close() error {
 	return nil
@@ -0,0 +1,467 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package vfs
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eikenb/pipeat"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+)
+
+const (
+	readCacheLogSender  = "read_cache"
+	readCacheFilePrefix = "sftpgo-cache-"
+)
+
+// Supported consistency modes for the read cache
+const (
+	// ReadCacheConsistencyLocal means that the cached objects are invalidated
+	// only when they are modified or removed through this SFTPGo instance
+	ReadCacheConsistencyLocal = iota
+	// ReadCacheConsistencyValidate means that the size and the modification time
+	// of a cached object are checked against the storage backend before serving it.
+	// Use this mode if the same bucket/container is modified from multiple SFTPGo
+	// instances or from other software
+	ReadCacheConsistencyValidate
+)
+
+var readCache *objectsReadCache
+
+// ReadCacheConfig defines the configuration for the local disk cache used for
+// downloads from S3, Google Cloud Storage and Azure Blob Storage
+type ReadCacheConfig struct {
+	// Absolute path to the directory used to store the cached objects.
+	// Leave empty to disable the read cache
+	Path string `json:"path" mapstructure:"path"`
+	// Maximum size of the cache as MB
+	MaxSize int64 `json:"max_size" mapstructure:"max_size"`
+	// Objects bigger than this size, as MB, are not cached. 0 means max_size
+	MaxObjectSize int64 `json:"max_object_size" mapstructure:"max_object_size"`
+	// Cached objects older than this, as minutes, are downloaded again from the
+	// storage backend. 0 means no expiration
+	TTL int `json:"ttl" mapstructure:"ttl"`
+	// 0 means that the cached objects are invalidated only for changes done
+	// through this instance, 1 means that the size and the modification time
+	// are checked against the storage backend before serving a cached object
+	ConsistencyMode int `json:"consistency_mode" mapstructure:"consistency_mode"`
+}
+
+func (c *ReadCacheConfig) isEnabled() bool {
+	return c.Path != ""
+}
+
+func (c *ReadCacheConfig) validate() error {
+	if !c.isEnabled() {
+		return nil
+	}
+	if !filepath.IsAbs(c.Path) {
+		return fmt.Errorf("read cache path %q must be an absolute path", c.Path)
+	}
+	if c.MaxSize <= 0 {
+		return fmt.Errorf("invalid read cache max size: %d", c.MaxSize)
+	}
+	if c.MaxObjectSize < 0 || c.MaxObjectSize > c.MaxSize {
+		return fmt.Errorf("invalid read cache max object size: %d", c.MaxObjectSize)
+	}
+	if c.TTL < 0 {
+		return fmt.Errorf("invalid read cache TTL: %d", c.TTL)
+	}
+	if c.ConsistencyMode != ReadCacheConsistencyLocal && c.ConsistencyMode != ReadCacheConsistencyValidate {
+		return fmt.Errorf("invalid read cache consistency mode: %d", c.ConsistencyMode)
+	}
+	return nil
+}
+
+// InitializeReadCache initializes the local read cache for cloud storage backends.
+// The index is kept in memory, so the objects cached by a previous run are removed
+func InitializeReadCache(c ReadCacheConfig) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if !c.isEnabled() {
+		readCache = nil
+		return nil
+	}
+	if err := os.MkdirAll(c.Path, 0700); err != nil {
+		return fmt.Errorf("unable to create read cache dir %q: %w", c.Path, err)
+	}
+	entries, err := os.ReadDir(c.Path)
+	if err != nil {
+		return fmt.Errorf("unable to read cache dir %q: %w", c.Path, err)
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasPrefix(entry.Name(), readCacheFilePrefix) {
+			if err := os.Remove(filepath.Join(c.Path, entry.Name())); err != nil {
+				logger.Warn(readCacheLogSender, "", "unable to remove stale cached file %q: %v", entry.Name(), err)
+			}
+		}
+	}
+	maxObjectSize := c.MaxObjectSize
+	if maxObjectSize == 0 {
+		maxObjectSize = c.MaxSize
+	}
+	readCache = &objectsReadCache{
+		dir:           c.Path,
+		maxSize:       c.MaxSize * 1048576,
+		maxObjectSize: maxObjectSize * 1048576,
+		ttl:           time.Duration(c.TTL) * time.Minute,
+		validate:      c.ConsistencyMode == ReadCacheConsistencyValidate,
+		entries:       make(map[string]*readCacheEntry),
+		lru:           list.New(),
+		invalidations: make(map[string]uint64),
+	}
+	metric.UpdateReadCacheSize(0)
+	logger.Info(readCacheLogSender, "", "read cache initialized, path: %q, max size: %d MB, max object size: %d MB, "+
+		"TTL: %d minutes, consistency mode: %d", c.Path, c.MaxSize, maxObjectSize, c.TTL, c.ConsistencyMode)
+	return nil
+}
+
+type readCacheEntry struct {
+	key      string
+	path     string
+	size     int64
+	modTime  time.Time
+	cachedAt time.Time
+	// number of downloads in progress reading this entry,
+	// removing the file is deferred until they complete
+	readers int
+	removed bool
+	elem    *list.Element
+}
+
+type objectsReadCache struct {
+	dir           string
+	maxSize       int64
+	maxObjectSize int64
+	ttl           time.Duration
+	validate      bool
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*readCacheEntry
+	// most recently used entries are at the front
+	lru *list.List
+	// the sequence number is incremented for each invalidation, the invalidations
+	// map is used to avoid to cache objects modified while being downloaded
+	seq           uint64
+	invalidations map[string]uint64
+	writers       int
+}
+
+// open returns a reader for the cached copy of the object identified by key,
+// if any. If the object is not cached, the returned writer must be used for the
+// download from the storage backend, it fills the cache if possible
+func (c *objectsReadCache) open(key string, offset int64, localTempDir string,
+	stat func() (os.FileInfo, error), w *pipeat.PipeWriterAt,
+) (*pipeat.PipeReaderAt, func(), *readCacheWriter) {
+	if c == nil {
+		return nil, nil, &readCacheWriter{w: w}
+	}
+	var info os.FileInfo
+	if c.validate {
+		fi, err := stat()
+		if err != nil {
+			// let the download from the backend report the error, if any
+			metric.AddReadCacheRequest(false)
+			return nil, nil, &readCacheWriter{w: w}
+		}
+		info = fi
+	}
+	if entry := c.acquire(key, info); entry != nil {
+		r, cancelFn, err := c.serve(entry, offset, localTempDir)
+		if err == nil {
+			metric.AddReadCacheRequest(true)
+			logger.Debug(readCacheLogSender, "", "serving key %q from cache, offset: %d", key, offset)
+			return r, cancelFn, nil
+		}
+		logger.Warn(readCacheLogSender, "", "unable to serve key %q from cache: %v", key, err)
+		c.invalidate(key)
+	}
+	metric.AddReadCacheRequest(false)
+	if offset > 0 {
+		return nil, nil, &readCacheWriter{w: w}
+	}
+	return nil, nil, c.newWriter(key, info, w)
+}
+
+func (c *objectsReadCache) acquire(key string, info os.FileInfo) *readCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if c.ttl > 0 && time.Since(entry.cachedAt) > c.ttl {
+		c.removeLocked(entry)
+		return nil
+	}
+	if info != nil && (info.Size() != entry.size || !info.ModTime().Equal(entry.modTime)) {
+		c.removeLocked(entry)
+		return nil
+	}
+	entry.readers++
+	c.lru.MoveToFront(entry.elem)
+	return entry
+}
+
+func (c *objectsReadCache) release(entry *readCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.readers--
+	if entry.removed && entry.readers == 0 {
+		c.removeFile(entry.path)
+	}
+}
+
+func (c *objectsReadCache) serve(entry *readCacheEntry, offset int64, localTempDir string,
+) (*pipeat.PipeReaderAt, func(), error) {
+	if offset > entry.size {
+		c.release(entry)
+		return nil, nil, fmt.Errorf("the requested offset %d exceeds the file size %d", offset, entry.size)
+	}
+	f, err := os.Open(entry.path)
+	if err != nil {
+		c.release(entry)
+		return nil, nil, err
+	}
+	r, w, err := pipeat.PipeInDir(localTempDir)
+	if err != nil {
+		f.Close()
+		c.release(entry)
+		return nil, nil, err
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+		defer c.release(entry)
+		defer f.Close()
+
+		_, err := io.Copy(w, &contextReader{
+			ctx: ctx,
+			r:   io.NewSectionReader(f, offset, entry.size-offset),
+		})
+		w.CloseWithError(err) //nolint:errcheck
+	}()
+
+	return r, cancelFn, nil
+}
+
+func (c *objectsReadCache) newWriter(key string, info os.FileInfo, w *pipeat.PipeWriterAt) *readCacheWriter {
+	f, err := os.CreateTemp(c.dir, readCacheFilePrefix)
+	if err != nil {
+		logger.Warn(readCacheLogSender, "", "unable to create cache file for key %q: %v", key, err)
+		return &readCacheWriter{w: w}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writers++
+	cw := &readCacheWriter{
+		w:     w,
+		cache: c,
+		key:   key,
+		seq:   c.seq,
+		f:     f,
+	}
+	if info != nil {
+		cw.modTime = info.ModTime()
+	}
+	return cw
+}
+
+// invalidate removes the object identified by key from the cache.
+// It must be called when an object is modified or removed
+func (c *objectsReadCache) invalidate(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	if c.writers > 0 {
+		c.invalidations[key] = c.seq
+	}
+	if entry, ok := c.entries[key]; ok {
+		c.removeLocked(entry)
+	}
+}
+
+func (c *objectsReadCache) commit(cw *readCacheWriter, size int64, success bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writers--
+	invalidated := c.invalidations[cw.key] > cw.seq
+	if c.writers == 0 {
+		c.invalidations = make(map[string]uint64)
+	}
+	if !success || invalidated || size > c.maxSize {
+		c.removeFile(cw.f.Name())
+		return
+	}
+	if _, ok := c.entries[cw.key]; ok {
+		// another download for the same object completed before this one
+		c.removeFile(cw.f.Name())
+		return
+	}
+	entry := &readCacheEntry{
+		key:      cw.key,
+		path:     cw.f.Name(),
+		size:     size,
+		modTime:  cw.modTime,
+		cachedAt: time.Now(),
+	}
+	entry.elem = c.lru.PushFront(entry)
+	c.entries[cw.key] = entry
+	c.size += size
+	for c.size > c.maxSize {
+		elem := c.lru.Back()
+		if elem == nil {
+			break
+		}
+		c.removeLocked(elem.Value.(*readCacheEntry))
+	}
+	metric.UpdateReadCacheSize(c.size)
+}
+
+func (c *objectsReadCache) removeLocked(entry *readCacheEntry) {
+	if entry.removed {
+		return
+	}
+	entry.removed = true
+	delete(c.entries, entry.key)
+	c.lru.Remove(entry.elem)
+	c.size -= entry.size
+	metric.UpdateReadCacheSize(c.size)
+	if entry.readers == 0 {
+		c.removeFile(entry.path)
+	}
+}
+
+func (c *objectsReadCache) removeFile(name string) {
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn(readCacheLogSender, "", "unable to remove cached file %q: %v", name, err)
+	}
+}
+
+// readCacheWriter forwards the downloaded data to the pipe and, if a cache file
+// is set, it writes a copy to the cache file too
+type readCacheWriter struct {
+	w       *pipeat.PipeWriterAt
+	cache   *objectsReadCache
+	key     string
+	seq     uint64
+	modTime time.Time
+	f       *os.File
+
+	mu     sync.Mutex
+	offset int64
+	size   int64
+	failed bool
+}
+
+func (cw *readCacheWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	if n > 0 && cw.f != nil {
+		cw.mu.Lock()
+		off := cw.offset
+		cw.offset += int64(n)
+		cw.mu.Unlock()
+
+		cw.writeToCache(p[:n], off)
+	}
+	return n, err
+}
+
+func (cw *readCacheWriter) WriteAt(p []byte, off int64) (int, error) {
+	n, err := cw.w.WriteAt(p, off)
+	if n > 0 && cw.f != nil {
+		cw.writeToCache(p[:n], off)
+	}
+	return n, err
+}
+
+func (cw *readCacheWriter) writeToCache(p []byte, off int64) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	if cw.failed {
+		return
+	}
+	end := off + int64(len(p))
+	if end > cw.cache.maxObjectSize {
+		cw.failed = true
+		return
+	}
+	if _, err := cw.f.WriteAt(p, off); err != nil {
+		logger.Warn(readCacheLogSender, "", "unable to write cache file for key %q: %v", cw.key, err)
+		cw.failed = true
+		return
+	}
+	if end > cw.size {
+		cw.size = end
+	}
+}
+
+// Done must be called after the download from the storage backend completes.
+// The cached copy is kept only if the download succeeded
+func (cw *readCacheWriter) Done(downloadErr error) {
+	if cw.f == nil {
+		return
+	}
+	cw.mu.Lock()
+	success := downloadErr == nil && !cw.failed
+	size := cw.size
+	cw.mu.Unlock()
+
+	if err := cw.f.Close(); err != nil {
+		success = false
+	}
+	cw.cache.commit(cw, size, success)
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+func newReadCacheKey(parts ...string) string {
+	h := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(h[:])
+}
@@ -18,9 +18,11 @@
 package vfs
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"mime"
 	"net"
 	"net/http"
@@ -45,10 +47,13 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/eikenb/pipeat"
 	"github.com/pkg/sftp"
+	"github.com/sftpgo/sdk"
 
+	"github.com/drakkan/sftpgo/v2/internal/latency"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/resolver"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/version"
 )
@@ -58,6 +63,8 @@ const (
 	s3DirMimeType        = "application/x-directory"
 	s3TransferBufferSize = 256 * 1024
 	s3fsName             = "S3Fs"
+	// max part size allowed by S3 is 5 GiB, we use the same limit as the config validation
+	s3MaxUploadPartSize = 5000 * 1024 * 1024
 )
 
 // S3Fs is a Fs implementation for AWS S3 compatible object storages
@@ -102,6 +109,7 @@ func NewS3Fs(connectionID, localTempDir, mountPath string, s3Config S3FsConfig)
 	if err != nil {
 		return fs, fmt.Errorf("unable to get AWS config: %w", err)
 	}
+	awsConfig.HTTPClient = &s3LatencyClient{client: awsConfig.HTTPClient}
 	if fs.config.Region != "" {
 		awsConfig.Region = fs.config.Region
 	}
@@ -150,6 +158,15 @@ func (fs *S3Fs) ConnectionID() string {
 
 // Stat returns a FileInfo describing the named file
 func (fs *S3Fs) Stat(name string) (os.FileInfo, error) {
+	if fs.config.EmulateSymlinks {
+		return statEmulatedSymlinks(fs, name, fs.config.KeyPrefix)
+	}
+	return fs.Lstat(name)
+}
+
+// Lstat returns a FileInfo describing the named file.
+// Emulated symlinks, if enabled, are not followed
+func (fs *S3Fs) Lstat(name string) (os.FileInfo, error) {
 	var result *FileInfo
 	if name == "" || name == "/" || name == "." {
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
@@ -159,6 +176,9 @@ func (fs *S3Fs) Stat(name string) (os.FileInfo, error) {
 	}
 	obj, err := fs.headObject(name)
 	if err == nil {
+		if target, ok := fs.getSymlinkTarget(obj); ok {
+			return newSymlinkFileInfo(name, target, util.GetTimeFromPointer(obj.LastModified)), nil
+		}
 		// a "dir" has a trailing "/" so we cannot have a directory here
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, false, obj.ContentLength,
 			util.GetTimeFromPointer(obj.LastModified), false))
@@ -190,17 +210,28 @@ func (fs *S3Fs) getStatForDir(name string) (os.FileInfo, error) {
 		util.GetTimeFromPointer(obj.LastModified), false))
 }
 
-// Lstat returns a FileInfo describing the named file
-func (fs *S3Fs) Lstat(name string) (os.FileInfo, error) {
-	return fs.Stat(name)
-}
-
 // Open opens the named file for reading
 func (fs *S3Fs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	if fs.config.EmulateSymlinks {
+		resolved, _, err := resolveEmulatedSymlinks(fs, name, fs.config.KeyPrefix)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		name = resolved
+	}
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	cacheKey := fs.getReadCacheKey(name)
+	cachedReader, cachedCancelFn, cw := readCache.open(cacheKey, offset, fs.localTempDir, func() (os.FileInfo, error) {
+		return fs.Stat(name)
+	}, w)
+	if cachedReader != nil {
+		r.Close()
+		w.Close()
+		return nil, cachedReader, cachedCancelFn, nil
+	}
 	ctx, cancelFn := context.WithCancel(context.Background())
 	downloader := manager.NewDownloader(fs.svc, func(d *manager.Downloader) {
 		d.Concurrency = fs.config.DownloadConcurrency
@@ -220,11 +251,21 @@ func (fs *S3Fs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, fun
 	go func() {
 		defer cancelFn()
 
-		n, err := downloader.Download(ctx, w, &s3.GetObjectInput{
-			Bucket: aws.String(fs.config.Bucket),
-			Key:    aws.String(name),
-			Range:  streamRange,
-		})
+		var n int64
+		var err error
+		if fs.useRangedDownload(offset) {
+			n, err = fs.handleRangedDownload(ctx, name, nil, offset, cw)
+		} else {
+			n, err = downloader.Download(ctx, cw, &s3.GetObjectInput{
+				Bucket: aws.String(fs.config.Bucket),
+				Key:    aws.String(name),
+				Range:  streamRange,
+			})
+		}
+		if isS3InvalidObjectState(err) {
+			err = fmt.Errorf("%w: %v", ErrObjectArchived, err)
+		}
+		cw.Done(err)
 		w.CloseWithError(err) //nolint:errcheck
 		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %+v", name, n, err)
 		metric.S3TransferCompleted(n, 1, err)
@@ -234,18 +275,28 @@ func (fs *S3Fs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, fun
 
 // Create creates or opens the named file for writing
 func (fs *S3Fs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	return fs.CreateWithSize(name, flag, 0)
+}
+
+// CreateWithSize creates or opens the named file for writing, the expected
+// size, if greater than 0, is used to tune the multipart upload part size
+func (fs *S3Fs) CreateWithSize(name string, flag int, size int64) (File, *PipeWriter, func(), error) {
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 	p := NewPipeWriter(w)
+	cacheKey := fs.getReadCacheKey(name)
+	readCache.invalidate(cacheKey)
 	ctx, cancelFn := context.WithCancel(context.Background())
 	uploader := manager.NewUploader(fs.svc, func(u *manager.Uploader) {
-		u.Concurrency = fs.config.UploadConcurrency
-		u.PartSize = fs.config.UploadPartSize
+		u.Concurrency = GetUploadConcurrency(fs.config.UploadConcurrency)
+		u.PartSize = GetUploadPartSize(fs.config.UploadPartSize, size, s3MaxUploadPartSize)
 		if fs.config.UploadPartMaxTime > 0 {
 			u.ClientOptions = append(u.ClientOptions, func(o *s3.Options) {
-				o.HTTPClient = getAWSHTTPClient(fs.config.UploadPartMaxTime, 100*time.Millisecond)
+				o.HTTPClient = &s3LatencyClient{
+					client: getAWSHTTPClient(fs.config.UploadPartMaxTime, 100*time.Millisecond),
+				}
 			})
 		}
 	})
@@ -267,6 +318,7 @@ func (fs *S3Fs) Create(name string, flag int) (File, *PipeWriter, func(), error)
 			StorageClass: types.StorageClass(fs.config.StorageClass),
 			ContentType:  util.NilIfEmpty(contentType),
 		})
+		readCache.invalidate(cacheKey)
 		r.CloseWithError(err) //nolint:errcheck
 		p.Done(err)
 		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, acl: %#v, readed bytes: %v, err: %+v",
@@ -285,11 +337,10 @@ func (fs *S3Fs) Rename(source, target string) error {
 	if source == target {
 		return nil
 	}
-	fi, err := fs.Stat(source)
+	fi, err := fs.Lstat(source)
 	if err != nil {
 		return err
 	}
-	copySource := fs.Join(fs.config.Bucket, source)
 	if fi.IsDir() {
 		hasContents, err := fs.hasContents(source)
 		if err != nil {
@@ -298,6 +349,35 @@ func (fs *S3Fs) Rename(source, target string) error {
 		if hasContents {
 			return fmt.Errorf("cannot rename non empty directory: %#v", source)
 		}
+	}
+	if err := fs.copyFileInternal(source, target, fi); err != nil {
+		return err
+	}
+	return fs.Remove(source, fi.IsDir())
+}
+
+// CopyFile copies source to target using a server side copy, only files are supported
+func (fs *S3Fs) CopyFile(source, target string) error {
+	if fs.config.EmulateSymlinks {
+		resolved, _, err := resolveEmulatedSymlinks(fs, source, fs.config.KeyPrefix)
+		if err != nil {
+			return err
+		}
+		source = resolved
+	}
+	fi, err := fs.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("cannot copy %q: %w", source, ErrVfsUnsupported)
+	}
+	return fs.copyFileInternal(source, target, fi)
+}
+
+func (fs *S3Fs) copyFileInternal(source, target string, fi os.FileInfo) error {
+	copySource := fs.Join(fs.config.Bucket, source)
+	if fi.IsDir() {
 		if !strings.HasSuffix(copySource, "/") {
 			copySource += "/"
 		}
@@ -313,10 +393,18 @@ func (fs *S3Fs) Rename(source, target string) error {
 	}
 	copySource = pathEscape(copySource)
 
+	readCache.invalidate(fs.getReadCacheKey(target))
+	var err error
 	if fi.Size() > 500*1024*1024 {
-		fsLog(fs, logger.LevelDebug, "renaming file %q with size %d using multipart copy",
+		fsLog(fs, logger.LevelDebug, "copying file %q with size %d using multipart copy",
 			source, fi.Size())
-		err = fs.doMultipartCopy(copySource, target, contentType, fi.Size())
+		// the metadata are not copied by multipart copies
+		var obj *s3.HeadObjectOutput
+		obj, err = fs.headObject(source)
+		if err != nil {
+			return err
+		}
+		err = fs.doMultipartCopy(copySource, target, contentType, fs.config.StorageClass, fi.Size(), obj.Metadata)
 	} else {
 		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
@@ -349,12 +437,12 @@ func (fs *S3Fs) Rename(source, target string) error {
 			err = plugin.Handler.SetModificationTime(fs.getStorageID(), ensureAbsPath(target),
 				util.GetTimeAsMsSinceEpoch(fi.ModTime()))
 			if err != nil {
-				fsLog(fs, logger.LevelWarn, "unable to preserve modification time after renaming %#v -> %#v: %+v",
+				fsLog(fs, logger.LevelWarn, "unable to preserve modification time after copying %#v -> %#v: %+v",
 					source, target, err)
 			}
 		}
 	}
-	return fs.Remove(source, fi.IsDir())
+	return nil
 }
 
 // Remove removes the named file or (empty) directory.
@@ -379,6 +467,7 @@ func (fs *S3Fs) Remove(name string, isDir bool) error {
 		Key:    aws.String(name),
 	})
 	metric.S3DeleteObjectCompleted(err)
+	readCache.invalidate(fs.getReadCacheKey(name))
 	if plugin.Handler.HasMetadater() && err == nil && !isDir {
 		if errMetadata := plugin.Handler.RemoveMetadata(fs.getStorageID(), ensureAbsPath(name)); errMetadata != nil {
 			fsLog(fs, logger.LevelWarn, "unable to remove metadata for path %#v: %+v", name, errMetadata)
@@ -404,13 +493,45 @@ func (fs *S3Fs) Mkdir(name string) error {
 }
 
 // Symlink creates source as a symbolic link to target.
-func (*S3Fs) Symlink(source, target string) error {
-	return ErrVfsUnsupported
+// Symlinks are emulated using zero bytes marker objects, if enabled
+func (fs *S3Fs) Symlink(source, target string) error {
+	if !fs.config.EmulateSymlinks {
+		return ErrVfsUnsupported
+	}
+	if _, err := fs.Lstat(target); !fs.IsNotExist(err) {
+		if err == nil {
+			return fmt.Errorf("cannot create symlink %q: %w", target, os.ErrExist)
+		}
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	_, err := fs.svc.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(fs.config.Bucket),
+		Key:          aws.String(target),
+		Body:         bytes.NewReader(nil),
+		ACL:          types.ObjectCannedACL(fs.config.ACL),
+		StorageClass: types.StorageClass(fs.config.StorageClass),
+		Metadata: map[string]string{
+			symlinkTargetMetadataKey: getSymlinkMarkerTarget(source, fs.config.KeyPrefix),
+		},
+	})
+	readCache.invalidate(fs.getReadCacheKey(target))
+	metric.S3TransferCompleted(0, 0, err)
+	return err
 }
 
 // Readlink returns the destination of the named symbolic link
-func (*S3Fs) Readlink(name string) (string, error) {
-	return "", ErrVfsUnsupported
+func (fs *S3Fs) Readlink(name string) (string, error) {
+	if !fs.config.EmulateSymlinks {
+		return "", ErrVfsUnsupported
+	}
+	info, err := fs.Lstat(name)
+	if err != nil {
+		return "", err
+	}
+	return readEmulatedSymlink(name, info, fs.mountPath)
 }
 
 // Chown changes the numeric uid and gid of the named file.
@@ -453,6 +574,10 @@ func (*S3Fs) Truncate(name string, size int64) error {
 func (fs *S3Fs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	var result []os.FileInfo
 	// dirname must be already cleaned
+	dirname, err := getDirToList(fs, dirname, fs.config.KeyPrefix, fs.config.EmulateSymlinks)
+	if err != nil {
+		return result, err
+	}
 	prefix := fs.getPrefix(dirname)
 
 	modTimes, err := getFolderModTimes(fs.getStorageID(), dirname)
@@ -503,8 +628,7 @@ func (fs *S3Fs) ReadDir(dirname string) ([]os.FileInfo, error) {
 			if t, ok := modTimes[name]; ok {
 				objectModTime = util.GetTimeFromMsecSinceEpoch(t)
 			}
-			result = append(result, NewFileInfo(name, (isDir && fileObject.Size == 0), fileObject.Size,
-				objectModTime, false))
+			result = append(result, fs.getListedFileInfo(fileObject, name, isDir, objectModTime))
 		}
 	}
 
@@ -807,16 +931,19 @@ func (fs *S3Fs) hasContents(name string) (bool, error) {
 	return false, nil
 }
 
-func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int64) error {
+func (fs *S3Fs) doMultipartCopy(source, target, contentType, storageClass string, fileSize int64,
+	metadata map[string]string,
+) error {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
 	res, err := fs.svc.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
 		Bucket:       aws.String(fs.config.Bucket),
 		Key:          aws.String(target),
-		StorageClass: types.StorageClass(fs.config.StorageClass),
+		StorageClass: types.StorageClass(storageClass),
 		ACL:          types.ObjectCannedACL(fs.config.ACL),
 		ContentType:  util.NilIfEmpty(contentType),
+		Metadata:     metadata,
 	})
 	if err != nil {
 		return fmt.Errorf("unable to create multipart copy request: %w", err)
@@ -936,6 +1063,127 @@ func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int
 	return nil
 }
 
+// useRangedDownload returns true if a download starting at the specified offset
+// must be handled using concurrent ranged reads. The SDK downloader ignores the
+// configured part size and concurrency if a range is requested, so it is used
+// for downloads from the beginning of the object only
+func (fs *S3Fs) useRangedDownload(offset int64) bool {
+	return offset > 0 && fs.config.DownloadConcurrency > 1
+}
+
+// handleRangedDownload downloads the object, starting at the specified offset,
+// using concurrent ranged reads of the configured part size
+func (fs *S3Fs) handleRangedDownload(ctx context.Context, name string, versionID *string, offset int64,
+	writer io.WriterAt,
+) (int64, error) {
+	headCtx, headCancelFn := context.WithDeadline(ctx, time.Now().Add(fs.ctxTimeout))
+	obj, err := fs.svc.HeadObject(headCtx, &s3.HeadObjectInput{
+		Bucket:    aws.String(fs.config.Bucket),
+		Key:       aws.String(name),
+		VersionId: versionID,
+	})
+	headCancelFn()
+	metric.S3HeadObjectCompleted(err)
+	if err != nil {
+		fsLog(fs, logger.LevelError, "unable to get object properties, download aborted: %+v", err)
+		return 0, err
+	}
+	contentLength := obj.ContentLength
+	sizeToDownload := contentLength - offset
+	if sizeToDownload < 0 {
+		fsLog(fs, logger.LevelError, "invalid ranged download size or offset, size: %v, offset: %v, size to download: %v",
+			contentLength, offset, sizeToDownload)
+		return 0, errors.New("the requested offset exceeds the file size")
+	}
+	if sizeToDownload == 0 {
+		fsLog(fs, logger.LevelDebug, "nothing to download, offset %v, content length %v", offset, contentLength)
+		return 0, nil
+	}
+	partSize := fs.config.DownloadPartSize
+	guard := make(chan struct{}, fs.config.DownloadConcurrency)
+	finished := false
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var hasError atomic.Bool
+	var written atomic.Int64
+	var poolError error
+
+	poolCtx, poolCancel := context.WithCancel(ctx)
+	defer poolCancel()
+
+	for part := 0; !finished; part++ {
+		start := offset
+		end := offset + partSize
+		if end >= contentLength {
+			end = contentLength
+			finished = true
+		}
+		writeOffset := int64(part) * partSize
+		offset = end
+
+		guard <- struct{}{}
+		if hasError.Load() {
+			fsLog(fs, logger.LevelDebug, "pool error, download for part %v not started", part)
+			break
+		}
+
+		wg.Add(1)
+		go func(start, end, writeOffset int64) {
+			defer func() {
+				<-guard
+				wg.Done()
+			}()
+
+			innerCtx := poolCtx
+			if fs.config.DownloadPartMaxTime > 0 {
+				var cancelFn context.CancelFunc
+				innerCtx, cancelFn = context.WithDeadline(poolCtx,
+					time.Now().Add(time.Duration(fs.config.DownloadPartMaxTime)*time.Second))
+				defer cancelFn()
+			}
+
+			n, err := fs.downloadPart(innerCtx, name, versionID, writer, start, end, writeOffset)
+			written.Add(n)
+			if err != nil {
+				errOnce.Do(func() {
+					fsLog(fs, logger.LevelError, "ranged download error: %+v", err)
+					hasError.Store(true)
+					poolError = fmt.Errorf("ranged download error: %w", err)
+					poolCancel()
+				})
+			}
+		}(start, end, writeOffset)
+	}
+
+	wg.Wait()
+	close(guard)
+
+	return written.Load(), poolError
+}
+
+// downloadPart downloads the range between start and end, end excluded, and
+// writes it to the specified writer starting at writeOffset
+func (fs *S3Fs) downloadPart(ctx context.Context, name string, versionID *string, writer io.WriterAt,
+	start, end, writeOffset int64,
+) (int64, error) {
+	resp, err := fs.svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(fs.config.Bucket),
+		Key:       aws.String(name),
+		VersionId: versionID,
+		Range:     aws.String(fmt.Sprintf("bytes=%d-%d", start, end-1)),
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	n, err := io.Copy(&offsetWriter{w: writer, offset: writeOffset}, resp.Body)
+	if err == nil && n != end-start {
+		err = fmt.Errorf("unexpected part size, expected: %d, actual: %d", end-start, n)
+	}
+	return n, err
+}
+
 func (fs *S3Fs) getPrefix(name string) string {
 	prefix := ""
 	if name != "" && name != "." && name != "/" {
@@ -947,6 +1195,28 @@ func (fs *S3Fs) getPrefix(name string) string {
 	return prefix
 }
 
+// getListedFileInfo returns the file info for a listed object.
+// S3 doesn't return metadata when listing objects, so an additional request
+// is required for each empty object to detect the emulated symlinks
+func (fs *S3Fs) getListedFileInfo(obj types.Object, name string, isDir bool, modTime time.Time) os.FileInfo {
+	if fs.config.EmulateSymlinks && !isDir && obj.Size == 0 {
+		if attrs, err := fs.headObject(util.GetStringFromPointer(obj.Key)); err == nil {
+			if target, ok := fs.getSymlinkTarget(attrs); ok {
+				return newSymlinkFileInfo(name, target, modTime)
+			}
+		}
+	}
+	return NewFileInfo(name, (isDir && obj.Size == 0), obj.Size, modTime, false)
+}
+
+// getSymlinkTarget returns the target of the specified object if it is a symlink marker
+func (fs *S3Fs) getSymlinkTarget(obj *s3.HeadObjectOutput) (string, bool) {
+	if !fs.config.EmulateSymlinks || obj.ContentLength != 0 {
+		return "", false
+	}
+	return getSymlinkTargetFromMetadata(obj.Metadata)
+}
+
 func (fs *S3Fs) headObject(name string) (*s3.HeadObjectOutput, error) {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
@@ -959,6 +1229,238 @@ func (fs *S3Fs) headObject(name string) (*s3.HeadObjectOutput, error) {
 	return obj, err
 }
 
+// GetArchiveStatus returns the archive status for the specified object
+func (fs *S3Fs) GetArchiveStatus(name string) (ArchiveStatus, error) {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return ArchiveStatus{}, err
+	}
+	status := ArchiveStatus{
+		StorageClass: string(obj.StorageClass),
+		Available:    true,
+	}
+	if !isS3ArchiveStorageClass(obj.StorageClass, obj.ArchiveStatus) {
+		return status, nil
+	}
+	status.Archived = true
+	status.Available = false
+	if obj.ArchiveStatus != "" {
+		status.StorageClass = string(obj.ArchiveStatus)
+	}
+	ongoing, expiresAt, ok := parseS3RestoreHeader(util.GetStringFromPointer(obj.Restore))
+	if ok {
+		status.Restoring = ongoing
+		status.Available = !ongoing
+		if !ongoing && !expiresAt.IsZero() {
+			status.RestoreExpiresAt = util.GetTimeAsMsSinceEpoch(expiresAt)
+		}
+	}
+	return status, nil
+}
+
+// RestoreArchivedObject starts a restore request for the specified archived object.
+// The restored copy will be available for the specified number of days, this setting
+// is ignored for objects in the intelligent tiering archive tiers
+func (fs *S3Fs) RestoreArchivedObject(name string, days int) error {
+	status, err := fs.GetArchiveStatus(name)
+	if err != nil {
+		return err
+	}
+	if !status.Archived || status.Restoring {
+		return nil
+	}
+	restoreRequest := &types.RestoreRequest{}
+	if status.StorageClass != string(types.ArchiveStatusArchiveAccess) &&
+		status.StorageClass != string(types.ArchiveStatusDeepArchiveAccess) {
+		restoreRequest.Days = int32(days)
+		restoreRequest.GlacierJobParameters = &types.GlacierJobParameters{
+			Tier: types.TierStandard,
+		}
+	}
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	_, err = fs.svc.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket:         aws.String(fs.config.Bucket),
+		Key:            aws.String(name),
+		RestoreRequest: restoreRequest,
+	})
+	if err != nil {
+		var apiErr interface{ ErrorCode() string }
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+			return nil
+		}
+		var activeTierErr *types.ObjectAlreadyInActiveTierError
+		if errors.As(err, &activeTierErr) {
+			return nil
+		}
+	}
+	fsLog(fs, logger.LevelDebug, "restore requested for archived object %q, days: %d, err: %v", name, days, err)
+	return err
+}
+
+// GetStorageProvider returns the filesystem provider
+func (*S3Fs) GetStorageProvider() sdk.FilesystemProvider {
+	return sdk.S3FilesystemProvider
+}
+
+// GetStorageClass returns the storage class for the specified object
+func (fs *S3Fs) GetStorageClass(name string) (string, error) {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return "", err
+	}
+	if obj.StorageClass == "" {
+		// S3 omits the storage class header for STANDARD objects
+		return string(types.StorageClassStandard), nil
+	}
+	return string(obj.StorageClass), nil
+}
+
+// SetStorageClass moves the specified object to the specified storage class
+// by copying it in place. Archived objects must be restored before they can
+// be moved to a different storage class
+func (fs *S3Fs) SetStorageClass(name, storageClass string) error {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	if obj.StorageClass == types.StorageClass(storageClass) {
+		return nil
+	}
+	if isS3ArchiveStorageClass(obj.StorageClass, obj.ArchiveStatus) {
+		ongoing, _, ok := parseS3RestoreHeader(util.GetStringFromPointer(obj.Restore))
+		if !ok || ongoing {
+			return ErrObjectArchived
+		}
+	}
+	copySource := pathEscape(fs.Join(fs.config.Bucket, name))
+	size := obj.ContentLength
+	if size > 500*1024*1024 {
+		fsLog(fs, logger.LevelDebug, "changing storage class for file %q with size %d using multipart copy",
+			name, size)
+		err = fs.doMultipartCopy(copySource, name, util.GetStringFromPointer(obj.ContentType), storageClass, size,
+			obj.Metadata)
+	} else {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
+
+		_, err = fs.svc.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:            aws.String(fs.config.Bucket),
+			CopySource:        aws.String(copySource),
+			Key:               aws.String(name),
+			StorageClass:      types.StorageClass(storageClass),
+			ACL:               types.ObjectCannedACL(fs.config.ACL),
+			MetadataDirective: types.MetadataDirectiveCopy,
+		})
+	}
+	metric.S3CopyObjectCompleted(err)
+	fsLog(fs, logger.LevelDebug, "storage class change for object %q, from %q to %q, err: %v",
+		name, obj.StorageClass, storageClass, err)
+	if err != nil {
+		return err
+	}
+	if obj.LastModified != nil {
+		if err := preserveModificationTime(fs.getStorageID(), name, *obj.LastModified); err != nil {
+			fsLog(fs, logger.LevelWarn, "unable to preserve modification time after storage class change for %q: %+v",
+				name, err)
+		}
+	}
+	return nil
+}
+
+// GetCustomMetadata returns the user defined metadata for the specified object
+func (fs *S3Fs) GetCustomMetadata(name string) (map[string]string, error) {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return nil, err
+	}
+	return filterCustomMetadata(obj.Metadata), nil
+}
+
+// SetCustomMetadata updates the user defined metadata for the specified object.
+// S3 does not allow to modify the metadata for existing objects, so the object
+// is copied in place. Archived objects must be restored before
+func (fs *S3Fs) SetCustomMetadata(name string, metadata map[string]string) error {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	if isS3ArchiveStorageClass(obj.StorageClass, obj.ArchiveStatus) {
+		ongoing, _, ok := parseS3RestoreHeader(util.GetStringFromPointer(obj.Restore))
+		if !ok || ongoing {
+			return ErrObjectArchived
+		}
+	}
+	updated, err := mergeCustomMetadata(obj.Metadata, metadata)
+	if err != nil {
+		return err
+	}
+	copySource := pathEscape(fs.Join(fs.config.Bucket, name))
+	size := obj.ContentLength
+	if size > 500*1024*1024 {
+		fsLog(fs, logger.LevelDebug, "updating metadata for file %q with size %d using multipart copy",
+			name, size)
+		err = fs.doMultipartCopy(copySource, name, util.GetStringFromPointer(obj.ContentType),
+			string(obj.StorageClass), size, updated)
+	} else {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
+
+		_, err = fs.svc.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:            aws.String(fs.config.Bucket),
+			CopySource:        aws.String(copySource),
+			Key:               aws.String(name),
+			StorageClass:      obj.StorageClass,
+			ACL:               types.ObjectCannedACL(fs.config.ACL),
+			ContentType:       obj.ContentType,
+			Metadata:          updated,
+			MetadataDirective: types.MetadataDirectiveReplace,
+		})
+	}
+	metric.S3CopyObjectCompleted(err)
+	fsLog(fs, logger.LevelDebug, "metadata updated for object %q, err: %v", name, err)
+	if err != nil {
+		return err
+	}
+	readCache.invalidate(fs.getReadCacheKey(name))
+	if obj.LastModified != nil {
+		if err := preserveModificationTime(fs.getStorageID(), name, *obj.LastModified); err != nil {
+			fsLog(fs, logger.LevelWarn, "unable to preserve modification time after metadata update for %q: %+v",
+				name, err)
+		}
+	}
+	return nil
+}
+
+// GetRecordedChecksum returns the MD5 checksum for the specified object if the
+// ETag is the MD5 digest of the object data. This is not the case for multipart
+// uploads and for objects encrypted using SSE-KMS or SSE-C
+func (fs *S3Fs) GetRecordedChecksum(name string) (Checksum, error) {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return Checksum{}, err
+	}
+	if isS3ArchiveStorageClass(obj.StorageClass, obj.ArchiveStatus) {
+		ongoing, _, ok := parseS3RestoreHeader(util.GetStringFromPointer(obj.Restore))
+		if !ok || ongoing {
+			return Checksum{}, ErrObjectArchived
+		}
+	}
+	if obj.ServerSideEncryption == types.ServerSideEncryptionAwsKms || obj.SSECustomerAlgorithm != nil {
+		return Checksum{}, nil
+	}
+	etag := strings.Trim(util.GetStringFromPointer(obj.ETag), `"`)
+	if len(etag) != 32 || strings.Contains(etag, "-") {
+		return Checksum{}, nil
+	}
+	return Checksum{
+		Algorithm: ChecksumAlgoMD5,
+		Value:     strings.ToLower(etag),
+	}, nil
+}
+
 // GetMimeType returns the content type
 func (fs *S3Fs) GetMimeType(name string) (string, error) {
 	obj, err := fs.headObject(name)
@@ -968,6 +1470,142 @@ func (fs *S3Fs) GetMimeType(name string) (string, error) {
 	return util.GetStringFromPointer(obj.ContentType), nil
 }
 
+// ListVersions returns the versions for the specified object, newest first.
+// Delete markers are not returned
+func (fs *S3Fs) ListVersions(name string) ([]FileVersion, error) {
+	var versions []FileVersion
+	var keyMarker, versionIDMarker *string
+
+	for {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		page, err := fs.svc.ListObjectVersions(ctx, &s3.ListObjectVersionsInput{
+			Bucket:          aws.String(fs.config.Bucket),
+			Prefix:          aws.String(name),
+			KeyMarker:       keyMarker,
+			VersionIdMarker: versionIDMarker,
+		})
+		cancelFn()
+		if err != nil {
+			metric.S3ListObjectsCompleted(err)
+			return nil, err
+		}
+		for _, v := range page.Versions {
+			if util.GetStringFromPointer(v.Key) != name {
+				continue
+			}
+			version := FileVersion{
+				ID:       util.GetStringFromPointer(v.VersionId),
+				Size:     v.Size,
+				IsLatest: v.IsLatest,
+			}
+			if v.LastModified != nil {
+				version.ModTime = util.GetTimeAsMsSinceEpoch(*v.LastModified)
+			}
+			versions = append(versions, version)
+		}
+		if !page.IsTruncated {
+			break
+		}
+		keyMarker = page.NextKeyMarker
+		versionIDMarker = page.NextVersionIdMarker
+	}
+	metric.S3ListObjectsCompleted(nil)
+	sortFileVersions(versions)
+	return versions, nil
+}
+
+// OpenVersion opens the specified object version for reading.
+// The read cache is not used for object versions
+func (fs *S3Fs) OpenVersion(name, versionID string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	downloader := manager.NewDownloader(fs.svc, func(d *manager.Downloader) {
+		d.Concurrency = fs.config.DownloadConcurrency
+		d.PartSize = fs.config.DownloadPartSize
+	})
+
+	var streamRange *string
+	if offset > 0 {
+		streamRange = aws.String(fmt.Sprintf("bytes=%v-", offset))
+	}
+
+	go func() {
+		defer cancelFn()
+
+		var n int64
+		var err error
+		if fs.useRangedDownload(offset) {
+			n, err = fs.handleRangedDownload(ctx, name, aws.String(versionID), offset, w)
+		} else {
+			n, err = downloader.Download(ctx, w, &s3.GetObjectInput{
+				Bucket:    aws.String(fs.config.Bucket),
+				Key:       aws.String(name),
+				VersionId: aws.String(versionID),
+				Range:     streamRange,
+			})
+		}
+		if isS3InvalidObjectState(err) {
+			err = fmt.Errorf("%w: %v", ErrObjectArchived, err)
+		}
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path: %q version: %q size: %v, err: %+v",
+			name, versionID, n, err)
+		metric.S3TransferCompleted(n, 1, err)
+	}()
+	return nil, r, cancelFn, nil
+}
+
+// RestoreVersion makes the specified version the current one by copying it in place
+func (fs *S3Fs) RestoreVersion(name, versionID string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	obj, err := fs.svc.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:    aws.String(fs.config.Bucket),
+		Key:       aws.String(name),
+		VersionId: aws.String(versionID),
+	})
+	metric.S3HeadObjectCompleted(err)
+	if err != nil {
+		return err
+	}
+	if isS3ArchiveStorageClass(obj.StorageClass, obj.ArchiveStatus) {
+		ongoing, _, ok := parseS3RestoreHeader(util.GetStringFromPointer(obj.Restore))
+		if !ok || ongoing {
+			return ErrObjectArchived
+		}
+	}
+	copySource := pathEscape(fs.Join(fs.config.Bucket, name)) + "?versionId=" + url.QueryEscape(versionID)
+
+	readCache.invalidate(fs.getReadCacheKey(name))
+	size := obj.ContentLength
+	if size > 500*1024*1024 {
+		fsLog(fs, logger.LevelDebug, "restoring version %q for file %q with size %d using multipart copy",
+			versionID, name, size)
+		err = fs.doMultipartCopy(copySource, name, util.GetStringFromPointer(obj.ContentType),
+			fs.config.StorageClass, size, obj.Metadata)
+	} else {
+		_, err = fs.svc.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:            aws.String(fs.config.Bucket),
+			CopySource:        aws.String(copySource),
+			Key:               aws.String(name),
+			StorageClass:      types.StorageClass(fs.config.StorageClass),
+			ACL:               types.ObjectCannedACL(fs.config.ACL),
+			MetadataDirective: types.MetadataDirectiveCopy,
+		})
+	}
+	metric.S3CopyObjectCompleted(err)
+	fsLog(fs, logger.LevelDebug, "version %q restored for object %q, err: %v", versionID, name, err)
+	if err != nil {
+		return err
+	}
+	updateRestoredVersionModTime(fs, fs.getStorageID(), name)
+	return nil
+}
+
 // Close closes the fs
 func (*S3Fs) Close() error {
 	return nil
@@ -988,6 +1626,10 @@ func (fs *S3Fs) getStorageID() string {
 	return fmt.Sprintf("s3://%v", fs.config.Bucket)
 }
 
+func (fs *S3Fs) getReadCacheKey(name string) string {
+	return newReadCacheKey(fs.getStorageID(), name)
+}
+
 func getAWSHTTPClient(timeout int, idleConnectionTimeout time.Duration) *awshttp.BuildableClient {
 	c := awshttp.NewBuildableClient().
 		WithDialerOptions(func(d *net.Dialer) {
@@ -997,6 +1639,7 @@ func getAWSHTTPClient(timeout int, idleConnectionTimeout time.Duration) *awshttp
 			tr.IdleConnTimeout = idleConnectionTimeout
 			tr.WriteBufferSize = s3TransferBufferSize
 			tr.ReadBufferSize = s3TransferBufferSize
+			tr.DialContext = resolver.WrapDialContext(tr.DialContext)
 		})
 	if timeout > 0 {
 		c = c.WithTimeout(time.Duration(timeout) * time.Second)
@@ -1004,6 +1647,35 @@ func getAWSHTTPClient(timeout int, idleConnectionTimeout time.Duration) *awshttp
 	return c
 }
 
+// s3LatencyClient records the latency of the PUT requests, such as object
+// and multipart uploads, for the latency based throttling
+type s3LatencyClient struct {
+	client aws.HTTPClient
+}
+
+func (c *s3LatencyClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPut {
+		return c.client.Do(req)
+	}
+	startTime := time.Now()
+	resp, err := c.client.Do(req)
+	latency.Record(latency.BackendS3Put, time.Since(startTime))
+	return resp, err
+}
+
+// offsetWriter writes sequentially to the wrapped WriterAt starting at the
+// specified offset
+type offsetWriter struct {
+	w      io.WriterAt
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
 // ideally we should simply use url.PathEscape:
 //
 // https://github.com/awsdocs/aws-doc-sdk-examples/blob/master/go/example_code/s3/s3_copy_object.go#L65
@@ -1014,3 +1686,66 @@ func pathEscape(in string) string {
 	u.Path = in
 	return strings.ReplaceAll(u.String(), "+", "%2B")
 }
+
+func isS3ArchiveStorageClass(storageClass types.StorageClass, archiveStatus types.ArchiveStatus) bool {
+	switch storageClass {
+	case types.StorageClassGlacier, types.StorageClassDeepArchive:
+		return true
+	case types.StorageClassIntelligentTiering:
+		return archiveStatus != ""
+	default:
+		return false
+	}
+}
+
+func isS3InvalidObjectState(err error) bool {
+	if err == nil {
+		return false
+	}
+	var stateErr *types.InvalidObjectState
+	if errors.As(err, &stateErr) {
+		return true
+	}
+	var apiErr interface{ ErrorCode() string }
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidObjectState"
+}
+
+// parseS3RestoreHeader parses the x-amz-restore header, for example:
+// ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"
+func parseS3RestoreHeader(value string) (bool, time.Time, bool) {
+	var ongoing bool
+	var expiresAt time.Time
+	found := false
+
+	for value != "" {
+		var part string
+		key, rest, ok := strings.Cut(value, "=")
+		if !ok {
+			break
+		}
+		key = strings.TrimSpace(key)
+		rest = strings.TrimSpace(rest)
+		if strings.HasPrefix(rest, "\"") {
+			rest = rest[1:]
+			idx := strings.Index(rest, "\"")
+			if idx < 0 {
+				break
+			}
+			part = rest[:idx]
+			rest = strings.TrimPrefix(strings.TrimSpace(rest[idx+1:]), ",")
+		} else {
+			part, rest, _ = strings.Cut(rest, ",")
+		}
+		value = rest
+		switch key {
+		case "ongoing-request":
+			ongoing = part == "true"
+			found = true
+		case "expiry-date":
+			if t, err := http.ParseTime(part); err == nil {
+				expiresAt = t
+			}
+		}
+	}
+	return ongoing, expiresAt, found
+}
@@ -0,0 +1,408 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nos3
+// +build !nos3
+
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+const testS3Bucket = "bucket"
+
+type fakeS3Object struct {
+	data     []byte
+	metadata map[string]string
+	modTime  time.Time
+}
+
+// fakeS3Server is a minimal S3 compatible server, using path style requests,
+// for a single bucket
+type fakeS3Server struct {
+	sync.Mutex
+	server  *httptest.Server
+	objects map[string]fakeS3Object
+	ranges  []string
+	// if set, it is called for each ranged GET request and can handle the
+	// response itself returning true
+	onRangedGet func(w http.ResponseWriter, start, end int64) bool
+}
+
+func newFakeS3Server(t *testing.T) *fakeS3Server {
+	s := &fakeS3Server{
+		objects: make(map[string]fakeS3Object),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *fakeS3Server) addObject(key string, data []byte, metadata map[string]string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.objects[key] = fakeS3Object{
+		data:     data,
+		metadata: metadata,
+		modTime:  time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+func (s *fakeS3Server) getObject(key string) (fakeS3Object, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	obj, ok := s.objects[key]
+	return obj, ok
+}
+
+func (s *fakeS3Server) resetRequestedRanges() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.ranges = nil
+}
+
+func (s *fakeS3Server) getRequestedRanges() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	ranges := make([]string, len(s.ranges))
+	copy(ranges, s.ranges)
+	sort.Slice(ranges, func(i, j int) bool {
+		return getRangeStart(ranges[i]) < getRangeStart(ranges[j])
+	})
+	return ranges
+}
+
+func (s *fakeS3Server) sendError(w http.ResponseWriter, statusCode int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+func (s *fakeS3Server) handle(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/"+testS3Bucket+"/")
+	switch r.Method {
+	case http.MethodHead:
+		obj, ok := s.getObject(key)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for k, v := range obj.metadata {
+			w.Header().Set("X-Amz-Meta-"+k, v)
+		}
+		w.Header().Set("Last-Modified", obj.modTime.Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.sendError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		metadata := make(map[string]string)
+		for k := range r.Header {
+			if strings.HasPrefix(strings.ToLower(k), "x-amz-meta-") {
+				metadata[strings.ToLower(k[len("x-amz-meta-"):])] = r.Header.Get(k)
+			}
+		}
+		s.addObject(key, data, metadata)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		if r.URL.Query().Get("list-type") == "2" {
+			s.listObjects(w, r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter"))
+			return
+		}
+		obj, ok := s.getObject(key)
+		if !ok {
+			s.sendError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		rangeHeader := r.Header.Get("Range")
+		if rangeHeader == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+			w.WriteHeader(http.StatusOK)
+			w.Write(obj.data) //nolint:errcheck
+			return
+		}
+		s.Lock()
+		s.ranges = append(s.ranges, rangeHeader)
+		s.Unlock()
+		start, end := parseTestRange(rangeHeader, int64(len(obj.data)))
+		if s.onRangedGet != nil && s.onRangedGet(w, start, end) {
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, len(obj.data)))
+		w.Header().Set("Content-Length", strconv.FormatInt(end-start, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(obj.data[start:end]) //nolint:errcheck
+	default:
+		s.sendError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// listObjects sends a ListObjectsV2 response, pagination is not supported
+func (s *fakeS3Server) listObjects(w http.ResponseWriter, prefix, delimiter string) {
+	s.Lock()
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var contents, commonPrefixes strings.Builder
+	seenPrefixes := make(map[string]bool)
+	for _, k := range keys {
+		if delimiter != "" {
+			if idx := strings.Index(k[len(prefix):], delimiter); idx >= 0 {
+				commonPrefix := k[:len(prefix)+idx+len(delimiter)]
+				if !seenPrefixes[commonPrefix] {
+					seenPrefixes[commonPrefix] = true
+					fmt.Fprintf(&commonPrefixes, "<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>",
+						html.EscapeString(commonPrefix))
+				}
+				continue
+			}
+		}
+		obj := s.objects[k]
+		fmt.Fprintf(&contents, "<Contents><Key>%s</Key><LastModified>%s</LastModified><Size>%d</Size></Contents>",
+			html.EscapeString(k), obj.modTime.Format("2006-01-02T15:04:05.000Z"), len(obj.data))
+	}
+	s.Unlock()
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`+
+		`<Name>%s</Name><Prefix>%s</Prefix><IsTruncated>false</IsTruncated>%s%s</ListBucketResult>`,
+		testS3Bucket, html.EscapeString(prefix), contents.String(), commonPrefixes.String())
+}
+
+// parseTestRange parses a range header like "bytes=start-end" or "bytes=start-",
+// the returned end is excluded
+func parseTestRange(rangeHeader string, size int64) (int64, int64) {
+	parts := strings.SplitN(strings.TrimPrefix(rangeHeader, "bytes="), "-", 2)
+	start, _ := strconv.ParseInt(parts[0], 10, 64)
+	end := size
+	if len(parts) == 2 && parts[1] != "" {
+		val, _ := strconv.ParseInt(parts[1], 10, 64)
+		if val+1 < end {
+			end = val + 1
+		}
+	}
+	return start, end
+}
+
+func getRangeStart(rangeHeader string) int64 {
+	start, _ := parseTestRange(rangeHeader, 0)
+	return start
+}
+
+func getTestS3Fs(t *testing.T, endpoint string, partSize int64, concurrency int) *S3Fs {
+	fs, err := NewS3Fs("connID", t.TempDir(), "", S3FsConfig{
+		BaseS3FsConfig: sdk.BaseS3FsConfig{
+			Bucket:              testS3Bucket,
+			Region:              "us-east-1",
+			AccessKey:           "access_key",
+			Endpoint:            endpoint,
+			ForcePathStyle:      true,
+			DownloadConcurrency: concurrency,
+		},
+		AccessSecret: kms.NewPlainSecret("access_secret"),
+	})
+	require.NoError(t, err)
+	s3Fs, ok := fs.(*S3Fs)
+	require.True(t, ok)
+	// use small parts, the configured part size cannot be lower than 1MB
+	s3Fs.config.DownloadPartSize = partSize
+	return s3Fs
+}
+
+func getTestData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func TestS3UseRangedDownload(t *testing.T) {
+	fs := &S3Fs{
+		config: &S3FsConfig{},
+	}
+	fs.config.DownloadConcurrency = 1
+	assert.False(t, fs.useRangedDownload(0))
+	assert.False(t, fs.useRangedDownload(100))
+	fs.config.DownloadConcurrency = 5
+	assert.False(t, fs.useRangedDownload(0))
+	assert.True(t, fs.useRangedDownload(100))
+}
+
+func TestS3RangedDownloadParts(t *testing.T) {
+	server := newFakeS3Server(t)
+	data := getTestData(1050)
+	server.addObject("file", data, nil)
+	fs := getTestS3Fs(t, server.server.URL, 100, 3)
+
+	offset := int64(25)
+	buf := manager.NewWriteAtBuffer(nil)
+	n, err := fs.handleRangedDownload(context.Background(), "file", nil, offset, buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data))-offset, n)
+	assert.Equal(t, data[offset:], buf.Bytes())
+	// the last part is shorter than the part size
+	ranges := server.getRequestedRanges()
+	if assert.Len(t, ranges, 11) {
+		assert.Equal(t, "bytes=25-124", ranges[0])
+		assert.Equal(t, "bytes=125-224", ranges[1])
+		assert.Equal(t, "bytes=1025-1049", ranges[10])
+	}
+	// the object size is a multiple of the part size, starting from the offset
+	server.resetRequestedRanges()
+	buf = manager.NewWriteAtBuffer(nil)
+	n, err = fs.handleRangedDownload(context.Background(), "file", nil, 850, buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(200), n)
+	assert.Equal(t, data[850:], buf.Bytes())
+	assert.Equal(t, []string{"bytes=850-949", "bytes=950-1049"}, server.getRequestedRanges())
+	// the offset is near the end of the object
+	server.resetRequestedRanges()
+	buf = manager.NewWriteAtBuffer(nil)
+	n, err = fs.handleRangedDownload(context.Background(), "file", nil, 1049, buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, data[1049:], buf.Bytes())
+	assert.Equal(t, []string{"bytes=1049-1049"}, server.getRequestedRanges())
+}
+
+func TestS3RangedDownloadEOF(t *testing.T) {
+	server := newFakeS3Server(t)
+	data := getTestData(300)
+	server.addObject("file", data, nil)
+	fs := getTestS3Fs(t, server.server.URL, 100, 2)
+	// nothing to download if the offset is equal to the object size
+	buf := manager.NewWriteAtBuffer(nil)
+	n, err := fs.handleRangedDownload(context.Background(), "file", nil, 300, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+	assert.Len(t, server.getRequestedRanges(), 0)
+	_, err = fs.handleRangedDownload(context.Background(), "file", nil, 301, buf)
+	assert.ErrorContains(t, err, "offset exceeds the file size")
+	assert.Len(t, server.getRequestedRanges(), 0)
+	// the object does not exist
+	_, err = fs.handleRangedDownload(context.Background(), "missing", nil, 10, buf)
+	assert.True(t, fs.IsNotExist(err), "unexpected error: %v", err)
+	// a part is shorter than expected
+	server.onRangedGet = func(w http.ResponseWriter, start, end int64) bool {
+		if start != 210 {
+			return false
+		}
+		w.Header().Set("Content-Length", "50")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[start : start+50]) //nolint:errcheck
+		return true
+	}
+	buf = manager.NewWriteAtBuffer(nil)
+	n, err = fs.handleRangedDownload(context.Background(), "file", nil, 10, buf)
+	assert.ErrorContains(t, err, "unexpected part size, expected: 90, actual: 50")
+	assert.Equal(t, int64(250), n)
+}
+
+func TestS3RangedDownloadPartError(t *testing.T) {
+	server := newFakeS3Server(t)
+	data := getTestData(2000)
+	server.addObject("file", data, nil)
+	fs := getTestS3Fs(t, server.server.URL, 100, 2)
+
+	var failedRequests int
+	var mu sync.Mutex
+	server.onRangedGet = func(w http.ResponseWriter, start, end int64) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if start == 401 {
+			failedRequests++
+			server.sendError(w, http.StatusForbidden, "AccessDenied")
+			return true
+		}
+		return false
+	}
+	buf := manager.NewWriteAtBuffer(nil)
+	n, err := fs.handleRangedDownload(context.Background(), "file", nil, 1, buf)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "ranged download error")
+		assert.Contains(t, err.Error(), "AccessDenied")
+	}
+	assert.Less(t, n, int64(len(data)-1))
+	mu.Lock()
+	assert.Equal(t, 1, failedRequests)
+	mu.Unlock()
+	// the remaining parts are not requested after the error
+	assert.Less(t, len(server.getRequestedRanges()), 20)
+	// the download is aborted if the context is canceled
+	server.onRangedGet = nil
+	ctx, cancelFn := context.WithCancel(context.Background())
+	cancelFn()
+	_, err = fs.handleRangedDownload(ctx, "file", nil, 1, manager.NewWriteAtBuffer(nil))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestS3OpenWithOffset(t *testing.T) {
+	server := newFakeS3Server(t)
+	data := getTestData(1000)
+	server.addObject("dir/file", data, nil)
+	fs := getTestS3Fs(t, server.server.URL, 128, 4)
+
+	_, r, cancelFn, err := fs.Open("dir/file", 100)
+	require.NoError(t, err)
+	defer cancelFn()
+	content, err := io.ReadAll(io.NewSectionReader(r, 0, 2000))
+	assert.NoError(t, err)
+	assert.Equal(t, data[100:], content)
+	assert.NoError(t, r.Close())
+	assert.Len(t, server.getRequestedRanges(), 8)
+
+	server.onRangedGet = func(w http.ResponseWriter, start, end int64) bool {
+		server.sendError(w, http.StatusForbidden, "AccessDenied")
+		return true
+	}
+	_, r, cancelFn, err = fs.Open("dir/file", 100)
+	require.NoError(t, err)
+	defer cancelFn()
+	_, err = io.ReadAll(io.NewSectionReader(r, 0, 2000))
+	assert.ErrorContains(t, err, "AccessDenied")
+	assert.NoError(t, r.Close())
+}
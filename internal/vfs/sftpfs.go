@@ -16,6 +16,7 @@ package vfs
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -37,6 +38,7 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/resolver"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/version"
 )
@@ -52,10 +54,13 @@ var ErrSFTPLoop = errors.New("SFTP loop or nested local SFTP folders detected")
 // SFTPFsConfig defines the configuration for SFTP based filesystem
 type SFTPFsConfig struct {
 	sdk.BaseSFTPFsConfig
-	Password               *kms.Secret `json:"password,omitempty"`
-	PrivateKey             *kms.Secret `json:"private_key,omitempty"`
-	KeyPassphrase          *kms.Secret `json:"key_passphrase,omitempty"`
-	forbiddenSelfUsernames []string    `json:"-"`
+	Password      *kms.Secret `json:"password,omitempty"`
+	PrivateKey    *kms.Secret `json:"private_key,omitempty"`
+	KeyPassphrase *kms.Secret `json:"key_passphrase,omitempty"`
+	// If enabled, the remote credentials are resolved at login time from the
+	// credentials defined for the authenticated user. Supported for virtual folders only
+	UserCredentials        bool     `json:"user_credentials,omitempty"`
+	forbiddenSelfUsernames []string `json:"-"`
 }
 
 // HideConfidentialData hides confidential data
@@ -99,6 +104,9 @@ func (c *SFTPFsConfig) isEqual(other SFTPFsConfig) bool {
 	if c.BufferSize != other.BufferSize {
 		return false
 	}
+	if c.UserCredentials != other.UserCredentials {
+		return false
+	}
 	if len(c.Fingerprints) != len(other.Fingerprints) {
 		return false
 	}
@@ -149,7 +157,7 @@ func (c *SFTPFsConfig) validate() error {
 	if err != nil {
 		return fmt.Errorf("invalid endpoint: %v", err)
 	}
-	if c.Username == "" {
+	if c.Username == "" && !c.UserCredentials {
 		return errors.New("username cannot be empty")
 	}
 	if c.BufferSize < 0 || c.BufferSize > 16 {
@@ -170,7 +178,7 @@ func (c *SFTPFsConfig) validate() error {
 }
 
 func (c *SFTPFsConfig) validateCredentials() error {
-	if c.Password.IsEmpty() && c.PrivateKey.IsEmpty() {
+	if c.Password.IsEmpty() && c.PrivateKey.IsEmpty() && !c.UserCredentials {
 		return errors.New("credentials cannot be empty")
 	}
 	if c.Password.IsEncrypted() && !c.Password.IsValid() {
@@ -842,7 +850,7 @@ func (fs *SFTPFs) createConnection() error {
 		User: fs.config.Username,
 		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
 			fp := ssh.FingerprintSHA256(key)
-			if util.Contains(sftpFingerprints, fp) {
+			if isSFTPFingerprint(fp) {
 				if allowSelfConnections == 0 {
 					fsLog(fs, logger.LevelError, "SFTP self connections not allowed")
 					return ErrSFTPLoop
@@ -895,7 +903,7 @@ func (fs *SFTPFs) createConnection() error {
 	clientConfig.MACs = []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256",
 		"hmac-sha2-512-etm@openssh.com", "hmac-sha2-512",
 		"hmac-sha1", "hmac-sha1-96"}
-	fs.sshClient, err = ssh.Dial("tcp", fs.config.Endpoint, clientConfig)
+	fs.sshClient, err = dialSSH(fs.config.Endpoint, clientConfig)
 	if err != nil {
 		fsLog(fs, logger.LevelError, "unable to connect: %v", err)
 		fs.err <- err
@@ -944,3 +952,19 @@ func (fs *SFTPFs) closed() error {
 		return nil
 	}
 }
+
+// dialSSH works like ssh.Dial but the endpoint is resolved using the
+// configured DNS settings
+func dialSSH(endpoint string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	dial := resolver.GetDialContext(&net.Dialer{Timeout: config.Timeout})
+	conn, err := dial(context.Background(), "tcp", endpoint)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, endpoint, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
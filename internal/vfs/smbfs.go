@@ -0,0 +1,711 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/hirochachacha/go-smb2"
+	"github.com/pkg/sftp"
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/resolver"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// smbFsName is the name for the SMB Fs implementation
+	smbFsName          = "smbfs"
+	smbDefaultPort     = "445"
+	smbConnectTimeout  = 10 * time.Second
+	smbShareNameMaxLen = 80
+)
+
+// SMBFsConfig defines the configuration for SMB/CIFS based filesystem
+type SMBFsConfig struct {
+	// Endpoint as host:port, if the port is omitted the default SMB port (445) is used
+	Endpoint string `json:"endpoint,omitempty"`
+	// Share is the name of the remote share to mount
+	Share string `json:"share,omitempty"`
+	// Domain for NTLM authentication, leave empty for local server accounts
+	Domain   string      `json:"domain,omitempty"`
+	Username string      `json:"username,omitempty"`
+	Password *kms.Secret `json:"password,omitempty"`
+	// Prefix is the path, inside the share, to use as root. Similar to a chroot
+	Prefix string `json:"prefix,omitempty"`
+	// Defines how to check if this config points to the same
+	// server as another config. If different configs point to the same
+	// server the renaming between the fs configs is allowed:
+	//  - 0 endpoint, share and username must match
+	//  - 1 only the endpoint and the share must match
+	EqualityCheckMode int `json:"equality_check_mode,omitempty"`
+}
+
+// HideConfidentialData hides confidential data
+func (c *SMBFsConfig) HideConfidentialData() {
+	if c.Password != nil {
+		c.Password.Hide()
+	}
+}
+
+func (c *SMBFsConfig) setNilSecretsIfEmpty() {
+	if c.Password != nil && c.Password.IsEmpty() {
+		c.Password = nil
+	}
+}
+
+func (c *SMBFsConfig) setEmptyCredentialsIfNil() {
+	if c.Password == nil {
+		c.Password = kms.NewEmptySecret()
+	}
+}
+
+func (c *SMBFsConfig) isEqual(other SMBFsConfig) bool {
+	if c.Endpoint != other.Endpoint {
+		return false
+	}
+	if c.Share != other.Share {
+		return false
+	}
+	if c.Domain != other.Domain {
+		return false
+	}
+	if c.Username != other.Username {
+		return false
+	}
+	if c.Prefix != other.Prefix {
+		return false
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	return c.Password.IsEqual(other.Password)
+}
+
+func (c *SMBFsConfig) isSameResource(other SMBFsConfig) bool {
+	if c.EqualityCheckMode == 0 || other.EqualityCheckMode == 0 {
+		if c.Domain != other.Domain || c.Username != other.Username {
+			return false
+		}
+	}
+	return c.Endpoint == other.Endpoint && strings.EqualFold(c.Share, other.Share)
+}
+
+func (c *SMBFsConfig) getAddress() string {
+	if _, _, err := net.SplitHostPort(c.Endpoint); err == nil {
+		return c.Endpoint
+	}
+	return net.JoinHostPort(c.Endpoint, smbDefaultPort)
+}
+
+// validate returns an error if the configuration is not valid
+func (c *SMBFsConfig) validate() error {
+	c.setEmptyCredentialsIfNil()
+	if c.Endpoint == "" {
+		return errors.New("endpoint cannot be empty")
+	}
+	if _, _, err := net.SplitHostPort(c.Endpoint); err != nil {
+		// the port is optional, a colon is allowed only for IPv6 addresses
+		if strings.Contains(c.Endpoint, ":") && net.ParseIP(c.Endpoint) == nil {
+			return fmt.Errorf("invalid endpoint: %v", err)
+		}
+	}
+	c.Share = strings.Trim(c.Share, `/\`)
+	if c.Share == "" {
+		return errors.New("share cannot be empty")
+	}
+	if len(c.Share) > smbShareNameMaxLen || strings.ContainsAny(c.Share, `/\`) {
+		return fmt.Errorf("invalid share name %q", c.Share)
+	}
+	if c.Username == "" {
+		return errors.New("username cannot be empty")
+	}
+	if !isEqualityCheckModeValid(c.EqualityCheckMode) {
+		return errors.New("invalid equality_check_mode")
+	}
+	if c.Password.IsEmpty() {
+		return errors.New("password cannot be empty")
+	}
+	if c.Password.IsEncrypted() && !c.Password.IsValid() {
+		return errors.New("invalid encrypted password")
+	}
+	if !c.Password.IsValidInput() {
+		return errors.New("invalid password")
+	}
+	if c.Prefix != "" {
+		c.Prefix = util.CleanPath(strings.ReplaceAll(c.Prefix, `\`, "/"))
+	} else {
+		c.Prefix = "/"
+	}
+	return nil
+}
+
+// ValidateAndEncryptCredentials validates the config and encrypts credentials if they are in plain text
+func (c *SMBFsConfig) ValidateAndEncryptCredentials(additionalData string) error {
+	if err := c.validate(); err != nil {
+		return util.NewValidationError(fmt.Sprintf("could not validate SMB fs config: %v", err))
+	}
+	if c.Password.IsPlain() {
+		c.Password.SetAdditionalData(additionalData)
+		if err := c.Password.Encrypt(); err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt SMB fs password: %v", err))
+		}
+	}
+	return nil
+}
+
+// SMBFs is a Fs implementation for SMB/CIFS shares
+type SMBFs struct {
+	sync.Mutex
+	connectionID string
+	// if not empty this fs is mouted as virtual folder in the specified path
+	mountPath    string
+	localTempDir string
+	config       *SMBFsConfig
+	conn         net.Conn
+	session      *smb2.Session
+	share        *smb2.Share
+}
+
+// NewSMBFs returns an SMBFs object that allows to interact with an SMB share
+func NewSMBFs(connectionID, mountPath, localTempDir string, config SMBFsConfig) (Fs, error) {
+	if localTempDir == "" {
+		if tempPath != "" {
+			localTempDir = tempPath
+		} else {
+			localTempDir = filepath.Clean(os.TempDir())
+		}
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Password.TryDecrypt(); err != nil {
+		return nil, err
+	}
+	smbFs := &SMBFs{
+		connectionID: connectionID,
+		mountPath:    getMountPath(mountPath),
+		localTempDir: localTempDir,
+		config:       &config,
+	}
+	_, err := smbFs.getShare()
+	return smbFs, err
+}
+
+// Name returns the name for the Fs implementation
+func (fs *SMBFs) Name() string {
+	return fmt.Sprintf("%s %q share %q", smbFsName, fs.config.Endpoint, fs.config.Share)
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *SMBFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *SMBFs) Stat(name string) (os.FileInfo, error) {
+	share, err := fs.getShare()
+	if err != nil {
+		return nil, err
+	}
+	info, err := share.Stat(fs.getSharePath(name))
+	if err != nil {
+		return nil, fs.checkError(err)
+	}
+	return NewFileInfo(info.Name(), info.IsDir(), info.Size(), info.ModTime(), false), nil
+}
+
+// Lstat returns a FileInfo describing the named file.
+// Symbolic links are not supported, so this is the same as Stat
+func (fs *SMBFs) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// Open opens the named file for reading
+func (fs *SMBFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	share, err := fs.getShare()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	f, err := share.Open(fs.getSharePath(name))
+	if err != nil {
+		return nil, nil, nil, fs.checkError(err)
+	}
+	if offset > 0 {
+		_, err = f.Seek(offset, io.SeekStart)
+		if err != nil {
+			f.Close()
+			return nil, nil, nil, fs.checkError(err)
+		}
+	}
+	return f, nil, nil, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *SMBFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	share, err := fs.getShare()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if flag == 0 {
+		flag = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := share.OpenFile(fs.getSharePath(name), flag, 0666)
+	if err != nil {
+		return nil, nil, nil, fs.checkError(err)
+	}
+	return f, nil, nil, nil
+}
+
+// Rename renames (moves) source to target.
+// SMB does not replace an existing target, so an existing target file is
+// removed before renaming to get the same semantics as the other backends
+func (fs *SMBFs) Rename(source, target string) error {
+	if source == target {
+		return nil
+	}
+	share, err := fs.getShare()
+	if err != nil {
+		return err
+	}
+	sourcePath := fs.getSharePath(source)
+	targetPath := fs.getSharePath(target)
+	err = share.Rename(sourcePath, targetPath)
+	if err == nil || !errors.Is(err, os.ErrExist) {
+		return fs.checkError(err)
+	}
+	info, errStat := share.Stat(targetPath)
+	if errStat != nil {
+		return fs.checkError(err)
+	}
+	if info.IsDir() {
+		// a directory cannot replace another directory, this
+		// is the same behavior as the local filesystem for non empty dirs
+		return err
+	}
+	fsLog(fs, logger.LevelDebug, "rename target %q exists, removing it before renaming", target)
+	if err := share.Remove(targetPath); err != nil {
+		return fs.checkError(err)
+	}
+	return fs.checkError(share.Rename(sourcePath, targetPath))
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs *SMBFs) Remove(name string, isDir bool) error {
+	share, err := fs.getShare()
+	if err != nil {
+		return err
+	}
+	return fs.checkError(share.Remove(fs.getSharePath(name)))
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *SMBFs) Mkdir(name string) error {
+	share, err := fs.getShare()
+	if err != nil {
+		return err
+	}
+	return fs.checkError(share.Mkdir(fs.getSharePath(name), os.ModePerm))
+}
+
+// Symlink creates source as a symbolic link to target.
+func (*SMBFs) Symlink(source, target string) error {
+	return ErrVfsUnsupported
+}
+
+// Readlink returns the destination of the named symbolic link
+func (*SMBFs) Readlink(name string) (string, error) {
+	return "", ErrVfsUnsupported
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (*SMBFs) Chown(name string, uid int, gid int) error {
+	return ErrVfsUnsupported
+}
+
+// Chmod changes the mode of the named file to mode.
+// SMB only supports toggling the read-only attribute
+func (fs *SMBFs) Chmod(name string, mode os.FileMode) error {
+	share, err := fs.getShare()
+	if err != nil {
+		return err
+	}
+	return fs.checkError(share.Chmod(fs.getSharePath(name), mode))
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (fs *SMBFs) Chtimes(name string, atime, mtime time.Time, isUploading bool) error {
+	share, err := fs.getShare()
+	if err != nil {
+		return err
+	}
+	return fs.checkError(share.Chtimes(fs.getSharePath(name), atime, mtime))
+}
+
+// Truncate changes the size of the named file.
+func (fs *SMBFs) Truncate(name string, size int64) error {
+	share, err := fs.getShare()
+	if err != nil {
+		return err
+	}
+	return fs.checkError(share.Truncate(fs.getSharePath(name), size))
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs *SMBFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	share, err := fs.getShare()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := share.ReadDir(fs.getSharePath(dirname))
+	if err != nil {
+		return nil, fs.checkError(err)
+	}
+	result := make([]os.FileInfo, 0, len(entries))
+	for _, info := range entries {
+		result = append(result, NewFileInfo(info.Name(), info.IsDir(), info.Size(), info.ModTime(), false))
+	}
+	return result, nil
+}
+
+// IsUploadResumeSupported returns true if resuming uploads is supported.
+func (*SMBFs) IsUploadResumeSupported() bool {
+	return true
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+func (*SMBFs) IsAtomicUploadSupported() bool {
+	return true
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (*SMBFs) IsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (*SMBFs) IsPermission(err error) bool {
+	if _, ok := err.(*pathResolutionError); ok {
+		return true
+	}
+	return errors.Is(err, fs.ErrPermission)
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (*SMBFs) IsNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	return err == ErrVfsUnsupported
+}
+
+// CheckRootPath creates the specified local root directory if it does not exists
+func (fs *SMBFs) CheckRootPath(username string, uid int, gid int) bool {
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, "")
+	osFs.CheckRootPath(username, uid, gid)
+	if fs.config.Prefix == "/" {
+		return true
+	}
+	share, err := fs.getShare()
+	if err != nil {
+		return false
+	}
+	if err := share.MkdirAll(fs.getSharePath(fs.config.Prefix), os.ModePerm); err != nil {
+		fsLog(fs, logger.LevelDebug, "error creating root directory %q for user %q: %v", fs.config.Prefix, username, err)
+		fs.checkError(err) //nolint:errcheck
+		return false
+	}
+	return true
+}
+
+// ScanRootDirContents returns the number of files contained in a directory and
+// their size
+func (fs *SMBFs) ScanRootDirContents() (int, int64, error) {
+	return fs.GetDirSize(fs.config.Prefix)
+}
+
+// CheckMetadata checks the metadata consistency
+func (*SMBFs) CheckMetadata() error {
+	return nil
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload
+func (*SMBFs) GetAtomicUploadPath(name string) string {
+	dir := path.Dir(name)
+	guid := xid.New().String()
+	return path.Join(dir, ".sftpgo-upload."+guid+"."+path.Base(name))
+}
+
+// GetRelativePath returns the path for a file relative to the share prefix if any.
+// This is the path as seen by SFTPGo users
+func (fs *SMBFs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		return "/" + rel
+	}
+	if fs.config.Prefix != "/" {
+		if !strings.HasPrefix(rel, fs.config.Prefix) {
+			rel = "/"
+		}
+		rel = path.Clean("/" + strings.TrimPrefix(rel, fs.config.Prefix))
+	}
+	if fs.mountPath != "" {
+		rel = path.Join(fs.mountPath, rel)
+	}
+	return rel
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *SMBFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Stat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	return fs.walk(root, info, walkFn)
+}
+
+// Join joins any number of path elements into a single path
+func (*SMBFs) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*SMBFs) HasVirtualFolders() bool {
+	return false
+}
+
+// ResolvePath returns the matching filesystem path for the specified virtual path
+func (fs *SMBFs) ResolvePath(virtualPath string) (string, error) {
+	if fs.mountPath != "" {
+		virtualPath = strings.TrimPrefix(virtualPath, fs.mountPath)
+	}
+	if !path.IsAbs(virtualPath) {
+		virtualPath = path.Clean("/" + virtualPath)
+	}
+	// backslashes are path separators for SMB, they must not be
+	// used to escape the configured prefix
+	if strings.Contains(virtualPath, `\`) {
+		err := fmt.Errorf("invalid path %q", virtualPath)
+		return "", &pathResolutionError{err: err.Error()}
+	}
+	return fs.Join(fs.config.Prefix, virtualPath), nil
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders
+func (fs *SMBFs) GetDirSize(dirname string) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	isDir, err := isDirectory(fs, dirname)
+	if err == nil && isDir {
+		err = fs.Walk(dirname, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info != nil && info.Mode().IsRegular() {
+				size += info.Size()
+				numFiles++
+			}
+			return nil
+		})
+	}
+	return numFiles, size, err
+}
+
+// GetMimeType returns the content type
+func (fs *SMBFs) GetMimeType(name string) (string, error) {
+	share, err := fs.getShare()
+	if err != nil {
+		return "", err
+	}
+	f, err := share.Open(fs.getSharePath(name))
+	if err != nil {
+		return "", fs.checkError(err)
+	}
+	defer f.Close()
+	var buf [512]byte
+	n, err := io.ReadFull(f, buf[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", fs.checkError(err)
+	}
+	ctype := http.DetectContentType(buf[:n])
+	// Rewind file.
+	_, err = f.Seek(0, io.SeekStart)
+	return ctype, err
+}
+
+// GetAvailableDiskSize returns the available size for the specified path
+func (fs *SMBFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
+	share, err := fs.getShare()
+	if err != nil {
+		return nil, err
+	}
+	info, err := share.Statfs(fs.getSharePath(dirName))
+	if err != nil {
+		return nil, fs.checkError(err)
+	}
+	return &sftp.StatVFS{
+		Bsize:   info.BlockSize(),
+		Frsize:  info.FragmentSize(),
+		Blocks:  info.TotalBlockCount(),
+		Bfree:   info.FreeBlockCount(),
+		Bavail:  info.AvailableBlockCount(),
+		Namemax: 255,
+	}, nil
+}
+
+// Close the connection
+func (fs *SMBFs) Close() error {
+	fs.Lock()
+	defer fs.Unlock()
+
+	return fs.closeConnection()
+}
+
+func (fs *SMBFs) walk(filePath string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(filePath, info, nil)
+	}
+	files, err := fs.ReadDir(filePath)
+	err1 := walkFn(filePath, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	for _, fi := range files {
+		objName := path.Join(filePath, fi.Name())
+		err = fs.walk(objName, fi, walkFn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getSharePath converts the specified absolute path to a path relative to
+// the share root using the SMB path separator
+func (*SMBFs) getSharePath(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	return strings.ReplaceAll(name, "/", `\`)
+}
+
+// checkError closes the connection if the specified error is a transport
+// error, a new connection will be established on the next request
+func (fs *SMBFs) checkError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var transportErr *smb2.TransportError
+	if errors.As(err, &transportErr) {
+		fsLog(fs, logger.LevelDebug, "transport error, closing connection: %v", err)
+		fs.Lock()
+		fs.closeConnection() //nolint:errcheck
+		fs.Unlock()
+	}
+	return err
+}
+
+func (fs *SMBFs) getShare() (*smb2.Share, error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if fs.share != nil {
+		return fs.share, nil
+	}
+	if err := fs.createConnection(); err != nil {
+		return nil, err
+	}
+	return fs.share, nil
+}
+
+func (fs *SMBFs) createConnection() error {
+	address := fs.config.getAddress()
+	dial := resolver.GetDialContext(&net.Dialer{Timeout: smbConnectTimeout})
+	conn, err := dial(context.Background(), "tcp", address)
+	if err != nil {
+		fsLog(fs, logger.LevelError, "unable to connect to %q: %v", address, err)
+		return err
+	}
+	// only NTLMv2 authentication is supported by the SMB client library
+	dialer := &smb2.Dialer{
+		Initiator: &smb2.NTLMInitiator{
+			User:     fs.config.Username,
+			Password: fs.config.Password.GetPayload(),
+			Domain:   fs.config.Domain,
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), smbConnectTimeout)
+	defer cancel()
+
+	session, err := dialer.DialContext(ctx, conn)
+	if err != nil {
+		fsLog(fs, logger.LevelError, "unable to establish an SMB session with %q: %v", address, err)
+		conn.Close()
+		return err
+	}
+	share, err := session.Mount(fs.config.Share)
+	if err != nil {
+		fsLog(fs, logger.LevelError, "unable to mount share %q: %v", fs.config.Share, err)
+		session.Logoff() //nolint:errcheck
+		conn.Close()
+		return err
+	}
+	fs.conn = conn
+	fs.session = session
+	fs.share = share
+	return nil
+}
+
+func (fs *SMBFs) closeConnection() error {
+	var err error
+	if fs.share != nil {
+		err = fs.share.Umount()
+	}
+	if fs.session != nil {
+		if errLogoff := fs.session.Logoff(); err == nil {
+			err = errLogoff
+		}
+	}
+	if fs.conn != nil {
+		if errClose := fs.conn.Close(); err == nil {
+			err = errClose
+		}
+	}
+	fs.share = nil
+	fs.session = nil
+	fs.conn = nil
+	return err
+}
@@ -0,0 +1,126 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// metadata key used to store the target of an emulated symlink. Only lowercase
+	// letters are used so that the key is valid for all the supported object storages
+	symlinkTargetMetadataKey = "sftpgosymlinktarget"
+	maxSymlinksToFollow      = 10
+)
+
+var errTooManySymlinks = errors.New("too many levels of symbolic links")
+
+// newSymlinkFileInfo returns the file info for an emulated symlink, the size is
+// the length of the target, as for symlinks on local filesystems
+func newSymlinkFileInfo(name, target string, modTime time.Time) *FileInfo {
+	info := NewFileInfo(name, false, int64(len(target)), modTime, false)
+	info.SetMode(os.ModeSymlink | 0777)
+	info.symlinkTarget = target
+	return info
+}
+
+// getSymlinkTarget returns the target for the specified file info if it
+// describes an emulated symlink, an empty string otherwise
+func getSymlinkTarget(info os.FileInfo) string {
+	if fi, ok := info.(*FileInfo); ok && fi.Mode()&os.ModeSymlink != 0 {
+		return fi.symlinkTarget
+	}
+	return ""
+}
+
+// getSymlinkTargetFromMetadata returns the target stored inside the metadata of a
+// symlink marker object. The second return value is false if the metadata don't
+// belong to a symlink marker
+func getSymlinkTargetFromMetadata(metadata map[string]string) (string, bool) {
+	for k, v := range metadata {
+		if strings.EqualFold(k, symlinkTargetMetadataKey) && v != "" {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// getSymlinkMarkerTarget returns the target to store inside a symlink marker for
+// the specified link source object name. Targets are stored as absolute paths
+// relative to the key prefix, so they are still valid if the filesystem is
+// mounted on a different virtual path
+func getSymlinkMarkerTarget(source, keyPrefix string) string {
+	return path.Clean("/" + strings.TrimPrefix(source, keyPrefix))
+}
+
+// getSymlinkTargetObjectName returns the object name for the specified symlink target
+func getSymlinkTargetObjectName(target, keyPrefix string) string {
+	return strings.TrimPrefix(path.Join(keyPrefix, path.Clean("/"+target)), "/")
+}
+
+// readEmulatedSymlink returns the target, as absolute virtual path, for the
+// emulated symlink described by the specified file info
+func readEmulatedSymlink(name string, info os.FileInfo, mountPath string) (string, error) {
+	target := getSymlinkTarget(info)
+	if target == "" {
+		return "", fmt.Errorf("%q is not a symlink: %w", name, os.ErrInvalid)
+	}
+	return path.Join("/", mountPath, path.Clean("/"+target)), nil
+}
+
+// resolveEmulatedSymlinks follows the emulated symlinks, if any, starting from the
+// specified object name. It returns the name of the resolved object and its file info
+func resolveEmulatedSymlinks(fs Fs, name, keyPrefix string) (string, os.FileInfo, error) {
+	for i := 0; i <= maxSymlinksToFollow; i++ {
+		info, err := fs.Lstat(name)
+		if err != nil {
+			return name, nil, err
+		}
+		target := getSymlinkTarget(info)
+		if target == "" {
+			return name, info, nil
+		}
+		name = getSymlinkTargetObjectName(target, keyPrefix)
+	}
+	return name, nil, errTooManySymlinks
+}
+
+// statEmulatedSymlinks returns the file info for the specified object name following
+// the emulated symlinks, if any. The returned file info has the name of the link
+func statEmulatedSymlinks(fs Fs, name, keyPrefix string) (os.FileInfo, error) {
+	resolved, info, err := resolveEmulatedSymlinks(fs, name, keyPrefix)
+	if err != nil || resolved == name {
+		return info, err
+	}
+	return NewFileInfo(name, info.IsDir(), info.Size(), info.ModTime(), false), nil
+}
+
+// getDirToList returns the object name to list for the specified directory,
+// following the emulated symlinks, if enabled
+func getDirToList(fs Fs, dirname, keyPrefix string, emulateSymlinks bool) (string, error) {
+	if !emulateSymlinks {
+		return dirname, nil
+	}
+	resolved, _, err := resolveEmulatedSymlinks(fs, dirname, keyPrefix)
+	if err != nil && !fs.IsNotExist(err) {
+		return dirname, err
+	}
+	return resolved, nil
+}
@@ -0,0 +1,274 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nos3
+// +build !nos3
+
+package vfs
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+func getTestS3FsWithSymlinks(t *testing.T, endpoint, keyPrefix, mountPath string) *S3Fs {
+	fs, err := NewS3Fs("connID", t.TempDir(), mountPath, S3FsConfig{
+		BaseS3FsConfig: sdk.BaseS3FsConfig{
+			Bucket:         testS3Bucket,
+			Region:         "us-east-1",
+			AccessKey:      "access_key",
+			Endpoint:       endpoint,
+			ForcePathStyle: true,
+			KeyPrefix:      keyPrefix,
+		},
+		AccessSecret:    kms.NewPlainSecret("access_secret"),
+		EmulateSymlinks: true,
+	})
+	require.NoError(t, err)
+	s3Fs, ok := fs.(*S3Fs)
+	require.True(t, ok)
+	return s3Fs
+}
+
+func readTestFile(t *testing.T, fs Fs, name string) ([]byte, error) {
+	_, r, cancelFn, err := fs.Open(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer cancelFn()
+	defer r.Close()
+
+	return io.ReadAll(io.NewSectionReader(r, 0, 1024*1024))
+}
+
+func TestSymlinkHelpers(t *testing.T) {
+	info := newSymlinkFileInfo("link", "/dir/file", time.Now())
+	assert.Equal(t, os.ModeSymlink, info.Mode()&os.ModeSymlink)
+	assert.Equal(t, int64(len("/dir/file")), info.Size())
+	assert.Equal(t, "/dir/file", getSymlinkTarget(info))
+	assert.Empty(t, getSymlinkTarget(NewFileInfo("file", false, 10, time.Now(), false)))
+
+	target, ok := getSymlinkTargetFromMetadata(map[string]string{"SftpgoSymlinkTarget": "/file"})
+	assert.True(t, ok)
+	assert.Equal(t, "/file", target)
+	_, ok = getSymlinkTargetFromMetadata(map[string]string{symlinkTargetMetadataKey: ""})
+	assert.False(t, ok)
+	_, ok = getSymlinkTargetFromMetadata(nil)
+	assert.False(t, ok)
+
+	assert.Equal(t, "/dir/file", getSymlinkMarkerTarget("home/user/dir/file", "home/user/"))
+	assert.Equal(t, "/dir/file", getSymlinkMarkerTarget("dir/file", ""))
+	assert.Equal(t, "home/user/dir/file", getSymlinkTargetObjectName("/dir/file", "home/user/"))
+	assert.Equal(t, "dir/file", getSymlinkTargetObjectName("dir/file", ""))
+	// the targets cannot escape the key prefix
+	assert.Equal(t, "home/user/etc/passwd", getSymlinkTargetObjectName("../../etc/passwd", "home/user/"))
+	assert.Equal(t, "home/user/etc/passwd", getSymlinkTargetObjectName("/dir/../../../etc/passwd", "home/user/"))
+	assert.Equal(t, "etc/passwd", getSymlinkTargetObjectName("../etc/passwd", ""))
+
+	_, err := readEmulatedSymlink("file", NewFileInfo("file", false, 10, time.Now(), false), "")
+	assert.ErrorIs(t, err, os.ErrInvalid)
+	linkTarget, err := readEmulatedSymlink("link", info, "/vdir")
+	assert.NoError(t, err)
+	assert.Equal(t, "/vdir/dir/file", linkTarget)
+	linkTarget, err = readEmulatedSymlink("link", newSymlinkFileInfo("link", "../../file", time.Now()), "/vdir")
+	assert.NoError(t, err)
+	assert.Equal(t, "/vdir/file", linkTarget)
+}
+
+func TestS3SymlinkCreateAndResolve(t *testing.T) {
+	server := newFakeS3Server(t)
+	server.addObject("home/user/dir/file", []byte("file content"), nil)
+	fs := getTestS3FsWithSymlinks(t, server.server.URL, "home/user/", "")
+
+	err := fs.Symlink("home/user/dir/file", "home/user/link")
+	require.NoError(t, err)
+	marker, ok := server.getObject("home/user/link")
+	require.True(t, ok)
+	assert.Len(t, marker.data, 0)
+	assert.Equal(t, map[string]string{symlinkTargetMetadataKey: "/dir/file"}, marker.metadata)
+	// the link already exists
+	err = fs.Symlink("home/user/dir/file", "home/user/link")
+	assert.ErrorIs(t, err, os.ErrExist)
+	err = fs.Symlink("home/user/link", "home/user/dir/file")
+	assert.ErrorIs(t, err, os.ErrExist)
+
+	info, err := fs.Lstat("home/user/link")
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSymlink, info.Mode()&os.ModeSymlink)
+	target, err := fs.Readlink("home/user/link")
+	assert.NoError(t, err)
+	assert.Equal(t, "/dir/file", target)
+	_, err = fs.Readlink("home/user/dir/file")
+	assert.ErrorIs(t, err, os.ErrInvalid)
+	_, err = fs.Readlink("home/user/missing")
+	assert.True(t, fs.IsNotExist(err))
+	// Stat follows the link and keeps the link name
+	info, err = fs.Stat("home/user/link")
+	require.NoError(t, err)
+	assert.Equal(t, "link", info.Name())
+	assert.Equal(t, int64(len("file content")), info.Size())
+	assert.False(t, info.IsDir())
+	assert.Equal(t, os.FileMode(0), info.Mode()&os.ModeSymlink)
+	content, err := readTestFile(t, fs, "home/user/link")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("file content"), content)
+	// link to link
+	err = fs.Symlink("home/user/link", "home/user/link2")
+	require.NoError(t, err)
+	target, err = fs.Readlink("home/user/link2")
+	assert.NoError(t, err)
+	assert.Equal(t, "/link", target)
+	content, err = readTestFile(t, fs, "home/user/link2")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("file content"), content)
+	// link to a directory
+	err = fs.Symlink("home/user/dir", "home/user/dirlink")
+	require.NoError(t, err)
+	info, err = fs.Stat("home/user/dirlink")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	entries, err := fs.ReadDir("home/user/dirlink")
+	require.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "file", entries[0].Name())
+	}
+	// the links are listed as symlinks
+	entries, err = fs.ReadDir("home/user")
+	require.NoError(t, err)
+	links := make(map[string]string)
+	for _, entry := range entries {
+		if entry.Mode()&os.ModeSymlink != 0 {
+			links[entry.Name()] = getSymlinkTarget(entry)
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"link":    "/dir/file",
+		"link2":   "/link",
+		"dirlink": "/dir",
+	}, links)
+	// a dangling link
+	err = fs.Symlink("home/user/missing", "home/user/dangling")
+	require.NoError(t, err)
+	_, err = fs.Lstat("home/user/dangling")
+	assert.NoError(t, err)
+	_, err = fs.Stat("home/user/dangling")
+	assert.True(t, fs.IsNotExist(err))
+	_, err = readTestFile(t, fs, "home/user/dangling")
+	assert.True(t, fs.IsNotExist(err))
+	// a regular empty object is not a symlink
+	server.addObject("home/user/empty", nil, map[string]string{"other": "value"})
+	info, err = fs.Lstat("home/user/empty")
+	require.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+
+	fs.config.EmulateSymlinks = false
+	err = fs.Symlink("home/user/dir/file", "home/user/link3")
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+	_, err = fs.Readlink("home/user/link")
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+	// the marker is a regular empty file if the emulation is disabled
+	info, err = fs.Stat("home/user/link")
+	require.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+	assert.Equal(t, int64(0), info.Size())
+}
+
+func TestS3SymlinkLoop(t *testing.T) {
+	server := newFakeS3Server(t)
+	fs := getTestS3FsWithSymlinks(t, server.server.URL, "", "")
+
+	err := fs.Symlink("b", "a")
+	require.NoError(t, err)
+	err = fs.Symlink("a", "b")
+	require.NoError(t, err)
+	err = fs.Symlink("self", "self")
+	require.NoError(t, err)
+
+	for _, name := range []string{"a", "b", "self"} {
+		_, err = fs.Lstat(name)
+		assert.NoError(t, err)
+		_, err = fs.Stat(name)
+		assert.ErrorIs(t, err, errTooManySymlinks)
+		_, err = readTestFile(t, fs, name)
+		assert.ErrorIs(t, err, errTooManySymlinks)
+		_, err = fs.ReadDir(name)
+		assert.ErrorIs(t, err, errTooManySymlinks)
+	}
+	err = fs.CopyFile("a", "copy")
+	assert.ErrorIs(t, err, errTooManySymlinks)
+	// a long chain within the limit is resolved
+	server.addObject("file", []byte("data"), nil)
+	previous := "file"
+	for i := 0; i < maxSymlinksToFollow; i++ {
+		name := "chain" + string(rune('a'+i))
+		err = fs.Symlink(previous, name)
+		require.NoError(t, err)
+		previous = name
+	}
+	content, err := readTestFile(t, fs, previous)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), content)
+	// one more link exceeds the limit
+	err = fs.Symlink(previous, "toolong")
+	require.NoError(t, err)
+	_, err = fs.Stat("toolong")
+	assert.ErrorIs(t, err, errTooManySymlinks)
+}
+
+func TestS3SymlinkOutsideHomeDir(t *testing.T) {
+	server := newFakeS3Server(t)
+	server.addObject("other/secret", []byte("secret"), nil)
+	server.addObject("home/user/file", []byte("user file"), nil)
+	fs := getTestS3FsWithSymlinks(t, server.server.URL, "home/user/", "/vdir")
+	// a marker, not created by SFTPGo, with a target outside the key prefix
+	server.addObject("home/user/escape", nil, map[string]string{
+		symlinkTargetMetadataKey: "../../other/secret",
+	})
+	target, err := fs.Readlink("home/user/escape")
+	assert.NoError(t, err)
+	assert.Equal(t, "/vdir/other/secret", target)
+	// the target is resolved inside the key prefix
+	_, err = fs.Stat("home/user/escape")
+	assert.True(t, fs.IsNotExist(err))
+	_, err = readTestFile(t, fs, "home/user/escape")
+	assert.True(t, fs.IsNotExist(err))
+	server.addObject("home/user/other/secret", []byte("user secret"), nil)
+	content, err := readTestFile(t, fs, "home/user/escape")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("user secret"), content)
+	// absolute targets are relative to the key prefix too
+	server.addObject("home/user/absolute", nil, map[string]string{
+		symlinkTargetMetadataKey: "/file",
+	})
+	content, err = readTestFile(t, fs, "home/user/absolute")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("user file"), content)
+	// a link created for a source outside the key prefix is confined as well
+	err = fs.Symlink("other/secret", "home/user/outside")
+	require.NoError(t, err)
+	marker, ok := server.getObject("home/user/outside")
+	require.True(t, ok)
+	assert.Equal(t, "/other/secret", marker.metadata[symlinkTargetMetadataKey])
+	content, err = readTestFile(t, fs, "home/user/outside")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("user secret"), content)
+}
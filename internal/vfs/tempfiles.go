@@ -0,0 +1,183 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	atomicUploadPrefix = ".sftpgo-upload."
+	// prefix used by pipeat for the temporary files backing the pipes, on
+	// Windows these files cannot be unlinked while open, so they can leak
+	pipeFilePrefix = "pipefile"
+)
+
+// TempFilesCleanupResult defines the result of a stale temporary files cleanup
+type TempFilesCleanupResult struct {
+	// number of removed files or aborted uploads
+	Files int
+	// size of the removed files as bytes, the size of aborted multipart
+	// uploads is unknown and it is not included
+	Size int64
+}
+
+// Add adds the specified result to this one
+func (r *TempFilesCleanupResult) Add(other TempFilesCleanupResult) {
+	r.Files += other.Files
+	r.Size += other.Size
+}
+
+// TempFilesCleaner is implemented by the filesystems that can detect and remove
+// stale temporary artifacts, for example the leftovers of interrupted atomic uploads
+type TempFilesCleaner interface {
+	RemoveStaleTempFiles(maxAge time.Duration) (TempFilesCleanupResult, error)
+}
+
+// IsAtomicUploadTempFile returns true if the specified file name is a temporary
+// file used for atomic uploads
+func IsAtomicUploadTempFile(name string) bool {
+	return strings.HasPrefix(name, atomicUploadPrefix)
+}
+
+// RemoveStaleLocalTempFiles removes the atomic uploads and pipe files, older than
+// maxAge, within the specified local directory. Subdirectories are not scanned.
+// The files that cannot be removed are skipped and the last error is returned
+func RemoveStaleLocalTempFiles(dir string, maxAge time.Duration) (TempFilesCleanupResult, error) {
+	var result TempFilesCleanupResult
+	var lastErr error
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return result, err
+	}
+	cutoff := time.Now().Add(-maxAge)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if !IsAtomicUploadTempFile(name) && !strings.HasPrefix(name, pipeFilePrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			lastErr = err
+			continue
+		}
+		result.Files++
+		result.Size += info.Size()
+	}
+	return result, lastErr
+}
+
+// RemoveStaleTempFiles removes the atomic uploads leftovers, older than maxAge,
+// within the root directory
+func (fs *OsFs) RemoveStaleTempFiles(maxAge time.Duration) (TempFilesCleanupResult, error) {
+	return removeStaleAtomicUploads(fs, fs.rootDir, maxAge)
+}
+
+// RemoveStaleTempFiles removes the atomic uploads leftovers, older than maxAge,
+// within the configured prefix
+func (fs *SFTPFs) RemoveStaleTempFiles(maxAge time.Duration) (TempFilesCleanupResult, error) {
+	return removeStaleAtomicUploads(fs, fs.config.Prefix, maxAge)
+}
+
+// RemoveStaleTempFiles aborts the multipart uploads, within the configured key prefix,
+// initiated more than maxAge ago. S3 charges for the parts of the incomplete uploads
+// and they are not visible listing the bucket contents
+func (fs *S3Fs) RemoveStaleTempFiles(maxAge time.Duration) (TempFilesCleanupResult, error) {
+	var result TempFilesCleanupResult
+
+	cutoff := time.Now().Add(-maxAge)
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(fs.config.Bucket),
+		Prefix: aws.String(fs.config.KeyPrefix),
+	}
+	for {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		output, err := fs.svc.ListMultipartUploads(ctx, input)
+		cancelFn()
+		if err != nil {
+			return result, err
+		}
+		for _, upload := range output.Uploads {
+			if upload.Initiated == nil || upload.Initiated.After(cutoff) {
+				continue
+			}
+			ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+			_, err := fs.svc.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(fs.config.Bucket),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			})
+			cancelFn()
+			if err != nil {
+				fsLog(fs, logger.LevelWarn, "unable to abort stale multipart upload for key %q: %v",
+					aws.ToString(upload.Key), err)
+				continue
+			}
+			fsLog(fs, logger.LevelDebug, "stale multipart upload for key %q, initiated at %v, aborted",
+				aws.ToString(upload.Key), upload.Initiated)
+			result.Files++
+		}
+		if !output.IsTruncated {
+			return result, nil
+		}
+		input.KeyMarker = output.NextKeyMarker
+		input.UploadIdMarker = output.NextUploadIdMarker
+	}
+}
+
+func removeStaleAtomicUploads(fs Fs, root string, maxAge time.Duration) (TempFilesCleanupResult, error) {
+	var result TempFilesCleanupResult
+
+	cutoff := time.Now().Add(-maxAge)
+	err := fs.Walk(root, func(walkedPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if fs.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() || !IsAtomicUploadTempFile(info.Name()) || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err := fs.Remove(walkedPath, false); err != nil {
+			fsLog(fs, logger.LevelWarn, "unable to remove stale atomic upload %q: %v", walkedPath, err)
+			return nil
+		}
+		fsLog(fs, logger.LevelDebug, "stale atomic upload %q removed, modification time: %v", walkedPath, info.ModTime())
+		result.Files++
+		result.Size += info.Size()
+		return nil
+	})
+	if err != nil && fs.IsNotExist(err) {
+		err = nil
+	}
+	return result, err
+}
@@ -16,15 +16,20 @@
 package vfs
 
 import (
+	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/eikenb/pipeat"
@@ -35,6 +40,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/resolver"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
@@ -45,10 +51,16 @@ var (
 	// ErrStorageSizeUnavailable is returned if the storage backend does not support getting the size
 	ErrStorageSizeUnavailable = errors.New("unable to get available size for this storage backend")
 	// ErrVfsUnsupported defines the error for an unsupported VFS operation
-	ErrVfsUnsupported    = errors.New("not supported")
-	tempPath             string
-	sftpFingerprints     []string
-	allowSelfConnections int
+	ErrVfsUnsupported = errors.New("not supported")
+	// ErrObjectArchived is returned if an object is stored in an archive tier and
+	// must be restored before it can be downloaded
+	ErrObjectArchived     = errors.New("the object is archived and must be restored before it can be downloaded")
+	tempPath              string
+	sftpFingerprints      []string
+	sftpFingerprintsMu    sync.RWMutex
+	allowSelfConnections  int
+	resolverTransport     *http.Transport
+	resolverTransportOnce sync.Once
 )
 
 // SetAllowSelfConnections sets the desired behaviour for self connections
@@ -68,9 +80,19 @@ func GetTempPath() string {
 
 // SetSFTPFingerprints sets the SFTP host key fingerprints
 func SetSFTPFingerprints(fp []string) {
+	sftpFingerprintsMu.Lock()
+	defer sftpFingerprintsMu.Unlock()
+
 	sftpFingerprints = fp
 }
 
+func isSFTPFingerprint(fp string) bool {
+	sftpFingerprintsMu.RLock()
+	defer sftpFingerprintsMu.RUnlock()
+
+	return util.Contains(sftpFingerprints, fp)
+}
+
 // Fs defines the interface for filesystem backends
 type Fs interface {
 	Name() string
@@ -115,6 +137,112 @@ type FsRealPather interface {
 	RealPath(p string) (string, error)
 }
 
+// FsArchiveRestorer is a Fs that supports archive storage tiers and allows
+// to restore archived objects
+type FsArchiveRestorer interface {
+	Fs
+	GetArchiveStatus(name string) (ArchiveStatus, error)
+	RestoreArchivedObject(name string, days int) error
+}
+
+// FsStorageClassTransitioner is a Fs that allows to move objects between
+// storage classes/access tiers
+type FsStorageClassTransitioner interface {
+	Fs
+	// GetStorageProvider returns the filesystem provider
+	GetStorageProvider() sdk.FilesystemProvider
+	// GetStorageClass returns the storage class/access tier for the specified object
+	GetStorageClass(name string) (string, error)
+	// SetStorageClass moves the specified object to the specified storage class/access tier
+	SetStorageClass(name, storageClass string) error
+}
+
+// FsFileCopier is a Fs that allows to copy files without reading and writing
+// them through SFTPGo, for example using a server side copy
+type FsFileCopier interface {
+	Fs
+	// CopyFile copies the source file to the target path, overwriting it if
+	// it exists. Directories are not supported
+	CopyFile(source, target string) error
+}
+
+// FsSizedCreator is a Fs that can tune the uploads, for example the multipart
+// part size, if the file size is known before the upload starts
+type FsSizedCreator interface {
+	Fs
+	// CreateWithSize is like Create, size is the expected file size
+	CreateWithSize(name string, flag int, size int64) (File, *PipeWriter, func(), error)
+}
+
+// Supported checksum algorithms for the checksums recorded by the storage backends
+const (
+	ChecksumAlgoMD5    = "md5"
+	ChecksumAlgoCRC32C = "crc32c"
+)
+
+// Checksum defines a checksum recorded by a storage backend for an object
+type Checksum struct {
+	// Algorithm is one of the supported checksum algorithms
+	Algorithm string
+	// Value is the hex encoded checksum
+	Value string
+}
+
+// IsEmpty returns true if no checksum is recorded
+func (c *Checksum) IsEmpty() bool {
+	return c.Algorithm == "" || c.Value == ""
+}
+
+// FsChecksumGetter is a Fs that records a checksum for the stored objects,
+// for example the S3 ETag or the MD5 hash for Google Cloud Storage
+type FsChecksumGetter interface {
+	Fs
+	// GetRecordedChecksum returns the checksum stored by the backend for the
+	// specified object. An empty checksum means that no usable checksum is recorded.
+	// ErrObjectArchived is returned for archived objects that cannot be read
+	GetRecordedChecksum(name string) (Checksum, error)
+}
+
+// ArchiveStatus defines the archive status for an object
+type ArchiveStatus struct {
+	// storage class/access tier as reported by the storage backend
+	StorageClass string `json:"storage_class,omitempty"`
+	// true if the object is stored in an archive tier
+	Archived bool `json:"archived"`
+	// true if a restore request is in progress
+	Restoring bool `json:"restoring"`
+	// true if the object can be downloaded
+	Available bool `json:"available"`
+	// expiration for the restored copy as unix timestamp in milliseconds,
+	// 0 means no expiration or not applicable
+	RestoreExpiresAt int64 `json:"restore_expires_at,omitempty"`
+}
+
+// FsVersioner is a Fs that supports object versioning, versioning must be
+// enabled on the bucket/container
+type FsVersioner interface {
+	Fs
+	// ListVersions returns the versions for the specified object, newest first
+	ListVersions(name string) ([]FileVersion, error)
+	// OpenVersion opens the specified object version for reading
+	OpenVersion(name, versionID string, offset int64) (File, *pipeat.PipeReaderAt, func(), error)
+	// RestoreVersion makes the specified version the current one by copying
+	// it in place
+	RestoreVersion(name, versionID string) error
+}
+
+// FileVersion defines a version for an object
+type FileVersion struct {
+	// version identifier as reported by the storage backend
+	ID string `json:"id"`
+	// size in bytes
+	Size int64 `json:"size"`
+	// last modification as unix timestamp in milliseconds
+	ModTime int64 `json:"mod_time"`
+	// true if this is the current version
+	IsLatest bool `json:"is_latest"`
+}
+
 // fsMetadataChecker is a Fs that implements the getFileNamesInPrefix method.
 // This interface is used to abstract metadata consistency checks
 type fsMetadataChecker interface {
@@ -166,6 +294,9 @@ func (q *QuotaCheckResult) GetRemainingFiles() int {
 type S3FsConfig struct {
 	sdk.BaseS3FsConfig
 	AccessSecret *kms.Secret `json:"access_secret,omitempty"`
+	// EmulateSymlinks enables symbolic links emulation using zero bytes
+	// marker objects that store the link target as metadata
+	EmulateSymlinks bool `json:"emulate_symlinks,omitempty"`
 }
 
 // HideConfidentialData hides confidential data
@@ -207,6 +338,9 @@ func (c *S3FsConfig) isEqual(other S3FsConfig) bool {
 	if c.ForcePathStyle != other.ForcePathStyle {
 		return false
 	}
+	if c.EmulateSymlinks != other.EmulateSymlinks {
+		return false
+	}
 	return c.isSecretEqual(other)
 }
 
@@ -333,6 +467,16 @@ func (c *S3FsConfig) validate() error {
 type GCSFsConfig struct {
 	sdk.BaseGCSFsConfig
 	Credentials *kms.Secret `json:"credentials,omitempty"`
+	// Customer-supplied encryption key (CSEK), base64 encoded AES-256 key.
+	// The key is stored encrypted based on the kms configuration
+	EncryptionKey *kms.Secret `json:"encryption_key,omitempty"`
+	// Cloud KMS key name (CMEK) to use for new objects, for example
+	// projects/my-project/locations/global/keyRings/my-kr/cryptoKeys/my-key.
+	// It cannot be used together with a customer-supplied encryption key
+	KMSKeyName string `json:"kms_key_name,omitempty"`
+	// EmulateSymlinks enables symbolic links emulation using zero bytes
+	// marker objects that store the link target as metadata
+	EmulateSymlinks bool `json:"emulate_symlinks,omitempty"`
 }
 
 // HideConfidentialData hides confidential data
@@ -340,6 +484,9 @@ func (c *GCSFsConfig) HideConfidentialData() {
 	if c.Credentials != nil {
 		c.Credentials.Hide()
 	}
+	if c.EncryptionKey != nil {
+		c.EncryptionKey.Hide()
+	}
 }
 
 // ValidateAndEncryptCredentials validates the configuration and encrypts credentials if they are in plain text
@@ -354,6 +501,12 @@ func (c *GCSFsConfig) ValidateAndEncryptCredentials(additionalData string) error
 			return util.NewValidationError(fmt.Sprintf("could not encrypt GCS credentials: %v", err))
 		}
 	}
+	if c.EncryptionKey.IsPlain() {
+		c.EncryptionKey.SetAdditionalData(additionalData)
+		if err := c.EncryptionKey.Encrypt(); err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt GCS encryption key: %v", err))
+		}
+	}
 	return nil
 }
 
@@ -373,13 +526,28 @@ func (c *GCSFsConfig) isEqual(other GCSFsConfig) bool {
 	if c.ACL != other.ACL {
 		return false
 	}
+	if c.KMSKeyName != other.KMSKeyName {
+		return false
+	}
+	if c.EmulateSymlinks != other.EmulateSymlinks {
+		return false
+	}
 	if c.Credentials == nil {
 		c.Credentials = kms.NewEmptySecret()
 	}
 	if other.Credentials == nil {
 		other.Credentials = kms.NewEmptySecret()
 	}
-	return c.Credentials.IsEqual(other.Credentials)
+	if c.EncryptionKey == nil {
+		c.EncryptionKey = kms.NewEmptySecret()
+	}
+	if other.EncryptionKey == nil {
+		other.EncryptionKey = kms.NewEmptySecret()
+	}
+	if !c.Credentials.IsEqual(other.Credentials) {
+		return false
+	}
+	return c.EncryptionKey.IsEqual(other.EncryptionKey)
 }
 
 func (c *GCSFsConfig) isSameResource(other GCSFsConfig) bool {
@@ -391,6 +559,9 @@ func (c *GCSFsConfig) validate() error {
 	if c.Credentials == nil || c.AutomaticCredentials == 1 {
 		c.Credentials = kms.NewEmptySecret()
 	}
+	if c.EncryptionKey == nil {
+		c.EncryptionKey = kms.NewEmptySecret()
+	}
 	if c.Bucket == "" {
 		return errors.New("bucket cannot be empty")
 	}
@@ -411,6 +582,31 @@ func (c *GCSFsConfig) validate() error {
 	}
 	c.StorageClass = strings.TrimSpace(c.StorageClass)
 	c.ACL = strings.TrimSpace(c.ACL)
+	return c.checkEncryption()
+}
+
+func (c *GCSFsConfig) checkEncryption() error {
+	c.KMSKeyName = strings.TrimSpace(c.KMSKeyName)
+	if c.EncryptionKey.IsEncrypted() && !c.EncryptionKey.IsValid() {
+		return errors.New("invalid encrypted encryption key")
+	}
+	if c.EncryptionKey.IsPlain() {
+		key, err := base64.StdEncoding.DecodeString(c.EncryptionKey.GetPayload())
+		if err != nil {
+			return fmt.Errorf("invalid encryption key, it must be base64 encoded: %w", err)
+		}
+		if len(key) != 32 {
+			return fmt.Errorf("invalid encryption key, a 256 bit key is required, got %d bits", len(key)*8)
+		}
+	}
+	if c.KMSKeyName != "" {
+		if !c.EncryptionKey.IsEmpty() {
+			return errors.New("encryption key and KMS key name are mutually exclusive")
+		}
+		if !strings.HasPrefix(c.KMSKeyName, "projects/") || !strings.Contains(c.KMSKeyName, "/cryptoKeys/") {
+			return fmt.Errorf("invalid KMS key name %q", c.KMSKeyName)
+		}
+	}
 	return nil
 }
 
@@ -422,6 +618,59 @@ type AzBlobFsConfig struct {
 	AccountKey *kms.Secret `json:"account_key,omitempty"`
 	// Shared access signature URL, leave blank if using account/key
 	SASURL *kms.Secret `json:"sas_url,omitempty"`
+	// Rules to select the access tier for new uploads. The first matching rule
+	// wins, if no rule matches the configured access tier, if any, is used
+	TieringRules []AzBlobTieringRule `json:"tiering_rules,omitempty"`
+	// EmulateSymlinks enables symbolic links emulation using zero bytes
+	// marker objects that store the link target as metadata
+	EmulateSymlinks bool `json:"emulate_symlinks,omitempty"`
+}
+
+// AzBlobTieringRule defines the access tier to use for uploaded files
+// matching the specified path pattern and/or minimum size
+type AzBlobTieringRule struct {
+	// Shell pattern to match, for example "*.bak" or "/archive/*". Patterns without a
+	// "/" are matched against the file name, the other ones against the full path
+	// relative to the filesystem root. Empty means any path
+	PathPattern string `json:"path_pattern,omitempty"`
+	// Minimum file size, in bytes. 0 means any size
+	MinSize int64 `json:"min_size,omitempty"`
+	// Access tier to set
+	AccessTier string `json:"access_tier"`
+}
+
+func (r *AzBlobTieringRule) validate() error {
+	r.PathPattern = strings.TrimSpace(r.PathPattern)
+	r.AccessTier = strings.TrimSpace(r.AccessTier)
+	if r.AccessTier == "" || !util.Contains(validAzAccessTier, r.AccessTier) {
+		return fmt.Errorf("invalid tiering rule access tier %q", r.AccessTier)
+	}
+	if r.MinSize < 0 {
+		return fmt.Errorf("invalid tiering rule min size: %d", r.MinSize)
+	}
+	if r.PathPattern == "" && r.MinSize == 0 {
+		return errors.New("a tiering rule requires a path pattern and/or a min size")
+	}
+	if r.PathPattern != "" {
+		if _, err := path.Match(r.PathPattern, "/"); err != nil {
+			return fmt.Errorf("invalid tiering rule path pattern %q: %w", r.PathPattern, err)
+		}
+	}
+	return nil
+}
+
+func (r *AzBlobTieringRule) matches(name string, size int64) bool {
+	if size < r.MinSize {
+		return false
+	}
+	if r.PathPattern == "" {
+		return true
+	}
+	if !strings.Contains(r.PathPattern, "/") {
+		name = path.Base(name)
+	}
+	matched, _ := path.Match(r.PathPattern, name)
+	return matched
 }
 
 // HideConfidentialData hides confidential data
@@ -474,6 +723,17 @@ func (c *AzBlobFsConfig) isEqual(other AzBlobFsConfig) bool {
 	if c.AccessTier != other.AccessTier {
 		return false
 	}
+	if c.EmulateSymlinks != other.EmulateSymlinks {
+		return false
+	}
+	if len(c.TieringRules) != len(other.TieringRules) {
+		return false
+	}
+	for idx := range c.TieringRules {
+		if c.TieringRules[idx] != other.TieringRules[idx] {
+			return false
+		}
+	}
 	return c.isSecretEqual(other)
 }
 
@@ -593,6 +853,18 @@ func (c *AzBlobFsConfig) validate() error {
 	if !util.Contains(validAzAccessTier, c.AccessTier) {
 		return fmt.Errorf("invalid access tier %#v, valid values: \"''%v\"", c.AccessTier, strings.Join(validAzAccessTier, ", "))
 	}
+	return c.checkTieringRules()
+}
+
+func (c *AzBlobFsConfig) checkTieringRules() error {
+	if len(c.TieringRules) > 20 {
+		return fmt.Errorf("too many tiering rules: %d, max allowed: 20", len(c.TieringRules))
+	}
+	for idx := range c.TieringRules {
+		if err := c.TieringRules[idx].validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -713,6 +985,29 @@ func IsCryptOsFs(fs Fs) bool {
 	return fs.Name() == cryptFsName
 }
 
+// IsCompressedOsFs returns true if fs is a compressed local filesystem implementation
+func IsCompressedOsFs(fs Fs) bool {
+	return fs.Name() == compressedFsName
+}
+
+// IsDedupFs returns true if fs is a deduplicated local filesystem implementation
+func IsDedupFs(fs Fs) bool {
+	return fs.Name() == dedupFsName
+}
+
+// ConvertFileInfo returns a FileInfo with the size of the file contents for the
+// Fs implementations where Stat returns the size to use for quota tracking
+func ConvertFileInfo(fs Fs, info os.FileInfo) os.FileInfo {
+	switch f := fs.(type) {
+	case *CryptFs:
+		return f.ConvertFileInfo(info)
+	case *DedupFs:
+		return f.ConvertFileInfo(info)
+	default:
+		return info
+	}
+}
+
 // IsSFTPFs returns true if fs is an SFTP filesystem
 func IsSFTPFs(fs Fs) bool {
 	return strings.HasPrefix(fs.Name(), sftpFsName)
@@ -723,6 +1018,16 @@ func IsHTTPFs(fs Fs) bool {
 	return strings.HasPrefix(fs.Name(), httpFsName)
 }
 
+// IsSMBFs returns true if fs is an SMB filesystem
+func IsSMBFs(fs Fs) bool {
+	return strings.HasPrefix(fs.Name(), smbFsName)
+}
+
+// IsWebDAVFs returns true if fs is a WebDAV filesystem
+func IsWebDAVFs(fs Fs) bool {
+	return strings.HasPrefix(fs.Name(), webDAVFsName)
+}
+
 // IsBufferedSFTPFs returns true if this is a buffered SFTP filesystem
 func IsBufferedSFTPFs(fs Fs) bool {
 	if !IsSFTPFs(fs) {
@@ -749,7 +1054,7 @@ func IsLocalOrSFTPFs(fs Fs) bool {
 
 // HasTruncateSupport returns true if the fs supports truncate files
 func HasTruncateSupport(fs Fs) bool {
-	return IsLocalOsFs(fs) || IsSFTPFs(fs) || IsHTTPFs(fs)
+	return IsLocalOsFs(fs) || IsSFTPFs(fs) || IsHTTPFs(fs) || IsSMBFs(fs)
 }
 
 // HasImplicitAtomicUploads returns true if the fs don't persists partial files on error
@@ -775,12 +1080,12 @@ func HasOpenRWSupport(fs Fs) bool {
 	if IsSFTPFs(fs) && fs.IsUploadResumeSupported() {
 		return true
 	}
-	return false
+	return IsSMBFs(fs)
 }
 
-// IsLocalOrCryptoFs returns true if fs is local or local encrypted
+// IsLocalOrCryptoFs returns true if fs is local, local encrypted or local compressed
 func IsLocalOrCryptoFs(fs Fs) bool {
-	return IsLocalOsFs(fs) || IsCryptOsFs(fs)
+	return IsLocalOsFs(fs) || IsCryptOsFs(fs) || IsCompressedOsFs(fs)
 }
 
 // SetPathPermissions calls fs.Chown.
@@ -817,6 +1122,46 @@ func updateFileInfoModTime(storageID, objectPath string, info *FileInfo) (*FileI
 	return info, nil
 }
 
+// preserveModificationTime stores the specified modification time using the
+// metadata plugin, if any, unless a modification time is already stored for
+// the object. It is used after operations that rewrite objects in place
+func preserveModificationTime(storageID, objectPath string, modTime time.Time) error {
+	if !plugin.Handler.HasMetadater() {
+		return nil
+	}
+	_, err := plugin.Handler.GetModificationTime(storageID, ensureAbsPath(objectPath), false)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, metadata.ErrNoSuchObject) {
+		return err
+	}
+	return plugin.Handler.SetModificationTime(storageID, ensureAbsPath(objectPath), util.GetTimeAsMsSinceEpoch(modTime))
+}
+
+// sortFileVersions sorts the specified versions, newest first
+func sortFileVersions(versions []FileVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].IsLatest != versions[j].IsLatest {
+			return versions[i].IsLatest
+		}
+		return versions[i].ModTime > versions[j].ModTime
+	})
+}
+
+// updateRestoredVersionModTime updates the modification time stored using the
+// metadata plugin, if any, after restoring an object version
+func updateRestoredVersionModTime(fs Fs, storageID, objectPath string) {
+	if !plugin.Handler.HasMetadater() {
+		return
+	}
+	err := plugin.Handler.SetModificationTime(storageID, ensureAbsPath(objectPath), util.GetTimeAsMsSinceEpoch(time.Now()))
+	if err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to update modification time after restoring a version for %q: %+v",
+			objectPath, err)
+	}
+}
+
 func getFolderModTimes(storageID, dirName string) (map[string]int64, error) {
 	var err error
 	modTimes := make(map[string]int64)
@@ -905,3 +1250,19 @@ func getMountPath(mountPath string) string {
 func fsLog(fs Fs, level logger.LogLevel, format string, v ...any) {
 	logger.Log(level, fs.Name(), fs.ConnectionID(), format, v...)
 }
+
+// getResolverTransport returns a shared HTTP transport that resolves the host
+// names using the configured DNS settings. It is used for the storage backends
+// whose SDK uses its own HTTP client by default
+func getResolverTransport() *http.Transport {
+	resolverTransportOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = resolver.WrapDialContext(transport.DialContext)
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.MinVersion = tls.VersionTLS12
+		resolverTransport = transport
+	})
+	return resolverTransport
+}
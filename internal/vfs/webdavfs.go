@@ -0,0 +1,989 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/pkg/sftp"
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/resolver"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// webDAVFsName is the name for the WebDAV Fs implementation
+	webDAVFsName = "webdavfs"
+	// chunk size limits, as MB, for the Nextcloud chunked uploads
+	webDAVMinUploadChunkSize = 5
+	webDAVMaxUploadChunkSize = 5120
+	// paths for the Nextcloud files and chunked uploads collections
+	webDAVNextcloudFilesPath   = "/remote.php/dav/files/"
+	webDAVNextcloudUploadsPath = "/remote.php/dav/uploads/"
+	webDAVPropfindBody         = `<?xml version="1.0" encoding="utf-8"?><d:propfind xmlns:d="DAV:"><d:prop>` +
+		`<d:resourcetype/><d:getcontentlength/><d:getlastmodified/><d:getcontenttype/></d:prop></d:propfind>`
+	webDAVQuotaPropfindBody = `<?xml version="1.0" encoding="utf-8"?><d:propfind xmlns:d="DAV:"><d:prop>` +
+		`<d:quota-available-bytes/><d:quota-used-bytes/></d:prop></d:propfind>`
+)
+
+// WebDAVFsConfig defines the configuration for WebDAV based filesystem
+type WebDAVFsConfig struct {
+	// Endpoint is the URL of the WebDAV collection to use as root, for example
+	// https://cloud.example.com/remote.php/dav/files/<username> for Nextcloud
+	Endpoint string      `json:"endpoint,omitempty"`
+	Username string      `json:"username,omitempty"`
+	Password *kms.Secret `json:"password,omitempty"`
+	// Prefix is the path, inside the endpoint collection, to use as root. Similar to a chroot
+	Prefix string `json:"prefix,omitempty"`
+	// SkipTLSVerify disables the server certificate verification
+	SkipTLSVerify bool `json:"skip_tls_verify,omitempty"`
+	// PEM encoded certificate authorities to trust in addition to the system ones
+	CACertificates string `json:"ca_certificates,omitempty"`
+	// UploadChunkSize defines, in MB, the chunk size for the chunked uploads.
+	// 0 means disabled, files are uploaded using a single PUT request
+	UploadChunkSize int `json:"upload_chunk_size,omitempty"`
+	// URL of the collection for the chunked uploads. It can be omitted for
+	// Nextcloud endpoints, the uploads collection is derived from the endpoint
+	UploadsEndpoint string `json:"uploads_endpoint,omitempty"`
+	// Defines how to check if this config points to the same
+	// server as another config. If different configs point to the same
+	// server the renaming between the fs configs is allowed:
+	//  - 0 endpoint and username must match
+	//  - 1 only the endpoint must match
+	EqualityCheckMode int `json:"equality_check_mode,omitempty"`
+}
+
+// HideConfidentialData hides confidential data
+func (c *WebDAVFsConfig) HideConfidentialData() {
+	if c.Password != nil {
+		c.Password.Hide()
+	}
+}
+
+func (c *WebDAVFsConfig) setNilSecretsIfEmpty() {
+	if c.Password != nil && c.Password.IsEmpty() {
+		c.Password = nil
+	}
+}
+
+func (c *WebDAVFsConfig) setEmptyCredentialsIfNil() {
+	if c.Password == nil {
+		c.Password = kms.NewEmptySecret()
+	}
+}
+
+func (c *WebDAVFsConfig) isEqual(other WebDAVFsConfig) bool {
+	if c.Endpoint != other.Endpoint {
+		return false
+	}
+	if c.Username != other.Username {
+		return false
+	}
+	if c.Prefix != other.Prefix {
+		return false
+	}
+	if c.SkipTLSVerify != other.SkipTLSVerify {
+		return false
+	}
+	if c.CACertificates != other.CACertificates {
+		return false
+	}
+	if c.UploadChunkSize != other.UploadChunkSize {
+		return false
+	}
+	if c.UploadsEndpoint != other.UploadsEndpoint {
+		return false
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	return c.Password.IsEqual(other.Password)
+}
+
+func (c *WebDAVFsConfig) isSameResource(other WebDAVFsConfig) bool {
+	if c.EqualityCheckMode == 0 || other.EqualityCheckMode == 0 {
+		if c.Username != other.Username {
+			return false
+		}
+	}
+	return c.Endpoint == other.Endpoint
+}
+
+// getUploadsEndpoint returns the collection to use for chunked uploads
+func (c *WebDAVFsConfig) getUploadsEndpoint() string {
+	if c.UploadsEndpoint != "" {
+		return c.UploadsEndpoint
+	}
+	endpointURL, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return ""
+	}
+	idx := strings.Index(endpointURL.Path, webDAVNextcloudFilesPath)
+	if idx < 0 {
+		return ""
+	}
+	username, _, _ := strings.Cut(endpointURL.Path[idx+len(webDAVNextcloudFilesPath):], "/")
+	if username == "" {
+		return ""
+	}
+	endpointURL.Path = endpointURL.Path[:idx] + webDAVNextcloudUploadsPath + username
+	endpointURL.RawPath = ""
+	return endpointURL.String()
+}
+
+func (c *WebDAVFsConfig) validateEndpoint(endpoint string) error {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint: %w", err)
+	}
+	if !util.IsStringPrefixInSlice(endpoint, supportedEndpointSchema) {
+		return errors.New("invalid endpoint schema: http and https are supported")
+	}
+	if endpointURL.Host == "" {
+		return fmt.Errorf("invalid endpoint %q", endpoint)
+	}
+	return nil
+}
+
+// validate returns an error if the configuration is not valid
+func (c *WebDAVFsConfig) validate() error {
+	c.setEmptyCredentialsIfNil()
+	if c.Endpoint == "" {
+		return errors.New("endpoint cannot be empty")
+	}
+	c.Endpoint = strings.TrimRight(c.Endpoint, "/")
+	if err := c.validateEndpoint(c.Endpoint); err != nil {
+		return err
+	}
+	if !isEqualityCheckModeValid(c.EqualityCheckMode) {
+		return errors.New("invalid equality_check_mode")
+	}
+	if c.Password.IsEncrypted() && !c.Password.IsValid() {
+		return errors.New("invalid encrypted password")
+	}
+	if !c.Password.IsEmpty() && !c.Password.IsValidInput() {
+		return errors.New("invalid password")
+	}
+	if c.CACertificates != "" {
+		if !x509.NewCertPool().AppendCertsFromPEM([]byte(c.CACertificates)) {
+			return errors.New("invalid CA certificates, no PEM encoded certificate found")
+		}
+	}
+	if c.UploadChunkSize != 0 {
+		if c.UploadChunkSize < webDAVMinUploadChunkSize || c.UploadChunkSize > webDAVMaxUploadChunkSize {
+			return fmt.Errorf("invalid upload chunk size %d, it must be between %d and %d MB",
+				c.UploadChunkSize, webDAVMinUploadChunkSize, webDAVMaxUploadChunkSize)
+		}
+		c.UploadsEndpoint = strings.TrimRight(c.UploadsEndpoint, "/")
+		if c.UploadsEndpoint != "" {
+			if err := c.validateEndpoint(c.UploadsEndpoint); err != nil {
+				return fmt.Errorf("uploads endpoint: %w", err)
+			}
+		} else if c.getUploadsEndpoint() == "" {
+			return errors.New("the uploads endpoint is required for chunked uploads to non Nextcloud endpoints")
+		}
+	} else {
+		c.UploadsEndpoint = ""
+	}
+	if c.Prefix != "" {
+		c.Prefix = util.CleanPath(c.Prefix)
+	} else {
+		c.Prefix = "/"
+	}
+	return nil
+}
+
+// ValidateAndEncryptCredentials validates the config and encrypts credentials if they are in plain text
+func (c *WebDAVFsConfig) ValidateAndEncryptCredentials(additionalData string) error {
+	if err := c.validate(); err != nil {
+		return util.NewValidationError(fmt.Sprintf("could not validate WebDAV fs config: %v", err))
+	}
+	if c.Password.IsPlain() {
+		c.Password.SetAdditionalData(additionalData)
+		if err := c.Password.Encrypt(); err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt WebDAV fs password: %v", err))
+		}
+	}
+	return nil
+}
+
+// WebDAVFs is a Fs implementation for remote WebDAV servers
+type WebDAVFs struct {
+	connectionID string
+	// if not empty this fs is mouted as virtual folder in the specified path
+	mountPath    string
+	localTempDir string
+	config       *WebDAVFsConfig
+	endpoint     *url.URL
+	client       *http.Client
+	ctxTimeout   time.Duration
+}
+
+// NewWebDAVFs returns a WebDAVFs object that allows to interact with a remote WebDAV server
+func NewWebDAVFs(connectionID, mountPath, localTempDir string, config WebDAVFsConfig) (Fs, error) {
+	if localTempDir == "" {
+		if tempPath != "" {
+			localTempDir = tempPath
+		} else {
+			localTempDir = filepath.Clean(os.TempDir())
+		}
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if !config.Password.IsEmpty() {
+		if err := config.Password.TryDecrypt(); err != nil {
+			return nil, err
+		}
+	}
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxResponseHeaderBytes = 1 << 16
+	transport.WriteBufferSize = 1 << 16
+	transport.ReadBufferSize = 1 << 16
+	transport.DialContext = resolver.WrapDialContext(transport.DialContext)
+	if config.SkipTLSVerify || config.CACertificates != "" {
+		tlsConfig := &tls.Config{
+			NextProtos: []string{"h2", "http/1.1"},
+		}
+		if config.SkipTLSVerify {
+			tlsConfig.InsecureSkipVerify = true
+		} else {
+			rootCAs, err := x509.SystemCertPool()
+			if err != nil {
+				rootCAs = x509.NewCertPool()
+			}
+			rootCAs.AppendCertsFromPEM([]byte(config.CACertificates))
+			tlsConfig.RootCAs = rootCAs
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &WebDAVFs{
+		connectionID: connectionID,
+		mountPath:    getMountPath(mountPath),
+		localTempDir: localTempDir,
+		config:       &config,
+		endpoint:     endpoint,
+		client: &http.Client{
+			Transport: transport,
+		},
+		ctxTimeout: 30 * time.Second,
+	}, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs *WebDAVFs) Name() string {
+	return fmt.Sprintf("%s %q", webDAVFsName, fs.config.Endpoint)
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *WebDAVFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *WebDAVFs) Stat(name string) (os.FileInfo, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	responses, err := fs.propfind(ctx, name, "0", webDAVPropfindBody)
+	if err != nil {
+		return nil, err
+	}
+	for idx := range responses {
+		if prop := responses[idx].getProp(); prop != nil {
+			return prop.getFileInfo(path.Base(name)), nil
+		}
+	}
+	return nil, fmt.Errorf("no properties returned for %q: %w", name, os.ErrNotExist)
+}
+
+// Lstat returns a FileInfo describing the named file.
+// Symbolic links are not supported, so this is the same as Stat
+func (fs *WebDAVFs) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// Open opens the named file for reading
+func (fs *WebDAVFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		var headers map[string]string
+		if offset > 0 {
+			headers = map[string]string{"Range": fmt.Sprintf("bytes=%d-", offset)}
+		}
+		resp, err := fs.sendRequest(ctx, http.MethodGet, fs.getFileURL(name), headers, nil, -1)
+		if err != nil {
+			fsLog(fs, logger.LevelError, "download error, path %q, err: %v", name, err)
+			w.CloseWithError(err) //nolint:errcheck
+			return
+		}
+		defer resp.Body.Close()
+
+		if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+			// range requests are not supported by the server, skip the initial bytes
+			if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+				fsLog(fs, logger.LevelError, "download error, path %q, unable to skip to offset %d, err: %v",
+					name, offset, err)
+				w.CloseWithError(err) //nolint:errcheck
+				return
+			}
+		}
+		n, err := io.Copy(w, resp.Body)
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path %q size: %v, err: %+v", name, n, err)
+	}()
+
+	return nil, r, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *WebDAVFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		var err error
+		if fs.config.UploadChunkSize > 0 {
+			err = fs.uploadChunked(ctx, name, r)
+		} else {
+			err = fs.upload(ctx, name, &wrapReader{reader: r}, -1)
+		}
+		if err != nil {
+			fsLog(fs, logger.LevelError, "upload error, path %q, err: %v", name, err)
+		}
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, readed bytes: %d, err: %v",
+			name, r.GetReadedBytes(), err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Rename renames (moves) source to target.
+func (fs *WebDAVFs) Rename(source, target string) error {
+	if source == target {
+		return nil
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	return fs.move(ctx, fs.getFileURL(source), fs.getFileURL(target), nil)
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs *WebDAVFs) Remove(name string, isDir bool) error {
+	if isDir {
+		// DELETE removes the collections recursively
+		entries, err := fs.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return fmt.Errorf("cannot remove non empty directory: %q", name)
+		}
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.sendRequest(ctx, http.MethodDelete, fs.getFileURL(name), nil, nil, -1)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *WebDAVFs) Mkdir(name string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	return fs.mkcol(ctx, fs.getFileURL(name), nil)
+}
+
+// Symlink creates source as a symbolic link to target.
+func (*WebDAVFs) Symlink(source, target string) error {
+	return ErrVfsUnsupported
+}
+
+// Readlink returns the destination of the named symbolic link
+func (*WebDAVFs) Readlink(name string) (string, error) {
+	return "", ErrVfsUnsupported
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (*WebDAVFs) Chown(name string, uid int, gid int) error {
+	return ErrVfsUnsupported
+}
+
+// Chmod changes the mode of the named file to mode.
+func (*WebDAVFs) Chmod(name string, mode os.FileMode) error {
+	return ErrVfsUnsupported
+}
+
+// Chtimes changes the access and modification times of the named file.
+// The modification time is a live property, computed by the server, for WebDAV
+func (*WebDAVFs) Chtimes(name string, atime, mtime time.Time, isUploading bool) error {
+	return ErrVfsUnsupported
+}
+
+// Truncate changes the size of the named file.
+// Truncate by path is not supported, while truncating an opened
+// file is handled inside base transfer
+func (*WebDAVFs) Truncate(name string, size int64) error {
+	return ErrVfsUnsupported
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs *WebDAVFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	dirURL := fs.getFileURL(dirname)
+	responses, err := fs.propfind(ctx, dirname, "1", webDAVPropfindBody)
+	if err != nil {
+		return nil, err
+	}
+	dirPath := strings.TrimRight(dirURL.Path, "/")
+	result := make([]os.FileInfo, 0, len(responses))
+	for idx := range responses {
+		respPath, err := responses[idx].getPath()
+		if err != nil {
+			fsLog(fs, logger.LevelWarn, "skipping entry with invalid href %q: %v", responses[idx].Href, err)
+			continue
+		}
+		if respPath == dirPath {
+			// the requested collection is included in the response
+			continue
+		}
+		prop := responses[idx].getProp()
+		if prop == nil {
+			continue
+		}
+		result = append(result, prop.getFileInfo(path.Base(respPath)))
+	}
+	return result, nil
+}
+
+// IsUploadResumeSupported returns true if resuming uploads is supported.
+func (*WebDAVFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+func (*WebDAVFs) IsAtomicUploadSupported() bool {
+	return false
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (*WebDAVFs) IsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (*WebDAVFs) IsPermission(err error) bool {
+	return errors.Is(err, fs.ErrPermission)
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (*WebDAVFs) IsNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	return err == ErrVfsUnsupported
+}
+
+// CheckRootPath creates the specified local root directory if it does not exists
+func (fs *WebDAVFs) CheckRootPath(username string, uid int, gid int) bool {
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, "")
+	osFs.CheckRootPath(username, uid, gid)
+	if fs.config.Prefix == "/" {
+		return true
+	}
+	if err := fs.mkdirAll(fs.config.Prefix); err != nil {
+		fsLog(fs, logger.LevelDebug, "error creating root directory %q for user %q: %v", fs.config.Prefix, username, err)
+		return false
+	}
+	return true
+}
+
+// ScanRootDirContents returns the number of files contained in a directory and
+// their size
+func (fs *WebDAVFs) ScanRootDirContents() (int, int64, error) {
+	return fs.GetDirSize(fs.config.Prefix)
+}
+
+// CheckMetadata checks the metadata consistency
+func (*WebDAVFs) CheckMetadata() error {
+	return nil
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload
+func (*WebDAVFs) GetAtomicUploadPath(name string) string {
+	return ""
+}
+
+// GetRelativePath returns the path for a file relative to the prefix if any.
+// This is the path as seen by SFTPGo users
+func (fs *WebDAVFs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		return "/" + rel
+	}
+	if fs.config.Prefix != "/" {
+		if !strings.HasPrefix(rel, fs.config.Prefix) {
+			rel = "/"
+		}
+		rel = path.Clean("/" + strings.TrimPrefix(rel, fs.config.Prefix))
+	}
+	if fs.mountPath != "" {
+		rel = path.Join(fs.mountPath, rel)
+	}
+	return rel
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *WebDAVFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Stat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	return fs.walk(root, info, walkFn)
+}
+
+// Join joins any number of path elements into a single path
+func (*WebDAVFs) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*WebDAVFs) HasVirtualFolders() bool {
+	return false
+}
+
+// ResolvePath returns the matching filesystem path for the specified virtual path
+func (fs *WebDAVFs) ResolvePath(virtualPath string) (string, error) {
+	if fs.mountPath != "" {
+		virtualPath = strings.TrimPrefix(virtualPath, fs.mountPath)
+	}
+	if !path.IsAbs(virtualPath) {
+		virtualPath = path.Clean("/" + virtualPath)
+	}
+	return fs.Join(fs.config.Prefix, virtualPath), nil
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders
+func (fs *WebDAVFs) GetDirSize(dirname string) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	isDir, err := isDirectory(fs, dirname)
+	if err == nil && isDir {
+		err = fs.Walk(dirname, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info != nil && info.Mode().IsRegular() {
+				size += info.Size()
+				numFiles++
+			}
+			return nil
+		})
+	}
+	return numFiles, size, err
+}
+
+// GetMimeType returns the content type
+func (fs *WebDAVFs) GetMimeType(name string) (string, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	responses, err := fs.propfind(ctx, name, "0", webDAVPropfindBody)
+	if err != nil {
+		return "", err
+	}
+	for idx := range responses {
+		if prop := responses[idx].getProp(); prop != nil && prop.ContentType != "" {
+			return prop.ContentType, nil
+		}
+	}
+	return mime.TypeByExtension(path.Ext(name)), nil
+}
+
+// GetAvailableDiskSize returns the available size for the specified path
+func (fs *WebDAVFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	responses, err := fs.propfind(ctx, dirName, "0", webDAVQuotaPropfindBody)
+	if err != nil {
+		return nil, err
+	}
+	for idx := range responses {
+		prop := responses[idx].getProp()
+		if prop == nil {
+			continue
+		}
+		// negative values are used to report unknown or unlimited quotas
+		available, err := strconv.ParseInt(prop.QuotaAvailable, 10, 64)
+		if err != nil || available < 0 {
+			return nil, ErrStorageSizeUnavailable
+		}
+		used, err := strconv.ParseInt(prop.QuotaUsed, 10, 64)
+		if err != nil || used < 0 {
+			used = 0
+		}
+		blockSize := uint64(4096)
+		return &sftp.StatVFS{
+			Bsize:   blockSize,
+			Frsize:  blockSize,
+			Blocks:  uint64(available+used) / blockSize,
+			Bfree:   uint64(available) / blockSize,
+			Bavail:  uint64(available) / blockSize,
+			Namemax: 255,
+		}, nil
+	}
+	return nil, ErrStorageSizeUnavailable
+}
+
+// Close closes the fs
+func (fs *WebDAVFs) Close() error {
+	fs.client.CloseIdleConnections()
+	return nil
+}
+
+func (fs *WebDAVFs) walk(filePath string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(filePath, info, nil)
+	}
+	files, err := fs.ReadDir(filePath)
+	err1 := walkFn(filePath, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	for _, fi := range files {
+		objName := path.Join(filePath, fi.Name())
+		err = fs.walk(objName, fi, walkFn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *WebDAVFs) mkdirAll(name string) error {
+	var current string
+	for _, dir := range strings.Split(strings.Trim(name, "/"), "/") {
+		current = path.Join(current, dir)
+		info, err := fs.Stat(current)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%q is not a directory", current)
+			}
+			continue
+		}
+		if !fs.IsNotExist(err) {
+			return err
+		}
+		if err := fs.Mkdir(current); err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// getFileURL returns the URL for the specified path relative to the endpoint
+func (fs *WebDAVFs) getFileURL(name string) *url.URL {
+	return fs.getURL(fs.endpoint, name)
+}
+
+func (*WebDAVFs) getURL(base *url.URL, name string) *url.URL {
+	u := *base
+	u.Path = path.Join(u.Path, path.Clean("/"+name))
+	u.RawPath = ""
+	return &u
+}
+
+func (fs *WebDAVFs) sendRequest(ctx context.Context, method string, u *url.URL, headers map[string]string,
+	body io.Reader, contentLength int64,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentLength >= 0 {
+		req.ContentLength = contentLength
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if fs.config.Username != "" || fs.config.Password.GetPayload() != "" {
+		req.SetBasicAuth(fs.config.Username, fs.config.Password.GetPayload())
+	}
+	resp, err := fs.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to send %s request to URL %v: %w", method, u.Redacted(), err)
+	}
+	if err := getWebDAVErrorFromResponseCode(resp.StatusCode); err != nil {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096)) //nolint:errcheck
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s request to %q failed: %w", method, u.Path, err)
+	}
+	return resp, nil
+}
+
+func (fs *WebDAVFs) propfind(ctx context.Context, name, depth, body string) ([]webDAVResponse, error) {
+	headers := map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml; charset=utf-8",
+	}
+	resp, err := fs.sendRequest(ctx, "PROPFIND", fs.getFileURL(name), headers, strings.NewReader(body),
+		int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ms webDAVMultiStatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("unable to decode PROPFIND response for %q: %w", name, err)
+	}
+	return ms.Responses, nil
+}
+
+func (fs *WebDAVFs) mkcol(ctx context.Context, u *url.URL, headers map[string]string) error {
+	resp, err := fs.sendRequest(ctx, "MKCOL", u, headers, nil, -1)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (fs *WebDAVFs) move(ctx context.Context, source, target *url.URL, headers map[string]string) error {
+	moveHeaders := map[string]string{
+		"Destination": target.String(),
+		"Overwrite":   "T",
+	}
+	for k, v := range headers {
+		moveHeaders[k] = v
+	}
+	resp, err := fs.sendRequest(ctx, "MOVE", source, moveHeaders, nil, -1)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (fs *WebDAVFs) upload(ctx context.Context, name string, body io.Reader, contentLength int64) error {
+	headers := make(map[string]string)
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		headers["Content-Type"] = contentType
+	}
+	resp, err := fs.sendRequest(ctx, http.MethodPut, fs.getFileURL(name), headers, body, contentLength)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// uploadChunked uploads the file using the Nextcloud chunked upload protocol (v2).
+// Small files, that fit in a single chunk, are uploaded using a single request
+func (fs *WebDAVFs) uploadChunked(ctx context.Context, name string, r io.Reader) error {
+	buf := make([]byte, int64(fs.config.UploadChunkSize)*1024*1024)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fs.upload(ctx, name, bytes.NewReader(buf[:n]), int64(n))
+	}
+	if err != nil {
+		return err
+	}
+	uploadsEndpoint, err := url.Parse(fs.config.getUploadsEndpoint())
+	if err != nil {
+		return err
+	}
+	uploadDir := fs.getURL(uploadsEndpoint, "sftpgo-"+xid.New().String())
+	destination := fs.getFileURL(name)
+	headers := map[string]string{
+		"Destination": destination.String(),
+	}
+	if err := fs.mkcol(ctx, uploadDir, headers); err != nil {
+		return fmt.Errorf("unable to create the chunked upload collection: %w", err)
+	}
+	abort := func() {
+		ctxAbort, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
+
+		resp, err := fs.sendRequest(ctxAbort, http.MethodDelete, uploadDir, nil, nil, -1)
+		if err != nil {
+			fsLog(fs, logger.LevelWarn, "unable to abort chunked upload %q: %v", uploadDir.Path, err)
+			return
+		}
+		resp.Body.Close()
+	}
+	var totalSize int64
+	for chunk := 1; n > 0; chunk++ {
+		// chunks are named with padded numbers, the server sorts them by name
+		chunkURL := fs.getURL(uploadDir, fmt.Sprintf("%05d", chunk))
+		resp, errPut := fs.sendRequest(ctx, http.MethodPut, chunkURL, headers, bytes.NewReader(buf[:n]), int64(n))
+		if errPut != nil {
+			abort()
+			return fmt.Errorf("unable to upload chunk %d: %w", chunk, errPut)
+		}
+		resp.Body.Close()
+		totalSize += int64(n)
+		if err != nil {
+			// the last chunk was sent
+			break
+		}
+		n, err = io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			abort()
+			return err
+		}
+	}
+	err = fs.move(ctx, fs.getURL(uploadDir, ".file"), destination, map[string]string{
+		"OC-Total-Length": strconv.FormatInt(totalSize, 10),
+	})
+	if err != nil {
+		abort()
+		return fmt.Errorf("unable to assemble the uploaded chunks: %w", err)
+	}
+	return nil
+}
+
+func getWebDAVErrorFromResponseCode(code int) error {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return os.ErrPermission
+	case http.StatusNotFound, http.StatusConflict:
+		// 409 is returned if a parent collection does not exist
+		return os.ErrNotExist
+	case http.StatusMethodNotAllowed, http.StatusPreconditionFailed:
+		// 405 is returned by MKCOL if the resource already exists
+		return os.ErrExist
+	case http.StatusNotImplemented:
+		return ErrVfsUnsupported
+	case http.StatusInsufficientStorage:
+		return errors.New("insufficient storage")
+	}
+	if code >= 200 && code < 300 {
+		return nil
+	}
+	return fmt.Errorf("unexpected response code: %v", code)
+}
+
+type webDAVMultiStatus struct {
+	XMLName   xml.Name         `xml:"DAV: multistatus"`
+	Responses []webDAVResponse `xml:"DAV: response"`
+}
+
+type webDAVResponse struct {
+	Href      string           `xml:"DAV: href"`
+	Propstats []webDAVPropstat `xml:"DAV: propstat"`
+}
+
+// getPath returns the unescaped path, without trailing slash, for this response
+func (r *webDAVResponse) getPath() (string, error) {
+	u, err := url.Parse(strings.TrimSpace(r.Href))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(u.Path, "/"), nil
+}
+
+// getProp returns the successfully retrieved properties, if any
+func (r *webDAVResponse) getProp() *webDAVProp {
+	for idx := range r.Propstats {
+		fields := strings.Fields(r.Propstats[idx].Status)
+		if len(fields) > 1 && fields[1] == "200" {
+			return &r.Propstats[idx].Prop
+		}
+	}
+	return nil
+}
+
+type webDAVPropstat struct {
+	Prop   webDAVProp `xml:"DAV: prop"`
+	Status string     `xml:"DAV: status"`
+}
+
+type webDAVProp struct {
+	ResourceType struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+	ContentLength  string `xml:"DAV: getcontentlength"`
+	LastModified   string `xml:"DAV: getlastmodified"`
+	ContentType    string `xml:"DAV: getcontenttype"`
+	QuotaAvailable string `xml:"DAV: quota-available-bytes"`
+	QuotaUsed      string `xml:"DAV: quota-used-bytes"`
+}
+
+func (p *webDAVProp) getFileInfo(name string) os.FileInfo {
+	isDir := p.ResourceType.Collection != nil
+	var size int64
+	if !isDir {
+		size, _ = strconv.ParseInt(strings.TrimSpace(p.ContentLength), 10, 64)
+	}
+	modTime, err := http.ParseTime(strings.TrimSpace(p.LastModified))
+	if err != nil {
+		modTime = time.Unix(0, 0)
+	}
+	return NewFileInfo(name, isDir, size, modTime, false)
+}
@@ -0,0 +1,99 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build linux
+// +build linux
+
+package vfs
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const userXattrPrefix = "user."
+
+func getXattrError(op, name string, err error) error {
+	if errors.Is(err, unix.ENOTSUP) {
+		return ErrVfsUnsupported
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+func getXattr(name, attr string) (string, error) {
+	size, err := unix.Getxattr(name, attr, nil)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Getxattr(name, attr, buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:size]), nil
+}
+
+// getUserXattrs returns the extended attributes in the user namespace,
+// the namespace prefix is removed from the returned names
+func getUserXattrs(name string) (map[string]string, error) {
+	result := make(map[string]string)
+	size, err := unix.Listxattr(name, nil)
+	if err != nil {
+		return nil, getXattrError("listxattr", name, err)
+	}
+	if size == 0 {
+		return result, nil
+	}
+	buf := make([]byte, size)
+	size, err = unix.Listxattr(name, buf)
+	if err != nil {
+		return nil, getXattrError("listxattr", name, err)
+	}
+	for _, attr := range strings.Split(string(buf[:size]), "\x00") {
+		if !strings.HasPrefix(attr, userXattrPrefix) {
+			continue
+		}
+		value, err := getXattr(name, attr)
+		if err != nil {
+			if errors.Is(err, unix.ENODATA) {
+				// removed after listing
+				continue
+			}
+			return nil, getXattrError("getxattr", name, err)
+		}
+		result[strings.TrimPrefix(attr, userXattrPrefix)] = value
+	}
+	return result, nil
+}
+
+// setUserXattrs sets the specified extended attributes in the user namespace,
+// attributes with an empty value are removed
+func setUserXattrs(name string, xattrs map[string]string) error {
+	for k, v := range xattrs {
+		attr := userXattrPrefix + k
+		if v == "" {
+			if err := unix.Removexattr(name, attr); err != nil && !errors.Is(err, unix.ENODATA) {
+				return getXattrError("removexattr", name, err)
+			}
+			continue
+		}
+		if err := unix.Setxattr(name, attr, []byte(v), 0); err != nil {
+			return getXattrError("setxattr", name, err)
+		}
+	}
+	return nil
+}
@@ -15,14 +15,17 @@
 package webdavd
 
 import (
+	"bytes"
 	"context"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"io"
 	"mime"
 	"net/http"
 	"os"
 	"path"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -41,6 +44,8 @@ var (
 	lastModifiedProps  = []string{"Win32LastModifiedTime", "getlastmodified"}
 )
 
+const customMetadataNamespace = "urn:sftpgo:metadata"
+
 type webDavFile struct {
 	*common.BaseTransfer
 	writer      io.WriteCloser
@@ -142,9 +147,7 @@ func (f *webDavFile) Stat() (os.FileInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	if vfs.IsCryptOsFs(f.Fs) {
-		info = f.Fs.(*vfs.CryptFs).ConvertFileInfo(info)
-	}
+	info = vfs.ConvertFileInfo(f.Fs, info)
 	fi := &webDavFileInfo{
 		FileInfo:    info,
 		Fs:          f.Fs,
@@ -247,9 +250,7 @@ func (f *webDavFile) updateStatInfo() error {
 	if err != nil {
 		return err
 	}
-	if vfs.IsCryptOsFs(f.Fs) {
-		info = f.Fs.(*vfs.CryptFs).ConvertFileInfo(info)
-	}
+	info = vfs.ConvertFileInfo(f.Fs, info)
 	f.info = info
 	return nil
 }
@@ -382,21 +383,109 @@ func (f *webDavFile) isTransfer() bool {
 }
 
 // DeadProps returns a copy of the dead properties held.
-// We always return nil for now, we only support the last modification time
-// and it is already included in "live" properties
+// If enabled, the custom metadata are returned in the "urn:sftpgo:metadata"
+// namespace, the last modification time is already included in "live" properties
 func (f *webDavFile) DeadProps() (map[xml.Name]webdav.Property, error) {
-	return nil, nil
+	if !customMetadataEnabled {
+		return nil, nil
+	}
+	metadata, err := f.Connection.GetCustomMetadata(f.GetVirtualPath())
+	if err != nil {
+		f.Connection.Log(logger.LevelDebug, "unable to get metadata for %q, err: %v", f.GetVirtualPath(), err)
+		return nil, nil
+	}
+	props := make(map[xml.Name]webdav.Property)
+	for k, v := range metadata {
+		var value bytes.Buffer
+		if err := xml.EscapeText(&value, []byte(v)); err != nil {
+			continue
+		}
+		name := xml.Name{Space: customMetadataNamespace, Local: k}
+		props[name] = webdav.Property{
+			XMLName:  name,
+			InnerXML: value.Bytes(),
+		}
+	}
+	return props, nil
+}
+
+func isCustomMetadataPatch(patch webdav.Proppatch) bool {
+	if !customMetadataEnabled || len(patch.Props) == 0 {
+		return false
+	}
+	for _, p := range patch.Props {
+		if p.XMLName.Space != customMetadataNamespace {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *webDavFile) patchCustomMetadata(patch webdav.Proppatch) (webdav.Propstat, error) {
+	pstat := webdav.Propstat{
+		Status: http.StatusOK,
+	}
+	metadata := make(map[string]string)
+	for _, p := range patch.Props {
+		pstat.Props = append(pstat.Props, webdav.Property{XMLName: p.XMLName})
+		if patch.Remove {
+			metadata[p.XMLName.Local] = ""
+			continue
+		}
+		value, err := getPropertyText(p.InnerXML)
+		if err != nil {
+			return pstat, err
+		}
+		metadata[p.XMLName.Local] = value
+	}
+	attrs := &common.StatAttributes{
+		Flags:    common.StatAttrMetadata,
+		Metadata: metadata,
+	}
+	return pstat, f.Connection.SetStat(f.GetVirtualPath(), attrs)
+}
+
+// getPropertyText returns the text content of a property,
+// properties with nested elements are not supported
+func getPropertyText(innerXML []byte) (string, error) {
+	var result strings.Builder
+	decoder := xml.NewDecoder(bytes.NewReader(innerXML))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return result.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.CharData:
+			result.Write(t)
+		case xml.StartElement:
+			return "", fmt.Errorf("unsupported nested element %q", t.Name.Local)
+		}
+	}
 }
 
 // Patch patches the dead properties held.
-// In our minimal implementation we just support Win32LastModifiedTime and
-// getlastmodified to set the the modification time.
+// We support Win32LastModifiedTime and getlastmodified to set the the modification
+// time and, if enabled, the properties in the custom metadata namespace.
 // We ignore any other property and just return an OK response if the patch sets
 // the modification time, otherwise a Forbidden response
 func (f *webDavFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
 	resp := make([]webdav.Propstat, 0, len(patches))
 	hasError := false
 	for _, patch := range patches {
+		if !hasError && isCustomMetadataPatch(patch) {
+			pstat, err := f.patchCustomMetadata(patch)
+			if err != nil {
+				f.Connection.Log(logger.LevelWarn, "unable to set metadata for %q, err: %v", f.GetVirtualPath(), err)
+				pstat.Status = http.StatusForbidden
+				hasError = true
+			}
+			resp = append(resp, pstat)
+			continue
+		}
 		status := http.StatusForbidden
 		pstat := webdav.Propstat{}
 		for _, p := range patch.Props {
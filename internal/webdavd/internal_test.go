@@ -759,6 +759,89 @@ func TestRemoveDirTree(t *testing.T) {
 	}
 }
 
+func TestCustomMetadataProps(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("extended attributes are supported on Linux only")
+	}
+	homeDir := filepath.Join(os.TempDir(), "webdav_metadata")
+	err := os.MkdirAll(homeDir, os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(homeDir, testFile), []byte("content"), 0666)
+	assert.NoError(t, err)
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			HomeDir: homeDir,
+		},
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	fs := vfs.NewOsFs("connID", user.HomeDir, "")
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(fs.ConnectionID(), common.ProtocolWebDAV, "", "", user),
+	}
+	davFile, err := connection.getFile(fs, filepath.Join(homeDir, testFile), "/"+testFile)
+	if assert.NoError(t, err) {
+		transfer := davFile.(*webDavFile)
+		props, err := transfer.DeadProps()
+		assert.NoError(t, err)
+		assert.Len(t, props, 0)
+
+		customMetadataEnabled = true
+		metadataProp := webdav.Property{
+			XMLName:  xml.Name{Space: customMetadataNamespace, Local: "label"},
+			InnerXML: []byte(`a &amp; b`),
+		}
+		pstats, err := transfer.Patch([]webdav.Proppatch{
+			{
+				Props: []webdav.Property{metadataProp},
+			},
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, pstats, 1) {
+			assert.Equal(t, http.StatusOK, pstats[0].Status)
+		}
+		props, err = transfer.DeadProps()
+		assert.NoError(t, err)
+		if assert.Len(t, props, 1) {
+			assert.Equal(t, []byte(`a &amp; b`), props[metadataProp.XMLName].InnerXML)
+		}
+		// nested elements are not supported
+		pstats, err = transfer.Patch([]webdav.Proppatch{
+			{
+				Props: []webdav.Property{
+					{
+						XMLName:  xml.Name{Space: customMetadataNamespace, Local: "nested"},
+						InnerXML: []byte(`<a>b</a>`),
+					},
+				},
+			},
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, pstats, 1) {
+			assert.Equal(t, http.StatusForbidden, pstats[0].Status)
+		}
+		pstats, err = transfer.Patch([]webdav.Proppatch{
+			{
+				Remove: true,
+				Props:  []webdav.Property{metadataProp},
+			},
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, pstats, 1) {
+			assert.Equal(t, http.StatusOK, pstats[0].Status)
+		}
+		props, err = transfer.DeadProps()
+		assert.NoError(t, err)
+		assert.Len(t, props, 0)
+		customMetadataEnabled = false
+
+		err = transfer.Close()
+		assert.NoError(t, err)
+	}
+	err = os.RemoveAll(homeDir)
+	assert.NoError(t, err)
+}
+
 func TestContentType(t *testing.T) {
 	user := dataprovider.User{
 		BaseUser: sdk.BaseUser{
@@ -1627,3 +1710,134 @@ func TestMisc(t *testing.T) {
 
 	certMgr = oldCertMgr
 }
+
+func TestLocksConfig(t *testing.T) {
+	c := LocksConfig{}
+	err := c.validate()
+	assert.NoError(t, err)
+	assert.Equal(t, LockDriverMemory, c.Driver)
+	c.Driver = "unknown"
+	err = c.validate()
+	assert.Error(t, err)
+	c.Driver = LockDriverProvider
+	c.MaxTimeout = -1
+	err = c.validate()
+	assert.Error(t, err)
+	c.MaxTimeout = 0
+	err = c.validate()
+	assert.NoError(t, err)
+	_, ok := c.newLockSystem("user").(*providerLockSystem)
+	assert.True(t, ok)
+	c.Driver = LockDriverMemory
+	_, ok = c.newLockSystem("user").(*providerLockSystem)
+	assert.False(t, ok)
+
+	req, err := http.NewRequest("LOCK", "/file", nil)
+	assert.NoError(t, err)
+	c.limitLockTimeout(req)
+	assert.Empty(t, req.Header.Get("Timeout"))
+	c.MaxTimeout = 60
+	c.limitLockTimeout(req)
+	assert.Equal(t, "Second-60", req.Header.Get("Timeout"))
+	req.Header.Set("Timeout", "Infinite, Second-4100000000")
+	c.limitLockTimeout(req)
+	assert.Equal(t, "Second-60", req.Header.Get("Timeout"))
+	req.Header.Set("Timeout", "Second-3600")
+	c.limitLockTimeout(req)
+	assert.Equal(t, "Second-60", req.Header.Get("Timeout"))
+	req.Header.Set("Timeout", "Second-30")
+	c.limitLockTimeout(req)
+	assert.Equal(t, "Second-30", req.Header.Get("Timeout"))
+	req.Header.Set("Timeout", "invalid")
+	c.limitLockTimeout(req)
+	assert.Equal(t, "invalid", req.Header.Get("Timeout"))
+
+	assert.Equal(t, -1*time.Second, limitLockDuration(-1*time.Second, 0))
+	assert.Equal(t, time.Minute, limitLockDuration(-1*time.Second, time.Minute))
+	assert.Equal(t, time.Minute, limitLockDuration(time.Hour, time.Minute))
+	assert.Equal(t, time.Second, limitLockDuration(time.Second, time.Minute))
+}
+
+func TestProviderLockSystem(t *testing.T) {
+	ls := newProviderLockSystem("webdav_locks_test_user", time.Hour)
+	ls2 := newProviderLockSystem("webdav_locks_test_user2", 0)
+	for _, l := range []*providerLockSystem{ls, ls2} {
+		err := l.save(nil)
+		assert.NoError(t, err)
+	}
+	now := time.Now()
+	_, _, _, err := ls.GetByName("/dir/file")
+	assert.ErrorIs(t, err, webdav.ErrNoSuchLock)
+
+	token, err := ls.Create(now, webdav.LockDetails{
+		Root:      "dir",
+		Duration:  -1,
+		OwnerXML:  "owner",
+		ZeroDepth: false,
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, token, lockTokenPrefix)
+	// the lock is shared among the lock systems for the same user
+	ls1 := newProviderLockSystem(ls.username, time.Hour)
+	foundToken, expiry, details, err := ls1.GetByName("/dir/file")
+	assert.NoError(t, err)
+	assert.Equal(t, token, foundToken)
+	assert.Equal(t, "/dir", details.Root)
+	assert.Equal(t, time.Hour, details.Duration)
+	assert.Equal(t, "owner", details.OwnerXML)
+	assert.False(t, details.ZeroDepth)
+	assert.WithinDuration(t, now.Add(time.Hour), expiry, time.Second)
+	// infinite depth lock on an ancestor
+	_, err = ls1.Create(now, webdav.LockDetails{Root: "/dir/file", Duration: time.Minute, ZeroDepth: true})
+	assert.ErrorIs(t, err, webdav.ErrLocked)
+	_, err = ls1.Create(now, webdav.LockDetails{Root: "/", Duration: time.Minute, ZeroDepth: false})
+	assert.ErrorIs(t, err, webdav.ErrLocked)
+	// a zero depth lock on the root is allowed
+	rootToken, err := ls1.Create(now, webdav.LockDetails{Root: "/", Duration: time.Hour, ZeroDepth: true})
+	assert.NoError(t, err)
+	// a different user is not affected
+	token2, err := ls2.Create(now, webdav.LockDetails{Root: "/dir", Duration: -1})
+	assert.NoError(t, err)
+
+	_, err = ls1.Confirm(now, "/dir/file", "", webdav.Condition{Token: "invalid"})
+	assert.ErrorIs(t, err, webdav.ErrConfirmationFailed)
+	_, err = ls1.Confirm(now, "/dir/file", "/file", webdav.Condition{Token: token})
+	assert.ErrorIs(t, err, webdav.ErrConfirmationFailed)
+	release, err := ls1.Confirm(now, "/dir/file", "/dir/sub/file", webdav.Condition{Token: token})
+	assert.NoError(t, err)
+	// an held lock cannot be confirmed, refreshed or unlocked
+	_, err = ls1.Confirm(now, "/dir/file", "", webdav.Condition{Token: token})
+	assert.ErrorIs(t, err, webdav.ErrConfirmationFailed)
+	_, err = ls1.Refresh(now, token, time.Minute)
+	assert.ErrorIs(t, err, webdav.ErrLocked)
+	err = ls1.Unlock(now, token)
+	assert.ErrorIs(t, err, webdav.ErrLocked)
+	release()
+
+	details, err = ls1.Refresh(now, token, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, details.Duration)
+	_, err = ls1.Refresh(now, "invalid", time.Minute)
+	assert.ErrorIs(t, err, webdav.ErrNoSuchLock)
+	// expired locks are removed
+	_, err = ls1.Confirm(now.Add(2*time.Minute), "/dir/file", "", webdav.Condition{Token: token})
+	assert.ErrorIs(t, err, webdav.ErrConfirmationFailed)
+	err = ls1.Unlock(now.Add(2*time.Minute), token)
+	assert.ErrorIs(t, err, webdav.ErrNoSuchLock)
+
+	later := now.Add(2 * time.Minute)
+	token, err = ls.Create(later, webdav.LockDetails{Root: "/dir/file", Duration: time.Minute, ZeroDepth: true})
+	assert.NoError(t, err)
+	err = ls.Delete(later, "/dir/file")
+	assert.NoError(t, err)
+	err = ls.Delete(later, "/dir/file")
+	assert.NoError(t, err)
+	err = ls.Unlock(later, token)
+	assert.ErrorIs(t, err, webdav.ErrNoSuchLock)
+	err = ls.Unlock(later, rootToken)
+	assert.NoError(t, err)
+	_, err = dataprovider.GetSharedSession(ls.getSessionKey())
+	assert.Error(t, err)
+	err = ls2.Unlock(later, token2)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,461 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package webdavd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/webdav"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported lock drivers
+const (
+	LockDriverMemory   = "memory"
+	LockDriverProvider = "provider"
+)
+
+const (
+	lockSessionKeyPrefix = "webdav_locks_"
+	lockTokenPrefix      = "urn:uuid:"
+)
+
+var (
+	supportedLockDrivers = []string{LockDriverMemory, LockDriverProvider}
+)
+
+// LocksConfig defines the configuration for WebDAV locks
+type LocksConfig struct {
+	// Driver defines where the locks are stored. "memory" keeps the locks for each user
+	// in memory and they are lost if the user is removed from the cache, "provider" stores
+	// the locks within the data provider so they are shared among multiple SFTPGo instances
+	Driver string `json:"driver" mapstructure:"driver"`
+	// Maximum timeout for locks, as seconds. Locks requested with a longer or an infinite
+	// timeout will be limited to this value. 0 means no limit
+	MaxTimeout int `json:"max_timeout" mapstructure:"max_timeout"`
+}
+
+func (c *LocksConfig) validate() error {
+	if c.Driver == "" {
+		c.Driver = LockDriverMemory
+	}
+	if !util.Contains(supportedLockDrivers, c.Driver) {
+		return fmt.Errorf("unsupported lock driver %#v", c.Driver)
+	}
+	if c.MaxTimeout < 0 {
+		return fmt.Errorf("invalid lock max timeout %d", c.MaxTimeout)
+	}
+	return nil
+}
+
+func (c *LocksConfig) getMaxTimeout() time.Duration {
+	return time.Duration(c.MaxTimeout) * time.Second
+}
+
+// newLockSystem returns the lock system to use for the specified user
+func (c *LocksConfig) newLockSystem(username string) webdav.LockSystem {
+	if c.Driver == LockDriverProvider {
+		return newProviderLockSystem(username, c.getMaxTimeout())
+	}
+	return webdav.NewMemLS()
+}
+
+// limitLockTimeout rewrites the Timeout header for LOCK requests, if required,
+// so the configured maximum timeout is applied and reported to the clients
+func (c *LocksConfig) limitLockTimeout(r *http.Request) {
+	if c.MaxTimeout <= 0 {
+		return
+	}
+	maxTimeout := fmt.Sprintf("Second-%d", c.MaxTimeout)
+	// only the first timeout is used, additional ones are ignored
+	timeout, _, _ := strings.Cut(r.Header.Get("Timeout"), ",")
+	timeout = strings.TrimSpace(timeout)
+	if timeout == "" || timeout == "Infinite" {
+		r.Header.Set("Timeout", maxTimeout)
+		return
+	}
+	if strings.HasPrefix(timeout, "Second-") {
+		val, err := strconv.ParseInt(strings.TrimPrefix(timeout, "Second-"), 10, 64)
+		if err == nil && val > int64(c.MaxTimeout) {
+			r.Header.Set("Timeout", maxTimeout)
+		}
+	}
+}
+
+// limitLockDuration applies the specified maximum timeout, if any, to the lock
+// duration. A negative duration means infinite
+func limitLockDuration(duration, maxTimeout time.Duration) time.Duration {
+	if maxTimeout <= 0 {
+		return duration
+	}
+	if duration < 0 || duration > maxTimeout {
+		return maxTimeout
+	}
+	return duration
+}
+
+type providerLock struct {
+	Token     string        `json:"token"`
+	Root      string        `json:"root"`
+	Duration  time.Duration `json:"duration"`
+	OwnerXML  string        `json:"owner_xml,omitempty"`
+	ZeroDepth bool          `json:"zero_depth,omitempty"`
+	// expiration as unix timestamp in milliseconds, 0 means no expiration
+	Expiry int64 `json:"expiry,omitempty"`
+}
+
+func (l *providerLock) isExpired(now int64) bool {
+	return l.Expiry > 0 && l.Expiry <= now
+}
+
+func (l *providerLock) setDuration(now time.Time, duration time.Duration) {
+	l.Duration = duration
+	if duration >= 0 {
+		l.Expiry = util.GetTimeAsMsSinceEpoch(now.Add(duration))
+	} else {
+		l.Expiry = 0
+	}
+}
+
+func (l *providerLock) getDetails() webdav.LockDetails {
+	return webdav.LockDetails{
+		Root:      l.Root,
+		Duration:  l.Duration,
+		OwnerXML:  l.OwnerXML,
+		ZeroDepth: l.ZeroDepth,
+	}
+}
+
+func (l *providerLock) getExpiry() time.Time {
+	if l.Expiry == 0 {
+		return time.Time{}
+	}
+	return util.GetTimeFromMsecSinceEpoch(l.Expiry)
+}
+
+// isLocking returns true if the lock regulates the specified resource name
+func (l *providerLock) isLocking(name string) bool {
+	if name == l.Root {
+		return true
+	}
+	if l.ZeroDepth {
+		return false
+	}
+	return l.Root == "/" || strings.HasPrefix(name, l.Root+"/")
+}
+
+// providerLockSystem is a lock system that stores the locks for a user within the
+// data provider, as a shared session. Locks are held, while a request confirmed them
+// is in progress, in memory only, so a lock held on a node can be confirmed on
+// another one
+type providerLockSystem struct {
+	mu         sync.Mutex
+	username   string
+	maxTimeout time.Duration
+	held       map[string]bool
+}
+
+func newProviderLockSystem(username string, maxTimeout time.Duration) *providerLockSystem {
+	return &providerLockSystem{
+		username:   username,
+		maxTimeout: maxTimeout,
+		held:       make(map[string]bool),
+	}
+}
+
+func (l *providerLockSystem) getSessionKey() string {
+	return lockSessionKeyPrefix + l.username
+}
+
+// load returns the not expired locks stored within the data provider
+func (l *providerLockSystem) load(now time.Time) ([]*providerLock, error) {
+	session, err := dataprovider.GetSharedSession(l.getSessionKey())
+	if err != nil {
+		if isLockSessionNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if session.Type != dataprovider.SessionTypeWebDAVLocks {
+		return nil, fmt.Errorf("unexpected session type for WebDAV locks: %v", session.Type)
+	}
+	data, ok := session.Data.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("invalid WebDAV locks data type %T", session.Data)
+	}
+	var locks []*providerLock
+	if err := json.Unmarshal(data, &locks); err != nil {
+		return nil, err
+	}
+	result := make([]*providerLock, 0, len(locks))
+	nowMs := util.GetTimeAsMsSinceEpoch(now)
+	for _, lock := range locks {
+		// held locks don't expire, this is the same behavior as the memory lock system
+		if !lock.isExpired(nowMs) || l.held[lock.Token] {
+			result = append(result, lock)
+		}
+	}
+	return result, nil
+}
+
+func (l *providerLockSystem) save(locks []*providerLock) error {
+	if len(locks) == 0 {
+		err := dataprovider.DeleteSharedSession(l.getSessionKey())
+		if err != nil && !isLockSessionNotFound(err) {
+			return err
+		}
+		return nil
+	}
+	// the session timestamp is the last lock expiration, this way the session can be
+	// removed when all the locks expire
+	var timestamp int64
+	for _, lock := range locks {
+		if lock.Expiry == 0 {
+			timestamp = util.GetTimeAsMsSinceEpoch(time.Now().AddDate(100, 0, 0))
+			break
+		}
+		if lock.Expiry > timestamp {
+			timestamp = lock.Expiry
+		}
+	}
+	return dataprovider.AddSharedSession(dataprovider.Session{
+		Key:       l.getSessionKey(),
+		Data:      locks,
+		Type:      dataprovider.SessionTypeWebDAVLocks,
+		Timestamp: timestamp,
+	})
+}
+
+func (l *providerLockSystem) getByToken(locks []*providerLock, token string) (int, *providerLock) {
+	for idx, lock := range locks {
+		if lock.Token == token {
+			return idx, lock
+		}
+	}
+	return -1, nil
+}
+
+// lookup returns the lock that regulates the named resource, provided that it matches
+// at least one of the given conditions and that it isn't held by another party
+func (l *providerLockSystem) lookup(locks []*providerLock, name string, conditions ...webdav.Condition) *providerLock {
+	for _, c := range conditions {
+		_, lock := l.getByToken(locks, c.Token)
+		if lock == nil || l.held[lock.Token] {
+			continue
+		}
+		if lock.isLocking(name) {
+			return lock
+		}
+	}
+	return nil
+}
+
+func (l *providerLockSystem) canCreate(locks []*providerLock, name string, zeroDepth bool) bool {
+	for _, lock := range locks {
+		if lock.Root == name {
+			// the target resource is already locked
+			return false
+		}
+		if !zeroDepth && (name == "/" || strings.HasPrefix(lock.Root, name+"/")) {
+			// the requested lock depth is infinite and a descendent of the target is locked
+			return false
+		}
+		if !lock.ZeroDepth && (lock.Root == "/" || strings.HasPrefix(name, lock.Root+"/")) {
+			// an ancestor of the target resource is locked with infinite depth
+			return false
+		}
+	}
+	return true
+}
+
+// Confirm implements the webdav.LockSystem interface
+func (l *providerLockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	locks, err := l.load(now)
+	if err != nil {
+		return nil, err
+	}
+	var l0, l1 *providerLock
+	if name0 != "" {
+		if l0 = l.lookup(locks, cleanLockName(name0), conditions...); l0 == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	if name1 != "" {
+		if l1 = l.lookup(locks, cleanLockName(name1), conditions...); l1 == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	var tokens []string
+	if l0 != nil {
+		tokens = append(tokens, l0.Token)
+	}
+	if l1 != nil && l1 != l0 {
+		tokens = append(tokens, l1.Token)
+	}
+	for _, token := range tokens {
+		l.held[token] = true
+	}
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		for _, token := range tokens {
+			delete(l.held, token)
+		}
+	}, nil
+}
+
+// Create implements the webdav.LockSystem interface
+func (l *providerLockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	locks, err := l.load(now)
+	if err != nil {
+		return "", err
+	}
+	details.Root = cleanLockName(details.Root)
+	if !l.canCreate(locks, details.Root, details.ZeroDepth) {
+		return "", webdav.ErrLocked
+	}
+	lock := &providerLock{
+		Token:     lockTokenPrefix + uuid.NewString(),
+		Root:      details.Root,
+		OwnerXML:  details.OwnerXML,
+		ZeroDepth: details.ZeroDepth,
+	}
+	lock.setDuration(now, limitLockDuration(details.Duration, l.maxTimeout))
+	locks = append(locks, lock)
+	if err := l.save(locks); err != nil {
+		return "", err
+	}
+	return lock.Token, nil
+}
+
+// Refresh implements the webdav.LockSystem interface
+func (l *providerLockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	locks, err := l.load(now)
+	if err != nil {
+		return webdav.LockDetails{}, err
+	}
+	_, lock := l.getByToken(locks, token)
+	if lock == nil {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	if l.held[token] {
+		return webdav.LockDetails{}, webdav.ErrLocked
+	}
+	lock.setDuration(now, limitLockDuration(duration, l.maxTimeout))
+	if err := l.save(locks); err != nil {
+		return webdav.LockDetails{}, err
+	}
+	return lock.getDetails(), nil
+}
+
+// Unlock implements the webdav.LockSystem interface
+func (l *providerLockSystem) Unlock(now time.Time, token string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	locks, err := l.load(now)
+	if err != nil {
+		return err
+	}
+	idx, lock := l.getByToken(locks, token)
+	if lock == nil {
+		return webdav.ErrNoSuchLock
+	}
+	if l.held[token] {
+		return webdav.ErrLocked
+	}
+	locks = append(locks[:idx], locks[idx+1:]...)
+	return l.save(locks)
+}
+
+// Delete implements the webdav.LockDeleter interface
+func (l *providerLockSystem) Delete(now time.Time, name string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	locks, err := l.load(now)
+	if err != nil {
+		return err
+	}
+	name = cleanLockName(name)
+	for idx, lock := range locks {
+		if lock.Root == name {
+			locks = append(locks[:idx], locks[idx+1:]...)
+			return l.save(locks)
+		}
+	}
+	// no locks for this resource, nothing to do
+	return nil
+}
+
+// GetByName implements the webdav.LockSystem interface
+func (l *providerLockSystem) GetByName(name string) (string, time.Time, webdav.LockDetails, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	locks, err := l.load(time.Now())
+	if err != nil {
+		return "", time.Time{}, webdav.LockDetails{}, err
+	}
+	name = cleanLockName(name)
+	for {
+		for _, lock := range locks {
+			if lock.Root == name {
+				return lock.Token, lock.getExpiry(), lock.getDetails(), nil
+			}
+		}
+		if name == "/" {
+			return "", time.Time{}, webdav.LockDetails{}, webdav.ErrNoSuchLock
+		}
+		name = path.Dir(name)
+	}
+}
+
+func cleanLockName(name string) string {
+	if name == "" || name[0] != '/' {
+		name = "/" + name
+	}
+	return path.Clean(name)
+}
+
+func isLockSessionNotFound(err error) bool {
+	if errors.Is(err, sql.ErrNoRows) {
+		return true
+	}
+	_, ok := err.(*util.RecordNotFoundError)
+	return ok
+}
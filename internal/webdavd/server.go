@@ -37,6 +37,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
@@ -46,7 +47,7 @@ type webDavServer struct {
 }
 
 func (s *webDavServer) listenAndServe(compressor *middleware.Compressor) error {
-	handler := compressor.Handler(s)
+	handler := tracing.HTTPMiddleware(common.ProtocolWebDAV, nil)(compressor.Handler(s))
 	httpServer := &http.Server{
 		ReadHeaderTimeout: 30 * time.Second,
 		ReadTimeout:       60 * time.Second,
@@ -95,9 +96,13 @@ func (s *webDavServer) listenAndServe(compressor *middleware.Compressor) error {
 				httpServer.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
 			}
 		}
+		if s.binding.EnableHTTP3 {
+			return util.HTTP3ListenAndServe(httpServer, s.binding.Address, s.binding.Port, logSender)
+		}
 		return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, true, logSender)
 	}
 	s.binding.EnableHTTPS = false
+	s.binding.EnableHTTP3 = false
 	serviceStatus.Bindings = append(serviceStatus.Bindings, s.binding)
 	return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, false, logSender)
 }
@@ -174,6 +179,11 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, common.ErrConnectionDenied.Error(), http.StatusForbidden)
 		return
 	}
+	if !common.IsCountryAllowed(ipAddr, s.binding.AllowedCountries, s.binding.DeniedCountries) {
+		logger.Log(logger.LevelDebug, common.ProtocolWebDAV, "", "connection refused, country not allowed for ip %q", ipAddr)
+		http.Error(w, common.ErrConnectionDenied.Error(), http.StatusForbidden)
+		return
+	}
 	delay, err := common.LimitRate(common.ProtocolWebDAV, ipAddr)
 	if err != nil {
 		delay += 499999999 * time.Nanosecond
@@ -182,16 +192,28 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusTooManyRequests)
 		return
 	}
+	if err := common.ThrottleByLatency(common.ProtocolWebDAV, ipAddr); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolWebDAV); err != nil {
 		http.Error(w, common.ErrConnectionDenied.Error(), http.StatusForbidden)
 		return
 	}
+	if !s.isClientAllowed(r) {
+		http.Error(w, common.ErrClientNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
 	user, isCached, lockSystem, loginMethod, err := s.authenticate(r, ipAddr)
 	if err != nil {
 		updateLoginMetrics(&user, ipAddr, loginMethod, err)
 		if !s.binding.DisableWWWAuthHeader {
 			w.Header().Set("WWW-Authenticate", "Basic realm=\"SFTPGo WebDAV\"")
 		}
+		if errors.Is(err, dataprovider.ErrProviderUnavailable) {
+			http.Error(w, fmt.Sprintf("Authentication error: %v", err), http.StatusServiceUnavailable)
+			return
+		}
 		http.Error(w, fmt.Sprintf("Authentication error: %v", err), http.StatusUnauthorized)
 		return
 	}
@@ -201,6 +223,13 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		// remove the cached user, we have not yet validated its filesystem
 		dataprovider.RemoveCachedWebDAVUser(user.Username)
 		updateLoginMetrics(&user, ipAddr, loginMethod, err)
+		if s.binding.UniformAuthErrors {
+			if !s.binding.DisableWWWAuthHeader {
+				w.Header().Set("WWW-Authenticate", "Basic realm=\"SFTPGo WebDAV\"")
+			}
+			http.Error(w, fmt.Sprintf("Authentication error: %v", dataprovider.ErrInvalidCredentials), http.StatusUnauthorized)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
@@ -223,6 +252,7 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			r.RemoteAddr, user),
 		request: r,
 	}
+	connection.SetTraceContext(r.Context())
 	if err = common.Connections.Add(connection); err != nil {
 		errClose := user.CloseFs()
 		logger.Warn(logSender, connectionID, "unable add connection: %v close fs error: %v", err, errClose)
@@ -247,6 +277,9 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.Method == "LOCK" {
+		s.config.Locks.limitLockTimeout(r)
+	}
 	handler := webdav.Handler{
 		Prefix:     s.binding.Prefix,
 		FileSystem: connection,
@@ -306,9 +339,19 @@ func (s *webDavServer) authenticate(r *http.Request, ip string) (dataprovider.Us
 	if err != nil {
 		user.Username = username
 		updateLoginMetrics(&user, ip, loginMethod, err)
+		if errors.Is(err, dataprovider.ErrProviderUnavailable) {
+			return user, false, nil, loginMethod, dataprovider.ErrProviderUnavailable
+		}
+		if _, ok := err.(*util.RecordNotFoundError); ok && s.binding.UniformAuthErrors && password != "" {
+			dataprovider.SimulatePasswordCheck(password)
+		}
 		return user, false, nil, loginMethod, dataprovider.ErrInvalidCredentials
 	}
-	lockSystem := webdav.NewMemLS()
+	lockSystem := s.config.Locks.newLockSystem(user.Username)
+	if user.IsReadOnlyFallback() {
+		// users authenticated while the data provider is unavailable are not cached
+		return user, false, lockSystem, loginMethod, nil
+	}
 	cachedUser = &dataprovider.CachedUser{
 		User:       user,
 		Password:   password,
@@ -344,9 +387,25 @@ func (s *webDavServer) validateUser(user *dataprovider.User, r *http.Request, lo
 			user.Username, r.RemoteAddr)
 		return connID, fmt.Errorf("login for user %#v is not allowed from this address: %v", user.Username, r.RemoteAddr)
 	}
+	if !user.IsClientAllowed(r.UserAgent()) {
+		common.AddDeniedClientVersion(common.ProtocolWebDAV, r.UserAgent())
+		logger.Info(logSender, connectionID, "cannot login user %q, client %q is not allowed", user.Username, r.UserAgent())
+		return connID, fmt.Errorf("client %q is not allowed for user %q", r.UserAgent(), user.Username)
+	}
 	return connID, nil
 }
 
+func (s *webDavServer) isClientAllowed(r *http.Request) bool {
+	if !s.binding.ClientPolicy.IsClientAllowed(r.UserAgent()) {
+		common.AddDeniedClientVersion(common.ProtocolWebDAV, r.UserAgent())
+		logger.Debug(logSender, "", "client %q not allowed for binding %q, remote address: %v",
+			r.UserAgent(), s.binding.GetAddress(), r.RemoteAddr)
+		return false
+	}
+	common.AddClientVersion(common.ProtocolWebDAV, r.UserAgent())
+	return true
+}
+
 func (s *webDavServer) checkRemoteAddress(r *http.Request) string {
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	var ip net.IP
@@ -409,12 +468,14 @@ func writeLog(r *http.Request, status int, err error) {
 func updateLoginMetrics(user *dataprovider.User, ip, loginMethod string, err error) {
 	metric.AddLoginAttempt(loginMethod)
 	if err != nil && err != common.ErrInternalFailure && err != common.ErrNoCredentials {
-		logger.ConnectionFailedLog(user.Username, ip, loginMethod, common.ProtocolWebDAV, err.Error())
+		logger.ConnectionFailedLog(user.Username, ip, common.GetCountryForIP(ip), loginMethod, common.ProtocolWebDAV, err.Error())
 		event := common.HostEventLoginFailed
 		if _, ok := err.(*util.RecordNotFoundError); ok {
 			event = common.HostEventUserNotFound
 		}
-		common.AddDefenderEvent(ip, event)
+		if !errors.Is(err, dataprovider.ErrProviderUnavailable) {
+			common.AddDefenderEvent(ip, event)
+		}
 	}
 	metric.AddLoginResult(loginMethod, err)
 	dataprovider.ExecutePostLoginHook(user, loginMethod, ip, common.ProtocolWebDAV, err)
@@ -40,8 +40,9 @@ const (
 )
 
 var (
-	certMgr       *common.CertManager
-	serviceStatus ServiceStatus
+	certMgr               *common.CertManager
+	serviceStatus         ServiceStatus
+	customMetadataEnabled bool
 )
 
 // ServiceStatus defines the service status
@@ -99,6 +100,10 @@ type Binding struct {
 	// set to 1 to require client certificate authentication in addition to basic auth.
 	// You need to define at least a certificate authority for this to work
 	ClientAuthType int `json:"client_auth_type" mapstructure:"client_auth_type"`
+	// Enable HTTP/3 over QUIC, in addition to HTTP/1.1 and HTTP/2, on the UDP port with the same
+	// number. The HTTP/3 endpoint is advertised using the Alt-Svc header.
+	// HTTPS must be enabled, Unix-domain sockets are not supported
+	EnableHTTP3 bool `json:"enable_http3" mapstructure:"enable_http3"`
 	// TLSCipherSuites is a list of supported cipher suites for TLS version 1.2.
 	// If CipherSuites is nil/empty, a default list of secure cipher suites
 	// is used, with a preference order based on hardware performance.
@@ -125,7 +130,19 @@ type Binding struct {
 	// Do not add the WWW-Authenticate header after an authentication error,
 	// only the 401 status code will be sent
 	DisableWWWAuthHeader bool `json:"disable_www_auth_header" mapstructure:"disable_www_auth_header"`
-	allowHeadersFrom     []func(net.IP) bool
+	// Client software allowed or denied for this binding, based on the User-Agent.
+	// The User-Agent is checked for each request, before authentication
+	ClientPolicy dataprovider.ClientPolicy `json:"client_policy" mapstructure:"client_policy"`
+	// Set to true to return the same response for all the failed logins, regardless of
+	// the failure reason, and to check the password of unknown users against a dummy
+	// hash, so that the existing users cannot be enumerated
+	UniformAuthErrors bool `json:"uniform_auth_errors" mapstructure:"uniform_auth_errors"`
+	allowHeadersFrom  []func(net.IP) bool
+	// ISO 3166-1 alpha-2 country codes allowed to connect to this binding. If set,
+	// only clients geolocated in the listed countries are allowed
+	AllowedCountries []string `json:"allowed_countries" mapstructure:"allowed_countries"`
+	// ISO 3166-1 alpha-2 country codes denied to connect to this binding
+	DeniedCountries []string `json:"denied_countries" mapstructure:"denied_countries"`
 }
 
 func (b *Binding) parseAllowedProxy() error {
@@ -175,6 +192,13 @@ type Configuration struct {
 	Cors CorsConfig `json:"cors" mapstructure:"cors"`
 	// Cache configuration
 	Cache Cache `json:"cache" mapstructure:"cache"`
+	// Locks configuration
+	Locks LocksConfig `json:"locks" mapstructure:"locks"`
+	// CustomMetadata enables to expose the files custom metadata as dead properties,
+	// in the "urn:sftpgo:metadata" namespace, and to update them using PROPPATCH.
+	// This requires an additional request for each listed file on cloud storage
+	// backends, so it is disabled by default
+	CustomMetadata bool `json:"custom_metadata" mapstructure:"custom_metadata"`
 }
 
 // GetStatus returns the server status
@@ -232,6 +256,10 @@ func (c *Configuration) Initialize(configDir string) error {
 	if !c.ShouldBind() {
 		return common.ErrNoBinding
 	}
+	if err := c.Locks.validate(); err != nil {
+		return err
+	}
+	customMetadataEnabled = c.CustomMetadata
 
 	keyPairs := c.getKeyPairs(configDir)
 	if len(keyPairs) > 0 {
@@ -265,6 +293,9 @@ func (c *Configuration) Initialize(configDir string) error {
 		if err := binding.parseAllowedProxy(); err != nil {
 			return err
 		}
+		if err := binding.ClientPolicy.Validate(); err != nil {
+			return err
+		}
 
 		go func(binding Binding) {
 			server := webDavServer{
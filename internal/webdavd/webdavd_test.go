@@ -1099,11 +1099,11 @@ func TestExternalAuthReturningAnonymousUser(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, user.Filters.IsAnonymous)
 	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermDownload}, user.Permissions["/"])
-	assert.Equal(t, []string{common.ProtocolSSH, common.ProtocolHTTP}, user.Filters.DeniedProtocols)
+	assert.Equal(t, []string{common.ProtocolSSH, common.ProtocolHTTP, common.ProtocolS3}, user.Filters.DeniedProtocols)
 	assert.Equal(t, []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
 		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndPassword,
 		dataprovider.SSHLoginMethodKeyAndKeyboardInt, dataprovider.LoginMethodTLSCertificate,
-		dataprovider.LoginMethodTLSCertificateAndPwd}, user.Filters.DeniedLoginMethods)
+		dataprovider.LoginMethodTLSCertificateAndPwd, dataprovider.SSHLoginMethodGSSAPI}, user.Filters.DeniedLoginMethods)
 
 	u.Password = emptyPwdPlaceholder
 	client = getWebDavClient(user, false, nil)
@@ -1857,6 +1857,44 @@ func TestUploadMaxSize(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestUploadDeclaredSize(t *testing.T) {
+	u := getTestUser()
+	u.QuotaSize = 1000
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	client := getWebDavClient(user, false, nil)
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	defer httpClient.CloseIdleConnections()
+
+	uploadURL := fmt.Sprintf("http://%v/%v", webDavServerAddr, testFileName)
+	// the Content-Length exceeds the quota, the upload is denied before writing any data
+	req, err := http.NewRequest(http.MethodPut, uploadURL, bytes.NewReader(make([]byte, 2000)))
+	assert.NoError(t, err)
+	req.SetBasicAuth(user.Username, defaultPassword)
+	resp, err := httpClient.Do(req)
+	if assert.NoError(t, err) {
+		assert.NotEqual(t, http.StatusCreated, resp.StatusCode)
+		resp.Body.Close()
+	}
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), testFileName))
+
+	req, err = http.NewRequest(http.MethodPut, uploadURL, bytes.NewReader(make([]byte, 500)))
+	assert.NoError(t, err)
+	req.SetBasicAuth(user.Username, defaultPassword)
+	resp, err = httpClient.Do(req)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		resp.Body.Close()
+	}
+	err = checkFileSize(testFileName, 500, client)
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestClientClose(t *testing.T) {
 	u := getTestUser()
 	u.UploadBandwidth = 64
@@ -2060,6 +2098,95 @@ func TestSFTPBuffered(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestWebDAVFs(t *testing.T) {
+	localUser, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestUser()
+	u.Username += "_webdav"
+	u.HomeDir = filepath.Join(os.TempDir(), u.Username)
+	u.FsConfig.Provider = vfs.WebDAVFilesystemProvider
+	u.FsConfig.WebDAVConfig.Endpoint = fmt.Sprintf("http://%v/", webDavServerAddr)
+	u.FsConfig.WebDAVConfig.Username = defaultUsername
+	u.FsConfig.WebDAVConfig.Password = kms.NewPlainSecret(defaultPassword)
+	u.FsConfig.WebDAVConfig.Prefix = "/webdavprefix"
+	u.QuotaFiles = 1000
+	webDAVUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	client := getWebDavClient(webDAVUser, false, nil)
+	assert.NoError(t, checkBasicFunc(client))
+	// the prefix is created at login
+	assert.DirExists(t, filepath.Join(localUser.GetHomeDir(), "webdavprefix"))
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	testFileSize := int64(65535)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+	err = uploadFileWithRawClient(testFilePath, testFileName, webDAVUser.Username, defaultPassword,
+		false, testFileSize, client)
+	assert.NoError(t, err)
+	info, err := os.Stat(filepath.Join(localUser.GetHomeDir(), "webdavprefix", testFileName))
+	if assert.NoError(t, err) {
+		assert.Equal(t, testFileSize, info.Size())
+	}
+	localDownloadPath := filepath.Join(homeBasePath, testDLFileName)
+	err = downloadFile(testFileName, localDownloadPath, testFileSize, client)
+	assert.NoError(t, err)
+
+	user, _, err := httpdtest.GetUserByUsername(webDAVUser.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, user.UsedQuotaFiles)
+	assert.Equal(t, testFileSize, user.UsedQuotaSize)
+
+	fileContent := []byte("test file contents")
+	err = os.WriteFile(testFilePath, fileContent, os.ModePerm)
+	assert.NoError(t, err)
+	err = uploadFileWithRawClient(testFilePath, testFileName, webDAVUser.Username, defaultPassword,
+		false, int64(len(fileContent)), client)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%v/%v", webDavServerAddr, testFileName), nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth(webDAVUser.Username, defaultPassword)
+	req.Header.Set("Range", "bytes=5-")
+	resp, err := httpclient.GetHTTPClient().Do(req)
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		bodyBytes, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "file contents", string(bodyBytes))
+	}
+
+	testDir := "testdir"
+	err = client.Mkdir(testDir, os.ModePerm)
+	assert.NoError(t, err)
+	err = client.Rename(testFileName, path.Join(testDir, testFileName), false)
+	assert.NoError(t, err)
+	files, err := client.ReadDir(testDir)
+	if assert.NoError(t, err) && assert.Len(t, files, 1) {
+		assert.Equal(t, testFileName, files[0].Name())
+		assert.Equal(t, int64(len(fileContent)), files[0].Size())
+	}
+	_, err = client.Stat(testFileName)
+	assert.Error(t, err)
+	// the directory tree is removed entry by entry
+	err = client.Remove(testDir)
+	assert.NoError(t, err)
+	assert.NoDirExists(t, filepath.Join(localUser.GetHomeDir(), "webdavprefix", testDir))
+
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+	err = os.Remove(localDownloadPath)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(webDAVUser, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(localUser, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(localUser.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(webDAVUser.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestBytesRangeRequests(t *testing.T) {
 	u := getTestUser()
 	u.Username = u.Username + "1"
@@ -0,0 +1,97 @@
+/*
+Copyright (C) 2019-2022  Nicola Murino
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, version 3.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Helpers to convert the WebAuthn options sent by SFTPGo to the format expected
+// by the browsers and to serialize the browser responses
+
+function webAuthnIsSupported() {
+    return window.PublicKeyCredential !== undefined && navigator.credentials !== undefined;
+}
+
+function webAuthnDecode(value) {
+    // both standard and URL safe base64 encodings are accepted
+    var b64 = value.replace(/-/g, '+').replace(/_/g, '/');
+    while (b64.length % 4) {
+        b64 += '=';
+    }
+    return Uint8Array.from(atob(b64), function (c) { return c.charCodeAt(0); });
+}
+
+function webAuthnEncode(buffer) {
+    var bytes = new Uint8Array(buffer);
+    var str = '';
+    for (var i = 0; i < bytes.byteLength; i++) {
+        str += String.fromCharCode(bytes[i]);
+    }
+    return btoa(str).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
+function webAuthnDecodeDescriptors(descriptors) {
+    if (!descriptors) {
+        return descriptors;
+    }
+    return descriptors.map(function (d) {
+        d.id = webAuthnDecode(d.id);
+        return d;
+    });
+}
+
+// webAuthnCreate registers a new credential and returns the JSON serialized response
+function webAuthnCreate(options) {
+    var publicKey = options.publicKey;
+    publicKey.challenge = webAuthnDecode(publicKey.challenge);
+    publicKey.user.id = webAuthnDecode(publicKey.user.id);
+    publicKey.excludeCredentials = webAuthnDecodeDescriptors(publicKey.excludeCredentials);
+    return navigator.credentials.create({ publicKey: publicKey }).then(function (credential) {
+        var transports = [];
+        if (typeof credential.response.getTransports === 'function') {
+            transports = credential.response.getTransports();
+        }
+        return JSON.stringify({
+            id: credential.id,
+            rawId: webAuthnEncode(credential.rawId),
+            type: credential.type,
+            transports: transports,
+            response: {
+                clientDataJSON: webAuthnEncode(credential.response.clientDataJSON),
+                attestationObject: webAuthnEncode(credential.response.attestationObject)
+            }
+        });
+    });
+}
+
+// webAuthnGet asserts an existing credential and returns the JSON serialized response
+function webAuthnGet(options) {
+    var publicKey = options.publicKey;
+    publicKey.challenge = webAuthnDecode(publicKey.challenge);
+    publicKey.allowCredentials = webAuthnDecodeDescriptors(publicKey.allowCredentials);
+    return navigator.credentials.get({ publicKey: publicKey }).then(function (credential) {
+        var response = {
+            clientDataJSON: webAuthnEncode(credential.response.clientDataJSON),
+            authenticatorData: webAuthnEncode(credential.response.authenticatorData),
+            signature: webAuthnEncode(credential.response.signature)
+        };
+        if (credential.response.userHandle) {
+            response.userHandle = webAuthnEncode(credential.response.userHandle);
+        }
+        return JSON.stringify({
+            id: credential.id,
+            rawId: webAuthnEncode(credential.rawId),
+            type: credential.type,
+            response: response
+        });
+    });
+}